@@ -69,6 +69,19 @@ func RunMigrations(db *sql.DB) error {
 	return nil
 }
 
+// LatestVersion returns the version of the newest embedded migration, the
+// schema version this build expects
+func LatestVersion() (string, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return "", err
+	}
+	if len(migrations) == 0 {
+		return "", nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
 func ensureMigrationsTable(db *sql.DB) error {
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS schema_migrations (
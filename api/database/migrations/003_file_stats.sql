@@ -0,0 +1,38 @@
+-- Migration: 003_file_stats
+-- Version: 20240101000003
+-- Description: Per-file download statistics
+
+-- =============================================================================
+-- File Download Statistics
+-- =============================================================================
+-- One row per path, day, user and client IP. Counts are buffered in memory by
+-- the API and flushed periodically. Paths are relative to the data root
+-- (users/{username}/... or shared/{folder}/...), same as shares.path.
+CREATE TABLE IF NOT EXISTS file_stats (
+    id BIGSERIAL PRIMARY KEY,
+    path VARCHAR(1024) NOT NULL,
+    day DATE NOT NULL,
+    user_id UUID,
+    ip_addr VARCHAR(45) NOT NULL DEFAULT '',
+    downloads INT NOT NULL DEFAULT 0,
+    is_deleted BOOLEAN DEFAULT FALSE,
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CONSTRAINT file_stats_unique UNIQUE NULLS NOT DISTINCT (path, day, user_id, ip_addr)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_stats_path ON file_stats(path text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_file_stats_day ON file_stats(day);
+
+COMMENT ON TABLE file_stats IS 'Per-file download counters for popularity reports';
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('download_stats_enabled', 'true', 'Track per-file download statistics'),
+    ('download_stats_disabled_folders', '', 'Comma-separated shared drive names excluded from download statistics')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000003', '003_file_stats')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,44 @@
+-- Migration: 004_camera_backup
+-- Version: 20240101000004
+-- Description: Mobile camera backup routing and ingest history
+
+-- =============================================================================
+-- Camera Backup Settings
+-- =============================================================================
+-- Per-user routing configuration for photos/videos uploaded with ingest=camera.
+-- backup_root is a virtual path under /home; routing_pattern supports the
+-- {yyyy}, {mm} and {dd} placeholders.
+CREATE TABLE IF NOT EXISTS camera_backup_settings (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    backup_root VARCHAR(1024) NOT NULL DEFAULT '/home/Camera',
+    routing_pattern VARCHAR(255) NOT NULL DEFAULT '{yyyy}/{mm}',
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+COMMENT ON TABLE camera_backup_settings IS 'Per-user mobile camera backup routing configuration';
+
+-- =============================================================================
+-- Camera Ingest Items
+-- =============================================================================
+-- One row per ingested (or skipped duplicate) camera upload. content_hash is
+-- the hex SHA-256 of the file and is used for duplicate detection.
+CREATE TABLE IF NOT EXISTS camera_ingest_items (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    path VARCHAR(1024) NOT NULL,
+    content_hash VARCHAR(64) NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    captured_at TIMESTAMPTZ,
+    date_source VARCHAR(10) NOT NULL DEFAULT 'upload',
+    status VARCHAR(20) NOT NULL DEFAULT 'ingested',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_camera_ingest_user_hash ON camera_ingest_items(user_id, content_hash);
+CREATE INDEX IF NOT EXISTS idx_camera_ingest_user_created ON camera_ingest_items(user_id, created_at DESC);
+
+COMMENT ON TABLE camera_ingest_items IS 'History of mobile camera backup uploads (ingested and skipped duplicates)';
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000004', '004_camera_backup')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,11 @@
+-- Migration: 005_onlyoffice_health
+-- Version: 20240101000005
+-- Description: Optional OnlyOffice status in the readiness probe
+
+INSERT INTO system_settings (key, value, description) VALUES
+    ('onlyoffice_health_in_readiness', 'false', 'Include OnlyOffice document server status in /health (reported as degraded, never failing)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000005', '005_onlyoffice_health')
+ON CONFLICT (version) DO NOTHING;
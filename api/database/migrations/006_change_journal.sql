@@ -0,0 +1,49 @@
+-- Migration: 006_change_journal
+-- Version: 20240101000006
+-- Description: Persistent change journal for sync clients
+
+-- =============================================================================
+-- File Change Journal
+-- =============================================================================
+-- One row per mutation, written by API handlers and the file watcher. Sync
+-- clients read rows after their last seen seq. Paths are relative to the data
+-- root (users/{username}/... or shared/{folder}/...), same as file_stats.
+CREATE TABLE IF NOT EXISTS file_changes (
+    seq BIGSERIAL PRIMARY KEY,
+    path VARCHAR(1024) NOT NULL,
+    old_path VARCHAR(1024),
+    change_type VARCHAR(10) NOT NULL,  -- create, modify, delete, rename
+    is_dir BOOLEAN NOT NULL DEFAULT FALSE,
+    size BIGINT NOT NULL DEFAULT 0,
+    mtime TIMESTAMPTZ,
+    actor_id UUID,
+    source VARCHAR(10) NOT NULL DEFAULT 'api',  -- api, watcher
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_changes_path ON file_changes(path text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_file_changes_old_path ON file_changes(old_path text_pattern_ops) WHERE old_path IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_file_changes_created ON file_changes(created_at);
+
+COMMENT ON TABLE file_changes IS 'Change journal read by sync clients via /api/changes';
+
+-- Highest seq removed by compaction; cursors at or below it need a full resync
+CREATE TABLE IF NOT EXISTS change_journal_state (
+    id INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    compacted_seq BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+INSERT INTO change_journal_state (id, compacted_seq) VALUES (1, 0)
+ON CONFLICT (id) DO NOTHING;
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('change_journal_retention_days', '30', 'Days to keep change journal entries (including deletions) before compaction')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000006', '006_change_journal')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,19 @@
+-- Migration: 007_twofa_policy
+-- Version: 20240101000007
+-- Description: Two-factor authentication enforcement settings
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- require_2fa_enforced_at is set by the API when enforcement is switched on and
+-- marks the start of the grace period.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('require_2fa_for_admins', 'false', 'Require two-factor authentication for admin accounts'),
+    ('require_2fa_for_all', 'false', 'Require two-factor authentication for all accounts'),
+    ('require_2fa_grace_days', '7', 'Days users may keep working without 2FA after enforcement starts'),
+    ('require_2fa_enforced_at', '', 'When 2FA enforcement was enabled (grace period start)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000007', '007_twofa_policy')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,17 @@
+-- Migration: 008_share_token_length
+-- Version: 20240101000008
+-- Description: Minimum length setting for generated share tokens
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Length of the random part of new share tokens, excluding the type prefix
+-- (sh_, up_, ed_). Values below 22 (128 bits) are raised to 22. Existing
+-- tokens are not affected.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('share_token_min_length', '22', 'Minimum length of newly generated share tokens')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000008', '008_share_token_length')
+ON CONFLICT (version) DO NOTHING;
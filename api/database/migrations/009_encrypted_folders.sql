@@ -0,0 +1,21 @@
+-- Migration: 009_encrypted_folders
+-- Version: 20240101000009
+-- Description: Folders whose files are encrypted at rest
+
+-- =============================================================================
+-- Encrypted Folders
+-- =============================================================================
+-- Files written through the API below one of these paths are encrypted with
+-- a per-file data key wrapped by FILE_ENCRYPTION_KEY. Paths are relative to
+-- the data root (shared/{folder}/... or users/{username}/...).
+CREATE TABLE IF NOT EXISTS encrypted_folders (
+    path VARCHAR(1024) PRIMARY KEY,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+COMMENT ON TABLE encrypted_folders IS 'Folders whose new files are encrypted at rest (AES-256-GCM)';
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000009', '009_encrypted_folders')
+ON CONFLICT (version) DO NOTHING;
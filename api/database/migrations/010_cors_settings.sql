@@ -0,0 +1,22 @@
+-- Migration: 010_cors_settings
+-- Version: 20240101000010
+-- Description: CORS origins configurable at runtime
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Comma-separated origins (scheme://host[:port]) or *. An empty default list
+-- falls back to the CORS_ALLOWED_ORIGINS environment variable. Empty share and
+-- admin lists inherit the default list; a non-empty list replaces it for that
+-- route group. cors_trust_proxy adds the origin derived from EXTERNAL_URL or
+-- X-Forwarded-Proto/X-Forwarded-Host to every group.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('cors_allowed_origins', '', 'Allowed CORS origins (empty: CORS_ALLOWED_ORIGINS env)'),
+    ('cors_share_origins', '', 'CORS origins for public share endpoints /api/s, /api/e, /api/u (empty: default list)'),
+    ('cors_admin_origins', '', 'CORS origins for /api/admin endpoints (empty: default list)'),
+    ('cors_trust_proxy', 'false', 'Allow the external origin reported by the reverse proxy')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000010', '010_cors_settings')
+ON CONFLICT (version) DO NOTHING;
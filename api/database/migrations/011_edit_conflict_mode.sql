@@ -0,0 +1,18 @@
+-- Migration: 011_edit_conflict_mode
+-- Version: 20240101000011
+-- Description: Handling of saves that would overwrite concurrent changes
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- When the editor or OnlyOffice saves a file that changed since it was opened
+-- (for example over SMB), "copy" saves the incoming content as
+-- "name (conflicted copy of <user> <timestamp>).ext" next to the original and
+-- "reject" fails the save.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('edit_conflict_mode', 'copy', 'Saves over a file changed since it was opened: copy (conflicted copy) or reject')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000011', '011_edit_conflict_mode')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,17 @@
+-- Migration: 012_notification_retention
+-- Version: 20240101000012
+-- Description: Retention period for read notifications
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Read notifications older than this many days are deleted by the periodic
+-- cleanup. Unread notifications are kept for at least 90 days. 0 disables
+-- the cleanup.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('notification_retention_days', '30', 'Days to keep read notifications (0 = keep forever)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000012', '012_notification_retention')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,17 @@
+-- Migration: 013_zip_download_limit
+-- Version: 20240101000013
+-- Description: Size limit for multi-file ZIP downloads
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- ZIP downloads of selections or folders larger than this are refused with
+-- 413. POST /api/download/preflight reports the total and whether the limit
+-- would be exceeded. 0 disables the limit.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('zip_download_max_bytes', '0', 'Maximum total size of a ZIP download in bytes (0 = unlimited)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000013', '013_zip_download_limit')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,28 @@
+-- Migration: 014_file_share_invitations
+-- Version: 20240101000014
+-- Description: Invitation state and expiry for user-to-user file shares
+
+-- =============================================================================
+-- File share invitations
+-- =============================================================================
+-- Shares created with requireAccept start as 'pending' until the recipient
+-- accepts or declines them. Only accepted, unexpired shares grant access.
+-- Existing shares keep working as accepted.
+ALTER TABLE file_shares ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'accepted';
+ALTER TABLE file_shares ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE file_shares ADD COLUMN IF NOT EXISTS responded_at TIMESTAMPTZ;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'file_shares_status_check') THEN
+        ALTER TABLE file_shares ADD CONSTRAINT file_shares_status_check
+            CHECK (status IN ('pending', 'accepted', 'declined'));
+    END IF;
+END $$;
+
+CREATE INDEX IF NOT EXISTS idx_file_shares_recipient_status ON file_shares(shared_with_id, status);
+CREATE INDEX IF NOT EXISTS idx_file_shares_expires_at ON file_shares(expires_at) WHERE expires_at IS NOT NULL;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000014', '014_file_share_invitations')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,23 @@
+-- Migration: 015_rate_limit_classes
+-- Version: 20240101000015
+-- Description: Per endpoint class rate limits
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- rate_limit_rps keeps applying to endpoints without a class, now per user
+-- when authenticated. Listing and preview endpoints get a separate, larger
+-- budget. Login, 2FA verify and share password attempts are limited per
+-- minute, keyed by IP and the targeted account or share. tus upload PATCH
+-- requests are not rate limited.
+UPDATE system_settings SET description = 'Requests per second per user (IP when anonymous)'
+WHERE key = 'rate_limit_rps';
+
+INSERT INTO system_settings (key, value, description) VALUES
+    ('rate_limit_browse_rps', '300', 'Requests per second per user for listing and preview endpoints'),
+    ('rate_limit_auth_per_minute', '10', 'Login, 2FA and share password attempts per minute per IP and account')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000015', '015_rate_limit_classes')
+ON CONFLICT (version) DO NOTHING;
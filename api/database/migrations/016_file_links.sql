@@ -0,0 +1,27 @@
+-- Migration: 016_file_links
+-- Version: 20240101000016
+-- Description: Index of .fhlink link files by target
+
+-- =============================================================================
+-- File Links
+-- =============================================================================
+-- A .fhlink file holds the path of its target. This table indexes link files
+-- by target so renaming or moving a target through the API rewrites the links
+-- pointing at it. Paths are relative to the data root (users/{username}/...
+-- or shared/{folder}/...). Link files created outside the API are added when
+-- a listing shows them.
+CREATE TABLE IF NOT EXISTS file_links (
+    link_path VARCHAR(1024) PRIMARY KEY,
+    target_path VARCHAR(1024) NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_links_link ON file_links(link_path text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_file_links_target ON file_links(target_path text_pattern_ops);
+
+COMMENT ON TABLE file_links IS 'Link files (.fhlink) by target, for following renames and moves';
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000016', '016_file_links')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,20 @@
+-- Migration: 017_onlyoffice_forcesave
+-- Version: 20240101000017
+-- Description: OnlyOffice force save and autosave interval
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- With force save on, an open editor asks the document server to save every
+-- onlyoffice_autosave_interval seconds (0 = only on demand), so edits persist
+-- before the last editor closes the document. The command URL defaults to
+-- ONLYOFFICE_INTERNAL_URL + /coauthoring/CommandService.ashx when empty.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('onlyoffice_forcesave_enabled', 'false', 'Save OnlyOffice documents periodically while they are open'),
+    ('onlyoffice_autosave_interval', '300', 'Seconds between OnlyOffice force saves (0 = only on demand)'),
+    ('onlyoffice_command_url', '', 'Document server command service URL (empty = derived from ONLYOFFICE_INTERNAL_URL)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000017', '017_onlyoffice_forcesave')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,19 @@
+-- Migration: 018_background_pacing
+-- Version: 20240101000018
+-- Description: Background job pacing
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Maintenance jobs (trash cleanup, adoption, key rotation) run at most
+-- background_duty_cycle percent of the time. All background work, including
+-- user-initiated copy, compress and extract jobs, backs off further while the
+-- listing/preview latency average is above background_latency_target_ms.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('background_duty_cycle', '50', 'Percent of time maintenance jobs may run (5-100)'),
+    ('background_latency_target_ms', '200', 'Listing latency above which background jobs back off (ms)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000018', '018_background_pacing')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,27 @@
+-- Migration: 019_folder_display
+-- Version: 20240101000019
+-- Description: Per-folder default sort and pinned items
+
+-- =============================================================================
+-- Folder Display
+-- =============================================================================
+-- Display settings shared by everyone who opens a folder. Folder paths are
+-- relative to the data root (users/{username}/... or shared/{folder}/...);
+-- pinned holds child names, which listings return first. Renames and moves
+-- through the API update both the folder key and the pinned names.
+CREATE TABLE IF NOT EXISTS folder_display (
+    folder_path VARCHAR(1024) PRIMARY KEY,
+    default_sort VARCHAR(20) NOT NULL DEFAULT '',
+    default_order VARCHAR(4) NOT NULL DEFAULT '',
+    pinned JSONB NOT NULL DEFAULT '[]',
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_folder_display_path ON folder_display(folder_path text_pattern_ops);
+
+COMMENT ON TABLE folder_display IS 'Folder default sort and pinned children, shared by all viewers';
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000019', '019_folder_display')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,16 @@
+-- Migration: 020_share_path_indexes
+-- Version: 20240101000020
+-- Description: Path indexes for the share exposure lookup
+
+-- =============================================================================
+-- Share Path Indexes
+-- =============================================================================
+-- GET /api/files/exposure/* matches a path and each of its ancestors exactly
+-- (path = ANY(...)), so a btree on the creator and path answers it without
+-- scanning all shares. file_shares is already covered by
+-- idx_file_shares_owner_path.
+CREATE INDEX IF NOT EXISTS idx_shares_creator_path ON shares(created_by, path);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000020', '020_share_path_indexes')
+ON CONFLICT (version) DO NOTHING;
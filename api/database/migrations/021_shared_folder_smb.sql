@@ -0,0 +1,24 @@
+-- Migration: 021_shared_folder_smb
+-- Version: 20240101000021
+-- Description: Per shared drive SMB export options
+
+-- =============================================================================
+-- Shared Folder SMB Exports
+-- =============================================================================
+-- A shared drive with an enabled row is exported as its own Samba share.
+-- Valid users are not stored: they are rendered from the drive members that
+-- have an SMB password, so membership changes need no extra bookkeeping.
+CREATE TABLE IF NOT EXISTS shared_folder_smb (
+    shared_folder_id UUID PRIMARY KEY REFERENCES shared_folders(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    browseable BOOLEAN NOT NULL DEFAULT TRUE,
+    guest_ok BOOLEAN NOT NULL DEFAULT FALSE,
+    recycle_bin BOOLEAN NOT NULL DEFAULT FALSE,
+    read_only BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000021', '021_shared_folder_smb')
+ON CONFLICT (version) DO NOTHING;
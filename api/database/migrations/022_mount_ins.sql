@@ -0,0 +1,29 @@
+-- Migration: 022_mount_ins
+-- Version: 20240101000022
+-- Description: Read-only mounts of home folders into shared drives
+
+-- =============================================================================
+-- Mount-ins
+-- =============================================================================
+-- A mount-in shows a folder of a user's home at a path inside a shared drive.
+-- Drive members browse it like any other folder, but only ever read it: the
+-- data stays in the owner's home and counts against the owner's quota only.
+-- Both paths are data-root relative (shared/{drive}/..., users/{owner}/...)
+-- and follow renames and moves.
+CREATE TABLE IF NOT EXISTS mount_ins (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    shared_folder_id UUID NOT NULL REFERENCES shared_folders(id) ON DELETE CASCADE,
+    mount_path TEXT NOT NULL UNIQUE,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source_path TEXT NOT NULL,
+    -- Permission ceiling for members; only read-only (1) is supported
+    permission_level INTEGER NOT NULL DEFAULT 1 CHECK (permission_level = 1),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mount_ins_owner ON mount_ins(owner_id);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000022', '022_mount_ins')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,53 @@
+-- Migration: 023_storage_locations
+-- Version: 20240101000023
+-- Description: Shared drives and home folders stored on other volumes
+
+-- =============================================================================
+-- Storage Locations
+-- =============================================================================
+-- A drive or home folder moved off the data root by a storage migration.
+-- Paths are data-root relative (shared/{folder} or users/{username}); the
+-- data is at {volume}/{path}, and the data root keeps a symlink to it.
+CREATE TABLE IF NOT EXISTS storage_locations (
+    path TEXT PRIMARY KEY,
+    volume TEXT NOT NULL,
+    migrated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- =============================================================================
+-- Storage Migrations
+-- =============================================================================
+-- One row per migration job, updated at each phase so an interrupted job
+-- resumes where it stopped: copy (old location stays live), switch (writes
+-- held, final sync, mapping switched), verify, cleanup (old copy removed).
+CREATE TABLE IF NOT EXISTS storage_migrations (
+    id VARCHAR(64) PRIMARY KEY,
+    path TEXT NOT NULL,
+    source_volume TEXT NOT NULL,
+    target_volume TEXT NOT NULL,
+    phase VARCHAR(20) NOT NULL DEFAULT 'copy',
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    bytes_total BIGINT NOT NULL DEFAULT 0,
+    files_total BIGINT NOT NULL DEFAULT 0,
+    verified BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    switched_at TIMESTAMP WITH TIME ZONE,
+    started_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_storage_migrations_status ON storage_migrations(status);
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Comma-separated absolute paths of mounted volumes that drives and home
+-- folders may be moved to. Each is validated by the startup self-test.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('storage_volumes', '', 'Volumes drives and home folders may be moved to (comma-separated absolute paths)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000023', '023_storage_locations')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,52 @@
+-- Migration: 024_usage_reports
+-- Version: 20240101000024
+-- Description: Monthly account usage reports and usage snapshots
+
+-- =============================================================================
+-- Users
+-- =============================================================================
+-- Users opt in to the monthly usage report
+ALTER TABLE users ADD COLUMN IF NOT EXISTS usage_report_enabled BOOLEAN DEFAULT FALSE;
+
+-- =============================================================================
+-- Usage Snapshots
+-- =============================================================================
+-- storage_used of every user and shared drive at the start of each month, so
+-- reports can show growth. Taken by the usage report job.
+CREATE TABLE IF NOT EXISTS usage_snapshots (
+    month DATE NOT NULL,
+    subject_type VARCHAR(10) NOT NULL CHECK (subject_type IN ('user', 'drive')),
+    subject_id UUID NOT NULL,
+    bytes_used BIGINT NOT NULL DEFAULT 0,
+    quota BIGINT NOT NULL DEFAULT 0,
+    trash_bytes BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (subject_type, subject_id, month)
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_snapshots_month ON usage_snapshots(month);
+
+-- =============================================================================
+-- Usage Report Runs
+-- =============================================================================
+-- One row per month the reports were sent; claiming the month first keeps
+-- several API instances from sending them twice
+CREATE TABLE IF NOT EXISTS usage_report_runs (
+    month DATE PRIMARY KEY,
+    started_at TIMESTAMPTZ DEFAULT NOW(),
+    finished_at TIMESTAMPTZ,
+    reports INT NOT NULL DEFAULT 0,
+    emails INT NOT NULL DEFAULT 0,
+    error TEXT
+);
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('usage_reports_enabled', 'true', 'Send monthly usage reports to users who opted in and to admins')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000024', '024_usage_reports')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,28 @@
+-- Migration: 025_file_history
+-- Version: 20240101000025
+-- Description: Indexes for the per-file history view
+
+-- =============================================================================
+-- Audit Log Indexes
+-- =============================================================================
+-- Prefix matches on target paths (direct children of a folder); the plain
+-- target index only serves equality under non-C collations
+CREATE INDEX IF NOT EXISTS idx_audit_target_pattern ON audit_logs(target_resource text_pattern_ops);
+
+-- Following a file back through its renames and moves: the event that gave
+-- a path its current name is looked up by the new path
+CREATE INDEX IF NOT EXISTS idx_audit_rename_new_path ON audit_logs((details->>'newPath'), ts DESC)
+    WHERE event_type = 'file.rename';
+CREATE INDEX IF NOT EXISTS idx_audit_move_destination ON audit_logs((details->>'destination'), ts DESC)
+    WHERE event_type = 'file.move';
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('file_history_days', '180', 'How many days of audit events the file history view shows')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000025', '025_file_history')
+ON CONFLICT (version) DO NOTHING;
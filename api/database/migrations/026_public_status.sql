@@ -0,0 +1,14 @@
+-- Migration: 026_public_status
+-- Version: 20240101000026
+-- Description: Incident message for the public status endpoint
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('status_incident_message', '', 'Incident message shown to all users by GET /api/status (empty = none)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000026', '026_public_status')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,18 @@
+-- Migration: 027_operation_budgets
+-- Version: 20240101000027
+-- Description: Time budgets for requests that walk the filesystem
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Past its budget a folder stats request or quota check answers 503 with
+-- Retry-After and a search returns partial results (0 = no budget)
+INSERT INTO system_settings (key, value, description) VALUES
+    ('folder_stats_budget_seconds', '30', 'Time budget of a folder stats request in seconds (0 = unlimited)'),
+    ('search_budget_seconds', '20', 'Time budget of a file search in seconds; results are partial past it (0 = unlimited)'),
+    ('quota_check_budget_seconds', '10', 'Time budget of a shared drive quota check in seconds (0 = unlimited)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000027', '027_operation_budgets')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,16 @@
+-- Migration: 028_upload_share_pause
+-- Version: 20240101000028
+-- Description: What happens to uploads in progress when an upload share is paused
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Owners pause upload shares with PUT /api/shares/:id; "finish" lets uploads
+-- already started complete, "reject" turns away their remaining chunks
+INSERT INTO system_settings (key, value, description) VALUES
+    ('upload_share_pause_inflight', 'finish', 'Uploads in progress when an upload share is paused: finish or reject')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000028', '028_upload_share_pause')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,43 @@
+-- Migration: 029_instance_transfer
+-- Version: 20240101000029
+-- Description: Exports and imports of users, shared drives, shares and files between instances
+
+-- =============================================================================
+-- Instance Transfers
+-- =============================================================================
+-- One row per export or import job. An export writes its manifest to
+-- .transfer/{id}/manifest.json below the data root; an import reads files
+-- from an uploaded archive or from the source instance's API and is updated
+-- at each phase (entities, files, references) so it resumes where it stopped.
+-- source_token is the admin token on the source, cleared once the import is
+-- done; initial passwords of imported users are never stored.
+CREATE TABLE IF NOT EXISTS instance_transfers (
+    id VARCHAR(64) PRIMARY KEY,
+    kind VARCHAR(10) NOT NULL,
+    phase VARCHAR(20),
+    status VARCHAR(20) NOT NULL DEFAULT 'running',
+    export_id VARCHAR(64),
+    source_url TEXT,
+    source_token TEXT,
+    from_archive BOOLEAN NOT NULL DEFAULT FALSE,
+    resolutions JSONB,
+    files_total BIGINT NOT NULL DEFAULT 0,
+    files_done BIGINT NOT NULL DEFAULT 0,
+    files_skipped BIGINT NOT NULL DEFAULT 0,
+    files_failed BIGINT NOT NULL DEFAULT 0,
+    bytes_total BIGINT NOT NULL DEFAULT 0,
+    bytes_done BIGINT NOT NULL DEFAULT 0,
+    results JSONB,
+    refs JSONB,
+    file_errors JSONB,
+    error TEXT,
+    started_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_instance_transfers_status ON instance_transfers(status);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000029', '029_instance_transfer')
+ON CONFLICT (version) DO NOTHING;
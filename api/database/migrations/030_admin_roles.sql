@@ -0,0 +1,43 @@
+-- Migration: 030_admin_roles
+-- Version: 20240101000030
+-- Description: Admin roles mapping to permission keys, assigned per user
+
+-- =============================================================================
+-- Roles
+-- =============================================================================
+-- A role grants a set of permission keys (users.manage, settings.write,
+-- audit.read, shares.manage_all, storage.admin). superadmin grants all of
+-- them and is stored as users.is_admin, so accounts that were admins before
+-- this migration are superadmins without further changes; the other roles
+-- are rows in user_roles.
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT,
+    permissions JSONB NOT NULL DEFAULT '[]',
+    built_in BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO roles (name, description, permissions, built_in) VALUES
+    ('superadmin', 'Full administrative access', '["users.manage", "settings.write", "audit.read", "shares.manage_all", "storage.admin"]', TRUE),
+    ('user-manager', 'Manages user accounts and their 2FA and lockouts', '["users.manage"]', TRUE),
+    ('storage-manager', 'Manages shared drives, volumes and storage jobs', '["storage.admin"]', TRUE),
+    ('auditor', 'Reads audit logs and access reports', '["audit.read"]', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+-- =============================================================================
+-- User Roles
+-- =============================================================================
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_name VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    assigned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    assigned_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role ON user_roles(role_name);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000030', '030_admin_roles')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,66 @@
+-- Migration: 031_security_alerts
+-- Version: 20240101000031
+-- Description: Anomaly rules over the audit event stream and their firings
+
+-- =============================================================================
+-- Alert Rules
+-- =============================================================================
+-- The rule kinds are built in; these rows hold their configuration. A rule
+-- fires when threshold matching events for one key (an IP or a user) fall
+-- within window_seconds, then stays quiet for that key for cooldown_seconds.
+CREATE TABLE IF NOT EXISTS alert_rules (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    threshold INTEGER NOT NULL DEFAULT 1,
+    window_seconds INTEGER NOT NULL DEFAULT 0,
+    cooldown_seconds INTEGER NOT NULL DEFAULT 3600,
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO alert_rules (name, description, threshold, window_seconds, cooldown_seconds) VALUES
+    ('failed_logins_ip', 'Failed logins from one IP address, across all accounts', 20, 600, 3600),
+    ('admin_new_country', 'Login to an admin account from a country it has not logged in from (needs a GeoIP database)', 1, 0, 86400),
+    ('permanent_delete_burst', 'Items permanently deleted by one user', 100, 300, 3600)
+ON CONFLICT (name) DO NOTHING;
+
+-- =============================================================================
+-- Alert Firings
+-- =============================================================================
+CREATE TABLE IF NOT EXISTS alert_firings (
+    id BIGSERIAL PRIMARY KEY,
+    rule VARCHAR(50) NOT NULL,
+    group_key VARCHAR(255) NOT NULL,
+    summary TEXT NOT NULL,
+    events JSONB NOT NULL DEFAULT '[]',
+    fired_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_firings_fired ON alert_firings(fired_at DESC);
+CREATE INDEX IF NOT EXISTS idx_alert_firings_rule_key ON alert_firings(rule, group_key, fired_at DESC);
+
+-- =============================================================================
+-- Admin Login Countries
+-- =============================================================================
+-- Countries each admin account has logged in from, for admin_new_country
+CREATE TABLE IF NOT EXISTS admin_login_countries (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    country VARCHAR(2) NOT NULL,
+    first_seen TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, country)
+);
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('security_alerts_enabled', 'true', 'Evaluate alert rules over audit events and notify admins'),
+    ('security_alerts_email', 'true', 'Also email alerts to admins when SMTP is configured'),
+    ('security_alerts_webhook_url', '', 'URL that alerts are POSTed to as JSON (empty = none)'),
+    ('geoip_mmdb_path', '', 'Path of a local GeoIP country database (MMDB) for the admin_new_country rule')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000031', '031_security_alerts')
+ON CONFLICT (version) DO NOTHING;
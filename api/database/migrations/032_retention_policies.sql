@@ -0,0 +1,53 @@
+-- Migration: 032_retention_policies
+-- Version: 20240101000032
+-- Description: WORM retention holds on designated folders and two-admin overrides
+
+-- =============================================================================
+-- Retention Policies
+-- =============================================================================
+-- Files in a held folder can be added but not changed, renamed, moved or
+-- deleted until their hold ends: retention_days after the file was written
+-- (mode 'duration') or a fixed hold_until date (mode 'until'). path is data-
+-- root relative (shared/{drive}/..., users/{owner}/...); policies never nest.
+-- A drive cannot be deleted while it holds a policy.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    path TEXT NOT NULL UNIQUE,
+    shared_folder_id UUID REFERENCES shared_folders(id) ON DELETE RESTRICT,
+    mode VARCHAR(20) NOT NULL CHECK (mode IN ('duration', 'until')),
+    retention_days INTEGER CHECK (retention_days > 0),
+    hold_until TIMESTAMP WITH TIME ZONE,
+    reason TEXT NOT NULL DEFAULT '',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((mode = 'duration' AND retention_days IS NOT NULL) OR (mode = 'until' AND hold_until IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_retention_policies_folder ON retention_policies(shared_folder_id);
+
+-- =============================================================================
+-- Retention Overrides
+-- =============================================================================
+-- One admin requests an override for a path, a second admin approves it and
+-- receives a token for the requester. The token lets one change to held
+-- files at or below path through before it expires; only its hash is kept.
+CREATE TABLE IF NOT EXISTS retention_overrides (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    policy_id UUID NOT NULL REFERENCES retention_policies(id) ON DELETE CASCADE,
+    path TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    requested_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    approved_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    token_hash VARCHAR(64) UNIQUE,
+    approved_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK (approved_by IS NULL OR approved_by <> requested_by)
+);
+
+CREATE INDEX IF NOT EXISTS idx_retention_overrides_policy ON retention_overrides(policy_id);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000032', '032_retention_policies')
+ON CONFLICT (version) DO NOTHING;
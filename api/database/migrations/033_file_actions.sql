@@ -0,0 +1,30 @@
+-- Migration: 033_file_actions
+-- Version: 20240101000033
+-- Description: Per-extension open actions reported to clients, with admin overrides
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- With OnlyOffice off, clients are not offered OnlyOffice editing or PDF
+-- conversion even when a document server is reachable.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('onlyoffice_enabled', 'true', 'Offer OnlyOffice editing and PDF conversion when a document server is reachable')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- File Action Overrides
+-- =============================================================================
+-- Replaces the actions offered for an extension (lowercase, without dot) and
+-- the one run on open ('none' = download). Actions the deployment cannot
+-- perform for the extension are dropped when capabilities are computed.
+CREATE TABLE IF NOT EXISTS file_action_overrides (
+    extension VARCHAR(32) PRIMARY KEY,
+    actions TEXT[] NOT NULL DEFAULT '{}',
+    default_action VARCHAR(32) NOT NULL DEFAULT 'none',
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000033', '033_file_actions')
+ON CONFLICT (version) DO NOTHING;
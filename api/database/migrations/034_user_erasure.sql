@@ -0,0 +1,60 @@
+-- Migration: 034_user_erasure
+-- Version: 20240101000034
+-- Description: GDPR erasure: pseudonymize a deleted user in audit logs and shares
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- The mapping back to the user is kept gdpr_erasure_hold_days so an erasure
+-- can be reversed if the deletion is retracted, then purged.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('gdpr_erasure_hold_days', '30', 'Days the identity mapping of an erased user is kept so the erasure can be reversed (0 = purge at the next run)'),
+    ('gdpr_erasure_truncate_ips', 'true', 'Truncate IP addresses (IPv4 /24, IPv6 /48) in the audit rows of an erased user')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Pseudonyms
+-- =============================================================================
+-- An erased actor's audit rows have actor_id NULL and the pseudonym instead;
+-- shares.created_by references users, so erased shares keep it here.
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS actor_pseudonym VARCHAR(32);
+ALTER TABLE shares ADD COLUMN IF NOT EXISTS created_by_pseudonym VARCHAR(32);
+
+-- =============================================================================
+-- User Erasures
+-- =============================================================================
+-- One row per erased user, kept as the DPO's record. user_id, username and
+-- email are the mapping: they are cleared with the erasure's rows when
+-- hold_until passes. report lists what was pseudonymized.
+CREATE TABLE IF NOT EXISTS user_erasures (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    pseudonym VARCHAR(32) NOT NULL UNIQUE,
+    user_id UUID,
+    username VARCHAR(255),
+    email VARCHAR(255),
+    truncate_ips BOOLEAN NOT NULL DEFAULT FALSE,
+    report JSONB NOT NULL DEFAULT '{}',
+    requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    hold_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    purged_at TIMESTAMP WITH TIME ZONE,
+    reversed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reversed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_erasures_hold ON user_erasures(hold_until) WHERE purged_at IS NULL AND reversed_at IS NULL;
+
+-- Original values of each changed row, to reverse the erasure
+CREATE TABLE IF NOT EXISTS user_erasure_rows (
+    id BIGSERIAL PRIMARY KEY,
+    erasure_id UUID NOT NULL REFERENCES user_erasures(id) ON DELETE CASCADE,
+    table_name VARCHAR(50) NOT NULL,
+    row_id TEXT NOT NULL,
+    original JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_erasure_rows_erasure ON user_erasure_rows(erasure_id);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000034', '034_user_erasure')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,113 @@
+-- Migration: 035_content_inspection
+-- Version: 20240101000035
+-- Description: Antivirus/DLP inspection of uploads with per-folder policies and verdict caching
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('inspection_clamav_address', '', 'clamd address for the clamav inspector (host:port or a unix socket path; empty = not configured)'),
+    ('inspection_max_attempts', '3', 'Attempts of an inspection while an inspector is unavailable before the policy''s failure mode applies'),
+    ('inspection_timeout_seconds', '120', 'Time one inspector may take for a file'),
+    ('inspection_dlp_max_mb', '32', 'Content of a file scanned by regex-dlp (MB)'),
+    ('inspection_max_file_mb', '0', 'size-type: files above this size are blocked (MB, 0 = no limit)'),
+    ('inspection_blocked_extensions', '', 'size-type: comma-separated extensions that are blocked (e.g. exe,bat,scr)')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Inspection Policies
+-- =============================================================================
+-- Inspectors run on uploads into path (data-root relative: shared/{drive}/...
+-- or users/{owner}/...); the nearest policy above a file applies. inspectors
+-- is the ordered list [{"name": "clamav", "blocking": true}, ...]. With a
+-- blocking inspector uploads wait in a pending area until they pass. When an
+-- inspector stays unavailable, fail_mode 'open' lets the file through flagged
+-- as not inspected and 'closed' treats it as blocked.
+CREATE TABLE IF NOT EXISTS inspection_policies (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    path TEXT NOT NULL UNIQUE,
+    inspectors JSONB NOT NULL DEFAULT '[]',
+    fail_mode VARCHAR(10) NOT NULL DEFAULT 'open' CHECK (fail_mode IN ('open', 'closed')),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- =============================================================================
+-- DLP Patterns
+-- =============================================================================
+-- Regular expressions (Go syntax) matched by the regex-dlp inspector
+CREATE TABLE IF NOT EXISTS dlp_patterns (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    pattern TEXT NOT NULL,
+    verdict VARCHAR(10) NOT NULL DEFAULT 'flag' CHECK (verdict IN ('flag', 'block')),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO dlp_patterns (name, pattern, verdict) VALUES
+    ('Resident registration number', '\b\d{2}(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])-[1-4]\d{6}\b', 'flag')
+ON CONFLICT (name) DO NOTHING;
+
+-- =============================================================================
+-- Verdict Cache
+-- =============================================================================
+-- Verdicts by content hash, so known content is not inspected again.
+-- fingerprint identifies the inspector's configuration (signature version,
+-- pattern set); verdicts of another configuration are not reused.
+CREATE TABLE IF NOT EXISTS inspection_verdicts (
+    content_hash VARCHAR(64) NOT NULL,
+    inspector VARCHAR(32) NOT NULL,
+    fingerprint TEXT NOT NULL,
+    verdict VARCHAR(10) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    inspected_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (content_hash, inspector, fingerprint)
+);
+
+-- =============================================================================
+-- Inspection Jobs
+-- =============================================================================
+-- The inspection queue. held_name is the file in the pending area
+-- (.inspection/ under the data root) while an upload waits for a blocking
+-- verdict, and stays set for blocked uploads until an admin releases or
+-- discards them; upload has what is needed to put the file in place.
+CREATE TABLE IF NOT EXISTS inspection_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    policy_id UUID REFERENCES inspection_policies(id) ON DELETE SET NULL,
+    path TEXT NOT NULL,
+    held_name VARCHAR(64),
+    upload JSONB,
+    content_hash VARCHAR(64),
+    size BIGINT NOT NULL DEFAULT 0,
+    uploaded_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued' CHECK (status IN ('queued', 'running', 'done')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_error TEXT,
+    verdict VARCHAR(10),
+    results JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_inspection_jobs_queue ON inspection_jobs(next_attempt_at) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_inspection_jobs_held ON inspection_jobs(id) WHERE held_name IS NOT NULL;
+
+-- =============================================================================
+-- Inspection Flags
+-- =============================================================================
+-- Files let through with a warning, shown as a badge in listings until an
+-- admin clears it. Follows renames and moves.
+CREATE TABLE IF NOT EXISTS inspection_flags (
+    path TEXT PRIMARY KEY,
+    job_id BIGINT REFERENCES inspection_jobs(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL,
+    flagged_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000035', '035_content_inspection')
+ON CONFLICT (version) DO NOTHING;
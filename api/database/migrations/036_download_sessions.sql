@@ -0,0 +1,59 @@
+-- Migration: 036_download_sessions
+-- Version: 20240101000036
+-- Description: Resumable bulk downloads: a manifest of signed per-file URLs instead of a ZIP stream
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('download_session_ttl_hours', '24', 'Hours a download session and its file URLs stay valid')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Download Sessions
+-- =============================================================================
+-- A selection resolved into a manifest of files. secret signs the per-file
+-- URLs, so download managers can fetch them without the user's token until
+-- expires_at; expired sessions are deleted with their files.
+CREATE TABLE IF NOT EXISTS download_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    paths JSONB NOT NULL DEFAULT '[]',
+    file_count INTEGER NOT NULL DEFAULT 0,
+    total_bytes BIGINT NOT NULL DEFAULT 0,
+    client_ip VARCHAR(45),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_sessions_user ON download_sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_download_sessions_expires ON download_sessions(expires_at);
+
+-- =============================================================================
+-- Download Session Files
+-- =============================================================================
+-- One row per manifest entry. sha256 is filled in the background after the
+-- session is created. served_ranges are the merged byte ranges sent so far
+-- ([[start, end), ...]); a file is complete once they cover it or the client
+-- reports it (completed_by 'server' or 'client').
+CREATE TABLE IF NOT EXISTS download_session_files (
+    session_id UUID NOT NULL REFERENCES download_sessions(id) ON DELETE CASCADE,
+    idx INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    real_path TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    mod_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    sha256 VARCHAR(64),
+    served_ranges JSONB NOT NULL DEFAULT '[]',
+    bytes_served BIGINT NOT NULL DEFAULT 0,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    completed_by VARCHAR(10) CHECK (completed_by IN ('server', 'client')),
+    PRIMARY KEY (session_id, idx)
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_session_files_checksum ON download_session_files(session_id) WHERE sha256 IS NULL;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000036', '036_download_sessions')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,27 @@
+-- Migration: 037_directory_entry_limits
+-- Version: 20240101000037
+-- Description: Soft and hard limits on the number of entries per directory
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('dir_entries_warn', '10000', 'Entries in a folder above which creations carry a warning and listings are paginated (0 = off)'),
+    ('dir_entries_max', '100000', 'Entries in a folder above which creations are refused unless an admin overrides (0 = off)')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Directory Entry Warnings
+-- =============================================================================
+-- Folders whose owner was told they passed dir_entries_warn, so each owner
+-- is notified once per folder. path is relative to the data root.
+CREATE TABLE IF NOT EXISTS directory_entry_warnings (
+    path TEXT PRIMARY KEY,
+    owner_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    entries INTEGER NOT NULL,
+    notified_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000037', '037_directory_entry_limits')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,43 @@
+-- Migration: 038_jobs
+-- Version: 20240101000038
+-- Description: Persistent background jobs with retries, cancellation and a jobs API
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('jobs_retention_days', '7', 'Days finished background jobs stay visible in the jobs list')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Jobs
+-- =============================================================================
+-- Work handled by a registered job type. Queued jobs run once run_after has
+-- passed; a failed attempt is requeued with backoff until the type's
+-- attempt limit. Jobs running when the server stopped are marked
+-- interrupted and requeued at startup if their type is idempotent.
+-- owner_id is NULL for system jobs such as scheduled cleanups.
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(64) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    state VARCHAR(20) NOT NULL DEFAULT 'queued'
+        CHECK (state IN ('queued', 'running', 'succeeded', 'failed', 'cancelled', 'interrupted')),
+    progress JSONB NOT NULL DEFAULT '{}',
+    owner_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    run_after TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_queue ON jobs(type, run_after) WHERE state = 'queued';
+CREATE INDEX IF NOT EXISTS idx_jobs_owner ON jobs(owner_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_jobs_finished ON jobs(finished_at) WHERE finished_at IS NOT NULL;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000038', '038_jobs')
+ON CONFLICT (version) DO NOTHING;
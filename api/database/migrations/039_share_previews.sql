@@ -0,0 +1,16 @@
+-- Migration: 039_share_previews
+-- Version: 20240101000039
+-- Description: Inline previews of image, video, audio and PDF files on share pages
+
+-- =============================================================================
+-- Share Preview Count
+-- =============================================================================
+-- Previews opened through GET /api/s/:token/preview, counted apart from
+-- access_count so browsing a photo share neither uses up max_access nor
+-- looks like downloads. Thumbnails and range requests continuing a stream
+-- are not counted.
+ALTER TABLE shares ADD COLUMN IF NOT EXISTS preview_count INTEGER NOT NULL DEFAULT 0;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000039', '039_share_previews')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,31 @@
+-- Migration: 040_guest_accounts
+-- Version: 20240101000040
+-- Description: Time-limited guest accounts restricted to specific shared drives
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('guest_max_days', '90', 'Longest a guest account can be valid for, in days from creation or extension'),
+    ('guest_archive_days', '30', 'Days after expiry an expired guest is archived: drive memberships and shares removed')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Guest Accounts
+-- =============================================================================
+-- Guests have no home folder, belong only to the drives they were created
+-- for and can't create link or user-to-user shares. At guest_expires_at
+-- login and tokens stop working; the daily guests.expire job deactivates
+-- them and sets tokens_revoked_at, so tokens issued before it stay invalid
+-- after an extension. guest_archived_at is set when the guest is archived.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_guest BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS guest_expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS guest_created_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS guest_archived_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_revoked_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_guest_expires ON users(guest_expires_at) WHERE is_guest;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000040', '040_guest_accounts')
+ON CONFLICT (version) DO NOTHING;
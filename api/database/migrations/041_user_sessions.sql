@@ -0,0 +1,38 @@
+-- Migration: 041_user_sessions
+-- Version: 20240101000041
+-- Description: Login sessions with idle and absolute timeouts, listed and revocable by their users
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('session_idle_minutes', '120', 'Lifetime of a token without remember me; activity refreshes it until session_max_hours'),
+    ('session_max_hours', '12', 'Longest a session without remember me lasts after sign-in, however active'),
+    ('session_remember_days', '30', 'Lifetime of a remember-me token; activity refreshes it')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- User Sessions
+-- =============================================================================
+-- One row per sign-in; tokens carry the row's id. expires_at is fixed at
+-- sign-in plus session_max_hours for sessions without remember me and
+-- slides forward on refresh for remembered ones. Ended sessions keep
+-- revoked_at until the daily sessions.prune job deletes them.
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    remember_me BOOLEAN NOT NULL DEFAULT FALSE,
+    ip_addr VARCHAR(45),
+    user_agent TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_activity_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_sessions_expires ON user_sessions(expires_at);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000041', '041_user_sessions')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,35 @@
+-- Migration: 042_shared_folder_snapshots
+-- Version: 20240101000042
+-- Description: Point-in-time snapshots of shared drives that admins can restore
+
+-- =============================================================================
+-- Shared Folder Snapshots
+-- =============================================================================
+-- One row per snapshot. The files live in {volume}/.snapshots/{drive id}/{id},
+-- on the volume that held the drive when the snapshot was taken, as hard
+-- links to the live files (mode hardlink) or as a full copy (mode copy).
+-- Creating, restoring and deleting run as jobs; state follows them.
+-- Snapshots past expires_at are deleted by the daily snapshots.prune job.
+CREATE TABLE IF NOT EXISTS shared_folder_snapshots (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    shared_folder_id UUID NOT NULL REFERENCES shared_folders(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    volume TEXT NOT NULL,
+    mode VARCHAR(20) NOT NULL DEFAULT 'hardlink',   -- hardlink or copy
+    state VARCHAR(20) NOT NULL DEFAULT 'creating',  -- creating, ready, restoring, deleting or failed
+    warning TEXT,
+    error TEXT,
+    file_count BIGINT NOT NULL DEFAULT 0,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    job_id BIGINT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_shared_folder_snapshots_folder ON shared_folder_snapshots(shared_folder_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_shared_folder_snapshots_expires ON shared_folder_snapshots(expires_at) WHERE expires_at IS NOT NULL;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000042', '042_shared_folder_snapshots')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,18 @@
+-- Migration: 043_zip_download_level
+-- Version: 20240101000043
+-- Description: Compression level of streamed ZIP downloads
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Folder and multi-file ZIP downloads are streamed to the client as they are
+-- built. 0 stores files without compressing them, which is fastest for media
+-- that does not compress anyway; 1-9 are deflate levels from fastest to
+-- smallest.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('zip_download_level', '6', 'Deflate level of ZIP downloads (0 = store uncompressed, 1-9 = fastest to smallest)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000043', '043_zip_download_level')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,17 @@
+-- Migration: 044_trash_size_limit
+-- Version: 20240101000044
+-- Description: Per-user trash size limit enforced by the trash cleanup job
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Besides removing items older than trash_retention_days, the cleanup job
+-- removes the oldest items of a user's trash until it is no larger than this
+-- many bytes. 0 keeps trash of any size.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('trash_max_size_bytes', '0', 'Largest trash per user in bytes; oldest items are removed first (0 = unlimited)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000044', '044_trash_size_limit')
+ON CONFLICT (version) DO NOTHING;
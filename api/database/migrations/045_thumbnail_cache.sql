@@ -0,0 +1,16 @@
+-- Migration: 045_thumbnail_cache
+-- Version: 20240101000045
+-- Description: Size cap of the persistent thumbnail cache
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Thumbnails are kept under /data/.thumbnails. Once they take more than this
+-- many bytes the least recently used ones are removed. 0 keeps all of them.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('thumbnail_cache_max_bytes', '2147483648', 'Largest size of the thumbnail cache in bytes; least recently used thumbnails are removed first (0 = unlimited)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000045', '045_thumbnail_cache')
+ON CONFLICT (version) DO NOTHING;
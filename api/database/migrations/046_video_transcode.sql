@@ -0,0 +1,17 @@
+-- Migration: 046_video_transcode
+-- Version: 20240101000046
+-- Description: Switch for converting video previews browsers cannot play
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- /api/preview/stream converts videos whose container or codecs browsers do
+-- not play (MKV, HEVC, ...) to fragmented MP4 with ffmpeg. Low-power servers
+-- can turn this off; natively playable videos are still streamed.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('video_transcode_enabled', 'true', 'Convert video previews browsers cannot play with ffmpeg')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000046', '046_video_transcode')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,36 @@
+-- Migration: 047_content_index
+-- Version: 20240101000047
+-- Description: Full-text index of document contents for search
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Off by default: extracting text reads every document once and the index
+-- holds up to 512 KB of text per file. Turn it on, then start a reindex.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('content_index_enabled', 'false', 'Index the text of txt, md, pdf, docx and xlsx files for content search'),
+    ('content_index_max_file_bytes', '52428800', 'Largest file whose text is indexed')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- File Contents
+-- =============================================================================
+-- One row per indexed file. path is data-root relative (users/alice/a.pdf,
+-- shared/Drive/b.docx) so search can scope rows to the user's home and the
+-- shared drives they may read. size and mtime are the file's when indexed;
+-- a file is extracted again once either changes.
+CREATE TABLE IF NOT EXISTS file_contents (
+    path TEXT PRIMARY KEY,
+    size BIGINT NOT NULL,
+    mtime TIMESTAMP WITH TIME ZONE NOT NULL,
+    content TEXT NOT NULL,
+    content_tsv TSVECTOR NOT NULL,
+    indexed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_contents_tsv ON file_contents USING GIN (content_tsv);
+CREATE INDEX IF NOT EXISTS idx_file_contents_path ON file_contents(path text_pattern_ops);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000047', '047_content_index')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,34 @@
+-- Migration: 048_metadata_search_indexes
+-- Version: 20240101000048
+-- Description: Trigram indexes for substring search in file tags and descriptions
+
+-- =============================================================================
+-- Trigram Indexes
+-- =============================================================================
+-- File search matches tags and descriptions by substring (LIKE '%q%'). The
+-- GIN index on tags only serves exact tag lookups (tags ? 'q'), so substring
+-- searches scanned every row of the user. pg_trgm indexes answer LIKE with a
+-- leading wildcard for queries of three or more characters.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- The tags of a row as one lower-cased string, one tag per line. A query
+-- without a line break can only match inside one tag, so no recheck against
+-- the individual tags is needed.
+CREATE OR REPLACE FUNCTION file_metadata_tag_text(tags JSONB) RETURNS TEXT
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT CASE WHEN jsonb_typeof(tags) = 'array'
+        THEN COALESCE((SELECT lower(string_agg(t, E'\n')) FROM jsonb_array_elements_text(tags) AS t), '')
+        ELSE ''
+    END
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_file_metadata_tag_text_trgm
+    ON file_metadata USING GIN (file_metadata_tag_text(tags) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_file_metadata_description_trgm
+    ON file_metadata USING GIN (lower(description) gin_trgm_ops);
+
+ANALYZE file_metadata;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000048', '048_metadata_search_indexes')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,39 @@
+-- Migration: 049_dir_sizes
+-- Version: 20240101000049
+-- Description: Per-directory size index for storage usage and folder statistics
+
+-- =============================================================================
+-- Directory Sizes
+-- =============================================================================
+-- One row per directory below users/, shared/ and trash/, data-root relative.
+-- Each row holds only the directory's direct entries; the size of a tree is
+-- the sum over the rows of its path prefix. A change therefore rescans one
+-- directory instead of walking the tree. The visible_* columns leave out
+-- entries whose name starts with a dot, and hidden marks rows below such a
+-- directory, for the folder statistics shown to users.
+CREATE TABLE IF NOT EXISTS dir_sizes (
+    path TEXT PRIMARY KEY,
+    hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    files BIGINT NOT NULL DEFAULT 0,
+    folders BIGINT NOT NULL DEFAULT 0,
+    visible_bytes BIGINT NOT NULL DEFAULT 0,
+    visible_files BIGINT NOT NULL DEFAULT 0,
+    visible_folders BIGINT NOT NULL DEFAULT 0,
+    scanned_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_dir_sizes_path ON dir_sizes(path text_pattern_ops);
+
+-- Trees scanned completely. Rows are also written for single directories as
+-- changes come in; sums are only trusted below a scanned tree.
+CREATE TABLE IF NOT EXISTS dir_size_roots (
+    path TEXT PRIMARY KEY,
+    scanned_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_dir_size_roots_path ON dir_size_roots(path text_pattern_ops);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000049', '049_dir_sizes')
+ON CONFLICT (version) DO NOTHING;
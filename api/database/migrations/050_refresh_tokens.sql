@@ -0,0 +1,25 @@
+-- Migration: 050_refresh_tokens
+-- Version: 20240101000050
+-- Description: Refresh cookies for login sessions and short-lived access tokens
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('session_access_minutes', '15', 'Lifetime of an access token issued for the refresh cookie; browsers renew it while the session lasts')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Refresh Tokens
+-- =============================================================================
+-- Sign-in sets an httpOnly cookie with a random refresh token; only its
+-- SHA-256 is stored. The token is good for as long as its session: revoking
+-- the session or signing out everywhere ends it too.
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS refresh_token_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_sessions_refresh ON user_sessions(refresh_token_hash)
+    WHERE refresh_token_hash IS NOT NULL;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000050', '050_refresh_tokens')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,23 @@
+-- Migration: 051_login_lockout
+-- Version: 20240101000051
+-- Description: Growing account lockouts after failed logins and an alert when one starts
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- The first lock lasts bruteforce_lock_minutes; every further lock before a
+-- successful login is twice as long, up to bruteforce_max_lock_minutes.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('bruteforce_max_lock_minutes', '1440', 'Longest account lock in minutes; repeated locks double up to it')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Alert Rules
+-- =============================================================================
+INSERT INTO alert_rules (name, description, threshold, window_seconds, cooldown_seconds) VALUES
+    ('account_locked', 'Account locked after repeated failed logins or 2FA codes', 1, 0, 3600)
+ON CONFLICT (name) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000051', '051_login_lockout')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,18 @@
+-- Migration: 052_audit_retention
+-- Version: 20240101000052
+-- Description: Retention period for audit log entries
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Audit log entries older than this many days are moved by the daily
+-- audit.archive job into gzipped NDJSON files below .audit-archive in the
+-- data root, one per month, and deleted from the table. 0 keeps every entry
+-- in the database.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('audit_retention_days', '0', 'Days to keep audit log entries before archiving them to .audit-archive (0 = keep forever)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000052', '052_audit_retention')
+ON CONFLICT (version) DO NOTHING;
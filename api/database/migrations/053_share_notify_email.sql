@@ -0,0 +1,16 @@
+-- Migration: 053_share_notify_email
+-- Version: 20240101000053
+-- Description: Email the creator of a share about its downloads and uploads
+
+-- =============================================================================
+-- Share Email Notifications
+-- =============================================================================
+-- Downloads through a share link and files received through an upload link
+-- always notify the creator in the notification center. With notify_email
+-- set they are emailed as well when SMTP is configured and the creator has
+-- an address.
+ALTER TABLE shares ADD COLUMN IF NOT EXISTS notify_email BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000053', '053_share_notify_email')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,42 @@
+-- Migration: 054_email
+-- Version: 20240101000054
+-- Description: SMTP settings, welcome mails and self-service password resets
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Outgoing mail server, edited through /api/admin/smtp. While smtp_host is
+-- empty the SMTP_* environment variables are used. smtp_tls_mode is starttls
+-- (STARTTLS when offered), tls (implicit TLS, port 465) or none.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('smtp_host', '', 'SMTP server host; empty uses the SMTP_* environment variables'),
+    ('smtp_port', '587', 'SMTP server port'),
+    ('smtp_tls_mode', 'starttls', 'SMTP TLS mode: starttls, tls or none'),
+    ('smtp_from', '', 'Sender address of outgoing mail'),
+    ('smtp_username', '', 'SMTP user name'),
+    ('smtp_password', '', 'SMTP password')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Password Tokens
+-- =============================================================================
+-- Single-use links to set a password: purpose 'reset' for self-service
+-- resets (1 hour), 'setup' for welcome mails of new accounts (72 hours).
+-- Only the SHA-256 of a token is stored. Issuing a token uses up the unused
+-- ones of the same purpose; rows are deleted a week after they expire.
+CREATE TABLE IF NOT EXISTS password_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    purpose VARCHAR(10) NOT NULL CHECK (purpose IN ('reset', 'setup')),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_tokens_user ON password_tokens(user_id, purpose) WHERE used_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_password_tokens_expires ON password_tokens(expires_at);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000054', '054_email')
+ON CONFLICT (version) DO NOTHING;
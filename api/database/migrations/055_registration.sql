@@ -0,0 +1,26 @@
+-- Migration: 055_registration
+-- Version: 20240101000055
+-- Description: Self-registration with an optional admin approval queue
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+INSERT INTO system_settings (key, value, description) VALUES
+    ('registration_enabled', 'false', 'Let anyone create an account through POST /auth/register'),
+    ('registration_email_domains', '', 'Comma-separated email domains allowed to register (empty: any, email optional)'),
+    ('registration_require_approval', 'true', 'Create self-registered accounts inactive until an admin approves them')
+ON CONFLICT (key) DO NOTHING;
+
+-- =============================================================================
+-- Approval Queue
+-- =============================================================================
+-- Self-registered accounts awaiting approval are inactive with
+-- registration_pending set. Approving activates them and clears the flag;
+-- rejecting deletes them. The home folder is created on first login.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS registration_pending BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_users_registration_pending ON users(created_at) WHERE registration_pending;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000055', '055_registration')
+ON CONFLICT (version) DO NOTHING;
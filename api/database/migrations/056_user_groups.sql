@@ -0,0 +1,98 @@
+-- Migration: 056_user_groups
+-- Version: 20240101000056
+-- Description: User groups, group grants on shared folders and group storage quotas
+
+-- =============================================================================
+-- Groups
+-- =============================================================================
+-- Admin-managed groups of users. The built-in everyone group (all_users)
+-- has no member rows: it always contains every active non-guest account.
+-- storage_quota, when set, is the quota of members without one of their own.
+CREATE TABLE IF NOT EXISTS user_groups (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    all_users BOOLEAN NOT NULL DEFAULT FALSE,
+    storage_quota BIGINT CHECK (storage_quota IS NULL OR storage_quota > 0),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_groups_all_users ON user_groups(all_users) WHERE all_users;
+
+CREATE TABLE IF NOT EXISTS user_group_members (
+    group_id UUID NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    added_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_group_members_user ON user_group_members(user_id);
+
+INSERT INTO user_groups (name, description, all_users)
+SELECT 'everyone', 'All active users', TRUE
+WHERE NOT EXISTS (SELECT 1 FROM user_groups WHERE all_users);
+
+-- =============================================================================
+-- Shared Folder Grants
+-- =============================================================================
+-- A group's permission on a shared folder, alongside shared_folder_members
+CREATE TABLE IF NOT EXISTS shared_folder_groups (
+    shared_folder_id UUID NOT NULL REFERENCES shared_folders(id) ON DELETE CASCADE,
+    group_id UUID NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+    permission_level INTEGER NOT NULL CHECK (permission_level IN (1, 2)),
+    added_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (shared_folder_id, group_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_shared_folder_groups_group ON shared_folder_groups(group_id);
+
+-- =============================================================================
+-- Effective Membership
+-- =============================================================================
+-- user_group_membership expands the everyone group. shared_folder_access is
+-- each user's highest permission on a folder across their direct membership
+-- and their groups; permission checks read it instead of shared_folder_members.
+CREATE OR REPLACE VIEW user_group_membership AS
+    SELECT group_id, user_id FROM user_group_members
+    UNION
+    SELECT g.id, u.id
+    FROM user_groups g
+    CROSS JOIN users u
+    WHERE g.all_users AND u.is_active AND NOT u.is_guest;
+
+CREATE OR REPLACE VIEW shared_folder_access AS
+    SELECT shared_folder_id, user_id, MAX(permission_level) AS permission_level
+    FROM (
+        SELECT shared_folder_id, user_id, permission_level FROM shared_folder_members
+        UNION ALL
+        SELECT sfg.shared_folder_id, ugm.user_id, sfg.permission_level
+        FROM shared_folder_groups sfg
+        INNER JOIN user_group_membership ugm ON ugm.group_id = sfg.group_id
+    ) grants
+    GROUP BY shared_folder_id, user_id;
+
+-- =============================================================================
+-- Storage Quota
+-- =============================================================================
+-- A user's quota: their own when set (non-zero), else the largest quota of
+-- their groups, else the stored value, else fallback
+CREATE OR REPLACE FUNCTION user_storage_quota(uid UUID, own BIGINT, fallback BIGINT) RETURNS BIGINT
+LANGUAGE sql STABLE AS $$
+    SELECT COALESCE(
+        NULLIF(own, 0),
+        (SELECT MAX(g.storage_quota)
+         FROM user_group_membership ugm
+         INNER JOIN user_groups g ON g.id = ugm.group_id
+         WHERE ugm.user_id = uid),
+        own,
+        fallback
+    )
+$$;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000056', '056_user_groups')
+ON CONFLICT (version) DO NOTHING;
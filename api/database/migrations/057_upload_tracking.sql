@@ -0,0 +1,38 @@
+-- Migration: 057_upload_tracking
+-- Version: 20240101000057
+-- Description: Upload tracking that survives restarts, expiry of abandoned uploads
+
+-- =============================================================================
+-- Upload Tracking
+-- =============================================================================
+-- The client IP of each tus upload, recorded when it is created and read when
+-- it completes, so an upload resumed after a restart is still audited with
+-- the IP that started it. Rows go when the upload completes or expires.
+CREATE TABLE IF NOT EXISTS tus_upload_clients (
+    upload_id VARCHAR(255) PRIMARY KEY,
+    client_ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_tus_upload_clients_created ON tus_upload_clients(created_at);
+
+-- Files being put in place by web uploads, so changes the file watcher sees
+-- for them are not taken for SMB activity. Marks last seconds; ones left
+-- by a restart are loaded back and expire as usual.
+CREATE TABLE IF NOT EXISTS web_upload_marks (
+    path TEXT PRIMARY KEY,
+    marked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Unfinished uploads whose staged files have not changed for this many hours
+-- are deleted by the hourly uploads.expire job. 0 keeps them forever.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('upload_expiry_hours', '24', 'Hours without activity after which unfinished uploads are deleted (0 = keep forever)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000057', '057_upload_tracking')
+ON CONFLICT (version) DO NOTHING;
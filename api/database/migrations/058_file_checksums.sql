@@ -0,0 +1,26 @@
+-- Migration: 058_file_checksums
+-- Version: 20240101000058
+-- Description: Stored whole-file SHA-256 checksums
+
+-- =============================================================================
+-- File Checksums
+-- =============================================================================
+-- One row per hashed file, written when an upload completes or the checksum
+-- is first requested. path is data-root relative like file_contents.path;
+-- size and mtime are the file's when hashed, and a row that no longer
+-- matches them is computed again. Writes through the API and changes the
+-- file watcher reports drop the row; moves rewrite its path.
+CREATE TABLE IF NOT EXISTS file_checksums (
+    path TEXT PRIMARY KEY,
+    size BIGINT NOT NULL,
+    mtime TIMESTAMP WITH TIME ZONE NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    computed_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Prefix matches for moves and deletes of whole folders
+CREATE INDEX IF NOT EXISTS idx_file_checksums_path_prefix ON file_checksums(path text_pattern_ops);
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000058', '058_file_checksums')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,22 @@
+-- Migration: 059_upload_dedup
+-- Version: 20240101000059
+-- Description: Deduplicated uploads from stored checksums
+
+-- =============================================================================
+-- File Checksums
+-- =============================================================================
+-- Upload prechecks look files up by content
+CREATE INDEX IF NOT EXISTS idx_file_checksums_sha256 ON file_checksums(sha256);
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Clients opt in per request; turning this off makes prechecks only compare
+-- with the destination, so no upload is answered from other files.
+INSERT INTO system_settings (key, value, description) VALUES
+    ('upload_dedup_enabled', 'true', 'Let upload prechecks complete an upload from an identical file the user can read')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000059', '059_upload_dedup')
+ON CONFLICT (version) DO NOTHING;
@@ -0,0 +1,18 @@
+-- Migration: 060_file_stats_last_download
+-- Version: 20240101000060
+-- Description: Time of the last download per download statistics row
+
+-- =============================================================================
+-- File Download Statistics
+-- =============================================================================
+-- updated_at also moves when a path is renamed, moved, deleted or restored,
+-- so it can't report the last download. last_download_at is only set when
+-- downloads are flushed. Existing rows start from updated_at, the closest
+-- value there is.
+ALTER TABLE file_stats ADD COLUMN IF NOT EXISTS last_download_at TIMESTAMPTZ;
+
+UPDATE file_stats SET last_download_at = updated_at WHERE last_download_at IS NULL;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000060', '060_file_stats_last_download')
+ON CONFLICT (version) DO NOTHING;
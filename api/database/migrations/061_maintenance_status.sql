@@ -0,0 +1,16 @@
+-- Migration: 061_maintenance_status
+-- Version: 20240101000061
+-- Description: Maintenance state and message for the public status endpoint
+
+-- =============================================================================
+-- Settings
+-- =============================================================================
+-- Announced by GET /api/status only; uploads and downloads are not blocked
+INSERT INTO system_settings (key, value, description) VALUES
+    ('maintenance_mode', 'false', 'Report the service as under maintenance in GET /api/status'),
+    ('maintenance_message', '', 'Message shown by GET /api/status during maintenance (empty = the incident message)')
+ON CONFLICT (key) DO NOTHING;
+
+-- Record this migration
+INSERT INTO schema_migrations (version, name) VALUES ('20240101000061', '061_maintenance_status')
+ON CONFLICT (version) DO NOTHING;
@@ -43,13 +43,13 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Filter by specific event type",
+                        "description": "Filter by event type; several comma-separated",
                         "name": "eventType",
                         "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Filter by category (file, admin, user)",
+                        "description": "Filter by category (file, admin, user, share)",
                         "name": "category",
                         "in": "query"
                     },
@@ -380,12 +380,19 @@ type TrashListResponse struct {
 
 // TrashStatsResponse represents trash statistics response
 type TrashStatsResponse struct {
-	ItemCount            int    `json:"itemCount" example:"10"`
-	TotalSize            int64  `json:"totalSize" example:"10485760"`
-	RetentionDays        int    `json:"retentionDays" example:"30"`
-	OldestItem           string `json:"oldestItem,omitempty" example:"2024-01-01T12:00:00Z"`
-	OldestItemDaysLeft   int    `json:"oldestItemDaysLeft,omitempty" example:"15"`
-	NewestItem           string `json:"newestItem,omitempty" example:"2024-01-15T12:00:00Z"`
+	ItemCount          int         `json:"itemCount" example:"10"`
+	TotalSize          int64       `json:"totalSize" example:"10485760"`
+	RetentionDays      int         `json:"retentionDays" example:"30"`
+	OldestItem         string      `json:"oldestItem,omitempty" example:"2024-01-01T12:00:00Z"`
+	OldestItemDaysLeft int         `json:"oldestItemDaysLeft,omitempty" example:"15"`
+	NewestItem         string      `json:"newestItem,omitempty" example:"2024-01-15T12:00:00Z"`
+	Policy             TrashPolicy `json:"policy"`
+}
+
+// TrashPolicy is what the trash cleanup job enforces
+type TrashPolicy struct {
+	RetentionDays int   `json:"retentionDays" example:"30"`
+	MaxSizeBytes  int64 `json:"maxSizeBytes" example:"21474836480"` // 0 = unlimited
 }
 
 // AuditLogListResponse represents audit log list response data
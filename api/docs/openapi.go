@@ -0,0 +1,10 @@
+package docs
+
+import _ "embed"
+
+// OpenAPISpec is the OpenAPI 3 document served at /api/openapi.json. It is
+// maintained by hand as the public contract for /api/v1; keep it in step
+// with handler responses (handlers/openapi_test.go checks the shapes).
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"database/sql"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Access mechanisms listed in an access report
+const (
+	AccessViaOwner        = "owner"
+	AccessViaSharedFolder = "shared_folder"
+	AccessViaGroup        = "group"
+	AccessViaFileShare    = "file_share"
+	AccessViaAdmin        = "admin"
+)
+
+// AccessGrant is one way a user reaches the reported path
+type AccessGrant struct {
+	Via             string `json:"via"`
+	PermissionLevel int    `json:"permissionLevel,omitempty"` // 1 = read, 2 = read-write
+	ItemPath        string `json:"itemPath,omitempty"`        // Shared item for file_share grants
+	GroupName       string `json:"groupName,omitempty"`       // Group behind a group grant
+	SharedBy        string `json:"sharedBy,omitempty"`
+	Inherited       bool   `json:"inherited,omitempty"` // Granted on an ancestor folder
+	Implicit        bool   `json:"implicit,omitempty"`  // Admins can manage membership and shares
+}
+
+// UserAccess lists how one user can access the reported path
+type UserAccess struct {
+	UserID          string        `json:"userId"`
+	Username        string        `json:"username"`
+	IsAdmin         bool          `json:"isAdmin"`
+	PermissionLevel int           `json:"permissionLevel"` // Highest level through any explicit grant
+	Grants          []AccessGrant `json:"grants"`
+}
+
+// LinkShareExposure is an active share link on the path or an ancestor
+type LinkShareExposure struct {
+	ID           string     `json:"id"`
+	Token        string     `json:"token"`
+	Path         string     `json:"path"`
+	ShareType    string     `json:"shareType"`
+	CreatedBy    string     `json:"createdBy,omitempty"`
+	Inherited    bool       `json:"inherited"`
+	HasPassword  bool       `json:"hasPassword"`
+	RequireLogin bool       `json:"requireLogin"`
+	Editable     bool       `json:"editable"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	MaxAccess    *int       `json:"maxAccess,omitempty"`
+	AccessCount  int        `json:"accessCount"`
+	Anonymous    bool       `json:"anonymous"` // Reachable by anyone holding the link
+}
+
+// AccessReport is the response of GET /api/admin/access-report
+type AccessReport struct {
+	Path              string              `json:"path"`
+	StorageType       string              `json:"storageType"`
+	AnonymousExposure bool                `json:"anonymousExposure"`
+	AnonymousLinks    int                 `json:"anonymousLinks"`
+	Users             []UserAccess        `json:"users"`
+	LinkShares        []LinkShareExposure `json:"linkShares"`
+	GeneratedAt       time.Time           `json:"generatedAt"`
+}
+
+// accessReportTarget is the reported path in the forms the permission
+// checks and the shares table use
+type accessReportTarget struct {
+	virtualPath string // /shared/{folder}/... or /home/... as seen by the owner
+	storedPath  string // Data-root relative, as stored in shares.path
+	owner       string // Home owner username
+	shared      bool
+}
+
+// parseAccessReportPath accepts /shared/{folder}/... or /users/{username}/...
+func parseAccessReportPath(requestPath string) (accessReportTarget, *APIError) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	parts := strings.Split(cleaned, "/")
+	if len(parts) < 2 || parts[1] == "" {
+		return accessReportTarget{}, ErrInvalidPath("Path must be /shared/{folder}/... or /users/{username}/...")
+	}
+	switch parts[0] {
+	case "shared":
+		return accessReportTarget{
+			virtualPath: "/" + cleaned,
+			storedPath:  cleaned,
+			shared:      true,
+		}, nil
+	case "users":
+		return accessReportTarget{
+			virtualPath: path.Join("/home", strings.Join(parts[2:], "/")),
+			storedPath:  cleaned,
+			owner:       parts[1],
+		}, nil
+	}
+	return accessReportTarget{}, ErrInvalidPath("Path must be /shared/{folder}/... or /users/{username}/...")
+}
+
+// isSameOrAncestor reports whether ancestor is p or one of its parent folders
+func isSameOrAncestor(ancestor, p string) bool {
+	return ancestor == p || strings.HasPrefix(p, strings.TrimSuffix(ancestor, "/")+"/")
+}
+
+// permissionLevelOf returns the highest level check grants, or 0
+func permissionLevelOf(check func(level int) bool) int {
+	if check(PermissionReadWrite) {
+		return PermissionReadWrite
+	}
+	if check(PermissionReadOnly) {
+		return PermissionReadOnly
+	}
+	return 0
+}
+
+// sharedFolderGrants describes how a user belongs to the shared drive of the
+// target: direct membership and each group granted on the drive. Whether
+// they grant access is decided by CheckSharedDrivePermission.
+func (h *Handler) sharedFolderGrants(userID string, target accessReportTarget) []AccessGrant {
+	folderName := strings.SplitN(target.storedPath, "/", 3)[1]
+	rows, err := h.db.Query(`
+		SELECT '', sfm.permission_level
+		FROM shared_folder_members sfm
+		INNER JOIN shared_folders sf ON sf.id = sfm.shared_folder_id
+		WHERE sf.name = $1 AND sfm.user_id = $2 AND sf.is_active = TRUE
+		UNION ALL
+		SELECT g.name, sfg.permission_level
+		FROM shared_folder_groups sfg
+		INNER JOIN shared_folders sf ON sf.id = sfg.shared_folder_id
+		INNER JOIN user_groups g ON g.id = sfg.group_id
+		INNER JOIN user_group_membership ugm ON ugm.group_id = sfg.group_id
+		WHERE sf.name = $1 AND ugm.user_id = $2 AND sf.is_active = TRUE
+	`, folderName, userID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var grants []AccessGrant
+	for rows.Next() {
+		var groupName string
+		var level int
+		if err := rows.Scan(&groupName, &level); err != nil {
+			continue
+		}
+		grant := AccessGrant{Via: AccessViaSharedFolder, PermissionLevel: level}
+		if groupName != "" {
+			grant.Via = AccessViaGroup
+			grant.GroupName = groupName
+		}
+		grants = append(grants, grant)
+	}
+	// Direct membership first, then groups by name
+	sort.SliceStable(grants, func(i, j int) bool {
+		if (grants[i].Via == AccessViaGroup) != (grants[j].Via == AccessViaGroup) {
+			return grants[j].Via == AccessViaGroup
+		}
+		return grants[i].GroupName < grants[j].GroupName
+	})
+	return grants
+}
+
+// fileShareGrants describes the file_shares rows that cover the target for a
+// user. Whether they grant access is decided by CheckFileSharePermission.
+func (h *Handler) fileShareGrants(userID string, target accessReportTarget) []AccessGrant {
+	rows, err := h.db.Query(`
+		SELECT fs.item_path, fs.is_folder, fs.permission_level, u.username
+		FROM file_shares fs
+		INNER JOIN users u ON u.id = fs.owner_id
+		WHERE fs.shared_with_id = $1 AND `+fileShareActiveSQL+`
+	`, userID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var grants []AccessGrant
+	for rows.Next() {
+		var itemPath, sharedBy string
+		var isFolder bool
+		var level int
+		if err := rows.Scan(&itemPath, &isFolder, &level, &sharedBy); err != nil {
+			continue
+		}
+		// Home paths are per owner
+		if !target.shared && sharedBy != target.owner {
+			continue
+		}
+		direct := itemPath == target.virtualPath
+		if !direct && !(isFolder && isSameOrAncestor(itemPath, target.virtualPath)) {
+			continue
+		}
+		grants = append(grants, AccessGrant{
+			Via:             AccessViaFileShare,
+			PermissionLevel: level,
+			ItemPath:        itemPath,
+			SharedBy:        sharedBy,
+			Inherited:       !direct,
+		})
+	}
+	return grants
+}
+
+// linkShareExposures lists active, unexpired share links on the target or an ancestor
+func (h *Handler) linkShareExposures(target accessReportTarget) ([]LinkShareExposure, error) {
+	rows, err := h.db.Query(`
+		SELECT s.id, s.token, s.path, s.share_type, COALESCE(u.username, ''),
+		       s.password_hash IS NOT NULL AND s.password_hash <> '', COALESCE(s.require_login, FALSE),
+		       COALESCE(s.editable, FALSE), s.expires_at, s.max_access, COALESCE(s.access_count, 0)
+		FROM shares s
+		LEFT JOIN users u ON u.id = s.created_by
+		WHERE s.is_active = TRUE
+		  AND (s.expires_at IS NULL OR s.expires_at > NOW())
+		  AND (s.max_access IS NULL OR s.max_access = 0 OR s.access_count < s.max_access)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]LinkShareExposure, 0)
+	for rows.Next() {
+		var l LinkShareExposure
+		var expiresAt sql.NullTime
+		var maxAccess sql.NullInt64
+		if err := rows.Scan(&l.ID, &l.Token, &l.Path, &l.ShareType, &l.CreatedBy,
+			&l.HasPassword, &l.RequireLogin, &l.Editable, &expiresAt, &maxAccess, &l.AccessCount); err != nil {
+			continue
+		}
+		stored := strings.Trim(l.Path, "/")
+		if !isSameOrAncestor(stored, target.storedPath) {
+			continue
+		}
+		l.Inherited = stored != target.storedPath
+		if expiresAt.Valid {
+			l.ExpiresAt = &expiresAt.Time
+		}
+		if maxAccess.Valid {
+			m := int(maxAccess.Int64)
+			l.MaxAccess = &m
+		}
+		l.Anonymous = !l.HasPassword && !l.RequireLogin
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// GetAccessReport lists everyone who can access a path and how
+// @Summary		Access report for a path
+// @Description	Lists every user who can access the path and through which mechanism: home ownership, shared drive membership (direct or through a group, naming the group), user-to-user shares on the path or an ancestor folder, and admins (implicitly). Also lists active share links on the path or an ancestor and flags links anyone can open (no password, no login required). Access is evaluated with the same checks the file endpoints enforce.
+// @Tags		Admin
+// @Produce		json
+// @Param		path	query		string	true	"/shared/{folder}/... or /users/{username}/..."
+// @Success		200		{object}	docs.SuccessResponse	"Access report"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/access-report [get]
+func (h *Handler) GetAccessReport(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermAuditRead); err != nil {
+		return err
+	}
+	requestPath := c.QueryParam("path")
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	target, apiErr := parseAccessReportPath(requestPath)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	rows, err := h.db.Query(`SELECT id, username, COALESCE(is_admin, FALSE) FROM users WHERE is_active = TRUE ORDER BY username`)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load users"))
+	}
+	type reportUser struct {
+		id, username string
+		isAdmin      bool
+	}
+	var users []reportUser
+	for rows.Next() {
+		var u reportUser
+		if rows.Scan(&u.id, &u.username, &u.isAdmin) == nil {
+			users = append(users, u)
+		}
+	}
+	rows.Close()
+
+	report := AccessReport{
+		Path:        "/" + target.storedPath,
+		StorageType: StorageHome,
+		Users:       make([]UserAccess, 0),
+		GeneratedAt: time.Now(),
+	}
+	if target.shared {
+		report.StorageType = StorageShared
+	}
+
+	for _, u := range users {
+		access := UserAccess{UserID: u.id, Username: u.username, IsAdmin: u.isAdmin, Grants: []AccessGrant{}}
+
+		if target.shared {
+			level := permissionLevelOf(func(level int) bool {
+				return h.CheckSharedDrivePermission(u.id, target.virtualPath, level)
+			})
+			if level > 0 {
+				grants := h.sharedFolderGrants(u.id, target)
+				if len(grants) == 0 {
+					// Granted by a membership whose details could not be matched
+					grants = []AccessGrant{{Via: AccessViaSharedFolder, PermissionLevel: level}}
+				}
+				access.Grants = append(access.Grants, grants...)
+				access.PermissionLevel = level
+			}
+		} else if u.username == target.owner {
+			access.Grants = append(access.Grants, AccessGrant{Via: AccessViaOwner, PermissionLevel: PermissionReadWrite})
+			access.PermissionLevel = PermissionReadWrite
+		}
+
+		if u.username != target.owner {
+			level := permissionLevelOf(func(level int) bool {
+				return h.CheckFileSharePermission(u.id, target.virtualPath, level)
+			})
+			if level > 0 {
+				grants := h.fileShareGrants(u.id, target)
+				if len(grants) == 0 {
+					// Granted by a share whose details could not be matched
+					grants = []AccessGrant{{Via: AccessViaFileShare, PermissionLevel: level}}
+				}
+				access.Grants = append(access.Grants, grants...)
+				if level > access.PermissionLevel {
+					access.PermissionLevel = level
+				}
+			}
+		}
+
+		if u.isAdmin {
+			access.Grants = append(access.Grants, AccessGrant{Via: AccessViaAdmin, Implicit: true})
+		}
+		if len(access.Grants) > 0 {
+			report.Users = append(report.Users, access)
+		}
+	}
+
+	links, err := h.linkShareExposures(target)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load share links"))
+	}
+	// Anonymous links first so they are not overlooked
+	sort.SliceStable(links, func(i, j int) bool { return links[i].Anonymous && !links[j].Anonymous })
+	for _, l := range links {
+		if l.Anonymous {
+			report.AnonymousLinks++
+		}
+	}
+	report.AnonymousExposure = report.AnonymousLinks > 0
+	report.LinkShares = links
+
+	return RespondSuccess(c, report)
+}
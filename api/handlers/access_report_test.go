@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseAccessReportPath(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantVirtual string
+		wantStored  string
+		wantOwner   string
+		wantErr     bool
+	}{
+		{"/shared/Finance", "/shared/Finance", "shared/Finance", "", false},
+		{"shared/Finance/2024/q1.xlsx", "/shared/Finance/2024/q1.xlsx", "shared/Finance/2024/q1.xlsx", "", false},
+		{"/users/alice/docs", "/home/docs", "users/alice/docs", "alice", false},
+		{"/users/alice", "/home", "users/alice", "alice", false},
+		{"/shared/../users/bob", "/home", "users/bob", "bob", false},
+		{"/shared", "", "", "", true},
+		{"/etc/passwd", "", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseAccessReportPath(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseAccessReportPath(%q) expected error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAccessReportPath(%q) unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got.virtualPath != tt.wantVirtual || got.storedPath != tt.wantStored || got.owner != tt.wantOwner {
+			t.Errorf("parseAccessReportPath(%q) = %+v", tt.in, got)
+		}
+	}
+}
+
+func TestIsSameOrAncestor(t *testing.T) {
+	tests := []struct {
+		ancestor, p string
+		want        bool
+	}{
+		{"shared/Finance", "shared/Finance", true},
+		{"shared/Finance", "shared/Finance/2024", true},
+		{"shared/Finance/", "shared/Finance/2024", true},
+		{"shared/Fin", "shared/Finance", false},
+		{"shared/Finance/2024", "shared/Finance", false},
+	}
+	for _, tt := range tests {
+		if got := isSameOrAncestor(tt.ancestor, tt.p); got != tt.want {
+			t.Errorf("isSameOrAncestor(%q, %q) = %v, want %v", tt.ancestor, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestSharedFolderGrants(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &Handler{db: tc.DB}
+	target, _ := parseAccessReportPath("/shared/Finance/2024")
+
+	// Access only through groups is not reported as membership
+	tc.Mock.ExpectQuery(`FROM shared_folder_members sfm[\s\S]+UNION ALL[\s\S]+INNER JOIN user_group_membership ugm`).
+		WithArgs("Finance", "u1").
+		WillReturnRows(sqlmock.NewRows([]string{"group", "permission_level"}).
+			AddRow("everyone", 1).
+			AddRow("accounting", 2))
+	grants := h.sharedFolderGrants("u1", target)
+	if len(grants) != 2 || grants[0].Via != AccessViaGroup || grants[0].GroupName != "accounting" || grants[0].PermissionLevel != 2 ||
+		grants[1].GroupName != "everyone" {
+		t.Errorf("group grants = %+v", grants)
+	}
+
+	// Direct membership comes first
+	tc.Mock.ExpectQuery("FROM shared_folder_members").
+		WithArgs("Finance", "u2").
+		WillReturnRows(sqlmock.NewRows([]string{"group", "permission_level"}).
+			AddRow("everyone", 1).
+			AddRow("", 2))
+	grants = h.sharedFolderGrants("u2", target)
+	if len(grants) != 2 || grants[0].Via != AccessViaSharedFolder || grants[0].GroupName != "" || grants[1].Via != AccessViaGroup {
+		t.Errorf("member grants = %+v", grants)
+	}
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,491 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	tusd "github.com/tus/tusd/v2/pkg/handler"
+)
+
+// Activity kinds
+const (
+	ActivityUpload   = "upload"
+	ActivityDownload = "download"
+	ActivityZip      = "zip"
+	ActivityCompress = "compress"
+	ActivityExtract  = "extract"
+	ActivityCopy     = "copy"
+	ActivityMove     = "move"
+	ActivityAdopt    = "adopt"
+	ActivityRewrap   = "rewrap"
+	ActivityMigrate  = "migrate"
+	ActivityExport   = "export"
+	ActivityImport   = "import"
+)
+
+const (
+	// ActivityMinDownloadSize is the size from which single-file downloads are tracked
+	ActivityMinDownloadSize = 10 * 1024 * 1024
+
+	// activityUploadIdleTimeout drops tus uploads that stopped sending data
+	// (paused or abandoned by the client)
+	activityUploadIdleTimeout = 2 * time.Minute
+)
+
+// activityPacePriority is the pacing priority of job kinds; transfers are not paced
+var activityPacePriority = map[string]string{
+	ActivityCompress: PacePriorityUser,
+	ActivityExtract:  PacePriorityUser,
+	ActivityCopy:     PacePriorityUser,
+	ActivityMove:     PacePriorityUser,
+	ActivityAdopt:    PacePriorityMaintenance,
+	ActivityRewrap:   PacePriorityMaintenance,
+	ActivityMigrate:  PacePriorityMaintenance,
+	ActivityExport:   PacePriorityMaintenance,
+	ActivityImport:   PacePriorityMaintenance,
+}
+
+// ErrActivityCancelled is returned by tracked transfers cancelled by an admin
+var ErrActivityCancelled = errors.New("cancelled by administrator")
+
+// ActivityInfo is a snapshot of a running transfer or job
+type ActivityInfo struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Username    string    `json:"username,omitempty"`
+	ShareToken  string    `json:"shareToken,omitempty"`
+	Path        string    `json:"path"`
+	BytesDone   int64     `json:"bytesDone"`
+	BytesTotal  int64     `json:"bytesTotal"`
+	BytesPerSec int64     `json:"bytesPerSec"`
+	StartedAt   time.Time `json:"startedAt"`
+	LastActive  time.Time `json:"lastActive"`
+}
+
+// Activity is a transfer or background job registered with the activity registry.
+// All methods are safe to call on a nil *Activity.
+type Activity struct {
+	info      ActivityInfo
+	bytesDone atomic.Int64
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+	pace      *PaceJob
+
+	mu         sync.Mutex
+	onCancel   func()
+	lastActive time.Time
+	lastBytes  int64
+	rate       int64
+}
+
+// ActivityRegistry keeps the in-memory list of running transfers and jobs
+type ActivityRegistry struct {
+	mu         sync.RWMutex
+	activities map[string]*Activity
+}
+
+var activityRegistry = &ActivityRegistry{
+	activities: make(map[string]*Activity),
+}
+
+// GetActivityRegistry returns the global activity registry
+func GetActivityRegistry() *ActivityRegistry {
+	return activityRegistry
+}
+
+func newActivityID(kind string) string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return kind + "-" + hex.EncodeToString(b)
+}
+
+// Start registers a new activity. Its context is derived from parent and is
+// cancelled when the activity is cancelled; call Finish when done.
+func (r *ActivityRegistry) Start(parent context.Context, info ActivityInfo) *Activity {
+	if info.ID == "" {
+		info.ID = newActivityID(info.Kind)
+	}
+	info.StartedAt = time.Now()
+
+	ctx, cancel := context.WithCancelCause(parent)
+	a := &Activity{
+		info:       info,
+		ctx:        ctx,
+		cancel:     cancel,
+		lastActive: info.StartedAt,
+	}
+	if priority, ok := activityPacePriority[info.Kind]; ok {
+		a.pace = GetBackgroundPacer().Begin(info.Kind, priority)
+	}
+
+	r.mu.Lock()
+	r.activities[info.ID] = a
+	r.mu.Unlock()
+	return a
+}
+
+// Get returns the activity with the given ID, or nil
+func (r *ActivityRegistry) Get(id string) *Activity {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.activities[id]
+}
+
+// Finish removes an activity from the registry
+func (r *ActivityRegistry) Finish(id string) {
+	r.mu.Lock()
+	a := r.activities[id]
+	delete(r.activities, id)
+	r.mu.Unlock()
+	if a != nil {
+		a.cancel(nil)
+		a.pace.End()
+	}
+}
+
+// Cancel cancels an activity by ID. Returns false if it is not running.
+func (r *ActivityRegistry) Cancel(id string) bool {
+	a := r.Get(id)
+	if a == nil {
+		return false
+	}
+	a.Cancel()
+	return true
+}
+
+// CancelAll cancels every running activity (e.g. on shutdown)
+func (r *ActivityRegistry) CancelAll() int {
+	r.mu.RLock()
+	activities := make([]*Activity, 0, len(r.activities))
+	for _, a := range r.activities {
+		activities = append(activities, a)
+	}
+	r.mu.RUnlock()
+
+	for _, a := range activities {
+		a.Cancel()
+	}
+	return len(activities)
+}
+
+// List returns a snapshot of all running activities, oldest first
+func (r *ActivityRegistry) List() []ActivityInfo {
+	r.mu.RLock()
+	list := make([]ActivityInfo, 0, len(r.activities))
+	for _, a := range r.activities {
+		list = append(list, a.Info())
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
+
+// sample updates transfer rates and drops idle tus uploads
+func (r *ActivityRegistry) sample(interval time.Duration) {
+	now := time.Now()
+	var stale []string
+
+	r.mu.RLock()
+	for id, a := range r.activities {
+		done := a.bytesDone.Load()
+		a.mu.Lock()
+		a.rate = int64(float64(done-a.lastBytes) / interval.Seconds())
+		a.lastBytes = done
+		idle := now.Sub(a.lastActive)
+		a.mu.Unlock()
+
+		if a.info.Kind == ActivityUpload && idle > activityUploadIdleTimeout {
+			stale = append(stale, id)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, id := range stale {
+		r.Finish(id)
+	}
+}
+
+// StartSampler periodically samples transfer rates
+func (r *ActivityRegistry) StartSampler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			r.sample(interval)
+		}
+	}()
+}
+
+// TrackTusProgress registers or updates a tus upload from a progress event.
+// Cancelling it stops the running PATCH request server-side.
+func (r *ActivityRegistry) TrackTusProgress(event tusd.HookEvent) {
+	id := ActivityUpload + "-" + event.Upload.ID
+	a := r.Get(id)
+	if a == nil {
+		a = r.Start(context.Background(), ActivityInfo{
+			ID:         id,
+			Kind:       ActivityUpload,
+			Username:   event.Upload.MetaData["username"],
+			Path:       event.Upload.MetaData["path"] + "/" + event.Upload.MetaData["filename"],
+			BytesTotal: event.Upload.Size,
+		})
+	}
+
+	upload := event.Upload
+	a.OnCancel(func() {
+		upload.StopUpload(tusd.HTTPResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       `{"error":"Upload cancelled by administrator"}`,
+		})
+	})
+	a.SetBytes(event.Upload.Offset)
+}
+
+// FinishTusUpload removes a completed tus upload from the registry
+func (r *ActivityRegistry) FinishTusUpload(uploadID string) {
+	r.Finish(ActivityUpload + "-" + uploadID)
+}
+
+// ID returns the activity ID
+func (a *Activity) ID() string {
+	if a == nil {
+		return ""
+	}
+	return a.info.ID
+}
+
+// Context returns the activity context, cancelled when the activity is cancelled
+func (a *Activity) Context() context.Context {
+	if a == nil {
+		return context.Background()
+	}
+	return a.ctx
+}
+
+// Err returns ErrActivityCancelled once the activity was cancelled by an admin,
+// or the parent context error (e.g. client disconnected)
+func (a *Activity) Err() error {
+	if a == nil || a.ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(a.ctx)
+}
+
+// Info returns a snapshot of the activity
+func (a *Activity) Info() ActivityInfo {
+	info := a.info
+	info.BytesDone = a.bytesDone.Load()
+	a.mu.Lock()
+	info.BytesPerSec = a.rate
+	info.LastActive = a.lastActive
+	a.mu.Unlock()
+	return info
+}
+
+// Pace lets the background pacer slow a job down; call it from the job's
+// loop. Returns the same error as Err once the activity is cancelled.
+func (a *Activity) Pace() error {
+	if a == nil {
+		return nil
+	}
+	_ = a.pace.PaceContext(a.ctx)
+	return a.Err()
+}
+
+// SetBytes sets the number of bytes processed so far
+func (a *Activity) SetBytes(n int64) {
+	if a == nil {
+		return
+	}
+	a.bytesDone.Store(n)
+	a.touch()
+}
+
+// AddBytes adds to the number of bytes processed
+func (a *Activity) AddBytes(n int64) {
+	if a == nil {
+		return
+	}
+	a.bytesDone.Add(n)
+	a.touch()
+}
+
+func (a *Activity) touch() {
+	a.mu.Lock()
+	a.lastActive = time.Now()
+	a.mu.Unlock()
+}
+
+// OnCancel sets a callback run when the activity is cancelled, for transfers
+// that cannot observe the activity context directly
+func (a *Activity) OnCancel(fn func()) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.onCancel = fn
+	a.mu.Unlock()
+}
+
+// Cancel cancels the activity context and runs the cancel callback
+func (a *Activity) Cancel() {
+	if a == nil {
+		return
+	}
+	a.cancel(ErrActivityCancelled)
+	a.mu.Lock()
+	fn := a.onCancel
+	a.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// Finish removes the activity from the registry
+func (a *Activity) Finish() {
+	if a == nil {
+		return
+	}
+	GetActivityRegistry().Finish(a.info.ID)
+}
+
+// activityResponseWriter counts bytes written to a tracked response and
+// aborts the response once the activity is cancelled
+type activityResponseWriter struct {
+	http.ResponseWriter
+	activity *Activity
+}
+
+func (w *activityResponseWriter) Write(p []byte) (int, error) {
+	if err := w.activity.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.activity.AddBytes(int64(n))
+	return n, err
+}
+
+func (w *activityResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *activityResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// TrackResponse registers an outgoing transfer (download or zip stream) and
+// wraps the response writer to count bytes. Call Finish on the returned
+// activity when the handler returns.
+func TrackResponse(c echo.Context, kind, path, shareToken string, total int64) *Activity {
+	info := ActivityInfo{
+		Kind:       kind,
+		Path:       path,
+		ShareToken: shareToken,
+		BytesTotal: total,
+	}
+	if claims := GetClaims(c); claims != nil {
+		info.Username = claims.Username
+	}
+
+	a := GetActivityRegistry().Start(c.Request().Context(), info)
+	c.SetRequest(c.Request().WithContext(a.Context()))
+	c.Response().Writer = &activityResponseWriter{ResponseWriter: c.Response().Writer, activity: a}
+	return a
+}
+
+// TrackJob registers a background job (compression, extraction, copy, move)
+// bound to the request context
+func TrackJob(c echo.Context, kind, path string, total int64) *Activity {
+	info := ActivityInfo{
+		Kind:       kind,
+		Path:       path,
+		BytesTotal: total,
+	}
+	if claims := GetClaims(c); claims != nil {
+		info.Username = claims.Username
+	}
+	return GetActivityRegistry().Start(c.Request().Context(), info)
+}
+
+// GetLiveActivity returns running transfers, jobs and WebSocket clients
+// @Summary		Live activity
+// @Description	Lists in-progress uploads, large downloads, zip streams, running jobs and connected WebSocket clients per user
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Live activity"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/activity/live [get]
+func (h *Handler) GetLiveActivity(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermStorageAdmin); err != nil {
+		return err
+	}
+
+	transfers := []ActivityInfo{}
+	jobs := []ActivityInfo{}
+	for _, a := range GetActivityRegistry().List() {
+		switch a.Kind {
+		case ActivityUpload, ActivityDownload, ActivityZip:
+			transfers = append(transfers, a)
+		default:
+			jobs = append(jobs, a)
+		}
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"transfers":        transfers,
+		"jobs":             jobs,
+		"websocketClients": hub.ClientCounts(),
+		"timestamp":        time.Now(),
+	})
+}
+
+// CancelActivity cancels a running transfer or job
+// @Summary		Cancel transfer or job
+// @Description	Cancels a running transfer or job by ID; the underlying request or upload is aborted
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Activity ID"
+// @Success		200		{object}	docs.SuccessResponse	"Cancelled"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/activity/{id}/cancel [post]
+func (h *Handler) CancelActivity(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if err != nil {
+		return err
+	}
+
+	id := c.Param("id")
+	a := GetActivityRegistry().Get(id)
+	if a == nil {
+		return RespondError(c, ErrNotFound("Activity"))
+	}
+	info := a.Info()
+	a.Cancel()
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminActivityCancel, info.Path, map[string]interface{}{
+		"activityId": info.ID,
+		"kind":       info.Kind,
+		"username":   info.Username,
+		"bytesDone":  info.BytesDone,
+	})
+
+	return RespondMessage(c, "Cancelled")
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestActivityRegistry_CancelPropagates(t *testing.T) {
+	r := &ActivityRegistry{activities: make(map[string]*Activity)}
+
+	a := r.Start(context.Background(), ActivityInfo{Kind: ActivityCopy, Path: "/home/big.iso", BytesTotal: 100})
+	a.SetBytes(40)
+
+	callbackRan := false
+	a.OnCancel(func() { callbackRan = true })
+
+	list := r.List()
+	if len(list) != 1 || list[0].BytesDone != 40 || list[0].Kind != ActivityCopy {
+		t.Fatalf("unexpected activity list: %+v", list)
+	}
+
+	if !r.Cancel(a.ID()) {
+		t.Fatal("expected cancel to find the activity")
+	}
+	if !errors.Is(a.Err(), ErrActivityCancelled) {
+		t.Errorf("expected ErrActivityCancelled, got %v", a.Err())
+	}
+	if a.Context().Err() == nil {
+		t.Error("expected activity context to be cancelled")
+	}
+	if !callbackRan {
+		t.Error("expected cancel callback to run")
+	}
+
+	r.Finish(a.ID())
+	if len(r.List()) != 0 {
+		t.Error("expected activity to be removed after Finish")
+	}
+	if r.Cancel(a.ID()) {
+		t.Error("expected cancel of finished activity to fail")
+	}
+}
+
+func TestActivityResponseWriter_AbortsAfterCancel(t *testing.T) {
+	r := &ActivityRegistry{activities: make(map[string]*Activity)}
+	a := r.Start(context.Background(), ActivityInfo{Kind: ActivityDownload})
+
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = &activityResponseWriter{ResponseWriter: rec, activity: a}
+
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+	if got := a.Info().BytesDone; got != 5 {
+		t.Errorf("expected 5 bytes counted, got %d", got)
+	}
+
+	a.Cancel()
+	if _, err := w.Write([]byte("world")); !errors.Is(err, ErrActivityCancelled) {
+		t.Errorf("expected write to fail after cancel, got %v", err)
+	}
+	if rec.Body.String() != "hello" {
+		t.Errorf("unexpected body %q", rec.Body.String())
+	}
+}
+
+func TestActivity_NilSafe(t *testing.T) {
+	var a *Activity
+	a.SetBytes(10)
+	a.AddBytes(10)
+	a.Cancel()
+	a.Finish()
+	if a.Err() != nil || a.ID() != "" {
+		t.Error("nil activity should be inert")
+	}
+}
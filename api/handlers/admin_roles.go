@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Admin permission keys. Admin routes require one or more of them, see
+// RequirePermission and RequireAdminPermission.
+const (
+	PermUsersManage     = "users.manage"
+	PermSettingsWrite   = "settings.write"
+	PermAuditRead       = "audit.read"
+	PermSharesManageAll = "shares.manage_all"
+	PermStorageAdmin    = "storage.admin"
+)
+
+// AdminPermissions lists every permission key; superadmins hold all of them
+var AdminPermissions = []string{
+	PermUsersManage,
+	PermSettingsWrite,
+	PermAuditRead,
+	PermSharesManageAll,
+	PermStorageAdmin,
+}
+
+// Built-in roles. RoleSuperadmin is stored as users.is_admin rather than in
+// user_roles, so code and tokens that check IsAdmin keep meaning superadmin.
+const (
+	RoleSuperadmin     = "superadmin"
+	RoleUserManager    = "user-manager"
+	RoleStorageManager = "storage-manager"
+	RoleAuditor        = "auditor"
+)
+
+// adminRolesCacheTTL bounds how long a role change made outside the roles
+// API, such as an SSO login updating is_admin, takes to reach requests
+const adminRolesCacheTTL = time.Minute
+
+// permissionsChangedHeader tells the client that its token no longer
+// matches the user's roles and should be refreshed
+const permissionsChangedHeader = "X-Permissions-Changed"
+
+// Role is an admin role and the permissions it grants
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	BuiltIn     bool     `json:"builtIn"`
+}
+
+// UserPermissions is the admin access a user holds through their roles.
+// Version changes whenever the permission set does.
+type UserPermissions struct {
+	Username    string   `json:"username"`
+	Superadmin  bool     `json:"superadmin"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	Version     string   `json:"version"`
+}
+
+// SetUserRolesRequest replaces the roles of a user
+type SetUserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// HasPermission reports whether the token grants an admin permission.
+// Superadmins hold every permission.
+func (c *JWTClaims) HasPermission(perm string) bool {
+	if c.IsAdmin {
+		return true
+	}
+	for _, p := range c.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// missingPermission returns the first of perms the token does not grant
+func (c *JWTClaims) missingPermission(perms []string) string {
+	for _, p := range perms {
+		if !c.HasPermission(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// AdminRoles resolves the admin permissions of users. Lookups are cached
+// for adminRolesCacheTTL; the roles and user APIs invalidate a user's entry
+// when they change it.
+type AdminRoles struct {
+	db    *sql.DB
+	audit *AuditHandler
+
+	mu    sync.Mutex
+	cache map[string]cachedPermissions
+}
+
+type cachedPermissions struct {
+	perms    *UserPermissions
+	loadedAt time.Time
+}
+
+var globalAdminRoles *AdminRoles
+
+// InitAdminRoles sets up the global role lookup
+func InitAdminRoles(db *sql.DB, audit *AuditHandler) *AdminRoles {
+	globalAdminRoles = &AdminRoles{
+		db:    db,
+		audit: audit,
+		cache: make(map[string]cachedPermissions),
+	}
+	return globalAdminRoles
+}
+
+// GetAdminRoles returns the global role lookup (nil before InitAdminRoles)
+func GetAdminRoles() *AdminRoles {
+	return globalAdminRoles
+}
+
+// Lookup returns the permissions of a user, from cache when fresh. A user
+// that no longer exists has none.
+func (r *AdminRoles) Lookup(userID string) (*UserPermissions, error) {
+	if r == nil {
+		return nil, nil
+	}
+	r.mu.Lock()
+	entry, ok := r.cache[userID]
+	r.mu.Unlock()
+	if ok && time.Since(entry.loadedAt) < adminRolesCacheTTL {
+		return entry.perms, nil
+	}
+	return r.Reload(userID)
+}
+
+// Reload reads the permissions of a user from the database and caches them
+func (r *AdminRoles) Reload(userID string) (*UserPermissions, error) {
+	if r == nil {
+		return nil, nil
+	}
+	perms, err := loadUserPermissions(r.db, userID)
+	if err == sql.ErrNoRows {
+		perms, err = newUserPermissions(false, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.cache[userID] = cachedPermissions{perms: perms, loadedAt: time.Now()}
+	r.mu.Unlock()
+	return perms, nil
+}
+
+// Invalidate drops the cached permissions of a user
+func (r *AdminRoles) Invalidate(userID string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.cache, userID)
+	r.mu.Unlock()
+}
+
+// refreshClaims replaces the admin fields of a token issued before the
+// user's roles last changed. It reports whether the token was stale.
+func (r *AdminRoles) refreshClaims(claims *JWTClaims) bool {
+	if r == nil || claims.IsLimited() {
+		return false
+	}
+	perms, err := r.Lookup(claims.UserID)
+	if err != nil {
+		log.Printf("[Roles] Failed to load permissions of %s: %v", claims.UserID, err)
+		return false
+	}
+	if perms.Version == claims.RoleVersion {
+		return false
+	}
+	claims.IsAdmin = perms.Superadmin
+	claims.Permissions = perms.Permissions
+	claims.RoleVersion = perms.Version
+	return true
+}
+
+// logDenied audit-logs an admin request refused for a missing permission
+func (r *AdminRoles) logDenied(c echo.Context, missing string) {
+	if r == nil || r.audit == nil {
+		return
+	}
+	r.audit.LogEventFromContext(c, EventPermissionDenied, c.Request().URL.Path, map[string]interface{}{
+		"permission": missing,
+		"method":     c.Request().Method,
+		"route":      c.Path(),
+	})
+}
+
+// loadUserPermissions reads the roles of a user. Returns sql.ErrNoRows for
+// an unknown user.
+func loadUserPermissions(db *sql.DB, userID string) (*UserPermissions, error) {
+	rows, err := db.Query(`
+		SELECT u.username, u.is_admin, r.name, r.permissions
+		FROM users u
+		LEFT JOIN user_roles ur ON ur.user_id = u.id
+		LEFT JOIN roles r ON r.name = ur.role_name
+		WHERE u.id = $1
+		ORDER BY r.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var username string
+	found := false
+	superadmin := false
+	var roles []Role
+	for rows.Next() {
+		var name sql.NullString
+		var permsJSON []byte
+		if err := rows.Scan(&username, &superadmin, &name, &permsJSON); err != nil {
+			return nil, err
+		}
+		found = true
+		if !name.Valid {
+			continue
+		}
+		role := Role{Name: name.String}
+		_ = json.Unmarshal(permsJSON, &role.Permissions)
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+	perms := newUserPermissions(superadmin, roles)
+	perms.Username = username
+	return perms, nil
+}
+
+// newUserPermissions merges the permissions of roles. A superadmin holds
+// every permission whatever their other roles.
+func newUserPermissions(superadmin bool, roles []Role) *UserPermissions {
+	p := &UserPermissions{Superadmin: superadmin, Roles: []string{}, Permissions: []string{}}
+	granted := make(map[string]bool)
+	if superadmin {
+		p.Roles = append(p.Roles, RoleSuperadmin)
+		for _, perm := range AdminPermissions {
+			granted[perm] = true
+		}
+	}
+	for _, role := range roles {
+		p.Roles = append(p.Roles, role.Name)
+		for _, perm := range role.Permissions {
+			granted[perm] = true
+		}
+	}
+	for perm := range granted {
+		p.Permissions = append(p.Permissions, perm)
+	}
+	sort.Strings(p.Permissions)
+
+	key := strings.Join(p.Permissions, ",")
+	if superadmin {
+		key = RoleSuperadmin + ":" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+	p.Version = hex.EncodeToString(sum[:6])
+	return p
+}
+
+// RequirePermission ensures the user holds every given admin permission.
+// Refusals are audit-logged with the missing permission. Users that pass
+// must also meet the 2FA policy for admin accounts.
+func (h *AuthHandler) RequirePermission(perms ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims := c.Get("user").(*JWTClaims)
+			if missing := claims.missingPermission(perms); missing != "" {
+				GetAdminRoles().logDenied(c, missing)
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error":      "Admin access required",
+					"permission": missing,
+				})
+			}
+			if adminViolates2FAPolicy(h.db, claims.UserID) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"error": "Two-factor authentication is required for admin accounts",
+					"code":  "2FA_REQUIRED",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// guardSuperadminAccount keeps admins who are not superadmins from changing
+// a superadmin account, which would let them take it over
+func guardSuperadminAccount(c echo.Context, db *sql.DB, claims *JWTClaims, userID string) *APIError {
+	if claims.IsAdmin {
+		return nil
+	}
+	var isAdmin bool
+	if err := db.QueryRow("SELECT is_admin FROM users WHERE id = $1", userID).Scan(&isAdmin); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound("User")
+		}
+		return ErrInternal("Database error")
+	}
+	if isAdmin {
+		GetAdminRoles().logDenied(c, RoleSuperadmin)
+		return ErrForbidden("Only a superadmin can change a superadmin account")
+	}
+	return nil
+}
+
+// guardSuperadminGrant keeps admins who are not superadmins from creating
+// or promoting superadmins
+func guardSuperadminGrant(c echo.Context, claims *JWTClaims, isAdmin bool) *APIError {
+	if !isAdmin || claims.IsAdmin {
+		return nil
+	}
+	GetAdminRoles().logDenied(c, RoleSuperadmin)
+	return ErrForbidden("Only a superadmin can grant superadmin access")
+}
+
+// listRoles returns all roles by name
+func listRoles(db *sql.DB) ([]Role, error) {
+	rows, err := db.Query(`
+		SELECT name, COALESCE(description, ''), permissions, built_in
+		FROM roles
+		ORDER BY built_in DESC, name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		var permsJSON []byte
+		if err := rows.Scan(&role.Name, &role.Description, &permsJSON, &role.BuiltIn); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(permsJSON, &role.Permissions)
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// ListRoles returns the admin roles and their permissions
+// @Summary		List admin roles
+// @Description	Returns the admin roles and the permission keys each grants.
+// @Tags		Admin
+// @Produce		json
+// @Success		200	{object}	docs.SuccessResponse	"Roles in data.roles"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/roles [get]
+func (h *AuthHandler) ListRoles(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermUsersManage); err != nil {
+		return err
+	}
+	roles, err := listRoles(h.db)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list roles", err))
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"roles":       roles,
+		"permissions": AdminPermissions,
+	})
+}
+
+// GetUserRoles returns the roles and effective permissions of a user
+// @Summary		Get user roles
+// @Description	Returns the roles assigned to a user and the permissions they grant.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"User ID"
+// @Success		200	{object}	docs.SuccessResponse	"Roles and permissions"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/users/{id}/roles [get]
+func (h *AuthHandler) GetUserRoles(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermUsersManage); err != nil {
+		return err
+	}
+	perms, err := loadUserPermissions(h.db, c.Param("id"))
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("User"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load roles", err))
+	}
+	return RespondSuccess(c, perms)
+}
+
+// SetUserRoles replaces the roles of a user. Admins can only assign or
+// remove roles whose permissions they hold themselves, and only
+// superadmins can assign or remove superadmin.
+// @Summary		Set user roles
+// @Description	Replaces the roles of a user. Roles granting permissions the caller lacks cannot be assigned or removed; the last active superadmin cannot be demoted. Tokens issued before the change pick up the new permissions on their next request.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string				true	"User ID"
+// @Param		body	body		SetUserRolesRequest	true	"Roles"
+// @Success		200		{object}	docs.SuccessResponse	"New roles and permissions"
+// @Failure		400		{object}	docs.ErrorResponse		"Unknown role"
+// @Failure		403		{object}	docs.ErrorResponse		"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse		"Not found"
+// @Security	BearerAuth
+// @Router		/admin/users/{id}/roles [put]
+func (h *AuthHandler) SetUserRoles(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermUsersManage)
+	if claims == nil {
+		return err // Error response already written
+	}
+	userID := c.Param("id")
+
+	var req SetUserRolesRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+
+	known, err := listRoles(h.db)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list roles", err))
+	}
+	byName := make(map[string]Role, len(known))
+	for _, role := range known {
+		byName[role.Name] = role
+	}
+	wanted := make(map[string]bool)
+	for _, name := range req.Roles {
+		if _, ok := byName[name]; !ok {
+			return RespondError(c, ErrBadRequest("Unknown role: "+name))
+		}
+		wanted[name] = true
+	}
+
+	current, err := loadUserPermissions(h.db, userID)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("User"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load roles", err))
+	}
+	had := make(map[string]bool)
+	for _, name := range current.Roles {
+		had[name] = true
+	}
+
+	var added, removed []string
+	for name := range wanted {
+		if !had[name] {
+			added = append(added, name)
+		}
+	}
+	for name := range had {
+		if !wanted[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	if len(added) == 0 && len(removed) == 0 {
+		return RespondSuccess(c, current)
+	}
+
+	// Only roles within the caller's own permissions change hands
+	for _, name := range append(append([]string{}, added...), removed...) {
+		if name == RoleSuperadmin && !claims.IsAdmin {
+			GetAdminRoles().logDenied(c, RoleSuperadmin)
+			return RespondError(c, ErrForbidden("Only a superadmin can assign or remove superadmin"))
+		}
+		if missing := claims.missingPermission(byName[name].Permissions); missing != "" {
+			GetAdminRoles().logDenied(c, missing)
+			return RespondError(c, ErrForbidden("Cannot assign or remove role "+name+" without permission "+missing))
+		}
+	}
+
+	superadmin := wanted[RoleSuperadmin]
+	if current.Superadmin && !superadmin {
+		var others int
+		if err := h.db.QueryRow(`
+			SELECT COUNT(*) FROM users WHERE is_admin = true AND is_active = true AND id <> $1
+		`, userID).Scan(&others); err != nil {
+			return RespondError(c, ErrInternal("Database error"))
+		}
+		if others == 0 {
+			return RespondError(c, ErrBadRequest("At least one active superadmin is required"))
+		}
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("update roles", err))
+	}
+	defer tx.Rollback()
+
+	if len(removed) > 0 {
+		if _, err := tx.Exec(`
+			DELETE FROM user_roles WHERE user_id = $1 AND role_name = ANY($2)
+		`, userID, pq.Array(removed)); err != nil {
+			return RespondError(c, ErrOperationFailed("update roles", err))
+		}
+	}
+	for _, name := range added {
+		if name == RoleSuperadmin {
+			continue
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO user_roles (user_id, role_name, assigned_by) VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, userID, name, claims.UserID); err != nil {
+			return RespondError(c, ErrOperationFailed("update roles", err))
+		}
+	}
+	if superadmin != current.Superadmin {
+		if _, err := tx.Exec(`
+			UPDATE users SET is_admin = $1, updated_at = NOW() WHERE id = $2
+		`, superadmin, userID); err != nil {
+			return RespondError(c, ErrOperationFailed("update roles", err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return RespondError(c, ErrOperationFailed("update roles", err))
+	}
+	GetAdminRoles().Invalidate(userID)
+
+	updated, err := loadUserPermissions(h.db, userID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load roles", err))
+	}
+	h.auditHandler.LogEventFromContext(c, EventAdminUserRoles, updated.Username, map[string]interface{}{
+		"added":       added,
+		"removed":     removed,
+		"permissions": updated.Permissions,
+	})
+	return RespondSuccess(c, updated)
+}
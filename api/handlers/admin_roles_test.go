@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+)
+
+func TestNewUserPermissions(t *testing.T) {
+	none := newUserPermissions(false, nil)
+	if len(none.Permissions) != 0 || len(none.Roles) != 0 {
+		t.Errorf("no roles = %+v", none)
+	}
+
+	merged := newUserPermissions(false, []Role{
+		{Name: RoleAuditor, Permissions: []string{PermAuditRead}},
+		{Name: RoleUserManager, Permissions: []string{PermUsersManage, PermAuditRead}},
+	})
+	if len(merged.Permissions) != 2 || merged.Permissions[0] != PermAuditRead || merged.Permissions[1] != PermUsersManage {
+		t.Errorf("merged permissions = %v", merged.Permissions)
+	}
+
+	super := newUserPermissions(true, nil)
+	if len(super.Permissions) != len(AdminPermissions) || super.Roles[0] != RoleSuperadmin {
+		t.Errorf("superadmin = %+v", super)
+	}
+
+	// The version follows the permission set, not how it was granted
+	same := newUserPermissions(false, []Role{{Name: "custom", Permissions: []string{PermUsersManage, PermAuditRead}}})
+	if same.Version != merged.Version {
+		t.Error("same permissions got different versions")
+	}
+	if none.Version == merged.Version || merged.Version == super.Version {
+		t.Error("different permissions share a version")
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	h := &AuthHandler{}
+	mw := h.RequirePermission(PermStorageAdmin)
+	next := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+
+	for _, tc := range []struct {
+		name   string
+		claims *JWTClaims
+		want   int
+	}{
+		{"superadmin", &JWTClaims{UserID: "u1", IsAdmin: true}, http.StatusNoContent},
+		{"storage manager", &JWTClaims{UserID: "u2", Permissions: []string{PermStorageAdmin}}, http.StatusNoContent},
+		{"auditor", &JWTClaims{UserID: "u3", Permissions: []string{PermAuditRead}}, http.StatusForbidden},
+		{"user", &JWTClaims{UserID: "u4"}, http.StatusForbidden},
+	} {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/admin/selftest", nil), rec)
+		c.Set("user", tc.claims)
+		if err := mw(next)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
+
+// expectUserRoles mocks loadUserPermissions for a user holding roles
+func expectUserRoles(mock sqlmock.Sqlmock, userID string, superadmin bool, roles ...Role) {
+	rows := sqlmock.NewRows([]string{"username", "is_admin", "name", "permissions"})
+	if len(roles) == 0 {
+		rows.AddRow("alice", superadmin, nil, nil)
+	}
+	for _, role := range roles {
+		perms, _ := json.Marshal(role.Permissions)
+		rows.AddRow("alice", superadmin, role.Name, perms)
+	}
+	mock.ExpectQuery("FROM users u\\s+LEFT JOIN user_roles").WithArgs(userID).WillReturnRows(rows)
+}
+
+func expectRoles(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM roles").WillReturnRows(sqlmock.NewRows([]string{"name", "description", "permissions", "built_in"}).
+		AddRow(RoleSuperadmin, "", []byte(`["users.manage","settings.write","audit.read","shares.manage_all","storage.admin"]`), true).
+		AddRow(RoleAuditor, "", []byte(`["audit.read"]`), true).
+		AddRow(RoleStorageManager, "", []byte(`["storage.admin"]`), true).
+		AddRow(RoleUserManager, "", []byte(`["users.manage"]`), true))
+}
+
+func TestSetUserRoles_LimitsToOwnPermissions(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := CreateTestAuthHandler(tc.DB)
+	manager := &JWTClaims{UserID: "m1", Permissions: []string{PermUsersManage}}
+
+	put := func(claims *JWTClaims, role string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := NewJSONRequest(http.MethodPut, "/api/admin/users/u1/roles", map[string]interface{}{"roles": []string{role}})
+		c := tc.Echo.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("u1")
+		c.Set("user", claims)
+		if err := h.SetUserRoles(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	// A user manager cannot hand out storage.admin
+	expectRoles(tc.Mock)
+	expectUserRoles(tc.Mock, "u1", false)
+	AssertStatus(t, put(manager, RoleStorageManager), http.StatusForbidden)
+
+	// ...nor superadmin
+	expectRoles(tc.Mock)
+	expectUserRoles(tc.Mock, "u1", false)
+	AssertStatus(t, put(manager, RoleSuperadmin), http.StatusForbidden)
+
+	// ...but can assign user-manager
+	expectRoles(tc.Mock)
+	expectUserRoles(tc.Mock, "u1", false)
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("INSERT INTO user_roles").WithArgs("u1", RoleUserManager, "m1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+	expectUserRoles(tc.Mock, "u1", false, Role{Name: RoleUserManager, Permissions: []string{PermUsersManage}})
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	AssertStatus(t, put(manager, RoleUserManager), http.StatusOK)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshClaims(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	roles := &AdminRoles{db: tc.DB, cache: make(map[string]cachedPermissions)}
+
+	// A token of a demoted superadmin loses admin access
+	expectUserRoles(tc.Mock, "u1", false, Role{Name: RoleAuditor, Permissions: []string{PermAuditRead}})
+	claims := &JWTClaims{UserID: "u1", IsAdmin: true, RoleVersion: newUserPermissions(true, nil).Version}
+	if !roles.refreshClaims(claims) {
+		t.Fatal("stale token not detected")
+	}
+	if claims.IsAdmin || !claims.HasPermission(PermAuditRead) || claims.HasPermission(PermUsersManage) {
+		t.Errorf("refreshed claims = %+v", claims)
+	}
+
+	// Served from cache once current
+	if roles.refreshClaims(claims) {
+		t.Error("current token reported stale")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
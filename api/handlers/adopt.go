@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Adopt job states
+const (
+	AdoptRunning   = "running"
+	AdoptCompleted = "completed"
+	AdoptFailed    = "failed"
+	AdoptCancelled = "cancelled"
+)
+
+const (
+	// maxAdoptSamples caps the per-item changes listed in a job summary
+	maxAdoptSamples = 100
+	// maxAdoptJobs is how many finished jobs are kept for status queries
+	maxAdoptJobs = 50
+)
+
+// AdoptRequest is the body of POST /api/admin/files/adopt
+type AdoptRequest struct {
+	Path           string `json:"path"`           // Data-root relative: shared/{folder}/... or users/{username}/...
+	FixPermissions *bool  `json:"fixPermissions"` // Default true
+	DryRun         bool   `json:"dryRun"`
+}
+
+// AdoptChange describes a permission or ownership fix for one item
+type AdoptChange struct {
+	Path    string `json:"path"`
+	IsDir   bool   `json:"isDir"`
+	OldMode string `json:"oldMode,omitempty"`
+	NewMode string `json:"newMode,omitempty"`
+	OldUID  *int   `json:"oldUid,omitempty"`
+	NewUID  *int   `json:"newUid,omitempty"`
+	OldGID  *int   `json:"oldGid,omitempty"`
+	NewGID  *int   `json:"newGid,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AdoptSummary is the running and final result of an adopt job
+type AdoptSummary struct {
+	Dirs              int64         `json:"dirs"`
+	Files             int64         `json:"files"`
+	Bytes             int64         `json:"bytes"`
+	SkippedSymlinks   int64         `json:"skippedSymlinks"`
+	PermissionChanges int64         `json:"permissionChanges"`
+	OwnershipChanges  int64         `json:"ownershipChanges"`
+	Errors            int64         `json:"errors"`
+	StorageRecorded   int64         `json:"storageRecorded"`
+	StorageActual     int64         `json:"storageActual"`
+	Changes           []AdoptChange `json:"changes"`
+	ChangesTruncated  bool          `json:"changesTruncated,omitempty"`
+}
+
+// AdoptJob is an adopt run tracked in memory. Its ID is also the activity ID,
+// so it shows up in /admin/activity/live and can be cancelled there.
+type AdoptJob struct {
+	ID         string       `json:"id"`
+	Path       string       `json:"path"`
+	Target     string       `json:"target"` // shared drive name or username
+	DryRun     bool         `json:"dryRun"`
+	FixPerms   bool         `json:"fixPermissions"`
+	Status     string       `json:"status"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt *time.Time   `json:"finishedAt,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Summary    AdoptSummary `json:"summary"`
+}
+
+// adoptRun guards a job while its walk updates the counters
+type adoptRun struct {
+	mu  sync.Mutex
+	job AdoptJob
+}
+
+// adoptTarget is the resolved owner of the subtree being adopted
+type adoptTarget struct {
+	rel      string // cleaned data-root relative path
+	realPath string
+	shared   bool
+	name     string // shared drive name or username
+	userID   string // set for home directories
+}
+
+// adoptPolicy is the mode and ownership an adopted item should end up with
+type adoptPolicy struct {
+	dirMode  os.FileMode
+	fileMode os.FileMode
+	uid      int // -1 keeps the owner
+	gid      int
+}
+
+var (
+	adoptJobsMu sync.Mutex
+	adoptJobs   = make(map[string]*adoptRun)
+	adoptOrder  []string
+)
+
+func (t adoptTarget) policy() adoptPolicy {
+	if t.shared {
+		// Same as EnsureSharedFolderDir / SetSharedPermissions
+		return adoptPolicy{dirMode: SharedDirPerm, fileMode: SharedFilePerm, uid: -1, gid: UsersGroupID}
+	}
+	// Same as EnsureUserHomeDir and files written by the API
+	return adoptPolicy{dirMode: 0755, fileMode: 0644, uid: os.Getuid(), gid: os.Getgid()}
+}
+
+// resolveAdoptTarget validates the requested path and finds the shared drive
+// or user that owns it
+func (h *Handler) resolveAdoptTarget(requestPath string) (adoptTarget, *APIError) {
+	cleaned := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	parts := strings.Split(cleaned, "/")
+	if len(parts) < 2 || (parts[0] != "shared" && parts[0] != "users") {
+		return adoptTarget{}, ErrInvalidPath("Path must be shared/{folder}/... or users/{username}/...")
+	}
+
+	ownerRoot := filepath.Join(h.dataRoot, parts[0], parts[1])
+	target := adoptTarget{
+		rel:      cleaned,
+		realPath: filepath.Join(h.dataRoot, filepath.FromSlash(cleaned)),
+		shared:   parts[0] == "shared",
+	}
+	if !isPathWithinRoot(target.realPath, ownerRoot) {
+		return adoptTarget{}, ErrInvalidPath("Path escapes the data root")
+	}
+
+	info, err := os.Lstat(target.realPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return adoptTarget{}, ErrNotFound("Path")
+		}
+		return adoptTarget{}, ErrOperationFailed("access path", err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return adoptTarget{}, ErrBadRequest("Path is a symbolic link")
+	}
+
+	if target.shared {
+		rows, err := h.db.Query(`SELECT name FROM shared_folders WHERE is_active = TRUE`)
+		if err != nil {
+			return adoptTarget{}, ErrInternal("Failed to load shared drives")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if rows.Scan(&name) == nil && sanitizeFolderName(name) == parts[1] {
+				target.name = name
+				break
+			}
+		}
+		if target.name == "" {
+			return adoptTarget{}, ErrNotFound("Shared drive (create it before adopting its files)")
+		}
+		return target, nil
+	}
+
+	target.name = parts[1]
+	err = h.db.QueryRow(`SELECT id FROM users WHERE username = $1`, target.name).Scan(&target.userID)
+	if err == sql.ErrNoRows {
+		return adoptTarget{}, ErrNotFound("User")
+	}
+	if err != nil {
+		return adoptTarget{}, ErrInternal("Failed to load user")
+	}
+	return target, nil
+}
+
+// snapshot returns a copy of the job that is safe to serialize
+func (r *adoptRun) snapshot() AdoptJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.job
+	job.Summary.Changes = append([]AdoptChange{}, r.job.Summary.Changes...)
+	return job
+}
+
+func (r *adoptRun) update(fn func(s *AdoptSummary)) {
+	r.mu.Lock()
+	fn(&r.job.Summary)
+	r.mu.Unlock()
+}
+
+func (r *adoptRun) addChange(change AdoptChange) {
+	r.update(func(s *AdoptSummary) {
+		if change.Error != "" {
+			s.Errors++
+		}
+		if len(s.Changes) < maxAdoptSamples {
+			s.Changes = append(s.Changes, change)
+		} else {
+			s.ChangesTruncated = true
+		}
+	})
+}
+
+func (r *adoptRun) finish(status string, err error) {
+	now := time.Now()
+	r.mu.Lock()
+	r.job.Status = status
+	r.job.FinishedAt = &now
+	if err != nil {
+		r.job.Error = err.Error()
+	}
+	r.mu.Unlock()
+}
+
+func registerAdoptJob(run *adoptRun) {
+	adoptJobsMu.Lock()
+	defer adoptJobsMu.Unlock()
+	adoptJobs[run.job.ID] = run
+	adoptOrder = append(adoptOrder, run.job.ID)
+
+	// Forget the oldest finished jobs
+	for len(adoptOrder) > maxAdoptJobs {
+		oldest := adoptJobs[adoptOrder[0]]
+		if oldest != nil && oldest.snapshot().Status == AdoptRunning {
+			break
+		}
+		delete(adoptJobs, adoptOrder[0])
+		adoptOrder = adoptOrder[1:]
+	}
+}
+
+func getAdoptJob(id string) *adoptRun {
+	adoptJobsMu.Lock()
+	defer adoptJobsMu.Unlock()
+	return adoptJobs[id]
+}
+
+// adoptEntry normalizes mode and ownership of one item and records what changed
+func adoptEntry(run *adoptRun, p adoptPolicy, realPath, displayPath string, info os.FileInfo) {
+	change := AdoptChange{Path: displayPath, IsDir: info.IsDir()}
+	changed := false
+
+	wantMode := p.fileMode
+	if info.IsDir() {
+		wantMode = p.dirMode
+	}
+	curMode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if curMode != wantMode {
+		change.OldMode = fmt.Sprintf("%04o", uint32(curMode.Perm()))
+		change.NewMode = fmt.Sprintf("%04o", uint32(wantMode))
+		changed = true
+		run.update(func(s *AdoptSummary) { s.PermissionChanges++ })
+		if !run.job.DryRun {
+			if err := os.Chmod(realPath, wantMode); err != nil {
+				change.Error = err.Error()
+			}
+		}
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		uid, gid := int(st.Uid), int(st.Gid)
+		newUID, newGID := -1, -1
+		if p.uid >= 0 && uid != p.uid {
+			change.OldUID, change.NewUID = &uid, &p.uid
+			newUID = p.uid
+		}
+		if p.gid >= 0 && gid != p.gid {
+			change.OldGID, change.NewGID = &gid, &p.gid
+			newGID = p.gid
+		}
+		if newUID >= 0 || newGID >= 0 {
+			changed = true
+			run.update(func(s *AdoptSummary) { s.OwnershipChanges++ })
+			if !run.job.DryRun {
+				if err := os.Lchown(realPath, newUID, newGID); err != nil && change.Error == "" {
+					change.Error = err.Error()
+				}
+			}
+		}
+	}
+
+	if changed {
+		run.addChange(change)
+	}
+}
+
+// runAdopt walks the subtree, fixes permissions and rebuilds storage usage
+func (h *Handler) runAdopt(run *adoptRun, activity *Activity, target adoptTarget, actorID, clientIP string) {
+	defer activity.Finish()
+
+	policy := target.policy()
+	statsCache := GetStatsCache()
+	walkErr := filepath.WalkDir(target.realPath, func(p string, d fs.DirEntry, err error) error {
+		if paceErr := activity.Pace(); paceErr != nil {
+			return paceErr
+		}
+		if err != nil {
+			run.addChange(AdoptChange{Path: "/" + target.rel, Error: err.Error()})
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, _ := filepath.Rel(h.dataRoot, p)
+		displayPath := "/" + filepath.ToSlash(rel)
+
+		// Never follow or modify links; chmod would act on their target
+		if d.Type()&fs.ModeSymlink != 0 {
+			run.update(func(s *AdoptSummary) { s.SkippedSymlinks++ })
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			run.addChange(AdoptChange{Path: displayPath, Error: err.Error()})
+			return nil
+		}
+
+		if info.IsDir() {
+			run.update(func(s *AdoptSummary) { s.Dirs++ })
+			if !run.job.DryRun && statsCache != nil {
+				statsCache.Invalidate(p)
+			}
+		} else {
+			run.update(func(s *AdoptSummary) {
+				s.Files++
+				s.Bytes += info.Size()
+			})
+			activity.AddBytes(info.Size())
+		}
+
+		if run.job.FixPerms {
+			adoptEntry(run, policy, p, displayPath, info)
+		}
+		return nil
+	})
+
+	if walkErr != nil {
+		status := AdoptFailed
+		if activity.Err() != nil {
+			status = AdoptCancelled
+		}
+		run.finish(status, walkErr)
+		return
+	}
+
+	// Compare recorded usage with the disk and rebuild it
+	var recorded int64
+	var ownerRoot string
+	if target.shared {
+		_ = h.db.QueryRow(`SELECT COALESCE(storage_used, 0) FROM shared_folders WHERE name = $1 AND is_active = TRUE`, target.name).Scan(&recorded)
+		ownerRoot = filepath.Join(h.dataRoot, "shared", sanitizeFolderName(target.name))
+	} else {
+		_ = h.db.QueryRow(`SELECT COALESCE(storage_used, 0) FROM users WHERE id = $1`, target.userID).Scan(&recorded)
+		ownerRoot = filepath.Join(h.dataRoot, "users", target.name)
+	}
+	actual, _ := h.calculateDirSize(ownerRoot)
+	run.update(func(s *AdoptSummary) {
+		s.StorageRecorded = recorded
+		s.StorageActual = actual
+	})
+
+	if !run.job.DryRun {
+		var err error
+		if target.shared {
+			err = h.RecalculateSharedFolderStorage(target.name)
+		} else {
+			err = h.RecalculateUserStorage(target.userID, target.name)
+		}
+		if err != nil {
+			LogError("Failed to rebuild storage usage after adopt", err, "path", target.rel)
+		}
+		InvalidateStorageCache(target.name)
+		GetChangeJournal().Record(ChangeCreate, target.realPath, "", actorID)
+		GetListingVersions().Invalidate(target.realPath)
+
+		result := run.snapshot()
+		_ = h.auditHandler.LogEvent(&actorID, clientIP, EventAdminFilesAdopt, "/"+target.rel, map[string]interface{}{
+			"jobId":             result.ID,
+			"target":            target.name,
+			"fixPermissions":    result.FixPerms,
+			"dirs":              result.Summary.Dirs,
+			"files":             result.Summary.Files,
+			"bytes":             result.Summary.Bytes,
+			"permissionChanges": result.Summary.PermissionChanges,
+			"ownershipChanges":  result.Summary.OwnershipChanges,
+			"errors":            result.Summary.Errors,
+			"storageBefore":     recorded,
+			"storageAfter":      actual,
+		})
+	}
+
+	run.finish(AdoptCompleted, nil)
+}
+
+// AdoptFiles starts a job that takes ownership of a directory tree copied
+// into the data root outside the API
+// @Summary		Adopt files placed on disk
+// @Description	Walks a subtree under the data root (shared/{folder}/... or users/{username}/...), normalizes permissions and ownership as if created through the API, rebuilds storage usage and audit-logs a summary. Runs as a background job; dryRun only reports what would change.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		AdoptRequest	true	"Path and options"
+// @Success		202		{object}	docs.SuccessResponse	"Job started"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/files/adopt [post]
+func (h *Handler) AdoptFiles(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if err != nil {
+		return err
+	}
+
+	var req AdoptRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if req.Path == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+
+	target, apiErr := h.resolveAdoptTarget(req.Path)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	fixPerms := req.FixPermissions == nil || *req.FixPermissions
+	activity := GetActivityRegistry().Start(context.Background(), ActivityInfo{
+		Kind:     ActivityAdopt,
+		Username: claims.Username,
+		Path:     "/" + target.rel,
+	})
+	run := &adoptRun{job: AdoptJob{
+		ID:        activity.ID(),
+		Path:      "/" + target.rel,
+		Target:    target.name,
+		DryRun:    req.DryRun,
+		FixPerms:  fixPerms,
+		Status:    AdoptRunning,
+		StartedAt: time.Now(),
+	}}
+	registerAdoptJob(run)
+
+	go h.runAdopt(run, activity, target, claims.UserID, c.RealIP())
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data":    run.snapshot(),
+	})
+}
+
+// GetAdoptJob returns progress or the result of an adopt job
+// @Summary		Adopt job status
+// @Description	Returns counters, sample changes and status of an adopt job
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Job ID"
+// @Success		200		{object}	docs.SuccessResponse	"Job status"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/files/adopt/{id} [get]
+func (h *Handler) GetAdoptJob(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermStorageAdmin); err != nil {
+		return err
+	}
+	run := getAdoptJob(c.Param("id"))
+	if run == nil {
+		return RespondError(c, ErrNotFound("Adopt job"))
+	}
+	return RespondSuccess(c, run.snapshot())
+}
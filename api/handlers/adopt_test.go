@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdoptEntry_DryRunAndApply(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(filePath, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy := adoptTarget{}.policy() // home policy: 0755 dirs, 0644 files, current owner
+
+	info, _ := os.Lstat(filePath)
+	dryRun := &adoptRun{job: AdoptJob{DryRun: true, FixPerms: true}}
+	adoptEntry(dryRun, policy, filePath, "/users/alice/photo.jpg", info)
+
+	result := dryRun.snapshot()
+	if result.Summary.PermissionChanges != 1 || len(result.Summary.Changes) != 1 {
+		t.Fatalf("expected one reported change, got %+v", result.Summary)
+	}
+	if c := result.Summary.Changes[0]; c.OldMode != "0600" || c.NewMode != "0644" {
+		t.Errorf("unexpected change %+v", c)
+	}
+	if info, _ := os.Stat(filePath); info.Mode().Perm() != 0600 {
+		t.Error("dry run must not modify the file")
+	}
+
+	apply := &adoptRun{job: AdoptJob{FixPerms: true}}
+	adoptEntry(apply, policy, filePath, "/users/alice/photo.jpg", info)
+	if info, _ := os.Stat(filePath); info.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %o", info.Mode().Perm())
+	}
+	if apply.snapshot().Summary.Errors != 0 {
+		t.Errorf("unexpected errors: %+v", apply.snapshot().Summary.Changes)
+	}
+}
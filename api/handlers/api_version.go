@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Public API versions. Routes are registered once under /api; each version
+// prefix maps onto them until a breaking change needs its own handlers.
+const (
+	APIVersionHeader = "API-Version"
+	APIVersionLatest = "1"
+)
+
+// apiVersionPrefixes maps versioned prefixes to the unversioned routes
+var apiVersionPrefixes = map[string]string{
+	"/api/v1": APIVersionLatest,
+}
+
+// stripAPIVersion returns the unversioned path and the version it named,
+// or an empty version for paths outside a versioned prefix
+func stripAPIVersion(p string) (string, string) {
+	for prefix, version := range apiVersionPrefixes {
+		if p == prefix {
+			return "/api", version
+		}
+		if strings.HasPrefix(p, prefix+"/") {
+			return "/api" + p[len(prefix):], version
+		}
+	}
+	return p, ""
+}
+
+// APIVersionMiddleware serves /api/v1/... from the /api routes. It must run
+// before routing (e.Pre) so rate limit classes and the tus handler see the
+// unversioned path. Every API response carries the version it was served as.
+func APIVersionMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			p, version := stripAPIVersion(req.URL.Path)
+			if version != "" {
+				req.URL.Path = p
+				if req.URL.RawPath != "" {
+					req.URL.RawPath, _ = stripAPIVersion(req.URL.RawPath)
+				}
+				req.RequestURI = req.URL.RequestURI()
+			} else if p == "/api" || strings.HasPrefix(p, "/api/") {
+				version = APIVersionLatest
+			}
+			if version != "" {
+				c.Response().Header().Set(APIVersionHeader, version)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archive formats created by compress and compress-stream
+const (
+	ArchiveFormatZip    = "zip"
+	ArchiveFormatTarZst = "tar.zst"
+)
+
+// ZIP entry compression methods
+const (
+	ZipMethodDeflate = "deflate"
+	ZipMethodZstd    = "zstd" // WinZip method 93; 7-Zip and recent WinZip read it
+)
+
+const (
+	// defaultZstdLevel is the zstd level used when a request does not set one
+	defaultZstdLevel = 3
+	// zstdWindowSize bounds encoder memory; files larger than the window are
+	// still compressed as one stream
+	zstdWindowSize = 8 << 20
+	// zstdMaxWindow is the largest window accepted when decompressing, so a
+	// crafted archive cannot make the decoder allocate without limit
+	zstdMaxWindow = 128 << 20
+	// zstdMaxEncoders bounds parallel block encoders for tar.zst streams
+	zstdMaxEncoders = 4
+)
+
+func init() {
+	// Let every zip reader (extract, ZIP preview) open zstd entries
+	zip.RegisterDecompressor(zstd.ZipMethodWinZip, zstd.ZipDecompressor(zstd.WithDecoderMaxWindow(zstdMaxWindow)))
+}
+
+// ArchiveOptions selects the archive format and codec of a compress request
+type ArchiveOptions struct {
+	Format string // ArchiveFormatZip or ArchiveFormatTarZst
+	Method string // ZIP only: ZipMethodDeflate or ZipMethodZstd
+	Level  int    // Codec level; 0 uses the codec default
+}
+
+// ParseArchiveOptions validates the format, method and level of a request.
+// Deflate levels are 1-9 and zstd levels 1-22, as in the reference tools.
+func ParseArchiveOptions(format, method string, level int) (ArchiveOptions, *APIError) {
+	opts := ArchiveOptions{
+		Format: strings.ToLower(strings.TrimPrefix(format, ".")),
+		Method: strings.ToLower(method),
+		Level:  level,
+	}
+	if opts.Format == "" {
+		opts.Format = ArchiveFormatZip
+	}
+
+	switch opts.Format {
+	case ArchiveFormatZip:
+		if opts.Method == "" {
+			opts.Method = ZipMethodDeflate
+		}
+		if opts.Method != ZipMethodDeflate && opts.Method != ZipMethodZstd {
+			return opts, ErrBadRequest("method must be deflate or zstd")
+		}
+	case ArchiveFormatTarZst:
+		if opts.Method != "" && opts.Method != ZipMethodZstd {
+			return opts, ErrBadRequest("tar.zst archives are always zstd compressed")
+		}
+		opts.Method = ZipMethodZstd
+	default:
+		return opts, ErrBadRequest("format must be zip or tar.zst")
+	}
+
+	maxLevel := 22
+	if opts.Method == ZipMethodDeflate {
+		maxLevel = flate.BestCompression
+	}
+	if opts.Level < 0 || opts.Level > maxLevel {
+		return opts, ErrBadRequest(fmt.Sprintf("level must be between 1 and %d for %s", maxLevel, opts.Method))
+	}
+	return opts, nil
+}
+
+// Ext returns the file extension of the archive, including the dot
+func (o ArchiveOptions) Ext() string {
+	return "." + o.Format
+}
+
+// zstdEncoderOptions returns bounded-memory encoder options for a level
+func zstdEncoderOptions(level, concurrency int) []zstd.EOption {
+	if level == 0 {
+		level = defaultZstdLevel
+	}
+	return []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithWindowSize(zstdWindowSize),
+		zstd.WithEncoderConcurrency(concurrency),
+	}
+}
+
+// newZstdReader returns a streaming decoder with a bounded window
+func newZstdReader(r io.Reader) (*zstd.Decoder, error) {
+	return zstd.NewReader(r,
+		zstd.WithDecoderMaxWindow(zstdMaxWindow),
+		zstd.WithDecoderLowmem(true),
+		zstd.WithDecoderConcurrency(1),
+	)
+}
+
+// archiveWriter adds entries to an archive being created
+type archiveWriter interface {
+	// CreateDir adds a directory entry
+	CreateDir(name string, info os.FileInfo) error
+	// CreateFile starts a file entry; info.Size() must match the bytes written
+	CreateFile(name string, info os.FileInfo) (io.Writer, error)
+	// Close finishes the archive; the underlying writer is not closed
+	Close() error
+}
+
+// newArchiveWriter creates a writer for the requested format
+func newArchiveWriter(w io.Writer, opts ArchiveOptions) (archiveWriter, error) {
+	if opts.Format == ArchiveFormatTarZst {
+		enc, err := zstd.NewWriter(w, zstdEncoderOptions(opts.Level, min(runtime.GOMAXPROCS(0), zstdMaxEncoders))...)
+		if err != nil {
+			return nil, err
+		}
+		return &tarZstWriter{enc: enc, tw: tar.NewWriter(enc)}, nil
+	}
+
+	zw := zip.NewWriter(w)
+	method := zip.Deflate
+	if opts.Method == ZipMethodZstd {
+		method = zstd.ZipMethodWinZip
+		zw.RegisterCompressor(method, zstd.ZipCompressor(zstdEncoderOptions(opts.Level, 1)...))
+	} else if opts.Level > 0 {
+		level := opts.Level
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	return &zipArchiveWriter{zw: zw, method: method}, nil
+}
+
+// zipArchiveWriter writes ZIP archives
+type zipArchiveWriter struct {
+	zw     *zip.Writer
+	method uint16
+}
+
+func (a *zipArchiveWriter) CreateDir(name string, info os.FileInfo) error {
+	_, err := a.zw.Create(name + "/")
+	return err
+}
+
+func (a *zipArchiveWriter) CreateFile(name string, info os.FileInfo) (io.Writer, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = name
+	header.Method = a.method
+	return a.zw.CreateHeader(header)
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+// tarZstWriter writes tar archives compressed as a single zstd stream
+type tarZstWriter struct {
+	enc *zstd.Encoder
+	tw  *tar.Writer
+}
+
+func (a *tarZstWriter) CreateDir(name string, info os.FileInfo) error {
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (a *tarZstWriter) CreateFile(name string, info os.FileInfo) (io.Writer, error) {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.tw, nil
+}
+
+func (a *tarZstWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		a.enc.Close()
+		return err
+	}
+	return a.enc.Close()
+}
+
+// Archive kinds recognized by extract
+const (
+	extractKindZip    = "zip"
+	extractKindTarZst = "tar.zst"
+	extractKindZst    = "zst" // Single zstd-compressed file
+)
+
+// extractKind returns the kind of an archive name and the name without its
+// archive extension, or "" if it cannot be extracted
+func extractKind(name string) (kind, base string) {
+	lower := strings.ToLower(name)
+	for _, ext := range []struct{ suffix, kind string }{
+		{".tar.zst", extractKindTarZst},
+		{".tzst", extractKindTarZst},
+		{".zip", extractKindZip},
+		{".zst", extractKindZst},
+	} {
+		if strings.HasSuffix(lower, ext.suffix) && len(name) > len(ext.suffix) {
+			return ext.kind, name[:len(name)-len(ext.suffix)]
+		}
+	}
+	return "", name
+}
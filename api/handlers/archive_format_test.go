@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+)
+
+// writeArchiveFixture creates a folder with a small file and one larger than
+// the zstd window, returning the folder and its file contents
+func writeArchiveFixture(t *testing.T) (string, map[string][]byte) {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "data")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compressible but not trivially repetitive
+	rng := rand.New(rand.NewSource(1))
+	words := [][]byte{[]byte("alpha "), []byte("beta "), []byte("gamma "), []byte("delta\n")}
+	var large bytes.Buffer
+	for large.Len() < zstdWindowSize+3<<20 {
+		large.Write(words[rng.Intn(len(words))])
+	}
+
+	files := map[string][]byte{
+		"data/small.txt":     []byte("hello archive"),
+		"data/sub/large.txt": large.Bytes(),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(filepath.Dir(src), name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src, files
+}
+
+// buildArchive compresses src with the progress path used by compress-stream
+func buildArchive(t *testing.T, src string, opts ArchiveOptions) string {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "out"+opts.Ext())
+	f, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	aw, err := newArchiveWriter(f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewCompressionContext(context.Background(), 0, 0, func(CompressionProgress) {})
+	if err := (&Handler{}).addDirToArchiveWithProgress(aw, src, "data", ctx); err != nil {
+		t.Fatalf("add dir: %v", err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("close archive: %v", err)
+	}
+	if ctx.ProcessedFiles != 2 {
+		t.Errorf("ProcessedFiles = %d, want 2", ctx.ProcessedFiles)
+	}
+	return out
+}
+
+func extractContext() echo.Context {
+	req := httptest.NewRequest(http.MethodPost, "/api/files/extract", nil)
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+func checkExtracted(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("read %s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: content differs (%d bytes, want %d)", name, len(got), len(want))
+		}
+	}
+}
+
+func TestArchive_TarZstRoundTrip(t *testing.T) {
+	src, files := writeArchiveFixture(t)
+	opts, apiErr := ParseArchiveOptions("tar.zst", "", 0)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	archive := buildArchive(t, src, opts)
+
+	dest := t.TempDir()
+	count, apiErr := (&Handler{}).extractTarZst(extractContext(), archive, "/home/out.tar.zst", dest)
+	if apiErr != nil {
+		t.Fatalf("extract: %v", apiErr)
+	}
+	if count != 2 {
+		t.Errorf("extracted %d files, want 2", count)
+	}
+	checkExtracted(t, dest, files)
+}
+
+func TestArchive_ZipZstdRoundTrip(t *testing.T) {
+	src, files := writeArchiveFixture(t)
+	opts, apiErr := ParseArchiveOptions("zip", "zstd", 19)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	archive := buildArchive(t, src, opts)
+
+	dest := t.TempDir()
+	count, apiErr := (&Handler{}).extractZipArchive(extractContext(), archive, "/home/out.zip", dest)
+	if apiErr != nil {
+		t.Fatalf("extract: %v", apiErr)
+	}
+	if count != 2 {
+		t.Errorf("extracted %d files, want 2", count)
+	}
+	checkExtracted(t, dest, files)
+}
+
+func TestArchive_CompressCancelled(t *testing.T) {
+	src, _ := writeArchiveFixture(t)
+	opts, _ := ParseArchiveOptions("tar.zst", "", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	aw, err := newArchiveWriter(&bytes.Buffer{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compCtx := NewCompressionContext(ctx, 0, 0, func(CompressionProgress) {})
+	err = (&Handler{}).addDirToArchiveWithProgress(aw, src, "data", compCtx)
+	if !errors.Is(err, ErrCompressionCancelled) {
+		t.Errorf("err = %v, want ErrCompressionCancelled", err)
+	}
+}
+
+func TestParseArchiveOptions(t *testing.T) {
+	tests := []struct {
+		format, method string
+		level          int
+		ok             bool
+	}{
+		{"", "", 0, true},
+		{"zip", "zstd", 22, true},
+		{"zip", "deflate", 9, true},
+		{"zip", "deflate", 10, false},
+		{"tar.zst", "", 3, true},
+		{"tar.zst", "deflate", 0, false},
+		{"tar.gz", "", 0, false},
+		{"zip", "zstd", -1, false},
+	}
+	for _, tt := range tests {
+		_, apiErr := ParseArchiveOptions(tt.format, tt.method, tt.level)
+		if (apiErr == nil) != tt.ok {
+			t.Errorf("ParseArchiveOptions(%q, %q, %d) error = %v, want ok=%v", tt.format, tt.method, tt.level, apiErr, tt.ok)
+		}
+	}
+}
+
+func TestExtractKind(t *testing.T) {
+	tests := []struct{ name, kind, base string }{
+		{"photos.tar.zst", extractKindTarZst, "photos"},
+		{"photos.TZST", extractKindTarZst, "photos"},
+		{"docs.zip", extractKindZip, "docs"},
+		{"dump.sql.zst", extractKindZst, "dump.sql"},
+		{"notes.txt", "", "notes.txt"},
+		{".zst", "", ".zst"},
+	}
+	for _, tt := range tests {
+		kind, base := extractKind(tt.name)
+		if kind != tt.kind || base != tt.base {
+			t.Errorf("extractKind(%q) = %q, %q; want %q, %q", tt.name, kind, base, tt.kind, tt.base)
+		}
+	}
+}
+
+func TestArchive_ExtractSingleZst(t *testing.T) {
+	_, files := writeArchiveFixture(t)
+	want := files["data/sub/large.txt"]
+
+	archive := filepath.Join(t.TempDir(), "large.txt.zst")
+	var buf bytes.Buffer
+	enc, err := zstd.NewWriter(&buf, zstdEncoderOptions(0, 1)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write(want)
+	enc.Close()
+	if err := os.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "large.txt")
+	if _, apiErr := (&Handler{}).extractZstFile(extractContext(), archive, "/home/large.txt.zst", dest); apiErr != nil {
+		t.Fatalf("extract: %v", apiErr)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("decompressed content differs (err=%v)", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 )
 
 type AuditHandler struct {
@@ -70,16 +71,46 @@ type AuditLog struct {
 // EventTypes
 const (
 	// File events
-	EventFileView     = "file.view"
-	EventFileDownload = "file.download"
-	EventFileUpload   = "file.upload"
-	EventFileEdit     = "file.edit"
-	EventFileDelete   = "file.delete"
-	EventFileRename   = "file.rename"
-	EventFileCopy     = "file.copy"
-	EventFileMove     = "file.move"
-	EventFolderCreate = "folder.create"
-	EventFolderDelete = "folder.delete"
+	EventFileView         = "file.view"
+	EventFileDownload     = "file.download"
+	EventFileUpload       = "file.upload"
+	EventFileEdit         = "file.edit"
+	EventFileAppend       = "file.append"
+	EventFileOverwrite    = "file.overwrite"
+	EventFileEditConflict = "file.edit_conflict"
+	EventFileDelete       = "file.delete"
+	EventFileRename       = "file.rename"
+	EventFileCopy         = "file.copy"
+	EventFileMove         = "file.move"
+	EventFileLink         = "file.link"
+	EventFolderCreate     = "folder.create"
+	EventFolderDelete     = "folder.delete"
+	EventTrashPurge       = "trash.purge"
+
+	// EventFileDownloadSession records a resumable download session, once
+	// for all its files
+	EventFileDownloadSession = "file.download_session"
+
+	// EventFileBatch records a batch move, copy or delete, once for all its items
+	EventFileBatch = "file.batch"
+
+	// EventTrashRestoreBatch records a bulk trash restore, once for all its items
+	EventTrashRestoreBatch = "trash.restore_batch"
+
+	// EventJobCancel records a background job cancelled by a user or admin
+	EventJobCancel = "job.cancel"
+
+	// EventUploadExpire records unfinished uploads deleted after
+	// upload_expiry_hours without activity, once per expiry run
+	EventUploadExpire = "upload.expire"
+
+	// EventUploadDedup records an upload completed from an identical file the
+	// server already had, without receiving its bytes
+	EventUploadDedup = "upload.dedup"
+
+	// Guest account events, recorded by the daily expiry job
+	EventGuestExpire  = "guest.expire"
+	EventGuestArchive = "guest.archive"
 
 	// SMB events
 	EventSMBCreate = "smb.create"
@@ -91,10 +122,30 @@ const (
 	EventUserLogin  = "user.login"
 	EventUserLogout = "user.logout"
 
+	// EventUserLogoutAll records a user signing out of all sessions
+	EventUserLogoutAll = "user.logout_all"
+
+	// Password reset events: a reset link was mailed, a password was set
+	// through a reset or welcome link
+	EventUserPasswordResetRequest = "user.password_reset_request"
+	EventUserPasswordReset        = "user.password_reset"
+
+	// EventUserRegister records a self-registration, pending approval or not
+	EventUserRegister = "user.register"
+
 	// Share events
 	EventShareCreate = "share.create"
 	EventShareAccess = "share.access"
 	EventShareDelete = "share.delete"
+	EventShareUpdate = "share.update"
+
+	// EventShareDownload records a file downloaded through a share link;
+	// EventShareExpire a share the expiry sweeper deactivated
+	EventShareDownload = "share.download"
+	EventShareExpire   = "share.expire"
+
+	// EventShareResetCounters records zeroed upload share counters
+	EventShareResetCounters = "share.reset_counters"
 
 	// Admin events
 	EventAdminUserCreate     = "admin.user.create"
@@ -105,6 +156,37 @@ const (
 	EventAdminSMBEnable      = "admin.smb.enable"
 	EventAdminSMBDisable     = "admin.smb.disable"
 	EventAdminSettingsUpdate = "admin.settings.update"
+	EventAdminProvision      = "admin.provision"
+	EventAdminActivityCancel = "admin.activity.cancel"
+	EventAdminFilesAdopt     = "admin.files.adopt"
+	EventAdminEncryptionFolder = "admin.encryption.folder"
+	EventAdminEncryptionRewrap = "admin.encryption.rewrap"
+	EventAdminStorageMigrate   = "admin.storage.migrate"
+	EventAdminStorageRecalc    = "admin.storage.recalculate"
+	EventAdminInstanceExport   = "admin.instance.export"
+	EventAdminInstanceImport   = "admin.instance.import"
+	EventAdminUserRoles        = "admin.user.roles"
+	EventAdminGuestCreate      = "admin.guest.create"
+	EventAdminGuestExtend      = "admin.guest.extend"
+	EventAdminGuestConvert     = "admin.guest.convert"
+	EventAdminUserSignOut      = "admin.user.sign_out"
+	EventAdminAuditExport      = "admin.audit.export"
+
+	// Self-registration approval queue
+	EventAdminUserApprove = "admin.user.approve"
+	EventAdminUserReject  = "admin.user.reject"
+
+	// User group events
+	EventAdminGroupCreate       = "admin.group.create"
+	EventAdminGroupUpdate       = "admin.group.update"
+	EventAdminGroupDelete       = "admin.group.delete"
+	EventAdminGroupMemberAdd    = "admin.group.member_add"
+	EventAdminGroupMemberRemove = "admin.group.member_remove"
+
+	// Shared drive snapshot events
+	EventAdminSnapshotCreate  = "admin.snapshot.create"
+	EventAdminSnapshotRestore = "admin.snapshot.restore"
+	EventAdminSnapshotDelete  = "admin.snapshot.delete"
 
 	// Security events
 	EventLoginFailed      = "security.login_failed"
@@ -113,9 +195,26 @@ const (
 	EventAccountUnlocked  = "security.account_unlocked"
 	EventIPLocked         = "security.ip_locked"
 	EventIPUnlocked       = "security.ip_unlocked"
+	EventPermissionDenied = "security.permission_denied"
+
+	// Retention (WORM) events
+	EventRetentionPolicy    = "admin.retention.policy"
+	EventRetentionOverride  = "admin.retention.override"
+	EventRetentionViolation = "security.retention_violation"
+
+	// GDPR erasure events
+	EventAdminUserErase        = "admin.user.erase"
+	EventAdminUserEraseReverse = "admin.user.erase_reverse"
+
+	// Content inspection events
+	EventInspectionPolicy  = "admin.inspection.policy"
+	EventInspectionPattern = "admin.inspection.pattern"
+	EventInspectionRelease = "admin.inspection.release"
+	EventContentFlagged    = "security.content_flagged"
+	EventContentBlocked    = "security.content_blocked"
 )
 
-// LogEvent records an audit event
+// LogEvent records an audit event and passes it on to the alert rules
 func (h *AuditHandler) LogEvent(actorID *string, ipAddr, eventType, targetResource string, details map[string]interface{}) error {
 	detailsJSON, _ := json.Marshal(details)
 
@@ -124,6 +223,15 @@ func (h *AuditHandler) LogEvent(actorID *string, ipAddr, eventType, targetResour
 		VALUES ($1, $2::inet, $3, $4, $5)
 	`, actorID, ipAddr, eventType, targetResource, detailsJSON)
 
+	GetAlertEngine().Observe(AlertEvent{
+		Timestamp:      time.Now(),
+		ActorID:        actorID,
+		IPAddress:      ipAddr,
+		EventType:      eventType,
+		TargetResource: targetResource,
+		Details:        details,
+	})
+
 	return err
 }
 
@@ -149,8 +257,8 @@ func (h *AuditHandler) LogEventFromContext(c echo.Context, eventType, targetReso
 // @Tags		Audit
 // @Accept		json
 // @Produce		json
-// @Param		eventType	query		string	false	"Filter by specific event type"
-// @Param		category	query		string	false	"Filter by category (file, admin, user)"
+// @Param		eventType	query		string	false	"Filter by event type; several comma-separated"
+// @Param		category	query		string	false	"Filter by category (file, admin, user, share)"
 // @Param		resource	query		string	false	"Filter by target resource path"
 // @Param		startDate	query		string	false	"Filter logs from this date (YYYY-MM-DD format)"
 // @Param		endDate		query		string	false	"Filter logs until this date (YYYY-MM-DD format)"
@@ -197,7 +305,7 @@ func (h *AuditHandler) ListAuditLogs(c echo.Context) error {
 
 	// Build query
 	query := `
-		SELECT al.id, al.ts, al.actor_id, u.username, al.ip_addr,
+		SELECT al.id, al.ts, al.actor_id, COALESCE(u.username, al.actor_pseudonym), al.ip_addr,
 		       al.event_type, al.target_resource, al.details
 		FROM audit_logs al
 		LEFT JOIN users u ON al.actor_id = u.id
@@ -219,15 +327,19 @@ func (h *AuditHandler) ListAuditLogs(c echo.Context) error {
 		categoryFilter = " AND al.event_type LIKE 'admin.%'"
 	} else if category == "user" {
 		categoryFilter = " AND (al.event_type LIKE 'user.%' OR al.event_type LIKE 'share.%')"
+	} else if category == "share" {
+		categoryFilter = " AND al.event_type LIKE 'share.%'"
 	}
 	query += categoryFilter
 	countQuery += categoryFilter
 
 	if eventType != "" {
-		query += " AND al.event_type = $" + strconv.Itoa(argCount)
-		countQuery += " AND al.event_type = $" + strconv.Itoa(countArgCount)
-		args = append(args, eventType)
-		countArgs = append(countArgs, eventType)
+		// Several types may be given comma-separated, e.g. share.access,share.download
+		eventTypes := pq.Array(strings.Split(eventType, ","))
+		query += " AND al.event_type = ANY($" + strconv.Itoa(argCount) + ")"
+		countQuery += " AND al.event_type = ANY($" + strconv.Itoa(countArgCount) + ")"
+		args = append(args, eventTypes)
+		countArgs = append(countArgs, eventTypes)
 		argCount++
 		countArgCount++
 	}
@@ -342,7 +454,7 @@ func (h *AuditHandler) GetResourceHistory(c echo.Context) error {
 	}
 
 	rows, err := h.db.Query(`
-		SELECT al.id, al.ts, al.actor_id, u.username, al.ip_addr,
+		SELECT al.id, al.ts, al.actor_id, COALESCE(u.username, al.actor_pseudonym), al.ip_addr,
 		       al.event_type, al.target_resource, al.details
 		FROM audit_logs al
 		LEFT JOIN users u ON al.actor_id = u.id
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	// JobAuditArchive is the job type moving audit log rows past
+	// audit_retention_days into archive files
+	JobAuditArchive = "audit.archive"
+
+	// auditArchiveDir holds the archive files below the data root, one
+	// gzipped NDJSON file per month (YYYY-MM.ndjson.gz)
+	auditArchiveDir = ".audit-archive"
+)
+
+// auditArchiveBatchSize is how many rows are archived and deleted at a time
+var auditArchiveBatchSize = 5000
+
+var auditArchiveNamePattern = regexp.MustCompile(`^\d{4}-\d{2}\.ndjson\.gz$`)
+
+// AuditArchiveResult is the progress and outcome of an archive run
+type AuditArchiveResult struct {
+	Cutoff time.Time `json:"cutoff"`
+	Rows   int       `json:"rows"`
+	Files  []string  `json:"files"`
+}
+
+// AuditArchiveFile is an archive file as listed by the API
+type AuditArchiveFile struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+}
+
+// StartArchive registers the audit log archive job and runs it daily
+func (h *AuditHandler) StartArchive() {
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:        JobAuditArchive,
+		Idempotent:  true,
+		MaxAttempts: 3,
+		Priority:    PacePriorityMaintenance,
+		Run:         h.runAuditArchive,
+	})
+	jobs.Schedule(JobAuditArchive, 24*time.Hour, false)
+}
+
+func (h *AuditHandler) runAuditArchive(run *JobRun) error {
+	days := 0
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		days = sh.GetAuditRetentionDays()
+	}
+	if days <= 0 {
+		return nil
+	}
+
+	result, err := h.archiveAuditLogs(time.Now().AddDate(0, 0, -days), run.Pace, run.SetProgress)
+	if result.Rows > 0 || err != nil {
+		LogInfo("Audit log archived", "rows", result.Rows, "files", result.Files,
+			"cutoff", result.Cutoff, "retentionDays", days, "complete", err == nil)
+	}
+	return err
+}
+
+// archiveAuditLogs moves the rows logged before cutoff, oldest first, into
+// the archive file of their month and deletes them. Each batch is appended
+// to its files as a new gzip member and synced before its rows are deleted,
+// so an interrupted run loses nothing; it may archive a batch twice.
+func (h *AuditHandler) archiveAuditLogs(cutoff time.Time, pace func() error, progress func(interface{})) (AuditArchiveResult, error) {
+	result := AuditArchiveResult{Cutoff: cutoff, Files: []string{}}
+	dir := filepath.Join(h.baseStoragePath, auditArchiveDir)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return result, err
+	}
+
+	for {
+		if err := pace(); err != nil {
+			return result, err
+		}
+		batch, err := h.auditArchiveBatch(cutoff)
+		if err != nil || len(batch) == 0 {
+			return result, err
+		}
+
+		months := map[string][]auditExportRow{}
+		ids := make([]int64, 0, len(batch))
+		for _, r := range batch {
+			month := r.Timestamp.UTC().Format("2006-01")
+			months[month] = append(months[month], r)
+			ids = append(ids, r.ID)
+		}
+		for month, rows := range months {
+			name := month + ".ndjson.gz"
+			if err := appendAuditArchive(filepath.Join(dir, name), rows); err != nil {
+				return result, err
+			}
+			if i := sort.SearchStrings(result.Files, name); i == len(result.Files) || result.Files[i] != name {
+				result.Files = append(result.Files, name)
+				sort.Strings(result.Files)
+			}
+		}
+
+		if _, err := h.db.Exec(`DELETE FROM audit_logs WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+			return result, err
+		}
+		result.Rows += len(batch)
+		progress(result)
+		if len(batch) < auditArchiveBatchSize {
+			return result, nil
+		}
+	}
+}
+
+// auditArchiveBatch reads the oldest rows logged before cutoff
+func (h *AuditHandler) auditArchiveBatch(cutoff time.Time) ([]auditExportRow, error) {
+	rows, err := h.db.Query(`
+		SELECT al.id, al.ts, COALESCE(al.actor_id::text, ''), COALESCE(u.username, al.actor_pseudonym, ''),
+		       COALESCE(host(al.ip_addr), ''), al.event_type, COALESCE(al.target_resource, ''), al.details
+		FROM audit_logs al
+		LEFT JOIN users u ON al.actor_id = u.id
+		WHERE al.ts < $1
+		ORDER BY al.ts, al.id
+		LIMIT $2
+	`, cutoff, auditArchiveBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditExportRows(rows)
+}
+
+// appendAuditArchive appends rows to an archive file as one gzip member.
+// gzip readers, zcat included, read concatenated members as one stream.
+func appendAuditArchive(path string, rows []auditExportRow) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	for _, r := range rows {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ListAuditArchives lists the audit log archive files
+// @Summary		List audit log archives
+// @Description	Lists the monthly archive files (YYYY-MM.ndjson.gz) holding audit log entries older than audit_retention_days
+// @Tags		Audit
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Archive files and retention"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/audit/archives [get]
+func (h *AuditHandler) ListAuditArchives(c echo.Context) error {
+	entries, err := os.ReadDir(filepath.Join(h.baseStoragePath, auditArchiveDir))
+	if err != nil && !os.IsNotExist(err) {
+		return RespondError(c, ErrOperationFailed("list audit archives", err))
+	}
+
+	archives := []AuditArchiveFile{}
+	for _, entry := range entries {
+		if !auditArchiveNamePattern.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		archives = append(archives, AuditArchiveFile{Name: entry.Name(), Size: info.Size(), ModifiedAt: info.ModTime()})
+	}
+
+	days := 0
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		days = sh.GetAuditRetentionDays()
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"archives":      archives,
+		"retentionDays": days,
+	})
+}
+
+// DownloadAuditArchive serves an audit log archive file
+// @Summary		Download audit log archive
+// @Description	Downloads a monthly archive file: gzipped NDJSON, one audit log entry per line in the format of the JSON export
+// @Tags		Audit
+// @Produce		application/gzip
+// @Param		name	path	string	true	"Archive file name (YYYY-MM.ndjson.gz)"
+// @Success		200		{file}		binary	"Archive file"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid name"
+// @Failure		404		{object}	docs.ErrorResponse	"Archive not found"
+// @Security	BearerAuth
+// @Router		/audit/archives/{name} [get]
+func (h *AuditHandler) DownloadAuditArchive(c echo.Context) error {
+	name := c.Param("name")
+	if !auditArchiveNamePattern.MatchString(name) {
+		return RespondError(c, ErrBadRequest("Invalid archive name"))
+	}
+	path := filepath.Join(h.baseStoragePath, auditArchiveDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return RespondError(c, ErrNotFound("Archive not found"))
+	}
+
+	h.LogEventFromContext(c, EventAdminAuditExport, "audit_logs", map[string]interface{}{
+		"archive": strings.TrimSuffix(name, ".ndjson.gz"),
+	})
+	c.Response().Header().Set(echo.HeaderContentType, "application/gzip")
+	setContentDisposition(c, "filehatch-audit-"+name)
+	return c.File(path)
+}
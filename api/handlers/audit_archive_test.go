@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func readAuditArchive(t *testing.T, path string) []auditExportRow {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []auditExportRow
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var r auditExportRow
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("archive line is not JSON: %v", err)
+		}
+		rows = append(rows, r)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestArchiveAuditLogs_MovesRowsIntoMonthlyFiles(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	root := t.TempDir()
+	h := &AuditHandler{db: tc.DB, baseStoragePath: root}
+	previous := auditArchiveBatchSize
+	auditArchiveBatchSize = 2
+	defer func() { auditArchiveBatchSize = previous }()
+
+	cutoff := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	aug := time.Date(2026, 8, 31, 23, 0, 0, 0, time.UTC)
+	jul := time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC)
+
+	tc.Mock.ExpectQuery("FROM audit_logs al").
+		WithArgs(cutoff, 2).
+		WillReturnRows(auditExportRows().
+			AddRow(1, jul, "u-1", "alice", "203.0.113.7", "user.login", "alice", []byte(`{"method":"password"}`)).
+			AddRow(2, aug, "", "", "", "share.expire", "/users/alice/report.pdf", nil))
+	tc.Mock.ExpectExec("DELETE FROM audit_logs").
+		WithArgs(pq.Array([]int64{1, 2})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	tc.Mock.ExpectQuery("FROM audit_logs al").
+		WithArgs(cutoff, 2).
+		WillReturnRows(auditExportRows().
+			AddRow(3, aug, "u-2", "bob", "198.51.100.1", "share.download", "/users/alice/report.pdf", []byte(`{"size":10}`)))
+	tc.Mock.ExpectExec("DELETE FROM audit_logs").
+		WithArgs(pq.Array([]int64{3})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	progressed := 0
+	result, err := h.archiveAuditLogs(cutoff, func() error { return nil }, func(interface{}) { progressed++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rows != 3 || progressed != 2 {
+		t.Errorf("rows %d, progress %d", result.Rows, progressed)
+	}
+	if len(result.Files) != 2 || result.Files[0] != "2026-07.ndjson.gz" || result.Files[1] != "2026-08.ndjson.gz" {
+		t.Errorf("files %v", result.Files)
+	}
+
+	dir := filepath.Join(root, auditArchiveDir)
+	if rows := readAuditArchive(t, filepath.Join(dir, "2026-07.ndjson.gz")); len(rows) != 1 || rows[0].ActorUsername != "alice" {
+		t.Errorf("2026-07 rows %+v", rows)
+	}
+	// The August file holds two gzip members, one per batch
+	rows := readAuditArchive(t, filepath.Join(dir, "2026-08.ndjson.gz"))
+	if len(rows) != 2 || rows[0].ID != 2 || rows[1].ID != 3 || string(rows[1].Details) != `{"size":10}` {
+		t.Errorf("2026-08 rows %+v", rows)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestArchiveAuditLogs_KeepsRowsWhenWriteFails(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	root := t.TempDir()
+	h := &AuditHandler{db: tc.DB, baseStoragePath: root}
+
+	// A directory in place of the month file makes the append fail
+	if err := os.MkdirAll(filepath.Join(root, auditArchiveDir, "2026-07.ndjson.gz"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	tc.Mock.ExpectQuery("FROM audit_logs al").
+		WillReturnRows(auditExportRows().
+			AddRow(1, time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC), "u-1", "alice", "", "user.login", "alice", nil))
+
+	if _, err := h.archiveAuditLogs(time.Now(), func() error { return nil }, func(interface{}) {}); err == nil {
+		t.Fatal("expected the archive run to fail")
+	}
+	// No DELETE was expected
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunAuditArchive_DisabledByDefault(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{"audit_retention_days": "0"})
+	h := &AuditHandler{db: tc.DB, baseStoragePath: t.TempDir()}
+
+	if err := h.runAuditArchive(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDownloadAuditArchive(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	root := t.TempDir()
+	h := &AuditHandler{db: tc.DB, baseStoragePath: root}
+	if err := os.MkdirAll(filepath.Join(root, auditArchiveDir), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendAuditArchive(filepath.Join(root, auditArchiveDir, "2026-07.ndjson.gz"),
+		[]auditExportRow{{ID: 1, EventType: "user.login", TargetResource: "alice"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	download := func(name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/audit/archives/"+name, nil)
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(req, rec)
+		c.Set("user", &JWTClaims{UserID: "admin-1", Username: "admin", IsAdmin: true})
+		c.SetParamNames("name")
+		c.SetParamValues(name)
+		if err := h.DownloadAuditArchive(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	if rec := download("..%2Fsecret.gz"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid name: status %d", rec.Code)
+	}
+	if rec := download("2026-06.ndjson.gz"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing archive: status %d", rec.Code)
+	}
+
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("admin-1", sqlmock.AnyArg(), EventAdminAuditExport, "audit_logs", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	rec := download("2026-07.ndjson.gz")
+	AssertStatus(t, rec, http.StatusOK)
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var row auditExportRow
+	if err := json.NewDecoder(gz).Decode(&row); err != nil || row.EventType != "user.login" {
+		t.Errorf("archive row %+v, %v", row, err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
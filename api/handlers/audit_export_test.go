@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func exportAuditLogs(t *testing.T, tc *TestContext, h *AuditHandler, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/audit/export?"+query, nil)
+	rec := httptest.NewRecorder()
+	c := tc.Echo.NewContext(req, rec)
+	c.Set("user", &JWTClaims{UserID: "admin-1", Username: "admin", IsAdmin: true})
+	if err := h.ExportAuditLogs(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func auditExportRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "ts", "actor_id", "actor_username", "ip_addr", "event_type", "target_resource", "details"})
+}
+
+func TestExportAuditLogs_StreamsCSVInBatches(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &AuditHandler{db: tc.DB}
+	previous := auditExportBatchSize
+	auditExportBatchSize = 2
+	defer func() { auditExportBatchSize = previous }()
+
+	ts := time.Date(2026, 9, 3, 10, 0, 0, 0, time.UTC)
+	from := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	// Each batch continues after the last row of the one before
+	tc.Mock.ExpectQuery("FROM audit_logs al").
+		WithArgs(from, to, from, int64(0), 2).
+		WillReturnRows(auditExportRows().
+			AddRow(1, ts, "u-1", "alice", "203.0.113.7", "share.create", "/users/alice/finance, \"Q3\".xlsx", []byte(`{"shareType":"download","hasPassword":true}`)).
+			AddRow(2, ts, "", "", "", "share.expire", "/users/alice/finance, \"Q3\".xlsx", nil))
+	tc.Mock.ExpectQuery("FROM audit_logs al").
+		WithArgs(from, to, ts, int64(2), 2).
+		WillReturnRows(auditExportRows().
+			AddRow(3, ts.Add(time.Hour), "u-2", "bob", "198.51.100.1", "share.download", "/users/alice/finance, \"Q3\".xlsx", []byte(`{"size":10}`)))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("admin-1", sqlmock.AnyArg(), EventAdminAuditExport, "audit_logs", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := exportAuditLogs(t, tc, h, "from=2026-09-01&to=2026-09-30&format=csv")
+	AssertStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Content-Disposition"); !strings.Contains(got, `filename="filehatch-audit-2026-09-01_2026-09-30.csv"`) {
+		t.Errorf("Content-Disposition %q", got)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("export is not valid CSV: %v", err)
+	}
+	if len(records) != 4 || strings.Join(records[0], ",") != strings.Join(auditExportColumns, ",") {
+		t.Fatalf("records %q", records)
+	}
+	if records[1][3] != "alice" || records[1][6] != `/users/alice/finance, "Q3".xlsx` || records[1][7] != `{"shareType":"download","hasPassword":true}` {
+		t.Errorf("first row %q", records[1])
+	}
+	if records[3][0] != "3" || records[3][3] != "bob" {
+		t.Errorf("last row %q", records[3])
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportAuditLogs_JSON(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &AuditHandler{db: tc.DB}
+
+	ts := time.Date(2026, 9, 3, 10, 0, 0, 0, time.UTC)
+	tc.Mock.ExpectQuery("FROM audit_logs al").
+		WillReturnRows(auditExportRows().
+			AddRow(7, ts, "u-1", "alice", "203.0.113.7", "user.login", "alice", []byte(`{"method":"password"}`)))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := exportAuditLogs(t, tc, h, "from=2026-09-01T00:00:00Z&format=json")
+	AssertStatus(t, rec, http.StatusOK)
+	var rows []auditExportRow
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("export is not valid JSON: %v\n%s", err, rec.Body.String())
+	}
+	if len(rows) != 1 || rows[0].ActorUsername != "alice" || string(rows[0].Details) != `{"method":"password"}` {
+		t.Errorf("rows %+v", rows)
+	}
+}
+
+func TestExportAuditLogs_InvalidRequests(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &AuditHandler{db: tc.DB}
+
+	for _, query := range []string{"format=xml", "from=yesterday", "from=2026-09-30&to=2026-09-01"} {
+		if rec := exportAuditLogs(t, tc, h, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", query, rec.Code)
+		}
+	}
+}
@@ -58,17 +58,30 @@ func GenerateJWT(userID, username string, isAdmin bool) (string, error) {
 
 // GenerateJWTWithExpiration generates a JWT token with custom expiration duration
 func GenerateJWTWithExpiration(userID, username string, isAdmin, rememberMe bool, expiration time.Duration) (string, error) {
+	return generateJWT(userID, username, isAdmin, rememberMe, "", "", expiration)
+}
+
+func generateJWT(userID, username string, isAdmin, rememberMe bool, scope, sessionID string, expiration time.Duration) (string, error) {
 	claims := &JWTClaims{
 		UserID:     userID,
 		Username:   username,
 		IsAdmin:    isAdmin,
 		RememberMe: rememberMe,
+		Scope:      scope,
+		SessionID:  sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "filehatch",
 		},
 	}
+	if perms, err := GetAdminRoles().Reload(userID); err != nil {
+		log.Printf("[Roles] Failed to load permissions of %s: %v", userID, err)
+	} else if perms != nil {
+		claims.IsAdmin = perms.Superadmin
+		claims.Permissions = perms.Permissions
+		claims.RoleVersion = perms.Version
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(sharedJWTSecret)
@@ -96,6 +109,12 @@ type User struct {
 	StorageUsed    int64     `json:"storageUsed"`
 	CreatedAt      time.Time `json:"createdAt"`
 	UpdatedAt      time.Time `json:"updatedAt"`
+	// Guest accounts, see Guests; GuestStatus is active, expired or archived
+	IsGuest        bool       `json:"isGuest,omitempty"`
+	GuestExpiresAt *time.Time `json:"guestExpiresAt,omitempty"`
+	GuestStatus    string     `json:"guestStatus,omitempty"`
+	// TwoFactorPolicy is only filled in for the admin user list
+	TwoFactorPolicy *TwoFactorCompliance `json:"twoFactorPolicy,omitempty"`
 }
 
 // JWTClaims represents JWT claims
@@ -104,6 +123,12 @@ type JWTClaims struct {
 	Username   string `json:"username"`
 	IsAdmin    bool   `json:"isAdmin"`
 	RememberMe bool   `json:"rememberMe,omitempty"`
+	Scope      string `json:"scope,omitempty"` // Empty for full access, see TokenScope2FASetup
+	SessionID  string `json:"sid,omitempty"`   // Login session, see Sessions
+	// Admin permissions granted by the user's roles, see AdminRoles.
+	// RoleVersion identifies the set; a stale token is refreshed per request.
+	Permissions []string `json:"permissions,omitempty"`
+	RoleVersion string   `json:"roleVersion,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -127,15 +152,38 @@ type LoginResponse struct {
 	User          User   `json:"user,omitempty"`
 	Requires2FA   bool   `json:"requires2fa,omitempty"`
 	RequiresSetup bool   `json:"requiresSetup,omitempty"` // For initial admin setup
-	UserID        string `json:"userId,omitempty"`        // Sent when 2FA or setup is required
+	UserID        string `json:"userId,omitempty"`        // Sent when setup is required
+	PendingToken  string `json:"pendingToken,omitempty"`  // Sent when 2FA is required, for /auth/2fa/verify
+	// 2FA enforcement: Requires2FASetup means the token only allows 2FA setup;
+	// TwoFactorGraceUntil nags users who still have to set up 2FA
+	Requires2FASetup    bool       `json:"requires2faSetup,omitempty"`
+	TwoFactorGraceUntil *time.Time `json:"twoFactorGraceUntil,omitempty"`
 }
 
-// Register creates a new user account
+// Register creates an account through self-registration
+// @Summary		Register
+// @Description	Creates an account when registration_enabled is set. With registration_email_domains an email address of one of those domains is required. With registration_require_approval (default) the account stays inactive until an admin approves it (pending=true); otherwise the user can sign in at once.
+// @Tags		Auth
+// @Accept		json
+// @Produce		json
+// @Param		request	body		RegisterRequest	true	"Account"
+// @Success		201		{object}	map[string]interface{}	"Registered"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid request or email domain not allowed"
+// @Failure		403		{object}	docs.ErrorResponse	"Registration disabled"
+// @Failure		409		{object}	docs.ErrorResponse	"Username taken"
+// @Router		/auth/register [post]
 func (h *AuthHandler) Register(c echo.Context) error {
+	policy := LoadRegistrationPolicy()
+	if !policy.Enabled {
+		return RespondError(c, ErrForbidden("Registration is disabled"))
+	}
+
 	var req RegisterRequest
 	if err := c.Bind(&req); err != nil {
 		return RespondError(c, ErrBadRequest("Invalid request"))
 	}
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
 
 	// Validate input
 	if len(req.Username) < 3 || len(req.Username) > 50 {
@@ -151,6 +199,9 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	if err := ValidateEmail(req.Email); err != nil {
 		return RespondError(c, ErrBadRequest(err.Error()))
 	}
+	if !policy.EmailAllowed(req.Email) {
+		return RespondError(c, ErrBadRequest("Registration needs an email address of: "+strings.Join(policy.EmailDomains, ", ")))
+	}
 
 	// Check if username already exists
 	var exists bool
@@ -168,23 +219,25 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		return RespondError(c, ErrInternal("Failed to hash password"))
 	}
 
-	// Create user
+	// Create user; the home folder is created on first login
+	pending := policy.RequiresApproval
 	var userID string
 	err = h.db.QueryRow(`
-		INSERT INTO users (username, email, password_hash, is_active)
-		VALUES ($1, $2, $3, true)
+		INSERT INTO users (username, email, password_hash, is_active, registration_pending)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id
-	`, req.Username, req.Email, string(passwordHash)).Scan(&userID)
+	`, req.Username, req.Email, string(passwordHash), !pending, pending).Scan(&userID)
 
 	if err != nil {
 		return RespondError(c, ErrInternal("Failed to create user"))
 	}
 
-	return c.JSON(http.StatusCreated, map[string]interface{}{
-		"success": true,
-		"id":      userID,
-		"message": "User registered successfully",
+	_ = h.auditHandler.LogEvent(&userID, c.RealIP(), EventUserRegister, req.Username, map[string]interface{}{
+		"email":   req.Email,
+		"pending": pending,
 	})
+
+	return registrationResponse(c, userID, pending)
 }
 
 // Login authenticates a user and returns a JWT token
@@ -199,6 +252,7 @@ func (h *AuthHandler) Register(c echo.Context) error {
 // @Failure 400 {object} map[string]string "Invalid request"
 // @Failure 401 {object} map[string]string "Invalid credentials"
 // @Failure 403 {object} map[string]string "Account disabled"
+// @Failure 429 {object} map[string]interface{} "Too many failed attempts; retry after Retry-After seconds"
 // @Router /auth/login [post]
 
 func (h *AuthHandler) Login(c echo.Context) error {
@@ -217,17 +271,9 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	// Check brute force protection
 	guard := GetBruteForceGuard()
 	if guard != nil {
-		allowed, reason, remaining := guard.CheckAndRecordAttempt(ctx, ip, req.Username)
+		allowed, reason, remaining, retryAfter := guard.CheckAndRecordAttempt(ctx, ip, req.Username)
 		if !allowed {
-			// Log blocked attempt
-			_ = h.auditHandler.LogEvent(nil, ip, EventLoginBlocked, req.Username, map[string]interface{}{
-				"username": req.Username,
-				"reason":   reason,
-			})
-			return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
-				"error":      reason,
-				"retryAfter": guard.config.LockDuration.Seconds(),
-			})
+			return respondLoginBlocked(c, h.auditHandler, req.Username, reason, retryAfter)
 		}
 		// Set remaining attempts header
 		c.Response().Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
@@ -266,8 +312,14 @@ func (h *AuthHandler) Login(c echo.Context) error {
 
 	// Check if user is active
 	if !user.IsActive {
+		if h.registrationPending(user.ID) {
+			return RespondError(c, ErrForbidden("Account is awaiting admin approval"))
+		}
 		return RespondError(c, ErrForbidden("Account is disabled"))
 	}
+	if GetGuests().Expired(user.ID) {
+		return RespondError(c, ErrForbidden("Guest account has expired"))
+	}
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
@@ -295,6 +347,14 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	user.Has2FA = totpEnabled.Valid && totpEnabled.Bool
 	user.SetupCompleted = !setupCompleted.Valid || setupCompleted.Bool
 
+	// Self-registered users get their home folder on first login, like SSO
+	// users; guests have none
+	if !GetGuests().IsGuest(user.ID) {
+		if err := h.ensureUserHomeDir(user.Username); err != nil {
+			log.Printf("WARNING: Failed to create home directory for user %s: %v", user.Username, err)
+		}
+	}
+
 	// Check if admin needs initial setup (before 2FA check)
 	if user.IsAdmin && !user.SetupCompleted {
 		// Generate temporary token for setup API
@@ -314,21 +374,32 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	// Check if 2FA is enabled
 	if user.Has2FA {
 		// Return requires_2fa response - user needs to verify OTP
+		pendingToken, err := generatePending2FAToken(user.ID, user.Username, user.IsAdmin)
+		if err != nil {
+			return RespondError(c, ErrInternal("Failed to generate token"))
+		}
 		return c.JSON(http.StatusOK, LoginResponse{
-			Requires2FA: true,
-			UserID:      user.ID,
+			Requires2FA:  true,
+			PendingToken: pendingToken,
 		})
 	}
 
-	// Determine token expiration based on rememberMe
-	// Default: 1 day, RememberMe: 30 days
-	expiration := 24 * time.Hour
-	if req.RememberMe {
-		expiration = 30 * 24 * time.Hour
+	// Enforce the 2FA policy: after the grace period only 2FA setup is allowed
+	compliance := LoadTwoFactorPolicy().Evaluate(user.IsAdmin, user.Has2FA, user.CreatedAt, time.Now())
+	if compliance.Overdue {
+		token, err := GenerateScopedJWT(user.ID, user.Username, user.IsAdmin, TokenScope2FASetup)
+		if err != nil {
+			return RespondError(c, ErrInternal("Failed to generate token"))
+		}
+		return c.JSON(http.StatusOK, LoginResponse{
+			Token:            token,
+			User:             user,
+			Requires2FASetup: true,
+		})
 	}
 
-	// Generate JWT token with appropriate expiration
-	token, err := h.generateTokenWithExpiration(user.ID, user.Username, user.IsAdmin, req.RememberMe, expiration)
+	// Start a session: short-lived and sliding without rememberMe, long-lived with it
+	token, err := issueSessionToken(c, user.ID, user.Username, user.IsAdmin, req.RememberMe)
 	if err != nil {
 		return RespondError(c, ErrInternal("Failed to generate token"))
 	}
@@ -346,14 +417,21 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	})
 
 	return c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  user,
+		Token:               token,
+		User:                user,
+		TwoFactorGraceUntil: compliance.GraceUntil,
 	})
 }
 
 // RefreshToken refreshes the JWT token if it's still valid
-// The new token preserves the original session type (remember me or not)
+// The new token preserves the original session type (remember me or not);
+// without remember me it never outlives session_max_hours after sign-in.
+// Browsers holding a refresh cookie exchange it for an access token instead;
+// it works once their last token has expired.
 func (h *AuthHandler) RefreshToken(c echo.Context) error {
+	if cookie, err := c.Cookie(refreshCookieName); err == nil && cookie.Value != "" && GetSessions() != nil {
+		return h.refreshFromCookie(c, cookie.Value)
+	}
 	claims, ok := c.Get("user").(*JWTClaims)
 	if !ok || claims == nil {
 		return RespondError(c, ErrUnauthorized("Invalid token"))
@@ -368,19 +446,76 @@ func (h *AuthHandler) RefreshToken(c echo.Context) error {
 	if !isActive {
 		return RespondError(c, ErrForbidden("Account is disabled"))
 	}
+	if !GetGuests().TokenAllowed(claims) {
+		return RespondError(c, ErrForbidden("Guest account has expired"))
+	}
+
+	// Tokens from before sessions were tracked start one; others slide their
+	// session forward, temporary sessions up to their absolute end
+	var token string
+	if claims.SessionID == "" || GetSessions() == nil {
+		token, err = issueSessionToken(c, claims.UserID, claims.Username, claims.IsAdmin, claims.RememberMe)
+	} else {
+		var expiration time.Duration
+		expiration, err = GetSessions().Extend(claims.SessionID)
+		if err == errSessionEnded {
+			return respondSessionEnded(c)
+		}
+		if err == nil {
+			token, err = generateJWT(claims.UserID, claims.Username, claims.IsAdmin, claims.RememberMe, "", claims.SessionID, expiration)
+		}
+	}
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to generate token"))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token": token,
+	})
+}
+
+// refreshFromCookie issues an access token for the session of a refresh
+// cookie. Remembered sessions slide forward, and so does their cookie.
+func (h *AuthHandler) refreshFromCookie(c echo.Context, refreshToken string) error {
+	sessions := GetSessions()
+	sessionID, userID, rememberMe, err := sessions.Redeem(refreshToken)
+	if err == errSessionEnded {
+		clearRefreshCookie(c)
+		return respondSessionEnded(c)
+	}
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
 
-	// Determine expiration based on rememberMe flag stored in claims
-	// Default: 1 day, RememberMe: 30 days
-	expiration := 24 * time.Hour
-	if claims.RememberMe {
-		expiration = 30 * 24 * time.Hour
+	var username string
+	var isAdmin, isActive bool
+	err = h.db.QueryRow("SELECT username, is_admin, is_active FROM users WHERE id = $1", userID).Scan(&username, &isAdmin, &isActive)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	if !isActive {
+		return RespondError(c, ErrForbidden("Account is disabled"))
+	}
+	if !GetGuests().TokenAllowed(&JWTClaims{UserID: userID, RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(time.Now())}}) {
+		return RespondError(c, ErrForbidden("Guest account has expired"))
 	}
 
-	// Generate new token with same session type
-	token, err := h.generateTokenWithExpiration(claims.UserID, claims.Username, claims.IsAdmin, claims.RememberMe, expiration)
+	lifetime, err := sessions.Extend(sessionID)
+	if err == errSessionEnded {
+		clearRefreshCookie(c)
+		return respondSessionEnded(c)
+	}
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to generate token"))
+	}
+	policy := LoadSessionPolicy()
+	token, err := generateJWT(userID, username, isAdmin, rememberMe, "", sessionID, min(policy.Access, lifetime))
 	if err != nil {
 		return RespondError(c, ErrInternal("Failed to generate token"))
 	}
+	if rememberMe {
+		setRefreshCookie(c, refreshToken, true, time.Now().Add(policy.Remember))
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"token": token,
@@ -433,9 +568,21 @@ func (h *AuthHandler) GetProfile(c echo.Context) error {
 	user.HasSMB = smbHash.Valid && smbHash.String != ""
 	user.Has2FA = totpEnabled.Valid && totpEnabled.Bool
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"user": user,
-	})
+	response := map[string]interface{}{
+		"user":            user,
+		"twoFactorPolicy": LoadTwoFactorPolicy().Evaluate(user.IsAdmin, user.Has2FA, user.CreatedAt, time.Now()),
+	}
+	// The session of this request; all sessions are listed by /auth/sessions
+	if sessions := GetSessions(); sessions != nil && claims.SessionID != "" {
+		if list, err := sessions.List(claims.UserID, claims.SessionID); err == nil {
+			for _, session := range list {
+				if session.Current {
+					response["session"] = session
+				}
+			}
+		}
+	}
+	return c.JSON(http.StatusOK, response)
 }
 
 // UpdateProfileRequest represents profile update request
@@ -695,6 +842,27 @@ func (h *AuthHandler) JWTMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			})
 		}
 
+		// Limited tokens (2FA setup) only reach their own endpoints
+		if claims.IsLimited() && !allowedForLimitedToken(claims, c.Request().Method, c.Path()) {
+			return respondLimitedToken(c)
+		}
+
+		// Guest tokens stop working at the expiry and when revoked
+		if !GetGuests().TokenAllowed(claims) {
+			return respondGuestExpired(c)
+		}
+
+		// Ended sessions and tokens from before a sign-out everywhere are refused
+		if !GetSessions().TokenAllowed(claims) {
+			return respondSessionEnded(c)
+		}
+		GetSessions().Touch(claims.SessionID)
+
+		// Apply role changes made since the token was issued
+		if GetAdminRoles().refreshClaims(claims) {
+			c.Response().Header().Set(permissionsChangedHeader, "true")
+		}
+
 		// Set user in context
 		c.Set("user", claims)
 
@@ -731,7 +899,8 @@ func (h *AuthHandler) OptionalJWTMiddleware(next echo.HandlerFunc) echo.HandlerF
 		})
 
 		if err == nil && token.Valid {
-			if claims, ok := token.Claims.(*JWTClaims); ok {
+			if claims, ok := token.Claims.(*JWTClaims); ok && !claims.IsLimited() && GetGuests().TokenAllowed(claims) && GetSessions().TokenAllowed(claims) {
+				GetSessions().Touch(claims.SessionID)
 				c.Set("user", claims)
 			}
 		}
@@ -740,19 +909,6 @@ func (h *AuthHandler) OptionalJWTMiddleware(next echo.HandlerFunc) echo.HandlerF
 	}
 }
 
-// AdminMiddleware ensures the user is an admin
-func (h *AuthHandler) AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		claims := c.Get("user").(*JWTClaims)
-		if !claims.IsAdmin {
-			return c.JSON(http.StatusForbidden, map[string]string{
-				"error": "Admin access required",
-			})
-		}
-		return next(c)
-	}
-}
-
 // InitialSetupRequest represents the initial admin setup request
 type InitialSetupRequest struct {
 	NewUsername string `json:"newUsername"`
@@ -833,9 +989,8 @@ func (h *AuthHandler) InitialSetup(c echo.Context) error {
 		return RespondError(c, ErrInternal("Failed to update user"))
 	}
 
-	// Generate new token with updated username
-	expiration := 24 * time.Hour
-	token, err := h.generateTokenWithExpiration(claims.UserID, req.NewUsername, claims.IsAdmin, false, expiration)
+	// Start a session with the updated username
+	token, err := issueSessionToken(c, claims.UserID, req.NewUsername, claims.IsAdmin, false)
 	if err != nil {
 		return RespondError(c, ErrInternal("Failed to generate token"))
 	}
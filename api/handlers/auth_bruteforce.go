@@ -4,9 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,12 +18,13 @@ import (
 
 // BruteForceConfig holds brute force protection settings
 type BruteForceConfig struct {
-	Enabled        bool          // 활성화 여부
-	MaxAttempts    int           // 사용자별 최대 시도 횟수 (기본: 5)
-	WindowDuration time.Duration // 추적 윈도우 (기본: 5분)
-	LockDuration   time.Duration // 잠금 시간 (기본: 15분)
-	IPMaxAttempts  int           // IP별 최대 시도 (기본: 20)
-	IPLockDuration time.Duration // IP 잠금 시간 (기본: 30분)
+	Enabled         bool          // 활성화 여부
+	MaxAttempts     int           // 사용자별 최대 시도 횟수 (기본: 5)
+	WindowDuration  time.Duration // 추적 윈도우 (기본: 5분)
+	LockDuration    time.Duration // 첫 잠금 시간 (기본: 15분), 잠길 때마다 두 배
+	MaxLockDuration time.Duration // 최대 잠금 시간 (기본: 24시간)
+	IPMaxAttempts   int           // IP별 최대 시도 (기본: 20)
+	IPLockDuration  time.Duration // IP 잠금 시간 (기본: 30분)
 }
 
 // LocalCacheEntry represents a cached attempt count
@@ -35,6 +38,7 @@ type BruteForceGuard struct {
 	redis        *redis.Client
 	db           *sql.DB
 	config       BruteForceConfig
+	configMu     sync.RWMutex
 	localCache   sync.Map // Valkey 장애 시 폴백
 	audit        *AuditHandler
 	keyPrefix    string
@@ -55,12 +59,13 @@ func GetBruteForceGuard() *BruteForceGuard {
 // DefaultBruteForceConfig returns the default configuration
 func DefaultBruteForceConfig() BruteForceConfig {
 	return BruteForceConfig{
-		Enabled:        true,
-		MaxAttempts:    5,
-		WindowDuration: 5 * time.Minute,
-		LockDuration:   15 * time.Minute,
-		IPMaxAttempts:  20,
-		IPLockDuration: 30 * time.Minute,
+		Enabled:         true,
+		MaxAttempts:     5,
+		WindowDuration:  5 * time.Minute,
+		LockDuration:    15 * time.Minute,
+		MaxLockDuration: 24 * time.Hour,
+		IPMaxAttempts:   20,
+		IPLockDuration:  30 * time.Minute,
 	}
 }
 
@@ -148,6 +153,10 @@ func loadBruteForceConfigFromDB(db *sql.DB) BruteForceConfig {
 			if v, err := strconv.Atoi(value); err == nil {
 				config.LockDuration = time.Duration(v) * time.Minute
 			}
+		case "bruteforce_max_lock_minutes":
+			if v, err := strconv.Atoi(value); err == nil {
+				config.MaxLockDuration = time.Duration(v) * time.Minute
+			}
 		case "bruteforce_ip_max_attempts":
 			if v, err := strconv.Atoi(value); err == nil {
 				config.IPMaxAttempts = v
@@ -162,6 +171,30 @@ func loadBruteForceConfigFromDB(db *sql.DB) BruteForceConfig {
 	return config
 }
 
+// isBruteForceSetting reports whether a settings key configures the guard
+func isBruteForceSetting(key string) bool {
+	return strings.HasPrefix(key, "bruteforce_")
+}
+
+// cfg returns the current configuration
+func (g *BruteForceGuard) cfg() BruteForceConfig {
+	g.configMu.RLock()
+	defer g.configMu.RUnlock()
+	return g.config
+}
+
+// lockDurationAfter returns how long an account is locked once it has
+// failed failed times since its last successful login: LockDuration for the
+// first lock, doubled for each lock after it, up to MaxLockDuration
+func (c BruteForceConfig) lockDurationAfter(failed int) time.Duration {
+	d := c.LockDuration
+	limit := max(c.MaxLockDuration, c.LockDuration)
+	for n := failed/max(c.MaxAttempts, 1) - 1; n > 0 && d < limit; n-- {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
 // cleanupLocalCache periodically cleans up expired entries
 func (g *BruteForceGuard) cleanupLocalCache() {
 	ticker := time.NewTicker(1 * time.Minute)
@@ -181,59 +214,61 @@ func (g *BruteForceGuard) cleanupLocalCache() {
 }
 
 // CheckAndRecordAttempt checks if login is allowed and records the attempt
-// Returns: (allowed bool, reason string, remainingAttempts int)
-func (g *BruteForceGuard) CheckAndRecordAttempt(ctx context.Context, ip, username string) (bool, string, int) {
-	if !g.config.Enabled {
-		return true, "", g.config.MaxAttempts
+// Returns: (allowed bool, reason string, remainingAttempts int, retryAfter time.Duration)
+func (g *BruteForceGuard) CheckAndRecordAttempt(ctx context.Context, ip, username string) (bool, string, int, time.Duration) {
+	config := g.cfg()
+	if !config.Enabled {
+		return true, "", config.MaxAttempts, 0
 	}
 
 	// 1. IP 잠금 확인
 	if locked, until := g.isIPLocked(ctx, ip); locked {
-		return false, fmt.Sprintf("IP가 %s까지 잠겨 있습니다", until.Format("15:04:05")), 0
+		return false, fmt.Sprintf("IP가 %s까지 잠겨 있습니다", until.Format("15:04:05")), 0, time.Until(until)
 	}
 
 	// 2. 사용자 잠금 확인 (DB + Valkey)
 	if username != "" {
 		if locked, until := g.isUserLocked(ctx, username); locked {
-			return false, fmt.Sprintf("계정이 %s까지 잠겨 있습니다", until.Format("15:04:05")), 0
+			return false, fmt.Sprintf("계정이 %s까지 잠겨 있습니다", until.Format("15:04:05")), 0, time.Until(until)
 		}
 	}
 
 	// 3. IP 시도 횟수 확인
 	ipAttempts := g.getAttemptCount(ctx, "ip:"+ip)
-	if ipAttempts >= g.config.IPMaxAttempts {
+	if ipAttempts >= config.IPMaxAttempts {
 		g.lockIP(ctx, ip)
-		g.logLockEvent(nil, ip, "ip", "max_attempts")
-		return false, "너무 많은 로그인 시도로 IP가 잠겼습니다", 0
+		g.logLockEvent(nil, ip, "ip", "max_attempts", config.IPLockDuration)
+		return false, "너무 많은 로그인 시도로 IP가 잠겼습니다", 0, config.IPLockDuration
 	}
 
 	// 4. 사용자 시도 횟수 확인
 	if username != "" {
 		userAttempts := g.getAttemptCount(ctx, "user:"+username)
-		remaining := g.config.MaxAttempts - userAttempts
-		if userAttempts >= g.config.MaxAttempts {
-			g.lockUser(ctx, username)
-			g.logLockEvent(&username, ip, "user", "max_attempts")
-			return false, "로그인 시도 횟수 초과로 계정이 잠겼습니다", 0
+		remaining := config.MaxAttempts - userAttempts
+		if userAttempts >= config.MaxAttempts {
+			d := g.lockUser(ctx, username)
+			g.logLockEvent(&username, ip, "user", "max_attempts", d)
+			return false, "로그인 시도 횟수 초과로 계정이 잠겼습니다", 0, d
 		}
-		return true, "", remaining
+		return true, "", remaining, 0
 	}
 
-	return true, "", g.config.MaxAttempts - ipAttempts
+	return true, "", config.MaxAttempts - ipAttempts, 0
 }
 
 // RecordFailedAttempt records a failed login attempt
 func (g *BruteForceGuard) RecordFailedAttempt(ctx context.Context, ip, username string) {
-	if !g.config.Enabled {
+	config := g.cfg()
+	if !config.Enabled {
 		return
 	}
 
 	// IP 카운터 증가
-	g.incrementAttempt(ctx, "ip:"+ip, g.config.WindowDuration)
+	g.incrementAttempt(ctx, "ip:"+ip, config.WindowDuration)
 
 	// 사용자 카운터 증가 (사용자가 존재하는 경우만)
 	if username != "" {
-		g.incrementAttempt(ctx, "user:"+username, g.config.WindowDuration)
+		g.incrementAttempt(ctx, "user:"+username, config.WindowDuration)
 
 		// DB에도 기록 (영구 추적)
 		_, _ = g.db.ExecContext(ctx, `
@@ -245,23 +280,23 @@ func (g *BruteForceGuard) RecordFailedAttempt(ctx context.Context, ip, username
 
 		// 잠금 임계값 도달 여부 확인
 		count := g.getAttemptCount(ctx, "user:"+username)
-		if count >= g.config.MaxAttempts {
-			g.lockUser(ctx, username)
-			g.logLockEvent(&username, ip, "user", "max_attempts")
+		if count >= config.MaxAttempts {
+			d := g.lockUser(ctx, username)
+			g.logLockEvent(&username, ip, "user", "max_attempts", d)
 		}
 	}
 
 	// IP 잠금 임계값 확인
 	ipCount := g.getAttemptCount(ctx, "ip:"+ip)
-	if ipCount >= g.config.IPMaxAttempts {
+	if ipCount >= config.IPMaxAttempts {
 		g.lockIP(ctx, ip)
-		g.logLockEvent(nil, ip, "ip", "max_attempts")
+		g.logLockEvent(nil, ip, "ip", "max_attempts", config.IPLockDuration)
 	}
 }
 
 // RecordSuccessfulLogin resets counters on successful login
 func (g *BruteForceGuard) RecordSuccessfulLogin(ctx context.Context, ip, username string) {
-	if !g.config.Enabled {
+	if !g.cfg().Enabled {
 		return
 	}
 
@@ -340,7 +375,7 @@ func (g *BruteForceGuard) isUserLocked(ctx context.Context, username string) (bo
 // lockIP locks an IP address
 func (g *BruteForceGuard) lockIP(ctx context.Context, ip string) {
 	key := g.keyPrefix + "locked:ip:" + ip
-	expiry := g.config.IPLockDuration
+	expiry := g.cfg().IPLockDuration
 
 	if g.redisEnabled {
 		g.redis.Set(ctx, key, "1", expiry)
@@ -353,10 +388,19 @@ func (g *BruteForceGuard) lockIP(ctx context.Context, ip string) {
 	})
 }
 
-// lockUser locks a user account
-func (g *BruteForceGuard) lockUser(ctx context.Context, username string) {
+// lockUser locks a user account and returns for how long. Each lock since
+// the last successful login is twice as long as the one before it, counted
+// from the failures recorded in the DB; the attempt counter starts over so
+// the account gets its attempts back once the lock ends.
+func (g *BruteForceGuard) lockUser(ctx context.Context, username string) time.Duration {
+	config := g.cfg()
+	failed := config.MaxAttempts
+	_ = g.db.QueryRowContext(ctx, `
+		SELECT COALESCE(failed_login_count, 0) FROM users WHERE username = $1
+	`, username).Scan(&failed)
+
 	key := g.keyPrefix + "locked:user:" + username
-	expiry := g.config.LockDuration
+	expiry := config.lockDurationAfter(failed)
 	lockedUntil := time.Now().Add(expiry)
 
 	// DB에 영구 기록
@@ -376,6 +420,14 @@ func (g *BruteForceGuard) lockUser(ctx context.Context, username string) {
 		Count:     1,
 		ExpiresAt: lockedUntil,
 	})
+
+	// 시도 횟수 초기화
+	if g.redisEnabled {
+		g.redis.Del(ctx, g.keyPrefix+"user:"+username)
+	}
+	g.localCache.Delete("user:" + username)
+
+	return expiry
 }
 
 // getAttemptCount gets the current attempt count for a key
@@ -424,7 +476,7 @@ func (g *BruteForceGuard) incrementAttempt(ctx context.Context, keySuffix string
 }
 
 // logLockEvent logs a lock event to audit log
-func (g *BruteForceGuard) logLockEvent(username *string, ip, lockType, reason string) {
+func (g *BruteForceGuard) logLockEvent(username *string, ip, lockType, reason string, duration time.Duration) {
 	if g.audit == nil {
 		return
 	}
@@ -448,7 +500,7 @@ func (g *BruteForceGuard) logLockEvent(username *string, ip, lockType, reason st
 	_ = g.audit.LogEvent(userID, ip, eventType, target, map[string]interface{}{
 		"lockType": lockType,
 		"reason":   reason,
-		"duration": g.config.LockDuration.String(),
+		"duration": duration.String(),
 	})
 }
 
@@ -491,11 +543,11 @@ func (g *BruteForceGuard) AdminUnlockIP(ctx context.Context, ip string) error {
 
 // LockedUserInfo represents a locked user's information
 type LockedUserInfo struct {
-	Username       string     `json:"username"`
-	LockedUntil    time.Time  `json:"lockedUntil"`
-	FailedCount    int        `json:"failedCount"`
-	LastFailedAt   *time.Time `json:"lastFailedAt,omitempty"`
-	RemainingTime  string     `json:"remainingTime"`
+	Username      string     `json:"username"`
+	LockedUntil   time.Time  `json:"lockedUntil"`
+	FailedCount   int        `json:"failedCount"`
+	LastFailedAt  *time.Time `json:"lastFailedAt,omitempty"`
+	RemainingTime string     `json:"remainingTime"`
 }
 
 // GetLockedUsers returns list of currently locked users (admin only)
@@ -604,17 +656,19 @@ func (g *BruteForceGuard) GetStats(c echo.Context) error {
 		WHERE locked_until IS NOT NULL AND locked_until > NOW()
 	`).Scan(&dbLockedCount)
 
+	config := g.cfg()
 	return c.JSON(http.StatusOK, BruteForceStats{
 		TrackedIPs:   ipCount,
 		TrackedUsers: userCount,
 		LockedUsers:  dbLockedCount,
 		Config: map[string]interface{}{
-			"enabled":          g.config.Enabled,
-			"maxAttempts":      g.config.MaxAttempts,
-			"windowMinutes":    g.config.WindowDuration.Minutes(),
-			"lockMinutes":      g.config.LockDuration.Minutes(),
-			"ipMaxAttempts":    g.config.IPMaxAttempts,
-			"ipLockMinutes":    g.config.IPLockDuration.Minutes(),
+			"enabled":        config.Enabled,
+			"maxAttempts":    config.MaxAttempts,
+			"windowMinutes":  config.WindowDuration.Minutes(),
+			"lockMinutes":    config.LockDuration.Minutes(),
+			"maxLockMinutes": config.MaxLockDuration.Minutes(),
+			"ipMaxAttempts":  config.IPMaxAttempts,
+			"ipLockMinutes":  config.IPLockDuration.Minutes(),
 		},
 	})
 }
@@ -635,6 +689,29 @@ func formatLockDuration(d time.Duration) string {
 
 // ReloadConfig reloads the configuration from the database
 func (g *BruteForceGuard) ReloadConfig() {
-	g.config = loadBruteForceConfigFromDB(g.db)
-	LogInfo("BruteForceGuard: Configuration reloaded", "config", g.config)
+	if g == nil {
+		return
+	}
+	config := loadBruteForceConfigFromDB(g.db)
+	g.configMu.Lock()
+	g.config = config
+	g.configMu.Unlock()
+	LogInfo("BruteForceGuard: Configuration reloaded", "config", config)
+}
+
+// respondLoginBlocked answers a login or 2FA attempt the guard refused with
+// 429 and the time until it may be retried
+func respondLoginBlocked(c echo.Context, audit *AuditHandler, username, reason string, retryAfter time.Duration) error {
+	if audit != nil {
+		_ = audit.LogEvent(nil, c.RealIP(), EventLoginBlocked, username, map[string]interface{}{
+			"username": username,
+			"reason":   reason,
+		})
+	}
+	seconds := max(int64(math.Ceil(retryAfter.Seconds())), 1)
+	c.Response().Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+		"error":      reason,
+		"retryAfter": seconds,
+	})
 }
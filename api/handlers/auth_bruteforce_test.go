@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useTestBruteForceGuard installs a guard with config and no Valkey for the
+// duration of the test
+func useTestBruteForceGuard(t *testing.T, tc *TestContext, config BruteForceConfig) *BruteForceGuard {
+	t.Helper()
+	guard := &BruteForceGuard{db: tc.DB, config: config, keyPrefix: "fh:bruteforce:"}
+	previous := bruteForceGuard
+	bruteForceGuard = guard
+	t.Cleanup(func() { bruteForceGuard = previous })
+	return guard
+}
+
+func TestBruteForceConfig_LockDurationBacksOff(t *testing.T) {
+	config := BruteForceConfig{MaxAttempts: 5, LockDuration: 15 * time.Minute, MaxLockDuration: time.Hour}
+	for _, tt := range []struct {
+		failed int
+		want   time.Duration
+	}{
+		{0, 15 * time.Minute},
+		{5, 15 * time.Minute},
+		{9, 15 * time.Minute},
+		{10, 30 * time.Minute},
+		{15, time.Hour},
+		{20, time.Hour},
+		{1 << 20, time.Hour},
+	} {
+		if got := config.lockDurationAfter(tt.failed); got != tt.want {
+			t.Errorf("lockDurationAfter(%d) = %v, want %v", tt.failed, got, tt.want)
+		}
+	}
+
+	// A maximum below the first lock does not shorten it
+	config.MaxLockDuration = time.Minute
+	if got := config.lockDurationAfter(50); got != 15*time.Minute {
+		t.Errorf("lockDurationAfter with a smaller maximum = %v", got)
+	}
+}
+
+func TestLogin_LockedAccountRetryAfter(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	config := DefaultBruteForceConfig()
+	config.MaxAttempts = 2
+	guard := useTestBruteForceGuard(t, tc, config)
+	handler := CreateTestAuthHandler(tc.DB)
+	tc.Mock.MatchExpectationsInOrder(false)
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.MinCost)
+	login := func(password string) *http.Response {
+		tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, username, email, password_hash`)).
+			WithArgs("admin").
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "username", "email", "password_hash", "smb_hash", "provider",
+				"is_admin", "is_active", "totp_enabled", "setup_completed", "created_at", "updated_at",
+			}).AddRow("user-1", "admin", nil, string(passwordHash), nil, "local",
+				true, true, false, true, time.Now(), time.Now()))
+		req, _ := NewJSONRequest(http.MethodPost, "/api/auth/login", map[string]string{
+			"username": "admin",
+			"password": password,
+		})
+		rec := httptest.NewRecorder()
+		_ = handler.Login(tc.Echo.NewContext(req, rec))
+		return rec.Result()
+	}
+
+	// The second failure is the first lock; its length comes from the
+	// failures stored for the account
+	if res := login("guess1"); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("first failure: status %d", res.StatusCode)
+	}
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(failed_login_count, 0)`)).WithArgs("admin").
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_count"}).AddRow(4))
+	if res := login("guess2"); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("second failure: status %d", res.StatusCode)
+	}
+
+	// Locked, even with the right password, for twice the first lock
+	res := login("correctpassword")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("locked login: status %d", res.StatusCode)
+	}
+	retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+	if want := int((2 * config.LockDuration).Seconds()); retryAfter < want-5 || retryAfter > want {
+		t.Errorf("Retry-After = %q, want about %d", res.Header.Get("Retry-After"), want)
+	}
+
+	// Once unlocked the account has its attempts back and a login resets them
+	_ = guard.AdminUnlockUser(context.Background(), "admin")
+	if res := login("correctpassword"); res.StatusCode != http.StatusOK {
+		t.Fatalf("login after unlock: status %d", res.StatusCode)
+	}
+}
+
+func TestVerify2FA_FailuresCountTowardLockout(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	config := DefaultBruteForceConfig()
+	config.MaxAttempts = 3
+	useTestBruteForceGuard(t, tc, config)
+	useTestTwoFactorGuard(t)
+	handler := &TOTPHandler{db: tc.DB, auditHandler: &AuditHandler{db: tc.DB}}
+	tc.Mock.MatchExpectationsInOrder(false)
+	pendingToken, _ := generatePending2FAToken("user-1", "alice", false)
+
+	backupHash, _ := bcrypt.GenerateFromPassword([]byte("ABCD1234"), bcrypt.MinCost)
+	verify := func(code string) int {
+		tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, username, email, smb_hash`)).
+			WithArgs("user-1").
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "username", "email", "smb_hash", "provider", "is_admin", "is_active",
+				"totp_secret", "totp_backup_codes", "created_at", "updated_at",
+			}).AddRow("user-1", "alice", nil, nil, "local", false, true,
+				nil, `["`+string(backupHash)+`"]`, time.Now(), time.Now()))
+		req, _ := NewJSONRequest(http.MethodPost, "/api/auth/2fa/verify", map[string]string{
+			"pendingToken": pendingToken,
+			"code":         code,
+		})
+		rec := httptest.NewRecorder()
+		_ = handler.Verify2FA(tc.Echo.NewContext(req, rec))
+		return rec.Code
+	}
+
+	for i := 0; i < config.MaxAttempts; i++ {
+		if code := verify("ZZZZ9999"); code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: status %d", i+1, code)
+		}
+	}
+	// The right code no longer helps once the account is locked
+	if code := verify("ABCD1234"); code != http.StatusTooManyRequests {
+		t.Errorf("right code while locked: status %d", code)
+	}
+}
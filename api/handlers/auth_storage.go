@@ -24,8 +24,20 @@ func (h *AuthHandler) calculateStorageUsed(username string) int64 {
 		return data.HomeUsed
 	}
 
-	// Calculate fresh value
+	// Calculate fresh value, from the folder size index when it covers the home
 	userDir := h.dataRoot + "/users/" + username
+	homeDir := GetStorageLocations().Map(userDir)
+	if totalSize, ok := indexedDirSize(homeDir); ok {
+		// The legacy in-home trash does not count
+		if legacyTrash, ok := indexedDirSize(filepath.Join(homeDir, ".trash")); ok {
+			totalSize -= legacyTrash
+		}
+		cache.SetUserUsage(username, &StorageUsageData{
+			HomeUsed:  totalSize,
+			TotalUsed: totalSize,
+		})
+		return totalSize
+	}
 	var totalSize int64
 
 	_ = filepath.Walk(userDir, func(path string, info os.FileInfo, err error) error {
@@ -53,7 +65,7 @@ func (h *AuthHandler) calculateStorageUsed(username string) int64 {
 
 // GetUserQuotaInfo returns quota info for a user by username
 func (h *AuthHandler) GetUserQuotaInfo(username string) (quota int64, used int64, err error) {
-	err = h.db.QueryRow("SELECT COALESCE(storage_quota, 0) FROM users WHERE username = $1", username).Scan(&quota)
+	err = h.db.QueryRow("SELECT user_storage_quota(id, storage_quota, 0) FROM users WHERE username = $1", username).Scan(&quota)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -180,16 +180,20 @@ func TestRegister_Success(t *testing.T) {
 	defer tc.Cleanup()
 
 	handler := CreateTestAuthHandler(tc.DB)
+	useRegistrationSettings(t, "true", "false", "")
 
 	// Mock: check username doesn't exist
 	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)`)).
 		WithArgs("newuser").
 		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
 
-	// Mock: insert user
+	// Mock: insert user, active at once without approval
 	tc.Mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO users`)).
-		WithArgs("newuser", "new@example.com", sqlmock.AnyArg()).
+		WithArgs("newuser", "new@example.com", sqlmock.AnyArg(), true, false).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("new-user-id"))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("new-user-id", sqlmock.AnyArg(), EventUserRegister, "newuser", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	req, _ := NewJSONRequest(http.MethodPost, "/api/auth/register", map[string]string{
 		"username": "newuser",
@@ -216,6 +220,7 @@ func TestRegister_ShortUsername(t *testing.T) {
 	defer tc.Cleanup()
 
 	handler := CreateTestAuthHandler(tc.DB)
+	useRegistrationSettings(t, "true", "true", "")
 
 	req, _ := NewJSONRequest(http.MethodPost, "/api/auth/register", map[string]string{
 		"username": "ab",
@@ -236,6 +241,7 @@ func TestRegister_ShortPassword(t *testing.T) {
 	defer tc.Cleanup()
 
 	handler := CreateTestAuthHandler(tc.DB)
+	useRegistrationSettings(t, "true", "true", "")
 
 	req, _ := NewJSONRequest(http.MethodPost, "/api/auth/register", map[string]string{
 		"username": "validuser",
@@ -256,6 +262,7 @@ func TestRegister_DuplicateUsername(t *testing.T) {
 	defer tc.Cleanup()
 
 	handler := CreateTestAuthHandler(tc.DB)
+	useRegistrationSettings(t, "true", "true", "")
 
 	// Mock: username already exists
 	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)`)).
@@ -423,3 +430,71 @@ func TestJWTMiddleware_InvalidToken(t *testing.T) {
 		t.Errorf("Expected status 401, got %d", rec.Code)
 	}
 }
+
+func TestJWTMiddleware_LimitedToken(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+
+	handler := CreateTestAuthHandler(tc.DB)
+	token, _ := GenerateScopedJWT("user-123", "testuser", true, TokenScope2FASetup)
+
+	testHandler := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, "ok")
+	}
+
+	tests := []struct {
+		method string
+		route  string
+		want   int
+	}{
+		{http.MethodGet, "/api/auth/2fa/setup", http.StatusOK},
+		{http.MethodPost, "/api/auth/2fa/enable", http.StatusOK},
+		{http.MethodGet, "/api/auth/profile", http.StatusOK},
+		{http.MethodPut, "/api/auth/profile", http.StatusForbidden},
+		{http.MethodGet, "/api/files/*", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(req, rec)
+		c.SetPath(tt.route)
+
+		_ = handler.JWTMiddleware(testHandler)(c)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.route, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestTwoFactorPolicy_Evaluate(t *testing.T) {
+	enforcedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	policy := TwoFactorPolicy{RequireForAdmins: true, GraceDays: 7, EnforcedAt: enforcedAt}
+	created := enforcedAt.AddDate(-1, 0, 0)
+
+	if got := policy.Evaluate(false, false, created, enforcedAt); got.Required || !got.Compliant {
+		t.Errorf("regular users should not be covered by the admin policy: %+v", got)
+	}
+	if got := policy.Evaluate(true, true, created, enforcedAt); !got.Required || !got.Compliant {
+		t.Errorf("admin with 2FA should be compliant: %+v", got)
+	}
+
+	inGrace := policy.Evaluate(true, false, created, enforcedAt.AddDate(0, 0, 3))
+	if inGrace.Compliant || inGrace.Overdue || inGrace.GraceUntil == nil || !inGrace.GraceUntil.Equal(enforcedAt.AddDate(0, 0, 7)) {
+		t.Errorf("unexpected grace evaluation: %+v", inGrace)
+	}
+	if got := policy.Evaluate(true, false, created, enforcedAt.AddDate(0, 0, 8)); !got.Overdue {
+		t.Errorf("expected overdue after grace period: %+v", got)
+	}
+
+	// Accounts created after enforcement get their own grace period
+	lateCreated := enforcedAt.AddDate(0, 1, 0)
+	if got := policy.Evaluate(true, false, lateCreated, lateCreated.AddDate(0, 0, 1)); got.Overdue {
+		t.Errorf("new account should still be in grace: %+v", got)
+	}
+
+	policy.RequireForAll = true
+	if got := policy.Evaluate(false, false, created, enforcedAt.AddDate(0, 0, 8)); !got.Required || !got.Overdue {
+		t.Errorf("org-wide policy should cover regular users: %+v", got)
+	}
+}
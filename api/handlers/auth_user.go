@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
@@ -17,6 +19,9 @@ type CreateUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	IsAdmin  bool   `json:"isAdmin"`
+	// SendWelcomeEmail mails the user a link to set their password; the
+	// password may then be left empty
+	SendWelcomeEmail bool `json:"sendWelcomeEmail,omitempty"`
 }
 
 // UpdateUserRequest represents admin user update request
@@ -41,6 +46,8 @@ func (h *AuthHandler) ListUsers(c echo.Context) error {
 	}
 	defer rows.Close()
 
+	policy := LoadTwoFactorPolicy()
+	now := time.Now()
 	users := []User{}
 	for rows.Next() {
 		var user User
@@ -66,11 +73,15 @@ func (h *AuthHandler) ListUsers(c echo.Context) error {
 		}
 		user.HasSMB = smbHash.Valid && smbHash.String != ""
 		user.Has2FA = totpEnabled
+		compliance := policy.Evaluate(user.IsAdmin, user.Has2FA, user.CreatedAt, now)
+		user.TwoFactorPolicy = &compliance
 		if storageQuota.Valid {
 			user.StorageQuota = storageQuota.Int64
 		}
 		// Calculate storage used
 		user.StorageUsed = h.calculateStorageUsed(user.Username)
+		user.GuestStatus, user.GuestExpiresAt = GetGuests().status(user.ID)
+		user.IsGuest = user.GuestStatus != ""
 
 		users = append(users, user)
 	}
@@ -87,36 +98,85 @@ func (h *AuthHandler) CreateUser(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return RespondError(c, ErrBadRequest("Invalid request"))
 	}
+	if apiErr := guardSuperadminGrant(c, c.Get("user").(*JWTClaims), req.IsAdmin); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if req.SendWelcomeEmail {
+		if req.Email == "" {
+			return RespondError(c, ErrBadRequest("A welcome email needs the user's email address"))
+		}
+		if !GetMailer().Configured() {
+			return RespondError(c, ErrBadRequest("SMTP is not configured"))
+		}
+	}
+
+	userID, warnings, apiErr := h.createUserAccount(req)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if req.SendWelcomeEmail {
+		baseURL := appBaseURL(c)
+		go func() {
+			if err := h.sendPasswordMail(userID, req.Username, req.Email, PasswordTokenSetup, baseURL); err != nil {
+				log.Printf("[Mail] Failed to send welcome mail to %s: %v", req.Username, err)
+			}
+		}()
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"id":      userID,
+		"message": "User created successfully",
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+	if req.SendWelcomeEmail {
+		response["welcomeEmail"] = req.Email
+	}
+	return c.JSON(http.StatusCreated, response)
+}
 
+// createUserAccount validates the request, inserts the user and prepares the
+// home directory. Used by CreateUser and bulk provisioning.
+func (h *AuthHandler) createUserAccount(req CreateUserRequest) (string, []string, *APIError) {
 	// Validate input
 	if len(req.Username) < 3 || len(req.Username) > 50 {
-		return RespondError(c, ErrBadRequest("Username must be between 3 and 50 characters"))
+		return "", nil, ErrBadRequest("Username must be between 3 and 50 characters")
 	}
 
-	// Validate password complexity
-	if err := ValidatePassword(req.Password); err != nil {
-		return RespondError(c, ErrBadRequest(err.Error()))
+	// Validate password complexity. Without a password the user sets one
+	// through the welcome mail; until then nobody knows the random one.
+	password := req.Password
+	if password == "" && req.SendWelcomeEmail {
+		random, err := GenerateURLSafeToken(32)
+		if err != nil {
+			return "", nil, ErrInternal("Failed to generate password")
+		}
+		password = random
+	} else if err := ValidatePassword(password); err != nil {
+		return "", nil, ErrBadRequest(err.Error())
 	}
 
 	// Validate email format
 	if err := ValidateEmail(req.Email); err != nil {
-		return RespondError(c, ErrBadRequest(err.Error()))
+		return "", nil, ErrBadRequest(err.Error())
 	}
 
 	// Check if username already exists
 	var exists bool
 	err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", req.Username).Scan(&exists)
 	if err != nil {
-		return RespondError(c, ErrInternal("Database error"))
+		return "", nil, ErrInternal("Database error")
 	}
 	if exists {
-		return RespondError(c, ErrAlreadyExists("Username"))
+		return "", nil, ErrAlreadyExists("Username")
 	}
 
 	// Hash password
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return RespondError(c, ErrInternal("Failed to hash password"))
+		return "", nil, ErrInternal("Failed to hash password")
 	}
 
 	// Create user
@@ -128,7 +188,7 @@ func (h *AuthHandler) CreateUser(c echo.Context) error {
 	`, req.Username, req.Email, string(passwordHash), req.IsAdmin).Scan(&userID)
 
 	if err != nil {
-		return RespondError(c, ErrInternal("Failed to create user"))
+		return "", nil, ErrInternal("Failed to create user")
 	}
 
 	// Create user's home directory
@@ -138,35 +198,48 @@ func (h *AuthHandler) CreateUser(c echo.Context) error {
 		warnings = append(warnings, "Home directory creation failed - will be created on first access")
 	}
 
-	response := map[string]interface{}{
-		"success": true,
-		"id":      userID,
-		"message": "User created successfully",
-	}
-	if len(warnings) > 0 {
-		response["warnings"] = warnings
-	}
-	return c.JSON(http.StatusCreated, response)
+	return userID, warnings, nil
 }
 
 // UpdateUser updates a user (admin only)
 func (h *AuthHandler) UpdateUser(c echo.Context) error {
 	userID := c.Param("id")
+	claims := c.Get("user").(*JWTClaims)
 
 	var req UpdateUserRequest
 	if err := c.Bind(&req); err != nil {
 		return RespondError(c, ErrBadRequest("Invalid request"))
 	}
+	if apiErr := guardSuperadminGrant(c, claims, req.IsAdmin); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := guardSuperadminAccount(c, h.db, claims, userID); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	if apiErr := h.updateUserAccount(userID, req); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	GetAdminRoles().Invalidate(userID)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "User updated successfully",
+	})
+}
 
-	// Build update query
-	updates := []string{"is_admin = $1", "is_active = $2", "updated_at = NOW()"}
+// updateUserAccount applies an UpdateUserRequest to an existing user.
+// Used by UpdateUser and bulk provisioning.
+func (h *AuthHandler) updateUserAccount(userID string, req UpdateUserRequest) *APIError {
+	// Build update query; activating a pending registration approves it
+	updates := []string{"is_admin = $1", "is_active = $2", "registration_pending = registration_pending AND NOT $2", "updated_at = NOW()"}
 	args := []interface{}{req.IsAdmin, req.IsActive}
 	argCount := 3
 
 	if req.Email != "" {
 		// Validate email format
 		if err := ValidateEmail(req.Email); err != nil {
-			return RespondError(c, ErrBadRequest(err.Error()))
+			return ErrBadRequest(err.Error())
 		}
 		updates = append(updates, fmt.Sprintf("email = $%d", argCount))
 		args = append(args, req.Email)
@@ -176,11 +249,11 @@ func (h *AuthHandler) UpdateUser(c echo.Context) error {
 	if req.Password != "" {
 		// Validate password complexity
 		if err := ValidatePassword(req.Password); err != nil {
-			return RespondError(c, ErrBadRequest(err.Error()))
+			return ErrBadRequest(err.Error())
 		}
 		passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
-			return RespondError(c, ErrInternal("Failed to hash password"))
+			return ErrInternal("Failed to hash password")
 		}
 		updates = append(updates, fmt.Sprintf("password_hash = $%d", argCount))
 		args = append(args, string(passwordHash))
@@ -198,21 +271,45 @@ func (h *AuthHandler) UpdateUser(c echo.Context) error {
 
 	result, err := h.db.Exec(query, args...)
 	if err != nil {
-		return RespondError(c, ErrInternal("Failed to update user"))
+		return ErrInternal("Failed to update user")
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		return RespondError(c, ErrNotFound("User"))
+		return ErrNotFound("User")
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": "User updated successfully",
-	})
+	// A deactivated user's tokens stop working now, and its sessions stay
+	// ended if the account is activated again
+	GetSessions().SetUserActive(userID, req.IsActive)
+	if !req.IsActive && GetSessions() != nil {
+		if _, err := GetSessions().RevokeAll(userID); err != nil {
+			log.Printf("[Sessions] Failed to end sessions of deactivated user %s: %v", userID, err)
+		}
+	}
+
+	return nil
 }
 
-// DeleteUser deletes a user (admin only)
+// userDependents are the rows deleted with a user by ON DELETE CASCADE
+var userDependents = []struct{ table, where string }{
+	{"file_shares", "owner_id = $1 OR shared_with_id = $1"},
+	{"shared_folder_members", "user_id = $1"},
+	{"user_group_members", "user_id = $1"},
+	{"mount_ins", "owner_id = $1"},
+	{"file_metadata", "user_id = $1"},
+	{"starred_files", "user_id = $1"},
+	{"file_locks", "locked_by = $1"},
+	{"notifications", "user_id = $1"},
+	{"password_tokens", "user_id = $1"},
+	{"camera_backup_settings", "user_id = $1"},
+	{"camera_ingest_items", "user_id = $1"},
+}
+
+// DeleteUser deletes a user (admin only). The home folder is kept. With
+// dryRun=true nothing is changed and the response lists the rows that would
+// be deleted. With erase=true (GDPR erasure) the user is also pseudonymized
+// in the audit log and their link shares, see eraseUser.
 func (h *AuthHandler) DeleteUser(c echo.Context) error {
 	userID := c.Param("id")
 	claims := c.Get("user").(*JWTClaims)
@@ -221,19 +318,75 @@ func (h *AuthHandler) DeleteUser(c echo.Context) error {
 	if userID == claims.UserID {
 		return RespondError(c, ErrBadRequest("Cannot delete your own account"))
 	}
+	if apiErr := guardSuperadminAccount(c, h.db, claims, userID); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	var username, email string
+	if err := h.db.QueryRow("SELECT username, COALESCE(email, '') FROM users WHERE id = $1", userID).Scan(&username, &email); err != nil {
+		if err == sql.ErrNoRows {
+			return RespondError(c, ErrNotFound("User"))
+		}
+		return RespondError(c, ErrInternal("Failed to delete user"))
+	}
 
-	result, err := h.db.Exec("DELETE FROM users WHERE id = $1", userID)
+	run, err := beginDestructiveRun(h.db, dryRunParam(c))
 	if err != nil {
 		return RespondError(c, ErrInternal("Failed to delete user"))
 	}
+	defer run.Abort()
 
-	rowsAffected, _ := result.RowsAffected()
+	for _, dep := range userDependents {
+		if err := run.Count(dep.table, dep.where, userID); err != nil {
+			return RespondError(c, ErrInternal("Failed to delete user"))
+		}
+	}
+	var erasure *UserErasure
+	if c.QueryParam("erase") == "true" {
+		holdDays, truncateIPs := erasureSettings(c)
+		if erasure, err = eraseUser(run, userID, username, email, claims.UserID, truncateIPs, holdDays); err != nil {
+			return RespondError(c, ErrOperationFailed("erase user", err))
+		}
+	}
+	rowsAffected, err := run.Exec("users", "DELETE FROM users WHERE id = $1", userID)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to delete user"))
+	}
 	if rowsAffected == 0 {
 		return RespondError(c, ErrNotFound("User"))
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": "User deleted successfully",
-	})
+	// The home folder stays on disk for an admin to archive or remove
+	home := GetStorageLocations().Map(filepath.Join(h.dataRoot, "users", username))
+	homeFiles, homeBytes := measureTree(home)
+
+	result, err := run.Finish()
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to delete user"))
+	}
+	result.Details = map[string]interface{}{
+		"username": username,
+		"home": map[string]interface{}{
+			"path":     "/users/" + username,
+			"retained": true,
+			"files":    homeFiles,
+			"bytes":    homeBytes,
+		},
+	}
+	if result.Applied {
+		GetGuests().forget(userID)
+		GetSessions().ForgetUser(userID)
+	}
+	if erasure != nil {
+		result.Details.(map[string]interface{})["erasure"] = erasure
+		if result.Applied && h.auditHandler != nil {
+			// Names only the pseudonym; the mapping is in the erasure until the hold ends
+			_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminUserErase, erasure.ID, map[string]interface{}{
+				"pseudonym": erasure.Pseudonym,
+				"rows":      erasure.Report.AuditRows + erasure.Report.AuditMentions,
+				"shares":    erasure.Report.Shares,
+			})
+		}
+	}
+	return RespondSuccess(c, result)
 }
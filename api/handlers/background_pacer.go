@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Pacing priorities. User jobs only back off while requests are slow;
+// maintenance jobs also keep to the duty cycle.
+const (
+	PacePriorityUser        = "user"        // Copy, move, compress, extract, size walks for a request
+	PacePriorityMaintenance = "maintenance" // Trash cleanup, adoption, key rotation
+)
+
+const (
+	// paceSlice is how long a job works between pacing decisions
+	paceSlice = 50 * time.Millisecond
+	// paceLatencyAlpha weights new samples in the request latency EWMA
+	paceLatencyAlpha = 0.2
+	// paceLatencyIdle is how long after the last request the EWMA is ignored
+	paceLatencyIdle = 10 * time.Second
+	// paceMaxDelayUser and paceMaxDelayMaintenance bound a single sleep
+	paceMaxDelayUser        = 500 * time.Millisecond
+	paceMaxDelayMaintenance = 2 * time.Second
+)
+
+// pacingConfig reads the duty cycle (percent of time maintenance jobs may
+// run) and the request latency target from settings
+func pacingConfig() (dutyCycle int, latencyTarget time.Duration) {
+	dutyCycle, targetMs := 50, 200
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		dutyCycle = sh.GetSettingInt("background_duty_cycle", dutyCycle)
+		targetMs = sh.GetSettingInt("background_latency_target_ms", targetMs)
+	}
+	return min(max(dutyCycle, 5), 100), time.Duration(max(targetMs, 10)) * time.Millisecond
+}
+
+// paceDelay returns how long a job should sleep after working for work.
+// load is the request latency EWMA divided by the target (0 when idle).
+func paceDelay(work time.Duration, priority string, dutyCycle int, load float64) time.Duration {
+	var delay time.Duration
+	maxDelay := paceMaxDelayUser
+	pressure := 1.0
+	if priority == PacePriorityMaintenance {
+		delay = work * time.Duration(100-dutyCycle) / time.Duration(dutyCycle)
+		maxDelay = paceMaxDelayMaintenance
+		pressure = 2
+	}
+	if load > 1 {
+		delay += time.Duration(float64(work) * (load - 1) * pressure)
+	}
+	return min(delay, maxDelay)
+}
+
+// BackgroundPacer slows background work down while interactive requests are
+// slow. Jobs call Pace periodically from their walk or copy loops.
+type BackgroundPacer struct {
+	mu           sync.Mutex
+	latencyEWMA  time.Duration
+	lastObserved time.Time
+	jobs         map[*PaceJob]struct{}
+	sleeps       int64
+	slept        time.Duration
+}
+
+var backgroundPacer = &BackgroundPacer{jobs: make(map[*PaceJob]struct{})}
+
+// GetBackgroundPacer returns the global background pacer
+func GetBackgroundPacer() *BackgroundPacer {
+	return backgroundPacer
+}
+
+// ObserveLatency adds a foreground request duration to the EWMA
+func (p *BackgroundPacer) ObserveLatency(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastObserved.IsZero() || time.Since(p.lastObserved) > paceLatencyIdle {
+		p.latencyEWMA = d
+	} else {
+		p.latencyEWMA += time.Duration(paceLatencyAlpha * float64(d-p.latencyEWMA))
+	}
+	p.lastObserved = time.Now()
+}
+
+// load returns the latency EWMA relative to target, or 0 when no request
+// was seen recently
+func (p *BackgroundPacer) load(target time.Duration, now time.Time) (time.Duration, float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastObserved.IsZero() || now.Sub(p.lastObserved) > paceLatencyIdle {
+		return p.latencyEWMA, 0
+	}
+	return p.latencyEWMA, float64(p.latencyEWMA) / float64(target)
+}
+
+// Begin registers a paced job; call End when it finishes
+func (p *BackgroundPacer) Begin(name, priority string) *PaceJob {
+	now := time.Now()
+	j := &PaceJob{pacer: p, name: name, priority: priority, startedAt: now, sliceStart: now}
+	p.mu.Lock()
+	p.jobs[j] = struct{}{}
+	p.mu.Unlock()
+	return j
+}
+
+// PaceJob is one paced job. Methods are safe to call on a nil *PaceJob and
+// must be called from the job's own goroutine.
+type PaceJob struct {
+	pacer      *BackgroundPacer
+	name       string
+	priority   string
+	startedAt  time.Time
+	sliceStart time.Time
+
+	// Guarded by pacer.mu
+	sleeps int64
+	slept  time.Duration
+}
+
+// Pace sleeps when the job has worked for a slice and needs to back off
+func (j *PaceJob) Pace() {
+	_ = j.PaceContext(context.Background())
+}
+
+// PaceContext is Pace that stops sleeping when ctx is done
+func (j *PaceJob) PaceContext(ctx context.Context) error {
+	if j == nil {
+		return nil
+	}
+	now := time.Now()
+	work := now.Sub(j.sliceStart)
+	if work < paceSlice {
+		return nil
+	}
+
+	dutyCycle, target := pacingConfig()
+	_, load := j.pacer.load(target, now)
+	delay := paceDelay(work, j.priority, dutyCycle, load)
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		j.pacer.mu.Lock()
+		j.sleeps++
+		j.slept += delay
+		j.pacer.sleeps++
+		j.pacer.slept += delay
+		j.pacer.mu.Unlock()
+	}
+	j.sliceStart = time.Now()
+	return nil
+}
+
+// End removes the job from the pacer
+func (j *PaceJob) End() {
+	if j == nil {
+		return
+	}
+	j.pacer.mu.Lock()
+	delete(j.pacer.jobs, j)
+	j.pacer.mu.Unlock()
+}
+
+// LatencyMiddleware feeds listing and preview request durations to the
+// background pacer. Transfers are left out, as their duration depends on size.
+func LatencyMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if RateLimitClass(c.Request().Method, c.Request().URL.Path) != RateClassBrowse {
+				return next(c)
+			}
+			start := time.Now()
+			err := next(c)
+			GetBackgroundPacer().ObserveLatency(time.Since(start))
+			return err
+		}
+	}
+}
+
+// PaceJobStatus is a running paced job
+type PaceJobStatus struct {
+	Name      string    `json:"name"`
+	Priority  string    `json:"priority"`
+	StartedAt time.Time `json:"startedAt"`
+	Sleeps    int64     `json:"sleeps"`
+	SleptMs   int64     `json:"sleptMs"`
+}
+
+// PacingStatus is the pacer state shown on the admin system info endpoint
+type PacingStatus struct {
+	DutyCycle       int             `json:"dutyCycle"`
+	LatencyTargetMs int64           `json:"latencyTargetMs"`
+	LatencyMs       int64           `json:"latencyMs"` // Request latency EWMA
+	Load            float64         `json:"load"`      // Latency EWMA / target; 0 when idle
+	BackingOff      bool            `json:"backingOff"`
+	Sleeps          int64           `json:"sleeps"`
+	SleptMs         int64           `json:"sleptMs"`
+	Jobs            []PaceJobStatus `json:"jobs"`
+}
+
+// EffectivePacingStatus reports the current pacing state and running jobs
+func EffectivePacingStatus() PacingStatus {
+	p := GetBackgroundPacer()
+	dutyCycle, target := pacingConfig()
+	latency, load := p.load(target, time.Now())
+
+	p.mu.Lock()
+	status := PacingStatus{
+		DutyCycle:       dutyCycle,
+		LatencyTargetMs: target.Milliseconds(),
+		LatencyMs:       latency.Milliseconds(),
+		Load:            load,
+		BackingOff:      load > 1,
+		Sleeps:          p.sleeps,
+		SleptMs:         p.slept.Milliseconds(),
+		Jobs:            make([]PaceJobStatus, 0, len(p.jobs)),
+	}
+	for j := range p.jobs {
+		status.Jobs = append(status.Jobs, PaceJobStatus{
+			Name:      j.name,
+			Priority:  j.priority,
+			StartedAt: j.startedAt,
+			Sleeps:    j.sleeps,
+			SleptMs:   j.slept.Milliseconds(),
+		})
+	}
+	p.mu.Unlock()
+
+	sort.Slice(status.Jobs, func(i, j int) bool {
+		return status.Jobs[i].StartedAt.Before(status.Jobs[j].StartedAt)
+	})
+	return status
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestPaceDelay(t *testing.T) {
+	work := 100 * time.Millisecond
+
+	if d := paceDelay(work, PacePriorityUser, 50, 0); d != 0 {
+		t.Errorf("user job without load: delay = %v, want 0", d)
+	}
+	if d := paceDelay(work, PacePriorityMaintenance, 50, 0); d != work {
+		t.Errorf("maintenance at 50%% duty: delay = %v, want %v", d, work)
+	}
+	if d := paceDelay(work, PacePriorityMaintenance, 100, 0); d != 0 {
+		t.Errorf("maintenance at 100%% duty: delay = %v, want 0", d)
+	}
+	if d := paceDelay(work, PacePriorityUser, 50, 3); d != 200*time.Millisecond {
+		t.Errorf("user job at load 3: delay = %v, want 200ms", d)
+	}
+	if d := paceDelay(work, PacePriorityMaintenance, 50, 3); d <= paceDelay(work, PacePriorityUser, 50, 3) {
+		t.Errorf("maintenance should back off more than user jobs, got %v", d)
+	}
+	if d := paceDelay(time.Minute, PacePriorityMaintenance, 5, 10); d != paceMaxDelayMaintenance {
+		t.Errorf("delay = %v, want cap %v", d, paceMaxDelayMaintenance)
+	}
+}
+
+func TestBackgroundPacer_LatencyEWMA(t *testing.T) {
+	p := &BackgroundPacer{jobs: make(map[*PaceJob]struct{})}
+	target := 100 * time.Millisecond
+
+	if _, load := p.load(target, time.Now()); load != 0 {
+		t.Errorf("load without requests = %v, want 0", load)
+	}
+
+	p.ObserveLatency(100 * time.Millisecond)
+	p.ObserveLatency(600 * time.Millisecond)
+	latency, load := p.load(target, time.Now())
+	if latency != 200*time.Millisecond || load != 2 {
+		t.Errorf("latency = %v, load = %v; want 200ms, 2", latency, load)
+	}
+
+	// Old samples do not keep jobs backing off once requests stop
+	if _, load := p.load(target, time.Now().Add(paceLatencyIdle+time.Second)); load != 0 {
+		t.Errorf("idle load = %v, want 0", load)
+	}
+}
+
+func TestPaceJob_SleepsAndReports(t *testing.T) {
+	useCachedSettings(t, map[string]string{
+		"background_duty_cycle":        "50",
+		"background_latency_target_ms": "200",
+	})
+	p := GetBackgroundPacer()
+	before := EffectivePacingStatus()
+
+	j := p.Begin("test-job", PacePriorityMaintenance)
+	j.sliceStart = time.Now().Add(-2 * paceSlice)
+	start := time.Now()
+	j.Pace()
+	if elapsed := time.Since(start); elapsed < 2*paceSlice-5*time.Millisecond {
+		t.Errorf("Pace slept %v, want about %v", elapsed, 2*paceSlice)
+	}
+
+	status := EffectivePacingStatus()
+	found := false
+	for _, job := range status.Jobs {
+		if job.Name == "test-job" && job.Sleeps == 1 {
+			found = true
+		}
+	}
+	if !found || status.Sleeps != before.Sleeps+1 {
+		t.Errorf("status does not report the job: %+v", status)
+	}
+
+	j.End()
+	for _, job := range EffectivePacingStatus().Jobs {
+		if job.Name == "test-job" {
+			t.Error("ended job still listed")
+		}
+	}
+}
+
+func TestPaceJob_CancelledWhileWaiting(t *testing.T) {
+	useCachedSettings(t, map[string]string{
+		"background_duty_cycle":        "5",
+		"background_latency_target_ms": "200",
+	})
+	j := GetBackgroundPacer().Begin("cancelled-job", PacePriorityMaintenance)
+	defer j.End()
+	j.sliceStart = time.Now().Add(-time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := j.PaceContext(ctx); err == nil {
+		t.Error("expected context error")
+	}
+}
+
+func TestLatencyMiddleware_OnlyBrowse(t *testing.T) {
+	p := GetBackgroundPacer()
+	p.mu.Lock()
+	p.lastObserved = time.Time{}
+	p.mu.Unlock()
+
+	e := echo.New()
+	handler := LatencyMiddleware()(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/files/copy", nil)
+	_ = handler(e.NewContext(req, httptest.NewRecorder()))
+	p.mu.Lock()
+	observed := !p.lastObserved.IsZero()
+	p.mu.Unlock()
+	if observed {
+		t.Error("non-browse request should not be observed")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/files/home", nil)
+	_ = handler(e.NewContext(req, httptest.NewRecorder()))
+	p.mu.Lock()
+	observed = !p.lastObserved.IsZero()
+	p.mu.Unlock()
+	if !observed {
+		t.Error("listing request should be observed")
+	}
+}
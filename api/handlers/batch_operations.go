@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// Batch operations
+const (
+	BatchMove   = "move"
+	BatchCopy   = "copy"
+	BatchDelete = "delete" // to the trash
+)
+
+// batchMaxItems bounds the items of one batch request
+const batchMaxItems = 1000
+
+// Per-item batch statuses
+const (
+	BatchItemOK      = "ok"
+	BatchItemSkipped = "skipped" // onConflict skip found the name taken
+	BatchItemFailed  = "failed"
+)
+
+// BatchRequest moves, copies or trashes several items at once
+type BatchRequest struct {
+	Operation         string   `json:"operation"` // move, copy or delete
+	Items             []string `json:"items"`
+	Destination       string   `json:"destination"`       // move and copy
+	CreateDestination bool     `json:"createDestination"` // move and copy
+	OnConflict        string   `json:"onConflict"`        // move and copy: fail (default), overwrite, rename or skip
+	// copy: keep times, extended attributes and ownership (default true)
+	PreserveAttributes *bool `json:"preserveAttributes"`
+}
+
+// BatchItemResult is the outcome of one item of a batch
+type BatchItemResult struct {
+	Path            string    `json:"path"`
+	Status          string    `json:"status"` // ok, skipped or failed
+	Code            ErrorCode `json:"code,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	NewPath         string    `json:"newPath,omitempty"`
+	Conflict        string    `json:"conflict,omitempty"`
+	TrashID         string    `json:"trashId,omitempty"`
+	ReplacedTrashID string    `json:"replacedTrashId,omitempty"`
+}
+
+// BatchResponse lists the outcome of every item in request order
+type BatchResponse struct {
+	Operation string            `json:"operation"`
+	Succeeded int               `json:"succeeded"`
+	Skipped   int               `json:"skipped"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// BatchFileOperation moves, copies or trashes several items in one request
+// @Summary		Batch move, copy or delete
+// @Description	Processes items one after another with the same checks as the single move, copy and trash APIs. A failing item does not stop the others; results lists every item in request order with its status (ok, skipped or failed), error and new path. One audit entry (file.batch) records the whole batch, and the storage usage cache is refreshed once at the end. Stops early when the client disconnects.
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		request	body		BatchRequest	true	"Operation, items and destination"
+// @Success		200		{object}	docs.SuccessResponse{data=BatchResponse}	"Per-item results"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/files/batch [post]
+func (h *Handler) BatchFileOperation(c echo.Context) error {
+	var req BatchRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if len(req.Items) == 0 {
+		return RespondError(c, ErrMissingParameter("items"))
+	}
+	if len(req.Items) > batchMaxItems {
+		return RespondError(c, ErrBadRequest("Too many items in one batch").WithDetails(map[string]int{"max": batchMaxItems}))
+	}
+
+	claims := GetClaims(c)
+	strategy := ConflictFail
+	switch req.Operation {
+	case BatchMove, BatchCopy:
+		if req.Destination == "" {
+			return RespondError(c, ErrMissingParameter("destination"))
+		}
+		var apiErr *APIError
+		if strategy, apiErr = parseConflictStrategy(req.OnConflict); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+	case BatchDelete:
+		if claims == nil {
+			return RespondError(c, ErrUnauthorized(""))
+		}
+	default:
+		return RespondError(c, ErrBadRequest("operation must be move, copy or delete"))
+	}
+
+	response := BatchResponse{Operation: req.Operation, Results: make([]BatchItemResult, 0, len(req.Items))}
+	var done []string
+	var createdDirs []string
+	charges := make(map[string]int64)
+	for _, item := range req.Items {
+		if c.Request().Context().Err() != nil {
+			break
+		}
+
+		var result *itemOpResult
+		var apiErr *APIError
+		opReq := itemOpRequest{
+			Source:             item,
+			Destination:        req.Destination,
+			CreateDestination:  req.CreateDestination,
+			OnConflict:         strategy,
+			PreserveAttributes: req.PreserveAttributes == nil || *req.PreserveAttributes,
+		}
+		switch req.Operation {
+		case BatchMove:
+			result, apiErr = h.moveOne(c, claims, opReq)
+		case BatchCopy:
+			result, apiErr = h.copyOne(c, claims, opReq)
+		case BatchDelete:
+			result, apiErr = h.trashOne(c, claims, item)
+		}
+
+		entry := BatchItemResult{Path: item}
+		switch {
+		case apiErr != nil:
+			entry.Status = BatchItemFailed
+			entry.Code = apiErr.Code
+			entry.Error = apiErr.Message
+			response.Failed++
+		case result.Conflict == ConflictSkip:
+			entry.Status = BatchItemSkipped
+			entry.NewPath = result.NewPath
+			entry.Conflict = result.Conflict
+			response.Skipped++
+		default:
+			entry.Status = BatchItemOK
+			entry.NewPath = result.NewPath
+			entry.Conflict = result.Conflict
+			entry.TrashID = result.TrashID
+			entry.ReplacedTrashID = result.ReplacedTrashID
+			response.Succeeded++
+			done = append(done, result.OldPath)
+			if result.CreatedDirs != nil {
+				createdDirs = append(createdDirs, result.CreatedDirs.DisplayPaths...)
+			}
+			if result.chargeUserID != "" {
+				charges[result.chargeUserID] += result.Size
+			}
+		}
+		response.Results = append(response.Results, entry)
+	}
+
+	for userID, size := range charges {
+		if size > 0 {
+			_ = h.UpdateUserStorage(userID, size)
+		}
+	}
+	if response.Succeeded > 0 {
+		cache := GetStorageCache()
+		if claims != nil {
+			cache.InvalidateUserUsage(claims.Username)
+		}
+		cache.InvalidateSharedUsage()
+
+		// One entry for the whole batch
+		var userID *string
+		if claims != nil {
+			userID = &claims.UserID
+		}
+		details := map[string]interface{}{
+			"operation": req.Operation,
+			"items":     response.Succeeded,
+			"paths":     done,
+			"skipped":   response.Skipped,
+			"failed":    response.Failed,
+		}
+		if req.Operation != BatchDelete {
+			details["destination"] = req.Destination
+			details["onConflict"] = strategy
+		}
+		if len(createdDirs) > 0 {
+			details["createdDirs"] = createdDirs
+		}
+		_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileBatch, req.Destination, details)
+	}
+
+	return RespondSuccess(c, response)
+}
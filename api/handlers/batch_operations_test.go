@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func runBatch(t *testing.T, ftc *FileTestContext, body BatchRequest) BatchResponse {
+	t.Helper()
+	req, err := NewJSONRequest(http.MethodPost, "/api/files/batch", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := CreateAuthenticatedContext(ftc.Echo, ftc.Recorder, req, "1", "testuser", false)
+	if err := ftc.Handler.BatchFileOperation(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, ftc.Recorder, http.StatusOK)
+	var resp struct {
+		Data BatchResponse `json:"data"`
+	}
+	if err := json.Unmarshal(ftc.Recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+func TestBatchFileOperation_Move(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	ftc.CreateTestFile(t, filepath.Join(userDir, "a.txt"), []byte("a"))
+	ftc.CreateTestFile(t, filepath.Join(userDir, "b.txt"), []byte("b"))
+	ftc.CreateTestFile(t, filepath.Join(userDir, "dest", "b.txt"), []byte("taken"))
+	ftc.CreateTestFolder(t, filepath.Join(userDir, "docs"))
+
+	// One audit entry for the batch, listing what was moved
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("1", sqlmock.AnyArg(), EventFileBatch, "/home/dest", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	resp := runBatch(t, ftc, BatchRequest{
+		Operation:   BatchMove,
+		Items:       []string{"/home/a.txt", "/home/missing.txt", "/home/b.txt", "/home/docs"},
+		Destination: "/home/dest",
+	})
+
+	if resp.Succeeded != 2 || resp.Failed != 2 || len(resp.Results) != 4 {
+		t.Fatalf("response = %+v", resp)
+	}
+	want := []struct{ status, newPath string }{
+		{BatchItemOK, "/home/dest/a.txt"},
+		{BatchItemFailed, ""},
+		{BatchItemFailed, ""},
+		{BatchItemOK, "/home/dest/docs"},
+	}
+	for i, w := range want {
+		if got := resp.Results[i]; got.Status != w.status || got.NewPath != w.newPath {
+			t.Errorf("result %d = %+v", i, got)
+		}
+	}
+	if resp.Results[1].Code != ErrCodeNotFound || resp.Results[2].Code != ErrCodeAlreadyExists {
+		t.Errorf("error codes = %s, %s", resp.Results[1].Code, resp.Results[2].Code)
+	}
+	if _, err := os.Stat(filepath.Join(userDir, "dest", "docs")); err != nil {
+		t.Error("folder not moved")
+	}
+	if err := ftc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatchFileOperation_Delete(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	ftc.CreateTestFile(t, filepath.Join(userDir, "a.txt"), []byte("a"))
+	ftc.CreateTestFile(t, filepath.Join(userDir, "b.txt"), []byte("bb"))
+
+	for range 2 {
+		ftc.Mock.ExpectExec("UPDATE users SET storage_used").WillReturnResult(sqlmock.NewResult(0, 1))
+		ftc.Mock.ExpectExec("UPDATE users SET trash_used").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("1", sqlmock.AnyArg(), EventFileBatch, "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	resp := runBatch(t, ftc, BatchRequest{Operation: BatchDelete, Items: []string{"/home/a.txt", "/home/b.txt"}})
+	if resp.Succeeded != 2 || resp.Results[0].TrashID == "" || resp.Results[1].TrashID == "" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if entries, _ := os.ReadDir(userDir); len(entries) != 0 {
+		t.Errorf("items left in home: %d", len(entries))
+	}
+	if err := ftc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,510 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// IngestCamera is the value of the "ingest" upload field/metadata that routes
+// an upload into the user's camera backup folder
+const IngestCamera = "camera"
+
+const (
+	DefaultCameraBackupRoot     = "/home/Camera"
+	DefaultCameraRoutingPattern = "{yyyy}/{mm}"
+
+	// MaxCameraRoutingDepth limits how many folder levels a routing pattern may create
+	MaxCameraRoutingDepth = 3
+)
+
+// Camera ingest statuses
+const (
+	CameraIngestIngested      = "ingested"
+	CameraIngestAlreadyExists = "already_exists"
+)
+
+// Capture date sources
+const (
+	CaptureDateExif   = "exif"
+	CaptureDateClient = "client"
+	CaptureDateUpload = "upload"
+)
+
+// CameraBackupConfig is a user's camera backup routing configuration
+type CameraBackupConfig struct {
+	BackupRoot     string `json:"backupRoot"`
+	RoutingPattern string `json:"routingPattern"`
+}
+
+// CameraIngestItem is a recorded camera backup upload
+type CameraIngestItem struct {
+	Path        string     `json:"path"`
+	ContentHash string     `json:"contentHash"`
+	Size        int64      `json:"size"`
+	CapturedAt  *time.Time `json:"capturedAt,omitempty"`
+	DateSource  string     `json:"dateSource"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// CameraIngestResult describes what happened to a single camera upload
+type CameraIngestResult struct {
+	Status     string    `json:"status"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	CapturedAt time.Time `json:"capturedAt"`
+	DateSource string    `json:"dateSource"`
+	realPath   string
+}
+
+// loadCameraBackupConfig returns the user's camera backup config, or the defaults
+func loadCameraBackupConfig(db *sql.DB, userID string) (CameraBackupConfig, error) {
+	cfg := CameraBackupConfig{
+		BackupRoot:     DefaultCameraBackupRoot,
+		RoutingPattern: DefaultCameraRoutingPattern,
+	}
+	err := db.QueryRow(`
+		SELECT backup_root, routing_pattern FROM camera_backup_settings WHERE user_id = $1
+	`, userID).Scan(&cfg.BackupRoot, &cfg.RoutingPattern)
+	if err != nil && err != sql.ErrNoRows {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// validateCameraBackupConfig normalizes the config and checks that the backup
+// root is inside the user's home folder and the pattern yields valid folder names
+func validateCameraBackupConfig(cfg *CameraBackupConfig) error {
+	root, err := validateAndCleanPath(cfg.BackupRoot)
+	if err != nil {
+		return fmt.Errorf("invalid backup root: %w", err)
+	}
+	if root != "/home" && !strings.HasPrefix(root, "/home/") {
+		return fmt.Errorf("backup root must be inside /home")
+	}
+	cfg.BackupRoot = root
+
+	pattern := strings.Trim(strings.TrimSpace(cfg.RoutingPattern), "/")
+	if pattern == "" {
+		cfg.RoutingPattern = ""
+		return nil
+	}
+	segments := strings.Split(pattern, "/")
+	if len(segments) > MaxCameraRoutingDepth {
+		return fmt.Errorf("routing pattern may have at most %d levels", MaxCameraRoutingDepth)
+	}
+	sample := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+	for _, segment := range segments {
+		if err := ValidateFolderName(expandRoutingPattern(segment, sample)); err != nil {
+			return fmt.Errorf("invalid routing pattern segment %q: %w", segment, err)
+		}
+	}
+	cfg.RoutingPattern = pattern
+	return nil
+}
+
+// expandRoutingPattern replaces {yyyy}, {mm} and {dd} with the given date
+func expandRoutingPattern(pattern string, t time.Time) string {
+	return strings.NewReplacer(
+		"{yyyy}", fmt.Sprintf("%04d", t.Year()),
+		"{mm}", fmt.Sprintf("%02d", int(t.Month())),
+		"{dd}", fmt.Sprintf("%02d", t.Day()),
+	).Replace(pattern)
+}
+
+// homeRealPath maps a /home virtual path to the user's real directory
+func homeRealPath(dataRoot, username, virtualPath string) string {
+	return filepath.Join(dataRoot, "users", username, strings.TrimPrefix(virtualPath, "/home"))
+}
+
+// resolveCaptureTime picks the capture date for a camera upload: EXIF first,
+// then a client-supplied value (RFC3339 or unix milliseconds), then upload time
+func resolveCaptureTime(realPath, clientValue string, uploadedAt time.Time) (time.Time, string) {
+	if t, ok := readExifCaptureTime(realPath); ok {
+		return t, CaptureDateExif
+	}
+	if clientValue != "" {
+		if t, err := time.Parse(time.RFC3339, clientValue); err == nil {
+			return t, CaptureDateClient
+		}
+		if ms, err := strconv.ParseInt(clientValue, 10, 64); err == nil && ms > 0 {
+			return time.UnixMilli(ms), CaptureDateClient
+		}
+	}
+	return uploadedAt, CaptureDateUpload
+}
+
+// hashFileSHA256 returns the hex SHA-256 of a file
+func hashFileSHA256(realPath string) (string, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// findCameraDuplicate looks for a file with the same content in the user's
+// backup root. Previously ingested items are checked first; otherwise files of
+// the same size under the backup root are hashed (covers files copied in via SMB).
+func findCameraDuplicate(db *sql.DB, dataRoot, userID, username string, cfg CameraBackupConfig, hash string, size int64) (string, bool) {
+	rows, err := db.Query(`
+		SELECT path FROM camera_ingest_items
+		WHERE user_id = $1 AND content_hash = $2 AND status = $3
+		ORDER BY created_at DESC
+	`, userID, hash, CameraIngestIngested)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var virtualPath string
+			if rows.Scan(&virtualPath) != nil {
+				continue
+			}
+			if info, err := os.Stat(homeRealPath(dataRoot, username, virtualPath)); err == nil && info.Size() == size {
+				return virtualPath, true
+			}
+		}
+	}
+
+	rootReal := homeRealPath(dataRoot, username, cfg.BackupRoot)
+	var found string
+	_ = filepath.WalkDir(rootReal, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() != size {
+			return nil
+		}
+		if existing, err := hashFileSHA256(p); err == nil && existing == hash {
+			rel, _ := filepath.Rel(rootReal, p)
+			found = path.Join(cfg.BackupRoot, filepath.ToSlash(rel))
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found, found != ""
+}
+
+// ingestCameraFile moves a fully received upload into the user's camera backup
+// folder, routed by capture date. If the same content already exists in the
+// backup root the source file is removed and the existing path is reported.
+func ingestCameraFile(db *sql.DB, dataRoot, userID, username, srcPath, filename, clientCapturedAt string) (*CameraIngestResult, error) {
+	cfg, err := loadCameraBackupConfig(db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load camera backup config: %w", err)
+	}
+
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashFileSHA256(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash upload: %w", err)
+	}
+
+	capturedAt, dateSource := resolveCaptureTime(srcPath, clientCapturedAt, time.Now())
+	result := &CameraIngestResult{
+		Size:       info.Size(),
+		CapturedAt: capturedAt,
+		DateSource: dateSource,
+	}
+
+	if existing, ok := findCameraDuplicate(db, dataRoot, userID, username, cfg, hash, info.Size()); ok {
+		os.Remove(srcPath)
+		result.Status = CameraIngestAlreadyExists
+		result.Path = existing
+	} else {
+		targetDir := path.Join(cfg.BackupRoot, expandRoutingPattern(cfg.RoutingPattern, capturedAt))
+		realDir := homeRealPath(dataRoot, username, targetDir)
+		if !isPathWithinRoot(realDir, filepath.Join(dataRoot, "users", username)) {
+			return nil, fmt.Errorf("backup folder escapes home directory")
+		}
+		if err := os.MkdirAll(realDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create backup folder: %w", err)
+		}
+
+		finalPath := GenerateUniquePath(realDir, filename, false, false)
+		tracker := GetWebUploadTracker()
+		tracker.MarkUploading(finalPath)
+		if err := finalizeUpload(srcPath, finalPath); err != nil {
+			tracker.UnmarkUploading(finalPath)
+			return nil, fmt.Errorf("failed to move upload: %w", err)
+		}
+		_ = SealPath(finalPath)
+		go func() {
+			time.Sleep(10 * time.Second)
+			tracker.UnmarkUploading(finalPath)
+		}()
+
+		result.Status = CameraIngestIngested
+		result.Path = path.Join(targetDir, filepath.Base(finalPath))
+		result.realPath = finalPath
+		GetChangeJournal().Record(ChangeCreate, finalPath, "", userID)
+		GetListingVersions().Invalidate(finalPath)
+	}
+
+	var capturedArg interface{} = capturedAt
+	if dateSource == CaptureDateUpload {
+		capturedArg = nil
+	}
+	if _, err := db.Exec(`
+		INSERT INTO camera_ingest_items (user_id, path, content_hash, size, captured_at, date_source, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, userID, result.Path, hash, result.Size, capturedArg, dateSource, result.Status); err != nil {
+		LogError("Failed to record camera ingest item", err, "path", result.Path)
+	}
+
+	return result, nil
+}
+
+// readExifCaptureTime extracts DateTimeOriginal (falling back to DateTime) from
+// a JPEG or TIFF-based image (including most RAW formats)
+func readExifCaptureTime(realPath string) (time.Time, bool) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+
+	// EXIF lives near the start of the file
+	buf := make([]byte, 256*1024)
+	n, _ := io.ReadFull(f, buf)
+	buf = buf[:n]
+
+	var tiff []byte
+	switch {
+	case len(buf) >= 4 && buf[0] == 0xFF && buf[1] == 0xD8:
+		tiff = findJPEGExif(buf)
+	case bytes.HasPrefix(buf, []byte("II*\x00")) || bytes.HasPrefix(buf, []byte("MM\x00*")):
+		tiff = buf
+	}
+	if tiff == nil {
+		return time.Time{}, false
+	}
+
+	value, ok := parseTIFFDateTime(tiff)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, time.Local)
+	if err != nil || t.Year() < 1900 {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// findJPEGExif returns the TIFF block of the APP1 Exif segment
+func findJPEGExif(buf []byte) []byte {
+	pos := 2
+	for pos+4 <= len(buf) {
+		if buf[pos] != 0xFF {
+			return nil
+		}
+		marker := buf[pos+1]
+		if marker == 0xD9 || marker == 0xDA { // EOI / start of scan
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(buf[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(buf) {
+			return nil
+		}
+		segment := buf[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		pos += 2 + length
+	}
+	return nil
+}
+
+// parseTIFFDateTime walks IFD0 and the Exif sub-IFD looking for capture dates
+func parseTIFFDateTime(tiff []byte) (string, bool) {
+	if len(tiff) < 8 {
+		return "", false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return "", false
+	}
+
+	const (
+		tagDateTime         = 0x0132
+		tagExifIFDPointer   = 0x8769
+		tagDateTimeOriginal = 0x9003
+	)
+
+	readIFD := func(offset uint32) map[uint16][]byte {
+		entries := make(map[uint16][]byte)
+		if int(offset)+2 > len(tiff) {
+			return entries
+		}
+		count := int(order.Uint16(tiff[offset:]))
+		for i := 0; i < count; i++ {
+			start := int(offset) + 2 + i*12
+			if start+12 > len(tiff) {
+				break
+			}
+			entry := tiff[start : start+12]
+			tag := order.Uint16(entry[0:2])
+			typ := order.Uint16(entry[2:4])
+			n := order.Uint32(entry[4:8])
+			switch typ {
+			case 2: // ASCII
+				if n <= 4 {
+					entries[tag] = entry[8 : 8+n]
+				} else if off := order.Uint32(entry[8:12]); int(off)+int(n) <= len(tiff) {
+					entries[tag] = tiff[off : off+n]
+				}
+			case 4, 13: // LONG / IFD
+				entries[tag] = entry[8:12]
+			}
+		}
+		return entries
+	}
+
+	asString := func(b []byte) string {
+		return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+	}
+
+	ifd0 := readIFD(order.Uint32(tiff[4:8]))
+	if ptr, ok := ifd0[tagExifIFDPointer]; ok {
+		exif := readIFD(order.Uint32(ptr))
+		if v, ok := exif[tagDateTimeOriginal]; ok && asString(v) != "" {
+			return asString(v), true
+		}
+	}
+	if v, ok := ifd0[tagDateTime]; ok && asString(v) != "" {
+		return asString(v), true
+	}
+	return "", false
+}
+
+// GetCameraBackup returns the camera backup routing config and recent ingests
+// @Summary		Get camera backup configuration
+// @Description	Returns the user's camera backup routing configuration and recently ingested items
+// @Tags		CameraBackup
+// @Produce		json
+// @Param		limit	query		int	false	"Number of recent items (default 50, max 500)"
+// @Success		200		{object}	docs.SuccessResponse	"Camera backup configuration"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/camera-backup [get]
+func (h *Handler) GetCameraBackup(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadCameraBackupConfig(h.db, claims.UserID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load camera backup config", err))
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	rows, err := h.db.Query(`
+		SELECT path, content_hash, size, captured_at, date_source, status, created_at
+		FROM camera_ingest_items
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, claims.UserID, limit)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load camera backup items", err))
+	}
+	defer rows.Close()
+
+	items := []CameraIngestItem{}
+	for rows.Next() {
+		var item CameraIngestItem
+		var capturedAt sql.NullTime
+		if err := rows.Scan(&item.Path, &item.ContentHash, &item.Size, &capturedAt, &item.DateSource, &item.Status, &item.CreatedAt); err != nil {
+			continue
+		}
+		if capturedAt.Valid {
+			item.CapturedAt = &capturedAt.Time
+		}
+		items = append(items, item)
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"config": cfg,
+		"items":  items,
+	})
+}
+
+// UpdateCameraBackup updates the camera backup routing config
+// @Summary		Update camera backup configuration
+// @Description	Sets the backup root (inside /home) and the {yyyy}/{mm}/{dd} routing pattern
+// @Tags		CameraBackup
+// @Accept		json
+// @Produce		json
+// @Param		request	body		CameraBackupConfig	true	"Camera backup configuration"
+// @Success		200		{object}	docs.SuccessResponse	"Updated configuration"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/camera-backup [put]
+func (h *Handler) UpdateCameraBackup(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	var cfg CameraBackupConfig
+	if err := c.Bind(&cfg); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if cfg.BackupRoot == "" {
+		cfg.BackupRoot = DefaultCameraBackupRoot
+	}
+	if err := validateCameraBackupConfig(&cfg); err != nil {
+		return RespondError(c, ErrBadRequest(err.Error()))
+	}
+
+	_, err = h.db.Exec(`
+		INSERT INTO camera_backup_settings (user_id, backup_root, routing_pattern, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET backup_root = EXCLUDED.backup_root,
+		    routing_pattern = EXCLUDED.routing_pattern,
+		    updated_at = NOW()
+	`, claims.UserID, cfg.BackupRoot, cfg.RoutingPattern)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("save camera backup config", err))
+	}
+
+	return RespondSuccess(c, cfg)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandRoutingPattern(t *testing.T) {
+	date := time.Date(2023, 7, 4, 12, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"{yyyy}/{mm}":      "2023/07",
+		"{yyyy}/{mm}/{dd}": "2023/07/04",
+		"{yyyy}-{mm}":      "2023-07",
+		"":                 "",
+	}
+	for pattern, want := range tests {
+		if got := expandRoutingPattern(pattern, date); got != want {
+			t.Errorf("expandRoutingPattern(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestValidateCameraBackupConfig(t *testing.T) {
+	tests := []struct {
+		root    string
+		pattern string
+		wantErr bool
+	}{
+		{"/home/Camera", "{yyyy}/{mm}", false},
+		{"/home/Camera/", "/{yyyy}/", false},
+		{"/home", "", false},
+		{"/shared/Team", "{yyyy}", true},
+		{"/home/../etc", "{yyyy}", true},
+		{"/home/Camera", "{yyyy}/{mm}/{dd}/x", true},
+		{"/home/Camera", "{yyyy}/..", true},
+	}
+	for _, tt := range tests {
+		cfg := CameraBackupConfig{BackupRoot: tt.root, RoutingPattern: tt.pattern}
+		err := validateCameraBackupConfig(&cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateCameraBackupConfig(%q, %q) error = %v, wantErr %v", tt.root, tt.pattern, err, tt.wantErr)
+		}
+	}
+}
+
+// buildTestTIFF builds a little-endian TIFF block with an Exif sub-IFD
+// containing DateTimeOriginal
+func buildTestTIFF(dateTime string) []byte {
+	le := binary.LittleEndian
+	buf := make([]byte, 0, 128)
+	buf = append(buf, 'I', 'I', 0x2A, 0x00)
+	buf = le.AppendUint32(buf, 8)
+
+	// IFD0 at 8: one entry (ExifIFD pointer), next IFD = 0
+	exifIFD := uint32(8 + 2 + 12 + 4)
+	buf = le.AppendUint16(buf, 1)
+	buf = le.AppendUint16(buf, 0x8769)
+	buf = le.AppendUint16(buf, 4)
+	buf = le.AppendUint32(buf, 1)
+	buf = le.AppendUint32(buf, exifIFD)
+	buf = le.AppendUint32(buf, 0)
+
+	// Exif IFD: one entry (DateTimeOriginal, ASCII)
+	value := append([]byte(dateTime), 0)
+	valueOffset := exifIFD + 2 + 12 + 4
+	buf = le.AppendUint16(buf, 1)
+	buf = le.AppendUint16(buf, 0x9003)
+	buf = le.AppendUint16(buf, 2)
+	buf = le.AppendUint32(buf, uint32(len(value)))
+	buf = le.AppendUint32(buf, valueOffset)
+	buf = le.AppendUint32(buf, 0)
+	return append(buf, value...)
+}
+
+func TestReadExifCaptureTime(t *testing.T) {
+	tiff := buildTestTIFF("2021:03:15 08:30:00")
+
+	// Wrap in a minimal JPEG: SOI, APP1 Exif, EOI
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	jpeg = append(jpeg, 0xFF, 0xD9)
+
+	dir := t.TempDir()
+	jpegPath := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(jpegPath, jpeg, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := readExifCaptureTime(jpegPath)
+	if !ok {
+		t.Fatal("expected EXIF capture time")
+	}
+	want := time.Date(2021, 3, 15, 8, 30, 0, 0, time.Local)
+	if !got.Equal(want) {
+		t.Errorf("readExifCaptureTime = %v, want %v", got, want)
+	}
+
+	plainPath := filepath.Join(dir, "clip.mp4")
+	if err := os.WriteFile(plainPath, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readExifCaptureTime(plainPath); ok {
+		t.Error("expected no EXIF capture time for non-image")
+	}
+
+	uploadedAt := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if _, source := resolveCaptureTime(plainPath, "2022-05-06T10:00:00Z", uploadedAt); source != CaptureDateClient {
+		t.Errorf("expected client date source, got %s", source)
+	}
+	if got, source := resolveCaptureTime(plainPath, "", uploadedAt); source != CaptureDateUpload || !got.Equal(uploadedAt) {
+		t.Errorf("expected upload time fallback, got %v (%s)", got, source)
+	}
+}
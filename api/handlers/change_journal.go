@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Change journal entry types
+const (
+	ChangeCreate = "create"
+	ChangeModify = "modify"
+	ChangeDelete = "delete"
+	ChangeRename = "rename"
+)
+
+const (
+	// changeWatcherSuppressWindow is how long watcher events are ignored for a
+	// path the API has just journaled, so one mutation is not recorded twice
+	changeWatcherSuppressWindow = 5 * time.Second
+	defaultChangesLimit         = 1000
+	maxChangesLimit             = 5000
+)
+
+// ChangeJournal records file mutations in the file_changes table so sync
+// clients can fetch everything that changed since their last cursor
+type ChangeJournal struct {
+	db       *sql.DB
+	dataRoot string
+	mu       sync.Mutex
+	suppress map[string]time.Time
+}
+
+// ChangeEntry is a journal row as returned to clients
+type ChangeEntry struct {
+	Seq       int64      `json:"seq"`
+	Type      string     `json:"type"`
+	Path      string     `json:"path"`
+	OldPath   string     `json:"oldPath,omitempty"`
+	IsDir     bool       `json:"isDir"`
+	Size      int64      `json:"size"`
+	ModTime   *time.Time `json:"mtime,omitempty"`
+	Actor     string     `json:"actor,omitempty"`
+	ChangedAt time.Time  `json:"changedAt"`
+}
+
+var globalChangeJournal *ChangeJournal
+
+// InitChangeJournal creates the global change journal
+func InitChangeJournal(db *sql.DB, dataRoot string) *ChangeJournal {
+	globalChangeJournal = &ChangeJournal{
+		db:       db,
+		dataRoot: dataRoot,
+		suppress: make(map[string]time.Time),
+	}
+	return globalChangeJournal
+}
+
+// GetChangeJournal returns the global change journal (nil if not initialized)
+func GetChangeJournal() *ChangeJournal {
+	return globalChangeJournal
+}
+
+// relPath converts a real path to the data-root relative key used in file_changes
+func (j *ChangeJournal) relPath(realPath string) (string, bool) {
+	rel, err := filepath.Rel(j.dataRoot, realPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "users/") && !strings.HasPrefix(rel, "shared/") {
+		return "", false
+	}
+	return rel, true
+}
+
+// Record journals a mutation made through the API. oldRealPath is only set
+// for renames and moves; actorID may be empty.
+func (j *ChangeJournal) Record(changeType, realPath, oldRealPath, actorID string) {
+	if j == nil {
+		return
+	}
+	rel, ok := j.relPath(realPath)
+	if !ok {
+		return
+	}
+	var oldRel string
+	if oldRealPath != "" {
+		if oldRel, ok = j.relPath(oldRealPath); !ok {
+			// Moved in from outside the journaled tree
+			changeType = ChangeCreate
+		}
+	}
+
+	j.mu.Lock()
+	now := time.Now()
+	for key, at := range j.suppress {
+		if now.Sub(at) > changeWatcherSuppressWindow {
+			delete(j.suppress, key)
+		}
+	}
+	j.suppress[rel] = now
+	if oldRel != "" {
+		j.suppress[oldRel] = now
+	}
+	j.mu.Unlock()
+
+	j.insert(changeType, realPath, rel, oldRel, actorID, "api")
+}
+
+// RecordWatcherEvent journals a change seen by the file watcher (SMB or other
+// direct disk access). Events for paths the API just journaled are skipped.
+// fsnotify reports a rename on the old name and a create on the new one, so
+// watcher renames are journaled as deletions.
+func (j *ChangeJournal) RecordWatcherEvent(fsPath, eventType string) {
+	if j == nil {
+		return
+	}
+	rel, ok := j.relPath(fsPath)
+	if !ok || j.isSuppressed(rel) {
+		return
+	}
+
+	changeType := ChangeCreate
+	switch eventType {
+	case "write":
+		changeType = ChangeModify
+	case "remove", "rename":
+		changeType = ChangeDelete
+	}
+	j.insert(changeType, fsPath, rel, "", "", "watcher")
+}
+
+func (j *ChangeJournal) isSuppressed(rel string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for key, at := range j.suppress {
+		if now.Sub(at) > changeWatcherSuppressWindow {
+			continue
+		}
+		if rel == key || strings.HasPrefix(rel, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *ChangeJournal) insert(changeType, realPath, rel, oldRel, actorID, source string) {
+	var isDir bool
+	var size int64
+	var mtime interface{}
+	if changeType != ChangeDelete {
+		if info, err := os.Stat(realPath); err == nil {
+			isDir = info.IsDir()
+			if !isDir {
+				size = info.Size()
+			}
+			mtime = info.ModTime()
+		}
+	}
+
+	var oldPath, actor interface{}
+	if oldRel != "" {
+		oldPath = oldRel
+	}
+	if actorID != "" {
+		actor = actorID
+	}
+
+	_, err := j.db.Exec(`
+		INSERT INTO file_changes (path, old_path, change_type, is_dir, size, mtime, actor_id, source)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, rel, oldPath, changeType, isDir, size, mtime, actor, source)
+	if err != nil {
+		log.Printf("[ChangeJournal] Failed to record %s %s: %v", changeType, rel, err)
+	}
+}
+
+// Compact removes entries older than the retention window, tombstones
+// included, and remembers the highest removed seq so stale cursors can be
+// told to resync
+func (j *ChangeJournal) Compact() error {
+	if j == nil {
+		return nil
+	}
+	retentionDays := 30
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		retentionDays = settings.GetChangeJournalRetentionDays()
+	}
+	if retentionDays <= 0 {
+		return nil
+	}
+
+	return WithTransaction(j.db, func(tx *sql.Tx) error {
+		var maxSeq sql.NullInt64
+		if err := tx.QueryRow(`
+			SELECT MAX(seq) FROM file_changes
+			WHERE created_at < NOW() - make_interval(days => $1)
+		`, retentionDays).Scan(&maxSeq); err != nil {
+			return err
+		}
+		if !maxSeq.Valid {
+			return nil
+		}
+		result, err := tx.Exec(`DELETE FROM file_changes WHERE seq <= $1`, maxSeq.Int64)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			UPDATE change_journal_state
+			SET compacted_seq = GREATEST(compacted_seq, $1), updated_at = NOW()
+			WHERE id = 1
+		`, maxSeq.Int64); err != nil {
+			return err
+		}
+		removed, _ := result.RowsAffected()
+		log.Printf("[ChangeJournal] Compacted %d entries up to seq %d", removed, maxSeq.Int64)
+		return nil
+	})
+}
+
+// StartCompaction compacts the journal now and then at the given interval
+func (j *ChangeJournal) StartCompaction(interval time.Duration) {
+	if j == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := j.Compact(); err != nil {
+				log.Printf("[ChangeJournal] Compaction failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// changeScope returns the data-root relative prefixes a user may see changes
+// for under the requested virtual path
+func (h *Handler) changeScope(virtualPath string, claims *JWTClaims) ([]string, *APIError) {
+	realPath, storageType, _, err := h.resolvePath(virtualPath, claims)
+	if err != nil {
+		return nil, ErrInvalidPath(err.Error())
+	}
+
+	sharedRoots := func() ([]string, *APIError) {
+		folders, err := NewPermissionChecker(h.db).ListAccessibleSharedFolders(claims.UserID)
+		if err != nil {
+			return nil, ErrInternal("Failed to list shared drives")
+		}
+		prefixes := make([]string, 0, len(folders))
+		for _, name := range folders {
+			prefixes = append(prefixes, "shared/"+sanitizeFolderName(name))
+		}
+		return prefixes, nil
+	}
+
+	switch storageType {
+	case "root":
+		prefixes, apiErr := sharedRoots()
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return append([]string{"users/" + claims.Username}, prefixes...), nil
+	case StorageHome:
+		rel, err := dataRootRel(h.dataRoot, realPath)
+		if err != nil {
+			return nil, ErrInvalidPath("Invalid path")
+		}
+		return []string{filepath.ToSlash(rel)}, nil
+	case StorageShared:
+		if ExtractSharedDriveFolderName(virtualPath) == "" {
+			return sharedRoots()
+		}
+		if !h.CanReadSharedDrive(claims.UserID, virtualPath) {
+			return nil, ErrForbidden("No permission to access this path")
+		}
+		rel, err := dataRootRel(h.dataRoot, realPath)
+		if err != nil {
+			return nil, ErrInvalidPath("Invalid path")
+		}
+		return []string{filepath.ToSlash(rel)}, nil
+	default:
+		return nil, ErrBadRequest("Changes are only available for home and shared drive paths")
+	}
+}
+
+// inChangeScope reports whether rel lies under one of the prefixes
+func inChangeScope(rel string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if rel == prefix || strings.HasPrefix(rel, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetChanges lists journaled changes under a path since a cursor
+// @Summary		List changes since a cursor
+// @Description	Returns file changes under a path in journal order with a new cursor. Omit since (or pass an expired cursor) to get fullResync=true and the current cursor.
+// @Tags		Files
+// @Produce		json
+// @Param		path	query		string	false	"Virtual path to watch (default /home)"
+// @Param		since	query		int		false	"Cursor returned by a previous call"
+// @Param		limit	query		int		false	"Maximum number of changes (default 1000, max 5000)"
+// @Success		200		{object}	docs.SuccessResponse	"Changes and next cursor"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/changes [get]
+func (h *Handler) GetChanges(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	virtualPath := c.QueryParam("path")
+	if virtualPath == "" {
+		virtualPath = "/home"
+	}
+
+	var since int64 = -1
+	if s := c.QueryParam("since"); s != "" {
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || since < 0 {
+			return RespondError(c, ErrBadRequest("Invalid cursor"))
+		}
+	}
+
+	limit := defaultChangesLimit
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxChangesLimit {
+		limit = maxChangesLimit
+	}
+
+	prefixes, apiErr := h.changeScope(virtualPath, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	var latestSeq, compactedSeq int64
+	if err := h.db.QueryRow(`
+		SELECT COALESCE((SELECT MAX(seq) FROM file_changes), 0),
+		       COALESCE((SELECT compacted_seq FROM change_journal_state WHERE id = 1), 0)
+	`).Scan(&latestSeq, &compactedSeq); err != nil {
+		return RespondError(c, ErrInternal("Failed to read change journal"))
+	}
+	latestSeq = max(latestSeq, compactedSeq)
+
+	// No cursor, a cursor from before compaction or from a reset journal: the
+	// client has to list the tree again and continue from the current cursor
+	if since < 0 || since < compactedSeq || since > latestSeq {
+		return RespondSuccess(c, map[string]interface{}{
+			"changes":    []ChangeEntry{},
+			"cursor":     latestSeq,
+			"hasMore":    false,
+			"fullResync": true,
+		})
+	}
+
+	changes := []ChangeEntry{}
+	cursor := latestSeq
+	hasMore := false
+	if len(prefixes) > 0 {
+		rows, err := h.db.Query(`
+			SELECT fc.seq, fc.path, COALESCE(fc.old_path, ''), fc.change_type, fc.is_dir, fc.size,
+			       fc.mtime, COALESCE(u.username, ''), fc.created_at
+			FROM file_changes fc
+			LEFT JOIN users u ON u.id = fc.actor_id
+			WHERE fc.seq > $1 AND fc.seq <= $2
+			  AND EXISTS (
+			      SELECT 1 FROM unnest($3::text[]) AS p(prefix)
+			      WHERE fc.path = p.prefix OR starts_with(fc.path, p.prefix || '/')
+			         OR fc.old_path = p.prefix OR starts_with(fc.old_path, p.prefix || '/')
+			  )
+			ORDER BY fc.seq
+			LIMIT $4
+		`, since, latestSeq, pq.Array(prefixes), limit+1)
+		if err != nil {
+			return RespondError(c, ErrInternal("Failed to read change journal"))
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var entry ChangeEntry
+			var rel, oldRel string
+			var mtime sql.NullTime
+			if err := rows.Scan(&entry.Seq, &rel, &oldRel, &entry.Type, &entry.IsDir, &entry.Size,
+				&mtime, &entry.Actor, &entry.ChangedAt); err != nil {
+				return RespondError(c, ErrInternal("Failed to read change journal"))
+			}
+			if len(changes) == limit {
+				hasMore = true
+				break
+			}
+			if mtime.Valid {
+				entry.ModTime = &mtime.Time
+			}
+
+			// A rename across the edge of the visible tree looks like a
+			// create or delete to this client
+			entry.Path = statsDisplayPath(rel, claims.Username)
+			if entry.Type == ChangeRename {
+				oldVisible := inChangeScope(oldRel, prefixes)
+				newVisible := inChangeScope(rel, prefixes)
+				switch {
+				case oldVisible && !newVisible:
+					entry.Type = ChangeDelete
+					entry.Path = statsDisplayPath(oldRel, claims.Username)
+					entry.Size = 0
+					entry.ModTime = nil
+				case !oldVisible:
+					entry.Type = ChangeCreate
+				default:
+					entry.OldPath = statsDisplayPath(oldRel, claims.Username)
+				}
+			}
+			changes = append(changes, entry)
+		}
+		if err := rows.Err(); err != nil {
+			return RespondError(c, ErrInternal("Failed to read change journal"))
+		}
+		if hasMore {
+			cursor = changes[len(changes)-1].Seq
+		}
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"changes":    changes,
+		"cursor":     cursor,
+		"hasMore":    hasMore,
+		"fullResync": false,
+	})
+}
+
+// changeActor returns the user ID from optional claims for journal entries
+func changeActor(claims *JWTClaims) string {
+	if claims == nil {
+		return ""
+	}
+	return claims.UserID
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInChangeScope(t *testing.T) {
+	prefixes := []string{"users/alice", "shared/Team"}
+	tests := map[string]bool{
+		"users/alice":            true,
+		"users/alice/docs/a.txt": true,
+		"users/alice2/a.txt":     false,
+		"shared/Team/plan.md":    true,
+		"shared/Teamwork/x":      false,
+		"users/bob/a.txt":        false,
+	}
+	for rel, want := range tests {
+		if got := inChangeScope(rel, prefixes); got != want {
+			t.Errorf("inChangeScope(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestChangeJournal_SuppressesWatcherEcho(t *testing.T) {
+	j := &ChangeJournal{dataRoot: "/data", suppress: make(map[string]time.Time)}
+	j.suppress["users/alice/photos"] = time.Now()
+	j.suppress["users/alice/old.txt"] = time.Now().Add(-2 * changeWatcherSuppressWindow)
+
+	if !j.isSuppressed("users/alice/photos/a.jpg") {
+		t.Error("expected event under a just-journaled folder to be suppressed")
+	}
+	if j.isSuppressed("users/alice/photos2") {
+		t.Error("sibling with a common prefix must not be suppressed")
+	}
+	if j.isSuppressed("users/alice/old.txt") {
+		t.Error("expired suppression must not apply")
+	}
+
+	if _, ok := j.relPath("/data/.uploads/abc"); ok {
+		t.Error("paths outside users/ and shared/ must not be journaled")
+	}
+	if rel, ok := j.relPath("/data/shared/Team/a.txt"); !ok || rel != "shared/Team/a.txt" {
+		t.Errorf("unexpected relPath %q %v", rel, ok)
+	}
+}
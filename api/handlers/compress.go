@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"context"
 	"encoding/json"
@@ -10,9 +11,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/labstack/echo/v4"
 )
 
@@ -22,10 +25,13 @@ var ErrCompressionCancelled = errors.New("compression cancelled")
 // CompressRequest is the request body for compressing files
 type CompressRequest struct {
 	Paths      []string `json:"paths"`      // List of file/folder paths to compress
-	OutputName string   `json:"outputName"` // Optional: output zip file name (without .zip)
+	OutputName string   `json:"outputName"` // Optional: output file name (without extension)
+	Format     string   `json:"format"`     // Optional: "zip" (default) or "tar.zst"
+	Method     string   `json:"method"`     // Optional: zip entry method, "deflate" (default) or "zstd"
+	Level      int      `json:"level"`      // Optional: codec level (deflate 1-9, zstd 1-22)
 }
 
-// CompressFiles creates a zip archive from selected files/folders
+// CompressFiles creates a zip or tar.zst archive from selected files/folders
 func (h *Handler) CompressFiles(c echo.Context) error {
 	var req CompressRequest
 	if err := c.Bind(&req); err != nil {
@@ -38,6 +44,11 @@ func (h *Handler) CompressFiles(c echo.Context) error {
 		return RespondError(c, ErrMissingParameter("paths"))
 	}
 
+	opts, apiErr := ParseArchiveOptions(req.Format, req.Method, req.Level)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
 	claims, ok := c.Get("user").(*JWTClaims)
 	if !ok || claims == nil {
 		return RespondError(c, ErrUnauthorized(""))
@@ -55,6 +66,12 @@ func (h *Handler) CompressFiles(c echo.Context) error {
 	if err != nil {
 		return RespondError(c, ErrInvalidPath(err.Error()))
 	}
+	if apiErr := mountInWriteError(parentDisplayPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(parentRealPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Generate output filename
 	outputName := req.OutputName
@@ -68,35 +85,40 @@ func (h *Handler) CompressFiles(c echo.Context) error {
 		}
 	}
 
-	// Ensure .zip extension
-	if !strings.HasSuffix(strings.ToLower(outputName), ".zip") {
-		outputName += ".zip"
+	// Ensure the archive extension
+	ext := opts.Ext()
+	if !strings.HasSuffix(strings.ToLower(outputName), ext) {
+		outputName += ext
 	}
 
 	// Check if output file already exists, add suffix if needed
 	outputPath := filepath.Join(parentRealPath, outputName)
-	baseName := strings.TrimSuffix(outputName, ".zip")
+	baseName := outputName[:len(outputName)-len(ext)]
 	counter := 1
 	for {
 		if _, err := os.Stat(outputPath); os.IsNotExist(err) {
 			break
 		}
-		outputName = fmt.Sprintf("%s (%d).zip", baseName, counter)
+		outputName = fmt.Sprintf("%s (%d)%s", baseName, counter, ext)
 		outputPath = filepath.Join(parentRealPath, outputName)
 		counter++
 	}
 
-	// Create zip file
+	// Create archive file
 	zipFile, err := os.Create(outputPath)
 	if err != nil {
-		return RespondError(c, ErrOperationFailed("create zip file", err))
+		return RespondError(c, ErrOperationFailed("create archive file", err))
 	}
 	defer zipFile.Close()
 
-	zipWriter := zip.NewWriter(zipFile)
+	zipWriter, err := newArchiveWriter(zipFile, opts)
+	if err != nil {
+		os.Remove(outputPath)
+		return RespondError(c, ErrOperationFailed("create archive file", err))
+	}
 	defer zipWriter.Close()
 
-	// Add each path to the zip
+	// Add each path to the archive
 	for _, path := range req.Paths {
 		realPath, _, _, err := h.resolvePath(path, claims)
 		if err != nil {
@@ -112,10 +134,10 @@ func (h *Handler) CompressFiles(c echo.Context) error {
 
 		if info.IsDir() {
 			// Add directory recursively
-			err = h.addDirToZip(zipWriter, realPath, baseName)
+			err = h.addDirToArchive(zipWriter, realPath, baseName)
 		} else {
 			// Add single file
-			err = h.addFileToZip(zipWriter, realPath, baseName)
+			err = h.addFileToArchive(zipWriter, realPath, baseName)
 		}
 
 		if err != nil {
@@ -124,10 +146,12 @@ func (h *Handler) CompressFiles(c echo.Context) error {
 		}
 	}
 
-	// Close zip writer to flush
+	// Close archive writer to flush
 	zipWriter.Close()
 	zipFile.Close()
 
+	_ = SealPath(outputPath)
+
 	// Get final file info
 	finalInfo, _ := os.Stat(outputPath)
 	var finalSize int64
@@ -140,6 +164,8 @@ func (h *Handler) CompressFiles(c echo.Context) error {
 		"sourceCount": len(req.Paths),
 		"sources":     req.Paths,
 		"outputSize":  finalSize,
+		"format":      opts.Format,
+		"method":      opts.Method,
 	})
 
 	// Update storage tracking: add compressed file size
@@ -155,9 +181,9 @@ func (h *Handler) CompressFiles(c echo.Context) error {
 	})
 }
 
-// addFileToZip adds a single file to the zip archive
-func (h *Handler) addFileToZip(zipWriter *zip.Writer, filePath, zipPath string) error {
-	file, err := os.Open(filePath)
+// addFileToArchive adds a single file to the archive
+func (h *Handler) addFileToArchive(zipWriter archiveWriter, filePath, zipPath string) error {
+	file, err := OpenPlain(filePath)
 	if err != nil {
 		return err
 	}
@@ -168,14 +194,7 @@ func (h *Handler) addFileToZip(zipWriter *zip.Writer, filePath, zipPath string)
 		return err
 	}
 
-	header, err := zip.FileInfoHeader(info)
-	if err != nil {
-		return err
-	}
-	header.Name = zipPath
-	header.Method = zip.Deflate
-
-	writer, err := zipWriter.CreateHeader(header)
+	writer, err := zipWriter.CreateFile(zipPath, info)
 	if err != nil {
 		return err
 	}
@@ -184,8 +203,8 @@ func (h *Handler) addFileToZip(zipWriter *zip.Writer, filePath, zipPath string)
 	return err
 }
 
-// addDirToZip adds a directory recursively to the zip archive
-func (h *Handler) addDirToZip(zipWriter *zip.Writer, dirPath, zipBasePath string) error {
+// addDirToArchive adds a directory recursively to the archive
+func (h *Handler) addDirToArchive(zipWriter archiveWriter, dirPath, zipBasePath string) error {
 	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip errors
@@ -210,22 +229,21 @@ func (h *Handler) addDirToZip(zipWriter *zip.Writer, dirPath, zipBasePath string
 
 		if info.IsDir() {
 			// Add directory entry
-			_, err := zipWriter.Create(zipPath + "/")
-			return err
+			return zipWriter.CreateDir(zipPath, info)
 		}
 
 		// Add file
-		return h.addFileToZip(zipWriter, path, zipPath)
+		return h.addFileToArchive(zipWriter, path, zipPath)
 	})
 }
 
 // ExtractRequest is the request body for extracting zip files
 type ExtractRequest struct {
-	Path       string `json:"path"`       // Path to the zip file
+	Path       string `json:"path"`       // Path to the archive (.zip, .tar.zst, .tzst or .zst)
 	OutputPath string `json:"outputPath"` // Optional: where to extract (defaults to same directory as zip)
 }
 
-// ExtractZip extracts a zip archive
+// ExtractZip extracts a zip, tar.zst or single-file zst archive
 func (h *Handler) ExtractZip(c echo.Context) error {
 	var req ExtractRequest
 	if err := c.Bind(&req); err != nil {
@@ -248,14 +266,15 @@ func (h *Handler) ExtractZip(c echo.Context) error {
 		return RespondError(c, ErrForbidden(err.Error()))
 	}
 
-	// Check if it's a zip file
-	if !strings.HasSuffix(strings.ToLower(req.Path), ".zip") {
-		return RespondError(c, ErrBadRequest("Only .zip files can be extracted"))
+	// Check if it's a supported archive
+	kind, archiveBase := extractKind(filepath.Base(req.Path))
+	if kind == "" {
+		return RespondError(c, ErrBadRequest("Only .zip, .tar.zst and .zst files can be extracted"))
 	}
 
 	// Check if file exists
 	if _, err := os.Stat(realZipPath); os.IsNotExist(err) {
-		return RespondError(c, ErrNotFound("Zip file not found"))
+		return RespondError(c, ErrNotFound("Archive file not found"))
 	}
 
 	// Determine output directory
@@ -267,48 +286,121 @@ func (h *Handler) ExtractZip(c echo.Context) error {
 			return RespondError(c, ErrForbidden(err.Error()))
 		}
 	} else {
-		// Extract to the same directory as the zip file
+		// Extract to the same directory as the archive
 		outputDir = filepath.Dir(realZipPath)
 		outputDisplayPath = filepath.Dir(displayPath)
 	}
+	if apiErr := mountInWriteError(outputDisplayPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(outputDir); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	// Refuse above the output folder's entry limit; warn above the threshold
+	entryWarning, apiErr := CheckDirEntries(outputDir, outputDisplayPath, 1, dirEntryOverride(c, claims))
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
-	// Create a folder with the zip file name (without extension)
-	zipBaseName := strings.TrimSuffix(filepath.Base(req.Path), ".zip")
-	extractDir := filepath.Join(outputDir, zipBaseName)
-	extractDisplayPath := filepath.Join(outputDisplayPath, zipBaseName)
+	// Archives extract into a folder named after them; a single .zst file
+	// is decompressed next to it
+	extractDir := filepath.Join(outputDir, archiveBase)
+	extractDisplayPath := filepath.Join(outputDisplayPath, archiveBase)
 
-	// If folder already exists, add a number suffix
-	originalExtractDir := extractDir
-	originalExtractDisplayPath := extractDisplayPath
+	// If the target already exists, add a number suffix
+	suffixExt := ""
+	if kind == extractKindZst {
+		suffixExt = filepath.Ext(archiveBase)
+	}
+	originalExtractDir := strings.TrimSuffix(extractDir, suffixExt)
+	originalExtractDisplayPath := strings.TrimSuffix(extractDisplayPath, suffixExt)
 	counter := 1
 	for {
 		if _, err := os.Stat(extractDir); os.IsNotExist(err) {
 			break
 		}
-		extractDir = fmt.Sprintf("%s_%d", originalExtractDir, counter)
-		extractDisplayPath = fmt.Sprintf("%s_%d", originalExtractDisplayPath, counter)
+		extractDir = fmt.Sprintf("%s_%d%s", originalExtractDir, counter, suffixExt)
+		extractDisplayPath = fmt.Sprintf("%s_%d%s", originalExtractDisplayPath, counter, suffixExt)
 		counter++
 	}
 
-	// Create extract directory
-	if err := os.MkdirAll(extractDir, 0755); err != nil {
-		return RespondError(c, ErrInternal("Failed to create extraction directory"))
+	var extractedCount int
+	switch kind {
+	case extractKindZst:
+		extractedCount, apiErr = h.extractZstFile(c, realZipPath, displayPath, extractDir)
+	default:
+		// Create extract directory
+		if err := os.MkdirAll(extractDir, 0755); err != nil {
+			return RespondError(c, ErrInternal("Failed to create extraction directory"))
+		}
+		if kind == extractKindTarZst {
+			extractedCount, apiErr = h.extractTarZst(c, realZipPath, displayPath, extractDir)
+		} else {
+			extractedCount, apiErr = h.extractZipArchive(c, realZipPath, displayPath, extractDir)
+		}
+	}
+	if apiErr != nil {
+		os.RemoveAll(extractDir) // Cleanup on error
+		return RespondError(c, apiErr)
+	}
+
+	_ = SealPath(extractDir)
+	GetDirEntryLimits().Added(outputDir, 1)
+
+	// Calculate extracted size for storage tracking
+	extractedSize, _ := GetFileSize(extractDir)
+
+	// Log audit event
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), "file.extract", displayPath, map[string]interface{}{
+		"extractedTo":    extractDisplayPath,
+		"extractedCount": extractedCount,
+		"extractedSize":  extractedSize,
+	})
+
+	// Update storage tracking: add extracted files size
+	if extractedSize > 0 {
+		_ = h.UpdateUserStorage(claims.UserID, extractedSize)
+	}
+
+	response := map[string]interface{}{
+		"success":        true,
+		"extractedPath":  extractDisplayPath,
+		"extractedCount": extractedCount,
+	}
+	if entryWarning != nil {
+		response["entryWarning"] = entryWarning
 	}
+	return c.JSON(http.StatusOK, response)
+}
 
+// extractZipArchive extracts a zip archive into extractDir
+func (h *Handler) extractZipArchive(c echo.Context, realPath, displayPath, extractDir string) (int, *APIError) {
 	// Open the zip file
-	reader, err := zip.OpenReader(realZipPath)
+	reader, zipFile, err := openPlainZip(realPath)
 	if err != nil {
-		os.RemoveAll(extractDir) // Cleanup on error
-		return RespondError(c, ErrInternal("Failed to open zip file"))
+		return 0, ErrInternal("Failed to open zip file")
+	}
+	defer zipFile.Close()
+
+	var totalSize int64
+	for _, file := range reader.File {
+		totalSize += int64(file.UncompressedSize64)
 	}
-	defer reader.Close()
+	activity := TrackJob(c, ActivityExtract, displayPath, totalSize)
+	defer activity.Finish()
 
 	// Extract files
 	var extractedCount int
 	for _, file := range reader.File {
+		if err := activity.Pace(); err != nil {
+			return 0, ErrOperationFailed("extract archive", err)
+		}
+		activity.AddBytes(int64(file.UncompressedSize64))
+
 		// Sanitize the file path to prevent zip slip attacks
-		destPath := filepath.Join(extractDir, file.Name)
-		if !strings.HasPrefix(destPath, filepath.Clean(extractDir)+string(os.PathSeparator)) {
+		destPath, ok := extractDestPath(extractDir, file.Name)
+		if !ok {
 			continue // Skip files that would extract outside the target directory
 		}
 
@@ -328,27 +420,141 @@ func (h *Handler) ExtractZip(c echo.Context) error {
 		}
 		extractedCount++
 	}
+	return extractedCount, nil
+}
 
-	// Calculate extracted size for storage tracking
-	extractedSize, _ := GetFileSize(extractDir)
+// extractDestPath joins an archive entry name to extractDir, rejecting names
+// that would land outside it
+func extractDestPath(extractDir, name string) (string, bool) {
+	destPath := filepath.Join(extractDir, name)
+	if !strings.HasPrefix(destPath, filepath.Clean(extractDir)+string(os.PathSeparator)) {
+		return "", false
+	}
+	return destPath, true
+}
 
-	// Log audit event
-	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), "file.extract", displayPath, map[string]interface{}{
-		"extractedTo":    extractDisplayPath,
-		"extractedCount": extractedCount,
-		"extractedSize":  extractedSize,
-	})
+// activityReader counts bytes read from an archive as job progress and stops
+// reading once the job is cancelled
+type activityReader struct {
+	r        io.Reader
+	activity *Activity
+}
 
-	// Update storage tracking: add extracted files size
-	if extractedSize > 0 {
-		_ = h.UpdateUserStorage(claims.UserID, extractedSize)
+func (a *activityReader) Read(p []byte) (int, error) {
+	if err := a.activity.Pace(); err != nil {
+		return 0, err
 	}
+	n, err := a.r.Read(p)
+	a.activity.AddBytes(int64(n))
+	return n, err
+}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"success":        true,
-		"extractedPath":  extractDisplayPath,
-		"extractedCount": extractedCount,
-	})
+// openZstdArchive opens a zstd-compressed file for streaming decompression.
+// Progress is reported in compressed bytes, since the uncompressed size is
+// not known up front.
+func openZstdArchive(c echo.Context, realPath, displayPath string) (*zstd.Decoder, PlainFile, *Activity, *APIError) {
+	file, err := OpenPlain(realPath)
+	if err != nil {
+		return nil, nil, nil, ErrInternal("Failed to open archive file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, ErrInternal("Failed to open archive file")
+	}
+	activity := TrackJob(c, ActivityExtract, displayPath, info.Size())
+	dec, err := newZstdReader(&activityReader{r: file, activity: activity})
+	if err != nil {
+		activity.Finish()
+		file.Close()
+		return nil, nil, nil, ErrInternal("Failed to open archive file")
+	}
+	return dec, file, activity, nil
+}
+
+// extractTarZst extracts a zstd-compressed tar archive into extractDir.
+// Only directories and regular files are extracted.
+func (h *Handler) extractTarZst(c echo.Context, realPath, displayPath, extractDir string) (int, *APIError) {
+	dec, file, activity, apiErr := openZstdArchive(c, realPath, displayPath)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	defer file.Close()
+	defer dec.Close()
+	defer activity.Finish()
+
+	tr := tar.NewReader(dec)
+	var extractedCount int
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if activityErr := activity.Err(); activityErr != nil {
+				err = activityErr
+			}
+			return 0, ErrOperationFailed("extract archive", err)
+		}
+
+		destPath, ok := extractDestPath(extractDir, header.Name)
+		if !ok {
+			continue // Skip files that would extract outside the target directory
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			_ = os.MkdirAll(destPath, 0755)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				continue
+			}
+			if err := writeExtractedFile(destPath, tr, os.FileMode(header.Mode).Perm()); err != nil {
+				if activityErr := activity.Err(); activityErr != nil {
+					return 0, ErrOperationFailed("extract archive", activityErr)
+				}
+				// A short entry means the stream is corrupt; later entries cannot be read
+				return 0, ErrOperationFailed("extract archive", err)
+			}
+			extractedCount++
+		}
+	}
+	return extractedCount, nil
+}
+
+// extractZstFile decompresses a single .zst file to destPath
+func (h *Handler) extractZstFile(c echo.Context, realPath, displayPath, destPath string) (int, *APIError) {
+	dec, file, activity, apiErr := openZstdArchive(c, realPath, displayPath)
+	if apiErr != nil {
+		return 0, apiErr
+	}
+	defer file.Close()
+	defer dec.Close()
+	defer activity.Finish()
+
+	if err := writeExtractedFile(destPath, dec, 0644); err != nil {
+		if activityErr := activity.Err(); activityErr != nil {
+			err = activityErr
+		}
+		return 0, ErrOperationFailed("extract archive", err)
+	}
+	return 1, nil
+}
+
+// writeExtractedFile writes an extracted entry to destPath
+func writeExtractedFile(destPath string, r io.Reader, mode os.FileMode) error {
+	if err := copyOnWrite(destPath, false); err != nil {
+		return err
+	}
+	destFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, r); err != nil {
+		destFile.Close()
+		return err
+	}
+	return destFile.Close()
 }
 
 // extractZipFile extracts a single file from the zip archive
@@ -359,6 +565,9 @@ func (h *Handler) extractZipFile(file *zip.File, destPath string) error {
 	}
 	defer rc.Close()
 
+	if err := copyOnWrite(destPath, false); err != nil {
+		return err
+	}
 	destFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
 	if err != nil {
 		return err
@@ -412,6 +621,7 @@ type CompressionContext struct {
 	StartTime        time.Time
 	LastProgressTime time.Time
 	SendProgress     CompressionProgressSender
+	Activity         *Activity // Live activity entry (optional)
 }
 
 // NewCompressionContext creates a new CompressionContext
@@ -440,6 +650,7 @@ func (ctx *CompressionContext) SendCompressionProgress(currentFile string) {
 	if time.Since(ctx.LastProgressTime) < 200*time.Millisecond {
 		return
 	}
+	ctx.Activity.SetBytes(ctx.CompressedBytes)
 
 	elapsed := time.Since(ctx.StartTime).Seconds()
 	var bytesPerSec int64
@@ -487,9 +698,9 @@ func (ctx *CompressionContext) SendCompressionError(err error) {
 	})
 }
 
-// addFileToZipWithProgress adds a single file to the zip archive with progress tracking
-func (h *Handler) addFileToZipWithProgress(zipWriter *zip.Writer, filePath, zipPath string, ctx *CompressionContext) error {
-	file, err := os.Open(filePath)
+// addFileToArchiveWithProgress adds a single file to the archive with progress tracking
+func (h *Handler) addFileToArchiveWithProgress(zipWriter archiveWriter, filePath, zipPath string, ctx *CompressionContext) error {
+	file, err := OpenPlain(filePath)
 	if err != nil {
 		return err
 	}
@@ -500,14 +711,7 @@ func (h *Handler) addFileToZipWithProgress(zipWriter *zip.Writer, filePath, zipP
 		return err
 	}
 
-	header, err := zip.FileInfoHeader(info)
-	if err != nil {
-		return err
-	}
-	header.Name = zipPath
-	header.Method = zip.Deflate
-
-	writer, err := zipWriter.CreateHeader(header)
+	writer, err := zipWriter.CreateFile(zipPath, info)
 	if err != nil {
 		return err
 	}
@@ -528,6 +732,9 @@ func (h *Handler) addFileToZipWithProgress(zipWriter *zip.Writer, filePath, zipP
 			}
 			ctx.CompressedBytes += int64(n)
 			ctx.SendCompressionProgress(filepath.Base(filePath))
+			if ctx.Activity.Pace() != nil {
+				return ErrCompressionCancelled
+			}
 		}
 		if readErr == io.EOF {
 			break
@@ -541,8 +748,8 @@ func (h *Handler) addFileToZipWithProgress(zipWriter *zip.Writer, filePath, zipP
 	return nil
 }
 
-// addDirToZipWithProgress adds a directory recursively to the zip archive with progress tracking
-func (h *Handler) addDirToZipWithProgress(zipWriter *zip.Writer, dirPath, zipBasePath string, ctx *CompressionContext) error {
+// addDirToArchiveWithProgress adds a directory recursively to the archive with progress tracking
+func (h *Handler) addDirToArchiveWithProgress(zipWriter archiveWriter, dirPath, zipBasePath string, ctx *CompressionContext) error {
 	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip errors
@@ -572,22 +779,24 @@ func (h *Handler) addDirToZipWithProgress(zipWriter *zip.Writer, dirPath, zipBas
 
 		if info.IsDir() {
 			// Add directory entry
-			_, err := zipWriter.Create(zipPath + "/")
-			return err
+			return zipWriter.CreateDir(zipPath, info)
 		}
 
 		// Add file with progress tracking
-		return h.addFileToZipWithProgress(zipWriter, path, zipPath, ctx)
+		return h.addFileToArchiveWithProgress(zipWriter, path, zipPath, ctx)
 	})
 }
 
-// CompressFilesStream creates a zip archive with streaming progress via SSE
+// CompressFilesStream creates a zip or tar.zst archive with streaming progress via SSE
 // @Summary		Compress files with progress
-// @Description	Compress files/folders into a zip archive with real-time progress updates via Server-Sent Events
+// @Description	Compress files/folders into a zip or tar.zst archive with real-time progress updates via Server-Sent Events
 // @Tags		Files
 // @Produce		text/event-stream
 // @Param		paths		query		string	true	"Comma-separated list of paths to compress"
-// @Param		outputName	query		string	false	"Output zip file name (without .zip extension)"
+// @Param		outputName	query		string	false	"Output file name (without extension)"
+// @Param		format		query		string	false	"Archive format: zip (default) or tar.zst"
+// @Param		method		query		string	false	"Zip entry method: deflate (default) or zstd"
+// @Param		level		query		int		false	"Codec level (deflate 1-9, zstd 1-22)"
 // @Success		200			{object}	CompressionProgress	"SSE stream with progress updates"
 // @Failure		400			{object}	docs.ErrorResponse	"Bad request"
 // @Failure		401			{object}	docs.ErrorResponse	"Unauthorized"
@@ -607,6 +816,18 @@ func (h *Handler) CompressFilesStream(c echo.Context) error {
 
 	outputName := c.QueryParam("outputName")
 
+	level := 0
+	if levelParam := c.QueryParam("level"); levelParam != "" {
+		var err error
+		if level, err = strconv.Atoi(levelParam); err != nil {
+			return RespondError(c, ErrBadRequest("Invalid level"))
+		}
+	}
+	opts, apiErr := ParseArchiveOptions(c.QueryParam("format"), c.QueryParam("method"), level)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
 	claims, ok := c.Get("user").(*JWTClaims)
 	if !ok || claims == nil {
 		return RespondError(c, ErrUnauthorized(""))
@@ -624,6 +845,12 @@ func (h *Handler) CompressFilesStream(c echo.Context) error {
 	if err != nil {
 		return RespondError(c, ErrInvalidPath(err.Error()))
 	}
+	if apiErr := mountInWriteError(parentDisplayPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(parentRealPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Generate output filename
 	if outputName == "" {
@@ -634,20 +861,21 @@ func (h *Handler) CompressFilesStream(c echo.Context) error {
 		}
 	}
 
-	// Ensure .zip extension
-	if !strings.HasSuffix(strings.ToLower(outputName), ".zip") {
-		outputName += ".zip"
+	// Ensure the archive extension
+	ext := opts.Ext()
+	if !strings.HasSuffix(strings.ToLower(outputName), ext) {
+		outputName += ext
 	}
 
 	// Check if output file already exists, add suffix if needed
 	outputPath := filepath.Join(parentRealPath, outputName)
-	baseName := strings.TrimSuffix(outputName, ".zip")
+	baseName := outputName[:len(outputName)-len(ext)]
 	counter := 1
 	for {
 		if _, err := os.Stat(outputPath); os.IsNotExist(err) {
 			break
 		}
-		outputName = fmt.Sprintf("%s (%d).zip", baseName, counter)
+		outputName = fmt.Sprintf("%s (%d)%s", baseName, counter, ext)
 		outputPath = filepath.Join(parentRealPath, outputName)
 		counter++
 	}
@@ -691,16 +919,26 @@ func (h *Handler) CompressFilesStream(c echo.Context) error {
 	})
 
 	// Create compression context with request context for cancellation
-	compCtx := NewCompressionContext(c.Request().Context(), totalBytes, totalFiles, sendProgress)
+	// (admins can also cancel it from the live activity view)
+	activity := TrackJob(c, ActivityCompress, parentDisplayPath+"/"+outputName, totalBytes)
+	defer activity.Finish()
+	compCtx := NewCompressionContext(activity.Context(), totalBytes, totalFiles, sendProgress)
+	compCtx.Activity = activity
 
-	// Create zip file
+	// Create archive file
 	zipFile, err := os.Create(outputPath)
 	if err != nil {
-		compCtx.SendCompressionError(fmt.Errorf("failed to create zip file: %w", err))
+		compCtx.SendCompressionError(fmt.Errorf("failed to create archive file: %w", err))
 		return nil
 	}
 
-	zipWriter := zip.NewWriter(zipFile)
+	zipWriter, err := newArchiveWriter(zipFile, opts)
+	if err != nil {
+		zipFile.Close()
+		os.Remove(outputPath)
+		compCtx.SendCompressionError(fmt.Errorf("failed to create archive file: %w", err))
+		return nil
+	}
 
 	// Track if compression was cancelled
 	var compressionErr error
@@ -727,10 +965,10 @@ func (h *Handler) CompressFilesStream(c echo.Context) error {
 
 		if info.IsDir() {
 			// Add directory recursively with progress
-			err = h.addDirToZipWithProgress(zipWriter, realPath, itemBaseName, compCtx)
+			err = h.addDirToArchiveWithProgress(zipWriter, realPath, itemBaseName, compCtx)
 		} else {
 			// Add single file with progress
-			err = h.addFileToZipWithProgress(zipWriter, realPath, itemBaseName, compCtx)
+			err = h.addFileToArchiveWithProgress(zipWriter, realPath, itemBaseName, compCtx)
 		}
 
 		if err != nil {
@@ -751,11 +989,13 @@ func (h *Handler) CompressFilesStream(c echo.Context) error {
 		}
 	}
 
-	// Close zip writer and file
-	zipWriter.Close()
+	// Close archive writer and file
+	if err := zipWriter.Close(); err != nil && compressionErr == nil {
+		compressionErr = err
+	}
 	zipFile.Close()
 
-	// Handle cancellation or error - delete partial zip file
+	// Handle cancellation or error - delete partial archive file
 	if compressionErr != nil {
 		os.Remove(outputPath)
 		errorMsg := "압축이 취소되었습니다"
@@ -769,6 +1009,8 @@ func (h *Handler) CompressFilesStream(c echo.Context) error {
 		return nil
 	}
 
+	_ = SealPath(outputPath)
+
 	// Get final file info
 	finalInfo, _ := os.Stat(outputPath)
 	var finalSize int64
@@ -783,6 +1025,8 @@ func (h *Handler) CompressFilesStream(c echo.Context) error {
 		"sourceCount": len(paths),
 		"sources":     paths,
 		"outputSize":  finalSize,
+		"format":      opts.Format,
+		"method":      opts.Method,
 	})
 
 	// Update storage tracking: add compressed file size
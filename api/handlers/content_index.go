@@ -0,0 +1,628 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// JobContentReindex walks the home folders and shared drives into the content index
+const JobContentReindex = "content.reindex"
+
+const (
+	// contentIndexMaxText bounds the text stored per file; PostgreSQL
+	// refuses tsvectors over 1 MB
+	contentIndexMaxText = 512 << 10
+	// defaultContentIndexMaxFileBytes is the largest file extracted unless
+	// content_index_max_file_bytes says otherwise
+	defaultContentIndexMaxFileBytes = 50 << 20
+	// contentIndexQueueSize bounds the paths waiting for the indexer; a
+	// full queue drops paths, the next reindex picks them up
+	contentIndexQueueSize = 4096
+	// contentExtractTimeout bounds one pdftotext run
+	contentExtractTimeout = time.Minute
+)
+
+// contentIndexExtensions are the file types whose text is indexed
+var contentIndexExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".csv": true, ".log": true,
+	".pdf": true, ".docx": true, ".xlsx": true,
+}
+
+// ContentIndex keeps the text of documents in file_contents for full-text
+// search. Files are indexed as the watcher and uploads report them and by
+// JobContentReindex; deletes and moves made through the API follow right away.
+type ContentIndex struct {
+	db       *sql.DB
+	dataRoot string
+	queue    chan string
+}
+
+var globalContentIndex *ContentIndex
+
+// InitContentIndex creates the global content index and starts its indexer
+func InitContentIndex(db *sql.DB, dataRoot string) *ContentIndex {
+	ci := newContentIndex(db, dataRoot)
+	globalContentIndex = ci
+	go ci.work()
+	return ci
+}
+
+func newContentIndex(db *sql.DB, dataRoot string) *ContentIndex {
+	return &ContentIndex{db: db, dataRoot: dataRoot, queue: make(chan string, contentIndexQueueSize)}
+}
+
+// GetContentIndex returns the global content index (nil if not initialized)
+func GetContentIndex() *ContentIndex {
+	return globalContentIndex
+}
+
+// Enabled reports whether content_index_enabled is on
+func (ci *ContentIndex) Enabled() bool {
+	if ci == nil {
+		return false
+	}
+	settings := GetGlobalSettingsHandler()
+	return settings != nil && settings.IsContentIndexEnabled()
+}
+
+// maxFileBytes returns the largest file whose text is indexed
+func (ci *ContentIndex) maxFileBytes() int64 {
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		return settings.GetContentIndexMaxFileBytes()
+	}
+	return defaultContentIndexMaxFileBytes
+}
+
+// rel returns the data-root relative path of a home or shared drive item,
+// "" for anything else
+func (ci *ContentIndex) rel(realPath string) string {
+	rel, err := dataRootRel(ci.dataRoot, realPath)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "users/") && !strings.HasPrefix(rel, "shared/") {
+		return ""
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if strings.HasPrefix(part, ".") {
+			return ""
+		}
+	}
+	return rel
+}
+
+// contentIndexable reports whether the text of a file type is indexed
+func contentIndexable(name string) bool {
+	return contentIndexExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// Changed queues a created or written file, or the files of a folder, for indexing
+func (ci *ContentIndex) Changed(realPath string) {
+	if !ci.Enabled() || ci.rel(realPath) == "" {
+		return
+	}
+	select {
+	case ci.queue <- realPath:
+	default:
+		log.Printf("[ContentIndex] Queue full, %s is indexed by the next reindex", realPath)
+	}
+}
+
+// work indexes queued paths one at a time, yielding to foreground requests
+func (ci *ContentIndex) work() {
+	for realPath := range ci.queue {
+		if !ci.Enabled() {
+			continue
+		}
+		pace := GetBackgroundPacer().Begin("content-index", PacePriorityMaintenance)
+		ctx := context.Background()
+		_ = ci.indexTree(ctx, realPath, func() error { return pace.PaceContext(ctx) }, nil)
+		pace.End()
+	}
+}
+
+// ContentReindexProgress is the progress and, once finished, the result of
+// a JobContentReindex job
+type ContentReindexProgress struct {
+	Indexed   int `json:"indexed"`   // extracted and stored
+	Unchanged int `json:"unchanged"` // already indexed at their size and modification time
+	Failed    int `json:"failed"`    // text could not be extracted
+	Removed   int `json:"removed"`   // rows of files that are gone
+}
+
+// indexTree indexes a file, or the indexable files below a folder. pace is
+// called before each file; its error stops the walk.
+func (ci *ContentIndex) indexTree(ctx context.Context, root string, pace func() error, progress *ContentReindexProgress) error {
+	if progress == nil {
+		progress = &ContentReindexProgress{}
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !contentIndexable(d.Name()) {
+			return nil
+		}
+		if err := pace(); err != nil {
+			return err
+		}
+		changed, err := ci.IndexFile(path)
+		switch {
+		case err != nil:
+			progress.Failed++
+			log.Printf("[ContentIndex] Failed to index %s: %v", path, err)
+		case changed:
+			progress.Indexed++
+		default:
+			progress.Unchanged++
+		}
+		return ctx.Err()
+	})
+}
+
+// IndexFile extracts the text of one file into the index. It reports false
+// when the file was already indexed at its size and modification time or is
+// not indexed at all: other types and files over content_index_max_file_bytes.
+func (ci *ContentIndex) IndexFile(realPath string) (bool, error) {
+	rel := ci.rel(realPath)
+	if rel == "" || !contentIndexable(rel) {
+		return false, nil
+	}
+	info, err := os.Stat(realPath)
+	if err != nil || info.IsDir() {
+		ci.ForgetTree(realPath)
+		return false, nil
+	}
+	if info.Size() > ci.maxFileBytes() {
+		ci.ForgetTree(realPath)
+		return false, nil
+	}
+
+	// PostgreSQL keeps microseconds
+	mtime := info.ModTime().Truncate(time.Microsecond)
+	var size int64
+	var indexedMtime time.Time
+	err = ci.db.QueryRow(`SELECT size, mtime FROM file_contents WHERE path = $1`, rel).Scan(&size, &indexedMtime)
+	if err == nil && size == info.Size() && indexedMtime.Equal(mtime) {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	text, err := extractText(realPath)
+	if err != nil {
+		return false, err
+	}
+	if _, err := ci.db.Exec(`
+		INSERT INTO file_contents (path, size, mtime, content, content_tsv, indexed_at)
+		VALUES ($1, $2, $3, $4, to_tsvector('simple', $4), NOW())
+		ON CONFLICT (path) DO UPDATE SET
+			size = EXCLUDED.size, mtime = EXCLUDED.mtime, content = EXCLUDED.content,
+			content_tsv = EXCLUDED.content_tsv, indexed_at = EXCLUDED.indexed_at
+	`, rel, info.Size(), mtime, text); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MovePath follows a rename or move with the index rows of the item and below
+func (ci *ContentIndex) MovePath(oldRealPath, newRealPath string) {
+	if ci == nil {
+		return
+	}
+	oldRel, newRel := ci.rel(oldRealPath), ci.rel(newRealPath)
+	switch {
+	case oldRel == "":
+		return
+	case newRel == "":
+		// Moved somewhere that is not indexed, such as the trash
+		ci.ForgetTree(oldRealPath)
+		return
+	}
+	// Rows of an item the move replaced go first
+	ci.ForgetTree(newRealPath)
+	if _, err := ci.db.Exec(`
+		UPDATE file_contents
+		SET path = $2 || substr(path, length($1) + 1)
+		WHERE path = $1 OR starts_with(path, $1 || '/')
+	`, oldRel, newRel); err != nil {
+		log.Printf("[ContentIndex] Failed to move %s -> %s: %v", oldRel, newRel, err)
+	}
+}
+
+// ForgetTree drops the index rows of a deleted item and below
+func (ci *ContentIndex) ForgetTree(realPath string) {
+	if ci == nil {
+		return
+	}
+	rel := ci.rel(realPath)
+	if rel == "" {
+		return
+	}
+	if _, err := ci.db.Exec(`
+		DELETE FROM file_contents WHERE path = $1 OR starts_with(path, $1 || '/')
+	`, rel); err != nil {
+		log.Printf("[ContentIndex] Failed to forget %s: %v", rel, err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Text extraction
+// ---------------------------------------------------------------------------
+
+// textBuffer collects extracted text up to contentIndexMaxText
+type textBuffer struct {
+	strings.Builder
+}
+
+func (b *textBuffer) full() bool {
+	return b.Len() >= contentIndexMaxText
+}
+
+func (b *textBuffer) add(s string) {
+	if room := contentIndexMaxText - b.Len(); room > 0 {
+		if len(s) > room {
+			s = s[:room]
+		}
+		b.WriteString(s)
+	}
+}
+
+// cleanIndexText makes extracted text storable: valid UTF-8 without NUL bytes
+func cleanIndexText(s string) string {
+	if len(s) > contentIndexMaxText {
+		s = s[:contentIndexMaxText]
+	}
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, " ")
+	}
+	return strings.ReplaceAll(s, "\x00", " ")
+}
+
+// extractText returns the text of a txt, md, csv, log, pdf, docx or xlsx
+// file. Files encrypted at rest are decrypted while read.
+func extractText(realPath string) (string, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(realPath)) {
+	case ".pdf":
+		return extractPDFText(f)
+	case ".docx":
+		return extractOfficeText(f, func(name string) bool { return name == "word/document.xml" })
+	case ".xlsx":
+		return extractOfficeText(f, func(name string) bool {
+			return name == "xl/sharedStrings.xml" ||
+				(strings.HasPrefix(name, "xl/worksheets/sheet") && strings.HasSuffix(name, ".xml"))
+		})
+	}
+	data, err := io.ReadAll(io.LimitReader(f, contentIndexMaxText))
+	if err != nil {
+		return "", err
+	}
+	return cleanIndexText(string(data)), nil
+}
+
+// extractPDFText runs pdftotext (poppler-utils) on a PDF
+func extractPDFText(f PlainFile) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), contentExtractTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pdftotext", "-q", "-enc", "UTF-8", "-", "-")
+	cmd.Stdin = f
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("pdftotext failed: %w", err)
+	}
+	return cleanIndexText(out.String()), nil
+}
+
+// extractOfficeText reads the text runs of the parts of an Office Open XML
+// file that want selects, in name order
+func extractOfficeText(f PlainFile, want func(name string) bool) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return "", fmt.Errorf("not an Office document: %w", err)
+	}
+
+	var parts []*zip.File
+	for _, f := range zr.File {
+		if want(f.Name) {
+			parts = append(parts, f)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Name < parts[j].Name })
+
+	var buf textBuffer
+	for _, part := range parts {
+		if buf.full() {
+			break
+		}
+		rc, err := part.Open()
+		if err != nil {
+			return "", err
+		}
+		err = officeXMLText(rc, &buf)
+		rc.Close()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", part.Name, err)
+		}
+	}
+	return cleanIndexText(buf.String()), nil
+}
+
+// officeXMLText appends the text of the <t> runs of a WordprocessingML or
+// SpreadsheetML part. Runs of one paragraph or string item are joined as
+// they are, since Word splits words across runs.
+func officeXMLText(r io.Reader, buf *textBuffer) error {
+	dec := xml.NewDecoder(r)
+	inText := false
+	for !buf.full() {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				buf.add("\t")
+			case "br":
+				buf.add("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p", "si", "row":
+				buf.add("\n")
+			case "c":
+				buf.add("\t")
+			}
+		case xml.CharData:
+			if inText {
+				buf.add(string(t))
+			}
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Search
+// ---------------------------------------------------------------------------
+
+// searchContent returns the files under searchPath the user may read whose
+// text matches query, best ranked first, with a snippet around the match
+func (h *Handler) searchContent(ctx context.Context, query, searchPath string, claims *JWTClaims, maxResults int) []SearchResult {
+	if claims == nil || !GetContentIndex().Enabled() {
+		return nil
+	}
+	prefixes, apiErr := h.changeScope(searchPath, claims)
+	if apiErr != nil || len(prefixes) == 0 {
+		return nil
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT fc.path,
+		       ts_headline('simple', fc.content, q, 'MaxFragments=1, MaxWords=24, MinWords=8, StartSel="", StopSel=""')
+		FROM file_contents fc, plainto_tsquery('simple', $1) q
+		WHERE fc.content_tsv @@ q
+		  AND EXISTS (
+		      SELECT 1 FROM unnest($2::text[]) AS p(prefix)
+		      WHERE starts_with(fc.path, p.prefix || '/')
+		  )
+		ORDER BY ts_rank(fc.content_tsv, q) DESC, fc.path
+		LIMIT $3
+	`, query, pq.Array(prefixes), maxResults)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var rel, snippet string
+		if err := rows.Scan(&rel, &snippet); err != nil {
+			continue
+		}
+		info, err := os.Stat(GetStorageLocations().Map(filepath.Join(h.dataRoot, filepath.FromSlash(rel))))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(info.Name()), "."))
+		results = append(results, SearchResult{
+			Name:      info.Name(),
+			Path:      statsDisplayPath(rel, claims.Username),
+			Size:      info.Size(),
+			ModTime:   info.ModTime(),
+			Extension: ext,
+			MimeType:  getMimeType(ext),
+			MatchType: "content",
+			Snippet:   strings.Join(strings.Fields(snippet), " "),
+		})
+	}
+	return results
+}
+
+// ---------------------------------------------------------------------------
+// Reindex job and admin API
+// ---------------------------------------------------------------------------
+
+// StartContentIndexJobs registers the reindex job and runs it daily to pick
+// up changes the watcher missed
+func (h *Handler) StartContentIndexJobs() {
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:       JobContentReindex,
+		Idempotent: true, // unchanged files are skipped, a rerun continues
+		Priority:   PacePriorityMaintenance,
+		Run:        h.runContentReindex,
+	})
+	jobs.Schedule(JobContentReindex, 24*time.Hour, false)
+}
+
+// runContentReindex runs a JobContentReindex job: it drops the rows of
+// files that are gone, then indexes every home folder and shared drive
+func (h *Handler) runContentReindex(run *JobRun) error {
+	ci := GetContentIndex()
+	if !ci.Enabled() {
+		return nil
+	}
+	progress := &ContentReindexProgress{}
+
+	rows, err := h.db.QueryContext(run.Context(), `SELECT path FROM file_contents`)
+	if err != nil {
+		return err
+	}
+	var gone []string
+	for rows.Next() {
+		var rel string
+		if rows.Scan(&rel) == nil && !pathExists(GetStorageLocations().Map(filepath.Join(h.dataRoot, filepath.FromSlash(rel)))) {
+			gone = append(gone, rel)
+		}
+	}
+	rows.Close()
+	if len(gone) > 0 {
+		res, err := h.db.Exec(`DELETE FROM file_contents WHERE path = ANY($1)`, pq.Array(gone))
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		progress.Removed = int(n)
+	}
+	run.SetProgress(progress)
+
+	for _, top := range []string{"users", "shared"} {
+		entries, err := os.ReadDir(filepath.Join(h.dataRoot, top))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			root := GetStorageLocations().Map(filepath.Join(h.dataRoot, top, entry.Name()))
+			err := ci.indexTree(run.Context(), root, func() error {
+				run.SetProgress(progress)
+				return run.Pace()
+			}, progress)
+			if err != nil {
+				run.SetProgress(progress)
+				return err
+			}
+		}
+	}
+	run.SetProgress(progress)
+	return nil
+}
+
+// ContentIndexStatus describes the content index
+type ContentIndexStatus struct {
+	Enabled       bool       `json:"enabled"`
+	Documents     int64      `json:"documents"`
+	TextBytes     int64      `json:"textBytes"`
+	LastIndexedAt *time.Time `json:"lastIndexedAt,omitempty"`
+	PDFSupported  bool       `json:"pdfSupported"` // pdftotext is installed
+	MaxFileBytes  int64      `json:"maxFileBytes"`
+}
+
+// GetContentIndexStatus reports the size of the content index
+// @Summary		Get content index status
+// @Description	Reports whether content indexing is on, how many documents and how much text the index holds, when a file was last indexed and whether PDFs can be read (pdftotext installed).
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse{data=ContentIndexStatus}	"Index status"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/content-index [get]
+func (h *Handler) GetContentIndexStatus(c echo.Context) error {
+	ci := GetContentIndex()
+	status := ContentIndexStatus{Enabled: ci.Enabled(), MaxFileBytes: defaultContentIndexMaxFileBytes}
+	if ci != nil {
+		status.MaxFileBytes = ci.maxFileBytes()
+	}
+	var last sql.NullTime
+	if err := h.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(octet_length(content)), 0), MAX(indexed_at) FROM file_contents
+	`).Scan(&status.Documents, &status.TextBytes, &last); err != nil {
+		return RespondError(c, ErrInternal("Failed to read the content index"))
+	}
+	if last.Valid {
+		status.LastIndexedAt = &last.Time
+	}
+	_, err := exec.LookPath("pdftotext")
+	status.PDFSupported = err == nil
+	return RespondSuccess(c, status)
+}
+
+// ReindexContent queues a walk of all files into the content index
+// @Summary		Rebuild the content index
+// @Description	Queues a background job that drops index rows of files that are gone and extracts the text of new and changed txt, md, csv, log, pdf, docx and xlsx files in every home folder and shared drive. Poll /jobs/{id} for progress: indexed, unchanged, failed and removed counts. The same job runs daily.
+// @Tags		Admin
+// @Produce		json
+// @Success		202		{object}	docs.SuccessResponse	"Reindex queued: jobId"
+// @Failure		400		{object}	docs.ErrorResponse	"Content indexing is disabled"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/content-index/reindex [post]
+func (h *Handler) ReindexContent(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	if !GetContentIndex().Enabled() {
+		return RespondError(c, ErrBadRequest("Content indexing is disabled; turn on content_index_enabled first"))
+	}
+	jobID, err := GetJobs().Enqueue(JobContentReindex, struct{}{}, &claims.UserID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("queue reindex", err))
+	}
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"jobId": jobID,
+		},
+	})
+}
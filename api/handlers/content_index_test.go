@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// writeTestZip writes a zip holding the given parts
+func writeTestZip(t *testing.T, path string, parts map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractText_OfficeDocuments(t *testing.T) {
+	dir := t.TempDir()
+
+	docx := filepath.Join(dir, "contract.docx")
+	writeTestZip(t, docx, map[string]string{
+		"[Content_Types].xml": `<Types/>`,
+		"word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+			<w:p><w:r><w:t>Section 12: Force ma</w:t></w:r><w:r><w:t xml:space="preserve">jeure </w:t></w:r><w:r><w:t>applies.</w:t></w:r></w:p>
+			<w:p><w:r><w:t>Signed</w:t><w:tab/><w:t>Alice</w:t></w:r></w:p>
+		</w:body></w:document>`,
+	})
+	text, err := extractText(docx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Section 12: Force majeure applies.\n") || !strings.Contains(text, "Signed\tAlice\n") {
+		t.Errorf("docx text = %q", text)
+	}
+
+	xlsx := filepath.Join(dir, "budget.xlsx")
+	writeTestZip(t, xlsx, map[string]string{
+		"xl/sharedStrings.xml":     `<sst><si><t>Quarterly budget</t></si><si><r><t>Tra</t></r><r><t>vel</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row><c t="s"><v>0</v></c><c t="inlineStr"><is><t>Hotel</t></is></c><c><v>1200</v></c></row></sheetData></worksheet>`,
+		"xl/styles.xml":            `<styleSheet><t>not text</t></styleSheet>`,
+	})
+	text, err = extractText(xlsx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Quarterly budget\nTravel\n") || !strings.Contains(text, "Hotel") || strings.Contains(text, "not text") {
+		t.Errorf("xlsx text = %q", text)
+	}
+
+	if _, err := extractText(filepath.Join(dir, "missing.docx")); err == nil {
+		t.Error("missing document extracted")
+	}
+}
+
+func TestCleanIndexText(t *testing.T) {
+	if got := cleanIndexText("a\x00b\xffc"); got != "a b c" {
+		t.Errorf("cleanIndexText = %q", got)
+	}
+	if got := cleanIndexText(strings.Repeat("x", contentIndexMaxText+10)); len(got) != contentIndexMaxText {
+		t.Errorf("cleaned text has %d bytes", len(got))
+	}
+}
+
+func TestContentIndex_IndexFileSkipsUnchanged(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{"content_index_max_file_bytes": "1024"})
+	ci := newContentIndex(tc.DB, h.dataRoot)
+	writeTestFiles(t, home, "notes.txt", "photo.jpg", ".hidden/secret.txt")
+	path := filepath.Join(home, "notes.txt")
+	info := mustStat(t, path)
+
+	tc.Mock.ExpectQuery("SELECT size, mtime FROM file_contents").
+		WithArgs("users/alice/notes.txt").
+		WillReturnRows(sqlmock.NewRows([]string{"size", "mtime"}))
+	tc.Mock.ExpectExec("INSERT INTO file_contents").
+		WithArgs("users/alice/notes.txt", info.Size(), info.ModTime().Truncate(time.Microsecond), "notes.txt").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if changed, err := ci.IndexFile(path); err != nil || !changed {
+		t.Fatalf("first index = %v, %v", changed, err)
+	}
+
+	tc.Mock.ExpectQuery("SELECT size, mtime FROM file_contents").
+		WillReturnRows(sqlmock.NewRows([]string{"size", "mtime"}).AddRow(info.Size(), info.ModTime().Truncate(time.Microsecond)))
+	if changed, err := ci.IndexFile(path); err != nil || changed {
+		t.Errorf("unchanged file indexed again: %v, %v", changed, err)
+	}
+
+	// Other types and hidden folders never reach the database
+	for _, name := range []string{"photo.jpg", ".hidden/secret.txt"} {
+		if changed, err := ci.IndexFile(filepath.Join(home, name)); err != nil || changed {
+			t.Errorf("%s indexed", name)
+		}
+	}
+
+	// Files over the size limit lose their row
+	if err := os.WriteFile(path, make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tc.Mock.ExpectExec("DELETE FROM file_contents").WithArgs("users/alice/notes.txt").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if changed, _ := ci.IndexFile(path); changed {
+		t.Error("file over the limit indexed")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContentIndex_MovePath(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	ci := newContentIndex(tc.DB, h.dataRoot)
+
+	tc.Mock.ExpectExec("DELETE FROM file_contents").WithArgs("shared/Team/docs").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec("UPDATE file_contents").WithArgs("users/alice/docs", "shared/Team/docs").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	ci.MovePath(filepath.Join(home, "docs"), filepath.Join(h.dataRoot, "shared", "Team", "docs"))
+
+	// Into the trash: the rows are dropped
+	tc.Mock.ExpectExec("DELETE FROM file_contents").WithArgs("users/alice/old.txt").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	ci.MovePath(filepath.Join(home, "old.txt"), filepath.Join(h.dataRoot, "trash", "alice", "old.txt"))
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSearchAll_MergesContentResults(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{"content_index_enabled": "true"})
+	prev := globalContentIndex
+	globalContentIndex = newContentIndex(tc.DB, h.dataRoot)
+	defer func() { globalContentIndex = prev }()
+	writeTestFiles(t, home, "majeure-notes.txt", "legal/contract.docx")
+
+	tc.Mock.ExpectQuery("FROM file_metadata").
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "description", "tags"}))
+	tc.Mock.ExpectQuery("FROM file_contents").
+		WithArgs("majeure", sqlmock.AnyArg(), searchMaxResults).
+		WillReturnRows(sqlmock.NewRows([]string{"path", "snippet"}).
+			AddRow("users/alice/legal/contract.docx", "Section 12: Force\n majeure applies").
+			AddRow("users/alice/majeure-notes.txt", "majeure").
+			AddRow("users/alice/deleted.pdf", "majeure"))
+
+	results, err := h.searchAll(context.Background(), "majeure", "/home", "all", &JWTClaims{UserID: "u1", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v", results)
+	}
+	if results[0].Path != "/home/majeure-notes.txt" || results[0].MatchType != "name" || results[0].Snippet != "majeure" {
+		t.Errorf("name match = %+v", results[0])
+	}
+	if results[1].Path != "/home/legal/contract.docx" || results[1].MatchType != "content" || results[1].Snippet != "Section 12: Force majeure applies" {
+		t.Errorf("content match = %+v", results[1])
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
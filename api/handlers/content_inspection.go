@@ -0,0 +1,1490 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Inspection verdicts, from least to most severe
+const (
+	VerdictAllow = "allow"
+	VerdictFlag  = "flag"  // Let through with a warning badge; admins are notified
+	VerdictBlock = "block" // Kept in the pending area; admins and the uploader are notified
+)
+
+var verdictSeverity = map[string]int{VerdictAllow: 0, VerdictFlag: 1, VerdictBlock: 2}
+
+// worseVerdict returns the more severe of two verdicts
+func worseVerdict(a, b string) string {
+	if verdictSeverity[b] > verdictSeverity[a] {
+		return b
+	}
+	return a
+}
+
+// What happens when an inspector stays unavailable after all attempts
+const (
+	InspectionFailOpen   = "open"   // The file is let through, flagged as not inspected
+	InspectionFailClosed = "closed" // The file is treated as blocked
+)
+
+// Inspection job statuses
+const (
+	InspectionQueued  = "queued"
+	InspectionRunning = "running"
+	InspectionDone    = "done"
+)
+
+// NotifContentInspection notifies of flagged and blocked files
+const NotifContentInspection = "content.inspection"
+
+// Inspection settings
+const (
+	settingInspectionClamAV      = "inspection_clamav_address"
+	settingInspectionMaxAttempts = "inspection_max_attempts"
+	settingInspectionTimeout     = "inspection_timeout_seconds"
+	settingInspectionDLPMaxMB    = "inspection_dlp_max_mb"
+	settingInspectionMaxFileMB   = "inspection_max_file_mb"
+	settingInspectionBlockedExts = "inspection_blocked_extensions"
+)
+
+// inspectionHeldDir is the pending area under the data root. Uploads wait
+// there for a blocking verdict, and blocked uploads stay until an admin
+// releases or discards them.
+const inspectionHeldDir = ".inspection"
+
+// inspectionPollInterval is how often the queue is checked for retries due
+const inspectionPollInterval = 15 * time.Second
+
+// Retry backoff while an inspector is unavailable
+const (
+	inspectionRetryBase = 30 * time.Second
+	inspectionRetryMax  = 30 * time.Minute
+)
+
+// inspectionBackoff returns the delay before attempt+1, doubling per attempt
+func inspectionBackoff(attempt int) time.Duration {
+	d := inspectionRetryBase
+	for i := 1; i < attempt && d < inspectionRetryMax; i++ {
+		d *= 2
+	}
+	if d > inspectionRetryMax {
+		d = inspectionRetryMax
+	}
+	return d
+}
+
+// InspectorVerdict is one inspector's verdict on a file
+type InspectorVerdict struct {
+	Inspector   string `json:"inspector"`
+	Verdict     string `json:"verdict"`
+	Reason      string `json:"reason,omitempty"`
+	Cached      bool   `json:"cached,omitempty"`      // From the verdict cache
+	Unavailable bool   `json:"unavailable,omitempty"` // The inspector could not run; the failure mode gave the verdict
+}
+
+// PolicyInspector is an inspector of a policy. Only blocking inspectors can
+// keep a file out; block verdicts of the others count as flags.
+type PolicyInspector struct {
+	Name     string `json:"name"`
+	Blocking bool   `json:"blocking"`
+}
+
+// InspectionPolicy picks the inspectors run on uploads into a folder
+type InspectionPolicy struct {
+	ID         string            `json:"id"`
+	Path       string            `json:"path"`       // Data-root relative: shared/{drive}/... or users/{owner}/...
+	Inspectors []PolicyInspector `json:"inspectors"` // Run in this order
+	FailMode   string            `json:"failMode"`   // open or closed
+	CreatedBy  *string           `json:"createdBy,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+}
+
+// Blocking reports whether uploads wait in the pending area for a verdict
+func (p *InspectionPolicy) Blocking() bool {
+	for _, i := range p.Inspectors {
+		if i.Blocking {
+			return true
+		}
+	}
+	return false
+}
+
+// InspectedUpload is what is needed to put a held upload in place
+type InspectedUpload struct {
+	Source     string `json:"source"`   // Upload path that registered the placer: web or share_upload
+	DestPath   string `json:"destPath"` // Destination folder as the upload named it
+	Filename   string `json:"filename"`
+	Folder     string `json:"folder,omitempty"` // Top-level folder of a folder upload
+	Username   string `json:"username,omitempty"`
+	Overwrite  bool   `json:"overwrite,omitempty"`
+	Size       int64  `json:"size"`
+	ClientIP   string `json:"clientIp,omitempty"`
+	ShareID    string `json:"shareId,omitempty"`
+	ShareToken string `json:"shareToken,omitempty"`
+}
+
+// InspectionJob is an inspection in the queue or its result
+type InspectionJob struct {
+	ID            int64              `json:"id"`
+	PolicyID      *string            `json:"policyId,omitempty"`
+	Path          string             `json:"path"` // Data-root relative; the intended path while held
+	Held          bool               `json:"held"` // In the pending area
+	Upload        *InspectedUpload   `json:"upload,omitempty"`
+	ContentHash   string             `json:"contentHash,omitempty"`
+	Size          int64              `json:"size"`
+	UploadedBy    *string            `json:"uploadedBy,omitempty"`
+	Status        string             `json:"status"`
+	Attempts      int                `json:"attempts"`
+	NextAttemptAt time.Time          `json:"nextAttemptAt"`
+	LastError     string             `json:"lastError,omitempty"`
+	Verdict       string             `json:"verdict,omitempty"`
+	Results       []InspectorVerdict `json:"results"`
+	CreatedAt     time.Time          `json:"createdAt"`
+	FinishedAt    *time.Time         `json:"finishedAt,omitempty"`
+
+	heldName string
+}
+
+// InspectionFlag is the warning badge of a flagged file
+type InspectionFlag struct {
+	Path      string    `json:"path"`
+	JobID     *int64    `json:"jobId,omitempty"`
+	Reason    string    `json:"reason"`
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+// UploadPlacer puts a held upload in place and returns its real path
+type UploadPlacer func(heldPath string, upload *InspectedUpload) (string, error)
+
+var (
+	uploadPlacersMu sync.RWMutex
+	uploadPlacers   = make(map[string]UploadPlacer)
+)
+
+// RegisterUploadPlacer sets how held uploads of a source are put in place
+func RegisterUploadPlacer(source string, placer UploadPlacer) {
+	uploadPlacersMu.Lock()
+	uploadPlacers[source] = placer
+	uploadPlacersMu.Unlock()
+}
+
+func uploadPlacer(source string) UploadPlacer {
+	uploadPlacersMu.RLock()
+	defer uploadPlacersMu.RUnlock()
+	return uploadPlacers[source]
+}
+
+// verdictCache stores inspector verdicts by content hash and fingerprint
+type verdictCache interface {
+	get(hash, inspector, fingerprint string) (InspectorVerdict, bool)
+	put(hash, fingerprint string, v InspectorVerdict)
+}
+
+// dbVerdictCache keeps verdicts in inspection_verdicts
+type dbVerdictCache struct {
+	db *sql.DB
+}
+
+func (c dbVerdictCache) get(hash, inspector, fingerprint string) (InspectorVerdict, bool) {
+	v := InspectorVerdict{Inspector: inspector}
+	err := c.db.QueryRow(`
+		SELECT verdict, reason FROM inspection_verdicts
+		WHERE content_hash = $1 AND inspector = $2 AND fingerprint = $3
+	`, hash, inspector, fingerprint).Scan(&v.Verdict, &v.Reason)
+	return v, err == nil
+}
+
+func (c dbVerdictCache) put(hash, fingerprint string, v InspectorVerdict) {
+	if _, err := c.db.Exec(`
+		INSERT INTO inspection_verdicts (content_hash, inspector, fingerprint, verdict, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (content_hash, inspector, fingerprint) DO UPDATE SET
+			verdict = EXCLUDED.verdict, reason = EXCLUDED.reason, inspected_at = NOW()
+	`, hash, v.Inspector, fingerprint, v.Verdict, v.Reason); err != nil {
+		log.Printf("[Inspection] Failed to cache verdict: %v", err)
+	}
+}
+
+// inspectionRun is one attempt at inspecting a file under a policy
+type inspectionRun struct {
+	policy       *InspectionPolicy
+	inspectors   map[string]ContentInspector
+	cache        verdictCache
+	timeout      time.Duration
+	held         bool // The file waits in the pending area, so it can be kept out
+	finalAttempt bool // Unavailable inspectors get the failure mode instead of a retry
+}
+
+// inspectionOutcome is the result of a run
+type inspectionOutcome struct {
+	Verdict string
+	Results []InspectorVerdict
+	Retry   error // An inspector was unavailable and attempts remain
+}
+
+// run applies the policy's inspectors in order and stops at the first
+// block. Verdicts are cached as the inspectors gave them, so a retry only
+// runs the inspectors that did not answer.
+func (r *inspectionRun) run(ctx context.Context, target InspectTarget, hash string) inspectionOutcome {
+	out := inspectionOutcome{Verdict: VerdictAllow, Results: []InspectorVerdict{}}
+	for _, pi := range r.policy.Inspectors {
+		v, err := r.inspect(ctx, pi.Name, target, hash)
+		if err != nil {
+			if !r.finalAttempt {
+				out.Retry = fmt.Errorf("%s: %w", pi.Name, err)
+				return out
+			}
+			v = InspectorVerdict{Verdict: VerdictFlag, Reason: "Not inspected: " + err.Error(), Unavailable: true}
+			if r.policy.FailMode == InspectionFailClosed {
+				v.Verdict = VerdictBlock
+			}
+		}
+		v.Inspector = pi.Name
+		// A file already in place, or a non-blocking inspector, can only be flagged
+		if v.Verdict == VerdictBlock && (!pi.Blocking || !r.held) {
+			v.Verdict = VerdictFlag
+		}
+		out.Results = append(out.Results, v)
+		out.Verdict = worseVerdict(out.Verdict, v.Verdict)
+		if out.Verdict == VerdictBlock {
+			break
+		}
+	}
+	return out
+}
+
+// inspect returns one inspector's verdict, from the cache if known
+func (r *inspectionRun) inspect(ctx context.Context, name string, target InspectTarget, hash string) (InspectorVerdict, error) {
+	inspector := r.inspectors[name]
+	if inspector == nil {
+		return InspectorVerdict{}, errInspectorNotConfigured
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	fingerprint, err := inspector.Fingerprint(ctx)
+	if err != nil {
+		return InspectorVerdict{}, err
+	}
+	if r.cache != nil && hash != "" {
+		if v, ok := r.cache.get(hash, name, fingerprint); ok {
+			v.Cached = true
+			return v, nil
+		}
+	}
+	v, err := inspector.Inspect(ctx, target)
+	if err != nil {
+		return InspectorVerdict{}, err
+	}
+	v.Inspector = name
+	if r.cache != nil && hash != "" {
+		r.cache.put(hash, fingerprint, v)
+	}
+	return v, nil
+}
+
+// verdictReasons joins the reasons of the results that were not allowed
+func verdictReasons(results []InspectorVerdict) string {
+	var reasons []string
+	for _, v := range results {
+		if v.Verdict != VerdictAllow && v.Reason != "" {
+			reasons = append(reasons, v.Reason)
+		}
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// ContentInspection runs uploads through the inspectors of the folder's
+// policy. Jobs are queued in the database and worked off one at a time;
+// while an inspector is unavailable a job is retried with backoff up to
+// inspection_max_attempts, then the policy's failure mode decides.
+type ContentInspection struct {
+	db            *sql.DB
+	dataRoot      string
+	audit         *AuditHandler
+	notifications *NotificationService
+	cache         verdictCache
+	inspectors    func() map[string]ContentInspector // From settings; replaced in tests
+	wake          chan struct{}
+
+	mu       sync.RWMutex
+	policies map[string]*InspectionPolicy // By Path
+	patterns []*DLPPattern
+	hasFlags atomic.Bool // Listings skip the flag lookup until a file was flagged
+}
+
+var globalContentInspection *ContentInspection
+
+// InitContentInspection creates the global inspection pipeline, loads the
+// policies and patterns, requeues jobs interrupted by a restart and starts
+// the worker
+func InitContentInspection(db *sql.DB, dataRoot string, notifications *NotificationService) *ContentInspection {
+	p := newContentInspection(db, dataRoot, notifications)
+	if err := p.Reload(); err != nil {
+		log.Printf("[Inspection] Failed to load inspection policies: %v", err)
+	}
+	var flagged bool
+	_ = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM inspection_flags)`).Scan(&flagged)
+	p.hasFlags.Store(flagged)
+	if _, err := db.Exec(`UPDATE inspection_jobs SET status = $1 WHERE status = $2`, InspectionQueued, InspectionRunning); err != nil {
+		log.Printf("[Inspection] Failed to requeue interrupted jobs: %v", err)
+	}
+	globalContentInspection = p
+	go p.work()
+	return p
+}
+
+func newContentInspection(db *sql.DB, dataRoot string, notifications *NotificationService) *ContentInspection {
+	p := &ContentInspection{
+		db:            db,
+		dataRoot:      dataRoot,
+		audit:         NewAuditHandler(db, dataRoot),
+		notifications: notifications,
+		cache:         dbVerdictCache{db: db},
+		wake:          make(chan struct{}, 1),
+		policies:      make(map[string]*InspectionPolicy),
+	}
+	p.inspectors = p.settingsInspectors
+	return p
+}
+
+// GetContentInspection returns the global inspection pipeline (nil if not initialized)
+func GetContentInspection() *ContentInspection {
+	return globalContentInspection
+}
+
+// Reload reads the policies and DLP patterns from the database
+func (p *ContentInspection) Reload() error {
+	if p == nil {
+		return nil
+	}
+	rows, err := p.db.Query(`
+		SELECT id, path, inspectors, fail_mode, created_by, created_at, updated_at
+		FROM inspection_policies
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	policies := make(map[string]*InspectionPolicy)
+	for rows.Next() {
+		var pol InspectionPolicy
+		var inspectors []byte
+		if err := rows.Scan(&pol.ID, &pol.Path, &inspectors, &pol.FailMode, &pol.CreatedBy, &pol.CreatedAt, &pol.UpdatedAt); err != nil {
+			return err
+		}
+		_ = json.Unmarshal(inspectors, &pol.Inspectors)
+		policies[pol.Path] = &pol
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	patterns, err := loadDLPPatterns(p.db)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.policies, p.patterns = policies, patterns
+	p.mu.Unlock()
+	return nil
+}
+
+// loadDLPPatterns reads all DLP patterns
+func loadDLPPatterns(db *sql.DB) ([]*DLPPattern, error) {
+	rows, err := db.Query(`SELECT id, name, pattern, verdict, enabled, created_by, created_at FROM dlp_patterns ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	patterns := []*DLPPattern{}
+	for rows.Next() {
+		var d DLPPattern
+		if err := rows.Scan(&d.ID, &d.Name, &d.Pattern, &d.Verdict, &d.Enabled, &d.CreatedBy, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, &d)
+	}
+	return patterns, rows.Err()
+}
+
+// List returns all policies
+func (p *ContentInspection) List() []*InspectionPolicy {
+	if p == nil {
+		return []*InspectionPolicy{}
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list := make([]*InspectionPolicy, 0, len(p.policies))
+	for _, pol := range p.policies {
+		list = append(list, pol)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// byID returns a policy by ID
+func (p *ContentInspection) byID(id string) *InspectionPolicy {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, pol := range p.policies {
+		if pol.ID == id {
+			return pol
+		}
+	}
+	return nil
+}
+
+// rel returns the data-root relative path of a real path, "" outside it
+func (p *ContentInspection) rel(realPath string) string {
+	rel, err := dataRootRel(p.dataRoot, realPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// policyFor returns the nearest policy at or above a data-root relative folder
+func (p *ContentInspection) policyFor(rel string) *InspectionPolicy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.policies) == 0 {
+		return nil
+	}
+	for dir := rel; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		if pol, ok := p.policies[dir]; ok {
+			return pol
+		}
+	}
+	return nil
+}
+
+// settingsInspectors builds the inspectors from the system settings
+func (p *ContentInspection) settingsInspectors() map[string]ContentInspector {
+	var clamd, blocked string
+	dlpMB, maxFileMB := 32, 0
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		clamd, _ = sh.GetSetting(settingInspectionClamAV)
+		blocked, _ = sh.GetSetting(settingInspectionBlockedExts)
+		dlpMB = sh.GetSettingInt(settingInspectionDLPMaxMB, 32)
+		maxFileMB = sh.GetSettingInt(settingInspectionMaxFileMB, 0)
+	}
+	p.mu.RLock()
+	patterns := compileDLPPatterns(p.patterns)
+	p.mu.RUnlock()
+	return map[string]ContentInspector{
+		InspectorClamAV:   newClamAVInspector(strings.TrimSpace(clamd)),
+		InspectorRegexDLP: &regexDLPInspector{patterns: patterns, maxBytes: int64(dlpMB) << 20},
+		InspectorSizeType: &sizeTypeInspector{maxBytes: int64(maxFileMB) << 20, blocked: parseExtensionList(blocked)},
+	}
+}
+
+// inspectionLimits returns the attempts before the failure mode applies and
+// the time an inspector may take per file
+func inspectionLimits() (int, time.Duration) {
+	attempts, timeout := 3, 120
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		attempts = sh.GetSettingInt(settingInspectionMaxAttempts, 3)
+		timeout = sh.GetSettingInt(settingInspectionTimeout, 120)
+	}
+	if attempts < 1 {
+		attempts = 1
+	}
+	if timeout < 1 {
+		timeout = 120
+	}
+	return attempts, time.Duration(timeout) * time.Second
+}
+
+// heldPath returns the real path of a file in the pending area
+func (p *ContentInspection) heldPath(name string) string {
+	return filepath.Join(p.dataRoot, inspectionHeldDir, name)
+}
+
+// Hold moves a finished upload into the pending area when the policy of its
+// destination folder has a blocking inspector, and queues its inspection.
+// It returns false when the upload is to be put in place now; call Inspect
+// once it is.
+func (p *ContentInspection) Hold(srcPath, destDir string, upload *InspectedUpload, uploadedBy *string) bool {
+	if p == nil {
+		return false
+	}
+	rel := p.rel(destDir)
+	policy := p.policyFor(rel)
+	if policy == nil || !policy.Blocking() {
+		return false
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return false
+	}
+	name := hex.EncodeToString(token)
+	heldPath := p.heldPath(name)
+	if err := os.MkdirAll(filepath.Dir(heldPath), 0700); err != nil {
+		log.Printf("[Inspection] Failed to create the pending area: %v", err)
+		return false
+	}
+	if err := finalizeUpload(srcPath, heldPath); err != nil {
+		log.Printf("[Inspection] Failed to hold %s, inspecting it in place: %v", upload.Filename, err)
+		return false
+	}
+
+	job := &InspectionJob{PolicyID: &policy.ID, Path: path.Join(rel, upload.Filename), heldName: name,
+		Upload: upload, Size: upload.Size, UploadedBy: uploadedBy}
+	if err := p.enqueue(job); err != nil {
+		log.Printf("[Inspection] Failed to queue %s: %v", job.Path, err)
+		if err := finalizeUpload(heldPath, srcPath); err != nil {
+			log.Printf("[Inspection] Upload %s stays in %s: %v", upload.Filename, heldPath, err)
+			return true
+		}
+		return false
+	}
+	log.Printf("[Inspection] Holding %s until it is inspected (job %d)", job.Path, job.ID)
+	return true
+}
+
+// Inspect queues the inspection of a file put in place, if a policy covers
+// it. Such files can only be flagged.
+func (p *ContentInspection) Inspect(realPath string, size int64, uploadedBy *string) {
+	if p == nil {
+		return
+	}
+	rel := p.rel(realPath)
+	policy := p.policyFor(path.Dir(rel))
+	if rel == "" || policy == nil {
+		return
+	}
+	job := &InspectionJob{PolicyID: &policy.ID, Path: rel, Size: size, UploadedBy: uploadedBy}
+	if err := p.enqueue(job); err != nil {
+		log.Printf("[Inspection] Failed to queue %s: %v", rel, err)
+	}
+}
+
+// enqueue stores a job and wakes the worker
+func (p *ContentInspection) enqueue(job *InspectionJob) error {
+	var upload interface{}
+	if job.Upload != nil {
+		b, _ := json.Marshal(job.Upload)
+		upload = string(b)
+	}
+	var heldName interface{}
+	if job.heldName != "" {
+		heldName = job.heldName
+	}
+	err := p.db.QueryRow(`
+		INSERT INTO inspection_jobs (policy_id, path, held_name, upload, size, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, job.PolicyID, job.Path, heldName, upload, job.Size, job.UploadedBy).Scan(&job.ID)
+	if err != nil {
+		return err
+	}
+	p.Wake()
+	return nil
+}
+
+// Wake has the worker check the queue now
+func (p *ContentInspection) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work runs queued jobs as they come and retries as they fall due
+func (p *ContentInspection) work() {
+	ticker := time.NewTicker(inspectionPollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			job, err := p.claim()
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("[Inspection] Failed to claim a job: %v", err)
+				}
+				break
+			}
+			p.process(job)
+		}
+		select {
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+const inspectionJobColumns = `id, policy_id, path, held_name, upload, content_hash, size, uploaded_by,
+	status, attempts, next_attempt_at, last_error, verdict, results, created_at, finished_at`
+
+// scanInspectionJob scans a row selected with inspectionJobColumns
+func scanInspectionJob(scan func(dest ...interface{}) error) (*InspectionJob, error) {
+	var j InspectionJob
+	var heldName, contentHash, lastError, verdict sql.NullString
+	var upload, results []byte
+	var finishedAt sql.NullTime
+	if err := scan(&j.ID, &j.PolicyID, &j.Path, &heldName, &upload, &contentHash, &j.Size, &j.UploadedBy,
+		&j.Status, &j.Attempts, &j.NextAttemptAt, &lastError, &verdict, &results, &j.CreatedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	j.heldName, j.Held = heldName.String, heldName.Valid
+	j.ContentHash, j.LastError, j.Verdict = contentHash.String, lastError.String, verdict.String
+	if len(upload) > 0 {
+		_ = json.Unmarshal(upload, &j.Upload)
+	}
+	_ = json.Unmarshal(results, &j.Results)
+	if j.Results == nil {
+		j.Results = []InspectorVerdict{}
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return &j, nil
+}
+
+// claim takes the next due job and counts the attempt
+func (p *ContentInspection) claim() (*InspectionJob, error) {
+	return scanInspectionJob(p.db.QueryRow(`
+		UPDATE inspection_jobs SET status = $1, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM inspection_jobs
+			WHERE status = $2 AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+inspectionJobColumns, InspectionRunning, InspectionQueued).Scan)
+}
+
+// target returns the file a job inspects
+func (p *ContentInspection) target(job *InspectionJob) InspectTarget {
+	if job.heldName != "" {
+		return InspectTarget{RealPath: p.heldPath(job.heldName), Name: path.Base(job.Path)}
+	}
+	realPath := GetStorageLocations().Map(filepath.Join(p.dataRoot, filepath.FromSlash(job.Path)))
+	return InspectTarget{RealPath: realPath, Name: path.Base(job.Path)}
+}
+
+// process runs one attempt of a job. A job whose policy was removed is let
+// through uninspected.
+func (p *ContentInspection) process(job *InspectionJob) {
+	target := p.target(job)
+	if _, err := os.Stat(target.RealPath); err != nil {
+		job.LastError = "File no longer exists"
+		p.finish(job, inspectionOutcome{Results: []InspectorVerdict{}})
+		return
+	}
+	var policy *InspectionPolicy
+	if job.PolicyID != nil {
+		policy = p.byID(*job.PolicyID)
+	}
+	if policy == nil {
+		p.finish(job, inspectionOutcome{Verdict: VerdictAllow, Results: []InspectorVerdict{}})
+		return
+	}
+	if job.ContentHash == "" {
+		if hash, err := hashFileSHA256(target.RealPath); err == nil {
+			job.ContentHash = hash
+		}
+	}
+
+	maxAttempts, timeout := inspectionLimits()
+	run := inspectionRun{
+		policy:       policy,
+		inspectors:   p.inspectors(),
+		cache:        p.cache,
+		timeout:      timeout,
+		held:         job.heldName != "",
+		finalAttempt: job.Attempts >= maxAttempts,
+	}
+	pace := GetBackgroundPacer().Begin("inspection", PacePriorityUser)
+	out := run.run(context.Background(), target, job.ContentHash)
+	pace.End()
+
+	if out.Retry != nil {
+		p.retry(job, out.Retry)
+		return
+	}
+	p.finish(job, out)
+}
+
+// retry puts a job back in the queue after a backoff
+func (p *ContentInspection) retry(job *InspectionJob, cause error) {
+	delay := inspectionBackoff(job.Attempts)
+	log.Printf("[Inspection] Job %d (%s), attempt %d: %v; retrying in %s", job.ID, job.Path, job.Attempts, cause, delay)
+	if _, err := p.db.Exec(`
+		UPDATE inspection_jobs
+		SET status = $2, next_attempt_at = NOW() + make_interval(secs => $3), last_error = $4, content_hash = NULLIF($5, '')
+		WHERE id = $1
+	`, job.ID, InspectionQueued, delay.Seconds(), cause.Error(), job.ContentHash); err != nil {
+		log.Printf("[Inspection] Failed to requeue job %d: %v", job.ID, err)
+	}
+}
+
+// finish records a verdict. Held uploads that were not blocked are put in
+// place; flagged files get their badge and admins are told of flagged and
+// blocked files.
+func (p *ContentInspection) finish(job *InspectionJob, out inspectionOutcome) {
+	job.Verdict, job.Results = out.Verdict, out.Results
+	if job.heldName != "" && out.Verdict != "" && out.Verdict != VerdictBlock {
+		if err := p.release(job); err != nil {
+			job.LastError = "Could not be put in place: " + err.Error()
+			log.Printf("[Inspection] Job %d: %s", job.ID, job.LastError)
+		}
+	}
+
+	results, _ := json.Marshal(job.Results)
+	var heldName, verdict interface{}
+	if job.heldName != "" {
+		heldName = job.heldName
+	}
+	if job.Verdict != "" {
+		verdict = job.Verdict
+	}
+	if _, err := p.db.Exec(`
+		UPDATE inspection_jobs
+		SET status = $2, verdict = $3, results = $4, content_hash = NULLIF($5, ''), path = $6,
+			held_name = $7, last_error = NULLIF($8, ''), finished_at = NOW()
+		WHERE id = $1
+	`, job.ID, InspectionDone, verdict, results, job.ContentHash, job.Path, heldName, job.LastError); err != nil {
+		log.Printf("[Inspection] Failed to record job %d: %v", job.ID, err)
+	}
+	job.Status, job.Held = InspectionDone, job.heldName != ""
+
+	reason := verdictReasons(job.Results)
+	switch {
+	case job.Verdict == VerdictFlag && !job.Held:
+		p.setFlag(job.Path, job.ID, reason)
+		p.report(job, EventContentFlagged, "File flagged by content inspection", reason)
+	case job.Verdict == VerdictBlock:
+		p.report(job, EventContentBlocked, "Upload blocked by content inspection", reason)
+	case job.Verdict == VerdictAllow && !job.Held:
+		// A clean upload replacing a flagged file drops the badge
+		p.clearFlag(job.Path)
+	}
+}
+
+// release puts a held upload in place
+func (p *ContentInspection) release(job *InspectionJob) error {
+	if job.Upload == nil {
+		return errors.New("upload details are missing")
+	}
+	placer := uploadPlacer(job.Upload.Source)
+	if placer == nil {
+		return fmt.Errorf("no placer for %s uploads", job.Upload.Source)
+	}
+	finalPath, err := placer(p.heldPath(job.heldName), job.Upload)
+	if err != nil {
+		return err
+	}
+	if rel := p.rel(finalPath); rel != "" {
+		job.Path = rel
+	}
+	job.heldName = ""
+	return nil
+}
+
+// report audits a flagged or blocked file and notifies the admins holding
+// audit.read; the uploader is told of a block
+func (p *ContentInspection) report(job *InspectionJob, event, title, reason string) {
+	clientIP := "0.0.0.0"
+	if job.Upload != nil && job.Upload.ClientIP != "" {
+		clientIP = job.Upload.ClientIP
+	}
+	if p.audit != nil {
+		_ = p.audit.LogEvent(job.UploadedBy, clientIP, event, "/"+job.Path, map[string]interface{}{
+			"jobId":   job.ID,
+			"verdict": job.Verdict,
+			"reason":  reason,
+			"results": job.Results,
+			"held":    job.Held,
+		})
+	}
+	if p.notifications == nil {
+		return
+	}
+	message := fmt.Sprintf("%s: %s", path.Base(job.Path), reason)
+	metadata := map[string]interface{}{"jobId": job.ID, "path": job.Path, "verdict": job.Verdict}
+	recipients, err := loadAlertRecipients(p.db, PermAuditRead)
+	if err != nil {
+		log.Printf("[Inspection] Failed to load recipients: %v", err)
+	}
+	for _, r := range recipients {
+		p.notifications.Send(r.id, NotifContentInspection, title, message, "/admin/inspection", nil, metadata)
+	}
+	if job.Verdict == VerdictBlock && job.UploadedBy != nil {
+		p.notifications.Send(*job.UploadedBy, NotifContentInspection, "Your upload was blocked",
+			message+". An administrator can release it.", "", nil, metadata)
+	}
+}
+
+// setFlag attaches the warning badge to a file
+func (p *ContentInspection) setFlag(rel string, jobID int64, reason string) {
+	if _, err := p.db.Exec(`
+		INSERT INTO inspection_flags (path, job_id, reason) VALUES ($1, $2, $3)
+		ON CONFLICT (path) DO UPDATE SET job_id = EXCLUDED.job_id, reason = EXCLUDED.reason, flagged_at = NOW()
+	`, rel, jobID, reason); err != nil {
+		log.Printf("[Inspection] Failed to flag %s: %v", rel, err)
+		return
+	}
+	p.hasFlags.Store(true)
+	GetListingVersions().Invalidate(filepath.Join(p.dataRoot, rel))
+}
+
+// clearFlag removes the badge of a file, reporting whether it had one
+func (p *ContentInspection) clearFlag(rel string) bool {
+	if !p.hasFlags.Load() {
+		return false
+	}
+	res, err := p.db.Exec(`DELETE FROM inspection_flags WHERE path = $1`, rel)
+	if err != nil {
+		log.Printf("[Inspection] Failed to clear the flag of %s: %v", rel, err)
+		return false
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		GetListingVersions().Invalidate(filepath.Join(p.dataRoot, rel))
+	}
+	return n > 0
+}
+
+// FlagsIn returns the flags of the files in a folder by name
+func (p *ContentInspection) FlagsIn(dirRealPath string) map[string]*InspectionFlag {
+	if p == nil || !p.hasFlags.Load() {
+		return nil
+	}
+	rel := p.rel(dirRealPath)
+	if rel == "" {
+		return nil
+	}
+	rows, err := p.db.Query(`
+		SELECT path, job_id, reason, flagged_at FROM inspection_flags
+		WHERE starts_with(path, $1 || '/') AND strpos(substr(path, length($1) + 2), '/') = 0
+	`, rel)
+	if err != nil {
+		log.Printf("[Inspection] Failed to load flags of %s: %v", rel, err)
+		return nil
+	}
+	defer rows.Close()
+	flags := make(map[string]*InspectionFlag)
+	for rows.Next() {
+		var f InspectionFlag
+		if err := rows.Scan(&f.Path, &f.JobID, &f.Reason, &f.FlaggedAt); err == nil {
+			flags[path.Base(f.Path)] = &f
+		}
+	}
+	return flags
+}
+
+// MovePath follows a rename or move with the flags of the item and below
+func (p *ContentInspection) MovePath(oldRealPath, newRealPath string) {
+	if p == nil || !p.hasFlags.Load() {
+		return
+	}
+	oldRel, newRel := p.rel(oldRealPath), p.rel(newRealPath)
+	if oldRel == "" || newRel == "" {
+		return
+	}
+	if _, err := p.db.Exec(`
+		UPDATE inspection_flags
+		SET path = $2 || substr(path, length($1) + 1)
+		WHERE path = $1 OR starts_with(path, $1 || '/')
+	`, oldRel, newRel); err != nil {
+		log.Printf("[Inspection] Failed to move flags %s -> %s: %v", oldRel, newRel, err)
+	}
+}
+
+// ForgetTree drops the flags of a deleted item and below
+func (p *ContentInspection) ForgetTree(realPath string) {
+	if p == nil || !p.hasFlags.Load() {
+		return
+	}
+	rel := p.rel(realPath)
+	if rel == "" {
+		return
+	}
+	if _, err := p.db.Exec(`
+		DELETE FROM inspection_flags WHERE path = $1 OR starts_with(path, $1 || '/')
+	`, rel); err != nil {
+		log.Printf("[Inspection] Failed to forget flags of %s: %v", rel, err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Admin API
+// ---------------------------------------------------------------------------
+
+// InspectionPolicyRequest creates or changes a policy
+type InspectionPolicyRequest struct {
+	Path       string            `json:"path"` // On create: shared/{drive}/... or users/{username}/...
+	Inspectors []PolicyInspector `json:"inspectors"`
+	FailMode   string            `json:"failMode"` // open (default) or closed
+}
+
+// validate checks the inspectors and failure mode
+func (req *InspectionPolicyRequest) validate() *APIError {
+	if len(req.Inspectors) == 0 {
+		return ErrBadRequest("inspectors must list at least one inspector")
+	}
+	seen := make(map[string]bool)
+	for _, i := range req.Inspectors {
+		if !inspectorNames[i.Name] {
+			return ErrBadRequest(fmt.Sprintf("Unknown inspector %q (clamav, regex-dlp or size-type)", i.Name))
+		}
+		if seen[i.Name] {
+			return ErrBadRequest(fmt.Sprintf("Inspector %s is listed twice", i.Name))
+		}
+		seen[i.Name] = true
+	}
+	switch req.FailMode {
+	case "":
+		req.FailMode = InspectionFailOpen
+	case InspectionFailOpen, InspectionFailClosed:
+	default:
+		return ErrBadRequest("failMode must be open or closed")
+	}
+	return nil
+}
+
+// ListInspectionPolicies lists the inspection policies
+// @Summary		List inspection policies
+// @Description	Lists the folders whose uploads are inspected, with their inspectors and failure mode.
+// @Tags		Admin
+// @Produce		json
+// @Success		200	{array}		InspectionPolicy	"Policies"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/inspection/policies [get]
+func (h *Handler) ListInspectionPolicies(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	return RespondSuccess(c, GetContentInspection().List())
+}
+
+// CreateInspectionPolicy sets the inspectors run on uploads into a folder
+// @Summary		Create inspection policy
+// @Description	Inspects uploads into a shared drive or home subfolder (shared/{drive}/... or users/{username}/...) and below; the nearest policy applies. Inspectors (clamav, regex-dlp, size-type) run in the listed order and stop at the first block. With a blocking inspector uploads wait in a pending area until they pass; otherwise they are put in place and can only be flagged. failMode open lets a file through flagged as not inspected when an inspector stays unavailable, closed blocks it.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		InspectionPolicyRequest	true	"Policy"
+// @Success		201		{object}	InspectionPolicy	"Created policy"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid policy"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		409		{object}	docs.ErrorResponse	"The folder has a policy"
+// @Security	BearerAuth
+// @Router		/admin/inspection/policies [post]
+func (h *Handler) CreateInspectionPolicy(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	var req InspectionPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if apiErr := req.validate(); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	target, apiErr := h.resolveAdoptTarget(req.Path)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if info, err := os.Stat(target.realPath); err != nil || !info.IsDir() {
+		return RespondError(c, ErrBadRequest("path must be a folder"))
+	}
+	ci := GetContentInspection()
+	if ci == nil {
+		return RespondError(c, ErrInternal("Content inspection is not available"))
+	}
+	if existing := ci.policyFor(target.rel); existing != nil && existing.Path == target.rel {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "The folder already has an inspection policy"))
+	}
+
+	inspectors, _ := json.Marshal(req.Inspectors)
+	var id string
+	if err := h.db.QueryRow(`
+		INSERT INTO inspection_policies (path, inspectors, fail_mode, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, target.rel, inspectors, req.FailMode, claims.UserID).Scan(&id); err != nil {
+		return RespondError(c, ErrOperationFailed("create inspection policy", err))
+	}
+	if err := ci.Reload(); err != nil {
+		log.Printf("[Inspection] Failed to reload inspection policies: %v", err)
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionPolicy, "/"+target.rel, map[string]interface{}{
+		"action":     "create",
+		"policyId":   id,
+		"inspectors": req.Inspectors,
+		"failMode":   req.FailMode,
+	})
+
+	p := ci.byID(id)
+	if p == nil {
+		p = &InspectionPolicy{ID: id, Path: target.rel, Inspectors: req.Inspectors, FailMode: req.FailMode,
+			CreatedBy: &claims.UserID, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	}
+	return RespondCreated(c, p)
+}
+
+// UpdateInspectionPolicy changes the inspectors or failure mode of a policy
+// @Summary		Update inspection policy
+// @Description	Replaces the inspectors and failure mode of a policy. Queued inspections use the new settings.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string					true	"Policy ID"
+// @Param		request	body		InspectionPolicyRequest	true	"Inspectors and failure mode"
+// @Success		200		{object}	InspectionPolicy	"Updated policy"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid policy"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/inspection/policies/{id} [put]
+func (h *Handler) UpdateInspectionPolicy(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	ci := GetContentInspection()
+	p := ci.byID(c.Param("id"))
+	if p == nil {
+		return RespondError(c, ErrNotFound("Inspection policy"))
+	}
+	var req InspectionPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if apiErr := req.validate(); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	inspectors, _ := json.Marshal(req.Inspectors)
+	if _, err := h.db.Exec(`
+		UPDATE inspection_policies SET inspectors = $2, fail_mode = $3, updated_at = NOW() WHERE id = $1
+	`, p.ID, inspectors, req.FailMode); err != nil {
+		return RespondError(c, ErrOperationFailed("update inspection policy", err))
+	}
+	if err := ci.Reload(); err != nil {
+		log.Printf("[Inspection] Failed to reload inspection policies: %v", err)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionPolicy, "/"+p.Path, map[string]interface{}{
+		"action":     "update",
+		"policyId":   p.ID,
+		"inspectors": req.Inspectors,
+		"failMode":   req.FailMode,
+	})
+	if updated := ci.byID(p.ID); updated != nil {
+		p = updated
+	}
+	return RespondSuccess(c, p)
+}
+
+// DeleteInspectionPolicy removes a policy
+// @Summary		Remove inspection policy
+// @Description	Removes an inspection policy. Queued inspections under it are let through uninspected; blocked uploads stay in the pending area.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Policy ID"
+// @Success		200	{object}	docs.SuccessResponse	"Removed"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/inspection/policies/{id} [delete]
+func (h *Handler) DeleteInspectionPolicy(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	ci := GetContentInspection()
+	p := ci.byID(c.Param("id"))
+	if p == nil {
+		return RespondError(c, ErrNotFound("Inspection policy"))
+	}
+	if _, err := h.db.Exec(`DELETE FROM inspection_policies WHERE id = $1`, p.ID); err != nil {
+		return RespondError(c, ErrOperationFailed("remove inspection policy", err))
+	}
+	if err := ci.Reload(); err != nil {
+		log.Printf("[Inspection] Failed to reload inspection policies: %v", err)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionPolicy, "/"+p.Path, map[string]interface{}{
+		"action":   "delete",
+		"policyId": p.ID,
+	})
+	return RespondSuccess(c, map[string]string{"message": "Inspection policy removed"})
+}
+
+// DLPPatternRequest creates or changes a DLP pattern
+type DLPPatternRequest struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"` // Go regexp syntax
+	Verdict string `json:"verdict"` // flag (default) or block
+	Enabled *bool  `json:"enabled"` // Default true
+}
+
+// validate checks the pattern compiles
+func (req *DLPPatternRequest) validate() *APIError {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || req.Pattern == "" {
+		return ErrMissingParameter("name and pattern")
+	}
+	if _, err := regexp.Compile(req.Pattern); err != nil {
+		return ErrBadRequest("Invalid pattern: " + err.Error())
+	}
+	switch req.Verdict {
+	case "":
+		req.Verdict = VerdictFlag
+	case VerdictFlag, VerdictBlock:
+	default:
+		return ErrBadRequest("verdict must be flag or block")
+	}
+	if req.Enabled == nil {
+		enabled := true
+		req.Enabled = &enabled
+	}
+	return nil
+}
+
+// ListDLPPatterns lists the regex-dlp patterns
+// @Summary		List DLP patterns
+// @Description	Lists the regular expressions the regex-dlp inspector looks for.
+// @Tags		Admin
+// @Produce		json
+// @Success		200	{array}		DLPPattern			"Patterns"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/inspection/patterns [get]
+func (h *Handler) ListDLPPatterns(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermSettingsWrite)
+	if claims == nil {
+		return err
+	}
+	patterns, err := loadDLPPatterns(h.db)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list DLP patterns", err))
+	}
+	return RespondSuccess(c, patterns)
+}
+
+// CreateDLPPattern adds a regex-dlp pattern
+// @Summary		Create DLP pattern
+// @Description	Adds a regular expression (Go syntax) to the regex-dlp inspector. Matches give the pattern's verdict; only the pattern name and match count are reported. Cached regex-dlp verdicts no longer apply.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		DLPPatternRequest	true	"Pattern"
+// @Success		201		{object}	DLPPattern			"Created pattern"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid pattern"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		409		{object}	docs.ErrorResponse	"Name in use"
+// @Security	BearerAuth
+// @Router		/admin/inspection/patterns [post]
+func (h *Handler) CreateDLPPattern(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermSettingsWrite)
+	if claims == nil {
+		return err
+	}
+	var req DLPPatternRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if apiErr := req.validate(); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	d := DLPPattern{Name: req.Name, Pattern: req.Pattern, Verdict: req.Verdict, Enabled: *req.Enabled, CreatedBy: &claims.UserID}
+	err = h.db.QueryRow(`
+		INSERT INTO dlp_patterns (name, pattern, verdict, enabled, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING id, created_at
+	`, d.Name, d.Pattern, d.Verdict, d.Enabled, claims.UserID).Scan(&d.ID, &d.CreatedAt)
+	if err == sql.ErrNoRows {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "A pattern with this name exists"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("create DLP pattern", err))
+	}
+	if err := GetContentInspection().Reload(); err != nil {
+		log.Printf("[Inspection] Failed to reload DLP patterns: %v", err)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionPattern, "dlp_patterns/"+d.ID, map[string]interface{}{
+		"action":  "create",
+		"name":    d.Name,
+		"verdict": d.Verdict,
+		"enabled": d.Enabled,
+	})
+	return RespondCreated(c, d)
+}
+
+// UpdateDLPPattern changes a regex-dlp pattern
+// @Summary		Update DLP pattern
+// @Description	Replaces the name, expression, verdict and enabled state of a pattern. Cached regex-dlp verdicts no longer apply.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string				true	"Pattern ID"
+// @Param		request	body		DLPPatternRequest	true	"Pattern"
+// @Success		200		{object}	DLPPattern			"Updated pattern"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid pattern"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/inspection/patterns/{id} [put]
+func (h *Handler) UpdateDLPPattern(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermSettingsWrite)
+	if claims == nil {
+		return err
+	}
+	var req DLPPatternRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if apiErr := req.validate(); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	var d DLPPattern
+	err = h.db.QueryRow(`
+		UPDATE dlp_patterns SET name = $2, pattern = $3, verdict = $4, enabled = $5
+		WHERE id = $1
+		RETURNING id, name, pattern, verdict, enabled, created_by, created_at
+	`, c.Param("id"), req.Name, req.Pattern, req.Verdict, *req.Enabled).
+		Scan(&d.ID, &d.Name, &d.Pattern, &d.Verdict, &d.Enabled, &d.CreatedBy, &d.CreatedAt)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("DLP pattern"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("update DLP pattern", err))
+	}
+	if err := GetContentInspection().Reload(); err != nil {
+		log.Printf("[Inspection] Failed to reload DLP patterns: %v", err)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionPattern, "dlp_patterns/"+d.ID, map[string]interface{}{
+		"action":  "update",
+		"name":    d.Name,
+		"verdict": d.Verdict,
+		"enabled": d.Enabled,
+	})
+	return RespondSuccess(c, d)
+}
+
+// DeleteDLPPattern removes a regex-dlp pattern
+// @Summary		Remove DLP pattern
+// @Description	Removes a pattern from the regex-dlp inspector.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Pattern ID"
+// @Success		200	{object}	docs.SuccessResponse	"Removed"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/inspection/patterns/{id} [delete]
+func (h *Handler) DeleteDLPPattern(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermSettingsWrite)
+	if claims == nil {
+		return err
+	}
+	var name string
+	err = h.db.QueryRow(`DELETE FROM dlp_patterns WHERE id = $1 RETURNING name`, c.Param("id")).Scan(&name)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("DLP pattern"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("remove DLP pattern", err))
+	}
+	if err := GetContentInspection().Reload(); err != nil {
+		log.Printf("[Inspection] Failed to reload DLP patterns: %v", err)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionPattern, "dlp_patterns/"+c.Param("id"), map[string]interface{}{
+		"action": "delete",
+		"name":   name,
+	})
+	return RespondSuccess(c, map[string]string{"message": "DLP pattern removed"})
+}
+
+// ListInspectionJobs lists recent inspections
+// @Summary		List inspection jobs
+// @Description	Lists the most recent inspections, newest first, with each inspector's verdict. held=true lists uploads in the pending area: waiting for a verdict, blocked, or not put in place.
+// @Tags		Admin
+// @Produce		json
+// @Param		status	query		string	false	"queued, running or done"
+// @Param		verdict	query		string	false	"allow, flag or block"
+// @Param		held	query		bool	false	"Only uploads in the pending area"
+// @Param		limit	query		int		false	"Max jobs (default 100, max 500)"
+// @Success		200		{array}		InspectionJob		"Jobs"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/inspection/jobs [get]
+func (h *Handler) ListInspectionJobs(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	limit := 100
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	rows, err := h.db.Query(`
+		SELECT `+inspectionJobColumns+` FROM inspection_jobs
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR verdict = $2) AND (NOT $3 OR held_name IS NOT NULL)
+		ORDER BY id DESC LIMIT $4
+	`, c.QueryParam("status"), c.QueryParam("verdict"), c.QueryParam("held") == "true", limit)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list inspection jobs", err))
+	}
+	defer rows.Close()
+	jobs := []*InspectionJob{}
+	for rows.Next() {
+		job, err := scanInspectionJob(rows.Scan)
+		if err != nil {
+			return RespondError(c, ErrOperationFailed("list inspection jobs", err))
+		}
+		jobs = append(jobs, job)
+	}
+	return RespondSuccess(c, jobs)
+}
+
+// heldJob loads a finished job whose upload is in the pending area
+func (h *Handler) heldJob(c echo.Context) (*InspectionJob, *APIError) {
+	job, err := scanInspectionJob(h.db.QueryRow(`SELECT `+inspectionJobColumns+` FROM inspection_jobs WHERE id = $1`, c.Param("id")).Scan)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound("Inspection job")
+	}
+	if err != nil {
+		return nil, ErrOperationFailed("load inspection job", err)
+	}
+	if !job.Held || job.Status != InspectionDone {
+		return nil, NewAPIError(ErrCodeConflict, "The job has no finished upload in the pending area")
+	}
+	return job, nil
+}
+
+// ReleaseInspectionJob puts a blocked upload in place
+// @Summary		Release held upload
+// @Description	Puts an upload that was blocked, or could not be put in place, at its destination, e.g. after a false positive. The verdict stays in the job's history.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Job ID"
+// @Success		200	{object}	InspectionJob		"Released job"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Failure		409	{object}	docs.ErrorResponse	"Nothing held"
+// @Security	BearerAuth
+// @Router		/admin/inspection/jobs/{id}/release [post]
+func (h *Handler) ReleaseInspectionJob(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	job, apiErr := h.heldJob(c)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	ci := GetContentInspection()
+	if ci == nil {
+		return RespondError(c, ErrInternal("Content inspection is not available"))
+	}
+	if err := ci.release(job); err != nil {
+		return RespondError(c, ErrOperationFailed("release upload", err))
+	}
+	job.Held, job.LastError = false, ""
+	if _, err := h.db.Exec(`UPDATE inspection_jobs SET held_name = NULL, path = $2, last_error = NULL WHERE id = $1`, job.ID, job.Path); err != nil {
+		log.Printf("[Inspection] Failed to record the release of job %d: %v", job.ID, err)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionRelease, "/"+job.Path, map[string]interface{}{
+		"action":  "release",
+		"jobId":   job.ID,
+		"verdict": job.Verdict,
+	})
+	return RespondSuccess(c, job)
+}
+
+// DiscardInspectionJob deletes a held upload
+// @Summary		Discard held upload
+// @Description	Deletes an upload that was blocked, or could not be put in place, from the pending area.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Job ID"
+// @Success		200	{object}	docs.SuccessResponse	"Discarded"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Failure		409	{object}	docs.ErrorResponse	"Nothing held"
+// @Security	BearerAuth
+// @Router		/admin/inspection/jobs/{id}/discard [post]
+func (h *Handler) DiscardInspectionJob(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	job, apiErr := h.heldJob(c)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if err := os.Remove(filepath.Join(h.dataRoot, inspectionHeldDir, job.heldName)); err != nil && !os.IsNotExist(err) {
+		return RespondError(c, ErrOperationFailed("discard upload", err))
+	}
+	if _, err := h.db.Exec(`UPDATE inspection_jobs SET held_name = NULL, last_error = 'Discarded' WHERE id = $1`, job.ID); err != nil {
+		log.Printf("[Inspection] Failed to record the discard of job %d: %v", job.ID, err)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionRelease, "/"+job.Path, map[string]interface{}{
+		"action":  "discard",
+		"jobId":   job.ID,
+		"verdict": job.Verdict,
+	})
+	return RespondSuccess(c, map[string]string{"message": "Upload discarded"})
+}
+
+// ClearInspectionFlag removes the warning badge of a file
+// @Summary		Clear inspection flag
+// @Description	Removes the warning badge of a flagged file after review.
+// @Tags		Admin
+// @Produce		json
+// @Param		path	query		string	true	"Data-root relative path (shared/{drive}/... or users/{username}/...)"
+// @Success		200		{object}	docs.SuccessResponse	"Cleared"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not flagged"
+// @Security	BearerAuth
+// @Router		/admin/inspection/flags [delete]
+func (h *Handler) ClearInspectionFlag(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	rel := strings.TrimPrefix(path.Clean("/"+c.QueryParam("path")), "/")
+	if rel == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	if !GetContentInspection().clearFlag(rel) {
+		return RespondError(c, ErrNotFound("Flag"))
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventInspectionRelease, "/"+rel, map[string]interface{}{
+		"action": "clear_flag",
+	})
+	return RespondSuccess(c, map[string]string{"message": "Flag cleared"})
+}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// stubInspector gives a fixed verdict or error and counts its runs
+type stubInspector struct {
+	verdict string
+	err     error
+	runs    int
+}
+
+func (s *stubInspector) Name() string                                    { return "stub" }
+func (s *stubInspector) Fingerprint(ctx context.Context) (string, error) { return "v1", nil }
+func (s *stubInspector) Inspect(ctx context.Context, target InspectTarget) (InspectorVerdict, error) {
+	s.runs++
+	if s.err != nil {
+		return InspectorVerdict{}, s.err
+	}
+	return InspectorVerdict{Verdict: s.verdict, Reason: s.verdict + " by stub"}, nil
+}
+
+// mapVerdictCache keeps verdicts in memory
+type mapVerdictCache map[string]InspectorVerdict
+
+func (m mapVerdictCache) get(hash, inspector, fingerprint string) (InspectorVerdict, bool) {
+	v, ok := m[hash+"|"+inspector+"|"+fingerprint]
+	return v, ok
+}
+
+func (m mapVerdictCache) put(hash, fingerprint string, v InspectorVerdict) {
+	m[hash+"|"+v.Inspector+"|"+fingerprint] = v
+}
+
+func TestInspectionRun(t *testing.T) {
+	down := errors.New("clamd unreachable")
+	for name, tc := range map[string]struct {
+		first, second *stubInspector
+		blocking      bool
+		held, final   bool
+		failMode      string
+		want          string
+		retry         bool
+		secondRuns    int
+	}{
+		"all clean":                  {first: &stubInspector{verdict: VerdictAllow}, second: &stubInspector{verdict: VerdictAllow}, blocking: true, held: true, want: VerdictAllow, secondRuns: 1},
+		"worst verdict wins":         {first: &stubInspector{verdict: VerdictFlag}, second: &stubInspector{verdict: VerdictAllow}, blocking: true, held: true, want: VerdictFlag, secondRuns: 1},
+		"block stops the chain":      {first: &stubInspector{verdict: VerdictBlock}, second: &stubInspector{verdict: VerdictAllow}, blocking: true, held: true, want: VerdictBlock},
+		"non-blocking only flags":    {first: &stubInspector{verdict: VerdictBlock}, second: &stubInspector{verdict: VerdictAllow}, held: true, want: VerdictFlag, secondRuns: 1},
+		"file in place only flagged": {first: &stubInspector{verdict: VerdictBlock}, second: &stubInspector{verdict: VerdictAllow}, blocking: true, want: VerdictFlag, secondRuns: 1},
+		"unavailable retries":        {first: &stubInspector{err: down}, second: &stubInspector{verdict: VerdictAllow}, blocking: true, held: true, retry: true},
+		"unavailable, fail open":     {first: &stubInspector{err: down}, second: &stubInspector{verdict: VerdictAllow}, blocking: true, held: true, final: true, failMode: InspectionFailOpen, want: VerdictFlag, secondRuns: 1},
+		"unavailable, fail closed":   {first: &stubInspector{err: down}, second: &stubInspector{verdict: VerdictAllow}, blocking: true, held: true, final: true, failMode: InspectionFailClosed, want: VerdictBlock},
+		"fail closed, not blocking":  {first: &stubInspector{err: down}, second: &stubInspector{verdict: VerdictAllow}, held: true, final: true, failMode: InspectionFailClosed, want: VerdictFlag, secondRuns: 1},
+		"second unavailable retries": {first: &stubInspector{verdict: VerdictFlag}, second: &stubInspector{err: down}, blocking: true, held: true, retry: true, secondRuns: 1},
+	} {
+		run := inspectionRun{
+			policy: &InspectionPolicy{FailMode: tc.failMode, Inspectors: []PolicyInspector{
+				{Name: InspectorClamAV, Blocking: tc.blocking}, {Name: InspectorRegexDLP, Blocking: tc.blocking},
+			}},
+			inspectors:   map[string]ContentInspector{InspectorClamAV: tc.first, InspectorRegexDLP: tc.second},
+			timeout:      time.Second,
+			held:         tc.held,
+			finalAttempt: tc.final,
+		}
+		out := run.run(context.Background(), InspectTarget{Name: "a.txt"}, "")
+		if (out.Retry != nil) != tc.retry {
+			t.Errorf("%s: retry = %v", name, out.Retry)
+			continue
+		}
+		if !tc.retry && out.Verdict != tc.want {
+			t.Errorf("%s: verdict = %s, want %s (%+v)", name, out.Verdict, tc.want, out.Results)
+		}
+		if tc.second.runs != tc.secondRuns {
+			t.Errorf("%s: second inspector ran %d times, want %d", name, tc.second.runs, tc.secondRuns)
+		}
+	}
+}
+
+func TestInspectionRun_Cache(t *testing.T) {
+	scanner := &stubInspector{verdict: VerdictFlag}
+	cache := mapVerdictCache{}
+	run := inspectionRun{
+		policy:     &InspectionPolicy{Inspectors: []PolicyInspector{{Name: InspectorClamAV, Blocking: true}}},
+		inspectors: map[string]ContentInspector{InspectorClamAV: scanner},
+		cache:      cache,
+		timeout:    time.Second,
+		held:       true,
+	}
+	for i := 0; i < 2; i++ {
+		out := run.run(context.Background(), InspectTarget{Name: "a.txt"}, "hash")
+		if out.Verdict != VerdictFlag || out.Results[0].Cached != (i == 1) {
+			t.Errorf("run %d = %+v", i, out)
+		}
+	}
+	if scanner.runs != 1 {
+		t.Errorf("inspector ran %d times, want 1", scanner.runs)
+	}
+
+	// Failures are not cached
+	scanner.err = errors.New("down")
+	run.run(context.Background(), InspectTarget{Name: "b.txt"}, "other")
+	if len(cache) != 1 {
+		t.Errorf("cache = %v", cache)
+	}
+}
+
+func TestInspectionBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		10: 30 * time.Minute,
+	} {
+		if got := inspectionBackoff(attempt); got != want {
+			t.Errorf("inspectionBackoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+// fakeClamd answers VERSION and INSTREAM, finding content containing "EICAR"
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, _ := r.ReadString(0)
+				switch cmd {
+				case "zVERSION\x00":
+					conn.Write([]byte("ClamAV 1.2.1/27100/Mon Nov 18 09:32:01 2024\x00"))
+				case "zINSTREAM\x00":
+					var content bytes.Buffer
+					for {
+						var n uint32
+						if binary.Read(r, binary.BigEndian, &n) != nil || n == 0 {
+							break
+						}
+						io.CopyN(&content, r, int64(n))
+					}
+					if strings.Contains(content.String(), "EICAR") {
+						conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+					} else {
+						conn.Write([]byte("stream: OK\x00"))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamAVInspector(t *testing.T) {
+	dir := t.TempDir()
+	clean := filepath.Join(dir, "clean.txt")
+	infected := filepath.Join(dir, "infected.txt")
+	os.WriteFile(clean, bytes.Repeat([]byte("hello "), 30000), 0644)
+	os.WriteFile(infected, []byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"), 0644)
+
+	i := &clamAVInspector{address: fakeClamd(t)}
+	ctx := context.Background()
+	if fp, err := i.Fingerprint(ctx); err != nil || fp != "ClamAV 1.2.1/27100" {
+		t.Errorf("Fingerprint = %q, %v", fp, err)
+	}
+	if v, err := i.Inspect(ctx, InspectTarget{RealPath: clean, Name: "clean.txt"}); err != nil || v.Verdict != VerdictAllow {
+		t.Errorf("clean = %+v, %v", v, err)
+	}
+	v, err := i.Inspect(ctx, InspectTarget{RealPath: infected, Name: "infected.txt"})
+	if err != nil || v.Verdict != VerdictBlock || v.Reason != "Malware: Eicar-Signature" {
+		t.Errorf("infected = %+v, %v", v, err)
+	}
+
+	if _, err := (&clamAVInspector{}).Fingerprint(ctx); !errors.Is(err, errInspectorNotConfigured) {
+		t.Errorf("unconfigured = %v", err)
+	}
+	if _, err := (&clamAVInspector{address: "127.0.0.1:1"}).Inspect(ctx, InspectTarget{RealPath: clean}); err == nil {
+		t.Error("expected an error when clamd is unreachable")
+	}
+}
+
+func TestRegexDLPInspector(t *testing.T) {
+	dir := t.TempDir()
+	i := &regexDLPInspector{maxBytes: 1 << 20, patterns: compileDLPPatterns([]*DLPPattern{
+		{Name: "Resident registration number", Pattern: `\b\d{2}(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])-[1-4]\d{6}\b`, Verdict: VerdictFlag, Enabled: true},
+		{Name: "Secret key", Pattern: `SECRET-[A-Z]{4}`, Verdict: VerdictBlock, Enabled: true},
+		{Name: "Disabled", Pattern: `hello`, Verdict: VerdictBlock, Enabled: false},
+	})}
+
+	text := filepath.Join(dir, "list.csv")
+	os.WriteFile(text, []byte("kim,900101-1234567\nlee,850505-2345678\nhello\n"), 0644)
+	v, err := i.Inspect(context.Background(), InspectTarget{RealPath: text, Name: "list.csv"})
+	if err != nil || v.Verdict != VerdictFlag || v.Reason != "Sensitive data: Resident registration number (2)" {
+		t.Errorf("csv = %+v, %v", v, err)
+	}
+	if strings.Contains(v.Reason, "900101") {
+		t.Error("reason must not include the matched text")
+	}
+
+	// Office documents are scanned by their text, across markup
+	docx := filepath.Join(dir, "memo.docx")
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("word/document.xml")
+	w.Write([]byte(`<w:document><w:p><w:r><w:t>SECRET-</w:t></w:r><w:r><w:t>ABCD</w:t></w:r></w:p></w:document>`))
+	zw.Close()
+	os.WriteFile(docx, buf.Bytes(), 0644)
+	v, err = i.Inspect(context.Background(), InspectTarget{RealPath: docx, Name: "memo.docx"})
+	if err != nil || v.Verdict != VerdictBlock || v.Reason != "Sensitive data: Secret key (1)" {
+		t.Errorf("docx = %+v, %v", v, err)
+	}
+
+	// The fingerprint follows the pattern set
+	fp1, _ := i.Fingerprint(context.Background())
+	other := &regexDLPInspector{maxBytes: 1 << 20, patterns: i.patterns[:1]}
+	if fp2, _ := other.Fingerprint(context.Background()); fp1 == fp2 {
+		t.Error("fingerprint did not change with the patterns")
+	}
+}
+
+func TestSizeTypeInspector(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content []byte) InspectTarget {
+		p := filepath.Join(dir, name)
+		os.WriteFile(p, content, 0644)
+		return InspectTarget{RealPath: p, Name: name}
+	}
+	i := &sizeTypeInspector{maxBytes: 1024, blocked: parseExtensionList("EXE, .scr")}
+	for name, tc := range map[string]struct {
+		target InspectTarget
+		want   string
+	}{
+		"blocked extension":  {write("setup.exe", []byte("MZ\x90\x00")), VerdictBlock},
+		"too large":          {write("big.txt", bytes.Repeat([]byte("a"), 2048)), VerdictBlock},
+		"executable renamed": {write("invoice.txt", []byte("MZ\x90\x00\x03")), VerdictFlag},
+		"fake pdf":           {write("report.pdf", []byte("hello world")), VerdictFlag},
+		"real pdf":           {write("real.pdf", []byte("%PDF-1.7\n")), VerdictAllow},
+		"png":                {write("image.png", []byte("\x89PNG\r\n\x1a\n")), VerdictAllow},
+		"plain text":         {write("notes.txt", []byte("hello")), VerdictAllow},
+	} {
+		v, err := i.Inspect(context.Background(), tc.target)
+		if err != nil || v.Verdict != tc.want {
+			t.Errorf("%s: %+v, %v, want %s", name, v, err, tc.want)
+		}
+	}
+}
+
+// withContentInspection installs a pipeline with the given policies and
+// inspectors for the duration of the test
+func withContentInspection(t *testing.T, tc *TestContext, dataRoot string, inspectors map[string]ContentInspector, policies ...*InspectionPolicy) *ContentInspection {
+	t.Helper()
+	useCachedSettings(t, map[string]string{settingInspectionMaxAttempts: "2", settingInspectionTimeout: "5"})
+	p := newContentInspection(tc.DB, dataRoot, nil)
+	p.audit = nil
+	p.cache = mapVerdictCache{}
+	p.inspectors = func() map[string]ContentInspector { return inspectors }
+	for _, pol := range policies {
+		p.policies[pol.Path] = pol
+	}
+	prev := globalContentInspection
+	globalContentInspection = p
+	t.Cleanup(func() { globalContentInspection = prev })
+	return p
+}
+
+func TestContentInspection_HoldAndRelease(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	scanner := &stubInspector{verdict: VerdictAllow}
+	p := withContentInspection(t, tc, dataRoot, map[string]ContentInspector{InspectorClamAV: scanner},
+		&InspectionPolicy{ID: "p1", Path: "shared/Inbox", Inspectors: []PolicyInspector{{Name: InspectorClamAV, Blocking: true}}})
+
+	var placed string
+	RegisterUploadPlacer("test", func(heldPath string, upload *InspectedUpload) (string, error) {
+		placed = filepath.Join(dataRoot, "shared/Inbox", upload.Filename)
+		return placed, finalizeUpload(heldPath, placed)
+	})
+	os.MkdirAll(filepath.Join(dataRoot, "shared/Inbox"), 0755)
+	src := filepath.Join(dataRoot, ".uploads", "u1")
+	os.MkdirAll(filepath.Dir(src), 0755)
+	os.WriteFile(src, []byte("report"), 0644)
+
+	// Uploads outside a policy are put in place right away
+	if p.Hold(src, filepath.Join(dataRoot, "users/alice"), &InspectedUpload{Source: "test", Filename: "a.txt"}, nil) {
+		t.Fatal("held an upload without a policy")
+	}
+
+	upload := &InspectedUpload{Source: "test", Filename: "report.txt", Size: 6}
+	tc.Mock.ExpectQuery("INSERT INTO inspection_jobs").
+		WithArgs("p1", "shared/Inbox/report.txt", sqlmock.AnyArg(), sqlmock.AnyArg(), int64(6), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	if !p.Hold(src, filepath.Join(dataRoot, "shared/Inbox"), upload, nil) {
+		t.Fatal("upload was not held")
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("upload is still staged")
+	}
+
+	policyID := "p1"
+	job := &InspectionJob{ID: 7, PolicyID: &policyID, Path: "shared/Inbox/report.txt", Upload: upload, Attempts: 1}
+	entries, _ := os.ReadDir(filepath.Join(dataRoot, inspectionHeldDir))
+	if len(entries) != 1 {
+		t.Fatalf("pending area = %v", entries)
+	}
+	job.heldName = entries[0].Name()
+
+	tc.Mock.ExpectExec("UPDATE inspection_jobs").
+		WithArgs(int64(7), InspectionDone, VerdictAllow, sqlmock.AnyArg(), sqlmock.AnyArg(), "shared/Inbox/report.txt", nil, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	p.process(job)
+
+	if placed == "" || job.Held {
+		t.Fatalf("upload was not put in place: %+v", job)
+	}
+	if data, err := os.ReadFile(placed); err != nil || string(data) != "report" {
+		t.Errorf("placed file = %q, %v", data, err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContentInspection_BlockedStaysHeld(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	p := withContentInspection(t, tc, dataRoot,
+		map[string]ContentInspector{InspectorClamAV: &stubInspector{err: errors.New("clamd unreachable")}},
+		&InspectionPolicy{ID: "p1", Path: "shared/Inbox", FailMode: InspectionFailClosed,
+			Inspectors: []PolicyInspector{{Name: InspectorClamAV, Blocking: true}}})
+	os.MkdirAll(filepath.Join(dataRoot, inspectionHeldDir), 0700)
+	os.WriteFile(p.heldPath("h1"), []byte("payload"), 0600)
+	policyID := "p1"
+	job := &InspectionJob{ID: 3, PolicyID: &policyID, Path: "shared/Inbox/x.bin", heldName: "h1",
+		Upload: &InspectedUpload{Source: "test", Filename: "x.bin"}, Attempts: 1}
+
+	// The first attempt is retried
+	tc.Mock.ExpectExec("UPDATE inspection_jobs").
+		WithArgs(int64(3), InspectionQueued, float64(30), "clamav: clamd unreachable", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	p.process(job)
+
+	// The last attempt fails closed
+	job.Attempts = 2
+	tc.Mock.ExpectExec("UPDATE inspection_jobs").
+		WithArgs(int64(3), InspectionDone, VerdictBlock, sqlmock.AnyArg(), sqlmock.AnyArg(), "shared/Inbox/x.bin", "h1", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	p.process(job)
+
+	if !job.Held || job.Verdict != VerdictBlock || !job.Results[0].Unavailable {
+		t.Errorf("job = %+v", job)
+	}
+	if _, err := os.Stat(p.heldPath("h1")); err != nil {
+		t.Error("blocked upload left the pending area")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
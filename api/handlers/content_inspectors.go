@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inspector names
+const (
+	InspectorClamAV   = "clamav"
+	InspectorRegexDLP = "regex-dlp"
+	InspectorSizeType = "size-type"
+)
+
+// inspectorNames lists the inspectors a policy can use
+var inspectorNames = map[string]bool{InspectorClamAV: true, InspectorRegexDLP: true, InspectorSizeType: true}
+
+// InspectTarget is a file to inspect
+type InspectTarget struct {
+	RealPath string // File to read; encrypted files are decrypted
+	Name     string // Name the file was uploaded as; held files are stored under a token
+}
+
+// ContentInspector checks the content of a file
+type ContentInspector interface {
+	Name() string
+	// Fingerprint identifies the inspector's configuration (signature
+	// version, pattern set); cached verdicts of another one are not reused.
+	// An error means the inspector is unavailable.
+	Fingerprint(ctx context.Context) (string, error)
+	// Inspect returns the inspector's verdict; an error means the inspector
+	// is unavailable and the inspection is retried
+	Inspect(ctx context.Context, target InspectTarget) (InspectorVerdict, error)
+}
+
+// errInspectorNotConfigured is returned by inspectors without settings
+var errInspectorNotConfigured = errors.New("inspector is not configured")
+
+// ---------------------------------------------------------------------------
+// clamav
+// ---------------------------------------------------------------------------
+
+// clamAVChunkSize is the INSTREAM chunk size; clamd's StreamMaxLength
+// bounds the total
+const clamAVChunkSize = 64 * 1024
+
+// clamAVVersionTTL is how long the signature version is reused as the
+// fingerprint before clamd is asked again
+const clamAVVersionTTL = time.Minute
+
+// clamAVInspector scans files with clamd over its INSTREAM protocol
+type clamAVInspector struct {
+	address string // host:port, or a unix socket path
+
+	mu        sync.Mutex
+	version   string
+	versionAt time.Time
+}
+
+var clamAVInspectors sync.Map // address -> *clamAVInspector, to share the version cache
+
+// newClamAVInspector returns the inspector for a clamd address
+func newClamAVInspector(address string) *clamAVInspector {
+	i, _ := clamAVInspectors.LoadOrStore(address, &clamAVInspector{address: address})
+	return i.(*clamAVInspector)
+}
+
+func (i *clamAVInspector) Name() string { return InspectorClamAV }
+
+// dial connects to clamd
+func (i *clamAVInspector) dial(ctx context.Context) (net.Conn, error) {
+	if i.address == "" {
+		return nil, errInspectorNotConfigured
+	}
+	network := "tcp"
+	if strings.HasPrefix(i.address, "/") {
+		network = "unix"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, i.address)
+	if err != nil {
+		return nil, fmt.Errorf("clamd unreachable: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// command sends a null-terminated command and reads the reply
+func (i *clamAVInspector) command(ctx context.Context, cmd string, body func(w io.Writer) error) (string, error) {
+	conn, err := i.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("z" + cmd + "\x00")); err != nil {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	if body != nil {
+		if err := body(conn); err != nil {
+			return "", err
+		}
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("clamd: %w", err)
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// Fingerprint is the signature database version, so verdicts are redone
+// after signature updates
+func (i *clamAVInspector) Fingerprint(ctx context.Context) (string, error) {
+	i.mu.Lock()
+	if i.version != "" && time.Since(i.versionAt) < clamAVVersionTTL {
+		defer i.mu.Unlock()
+		return i.version, nil
+	}
+	i.mu.Unlock()
+
+	reply, err := i.command(ctx, "VERSION", nil)
+	if err != nil {
+		return "", err
+	}
+	// ClamAV 1.2.1/27100/Mon Nov 18 09:32:01 2024
+	version := reply
+	if parts := strings.Split(reply, "/"); len(parts) >= 2 {
+		version = parts[0] + "/" + parts[1]
+	}
+	i.mu.Lock()
+	i.version, i.versionAt = version, time.Now()
+	i.mu.Unlock()
+	return version, nil
+}
+
+// Inspect streams the file to clamd; a signature match blocks the file
+func (i *clamAVInspector) Inspect(ctx context.Context, target InspectTarget) (InspectorVerdict, error) {
+	f, err := OpenPlain(target.RealPath)
+	if err != nil {
+		return InspectorVerdict{}, err
+	}
+	defer f.Close()
+
+	reply, err := i.command(ctx, "INSTREAM", func(w io.Writer) error {
+		buf := make([]byte, 4+clamAVChunkSize)
+		for {
+			n, err := f.Read(buf[4:])
+			if n > 0 {
+				binary.BigEndian.PutUint32(buf[:4], uint32(n))
+				if _, werr := w.Write(buf[:4+n]); werr != nil {
+					return fmt.Errorf("clamd: %w", werr)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err := w.Write([]byte{0, 0, 0, 0})
+		return err
+	})
+	if err != nil {
+		return InspectorVerdict{}, err
+	}
+
+	// stream: OK | stream: {signature} FOUND | {message} ERROR
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return InspectorVerdict{Verdict: VerdictAllow}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return InspectorVerdict{Verdict: VerdictBlock, Reason: "Malware: " + strings.TrimSuffix(reply, " FOUND")}, nil
+	case strings.Contains(reply, "size limit exceeded"):
+		// Too large for clamd to scan, which is configuration rather than an outage
+		return InspectorVerdict{Verdict: VerdictFlag, Reason: "Not scanned: larger than clamd's StreamMaxLength"}, nil
+	default:
+		return InspectorVerdict{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// regex-dlp
+// ---------------------------------------------------------------------------
+
+// DLPPattern is a regular expression the regex-dlp inspector looks for
+type DLPPattern struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Pattern   string    `json:"pattern"` // Go regexp syntax
+	Verdict   string    `json:"verdict"` // flag or block
+	Enabled   bool      `json:"enabled"`
+	CreatedBy *string   `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	re *regexp.Regexp
+}
+
+// dlpMarkupTag matches the tags between the text runs of office XML
+var dlpMarkupTag = regexp.MustCompile(`<[^>]*>`)
+
+// regexDLPInspector looks for patterns in file contents. Office documents
+// (zip of XML) are searched in their XML parts with the markup removed, as
+// their text is compressed and split into runs.
+type regexDLPInspector struct {
+	patterns []*DLPPattern // Enabled, compiled
+	maxBytes int64         // Content searched per file
+}
+
+func (i *regexDLPInspector) Name() string { return InspectorRegexDLP }
+
+// Fingerprint hashes the pattern set and limit
+func (i *regexDLPInspector) Fingerprint(ctx context.Context) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", i.maxBytes)
+	for _, p := range i.patterns {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\n", p.Name, p.Pattern, p.Verdict)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Inspect reports which patterns matched and how often, never the matches
+func (i *regexDLPInspector) Inspect(ctx context.Context, target InspectTarget) (InspectorVerdict, error) {
+	if len(i.patterns) == 0 {
+		return InspectorVerdict{Verdict: VerdictAllow}, nil
+	}
+	content, err := i.readContent(target)
+	if err != nil {
+		return InspectorVerdict{}, err
+	}
+
+	verdict := InspectorVerdict{Verdict: VerdictAllow}
+	var found []string
+	for _, p := range i.patterns {
+		if err := ctx.Err(); err != nil {
+			return InspectorVerdict{}, err
+		}
+		n := len(p.re.FindAllIndex(content, -1))
+		if n == 0 {
+			continue
+		}
+		found = append(found, fmt.Sprintf("%s (%d)", p.Name, n))
+		verdict.Verdict = worseVerdict(verdict.Verdict, p.Verdict)
+	}
+	if len(found) > 0 {
+		verdict.Reason = "Sensitive data: " + strings.Join(found, ", ")
+	}
+	return verdict, nil
+}
+
+// readContent returns up to maxBytes of text to search
+func (i *regexDLPInspector) readContent(target InspectTarget) ([]byte, error) {
+	f, err := OpenPlain(target.RealPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if isOfficeZip(target.Name) {
+		if info, err := f.Stat(); err == nil {
+			if zr, err := zip.NewReader(f, info.Size()); err == nil {
+				return i.readOfficeText(zr), nil
+			}
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(io.LimitReader(f, i.maxBytes))
+}
+
+// readOfficeText joins the text of an office document's XML parts
+func (i *regexDLPInspector) readOfficeText(zr *zip.Reader) []byte {
+	var buf bytes.Buffer
+	for _, zf := range zr.File {
+		if !strings.HasSuffix(zf.Name, ".xml") {
+			continue
+		}
+		remaining := i.maxBytes - int64(buf.Len())
+		if remaining <= 0 {
+			break
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			continue
+		}
+		part, _ := io.ReadAll(io.LimitReader(rc, remaining))
+		rc.Close()
+		buf.Write(dlpMarkupTag.ReplaceAll(part, nil))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// isOfficeZip reports whether a file name is a zip-based office format
+func isOfficeZip(name string) bool {
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(name), ".")) {
+	case "docx", "xlsx", "pptx", "odt", "ods", "odp":
+		return true
+	}
+	return false
+}
+
+// compileDLPPatterns compiles the enabled patterns, skipping invalid ones
+func compileDLPPatterns(patterns []*DLPPattern) []*DLPPattern {
+	compiled := make([]*DLPPattern, 0, len(patterns))
+	for _, p := range patterns {
+		if !p.Enabled {
+			continue
+		}
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			continue
+		}
+		c := *p
+		c.re = re
+		compiled = append(compiled, &c)
+	}
+	return compiled
+}
+
+// ---------------------------------------------------------------------------
+// size-type
+// ---------------------------------------------------------------------------
+
+// executableExtensions are where executable content is expected
+var executableExtensions = map[string]bool{
+	"exe": true, "dll": true, "com": true, "msi": true, "sys": true, "scr": true,
+	"bin": true, "so": true, "elf": true, "out": true, "app": true, "dylib": true, "o": true,
+}
+
+// contentSignatures are the leading bytes files of an extension must start with
+var contentSignatures = map[string][]string{
+	"pdf":  {"%PDF-"},
+	"png":  {"\x89PNG\r\n\x1a\n"},
+	"jpg":  {"\xff\xd8\xff"},
+	"jpeg": {"\xff\xd8\xff"},
+	"gif":  {"GIF87a", "GIF89a"},
+	"zip":  {"PK\x03\x04", "PK\x05\x06"},
+	"docx": {"PK\x03\x04"},
+	"xlsx": {"PK\x03\x04"},
+	"pptx": {"PK\x03\x04"},
+}
+
+// executableSignatures start PE, ELF and Mach-O executables
+var executableSignatures = []string{"MZ", "\x7fELF", "\xfe\xed\xfa\xce", "\xfe\xed\xfa\xcf", "\xce\xfa\xed\xfe", "\xcf\xfa\xed\xfe"}
+
+// sizeTypeInspector checks size, extension and that the content matches the
+// extension
+type sizeTypeInspector struct {
+	maxBytes int64           // 0 = no limit
+	blocked  map[string]bool // Extensions without dot
+}
+
+func (i *sizeTypeInspector) Name() string { return InspectorSizeType }
+
+// Fingerprint describes the limits
+func (i *sizeTypeInspector) Fingerprint(ctx context.Context) (string, error) {
+	exts := make([]string, 0, len(i.blocked))
+	for ext := range i.blocked {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s", i.maxBytes, strings.Join(exts, ","))))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Inspect blocks files over the size limit or with a blocked extension, and
+// flags content that does not match its extension
+func (i *sizeTypeInspector) Inspect(ctx context.Context, target InspectTarget) (InspectorVerdict, error) {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(target.Name), "."))
+	if i.blocked[ext] {
+		return InspectorVerdict{Verdict: VerdictBlock, Reason: fmt.Sprintf("Extension .%s is not allowed", ext)}, nil
+	}
+
+	f, err := OpenPlain(target.RealPath)
+	if err != nil {
+		return InspectorVerdict{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return InspectorVerdict{}, err
+	}
+	if i.maxBytes > 0 && info.Size() > i.maxBytes {
+		return InspectorVerdict{Verdict: VerdictBlock, Reason: fmt.Sprintf("Larger than %s", formatFileSize(i.maxBytes))}, nil
+	}
+
+	head := make([]byte, 16)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return InspectorVerdict{}, err
+	}
+	head = head[:n]
+	if n == 0 {
+		return InspectorVerdict{Verdict: VerdictAllow}, nil
+	}
+	if !executableExtensions[ext] && hasAnyPrefix(head, executableSignatures) {
+		return InspectorVerdict{Verdict: VerdictFlag, Reason: fmt.Sprintf("Executable content in a .%s file", ext)}, nil
+	}
+	if signatures, ok := contentSignatures[ext]; ok && !hasAnyPrefix(head, signatures) {
+		return InspectorVerdict{Verdict: VerdictFlag, Reason: fmt.Sprintf("Content does not match the .%s extension", ext)}, nil
+	}
+	return InspectorVerdict{Verdict: VerdictAllow}, nil
+}
+
+// hasAnyPrefix reports whether b starts with one of the prefixes
+func hasAnyPrefix(b []byte, prefixes []string) bool {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(b, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseExtensionList parses a comma-separated extension setting
+func parseExtensionList(value string) map[string]bool {
+	exts := make(map[string]bool)
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			exts[ext] = true
+		}
+	}
+	return exts
+}
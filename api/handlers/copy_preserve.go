@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyPreserve selects what a copy keeps of the source besides content and
+// mode
+type copyPreserve struct {
+	Attributes bool // modification times (of folders after their contents) and extended attributes
+	Owner      bool // owner and group, where the process may set them
+}
+
+// preserveAll is what a cross-filesystem move keeps: everything
+var preserveAll = copyPreserve{Attributes: true, Owner: true}
+
+// copyPreserveFor is what a copy from srcDisplayPath to destDisplayPath
+// keeps. Ownership only carries over within one home or shared drive; a
+// copy into another area belongs to the server like new files do.
+func copyPreserveFor(attributes bool, srcDisplayPath, destDisplayPath string) copyPreserve {
+	keep := copyPreserve{Attributes: attributes}
+	if _, _, mounted := GetMountIns().Resolve(srcDisplayPath); !mounted {
+		keep.Owner = attributes && storageAreaOf(srcDisplayPath) == storageAreaOf(destDisplayPath)
+	}
+	return keep
+}
+
+// storageAreaOf returns the home or shared drive a display path is in
+func storageAreaOf(displayPath string) string {
+	parts := strings.SplitN(strings.TrimPrefix(path.Clean(displayPath), "/"), "/", 3)
+	if parts[0] == "shared" && len(parts) > 1 {
+		return "/shared/" + parts[1]
+	}
+	return "/" + parts[0]
+}
+
+// preserveAttrs gives dst what keep selects of src: extended attributes,
+// ownership and, last so nothing changes it again, the modification time.
+// Attributes the filesystem cannot store and ownership the process may not
+// set are skipped.
+func preserveAttrs(src string, srcInfo os.FileInfo, dst string, keep copyPreserve) error {
+	if keep.Attributes {
+		copyXattrs(src, dst)
+	}
+	if keep.Owner {
+		if st, ok := srcInfo.Sys().(*syscall.Stat_t); ok {
+			_ = os.Lchown(dst, int(st.Uid), int(st.Gid))
+		}
+	}
+	if keep.Attributes {
+		return os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+	}
+	return nil
+}
+
+// copyXattrs copies the extended attributes of src to dst, such as the DOS
+// attributes Samba keeps in user.DOSATTRIB
+func copyXattrs(src, dst string) {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil || size <= 0 {
+		return
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(src, buf); err != nil {
+		return
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		n, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(src, name, value); err != nil {
+			continue
+		}
+		if err := unix.Lsetxattr(dst, name, value[:n], 0); errors.Is(err, unix.ENOTSUP) {
+			return // The destination filesystem keeps none
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyDir_PreservesTimesAndXattrs(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "docs")
+	writeTestFiles(t, src, "a.txt", "deep/b.txt")
+	old := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, p := range []string{"a.txt", "deep/b.txt", "deep", "."} {
+		if err := os.Chtimes(filepath.Join(src, p), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	xattrs := unix.Setxattr(filepath.Join(src, "a.txt"), "user.DOSATTRIB", []byte("0x20"), 0) == nil
+
+	dst := filepath.Join(t.TempDir(), "docs")
+	if err := copyDir(src, dst, copyPreserve{Attributes: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a.txt", "deep/b.txt", "deep", "."} {
+		if info, err := os.Stat(filepath.Join(dst, p)); err != nil || !info.ModTime().Equal(old) {
+			t.Errorf("%s: mtime not kept (%v)", p, err)
+		}
+	}
+	if xattrs {
+		buf := make([]byte, 16)
+		if n, err := unix.Getxattr(filepath.Join(dst, "a.txt"), "user.DOSATTRIB", buf); err != nil || string(buf[:n]) != "0x20" {
+			t.Errorf("xattr not kept: %q, %v", buf[:n], err)
+		}
+	}
+
+	// Without preservation the copy is new
+	plain := filepath.Join(t.TempDir(), "docs")
+	if err := copyDir(src, plain, copyPreserve{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(filepath.Join(plain, "a.txt")); info.ModTime().Equal(old) {
+		t.Error("mtime kept without preservation")
+	}
+	if xattrs {
+		if _, err := unix.Getxattr(filepath.Join(plain, "a.txt"), "user.DOSATTRIB", nil); !errors.Is(err, unix.ENODATA) {
+			t.Errorf("xattr copied without preservation: %v", err)
+		}
+	}
+}
+
+func TestCopyItem_PreserveAttributes(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	ftc.CreateTestFile(t, filepath.Join(userDir, "docs", "a.txt"), []byte("a"))
+	ftc.CreateTestFolder(t, filepath.Join(userDir, "kept"))
+	ftc.CreateTestFolder(t, filepath.Join(userDir, "fresh"))
+	old := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	_ = os.Chtimes(filepath.Join(userDir, "docs", "a.txt"), old, old)
+
+	for range 2 {
+		ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+		ftc.Mock.ExpectExec("UPDATE users SET storage_used").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	off := false
+	AssertStatus(t, moveOrCopy(t, ftc, true, "home/docs", CopyRequest{Destination: "/home/kept"}), http.StatusOK)
+	AssertStatus(t, moveOrCopy(t, ftc, true, "home/docs", CopyRequest{Destination: "/home/fresh", PreserveAttributes: &off}), http.StatusOK)
+
+	if info, err := os.Stat(filepath.Join(userDir, "kept", "docs", "a.txt")); err != nil || !info.ModTime().Equal(old) {
+		t.Error("default copy did not keep the modification time")
+	}
+	if info, err := os.Stat(filepath.Join(userDir, "fresh", "docs", "a.txt")); err != nil || info.ModTime().Equal(old) {
+		t.Error("preserveAttributes=false kept the modification time")
+	}
+	if err := ftc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStorageAreaOf(t *testing.T) {
+	for path, want := range map[string]string{
+		"/home/docs/a.txt":  "/home",
+		"/shared/team/a":    "/shared/team",
+		"/shared/team":      "/shared/team",
+		"/shared/other/x/y": "/shared/other",
+	} {
+		if got := storageAreaOf(path); got != want {
+			t.Errorf("storageAreaOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
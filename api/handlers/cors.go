@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CORS route groups. Each group can override the default origin list.
+const (
+	CORSGroupDefault = "default"
+	CORSGroupShare   = "share" // Public share links: /api/s, /api/e, /api/u
+	CORSGroupAdmin   = "admin" // /api/admin
+)
+
+// CORS settings keys
+const (
+	settingCORSOrigins      = "cors_allowed_origins"
+	settingCORSShareOrigins = "cors_share_origins"
+	settingCORSAdminOrigins = "cors_admin_origins"
+	settingCORSTrustProxy   = "cors_trust_proxy"
+)
+
+// corsWildcard allows any origin. Credentials are never allowed, so this only
+// opens endpoints that do not rely on cookies.
+const corsWildcard = "*"
+
+var corsAllowMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodHead, http.MethodOptions,
+}
+
+var corsAllowHeaders = []string{
+	"Accept",
+	"Accept-Language",
+	"Authorization",
+	"Content-Type",
+	"Content-Length",
+	"X-Requested-With",
+	"Origin",
+	"Cache-Control",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Match",
+	"Content-Range",
+	"X-Write-Mode",
+	ShareSessionHeader,
+	// tus request headers
+	"Upload-Length",
+	"Upload-Offset",
+	"Tus-Resumable",
+	"Upload-Metadata",
+	"Upload-Defer-Length",
+	"Upload-Concat",
+	"Upload-Checksum",
+	"X-HTTP-Method-Override",
+}
+
+var corsExposeHeaders = []string{
+	// tus response headers; tus clients read these cross-origin
+	"Upload-Offset",
+	"Location",
+	"Upload-Length",
+	"Tus-Version",
+	"Tus-Resumable",
+	"Tus-Max-Size",
+	"Tus-Extension",
+	"Tus-Checksum-Algorithm",
+	"Upload-Metadata",
+	"Upload-Defer-Length",
+	"Upload-Concat",
+	"Upload-Expires",
+	"ETag",
+	"Last-Modified",
+	"Content-Disposition",
+	APIVersionHeader,
+	DirEntryWarningHeader,
+	UploadFolderHeader,
+}
+
+// corsGroupPrefixes maps request path prefixes to route groups
+var corsGroupPrefixes = []struct {
+	prefix string
+	group  string
+}{
+	{"/api/admin/", CORSGroupAdmin},
+	{"/api/s/", CORSGroupShare},
+	{"/api/e/", CORSGroupShare},
+	{"/api/u/", CORSGroupShare},
+}
+
+// devCORSOrigins are allowed when nothing is configured outside production
+var devCORSOrigins = []string{
+	"http://localhost:3000",
+	"http://localhost:3080",
+	"http://localhost:5173",
+	"http://127.0.0.1:3000",
+	"http://127.0.0.1:3080",
+	"http://127.0.0.1:5173",
+}
+
+// CORSGroupStatus is the effective origin list of one route group
+type CORSGroupStatus struct {
+	Origins      []string `json:"origins"`
+	Inherited    bool     `json:"inherited"` // No override; uses the default group
+	PathPrefixes []string `json:"pathPrefixes,omitempty"`
+}
+
+// CORSStatus is the effective CORS configuration, shown on the admin system info endpoint
+type CORSStatus struct {
+	Source         string                     `json:"source"` // settings, env or defaults
+	TrustProxy     bool                       `json:"trustProxy"`
+	ExternalOrigin string                     `json:"externalOrigin,omitempty"` // Added to every group when trustProxy is on
+	Groups         map[string]CORSGroupStatus `json:"groups"`
+	AllowMethods   []string                   `json:"allowMethods"`
+	AllowHeaders   []string                   `json:"allowHeaders"`
+	ExposeHeaders  []string                   `json:"exposeHeaders"`
+	Invalid        []string                   `json:"invalid,omitempty"` // Stored entries that were ignored
+}
+
+// corsPolicy is the parsed origin configuration
+type corsPolicy struct {
+	source     string
+	trustProxy bool
+	groups     map[string][]string
+	overridden map[string]bool
+	invalid    []string
+}
+
+// corsPolicyCache avoids re-parsing origin lists on every request. Settings
+// reads are cached by SettingsHandler and invalidated on update, so changes
+// apply without a restart.
+var corsPolicyCache struct {
+	mu     sync.Mutex
+	raw    string
+	policy *corsPolicy
+}
+
+// NormalizeCORSOrigin validates an origin entry and returns it as scheme://host[:port]
+func NormalizeCORSOrigin(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == corsWildcard {
+		return entry, nil
+	}
+	u, err := url.Parse(entry)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid origin %q: expected scheme://host[:port]", entry)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid origin %q: scheme must be http or https", entry)
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.Opaque != "" {
+		return "", fmt.Errorf("invalid origin %q: only scheme and host are allowed, no path", entry)
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// parseCORSOrigins splits a comma-separated origin list, returning valid
+// entries and the entries that were rejected
+func parseCORSOrigins(raw string) (origins []string, invalid []string) {
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(raw, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		origin, err := NormalizeCORSOrigin(entry)
+		if err != nil {
+			invalid = append(invalid, strings.TrimSpace(entry))
+			continue
+		}
+		if !seen[origin] {
+			seen[origin] = true
+			origins = append(origins, origin)
+		}
+	}
+	return origins, invalid
+}
+
+// ValidateCORSSetting checks a CORS setting before it is saved.
+// Other keys are accepted unchanged.
+func ValidateCORSSetting(key, value string) error {
+	switch key {
+	case settingCORSOrigins, settingCORSShareOrigins, settingCORSAdminOrigins:
+		for _, entry := range strings.Split(value, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			if _, err := NormalizeCORSOrigin(entry); err != nil {
+				return err
+			}
+		}
+	case settingCORSTrustProxy:
+		switch value {
+		case "", "true", "false", "1", "0", "yes", "no":
+		default:
+			return fmt.Errorf("invalid value %q for %s: expected true or false", value, key)
+		}
+	}
+	return nil
+}
+
+// CORSGroupForPath returns the route group a request path belongs to
+func CORSGroupForPath(p string) string {
+	for _, g := range corsGroupPrefixes {
+		if strings.HasPrefix(p, g.prefix) {
+			return g.group
+		}
+	}
+	return CORSGroupDefault
+}
+
+// loadCORSPolicy reads the current CORS settings.
+// Precedence for the default group: setting, CORS_ALLOWED_ORIGINS, development defaults.
+func loadCORSPolicy() *corsPolicy {
+	var defaults, shareRaw, adminRaw string
+	trustProxy := false
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		defaults, _ = sh.GetSetting(settingCORSOrigins)
+		shareRaw, _ = sh.GetSetting(settingCORSShareOrigins)
+		adminRaw, _ = sh.GetSetting(settingCORSAdminOrigins)
+		trustProxy = sh.GetSettingBool(settingCORSTrustProxy, false)
+	}
+
+	source := "settings"
+	if strings.TrimSpace(defaults) == "" {
+		defaults = os.Getenv("CORS_ALLOWED_ORIGINS")
+		source = "env"
+	}
+	if strings.TrimSpace(defaults) == "" {
+		source = "defaults"
+		if os.Getenv("FH_ENV") != "production" {
+			defaults = strings.Join(devCORSOrigins, ",")
+		}
+	}
+
+	raw := strings.Join([]string{source, defaults, shareRaw, adminRaw, fmt.Sprint(trustProxy)}, "\n")
+	corsPolicyCache.mu.Lock()
+	defer corsPolicyCache.mu.Unlock()
+	if corsPolicyCache.policy != nil && corsPolicyCache.raw == raw {
+		return corsPolicyCache.policy
+	}
+
+	p := &corsPolicy{
+		source:     source,
+		trustProxy: trustProxy,
+		groups:     make(map[string][]string),
+		overridden: make(map[string]bool),
+	}
+	origins, invalid := parseCORSOrigins(defaults)
+	p.groups[CORSGroupDefault] = origins
+	p.invalid = append(p.invalid, invalid...)
+	for group, override := range map[string]string{CORSGroupShare: shareRaw, CORSGroupAdmin: adminRaw} {
+		if strings.TrimSpace(override) == "" {
+			p.groups[group] = origins
+			continue
+		}
+		groupOrigins, invalid := parseCORSOrigins(override)
+		p.groups[group] = groupOrigins
+		p.overridden[group] = true
+		p.invalid = append(p.invalid, invalid...)
+	}
+	if len(p.invalid) > 0 {
+		log.Printf("CORS: Ignoring invalid origins: %v", p.invalid)
+	}
+
+	corsPolicyCache.raw = raw
+	corsPolicyCache.policy = p
+	return p
+}
+
+// externalOrigin is the origin clients reach the server on behind a proxy
+func externalOrigin(c echo.Context) string {
+	host := getExternalHost(c)
+	if host == "" {
+		return ""
+	}
+	return strings.ToLower(getExternalScheme(c) + "://" + host)
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request, or ""
+func (p *corsPolicy) allowedOrigin(c echo.Context, origin string) string {
+	normalized := strings.ToLower(origin)
+	for _, o := range p.groups[CORSGroupForPath(c.Request().URL.Path)] {
+		if o == corsWildcard {
+			return corsWildcard
+		}
+		if o == normalized {
+			return origin
+		}
+	}
+	if p.trustProxy && externalOrigin(c) == normalized {
+		return origin
+	}
+	return ""
+}
+
+// CORSMiddleware applies the CORS policy from system settings. The origin list
+// is resolved per request so settings changes take effect immediately.
+func CORSMiddleware() echo.MiddlewareFunc {
+	allowMethods := strings.Join(corsAllowMethods, ",")
+	allowHeaders := strings.Join(corsAllowHeaders, ",")
+	exposeHeaders := strings.Join(corsExposeHeaders, ",")
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			header := c.Response().Header()
+			origin := req.Header.Get(echo.HeaderOrigin)
+			preflight := req.Method == http.MethodOptions && req.Header.Get(echo.HeaderAccessControlRequestMethod) != ""
+
+			header.Add(echo.HeaderVary, echo.HeaderOrigin)
+			if preflight {
+				header.Add(echo.HeaderVary, echo.HeaderAccessControlRequestMethod)
+				header.Add(echo.HeaderVary, echo.HeaderAccessControlRequestHeaders)
+			}
+
+			allowed := ""
+			if origin != "" {
+				allowed = loadCORSPolicy().allowedOrigin(c, origin)
+			}
+			if allowed == "" {
+				if preflight {
+					return c.NoContent(http.StatusNoContent)
+				}
+				return next(c)
+			}
+
+			header.Set(echo.HeaderAccessControlAllowOrigin, allowed)
+			if !preflight {
+				header.Set(echo.HeaderAccessControlExposeHeaders, exposeHeaders)
+				return next(c)
+			}
+			header.Set(echo.HeaderAccessControlAllowMethods, allowMethods)
+			header.Set(echo.HeaderAccessControlAllowHeaders, allowHeaders)
+			return c.NoContent(http.StatusNoContent)
+		}
+	}
+}
+
+// EffectiveCORSStatus describes the CORS configuration as applied to the given request
+func EffectiveCORSStatus(c echo.Context) CORSStatus {
+	p := loadCORSPolicy()
+	status := CORSStatus{
+		Source:        p.source,
+		TrustProxy:    p.trustProxy,
+		Groups:        make(map[string]CORSGroupStatus),
+		AllowMethods:  corsAllowMethods,
+		AllowHeaders:  corsAllowHeaders,
+		ExposeHeaders: corsExposeHeaders,
+		Invalid:       p.invalid,
+	}
+	if p.trustProxy {
+		status.ExternalOrigin = externalOrigin(c)
+	}
+	for group, origins := range p.groups {
+		gs := CORSGroupStatus{Origins: origins, Inherited: group != CORSGroupDefault && !p.overridden[group]}
+		if gs.Origins == nil {
+			gs.Origins = []string{}
+		}
+		for _, prefix := range corsGroupPrefixes {
+			if prefix.group == group {
+				gs.PathPrefixes = append(gs.PathPrefixes, prefix.prefix)
+			}
+		}
+		status.Groups[group] = gs
+	}
+	return status
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestNormalizeCORSOrigin(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"https://files.example.com", "https://files.example.com", false},
+		{" HTTPS://Files.Example.com:8443/ ", "https://files.example.com:8443", false},
+		{"*", "*", false},
+		{"https://files.example.com/app", "", true},
+		{"https://files.example.com?x=1", "", true},
+		{"ftp://files.example.com", "", true},
+		{"files.example.com", "", true},
+		{"https://user@files.example.com", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeCORSOrigin(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeCORSOrigin(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestValidateCORSSetting(t *testing.T) {
+	if err := ValidateCORSSetting(settingCORSShareOrigins, "https://a.example.com, https://b.example.com"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateCORSSetting(settingCORSAdminOrigins, "https://a.example.com/admin"); err == nil {
+		t.Error("expected origin with a path to be rejected")
+	}
+	if err := ValidateCORSSetting(settingCORSTrustProxy, "maybe"); err == nil {
+		t.Error("expected invalid boolean to be rejected")
+	}
+	if err := ValidateCORSSetting("trash_retention_days", "https://a.example.com/x"); err != nil {
+		t.Errorf("unrelated keys must not be validated: %v", err)
+	}
+}
+
+func TestCORSGroupForPath(t *testing.T) {
+	tests := map[string]string{
+		"/api/admin/settings":     CORSGroupAdmin,
+		"/api/s/sh_abc/download":  CORSGroupShare,
+		"/api/u/up_abc/upload/id": CORSGroupShare,
+		"/api/files/home":         CORSGroupDefault,
+		"/api/settings":           CORSGroupDefault,
+	}
+	for p, want := range tests {
+		if got := CORSGroupForPath(p); got != want {
+			t.Errorf("CORSGroupForPath(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestCORSMiddleware_EnvOrigins(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, not a url")
+
+	e := echo.New()
+	handler := CORSMiddleware()(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	// Allowed origin on a simple request
+	req := httptest.NewRequest(http.MethodGet, "/api/files/home", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://app.example.com")
+	rec := httptest.NewRecorder()
+	if err := handler(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if rec.Header().Get(echo.HeaderAccessControlExposeHeaders) == "" {
+		t.Error("expected exposed headers for tus clients")
+	}
+
+	// Unknown origin on a preflight
+	req = httptest.NewRequest(http.MethodOptions, "/api/upload/", nil)
+	req.Header.Set(echo.HeaderOrigin, "https://evil.example.com")
+	req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodPost)
+	rec = httptest.NewRecorder()
+	if err := handler(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNoContent || rec.Header().Get(echo.HeaderAccessControlAllowOrigin) != "" {
+		t.Errorf("preflight from unknown origin: code %d, Allow-Origin %q", rec.Code, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+	}
+
+	status := EffectiveCORSStatus(e.NewContext(req, rec))
+	if status.Source != "env" || len(status.Invalid) != 1 || !status.Groups[CORSGroupAdmin].Inherited {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
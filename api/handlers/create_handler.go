@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -64,6 +65,12 @@ func (h *Handler) CreateFile(c echo.Context) error {
 			"error": "Cannot create file in root",
 		})
 	}
+	if apiErr := mountInWriteError(targetPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Check permissions for home folder
 	if storageType == StorageHome && claims == nil {
@@ -97,6 +104,12 @@ func (h *Handler) CreateFile(c echo.Context) error {
 		})
 	}
 
+	// Refuse above the folder's entry limit; warn above the threshold
+	entryWarning, apiErr := CheckDirEntries(realPath, targetPath, 1, dirEntryOverride(c, claims))
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
 	// Get template content based on file type
 	content := getTemplateContent(req.FileType)
 
@@ -105,7 +118,7 @@ func (h *Handler) CreateFile(c echo.Context) error {
 	if storageType == StorageShared {
 		filePerm = SharedFilePerm
 	}
-	if err := os.WriteFile(filePath, content, filePerm); err != nil {
+	if err := WriteFileSealed(filePath, content, filePerm); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to create file",
 		})
@@ -115,6 +128,9 @@ func (h *Handler) CreateFile(c echo.Context) error {
 	if storageType == StorageShared {
 		_ = SetSharedPermissions(filePath, false)
 	}
+	GetChangeJournal().Record(ChangeCreate, filePath, "", changeActor(claims))
+	GetListingVersions().Invalidate(filePath)
+	GetDirEntryLimits().Added(realPath, 1)
 
 	// Log audit event
 	var userID *string
@@ -127,11 +143,15 @@ func (h *Handler) CreateFile(c echo.Context) error {
 		"source":   "create",
 	})
 
-	return c.JSON(http.StatusCreated, map[string]interface{}{
+	response := map[string]interface{}{
 		"success":  true,
 		"filename": req.Filename,
 		"path":     targetPath + "/" + req.Filename,
-	})
+	}
+	if entryWarning != nil {
+		response["entryWarning"] = entryWarning
+	}
+	return c.JSON(http.StatusCreated, response)
 }
 
 // getTemplateContent returns template content for different file types
@@ -186,6 +206,26 @@ func (h *Handler) SimpleUpload(c echo.Context) error {
 		claims = user
 	}
 
+	if c.FormValue("ingest") == IngestCamera {
+		return h.simpleCameraUpload(c, claims, file)
+	}
+
+	// Files of a folder upload go below the target by their relative
+	// directory, checked like CreateFolder's names
+	relativeDir, err := validateRelativeDir(c.FormValue("relativePath"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if relativeDir != "" && (targetPath == "/shared" || targetPath == "/shared/") {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": "공유 드라이브는 관리자 설정에서만 생성할 수 있습니다",
+		})
+	}
+	folder := uploadTopFolder(targetPath, relativeDir)
+	targetPath = uploadDestPath(targetPath, relativeDir)
+
 	// Resolve path
 	realPath, storageType, _, err := h.resolvePath(targetPath, claims)
 	if err != nil {
@@ -199,6 +239,19 @@ func (h *Handler) SimpleUpload(c echo.Context) error {
 			"error": "Cannot upload to root",
 		})
 	}
+	if apiErr := mountInWriteError(targetPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	// Files of a batch are checked with the rest of the batch
+	quotaSize := UploadBatchBytes(file.Size, c.FormValue("batchRemaining"))
+	if storageType == StorageShared {
+		if apiErr := checkSharedUploadQuota(c.Request().Context(), h.db, h.dataRoot, targetPath, quotaSize); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+	}
 
 	// Check permissions for home folder
 	if storageType == StorageHome && claims == nil {
@@ -207,21 +260,35 @@ func (h *Handler) SimpleUpload(c echo.Context) error {
 		})
 	}
 
-	// Ensure target directory exists with appropriate permissions
-	if storageType == StorageShared {
-		if err := MkdirAllShared(realPath); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": "Failed to create target directory",
-			})
-		}
-	} else {
-		if err := os.MkdirAll(realPath, 0755); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": "Failed to create target directory",
+	if storageType == StorageHome && quotaSize > 0 {
+		var quota, used int64
+		if err := h.db.QueryRow(`
+			SELECT user_storage_quota(id, storage_quota, $1), COALESCE(storage_used, 0) + COALESCE(trash_used, 0)
+			FROM users WHERE id = $2
+		`, DefaultUserQuota, claims.UserID).Scan(&quota, &used); err == nil && quota > 0 && used+quotaSize > quota {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]interface{}{
+				"error":     "Storage quota exceeded",
+				"quota":     quota,
+				"used":      used,
+				"requested": quotaSize,
 			})
 		}
 	}
 
+	// Ensure target directory exists with appropriate permissions, with the
+	// folders of a folder upload
+	if err := mkdirUploadFolders(realPath, storageType == StorageShared, changeActor(claims)); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create target directory",
+		})
+	}
+
+	// Refuse above the folder's entry limit; warn above the threshold
+	entryWarning, apiErr := CheckDirEntries(realPath, targetPath, 1, dirEntryOverride(c, claims))
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
 	// Open the uploaded file
 	src, err := file.Open()
 	if err != nil {
@@ -238,6 +305,12 @@ func (h *Handler) SimpleUpload(c echo.Context) error {
 	tracker := GetWebUploadTracker()
 	tracker.MarkUploading(destPath)
 
+	if err := copyOnWrite(destPath, false); err != nil {
+		tracker.UnmarkUploading(destPath)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create destination file",
+		})
+	}
 	dst, err := os.Create(destPath)
 	if err != nil {
 		tracker.UnmarkUploading(destPath)
@@ -254,11 +327,26 @@ func (h *Handler) SimpleUpload(c echo.Context) error {
 			"error": "Failed to save file",
 		})
 	}
+	dst.Close()
+	if err := SealPath(destPath); err != nil {
+		os.Remove(destPath)
+		tracker.UnmarkUploading(destPath)
+		return RespondError(c, encryptionAPIError("encrypt file", err))
+	}
 
-	// Set permissions for shared folders
+	// Set permissions for shared folders and keep their usage current for
+	// the quota check
 	if storageType == StorageShared {
 		_ = SetSharedPermissions(destPath, false)
+		folderName := ExtractSharedDriveFolderName(targetPath)
+		if err := h.UpdateSharedFolderStorage(folderName, file.Size); err != nil {
+			LogError("Failed to update storage usage", err, "folder", folderName)
+		}
 	}
+	GetChangeJournal().Record(ChangeCreate, destPath, "", changeActor(claims))
+	GetListingVersions().Invalidate(destPath)
+	GetFileChecksums().Queue(destPath)
+	GetDirEntryLimits().Added(realPath, 1)
 
 	// Keep the mark for 10 seconds then remove it
 	go func() {
@@ -267,15 +355,125 @@ func (h *Handler) SimpleUpload(c echo.Context) error {
 	}()
 
 	// Log audit event for file upload
-	h.auditHandler.LogEventFromContext(c, EventFileUpload, targetPath+"/"+file.Filename, map[string]interface{}{
+	details := map[string]interface{}{
 		"fileName": file.Filename,
 		"size":     file.Size,
 		"source":   "web",
-	})
+	}
+	if folder != "" {
+		details["folder"] = folder
+	}
+	h.auditHandler.LogEventFromContext(c, EventFileUpload, targetPath+"/"+file.Filename, details)
 
-	return c.JSON(http.StatusCreated, map[string]interface{}{
+	response := map[string]interface{}{
 		"success":  true,
 		"filename": file.Filename,
 		"size":     file.Size,
+		"path":     targetPath + "/" + file.Filename,
+	}
+	if folder != "" {
+		response["folder"] = folder
+	}
+	if entryWarning != nil {
+		response["entryWarning"] = entryWarning
+	}
+	return c.JSON(http.StatusCreated, response)
+}
+
+// simpleCameraUpload handles a SimpleUpload with ingest=camera: the file is
+// routed into the user's camera backup folder, or skipped if it already exists
+func (h *Handler) simpleCameraUpload(c echo.Context, claims *JWTClaims, file *multipart.FileHeader) error {
+	if claims == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "Authentication required",
+		})
+	}
+	if err := validateFilename(file.Filename); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	var quota, used int64
+	if err := h.db.QueryRow(`
+		SELECT user_storage_quota(id, storage_quota, $1), COALESCE(storage_used, 0) + COALESCE(trash_used, 0)
+		FROM users WHERE id = $2
+	`, DefaultUserQuota, claims.UserID).Scan(&quota, &used); err == nil && quota > 0 && used+file.Size > quota {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]interface{}{
+			"error":     "Storage quota exceeded",
+			"quota":     quota,
+			"used":      used,
+			"requested": file.Size,
+		})
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to open uploaded file",
+		})
+	}
+	defer src.Close()
+
+	// Stage under .uploads so the final move is a rename on the same filesystem
+	uploadDir := filepath.Join(h.dataRoot, ".uploads")
+	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create upload directory",
+		})
+	}
+	tmp, err := os.CreateTemp(uploadDir, "camera-*")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create temporary file",
+		})
+	}
+	tmpPath := tmp.Name()
+	_, err = io.Copy(tmp, src)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to save file",
+		})
+	}
+
+	result, err := ingestCameraFile(h.db, h.dataRoot, claims.UserID, claims.Username, tmpPath, file.Filename, c.FormValue("capturedAt"))
+	if err != nil {
+		os.Remove(tmpPath)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to store camera upload",
+		})
+	}
+
+	if result.Status == CameraIngestAlreadyExists {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"success": true,
+			"status":  result.Status,
+			"message": "already exists",
+			"path":    result.Path,
+		})
+	}
+
+	if err := h.UpdateUserStorage(claims.UserID, result.Size); err != nil {
+		LogError("Failed to update storage usage", err, "user", claims.Username)
+	}
+
+	h.auditHandler.LogEventFromContext(c, EventFileUpload, result.Path, map[string]interface{}{
+		"fileName":   file.Filename,
+		"size":       result.Size,
+		"source":     IngestCamera,
+		"capturedAt": result.CapturedAt,
+		"dateSource": result.DateSource,
+	})
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"success":    true,
+		"status":     result.Status,
+		"filename":   filepath.Base(result.Path),
+		"path":       result.Path,
+		"size":       result.Size,
+		"capturedAt": result.CapturedAt,
+		"dateSource": result.DateSource,
 	})
 }
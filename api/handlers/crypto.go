@@ -30,6 +30,16 @@ func MustGenerateSecureToken(length int) string {
 	return token
 }
 
+// GenerateURLSafeToken generates a cryptographically secure random token
+// encoded as unpadded base64url; length is the number of random bytes
+func GenerateURLSafeToken(length int) (string, error) {
+	bytes, err := GenerateSecureBytes(length)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
 // GenerateSecureBytes generates cryptographically secure random bytes
 func GenerateSecureBytes(length int) ([]byte, error) {
 	bytes := make([]byte, length)
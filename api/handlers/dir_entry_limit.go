@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Directory entry limits. File systems, SMB clients and the file list all
+// slow down on folders with very many entries. Above dir_entries_warn,
+// files created through the API still land but the response carries a
+// warning and the folder's owner is notified once; above dir_entries_max,
+// creations are refused with a suggestion to use subfolders unless a
+// storage admin sets the override flag. Counts come from the stats cache
+// rather than a fresh ReadDir per creation.
+
+const (
+	settingDirEntriesWarn = "dir_entries_warn"
+	settingDirEntriesMax  = "dir_entries_max"
+
+	// DirEntryOverrideParam lets storage admins create entries above the
+	// hard limit: a query parameter, or upload metadata for tus uploads
+	DirEntryOverrideParam = "overrideEntryLimit"
+	// DirEntryWarningHeader carries the warning on tus upload creation
+	DirEntryWarningHeader = "X-Entry-Warning"
+	// dirEntryPageSize is the page size of listings paginated because of
+	// their entry count when the client didn't ask for pages
+	dirEntryPageSize = 500
+)
+
+// NotifDirEntries tells a folder's owner it passed the warning threshold
+const NotifDirEntries = "directory.entries"
+
+// DirEntryWarning is attached to responses creating entries in, or
+// listing, a folder above the warning threshold
+type DirEntryWarning struct {
+	Path    string `json:"path"`
+	Entries int64  `json:"entries"`
+	WarnAt  int    `json:"warnAt"`
+	Limit   int    `json:"limit,omitempty"` // 0 when there is no hard limit
+	Message string `json:"message"`
+}
+
+// dirEntryThresholds returns the warning and hard limits; 0 disables either
+func dirEntryThresholds() (warn, limit int) {
+	warn, limit = 10000, 100000
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		warn = sh.GetSettingInt(settingDirEntriesWarn, warn)
+		limit = sh.GetSettingInt(settingDirEntriesMax, limit)
+	}
+	return max(warn, 0), max(limit, 0)
+}
+
+// dirEntryWarningFor returns the warning for a folder holding entries, or
+// nil below the threshold
+func dirEntryWarningFor(displayDir string, entries int64) *DirEntryWarning {
+	warn, limit := dirEntryThresholds()
+	if warn == 0 || entries <= int64(warn) {
+		return nil
+	}
+	message := fmt.Sprintf("This folder holds %d items. Large folders are slow to open and sync; consider organizing them into subfolders.", entries)
+	if limit > 0 {
+		message = fmt.Sprintf("This folder holds %d items; new files are refused above %d. Consider organizing them into subfolders.", entries, limit)
+	}
+	return &DirEntryWarning{
+		Path:    displayDir,
+		Entries: entries,
+		WarnAt:  warn,
+		Limit:   limit,
+		Message: message,
+	}
+}
+
+// CheckDirEntries checks adding entries to dir against the limits. Above
+// the hard limit it returns a DIRECTORY_FULL error unless override is set;
+// above the warning threshold, a warning to attach to the response. A
+// folder that doesn't exist yet counts as empty; replacing a file adds 0.
+func CheckDirEntries(dir, displayDir string, adding int, override bool) (*DirEntryWarning, *APIError) {
+	warn, limit := dirEntryThresholds()
+	if warn == 0 && limit == 0 {
+		return nil, nil
+	}
+	count, err := GetStatsCache().ChildCount(dir)
+	if err != nil {
+		count = 0
+	}
+	after := count + int64(adding)
+
+	if limit > 0 && adding > 0 && after > int64(limit) && !override {
+		return nil, NewAPIError(ErrCodeDirectoryFull,
+			fmt.Sprintf("This folder already holds %d items, the limit is %d. Organize the files into subfolders and try again.", count, limit)).
+			WithDetails(map[string]interface{}{
+				"path":       displayDir,
+				"entries":    count,
+				"adding":     adding,
+				"limit":      limit,
+				"suggestion": "subfolders",
+			})
+	}
+	return dirEntryWarningFor(displayDir, after), nil
+}
+
+// dirEntryOverride reports whether the request asks to pass the hard
+// entry limit and may; the flag is ignored for users without storage.admin
+func dirEntryOverride(c echo.Context, claims *JWTClaims) bool {
+	if claims == nil || !claims.HasPermission(PermStorageAdmin) {
+		return false
+	}
+	return c.QueryParam(DirEntryOverrideParam) == "true"
+}
+
+// DirEntryLimits keeps entry counts current after API creations and
+// notifies folder owners when a folder passes the warning threshold
+type DirEntryLimits struct {
+	db            *sql.DB
+	dataRoot      string
+	notifications *NotificationService
+	notified      sync.Map // Folders handled since startup; the table covers restarts
+}
+
+var globalDirEntryLimits *DirEntryLimits
+
+// InitDirEntryLimits creates the global directory entry limits
+func InitDirEntryLimits(db *sql.DB, dataRoot string, notifications *NotificationService) *DirEntryLimits {
+	globalDirEntryLimits = &DirEntryLimits{db: db, dataRoot: dataRoot, notifications: notifications}
+	return globalDirEntryLimits
+}
+
+// GetDirEntryLimits returns the global directory entry limits (nil if not initialized)
+func GetDirEntryLimits() *DirEntryLimits {
+	return globalDirEntryLimits
+}
+
+// Added records entries created in dir through the API and notifies the
+// folder's owner, once per folder, when it is above the warning threshold
+func (l *DirEntryLimits) Added(dir string, added int) {
+	GetStatsCache().AddChildren(dir, int64(added))
+	if l == nil {
+		return
+	}
+	warn, _ := dirEntryThresholds()
+	if warn == 0 {
+		return
+	}
+	if _, done := l.notified.Load(dir); done {
+		return
+	}
+	count, err := GetStatsCache().ChildCount(dir)
+	if err != nil || count <= int64(warn) {
+		return
+	}
+	l.notified.Store(dir, true)
+	l.notifyOwner(dir, count)
+}
+
+// notifyOwner tells the owner of a home folder, or the creator of a shared
+// drive, that dir passed the warning threshold, unless they were told before
+func (l *DirEntryLimits) notifyOwner(dir string, count int64) {
+	rel, err := dataRootRel(l.dataRoot, dir)
+	if err != nil {
+		return
+	}
+	ownerID, displayDir := l.ownerOf(rel)
+	if ownerID == "" {
+		return
+	}
+	res, err := l.db.Exec(`
+		INSERT INTO directory_entry_warnings (path, owner_id, entries) VALUES ($1, $2, $3)
+		ON CONFLICT (path) DO NOTHING
+	`, filepath.ToSlash(rel), ownerID, count)
+	if err != nil {
+		log.Printf("[DirEntries] Failed to record warning for %s: %v", displayDir, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 || l.notifications == nil {
+		return
+	}
+	warning := dirEntryWarningFor(displayDir, count)
+	if warning == nil {
+		return
+	}
+	l.notifications.Send(ownerID, NotifDirEntries, "A folder is getting very large", warning.Message,
+		dirEntryLink(displayDir), nil, map[string]interface{}{
+			"path":    displayDir,
+			"entries": count,
+			"warnAt":  warning.WarnAt,
+			"limit":   warning.Limit,
+		})
+}
+
+// ownerOf returns the user owning a data-root relative path, the home
+// folder's user or the shared drive's creator, and the path as they see it
+func (l *DirEntryLimits) ownerOf(rel string) (ownerID, displayDir string) {
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+	if len(parts) < 2 {
+		return "", ""
+	}
+	var owner sql.NullString
+	switch parts[0] {
+	case "users":
+		_ = l.db.QueryRow(`SELECT id FROM users WHERE username = $1`, parts[1]).Scan(&owner)
+		displayDir = "/home"
+		if len(parts) == 3 {
+			displayDir += "/" + parts[2]
+		}
+	case "shared":
+		_ = l.db.QueryRow(`SELECT created_by FROM shared_folders WHERE name = $1 AND is_active = TRUE`, parts[1]).Scan(&owner)
+		displayDir = "/" + filepath.ToSlash(rel)
+	}
+	return owner.String, displayDir
+}
+
+// dirEntryLink returns the UI route showing a folder
+func dirEntryLink(displayDir string) string {
+	if rest, ok := strings.CutPrefix(displayDir, "/shared/"); ok {
+		return "/shared-drive/" + rest
+	}
+	if rest, ok := strings.CutPrefix(displayDir, "/home"); ok {
+		return "/files" + rest
+	}
+	return "/files"
+}
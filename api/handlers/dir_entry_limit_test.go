@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func writeEntries(t *testing.T, dir string, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d.txt", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStatsCacheChildCount(t *testing.T) {
+	cache := &StatsCache{}
+	dir := t.TempDir()
+	writeEntries(t, dir, 0, 3)
+
+	if n, err := cache.ChildCount(dir); err != nil || n != 3 {
+		t.Fatalf("ChildCount = %d, %v", n, err)
+	}
+
+	// An API creation adjusts the count without a recount
+	writeEntries(t, dir, 3, 4)
+	cache.AddChildren(dir, 1)
+	if cached, _ := cache.childCounts.Load(dir); cached.(cachedChildCount).Count != 4 {
+		t.Errorf("count after AddChildren = %d", cached.(cachedChildCount).Count)
+	}
+
+	// A change outside the API shows up through the directory's mtime
+	writeEntries(t, dir, 4, 6)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(dir, future, future)
+	if n, _ := cache.ChildCount(dir); n != 6 {
+		t.Errorf("ChildCount after outside change = %d", n)
+	}
+
+	if _, err := cache.ChildCount(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing directory should fail")
+	}
+}
+
+func TestCheckDirEntries(t *testing.T) {
+	useLocalStatsCache(t)
+	useCachedSettings(t, map[string]string{settingDirEntriesWarn: "2", settingDirEntriesMax: "3"})
+	dir := t.TempDir()
+	writeEntries(t, dir, 0, 2)
+
+	// Below the threshold
+	if warning, apiErr := CheckDirEntries(dir, "/home/big", 0, false); warning != nil || apiErr != nil {
+		t.Errorf("at threshold: %v, %v", warning, apiErr)
+	}
+
+	// Above the threshold: allowed with a warning
+	warning, apiErr := CheckDirEntries(dir, "/home/big", 1, false)
+	if apiErr != nil || warning == nil || warning.Entries != 3 || warning.Limit != 3 {
+		t.Fatalf("above threshold: %+v, %v", warning, apiErr)
+	}
+
+	// Above the hard limit: refused unless overridden
+	writeEntries(t, dir, 2, 3)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(dir, future, future)
+	if _, apiErr := CheckDirEntries(dir, "/home/big", 1, false); apiErr == nil || apiErr.Code != ErrCodeDirectoryFull {
+		t.Errorf("above limit: %v", apiErr)
+	} else if apiErr.HTTPStatus() != http.StatusConflict || apiErr.Details.(map[string]interface{})["suggestion"] != "subfolders" {
+		t.Errorf("error = %d %v", apiErr.HTTPStatus(), apiErr.Details)
+	}
+	if warning, apiErr := CheckDirEntries(dir, "/home/big", 1, true); apiErr != nil || warning == nil {
+		t.Errorf("override: %v, %v", warning, apiErr)
+	}
+	// Replacing a file adds nothing
+	if _, apiErr := CheckDirEntries(dir, "/home/big", 0, false); apiErr != nil {
+		t.Errorf("replace: %v", apiErr)
+	}
+	// A folder that doesn't exist yet is empty
+	if warning, apiErr := CheckDirEntries(filepath.Join(dir, "new"), "/home/big/new", 1, false); warning != nil || apiErr != nil {
+		t.Errorf("new folder: %v, %v", warning, apiErr)
+	}
+}
+
+func TestDirEntryLimitsNotifyOnce(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useLocalStatsCache(t)
+	useCachedSettings(t, map[string]string{settingDirEntriesWarn: "2", settingDirEntriesMax: "0"})
+	dataRoot := t.TempDir()
+	dir := filepath.Join(dataRoot, "users", "alice", "scans")
+	_ = os.MkdirAll(dir, 0755)
+	writeEntries(t, dir, 0, 3)
+	l := &DirEntryLimits{db: tc.DB, dataRoot: dataRoot}
+
+	tc.Mock.ExpectQuery("SELECT id FROM users WHERE username").
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("u1"))
+	tc.Mock.ExpectExec("INSERT INTO directory_entry_warnings").
+		WithArgs("users/alice/scans", "u1", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	l.Added(dir, 1)
+
+	// The folder was handled; more files don't notify again
+	writeEntries(t, dir, 3, 4)
+	l.Added(dir, 1)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListFilesPaginatesLargeFolders(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useLocalStatsCache(t)
+	useCachedSettings(t, map[string]string{settingDirEntriesWarn: "3", settingDirEntriesMax: "0"})
+	dataRoot := t.TempDir()
+	dir := filepath.Join(dataRoot, "users", "alice", "big")
+	_ = os.MkdirAll(dir, 0755)
+	writeEntries(t, dir, 0, 4)
+	h := &Handler{db: tc.DB, dataRoot: dataRoot}
+
+	req, _ := NewJSONRequest(http.MethodGet, "/api/files?path=/home/big", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	if err := h.ListFiles(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	var resp ListFilesResponse
+	if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.EntryWarning == nil || resp.EntryWarning.Entries != 4 {
+		t.Errorf("entryWarning = %+v", resp.EntryWarning)
+	}
+	if resp.Page != 1 || resp.PageSize != dirEntryPageSize || resp.TotalPages != 1 || resp.Total != 4 {
+		t.Errorf("pagination = page %d size %d pages %d total %d", resp.Page, resp.PageSize, resp.TotalPages, resp.Total)
+	}
+
+	// The listing primed the entry count index
+	if cached, ok := GetStatsCache().childCounts.Load(dir); !ok || cached.(cachedChildCount).Count != 4 {
+		t.Errorf("child count not recorded: %v", cached)
+	}
+}
@@ -0,0 +1,511 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// JobDirSizeReconcile rescans every directory into the size index
+const JobDirSizeReconcile = "dirsize.reconcile"
+
+const (
+	// dirSizeSettle is how long reported changes collect before the
+	// directories are rescanned, so a burst of uploads into one folder
+	// rescans it once
+	dirSizeSettle = 2 * time.Second
+	// dirSizeMaxPending bounds the paths waiting for a rescan; past it
+	// changes are dropped and the next reconcile picks them up
+	dirSizeMaxPending = 100000
+)
+
+// dirSizeTops are the data-root folders whose sizes are indexed
+var dirSizeTops = []string{"users", "shared", "trash"}
+
+// DirUsage is the size of a directory tree: the bytes and number of files
+// and folders below it
+type DirUsage struct {
+	Bytes   int64
+	Files   int64
+	Folders int64
+}
+
+// DirSizeIndex keeps the size of each directory's direct entries in
+// dir_sizes, so storage usage and folder statistics are sums over a path
+// prefix instead of walks. The watcher and uploads report changes, which
+// rescan the directories involved; moves and deletes made through the API
+// follow right away. JobDirSizeReconcile corrects whatever was missed.
+type DirSizeIndex struct {
+	db       *sql.DB
+	dataRoot string
+
+	mu       sync.Mutex
+	pending  map[string]struct{}
+	indexing map[string]bool // trees scanned in the background, see indexLater
+	wake     chan struct{}
+}
+
+var globalDirSizes *DirSizeIndex
+
+// InitDirSizes creates the global size index and starts its updater
+func InitDirSizes(db *sql.DB, dataRoot string) *DirSizeIndex {
+	d := newDirSizeIndex(db, dataRoot)
+	globalDirSizes = d
+	go d.work()
+	return d
+}
+
+func newDirSizeIndex(db *sql.DB, dataRoot string) *DirSizeIndex {
+	return &DirSizeIndex{
+		db:       db,
+		dataRoot: dataRoot,
+		pending:  make(map[string]struct{}),
+		indexing: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// GetDirSizes returns the global size index (nil if not initialized)
+func GetDirSizes() *DirSizeIndex {
+	return globalDirSizes
+}
+
+// rel returns the data-root relative path of an indexed directory or file,
+// "" for anything else
+func (d *DirSizeIndex) rel(realPath string) string {
+	rel, err := dataRootRel(d.dataRoot, realPath)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	top, _, _ := strings.Cut(rel, "/")
+	for _, t := range dirSizeTops {
+		if top == t {
+			return rel
+		}
+	}
+	return ""
+}
+
+// Changed queues a created, written or removed item; its folder, and the
+// item itself when it is a folder, are rescanned shortly
+func (d *DirSizeIndex) Changed(realPath string) {
+	if d == nil || d.rel(realPath) == "" {
+		return
+	}
+	d.mu.Lock()
+	if len(d.pending) < dirSizeMaxPending {
+		d.pending[filepath.Clean(realPath)] = struct{}{}
+	}
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work rescans the queued changes in batches, yielding to foreground requests
+func (d *DirSizeIndex) work() {
+	for range d.wake {
+		time.Sleep(dirSizeSettle)
+		d.mu.Lock()
+		batch := d.pending
+		d.pending = make(map[string]struct{})
+		d.mu.Unlock()
+
+		pace := GetBackgroundPacer().Begin("dir-size-index", PacePriorityMaintenance)
+		ctx := context.Background()
+		d.update(ctx, batch, func() error { return pace.PaceContext(ctx) })
+		pace.End()
+	}
+}
+
+// update rescans the folders of changed items. A folder new to the index is
+// scanned with everything below it; a folder that is gone loses its rows.
+func (d *DirSizeIndex) update(ctx context.Context, changed map[string]struct{}, pace func() error) {
+	now := time.Now()
+	dirs := make(map[string]bool)
+	for realPath := range changed {
+		if parent := filepath.Dir(realPath); d.rel(parent) != "" {
+			dirs[parent] = true
+		}
+		info, err := os.Lstat(realPath)
+		switch {
+		case err != nil:
+			d.forget(d.rel(realPath))
+		case !info.IsDir():
+		case d.hasRow(d.rel(realPath)):
+			dirs[realPath] = true
+		default:
+			if err := d.scanTree(ctx, realPath, pace, now); err != nil {
+				return
+			}
+		}
+	}
+	for dir := range dirs {
+		if pace() != nil {
+			return
+		}
+		if _, err := d.scanDir(dir, now); err != nil {
+			log.Printf("[DirSizes] Failed to scan %s: %v", dir, err)
+		}
+	}
+}
+
+func (d *DirSizeIndex) hasRow(rel string) bool {
+	var exists bool
+	_ = d.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM dir_sizes WHERE path = $1)`, rel).Scan(&exists)
+	return exists
+}
+
+// scanDir stores the sizes of a directory's direct entries and returns the
+// names of its subdirectories. A directory that is gone loses its rows.
+func (d *DirSizeIndex) scanDir(realDir string, now time.Time) ([]string, error) {
+	rel := d.rel(realDir)
+	if rel == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(realDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.forget(rel)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var all, visible DirUsage
+	var subdirs []string
+	for _, entry := range entries {
+		hidden := strings.HasPrefix(entry.Name(), ".")
+		if entry.IsDir() {
+			subdirs = append(subdirs, entry.Name())
+			all.Folders++
+			if !hidden {
+				visible.Folders++
+			}
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		all.Files++
+		all.Bytes += info.Size()
+		if !hidden {
+			visible.Files++
+			visible.Bytes += info.Size()
+		}
+	}
+
+	_, err = d.db.Exec(`
+		INSERT INTO dir_sizes (path, hidden, bytes, files, folders,
+			visible_bytes, visible_files, visible_folders, scanned_at)
+		VALUES ($1, $1 ~ '(^|/)\.', $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (path) DO UPDATE SET
+			hidden = EXCLUDED.hidden, bytes = EXCLUDED.bytes, files = EXCLUDED.files,
+			folders = EXCLUDED.folders, visible_bytes = EXCLUDED.visible_bytes,
+			visible_files = EXCLUDED.visible_files, visible_folders = EXCLUDED.visible_folders,
+			scanned_at = EXCLUDED.scanned_at
+	`, rel, all.Bytes, all.Files, all.Folders, visible.Bytes, visible.Files, visible.Folders, now)
+	return subdirs, err
+}
+
+// scanTree scans a directory and everything below it. pace is called before
+// each directory; its error stops the scan. Home folders and shared drives
+// kept on other storage locations are scanned where they are.
+func (d *DirSizeIndex) scanTree(ctx context.Context, realDir string, pace func() error, now time.Time) error {
+	if err := pace(); err != nil {
+		return err
+	}
+	subdirs, err := d.scanDir(realDir, now)
+	if err != nil {
+		log.Printf("[DirSizes] Failed to scan %s: %v", realDir, err)
+		return nil
+	}
+	for _, name := range subdirs {
+		if err := d.scanTree(ctx, GetStorageLocations().Map(filepath.Join(realDir, name)), pace, now); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// indexTree scans a tree and records it as completely scanned
+func (d *DirSizeIndex) indexTree(ctx context.Context, realDir string, pace func() error, now time.Time) error {
+	if err := d.scanTree(ctx, realDir, pace, now); err != nil {
+		return err
+	}
+	_, err := d.db.Exec(`
+		INSERT INTO dir_size_roots (path, scanned_at) VALUES ($1, $2)
+		ON CONFLICT (path) DO UPDATE SET scanned_at = EXCLUDED.scanned_at
+	`, d.rel(realDir), now)
+	return err
+}
+
+// covered reports whether a path lies in a completely scanned tree
+func (d *DirSizeIndex) covered(rel string) bool {
+	var ancestors []string
+	for p := rel; p != "." && p != "/" && p != ""; p = filepath.ToSlash(filepath.Dir(p)) {
+		ancestors = append(ancestors, p)
+	}
+	var exists bool
+	_ = d.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM dir_size_roots WHERE path = ANY($1))`,
+		pq.Array(ancestors)).Scan(&exists)
+	return exists
+}
+
+// Usage returns the size of everything below a directory, hidden items
+// included. A tree the index has not scanned yet is scanned first. ok is
+// false when the directory is not indexed at all or the index failed.
+func (d *DirSizeIndex) Usage(ctx context.Context, realDir string) (DirUsage, bool) {
+	return d.usage(ctx, realDir, false, true)
+}
+
+// CachedUsage is Usage without scanning: ok is false for a tree the index
+// has not scanned yet
+func (d *DirSizeIndex) CachedUsage(ctx context.Context, realDir string) (DirUsage, bool) {
+	return d.usage(ctx, realDir, false, false)
+}
+
+// VisibleUsage is Usage without items whose name starts with a dot or that
+// are below such a folder
+func (d *DirSizeIndex) VisibleUsage(ctx context.Context, realDir string) (DirUsage, bool) {
+	return d.usage(ctx, realDir, true, true)
+}
+
+func (d *DirSizeIndex) usage(ctx context.Context, realDir string, visible, scan bool) (DirUsage, bool) {
+	var u DirUsage
+	if d == nil {
+		return u, false
+	}
+	rel := d.rel(realDir)
+	if rel == "" {
+		return u, false
+	}
+	if !pathExists(realDir) {
+		return u, true
+	}
+	if !d.covered(rel) {
+		if !scan {
+			return u, false
+		}
+		pace := GetBackgroundPacer().Begin("dir-size", PacePriorityUser)
+		err := d.indexTree(ctx, realDir, func() error { return pace.PaceContext(ctx) }, time.Now())
+		pace.End()
+		if err != nil {
+			// The request ran out of time; the scan is finished in the
+			// background for the next one
+			d.indexLater(realDir)
+			return u, false
+		}
+	}
+
+	query := `
+		SELECT COALESCE(SUM(bytes), 0), COALESCE(SUM(files), 0), COALESCE(SUM(folders), 0)
+		FROM dir_sizes WHERE (path = $1 OR path LIKE $2)`
+	if visible {
+		query = `
+		SELECT COALESCE(SUM(visible_bytes), 0), COALESCE(SUM(visible_files), 0), COALESCE(SUM(visible_folders), 0)
+		FROM dir_sizes WHERE (path = $1 OR path LIKE $2) AND NOT hidden`
+	}
+	if err := d.db.QueryRowContext(ctx, query, rel, escapeLikePattern(rel)+"/%").
+		Scan(&u.Bytes, &u.Files, &u.Folders); err != nil {
+		log.Printf("[DirSizes] Failed to sum %s: %v", rel, err)
+		return u, false
+	}
+	return u, true
+}
+
+// indexLater scans a tree in the background unless that is under way
+func (d *DirSizeIndex) indexLater(realDir string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.indexing[realDir] {
+		return
+	}
+	d.indexing[realDir] = true
+	go func() {
+		pace := GetBackgroundPacer().Begin("dir-size-index", PacePriorityMaintenance)
+		ctx := context.Background()
+		if err := d.indexTree(ctx, realDir, func() error { return pace.PaceContext(ctx) }, time.Now()); err != nil {
+			log.Printf("[DirSizes] Failed to index %s: %v", realDir, err)
+		}
+		pace.End()
+		d.mu.Lock()
+		delete(d.indexing, realDir)
+		d.mu.Unlock()
+	}()
+}
+
+// MovePath follows a rename or move with the rows of the folder and below
+func (d *DirSizeIndex) MovePath(oldRealPath, newRealPath string) {
+	if d == nil {
+		return
+	}
+	oldRel, newRel := d.rel(oldRealPath), d.rel(newRealPath)
+	switch {
+	case oldRel == "":
+		d.Changed(newRealPath)
+		return
+	case newRel == "":
+		d.ForgetTree(oldRealPath)
+		return
+	}
+	// Rows of an item the move replaced go first
+	d.forget(newRel)
+	if d.covered(oldRel) {
+		for _, table := range []string{"dir_sizes", "dir_size_roots"} {
+			if _, err := d.db.Exec(`
+				UPDATE `+table+`
+				SET path = $2 || substr(path, length($1) + 1)
+				WHERE path = $1 OR path LIKE $3
+			`, oldRel, newRel, escapeLikePattern(oldRel)+"/%"); err != nil {
+				log.Printf("[DirSizes] Failed to move %s -> %s: %v", oldRel, newRel, err)
+			}
+		}
+		if _, err := d.db.Exec(`
+			UPDATE dir_sizes SET hidden = path ~ '(^|/)\.' WHERE path = $1 OR path LIKE $2
+		`, newRel, escapeLikePattern(newRel)+"/%"); err != nil {
+			log.Printf("[DirSizes] Failed to move %s -> %s: %v", oldRel, newRel, err)
+		}
+	} else {
+		// Rows outside a scanned tree may be incomplete; the folder is
+		// scanned again where it landed
+		d.forget(oldRel)
+	}
+	d.Changed(oldRealPath)
+	d.Changed(newRealPath)
+}
+
+// ForgetTree drops the rows of a deleted folder and below and rescans its parent
+func (d *DirSizeIndex) ForgetTree(realPath string) {
+	if d == nil {
+		return
+	}
+	d.forget(d.rel(realPath))
+	d.Changed(realPath)
+}
+
+func (d *DirSizeIndex) forget(rel string) {
+	if rel == "" {
+		return
+	}
+	for _, table := range []string{"dir_sizes", "dir_size_roots"} {
+		if _, err := d.db.Exec(`DELETE FROM `+table+` WHERE path = $1 OR path LIKE $2`,
+			rel, escapeLikePattern(rel)+"/%"); err != nil {
+			log.Printf("[DirSizes] Failed to forget %s: %v", rel, err)
+		}
+	}
+}
+
+// indexedDirSize returns the size of a directory tree from the size index;
+// false when the caller has to walk it
+func indexedDirSize(realDir string) (int64, bool) {
+	u, ok := GetDirSizes().Usage(context.Background(), realDir)
+	return u.Bytes, ok
+}
+
+// ---------------------------------------------------------------------------
+// Reconcile job and admin API
+// ---------------------------------------------------------------------------
+
+// DirSizeReconcileProgress is the progress and, once finished, the result
+// of a JobDirSizeReconcile job
+type DirSizeReconcileProgress struct {
+	Folders int `json:"folders"` // directories scanned
+	Removed int `json:"removed"` // rows of directories that are gone
+}
+
+// StartDirSizeJobs registers the reconcile job and runs it daily. The first
+// start after the index was added fills it right away.
+func (h *Handler) StartDirSizeJobs() {
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:       JobDirSizeReconcile,
+		Idempotent: true,
+		Priority:   PacePriorityMaintenance,
+		Run:        h.runDirSizeReconcile,
+	})
+	var roots int
+	_ = h.db.QueryRow(`SELECT COUNT(*) FROM dir_size_roots`).Scan(&roots)
+	jobs.Schedule(JobDirSizeReconcile, 24*time.Hour, roots == 0)
+}
+
+// runDirSizeReconcile runs a JobDirSizeReconcile job: it rescans every
+// indexed folder, then drops the rows of folders it did not find
+func (h *Handler) runDirSizeReconcile(run *JobRun) error {
+	d := GetDirSizes()
+	if d == nil {
+		return nil
+	}
+	started := time.Now()
+	progress := &DirSizeReconcileProgress{}
+	pace := func() error {
+		progress.Folders++
+		if progress.Folders%1000 == 0 {
+			run.SetProgress(progress)
+		}
+		return run.Pace()
+	}
+	for _, top := range dirSizeTops {
+		if err := d.indexTree(run.Context(), filepath.Join(h.dataRoot, top), pace, started); err != nil {
+			run.SetProgress(progress)
+			return err
+		}
+	}
+
+	res, err := h.db.Exec(`DELETE FROM dir_sizes WHERE scanned_at < $1`, started)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	progress.Removed = int(n)
+	if _, err := h.db.Exec(`
+		DELETE FROM dir_size_roots r WHERE NOT EXISTS (SELECT 1 FROM dir_sizes s WHERE s.path = r.path)
+	`); err != nil {
+		return err
+	}
+	run.SetProgress(progress)
+	return nil
+}
+
+// RebuildDirSizeIndex queues a rescan of every folder into the size index
+// @Summary		Recalculate the folder size index
+// @Description	Queues a background job that rescans every folder below the home folders, shared drives and trash into the folder size index, which answers storage usage, folder statistics and the user list. Use it when sizes look wrong after changes made while the server was down. Poll /jobs/{id} for progress: folders scanned and rows removed. The same job runs daily.
+// @Tags		Admin
+// @Produce		json
+// @Success		202		{object}	docs.SuccessResponse	"Rescan queued: jobId"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		503		{object}	docs.ErrorResponse	"Size index not available"
+// @Security	BearerAuth
+// @Router		/admin/storage/size-index/rebuild [post]
+func (h *Handler) RebuildDirSizeIndex(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	if GetDirSizes() == nil {
+		return RespondError(c, NewAPIError(ErrCodeServiceUnavailable, "Folder size index is not available"))
+	}
+	jobID, err := GetJobs().Enqueue(JobDirSizeReconcile, struct{}{}, &claims.UserID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("queue size index rebuild", err))
+	}
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"jobId": jobID,
+		},
+	})
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDirSizeIndex_ScanDirCountsDirectEntries(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	d := newDirSizeIndex(tc.DB, h.dataRoot)
+	// Contents are the paths: 10 + 11 bytes of visible files, 11 hidden
+	writeTestFiles(t, home, "docs/a.txt", "docs/bb.txt", "docs/.x.txt", "docs/sub/c.txt", "docs/.cache/d.txt")
+
+	tc.Mock.ExpectExec("INSERT INTO dir_sizes").
+		WithArgs("users/alice/docs", int64(32), int64(3), int64(2), int64(21), int64(2), int64(1), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	subdirs, err := d.scanDir(filepath.Join(home, "docs"), mustStat(t, home).ModTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subdirs) != 2 {
+		t.Errorf("subdirs = %v", subdirs)
+	}
+
+	// Paths outside the home folders, shared drives and trash are not indexed
+	if _, err := d.scanDir(filepath.Join(h.dataRoot, ".thumbnails"), mustStat(t, home).ModTime()); err != nil {
+		t.Error(err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirSizeIndex_UsageScansUncoveredTree(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	d := newDirSizeIndex(tc.DB, h.dataRoot)
+	writeTestFiles(t, home, "docs/a.txt", "docs/sub/c.txt")
+	docs := filepath.Join(home, "docs")
+
+	tc.Mock.ExpectQuery("FROM dir_size_roots").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	tc.Mock.ExpectExec("INSERT INTO dir_sizes").WithArgs("users/alice/docs", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+		sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO dir_sizes").WithArgs("users/alice/docs/sub", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+		sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO dir_size_roots").WithArgs("users/alice/docs", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectQuery(`SUM\(visible_bytes\).*NOT hidden`).WithArgs("users/alice/docs", `users/alice/docs/%`).
+		WillReturnRows(sqlmock.NewRows([]string{"bytes", "files", "folders"}).AddRow(20, 2, 1))
+
+	u, ok := d.VisibleUsage(context.Background(), docs)
+	if !ok || u != (DirUsage{Bytes: 20, Files: 2, Folders: 1}) {
+		t.Errorf("usage = %+v, %v", u, ok)
+	}
+
+	// A covered tree is summed without scanning
+	tc.Mock.ExpectQuery("FROM dir_size_roots").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	tc.Mock.ExpectQuery(`SUM\(bytes\)`).WithArgs("users/alice/docs", `users/alice/docs/%`).
+		WillReturnRows(sqlmock.NewRows([]string{"bytes", "files", "folders"}).AddRow(20, 2, 1))
+	if u, ok := d.Usage(context.Background(), docs); !ok || u.Bytes != 20 {
+		t.Errorf("usage = %+v, %v", u, ok)
+	}
+
+	// Folders that do not exist are empty; other paths are not indexed
+	if u, ok := d.Usage(context.Background(), filepath.Join(home, "missing")); !ok || u.Bytes != 0 {
+		t.Errorf("missing folder usage = %+v, %v", u, ok)
+	}
+	if _, ok := d.Usage(context.Background(), filepath.Join(h.dataRoot, ".cache")); ok {
+		t.Error("unindexed path has a usage")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirSizeIndex_MovePath(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	d := newDirSizeIndex(tc.DB, h.dataRoot)
+
+	// Into the trash: the rows of a scanned tree move along
+	tc.Mock.ExpectExec("DELETE FROM dir_sizes").WithArgs("trash/alice/1_docs", `trash/alice/1\_docs/%`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec("DELETE FROM dir_size_roots").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectQuery("FROM dir_size_roots").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	tc.Mock.ExpectExec("UPDATE dir_sizes").WithArgs("users/alice/docs", "trash/alice/1_docs", "users/alice/docs/%").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	tc.Mock.ExpectExec("UPDATE dir_size_roots").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec("UPDATE dir_sizes SET hidden").WithArgs("trash/alice/1_docs", `trash/alice/1\_docs/%`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	d.MovePath(filepath.Join(home, "docs"), filepath.Join(h.dataRoot, "trash", "alice", "1_docs"))
+
+	// Rows outside a scanned tree may be partial and are dropped
+	tc.Mock.ExpectExec("DELETE FROM dir_sizes").WithArgs("users/alice/b", "users/alice/b/%").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec("DELETE FROM dir_size_roots").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectQuery("FROM dir_size_roots").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	tc.Mock.ExpectExec("DELETE FROM dir_sizes").WithArgs("users/alice/a", "users/alice/a/%").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	tc.Mock.ExpectExec("DELETE FROM dir_size_roots").WillReturnResult(sqlmock.NewResult(0, 0))
+	d.MovePath(filepath.Join(home, "a"), filepath.Join(home, "b"))
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	// Both sides are rescanned
+	if len(d.pending) != 4 {
+		t.Errorf("pending = %v", d.pending)
+	}
+}
+
+func TestDirSizeIndex_UpdateRescansParents(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	d := newDirSizeIndex(tc.DB, h.dataRoot)
+	writeTestFiles(t, home, "docs/a.txt")
+	if err := os.MkdirAll(filepath.Join(home, "new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := map[string]struct{}{
+		filepath.Join(home, "docs", "a.txt"): {},
+		filepath.Join(home, "gone"):          {},
+	}
+	tc.Mock.ExpectExec("DELETE FROM dir_sizes").WithArgs("users/alice/gone", "users/alice/gone/%").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("DELETE FROM dir_size_roots").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.MatchExpectationsInOrder(false)
+	tc.Mock.ExpectExec("INSERT INTO dir_sizes").WithArgs("users/alice/docs", int64(10), int64(1), int64(0), int64(10), int64(1), int64(0), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO dir_sizes").WithArgs("users/alice", int64(0), int64(0), int64(2), int64(0), int64(0), int64(2), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	d.update(context.Background(), changed, func() error { return nil })
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,765 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Download sessions are the resumable alternative to ZIP downloads: the
+// selection is resolved into a manifest of files, each fetched on its own
+// from a signed URL with Range support. The server tracks which byte ranges
+// of each file it sent, so a status request tells the client what is left
+// after a broken connection.
+
+const (
+	// downloadSessionMaxFiles bounds the manifest of one session
+	downloadSessionMaxFiles = 100000
+	// downloadSessionSweepInterval is how often expired sessions are deleted
+	downloadSessionSweepInterval = time.Hour
+
+	settingDownloadSessionTTL = "download_session_ttl_hours"
+)
+
+// Who marked a session file complete
+const (
+	DownloadCompletedByServer = "server" // The served byte ranges cover the file
+	DownloadCompletedByClient = "client" // Reported by the client
+)
+
+// DownloadSessionRequest selects what a download session contains
+type DownloadSessionRequest struct {
+	Path  string   `json:"path"`
+	Paths []string `json:"paths"`
+}
+
+// DownloadSessionFile is a manifest entry with its transfer state
+type DownloadSessionFile struct {
+	Index       int        `json:"index"`
+	Path        string     `json:"path"` // Relative, starting with the selected item's name
+	Size        int64      `json:"size"`
+	ModTime     time.Time  `json:"modTime"`
+	SHA256      string     `json:"sha256,omitempty"` // Filled in shortly after the session is created
+	URL         string     `json:"url"`              // Signed; valid until the session expires
+	BytesServed int64      `json:"bytesServed"`      // Distinct bytes sent so far
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	CompletedBy string     `json:"completedBy,omitempty"` // server or client
+
+	realPath string
+}
+
+// DownloadSession is a resolved selection and the state of its files
+type DownloadSession struct {
+	ID               string                `json:"id"`
+	Paths            []string              `json:"paths"`
+	FileCount        int                   `json:"fileCount"`
+	TotalBytes       int64                 `json:"totalBytes"`
+	CompletedCount   int                   `json:"completedCount"`
+	ChecksumsPending int                   `json:"checksumsPending"`
+	CreatedAt        time.Time             `json:"createdAt"`
+	ExpiresAt        time.Time             `json:"expiresAt"`
+	Files            []DownloadSessionFile `json:"files"`
+}
+
+// downloadSessionTTL returns how long a session stays valid
+func downloadSessionTTL() time.Duration {
+	hours := 24
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		hours = sh.GetSettingInt(settingDownloadSessionTTL, 24)
+	}
+	if hours < 1 {
+		hours = 1
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// downloadFileSignature signs the URL of a session file
+func downloadFileSignature(secret, sessionID string, index int) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s/%d", sessionID, index)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// downloadFileURL returns the signed URL of a session file
+func downloadFileURL(secret, sessionID string, index int) string {
+	return fmt.Sprintf("/api/download/session/%s/files/%d?sig=%s", sessionID, index, downloadFileSignature(secret, sessionID, index))
+}
+
+// buildDownloadManifest lists the files of a selection: files as they are,
+// folders with everything below them including mount-ins, named like in a
+// ZIP download
+func buildDownloadManifest(paths []zipPathInfo) ([]DownloadSessionFile, *APIError) {
+	files := []DownloadSessionFile{}
+	add := func(realPath, rel string, info os.FileInfo) *APIError {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if len(files) >= downloadSessionMaxFiles {
+			return ErrBadRequest(fmt.Sprintf("Selection has more than %d files", downloadSessionMaxFiles))
+		}
+		files = append(files, DownloadSessionFile{
+			Index:    len(files),
+			Path:     filepath.ToSlash(rel),
+			Size:     PlainSize(realPath, info),
+			ModTime:  info.ModTime().Truncate(time.Microsecond),
+			realPath: realPath,
+		})
+		return nil
+	}
+	walk := func(root, name string) *APIError {
+		var apiErr *APIError
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			if apiErr = add(p, filepath.Join(name, rel), info); apiErr != nil {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		if apiErr != nil {
+			return apiErr
+		}
+		if err != nil {
+			return ErrOperationFailed("list folder", err)
+		}
+		return nil
+	}
+
+	for _, pi := range paths {
+		name := filepath.Base(pi.displayPath)
+		if !pi.isDir {
+			info, err := os.Stat(pi.realPath)
+			if err != nil {
+				return nil, ErrOperationFailed("access file", err)
+			}
+			if apiErr := add(pi.realPath, name, info); apiErr != nil {
+				return nil, apiErr
+			}
+			continue
+		}
+		if apiErr := walk(pi.realPath, name); apiErr != nil {
+			return nil, apiErr
+		}
+		for _, m := range GetMountIns().Below(pi.displayPath, false) {
+			if m.Path == cleanVirtualPath(pi.displayPath) {
+				continue // The folder itself, already walked
+			}
+			mountRoot := filepath.Join(name, strings.TrimPrefix(m.Path, cleanVirtualPath(pi.displayPath)+"/"))
+			if apiErr := walk(GetMountIns().RealPath(m, ""), mountRoot); apiErr != nil {
+				return nil, apiErr
+			}
+		}
+	}
+	return files, nil
+}
+
+// CreateDownloadSession resolves a selection into a manifest of signed file URLs
+// @Summary		Create download session
+// @Description	Resumable alternative to /download/zip for large selections and unreliable links. Takes path or paths with the same permission checks and ZIP download limit, and returns a session with a manifest: relative path, size and a signed URL per file, valid without a token until the session expires (download_session_ttl_hours). Files are fetched one by one and support Range requests. SHA-256 checksums are computed in the background and appear in GET /download/session/{id}. The session is audit-logged once.
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		request	body		DownloadSessionRequest	true	"Selected paths"
+// @Success		201		{object}	DownloadSession		"Session with its manifest"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		404		{object}	docs.ErrorResponse	"Path not found"
+// @Failure		413		{object}	docs.ErrorResponse	"Selection exceeds the download limit"
+// @Security	BearerAuth
+// @Router		/download/session [post]
+func (h *Handler) CreateDownloadSession(c echo.Context) error {
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+	var req DownloadSessionRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	paths := req.Paths
+	if req.Path != "" {
+		paths = append([]string{req.Path}, paths...)
+	}
+	if len(paths) == 0 {
+		return RespondError(c, ErrMissingParameter("path or paths"))
+	}
+
+	validPaths, apiErr := h.resolveZipPaths(paths, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	files, apiErr := buildDownloadManifest(validPaths)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	var totalBytes int64
+	for _, f := range files {
+		totalBytes += f.Size
+	}
+	if limit := zipDownloadMaxBytes(); limit > 0 && totalBytes > limit {
+		return RespondError(c, NewAPIError(ErrCodeFileTooLarge, "Selection exceeds the download limit").WithDetails(map[string]interface{}{
+			"fileCount":  len(files),
+			"totalBytes": totalBytes,
+			"limitBytes": limit,
+		}))
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return RespondError(c, ErrInternal("Failed to create download session"))
+	}
+	secret := hex.EncodeToString(key)
+	displayPaths := make([]string, len(validPaths))
+	for i, pi := range validPaths {
+		displayPaths[i] = pi.displayPath
+	}
+	pathsJSON, _ := json.Marshal(displayPaths)
+	expiresAt := time.Now().Add(downloadSessionTTL())
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("create download session", err))
+	}
+	defer tx.Rollback()
+
+	session := DownloadSession{Paths: displayPaths, FileCount: len(files), TotalBytes: totalBytes,
+		ChecksumsPending: len(files), Files: files}
+	if err := tx.QueryRow(`
+		INSERT INTO download_sessions (user_id, secret, paths, file_count, total_bytes, client_ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, expires_at
+	`, claims.UserID, secret, pathsJSON, len(files), totalBytes, c.RealIP(), expiresAt).
+		Scan(&session.ID, &session.CreatedAt, &session.ExpiresAt); err != nil {
+		return RespondError(c, ErrOperationFailed("create download session", err))
+	}
+
+	indexes := make([]int64, len(files))
+	relPaths := make([]string, len(files))
+	realPaths := make([]string, len(files))
+	sizes := make([]int64, len(files))
+	modTimes := make([]string, len(files))
+	for i, f := range files {
+		indexes[i], relPaths[i], realPaths[i], sizes[i] = int64(f.Index), f.Path, f.realPath, f.Size
+		modTimes[i] = f.ModTime.Format(time.RFC3339Nano)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO download_session_files (session_id, idx, path, real_path, size, mod_time)
+		SELECT $1, * FROM unnest($2::int[], $3::text[], $4::text[], $5::bigint[], $6::timestamptz[])
+	`, session.ID, pq.Array(indexes), pq.Array(relPaths), pq.Array(realPaths), pq.Array(sizes), pq.Array(modTimes)); err != nil {
+		return RespondError(c, ErrOperationFailed("create download session", err))
+	}
+	if err := tx.Commit(); err != nil {
+		return RespondError(c, ErrOperationFailed("create download session", err))
+	}
+
+	for i := range session.Files {
+		session.Files[i].URL = downloadFileURL(secret, session.ID, i)
+	}
+	GetDownloadSessions().Wake()
+
+	for _, pi := range validPaths {
+		h.auditZipMountIns(c, claims, pi)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventFileDownloadSession, strings.Join(displayPaths, ", "), map[string]interface{}{
+		"sessionId":  session.ID,
+		"paths":      displayPaths,
+		"fileCount":  len(files),
+		"totalBytes": totalBytes,
+		"expiresAt":  session.ExpiresAt,
+	})
+
+	return RespondCreated(c, session)
+}
+
+// loadDownloadSession loads a live session of the user with its files
+func (h *Handler) loadDownloadSession(id, userID string) (*DownloadSession, *APIError) {
+	var s DownloadSession
+	var secret string
+	var paths []byte
+	err := h.db.QueryRow(`
+		SELECT id, secret, paths, file_count, total_bytes, created_at, expires_at
+		FROM download_sessions
+		WHERE id = $1 AND user_id = $2 AND expires_at > NOW()
+	`, id, userID).Scan(&s.ID, &secret, &paths, &s.FileCount, &s.TotalBytes, &s.CreatedAt, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound("Download session")
+	}
+	if err != nil {
+		return nil, ErrOperationFailed("load download session", err)
+	}
+	_ = json.Unmarshal(paths, &s.Paths)
+
+	rows, err := h.db.Query(`
+		SELECT idx, path, size, mod_time, sha256, bytes_served, completed_at, completed_by
+		FROM download_session_files
+		WHERE session_id = $1
+		ORDER BY idx
+	`, s.ID)
+	if err != nil {
+		return nil, ErrOperationFailed("load download session", err)
+	}
+	defer rows.Close()
+	s.Files = []DownloadSessionFile{}
+	for rows.Next() {
+		var f DownloadSessionFile
+		var checksum, completedBy sql.NullString
+		if err := rows.Scan(&f.Index, &f.Path, &f.Size, &f.ModTime, &checksum, &f.BytesServed, &f.CompletedAt, &completedBy); err != nil {
+			return nil, ErrOperationFailed("load download session", err)
+		}
+		f.SHA256, f.CompletedBy = checksum.String, completedBy.String
+		if !checksum.Valid {
+			s.ChecksumsPending++
+		}
+		if f.CompletedAt != nil {
+			s.CompletedCount++
+		}
+		f.URL = downloadFileURL(secret, s.ID, f.Index)
+		s.Files = append(s.Files, f)
+	}
+	return &s, nil
+}
+
+// GetDownloadSession reports which files of a session were fully fetched
+// @Summary		Download session status
+// @Description	Returns the manifest of a download session with each file's checksum and transfer state: bytesServed counts the distinct bytes sent, and completedBy is server once the served ranges cover the file or client once reported via /complete. Files not completed are the ones left to fetch.
+// @Tags		Files
+// @Produce		json
+// @Param		id	path		string	true	"Session ID"
+// @Success		200	{object}	DownloadSession		"Session"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found or expired"
+// @Security	BearerAuth
+// @Router		/download/session/{id} [get]
+func (h *Handler) GetDownloadSession(c echo.Context) error {
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+	session, apiErr := h.loadDownloadSession(c.Param("id"), claims.UserID)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	return RespondSuccess(c, session)
+}
+
+// DownloadSessionCompleteRequest reports files the client has in full
+type DownloadSessionCompleteRequest struct {
+	Indexes []int `json:"indexes"`
+}
+
+// CompleteDownloadSessionFiles records files the client reports as fetched
+// @Summary		Report fetched files
+// @Description	Marks files of a download session as fetched by the client, e.g. after verifying their checksums. Files already complete are left as they are.
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string							true	"Session ID"
+// @Param		request	body		DownloadSessionCompleteRequest	true	"Manifest indexes"
+// @Success		200		{object}	docs.SuccessResponse	"completed: files newly marked"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found or expired"
+// @Security	BearerAuth
+// @Router		/download/session/{id}/complete [post]
+func (h *Handler) CompleteDownloadSessionFiles(c echo.Context) error {
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+	var req DownloadSessionCompleteRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if len(req.Indexes) == 0 {
+		return RespondError(c, ErrMissingParameter("indexes"))
+	}
+	indexes := make([]int64, len(req.Indexes))
+	for i, idx := range req.Indexes {
+		indexes[i] = int64(idx)
+	}
+
+	rows, err := h.db.Query(`
+		UPDATE download_session_files f
+		SET completed_at = NOW(), completed_by = $3
+		FROM download_sessions s
+		WHERE s.id = f.session_id AND s.id = $1 AND s.user_id = $2 AND s.expires_at > NOW()
+			AND f.idx = ANY($4::int[]) AND f.completed_at IS NULL
+		RETURNING f.real_path
+	`, c.Param("id"), claims.UserID, DownloadCompletedByClient, pq.Array(indexes))
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("update download session", err))
+	}
+	defer rows.Close()
+	completed := 0
+	for rows.Next() {
+		var realPath string
+		if rows.Scan(&realPath) == nil {
+			GetDownloadStats().RecordDownload(realPath, claims.UserID, c.RealIP())
+			completed++
+		}
+	}
+	return RespondSuccess(c, map[string]int{"completed": completed})
+}
+
+// DeleteDownloadSession ends a session before it expires
+// @Summary		End download session
+// @Description	Deletes a download session; its file URLs stop working.
+// @Tags		Files
+// @Produce		json
+// @Param		id	path		string	true	"Session ID"
+// @Success		200	{object}	docs.SuccessResponse	"Deleted"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/download/session/{id} [delete]
+func (h *Handler) DeleteDownloadSession(c echo.Context) error {
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+	res, err := h.db.Exec(`DELETE FROM download_sessions WHERE id = $1 AND user_id = $2`, c.Param("id"), claims.UserID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("delete download session", err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return RespondError(c, ErrNotFound("Download session"))
+	}
+	return RespondSuccess(c, map[string]string{"message": "Download session ended"})
+}
+
+// GetDownloadSessionFile serves one file of a session
+// @Summary		Fetch download session file
+// @Description	Serves a file of a download session from its signed manifest URL; no token is needed. Range and If-Range requests resume interrupted transfers. Fails with 412 if the file changed since the session was created.
+// @Tags		Files
+// @Produce		octet-stream
+// @Param		id		path		string	true	"Session ID"
+// @Param		index	path		int		true	"Manifest index"
+// @Param		sig		query		string	true	"URL signature from the manifest"
+// @Success		200		{file}		binary	"File content"
+// @Success		206		{file}		binary	"Requested range"
+// @Failure		403		{object}	docs.ErrorResponse	"Invalid signature"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found or expired"
+// @Failure		412		{object}	docs.ErrorResponse	"File changed since the session was created"
+// @Router		/download/session/{id}/files/{index} [get]
+func (h *Handler) GetDownloadSessionFile(c echo.Context) error {
+	sessionID := c.Param("id")
+	index, err := strconv.Atoi(c.Param("index"))
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Invalid file index"))
+	}
+
+	var secret, userID, relPath, realPath string
+	var size int64
+	var modTime time.Time
+	err = h.db.QueryRow(`
+		SELECT s.secret, s.user_id, f.path, f.real_path, f.size, f.mod_time
+		FROM download_sessions s
+		JOIN download_session_files f ON f.session_id = s.id
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND f.idx = $2 AND s.expires_at > NOW() AND u.is_active = TRUE
+	`, sessionID, index).Scan(&secret, &userID, &relPath, &realPath, &size, &modTime)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Download session file"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load download session", err))
+	}
+	if !hmac.Equal([]byte(c.QueryParam("sig")), []byte(downloadFileSignature(secret, sessionID, index))) {
+		return RespondError(c, ErrForbidden("Invalid download signature"))
+	}
+
+	info, err := os.Stat(realPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return RespondError(c, ErrNotFound("File"))
+		}
+		return RespondError(c, ErrOperationFailed("access file", err))
+	}
+	if PlainSize(realPath, info) != size || !info.ModTime().Truncate(time.Microsecond).Equal(modTime) {
+		return RespondError(c, NewAPIError(ErrCodePreconditionFailed, "File changed since the download session was created"))
+	}
+
+	setContentDisposition(c, path.Base(relPath))
+	if size >= ActivityMinDownloadSize {
+		defer TrackResponse(c, ActivityDownload, relPath, "", size).Finish()
+	}
+	counter := &countingResponseWriter{ResponseWriter: c.Response().Writer}
+	c.Response().Writer = counter
+	c.Response().Header().Set("ETag", GenerateETag(realPath, info.ModTime(), info.Size()))
+	if err := ServePlainFile(c, realPath); err != nil {
+		return err
+	}
+
+	start, ok := servedRangeStart(c.Response().Status, c.Request().Header.Get("Range"), size)
+	if ok && counter.n > 0 {
+		h.recordDownloadRange(sessionID, index, userID, realPath, c.RealIP(), downloadRange{start, start + counter.n})
+	}
+	return nil
+}
+
+// countingResponseWriter counts the body bytes written
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// servedRangeStart returns the file offset a response body started at. Only
+// full responses and single ranges are tracked; multipart ranges are not.
+func servedRangeStart(status int, rangeHeader string, size int64) (int64, bool) {
+	switch status {
+	case http.StatusOK:
+		return 0, true
+	case http.StatusPartialContent:
+	default:
+		return 0, false
+	}
+	spec, ok := strings.CutPrefix(strings.TrimSpace(rangeHeader), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, false
+	}
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return max(size-n, 0), true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// downloadRange is a half-open byte range [start, end)
+type downloadRange [2]int64
+
+// mergeDownloadRanges adds a range to sorted disjoint ranges and merges
+// overlapping and adjacent ones
+func mergeDownloadRanges(ranges []downloadRange, r downloadRange) []downloadRange {
+	ranges = append(ranges, r)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:1]
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next[0] <= last[1] {
+			last[1] = max(last[1], next[1])
+			continue
+		}
+		merged = append(merged, next)
+	}
+	return merged
+}
+
+// rangesBytes returns the bytes covered by disjoint ranges
+func rangesBytes(ranges []downloadRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r[1] - r[0]
+	}
+	return n
+}
+
+// recordDownloadRange adds a served range to a session file and marks the
+// file complete once the ranges cover it
+func (h *Handler) recordDownloadRange(sessionID string, index int, userID, realPath, clientIP string, served downloadRange) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		log.Printf("[DownloadSession] Failed to record range of %s/%d: %v", sessionID, index, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var size int64
+	var rangesJSON []byte
+	var completedAt sql.NullTime
+	if err := tx.QueryRow(`
+		SELECT size, served_ranges, completed_at FROM download_session_files
+		WHERE session_id = $1 AND idx = $2
+		FOR UPDATE
+	`, sessionID, index).Scan(&size, &rangesJSON, &completedAt); err != nil {
+		log.Printf("[DownloadSession] Failed to record range of %s/%d: %v", sessionID, index, err)
+		return
+	}
+	var ranges []downloadRange
+	_ = json.Unmarshal(rangesJSON, &ranges)
+	ranges = mergeDownloadRanges(ranges, downloadRange{served[0], min(served[1], size)})
+	servedBytes := rangesBytes(ranges)
+	complete := !completedAt.Valid && servedBytes >= size
+
+	rangesJSON, _ = json.Marshal(ranges)
+	if _, err := tx.Exec(`
+		UPDATE download_session_files
+		SET served_ranges = $3, bytes_served = $4,
+			completed_at = CASE WHEN $5 THEN NOW() ELSE completed_at END,
+			completed_by = CASE WHEN $5 THEN $6 ELSE completed_by END
+		WHERE session_id = $1 AND idx = $2
+	`, sessionID, index, rangesJSON, servedBytes, complete, DownloadCompletedByServer); err != nil {
+		log.Printf("[DownloadSession] Failed to record range of %s/%d: %v", sessionID, index, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("[DownloadSession] Failed to record range of %s/%d: %v", sessionID, index, err)
+		return
+	}
+	if complete {
+		GetDownloadStats().RecordDownload(realPath, userID, clientIP)
+	}
+}
+
+// DownloadSessions computes the checksums of session files in the
+// background and deletes expired sessions
+type DownloadSessions struct {
+	db   *sql.DB
+	wake chan struct{}
+}
+
+var globalDownloadSessions *DownloadSessions
+
+// InitDownloadSessions starts the checksum and expiry worker
+func InitDownloadSessions(db *sql.DB) *DownloadSessions {
+	s := &DownloadSessions{db: db, wake: make(chan struct{}, 1)}
+	globalDownloadSessions = s
+	go s.work()
+	return s
+}
+
+// GetDownloadSessions returns the download session worker (nil if not initialized)
+func GetDownloadSessions() *DownloadSessions {
+	return globalDownloadSessions
+}
+
+// Wake has the worker look for checksums to compute
+func (s *DownloadSessions) Wake() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *DownloadSessions) work() {
+	ticker := time.NewTicker(downloadSessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.sweep(); err != nil {
+			log.Printf("[DownloadSession] Failed to delete expired sessions: %v", err)
+		} else if n > 0 {
+			log.Printf("[DownloadSession] Deleted %d expired sessions", n)
+		}
+		for s.checksumNext() {
+		}
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep deletes expired sessions with their files
+func (s *DownloadSessions) sweep() (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM download_sessions WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// checksumNext computes the checksum of the next file of a live session,
+// oldest session first. It returns false when there is none. Files that
+// changed or went missing get an empty checksum and are not tried again.
+func (s *DownloadSessions) checksumNext() bool {
+	var sessionID, realPath string
+	var index int
+	var size int64
+	var modTime time.Time
+	err := s.db.QueryRow(`
+		SELECT f.session_id, f.idx, f.real_path, f.size, f.mod_time
+		FROM download_session_files f
+		JOIN download_sessions s ON s.id = f.session_id
+		WHERE f.sha256 IS NULL AND s.expires_at > NOW()
+		ORDER BY s.created_at, f.idx
+		LIMIT 1
+	`).Scan(&sessionID, &index, &realPath, &size, &modTime)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[DownloadSession] Failed to find files to checksum: %v", err)
+		}
+		return false
+	}
+
+	checksum := ""
+	if info, err := os.Stat(realPath); err == nil && PlainSize(realPath, info) == size &&
+		info.ModTime().Truncate(time.Microsecond).Equal(modTime) {
+		if sum, err := pacedSHA256(realPath); err == nil {
+			checksum = sum
+		} else {
+			log.Printf("[DownloadSession] Failed to checksum %s: %v", realPath, err)
+		}
+	}
+	if _, err := s.db.Exec(`
+		UPDATE download_session_files SET sha256 = $3 WHERE session_id = $1 AND idx = $2
+	`, sessionID, index, checksum); err != nil {
+		log.Printf("[DownloadSession] Failed to store checksum: %v", err)
+		return false
+	}
+	return true
+}
+
+// pacedSHA256 hashes a file's plaintext, backing off while the server is busy
+func pacedSHA256(realPath string) (string, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	pace := GetBackgroundPacer().Begin("download-checksum", PacePriorityMaintenance)
+	defer pace.End()
+
+	hasher := sha256.New()
+	buf := make([]byte, 1<<20)
+	for {
+		n, err := f.Read(buf)
+		hasher.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		pace.Pace()
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMergeDownloadRanges(t *testing.T) {
+	var ranges []downloadRange
+	for _, r := range []downloadRange{{100, 200}, {0, 50}, {50, 80}, {150, 300}, {400, 500}} {
+		ranges = mergeDownloadRanges(ranges, r)
+	}
+	if want := []downloadRange{{0, 80}, {100, 300}, {400, 500}}; !reflect.DeepEqual(ranges, want) {
+		t.Errorf("ranges = %v, want %v", ranges, want)
+	}
+	if n := rangesBytes(ranges); n != 380 {
+		t.Errorf("rangesBytes = %d", n)
+	}
+}
+
+func TestServedRangeStart(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		header string
+		start  int64
+		ok     bool
+	}{
+		{http.StatusOK, "", 0, true},
+		{http.StatusPartialContent, "bytes=500-", 500, true},
+		{http.StatusPartialContent, "bytes=500-999", 500, true},
+		{http.StatusPartialContent, "bytes=-100", 900, true},
+		{http.StatusPartialContent, "bytes=0-10,20-30", 0, false},
+		{http.StatusNotModified, "", 0, false},
+	} {
+		start, ok := servedRangeStart(tc.status, tc.header, 1000)
+		if start != tc.start || ok != tc.ok {
+			t.Errorf("servedRangeStart(%d, %q) = %d, %v", tc.status, tc.header, start, ok)
+		}
+	}
+}
+
+func TestBuildDownloadManifest(t *testing.T) {
+	root := t.TempDir()
+	photos := filepath.Join(root, "Photos")
+	os.MkdirAll(filepath.Join(photos, "2024"), 0755)
+	os.WriteFile(filepath.Join(photos, "a.jpg"), []byte("aaa"), 0644)
+	os.WriteFile(filepath.Join(photos, "2024", "b.jpg"), []byte("bbbbb"), 0644)
+	notes := filepath.Join(root, "notes.txt")
+	os.WriteFile(notes, []byte("n"), 0644)
+
+	files, apiErr := buildDownloadManifest([]zipPathInfo{
+		{realPath: photos, displayPath: "/home/Photos", isDir: true},
+		{realPath: notes, displayPath: "/home/notes.txt"},
+	})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	var got []string
+	var total int64
+	for i, f := range files {
+		if f.Index != i {
+			t.Errorf("index of %s = %d", f.Path, f.Index)
+		}
+		got = append(got, f.Path)
+		total += f.Size
+	}
+	if want := []string{"Photos/2024/b.jpg", "Photos/a.jpg", "notes.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest = %v, want %v", got, want)
+	}
+	if total != 9 {
+		t.Errorf("total = %d", total)
+	}
+}
+
+func TestGetDownloadSessionFile(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dir := t.TempDir()
+	realPath := filepath.Join(dir, "big.bin")
+	os.WriteFile(realPath, []byte("0123456789"), 0644)
+	info, _ := os.Stat(realPath)
+	h := &Handler{db: tc.DB, dataRoot: dir, auditHandler: NewAuditHandler(tc.DB, dir)}
+	secret, sessionID := "secret", "s1"
+
+	fetch := func(sig, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/download/session/s1/files/0?sig="+sig, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(req, rec)
+		c.SetParamNames("id", "index")
+		c.SetParamValues(sessionID, "0")
+		if err := h.GetDownloadSessionFile(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	expectFile := func(modTime time.Time) {
+		tc.Mock.ExpectQuery("SELECT s.secret, s.user_id, f.path, f.real_path, f.size, f.mod_time").
+			WithArgs(sessionID, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"secret", "user_id", "path", "real_path", "size", "mod_time"}).
+				AddRow(secret, "u1", "Backup/big.bin", realPath, int64(10), modTime))
+	}
+	modTime := info.ModTime().Truncate(time.Microsecond)
+
+	// A wrong signature is refused
+	expectFile(modTime)
+	AssertStatus(t, fetch("bad", ""), http.StatusForbidden)
+
+	// The second half, after the first half was fetched earlier, completes the file
+	expectFile(modTime)
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectQuery("SELECT size, served_ranges, completed_at FROM download_session_files").
+		WithArgs(sessionID, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"size", "served_ranges", "completed_at"}).AddRow(int64(10), []byte(`[[0,5]]`), nil))
+	tc.Mock.ExpectExec("UPDATE download_session_files").
+		WithArgs(sessionID, 0, []byte(`[[0,10]]`), int64(10), true, DownloadCompletedByServer).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+	rec := fetch(downloadFileSignature(secret, sessionID, 0), "bytes=5-")
+	AssertStatus(t, rec, http.StatusPartialContent)
+	if rec.Body.String() != "56789" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+
+	// A file changed since the session was created is not served
+	expectFile(modTime.Add(-time.Hour))
+	AssertStatus(t, fetch(downloadFileSignature(secret, sessionID, 0), ""), http.StatusPreconditionFailed)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
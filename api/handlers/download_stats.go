@@ -0,0 +1,449 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DownloadStatsTracker buffers per-file download counts in memory and
+// periodically flushes them to the file_stats table, so a download does not
+// cost a database write
+type DownloadStatsTracker struct {
+	db       *sql.DB
+	dataRoot string
+	mu       sync.Mutex
+	pending  map[downloadStatsKey]int
+}
+
+type downloadStatsKey struct {
+	Path   string
+	Day    string
+	UserID string
+	IP     string
+}
+
+// FileDownloadStats is the download summary for a file or directory
+type FileDownloadStats struct {
+	Path         string     `json:"path"`
+	Period       string     `json:"period,omitempty"`
+	Downloads    int64      `json:"downloads"`
+	UniqueUsers  int64      `json:"uniqueUsers"`
+	UniqueIPs    int64      `json:"uniqueIps"`
+	LastDownload *time.Time `json:"lastDownload,omitempty"` // Flushed downloads only; moves and deletes don't count
+	Deleted      bool       `json:"deleted,omitempty"`
+}
+
+var globalDownloadStats *DownloadStatsTracker
+
+// InitDownloadStats creates the global download stats tracker
+func InitDownloadStats(db *sql.DB, dataRoot string) *DownloadStatsTracker {
+	globalDownloadStats = &DownloadStatsTracker{
+		db:       db,
+		dataRoot: dataRoot,
+		pending:  make(map[downloadStatsKey]int),
+	}
+	return globalDownloadStats
+}
+
+// GetDownloadStats returns the global download stats tracker (nil if not initialized)
+func GetDownloadStats() *DownloadStatsTracker {
+	return globalDownloadStats
+}
+
+// relPath converts a real path to the data-root relative key used in file_stats
+func (t *DownloadStatsTracker) relPath(realPath string) (string, bool) {
+	rel, err := filepath.Rel(t.dataRoot, realPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// isTracked checks the global and per shared drive opt-out settings
+func (t *DownloadStatsTracker) isTracked(rel string) bool {
+	settings := GetGlobalSettingsHandler()
+	if settings == nil {
+		return true
+	}
+	if !settings.IsDownloadStatsEnabled() {
+		return false
+	}
+	if strings.HasPrefix(rel, "shared/") {
+		parts := strings.SplitN(rel, "/", 3)
+		for _, name := range settings.GetDownloadStatsDisabledFolders() {
+			if len(parts) > 1 && parts[1] == sanitizeFolderName(name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// RecordDownload counts one download of the file at realPath
+func (t *DownloadStatsTracker) RecordDownload(realPath, userID, clientIP string) {
+	if t == nil {
+		return
+	}
+	rel, ok := t.relPath(realPath)
+	if !ok || !t.isTracked(rel) {
+		return
+	}
+
+	key := downloadStatsKey{
+		Path:   rel,
+		Day:    time.Now().Format("2006-01-02"),
+		UserID: userID,
+		IP:     clientIP,
+	}
+	t.mu.Lock()
+	t.pending[key]++
+	t.mu.Unlock()
+}
+
+// Flush writes buffered counts to the database
+func (t *DownloadStatsTracker) Flush() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	if len(t.pending) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	batch := t.pending
+	t.pending = make(map[downloadStatsKey]int)
+	t.mu.Unlock()
+
+	err := WithTransaction(t.db, func(tx *sql.Tx) error {
+		for key, count := range batch {
+			var userID interface{}
+			if key.UserID != "" {
+				userID = key.UserID
+			}
+			if _, err := tx.Exec(`
+				INSERT INTO file_stats (path, day, user_id, ip_addr, downloads, last_download_at)
+				VALUES ($1, $2, $3, $4, $5, NOW())
+				ON CONFLICT ON CONSTRAINT file_stats_unique
+				DO UPDATE SET downloads = file_stats.downloads + EXCLUDED.downloads,
+				              is_deleted = FALSE, updated_at = NOW(), last_download_at = NOW()
+			`, key.Path, key.Day, userID, key.IP, count); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Put the counts back so they are retried on the next flush
+		t.mu.Lock()
+		for key, count := range batch {
+			t.pending[key] += count
+		}
+		t.mu.Unlock()
+		return fmt.Errorf("failed to flush download stats: %w", err)
+	}
+	return nil
+}
+
+// StartFlushRoutine flushes buffered counts at the given interval
+func (t *DownloadStatsTracker) StartFlushRoutine(interval time.Duration) {
+	if t == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := t.Flush(); err != nil {
+				log.Printf("[DownloadStats] %v", err)
+			}
+		}
+	}()
+}
+
+// MovePath carries statistics along when a file or folder is renamed or moved
+func (t *DownloadStatsTracker) MovePath(oldRealPath, newRealPath string) {
+	if t == nil {
+		return
+	}
+	oldRel, ok1 := t.relPath(oldRealPath)
+	newRel, ok2 := t.relPath(newRealPath)
+	if !ok1 || !ok2 {
+		return
+	}
+	if err := t.Flush(); err != nil {
+		log.Printf("[DownloadStats] %v", err)
+	}
+	_, err := t.db.Exec(`
+		UPDATE file_stats
+		SET path = $2 || substr(path, length($1) + 1), updated_at = NOW()
+		WHERE path = $1 OR starts_with(path, $1 || '/')
+	`, oldRel, newRel)
+	if err != nil {
+		log.Printf("[DownloadStats] Failed to move stats %s -> %s: %v", oldRel, newRel, err)
+	}
+}
+
+// MarkDeleted flags statistics of a deleted file or folder; history is kept for reporting
+func (t *DownloadStatsTracker) MarkDeleted(realPath string) {
+	t.setDeleted(realPath, true)
+}
+
+// MarkRestored clears the deleted flag when an item is restored from trash
+func (t *DownloadStatsTracker) MarkRestored(realPath string) {
+	t.setDeleted(realPath, false)
+}
+
+func (t *DownloadStatsTracker) setDeleted(realPath string, deleted bool) {
+	if t == nil {
+		return
+	}
+	rel, ok := t.relPath(realPath)
+	if !ok {
+		return
+	}
+	if err := t.Flush(); err != nil {
+		log.Printf("[DownloadStats] %v", err)
+	}
+	_, err := t.db.Exec(`
+		UPDATE file_stats SET is_deleted = $2, updated_at = NOW()
+		WHERE path = $1 OR starts_with(path, $1 || '/')
+	`, rel, deleted)
+	if err != nil {
+		log.Printf("[DownloadStats] Failed to update deleted flag for %s: %v", rel, err)
+	}
+}
+
+// parseStatsPeriod parses a period like "30d", "12h" or "all" into a start time.
+// A zero time means no lower bound.
+func parseStatsPeriod(period string) (time.Time, error) {
+	if period == "" || period == "all" {
+		return time.Time{}, nil
+	}
+	if strings.HasSuffix(period, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(period, "d"))
+		if err != nil || days <= 0 {
+			return time.Time{}, fmt.Errorf("invalid period")
+		}
+		return time.Now().AddDate(0, 0, -days), nil
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid period")
+	}
+	return time.Now().Add(-d), nil
+}
+
+// statsDisplayPath converts a file_stats path back to a virtual path for the viewer
+func statsDisplayPath(rel, username string) string {
+	prefix := "users/" + username + "/"
+	if username != "" && strings.HasPrefix(rel, prefix) {
+		return "/home/" + strings.TrimPrefix(rel, prefix)
+	}
+	return "/" + rel
+}
+
+// GetFileDownloadStats returns download statistics for a file or directory
+// @Summary		Get download statistics
+// @Description	Get downloads and unique users/IPs for a file, or aggregated for a directory with its most downloaded files
+// @Tags		Files
+// @Produce		json
+// @Param		path	path		string	true	"File or folder path"
+// @Param		period	query		string	false	"Period such as 7d, 30d, 24h or all (default 30d)"
+// @Success		200		{object}	docs.SuccessResponse	"Download statistics"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/files/stats/{path} [get]
+func (h *Handler) GetFileDownloadStats(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	requestPath := c.Param("*")
+	if decodedPath, err := url.PathUnescape(requestPath); err == nil {
+		requestPath = decodedPath
+	}
+	virtualPath := "/" + requestPath
+
+	period := c.QueryParam("period")
+	if period == "" {
+		period = "30d"
+	}
+	since, err := parseStatsPeriod(period)
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Invalid period"))
+	}
+
+	realPath, storageType, displayPath, err := h.resolvePath(virtualPath, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if storageType != StorageHome && storageType != StorageShared {
+		return RespondError(c, ErrBadRequest("Statistics are only available for home and shared drive items"))
+	}
+	if storageType == StorageShared && !h.CanReadSharedDrive(claims.UserID, virtualPath) {
+		return RespondError(c, ErrForbidden("No permission to access this path"))
+	}
+
+	tracker := GetDownloadStats()
+	if tracker == nil {
+		return RespondError(c, NewAPIError(ErrCodeServiceUnavailable, "Download statistics are not available"))
+	}
+	rel, ok := tracker.relPath(realPath)
+	if !ok {
+		return RespondError(c, ErrInvalidPath("Invalid path"))
+	}
+	if !tracker.isTracked(rel) {
+		return RespondSuccess(c, map[string]interface{}{
+			"path":    displayPath,
+			"enabled": false,
+		})
+	}
+	if err := tracker.Flush(); err != nil {
+		log.Printf("[DownloadStats] %v", err)
+	}
+
+	isDir := false
+	if info, statErr := os.Stat(realPath); statErr == nil {
+		isDir = info.IsDir()
+	}
+
+	where := "path = $1"
+	if isDir {
+		where = "starts_with(path, $1 || '/')"
+	}
+
+	stats := FileDownloadStats{Path: displayPath, Period: period}
+	var lastDownload sql.NullTime
+	var deleted sql.NullBool
+	err = h.db.QueryRow(`
+		SELECT COALESCE(SUM(downloads), 0), COUNT(DISTINCT user_id), COUNT(DISTINCT ip_addr),
+		       MAX(last_download_at), BOOL_AND(is_deleted)
+		FROM file_stats
+		WHERE `+where+` AND day >= $2
+	`, rel, since).Scan(&stats.Downloads, &stats.UniqueUsers, &stats.UniqueIPs, &lastDownload, &deleted)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load download statistics"))
+	}
+	if lastDownload.Valid {
+		stats.LastDownload = &lastDownload.Time
+	}
+	stats.Deleted = !isDir && deleted.Valid && deleted.Bool
+
+	response := map[string]interface{}{
+		"enabled": true,
+		"isDir":   isDir,
+		"stats":   stats,
+	}
+
+	if isDir {
+		files, err := h.queryTopDownloads(rel, since, 20, claims.Username)
+		if err != nil {
+			return RespondError(c, ErrInternal("Failed to load download statistics"))
+		}
+		response["files"] = files
+	}
+
+	return RespondSuccess(c, response)
+}
+
+// GetTopDownloads returns the most downloaded files (admin only)
+// @Summary		Most downloaded files
+// @Description	Report of the most downloaded files across the server or within a shared drive
+// @Tags		Admin
+// @Produce		json
+// @Param		period	query		string	false	"Period such as 7d, 30d, 24h or all (default 30d)"
+// @Param		folder	query		string	false	"Limit to a shared drive name"
+// @Param		limit	query		int		false	"Maximum number of files (default 50, max 500)"
+// @Success		200		{object}	docs.SuccessResponse	"Most downloaded files"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/downloads/top [get]
+func (h *Handler) GetTopDownloads(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermAuditRead); err != nil {
+		return err
+	}
+
+	period := c.QueryParam("period")
+	if period == "" {
+		period = "30d"
+	}
+	since, err := parseStatsPeriod(period)
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Invalid period"))
+	}
+
+	limit := 50
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	prefix := ""
+	if folder := c.QueryParam("folder"); folder != "" {
+		prefix = "shared/" + sanitizeFolderName(folder)
+	}
+
+	if err := GetDownloadStats().Flush(); err != nil {
+		log.Printf("[DownloadStats] %v", err)
+	}
+
+	files, err := h.queryTopDownloads(prefix, since, limit, "")
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load download statistics"))
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"period": period,
+		"files":  files,
+	})
+}
+
+// queryTopDownloads aggregates file_stats per path under prefix (all paths if empty)
+func (h *Handler) queryTopDownloads(prefix string, since time.Time, limit int, username string) ([]FileDownloadStats, error) {
+	rows, err := h.db.Query(`
+		SELECT path, SUM(downloads), COUNT(DISTINCT user_id), COUNT(DISTINCT ip_addr),
+		       MAX(last_download_at), BOOL_AND(is_deleted)
+		FROM file_stats
+		WHERE ($1 = '' OR starts_with(path, $1 || '/')) AND day >= $2
+		GROUP BY path
+		ORDER BY SUM(downloads) DESC, path
+		LIMIT $3
+	`, prefix, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []FileDownloadStats{}
+	for rows.Next() {
+		var f FileDownloadStats
+		var rel string
+		var lastDownload sql.NullTime
+		if err := rows.Scan(&rel, &f.Downloads, &f.UniqueUsers, &f.UniqueIPs, &lastDownload, &f.Deleted); err != nil {
+			continue
+		}
+		f.Path = statsDisplayPath(rel, username)
+		if lastDownload.Valid {
+			f.LastDownload = &lastDownload.Time
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newTestDownloadStats returns a tracker with statistics enabled, except on
+// the Private shared drive
+func newTestDownloadStats(t *testing.T, tc *TestContext, dataRoot string) *DownloadStatsTracker {
+	t.Helper()
+	useCachedSettings(t, map[string]string{
+		"download_stats_enabled":          "true",
+		"download_stats_disabled_folders": "Private",
+	})
+	return &DownloadStatsTracker{db: tc.DB, dataRoot: dataRoot, pending: make(map[downloadStatsKey]int)}
+}
+
+func TestDownloadStats_RecordBuffersCounts(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	tracker := newTestDownloadStats(t, tc, dataRoot)
+
+	report := filepath.Join(dataRoot, "users", "alice", "report.pdf")
+	tracker.RecordDownload(report, "u1", "203.0.113.7")
+	tracker.RecordDownload(report, "u1", "203.0.113.7")
+	tracker.RecordDownload(report, "", "198.51.100.1")
+	// Opted-out drives and paths outside the data root aren't counted
+	tracker.RecordDownload(filepath.Join(dataRoot, "shared", "Private", "a.txt"), "u1", "203.0.113.7")
+	tracker.RecordDownload("/etc/passwd", "u1", "203.0.113.7")
+
+	day := time.Now().Format("2006-01-02")
+	if n := tracker.pending[downloadStatsKey{Path: "users/alice/report.pdf", Day: day, UserID: "u1", IP: "203.0.113.7"}]; n != 2 {
+		t.Errorf("alice's downloads = %d, want 2", n)
+	}
+	if len(tracker.pending) != 2 {
+		t.Errorf("%d pending rows, want 2: %v", len(tracker.pending), tracker.pending)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDownloadStats_FlushRetriesAfterError(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	tracker := newTestDownloadStats(t, tc, dataRoot)
+	report := filepath.Join(dataRoot, "users", "alice", "report.pdf")
+	day := time.Now().Format("2006-01-02")
+
+	tracker.RecordDownload(report, "u1", "203.0.113.7")
+	tracker.RecordDownload(report, "u1", "203.0.113.7")
+
+	// A failed flush keeps the counts
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("INSERT INTO file_stats").
+		WithArgs("users/alice/report.pdf", day, "u1", "203.0.113.7", 2).
+		WillReturnError(errors.New("connection reset"))
+	tc.Mock.ExpectRollback()
+	if err := tracker.Flush(); err == nil {
+		t.Fatal("flush should fail")
+	}
+
+	// Downloads in between are added to them
+	tracker.RecordDownload(report, "u1", "203.0.113.7")
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec(`INSERT INTO file_stats \(path, day, user_id, ip_addr, downloads, last_download_at\)`).
+		WithArgs("users/alice/report.pdf", day, "u1", "203.0.113.7", 3).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	tc.Mock.ExpectCommit()
+	if err := tracker.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracker.pending) != 0 {
+		t.Errorf("pending after flush: %v", tracker.pending)
+	}
+
+	// Nothing buffered, nothing written
+	if err := tracker.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDownloadStats_MoveDeleteAndRestore(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	tracker := newTestDownloadStats(t, tc, dataRoot)
+	day := time.Now().Format("2006-01-02")
+	docs := filepath.Join(dataRoot, "users", "alice", "docs")
+	archive := filepath.Join(dataRoot, "users", "alice", "archive")
+
+	// Buffered downloads are flushed first so they move along
+	tracker.RecordDownload(filepath.Join(docs, "a.pdf"), "u1", "203.0.113.7")
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("INSERT INTO file_stats").
+		WithArgs("users/alice/docs/a.pdf", day, "u1", "203.0.113.7", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	tc.Mock.ExpectCommit()
+	tc.Mock.ExpectExec(`UPDATE file_stats\s+SET path = \$2 \|\| substr\(path, length\(\$1\) \+ 1\), updated_at = NOW\(\)\s+WHERE path = \$1 OR starts_with\(path, \$1 \|\| '/'\)`).
+		WithArgs("users/alice/docs", "users/alice/archive").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tracker.MovePath(docs, archive)
+
+	tc.Mock.ExpectExec(`UPDATE file_stats SET is_deleted = \$2, updated_at = NOW\(\)`).
+		WithArgs("users/alice/archive", true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tracker.MarkDeleted(archive)
+	tc.Mock.ExpectExec(`UPDATE file_stats SET is_deleted = \$2, updated_at = NOW\(\)`).
+		WithArgs("users/alice/archive", false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tracker.MarkRestored(archive)
+
+	// Paths outside the data root are left alone, and a nil tracker is a no-op
+	tracker.MovePath("/tmp/a", filepath.Join(dataRoot, "users", "alice", "a"))
+	var none *DownloadStatsTracker
+	none.MarkDeleted(archive)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetFileDownloadStats_LastDownload(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	writeTestFiles(t, dataRoot, "users/alice/report.pdf")
+	h := &Handler{db: tc.DB, dataRoot: dataRoot}
+	previous := globalDownloadStats
+	globalDownloadStats = newTestDownloadStats(t, tc, dataRoot)
+	t.Cleanup(func() { globalDownloadStats = previous })
+
+	// A rename after the last download doesn't change it
+	lastDownload := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	tc.Mock.ExpectQuery(`MAX\(last_download_at\), BOOL_AND\(is_deleted\)\s+FROM file_stats\s+WHERE path = \$1`).
+		WithArgs("users/alice/report.pdf", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"downloads", "users", "ips", "last", "deleted"}).
+			AddRow(4, 2, 3, lastDownload, false))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/stats/home/report.pdf", nil)
+	c := tc.Echo.NewContext(req, tc.Recorder)
+	c.Set("user", &JWTClaims{UserID: "u1", Username: "alice"})
+	c.SetParamNames("*")
+	c.SetParamValues("home/report.pdf")
+	if err := h.GetFileDownloadStats(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	body := tc.Recorder.Body.String()
+	if !strings.Contains(body, `"downloads":4`) || !strings.Contains(body, `"lastDownload":"`+lastDownload.Format(time.RFC3339)) {
+		t.Errorf("body = %s", body)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
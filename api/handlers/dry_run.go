@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxDestructivePaths bounds the paths listed in a destructive result
+const maxDestructivePaths = 500
+
+// DestructiveResult is the response of a destructive admin operation. A dry
+// run returns exactly the same numbers with Applied false.
+type DestructiveResult struct {
+	Applied   bool             `json:"applied"`
+	Paths     []string         `json:"paths"` // Files and folders removed, at most maxDestructivePaths
+	Truncated bool             `json:"truncated,omitempty"`
+	Files     int64            `json:"files"`
+	Bytes     int64            `json:"bytes"`
+	Rows      map[string]int64 `json:"rows"` // Rows deleted or changed per table
+	Details   interface{}      `json:"details,omitempty"`
+}
+
+// destructiveRun carries out the changes of a destructive operation, or on
+// a dry run works out the same changes without making them. Handlers route
+// every change through it and take the same path either way, so a preview
+// cannot diverge from the real run:
+//   - database changes run in a transaction that a dry run rolls back, so
+//     row counts come from the real statements;
+//   - filesystem changes are measured up front and carried out after the
+//     commit; a dry run skips them.
+type destructiveRun struct {
+	dryRun bool
+	tx     *sql.Tx
+	result *DestructiveResult
+	after  []func() error
+	done   bool
+}
+
+// beginDestructiveRun starts a destructive operation
+func beginDestructiveRun(db *sql.DB, dryRun bool) (*destructiveRun, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &destructiveRun{
+		dryRun: dryRun,
+		tx:     tx,
+		result: &DestructiveResult{Paths: []string{}, Rows: make(map[string]int64)},
+	}, nil
+}
+
+// dryRunParam reports whether the request asks for a dry run
+func dryRunParam(c echo.Context) bool {
+	return c.QueryParam("dryRun") == "true"
+}
+
+// Query runs a read in the operation's transaction, seeing its earlier changes
+func (r *destructiveRun) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return r.tx.Query(query, args...)
+}
+
+// QueryRow is Query for a single row
+func (r *destructiveRun) QueryRow(query string, args ...interface{}) *sql.Row {
+	return r.tx.QueryRow(query, args...)
+}
+
+// Exec runs a change and counts the rows it affected under table
+func (r *destructiveRun) Exec(table, query string, args ...interface{}) (int64, error) {
+	res, err := r.tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	r.result.Rows[table] += n
+	return n, nil
+}
+
+// Count counts rows removed by ON DELETE CASCADE, which RowsAffected does
+// not report; call it before the delete that cascades
+func (r *destructiveRun) Count(table, where string, args ...interface{}) error {
+	var n int64
+	if err := r.tx.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE `+where, args...).Scan(&n); err != nil {
+		return err
+	}
+	r.result.Rows[table] += n
+	return nil
+}
+
+// List adds a path to the result's path list
+func (r *destructiveRun) List(path string) {
+	if len(r.result.Paths) >= maxDestructivePaths {
+		r.result.Truncated = true
+		return
+	}
+	r.result.Paths = append(r.result.Paths, path)
+}
+
+// Remove measures a file or tree and removes it after the commit.
+// displayPath is listed unless empty.
+func (r *destructiveRun) Remove(realPath, displayPath string) {
+	files, bytes := measureTree(realPath)
+	r.result.Files += files
+	r.result.Bytes += bytes
+	if displayPath != "" {
+		r.List(displayPath)
+	}
+	r.After(func() error { return os.RemoveAll(realPath) })
+}
+
+// After queues a filesystem change to make after the commit
+func (r *destructiveRun) After(fn func() error) {
+	r.after = append(r.after, fn)
+}
+
+// Finish commits and applies the queued changes, or on a dry run rolls back
+// and skips them
+func (r *destructiveRun) Finish() (*DestructiveResult, error) {
+	r.done = true
+	if r.dryRun {
+		_ = r.tx.Rollback()
+		return r.result, nil
+	}
+	if err := r.tx.Commit(); err != nil {
+		return nil, err
+	}
+	r.result.Applied = true
+	var failed int
+	for _, fn := range r.after {
+		if err := fn(); err != nil {
+			log.Printf("[DryRun] Failed to apply change: %v", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return r.result, fmt.Errorf("%d filesystem changes failed", failed)
+	}
+	return r.result, nil
+}
+
+// Abort rolls back an unfinished operation; deferred after beginDestructiveRun
+func (r *destructiveRun) Abort() {
+	if !r.done {
+		_ = r.tx.Rollback()
+	}
+}
+
+// measureTree counts the files and bytes of a file or tree
+func measureTree(path string) (files, bytes int64) {
+	pace := GetBackgroundPacer().Begin("measure", PacePriorityUser)
+	defer pace.End()
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		pace.Pace()
+		if info, err := d.Info(); err == nil {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return files, bytes
+}
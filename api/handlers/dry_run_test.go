@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// emptyTrash empties alice's trash and returns the result
+func emptyTrash(t *testing.T, tc *TestContext, h *Handler, query string) DestructiveResult {
+	t.Helper()
+	req, _ := NewJSONRequest(http.MethodDelete, "/api/trash"+query, nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	if err := h.EmptyTrash(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	var resp struct {
+		Data DestructiveResult `json:"data"`
+	}
+	if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+func TestEmptyTrash_DryRun(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	trashDir := h.getTrashPath("alice")
+	_ = os.MkdirAll(filepath.Join(trashDir, "t2"), 0755)
+	_ = os.WriteFile(filepath.Join(trashDir, "t1"), make([]byte, 10), 0644)
+	_ = os.WriteFile(filepath.Join(trashDir, "t2", "a.txt"), make([]byte, 20), 0644)
+	_ = os.WriteFile(filepath.Join(trashDir, "t2", "b.txt"), make([]byte, 30), 0644)
+	now := time.Now()
+	_ = h.saveTrashMeta("alice", map[string]TrashItem{
+		"t1": {ID: "t1", OriginalPath: "/home/old.txt", DeletedAt: now.Add(-time.Hour)},
+		"t2": {ID: "t2", OriginalPath: "/home/docs", IsDir: true, DeletedAt: now},
+	})
+
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("UPDATE users SET trash_used = 0").WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectRollback()
+
+	preview := emptyTrash(t, tc, h, "?dryRun=true")
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if preview.Applied || preview.Files != 3 || preview.Bytes != 60 || preview.Rows["users"] != 1 {
+		t.Errorf("preview = %+v", preview)
+	}
+	if len(preview.Paths) != 2 || preview.Paths[0] != "/home/docs" || preview.Paths[1] != "/home/old.txt" {
+		t.Errorf("paths = %v", preview.Paths)
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, "t2", "b.txt")); err != nil {
+		t.Fatal("dry run removed files")
+	}
+
+	// The real run reports the same and removes everything
+	tc.Recorder.Body.Reset()
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("UPDATE users SET trash_used = 0").WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+
+	result := emptyTrash(t, tc, h, "")
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Applied || result.Files != preview.Files || result.Bytes != preview.Bytes {
+		t.Errorf("result = %+v, preview = %+v", result, preview)
+	}
+	if entries, err := os.ReadDir(trashDir); err != nil || len(entries) != 0 {
+		t.Errorf("trash after emptying = %v, %v", entries, err)
+	}
+}
+
+func TestDeleteSharedFolder_DryRun(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		admin := f.User(t, "admin", true)
+		team := f.SharedFolder(t, "Team", admin)
+		drive := filepath.Join(f.DataRoot, "shared", team.Name)
+		f.CreateTestFile(t, filepath.Join(drive, "plan.md"), make([]byte, 42))
+
+		wantMembers, wantSMB := 3, 1
+		f.Mock.ExpectQuery("SELECT name FROM shared_folders").WithArgs(team.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(team.Name))
+		f.Mock.ExpectBegin()
+		f.Mock.ExpectQuery("SELECT DISTINCT volume FROM shared_folder_snapshots").WithArgs(team.ID).WillReturnRows(sqlmock.NewRows([]string{"volume"}))
+		f.Mock.ExpectQuery("FROM shared_folder_members").WithArgs(team.ID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(wantMembers))
+		f.Mock.ExpectQuery("FROM shared_folder_groups").WithArgs(team.ID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		f.Mock.ExpectQuery("FROM shared_folder_smb").WithArgs(team.ID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(wantSMB))
+		f.Mock.ExpectQuery("FROM mount_ins").WithArgs(team.ID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		f.Mock.ExpectQuery("FROM shared_folder_snapshots").WithArgs(team.ID).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		f.Mock.ExpectExec("DELETE FROM shared_folders").WithArgs(team.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+		f.Mock.ExpectRollback()
+
+		req, _ := NewJSONRequest(http.MethodDelete, "/api/shared-folders/"+team.ID+"?dryRun=true", nil)
+		c := f.Context(req, admin)
+		c.SetParamNames("id")
+		c.SetParamValues(team.ID)
+		if err := f.SharedFolders.DeleteSharedFolder(c); err != nil {
+			t.Fatal(err)
+		}
+		AssertStatus(t, f.Recorder, http.StatusOK)
+		f.AssertExpectations(t)
+
+		var resp struct {
+			Data DestructiveResult `json:"data"`
+		}
+		_ = json.Unmarshal(f.Recorder.Body.Bytes(), &resp)
+		result := resp.Data
+		if result.Applied || result.Files != 1 || result.Bytes != 42 {
+			t.Errorf("result = %+v", result)
+		}
+		if result.Rows["shared_folders"] != 1 || result.Rows["shared_folder_members"] != int64(wantMembers) || result.Rows["shared_folder_smb"] != int64(wantSMB) {
+			t.Errorf("rows = %v", result.Rows)
+		}
+		if len(result.Paths) != 1 || result.Paths[0] != "/shared/"+team.Name {
+			t.Errorf("paths = %v", result.Paths)
+		}
+		if _, err := os.Stat(filepath.Join(drive, "plan.md")); err != nil {
+			t.Fatal("dry run removed the drive")
+		}
+	})
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Edit conflict modes (edit_conflict_mode setting)
+const (
+	EditConflictCopy   = "copy"   // Save the incoming content as a conflicted copy
+	EditConflictReject = "reject" // Fail the save with 409
+)
+
+// Writer hint sources
+const (
+	WriterSourceAPI      = "api"
+	WriterSourceSMB      = "smb"
+	WriterSourceExternal = "external" // Seen by the file watcher; writer not known yet
+)
+
+const (
+	// writerEchoWindow covers watcher events caused by a write that was
+	// already attributed (API writes, SMB audit entries)
+	writerEchoWindow = 10 * time.Second
+	// writerHintTTL is how long a hint is kept after the last write
+	writerHintTTL = 24 * time.Hour
+	// maxWriterHints bounds memory use on busy servers
+	maxWriterHints = 20000
+)
+
+// WriterHint records who last wrote a file, as far as the server can tell
+type WriterHint struct {
+	UserID   string    `json:"userId,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Source   string    `json:"source"`
+	At       time.Time `json:"at"`
+}
+
+// WriterHints tracks the last writer per real path, and the file version
+// OnlyOffice editing sessions are based on after a save
+type WriterHints struct {
+	mu       sync.Mutex
+	hints    map[string]WriterHint
+	sessions map[string]time.Time // OnlyOffice document key -> mtime written by the last save
+}
+
+var writerHints = &WriterHints{
+	hints:    make(map[string]WriterHint),
+	sessions: make(map[string]time.Time),
+}
+
+// GetWriterHints returns the global last-writer registry
+func GetWriterHints() *WriterHints {
+	return writerHints
+}
+
+// note stores a hint, pruning expired entries when the map grows too large
+func (w *WriterHints) note(realPath string, hint WriterHint) {
+	w.hints[realPath] = hint
+	if len(w.hints) <= maxWriterHints {
+		return
+	}
+	cutoff := time.Now().Add(-writerHintTTL)
+	for p, h := range w.hints {
+		if h.At.Before(cutoff) {
+			delete(w.hints, p)
+		}
+	}
+	for key, at := range w.sessions {
+		if at.Before(cutoff) {
+			delete(w.sessions, key)
+		}
+	}
+}
+
+// NoteAPI records a write made through the API
+func (w *WriterHints) NoteAPI(realPath string, claims *JWTClaims) {
+	hint := WriterHint{Source: WriterSourceAPI, At: time.Now()}
+	if claims != nil {
+		hint.UserID = claims.UserID
+		hint.Username = claims.Username
+	}
+	w.mu.Lock()
+	w.note(realPath, hint)
+	w.mu.Unlock()
+}
+
+// NoteExternal records a write seen by the file watcher. Events echoing a
+// write that is already attributed are ignored.
+func (w *WriterHints) NoteExternal(realPath string, at time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if prev, ok := w.hints[realPath]; ok && prev.Source != WriterSourceExternal && at.Sub(prev.At) < writerEchoWindow {
+		return
+	}
+	w.note(realPath, WriterHint{Source: WriterSourceExternal, At: at})
+}
+
+// NoteSMB attributes a write to an SMB user from the Samba audit log, which
+// is read some time after the watcher saw the change
+func (w *WriterHints) NoteSMB(realPath, userID, username string, at time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if prev, ok := w.hints[realPath]; ok && prev.At.After(at.Add(writerEchoWindow)) {
+		return // A later write is already recorded
+	}
+	w.note(realPath, WriterHint{UserID: userID, Username: username, Source: WriterSourceSMB, At: at})
+}
+
+// Last returns the most recent writer hint for a path
+func (w *WriterHints) Last(realPath string) (WriterHint, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hint, ok := w.hints[realPath]
+	return hint, ok
+}
+
+// sessionBase returns the file mtime an OnlyOffice session is based on:
+// the mtime of its last save, or the one encoded in the document key
+func (w *WriterHints) sessionBase(documentKey string) (time.Time, bool) {
+	w.mu.Lock()
+	saved, ok := w.sessions[documentKey]
+	w.mu.Unlock()
+	if ok {
+		return saved, true
+	}
+	idx := strings.LastIndex(documentKey, "_")
+	if idx == -1 {
+		return time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(documentKey[idx+1:], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(unix, 0), true
+}
+
+// noteSessionSave records the mtime an OnlyOffice save produced, so a later
+// save of the same session is not mistaken for a conflict
+func (w *WriterHints) noteSessionSave(documentKey string, modTime time.Time) {
+	w.mu.Lock()
+	w.sessions[documentKey] = modTime
+	w.mu.Unlock()
+}
+
+// GetEditConflictMode returns how saves over a changed file are handled
+func (h *SettingsHandler) GetEditConflictMode() string {
+	mode, _ := h.GetSetting("edit_conflict_mode")
+	if mode == EditConflictReject {
+		return EditConflictReject
+	}
+	return EditConflictCopy
+}
+
+// editConflictMode reads the setting, defaulting to conflicted copies
+func editConflictMode() string {
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		return sh.GetEditConflictMode()
+	}
+	return EditConflictCopy
+}
+
+// changedSinceRequest reports whether the file changed since the client read
+// it, based on If-Match or If-Unmodified-Since. Without either header the
+// editing session is unknown and the save is not treated as a conflict.
+func changedSinceRequest(r *http.Request, realPath string, info os.FileInfo) bool {
+	if r.Header.Get("If-Match") != "" {
+		return !CheckIfMatch(r, GenerateETag(realPath, info.ModTime(), info.Size()))
+	}
+	if since := r.Header.Get("If-Unmodified-Since"); since != "" {
+		t, err := http.ParseTime(since)
+		if err != nil {
+			return false
+		}
+		return info.ModTime().Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// conflictedCopyName builds "name (conflicted copy of user 2006-01-02 150405).ext"
+// next to the original, adding a counter if that name is taken
+func conflictedCopyName(realPath, username string, at time.Time) string {
+	if username == "" {
+		username = "unknown"
+	}
+	dir := filepath.Dir(realPath)
+	ext := filepath.Ext(realPath)
+	base := strings.TrimSuffix(filepath.Base(realPath), ext)
+	label := fmt.Sprintf("conflicted copy of %s %s", username, at.Format("2006-01-02 150405"))
+
+	candidate := filepath.Join(dir, fmt.Sprintf("%s (%s)%s", base, label, ext))
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = filepath.Join(dir, fmt.Sprintf("%s (%s %d)%s", base, label, i, ext))
+	}
+}
+
+// EditConflict describes a save that arrived after the file changed underneath it
+type EditConflict struct {
+	Path         string      `json:"path"`
+	Mode         string      `json:"mode"`
+	ConflictCopy string      `json:"conflictCopy,omitempty"` // Virtual path of the saved copy
+	LastWriter   *WriterHint `json:"lastWriter,omitempty"`
+	ModifiedAt   time.Time   `json:"modifiedAt"`
+}
+
+// handleEditConflict resolves a save whose base version is outdated. In copy
+// mode the content is written as a conflicted copy; in reject mode nothing is
+// written and a 409 error is returned. Both writers are notified and the
+// conflict is audit-logged either way.
+func (h *Handler) handleEditConflict(c echo.Context, claims *JWTClaims, realPath, virtualPath string, info os.FileInfo, content []byte, source string) (*EditConflict, *APIError) {
+	now := time.Now()
+	conflict := &EditConflict{
+		Path:       virtualPath,
+		Mode:       editConflictMode(),
+		ModifiedAt: info.ModTime(),
+	}
+	if hint, ok := GetWriterHints().Last(realPath); ok {
+		conflict.LastWriter = &hint
+	}
+
+	username := ""
+	if claims != nil {
+		username = claims.Username
+	}
+
+	if conflict.Mode == EditConflictCopy {
+		copyPath := conflictedCopyName(realPath, username, now)
+		if err := WriteFileSealed(copyPath, content, 0644); err != nil {
+			return nil, encryptionAPIError("save conflicted copy", err)
+		}
+		GetChangeJournal().Record(ChangeCreate, copyPath, "", changeActor(claims))
+		GetListingVersions().Invalidate(copyPath)
+		GetWriterHints().NoteAPI(copyPath, claims)
+		conflict.ConflictCopy = path.Join(path.Dir(virtualPath), filepath.Base(copyPath))
+	}
+
+	var userID *string
+	if claims != nil {
+		userID = &claims.UserID
+	}
+	details := map[string]interface{}{
+		"mode":       conflict.Mode,
+		"source":     source,
+		"size":       len(content),
+		"modifiedAt": info.ModTime(),
+	}
+	if conflict.ConflictCopy != "" {
+		details["conflictCopy"] = conflict.ConflictCopy
+	}
+	if conflict.LastWriter != nil {
+		details["lastWriter"] = conflict.LastWriter.Username
+		details["lastWriterSource"] = conflict.LastWriter.Source
+	}
+	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileEditConflict, virtualPath, details)
+
+	h.notifyEditConflict(claims, conflict)
+
+	if conflict.Mode == EditConflictReject {
+		return conflict, NewAPIError(ErrCodeConflict, "File was changed by someone else since it was opened").WithDetails(conflict)
+	}
+	return conflict, nil
+}
+
+// notifyEditConflict tells the writer whose save conflicted and the last
+// known writer of the file
+func (h *Handler) notifyEditConflict(claims *JWTClaims, conflict *EditConflict) {
+	name := path.Base(conflict.Path)
+	var message string
+	if conflict.ConflictCopy != "" {
+		message = "'" + name + "' 파일이 편집 중에 변경되어 '" + path.Base(conflict.ConflictCopy) + "'(으)로 저장되었습니다"
+	} else {
+		message = "'" + name + "' 파일이 편집 중에 변경되어 저장이 거부되었습니다"
+	}
+	metadata := map[string]interface{}{
+		"path":         conflict.Path,
+		"mode":         conflict.Mode,
+		"conflictCopy": conflict.ConflictCopy,
+	}
+	link := path.Dir(conflict.Path)
+
+	var actorID *string
+	recipients := make([]string, 0, 2)
+	if claims != nil {
+		actorID = &claims.UserID
+		recipients = append(recipients, claims.UserID)
+	}
+	if conflict.LastWriter != nil && conflict.LastWriter.UserID != "" && (claims == nil || conflict.LastWriter.UserID != claims.UserID) {
+		recipients = append(recipients, conflict.LastWriter.UserID)
+	}
+	if len(recipients) == 0 {
+		return
+	}
+	NewNotificationService(h.db).SendBulk(recipients, NotifFileEditConflict, "편집 충돌이 감지되었습니다", message, link, actorID, metadata)
+}
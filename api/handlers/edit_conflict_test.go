@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConflictedCopyName(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "report.docx")
+	at := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+
+	first := conflictedCopyName(original, "alice", at)
+	if filepath.Base(first) != "report (conflicted copy of alice 2024-03-05 140709).docx" {
+		t.Errorf("unexpected name %q", filepath.Base(first))
+	}
+	if err := os.WriteFile(first, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := conflictedCopyName(original, "alice", at)
+	if second == first || !strings.HasSuffix(second, " 2).docx") {
+		t.Errorf("expected a numbered name when taken, got %q", filepath.Base(second))
+	}
+}
+
+func TestChangedSinceRequest(t *testing.T) {
+	realPath := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(realPath, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(realPath)
+	etag := GenerateETag(realPath, info.ModTime(), info.Size())
+
+	req := httptest.NewRequest(http.MethodPut, "/", nil)
+	if changedSinceRequest(req, realPath, info) {
+		t.Error("no precondition headers must not be a conflict")
+	}
+	req.Header.Set("If-Match", etag)
+	if changedSinceRequest(req, realPath, info) {
+		t.Error("matching ETag reported as changed")
+	}
+
+	later := info.ModTime().Add(5 * time.Second)
+	if err := os.Chtimes(realPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	info, _ = os.Stat(realPath)
+	if !changedSinceRequest(req, realPath, info) {
+		t.Error("stale ETag not reported as changed")
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/", nil)
+	req.Header.Set("If-Unmodified-Since", later.Add(-5*time.Second).UTC().Format(http.TimeFormat))
+	if !changedSinceRequest(req, realPath, info) {
+		t.Error("If-Unmodified-Since before mtime not reported as changed")
+	}
+}
+
+func TestWriterHints(t *testing.T) {
+	w := &WriterHints{hints: make(map[string]WriterHint), sessions: make(map[string]time.Time)}
+	p := "/data/shared/Team/plan.xlsx"
+
+	// The watcher echo of an API write keeps the API attribution
+	w.NoteAPI(p, &JWTClaims{UserID: "u1", Username: "alice"})
+	w.NoteExternal(p, time.Now())
+	if hint, _ := w.Last(p); hint.Source != WriterSourceAPI || hint.Username != "alice" {
+		t.Errorf("API hint overwritten by watcher echo: %+v", hint)
+	}
+
+	// A later external write is attributed once the SMB audit log is read
+	at := time.Now().Add(time.Minute)
+	w.NoteExternal(p, at)
+	w.NoteSMB(p, "u2", "bob", at.Add(-time.Second))
+	if hint, _ := w.Last(p); hint.Source != WriterSourceSMB || hint.Username != "bob" {
+		t.Errorf("expected SMB attribution, got %+v", hint)
+	}
+
+	// OnlyOffice sessions start from the key's mtime, then from their last save
+	key := generateDocumentKey("/shared/Team/plan.xlsx", 1700000000)
+	if base, ok := w.sessionBase(key); !ok || base.Unix() != 1700000000 {
+		t.Errorf("sessionBase = %v, %v", base, ok)
+	}
+	saved := time.Unix(1700000100, 0)
+	w.noteSessionSave(key, saved)
+	if base, _ := w.sessionBase(key); !base.Equal(saved) {
+		t.Errorf("sessionBase after save = %v", base)
+	}
+}
@@ -0,0 +1,790 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Email, calendar and contact previews. GetPreview parses .eml messages into
+// their headers, body and attachment list instead of offering them as opaque
+// downloads, and summarizes .ics and .vcf files as events and contacts.
+// Parsing is defensive: only the first maxEmailPreviewBytes of a message are
+// read, MIME nesting and part counts are capped, and a malformed part ends
+// the walk rather than the preview. HTML bodies keep an allowlist of
+// formatting tags, without scripts, styles, event handlers or remote images.
+// Attachments are never inlined; GET /api/email/attachment/* extracts one on
+// the fly and serves it as a download.
+
+// Structured preview types returned by GetPreview
+const (
+	PreviewTypeEmail    = "email"
+	PreviewTypeCalendar = "calendar"
+	PreviewTypeContact  = "contact"
+)
+
+const (
+	maxEmailPreviewBytes    = 50 << 20 // read limit for a message
+	maxEmailAttachmentBytes = 25 << 20 // largest attachment served
+	maxEmailBodyBytes       = 512 << 10
+	maxMIMEDepth            = 10
+	maxMIMEParts            = 200
+	maxCardFileBytes        = 5 << 20 // read limit for .ics and .vcf
+	maxCardEntries          = 500
+)
+
+// structuredPreviewType returns the structured preview type for an extension,
+// or "" if the file is previewed some other way
+func structuredPreviewType(ext string) string {
+	switch ext {
+	case "eml":
+		return PreviewTypeEmail
+	case "ics":
+		return PreviewTypeCalendar
+	case "vcf":
+		return PreviewTypeContact
+	}
+	return ""
+}
+
+// EmailAddress is a parsed address header entry
+type EmailAddress struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+// EmailAttachment describes an attachment of a previewed message
+type EmailAttachment struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url,omitempty"` // omitted when larger than the download cap
+}
+
+// EmailPreview is the structured view of an .eml file
+type EmailPreview struct {
+	From        []EmailAddress    `json:"from"`
+	To          []EmailAddress    `json:"to"`
+	Cc          []EmailAddress    `json:"cc"`
+	Subject     string            `json:"subject"`
+	Date        *time.Time        `json:"date,omitempty"`
+	Text        string            `json:"text"`
+	HTML        string            `json:"html"` // sanitized
+	Attachments []EmailAttachment `json:"attachments"`
+	Truncated   bool              `json:"truncated"`
+}
+
+// emailAttachmentPart is an attachment found while walking a message. Data
+// is only kept for the attachment being extracted.
+type emailAttachmentPart struct {
+	name        string
+	contentType string
+	size        int64
+	data        []byte
+}
+
+// parsedEmail collects the parts of a message
+type parsedEmail struct {
+	header      mail.Header
+	text        string
+	html        string
+	attachments []emailAttachmentPart
+	parts       int
+	keep        int // attachment index whose data is kept, -1 for none
+	truncated   bool
+}
+
+// parseEmail reads a message, keeping the data of attachment keep (-1 for
+// none). Only an unreadable top-level header is an error; broken parts are
+// skipped and reported as truncation.
+func parseEmail(r io.Reader, keep int) (*parsedEmail, error) {
+	limited := &io.LimitedReader{R: r, N: maxEmailPreviewBytes}
+	msg, err := mail.ReadMessage(bufio.NewReader(limited))
+	if err != nil {
+		return nil, err
+	}
+	pe := &parsedEmail{header: msg.Header, keep: keep}
+	pe.walk(textproto.MIMEHeader(msg.Header), msg.Body, 0)
+	if limited.N <= 0 {
+		pe.truncated = true
+	}
+	return pe, nil
+}
+
+func (pe *parsedEmail) walk(header textproto.MIMEHeader, body io.Reader, depth int) {
+	if depth > maxMIMEDepth || pe.parts >= maxMIMEParts {
+		pe.truncated = true
+		return
+	}
+	pe.parts++
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045 default for a missing or unreadable Content-Type
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if params["boundary"] == "" {
+			pe.truncated = true
+			return
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				pe.truncated = true
+				return
+			}
+			pe.walk(part.Header, part, depth+1)
+		}
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	name := decodeMIMEWord(dispParams["filename"])
+	if name == "" {
+		name = decodeMIMEWord(params["name"])
+	}
+	isBody := (mediaType == "text/plain" || mediaType == "text/html") && disposition != "attachment" && name == ""
+
+	decoded := transferDecoder(header.Get("Content-Transfer-Encoding"), body)
+	if !isBody {
+		pe.addAttachment(mediaType, name, decoded)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(decoded, maxEmailBodyBytes*4))
+	if err != nil {
+		pe.truncated = true
+		if len(data) == 0 {
+			return
+		}
+	}
+	content := decodeCharset(data, params["charset"])
+	if mediaType == "text/plain" && pe.text == "" {
+		pe.text = content
+	} else if mediaType == "text/html" && pe.html == "" {
+		pe.html = sanitizeEmailHTML(content)
+	}
+}
+
+func (pe *parsedEmail) addAttachment(mediaType, name string, body io.Reader) {
+	index := len(pe.attachments)
+	attachment := emailAttachmentPart{name: name, contentType: mediaType}
+	var err error
+	if index == pe.keep {
+		var buf bytes.Buffer
+		attachment.size, err = io.Copy(&buf, io.LimitReader(body, maxEmailAttachmentBytes+1))
+		attachment.data = buf.Bytes()
+	} else {
+		attachment.size, err = io.Copy(io.Discard, body)
+	}
+	if err != nil {
+		// Cut off by the read limit or undecodable: never offer partial data
+		pe.truncated = true
+		return
+	}
+	if attachment.name == "" {
+		attachment.name = "attachment-" + strconv.Itoa(index+1)
+		if mediaType == "message/rfc822" {
+			attachment.name += ".eml"
+		}
+	}
+	attachment.name = cleanAttachmentName(attachment.name)
+	pe.attachments = append(pe.attachments, attachment)
+}
+
+// transferDecoder undoes a Content-Transfer-Encoding; unknown encodings are
+// passed through
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Filter{r: r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// base64Filter drops characters outside the base64 alphabet, which mailers
+// sometimes leave around line breaks
+type base64Filter struct {
+	r io.Reader
+}
+
+func (f *base64Filter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		kept := 0
+		for _, b := range p[:n] {
+			if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '+' || b == '/' || b == '=' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// decodeCharset converts text in a declared charset to UTF-8; unknown
+// charsets and invalid sequences are kept as valid UTF-8 best effort
+func decodeCharset(data []byte, charset string) string {
+	if charset != "" && !strings.EqualFold(charset, "utf-8") && !strings.EqualFold(charset, "us-ascii") {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := enc.NewDecoder().Bytes(data); err == nil {
+				data = decoded
+			}
+		}
+	}
+	return strings.ToValidUTF8(string(data), "�")
+}
+
+var mimeWordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// decodeMIMEWord decodes RFC 2047 encoded words, keeping the raw value if
+// they can't be decoded
+func decodeMIMEWord(s string) string {
+	if decoded, err := mimeWordDecoder.DecodeHeader(s); err == nil {
+		s = decoded
+	}
+	return strings.ToValidUTF8(s, "�")
+}
+
+// cleanAttachmentName reduces an attachment name to a plain file name
+func cleanAttachmentName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "attachment"
+	}
+	return name
+}
+
+// parseAddressList decodes an address header; entries that don't parse as a
+// list are returned as a single raw address
+func parseAddressList(header mail.Header, key string) []EmailAddress {
+	raw := header.Get(key)
+	if raw == "" {
+		return []EmailAddress{}
+	}
+	parser := mail.AddressParser{WordDecoder: mimeWordDecoder}
+	list, err := parser.ParseList(raw)
+	if err != nil {
+		return []EmailAddress{{Address: decodeMIMEWord(raw)}}
+	}
+	addresses := make([]EmailAddress, 0, len(list))
+	for _, a := range list {
+		addresses = append(addresses, EmailAddress{Name: a.Name, Address: a.Address})
+	}
+	return addresses
+}
+
+// truncateUTF8 cuts s to at most max bytes on a rune boundary
+func truncateUTF8(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max], true
+}
+
+// buildEmailPreview turns a parsed message into its JSON view. Attachment
+// URLs point at attachmentBase with the attachment's index.
+func buildEmailPreview(pe *parsedEmail, attachmentBase string) *EmailPreview {
+	preview := &EmailPreview{
+		From:        parseAddressList(pe.header, "From"),
+		To:          parseAddressList(pe.header, "To"),
+		Cc:          parseAddressList(pe.header, "Cc"),
+		Subject:     decodeMIMEWord(pe.header.Get("Subject")),
+		Attachments: make([]EmailAttachment, 0, len(pe.attachments)),
+		Truncated:   pe.truncated,
+	}
+	if date, err := pe.header.Date(); err == nil {
+		preview.Date = &date
+	}
+	var cut bool
+	preview.Text, cut = truncateUTF8(pe.text, maxEmailBodyBytes)
+	preview.Truncated = preview.Truncated || cut
+	preview.HTML, cut = truncateUTF8(pe.html, maxEmailBodyBytes)
+	if cut {
+		// A cut tag would leave markup behind; drop to the text body
+		preview.HTML = ""
+		preview.Truncated = true
+	}
+	for i, a := range pe.attachments {
+		attachment := EmailAttachment{Index: i, Name: a.name, ContentType: a.contentType, Size: a.size}
+		if a.size <= maxEmailAttachmentBytes {
+			attachment.URL = attachmentBase + "?index=" + strconv.Itoa(i)
+		}
+		preview.Attachments = append(preview.Attachments, attachment)
+	}
+	return preview
+}
+
+// Tags kept in sanitized HTML bodies
+var emailHTMLAllowedTags = map[string]bool{
+	"a": true, "b": true, "i": true, "u": true, "s": true, "em": true, "strong": true,
+	"p": true, "br": true, "div": true, "span": true, "hr": true, "pre": true, "code": true,
+	"blockquote": true, "ul": true, "ol": true, "li": true, "sub": true, "sup": true, "small": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"table": true, "thead": true, "tbody": true, "tfoot": true, "tr": true, "td": true, "th": true,
+}
+
+// Tags dropped together with everything inside them
+var emailHTMLDroppedTags = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true, "iframe": true,
+	"object": true, "embed": true, "applet": true, "noscript": true, "template": true,
+	"svg": true, "math": true, "form": true, "textarea": true, "select": true,
+}
+
+var emailHTMLVoidTags = map[string]bool{"br": true, "hr": true}
+
+// sanitizeEmailHTML keeps allowlisted tags with no attributes other than
+// http(s)/mailto links and table spans. Images are replaced by their alt
+// text so opening a message never contacts a remote server.
+func sanitizeEmailHTML(s string) string {
+	var out strings.Builder
+	var open []string
+	dropDepth := 0
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+		name := token.Data
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if emailHTMLDroppedTags[name] {
+				if tt == html.StartTagToken {
+					dropDepth++
+				}
+				continue
+			}
+			if dropDepth > 0 {
+				continue
+			}
+			if name == "img" {
+				for _, attr := range token.Attr {
+					if attr.Key == "alt" && attr.Val != "" {
+						out.WriteString(html.EscapeString("[" + attr.Val + "]"))
+					}
+				}
+				continue
+			}
+			if !emailHTMLAllowedTags[name] {
+				continue
+			}
+			out.WriteString("<" + name)
+			for _, attr := range token.Attr {
+				switch {
+				case name == "a" && attr.Key == "href" && safeEmailLink(attr.Val):
+					out.WriteString(` href="` + html.EscapeString(attr.Val) + `" target="_blank" rel="noopener noreferrer"`)
+				case (name == "td" || name == "th") && (attr.Key == "colspan" || attr.Key == "rowspan"):
+					if n, err := strconv.Atoi(attr.Val); err == nil && n > 0 && n < 1000 {
+						out.WriteString(" " + attr.Key + `="` + strconv.Itoa(n) + `"`)
+					}
+				}
+			}
+			out.WriteString(">")
+			if !emailHTMLVoidTags[name] && tt == html.StartTagToken {
+				open = append(open, name)
+			}
+		case html.EndTagToken:
+			if emailHTMLDroppedTags[name] {
+				if dropDepth > 0 {
+					dropDepth--
+				}
+				continue
+			}
+			if dropDepth > 0 {
+				continue
+			}
+			// Close only tags opened here, so stray end tags can't escape
+			// the container the body is rendered in
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == name {
+					for j := len(open) - 1; j >= i; j-- {
+						out.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		case html.TextToken:
+			if dropDepth == 0 {
+				out.WriteString(html.EscapeString(token.Data))
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String()
+}
+
+// safeEmailLink allows only web and mail links
+func safeEmailLink(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// cardProperty is one content line of an iCalendar or vCard file
+type cardProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// parseCardLines unfolds and splits iCalendar/vCard content lines (RFC 5545
+// and RFC 6350). Lines without a colon are skipped.
+func parseCardLines(r io.Reader) []cardProperty {
+	scanner := bufio.NewScanner(io.LimitReader(r, maxCardFileBytes))
+	scanner.Buffer(make([]byte, 64*1024), maxCardFileBytes)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	props := make([]cardProperty, 0, len(lines))
+	for _, line := range lines {
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			continue
+		}
+		head := strings.Split(line[:colon], ";")
+		prop := cardProperty{
+			name:   strings.ToUpper(strings.TrimSpace(head[0])),
+			params: make(map[string]string),
+			value:  strings.ToValidUTF8(line[colon+1:], "�"),
+		}
+		// Drop a vCard group prefix such as "item1.EMAIL"
+		if dot := strings.LastIndexByte(prop.name, '.'); dot >= 0 {
+			prop.name = prop.name[dot+1:]
+		}
+		for _, p := range head[1:] {
+			if key, value, ok := strings.Cut(p, "="); ok {
+				prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+			}
+		}
+		props = append(props, prop)
+	}
+	return props
+}
+
+// unescapeCardText undoes TEXT value escaping
+func unescapeCardText(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				out.WriteByte('\n')
+			default:
+				out.WriteByte(s[i])
+			}
+			continue
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+// CalendarEvent summarizes a VEVENT
+type CalendarEvent struct {
+	Summary     string `json:"summary"`
+	Start       string `json:"start,omitempty"` // RFC 3339, or a date for all-day events
+	End         string `json:"end,omitempty"`
+	AllDay      bool   `json:"allDay"`
+	Timezone    string `json:"timezone,omitempty"` // TZID of a local start time
+	Location    string `json:"location,omitempty"`
+	Description string `json:"description,omitempty"`
+	Organizer   string `json:"organizer,omitempty"`
+}
+
+// formatCalendarTime converts a DATE or DATE-TIME value. Values in an
+// unknown format are returned as written.
+func formatCalendarTime(prop cardProperty) (value string, allDay bool) {
+	v := strings.TrimSpace(prop.value)
+	if t, err := time.Parse("20060102T150405Z", v); err == nil {
+		return t.Format(time.RFC3339), false
+	}
+	if t, err := time.Parse("20060102T150405", v); err == nil {
+		return t.Format("2006-01-02T15:04:05"), false
+	}
+	if t, err := time.Parse("20060102", v); err == nil {
+		return t.Format("2006-01-02"), true
+	}
+	return v, false
+}
+
+// parseCalendar returns the events of an iCalendar file
+func parseCalendar(r io.Reader) ([]CalendarEvent, bool) {
+	events := []CalendarEvent{}
+	var current *CalendarEvent
+	depth := 0 // nesting below VEVENT, e.g. VALARM
+	for _, prop := range parseCardLines(r) {
+		value := strings.ToUpper(strings.TrimSpace(prop.value))
+		switch {
+		case prop.name == "BEGIN" && value == "VEVENT" && current == nil:
+			if len(events) >= maxCardEntries {
+				return events, true
+			}
+			current = &CalendarEvent{}
+			continue
+		case prop.name == "BEGIN" && current != nil:
+			depth++
+			continue
+		case prop.name == "END" && current != nil && depth > 0:
+			depth--
+			continue
+		case prop.name == "END" && value == "VEVENT" && current != nil:
+			events = append(events, *current)
+			current = nil
+			continue
+		}
+		if current == nil || depth > 0 {
+			continue
+		}
+		switch prop.name {
+		case "SUMMARY":
+			current.Summary = unescapeCardText(prop.value)
+		case "LOCATION":
+			current.Location = unescapeCardText(prop.value)
+		case "DESCRIPTION":
+			current.Description, _ = truncateUTF8(unescapeCardText(prop.value), 4096)
+		case "ORGANIZER":
+			current.Organizer = prop.params["CN"]
+			if current.Organizer == "" {
+				current.Organizer = strings.TrimPrefix(strings.TrimPrefix(prop.value, "mailto:"), "MAILTO:")
+			}
+		case "DTSTART":
+			current.Start, current.AllDay = formatCalendarTime(prop)
+			current.Timezone = prop.params["TZID"]
+		case "DTEND":
+			current.End, _ = formatCalendarTime(prop)
+		}
+	}
+	return events, false
+}
+
+// ContactCard summarizes a VCARD
+type ContactCard struct {
+	Name         string   `json:"name"`
+	Organization string   `json:"organization,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Emails       []string `json:"emails"`
+	Phones       []string `json:"phones"`
+}
+
+// parseContacts returns the cards of a vCard file
+func parseContacts(r io.Reader) ([]ContactCard, bool) {
+	contacts := []ContactCard{}
+	var current *ContactCard
+	for _, prop := range parseCardLines(r) {
+		value := strings.ToUpper(strings.TrimSpace(prop.value))
+		switch {
+		case prop.name == "BEGIN" && value == "VCARD":
+			if len(contacts) >= maxCardEntries {
+				return contacts, true
+			}
+			current = &ContactCard{Emails: []string{}, Phones: []string{}}
+			continue
+		case prop.name == "END" && value == "VCARD" && current != nil:
+			contacts = append(contacts, *current)
+			current = nil
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch prop.name {
+		case "FN":
+			current.Name = unescapeCardText(prop.value)
+		case "N":
+			if current.Name == "" {
+				// Family;Given;Additional;Prefix;Suffix
+				parts := strings.Split(prop.value, ";")
+				if len(parts) > 1 {
+					current.Name = strings.TrimSpace(unescapeCardText(parts[1]) + " " + unescapeCardText(parts[0]))
+				} else {
+					current.Name = unescapeCardText(parts[0])
+				}
+			}
+		case "ORG":
+			current.Organization = strings.Trim(strings.ReplaceAll(unescapeCardText(prop.value), ";", ", "), ", ")
+		case "TITLE":
+			current.Title = unescapeCardText(prop.value)
+		case "EMAIL":
+			if len(current.Emails) < 20 {
+				current.Emails = append(current.Emails, unescapeCardText(prop.value))
+			}
+		case "TEL":
+			if len(current.Phones) < 20 {
+				current.Phones = append(current.Phones, strings.TrimPrefix(unescapeCardText(prop.value), "tel:"))
+			}
+		}
+	}
+	return contacts, false
+}
+
+// structuredPreview builds the GetPreview response for an email, calendar
+// or contact file
+func structuredPreview(previewType, realPath, displayPath string, info os.FileInfo) (map[string]interface{}, *APIError) {
+	file, err := OpenPlain(realPath)
+	if err != nil {
+		return nil, ErrOperationFailed("open file", err)
+	}
+	defer file.Close()
+
+	response := map[string]interface{}{
+		"type":     previewType,
+		"mimeType": getMimeType(strings.ToLower(strings.TrimPrefix(filepath.Ext(realPath), "."))),
+		"size":     info.Size(),
+	}
+	switch previewType {
+	case PreviewTypeEmail:
+		pe, err := parseEmail(file, -1)
+		if err != nil {
+			return nil, ErrBadRequest("Not a readable email message")
+		}
+		response["email"] = buildEmailPreview(pe, "/api/email/attachment/"+strings.TrimPrefix(displayPath, "/"))
+	case PreviewTypeCalendar:
+		events, truncated := parseCalendar(file)
+		response["events"] = events
+		response["truncated"] = truncated
+	case PreviewTypeContact:
+		contacts, truncated := parseContacts(file)
+		response["contacts"] = contacts
+		response["truncated"] = truncated
+	}
+	return response, nil
+}
+
+// GetEmailAttachment extracts one attachment of an .eml file
+// @Summary		Download an email attachment
+// @Description	Extracts the attachment at index from an .eml file and serves it as a download. Attachments are never served inline.
+// @Tags		Files
+// @Produce		octet-stream
+// @Param		path	path	string	true	"Message path"
+// @Param		index	query	int		true	"Attachment index from the preview"
+// @Success		200		{file}	binary
+// @Failure		400		{object}	APIError
+// @Failure		404		{object}	APIError
+// @Failure		413		{object}	APIError
+// @Router		/email/attachment/{path} [get]
+func (h *Handler) GetEmailAttachment(c echo.Context) error {
+	requestPath := c.Param("*")
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	index, err := strconv.Atoi(c.QueryParam("index"))
+	if err != nil || index < 0 {
+		return RespondError(c, ErrBadRequest("Invalid attachment index"))
+	}
+
+	claims := GetClaims(c)
+	realPath, _, displayPath, err := h.resolvePath("/"+requestPath, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if isFileLink(realPath) {
+		targetPath, apiErr := h.followFileLink(realPath, claims)
+		if apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+		realPath = targetPath
+	}
+	if structuredPreviewType(strings.ToLower(strings.TrimPrefix(filepath.Ext(realPath), "."))) != PreviewTypeEmail {
+		return RespondError(c, ErrBadRequest("File is not an email message"))
+	}
+
+	file, err := OpenPlain(realPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return RespondError(c, ErrNotFound("File"))
+		}
+		return RespondError(c, ErrOperationFailed("open file", err))
+	}
+	defer file.Close()
+
+	pe, err := parseEmail(file, index)
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Not a readable email message"))
+	}
+	if index >= len(pe.attachments) {
+		return RespondError(c, ErrNotFound("Attachment"))
+	}
+	attachment := pe.attachments[index]
+	if attachment.size > maxEmailAttachmentBytes {
+		return RespondError(c, NewAPIError(ErrCodeFileTooLarge, fmt.Sprintf("Attachment exceeds the %d MB limit", maxEmailAttachmentBytes>>20)))
+	}
+
+	var userID *string
+	if claims != nil {
+		userID = &claims.UserID
+	}
+	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileDownload, displayPath, map[string]any{
+		"attachment": attachment.name,
+		"size":       attachment.size,
+	})
+
+	setContentDisposition(c, attachment.name)
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	return c.Blob(http.StatusOK, "application/octet-stream", attachment.data)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testEmail = "From: =?UTF-8?B?7ZmN6ri464+Z?= <hong@example.com>\r\n" +
+	"To: team@example.com, \"Kim\" <kim@example.com>\r\n" +
+	"Subject: =?UTF-8?Q?Q3_=EB=B3=B4=EA=B3=A0?=\r\n" +
+	"Date: Mon, 12 Oct 2026 09:30:00 +0900\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"See the =EB=B3=B4=EA=B3=A0 attached.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<html><head><style>p{}</style></head><body><p onclick=\"x()\">See <a href=\"javascript:alert(1)\">this</a> and <a href=\"https://example.com\">that</a></p><script>alert(1)</script><img src=\"https://tracker.example/p.gif\" alt=\"logo\"></div></body></html>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=\"report.csv\"\r\n" +
+	"Content-Disposition: attachment; filename=\"../report.csv\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YSxiCjEs\r\nMgo=\r\n" +
+	"--outer--\r\n"
+
+func TestParseEmail(t *testing.T) {
+	pe, err := parseEmail(strings.NewReader(testEmail), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview := buildEmailPreview(pe, "/api/email/attachment/home/mail.eml")
+
+	if len(preview.From) != 1 || preview.From[0].Name != "홍길동" || preview.From[0].Address != "hong@example.com" {
+		t.Errorf("from = %+v", preview.From)
+	}
+	if len(preview.To) != 2 || preview.To[1].Name != "Kim" {
+		t.Errorf("to = %+v", preview.To)
+	}
+	if preview.Subject != "Q3 보고" {
+		t.Errorf("subject = %q", preview.Subject)
+	}
+	if preview.Date == nil || preview.Date.UTC().Hour() != 0 {
+		t.Errorf("date = %v", preview.Date)
+	}
+	if !strings.Contains(preview.Text, "See the 보고 attached.") {
+		t.Errorf("text = %q", preview.Text)
+	}
+	if len(preview.Attachments) != 1 {
+		t.Fatalf("attachments = %+v", preview.Attachments)
+	}
+	a := preview.Attachments[0]
+	if a.Name != "_report.csv" || a.ContentType != "text/csv" || a.Size != 8 || a.URL != "/api/email/attachment/home/mail.eml?index=0" {
+		t.Errorf("attachment = %+v", a)
+	}
+	if string(pe.attachments[0].data) != "a,b\n1,2\n" {
+		t.Errorf("attachment data = %q", pe.attachments[0].data)
+	}
+	if !strings.Contains(preview.HTML, `<a href="https://example.com"`) || strings.Contains(preview.HTML, "alert") || strings.Contains(preview.HTML, "tracker") {
+		t.Errorf("html = %q", preview.HTML)
+	}
+	if preview.Truncated {
+		t.Error("complete message reported as truncated")
+	}
+}
+
+func TestSanitizeEmailHTML(t *testing.T) {
+	cases := map[string]string{
+		`<p onclick="x()">Hi</p>`:                        `<p>Hi</p>`,
+		`<script>alert(1)</script>ok`:                    `ok`,
+		`<a href="javascript:alert(1)">x</a>`:            `<a>x</a>`,
+		`<a href="https://e.com/?a=1&b=2">x</a>`:         `<a href="https://e.com/?a=1&amp;b=2" target="_blank" rel="noopener noreferrer">x</a>`,
+		`<img src="https://t.example/p.gif" alt="logo">`: `[logo]`,
+		`<b>bold</div></b>`:                              `<b>bold</b>`,
+		`<div><span>open`:                                `<div><span>open</span></div>`,
+		`<style>body{}</style><iframe src=x></iframe>`:   ``,
+		`<td colspan="2" style="x">c</td>`:               `<td colspan="2">c</td>`,
+		`1 < 2 &amp; "q"`:                                `1 &lt; 2 &amp; &#34;q&#34;`,
+	}
+	for in, want := range cases {
+		if got := sanitizeEmailHTML(in); got != want {
+			t.Errorf("sanitizeEmailHTML(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestParseEmail_Malformed feeds truncated and corrupted messages through the
+// parser: none may panic, and sanitized output never carries script
+func TestParseEmail_Malformed(t *testing.T) {
+	inputs := []string{
+		"",
+		"not an email",
+		"Content-Type: multipart/mixed\r\n\r\nno boundary",
+		"Content-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\nContent-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\n",
+		"Content-Transfer-Encoding: base64\r\nContent-Type: application/pdf\r\n\r\n!!!not base64!!!",
+		"Content-Type: text/plain; charset=bogus-charset\r\n\r\n\xff\xfe",
+		"Subject: =?unknown?Q?x?=\r\nFrom: <<<\r\n\r\nbody",
+		"Content-Type: text/html\r\n\r\n<scr<script>ipt>alert(1)</script>",
+	}
+	for i := 0; i < len(testEmail); i += 7 {
+		inputs = append(inputs, testEmail[:i])
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		b := []byte(testEmail)
+		for j := 0; j < 1+rng.Intn(8); j++ {
+			b[rng.Intn(len(b))] = byte(rng.Intn(256))
+		}
+		inputs = append(inputs, string(b))
+	}
+	// Deeply nested multiparts stop at the depth cap
+	nested := ""
+	for i := 0; i < 50; i++ {
+		nested += "Content-Type: multipart/mixed; boundary=b" + string(rune('a'+i%26)) + "\r\n\r\n--b" + string(rune('a'+i%26)) + "\r\n"
+	}
+	inputs = append(inputs, nested)
+
+	for _, in := range inputs {
+		pe, err := parseEmail(strings.NewReader(in), 0)
+		if err != nil {
+			continue
+		}
+		preview := buildEmailPreview(pe, "/x")
+		if strings.Contains(strings.ToLower(preview.HTML), "<script") {
+			t.Errorf("script survived sanitizing: %q", preview.HTML)
+		}
+		for _, a := range preview.Attachments {
+			if strings.ContainsAny(a.Name, "/\\\r\n") {
+				t.Errorf("unsafe attachment name %q", a.Name)
+			}
+		}
+	}
+}
+
+func TestParseCalendar(t *testing.T) {
+	ics := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Design review\\, round 2\r\n" +
+		"DTSTART;TZID=Asia/Seoul:20261020T140000\r\nDTEND;TZID=Asia/Seoul:20261020T150000\r\n" +
+		"LOCATION:Room 3\r\nDESCRIPTION:Bring the\\n mockups and a long\r\n  folded line\r\n" +
+		"ORGANIZER;CN=Kim:mailto:kim@example.com\r\nBEGIN:VALARM\r\nSUMMARY:alarm\r\nEND:VALARM\r\n" +
+		"END:VEVENT\r\nBEGIN:VEVENT\r\nSUMMARY:Holiday\r\nDTSTART;VALUE=DATE:20261225\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nSUMMARY:Call\r\nDTSTART:20261021T010000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	events, truncated := parseCalendar(strings.NewReader(ics))
+	if truncated || len(events) != 3 {
+		t.Fatalf("events = %+v, truncated %v", events, truncated)
+	}
+	e := events[0]
+	if e.Summary != "Design review, round 2" || e.Start != "2026-10-20T14:00:00" || e.Timezone != "Asia/Seoul" ||
+		e.Location != "Room 3" || e.Description != "Bring the\n mockups and a long folded line" || e.Organizer != "Kim" {
+		t.Errorf("event = %+v", e)
+	}
+	if !events[1].AllDay || events[1].Start != "2026-12-25" {
+		t.Errorf("all-day event = %+v", events[1])
+	}
+	if events[2].Start != "2026-10-21T01:00:00Z" {
+		t.Errorf("UTC event = %+v", events[2])
+	}
+
+	for _, in := range []string{"", "BEGIN:VEVENT", "END:VEVENT\nBEGIN:VEVENT\nDTSTART:garbage", ":::\n;;;\n"} {
+		parseCalendar(strings.NewReader(in))
+	}
+}
+
+func TestParseContacts(t *testing.T) {
+	vcf := "BEGIN:VCARD\nVERSION:3.0\nN:Hong;Gildong;;;\nORG:FileHatch;Platform\nTITLE:Engineer\n" +
+		"item1.EMAIL;TYPE=work:hong@example.com\nTEL;TYPE=cell:+82 10 1234 5678\nEND:VCARD\n" +
+		"BEGIN:VCARD\nFN:Kim\nEMAIL:kim@example.com\nEND:VCARD\n"
+	contacts, truncated := parseContacts(strings.NewReader(vcf))
+	if truncated || len(contacts) != 2 {
+		t.Fatalf("contacts = %+v", contacts)
+	}
+	c := contacts[0]
+	if c.Name != "Gildong Hong" || c.Organization != "FileHatch, Platform" || c.Title != "Engineer" ||
+		len(c.Emails) != 1 || c.Emails[0] != "hong@example.com" || len(c.Phones) != 1 {
+		t.Errorf("contact = %+v", c)
+	}
+	if contacts[1].Name != "Kim" {
+		t.Errorf("contact = %+v", contacts[1])
+	}
+}
+
+func TestGetEmailAttachment(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	home := filepath.Join(dataRoot, "users", "alice")
+	writeTestFiles(t, home, "placeholder")
+	if err := os.WriteFile(filepath.Join(home, "report.eml"), []byte(testEmail), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{db: tc.DB, dataRoot: dataRoot, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+
+	get := func(index string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/email/attachment/home/report.eml?index="+index, nil)
+		c := CreateAuthenticatedContext(tc.Echo, rec, req, "u1", "alice", false)
+		c.SetParamNames("*")
+		c.SetParamValues("home/report.eml")
+		if err := h.GetEmailAttachment(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	rec := get("0")
+	AssertStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "a,b\n1,2\n" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "application/octet-stream" || !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment;") {
+		t.Errorf("attachment served inline: %v", rec.Header())
+	}
+	AssertStatus(t, get("1"), http.StatusNotFound)
+	AssertStatus(t, get("x"), http.StatusBadRequest)
+}
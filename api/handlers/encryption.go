@@ -0,0 +1,987 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Encrypted file format (version 1)
+//
+//	magic "FHENC" | version (1) | key ID (16) | wrapped data key (60) | nonce prefix (8)
+//	followed by 64 KiB plaintext chunks, each sealed with AES-256-GCM
+//
+// The data key is wrapped by the master key; re-wrapping after a key rotation
+// only rewrites the key ID and wrapped key in the header. Each chunk nonce is
+// the nonce prefix plus the chunk index, and the last chunk is authenticated
+// as final so a truncated file fails to decrypt.
+const (
+	encMagic          = "FHENC"
+	encVersion        = 1
+	encKeyIDSize      = 16
+	encWrappedKeySize = 12 + 32 + 16 // nonce + key + tag
+	encNoncePrefixLen = 8
+	encHeaderSize     = len(encMagic) + 1 + encKeyIDSize + encWrappedKeySize + encNoncePrefixLen
+	encChunkSize      = 64 * 1024
+	encTagSize        = 16
+	encSealedChunk    = encChunkSize + encTagSize
+
+	// encKeyOffset is where the key ID starts; key ID and wrapped key are contiguous
+	encKeyOffset = len(encMagic) + 1
+)
+
+// EncryptedMarkerFile is created in the root of every encrypted folder so
+// tools outside the API (the Samba entrypoint) can recognize it
+const EncryptedMarkerFile = ".fh-encrypted"
+
+// encryptedDirMode keeps SMB users (non-root) out of encrypted folders; the
+// Samba path bypasses the API and would only see ciphertext
+const encryptedDirMode = 0700
+
+var (
+	// ErrEncryptionUnavailable is returned when no master key is configured
+	ErrEncryptionUnavailable = errors.New("file encryption is not configured (FILE_ENCRYPTION_KEY)")
+	// ErrUnknownEncryptionKey is returned for files wrapped by a key that is not loaded
+	ErrUnknownEncryptionKey = errors.New("file is encrypted with an unknown master key")
+)
+
+// EncryptedFolder is a folder whose new files are encrypted at rest
+type EncryptedFolder struct {
+	Path      string    `json:"path"` // Data-root relative: shared/{folder} or users/{username}/...
+	CreatedBy *string   `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// FileEncryption holds the master keys and the set of encrypted folders
+type FileEncryption struct {
+	db       *sql.DB
+	dataRoot string
+
+	masterKey []byte
+	masterID  string
+	keys      map[string][]byte // key ID -> key, current and previous
+
+	mu      sync.RWMutex
+	folders map[string]EncryptedFolder
+}
+
+var (
+	fileEncryption   *FileEncryption
+	fileEncryptionMu sync.RWMutex
+)
+
+// InitFileEncryption loads the master keys from the environment and the
+// encrypted folders from the database
+func InitFileEncryption(db *sql.DB, dataRoot string) *FileEncryption {
+	e := &FileEncryption{
+		db:       db,
+		dataRoot: dataRoot,
+		keys:     make(map[string][]byte),
+		folders:  make(map[string]EncryptedFolder),
+	}
+
+	current := os.Getenv("FILE_ENCRYPTION_KEY")
+	if keyFile := os.Getenv("FILE_ENCRYPTION_KEY_FILE"); current == "" && keyFile != "" {
+		// Key mounted by a secret store or KMS agent
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			log.Printf("[Encryption] Failed to read FILE_ENCRYPTION_KEY_FILE: %v", err)
+		}
+		current = strings.TrimSpace(string(data))
+	}
+	if current != "" {
+		e.masterKey = parseMasterKey(current)
+		e.masterID = masterKeyID(e.masterKey)
+		e.keys[e.masterID] = e.masterKey
+	}
+	for _, previous := range strings.Split(os.Getenv("FILE_ENCRYPTION_PREVIOUS_KEYS"), ",") {
+		if previous = strings.TrimSpace(previous); previous != "" {
+			key := parseMasterKey(previous)
+			e.keys[masterKeyID(key)] = key
+		}
+	}
+
+	if err := e.loadFolders(); err != nil {
+		log.Printf("[Encryption] Failed to load encrypted folders: %v", err)
+	}
+	if e.masterKey == nil && len(e.folders) > 0 {
+		log.Printf("[Encryption] WARNING: %d encrypted folder(s) configured but FILE_ENCRYPTION_KEY is not set; encrypted files cannot be read or written", len(e.folders))
+	}
+
+	fileEncryptionMu.Lock()
+	fileEncryption = e
+	fileEncryptionMu.Unlock()
+	return e
+}
+
+// GetFileEncryption returns the global file encryption state (nil if not initialized)
+func GetFileEncryption() *FileEncryption {
+	fileEncryptionMu.RLock()
+	defer fileEncryptionMu.RUnlock()
+	return fileEncryption
+}
+
+// parseMasterKey accepts a hex or base64-encoded 32-byte key or derives one
+// from a passphrase
+func parseMasterKey(value string) []byte {
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key
+	}
+	sum := sha256.Sum256([]byte(value))
+	return sum[:]
+}
+
+// masterKeyID identifies a master key without revealing it
+func masterKeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("filehatch-master-key:"), key...))
+	return hex.EncodeToString(sum[:])[:encKeyIDSize]
+}
+
+// Available reports whether a master key is configured
+func (e *FileEncryption) Available() bool {
+	return e != nil && e.masterKey != nil
+}
+
+// Active reports whether files may be encrypted at all: a master key is
+// configured or a folder is flagged. When inactive no file is opened to look
+// for an encryption header.
+func (e *FileEncryption) Active() bool {
+	if e == nil {
+		return false
+	}
+	if len(e.keys) > 0 {
+		return true
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.folders) > 0
+}
+
+// KeyID returns the ID of the current master key
+func (e *FileEncryption) KeyID() string {
+	if e == nil {
+		return ""
+	}
+	return e.masterID
+}
+
+func (e *FileEncryption) loadFolders() error {
+	if e.db == nil {
+		return nil
+	}
+	rows, err := e.db.Query(`SELECT path, created_by, created_at FROM encrypted_folders`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for rows.Next() {
+		var f EncryptedFolder
+		var createdBy sql.NullString
+		if err := rows.Scan(&f.Path, &createdBy, &f.CreatedAt); err != nil {
+			continue
+		}
+		if createdBy.Valid {
+			f.CreatedBy = &createdBy.String
+		}
+		e.folders[f.Path] = f
+	}
+	return rows.Err()
+}
+
+// Folders returns the encrypted folders
+func (e *FileEncryption) Folders() []EncryptedFolder {
+	if e == nil {
+		return nil
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	folders := make([]EncryptedFolder, 0, len(e.folders))
+	for _, f := range e.folders {
+		folders = append(folders, f)
+	}
+	return folders
+}
+
+// relPath returns the slash-separated data-root relative path
+func (e *FileEncryption) relPath(realPath string) (string, bool) {
+	rel, err := filepath.Rel(e.dataRoot, realPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// EncryptedFolderOf returns the encrypted folder containing realPath (or
+// equal to it), or "" if the path is not in one
+func (e *FileEncryption) EncryptedFolderOf(realPath string) string {
+	if e == nil {
+		return ""
+	}
+	rel, ok := e.relPath(realPath)
+	if !ok {
+		return ""
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for folder := range e.folders {
+		if rel == folder || strings.HasPrefix(rel, folder+"/") {
+			return folder
+		}
+	}
+	return ""
+}
+
+// InEncryptedFolder reports whether files written to realPath must be encrypted
+func (e *FileEncryption) InEncryptedFolder(realPath string) bool {
+	return e.EncryptedFolderOf(realPath) != ""
+}
+
+// SetFolder flags or unflags a folder. Flagging requires a master key, locks
+// the folder against SMB users and encrypts files written afterwards; files
+// already in the folder stay as they are. Unflagging leaves encrypted files
+// encrypted (they stay readable through the API).
+func (e *FileEncryption) SetFolder(rel string, encrypted bool, actorID *string) error {
+	if e == nil {
+		return ErrEncryptionUnavailable
+	}
+	realPath := filepath.Join(e.dataRoot, filepath.FromSlash(rel))
+
+	if !encrypted {
+		if _, err := e.db.Exec(`DELETE FROM encrypted_folders WHERE path = $1`, rel); err != nil {
+			return err
+		}
+		e.mu.Lock()
+		delete(e.folders, rel)
+		e.mu.Unlock()
+		os.Remove(filepath.Join(realPath, EncryptedMarkerFile))
+		// Restore the mode the folder would have without encryption
+		if strings.HasPrefix(rel, "shared/") {
+			return SetSharedPermissions(realPath, true)
+		}
+		return os.Chmod(realPath, 0755)
+	}
+
+	if !e.Available() {
+		return ErrEncryptionUnavailable
+	}
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", rel)
+	}
+
+	var createdAt time.Time
+	if err := e.db.QueryRow(`
+		INSERT INTO encrypted_folders (path, created_by) VALUES ($1, $2)
+		ON CONFLICT (path) DO UPDATE SET path = EXCLUDED.path
+		RETURNING created_at
+	`, rel, actorID).Scan(&createdAt); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.folders[rel] = EncryptedFolder{Path: rel, CreatedBy: actorID, CreatedAt: createdAt}
+	e.mu.Unlock()
+
+	if err := os.WriteFile(filepath.Join(realPath, EncryptedMarkerFile), []byte(e.masterID+"\n"), 0600); err != nil {
+		return err
+	}
+	// Owned by the API user so an SMB user who created the folder cannot enter it
+	if err := os.Chown(realPath, os.Getuid(), os.Getgid()); err != nil {
+		return err
+	}
+	return os.Chmod(realPath, encryptedDirMode)
+}
+
+// RenameFolder moves encrypted folder entries when a folder is renamed
+func (e *FileEncryption) RenameFolder(oldRel, newRel string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for folder, f := range e.folders {
+		if folder != oldRel && !strings.HasPrefix(folder, oldRel+"/") {
+			continue
+		}
+		moved := newRel + strings.TrimPrefix(folder, oldRel)
+		if _, err := e.db.Exec(`UPDATE encrypted_folders SET path = $1 WHERE path = $2`, moved, folder); err != nil {
+			log.Printf("[Encryption] Failed to rename encrypted folder %s: %v", folder, err)
+			continue
+		}
+		delete(e.folders, folder)
+		f.Path = moved
+		e.folders[moved] = f
+	}
+}
+
+// IsFolderEncrypted reports whether rel is itself flagged as an encrypted folder
+func (e *FileEncryption) IsFolderEncrypted(rel string) bool {
+	if e == nil {
+		return false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.folders[rel]
+	return ok
+}
+
+// MovePath follows a rename or move: encrypted folders inside the moved item
+// keep their flag at the new location, and plaintext files moved into an
+// encrypted folder are encrypted
+func (e *FileEncryption) MovePath(oldRealPath, newRealPath string) {
+	if e == nil {
+		return
+	}
+	oldRel, ok1 := e.relPath(oldRealPath)
+	newRel, ok2 := e.relPath(newRealPath)
+	if ok1 && ok2 {
+		e.RenameFolder(oldRel, newRel)
+	}
+	_ = SealPath(newRealPath)
+}
+
+// ForgetTree drops the flag of encrypted folders at or below a deleted path.
+// Files that were encrypted stay encrypted wherever they end up (e.g. trash).
+func (e *FileEncryption) ForgetTree(realPath string) {
+	if e == nil {
+		return
+	}
+	rel, ok := e.relPath(realPath)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for folder := range e.folders {
+		if folder != rel && !strings.HasPrefix(folder, rel+"/") {
+			continue
+		}
+		if _, err := e.db.Exec(`DELETE FROM encrypted_folders WHERE path = $1`, folder); err != nil {
+			log.Printf("[Encryption] Failed to remove encrypted folder %s: %v", folder, err)
+			continue
+		}
+		delete(e.folders, folder)
+	}
+}
+
+// readEncHeader reads the header of an encrypted file.
+// ok is false for plaintext files.
+func readEncHeader(f io.ReaderAt) (header []byte, ok bool, err error) {
+	header = make([]byte, encHeaderSize)
+	n, err := f.ReadAt(header, 0)
+	if n < encHeaderSize {
+		if err == io.EOF || err == nil {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if string(header[:len(encMagic)]) != encMagic {
+		return nil, false, nil
+	}
+	if header[len(encMagic)] != encVersion {
+		return nil, false, fmt.Errorf("unsupported encrypted file version %d", header[len(encMagic)])
+	}
+	return header, true, nil
+}
+
+// IsEncryptedFile reports whether the file at path is stored encrypted
+func IsEncryptedFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, ok, _ := readEncHeader(f)
+	return ok
+}
+
+// IsEncryptedEntry reports whether a listed file is stored encrypted, or
+// whether a listed directory is (inside) an encrypted folder
+func IsEncryptedEntry(realPath string, info os.FileInfo) bool {
+	e := GetFileEncryption()
+	if !e.Active() {
+		return false
+	}
+	if info.IsDir() {
+		return e.InEncryptedFolder(realPath)
+	}
+	return info.Mode().IsRegular() && info.Size() >= int64(encHeaderSize) && IsEncryptedFile(realPath)
+}
+
+// encryptedPlainSize returns the plaintext size of an encrypted file of diskSize bytes
+func encryptedPlainSize(diskSize int64) int64 {
+	n := diskSize - int64(encHeaderSize)
+	if n < encTagSize {
+		return 0
+	}
+	full := n / encSealedChunk
+	rem := n % encSealedChunk
+	if rem == 0 {
+		return full * encChunkSize
+	}
+	return full*encChunkSize + rem - encTagSize
+}
+
+// PlainSize returns the size of the file's content as seen through the API
+func PlainSize(path string, info os.FileInfo) int64 {
+	if info.IsDir() || info.Size() < int64(encHeaderSize) || !IsEncryptedFile(path) {
+		return info.Size()
+	}
+	return encryptedPlainSize(info.Size())
+}
+
+// aeadFor builds an AES-256-GCM cipher
+func aeadFor(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce derives the nonce of chunk index from the file's nonce prefix
+func chunkNonce(prefix []byte, index int64) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encNoncePrefixLen:], uint32(index))
+	return nonce
+}
+
+// chunkAAD marks whether a chunk is the last one
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// newFileHeader creates a header with a fresh data key wrapped by the master key
+func (e *FileEncryption) newFileHeader() (header []byte, dataKey []byte, err error) {
+	if !e.Available() {
+		return nil, nil, ErrEncryptionUnavailable
+	}
+	dataKey, err = GenerateSecureBytes(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix, err := GenerateSecureBytes(encNoncePrefixLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := wrapDataKey(e.masterKey, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header = make([]byte, 0, encHeaderSize)
+	header = append(header, encMagic...)
+	header = append(header, encVersion)
+	header = append(header, e.masterID...)
+	header = append(header, wrapped...)
+	header = append(header, prefix...)
+	return header, dataKey, nil
+}
+
+// wrapDataKey seals a data key with a master key
+func wrapDataKey(masterKey, dataKey []byte) ([]byte, error) {
+	aead, err := aeadFor(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := GenerateSecureBytes(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(encMagic)), nil
+}
+
+// unwrapDataKey opens the data key in a header with the key it names
+func (e *FileEncryption) unwrapDataKey(header []byte) ([]byte, error) {
+	if e == nil {
+		return nil, ErrEncryptionUnavailable
+	}
+	keyID := string(header[encKeyOffset : encKeyOffset+encKeyIDSize])
+	masterKey, ok := e.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownEncryptionKey
+	}
+	aead, err := aeadFor(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := header[encKeyOffset+encKeyIDSize : encKeyOffset+encKeyIDSize+encWrappedKeySize]
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(encMagic))
+}
+
+// encryptWriter encrypts a stream into the chunked format. The last chunk is
+// only sealed on Close, when it is known to be final.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  int64
+	buf    []byte
+	out    []byte
+}
+
+// NewEncryptWriter writes a new header to w and returns a writer that
+// encrypts everything written to it. Close must be called to seal the last chunk.
+func (e *FileEncryption) NewEncryptWriter(w io.Writer) (io.WriteCloser, error) {
+	header, dataKey, err := e.newFileHeader()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := aeadFor(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: header[encHeaderSize-encNoncePrefixLen:],
+		buf:    make([]byte, 0, encChunkSize),
+		out:    make([]byte, 0, encSealedChunk),
+	}, nil
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		if len(ew.buf) == encChunkSize {
+			if err := ew.flush(false); err != nil {
+				return 0, err
+			}
+		}
+		n := copy(ew.buf[len(ew.buf):encChunkSize], p)
+		ew.buf = ew.buf[:len(ew.buf)+n]
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (ew *encryptWriter) flush(final bool) error {
+	ew.out = ew.aead.Seal(ew.out[:0], chunkNonce(ew.prefix, ew.index), ew.buf, chunkAAD(final))
+	ew.index++
+	ew.buf = ew.buf[:0]
+	_, err := ew.w.Write(ew.out)
+	return err
+}
+
+func (ew *encryptWriter) Close() error {
+	return ew.flush(true)
+}
+
+// PlainFile is a file opened for reading its content as seen through the API
+type PlainFile interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Stat() (os.FileInfo, error)
+}
+
+// decryptFile reads an encrypted file, decrypting chunks on demand
+type decryptFile struct {
+	f      *os.File
+	aead   cipher.AEAD
+	prefix []byte
+	size   int64 // plaintext size
+	pos    int64
+
+	cached    int64 // index of the chunk in plain, -1 if none
+	plain     []byte
+	sealedBuf []byte
+}
+
+// OpenPlain opens a file for reading. Encrypted files are transparently
+// decrypted; other files are returned as *os.File.
+func OpenPlain(path string) (PlainFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header, ok, err := readEncHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !ok {
+		return f, nil
+	}
+
+	dataKey, err := GetFileEncryption().unwrapDataKey(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	aead, err := aeadFor(dataKey)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decryptFile{
+		f:         f,
+		aead:      aead,
+		prefix:    header[encHeaderSize-encNoncePrefixLen:],
+		size:      encryptedPlainSize(info.Size()),
+		cached:    -1,
+		sealedBuf: make([]byte, encSealedChunk),
+	}, nil
+}
+
+// chunk decrypts chunk index into d.plain
+func (d *decryptFile) chunk(index int64) error {
+	if d.cached == index {
+		return nil
+	}
+	lastIndex := int64(0)
+	if d.size > 0 {
+		lastIndex = (d.size - 1) / encChunkSize
+	}
+	offset := int64(encHeaderSize) + index*encSealedChunk
+	length := encChunkSize + encTagSize
+	if index == lastIndex {
+		length = int(d.size-index*encChunkSize) + encTagSize
+	}
+	sealed := d.sealedBuf[:length]
+	if _, err := d.f.ReadAt(sealed, offset); err != nil {
+		return err
+	}
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(d.prefix, index), sealed, chunkAAD(index == lastIndex))
+	if err != nil {
+		return fmt.Errorf("encrypted file is corrupt or was tampered with: %w", err)
+	}
+	d.plain = plain
+	d.cached = index
+	return nil
+}
+
+func (d *decryptFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		if off >= d.size {
+			return n, io.EOF
+		}
+		index := off / encChunkSize
+		if err := d.chunk(index); err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], d.plain[off-index*encChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+func (d *decryptFile) Read(p []byte) (int, error) {
+	if d.pos >= d.size {
+		return 0, io.EOF
+	}
+	n, err := d.ReadAt(p, d.pos)
+	d.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (d *decryptFile) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = d.pos + offset
+	case io.SeekEnd:
+		pos = d.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.pos = pos
+	return pos, nil
+}
+
+func (d *decryptFile) Close() error {
+	return d.f.Close()
+}
+
+func (d *decryptFile) Stat() (os.FileInfo, error) {
+	info, err := d.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return plainFileInfo{FileInfo: info, size: d.size}, nil
+}
+
+// plainFileInfo reports the plaintext size of an encrypted file
+type plainFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i plainFileInfo) Size() int64 { return i.size }
+
+// ServePlainFile serves a file like c.File, decrypting it on the fly if it
+// is stored encrypted. Range requests are supported for both.
+func ServePlainFile(c echo.Context, realPath string) error {
+	if !GetFileEncryption().Active() || !IsEncryptedFile(realPath) {
+		return c.File(realPath)
+	}
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return RespondError(c, encryptionAPIError("read file", err))
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("access file", err))
+	}
+	http.ServeContent(c.Response(), c.Request(), info.Name(), info.ModTime(), f)
+	return nil
+}
+
+// openPlainZip opens a ZIP archive that may be stored encrypted. The
+// returned file must be closed when the reader is no longer used.
+func openPlainZip(realPath string) (*zip.Reader, PlainFile, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	reader, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return reader, f, nil
+}
+
+// encryptionAPIError maps encryption failures to API errors
+func encryptionAPIError(operation string, err error) *APIError {
+	if errors.Is(err, ErrEncryptionUnavailable) || errors.Is(err, ErrUnknownEncryptionKey) {
+		return NewAPIError(ErrCodeServiceUnavailable, err.Error())
+	}
+	return ErrOperationFailed(operation, err)
+}
+
+// sealFile encrypts a plaintext file in place through a temporary file in
+// the same directory, keeping its mode and modification time
+func (e *FileEncryption) sealFile(realPath string, info os.FileInfo) error {
+	src, err := os.Open(realPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, ok, err := readEncHeader(src); err != nil || ok {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(realPath), "."+filepath.Base(realPath)+".fhenc-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	ew, err := e.NewEncryptWriter(tmp)
+	if err != nil {
+		return fail(err)
+	}
+	if _, err := io.Copy(ew, src); err != nil {
+		return fail(err)
+	}
+	if err := ew.Close(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	_ = os.Chmod(tmpPath, info.Mode().Perm())
+	_ = os.Chtimes(tmpPath, time.Now(), info.ModTime())
+	if err := os.Rename(tmpPath, realPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// SealPath encrypts the file, or the files below the directory, at realPath
+// if it lies in an encrypted folder. Already encrypted files are skipped.
+// Called after the API writes, copies or moves something into place.
+func SealPath(realPath string) error {
+	e := GetFileEncryption()
+	if !e.InEncryptedFolder(realPath) {
+		return nil
+	}
+	if !e.Available() {
+		return ErrEncryptionUnavailable
+	}
+	var failed error
+	_ = filepath.WalkDir(realPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !d.Type().IsRegular() || d.Name() == EncryptedMarkerFile {
+			return nil
+		}
+		info, err := d.Info()
+		if err == nil {
+			err = e.sealFile(p, info)
+		}
+		if err != nil {
+			LogError("Failed to encrypt file", err, "path", p)
+			failed = err
+		}
+		return nil
+	})
+	return failed
+}
+
+// WriteFileSealed writes data to realPath, encrypting it directly if the path
+// is in an encrypted folder so no plaintext reaches the disk
+func WriteFileSealed(realPath string, data []byte, perm os.FileMode) error {
+	return WriteFileSealedFrom(realPath, bytes.NewReader(data), perm)
+}
+
+// WriteFileSealedFrom writes the content of r to realPath like
+// WriteFileSealed. The content goes to a temporary file in the same directory
+// that replaces realPath only once it is completely written and synced, so a
+// failed write leaves the previous content in place. An existing file keeps
+// its mode and owner; a new one gets perm. Replacing the file also detaches
+// it from the snapshots sharing it.
+func WriteFileSealedFrom(realPath string, r io.Reader, perm os.FileMode) error {
+	e := GetFileEncryption()
+	sealed := e.InEncryptedFolder(realPath)
+	if sealed && !e.Available() {
+		return ErrEncryptionUnavailable
+	}
+	mode := perm
+	var owner *syscall.Stat_t
+	if info, err := os.Stat(realPath); err == nil {
+		mode = info.Mode().Perm()
+		owner, _ = info.Sys().(*syscall.Stat_t)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(realPath), "."+filepath.Base(realPath)+".save-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if sealed {
+		ew, err := e.NewEncryptWriter(tmp)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := io.Copy(ew, r); err != nil {
+			return fail(err)
+		}
+		if err := ew.Close(); err != nil {
+			return fail(err)
+		}
+	} else if _, err := io.Copy(tmp, r); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	_ = os.Chmod(tmpPath, mode)
+	if owner != nil {
+		_ = os.Chown(tmpPath, int(owner.Uid), int(owner.Gid))
+	}
+	if err := os.Rename(tmpPath, realPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// rewrapFile rewrites the header of an encrypted file so its data key is
+// wrapped by the current master key. Returns false if nothing changed.
+func (e *FileEncryption) rewrapFile(realPath string) (bool, error) {
+	f, err := os.OpenFile(realPath, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header, ok, err := readEncHeader(f)
+	if err != nil || !ok {
+		return false, err
+	}
+	if string(header[encKeyOffset:encKeyOffset+encKeyIDSize]) == e.masterID {
+		return false, nil
+	}
+	dataKey, err := e.unwrapDataKey(header)
+	if err != nil {
+		return false, err
+	}
+	wrapped, err := wrapDataKey(e.masterKey, dataKey)
+	if err != nil {
+		return false, err
+	}
+	keyPart := append([]byte(e.masterID), wrapped...)
+	if _, err := f.WriteAt(keyPart, int64(encKeyOffset)); err != nil {
+		return false, err
+	}
+	return true, f.Sync()
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxRewrapJobs is how many finished key rotation jobs are kept for status queries
+const maxRewrapJobs = 20
+
+// SetEncryptedFolderRequest is the body of PUT /api/admin/encryption/folders
+type SetEncryptedFolderRequest struct {
+	Path      string `json:"path"` // Data-root relative: shared/{folder}/... or users/{username}/...
+	Encrypted bool   `json:"encrypted"`
+}
+
+// RewrapJob is a key rotation run that re-wraps the data key of every
+// encrypted file with the current master key. File contents are not rewritten.
+type RewrapJob struct {
+	ID          string     `json:"id"`
+	KeyID       string     `json:"keyId"`
+	Status      string     `json:"status"` // Same states as adopt jobs
+	StartedAt   time.Time  `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Scanned     int64      `json:"scanned"`
+	Encrypted   int64      `json:"encrypted"`
+	Rewrapped   int64      `json:"rewrapped"`
+	Errors      int64      `json:"errors"`
+	FailedPaths []string   `json:"failedPaths,omitempty"`
+}
+
+// rewrapRun guards a job while its walk updates the counters
+type rewrapRun struct {
+	mu  sync.Mutex
+	job RewrapJob
+}
+
+var (
+	rewrapJobsMu sync.Mutex
+	rewrapJobs   = make(map[string]*rewrapRun)
+	rewrapOrder  []string
+)
+
+func (r *rewrapRun) snapshot() RewrapJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.job
+	job.FailedPaths = append([]string{}, r.job.FailedPaths...)
+	return job
+}
+
+func (r *rewrapRun) update(fn func(j *RewrapJob)) {
+	r.mu.Lock()
+	fn(&r.job)
+	r.mu.Unlock()
+}
+
+func (r *rewrapRun) finish(status string, err error) {
+	now := time.Now()
+	r.update(func(j *RewrapJob) {
+		j.Status = status
+		j.FinishedAt = &now
+		if err != nil {
+			j.Error = err.Error()
+		}
+	})
+}
+
+func registerRewrapJob(run *rewrapRun) {
+	rewrapJobsMu.Lock()
+	defer rewrapJobsMu.Unlock()
+	rewrapJobs[run.job.ID] = run
+	rewrapOrder = append(rewrapOrder, run.job.ID)
+
+	for len(rewrapOrder) > maxRewrapJobs {
+		oldest := rewrapJobs[rewrapOrder[0]]
+		if oldest != nil && oldest.snapshot().Status == AdoptRunning {
+			break
+		}
+		delete(rewrapJobs, rewrapOrder[0])
+		rewrapOrder = rewrapOrder[1:]
+	}
+}
+
+// runningRewrapJob returns the key rotation job in progress, if any
+func runningRewrapJob() *rewrapRun {
+	rewrapJobsMu.Lock()
+	defer rewrapJobsMu.Unlock()
+	for _, run := range rewrapJobs {
+		if run.snapshot().Status == AdoptRunning {
+			return run
+		}
+	}
+	return nil
+}
+
+// runRewrap walks the whole data root, since encrypted files stay encrypted
+// when moved out of their folder or into the trash
+func (h *Handler) runRewrap(run *rewrapRun, activity *Activity, enc *FileEncryption, actorID, clientIP string) {
+	defer activity.Finish()
+
+	cacheDir := filepath.Join(h.dataRoot, ".cache")
+	walkErr := filepath.WalkDir(h.dataRoot, func(p string, d fs.DirEntry, err error) error {
+		if paceErr := activity.Pace(); paceErr != nil {
+			return paceErr
+		}
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p == cacheDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		run.update(func(j *RewrapJob) { j.Scanned++ })
+		if !IsEncryptedFile(p) {
+			return nil
+		}
+		run.update(func(j *RewrapJob) { j.Encrypted++ })
+
+		changed, err := enc.rewrapFile(p)
+		if err != nil {
+			LogError("Failed to re-wrap file key", err, "path", p)
+			rel, _ := filepath.Rel(h.dataRoot, p)
+			run.update(func(j *RewrapJob) {
+				j.Errors++
+				if len(j.FailedPaths) < maxAdoptSamples {
+					j.FailedPaths = append(j.FailedPaths, "/"+filepath.ToSlash(rel))
+				}
+			})
+			return nil
+		}
+		if changed {
+			run.update(func(j *RewrapJob) { j.Rewrapped++ })
+		}
+		return nil
+	})
+
+	if walkErr != nil {
+		status := AdoptFailed
+		if activity.Err() != nil {
+			status = AdoptCancelled
+		}
+		run.finish(status, walkErr)
+		return
+	}
+
+	// Markers record the key new files are written with
+	for _, f := range enc.Folders() {
+		marker := filepath.Join(h.dataRoot, filepath.FromSlash(f.Path), EncryptedMarkerFile)
+		_ = os.WriteFile(marker, []byte(enc.KeyID()+"\n"), 0600)
+	}
+
+	result := run.snapshot()
+	_ = h.auditHandler.LogEvent(&actorID, clientIP, EventAdminEncryptionRewrap, "", map[string]interface{}{
+		"jobId":     result.ID,
+		"keyId":     result.KeyID,
+		"encrypted": result.Encrypted,
+		"rewrapped": result.Rewrapped,
+		"errors":    result.Errors,
+	})
+	run.finish(AdoptCompleted, nil)
+}
+
+// GetEncryptionStatus returns the encryption configuration
+// @Summary		Encryption status
+// @Description	Returns whether a master key is configured, its ID, and the encrypted folders
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Encryption status"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/encryption [get]
+func (h *Handler) GetEncryptionStatus(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermStorageAdmin); err != nil {
+		return err
+	}
+	enc := GetFileEncryption()
+	folders := enc.Folders()
+	if folders == nil {
+		folders = []EncryptedFolder{}
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Path < folders[j].Path })
+
+	status := map[string]interface{}{
+		"available": enc.Available(),
+		"keyId":     enc.KeyID(),
+		"folders":   folders,
+	}
+	if run := runningRewrapJob(); run != nil {
+		status["rewrapJob"] = run.snapshot()
+	}
+	return RespondSuccess(c, status)
+}
+
+// SetEncryptedFolder flags or unflags a folder for encryption at rest
+// @Summary		Set folder encryption
+// @Description	Flags a shared drive or home subfolder (shared/{folder}/... or users/{username}/...) so files written to it through the API are encrypted. The folder is closed to SMB access. Existing files are not converted; unflagging leaves encrypted files encrypted and readable.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		SetEncryptedFolderRequest	true	"Folder and flag"
+// @Success		200		{object}	docs.SuccessResponse	"Folder updated"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Failure		503		{object}	docs.ErrorResponse	"No master key configured"
+// @Security	BearerAuth
+// @Router		/admin/encryption/folders [put]
+func (h *Handler) SetEncryptedFolder(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if err != nil {
+		return err
+	}
+
+	var req SetEncryptedFolderRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if req.Path == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	target, apiErr := h.resolveAdoptTarget(req.Path)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	if err := GetFileEncryption().SetFolder(target.rel, req.Encrypted, &claims.UserID); err != nil {
+		return RespondError(c, encryptionAPIError("update folder encryption", err))
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminEncryptionFolder, "/"+target.rel, map[string]interface{}{
+		"encrypted": req.Encrypted,
+		"keyId":     GetFileEncryption().KeyID(),
+	})
+
+	return RespondSuccess(c, map[string]interface{}{
+		"path":      target.rel,
+		"encrypted": req.Encrypted,
+	})
+}
+
+// StartEncryptionRewrap starts a key rotation job
+// @Summary		Re-wrap file keys
+// @Description	After changing FILE_ENCRYPTION_KEY (with the old key in FILE_ENCRYPTION_PREVIOUS_KEYS), re-wraps the data key of every encrypted file with the current key. Runs as a background job; only file headers are rewritten.
+// @Tags		Admin
+// @Produce		json
+// @Success		202		{object}	docs.SuccessResponse	"Job started"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		409		{object}	docs.ErrorResponse	"A job is already running"
+// @Failure		503		{object}	docs.ErrorResponse	"No master key configured"
+// @Security	BearerAuth
+// @Router		/admin/encryption/rewrap [post]
+func (h *Handler) StartEncryptionRewrap(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if err != nil {
+		return err
+	}
+	enc := GetFileEncryption()
+	if !enc.Available() {
+		return RespondError(c, encryptionAPIError("re-wrap keys", ErrEncryptionUnavailable))
+	}
+	if run := runningRewrapJob(); run != nil {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "A key rotation job is already running").WithDetails(run.snapshot()))
+	}
+
+	activity := GetActivityRegistry().Start(context.Background(), ActivityInfo{
+		Kind:     ActivityRewrap,
+		Username: claims.Username,
+		Path:     "/",
+	})
+	run := &rewrapRun{job: RewrapJob{
+		ID:        activity.ID(),
+		KeyID:     enc.KeyID(),
+		Status:    AdoptRunning,
+		StartedAt: time.Now(),
+	}}
+	registerRewrapJob(run)
+
+	go h.runRewrap(run, activity, enc, claims.UserID, c.RealIP())
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data":    run.snapshot(),
+	})
+}
+
+// GetEncryptionRewrapJob returns progress or the result of a key rotation job
+// @Summary		Re-wrap job status
+// @Description	Returns counters and status of a key rotation job
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Job ID"
+// @Success		200		{object}	docs.SuccessResponse	"Job status"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/encryption/rewrap/{id} [get]
+func (h *Handler) GetEncryptionRewrapJob(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermStorageAdmin); err != nil {
+		return err
+	}
+	rewrapJobsMu.Lock()
+	run := rewrapJobs[c.Param("id")]
+	rewrapJobsMu.Unlock()
+	if run == nil {
+		return RespondError(c, ErrNotFound("Re-wrap job"))
+	}
+	return RespondSuccess(c, run.snapshot())
+}
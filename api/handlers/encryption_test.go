@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupTestEncryption installs a file encryption state with one encrypted folder
+func setupTestEncryption(t *testing.T, dataRoot string, folders ...string) *FileEncryption {
+	t.Helper()
+	key := parseMasterKey("test-master-key")
+	e := &FileEncryption{
+		dataRoot:  dataRoot,
+		masterKey: key,
+		masterID:  masterKeyID(key),
+		keys:      map[string][]byte{masterKeyID(key): key},
+		folders:   make(map[string]EncryptedFolder),
+	}
+	for _, f := range folders {
+		e.folders[f] = EncryptedFolder{Path: f}
+	}
+
+	fileEncryptionMu.Lock()
+	previous := fileEncryption
+	fileEncryption = e
+	fileEncryptionMu.Unlock()
+	t.Cleanup(func() {
+		fileEncryptionMu.Lock()
+		fileEncryption = previous
+		fileEncryptionMu.Unlock()
+	})
+	return e
+}
+
+func TestWriteFileSealed_RoundTrip(t *testing.T) {
+	dataRoot := t.TempDir()
+	setupTestEncryption(t, dataRoot, "shared/HR")
+	dir := filepath.Join(dataRoot, "shared", "HR")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spans several chunks and ends mid-chunk
+	plain := make([]byte, 3*encChunkSize+1234)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+	realPath := filepath.Join(dir, "salaries.xlsx")
+	if err := WriteFileSealed(realPath, plain, 0644); err != nil {
+		t.Fatalf("WriteFileSealed: %v", err)
+	}
+
+	raw, _ := os.ReadFile(realPath)
+	if bytes.Contains(raw, plain[:64]) {
+		t.Fatal("plaintext found on disk")
+	}
+	info, _ := os.Stat(realPath)
+	if got := PlainSize(realPath, info); got != int64(len(plain)) {
+		t.Errorf("PlainSize = %d, want %d", got, len(plain))
+	}
+	if !IsEncryptedEntry(realPath, info) {
+		t.Error("expected file to be reported as encrypted")
+	}
+
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		t.Fatalf("OpenPlain: %v", err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted content does not match")
+	}
+
+	// Random access across a chunk boundary
+	buf := make([]byte, 100)
+	if _, err := f.ReadAt(buf, encChunkSize-50); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(buf, plain[encChunkSize-50:encChunkSize+50]) {
+		t.Error("ReadAt across chunk boundary returned wrong data")
+	}
+}
+
+func TestWriteFileSealed_OutsideEncryptedFolder(t *testing.T) {
+	dataRoot := t.TempDir()
+	setupTestEncryption(t, dataRoot, "shared/HR")
+	dir := filepath.Join(dataRoot, "shared", "Public")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	realPath := filepath.Join(dir, "notes.txt")
+	if err := WriteFileSealed(realPath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := os.ReadFile(realPath); string(raw) != "hello" {
+		t.Errorf("expected plaintext outside encrypted folders, got %q", raw)
+	}
+}
+
+// failingReader returns its data, then an error
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWriteFileSealedFrom_FailedWriteKeepsOriginal(t *testing.T) {
+	dataRoot := t.TempDir()
+	setupTestEncryption(t, dataRoot, "shared/HR")
+	for _, folder := range []string{"Public", "HR"} {
+		dir := filepath.Join(dataRoot, "shared", folder)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		realPath := filepath.Join(dir, "report.docx")
+		if err := WriteFileSealed(realPath, []byte("original"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(realPath, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		err := WriteFileSealedFrom(realPath, &failingReader{data: make([]byte, 3*encChunkSize)}, 0644)
+		if err == nil {
+			t.Fatalf("%s: write of a broken stream succeeded", folder)
+		}
+		if got := readPlain(t, realPath); got != "original" {
+			t.Errorf("%s: content after failed write = %q", folder, got)
+		}
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 1 {
+			t.Errorf("%s: temporary file left behind: %v", folder, entries)
+		}
+
+		// A complete write replaces the content and keeps the mode
+		if err := WriteFileSealedFrom(realPath, bytes.NewReader([]byte("saved")), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := readPlain(t, realPath); got != "saved" {
+			t.Errorf("%s: content after write = %q", folder, got)
+		}
+		if mode := mustStat(t, realPath).Mode().Perm(); mode != 0600 {
+			t.Errorf("%s: mode = %o, want 600", folder, mode)
+		}
+	}
+}
+
+func readPlain(t *testing.T, realPath string) string {
+	t.Helper()
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestOpenPlain_DetectsTruncation(t *testing.T) {
+	dataRoot := t.TempDir()
+	setupTestEncryption(t, dataRoot, "users/alice/hr")
+	dir := filepath.Join(dataRoot, "users", "alice", "hr")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	realPath := filepath.Join(dir, "contract.pdf")
+	if err := WriteFileSealed(realPath, make([]byte, 2*encChunkSize+10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dropping the final chunk leaves a file whose last chunk is not marked final
+	if err := os.Truncate(realPath, int64(encHeaderSize+2*encSealedChunk)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.ReadAll(f); err == nil {
+		t.Error("expected truncated file to fail authentication")
+	}
+}
+
+func TestRewrapFile(t *testing.T) {
+	dataRoot := t.TempDir()
+	e := setupTestEncryption(t, dataRoot, "shared/HR")
+	dir := filepath.Join(dataRoot, "shared", "HR")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	realPath := filepath.Join(dir, "review.docx")
+	if err := WriteFileSealed(realPath, []byte("confidential"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: the old key becomes a previous key
+	newKey := parseMasterKey("rotated-master-key")
+	e.masterKey = newKey
+	e.masterID = masterKeyID(newKey)
+	e.keys[e.masterID] = newKey
+
+	changed, err := e.rewrapFile(realPath)
+	if err != nil || !changed {
+		t.Fatalf("rewrapFile = %v, %v; want true, nil", changed, err)
+	}
+	if changed, _ := e.rewrapFile(realPath); changed {
+		t.Error("second rewrap should be a no-op")
+	}
+
+	// Readable with only the new key
+	e.keys = map[string][]byte{e.masterID: newKey}
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		t.Fatalf("OpenPlain after rewrap: %v", err)
+	}
+	defer f.Close()
+	if got, _ := io.ReadAll(f); string(got) != "confidential" {
+		t.Errorf("got %q after rewrap", got)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 )
@@ -28,6 +29,12 @@ const (
 	ErrCodeNotFound         ErrorCode = "NOT_FOUND"
 	ErrCodeAlreadyExists    ErrorCode = "ALREADY_EXISTS"
 	ErrCodeConflict         ErrorCode = "CONFLICT"
+	ErrCodePreconditionFailed ErrorCode = "PRECONDITION_FAILED"
+	ErrCodeLocked           ErrorCode = "LOCKED"
+	ErrCodeRetentionHold    ErrorCode = "RETENTION_HOLD"
+	ErrCodeDirectoryFull    ErrorCode = "DIRECTORY_FULL"
+	ErrCodeReadOnly         ErrorCode = "READ_ONLY"
+	ErrCodeRateLimited      ErrorCode = "RATE_LIMITED"
 
 	// Storage errors
 	ErrCodeQuotaExceeded    ErrorCode = "QUOTA_EXCEEDED"
@@ -46,6 +53,7 @@ const (
 	ErrCodeInternal         ErrorCode = "INTERNAL_ERROR"
 	ErrCodeDatabaseError    ErrorCode = "DATABASE_ERROR"
 	ErrCodeServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
+	ErrCodeBadGateway       ErrorCode = "BAD_GATEWAY"
 )
 
 // APIError represents a standardized API error response
@@ -79,15 +87,21 @@ func (e *APIError) HTTPStatus() int {
 	switch e.Code {
 	case ErrCodeUnauthorized, ErrCodeInvalidToken, ErrCodeTokenExpired:
 		return http.StatusUnauthorized
-	case ErrCodeForbidden:
+	case ErrCodeForbidden, ErrCodeReadOnly:
 		return http.StatusForbidden
 	case ErrCodeBadRequest, ErrCodeInvalidPath, ErrCodeInvalidFilename,
 		ErrCodePathTraversal, ErrCodeMissingParameter:
 		return http.StatusBadRequest
 	case ErrCodeNotFound:
 		return http.StatusNotFound
-	case ErrCodeAlreadyExists, ErrCodeConflict:
+	case ErrCodeAlreadyExists, ErrCodeConflict, ErrCodeDirectoryFull:
 		return http.StatusConflict
+	case ErrCodePreconditionFailed:
+		return http.StatusPreconditionFailed
+	case ErrCodeLocked, ErrCodeRetentionHold:
+		return http.StatusLocked
+	case ErrCodeRateLimited:
+		return http.StatusTooManyRequests
 	case ErrCodeQuotaExceeded, ErrCodeFileTooLarge:
 		return http.StatusRequestEntityTooLarge
 	case ErrCodeStorageFull:
@@ -98,13 +112,21 @@ func (e *APIError) HTTPStatus() int {
 		return http.StatusInternalServerError
 	case ErrCodeServiceUnavailable:
 		return http.StatusServiceUnavailable
+	case ErrCodeBadGateway:
+		return http.StatusBadGateway
 	default:
 		return http.StatusInternalServerError
 	}
 }
 
-// RespondError sends a standardized error response
+// RespondError sends a standardized error response. A retryAfter detail
+// (seconds) is also sent as the Retry-After header.
 func RespondError(c echo.Context, err *APIError) error {
+	if details, ok := err.Details.(map[string]interface{}); ok {
+		if seconds, ok := details["retryAfter"].(int); ok {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+	}
 	return c.JSON(err.HTTPStatus(), map[string]interface{}{
 		"error":   err.Message,
 		"code":    err.Code,
@@ -220,14 +242,29 @@ func RequireClaims(c echo.Context) (*JWTClaims, error) {
 	return claims, nil
 }
 
-// RequireAdmin checks if the user is an admin and returns claims
+// RequireAdmin checks if the user is a superadmin and returns claims
 func RequireAdmin(c echo.Context) (*JWTClaims, error) {
 	claims := GetClaims(c)
 	if claims == nil {
 		return nil, RespondError(c, ErrUnauthorized(""))
 	}
 	if !claims.IsAdmin {
+		GetAdminRoles().logDenied(c, RoleSuperadmin)
 		return nil, RespondError(c, ErrForbidden("Admin access required"))
 	}
 	return claims, nil
 }
+
+// RequireAdminPermission checks that the user holds every given admin
+// permission and returns claims. Refusals are audit-logged.
+func RequireAdminPermission(c echo.Context, perms ...string) (*JWTClaims, error) {
+	claims := GetClaims(c)
+	if claims == nil {
+		return nil, RespondError(c, ErrUnauthorized(""))
+	}
+	if missing := claims.missingPermission(perms); missing != "" {
+		GetAdminRoles().logDenied(c, missing)
+		return nil, RespondError(c, ErrForbidden("Admin permission required: "+missing))
+	}
+	return claims, nil
+}
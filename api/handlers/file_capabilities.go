@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// File actions clients can offer for a file type
+const (
+	FileActionPreview        = "preview"         // GET /preview, of the type in FileCapability.Preview
+	FileActionOnlyOfficeEdit = "onlyoffice-edit" // OnlyOffice editor, read-only without write access
+	FileActionOnlyOfficeView = "onlyoffice-view"
+	FileActionPDFConvert     = "pdf-convert" // Converted by the OnlyOffice document server
+	FileActionTextEdit       = "text-edit"   // PUT /files/content
+	FileActionNone           = "none"        // Open action only: download the file
+)
+
+// fileActionOrder is the order actions are listed in
+var fileActionOrder = []string{
+	FileActionPreview,
+	FileActionOnlyOfficeEdit,
+	FileActionOnlyOfficeView,
+	FileActionPDFConvert,
+	FileActionTextEdit,
+}
+
+// fileActionExtension matches an override extension: lowercase, no dot
+var fileActionExtension = regexp.MustCompile(`^[a-z0-9_+-]{1,32}$`)
+
+// FileCapability is what the deployment supports for an extension
+type FileCapability struct {
+	Extension     string   `json:"extension"`
+	Preview       string   `json:"preview"` // image, text, video, audio, pdf, email, calendar, contact or none
+	Actions       []string `json:"actions"`
+	DefaultAction string   `json:"defaultAction"` // One of Actions, or none
+	Overridden    bool     `json:"overridden,omitempty"`
+}
+
+// FileCapabilities is the capability map reported to clients
+type FileCapabilities struct {
+	OnlyOffice    bool                      `json:"onlyOffice"`    // Enabled and reachable
+	HEICConverter bool                      `json:"heicConverter"` // HEIC/HEIF previews can be converted
+	Extensions    map[string]FileCapability `json:"extensions"`
+}
+
+// FileActionOverride replaces the actions offered for an extension
+type FileActionOverride struct {
+	Extension     string    `json:"extension"`
+	Actions       []string  `json:"actions"`
+	DefaultAction string    `json:"defaultAction"`
+	UpdatedBy     *string   `json:"updatedBy,omitempty"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// SetFileActionOverrideRequest is the body of PUT /admin/file-actions/:ext
+type SetFileActionOverrideRequest struct {
+	Actions       []string `json:"actions"`
+	DefaultAction string   `json:"defaultAction"` // Empty picks one from actions
+}
+
+// FileCapabilityRegistry computes and caches the capability map. It is
+// rebuilt when OnlyOffice or HEIC converter availability changes, and
+// dropped by Invalidate when settings or overrides change.
+type FileCapabilityRegistry struct {
+	db        *sql.DB
+	mu        sync.Mutex
+	overrides map[string]FileActionOverride // nil until loaded
+	caps      *FileCapabilities
+}
+
+var globalFileCapabilities *FileCapabilityRegistry
+
+// InitFileCapabilities creates the global file capability registry
+func InitFileCapabilities(db *sql.DB) *FileCapabilityRegistry {
+	globalFileCapabilities = &FileCapabilityRegistry{db: db}
+	return globalFileCapabilities
+}
+
+// GetFileCapabilities returns the global file capability registry (nil if not initialized)
+func GetFileCapabilities() *FileCapabilityRegistry {
+	return globalFileCapabilities
+}
+
+// isFileCapabilitySetting reports whether a setting changes the capability map
+func isFileCapabilitySetting(key string) bool {
+	return strings.HasPrefix(key, "onlyoffice_")
+}
+
+// Invalidate drops the cached map and overrides, and rechecks the document
+// server on the next lookup
+func (r *FileCapabilityRegistry) Invalidate() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.caps = nil
+	r.overrides = nil
+	r.mu.Unlock()
+	onlyOfficeHealth.reset()
+	GetListingVersions().InvalidateAll()
+}
+
+// Capabilities returns the capability map for the current deployment
+func (r *FileCapabilityRegistry) Capabilities() *FileCapabilities {
+	onlyOffice := onlyOfficeEnabled() && onlyOfficeHealth.Available()
+	heic := heicConverter() != ""
+	if r == nil {
+		return buildFileCapabilities(onlyOffice, heic, nil)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.caps != nil && r.caps.OnlyOffice == onlyOffice && r.caps.HEICConverter == heic {
+		return r.caps
+	}
+	if r.overrides == nil {
+		overrides, err := loadFileActionOverrides(r.db)
+		if err != nil {
+			log.Printf("[FileCapabilities] Failed to load overrides: %v", err)
+			return buildFileCapabilities(onlyOffice, heic, nil) // Retried on the next lookup
+		}
+		r.overrides = overrides
+	}
+	r.caps = buildFileCapabilities(onlyOffice, heic, r.overrides)
+	return r.caps
+}
+
+// loadFileActionOverrides reads the overrides keyed by extension
+func loadFileActionOverrides(db *sql.DB) (map[string]FileActionOverride, error) {
+	overrides := make(map[string]FileActionOverride)
+	if db == nil {
+		return overrides, nil
+	}
+	rows, err := db.Query(`
+		SELECT extension, actions, default_action, updated_by, updated_at
+		FROM file_action_overrides
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o FileActionOverride
+		var updatedBy sql.NullString
+		if err := rows.Scan(&o.Extension, pq.Array(&o.Actions), &o.DefaultAction, &updatedBy, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if updatedBy.Valid {
+			o.UpdatedBy = &updatedBy.String
+		}
+		overrides[o.Extension] = o
+	}
+	return overrides, rows.Err()
+}
+
+// For returns the capability of an extension (lowercase, without dot)
+func (caps *FileCapabilities) For(ext string) FileCapability {
+	if capability, ok := caps.Extensions[ext]; ok {
+		return capability
+	}
+	return FileCapability{Extension: ext, Preview: "none", Actions: []string{}, DefaultAction: FileActionNone}
+}
+
+// describeFileActions fills in the actions of a listed file; links take
+// those of their target
+func describeFileActions(entry *FileInfo, caps *FileCapabilities) {
+	ext := entry.Extension
+	if entry.IsLink {
+		if entry.LinkTarget == "" || entry.LinkBroken || entry.LinkInaccessible {
+			return
+		}
+		ext = strings.ToLower(strings.TrimPrefix(path.Ext(entry.LinkTarget), "."))
+	}
+	capability := caps.For(ext)
+	entry.Actions, entry.DefaultAction = capability.Actions, capability.DefaultAction
+}
+
+// buildFileCapabilities computes the map for every extension the server
+// knows and every overridden one
+func buildFileCapabilities(onlyOffice, heic bool, overrides map[string]FileActionOverride) *FileCapabilities {
+	caps := &FileCapabilities{OnlyOffice: onlyOffice, HEICConverter: heic, Extensions: make(map[string]FileCapability)}
+	add := func(ext string) {
+		if _, ok := caps.Extensions[ext]; !ok {
+			caps.Extensions[ext] = fileCapability(ext, onlyOffice, heic, overrides)
+		}
+	}
+	for ext := range mimeTypes {
+		add(ext)
+	}
+	for ext := range onlyOfficeDocumentTypes {
+		add(strings.TrimPrefix(ext, "."))
+	}
+	for ext := range overrides {
+		add(ext)
+	}
+	return caps
+}
+
+// previewType mirrors what GetPreview serves for an extension
+func previewType(ext string, heic bool) string {
+	if isConvertedImage("." + ext) {
+		if (ext == "heic" || ext == "heif") && !heic {
+			return "none"
+		}
+		return "image"
+	}
+	if structured := structuredPreviewType(ext); structured != "" {
+		return structured
+	}
+	mimeType := getMimeType(ext)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "text/") || ext == "json" || ext == "md":
+		return "text"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case mimeType == "application/pdf":
+		return "pdf"
+	}
+	return "none"
+}
+
+func fileCapability(ext string, onlyOffice, heic bool, overrides map[string]FileActionOverride) FileCapability {
+	capability := FileCapability{Extension: ext, Preview: previewType(ext, heic)}
+
+	supported := make(map[string]bool)
+	if capability.Preview != "none" {
+		supported[FileActionPreview] = true
+	}
+	if onlyOffice && getOnlyOfficeDocumentType("."+ext) != "" {
+		supported[FileActionOnlyOfficeView] = true
+		if ext != "pdf" {
+			supported[FileActionOnlyOfficeEdit] = true
+			supported[FileActionPDFConvert] = true
+		}
+	}
+	builtin := make(map[string]bool, len(supported))
+	for action := range supported {
+		builtin[action] = true
+	}
+	if capability.Preview == "text" {
+		builtin[FileActionTextEdit] = true
+	}
+	// Any file can be saved as text, but only overrides offer it for types
+	// the server does not know as text
+	supported[FileActionTextEdit] = true
+
+	wanted, defaultAction := builtin, ""
+	if o, ok := overrides[ext]; ok {
+		wanted = make(map[string]bool, len(o.Actions))
+		for _, action := range o.Actions {
+			wanted[action] = true
+		}
+		defaultAction = o.DefaultAction
+		capability.Overridden = true
+	}
+
+	capability.Actions = []string{}
+	for _, action := range fileActionOrder {
+		if wanted[action] && supported[action] {
+			capability.Actions = append(capability.Actions, action)
+		}
+	}
+	capability.DefaultAction = pickDefaultFileAction(capability.Actions, capability.Preview, defaultAction)
+	return capability
+}
+
+// pickDefaultFileAction keeps a configured default the actions allow, else
+// prefers text previews, then the editor, then any preview
+func pickDefaultFileAction(actions []string, preview, configured string) string {
+	has := func(action string) bool {
+		for _, a := range actions {
+			if a == action {
+				return true
+			}
+		}
+		return false
+	}
+	if configured == FileActionNone || (configured != "" && has(configured)) {
+		return configured
+	}
+	switch {
+	case preview == "text" && has(FileActionPreview):
+		return FileActionPreview
+	case has(FileActionOnlyOfficeEdit):
+		return FileActionOnlyOfficeEdit
+	case has(FileActionPreview):
+		return FileActionPreview
+	case has(FileActionOnlyOfficeView):
+		return FileActionOnlyOfficeView
+	}
+	return FileActionNone
+}
+
+// normalizeFileActionExtension lowercases an extension and strips its dot
+func normalizeFileActionExtension(ext string) (string, bool) {
+	ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+	return ext, fileActionExtension.MatchString(ext)
+}
+
+// GetFileCapabilities returns the actions supported per extension
+// @Summary		File capabilities
+// @Description	Returns, per extension, the preview type, the actions this deployment supports (preview, onlyoffice-edit, onlyoffice-view, pdf-convert, text-edit) and the action to run on open (none = download). OnlyOffice actions are only listed while OnlyOffice is enabled and its document server is reachable. Admin overrides are applied. Extensions not listed support no actions.
+// @Tags		Files
+// @Produce		json
+// @Success		200	{object}	FileCapabilities	"Capability map"
+// @Router		/files/capabilities [get]
+func (h *Handler) GetFileCapabilities(c echo.Context) error {
+	return RespondSuccess(c, GetFileCapabilities().Capabilities())
+}
+
+// ListFileActionOverrides lists the admin file action overrides
+// @Summary		List file action overrides
+// @Description	Lists the per-extension overrides of the actions reported by /files/capabilities.
+// @Tags		Admin
+// @Produce		json
+// @Success		200	{array}		FileActionOverride	"Overrides"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/file-actions [get]
+func (h *Handler) ListFileActionOverrides(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermSettingsWrite)
+	if claims == nil {
+		return err
+	}
+	overrides, err := loadFileActionOverrides(h.db)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load file action overrides", err))
+	}
+	list := make([]FileActionOverride, 0, len(overrides))
+	for _, o := range overrides {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Extension < list[j].Extension })
+	return RespondSuccess(c, list)
+}
+
+// SetFileActionOverride sets the actions offered for an extension
+// @Summary		Set file action override
+// @Description	Replaces the actions offered for an extension and the action run on open (none = download; empty picks one). Actions the deployment cannot perform for the extension, such as OnlyOffice actions for formats OnlyOffice does not open, are dropped from /files/capabilities; text-edit can be offered for any extension.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		ext		path		string							true	"Extension, without dot"
+// @Param		request	body		SetFileActionOverrideRequest	true	"Actions"
+// @Success		200		{object}	FileActionOverride	"Override"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid extension or action"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/file-actions/{ext} [put]
+func (h *Handler) SetFileActionOverride(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermSettingsWrite)
+	if claims == nil {
+		return err
+	}
+	ext, ok := normalizeFileActionExtension(c.Param("ext"))
+	if !ok {
+		return RespondError(c, ErrBadRequest("Invalid extension"))
+	}
+	var req SetFileActionOverrideRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+
+	known := make(map[string]bool, len(fileActionOrder))
+	for _, action := range fileActionOrder {
+		known[action] = true
+	}
+	seen := make(map[string]bool, len(req.Actions))
+	actions := make([]string, 0, len(req.Actions))
+	for _, action := range req.Actions {
+		if !known[action] {
+			return RespondError(c, ErrBadRequest("Unknown action: "+action))
+		}
+		if !seen[action] {
+			seen[action] = true
+			actions = append(actions, action)
+		}
+	}
+	if req.DefaultAction == "" {
+		req.DefaultAction = pickDefaultFileAction(actions, previewType(ext, true), "")
+	} else if req.DefaultAction != FileActionNone && !seen[req.DefaultAction] {
+		return RespondError(c, ErrBadRequest("defaultAction must be none or one of actions"))
+	}
+
+	o := FileActionOverride{Extension: ext, Actions: actions, DefaultAction: req.DefaultAction, UpdatedBy: &claims.UserID}
+	err = h.db.QueryRow(`
+		INSERT INTO file_action_overrides (extension, actions, default_action, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (extension) DO UPDATE
+		SET actions = EXCLUDED.actions, default_action = EXCLUDED.default_action,
+			updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		RETURNING updated_at
+	`, ext, pq.Array(actions), req.DefaultAction, claims.UserID).Scan(&o.UpdatedAt)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("save file action override", err))
+	}
+	GetFileCapabilities().Invalidate()
+
+	if h.auditHandler != nil {
+		_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminSettingsUpdate, "file_actions/"+ext, map[string]interface{}{
+			"actions":       actions,
+			"defaultAction": req.DefaultAction,
+		})
+	}
+	return RespondSuccess(c, o)
+}
+
+// DeleteFileActionOverride restores the built-in actions of an extension
+// @Summary		Remove file action override
+// @Description	Removes the override of an extension so its built-in actions apply again.
+// @Tags		Admin
+// @Produce		json
+// @Param		ext	path		string	true	"Extension, without dot"
+// @Success		200	{object}	docs.SuccessResponse	"Removed"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/file-actions/{ext} [delete]
+func (h *Handler) DeleteFileActionOverride(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermSettingsWrite)
+	if claims == nil {
+		return err
+	}
+	ext, ok := normalizeFileActionExtension(c.Param("ext"))
+	if !ok {
+		return RespondError(c, ErrBadRequest("Invalid extension"))
+	}
+	result, err := h.db.Exec("DELETE FROM file_action_overrides WHERE extension = $1", ext)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("remove file action override", err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return RespondError(c, ErrNotFound("File action override"))
+	}
+	GetFileCapabilities().Invalidate()
+
+	if h.auditHandler != nil {
+		_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminSettingsUpdate, "file_actions/"+ext, map[string]interface{}{
+			"removed": true,
+		})
+	}
+	return RespondSuccess(c, map[string]string{"message": "File action override removed"})
+}
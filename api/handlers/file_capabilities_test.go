@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// withOnlyOffice pins the cached document server health for the test
+func withOnlyOffice(t *testing.T, available bool) {
+	t.Helper()
+	onlyOfficeHealth.set(available)
+	t.Cleanup(onlyOfficeHealth.reset)
+}
+
+func TestBuildFileCapabilities(t *testing.T) {
+	overrides := map[string]FileActionOverride{
+		"hwp":  {Extension: "hwp", Actions: []string{FileActionOnlyOfficeEdit}, DefaultAction: FileActionOnlyOfficeEdit},
+		"log":  {Extension: "log", Actions: []string{FileActionTextEdit}, DefaultAction: FileActionTextEdit},
+		"docx": {Extension: "docx", Actions: []string{FileActionOnlyOfficeView, FileActionPDFConvert}},
+		"mp4":  {Extension: "mp4", Actions: []string{FileActionPreview}, DefaultAction: FileActionNone},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		onlyOffice bool
+		ext        string
+		preview    string
+		actions    []string
+		open       string
+	}{
+		{"office document", true, "xlsx", "none", []string{FileActionOnlyOfficeEdit, FileActionOnlyOfficeView, FileActionPDFConvert}, FileActionOnlyOfficeEdit},
+		{"office document without OnlyOffice", false, "xlsx", "none", []string{}, FileActionNone},
+		{"pdf", true, "pdf", "pdf", []string{FileActionPreview, FileActionOnlyOfficeView}, FileActionPreview},
+		{"text", true, "txt", "text", []string{FileActionPreview, FileActionOnlyOfficeEdit, FileActionOnlyOfficeView, FileActionPDFConvert, FileActionTextEdit}, FileActionPreview},
+		{"image", true, "png", "image", []string{FileActionPreview}, FileActionPreview},
+		{"unknown", true, "exe", "none", []string{}, FileActionNone},
+		{"override OnlyOffice cannot open", true, "hwp", "none", []string{}, FileActionNone},
+		{"override adds text editing", false, "log", "none", []string{FileActionTextEdit}, FileActionTextEdit},
+		{"override narrows actions", true, "docx", "none", []string{FileActionOnlyOfficeView, FileActionPDFConvert}, FileActionOnlyOfficeView},
+		{"override downloads on open", true, "mp4", "video", []string{FileActionPreview}, FileActionNone},
+	} {
+		got := buildFileCapabilities(tc.onlyOffice, false, overrides).For(tc.ext)
+		if got.Preview != tc.preview || !reflect.DeepEqual(got.Actions, tc.actions) || got.DefaultAction != tc.open {
+			t.Errorf("%s: got %s %v open %s, want %s %v open %s", tc.name, got.Preview, got.Actions, got.DefaultAction, tc.preview, tc.actions, tc.open)
+		}
+	}
+
+	if heic := buildFileCapabilities(true, false, nil).For("heic"); heic.Preview != "none" {
+		t.Errorf("heic preview without a converter = %s", heic.Preview)
+	}
+	if heic := buildFileCapabilities(true, true, nil).For("heic"); heic.Preview != "image" {
+		t.Errorf("heic preview with a converter = %s", heic.Preview)
+	}
+}
+
+func TestFileCapabilities_SettingInvalidates(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	withOnlyOffice(t, true)
+	sh := useCachedSettings(t, map[string]string{settingOnlyOfficeEnabled: "true", settingDirEntriesWarn: "10000", settingDirEntriesMax: "100000"})
+	sh.db = tc.DB
+	prev := globalFileCapabilities
+	InitFileCapabilities(nil)
+	t.Cleanup(func() { globalFileCapabilities = prev })
+
+	if !GetFileCapabilities().Capabilities().OnlyOffice {
+		t.Fatal("OnlyOffice should be offered")
+	}
+
+	// Turning OnlyOffice off takes effect on the next lookup
+	tc.Mock.ExpectExec("INSERT INTO system_settings").
+		WithArgs(settingOnlyOfficeEnabled, "false", "u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectQuery("SELECT value FROM system_settings").WithArgs(settingOnlyOfficeEnabled).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow("false"))
+	req, _ := NewJSONRequest(http.MethodPut, "/api/admin/settings", map[string]interface{}{
+		"settings": map[string]string{settingOnlyOfficeEnabled: "false"},
+	})
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "admin", true)
+	if err := sh.UpdateSettings(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if globalFileCapabilities.caps != nil {
+		t.Error("capability cache was not dropped")
+	}
+	caps := GetFileCapabilities().Capabilities()
+	if caps.OnlyOffice || len(caps.For("docx").Actions) != 0 {
+		t.Errorf("OnlyOffice still offered: %+v", caps.For("docx"))
+	}
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListFiles_IncludeActions(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+	withOnlyOffice(t, true)
+	useCachedSettings(t, map[string]string{settingOnlyOfficeEnabled: "true", settingDirEntriesWarn: "10000", settingDirEntriesMax: "100000"})
+	prev := globalFileCapabilities
+	InitFileCapabilities(nil)
+	t.Cleanup(func() { globalFileCapabilities = prev })
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	ftc.CreateTestFile(t, filepath.Join(userDir, "report.docx"), []byte("doc"))
+	ftc.CreateTestFile(t, filepath.Join(userDir, "form.hwp"), []byte("hwp"))
+	if err := os.Mkdir(filepath.Join(userDir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) map[string]FileInfo {
+		req, _ := NewJSONRequest(http.MethodGet, "/api/files?path=/home"+query, nil)
+		rec := httptest.NewRecorder()
+		c := CreateAuthenticatedContext(ftc.Echo, rec, req, "1", "testuser", false)
+		if err := ftc.Handler.ListFiles(c); err != nil {
+			t.Fatal(err)
+		}
+		AssertStatus(t, rec, http.StatusOK)
+		var resp ListFilesResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		files := make(map[string]FileInfo)
+		for _, f := range resp.Files {
+			files[f.Name] = f
+		}
+		return files
+	}
+
+	files := list("&includeActions=true")
+	if doc := files["report.docx"]; doc.DefaultAction != FileActionOnlyOfficeEdit || len(doc.Actions) != 3 {
+		t.Errorf("report.docx: %v open %s", doc.Actions, doc.DefaultAction)
+	}
+	if hwp := files["form.hwp"]; hwp.DefaultAction != FileActionNone || len(hwp.Actions) != 0 {
+		t.Errorf("form.hwp: %v open %s", hwp.Actions, hwp.DefaultAction)
+	}
+	if dir := files["docs"]; dir.DefaultAction != "" {
+		t.Errorf("folder has open action %s", dir.DefaultAction)
+	}
+
+	if doc := list("")["report.docx"]; doc.Actions != nil || doc.DefaultAction != "" {
+		t.Error("actions listed without includeActions")
+	}
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// fileChecksumQueueSize bounds the files waiting for their checksum
+const fileChecksumQueueSize = 1000
+
+// FileChecksum is the SHA-256 of a file's contents at a size and
+// modification time. Encrypted files are hashed decrypted.
+type FileChecksum struct {
+	Path       string    `json:"path"`
+	Algorithm  string    `json:"algorithm"`
+	Checksum   string    `json:"checksum"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modifiedAt"`
+	ComputedAt time.Time `json:"computedAt"`
+	Cached     bool      `json:"cached"` // From file_checksums rather than hashed for this request
+}
+
+// FileChecksums keeps whole-file SHA-256 checksums in file_checksums,
+// keyed by data-root relative path and valid for the size and modification
+// time they were computed at. Completed uploads are hashed in the
+// background; other files on first request. Writes through the API and
+// changes the watcher sees drop a file's row; moves carry it along.
+type FileChecksums struct {
+	db       *sql.DB
+	dataRoot string
+	queue    chan string
+}
+
+var globalFileChecksums *FileChecksums
+
+// InitFileChecksums creates the global checksum store and starts its hasher
+func InitFileChecksums(db *sql.DB, dataRoot string) *FileChecksums {
+	fc := &FileChecksums{db: db, dataRoot: dataRoot, queue: make(chan string, fileChecksumQueueSize)}
+	globalFileChecksums = fc
+	go fc.work()
+	return fc
+}
+
+// GetFileChecksums returns the global checksum store (nil if not initialized)
+func GetFileChecksums() *FileChecksums {
+	return globalFileChecksums
+}
+
+// rel returns the data-root relative path of a home or shared drive item,
+// "" for anything else
+func (fc *FileChecksums) rel(realPath string) string {
+	rel, err := dataRootRel(fc.dataRoot, realPath)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "users/") && !strings.HasPrefix(rel, "shared/") {
+		return ""
+	}
+	return rel
+}
+
+// Queue hashes a file in the background, such as a completed upload
+func (fc *FileChecksums) Queue(realPath string) {
+	if fc == nil || fc.rel(realPath) == "" {
+		return
+	}
+	select {
+	case fc.queue <- realPath:
+	default:
+		log.Printf("[Checksums] Queue full, %s is hashed on request", realPath)
+	}
+}
+
+// work hashes queued files one at a time, yielding to foreground requests
+func (fc *FileChecksums) work() {
+	for realPath := range fc.queue {
+		pace := GetBackgroundPacer().Begin("file-checksum", PacePriorityMaintenance)
+		if _, err := fc.Get(context.Background(), realPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[Checksums] Failed to hash %s: %v", realPath, err)
+		}
+		pace.End()
+	}
+}
+
+// Get returns a file's checksum, computing and storing it unless the stored
+// one matches the file's size and modification time
+func (fc *FileChecksums) Get(ctx context.Context, realPath string) (FileChecksum, error) {
+	sum := FileChecksum{Algorithm: "sha256"}
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return sum, err
+	}
+	if info.IsDir() {
+		return sum, errors.New("not a file")
+	}
+	sum.Size = PlainSize(realPath, info)
+	// PostgreSQL keeps microseconds
+	sum.ModifiedAt = info.ModTime().Truncate(time.Microsecond)
+
+	rel := ""
+	if fc != nil {
+		rel = fc.rel(realPath)
+	}
+	if rel != "" {
+		var size int64
+		var mtime time.Time
+		err := fc.db.QueryRowContext(ctx, `
+			SELECT size, mtime, sha256, computed_at FROM file_checksums WHERE path = $1
+		`, rel).Scan(&size, &mtime, &sum.Checksum, &sum.ComputedAt)
+		if err == nil && size == info.Size() && mtime.Equal(sum.ModifiedAt) {
+			sum.Cached = true
+			return sum, nil
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return sum, err
+		}
+	}
+
+	if sum.Checksum, err = hashFile(ctx, realPath); err != nil {
+		return sum, err
+	}
+	sum.ComputedAt = time.Now()
+
+	// A file written while it was hashed is hashed again next time
+	if after, err := os.Stat(realPath); err != nil || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		return sum, nil
+	}
+	if rel != "" {
+		if _, err := fc.db.ExecContext(ctx, `
+			INSERT INTO file_checksums (path, size, mtime, sha256, computed_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (path) DO UPDATE SET
+				size = EXCLUDED.size, mtime = EXCLUDED.mtime,
+				sha256 = EXCLUDED.sha256, computed_at = EXCLUDED.computed_at
+		`, rel, info.Size(), sum.ModifiedAt, sum.Checksum, sum.ComputedAt); err != nil {
+			log.Printf("[Checksums] Failed to store %s: %v", rel, err)
+		}
+	}
+	return sum, nil
+}
+
+// Find returns the data-root relative paths of stored files with a
+// checksum, at most limit, most recently hashed first. Rows may be stale;
+// callers confirm with Get.
+func (fc *FileChecksums) Find(ctx context.Context, checksum string, limit int) ([]string, error) {
+	if fc == nil {
+		return nil, nil
+	}
+	rows, err := fc.db.QueryContext(ctx, `
+		SELECT path FROM file_checksums WHERE sha256 = $1 ORDER BY computed_at DESC LIMIT $2
+	`, checksum, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var rel string
+		if err := rows.Scan(&rel); err != nil {
+			return nil, err
+		}
+		paths = append(paths, rel)
+	}
+	return paths, rows.Err()
+}
+
+// hashFile returns the hex SHA-256 of a file's plain contents, stopping
+// when ctx ends
+func hashFile(ctx context.Context, realPath string) (string, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	buf := make([]byte, 1<<20)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := f.Read(buf)
+		h.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// MovePath follows a rename or move with the rows of the item and below
+func (fc *FileChecksums) MovePath(oldRealPath, newRealPath string) {
+	if fc == nil {
+		return
+	}
+	oldRel, newRel := fc.rel(oldRealPath), fc.rel(newRealPath)
+	switch {
+	case oldRel == "":
+		return
+	case newRel == "":
+		fc.ForgetTree(oldRealPath)
+		return
+	}
+	fc.ForgetTree(newRealPath)
+	if _, err := fc.db.Exec(`
+		UPDATE file_checksums
+		SET path = $2 || substr(path, length($1) + 1)
+		WHERE path = $1 OR starts_with(path, $1 || '/')
+	`, oldRel, newRel); err != nil {
+		log.Printf("[Checksums] Failed to move %s -> %s: %v", oldRel, newRel, err)
+	}
+}
+
+// Changed drops the row of a created or written file unless it is for the
+// file's current size and modification time, as after an upload hashed
+// before the watcher reported it
+func (fc *FileChecksums) Changed(realPath string) {
+	if fc == nil {
+		return
+	}
+	info, err := os.Stat(realPath)
+	if err != nil {
+		fc.ForgetTree(realPath)
+		return
+	}
+	rel := fc.rel(realPath)
+	if rel == "" || info.IsDir() {
+		return
+	}
+	if _, err := fc.db.Exec(`
+		DELETE FROM file_checksums WHERE path = $1 AND (size <> $2 OR mtime <> $3)
+	`, rel, info.Size(), info.ModTime().Truncate(time.Microsecond)); err != nil {
+		log.Printf("[Checksums] Failed to invalidate %s: %v", rel, err)
+	}
+}
+
+// ForgetTree drops the rows of a changed or deleted item and below
+func (fc *FileChecksums) ForgetTree(realPath string) {
+	if fc == nil {
+		return
+	}
+	rel := fc.rel(realPath)
+	if rel == "" {
+		return
+	}
+	if _, err := fc.db.Exec(`
+		DELETE FROM file_checksums WHERE path = $1 OR starts_with(path, $1 || '/')
+	`, rel); err != nil {
+		log.Printf("[Checksums] Failed to forget %s: %v", rel, err)
+	}
+}
+
+// GetFileChecksum returns the SHA-256 of a file
+// @Summary		File checksum
+// @Description	Returns the SHA-256 of a file's contents, to compare with a local copy. It is stored for the file's size and modification time, so only the first request after a change reads the whole file. Encrypted files are hashed decrypted.
+// @Tags		Files
+// @Produce		json
+// @Param		path	path		string	true	"File path"
+// @Success		200		{object}	docs.SuccessResponse	"path, algorithm, checksum, size, modifiedAt, computedAt and cached in data"
+// @Failure		400		{object}	docs.ErrorResponse	"Not a home or shared drive file"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"File not found"
+// @Security	BearerAuth
+// @Router		/files/checksum/{path} [get]
+func (h *Handler) GetFileChecksum(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	requestPath := c.Param("*")
+	if decodedPath, err := url.PathUnescape(requestPath); err == nil {
+		requestPath = decodedPath
+	}
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	virtualPath := "/" + requestPath
+
+	realPath, storageType, displayPath, err := h.resolvePath(virtualPath, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if storageType != StorageHome && storageType != StorageShared {
+		return RespondError(c, ErrBadRequest("Checksums are only available for home and shared drive files"))
+	}
+	if storageType == StorageShared && !h.CanReadSharedDrive(claims.UserID, displayPath) {
+		return RespondError(c, ErrForbidden("No permission to access this path"))
+	}
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return RespondError(c, ErrNotFound("File"))
+	}
+	if info.IsDir() {
+		return RespondError(c, ErrBadRequest("Checksums are only available for files"))
+	}
+
+	sum, err := GetFileChecksums().Get(c.Request().Context(), realPath)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return RespondError(c, ErrOperationFailed("compute checksum", err))
+	}
+	sum.Path = displayPath
+	return RespondSuccess(c, sum)
+}
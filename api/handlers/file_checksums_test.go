@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestFileChecksums_Get(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	fc := &FileChecksums{db: tc.DB, dataRoot: dataRoot}
+
+	path := filepath.Join(dataRoot, "users", "alice", "a.txt")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	mtime := info.ModTime().Truncate(time.Microsecond)
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	// No row yet: hashed and stored
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT size, mtime, sha256, computed_at FROM file_checksums WHERE path = $1`)).
+		WithArgs("users/alice/a.txt").
+		WillReturnRows(sqlmock.NewRows([]string{"size", "mtime", "sha256", "computed_at"}))
+	tc.Mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO file_checksums`)).
+		WithArgs("users/alice/a.txt", int64(5), mtime, want, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sum, err := fc.Get(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Checksum != want || sum.Cached {
+		t.Errorf("sum = %+v, want freshly computed %s", sum, want)
+	}
+
+	// A row for the file's size and mtime is used as is
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT size, mtime, sha256, computed_at FROM file_checksums WHERE path = $1`)).
+		WithArgs("users/alice/a.txt").
+		WillReturnRows(sqlmock.NewRows([]string{"size", "mtime", "sha256", "computed_at"}).
+			AddRow(int64(5), mtime, "cached", time.Now()))
+	if sum, err := fc.Get(context.Background(), path); err != nil || sum.Checksum != "cached" || !sum.Cached {
+		t.Errorf("sum = %+v, err = %v, want stored row", sum, err)
+	}
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileChecksums_MovePath(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	fc := &FileChecksums{db: tc.DB, dataRoot: dataRoot}
+
+	tc.Mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM file_checksums WHERE path = $1 OR starts_with(path, $1 || '/')`)).
+		WithArgs("shared/Team/docs").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec(regexp.QuoteMeta(`UPDATE file_checksums`)).
+		WithArgs("users/alice/docs", "shared/Team/docs").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	fc.MovePath(filepath.Join(dataRoot, "users", "alice", "docs"), filepath.Join(dataRoot, "shared", "Team", "docs"))
+
+	// Items outside home and shared drives have no rows
+	fc.MovePath(filepath.Join(dataRoot, ".trash", "x"), filepath.Join(dataRoot, "users", "alice", "x"))
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
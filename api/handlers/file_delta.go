@@ -0,0 +1,610 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Delta updates replace a large file by sending only what changed, like
+// rsync. The client fetches the block signatures of the current file, finds
+// the blocks it still contains with the rolling checksum, and sends a delta:
+// a stream of copy instructions for those blocks and literal data for
+// everything else. The server rebuilds the file from the delta into a temp
+// file next to it, verifies the SHA-256 of the result and renames it into
+// place.
+//
+// The delta stream starts with deltaMagic, followed by operations:
+//
+//	'C' uint64 block, uint32 count   copy count blocks of the current file
+//	'L' uint32 length, data          literal data, at most deltaMaxLiteral bytes
+//	'E'                              end of the delta
+//
+// Integers are big-endian.
+const (
+	deltaMagic      = "FHD1"
+	deltaOpCopy     = 'C'
+	deltaOpLiteral  = 'L'
+	deltaOpEnd      = 'E'
+	deltaMaxLiteral = 64 * 1024 * 1024
+)
+
+const (
+	// deltaMinBlockSize and deltaMaxBlockSize bound the signature block size
+	deltaMinBlockSize = 1024
+	deltaMaxBlockSize = 16 * 1024 * 1024
+	// deltaTargetBlocks is roughly how many blocks the default block size
+	// splits a file into, so signatures of large files stay small
+	deltaTargetBlocks = 65536
+	// deltaMaxChunkSize limits the delta data sent in one request
+	deltaMaxChunkSize = 256 * 1024 * 1024
+	// deltaSessionTTL is how long an unfinished delta session can be resumed
+	deltaSessionTTL = 24 * time.Hour
+)
+
+// Delta upload request headers
+const (
+	headerDeltaSession   = "X-Delta-Session"
+	headerDeltaOffset    = "X-Delta-Offset"
+	headerDeltaBlockSize = "X-Delta-Block-Size"
+	headerDeltaComplete  = "X-Delta-Complete"
+	headerDeltaChecksum  = "X-Delta-Checksum"
+)
+
+// BlockSignature is the weak rolling checksum and SHA-256 of one block
+type BlockSignature struct {
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+// rollingChecksum is the rsync weak checksum of a window of bytes: a is the
+// sum of the bytes and b the sum of the running sums, both mod 2^16. It can
+// be moved forward one byte at a time.
+type rollingChecksum struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRollingChecksum(block []byte) rollingChecksum {
+	r := rollingChecksum{n: uint32(len(block))}
+	for i, c := range block {
+		r.a += uint32(c)
+		r.b += uint32(len(block)-i) * uint32(c)
+	}
+	r.a &= 0xffff
+	r.b &= 0xffff
+	return r
+}
+
+// roll drops out from the front of the window and appends in
+func (r *rollingChecksum) roll(out, in byte) {
+	r.a = (r.a - uint32(out) + uint32(in)) & 0xffff
+	r.b = (r.b - r.n*uint32(out) + r.a) & 0xffff
+}
+
+func (r rollingChecksum) sum() uint32 {
+	return r.b<<16 | r.a
+}
+
+// defaultDeltaBlockSize picks a power of two block size that splits a file
+// of size bytes into about deltaTargetBlocks blocks
+func defaultDeltaBlockSize(size int64) int {
+	blockSize := 4096
+	for int64(blockSize)*deltaTargetBlocks < size && blockSize < deltaMaxBlockSize {
+		blockSize *= 2
+	}
+	return blockSize
+}
+
+// deltaBlockCount returns how many blocks a file of size bytes has
+func deltaBlockCount(size int64, blockSize int) int64 {
+	return (size + int64(blockSize) - 1) / int64(blockSize)
+}
+
+// parseDeltaBlockSize parses a requested block size
+func parseDeltaBlockSize(value string) (int, error) {
+	blockSize, err := strconv.Atoi(value)
+	if err != nil || blockSize < deltaMinBlockSize || blockSize > deltaMaxBlockSize {
+		return 0, fmt.Errorf("block size must be between %d and %d bytes", deltaMinBlockSize, deltaMaxBlockSize)
+	}
+	return blockSize, nil
+}
+
+// deltaSession is an unfinished delta upload. The delta data received so far
+// is staged next to its description until the client completes it.
+type deltaSession struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // Virtual path of the file
+	UserID    string    `json:"userId"`
+	BaseETag  string    `json:"baseEtag"` // ETag of the file the signature was taken from
+	BlockSize int       `json:"blockSize"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	dir string
+}
+
+// deltaSessionsDir returns where delta sessions are staged
+func (h *Handler) deltaSessionsDir() string {
+	return filepath.Join(h.dataRoot, uploadStagingDirName, "delta")
+}
+
+func (s *deltaSession) dataPath() string {
+	return filepath.Join(s.dir, "delta")
+}
+
+// offset returns how many bytes of delta data the session holds
+func (s *deltaSession) offset() int64 {
+	info, err := os.Stat(s.dataPath())
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// createDeltaSession starts a session and clears out expired ones
+func (h *Handler) createDeltaSession(virtualPath, userID, baseETag string, blockSize int) (*deltaSession, error) {
+	root := h.deltaSessionsDir()
+	if entries, err := os.ReadDir(root); err == nil {
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > deltaSessionTTL {
+				os.RemoveAll(filepath.Join(root, entry.Name()))
+			}
+		}
+	}
+
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return nil, err
+	}
+	s := &deltaSession{
+		ID:        hex.EncodeToString(token[:]),
+		Path:      virtualPath,
+		UserID:    userID,
+		BaseETag:  baseETag,
+		BlockSize: blockSize,
+		CreatedAt: time.Now(),
+	}
+	s.dir = filepath.Join(root, s.ID)
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	meta, _ := json.Marshal(s)
+	if err := os.WriteFile(filepath.Join(s.dir, "session.json"), meta, 0644); err != nil {
+		os.RemoveAll(s.dir)
+		return nil, err
+	}
+	if err := os.WriteFile(s.dataPath(), nil, 0644); err != nil {
+		os.RemoveAll(s.dir)
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadDeltaSession returns a session of the user for the file, or nil
+func (h *Handler) loadDeltaSession(id, virtualPath, userID string) *deltaSession {
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return nil
+	}
+	dir := filepath.Join(h.deltaSessionsDir(), id)
+	meta, err := os.ReadFile(filepath.Join(dir, "session.json"))
+	if err != nil {
+		return nil
+	}
+	var s deltaSession
+	if json.Unmarshal(meta, &s) != nil || s.Path != virtualPath || s.UserID != userID ||
+		time.Since(s.CreatedAt) > deltaSessionTTL {
+		return nil
+	}
+	s.dir = dir
+	return &s
+}
+
+// GetFileSignature returns the block signatures of a file
+// @Summary		File block signatures
+// @Description	Returns the rolling checksum and SHA-256 of each block of a file, the file's ETag and its SHA-256, for computing a delta to upload with PATCH /files/delta. Requires write access to the file. The response is streamed.
+// @Tags		Files
+// @Produce		json
+// @Param		path		path		string	true	"File path"
+// @Param		blockSize	query		int		false	"Block size in bytes (1 KiB to 16 MiB); by default about 65536 blocks per file"
+// @Success		200		{object}	docs.SuccessResponse	"size, blockSize, blockCount, etag, blocks and sha256 in data"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"File not found"
+// @Security	BearerAuth
+// @Router		/files/signature/{path} [get]
+func (h *Handler) GetFileSignature(c echo.Context) error {
+	requestPath := c.Param("*")
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	target, apiErr := h.resolveEditableFile("/"+requestPath, GetClaims(c))
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if GetFileEncryption().InEncryptedFolder(target.realPath) || IsEncryptedFile(target.realPath) {
+		return RespondError(c, ErrBadRequest("Delta updates are not supported for encrypted files"))
+	}
+
+	f, err := os.Open(target.realPath)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("open file", err))
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("access file", err))
+	}
+
+	blockSize := defaultDeltaBlockSize(info.Size())
+	if value := c.QueryParam("blockSize"); value != "" {
+		if blockSize, err = parseDeltaBlockSize(value); err != nil {
+			return RespondError(c, ErrBadRequest(err.Error()))
+		}
+	}
+	etag := GenerateETag(target.realPath, info.ModTime(), info.Size())
+
+	// Signatures of large files are long, so the JSON is written as the
+	// file is read; the whole-file checksum comes after the blocks
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	res.Header().Set("ETag", etag)
+	res.WriteHeader(http.StatusOK)
+	w := bufio.NewWriterSize(res, 64*1024)
+	fmt.Fprintf(w, `{"success":true,"data":{"size":%d,"blockSize":%d,"blockCount":%d,"etag":%q,"blocks":[`,
+		info.Size(), blockSize, deltaBlockCount(info.Size(), blockSize), etag)
+
+	ctx := c.Request().Context()
+	whole := sha256.New()
+	block := make([]byte, blockSize)
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(f, block)
+		if n > 0 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			whole.Write(block[:n])
+			strong := sha256.Sum256(block[:n])
+			fmt.Fprintf(w, `{"weak":%d,"strong":"%x"}`, newRollingChecksum(block[:n]).sum(), strong)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil || ctx.Err() != nil {
+			// Headers are sent; a truncated body is the only way left to fail
+			return w.Flush()
+		}
+	}
+	fmt.Fprintf(w, `],"sha256":"%x"}}`, whole.Sum(nil))
+	return w.Flush()
+}
+
+// deltaResult summarises an applied delta
+type deltaResult struct {
+	Size         int64  `json:"size"`
+	CopiedBytes  int64  `json:"copiedBytes"`
+	LiteralBytes int64  `json:"literalBytes"`
+	SHA256       string `json:"sha256"`
+}
+
+// applyDelta rebuilds a file from base and a delta stream into out.
+// Copy instructions must refer to blocks of base.
+func applyDelta(base io.ReaderAt, baseSize int64, blockSize int, delta io.Reader, out io.Writer) (*deltaResult, error) {
+	r := bufio.NewReaderSize(delta, 64*1024)
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != deltaMagic {
+		return nil, errors.New("delta does not start with " + deltaMagic)
+	}
+
+	blockCount := deltaBlockCount(baseSize, blockSize)
+	result := &deltaResult{}
+	var header [12]byte
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New("delta ends without an end marker")
+		}
+		switch op {
+		case deltaOpCopy:
+			if _, err := io.ReadFull(r, header[:12]); err != nil {
+				return nil, errors.New("truncated copy instruction")
+			}
+			first := binary.BigEndian.Uint64(header[:8])
+			count := uint64(binary.BigEndian.Uint32(header[8:12]))
+			if count == 0 || first >= uint64(blockCount) || count > uint64(blockCount)-first {
+				return nil, fmt.Errorf("copy of blocks %d+%d is outside the file's %d blocks", first, count, blockCount)
+			}
+			start := int64(first) * int64(blockSize)
+			length := min(int64(count)*int64(blockSize), baseSize-start)
+			n, err := io.Copy(out, io.NewSectionReader(base, start, length))
+			if err != nil {
+				return nil, err
+			}
+			if n != length {
+				return nil, errors.New("file changed while the delta was applied")
+			}
+			result.CopiedBytes += n
+		case deltaOpLiteral:
+			if _, err := io.ReadFull(r, header[:4]); err != nil {
+				return nil, errors.New("truncated literal instruction")
+			}
+			length := int64(binary.BigEndian.Uint32(header[:4]))
+			if length == 0 || length > deltaMaxLiteral {
+				return nil, fmt.Errorf("literal length must be between 1 and %d bytes", deltaMaxLiteral)
+			}
+			n, err := io.CopyN(out, r, length)
+			if err != nil {
+				if n < length && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+					return nil, errors.New("truncated literal data")
+				}
+				return nil, err
+			}
+			result.LiteralBytes += n
+		case deltaOpEnd:
+			if _, err := r.ReadByte(); err != io.EOF {
+				return nil, errors.New("data after the end marker")
+			}
+			result.Size = result.CopiedBytes + result.LiteralBytes
+			return result, nil
+		default:
+			return nil, fmt.Errorf("unknown delta instruction 0x%02x", op)
+		}
+	}
+}
+
+// PatchFileDelta uploads a delta against a file's block signatures
+// @Summary		Delta file update
+// @Description	Updates a file from a delta computed against its block signatures (GET /files/signature). The first request sends If-Match with the signature's ETag and X-Delta-Block-Size, and gets an X-Delta-Session ID back; the delta can be sent in several requests, each with the session ID and its X-Delta-Offset in the delta. A request with X-Delta-Complete: true and X-Delta-Checksum (SHA-256 hex of the resulting file) applies it: the result is built in a temp file, verified and renamed over the file. Quota is charged for the change in size. An interrupted upload resumes from the offset a body-less request reports.
+// @Tags		Files
+// @Accept		octet-stream
+// @Produce		json
+// @Param		path				path		string	true	"File path"
+// @Param		If-Match			header		string	false	"ETag of the signature (first request)"
+// @Param		X-Delta-Block-Size	header		int		false	"Block size of the signature (first request)"
+// @Param		X-Delta-Session		header		string	false	"Session ID (later requests)"
+// @Param		X-Delta-Offset		header		int		false	"Offset of this body in the delta"
+// @Param		X-Delta-Complete	header		bool	false	"Apply the delta after this body"
+// @Param		X-Delta-Checksum	header		string	false	"SHA-256 hex of the resulting file (required to complete)"
+// @Success		200		{object}	map[string]interface{}	"Applied: size, etag, sha256, copied and literal bytes"
+// @Success		202		{object}	map[string]interface{}	"Stored: sessionId and offset"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid delta or checksum mismatch"
+// @Failure		404		{object}	docs.ErrorResponse	"File or session not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Offset does not match the session"
+// @Failure		412		{object}	docs.ErrorResponse	"File changed since the signature"
+// @Failure		413		{object}	docs.ErrorResponse	"Quota exceeded"
+// @Security	BearerAuth
+// @Router		/files/delta/{path} [patch]
+func (h *Handler) PatchFileDelta(c echo.Context) error {
+	requestPath := c.Param("*")
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+	virtualPath := "/" + requestPath
+	req := c.Request()
+
+	target, apiErr := h.resolveEditableFile(virtualPath, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if GetFileEncryption().InEncryptedFolder(target.realPath) || IsEncryptedFile(target.realPath) {
+		return RespondError(c, ErrBadRequest("Delta updates are not supported for encrypted files"))
+	}
+
+	var session *deltaSession
+	if id := req.Header.Get(headerDeltaSession); id != "" {
+		if session = h.loadDeltaSession(id, virtualPath, claims.UserID); session == nil {
+			return RespondError(c, ErrNotFound("Delta session"))
+		}
+	} else {
+		baseETag := req.Header.Get("If-Match")
+		if baseETag == "" {
+			return RespondError(c, ErrMissingParameter("If-Match"))
+		}
+		blockSize, err := parseDeltaBlockSize(req.Header.Get(headerDeltaBlockSize))
+		if err != nil {
+			return RespondError(c, ErrBadRequest(err.Error()))
+		}
+		if !CheckIfMatch(req, GenerateETag(target.realPath, target.info.ModTime(), target.info.Size())) {
+			return RespondError(c, NewAPIError(ErrCodePreconditionFailed, "File has changed since the signature was taken"))
+		}
+		if session, err = h.createDeltaSession(virtualPath, claims.UserID, baseETag, blockSize); err != nil {
+			return RespondError(c, ErrOperationFailed("create delta session", err))
+		}
+	}
+	c.Response().Header().Set(headerDeltaSession, session.ID)
+
+	// Append this body at its offset; a mismatch reports where to resume
+	offset := session.offset()
+	if value := req.Header.Get(headerDeltaOffset); value != "" {
+		sent, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || sent < 0 {
+			return RespondError(c, ErrBadRequest("Invalid "+headerDeltaOffset))
+		}
+		if sent != offset {
+			return RespondError(c, NewAPIError(ErrCodeConflict, "Delta offset does not match the session").
+				WithDetails(map[string]interface{}{"sessionId": session.ID, "offset": offset}))
+		}
+	}
+	if req.Body != nil {
+		if apiErr := appendDeltaChunk(session, req.Body); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+		offset = session.offset()
+	}
+
+	if complete, _ := strconv.ParseBool(req.Header.Get(headerDeltaComplete)); !complete {
+		return c.JSON(http.StatusAccepted, map[string]any{
+			"success":   true,
+			"sessionId": session.ID,
+			"offset":    offset,
+		})
+	}
+
+	checksum := strings.ToLower(strings.TrimPrefix(req.Header.Get(headerDeltaChecksum), "sha256:"))
+	if len(checksum) != sha256.Size*2 {
+		return RespondError(c, ErrBadRequest(headerDeltaChecksum+" must be the SHA-256 hex of the resulting file"))
+	}
+
+	if apiErr := h.retentionError(c, claims, target.realPath, EventFileEdit, false); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	result, apiErr := h.applyDeltaSession(req, target.realPath, session, checksum)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	os.RemoveAll(session.dir)
+
+	info, err := os.Stat(target.realPath)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("access file", err))
+	}
+	etag := GenerateETag(target.realPath, info.ModTime(), info.Size())
+	c.Response().Header().Set("ETag", etag)
+
+	GetChangeJournal().Record(ChangeModify, target.realPath, "", changeActor(claims))
+	GetListingVersions().Invalidate(target.realPath)
+	GetFileChecksums().ForgetTree(target.realPath)
+	GetWriterHints().NoteAPI(target.realPath, claims)
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventFileEdit, virtualPath, map[string]any{
+		"mode":         "delta",
+		"size":         result.Size,
+		"copiedBytes":  result.CopiedBytes,
+		"literalBytes": result.LiteralBytes,
+		"storageType":  target.storageType,
+		"isShared":     target.isShared,
+	})
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"success":      true,
+		"size":         result.Size,
+		"etag":         etag,
+		"sha256":       result.SHA256,
+		"copiedBytes":  result.CopiedBytes,
+		"literalBytes": result.LiteralBytes,
+	})
+}
+
+// appendDeltaChunk appends a request body to the session's delta data
+func appendDeltaChunk(session *deltaSession, body io.Reader) *APIError {
+	f, err := os.OpenFile(session.dataPath(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return ErrOperationFailed("open delta session", err)
+	}
+	defer f.Close()
+	// One writer per session at a time, so concurrent retries cannot interleave
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return NewAPIError(ErrCodeLocked, "Delta session is being written by another request")
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	n, err := io.Copy(f, io.LimitReader(body, deltaMaxChunkSize+1))
+	if err != nil {
+		return NewAPIError(ErrCodeWriteFailed, "Failed to store delta data")
+	}
+	if n > deltaMaxChunkSize {
+		return NewAPIError(ErrCodeFileTooLarge, fmt.Sprintf("Send at most %d bytes of delta per request", deltaMaxChunkSize))
+	}
+	return nil
+}
+
+// applyDeltaSession rebuilds the file from its delta in a temp file next to
+// it, checks the checksum and the quota for the change in size, and renames
+// the result over the file
+func (h *Handler) applyDeltaSession(r *http.Request, realPath string, session *deltaSession, checksum string) (*deltaResult, *APIError) {
+	base, err := os.Open(realPath)
+	if err != nil {
+		return nil, ErrOperationFailed("open file", err)
+	}
+	defer base.Close()
+
+	// Writers of the file wait until the result is in place
+	if err := syscall.Flock(int(base.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, NewAPIError(ErrCodeLocked, "File is being written by another client")
+		}
+		return nil, ErrOperationFailed("lock file", err)
+	}
+	defer syscall.Flock(int(base.Fd()), syscall.LOCK_UN)
+
+	info, err := base.Stat()
+	if err != nil {
+		return nil, ErrOperationFailed("access file", err)
+	}
+	if GenerateETag(realPath, info.ModTime(), info.Size()) != session.BaseETag {
+		os.RemoveAll(session.dir)
+		return nil, NewAPIError(ErrCodePreconditionFailed, "File has changed since the signature was taken")
+	}
+
+	delta, err := os.Open(session.dataPath())
+	if err != nil {
+		return nil, ErrOperationFailed("open delta session", err)
+	}
+	defer delta.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(realPath), "."+filepath.Base(realPath)+".*.delta")
+	if err != nil {
+		return nil, ErrOperationFailed("create temp file", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // Gone after the rename; cleans up on failure
+
+	hash := sha256.New()
+	result, err := applyDelta(base, info.Size(), session.BlockSize, delta, io.MultiWriter(tmp, hash))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return nil, NewAPIError(ErrCodeWriteFailed, "Failed to write the updated file")
+		}
+		os.RemoveAll(session.dir)
+		return nil, ErrBadRequest("Invalid delta: " + err.Error())
+	}
+	result.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if result.SHA256 != checksum {
+		os.RemoveAll(session.dir)
+		return nil, ErrBadRequest("The updated file does not match " + headerDeltaChecksum).
+			WithDetails(map[string]interface{}{"sha256": result.SHA256})
+	}
+
+	growth := result.Size - info.Size()
+	if growth > 0 {
+		ctx, cancel := budgetQuotaCheck.Context(r.Context())
+		defer cancel()
+		if apiErr := h.checkGrowthQuota(ctx, realPath, growth); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return nil, ErrOperationFailed("set file mode", err)
+	}
+	if err := os.Rename(tmpPath, realPath); err != nil {
+		return nil, NewAPIError(ErrCodeWriteFailed, "Failed to replace the file")
+	}
+	if growth != 0 {
+		h.recordGrowth(realPath, growth)
+	}
+	return result, nil
+}
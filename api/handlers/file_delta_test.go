@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+)
+
+// testSignature is the data of a GET /files/signature response
+type testSignature struct {
+	Size      int64            `json:"size"`
+	BlockSize int              `json:"blockSize"`
+	ETag      string           `json:"etag"`
+	Blocks    []BlockSignature `json:"blocks"`
+	SHA256    string           `json:"sha256"`
+}
+
+func signatureOf(data []byte, blockSize int) testSignature {
+	sig := testSignature{Size: int64(len(data)), BlockSize: blockSize}
+	for off := 0; off < len(data); off += blockSize {
+		block := data[off:min(off+blockSize, len(data))]
+		strong := sha256.Sum256(block)
+		sig.Blocks = append(sig.Blocks, BlockSignature{Weak: newRollingChecksum(block).sum(), Strong: hex.EncodeToString(strong[:])})
+	}
+	return sig
+}
+
+// computeDelta is a client: it finds the blocks of sig in data with the
+// rolling checksum and encodes the rest as literals
+func computeDelta(sig testSignature, data []byte) []byte {
+	index := make(map[uint32][]int)
+	for i, b := range sig.Blocks {
+		index[b.Weak] = append(index[b.Weak], i)
+	}
+	match := func(weak uint32, window []byte) int {
+		for _, i := range index[weak] {
+			if strong := sha256.Sum256(window); hex.EncodeToString(strong[:]) == sig.Blocks[i].Strong {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var out bytes.Buffer
+	out.WriteString(deltaMagic)
+	var literal []byte
+	copyStart, copyCount := 0, 0
+	flush := func() {
+		if copyCount > 0 {
+			out.WriteByte(deltaOpCopy)
+			_ = binary.Write(&out, binary.BigEndian, uint64(copyStart))
+			_ = binary.Write(&out, binary.BigEndian, uint32(copyCount))
+			copyCount = 0
+		}
+		if len(literal) > 0 {
+			out.WriteByte(deltaOpLiteral)
+			_ = binary.Write(&out, binary.BigEndian, uint32(len(literal)))
+			out.Write(literal)
+			literal = nil
+		}
+	}
+
+	var roll rollingChecksum
+	rolling := false
+	for pos := 0; pos < len(data); {
+		end := min(pos+sig.BlockSize, len(data))
+		if !rolling {
+			roll, rolling = newRollingChecksum(data[pos:end]), true
+		}
+		if i := match(roll.sum(), data[pos:end]); i >= 0 {
+			if copyCount > 0 && len(literal) == 0 && copyStart+copyCount == i {
+				copyCount++
+			} else {
+				flush()
+				copyStart, copyCount = i, 1
+			}
+			pos, rolling = end, false
+			continue
+		}
+		if copyCount > 0 {
+			flush()
+		}
+		literal = append(literal, data[pos])
+		if end < len(data) {
+			roll.roll(data[pos], data[end])
+		} else {
+			rolling = false // The window shrinks at the end
+		}
+		pos++
+	}
+	flush()
+	out.WriteByte(deltaOpEnd)
+	return out.Bytes()
+}
+
+func TestRollingChecksum(t *testing.T) {
+	data := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(data)
+	const window = 512
+	roll := newRollingChecksum(data[:window])
+	for i := 1; i+window <= len(data); i++ {
+		roll.roll(data[i-1], data[i+window-1])
+		if want := newRollingChecksum(data[i : i+window]).sum(); roll.sum() != want {
+			t.Fatalf("offset %d: rolled %08x, want %08x", i, roll.sum(), want)
+		}
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rng.Read(b)
+		return b
+	}
+	const blockSize = 1024
+	base := random(40*blockSize + 300)
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	for _, tc := range []struct {
+		name       string
+		data       []byte
+		maxLiteral int64
+	}{
+		{"identical", base, 0},
+		{"shifted by an insert", cat(random(7), base), 7},
+		{"bytes removed in the middle", cat(base[:5000], base[5003:]), 2 * blockSize},
+		{"block changed", cat(base[:blockSize*3], random(blockSize), base[blockSize*4:]), blockSize},
+		{"appended", cat(base, random(5000)), 300 + 5000}, // The short last block is resent
+		{"truncated", base[:10*blockSize+17], 17},
+		{"total rewrite", random(len(base)), int64(len(base))},
+		{"empty", nil, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			delta := computeDelta(signatureOf(base, blockSize), tc.data)
+			var out bytes.Buffer
+			result, err := applyDelta(bytes.NewReader(base), int64(len(base)), blockSize, bytes.NewReader(delta), &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), tc.data) {
+				t.Fatalf("rebuilt %d bytes, want %d", out.Len(), len(tc.data))
+			}
+			if result.Size != int64(len(tc.data)) || result.LiteralBytes > tc.maxLiteral {
+				t.Errorf("result = %+v, want at most %d literal bytes", result, tc.maxLiteral)
+			}
+		})
+	}
+
+	// Malformed deltas are rejected
+	copyOp := func(block uint64, count uint32) []byte {
+		b := []byte{deltaOpCopy}
+		b = binary.BigEndian.AppendUint64(b, block)
+		return binary.BigEndian.AppendUint32(b, count)
+	}
+	for name, delta := range map[string][]byte{
+		"no magic":           []byte("XXXXE"),
+		"no end marker":      []byte(deltaMagic),
+		"copy past the end":  cat([]byte(deltaMagic), copyOp(40, 2), []byte{deltaOpEnd}),
+		"copy overflow":      cat([]byte(deltaMagic), copyOp(1, 0xffffffff), []byte{deltaOpEnd}),
+		"empty copy":         cat([]byte(deltaMagic), copyOp(0, 0), []byte{deltaOpEnd}),
+		"truncated literal":  cat([]byte(deltaMagic), []byte{deltaOpLiteral, 0, 0, 0, 10}, []byte("abc")),
+		"oversized literal":  cat([]byte(deltaMagic), []byte{deltaOpLiteral, 0xff, 0xff, 0xff, 0xff}),
+		"data after the end": cat([]byte(deltaMagic), []byte{deltaOpEnd, 0}),
+		"unknown op":         cat([]byte(deltaMagic), []byte{'X'}),
+	} {
+		if _, err := applyDelta(bytes.NewReader(base), int64(len(base)), blockSize, bytes.NewReader(delta), &bytes.Buffer{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPatchFileDelta(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	filePath := filepath.Join(userDir, "disk.img")
+	base := make([]byte, 64*1024)
+	rand.New(rand.NewSource(3)).Read(base)
+	ftc.CreateTestFile(t, filePath, base)
+
+	call := func(method, target string, body []byte, headers map[string]string, fn func(c echo.Context) error) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		c := CreateAuthenticatedContext(ftc.Echo, rec, req, "1", "testuser", false)
+		c.SetParamNames("*")
+		c.SetParamValues("home/disk.img")
+		if err := fn(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	signature, patch := ftc.Handler.GetFileSignature, ftc.Handler.PatchFileDelta
+
+	rec := call(http.MethodGet, "/api/files/signature/home/disk.img?blockSize=1024", nil, nil, signature)
+	AssertStatus(t, rec, http.StatusOK)
+	var sigResp struct {
+		Data testSignature `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &sigResp); err != nil {
+		t.Fatalf("signature is not JSON: %v", err)
+	}
+	sig := sigResp.Data
+	if whole := sha256.Sum256(base); len(sig.Blocks) != 64 || sig.SHA256 != hex.EncodeToString(whole[:]) {
+		t.Fatalf("signature has %d blocks, sha256 %s", len(sig.Blocks), sig.SHA256)
+	}
+
+	// Insert data near the start and grow the file
+	updated := bytes.Join([][]byte{base[:5000], []byte("inserted"), base[5000:], []byte("tail")}, nil)
+	delta := computeDelta(sig, updated)
+	sum := sha256.Sum256(updated)
+
+	rec = call(http.MethodPatch, "/api/files/delta/home/disk.img", delta[:100], map[string]string{
+		"If-Match": sig.ETag, headerDeltaBlockSize: "1024",
+	}, patch)
+	AssertStatus(t, rec, http.StatusAccepted)
+	session := rec.Header().Get(headerDeltaSession)
+	if session == "" {
+		t.Fatal("no session ID")
+	}
+
+	// A retry of a chunk already stored is refused with the offset to resume at
+	rec = call(http.MethodPatch, "/api/files/delta/home/disk.img", delta[:100], map[string]string{
+		headerDeltaSession: session, headerDeltaOffset: "0",
+	}, patch)
+	AssertStatus(t, rec, http.StatusConflict)
+
+	ftc.Mock.ExpectQuery("SELECT user_storage_quota").
+		WillReturnRows(sqlmock.NewRows([]string{"quota", "used"}).AddRow(0, 0))
+	ftc.Mock.ExpectExec("UPDATE users").WithArgs(int64(12), "testuser").WillReturnResult(sqlmock.NewResult(0, 1))
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+	rec = call(http.MethodPatch, "/api/files/delta/home/disk.img", delta[100:], map[string]string{
+		headerDeltaSession:  session,
+		headerDeltaOffset:   "100",
+		headerDeltaComplete: "true",
+		headerDeltaChecksum: hex.EncodeToString(sum[:]),
+	}, patch)
+	AssertStatus(t, rec, http.StatusOK)
+
+	content, _ := os.ReadFile(filePath)
+	if !bytes.Equal(content, updated) {
+		t.Fatal("file does not match the update")
+	}
+	var result struct {
+		LiteralBytes int64 `json:"literalBytes"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.LiteralBytes > 2*1024+12 {
+		t.Errorf("sent %d literal bytes for a 12 byte change", result.LiteralBytes)
+	}
+	if _, err := os.Stat(filepath.Join(ftc.DataRoot, ".uploads", "delta", session)); !os.IsNotExist(err) {
+		t.Error("session not removed")
+	}
+
+	// A delta against a signature of an older version is refused
+	rec = call(http.MethodPatch, "/api/files/delta/home/disk.img", delta, map[string]string{
+		"If-Match": sig.ETag, headerDeltaBlockSize: strconv.Itoa(1024),
+	}, patch)
+	AssertStatus(t, rec, http.StatusPreconditionFailed)
+
+	if err := ftc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
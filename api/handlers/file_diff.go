@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pmezard/go-difflib/difflib"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	// maxDiffTextSize bounds each side of a text diff; larger text files are refused
+	maxDiffTextSize = 2 << 20
+	// maxDiffLines bounds the line count of each side of a text diff
+	maxDiffLines = 20000
+	// diffSniffSize is how much of a file is read to tell text from binary
+	diffSniffSize = 8192
+	// defaultDiffContext and maxDiffContext bound the context lines around changes
+	defaultDiffContext = 3
+	maxDiffContext     = 20
+)
+
+// Diff line types
+const (
+	DiffLineContext = "context"
+	DiffLineAdd     = "add"
+	DiffLineDelete  = "delete"
+)
+
+// DiffSource is one side of a comparison; exactly one field is set
+type DiffSource struct {
+	Path      string `json:"path,omitempty"`      // Live file
+	VersionID string `json:"versionId,omitempty"` // Stored version
+	TrashID   string `json:"trashId,omitempty"`   // File in the caller's trash
+}
+
+// DiffRequest is the request body for POST /api/files/diff
+type DiffRequest struct {
+	From    DiffSource `json:"from"`
+	To      DiffSource `json:"to"`
+	Context int        `json:"context,omitempty"` // Context lines around changes (default 3)
+}
+
+// DiffSide describes one compared file
+type DiffSide struct {
+	Label    string    `json:"label"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Checksum string    `json:"checksum"`           // SHA-256 of the stored content
+	Encoding string    `json:"encoding,omitempty"` // Text encoding the content was decoded from
+	Lines    int       `json:"lines,omitempty"`
+}
+
+// DiffLine is one line of a hunk. Line numbers are 1-based; 0 when the line
+// does not exist on that side.
+type DiffLine struct {
+	Type    string `json:"type"`
+	Text    string `json:"text"`
+	OldLine int    `json:"oldLine,omitempty"`
+	NewLine int    `json:"newLine,omitempty"`
+}
+
+// DiffHunk is a group of changes with surrounding context
+type DiffHunk struct {
+	OldStart int        `json:"oldStart"`
+	OldLines int        `json:"oldLines"`
+	NewStart int        `json:"newStart"`
+	NewLines int        `json:"newLines"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+// FileDiff is the response of POST /api/files/diff
+type FileDiff struct {
+	Mode      string     `json:"mode"` // "text" or "binary"
+	From      DiffSide   `json:"from"`
+	To        DiffSide   `json:"to"`
+	Identical bool       `json:"identical"`
+	Added     int        `json:"added"`
+	Removed   int        `json:"removed"`
+	Hunks     []DiffHunk `json:"hunks,omitempty"`
+	Unified   string     `json:"unified,omitempty"`
+}
+
+// diffInput is a resolved diff source
+type diffInput struct {
+	realPath string
+	side     DiffSide
+}
+
+// resolveDiffSource checks the caller may read a source and returns its file
+func (h *Handler) resolveDiffSource(src DiffSource, claims *JWTClaims) (*diffInput, *APIError) {
+	set := 0
+	for _, v := range []string{src.Path, src.VersionID, src.TrashID} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, ErrBadRequest("Each source needs exactly one of path, versionId or trashId")
+	}
+
+	var realPath, label string
+	switch {
+	case src.VersionID != "":
+		return nil, ErrBadRequest("File versions are not available on this server")
+
+	case src.TrashID != "":
+		meta, err := h.loadTrashMeta(claims.Username)
+		if err != nil {
+			return nil, ErrOperationFailed("read trash", err)
+		}
+		item, ok := meta[src.TrashID]
+		if !ok || strings.ContainsAny(src.TrashID, `/\`) {
+			return nil, ErrNotFound("Trash item")
+		}
+		realPath = filepath.Join(h.getTrashPath(claims.Username), src.TrashID)
+		label = "trash:" + item.OriginalPath
+
+	default:
+		var storageType, displayPath string
+		var err error
+		realPath, storageType, displayPath, err = h.resolvePath(src.Path, claims)
+		if err != nil {
+			return nil, ErrInvalidPath(err.Error())
+		}
+		switch {
+		case storageType == StorageShared && !h.CanReadSharedDrive(claims.UserID, displayPath):
+			return nil, ErrForbidden("No permission to read " + displayPath)
+		case realPath == "":
+			// Shared with the caller by another user
+			sharedPath, _, shareErr := h.GetSharedFileOwnerPath(claims.UserID, src.Path)
+			if shareErr != nil || !h.CanReadSharedFile(claims.UserID, src.Path) {
+				return nil, ErrForbidden("No permission to read " + src.Path)
+			}
+			realPath = sharedPath
+		}
+		target, apiErr := h.followFileLink(realPath, claims)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		realPath = target
+		label = displayPath
+	}
+
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return nil, ErrNotFound(label)
+	}
+	if info.IsDir() {
+		return nil, ErrBadRequest(label + " is a folder")
+	}
+	return &diffInput{realPath: realPath, side: DiffSide{Label: label, ModTime: info.ModTime()}}, nil
+}
+
+// checksumFile hashes a file's stored content without loading it
+func checksumFile(realPath string) (string, int64, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
+
+// sniffText reads the start of a file and reports whether it looks like text
+func sniffText(realPath string) (bool, int64, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return false, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, 0, err
+	}
+	buf := make([]byte, diffSniffSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, 0, err
+	}
+	return looksLikeText(buf[:n]), info.Size(), nil
+}
+
+// looksLikeText treats data with NUL bytes as binary, except UTF-16 with a BOM
+func looksLikeText(data []byte) bool {
+	if bytes.HasPrefix(data, []byte{0xFF, 0xFE}) || bytes.HasPrefix(data, []byte{0xFE, 0xFF}) {
+		return true
+	}
+	return bytes.IndexByte(data, 0) < 0
+}
+
+// decodeText converts file content to UTF-8. UTF-16 needs a BOM; content
+// that is not valid UTF-8 is read as EUC-KR (CP949).
+func decodeText(data []byte) (string, string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return string(data[3:]), "utf-8", nil
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		out, err := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+		return string(out), "utf-16le", err
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		out, err := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+		return string(out), "utf-16be", err
+	case utf8.Valid(data):
+		return string(data), "utf-8", nil
+	}
+	out, err := korean.EUCKR.NewDecoder().Bytes(data)
+	return string(out), "euc-kr", err
+}
+
+// splitDiffLines splits text into lines without their line endings
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffHunks groups the changes between two line slices into hunks
+func diffHunks(a, b []string, context int) (hunks []DiffHunk, added, removed int) {
+	matcher := difflib.NewMatcher(a, b)
+	for _, group := range matcher.GetGroupedOpCodes(context) {
+		first, last := group[0], group[len(group)-1]
+		hunk := DiffHunk{
+			OldStart: first.I1 + 1,
+			OldLines: last.I2 - first.I1,
+			NewStart: first.J1 + 1,
+			NewLines: last.J2 - first.J1,
+		}
+		for _, op := range group {
+			if op.Tag == 'e' {
+				for k := 0; k < op.I2-op.I1; k++ {
+					hunk.Lines = append(hunk.Lines, DiffLine{Type: DiffLineContext, Text: a[op.I1+k], OldLine: op.I1 + k + 1, NewLine: op.J1 + k + 1})
+				}
+				continue
+			}
+			if op.Tag == 'r' || op.Tag == 'd' {
+				for i := op.I1; i < op.I2; i++ {
+					hunk.Lines = append(hunk.Lines, DiffLine{Type: DiffLineDelete, Text: a[i], OldLine: i + 1})
+					removed++
+				}
+			}
+			if op.Tag == 'r' || op.Tag == 'i' {
+				for j := op.J1; j < op.J2; j++ {
+					hunk.Lines = append(hunk.Lines, DiffLine{Type: DiffLineAdd, Text: b[j], NewLine: j + 1})
+					added++
+				}
+			}
+		}
+		hunks = append(hunks, hunk)
+	}
+	return hunks, added, removed
+}
+
+// unifiedDiff renders hunks in unified diff format
+func unifiedDiff(fromLabel, toLabel string, hunks []DiffHunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromLabel, toLabel)
+	for _, hunk := range hunks {
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", unifiedRange(hunk.OldStart, hunk.OldLines), unifiedRange(hunk.NewStart, hunk.NewLines))
+		for _, line := range hunk.Lines {
+			switch line.Type {
+			case DiffLineAdd:
+				sb.WriteByte('+')
+			case DiffLineDelete:
+				sb.WriteByte('-')
+			default:
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(line.Text)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// unifiedRange formats a hunk range; empty ranges point at the line before
+func unifiedRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// readDiffText loads a text file for diffing, enforcing the size and line limits
+func readDiffText(in *diffInput) ([]string, *APIError) {
+	f, err := OpenPlain(in.realPath)
+	if err != nil {
+		return nil, ErrOperationFailed("read "+in.side.Label, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxDiffTextSize+1))
+	if err != nil {
+		return nil, ErrOperationFailed("read "+in.side.Label, err)
+	}
+	if len(data) > maxDiffTextSize {
+		return nil, diffLimitError(in.side, "size")
+	}
+	sum := sha256.Sum256(data)
+	in.side.Checksum = hex.EncodeToString(sum[:])
+
+	text, encoding, err := decodeText(data)
+	if err != nil {
+		return nil, ErrBadRequest(fmt.Sprintf("Cannot decode %s as text", in.side.Label))
+	}
+	in.side.Encoding = encoding
+	lines := splitDiffLines(text)
+	if len(lines) > maxDiffLines {
+		return nil, diffLimitError(in.side, "lines")
+	}
+	in.side.Lines = len(lines)
+	return lines, nil
+}
+
+// diffLimitError reports a source too large to diff
+func diffLimitError(side DiffSide, limit string) *APIError {
+	return NewAPIError(ErrCodeFileTooLarge, fmt.Sprintf("%s is too large to compare as text", side.Label)).WithDetails(map[string]interface{}{
+		"path":     side.Label,
+		"size":     side.Size,
+		"maxSize":  maxDiffTextSize,
+		"maxLines": maxDiffLines,
+		"limit":    limit,
+	})
+}
+
+// DiffFiles compares two files
+// @Summary		Compare two files
+// @Description	Compares two sources, each a live path or a file in the caller's trash. Text files get a unified diff with structured hunks (content decoded to UTF-8, up to 2 MiB and 20000 lines per side); binary files get a size, modification time and checksum comparison. Read permission is checked on each source.
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		request	body		DiffRequest				true	"Sources to compare"
+// @Success		200		{object}	FileDiff				"Comparison"
+// @Failure		400		{object}	docs.ErrorResponse		"Bad request"
+// @Failure		403		{object}	docs.ErrorResponse		"No permission for a source"
+// @Failure		404		{object}	docs.ErrorResponse		"Source not found"
+// @Failure		413		{object}	docs.ErrorResponse		"Text file too large to compare"
+// @Security	BearerAuth
+// @Router		/files/diff [post]
+func (h *Handler) DiffFiles(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	var req DiffRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	context := req.Context
+	if context <= 0 {
+		context = defaultDiffContext
+	}
+	context = min(context, maxDiffContext)
+
+	from, apiErr := h.resolveDiffSource(req.From, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	to, apiErr := h.resolveDiffSource(req.To, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	fromText, fromSize, err := sniffText(from.realPath)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("read "+from.side.Label, err))
+	}
+	toText, toSize, err := sniffText(to.realPath)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("read "+to.side.Label, err))
+	}
+	from.side.Size, to.side.Size = fromSize, toSize
+
+	if !fromText || !toText {
+		for _, in := range []*diffInput{from, to} {
+			sum, _, err := checksumFile(in.realPath)
+			if err != nil {
+				return RespondError(c, ErrOperationFailed("read "+in.side.Label, err))
+			}
+			in.side.Checksum = sum
+		}
+		return RespondSuccess(c, FileDiff{
+			Mode:      "binary",
+			From:      from.side,
+			To:        to.side,
+			Identical: from.side.Checksum == to.side.Checksum,
+		})
+	}
+
+	// Refuse before reading anything when either side is over the limit
+	for _, in := range []*diffInput{from, to} {
+		if in.side.Size > maxDiffTextSize {
+			return RespondError(c, diffLimitError(in.side, "size"))
+		}
+	}
+	a, apiErr := readDiffText(from)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	b, apiErr := readDiffText(to)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	hunks, added, removed := diffHunks(a, b, context)
+	return RespondSuccess(c, FileDiff{
+		Mode:      "text",
+		From:      from.side,
+		To:        to.side,
+		Identical: from.side.Checksum == to.side.Checksum,
+		Added:     added,
+		Removed:   removed,
+		Hunks:     hunks,
+		Unified:   unifiedDiff(from.side.Label, to.side.Label, hunks),
+	})
+}
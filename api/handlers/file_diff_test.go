@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeText(t *testing.T) {
+	for name, tt := range map[string]struct {
+		data     []byte
+		text     string
+		encoding string
+	}{
+		"utf-8":     {data: []byte("안녕"), text: "안녕", encoding: "utf-8"},
+		"utf-8 bom": {data: []byte("\xEF\xBB\xBFhi"), text: "hi", encoding: "utf-8"},
+		"utf-16le":  {data: []byte{0xFF, 0xFE, 'h', 0, 'i', 0}, text: "hi", encoding: "utf-16le"},
+		"euc-kr":    {data: []byte{0xBE, 0xC8, 0xB3, 0xE7}, text: "안녕", encoding: "euc-kr"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			text, encoding, err := decodeText(tt.data)
+			if err != nil || text != tt.text || encoding != tt.encoding {
+				t.Errorf("decodeText = %q, %s, %v; want %q, %s", text, encoding, err, tt.text, tt.encoding)
+			}
+		})
+	}
+}
+
+func TestDiffHunks(t *testing.T) {
+	a := []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten"}
+	b := []string{"one", "two", "THREE", "four", "five", "six", "seven", "eight", "nine", "ten", "eleven"}
+
+	hunks, added, removed := diffHunks(a, b, 1)
+	if len(hunks) != 2 || added != 2 || removed != 1 {
+		t.Fatalf("hunks = %d, added = %d, removed = %d", len(hunks), added, removed)
+	}
+	h := hunks[0]
+	if h.OldStart != 2 || h.OldLines != 3 || h.NewStart != 2 || h.NewLines != 3 {
+		t.Errorf("hunk range = %+v", h)
+	}
+	if h.Lines[1] != (DiffLine{Type: DiffLineDelete, Text: "three", OldLine: 3}) ||
+		h.Lines[2] != (DiffLine{Type: DiffLineAdd, Text: "THREE", NewLine: 3}) {
+		t.Errorf("hunk lines = %+v", h.Lines)
+	}
+
+	unified := unifiedDiff("a", "b", hunks)
+	if !strings.Contains(unified, "@@ -2,3 +2,3 @@\n two\n-three\n+THREE\n four\n") {
+		t.Errorf("unified diff:\n%s", unified)
+	}
+}
+
+func diffTestHandler(t *testing.T) (*TestContext, *Handler, string) {
+	t.Helper()
+	tc := SetupTest(t)
+	dataRoot := t.TempDir()
+	home := filepath.Join(dataRoot, "users", "alice")
+	if err := os.MkdirAll(home, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return tc, &Handler{db: tc.DB, dataRoot: dataRoot}, home
+}
+
+func runDiff(t *testing.T, tc *TestContext, h *Handler, body DiffRequest) *FileDiff {
+	t.Helper()
+	req, _ := NewJSONRequest(http.MethodPost, "/api/files/diff", body)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	if err := h.DiffFiles(c); err != nil {
+		t.Fatal(err)
+	}
+	if tc.Recorder.Code != http.StatusOK {
+		return nil
+	}
+	var resp struct {
+		Data FileDiff `json:"data"`
+	}
+	if err := ParseJSONResponse(tc.Recorder, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp.Data
+}
+
+func TestDiffFiles_TrashAgainstLive(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	_ = os.WriteFile(filepath.Join(home, "notes.txt"), []byte("a\nb\nc\n"), 0644)
+	trashDir := h.getTrashPath("alice")
+	_ = os.MkdirAll(trashDir, 0755)
+	_ = os.WriteFile(filepath.Join(trashDir, "1_notes.txt"), []byte("a\nc\n"), 0644)
+	_ = h.saveTrashMeta("alice", map[string]TrashItem{
+		"1_notes.txt": {ID: "1_notes.txt", Name: "notes.txt", OriginalPath: "/home/notes.txt", DeletedAt: time.Now()},
+	})
+
+	diff := runDiff(t, tc, h, DiffRequest{From: DiffSource{TrashID: "1_notes.txt"}, To: DiffSource{Path: "/home/notes.txt"}})
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if diff.Mode != "text" || diff.Identical || diff.Added != 1 || diff.Removed != 0 {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+	if diff.From.Label != "trash:/home/notes.txt" || diff.To.Lines != 3 {
+		t.Errorf("sides: %+v / %+v", diff.From, diff.To)
+	}
+}
+
+func TestDiffFiles_Binary(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	_ = os.WriteFile(filepath.Join(home, "a.bin"), []byte{1, 0, 2}, 0644)
+	_ = os.WriteFile(filepath.Join(home, "b.bin"), []byte{1, 0, 2}, 0644)
+
+	diff := runDiff(t, tc, h, DiffRequest{From: DiffSource{Path: "/home/a.bin"}, To: DiffSource{Path: "/home/b.bin"}})
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if diff.Mode != "binary" || !diff.Identical || diff.From.Checksum == "" || len(diff.Hunks) != 0 {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+}
+
+func TestDiffFiles_RefusesLargeText(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	_ = os.WriteFile(filepath.Join(home, "big.log"), bytes.Repeat([]byte("log line\n"), maxDiffTextSize/9+1), 0644)
+	_ = os.WriteFile(filepath.Join(home, "small.log"), []byte("log line\n"), 0644)
+
+	runDiff(t, tc, h, DiffRequest{From: DiffSource{Path: "/home/small.log"}, To: DiffSource{Path: "/home/big.log"}})
+	AssertStatus(t, tc.Recorder, http.StatusRequestEntityTooLarge)
+	if !strings.Contains(tc.Recorder.Body.String(), "FILE_TOO_LARGE") {
+		t.Errorf("body = %s", tc.Recorder.Body.String())
+	}
+}
+
+func TestDiffFiles_InvalidSources(t *testing.T) {
+	for name, tt := range map[string]struct {
+		from   DiffSource
+		status int
+	}{
+		"two fields":    {from: DiffSource{Path: "/home/a.txt", TrashID: "x"}, status: http.StatusBadRequest},
+		"version":       {from: DiffSource{VersionID: "v1"}, status: http.StatusBadRequest},
+		"foreign trash": {from: DiffSource{TrashID: "../bob/1_x"}, status: http.StatusNotFound},
+		"missing":       {from: DiffSource{Path: "/home/missing.txt"}, status: http.StatusNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc, h, home := diffTestHandler(t)
+			defer tc.Cleanup()
+			_ = os.WriteFile(filepath.Join(home, "a.txt"), []byte("a\n"), 0644)
+
+			runDiff(t, tc, h, DiffRequest{From: tt.from, To: DiffSource{Path: "/home/a.txt"}})
+			AssertStatus(t, tc.Recorder, tt.status)
+		})
+	}
+}
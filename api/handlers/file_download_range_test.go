@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// rangeTestContent is 1000 bytes that differ by offset
+var rangeTestContent = bytes.Repeat([]byte("0123456789"), 100)
+
+func getFileRange(t *testing.T, tc *TestContext, h *Handler, userID, username, path string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/files/"+path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	c := tc.Echo.NewContext(req, rec)
+	c.SetParamNames("*")
+	c.SetParamValues(path)
+	c.Set("user", &JWTClaims{UserID: userID, Username: username})
+	if err := h.GetFile(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func assertRangeResponse(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Range"); got != fmt.Sprintf("bytes 100-%d/%d", len(rangeTestContent)-1, len(rangeTestContent)) {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), rangeTestContent[100:]) {
+		t.Errorf("body is %d bytes, not the requested range", rec.Body.Len())
+	}
+	if rec.Header().Get("ETag") == "" || rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("headers = %v", rec.Header())
+	}
+}
+
+func TestGetFile_RangeHome(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	_ = os.WriteFile(filepath.Join(home, "video.bin"), rangeTestContent, 0644)
+
+	rec := getFileRange(t, tc, h, "u1", "alice", "home/video.bin", http.Header{"Range": {"bytes=100-"}})
+	assertRangeResponse(t, rec)
+
+	// A resume after the file changed gets the whole new file
+	etag := rec.Header().Get("ETag")
+	rec = getFileRange(t, tc, h, "u1", "alice", "home/video.bin", http.Header{"Range": {"bytes=100-"}, "If-Range": {etag}})
+	assertRangeResponse(t, rec)
+	_ = os.WriteFile(filepath.Join(home, "video.bin"), append(rangeTestContent, 'x'), 0644)
+	rec = getFileRange(t, tc, h, "u1", "alice", "home/video.bin", http.Header{"Range": {"bytes=100-"}, "If-Range": {etag}})
+	if rec.Code != http.StatusOK || rec.Body.Len() != len(rangeTestContent)+1 {
+		t.Errorf("stale If-Range = %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestGetFile_RangeSharedDrive(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+	drive := filepath.Join(h.dataRoot, "shared", "media")
+	_ = os.MkdirAll(drive, 0755)
+	_ = os.WriteFile(filepath.Join(drive, "video.bin"), rangeTestContent, 0644)
+
+	tc.Mock.ExpectQuery("SELECT sfa.permission_level").
+		WithArgs("media", "u1").
+		WillReturnRows(sqlmock.NewRows([]string{"permission_level", "id"}).AddRow(1, "d1"))
+
+	assertRangeResponse(t, getFileRange(t, tc, h, "u1", "alice", "shared/media/video.bin", http.Header{"Range": {"bytes=100-"}}))
+}
+
+func TestGetFile_RangeSharedWithMe(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	_ = os.MkdirAll(filepath.Join(home, "docs"), 0755)
+	_ = os.WriteFile(filepath.Join(home, "docs", "video.bin"), rangeTestContent, 0644)
+
+	// bob opens alice's file by its path in her home
+	tc.Mock.ExpectQuery("SELECT fs.item_path, u.username").
+		WithArgs("u2", "/home/docs/video.bin").
+		WillReturnRows(sqlmock.NewRows([]string{"item_path", "username"}).AddRow("/home/docs/video.bin", "alice"))
+	tc.Mock.ExpectQuery("SELECT permission_level FROM file_shares").
+		WithArgs("u2", "/home/docs/video.bin").
+		WillReturnRows(sqlmock.NewRows([]string{"permission_level"}).AddRow(1))
+
+	assertRangeResponse(t, getFileRange(t, tc, h, "u2", "bob", "home/docs/video.bin", http.Header{"Range": {"bytes=100-"}}))
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetFile_ResumedDownloadNotLoggedAgain(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+	userDir := ftc.CreateTestUser(t, "testuser")
+	ftc.CreateTestFile(t, filepath.Join(userDir, "video.bin"), rangeTestContent)
+
+	download := func(rangeHeader string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/files/home/video.bin?download=true", nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		c := CreateAuthenticatedContext(ftc.Echo, rec, req, "1", "testuser", false)
+		c.SetParamNames("*")
+		c.SetParamValues("home/video.bin")
+		if err := ftc.Handler.GetFile(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("1", sqlmock.AnyArg(), EventFileDownload, "/home/video.bin", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if code := download(""); code != http.StatusOK {
+		t.Fatalf("download = %d", code)
+	}
+	if err := ftc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same entry would be written for the resumed request
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+	if code := download("bytes=100-"); code != http.StatusPartialContent {
+		t.Fatalf("resume = %d", code)
+	}
+	if ftc.Mock.ExpectationsWereMet() == nil {
+		t.Error("resumed download logged again")
+	}
+}
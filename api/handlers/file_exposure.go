@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Share link states reported by the exposure lookup
+const (
+	LinkStatusActive    = "active"
+	LinkStatusExpired   = "expired"
+	LinkStatusExhausted = "exhausted" // Access limit reached
+	LinkStatusDisabled  = "disabled"
+)
+
+// ExposureLink is a share link created by the caller on the path or an ancestor
+type ExposureLink struct {
+	LinkShareExposure
+	Status string `json:"status"`
+}
+
+// ExposureFileShare is a user-to-user share by the caller on the path or an ancestor folder
+type ExposureFileShare struct {
+	ID              int64      `json:"id"`
+	ItemPath        string     `json:"itemPath"`
+	IsFolder        bool       `json:"isFolder"`
+	SharedWithID    string     `json:"sharedWithId"`
+	SharedWith      string     `json:"sharedWith"`
+	PermissionLevel int        `json:"permissionLevel"`
+	Status          string     `json:"status"` // accepted or pending
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+	Inherited       bool       `json:"inherited"`
+}
+
+// ExposureTeamDrive describes the shared drive the path sits in
+type ExposureTeamDrive struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	MemberCount int    `json:"memberCount"`
+}
+
+// FileExposure is the response of GET /api/files/exposure/*
+type FileExposure struct {
+	Path         string              `json:"path"`
+	LinkShares   []ExposureLink      `json:"linkShares"`
+	UploadShares []ExposureLink      `json:"uploadShares"`
+	FileShares   []ExposureFileShare `json:"fileShares"`
+	TeamDrive    *ExposureTeamDrive  `json:"teamDrive,omitempty"`
+	Exposed      bool                `json:"exposed"` // Any active link or share reaches the path
+}
+
+// pathAncestors returns p followed by its parent folders, stopping at the
+// first minParts segments. Matching stored paths against this list with
+// = ANY keeps folder boundaries exact and uses the path indexes.
+func pathAncestors(p string, minParts int) []string {
+	leading := strings.HasPrefix(p, "/")
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	ancestors := make([]string, 0, len(parts))
+	for n := len(parts); n >= minParts && n > 0; n-- {
+		a := strings.Join(parts[:n], "/")
+		if leading {
+			a = "/" + a
+		}
+		ancestors = append(ancestors, a)
+	}
+	return ancestors
+}
+
+// linkStatus reports whether a share link can still be opened
+func linkStatus(active bool, expiresAt *time.Time, maxAccess *int, accessCount int, now time.Time) string {
+	switch {
+	case !active:
+		return LinkStatusDisabled
+	case expiresAt != nil && !expiresAt.After(now):
+		return LinkStatusExpired
+	case maxAccess != nil && *maxAccess > 0 && accessCount >= *maxAccess:
+		return LinkStatusExhausted
+	}
+	return LinkStatusActive
+}
+
+// exposureLinks lists the caller's share links on stored (data-root
+// relative) paths, split into download/edit links and upload shares
+func (h *Handler) exposureLinks(userID, storedPath string) (links, uploads []ExposureLink, err error) {
+	rows, err := h.db.Query(`
+		SELECT s.id, s.token, s.path, s.share_type,
+		       s.password_hash IS NOT NULL AND s.password_hash <> '', COALESCE(s.require_login, FALSE),
+		       COALESCE(s.editable, FALSE), COALESCE(s.is_active, FALSE), s.expires_at, s.max_access,
+		       COALESCE(s.access_count, 0)
+		FROM shares s
+		WHERE s.created_by = $1 AND s.path = ANY($2)
+		ORDER BY s.created_at DESC
+	`, userID, pq.Array(pathAncestors(storedPath, 2)))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	links, uploads = make([]ExposureLink, 0), make([]ExposureLink, 0)
+	now := time.Now()
+	for rows.Next() {
+		var l ExposureLink
+		var active bool
+		var expiresAt sql.NullTime
+		var maxAccess sql.NullInt64
+		if err := rows.Scan(&l.ID, &l.Token, &l.Path, &l.ShareType, &l.HasPassword, &l.RequireLogin,
+			&l.Editable, &active, &expiresAt, &maxAccess, &l.AccessCount); err != nil {
+			continue
+		}
+		l.Inherited = l.Path != storedPath
+		if expiresAt.Valid {
+			l.ExpiresAt = &expiresAt.Time
+		}
+		if maxAccess.Valid {
+			m := int(maxAccess.Int64)
+			l.MaxAccess = &m
+		}
+		l.Status = linkStatus(active, l.ExpiresAt, l.MaxAccess, l.AccessCount, now)
+		l.Anonymous = l.Status == LinkStatusActive && !l.HasPassword && !l.RequireLogin
+		l.Path = "/" + l.Path
+		if l.ShareType == "upload" {
+			uploads = append(uploads, l)
+		} else {
+			links = append(links, l)
+		}
+	}
+	return links, uploads, rows.Err()
+}
+
+// exposureFileShares lists the caller's pending and accepted user-to-user
+// shares on the virtual path or an ancestor folder
+func (h *Handler) exposureFileShares(userID, virtualPath string) ([]ExposureFileShare, error) {
+	// A whole home can be shared as /home; shared drive paths start at /shared/{folder}
+	minParts := 2
+	if !strings.HasPrefix(virtualPath, "/shared/") {
+		minParts = 1
+	}
+	rows, err := h.db.Query(`
+		SELECT fs.id, fs.item_path, fs.is_folder, fs.shared_with_id, u.username,
+		       fs.permission_level, fs.status, fs.expires_at
+		FROM file_shares fs
+		INNER JOIN users u ON u.id = fs.shared_with_id
+		WHERE fs.owner_id = $1 AND fs.item_path = ANY($2)
+		  AND (fs.item_path = $3 OR fs.is_folder = TRUE)
+		  AND fs.status IN ('accepted', 'pending')
+		  AND (fs.expires_at IS NULL OR fs.expires_at > NOW())
+		ORDER BY u.username
+	`, userID, pq.Array(pathAncestors(virtualPath, minParts)), virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := make([]ExposureFileShare, 0)
+	for rows.Next() {
+		var s ExposureFileShare
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.ItemPath, &s.IsFolder, &s.SharedWithID, &s.SharedWith,
+			&s.PermissionLevel, &s.Status, &expiresAt); err != nil {
+			continue
+		}
+		if expiresAt.Valid {
+			s.ExpiresAt = &expiresAt.Time
+		}
+		s.Inherited = s.ItemPath != virtualPath
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// GetFileExposure lists how a file or folder the caller owns is reachable
+// @Summary		Exposure of a path
+// @Description	Lists the caller's share links and upload shares on the path or an ancestor folder (with status and protections), the caller's user-to-user shares covering it, and the shared drive it sits in with its member count. Unlike the admin access report, only shares created by the caller are listed.
+// @Tags		Files
+// @Produce		json
+// @Param		path	path		string	true	"File or folder path"
+// @Success		200		{object}	FileExposure		"Exposure"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/files/exposure/{path} [get]
+func (h *Handler) GetFileExposure(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	requestPath := c.Param("*")
+	if decodedPath, err := url.PathUnescape(requestPath); err == nil {
+		requestPath = decodedPath
+	}
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	virtualPath := "/" + requestPath
+
+	realPath, storageType, displayPath, err := h.resolvePath(virtualPath, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if storageType != StorageHome && storageType != StorageShared {
+		return RespondError(c, ErrBadRequest("Exposure is only available for home and shared drive items"))
+	}
+	if storageType == StorageShared && !h.CanReadSharedDrive(claims.UserID, displayPath) {
+		return RespondError(c, ErrForbidden("No permission to access this path"))
+	}
+	storedPath, err := dataRootRel(h.dataRoot, realPath)
+	if err != nil || strings.HasPrefix(storedPath, "..") {
+		return RespondError(c, ErrInvalidPath("Invalid path"))
+	}
+	storedPath = filepath.ToSlash(storedPath)
+
+	exposure := FileExposure{Path: displayPath}
+	exposure.LinkShares, exposure.UploadShares, err = h.exposureLinks(claims.UserID, storedPath)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load share links"))
+	}
+	exposure.FileShares, err = h.exposureFileShares(claims.UserID, displayPath)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load file shares"))
+	}
+
+	if folderName := ExtractSharedDriveFolderName(displayPath); storageType == StorageShared && folderName != "" {
+		var drive ExposureTeamDrive
+		err := h.db.QueryRow(`
+			SELECT sf.id, sf.name, COUNT(sfa.user_id)
+			FROM shared_folders sf
+			LEFT JOIN shared_folder_access sfa ON sfa.shared_folder_id = sf.id
+			WHERE sf.name = $1 AND sf.is_active = TRUE
+			GROUP BY sf.id, sf.name
+		`, folderName).Scan(&drive.ID, &drive.Name, &drive.MemberCount)
+		if err == nil {
+			exposure.TeamDrive = &drive
+		} else if err != sql.ErrNoRows {
+			return RespondError(c, ErrInternal("Failed to load shared drive"))
+		}
+	}
+
+	for _, l := range append(exposure.LinkShares, exposure.UploadShares...) {
+		if l.Status == LinkStatusActive {
+			exposure.Exposed = true
+		}
+	}
+	for _, s := range exposure.FileShares {
+		if s.Status == FileShareAccepted {
+			exposure.Exposed = true
+		}
+	}
+
+	return RespondSuccess(c, exposure)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestPathAncestors(t *testing.T) {
+	got := pathAncestors("users/alice/docs/a.txt", 2)
+	want := []string{"users/alice/docs/a.txt", "users/alice/docs", "users/alice"}
+	if len(got) != len(want) {
+		t.Fatalf("pathAncestors = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pathAncestors[%d] = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	// Sibling prefixes such as /home/docs-old are never ancestors of /home/docs
+	got = pathAncestors("/home/docs", 2)
+	if len(got) != 1 || got[0] != "/home/docs" {
+		t.Errorf("pathAncestors = %v, want [/home/docs]", got)
+	}
+}
+
+func TestLinkStatus(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	limit := 3
+	for want, status := range map[string]string{
+		LinkStatusDisabled:  linkStatus(false, nil, nil, 0, now),
+		LinkStatusExpired:   linkStatus(true, &past, nil, 0, now),
+		LinkStatusExhausted: linkStatus(true, nil, &limit, 3, now),
+		LinkStatusActive:    linkStatus(true, nil, &limit, 2, now),
+	} {
+		if status != want {
+			t.Errorf("status = %s, want %s", status, want)
+		}
+	}
+}
+
+func TestGetFileExposure(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &Handler{db: tc.DB, dataRoot: t.TempDir()}
+
+	linkColumns := []string{"id", "token", "path", "share_type", "has_password", "require_login",
+		"editable", "is_active", "expires_at", "max_access", "access_count"}
+	tc.Mock.ExpectQuery("FROM shares s").
+		WithArgs("u1", pq.Array([]string{"users/alice/docs/a.txt", "users/alice/docs", "users/alice"})).
+		WillReturnRows(sqlmock.NewRows(linkColumns).
+			AddRow("s1", "sh_a", "users/alice/docs", "download", false, false, false, true, nil, nil, 5).
+			AddRow("s2", "sh_b", "users/alice/docs/a.txt", "download", true, false, false, false, nil, nil, 0).
+			AddRow("s3", "up_c", "users/alice", "upload", false, false, false, true, nil, nil, 0))
+	tc.Mock.ExpectQuery("FROM file_shares fs").
+		WithArgs("u1", pq.Array([]string{"/home/docs/a.txt", "/home/docs", "/home"}), "/home/docs/a.txt").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_path", "is_folder", "shared_with_id", "username",
+			"permission_level", "status", "expires_at"}).
+			AddRow(7, "/home/docs", true, "u2", "bob", 1, "accepted", nil))
+
+	req, _ := NewJSONRequest(http.MethodGet, "/api/files/exposure/home/docs/a.txt", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	c.SetParamNames("*")
+	c.SetParamValues("home/docs/a.txt")
+	if err := h.GetFileExposure(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+
+	var resp struct {
+		Data FileExposure `json:"data"`
+	}
+	if err := ParseJSONResponse(tc.Recorder, &resp); err != nil {
+		t.Fatal(err)
+	}
+	e := resp.Data
+	if len(e.LinkShares) != 2 || len(e.UploadShares) != 1 || len(e.FileShares) != 1 || !e.Exposed {
+		t.Fatalf("unexpected exposure: %+v", e)
+	}
+	if !e.LinkShares[0].Inherited || !e.LinkShares[0].Anonymous || e.LinkShares[0].Path != "/users/alice/docs" {
+		t.Errorf("inherited link: %+v", e.LinkShares[0])
+	}
+	if e.LinkShares[1].Status != LinkStatusDisabled || e.LinkShares[1].Anonymous {
+		t.Errorf("disabled link: %+v", e.LinkShares[1])
+	}
+	if e.TeamDrive != nil {
+		t.Errorf("home path should not report a team drive")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -13,16 +13,20 @@ import (
 
 // GetFile handles file download requests
 // @Summary		Download file
-// @Description	Download a file by path. Supports both inline viewing and forced download.
+// @Description	Download a file by path: a home, shared drive or shared-with-me file (the owner's path of a file shared with the caller). Range, If-Range and ETag are honored for every kind, so an interrupted download resumes where it stopped; a resumed request is not logged as another download.
 // @Tags		Download
 // @Produce		octet-stream
 // @Param		path		path		string	true	"File path"
 // @Param		download	query		bool	false	"Force download with Content-Disposition attachment"
+// @Param		Range		header		string	false	"Byte range, e.g. bytes=100-"
+// @Param		If-Range	header		string	false	"ETag of the earlier response; a changed file is sent in full"
 // @Success		200		{file}		binary	"File content"
+// @Success		206		{file}		binary	"Requested range"
 // @Failure		400		{object}	map[string]string	"Bad request"
 // @Failure		401		{object}	map[string]string	"Unauthorized"
 // @Failure		403		{object}	map[string]string	"Forbidden"
 // @Failure		404		{object}	map[string]string	"File not found"
+// @Failure		416		{object}	map[string]string	"Range not satisfiable"
 // @Failure		500		{object}	map[string]string	"Internal server error"
 // @Security	BearerAuth
 // @Router		/files/{path} [get]
@@ -44,77 +48,126 @@ func (h *Handler) GetFile(c echo.Context) error {
 		claims = user
 	}
 
-	// Resolve path
 	virtualPath := "/" + decodedPath
+	realPath, storageType, info, apiErr := h.resolveReadableFile(virtualPath, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	// Check if download is requested
+	isDownload := c.QueryParam("download") == "true"
+	if isDownload {
+		setContentDisposition(c, info.Name())
+	}
+
+	// Log audit event for downloads. Resuming from a later offset continues
+	// the same download.
+	if isDownload {
+		start := int64(0)
+		if rangeHeader := c.Request().Header.Get("Range"); rangeHeader != "" {
+			if offset, ok := servedRangeStart(http.StatusPartialContent, rangeHeader, info.Size()); ok {
+				start = offset
+			}
+		}
+
+		if start == 0 {
+			var userID *string
+			if claims != nil {
+				userID = &claims.UserID
+			}
+			details := map[string]any{
+				"filename":    info.Name(),
+				"size":        info.Size(),
+				"storageType": storageType,
+			}
+			addMountInDetails(virtualPath, details)
+			_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileDownload, virtualPath, details)
+
+			downloaderID := ""
+			if claims != nil {
+				downloaderID = claims.UserID
+			}
+			GetDownloadStats().RecordDownload(realPath, downloaderID, c.RealIP())
+		}
+
+		if remaining := info.Size() - start; remaining >= ActivityMinDownloadSize {
+			defer TrackResponse(c, ActivityDownload, virtualPath, "", remaining).Finish()
+		}
+	}
+
+	// Editors send this back in If-Match when saving; If-Range compares it
+	// to resume a download
+	c.Response().Header().Set("ETag", GenerateETag(realPath, info.ModTime(), info.Size()))
+	return ServePlainFile(c, realPath)
+}
+
+// resolveReadableFile resolves a file the caller may read: in their home, in
+// a shared drive they are a member of, or shared with them by its owner's
+// path. Links are followed to their target.
+func (h *Handler) resolveReadableFile(virtualPath string, claims *JWTClaims) (string, string, os.FileInfo, *APIError) {
 	realPath, storageType, _, err := h.resolvePath(virtualPath, claims)
 	if err != nil {
-		return RespondError(c, ErrInvalidPath(err.Error()))
+		return "", "", nil, ErrInvalidPath(err.Error())
 	}
 
 	// Check shared permission
 	if storageType == StorageShared {
 		if claims == nil {
-			return RespondError(c, ErrUnauthorized(""))
+			return "", "", nil, ErrUnauthorized("")
 		}
 		if !h.CanReadSharedDrive(claims.UserID, virtualPath) {
-			return RespondError(c, ErrForbidden("No permission to access this file"))
+			return "", "", nil, ErrForbidden("No permission to access this file")
 		}
 	}
 
-	// For home folder files accessed by the owner, no additional check needed
-	// Shared file access is handled in the block below
-
-	// If realPath doesn't exist but user is authenticated, check if it's a shared file
-	if realPath == "" || (storageType == StorageSharedWithMe && claims != nil) {
-		// Handle shared-with-me file access
+	// sharedWithMe resolves the path as a file shared with the caller
+	sharedWithMe := func() bool {
+		if claims == nil {
+			return false
+		}
 		sharedRealPath, _, err := h.GetSharedFileOwnerPath(claims.UserID, virtualPath)
-		if err == nil && h.CanReadSharedFile(claims.UserID, virtualPath) {
-			realPath = sharedRealPath
+		if err != nil || !h.CanReadSharedFile(claims.UserID, virtualPath) {
+			return false
 		}
+		realPath = sharedRealPath
+		return true
 	}
 
-	info, err := os.Stat(realPath)
-	if err != nil {
-		// File not found in direct path - check if it's a shared file
-		if claims != nil && os.IsNotExist(err) {
-			sharedRealPath, _, shareErr := h.GetSharedFileOwnerPath(claims.UserID, virtualPath)
-			if shareErr == nil && h.CanReadSharedFile(claims.UserID, virtualPath) {
-				realPath = sharedRealPath
-				info, err = os.Stat(realPath)
-			}
-		}
-		if err != nil {
-			if os.IsNotExist(err) {
-				return RespondError(c, ErrNotFound("File"))
-			}
-			return RespondError(c, ErrOperationFailed("access file", err))
+	// Virtual paths only exist as shared files
+	if realPath == "" || storageType == StorageSharedWithMe {
+		if !sharedWithMe() {
+			return "", "", nil, ErrNotFound("File")
 		}
 	}
 
-	if info.IsDir() {
-		return RespondError(c, ErrBadRequest("Path is a directory"))
+	info, err := os.Stat(realPath)
+	// File not found in direct path - check if it's a shared file
+	if os.IsNotExist(err) && storageType == StorageHome && sharedWithMe() {
+		info, err = os.Stat(realPath)
 	}
-
-	// Check if download is requested
-	isDownload := c.QueryParam("download") == "true"
-	if isDownload {
-		setContentDisposition(c, info.Name())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", nil, ErrNotFound("File")
+		}
+		return "", "", nil, ErrOperationFailed("access file", err)
 	}
 
-	// Log audit event for downloads
-	if isDownload {
-		var userID *string
-		if claims != nil {
-			userID = &claims.UserID
+	// Links serve their target, with permissions checked against the target
+	if isFileLink(realPath) {
+		targetPath, apiErr := h.followFileLink(realPath, claims)
+		if apiErr != nil {
+			return "", "", nil, apiErr
+		}
+		realPath = targetPath
+		if info, err = os.Stat(realPath); err != nil {
+			return "", "", nil, ErrOperationFailed("access file", err)
 		}
-		_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileDownload, virtualPath, map[string]any{
-			"filename":    info.Name(),
-			"size":        info.Size(),
-			"storageType": storageType,
-		})
 	}
 
-	return c.File(realPath)
+	if info.IsDir() {
+		return "", "", nil, ErrBadRequest("Path is a directory")
+	}
+	return realPath, storageType, info, nil
 }
 
 // DeleteFile handles file deletion requests
@@ -161,6 +214,9 @@ func (h *Handler) DeleteFile(c echo.Context) error {
 	if storageType == StorageHome && claims == nil {
 		return RespondError(c, ErrUnauthorized(""))
 	}
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Check shared write permission
 	virtualPath := "/" + requestPath
@@ -168,11 +224,18 @@ func (h *Handler) DeleteFile(c echo.Context) error {
 		if claims == nil {
 			return RespondError(c, ErrUnauthorized(""))
 		}
+		if apiErr := mountInMoveError(virtualPath); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
 		if !h.CanWriteSharedDrive(claims.UserID, virtualPath) {
 			return RespondError(c, ErrForbidden("No permission to delete files in this folder"))
 		}
 	}
 
+	if apiErr := h.retentionError(c, claims, realPath, EventFileDelete, true); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
 	info, err := os.Stat(realPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -191,6 +254,15 @@ func (h *Handler) DeleteFile(c echo.Context) error {
 	if err := os.Remove(realPath); err != nil {
 		return RespondError(c, ErrOperationFailed("delete file", err))
 	}
+	GetDownloadStats().MarkDeleted(realPath)
+	GetFileLinks().ForgetTree(realPath)
+	GetFolderDisplay().ForgetTree(realPath)
+	GetContentInspection().ForgetTree(realPath)
+	GetContentIndex().ForgetTree(realPath)
+	GetFileChecksums().ForgetTree(realPath)
+	GetDirSizes().ForgetTree(realPath)
+	GetChangeJournal().Record(ChangeDelete, realPath, "", changeActor(claims))
+	GetListingVersions().Invalidate(realPath)
 
 	// Update storage tracking
 	if storageType == StorageShared {
@@ -212,17 +284,20 @@ func (h *Handler) DeleteFile(c echo.Context) error {
 
 // SaveFileContent saves text content to a file
 // @Summary		Save file content
-// @Description	Save text content to an existing file (for text editor)
+// @Description	Save text content to an existing file (for text editor). If If-Match or If-Unmodified-Since shows the file changed since it was opened, the content is saved as a conflicted copy next to it, or rejected with 409 when edit_conflict_mode is reject.
 // @Tags		Files
 // @Accept		text/plain
 // @Produce		json
-// @Param		path	path		string	true	"File path"
-// @Param		content	body		string	true	"File content"
+// @Param		path				path		string	true	"File path"
+// @Param		content				body		string	true	"File content"
+// @Param		If-Match			header		string	false	"ETag returned when the file was opened"
+// @Param		If-Unmodified-Since	header		string	false	"Modification time the file was opened at"
 // @Success		200		{object}	docs.SuccessResponse	"File saved successfully"
 // @Failure		400		{object}	map[string]string	"Bad request"
 // @Failure		401		{object}	map[string]string	"Unauthorized"
 // @Failure		403		{object}	map[string]string	"Forbidden"
 // @Failure		404		{object}	map[string]string	"File not found"
+// @Failure		409		{object}	map[string]string	"File changed since it was opened (reject mode)"
 // @Failure		500		{object}	map[string]string	"Internal server error"
 // @Security	BearerAuth
 // @Router		/file/{path} [put]
@@ -238,20 +313,90 @@ func (h *Handler) SaveFileContent(c echo.Context) error {
 		claims = user
 	}
 
+	target, apiErr := h.resolveEditableFile("/"+requestPath, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	realPath, storageType, isSharedFile := target.realPath, target.storageType, target.isShared
+	if apiErr := h.retentionError(c, claims, realPath, EventFileEdit, false); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	// Read request body
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Failed to read request body"))
+	}
+
+	// Don't overwrite changes made since the editor loaded the file
+	if target.info != nil && changedSinceRequest(c.Request(), realPath, target.info) {
+		conflict, apiErr := h.handleEditConflict(c, claims, realPath, "/"+requestPath, target.info, body, "editor")
+		if apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"success":  true,
+			"message":  "File was changed since it was opened; saved as a conflicted copy",
+			"size":     len(body),
+			"conflict": conflict,
+		})
+	}
+
+	// Write to file
+	if err := WriteFileSealed(realPath, body, 0644); err != nil {
+		return RespondError(c, encryptionAPIError("save file", err))
+	}
+	GetChangeJournal().Record(ChangeModify, realPath, "", changeActor(claims))
+	GetListingVersions().Invalidate(realPath)
+	GetFileChecksums().ForgetTree(realPath)
+	GetWriterHints().NoteAPI(realPath, claims)
+
+	// Log the action
+	var userID *string
+	if claims != nil {
+		userID = &claims.UserID
+	}
+	clientIP := c.RealIP()
+	_ = h.auditHandler.LogEvent(userID, clientIP, EventFileEdit, "/"+requestPath, map[string]any{
+		"size":        len(body),
+		"storageType": storageType,
+		"isShared":    isSharedFile,
+	})
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"success": true,
+		"message": "File saved successfully",
+		"size":    len(body),
+	})
+}
+
+// editableFile is a file resolved for a content edit
+type editableFile struct {
+	realPath    string
+	storageType string
+	isShared    bool // file shared with the user by its owner
+	info        os.FileInfo
+}
+
+// resolveEditableFile resolves a virtual path for a content edit and checks
+// write permission, including files another user shared with write access
+func (h *Handler) resolveEditableFile(virtualPath string, claims *JWTClaims) (*editableFile, *APIError) {
 	// Resolve path
-	virtualPath := "/" + requestPath
 	realPath, storageType, _, err := h.resolvePath(virtualPath, claims)
 	if err != nil {
-		return RespondError(c, ErrInvalidPath(err.Error()))
+		return nil, ErrInvalidPath(err.Error())
 	}
 
 	// Check shared write permission
 	if storageType == StorageShared {
 		if claims == nil {
-			return RespondError(c, ErrUnauthorized(""))
+			return nil, ErrUnauthorized("")
+		}
+		if apiErr := mountInWriteError(virtualPath); apiErr != nil {
+			return nil, apiErr
 		}
 		if !h.CanWriteSharedDrive(claims.UserID, virtualPath) {
-			return RespondError(c, ErrForbidden("No permission to edit files in this folder"))
+			return nil, ErrForbidden("No permission to edit files in this folder")
 		}
 	}
 
@@ -259,15 +404,15 @@ func (h *Handler) SaveFileContent(c echo.Context) error {
 	isSharedFile := false
 	if realPath == "" || storageType == StorageSharedWithMe {
 		if claims == nil {
-			return RespondError(c, ErrUnauthorized(""))
+			return nil, ErrUnauthorized("")
 		}
 		// Check if user has write permission for this shared file
 		if !h.CanWriteSharedFile(claims.UserID, virtualPath) {
-			return RespondError(c, ErrForbidden("No permission to edit this shared file"))
+			return nil, ErrForbidden("No permission to edit this shared file")
 		}
 		sharedRealPath, _, err := h.GetSharedFileOwnerPath(claims.UserID, virtualPath)
 		if err != nil {
-			return RespondError(c, ErrNotFound("Shared file"))
+			return nil, ErrNotFound("Shared file")
 		}
 		realPath = sharedRealPath
 		isSharedFile = true
@@ -289,44 +434,20 @@ func (h *Handler) SaveFileContent(c echo.Context) error {
 		}
 		if err != nil {
 			if os.IsNotExist(err) {
-				return RespondError(c, ErrNotFound("File"))
+				return nil, ErrNotFound("File")
 			}
-			return RespondError(c, ErrOperationFailed("access file", err))
+			return nil, ErrOperationFailed("access file", err)
 		}
 	}
 
 	if info.IsDir() {
-		return RespondError(c, ErrBadRequest("Path is a directory"))
-	}
-
-	// Read request body
-	body, err := io.ReadAll(c.Request().Body)
-	if err != nil {
-		return RespondError(c, ErrBadRequest("Failed to read request body"))
+		return nil, ErrBadRequest("Path is a directory")
 	}
-
-	// Write to file
-	if err := os.WriteFile(realPath, body, 0644); err != nil {
-		return RespondError(c, ErrOperationFailed("save file", err))
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return nil, apiErr
 	}
 
-	// Log the action
-	var userID *string
-	if claims != nil {
-		userID = &claims.UserID
-	}
-	clientIP := c.RealIP()
-	_ = h.auditHandler.LogEvent(userID, clientIP, EventFileEdit, "/"+requestPath, map[string]any{
-		"size":        len(body),
-		"storageType": storageType,
-		"isShared":    isSharedFile,
-	})
-
-	return c.JSON(http.StatusOK, map[string]any{
-		"success": true,
-		"message": "File saved successfully",
-		"size":    len(body),
-	})
+	return &editableFile{realPath: realPath, storageType: storageType, isShared: isSharedFile, info: info}, nil
 }
 
 // CheckFileExists checks if a file exists at the given path
@@ -51,7 +51,7 @@ func (ftc *FileTestContext) CreateTestUser(t *testing.T, username string) string
 }
 
 // CreateTestFile creates a test file with content
-func (ftc *FileTestContext) CreateTestFile(t *testing.T, path string, content []byte) {
+func (tc *TestContext) CreateTestFile(t *testing.T, path string, content []byte) {
 	t.Helper()
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -63,7 +63,7 @@ func (ftc *FileTestContext) CreateTestFile(t *testing.T, path string, content []
 }
 
 // CreateTestFolder creates a test folder
-func (ftc *FileTestContext) CreateTestFolder(t *testing.T, path string) {
+func (tc *TestContext) CreateTestFolder(t *testing.T, path string) {
 	t.Helper()
 	if err := os.MkdirAll(path, 0755); err != nil {
 		t.Fatalf("Failed to create test folder: %v", err)
@@ -634,6 +634,161 @@ func TestMoveItem_SourceNotFound(t *testing.T) {
 	AssertStatus(t, ftc.Recorder, http.StatusNotFound)
 }
 
+func TestMoveItem_CreateDestination(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	sourcePath := filepath.Join(userDir, "source.txt")
+	ftc.CreateTestFile(t, sourcePath, []byte("move me"))
+
+	// Mock audit log
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := MoveRequest{
+		Destination:       "/home/archive/2024-archive",
+		CreateDestination: true,
+	}
+	req, err := NewJSONRequest(http.MethodPut, "/api/files/move/home/source.txt", body)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	c := CreateAuthenticatedContext(ftc.Echo, ftc.Recorder, req, "1", "testuser", false)
+	c.SetParamNames("*")
+	c.SetParamValues("home/source.txt")
+
+	err = ftc.Handler.MoveItem(c)
+	if err != nil {
+		t.Fatalf("MoveItem returned error: %v", err)
+	}
+
+	AssertStatus(t, ftc.Recorder, http.StatusOK)
+
+	newPath := filepath.Join(userDir, "archive", "2024-archive", "source.txt")
+	if _, err := os.Stat(newPath); os.IsNotExist(err) {
+		t.Error("File was not moved into the created destination")
+	}
+}
+
+func TestMoveItem_CreateDestination_SourceNotFound(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+
+	body := MoveRequest{
+		Destination:       "/home/newfolder",
+		CreateDestination: true,
+	}
+	req, err := NewJSONRequest(http.MethodPut, "/api/files/move/home/nonexistent.txt", body)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	c := CreateAuthenticatedContext(ftc.Echo, ftc.Recorder, req, "1", "testuser", false)
+	c.SetParamNames("*")
+	c.SetParamValues("home/nonexistent.txt")
+
+	err = ftc.Handler.MoveItem(c)
+	if err != nil {
+		t.Fatalf("MoveItem returned error: %v", err)
+	}
+
+	AssertStatus(t, ftc.Recorder, http.StatusNotFound)
+
+	// Destination must not be created when the source is missing
+	if _, err := os.Stat(filepath.Join(userDir, "newfolder")); !os.IsNotExist(err) {
+		t.Error("Destination folder should not exist")
+	}
+}
+
+// moveOrCopy runs MoveItem or CopyItem for testuser with a fresh recorder
+func moveOrCopy(t *testing.T, ftc *FileTestContext, isCopy bool, source string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := NewJSONRequest(http.MethodPost, "/api/files/x/"+source, body)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	c := CreateAuthenticatedContext(ftc.Echo, rec, req, "1", "testuser", false)
+	c.SetParamNames("*")
+	c.SetParamValues(source)
+	if isCopy {
+		err = ftc.Handler.CopyItem(c)
+	} else {
+		err = ftc.Handler.MoveItem(c)
+	}
+	if err != nil {
+		t.Fatalf("handler returned error: %v", err)
+	}
+	return rec
+}
+
+func TestMoveItem_OnConflict(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	ftc.CreateTestFile(t, filepath.Join(userDir, "a.txt"), []byte("new"))
+	ftc.CreateTestFile(t, filepath.Join(userDir, "dest", "a.txt"), []byte("old"))
+	ftc.CreateTestFolder(t, filepath.Join(userDir, "dest", "b.txt"))
+	ftc.CreateTestFile(t, filepath.Join(userDir, "b.txt"), []byte("file"))
+
+	// Default and fail refuse; unknown strategies are rejected
+	for _, strategy := range []string{"", ConflictFail} {
+		rec := moveOrCopy(t, ftc, false, "home/a.txt", MoveRequest{Destination: "/home/dest", OnConflict: strategy})
+		AssertStatus(t, rec, http.StatusConflict)
+	}
+	AssertStatus(t, moveOrCopy(t, ftc, false, "home/a.txt", MoveRequest{Destination: "/home/dest", OnConflict: "merge"}), http.StatusBadRequest)
+
+	// Skip leaves both in place
+	rec := moveOrCopy(t, ftc, false, "home/a.txt", MoveRequest{Destination: "/home/dest", OnConflict: ConflictSkip})
+	AssertStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"conflict":"skip"`) {
+		t.Errorf("skip response = %s", rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(userDir, "a.txt")); err != nil {
+		t.Error("skipped source was moved")
+	}
+
+	// A file cannot replace a folder
+	AssertStatus(t, moveOrCopy(t, ftc, false, "home/b.txt", MoveRequest{Destination: "/home/dest", OnConflict: ConflictOverwrite}), http.StatusConflict)
+
+	// Overwrite sends the old file to the trash
+	ftc.Mock.ExpectExec("UPDATE users SET storage_used").WillReturnResult(sqlmock.NewResult(0, 1))
+	ftc.Mock.ExpectExec("UPDATE users SET trash_used").WillReturnResult(sqlmock.NewResult(0, 1))
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+	rec = moveOrCopy(t, ftc, false, "home/a.txt", MoveRequest{Destination: "/home/dest", OnConflict: ConflictOverwrite})
+	AssertStatus(t, rec, http.StatusOK)
+	if got, _ := os.ReadFile(filepath.Join(userDir, "dest", "a.txt")); string(got) != "new" {
+		t.Errorf("destination = %q after overwrite", got)
+	}
+	trashed, _ := filepath.Glob(filepath.Join(ftc.DataRoot, "trash", "testuser", "*_a.txt"))
+	if len(trashed) != 1 {
+		t.Fatalf("trash = %v", trashed)
+	}
+	if got, _ := os.ReadFile(trashed[0]); string(got) != "old" {
+		t.Errorf("trashed item = %q", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"conflict":"overwrite"`) || !strings.Contains(rec.Body.String(), "replacedTrashId") {
+		t.Errorf("overwrite response = %s", rec.Body.String())
+	}
+
+	// Rename picks the next free name
+	ftc.CreateTestFile(t, filepath.Join(userDir, "a.txt"), []byte("third"))
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+	rec = moveOrCopy(t, ftc, false, "home/a.txt", MoveRequest{Destination: "/home/dest", OnConflict: ConflictRename})
+	AssertStatus(t, rec, http.StatusOK)
+	if _, err := os.Stat(filepath.Join(userDir, "dest", "a (1).txt")); err != nil {
+		t.Error("renamed item missing")
+	}
+	if err := ftc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
 // =============================================================================
 // Copy Tests
 // =============================================================================
@@ -726,6 +881,53 @@ func TestCopyItem_SourceNotFound(t *testing.T) {
 	AssertStatus(t, ftc.Recorder, http.StatusNotFound)
 }
 
+func TestCopyItem_OnConflict(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	ftc.CreateTestFile(t, filepath.Join(userDir, "docs", "new.txt"), []byte("new"))
+	ftc.CreateTestFile(t, filepath.Join(userDir, "dest", "docs", "old.txt"), []byte("old"))
+
+	// Copies no longer rename silently
+	AssertStatus(t, moveOrCopy(t, ftc, true, "home/docs", CopyRequest{Destination: "/home/dest"}), http.StatusConflict)
+
+	// Copying into its own folder cannot overwrite the source
+	AssertStatus(t, moveOrCopy(t, ftc, true, "home/docs", CopyRequest{Destination: "/home", OnConflict: ConflictOverwrite}), http.StatusBadRequest)
+
+	// A folder replaces a folder; the old one is in the trash, not merged
+	ftc.Mock.ExpectExec("UPDATE users SET storage_used").WillReturnResult(sqlmock.NewResult(0, 1))
+	ftc.Mock.ExpectExec("UPDATE users SET trash_used").WillReturnResult(sqlmock.NewResult(0, 1))
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+	ftc.Mock.ExpectExec("UPDATE users SET storage_used").WillReturnResult(sqlmock.NewResult(0, 1))
+	rec := moveOrCopy(t, ftc, true, "home/docs", CopyRequest{Destination: "/home/dest", OnConflict: ConflictOverwrite})
+	AssertStatus(t, rec, http.StatusOK)
+	if _, err := os.Stat(filepath.Join(userDir, "dest", "docs", "new.txt")); err != nil {
+		t.Error("copied folder missing")
+	}
+	if _, err := os.Stat(filepath.Join(userDir, "dest", "docs", "old.txt")); !os.IsNotExist(err) {
+		t.Error("folders were merged instead of replaced")
+	}
+	if trashed, _ := filepath.Glob(filepath.Join(ftc.DataRoot, "trash", "testuser", "*_docs", "old.txt")); len(trashed) != 1 {
+		t.Error("replaced folder not in the trash")
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(userDir, "dest", ".docs.copy-*")); len(leftovers) != 0 {
+		t.Errorf("staging left behind: %v", leftovers)
+	}
+
+	// Rename keeps the old behaviour
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+	ftc.Mock.ExpectExec("UPDATE users SET storage_used").WillReturnResult(sqlmock.NewResult(0, 1))
+	rec = moveOrCopy(t, ftc, true, "home/docs", CopyRequest{Destination: "/home/dest", OnConflict: ConflictRename})
+	AssertStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), `"conflict":"rename"`) || !strings.Contains(rec.Body.String(), "docs (1)") {
+		t.Errorf("rename response = %s", rec.Body.String())
+	}
+	if err := ftc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
 // =============================================================================
 // Save File Content Tests
 // =============================================================================
@@ -793,3 +995,101 @@ func TestSaveFileContent_FileNotFound(t *testing.T) {
 
 	AssertStatus(t, ftc.Recorder, http.StatusNotFound)
 }
+
+func TestPatchFileContent_Append(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	filePath := filepath.Join(userDir, "journal.log")
+	ftc.CreateTestFile(t, filePath, []byte("line 1\n"))
+
+	ftc.Mock.ExpectQuery("SELECT user_storage_quota").
+		WillReturnRows(sqlmock.NewRows([]string{"quota", "used"}).AddRow(0, 0))
+	ftc.Mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/files/content/home/journal.log", strings.NewReader("line 2\n"))
+	req.Header.Set("X-Write-Mode", "append")
+	c := CreateAuthenticatedContext(ftc.Echo, ftc.Recorder, req, "1", "testuser", false)
+	c.SetParamNames("*")
+	c.SetParamValues("home/journal.log")
+
+	if err := ftc.Handler.PatchFileContent(c); err != nil {
+		t.Fatalf("PatchFileContent returned error: %v", err)
+	}
+	AssertStatus(t, ftc.Recorder, http.StatusOK)
+
+	content, _ := os.ReadFile(filePath)
+	if string(content) != "line 1\nline 2\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+	if ftc.Recorder.Header().Get("ETag") == "" {
+		t.Error("expected ETag header")
+	}
+}
+
+func TestPatchFileContent_Range(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	filePath := filepath.Join(userDir, "data.bin")
+	ftc.CreateTestFile(t, filePath, []byte("abcdefgh"))
+
+	patch := func(contentRange, body, ifMatch string) int {
+		ftc.Mock.ExpectQuery("FROM file_locks").WillReturnRows(sqlmock.NewRows([]string{"username"}))
+		ftc.Recorder = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPatch, "/api/files/content/home/data.bin", strings.NewReader(body))
+		req.Header.Set("Content-Range", contentRange)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		c := CreateAuthenticatedContext(ftc.Echo, ftc.Recorder, req, "1", "testuser", false)
+		c.SetParamNames("*")
+		c.SetParamValues("home/data.bin")
+		if strings.HasSuffix(contentRange, "/8") && ifMatch != `"stale"` {
+			ftc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+		if err := ftc.Handler.PatchFileContent(c); err != nil {
+			t.Fatalf("PatchFileContent returned error: %v", err)
+		}
+		return ftc.Recorder.Code
+	}
+
+	// Range past EOF and a stale ETag are rejected without writing
+	if code := patch("bytes 6-9/*", "WXYZ", ""); code != http.StatusBadRequest {
+		t.Errorf("range past EOF: expected 400, got %d", code)
+	}
+	if code := patch("bytes 0-1/8", "AB", `"stale"`); code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: expected 412, got %d", code)
+	}
+
+	info, _ := os.Stat(filePath)
+	if code := patch("bytes 4-7/8", "EFGH", GenerateETag(filePath, info.ModTime(), info.Size())); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", code, ftc.Recorder.Body.String())
+	}
+	if content, _ := os.ReadFile(filePath); string(content) != "abcdEFGH" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestRestoredCopyPath(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "report.docx")
+
+	if got := restoredCopyPath(dest); got != filepath.Join(dir, "report (restored).docx") {
+		t.Errorf("unexpected first restored path: %s", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "report (restored).docx"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := restoredCopyPath(dest); got != filepath.Join(dir, "report (restored 2).docx") {
+		t.Errorf("unexpected second restored path: %s", got)
+	}
+
+	if got := restoredCopyPath(filepath.Join(dir, ".env")); got != filepath.Join(dir, ".env (restored)") {
+		t.Errorf("unexpected dotfile restored path: %s", got)
+	}
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// File history actions, normalized from audit event types
+const (
+	HistoryUpload = "upload"
+	HistoryEdit   = "edit"
+	HistoryRename = "rename"
+	HistoryMove   = "move"
+	HistoryCopy   = "copy"
+	HistoryShare  = "share"
+	HistoryCreate = "create"
+	HistoryDelete = "delete"
+)
+
+// fileHistoryActions maps the audit events shown in file history to actions
+var fileHistoryActions = map[string]string{
+	EventFileUpload:    HistoryUpload,
+	EventUploadDedup:   HistoryUpload,
+	EventFileEdit:      HistoryEdit,
+	EventFileAppend:    HistoryEdit,
+	EventFileOverwrite: HistoryEdit,
+	EventFileRename:    HistoryRename,
+	EventFileMove:      HistoryMove,
+	EventFileCopy:      HistoryCopy,
+	EventShareCreate:   HistoryShare,
+	EventFolderCreate:  HistoryCreate,
+	EventFileDelete:    HistoryDelete,
+	EventFolderDelete:  HistoryDelete,
+}
+
+// settingFileHistoryDays is how far back file history goes
+const settingFileHistoryDays = "file_history_days"
+
+const (
+	defaultFileHistoryDays = 180
+	// fileHistoryMaxPaths bounds how many renames and moves are followed back
+	fileHistoryMaxPaths = 20
+)
+
+// FileHistoryEvent is one change in a file's history
+type FileHistoryEvent struct {
+	ID          int64     `json:"id"`
+	Action      string    `json:"action"`
+	Time        time.Time `json:"time"`
+	Actor       string    `json:"actor,omitempty"` // Username
+	Path        string    `json:"path"`            // Path at the time of the event
+	OldName     string    `json:"oldName,omitempty"`
+	NewName     string    `json:"newName,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Size        *int64    `json:"size,omitempty"`
+	ShareType   string    `json:"shareType,omitempty"`
+	Source      string    `json:"source,omitempty"` // web, onlyoffice, ...
+}
+
+// FileHistory is the response of GET /api/files/history/*
+type FileHistory struct {
+	Path        string             `json:"path"`
+	FormerPaths []string           `json:"formerPaths"` // Earlier paths, most recent first
+	Events      []FileHistoryEvent `json:"events"`
+	Total       int                `json:"total"`
+	Limit       int                `json:"limit"`
+	Offset      int                `json:"offset"`
+	Days        int                `json:"days"`
+}
+
+// fileHistoryPath is a path an item had: events on it are part of the
+// item's history between after (the rename or move that gave the path to
+// the item) and before (the one that took it away)
+type fileHistoryPath struct {
+	path   string
+	after  *time.Time
+	before *time.Time
+}
+
+// fileHistoryDays returns the configured history window
+func fileHistoryDays() int {
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		if days := sh.GetSettingInt(settingFileHistoryDays, defaultFileHistoryDays); days > 0 {
+			return days
+		}
+	}
+	return defaultFileHistoryDays
+}
+
+// historyStoredPath returns the data-root relative form of a display path,
+// which share events are recorded under
+func historyStoredPath(displayPath, username string) string {
+	if rest, ok := strings.CutPrefix(displayPath, "/home"); ok {
+		return "users/" + username + rest
+	}
+	return strings.TrimPrefix(displayPath, "/")
+}
+
+// historyDisplayPath is the inverse of historyStoredPath for the requester's paths
+func historyDisplayPath(target, username string) string {
+	if rest, ok := strings.CutPrefix(target, "users/"+username); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		return "/home" + rest
+	}
+	if strings.HasPrefix(target, "shared/") {
+		return "/" + target
+	}
+	return target
+}
+
+// historyVisible reports whether the requester may see history recorded on
+// a path: their own home folder (events of other users' home folders use the
+// same /home paths and are filtered by actor), or a drive they can read
+func (h *Handler) historyVisible(claims *JWTClaims, displayPath, actorID string) bool {
+	switch {
+	case displayPath == "/home" || strings.HasPrefix(displayPath, "/home/"):
+		return actorID == claims.UserID
+	case strings.HasPrefix(displayPath, "/shared/"):
+		return h.CanReadSharedDrive(claims.UserID, displayPath)
+	}
+	return false
+}
+
+// fileHistoryChain follows an item back through the renames and moves
+// recorded in the audit log, its own and those of parent folders. Each step
+// is the most recent event that gave the path its current name.
+func (h *Handler) fileHistoryChain(claims *JWTClaims, displayPath string, since time.Time) ([]fileHistoryPath, error) {
+	chain := []fileHistoryPath{{path: displayPath}}
+	for len(chain) < fileHistoryMaxPaths {
+		current := &chain[len(chain)-1]
+		isHome := !strings.HasPrefix(current.path, "/shared/")
+
+		query := `
+			SELECT a.target_resource, COALESCE(a.details->>'newPath', a.details->>'destination'), a.ts, COALESCE(a.actor_id::text, '')
+			FROM audit_logs a
+			WHERE ((a.event_type = 'file.rename' AND a.details->>'newPath' = ANY($1))
+			    OR (a.event_type = 'file.move' AND a.details->>'destination' = ANY($1)))
+			  AND a.ts >= $2`
+		args := []interface{}{pq.Array(pathAncestors(current.path, 2)), since}
+		if current.before != nil {
+			args = append(args, *current.before)
+			query += fmt.Sprintf(" AND a.ts < $%d", len(args))
+		}
+		if isHome {
+			args = append(args, claims.UserID)
+			query += fmt.Sprintf(" AND a.actor_id = $%d", len(args))
+		}
+		query += " ORDER BY a.ts DESC, a.id DESC LIMIT 1"
+
+		var oldPath, newPath, actorID string
+		var ts time.Time
+		err := h.db.QueryRow(query, args...).Scan(&oldPath, &newPath, &ts, &actorID)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Before ts the path belonged to whatever was there earlier
+		current.after = &ts
+		former := oldPath + strings.TrimPrefix(current.path, newPath)
+		if !h.historyVisible(claims, former, actorID) {
+			break
+		}
+		chain = append(chain, fileHistoryPath{path: former, before: &ts})
+	}
+	return chain, nil
+}
+
+// fileHistoryWhere builds the audit_logs condition matching events on the
+// chain's paths, and with children on the direct children of folder paths
+func fileHistoryWhere(chain []fileHistoryPath, claims *JWTClaims, since time.Time, children bool) (string, []interface{}) {
+	actions := make([]string, 0, len(fileHistoryActions))
+	for event := range fileHistoryActions {
+		actions = append(actions, event)
+	}
+	sort.Strings(actions)
+	args := []interface{}{pq.Array(actions), since}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	var paths []string
+	for _, p := range chain {
+		var targets []string
+		for _, target := range []string{p.path, historyStoredPath(p.path, claims.Username)} {
+			targets = append(targets, "a.target_resource = "+arg(target))
+			if children {
+				prefix := target + "/"
+				targets = append(targets, fmt.Sprintf(
+					"(a.target_resource LIKE %s ESCAPE '\\' AND strpos(substr(a.target_resource, %s), '/') = 0)",
+					arg(escapeLikePattern(prefix)+"%"), arg(len(prefix)+1)))
+			}
+		}
+		cond := "(" + strings.Join(targets, " OR ") + ")"
+		if p.after != nil {
+			cond += " AND a.ts >= " + arg(*p.after)
+		}
+		if p.before != nil {
+			cond += " AND a.ts <= " + arg(*p.before)
+		}
+		if !strings.HasPrefix(p.path, "/shared/") {
+			cond += " AND a.actor_id = " + arg(claims.UserID)
+		}
+		paths = append(paths, "("+cond+")")
+	}
+	return "a.event_type = ANY($1) AND a.ts >= $2 AND (" + strings.Join(paths, " OR ") + ")", args
+}
+
+// escapeLikePattern escapes LIKE wildcards in a literal prefix
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// normalizeHistoryEvent turns an audit event into a file history event
+func normalizeHistoryEvent(e *FileHistoryEvent, eventType string, details map[string]interface{}, username string) {
+	e.Action = fileHistoryActions[eventType]
+	e.Path = historyDisplayPath(e.Path, username)
+	str := func(key string) string {
+		s, _ := details[key].(string)
+		return s
+	}
+	switch e.Action {
+	case HistoryRename:
+		e.OldName = path.Base(e.Path)
+		e.NewName = str("newName")
+	case HistoryMove, HistoryCopy:
+		e.Destination = str("destination")
+	case HistoryShare:
+		e.ShareType = str("shareType")
+	}
+	if size, ok := details["size"].(float64); ok {
+		n := int64(size)
+		e.Size = &n
+	}
+	e.Source = str("source")
+}
+
+// GetFileHistory returns the changes made to a file or folder
+// @Summary		File history
+// @Description	Lists uploads, edits, renames, moves, copies, shares, creation and deletion of a path from the audit log, following it back through renames and moves. Home folder items show the owner's own actions; shared drive items show every member's. With children=true, events on the direct children of a folder are included.
+// @Tags		Files
+// @Produce		json
+// @Param		path		path		string	true	"File or folder path"
+// @Param		children	query		bool	false	"Include direct children of a folder"
+// @Param		limit		query		int		false	"Maximum results (default 50, max 200)"
+// @Param		offset		query		int		false	"Offset"
+// @Success		200		{object}	FileHistory			"History"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/files/history/{path} [get]
+func (h *Handler) GetFileHistory(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	requestPath := c.Param("*")
+	if decodedPath, err := url.PathUnescape(requestPath); err == nil {
+		requestPath = decodedPath
+	}
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	virtualPath := "/" + requestPath
+
+	limit, offset := 50, 0
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(c.QueryParam("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	realPath, storageType, displayPath, err := h.resolvePath(virtualPath, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if storageType != StorageHome && storageType != StorageShared {
+		return RespondError(c, ErrBadRequest("History is only available for home and shared drive items"))
+	}
+	if storageType == StorageShared && !h.CanReadSharedDrive(claims.UserID, displayPath) {
+		return RespondError(c, ErrForbidden("No permission to access this path"))
+	}
+	children := false
+	if c.QueryParam("children") == "true" {
+		if info, err := os.Stat(realPath); err == nil && info.IsDir() {
+			children = true
+		}
+	}
+
+	days := fileHistoryDays()
+	since := time.Now().AddDate(0, 0, -days)
+	chain, err := h.fileHistoryChain(claims, displayPath, since)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load file history"))
+	}
+	where, args := fileHistoryWhere(chain, claims, since, children)
+
+	history := FileHistory{Path: displayPath, FormerPaths: []string{}, Events: []FileHistoryEvent{}, Limit: limit, Offset: offset, Days: days}
+	for _, p := range chain[1:] {
+		history.FormerPaths = append(history.FormerPaths, p.path)
+	}
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM audit_logs a WHERE `+where, args...).Scan(&history.Total); err != nil {
+		return RespondError(c, ErrInternal("Failed to load file history"))
+	}
+
+	args = append(args, limit, offset)
+	rows, err := h.db.Query(`
+		SELECT a.id, a.ts, a.event_type, a.target_resource, a.details, COALESCE(u.username, '')
+		FROM audit_logs a
+		LEFT JOIN users u ON u.id = a.actor_id
+		WHERE `+where+fmt.Sprintf(`
+		ORDER BY a.ts DESC, a.id DESC
+		LIMIT $%d OFFSET $%d`, len(args)-1, len(args)), args...)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load file history"))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e FileHistoryEvent
+		var eventType string
+		var detailsJSON []byte
+		if err := rows.Scan(&e.ID, &e.Time, &eventType, &e.Path, &detailsJSON, &e.Actor); err != nil {
+			return RespondError(c, ErrInternal("Failed to load file history"))
+		}
+		var details map[string]interface{}
+		_ = json.Unmarshal(detailsJSON, &details)
+		normalizeHistoryEvent(&e, eventType, details, claims.Username)
+		history.Events = append(history.Events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return RespondError(c, ErrInternal("Failed to load file history"))
+	}
+
+	return RespondSuccess(c, history)
+}
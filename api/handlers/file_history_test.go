@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// getFileHistory requests the history of path as alice
+func getFileHistory(t *testing.T, tc *TestContext, h *Handler, path string) FileHistory {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/files/history/"+path, nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	c.SetParamNames("*")
+	c.SetParamValues(path)
+	if err := h.GetFileHistory(c); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Data FileHistory `json:"data"`
+	}
+	_ = json.Unmarshal(tc.Recorder.Body.Bytes(), &resp)
+	return resp.Data
+}
+
+func TestGetFileHistory_FollowsRenames(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	renamed := time.Date(2026, 9, 20, 10, 0, 0, 0, time.UTC)
+	folderRenamed := time.Date(2026, 9, 10, 10, 0, 0, 0, time.UTC)
+	chainColumns := []string{"target_resource", "new_path", "ts", "actor_id"}
+
+	// b.txt was a.txt, and its folder was /home/old
+	tc.Mock.ExpectQuery("file.rename").
+		WithArgs(pq.Array([]string{"/home/docs/b.txt", "/home/docs"}), sqlmock.AnyArg(), "u1").
+		WillReturnRows(sqlmock.NewRows(chainColumns).AddRow("/home/docs/a.txt", "/home/docs/b.txt", renamed, "u1"))
+	tc.Mock.ExpectQuery("file.rename").
+		WithArgs(pq.Array([]string{"/home/docs/a.txt", "/home/docs"}), sqlmock.AnyArg(), renamed, "u1").
+		WillReturnRows(sqlmock.NewRows(chainColumns).AddRow("/home/old", "/home/docs", folderRenamed, "u1"))
+	tc.Mock.ExpectQuery("file.rename").
+		WithArgs(pq.Array([]string{"/home/old/a.txt", "/home/old"}), sqlmock.AnyArg(), folderRenamed, "u1").
+		WillReturnRows(sqlmock.NewRows(chainColumns))
+
+	tc.Mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	tc.Mock.ExpectQuery("FROM audit_logs a\\s+LEFT JOIN users u").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ts", "event_type", "target_resource", "details", "username"}).
+			AddRow(4, renamed.Add(time.Hour), EventShareCreate, "users/alice/docs/b.txt", []byte(`{"shareType":"download"}`), "alice").
+			AddRow(3, renamed.Add(time.Minute), EventFileEdit, "/home/docs/b.txt", []byte(`{"size":12,"source":"onlyoffice"}`), "alice").
+			AddRow(2, renamed, EventFileRename, "/home/docs/a.txt", []byte(`{"newName":"b.txt","newPath":"/home/docs/b.txt"}`), "alice").
+			AddRow(1, folderRenamed.Add(-time.Hour), EventFileUpload, "/home/old/a.txt", []byte(`{"size":10,"source":"web"}`), "alice"))
+
+	history := getFileHistory(t, tc, h, "home/docs/b.txt")
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history.FormerPaths) != 2 || history.FormerPaths[0] != "/home/docs/a.txt" || history.FormerPaths[1] != "/home/old/a.txt" {
+		t.Errorf("former paths = %v", history.FormerPaths)
+	}
+	if history.Total != 4 || len(history.Events) != 4 {
+		t.Fatalf("total %d, events %v", history.Total, history.Events)
+	}
+	share, edit, rename, upload := history.Events[0], history.Events[1], history.Events[2], history.Events[3]
+	if share.Action != HistoryShare || share.Path != "/home/docs/b.txt" || share.ShareType != "download" {
+		t.Errorf("share = %+v", share)
+	}
+	if edit.Action != HistoryEdit || edit.Source != "onlyoffice" || edit.Size == nil || *edit.Size != 12 {
+		t.Errorf("edit = %+v", edit)
+	}
+	if rename.Action != HistoryRename || rename.OldName != "a.txt" || rename.NewName != "b.txt" {
+		t.Errorf("rename = %+v", rename)
+	}
+	if upload.Action != HistoryUpload || upload.Actor != "alice" || upload.Path != "/home/old/a.txt" {
+		t.Errorf("upload = %+v", upload)
+	}
+}
+
+func TestGetFileHistory_SharedDriveWithoutAccess(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	tc.Mock.ExpectQuery("FROM shared_folder_access").WillReturnRows(sqlmock.NewRows([]string{"permission_level", "id"}))
+
+	getFileHistory(t, tc, h, "shared/Finance/budget.xlsx")
+	AssertStatus(t, tc.Recorder, http.StatusForbidden)
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileHistoryWhere(t *testing.T) {
+	claims := &JWTClaims{UserID: "u1", Username: "alice"}
+	before := time.Now()
+	chain := []fileHistoryPath{{path: "/shared/Team/a_b"}, {path: "/home/a_b", before: &before}}
+
+	where, args := fileHistoryWhere(chain, claims, before.AddDate(0, 0, -30), true)
+	for _, want := range []interface{}{"/shared/Team/a_b", "shared/Team/a_b", `/shared/Team/a\_b/%`, "/home/a_b", "users/alice/a_b", "u1"} {
+		found := false
+		for _, arg := range args {
+			if arg == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing argument %v in %v", want, args)
+		}
+	}
+	// Only the home path is restricted to the requester's own events
+	if n := len(args); args[n-1] != "u1" {
+		t.Errorf("last argument = %v, want the actor filter", args[n-1])
+	}
+	if where == "" {
+		t.Error("empty condition")
+	}
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FileLinkExt is the extension of link files. SMB clients see them as small
+// JSON files; the web UI and API follow them to their target.
+const FileLinkExt = ".fhlink"
+
+// maxFileLinkSize bounds how much of a link file is read
+const maxFileLinkSize = 4096
+
+// FileLink is the content of a .fhlink file
+type FileLink struct {
+	Target    string    `json:"target"` // Data-root relative path, e.g. "users/alice/docs/a.pdf" or "shared/team/a.pdf"
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// isFileLink reports whether a file name is a link file
+func isFileLink(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), FileLinkExt)
+}
+
+// readFileLink reads and validates a link file
+func readFileLink(realPath string) (*FileLink, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxFileLinkSize))
+	if err != nil {
+		return nil, err
+	}
+	var link FileLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return nil, fmt.Errorf("invalid link file: %w", err)
+	}
+	target, err := validateAndCleanPath(link.Target)
+	if err != nil || (!strings.HasPrefix(target, "users/") && !strings.HasPrefix(target, "shared/")) {
+		return nil, fmt.Errorf("invalid link target %q", link.Target)
+	}
+	link.Target = target
+	return &link, nil
+}
+
+// writeFileLink writes a link file
+func writeFileLink(realPath string, link *FileLink) error {
+	data, err := json.MarshalIndent(link, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileSealed(realPath, append(data, '\n'), 0644)
+}
+
+// FileLinkRegistry indexes link files by target, so moving or renaming a
+// target through the API can update the links pointing at it. The link
+// file on disk stays the source of truth for its target.
+type FileLinkRegistry struct {
+	db       *sql.DB
+	dataRoot string
+}
+
+var globalFileLinks *FileLinkRegistry
+
+// InitFileLinks creates the global link registry
+func InitFileLinks(db *sql.DB, dataRoot string) *FileLinkRegistry {
+	globalFileLinks = &FileLinkRegistry{db: db, dataRoot: dataRoot}
+	return globalFileLinks
+}
+
+// GetFileLinks returns the global link registry (nil if not initialized)
+func GetFileLinks() *FileLinkRegistry {
+	return globalFileLinks
+}
+
+// relPath converts a real path to the data-root relative key used in file_links
+func (r *FileLinkRegistry) relPath(realPath string) (string, bool) {
+	rel, err := dataRootRel(r.dataRoot, realPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// Register records a link file, or updates its target if the file changed
+// outside the API (copied, restored from trash)
+func (r *FileLinkRegistry) Register(linkRealPath, target, userID string) {
+	if r == nil {
+		return
+	}
+	rel, ok := r.relPath(linkRealPath)
+	if !ok {
+		return
+	}
+	var createdBy interface{}
+	if userID != "" {
+		createdBy = userID
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO file_links (link_path, target_path, created_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (link_path) DO UPDATE SET target_path = EXCLUDED.target_path
+		WHERE file_links.target_path <> EXCLUDED.target_path
+	`, rel, target, createdBy)
+	if err != nil {
+		log.Printf("[FileLinks] Failed to register %s: %v", rel, err)
+	}
+}
+
+// MovePath follows a rename or move: links inside the moved item keep their
+// index entry, and links whose target is the moved item (or inside it) are
+// rewritten to point at the new location
+func (r *FileLinkRegistry) MovePath(oldRealPath, newRealPath string) {
+	if r == nil {
+		return
+	}
+	oldRel, ok1 := r.relPath(oldRealPath)
+	newRel, ok2 := r.relPath(newRealPath)
+	if !ok1 || !ok2 {
+		return
+	}
+
+	if _, err := r.db.Exec(`
+		UPDATE file_links
+		SET link_path = $2 || substr(link_path, length($1) + 1)
+		WHERE link_path = $1 OR starts_with(link_path, $1 || '/')
+	`, oldRel, newRel); err != nil {
+		log.Printf("[FileLinks] Failed to move links %s -> %s: %v", oldRel, newRel, err)
+		return
+	}
+
+	rows, err := r.db.Query(`
+		UPDATE file_links
+		SET target_path = $2 || substr(target_path, length($1) + 1)
+		WHERE target_path = $1 OR starts_with(target_path, $1 || '/')
+		RETURNING link_path, target_path
+	`, oldRel, newRel)
+	if err != nil {
+		log.Printf("[FileLinks] Failed to retarget links %s -> %s: %v", oldRel, newRel, err)
+		return
+	}
+	type retarget struct{ linkPath, target string }
+	var updated []retarget
+	for rows.Next() {
+		var u retarget
+		if err := rows.Scan(&u.linkPath, &u.target); err == nil {
+			updated = append(updated, u)
+		}
+	}
+	rows.Close()
+
+	for _, u := range updated {
+		linkRealPath := filepath.Join(r.dataRoot, filepath.FromSlash(u.linkPath))
+		link, err := readFileLink(linkRealPath)
+		if err != nil {
+			continue // Link file is gone or was replaced; listing re-registers it
+		}
+		link.Target = u.target
+		if err := writeFileLink(linkRealPath, link); err != nil {
+			log.Printf("[FileLinks] Failed to update %s: %v", u.linkPath, err)
+		}
+	}
+}
+
+// ForgetTree drops index entries of link files at or below a deleted path.
+// Targets are never touched; links to a deleted target show as broken.
+func (r *FileLinkRegistry) ForgetTree(realPath string) {
+	if r == nil {
+		return
+	}
+	rel, ok := r.relPath(realPath)
+	if !ok {
+		return
+	}
+	if _, err := r.db.Exec(`
+		DELETE FROM file_links WHERE link_path = $1 OR starts_with(link_path, $1 || '/')
+	`, rel); err != nil {
+		log.Printf("[FileLinks] Failed to forget links under %s: %v", rel, err)
+	}
+}
+
+// linkTargetVirtualPath returns the target as a path in the viewer's own
+// namespace, or "" if it lives in another user's home
+func linkTargetVirtualPath(target string, claims *JWTClaims) string {
+	parts := strings.SplitN(target, "/", 3)
+	switch {
+	case parts[0] == "shared":
+		return "/" + target
+	case parts[0] == "users" && len(parts) >= 2 && claims != nil && parts[1] == claims.Username:
+		if len(parts) == 3 {
+			return "/home/" + parts[2]
+		}
+		return "/home"
+	}
+	return ""
+}
+
+// resolveFileLink resolves a link to its target's real path. Permissions are
+// checked against the target: shared drive access, the viewer's own home, or
+// a file share from the owner of another home.
+func (h *Handler) resolveFileLink(link *FileLink, claims *JWTClaims) (string, *APIError) {
+	if claims == nil {
+		return "", ErrUnauthorized("Authentication required")
+	}
+	realPath := filepath.Join(h.dataRoot, filepath.FromSlash(link.Target))
+	if !isPathWithinRoot(realPath, h.dataRoot) {
+		return "", ErrForbidden("Invalid link target")
+	}
+
+	parts := strings.SplitN(link.Target, "/", 3)
+	switch {
+	case parts[0] == "shared":
+		if !h.CanReadSharedDrive(claims.UserID, "/"+link.Target) {
+			return "", ErrForbidden("No permission to access the link target")
+		}
+	case parts[0] == "users" && len(parts) >= 2 && parts[1] == claims.Username:
+		// Viewer's own home
+	case parts[0] == "users" && len(parts) == 3:
+		ownerPath := "/home/" + parts[2]
+		sharedRealPath, owner, err := h.GetSharedFileOwnerPath(claims.UserID, ownerPath)
+		if err != nil || owner != parts[1] || sharedRealPath != realPath || !h.CanReadSharedFile(claims.UserID, ownerPath) {
+			return "", ErrForbidden("No permission to access the link target")
+		}
+	default:
+		return "", ErrForbidden("No permission to access the link target")
+	}
+	return realPath, nil
+}
+
+// followFileLink returns the target of realPath if it is a link file, or
+// realPath itself otherwise. Directory targets are returned as-is; callers
+// decide whether they accept them.
+func (h *Handler) followFileLink(realPath string, claims *JWTClaims) (string, *APIError) {
+	if !isFileLink(realPath) {
+		return realPath, nil
+	}
+	info, err := os.Stat(realPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return realPath, nil
+	}
+	link, err := readFileLink(realPath)
+	if err != nil {
+		return "", ErrBadRequest(err.Error())
+	}
+	targetPath, apiErr := h.resolveFileLink(link, claims)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
+		return "", NewAPIError(ErrCodeNotFound, "Link target no longer exists").WithDetails(map[string]string{
+			"target": linkTargetVirtualPath(link.Target, claims),
+		})
+	}
+	return targetPath, nil
+}
+
+// describeFileLink fills the link fields of a listing entry. Link files can
+// be uploaded by hand, so the target is only looked at, and the link only
+// registered, once the viewer may read it.
+func (h *Handler) describeFileLink(entry *FileInfo, realPath string, claims *JWTClaims) {
+	link, err := readFileLink(realPath)
+	if err != nil {
+		return
+	}
+	entry.IsLink = true
+	targetPath, apiErr := h.resolveFileLink(link, claims)
+	if apiErr != nil {
+		entry.LinkInaccessible = true
+		return
+	}
+	entry.LinkTarget = linkTargetVirtualPath(link.Target, claims)
+	if _, err := os.Stat(targetPath); err != nil {
+		entry.LinkBroken = true
+	}
+	GetFileLinks().Register(realPath, link.Target, "")
+}
+
+// CreateFileLinkRequest is the request body for creating a link
+type CreateFileLinkRequest struct {
+	Target      string `json:"target"`         // Virtual path of the file or folder to link to
+	Destination string `json:"destination"`    // Folder the link is created in
+	Name        string `json:"name,omitempty"` // Optional link name; defaults to the target name
+}
+
+// CreateFileLink creates a .fhlink file pointing at a file or folder
+// @Summary		Create file link
+// @Description	Creates a link (.fhlink) in a folder that points at another file or folder. Listings mark it with isLink and linkTarget; opening, previewing or downloading it serves the target, with permissions checked against the target.
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		request	body		CreateFileLinkRequest	true	"Target and destination"
+// @Success		201		{object}	docs.SuccessResponse	"Link created"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Target or destination not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Item already exists"
+// @Security	BearerAuth
+// @Router		/files/link [post]
+func (h *Handler) CreateFileLink(c echo.Context) error {
+	var req CreateFileLinkRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if req.Target == "" {
+		return RespondError(c, ErrMissingParameter("target"))
+	}
+	if req.Destination == "" {
+		return RespondError(c, ErrMissingParameter("destination"))
+	}
+
+	claims, ok := c.Get("user").(*JWTClaims)
+	if !ok || claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+
+	// Target: the creator must be able to read it
+	targetReal, targetStorage, targetDisplay, err := h.resolvePath(req.Target, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if targetStorage != StorageHome && targetStorage != StorageShared {
+		return RespondError(c, ErrBadRequest("Links can only point into home or shared drives"))
+	}
+	if targetStorage == StorageShared && !h.CanReadSharedDrive(claims.UserID, targetDisplay) {
+		return RespondError(c, ErrForbidden("No permission to access the target"))
+	}
+	if _, err := os.Stat(targetReal); err != nil {
+		if os.IsNotExist(err) {
+			return RespondError(c, ErrNotFound("Target not found"))
+		}
+		return RespondError(c, ErrOperationFailed("access target", err))
+	}
+	if isFileLink(targetReal) {
+		return RespondError(c, ErrBadRequest("Cannot link to a link"))
+	}
+	targetRel, err := dataRootRel(h.dataRoot, targetReal)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath("Invalid target"))
+	}
+	targetRel = filepath.ToSlash(targetRel)
+
+	// Destination: the creator must be able to write there
+	destReal, destStorage, destDisplay, err := h.resolvePath(req.Destination, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if destStorage != StorageHome && destStorage != StorageShared {
+		return RespondError(c, ErrBadRequest("Links can only be created in home or shared drives"))
+	}
+	if apiErr := mountInWriteError(destDisplay); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(destReal); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if destStorage == StorageShared && !h.CanWriteSharedDrive(claims.UserID, destDisplay) {
+		return RespondError(c, ErrForbidden("No permission to write to the destination"))
+	}
+	if info, err := os.Stat(destReal); err != nil || !info.IsDir() {
+		return RespondError(c, ErrNotFound("Destination folder not found"))
+	}
+
+	name := req.Name
+	if name == "" {
+		name = filepath.Base(targetReal)
+	}
+	if !isFileLink(name) {
+		name += FileLinkExt
+	}
+	if strings.ContainsAny(name, `/\:*?"<>|`) || strings.HasPrefix(name, ".") {
+		return RespondError(c, ErrBadRequest("Invalid name"))
+	}
+	linkReal := filepath.Join(destReal, name)
+	if _, err := os.Lstat(linkReal); err == nil {
+		return RespondError(c, ErrAlreadyExists("An item with that name already exists"))
+	}
+
+	link := &FileLink{Target: targetRel, CreatedBy: claims.UserID, CreatedAt: time.Now()}
+	if err := writeFileLink(linkReal, link); err != nil {
+		return RespondError(c, encryptionAPIError("create link", err))
+	}
+	GetFileLinks().Register(linkReal, targetRel, claims.UserID)
+	GetChangeJournal().Record(ChangeCreate, linkReal, "", changeActor(claims))
+	GetListingVersions().Invalidate(linkReal)
+	GetWriterHints().NoteAPI(linkReal, claims)
+
+	linkDisplay := filepath.Join(destDisplay, name)
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventFileLink, linkDisplay, map[string]interface{}{
+		"target": targetDisplay,
+	})
+
+	return RespondCreated(c, map[string]interface{}{
+		"path":       linkDisplay,
+		"name":       name,
+		"linkTarget": targetDisplay,
+	})
+}
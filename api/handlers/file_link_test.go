@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// setupLinkFixture creates alice's home with docs/ref.txt and a link to it in proj/
+func setupLinkFixture(t *testing.T) (dataRoot, linkPath, targetPath string) {
+	t.Helper()
+	dataRoot = t.TempDir()
+	home := filepath.Join(dataRoot, "users", "alice")
+	for _, dir := range []string{"docs", "proj"} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	targetPath = filepath.Join(home, "docs", "ref.txt")
+	if err := os.WriteFile(targetPath, []byte("reference"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	linkPath = filepath.Join(home, "proj", "ref.txt"+FileLinkExt)
+	if err := writeFileLink(linkPath, &FileLink{Target: "users/alice/docs/ref.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	return dataRoot, linkPath, targetPath
+}
+
+func TestFileLink_GetFileServesTarget(t *testing.T) {
+	dataRoot, _, _ := setupLinkFixture(t)
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &Handler{db: tc.DB, dataRoot: dataRoot}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/files/home/proj/ref.txt.fhlink", nil)
+	c := tc.Echo.NewContext(req, tc.Recorder)
+	c.SetParamNames("*")
+	c.SetParamValues("home/proj/ref.txt.fhlink")
+	c.Set("user", &JWTClaims{UserID: "u1", Username: "alice"})
+
+	if err := h.GetFile(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if body := tc.Recorder.Body.String(); body != "reference" {
+		t.Errorf("body = %q, want target content", body)
+	}
+}
+
+func TestFileLink_BrokenAndForeignTargets(t *testing.T) {
+	dataRoot, linkPath, targetPath := setupLinkFixture(t)
+	h := &Handler{dataRoot: dataRoot}
+	alice := &JWTClaims{UserID: "u1", Username: "alice"}
+
+	var entry FileInfo
+	h.describeFileLink(&entry, linkPath, alice)
+	if !entry.IsLink || entry.LinkTarget != "/home/docs/ref.txt" || entry.LinkBroken {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	// Deleting the target leaves the link in place, reported as broken
+	if err := os.Remove(targetPath); err != nil {
+		t.Fatal(err)
+	}
+	entry = FileInfo{}
+	h.describeFileLink(&entry, linkPath, alice)
+	if !entry.LinkBroken {
+		t.Error("link to a deleted target should be broken")
+	}
+	if _, apiErr := h.followFileLink(linkPath, alice); apiErr == nil || apiErr.Code != ErrCodeNotFound {
+		t.Errorf("follow broken link: %v, want NOT_FOUND", apiErr)
+	}
+	if _, err := os.Stat(linkPath); err != nil {
+		t.Errorf("link file should remain: %v", err)
+	}
+
+	// Another user's target is only visible through their own namespace
+	if got := linkTargetVirtualPath("users/alice/docs/ref.txt", &JWTClaims{Username: "bob"}); got != "" {
+		t.Errorf("linkTarget for bob = %q, want empty", got)
+	}
+	if _, apiErr := h.followFileLink(linkPath, nil); apiErr == nil || apiErr.Code != ErrCodeUnauthorized {
+		t.Errorf("anonymous follow: %v, want UNAUTHORIZED", apiErr)
+	}
+}
+
+func TestFileLink_ForgedLinkRevealsNothing(t *testing.T) {
+	dataRoot, _, _ := setupLinkFixture(t)
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &Handler{db: tc.DB, dataRoot: dataRoot}
+	writeTestFiles(t, dataRoot, "shared/Board/minutes.txt")
+	// A registry without a database panics if the link gets registered
+	previous := globalFileLinks
+	globalFileLinks = &FileLinkRegistry{dataRoot: dataRoot}
+	t.Cleanup(func() { globalFileLinks = previous })
+	bob := &JWTClaims{UserID: "u2", Username: "bob"}
+
+	// Hand-written links in bob's home to alice's file and a drive he isn't in
+	tc.Mock.ExpectQuery("FROM file_shares").WithArgs("u2", "/home/docs/ref.txt").WillReturnRows(sqlmock.NewRows([]string{"item_path", "username"}))
+	tc.Mock.ExpectQuery("FROM file_shares").WithArgs("u2").WillReturnRows(sqlmock.NewRows([]string{"item_path", "username", "is_folder"}))
+	tc.Mock.ExpectQuery("FROM shared_folder_access").WithArgs("Board", "u2").WillReturnRows(sqlmock.NewRows([]string{"permission_level", "id"}))
+
+	for _, target := range []string{"users/alice/docs/ref.txt", "shared/Board/minutes.txt"} {
+		linkPath := filepath.Join(dataRoot, "users", "bob", "probe"+FileLinkExt)
+		if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFileLink(linkPath, &FileLink{Target: target}); err != nil {
+			t.Fatal(err)
+		}
+		entry := FileInfo{}
+		h.describeFileLink(&entry, linkPath, bob)
+		if !entry.IsLink || !entry.LinkInaccessible || entry.LinkBroken || entry.LinkTarget != "" {
+			t.Errorf("%s: entry = %+v", target, entry)
+		}
+	}
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadFileLink_RejectsEscapingTargets(t *testing.T) {
+	dir := t.TempDir()
+	for _, target := range []string{"../etc/passwd", "/etc/passwd", "tmp/x", ""} {
+		p := filepath.Join(dir, "bad"+FileLinkExt)
+		if err := os.WriteFile(p, []byte(`{"target":"`+target+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readFileLink(p); err == nil {
+			t.Errorf("target %q should be rejected", target)
+		}
+	}
+}
+
+func TestFileLinkRegistry_MovePathRewritesLinks(t *testing.T) {
+	dataRoot, linkPath, targetPath := setupLinkFixture(t)
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	r := &FileLinkRegistry{db: tc.DB, dataRoot: dataRoot}
+
+	tc.Mock.ExpectExec("UPDATE file_links SET link_path").
+		WithArgs("users/alice/docs", "users/alice/archive").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectQuery("UPDATE file_links SET target_path").
+		WithArgs("users/alice/docs", "users/alice/archive").
+		WillReturnRows(sqlmock.NewRows([]string{"link_path", "target_path"}).
+			AddRow("users/alice/proj/ref.txt.fhlink", "users/alice/archive/ref.txt"))
+
+	newDir := filepath.Join(dataRoot, "users", "alice", "archive")
+	if err := os.Rename(filepath.Dir(targetPath), newDir); err != nil {
+		t.Fatal(err)
+	}
+	r.MovePath(filepath.Dir(targetPath), newDir)
+
+	link, err := readFileLink(linkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Target != "users/alice/archive/ref.txt" {
+		t.Errorf("target = %q after move", link.Target)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
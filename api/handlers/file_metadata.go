@@ -3,11 +3,14 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 )
 
 type FileMetadataHandler struct {
@@ -198,14 +201,21 @@ func (h *FileMetadataHandler) DeleteFileMetadata(c echo.Context) error {
 	})
 }
 
-// ListUserTags returns all unique tags used by the user (for autocomplete)
+// TagUsage is a tag and the number of files carrying it
+type TagUsage struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ListUserTags returns the user's tags with usage counts (for autocomplete and tag management)
 func (h *FileMetadataHandler) ListUserTags(c echo.Context) error {
 	claims := c.Get("user").(*JWTClaims)
 
 	rows, err := h.db.Query(`
-		SELECT DISTINCT jsonb_array_elements_text(tags) as tag
-		FROM file_metadata
-		WHERE user_id = $1
+		SELECT tag, COUNT(*)
+		FROM file_metadata, jsonb_array_elements_text(tags) AS tag
+		WHERE user_id = $1 AND jsonb_typeof(tags) = 'array'
+		GROUP BY tag
 		ORDER BY tag
 	`, claims.UserID)
 
@@ -216,11 +226,11 @@ func (h *FileMetadataHandler) ListUserTags(c echo.Context) error {
 	}
 	defer rows.Close()
 
-	tags := []string{}
+	tags := []TagUsage{}
 	for rows.Next() {
-		var tag string
-		if err := rows.Scan(&tag); err == nil {
-			tags = append(tags, tag)
+		var usage TagUsage
+		if err := rows.Scan(&usage.Tag, &usage.Count); err == nil {
+			tags = append(tags, usage)
 		}
 	}
 
@@ -329,3 +339,273 @@ func (h *FileMetadataHandler) GetBatchMetadata(c echo.Context) error {
 		"metadata": result,
 	})
 }
+
+const (
+	// maxTagLength bounds the length of a single tag
+	maxTagLength = 100
+	// maxTagApplyPaths bounds the number of files in one bulk tag request
+	maxTagApplyPaths = 10000
+)
+
+// normalizeTags trims tags and drops empty and duplicate entries
+func normalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag too long (max %d characters): %s", maxTagLength, tag)
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result, nil
+}
+
+// ApplyTagsRequest adds and removes tags on many files at once
+type ApplyTagsRequest struct {
+	Paths      []string `json:"paths"`
+	AddTags    []string `json:"addTags"`
+	RemoveTags []string `json:"removeTags"`
+}
+
+// TagApplyResult is the outcome for one file of a bulk tag request
+type TagApplyResult struct {
+	Path   string   `json:"path"`
+	Status string   `json:"status"` // created, updated, unchanged
+	Tags   []string `json:"tags"`   // Tags after the change; empty when unchanged
+}
+
+// ApplyTags adds and removes tags on many files in one statement. Files
+// without metadata get a row when tags are added; removing tags never
+// creates rows.
+func (h *FileMetadataHandler) ApplyTags(c echo.Context) error {
+	claims := c.Get("user").(*JWTClaims)
+
+	var req ApplyTagsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request",
+		})
+	}
+	if len(req.Paths) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Paths required",
+		})
+	}
+	if len(req.Paths) > maxTagApplyPaths {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Too many paths (max %d)", maxTagApplyPaths),
+		})
+	}
+
+	addTags, err := normalizeTags(req.AddTags)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	removeTags, err := normalizeTags(req.RemoveTags)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if len(addTags) == 0 && len(removeTags) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "addTags or removeTags required",
+		})
+	}
+
+	// A tag both added and removed is removed
+	removeSet := make(map[string]bool, len(removeTags))
+	for _, tag := range removeTags {
+		removeSet[tag] = true
+	}
+	filtered := addTags[:0]
+	for _, tag := range addTags {
+		if !removeSet[tag] {
+			filtered = append(filtered, tag)
+		}
+	}
+	addTags = filtered
+
+	paths := make([]string, 0, len(req.Paths))
+	seenPaths := make(map[string]bool, len(req.Paths))
+	for _, path := range req.Paths {
+		if path == "" {
+			continue
+		}
+		if path[0] != '/' {
+			path = "/" + path
+		}
+		if !seenPaths[path] {
+			seenPaths[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	// New tags go after the existing ones, in request order, skipping tags
+	// the file already has
+	var rows *sql.Rows
+	if len(addTags) > 0 {
+		addJSON, _ := json.Marshal(addTags)
+		rows, err = h.db.Query(`
+			INSERT INTO file_metadata (user_id, file_path, tags, updated_at)
+			SELECT $1, p, $4::jsonb, NOW() FROM unnest($2::text[]) AS p
+			ON CONFLICT (user_id, file_path) DO UPDATE SET
+				tags = (file_metadata.tags - $5::text[]) || (
+					SELECT COALESCE(jsonb_agg(a.tag ORDER BY a.ord), '[]'::jsonb)
+					FROM unnest($3::text[]) WITH ORDINALITY AS a(tag, ord)
+					WHERE NOT file_metadata.tags ? a.tag
+				),
+				updated_at = NOW()
+			RETURNING file_path, tags, (xmax = 0) AS inserted
+		`, claims.UserID, pq.Array(paths), pq.Array(addTags), addJSON, pq.Array(removeTags))
+	} else {
+		rows, err = h.db.Query(`
+			UPDATE file_metadata SET tags = tags - $3::text[], updated_at = NOW()
+			WHERE user_id = $1 AND file_path = ANY($2::text[]) AND tags ?| $3::text[]
+			RETURNING file_path, tags, FALSE AS inserted
+		`, claims.UserID, pq.Array(paths), pq.Array(removeTags))
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to apply tags",
+		})
+	}
+	defer rows.Close()
+
+	changed := make(map[string]TagApplyResult, len(paths))
+	for rows.Next() {
+		var result TagApplyResult
+		var tagsJSON []byte
+		var inserted bool
+		if err := rows.Scan(&result.Path, &tagsJSON, &inserted); err != nil {
+			continue
+		}
+		_ = json.Unmarshal(tagsJSON, &result.Tags)
+		if result.Tags == nil {
+			result.Tags = []string{}
+		}
+		result.Status = "updated"
+		if inserted {
+			result.Status = "created"
+		}
+		changed[result.Path] = result
+	}
+	if err := rows.Err(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to apply tags",
+		})
+	}
+
+	results := make([]TagApplyResult, 0, len(paths))
+	for _, path := range paths {
+		result, ok := changed[path]
+		if !ok {
+			result = TagApplyResult{Path: path, Status: "unchanged", Tags: []string{}}
+		}
+		results = append(results, result)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"results": results,
+		"changed": len(changed),
+		"total":   len(results),
+	})
+}
+
+// RenameTagRequest renames a tag on all of the user's files
+type RenameTagRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RenameTag replaces a tag on all of the user's files. Files that already
+// carry the new tag keep a single copy.
+func (h *FileMetadataHandler) RenameTag(c echo.Context) error {
+	claims := c.Get("user").(*JWTClaims)
+
+	var req RenameTagRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid request",
+		})
+	}
+	// Empty or identical names collapse to fewer than two tags
+	names, err := normalizeTags([]string{req.From, req.To})
+	if err != nil || len(names) != 2 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "from and to must be different, non-empty tags",
+		})
+	}
+	from, to := names[0], names[1]
+
+	// Replace in place to keep the tag order
+	result, err := h.db.Exec(`
+		UPDATE file_metadata SET
+			tags = (
+				SELECT COALESCE(jsonb_agg(t.tag ORDER BY t.ord), '[]'::jsonb)
+				FROM (
+					SELECT DISTINCT ON (tag) tag, ord
+					FROM (
+						SELECT CASE WHEN e.tag = $2 THEN $3 ELSE e.tag END AS tag, e.ord
+						FROM jsonb_array_elements_text(file_metadata.tags) WITH ORDINALITY AS e(tag, ord)
+					) renamed
+					ORDER BY tag, ord
+				) t
+			),
+			updated_at = NOW()
+		WHERE user_id = $1 AND tags ? $2
+	`, claims.UserID, from, to)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to rename tag",
+		})
+	}
+	affected, _ := result.RowsAffected()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"from":    from,
+		"to":      to,
+		"updated": affected,
+	})
+}
+
+// DeleteTag removes a tag from all of the user's files
+func (h *FileMetadataHandler) DeleteTag(c echo.Context) error {
+	claims := c.Get("user").(*JWTClaims)
+
+	tag := c.Param("tag")
+	if decoded, err := url.PathUnescape(tag); err == nil {
+		tag = decoded
+	}
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Tag required",
+		})
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE file_metadata SET tags = tags - $2::text, updated_at = NOW()
+		WHERE user_id = $1 AND tags ? $2
+	`, claims.UserID, tag)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete tag",
+		})
+	}
+	affected, _ := result.RowsAffected()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"tag":     tag,
+		"updated": affected,
+	})
+}
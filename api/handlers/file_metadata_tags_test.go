@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" work ", "", "work", "urgent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0] != "work" || tags[1] != "urgent" {
+		t.Errorf("tags = %v, want [work urgent]", tags)
+	}
+
+	long := make([]byte, maxTagLength+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if _, err := normalizeTags([]string{string(long)}); err == nil {
+		t.Error("expected error for overlong tag")
+	}
+}
+
+func TestListUserTags_Counts(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		alice := f.User(t, "alice", false)
+		f.Mock.ExpectQuery("SELECT tag, COUNT").
+			WithArgs(alice.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"tag", "count"}).AddRow("urgent", 1).AddRow("work", 3))
+
+		req, _ := NewJSONRequest(http.MethodGet, "/api/file-metadata/tags", nil)
+		if err := f.Metadata.ListUserTags(f.Context(req, alice)); err != nil {
+			t.Fatal(err)
+		}
+		AssertStatus(t, f.Recorder, http.StatusOK)
+
+		var resp struct {
+			Tags  []TagUsage `json:"tags"`
+			Total int        `json:"total"`
+		}
+		if err := ParseJSONResponse(f.Recorder, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Total != 2 || resp.Tags[1] != (TagUsage{Tag: "work", Count: 3}) {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+		f.AssertExpectations(t)
+	})
+}
+
+func TestApplyTags_PerItemResults(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		alice := f.User(t, "alice", false)
+
+		// "keep" is both added and removed, so only "work" is added
+		f.Mock.ExpectQuery("INSERT INTO file_metadata").
+			WithArgs(alice.ID, sqlmock.AnyArg(), sqlmock.AnyArg(), []byte(`["work"]`), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"file_path", "tags", "inserted"}).
+				AddRow("/a.txt", []byte(`["work"]`), true).
+				AddRow("/b.txt", []byte(`["old","work"]`), false))
+
+		req, _ := NewJSONRequest(http.MethodPost, "/api/file-metadata/tags/apply", ApplyTagsRequest{
+			Paths:      []string{"a.txt", "/b.txt", "/b.txt"},
+			AddTags:    []string{"work", "keep"},
+			RemoveTags: []string{"keep"},
+		})
+		if err := f.Metadata.ApplyTags(f.Context(req, alice)); err != nil {
+			t.Fatal(err)
+		}
+		AssertStatus(t, f.Recorder, http.StatusOK)
+
+		var resp struct {
+			Results []TagApplyResult `json:"results"`
+			Changed int              `json:"changed"`
+		}
+		if err := ParseJSONResponse(f.Recorder, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Results) != 2 || resp.Changed != 2 {
+			t.Fatalf("unexpected response: %+v", resp)
+		}
+		if resp.Results[0].Status != "created" || resp.Results[1].Status != "updated" {
+			t.Errorf("statuses = %s, %s", resp.Results[0].Status, resp.Results[1].Status)
+		}
+		f.AssertExpectations(t)
+	})
+}
+
+func TestApplyTags_RemoveOnlyReportsUnchanged(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		alice := f.User(t, "alice", false)
+		f.Mock.ExpectQuery("UPDATE file_metadata SET tags = tags -").
+			WillReturnRows(sqlmock.NewRows([]string{"file_path", "tags", "inserted"}).
+				AddRow("/a.txt", []byte(`[]`), false))
+
+		req, _ := NewJSONRequest(http.MethodPost, "/api/file-metadata/tags/apply", ApplyTagsRequest{
+			Paths:      []string{"/a.txt", "/b.txt"},
+			RemoveTags: []string{"work"},
+		})
+		if err := f.Metadata.ApplyTags(f.Context(req, alice)); err != nil {
+			t.Fatal(err)
+		}
+		AssertStatus(t, f.Recorder, http.StatusOK)
+
+		var resp struct {
+			Results []TagApplyResult `json:"results"`
+		}
+		if err := ParseJSONResponse(f.Recorder, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Results) != 2 || resp.Results[0].Status != "updated" || resp.Results[1].Status != "unchanged" {
+			t.Errorf("unexpected results: %+v", resp.Results)
+		}
+		f.AssertExpectations(t)
+	})
+}
+
+func TestApplyTags_Validation(t *testing.T) {
+	for name, body := range map[string]ApplyTagsRequest{
+		"no paths": {AddTags: []string{"work"}},
+		"no tags":  {Paths: []string{"/a.txt"}, AddTags: []string{" "}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc := SetupTest(t)
+			defer tc.Cleanup()
+			h := NewFileMetadataHandler(tc.DB)
+
+			req, _ := NewJSONRequest(http.MethodPost, "/api/file-metadata/tags/apply", body)
+			c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+			if err := h.ApplyTags(c); err != nil {
+				t.Fatal(err)
+			}
+			AssertStatus(t, tc.Recorder, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestRenameTag(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		alice := f.User(t, "alice", false)
+		f.Mock.ExpectExec("UPDATE file_metadata SET").
+			WithArgs(alice.ID, "wrk", "work").
+			WillReturnResult(sqlmock.NewResult(0, 4))
+
+		req, _ := NewJSONRequest(http.MethodPut, "/api/file-metadata/tags/rename", RenameTagRequest{From: "wrk", To: " work "})
+		if err := f.Metadata.RenameTag(f.Context(req, alice)); err != nil {
+			t.Fatal(err)
+		}
+		AssertStatus(t, f.Recorder, http.StatusOK)
+
+		var resp map[string]interface{}
+		if err := ParseJSONResponse(f.Recorder, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp["updated"] != float64(4) {
+			t.Errorf("updated = %v, want 4", resp["updated"])
+		}
+		f.AssertExpectations(t)
+	})
+}
+
+func TestRenameTag_SameName(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := NewFileMetadataHandler(tc.DB)
+
+	req, _ := NewJSONRequest(http.MethodPut, "/api/file-metadata/tags/rename", RenameTagRequest{From: "work", To: "work"})
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	if err := h.RenameTag(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusBadRequest)
+}
+
+func TestDeleteTag(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		alice := f.User(t, "alice", false)
+		f.Mock.ExpectExec("UPDATE file_metadata SET tags = tags -").
+			WithArgs(alice.ID, "two words").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		req, _ := NewJSONRequest(http.MethodDelete, "/api/file-metadata/tags/two%20words", nil)
+		c := f.Context(req, alice)
+		c.SetParamNames("tag")
+		c.SetParamValues("two%20words")
+		if err := f.Metadata.DeleteTag(c); err != nil {
+			t.Fatal(err)
+		}
+		AssertStatus(t, f.Recorder, http.StatusOK)
+		f.AssertExpectations(t)
+	})
+}
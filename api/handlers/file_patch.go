@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Partial content write modes, selected with the X-Write-Mode header
+const (
+	WriteModeAppend = "append"
+	WriteModeRange  = "range"
+)
+
+// maxPatchBodySize limits the body of a single append or range write
+const maxPatchBodySize = 64 * 1024 * 1024
+
+// byteRange is an inclusive byte span parsed from a Content-Range header
+type byteRange struct {
+	start, end int64
+}
+
+// parseContentRange parses "bytes start-end/total" where total may be "*".
+// A given total must match the current file size.
+func parseContentRange(header string, size int64) (byteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !ok {
+		return byteRange{}, errors.New("Content-Range must use the bytes unit")
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return byteRange{}, errors.New("Content-Range must be bytes start-end/total")
+	}
+	startStr, endStr, ok := strings.Cut(span, "-")
+	if !ok {
+		return byteRange{}, errors.New("Content-Range must be bytes start-end/total")
+	}
+	start, err1 := strconv.ParseInt(startStr, 10, 64)
+	end, err2 := strconv.ParseInt(endStr, 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || end < start {
+		return byteRange{}, errors.New("invalid Content-Range span")
+	}
+	if total != "*" {
+		n, err := strconv.ParseInt(total, 10, 64)
+		if err != nil || n != size {
+			return byteRange{}, fmt.Errorf("Content-Range total does not match file size %d", size)
+		}
+	}
+	if end >= size {
+		return byteRange{}, fmt.Errorf("range must end within the file (size %d)", size)
+	}
+	return byteRange{start: start, end: end}, nil
+}
+
+// storageOwnerOf returns the home username or shared drive name a real path belongs to
+func (h *Handler) storageOwnerOf(realPath string) (username, folder string) {
+	rel, err := dataRootRel(h.dataRoot, realPath)
+	if err != nil {
+		return "", ""
+	}
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+	if len(parts) < 2 {
+		return "", ""
+	}
+	switch parts[0] {
+	case "users":
+		return parts[1], ""
+	case "shared":
+		return "", parts[1]
+	}
+	return "", ""
+}
+
+// checkGrowthQuota checks whether the file at realPath may grow by growth
+// bytes under the quota of the home or shared drive it lives in
+func (h *Handler) checkGrowthQuota(ctx context.Context, realPath string, growth int64) *APIError {
+	username, folder := h.storageOwnerOf(realPath)
+	if folder != "" {
+		allowed, quota, used, err := h.CheckSharedDriveQuota(ctx, "/shared/"+folder, growth)
+		if err != nil {
+			return errBudgetExceeded()
+		}
+		if !allowed {
+			return ErrQuotaExceeded(quota, used, growth)
+		}
+		return nil
+	}
+	if username == "" {
+		return nil
+	}
+	var quota, used int64
+	err := h.db.QueryRow(`
+		SELECT user_storage_quota(id, storage_quota, $1), COALESCE(storage_used, 0) + COALESCE(trash_used, 0)
+		FROM users WHERE username = $2
+	`, DefaultUserQuota, username).Scan(&quota, &used)
+	if err == nil && quota > 0 && used+growth > quota {
+		return ErrQuotaExceeded(quota, used, growth)
+	}
+	return nil
+}
+
+// recordGrowth adds growth bytes to the storage usage of the file's owner
+func (h *Handler) recordGrowth(realPath string, growth int64) {
+	username, folder := h.storageOwnerOf(realPath)
+	if folder != "" {
+		if err := h.UpdateSharedFolderStorage(folder, growth); err != nil {
+			fmt.Printf("[Storage] Failed to update shared folder storage: %v\n", err)
+		}
+		return
+	}
+	if username == "" {
+		return
+	}
+	if _, err := h.db.Exec(`
+		UPDATE users
+		SET storage_used = GREATEST(0, COALESCE(storage_used, 0) + $1),
+		    updated_at = NOW()
+		WHERE username = $2
+	`, growth, username); err != nil {
+		fmt.Printf("[Storage] Failed to update user storage: %v\n", err)
+	}
+	GetStorageCache().InvalidateUserUsage(username)
+}
+
+// PatchFileContent appends to or overwrites part of an existing file
+// @Summary		Patch file content
+// @Description	Append the body to a file (X-Write-Mode: append) or overwrite the byte span given by Content-Range (X-Write-Mode: range). Appends take an exclusive advisory lock; range writes fail with 423 while the file is locked by another user or writer. If-Match is checked against the file's ETag.
+// @Tags		Files
+// @Accept		octet-stream
+// @Produce		json
+// @Param		path			path		string	true	"File path"
+// @Param		X-Write-Mode	header		string	false	"append or range (range is implied by Content-Range)"
+// @Param		Content-Range	header		string	false	"bytes start-end/total for range writes; total may be *"
+// @Param		If-Match		header		string	false	"ETag the file must still have"
+// @Success		200		{object}	map[string]interface{}	"New size and ETag"
+// @Failure		400		{object}	map[string]string	"Bad request"
+// @Failure		403		{object}	map[string]string	"Forbidden"
+// @Failure		404		{object}	map[string]string	"File not found"
+// @Failure		412		{object}	map[string]string	"ETag mismatch"
+// @Failure		413		{object}	map[string]string	"Quota exceeded"
+// @Failure		423		{object}	map[string]string	"File is locked"
+// @Security	BearerAuth
+// @Router		/files/content/{path} [patch]
+func (h *Handler) PatchFileContent(c echo.Context) error {
+	requestPath := c.Param("*")
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	claims := GetClaims(c)
+
+	mode := strings.ToLower(c.Request().Header.Get("X-Write-Mode"))
+	contentRange := c.Request().Header.Get("Content-Range")
+	if mode == "" && contentRange != "" {
+		mode = WriteModeRange
+	}
+	switch mode {
+	case WriteModeAppend:
+	case WriteModeRange:
+		if contentRange == "" {
+			return RespondError(c, ErrMissingParameter("Content-Range"))
+		}
+	default:
+		return RespondError(c, ErrBadRequest("X-Write-Mode must be 'append' or 'range'"))
+	}
+
+	target, apiErr := h.resolveEditableFile("/"+requestPath, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := h.retentionError(c, claims, target.realPath, EventFileEdit, false); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	// Encrypted files are sealed in chunks and can only be replaced whole
+	if GetFileEncryption().InEncryptedFolder(target.realPath) || IsEncryptedFile(target.realPath) {
+		return RespondError(c, ErrBadRequest("Partial writes are not supported for encrypted files; save the whole file instead"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxPatchBodySize+1))
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Failed to read request body"))
+	}
+	if len(body) == 0 {
+		return RespondError(c, ErrBadRequest("Request body is empty"))
+	}
+	if len(body) > maxPatchBodySize {
+		return RespondError(c, NewAPIError(ErrCodeFileTooLarge, "Request body is too large"))
+	}
+
+	// Range writes must not race an editor holding the file lock
+	if mode == WriteModeRange {
+		userID := ""
+		if claims != nil {
+			userID = claims.UserID
+		}
+		if holder := h.lockHolder("/"+requestPath, userID); holder != "" {
+			return RespondError(c, NewAPIError(ErrCodeLocked, "File is locked by "+holder))
+		}
+	}
+
+	var offset int64
+	var newSize int64
+	if mode == WriteModeAppend {
+		offset, newSize, apiErr = h.appendFile(c.Request(), target.realPath, body)
+	} else {
+		offset, newSize, apiErr = h.overwriteRange(c.Request(), target.realPath, contentRange, body)
+	}
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	info, err := os.Stat(target.realPath)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("access file", err))
+	}
+	etag := GenerateETag(target.realPath, info.ModTime(), info.Size())
+	c.Response().Header().Set("ETag", etag)
+
+	GetChangeJournal().Record(ChangeModify, target.realPath, "", changeActor(claims))
+	GetListingVersions().Invalidate(target.realPath)
+	GetFileChecksums().ForgetTree(target.realPath)
+
+	event := EventFileOverwrite
+	if mode == WriteModeAppend {
+		event = EventFileAppend
+		h.recordGrowth(target.realPath, int64(len(body)))
+	}
+	var userID *string
+	if claims != nil {
+		userID = &claims.UserID
+	}
+	_ = h.auditHandler.LogEvent(userID, c.RealIP(), event, "/"+requestPath, map[string]any{
+		"offset":      offset,
+		"length":      len(body),
+		"size":        newSize,
+		"storageType": target.storageType,
+		"isShared":    target.isShared,
+	})
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"success": true,
+		"mode":    mode,
+		"offset":  offset,
+		"length":  len(body),
+		"size":    newSize,
+		"etag":    etag,
+	})
+}
+
+// appendFile appends body under an exclusive advisory lock, waiting for other
+// appenders. Returns the offset the body was written at and the new size.
+func (h *Handler) appendFile(r *http.Request, realPath string, body []byte) (int64, int64, *APIError) {
+	if err := copyOnWrite(realPath, true); err != nil {
+		return 0, 0, ErrOperationFailed("copy file", err)
+	}
+	f, err := os.OpenFile(realPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return 0, 0, ErrOperationFailed("open file", err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, 0, ErrOperationFailed("lock file", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	// Preconditions are checked under the lock so they hold for the write
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, ErrOperationFailed("access file", err)
+	}
+	if !CheckIfMatch(r, GenerateETag(realPath, info.ModTime(), info.Size())) {
+		return 0, 0, NewAPIError(ErrCodePreconditionFailed, "File has changed since it was read")
+	}
+	ctx, cancel := budgetQuotaCheck.Context(r.Context())
+	defer cancel()
+	if apiErr := h.checkGrowthQuota(ctx, realPath, int64(len(body))); apiErr != nil {
+		return 0, 0, apiErr
+	}
+
+	if _, err := f.Write(body); err != nil {
+		return 0, 0, NewAPIError(ErrCodeWriteFailed, "Failed to append to file")
+	}
+	return info.Size(), info.Size() + int64(len(body)), nil
+}
+
+// overwriteRange writes body over the Content-Range span. The file must not
+// be held by another writer and the span must end within the file.
+// Returns the offset written and the (unchanged) size.
+func (h *Handler) overwriteRange(r *http.Request, realPath, contentRange string, body []byte) (int64, int64, *APIError) {
+	if err := copyOnWrite(realPath, true); err != nil {
+		return 0, 0, ErrOperationFailed("copy file", err)
+	}
+	f, err := os.OpenFile(realPath, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, 0, ErrOperationFailed("open file", err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return 0, 0, NewAPIError(ErrCodeLocked, "File is being written by another client")
+		}
+		return 0, 0, ErrOperationFailed("lock file", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, ErrOperationFailed("access file", err)
+	}
+	if !CheckIfMatch(r, GenerateETag(realPath, info.ModTime(), info.Size())) {
+		return 0, 0, NewAPIError(ErrCodePreconditionFailed, "File has changed since it was read")
+	}
+	span, err := parseContentRange(contentRange, info.Size())
+	if err != nil {
+		return 0, 0, ErrBadRequest(err.Error())
+	}
+	if span.end-span.start+1 != int64(len(body)) {
+		return 0, 0, ErrBadRequest("Content-Range length does not match the request body")
+	}
+
+	if _, err := f.WriteAt(body, span.start); err != nil {
+		return 0, 0, NewAPIError(ErrCodeWriteFailed, "Failed to write file range")
+	}
+	return span.start, info.Size(), nil
+}
@@ -16,18 +16,35 @@ const (
 	FileShareReadWrite = 2
 )
 
+// File share states. Expired is derived from expires_at, not stored.
+const (
+	FileSharePending  = "pending"
+	FileShareAccepted = "accepted"
+	FileShareDeclined = "declined"
+	FileShareExpired  = "expired"
+)
+
+// fileShareActiveSQL matches file_shares rows (aliased fs) that grant access:
+// accepted and not expired
+const fileShareActiveSQL = `fs.status = 'accepted' AND (fs.expires_at IS NULL OR fs.expires_at > NOW())`
+
+// fileShareStateSQL reports the state of a file_shares row aliased fs
+const fileShareStateSQL = `CASE WHEN fs.expires_at IS NOT NULL AND fs.expires_at <= NOW() THEN 'expired' ELSE fs.status END`
+
 // FileShare represents a user-to-user file/folder share
 type FileShare struct {
-	ID              int64     `json:"id"`
-	ItemPath        string    `json:"itemPath"`
-	ItemName        string    `json:"itemName"`
-	IsFolder        bool      `json:"isFolder"`
-	OwnerID         string    `json:"ownerId"`
-	SharedWithID    string    `json:"sharedWithId"`
-	PermissionLevel int       `json:"permissionLevel"`
-	Message         string    `json:"message,omitempty"`
-	CreatedAt       time.Time `json:"createdAt"`
-	UpdatedAt       time.Time `json:"updatedAt"`
+	ID              int64      `json:"id"`
+	ItemPath        string     `json:"itemPath"`
+	ItemName        string     `json:"itemName"`
+	IsFolder        bool       `json:"isFolder"`
+	OwnerID         string     `json:"ownerId"`
+	SharedWithID    string     `json:"sharedWithId"`
+	PermissionLevel int        `json:"permissionLevel"`
+	Message         string     `json:"message,omitempty"`
+	State           string     `json:"state"` // pending, accepted, declined or expired
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
 	// Additional fields for display
 	OwnerUsername      string `json:"ownerUsername,omitempty"`
 	SharedWithUsername string `json:"sharedWithUsername,omitempty"`
@@ -51,17 +68,19 @@ func NewFileShareHandler(db *sql.DB, notificationService *NotificationService) *
 
 // CreateFileShareRequest represents the request to create a file share
 type CreateFileShareRequest struct {
-	ItemPath        string `json:"itemPath"`
-	ItemName        string `json:"itemName"`
-	IsFolder        bool   `json:"isFolder"`
-	SharedWithID    string `json:"sharedWithId"`
-	PermissionLevel int    `json:"permissionLevel"`
-	Message         string `json:"message"`
+	ItemPath        string     `json:"itemPath"`
+	ItemName        string     `json:"itemName"`
+	IsFolder        bool       `json:"isFolder"`
+	SharedWithID    string     `json:"sharedWithId"`
+	PermissionLevel int        `json:"permissionLevel"`
+	Message         string     `json:"message"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`     // Access ends at this time
+	RequireAccept   bool       `json:"requireAccept,omitempty"` // Recipient must accept before the share takes effect
 }
 
 // CreateFileShare creates a new file share
 // @Summary		Create file share
-// @Description	Share a file or folder with another user
+// @Description	Share a file or folder with another user. With requireAccept the share stays pending until the recipient accepts it; expiresAt ends access at that time.
 // @Tags		FileShares
 // @Accept		json
 // @Produce		json
@@ -79,6 +98,9 @@ func (h *FileShareHandler) CreateFileShare(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	if GetGuests().IsGuest(claims.UserID) {
+		return RespondError(c, ErrForbidden("Guest accounts cannot create shares"))
+	}
 
 	var req CreateFileShareRequest
 	if err := c.Bind(&req); err != nil {
@@ -100,6 +122,13 @@ func (h *FileShareHandler) CreateFileShare(c echo.Context) error {
 	if req.PermissionLevel < 1 || req.PermissionLevel > 2 {
 		req.PermissionLevel = FileShareReadOnly
 	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return RespondError(c, ErrBadRequest("Expiry must be in the future"))
+	}
+	status := FileShareAccepted
+	if req.RequireAccept {
+		status = FileSharePending
+	}
 
 	// Check if target user exists
 	var userExists bool
@@ -118,15 +147,20 @@ func (h *FileShareHandler) CreateFileShare(c echo.Context) error {
 		return RespondError(c, ErrForbidden("You can only share files from your home folder or shared drives"))
 	}
 
-	// Insert the share
+	// Insert the share. Re-sharing keeps a share the recipient already
+	// accepted; a declined or expired one becomes a new invitation.
 	var shareID int64
 	insertErr := h.db.QueryRow(`
-		INSERT INTO file_shares (item_path, item_name, is_folder, owner_id, shared_with_id, permission_level, message)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO file_shares (item_path, item_name, is_folder, owner_id, shared_with_id, permission_level, message, status, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		ON CONFLICT (item_path, owner_id, shared_with_id)
-		DO UPDATE SET permission_level = EXCLUDED.permission_level, message = EXCLUDED.message, updated_at = NOW()
-		RETURNING id
-	`, req.ItemPath, req.ItemName, req.IsFolder, claims.UserID, req.SharedWithID, req.PermissionLevel, req.Message).Scan(&shareID)
+		DO UPDATE SET permission_level = EXCLUDED.permission_level, message = EXCLUDED.message,
+			status = CASE
+				WHEN file_shares.status = 'accepted' AND (file_shares.expires_at IS NULL OR file_shares.expires_at > NOW())
+				THEN 'accepted' ELSE EXCLUDED.status END,
+			expires_at = EXCLUDED.expires_at, updated_at = NOW()
+		RETURNING id, status
+	`, req.ItemPath, req.ItemName, req.IsFolder, claims.UserID, req.SharedWithID, req.PermissionLevel, req.Message, status, req.ExpiresAt).Scan(&shareID, &status)
 
 	if insertErr != nil {
 		return RespondError(c, ErrOperationFailed("create share", insertErr))
@@ -137,6 +171,8 @@ func (h *FileShareHandler) CreateFileShare(c echo.Context) error {
 		"sharedWithId":    req.SharedWithID,
 		"permissionLevel": req.PermissionLevel,
 		"isFolder":        req.IsFolder,
+		"status":          status,
+		"expiresAt":       req.ExpiresAt,
 	})
 
 	// Send notification to the shared-with user
@@ -149,12 +185,22 @@ func (h *FileShareHandler) CreateFileShare(c echo.Context) error {
 		if req.PermissionLevel == FileShareReadWrite {
 			permLabel = "읽기/쓰기"
 		}
+		notifType := NotifShareReceived
 		title := claims.Username + "님이 " + itemType + "을 공유했습니다"
 		message := "'" + req.ItemName + "' (" + permLabel + " 권한)"
 		link := "/shared-with-me"
-		_, _ = h.notificationService.Create(
+		if status == FileSharePending {
+			notifType = NotifShareInvitation
+			title = claims.Username + "님이 " + itemType + " 공유를 요청했습니다"
+			message += " - 수락하면 공유 항목에 표시됩니다"
+			link = "/shared-with-me?tab=invitations"
+		}
+		if req.ExpiresAt != nil {
+			message += ", " + req.ExpiresAt.Local().Format("2006-01-02 15:04") + "까지"
+		}
+		h.notificationService.Send(
 			req.SharedWithID,
-			NotifShareReceived,
+			notifType,
 			title,
 			message,
 			link,
@@ -164,12 +210,15 @@ func (h *FileShareHandler) CreateFileShare(c echo.Context) error {
 				"itemName":        req.ItemName,
 				"isFolder":        req.IsFolder,
 				"permissionLevel": req.PermissionLevel,
+				"shareId":         shareID,
+				"status":          status,
 			},
 		)
 	}
 
 	return RespondCreated(c, map[string]interface{}{
 		"id":      shareID,
+		"state":   status,
 		"message": "File shared successfully",
 	})
 }
@@ -183,7 +232,8 @@ func (h *FileShareHandler) ListSharedByMe(c echo.Context) error {
 
 	query := `
 		SELECT fs.id, fs.item_path, fs.item_name, fs.is_folder, fs.owner_id, fs.shared_with_id,
-		       fs.permission_level, fs.message, fs.created_at, fs.updated_at, u.username as shared_with_username
+		       fs.permission_level, fs.message, ` + fileShareStateSQL + `, fs.expires_at,
+		       fs.created_at, fs.updated_at, u.username as shared_with_username
 		FROM file_shares fs
 		INNER JOIN users u ON fs.shared_with_id = u.id
 		WHERE fs.owner_id = $1
@@ -200,15 +250,19 @@ func (h *FileShareHandler) ListSharedByMe(c echo.Context) error {
 	for rows.Next() {
 		var s FileShare
 		var message sql.NullString
+		var expiresAt sql.NullTime
 		if scanErr := rows.Scan(
 			&s.ID, &s.ItemPath, &s.ItemName, &s.IsFolder, &s.OwnerID, &s.SharedWithID,
-			&s.PermissionLevel, &message, &s.CreatedAt, &s.UpdatedAt, &s.SharedWithUsername,
+			&s.PermissionLevel, &message, &s.State, &expiresAt, &s.CreatedAt, &s.UpdatedAt, &s.SharedWithUsername,
 		); scanErr != nil {
 			continue
 		}
 		if message.Valid {
 			s.Message = message.String
 		}
+		if expiresAt.Valid {
+			s.ExpiresAt = &expiresAt.Time
+		}
 		shares = append(shares, s)
 	}
 
@@ -227,10 +281,10 @@ func (h *FileShareHandler) ListSharedWithMe(c echo.Context) error {
 
 	query := `
 		SELECT fs.id, fs.item_path, fs.item_name, fs.is_folder, fs.owner_id, fs.shared_with_id,
-		       fs.permission_level, fs.message, fs.created_at, fs.updated_at, u.username as owner_username
+		       fs.permission_level, fs.message, fs.expires_at, fs.created_at, fs.updated_at, u.username as owner_username
 		FROM file_shares fs
 		INNER JOIN users u ON fs.owner_id = u.id
-		WHERE fs.shared_with_id = $1
+		WHERE fs.shared_with_id = $1 AND ` + fileShareActiveSQL + `
 		ORDER BY fs.created_at DESC
 	`
 
@@ -244,15 +298,20 @@ func (h *FileShareHandler) ListSharedWithMe(c echo.Context) error {
 	for rows.Next() {
 		var s FileShare
 		var message sql.NullString
+		var expiresAt sql.NullTime
 		if scanErr := rows.Scan(
 			&s.ID, &s.ItemPath, &s.ItemName, &s.IsFolder, &s.OwnerID, &s.SharedWithID,
-			&s.PermissionLevel, &message, &s.CreatedAt, &s.UpdatedAt, &s.OwnerUsername,
+			&s.PermissionLevel, &message, &expiresAt, &s.CreatedAt, &s.UpdatedAt, &s.OwnerUsername,
 		); scanErr != nil {
 			continue
 		}
 		if message.Valid {
 			s.Message = message.String
 		}
+		s.State = FileShareAccepted
+		if expiresAt.Valid {
+			s.ExpiresAt = &expiresAt.Time
+		}
 		shares = append(shares, s)
 	}
 
@@ -262,6 +321,156 @@ func (h *FileShareHandler) ListSharedWithMe(c echo.Context) error {
 	})
 }
 
+// ListInvitations returns pending, unexpired share invitations for the current user
+// @Summary		List file share invitations
+// @Description	Shares created with requireAccept that wait for the current user to accept or decline them
+// @Tags		FileShares
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Pending invitations"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/file-shares/invitations [get]
+func (h *FileShareHandler) ListInvitations(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db.Query(`
+		SELECT fs.id, fs.item_path, fs.item_name, fs.is_folder, fs.owner_id, fs.shared_with_id,
+		       fs.permission_level, fs.message, fs.expires_at, fs.created_at, fs.updated_at, u.username as owner_username
+		FROM file_shares fs
+		INNER JOIN users u ON fs.owner_id = u.id
+		WHERE fs.shared_with_id = $1 AND fs.status = 'pending'
+		  AND (fs.expires_at IS NULL OR fs.expires_at > NOW())
+		ORDER BY fs.created_at DESC
+	`, claims.UserID)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	defer rows.Close()
+
+	invitations := []FileShare{}
+	for rows.Next() {
+		var s FileShare
+		var message sql.NullString
+		var expiresAt sql.NullTime
+		if scanErr := rows.Scan(
+			&s.ID, &s.ItemPath, &s.ItemName, &s.IsFolder, &s.OwnerID, &s.SharedWithID,
+			&s.PermissionLevel, &message, &expiresAt, &s.CreatedAt, &s.UpdatedAt, &s.OwnerUsername,
+		); scanErr != nil {
+			continue
+		}
+		if message.Valid {
+			s.Message = message.String
+		}
+		s.State = FileSharePending
+		if expiresAt.Valid {
+			s.ExpiresAt = &expiresAt.Time
+		}
+		invitations = append(invitations, s)
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"invitations": invitations,
+		"total":       len(invitations),
+	})
+}
+
+// AcceptInvitation accepts a pending share invitation
+// @Summary		Accept file share invitation
+// @Tags		FileShares
+// @Produce		json
+// @Param		id	path		int	true	"Share ID"
+// @Success		200	{object}	docs.SuccessResponse	"Invitation accepted"
+// @Failure		404	{object}	docs.ErrorResponse	"No pending invitation"
+// @Security	BearerAuth
+// @Router		/file-shares/{id}/accept [post]
+func (h *FileShareHandler) AcceptInvitation(c echo.Context) error {
+	return h.respondToInvitation(c, FileShareAccepted)
+}
+
+// DeclineInvitation declines a pending share invitation
+// @Summary		Decline file share invitation
+// @Tags		FileShares
+// @Produce		json
+// @Param		id	path		int	true	"Share ID"
+// @Success		200	{object}	docs.SuccessResponse	"Invitation declined"
+// @Failure		404	{object}	docs.ErrorResponse	"No pending invitation"
+// @Security	BearerAuth
+// @Router		/file-shares/{id}/decline [post]
+func (h *FileShareHandler) DeclineInvitation(c echo.Context) error {
+	return h.respondToInvitation(c, FileShareDeclined)
+}
+
+// respondToInvitation moves a pending invitation of the current user to
+// accepted or declined and tells the owner
+func (h *FileShareHandler) respondToInvitation(c echo.Context, status string) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	shareID := c.Param("id")
+	if shareID == "" {
+		return RespondError(c, ErrMissingParameter("share ID"))
+	}
+
+	var itemPath, itemName, ownerID string
+	var isFolder bool
+	queryErr := h.db.QueryRow(`
+		UPDATE file_shares
+		SET status = $1, responded_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND shared_with_id = $3 AND status = 'pending'
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING item_path, item_name, owner_id, is_folder
+	`, status, shareID, claims.UserID).Scan(&itemPath, &itemName, &ownerID, &isFolder)
+	if queryErr == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Invitation"))
+	}
+	if queryErr != nil {
+		return RespondError(c, ErrOperationFailed("respond to invitation", queryErr))
+	}
+
+	event := "file_share_accept"
+	notifType := NotifShareAccepted
+	title := "공유 요청이 수락되었습니다"
+	verb := "수락"
+	if status == FileShareDeclined {
+		event = "file_share_decline"
+		notifType = NotifShareDeclined
+		title = "공유 요청이 거절되었습니다"
+		verb = "거절"
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), event, itemPath, map[string]interface{}{
+		"shareId": shareID,
+		"ownerId": ownerID,
+	})
+
+	if h.notificationService != nil {
+		h.notificationService.Send(
+			ownerID,
+			notifType,
+			title,
+			claims.Username+"님이 '"+itemName+"' 공유 요청을 "+verb+"했습니다",
+			"/shared-by-me",
+			&claims.UserID,
+			map[string]interface{}{
+				"shareId":  shareID,
+				"itemPath": itemPath,
+				"itemName": itemName,
+				"isFolder": isFolder,
+			},
+		)
+	}
+
+	return RespondSuccess(c, map[string]string{
+		"state":   status,
+		"message": "Invitation " + status,
+	})
+}
+
 // UpdateFileShare updates a file share's permission level
 func (h *FileShareHandler) UpdateFileShare(c echo.Context) error {
 	claims, err := RequireClaims(c)
@@ -324,7 +533,7 @@ func (h *FileShareHandler) UpdateFileShare(c echo.Context) error {
 		title := "공유 권한이 변경되었습니다"
 		message := claims.Username + "님이 '" + itemName + "' 권한을 " + permLabel + "(으)로 변경했습니다"
 		link := "/shared-with-me"
-		_, _ = h.notificationService.Create(
+		h.notificationService.Send(
 			sharedWithID,
 			NotifSharePermissionChanged,
 			title,
@@ -385,7 +594,7 @@ func (h *FileShareHandler) DeleteFileShare(c echo.Context) error {
 		}
 		title := "공유가 취소되었습니다"
 		message := claims.Username + "님이 '" + itemName + "' " + itemType + " 공유를 취소했습니다"
-		_, _ = h.notificationService.Create(
+		h.notificationService.Send(
 			sharedWithID,
 			NotifShareRemoved,
 			title,
@@ -426,7 +635,8 @@ func (h *FileShareHandler) GetFileShareInfo(c echo.Context) error {
 
 	query := `
 		SELECT fs.id, fs.item_path, fs.item_name, fs.is_folder, fs.owner_id, fs.shared_with_id,
-		       fs.permission_level, fs.message, fs.created_at, fs.updated_at, u.username as shared_with_username
+		       fs.permission_level, fs.message, ` + fileShareStateSQL + `, fs.expires_at,
+		       fs.created_at, fs.updated_at, u.username as shared_with_username
 		FROM file_shares fs
 		INNER JOIN users u ON fs.shared_with_id = u.id
 		WHERE fs.item_path = $1 AND fs.owner_id = $2
@@ -443,15 +653,19 @@ func (h *FileShareHandler) GetFileShareInfo(c echo.Context) error {
 	for rows.Next() {
 		var s FileShare
 		var message sql.NullString
+		var expiresAt sql.NullTime
 		if scanErr := rows.Scan(
 			&s.ID, &s.ItemPath, &s.ItemName, &s.IsFolder, &s.OwnerID, &s.SharedWithID,
-			&s.PermissionLevel, &message, &s.CreatedAt, &s.UpdatedAt, &s.SharedWithUsername,
+			&s.PermissionLevel, &message, &s.State, &expiresAt, &s.CreatedAt, &s.UpdatedAt, &s.SharedWithUsername,
 		); scanErr != nil {
 			continue
 		}
 		if message.Valid {
 			s.Message = message.String
 		}
+		if expiresAt.Valid {
+			s.ExpiresAt = &expiresAt.Time
+		}
 		shares = append(shares, s)
 	}
 
@@ -527,16 +741,16 @@ func (h *FileShareHandler) SearchUsers(c echo.Context) error {
 func (h *FileShareHandler) CheckFileSharePermission(userID, itemPath string, requiredLevel int) bool {
 	var permissionLevel int
 	err := h.db.QueryRow(`
-		SELECT permission_level FROM file_shares
-		WHERE item_path = $1 AND shared_with_id = $2
+		SELECT permission_level FROM file_shares fs
+		WHERE item_path = $1 AND shared_with_id = $2 AND `+fileShareActiveSQL+`
 	`, itemPath, userID).Scan(&permissionLevel)
 
 	if err != nil {
 		// Also check if the path is under a shared folder
 		// For example, if /home/admin/folder is shared, /home/admin/folder/file.txt should also be accessible
 		rows, err := h.db.Query(`
-			SELECT item_path, permission_level FROM file_shares
-			WHERE shared_with_id = $1 AND is_folder = TRUE
+			SELECT item_path, permission_level FROM file_shares fs
+			WHERE shared_with_id = $1 AND is_folder = TRUE AND `+fileShareActiveSQL+`
 		`, userID)
 		if err != nil {
 			return false
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAcceptInvitation(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+
+	tc.Mock.ExpectQuery("UPDATE file_shares").
+		WithArgs(FileShareAccepted, "7", "user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"item_path", "item_name", "owner_id", "is_folder"}).
+			AddRow("/home/Plans", "Plans", "user-1", true))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "file_share_accept", "/home/Plans", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	h := &FileShareHandler{db: tc.DB, auditHandler: &AuditHandler{db: tc.DB}}
+	req, _ := NewJSONRequest(http.MethodPost, "/api/file-shares/7/accept", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "user-2", "bob", false)
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+
+	if err := h.AcceptInvitation(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeclineInvitationNotPending(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+
+	// Already answered, expired or someone else's invitation
+	tc.Mock.ExpectQuery("UPDATE file_shares").
+		WithArgs(FileShareDeclined, "7", "user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"item_path", "item_name", "owner_id", "is_folder"}))
+
+	h := &FileShareHandler{db: tc.DB, auditHandler: &AuditHandler{db: tc.DB}}
+	req, _ := NewJSONRequest(http.MethodPost, "/api/file-shares/7/decline", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "user-2", "bob", false)
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+
+	if err := h.DeclineInvitation(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusNotFound)
+}
+
+func TestCheckFileSharePermissionIgnoresInactiveShares(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+
+	// Pending, declined and expired rows are filtered out by the query
+	tc.Mock.ExpectQuery(`SELECT permission_level FROM file_shares fs\s+WHERE shared_with_id = \$1 AND item_path = \$2 AND fs.status = 'accepted'`).
+		WithArgs("user-2", "/home/Plans/a.txt").
+		WillReturnRows(sqlmock.NewRows([]string{"permission_level"}))
+	tc.Mock.ExpectQuery(`SELECT item_path, permission_level FROM file_shares fs\s+WHERE shared_with_id = \$1 AND is_folder = TRUE AND fs.status = 'accepted'`).
+		WithArgs("user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"item_path", "permission_level"}))
+
+	h := &Handler{db: tc.DB}
+	if h.CanReadSharedFile("user-2", "/home/Plans/a.txt") {
+		t.Error("expected no access without an active share")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCleanupExpiredFileShares(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+
+	tc.Mock.ExpectQuery("DELETE FROM file_shares").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_path", "owner_id", "shared_with_id", "status", "expires_at"}).
+			AddRow(3, "/home/Plans", "user-1", "user-2", FileShareAccepted, time.Now().Add(-time.Minute)))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs(nil, "0.0.0.0", "file_share_expire", "/home/Plans", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	checker := &ShareExpirationChecker{db: tc.DB, auditHandler: &AuditHandler{db: tc.DB}}
+	checker.cleanupExpiredFileShares()
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -50,6 +50,9 @@ func (h *Handler) LockFile(c echo.Context) error {
 	if err != nil {
 		return RespondError(c, ErrInvalidPath(err.Error()))
 	}
+	if apiErr := mountInWriteError(req.Path); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 	if _, err := os.Stat(realPath); os.IsNotExist(err) {
 		return RespondError(c, ErrNotFound("File not found"))
 	}
@@ -174,7 +177,7 @@ func (h *Handler) UnlockFile(c echo.Context) error {
 
 	// Check permission
 	if lockedBy != claims.UserID {
-		if !claims.IsAdmin || !req.Force {
+		if !claims.HasPermission(PermStorageAdmin) || !req.Force {
 			return c.JSON(http.StatusForbidden, map[string]interface{}{
 				"error": "You can only unlock files you locked",
 			})
@@ -342,3 +345,20 @@ func (h *Handler) UpdateLockPath(oldPath, newPath string) error {
 	`, newPath, oldPath)
 	return err
 }
+
+// lockHolder returns the username of another user holding an unexpired lock
+// on path, or "" if the file is not locked by someone else
+func (h *Handler) lockHolder(path, userID string) string {
+	var username string
+	err := h.db.QueryRow(`
+		SELECT u.username
+		FROM file_locks fl
+		JOIN users u ON fl.locked_by = u.id
+		WHERE fl.file_path = $1 AND fl.locked_by::text <> $2
+		  AND (fl.expires_at IS NULL OR fl.expires_at > NOW())
+	`, path, userID).Scan(&username)
+	if err != nil {
+		return ""
+	}
+	return username
+}
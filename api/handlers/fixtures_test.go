@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RunWithFixtures runs fn with handlers wired to sqlmock: fn sets the
+// expectations and seeds files with the fixture factories
+func RunWithFixtures(t *testing.T, fn func(t *testing.T, f *FixtureContext)) {
+	t.Helper()
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	fn(t, SetupFixtures(t, tc))
+}
+
+// FixtureContext wires the handlers to sqlmock and a temp data root,
+// the way SetupFileTest does for Handler
+type FixtureContext struct {
+	*TestContext
+	DataRoot      string
+	Handler       *Handler
+	Auth          *AuthHandler
+	Shares        *ShareHandler
+	UploadShares  *UploadShareHandler
+	SharedFolders *SharedFolderHandler
+	Metadata      *FileMetadataHandler
+}
+
+// SetupFixtures creates the handlers for tc under a new temp data root
+func SetupFixtures(t *testing.T, tc *TestContext) *FixtureContext {
+	t.Helper()
+	dataRoot := t.TempDir()
+	audit := NewAuditHandler(tc.DB, dataRoot)
+
+	auth := CreateTestAuthHandler(tc.DB)
+	auth.dataRoot = dataRoot
+	auth.auditHandler = audit
+
+	uploadShares, err := NewUploadShareHandler(tc.DB, dataRoot, audit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &FixtureContext{
+		TestContext:   tc,
+		DataRoot:      dataRoot,
+		Handler:       &Handler{db: tc.DB, dataRoot: dataRoot, auditHandler: audit},
+		Auth:          auth,
+		Shares:        NewShareHandler(tc.DB, dataRoot, audit, nil),
+		UploadShares:  uploadShares,
+		SharedFolders: NewSharedFolderHandler(tc.DB, dataRoot, nil),
+		Metadata:      NewFileMetadataHandler(tc.DB),
+	}
+}
+
+// AssertExpectations checks that all sqlmock expectations were met
+func (tc *TestContext) AssertExpectations(t *testing.T) {
+	t.Helper()
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Context returns a request context for req authenticated as user
+func (f *FixtureContext) Context(req *http.Request, user TestUser) echo.Context {
+	f.Recorder = httptest.NewRecorder()
+	return CreateAuthenticatedContext(f.Echo, f.Recorder, req, user.ID, user.Username, user.IsAdmin)
+}
+
+// TestUser is a user of FixtureContext.User
+type TestUser struct {
+	ID       string
+	Username string
+	IsAdmin  bool
+}
+
+// TestSharedFolder is a shared drive of FixtureContext.SharedFolder
+type TestSharedFolder struct {
+	ID   string
+	Name string
+}
+
+// TestShare is a link share of FixtureContext.Share
+type TestShare struct {
+	ID    string
+	Token string
+}
+
+// User creates the home folder of a user; the ID is "id-" plus the name
+func (f *FixtureContext) User(t *testing.T, name string, isAdmin bool) TestUser {
+	t.Helper()
+	user := TestUser{ID: "id-" + name, Username: name, IsAdmin: isAdmin}
+	if err := os.MkdirAll(filepath.Join(f.DataRoot, "users", user.Username), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// SharedFolder creates the folder of a shared drive; the ID is "id-" plus
+// the name
+func (f *FixtureContext) SharedFolder(t *testing.T, name string, admin TestUser) TestSharedFolder {
+	t.Helper()
+	folder := TestSharedFolder{ID: "id-" + name, Name: name}
+	if err := os.MkdirAll(filepath.Join(f.DataRoot, "shared", folder.Name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return folder
+}
+
+// Share returns the link share tests expect ShareHandler to look up
+func (f *FixtureContext) Share(t *testing.T, owner TestUser, req CreateShareRequest) TestShare {
+	t.Helper()
+	return TestShare{ID: "id-share", Token: "token"}
+}
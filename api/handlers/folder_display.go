@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxPinnedItems bounds the pinned list of a folder
+const maxPinnedItems = 100
+
+// folderDisplaySorts are the sort fields a folder can default to
+var folderDisplaySorts = map[string]bool{"name": true, "size": true, "date": true, "type": true}
+
+// FolderDisplay is the display metadata of a folder, shared by everyone who opens it
+type FolderDisplay struct {
+	DefaultSort  string    `json:"defaultSort,omitempty"`
+	DefaultOrder string    `json:"defaultOrder,omitempty"`
+	Pinned       []string  `json:"pinned"` // Child names, listed first in this order
+	UpdatedAt    time.Time `json:"updatedAt,omitempty"`
+}
+
+// FolderDisplayRegistry stores folder display metadata keyed by data-root
+// relative folder path
+type FolderDisplayRegistry struct {
+	db       *sql.DB
+	dataRoot string
+}
+
+var globalFolderDisplay *FolderDisplayRegistry
+
+// InitFolderDisplay creates the global folder display registry
+func InitFolderDisplay(db *sql.DB, dataRoot string) *FolderDisplayRegistry {
+	globalFolderDisplay = &FolderDisplayRegistry{db: db, dataRoot: dataRoot}
+	return globalFolderDisplay
+}
+
+// GetFolderDisplay returns the global folder display registry (nil if not initialized)
+func GetFolderDisplay() *FolderDisplayRegistry {
+	return globalFolderDisplay
+}
+
+// relPath converts a real path to the data-root relative key used in folder_display
+func (r *FolderDisplayRegistry) relPath(realPath string) (string, bool) {
+	rel, err := dataRootRel(r.dataRoot, realPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// Get returns the display metadata of a folder, or nil if it has none
+func (r *FolderDisplayRegistry) Get(folderRealPath string) (*FolderDisplay, error) {
+	if r == nil {
+		return nil, nil
+	}
+	rel, ok := r.relPath(folderRealPath)
+	if !ok {
+		return nil, nil
+	}
+	var d FolderDisplay
+	var pinnedJSON []byte
+	err := r.db.QueryRow(`
+		SELECT default_sort, default_order, pinned, updated_at
+		FROM folder_display WHERE folder_path = $1
+	`, rel).Scan(&d.DefaultSort, &d.DefaultOrder, &pinnedJSON, &d.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pinned []string
+	_ = json.Unmarshal(pinnedJSON, &pinned)
+	// A rename onto a pinned name can leave a duplicate behind
+	d.Pinned, _ = normalizePinned(pinned)
+	return &d, nil
+}
+
+// Set stores the display metadata of a folder; empty metadata removes the row
+func (r *FolderDisplayRegistry) Set(folderRealPath string, d *FolderDisplay, userID string) error {
+	if r == nil {
+		return nil
+	}
+	rel, ok := r.relPath(folderRealPath)
+	if !ok {
+		return os.ErrInvalid
+	}
+	if d.DefaultSort == "" && d.DefaultOrder == "" && len(d.Pinned) == 0 {
+		_, err := r.db.Exec(`DELETE FROM folder_display WHERE folder_path = $1`, rel)
+		return err
+	}
+	pinnedJSON, err := json.Marshal(d.Pinned)
+	if err != nil {
+		return err
+	}
+	var updatedBy interface{}
+	if userID != "" {
+		updatedBy = userID
+	}
+	_, err = r.db.Exec(`
+		INSERT INTO folder_display (folder_path, default_sort, default_order, pinned, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (folder_path) DO UPDATE SET
+			default_sort = EXCLUDED.default_sort,
+			default_order = EXCLUDED.default_order,
+			pinned = EXCLUDED.pinned,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+	`, rel, d.DefaultSort, d.DefaultOrder, pinnedJSON, updatedBy)
+	return err
+}
+
+// MovePath follows a rename or move: metadata of the moved folder and its
+// subfolders moves with it, and the item's entry in its parent's pinned list
+// is renamed, or dropped when it left the parent
+func (r *FolderDisplayRegistry) MovePath(oldRealPath, newRealPath string) {
+	if r == nil {
+		return
+	}
+	oldRel, ok1 := r.relPath(oldRealPath)
+	newRel, ok2 := r.relPath(newRealPath)
+	if !ok1 || !ok2 {
+		return
+	}
+
+	if _, err := r.db.Exec(`
+		UPDATE folder_display
+		SET folder_path = $2 || substr(folder_path, length($1) + 1)
+		WHERE folder_path = $1 OR starts_with(folder_path, $1 || '/')
+	`, oldRel, newRel); err != nil {
+		log.Printf("[FolderDisplay] Failed to move %s -> %s: %v", oldRel, newRel, err)
+		return
+	}
+
+	oldParent, oldName := path.Split(oldRel)
+	newParent, newName := path.Split(newRel)
+	if oldParent != newParent {
+		r.unpin(strings.TrimSuffix(oldParent, "/"), oldName)
+		return
+	}
+	if _, err := r.db.Exec(`
+		UPDATE folder_display
+		SET pinned = (
+			SELECT jsonb_agg(CASE WHEN name = $2 THEN $3 ELSE name END ORDER BY idx)
+			FROM jsonb_array_elements_text(pinned) WITH ORDINALITY AS p(name, idx)
+		)
+		WHERE folder_path = $1 AND pinned ? $2
+	`, strings.TrimSuffix(oldParent, "/"), oldName, newName); err != nil {
+		log.Printf("[FolderDisplay] Failed to rename pinned %s -> %s: %v", oldRel, newRel, err)
+	}
+}
+
+// ForgetTree drops metadata of a deleted folder and its subfolders, and
+// unpins the item from its parent
+func (r *FolderDisplayRegistry) ForgetTree(realPath string) {
+	if r == nil {
+		return
+	}
+	rel, ok := r.relPath(realPath)
+	if !ok {
+		return
+	}
+	if _, err := r.db.Exec(`
+		DELETE FROM folder_display WHERE folder_path = $1 OR starts_with(folder_path, $1 || '/')
+	`, rel); err != nil {
+		log.Printf("[FolderDisplay] Failed to forget %s: %v", rel, err)
+	}
+	parent, name := path.Split(rel)
+	r.unpin(strings.TrimSuffix(parent, "/"), name)
+}
+
+// unpin removes a child name from a folder's pinned list
+func (r *FolderDisplayRegistry) unpin(folderRel, name string) {
+	if folderRel == "" {
+		return
+	}
+	if _, err := r.db.Exec(`
+		UPDATE folder_display SET pinned = pinned - $2
+		WHERE folder_path = $1 AND pinned ? $2
+	`, folderRel, name); err != nil {
+		log.Printf("[FolderDisplay] Failed to unpin %s from %s: %v", name, folderRel, err)
+	}
+}
+
+// normalizePinned trims and dedupes pinned child names, keeping their order
+func normalizePinned(names []string) ([]string, bool) {
+	pinned := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, false
+		}
+		seen[name] = true
+		pinned = append(pinned, name)
+	}
+	return pinned, len(pinned) <= maxPinnedItems
+}
+
+// floatPinned moves pinned entries to the front of a sorted listing, in
+// pinned order, and returns the pinned names that exist in the listing
+func floatPinned(files []FileInfo, pinned []string) []string {
+	if len(pinned) == 0 {
+		return nil
+	}
+	rank := make(map[string]int, len(pinned))
+	for i, name := range pinned {
+		rank[name] = i
+	}
+	rankOf := func(f FileInfo) int {
+		if i, ok := rank[f.Name]; ok {
+			return i
+		}
+		return len(pinned)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return rankOf(files[i]) < rankOf(files[j])
+	})
+
+	existing := make([]string, 0, len(pinned))
+	for _, f := range files {
+		if _, ok := rank[f.Name]; !ok {
+			break
+		}
+		existing = append(existing, f.Name)
+	}
+	return existing
+}
+
+// UpdateFolderDisplayRequest is the request body for folder display metadata
+type UpdateFolderDisplayRequest struct {
+	DefaultSort  string   `json:"defaultSort"`  // name, size, date, type; empty for the viewer's choice
+	DefaultOrder string   `json:"defaultOrder"` // asc, desc
+	Pinned       []string `json:"pinned"`       // Child names listed first
+}
+
+// UpdateFolderDisplay sets the default sort and pinned items of a folder
+// @Summary		Update folder display metadata
+// @Description	Sets the default sort and pinned children of a folder for everyone who opens it. Listings use the default when no sort is requested and return pinned entries first. Sending empty values clears the metadata.
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		path	path		string						true	"Folder path"
+// @Param		request	body		UpdateFolderDisplayRequest	true	"Display metadata"
+// @Success		200		{object}	FolderDisplay				"Updated metadata"
+// @Failure		400		{object}	docs.ErrorResponse			"Invalid sort, order or pinned names"
+// @Failure		403		{object}	docs.ErrorResponse			"No write permission"
+// @Failure		404		{object}	docs.ErrorResponse			"Folder not found"
+// @Security	BearerAuth
+// @Router		/file-metadata/folder/{path} [put]
+func (h *Handler) UpdateFolderDisplay(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	requestPath := c.Param("*")
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	virtualPath := "/" + requestPath
+
+	var req UpdateFolderDisplayRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if req.DefaultSort != "" && !folderDisplaySorts[req.DefaultSort] {
+		return RespondError(c, ErrBadRequest("defaultSort must be one of name, size, date, type"))
+	}
+	if req.DefaultOrder != "" && req.DefaultOrder != "asc" && req.DefaultOrder != "desc" {
+		return RespondError(c, ErrBadRequest("defaultOrder must be asc or desc"))
+	}
+	pinned, ok := normalizePinned(req.Pinned)
+	if !ok {
+		return RespondError(c, ErrBadRequest("pinned must be at most 100 child names"))
+	}
+
+	realPath, storageType, displayPath, err := h.resolvePath(virtualPath, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if storageType != StorageHome && storageType != StorageShared {
+		return RespondError(c, ErrBadRequest("Display metadata can only be set on home or shared drive folders"))
+	}
+	if apiErr := mountInWriteError(displayPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if storageType == StorageShared && !h.CanWriteSharedDrive(claims.UserID, displayPath) {
+		return RespondError(c, ErrForbidden("No permission to write to this folder"))
+	}
+	if info, err := os.Stat(realPath); err != nil || !info.IsDir() {
+		return RespondError(c, ErrNotFound("Folder"))
+	}
+
+	display := &FolderDisplay{
+		DefaultSort:  req.DefaultSort,
+		DefaultOrder: req.DefaultOrder,
+		Pinned:       pinned,
+		UpdatedAt:    time.Now(),
+	}
+	if err := GetFolderDisplay().Set(realPath, display, claims.UserID); err != nil {
+		return RespondError(c, ErrOperationFailed("update folder display", err))
+	}
+	GetListingVersions().Invalidate(realPath)
+	return RespondSuccess(c, display)
+}
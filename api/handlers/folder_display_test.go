@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNormalizePinned(t *testing.T) {
+	pinned, ok := normalizePinned([]string{" README.txt ", "", "README.txt", "docs"})
+	if !ok || len(pinned) != 2 || pinned[0] != "README.txt" || pinned[1] != "docs" {
+		t.Errorf("pinned = %v, %v; want [README.txt docs]", pinned, ok)
+	}
+	for _, name := range []string{"..", "a/b", `a\b`} {
+		if _, ok := normalizePinned([]string{name}); ok {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestFloatPinned(t *testing.T) {
+	files := []FileInfo{
+		{Name: "releases", IsDir: true},
+		{Name: "a.txt"},
+		{Name: "README.txt"},
+		{Name: "z.txt"},
+	}
+	pinned := floatPinned(files, []string{"README.txt", "gone.txt", "z.txt"})
+
+	want := []string{"README.txt", "z.txt", "releases", "a.txt"}
+	for i, name := range want {
+		if files[i].Name != name {
+			t.Fatalf("order = %v, want %v", files, want)
+		}
+	}
+	if len(pinned) != 2 || pinned[0] != "README.txt" || pinned[1] != "z.txt" {
+		t.Errorf("pinned = %v, want existing names only", pinned)
+	}
+}
+
+func TestFolderDisplay_MovePath(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	r := &FolderDisplayRegistry{db: tc.DB, dataRoot: dataRoot}
+
+	// Rename within the same parent renames the pinned entry
+	tc.Mock.ExpectExec("UPDATE folder_display SET folder_path").
+		WithArgs("shared/team/rel", "shared/team/releases").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("UPDATE folder_display SET pinned = \\(").
+		WithArgs("shared/team", "rel", "releases").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	r.MovePath(filepath.Join(dataRoot, "shared/team/rel"), filepath.Join(dataRoot, "shared/team/releases"))
+
+	// A move to another folder unpins it from the old parent
+	tc.Mock.ExpectExec("UPDATE folder_display SET folder_path").
+		WithArgs("shared/team/releases", "shared/archive/releases").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec("UPDATE folder_display SET pinned = pinned -").
+		WithArgs("shared/team", "releases").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	r.MovePath(filepath.Join(dataRoot, "shared/team/releases"), filepath.Join(dataRoot, "shared/archive/releases"))
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateFolderDisplay(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	h := &Handler{db: tc.DB, dataRoot: dataRoot}
+	if err := os.MkdirAll(filepath.Join(dataRoot, "users", "alice", "releases"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	previous := GetFolderDisplay()
+	InitFolderDisplay(tc.DB, dataRoot)
+	t.Cleanup(func() { globalFolderDisplay = previous })
+
+	tc.Mock.ExpectExec("INSERT INTO folder_display").
+		WithArgs("users/alice/releases", "date", "desc", []byte(`["README.txt"]`), "u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req, _ := NewJSONRequest(http.MethodPut, "/api/file-metadata/folder/home/releases", UpdateFolderDisplayRequest{
+		DefaultSort:  "date",
+		DefaultOrder: "desc",
+		Pinned:       []string{"README.txt"},
+	})
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	c.SetParamNames("*")
+	c.SetParamValues("home/releases")
+	if err := h.UpdateFolderDisplay(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateFolderDisplay_Rejects(t *testing.T) {
+	for name, tt := range map[string]struct {
+		path   string
+		body   UpdateFolderDisplayRequest
+		status int
+	}{
+		"bad sort":    {path: "home/releases", body: UpdateFolderDisplayRequest{DefaultSort: "random"}, status: http.StatusBadRequest},
+		"bad order":   {path: "home/releases", body: UpdateFolderDisplayRequest{DefaultOrder: "up"}, status: http.StatusBadRequest},
+		"bad pinned":  {path: "home/releases", body: UpdateFolderDisplayRequest{Pinned: []string{"../x"}}, status: http.StatusBadRequest},
+		"not found":   {path: "home/missing", body: UpdateFolderDisplayRequest{DefaultSort: "name"}, status: http.StatusNotFound},
+		"shared root": {path: "shared", body: UpdateFolderDisplayRequest{DefaultSort: "name"}, status: http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc := SetupTest(t)
+			defer tc.Cleanup()
+			dataRoot := t.TempDir()
+			h := &Handler{db: tc.DB, dataRoot: dataRoot}
+			_ = os.MkdirAll(filepath.Join(dataRoot, "users", "alice", "releases"), 0755)
+
+			req, _ := NewJSONRequest(http.MethodPut, "/api/file-metadata/folder/"+tt.path, tt.body)
+			c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+			c.SetParamNames("*")
+			c.SetParamValues(tt.path)
+			if err := h.UpdateFolderDisplay(c); err != nil {
+				t.Fatal(err)
+			}
+			AssertStatus(t, tc.Recorder, tt.status)
+		})
+	}
+}
+
+func TestListFiles_FolderDefaults(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	h := &Handler{db: tc.DB, dataRoot: dataRoot}
+	dir := filepath.Join(dataRoot, "users", "alice", "releases")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"README.txt", "v1.zip", "v2.zip"} {
+		p := filepath.Join(dir, name)
+		_ = os.WriteFile(p, []byte(name), 0644)
+		_ = os.Chtimes(p, base.Add(time.Duration(i)*time.Minute), base.Add(time.Duration(i)*time.Minute))
+	}
+	previous := GetFolderDisplay()
+	InitFolderDisplay(tc.DB, dataRoot)
+	t.Cleanup(func() { globalFolderDisplay = previous })
+
+	tc.Mock.ExpectQuery("SELECT default_sort, default_order, pinned").
+		WithArgs("users/alice/releases").
+		WillReturnRows(sqlmock.NewRows([]string{"default_sort", "default_order", "pinned", "updated_at"}).
+			AddRow("date", "desc", []byte(`["README.txt"]`), time.Now()))
+
+	req, _ := NewJSONRequest(http.MethodGet, "/api/files?path=/home/releases", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	if err := h.ListFiles(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+
+	var resp ListFilesResponse
+	if err := ParseJSONResponse(tc.Recorder, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Sort != "date" || resp.Order != "desc" || len(resp.Pinned) != 1 {
+		t.Fatalf("sort = %s %s, pinned = %v", resp.Sort, resp.Order, resp.Pinned)
+	}
+	want := []string{"README.txt", "v2.zip", "v1.zip"}
+	for i, name := range want {
+		if resp.Files[i].Name != name {
+			t.Errorf("files[%d] = %s, want %s", i, resp.Files[i].Name, name)
+		}
+	}
+}
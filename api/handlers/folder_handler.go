@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -23,6 +26,8 @@ type FolderStats struct {
 	FileCount   int    `json:"fileCount"`
 	FolderCount int    `json:"folderCount"`
 	TotalSize   int64  `json:"totalSize"`
+	// Set only with ?detail=true
+	Detail *FolderStatsDetail `json:"detail,omitempty"`
 }
 
 // CreateFolder handles folder creation requests
@@ -100,6 +105,9 @@ func (h *Handler) CreateFolder(c echo.Context) error {
 			"error": "Authentication required",
 		})
 	}
+	if apiErr := storageWriteError(realParentPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Check shared write permission
 	if storageType == StorageShared {
@@ -108,6 +116,9 @@ func (h *Handler) CreateFolder(c echo.Context) error {
 				"error": "Authentication required",
 			})
 		}
+		if apiErr := mountInWriteError(parentPath); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
 		if !h.CanWriteSharedDrive(claims.UserID, parentPath) {
 			return c.JSON(http.StatusForbidden, map[string]string{
 				"error": "No permission to create folders in this shared drive",
@@ -141,6 +152,9 @@ func (h *Handler) CreateFolder(c echo.Context) error {
 		}
 	}
 
+	GetChangeJournal().Record(ChangeCreate, folderPath, "", changeActor(claims))
+	GetListingVersions().Invalidate(folderPath)
+
 	newFolderPath := filepath.Join(displayPath, req.Name)
 
 	// Log audit event
@@ -232,6 +246,9 @@ func (h *Handler) DeleteFolder(c echo.Context) error {
 			"error": "Authentication required",
 		})
 	}
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Check shared write permission
 	if storageType == StorageShared {
@@ -240,6 +257,9 @@ func (h *Handler) DeleteFolder(c echo.Context) error {
 				"error": "Authentication required",
 			})
 		}
+		if apiErr := mountInMoveError(virtualPath); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
 		if !h.CanWriteSharedDrive(claims.UserID, virtualPath) {
 			return c.JSON(http.StatusForbidden, map[string]string{
 				"error": "No permission to delete folders in this shared drive",
@@ -247,6 +267,10 @@ func (h *Handler) DeleteFolder(c echo.Context) error {
 		}
 	}
 
+	if apiErr := h.retentionError(c, claims, realPath, EventFolderDelete, true); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
 	info, err := os.Stat(realPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -302,6 +326,17 @@ func (h *Handler) DeleteFolder(c echo.Context) error {
 		}
 	}
 
+	GetDownloadStats().MarkDeleted(realPath)
+	GetFileEncryption().ForgetTree(realPath)
+	GetFileLinks().ForgetTree(realPath)
+	GetFolderDisplay().ForgetTree(realPath)
+	GetContentInspection().ForgetTree(realPath)
+	GetContentIndex().ForgetTree(realPath)
+	GetFileChecksums().ForgetTree(realPath)
+	GetDirSizes().ForgetTree(realPath)
+	GetChangeJournal().Record(ChangeDelete, realPath, "", changeActor(claims))
+	GetListingVersions().Invalidate(realPath)
+
 	// Update storage tracking (only if force delete with non-zero size)
 	if force && folderSize > 0 {
 		if storageType == StorageShared {
@@ -325,18 +360,21 @@ func (h *Handler) DeleteFolder(c echo.Context) error {
 // GetFolderStats returns statistics for a folder (recursive file/folder count and total size)
 // Uses caching for improved performance
 // @Summary		Get folder statistics
-// @Description	Get recursive statistics for a folder including file count, folder count, and total size
+// @Description	Get recursive statistics for a folder including file count, folder count, and total size. With detail=true, also returns a by-extension breakdown (top 20 plus "(other)") and the largest, oldest and deepest items, computed in one walk; partial is true if the walk hit its time budget. Without detail, a walk over the folder_stats_budget_seconds budget returns 503 with Retry-After.
 // @Tags		Files
 // @Accept		json
 // @Produce		json
 // @Param		path		path		string	true	"Folder path"
 // @Param		no-cache	query		bool	false	"Bypass cache and recompute stats"
+// @Param		detail		query		bool	false	"Include extension breakdown and largest, oldest and deepest items (not cached)"
+// @Param		limit		query		int		false	"Items per list with detail=true (default 20, max 100)"
 // @Success		200		{object}	FolderStats	"Folder statistics"
 // @Failure		400		{object}	map[string]string	"Bad request"
 // @Failure		401		{object}	map[string]string	"Unauthorized"
 // @Failure		403		{object}	map[string]string	"Forbidden"
 // @Failure		404		{object}	map[string]string	"Path not found"
 // @Failure		500		{object}	map[string]string	"Internal server error"
+// @Failure		503		{object}	docs.ErrorResponse	"Time budget exceeded"
 // @Security	BearerAuth
 // @Router		/folders/stats/{path} [get]
 func (h *Handler) GetFolderStats(c echo.Context) error {
@@ -398,6 +436,28 @@ func (h *Handler) GetFolderStats(c echo.Context) error {
 		})
 	}
 
+	ctx, cancel := budgetFolderStats.Context(c.Request().Context())
+	defer cancel()
+
+	// Detailed stats need a full walk and are not cached
+	if c.QueryParam("detail") == "true" {
+		limit := folderStatsDefaultItems
+		if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+			limit = min(l, folderStatsMaxItems)
+		}
+		stats, detail, err := computeFolderStatsDetail(ctx, realPath, displayPath, limit, folderStatsDetailBudget)
+		if err != nil {
+			return respondWalkStopped(c, err)
+		}
+		return c.JSON(http.StatusOK, FolderStats{
+			Path:        displayPath,
+			FileCount:   int(stats.FileCount),
+			FolderCount: int(stats.FolderCount),
+			TotalSize:   stats.TotalSize,
+			Detail:      detail,
+		})
+	}
+
 	// Check for no-cache query parameter
 	noCache := c.QueryParam("no-cache") == "true"
 
@@ -405,8 +465,11 @@ func (h *Handler) GetFolderStats(c echo.Context) error {
 	cache := GetStatsCache()
 	if cache != nil && !noCache {
 		stats, err := cache.GetOrCompute(realPath, func() (*CachedFolderStats, error) {
-			return h.computeFolderStatsInternal(realPath)
+			return h.computeFolderStatsInternal(ctx, realPath)
 		})
+		if isWalkStopped(err) {
+			return respondWalkStopped(c, err)
+		}
 		if err == nil {
 			// Set cache headers
 			SetCacheHeaders(c.Response().Writer, GenerateETag(realPath, info.ModTime(), 0), 60) // 1 minute browser cache
@@ -421,7 +484,10 @@ func (h *Handler) GetFolderStats(c echo.Context) error {
 	}
 
 	// Compute stats directly
-	stats, err := h.computeFolderStatsInternal(realPath)
+	stats, err := h.computeFolderStatsInternal(ctx, realPath)
+	if isWalkStopped(err) {
+		return respondWalkStopped(c, err)
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to calculate folder stats",
@@ -442,12 +508,22 @@ func (h *Handler) GetFolderStats(c echo.Context) error {
 	})
 }
 
-// computeFolderStatsInternal calculates folder statistics
-func (h *Handler) computeFolderStatsInternal(realPath string) (*CachedFolderStats, error) {
+// computeFolderStatsInternal calculates folder statistics, from the folder
+// size index when it covers the folder. The walk stops when ctx ends; see
+// walkStopped for the error returned then.
+func (h *Handler) computeFolderStatsInternal(ctx context.Context, realPath string) (*CachedFolderStats, error) {
+	if u, ok := GetDirSizes().VisibleUsage(ctx, realPath); ok {
+		return &CachedFolderStats{FileCount: u.Files, FolderCount: u.Folders, TotalSize: u.Bytes}, nil
+	}
+
 	var fileCount, folderCount int64
 	var totalSize int64
 
+	guard := newWalkGuard(ctx)
 	err := filepath.Walk(realPath, func(path string, info os.FileInfo, err error) error {
+		if err := guard.Check(); err != nil {
+			return err
+		}
 		if err != nil {
 			return nil // Skip errors
 		}
@@ -519,6 +595,8 @@ func (h *Handler) BatchGetFolderStats(c echo.Context) error {
 
 	results := make(map[string]interface{})
 	cache := GetStatsCache()
+	ctx, cancel := budgetFolderStats.Context(c.Request().Context())
+	defer cancel()
 
 	for _, path := range req.Paths {
 		realPath, storageType, displayPath, err := h.resolvePath(path, claims)
@@ -549,8 +627,15 @@ func (h *Handler) BatchGetFolderStats(c echo.Context) error {
 		// Try cache
 		if cache != nil {
 			stats, err := cache.GetOrCompute(realPath, func() (*CachedFolderStats, error) {
-				return h.computeFolderStatsInternal(realPath)
+				return h.computeFolderStatsInternal(ctx, realPath)
 			})
+			if isWalkStopped(err) {
+				if !errors.Is(err, errWalkBudget) {
+					return nil // Client gone
+				}
+				results[path] = map[string]string{"error": "timed out"}
+				continue
+			}
 			if err == nil {
 				results[path] = FolderStats{
 					Path:        displayPath,
@@ -563,7 +648,14 @@ func (h *Handler) BatchGetFolderStats(c echo.Context) error {
 		}
 
 		// Compute directly
-		stats, err := h.computeFolderStatsInternal(realPath)
+		stats, err := h.computeFolderStatsInternal(ctx, realPath)
+		if isWalkStopped(err) {
+			if !errors.Is(err, errWalkBudget) {
+				return nil // Client gone
+			}
+			results[path] = map[string]string{"error": "timed out"}
+			continue
+		}
 		if err != nil {
 			results[path] = map[string]string{"error": "failed to compute"}
 			continue
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// folderStatsDetailBudget bounds the walk for ?detail=true
+	folderStatsDetailBudget = 10 * time.Second
+	// folderStatsTopExtensions is how many extensions are listed before "other"
+	folderStatsTopExtensions = 20
+	// folderStatsDefaultItems and folderStatsMaxItems bound the item lists
+	folderStatsDefaultItems = 20
+	folderStatsMaxItems     = 100
+)
+
+// Extension labels that are not real extensions
+const (
+	ExtensionNone  = "(none)"
+	ExtensionOther = "(other)"
+)
+
+var errFolderStatsBudget = errors.New("folder stats time budget exceeded")
+
+// ExtensionStat is the file count and size of one extension
+type ExtensionStat struct {
+	Extension string `json:"extension"` // Lowercase without the dot, or (none) / (other)
+	Count     int64  `json:"count"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// FolderStatsItem is a file or folder listed in the detailed stats
+type FolderStatsItem struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Depth   int       `json:"depth"` // 1 for direct children
+}
+
+// FolderStatsDetail breaks a folder's usage down for ?detail=true
+type FolderStatsDetail struct {
+	Extensions   []ExtensionStat   `json:"extensions"` // By bytes, largest first
+	LargestFiles []FolderStatsItem `json:"largestFiles"`
+	OldestFiles  []FolderStatsItem `json:"oldestFiles"`
+	DeepestItems []FolderStatsItem `json:"deepestItems"`
+	MaxDepth     int               `json:"maxDepth"`
+	Partial      bool              `json:"partial"` // The walk stopped at the time budget
+	ElapsedMs    int64             `json:"elapsedMs"`
+}
+
+// topItems keeps the n items that sort first under less
+type topItems struct {
+	n     int
+	less  func(a, b FolderStatsItem) bool
+	items []FolderStatsItem
+}
+
+func (t *topItems) add(item FolderStatsItem) {
+	if len(t.items) == t.n && !t.less(item, t.items[len(t.items)-1]) {
+		return
+	}
+	i := sort.Search(len(t.items), func(i int) bool { return t.less(item, t.items[i]) })
+	if len(t.items) < t.n {
+		t.items = append(t.items, FolderStatsItem{})
+	}
+	copy(t.items[i+1:], t.items[i:])
+	t.items[i] = item
+}
+
+func (t *topItems) list() []FolderStatsItem {
+	if t.items == nil {
+		return []FolderStatsItem{}
+	}
+	return t.items
+}
+
+// computeFolderStatsDetail walks realPath once, collecting totals and the
+// breakdowns. Hidden entries are skipped like in computeFolderStatsInternal.
+// When the budget or ctx's budget runs out the results cover what was walked
+// so far; when ctx is cancelled the walk stops and its error is returned.
+func computeFolderStatsDetail(ctx context.Context, realPath, displayPath string, limit int, budget time.Duration) (*CachedFolderStats, *FolderStatsDetail, error) {
+	started := time.Now()
+	deadline := started.Add(budget)
+
+	totals := &CachedFolderStats{}
+	detail := &FolderStatsDetail{}
+	extensions := make(map[string]*ExtensionStat)
+	largest := &topItems{n: limit, less: func(a, b FolderStatsItem) bool { return a.Size > b.Size }}
+	oldest := &topItems{n: limit, less: func(a, b FolderStatsItem) bool { return a.ModTime.Before(b.ModTime) }}
+	deepest := &topItems{n: limit, less: func(a, b FolderStatsItem) bool { return a.Depth > b.Depth }}
+
+	walked := 0
+	err := filepath.WalkDir(realPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip errors
+		}
+		// Checking the clock on every entry is measurable on large trees
+		if walked++; walked%walkCheckEvery == 0 {
+			if time.Now().After(deadline) {
+				return errFolderStatsBudget
+			}
+			if err := walkStopped(ctx); err != nil {
+				return err
+			}
+		}
+		if p == realPath {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(realPath, p)
+		rel = filepath.ToSlash(rel)
+		item := FolderStatsItem{
+			Path:    path.Join(displayPath, rel),
+			Name:    d.Name(),
+			IsDir:   d.IsDir(),
+			ModTime: info.ModTime(),
+			Depth:   strings.Count(rel, "/") + 1,
+		}
+		if item.Depth > detail.MaxDepth {
+			detail.MaxDepth = item.Depth
+		}
+		deepest.add(item)
+
+		if d.IsDir() {
+			totals.FolderCount++
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		totals.FileCount++
+		totals.TotalSize += info.Size()
+		item.Size = info.Size()
+		largest.add(item)
+		oldest.add(item)
+
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(d.Name()), "."))
+		if ext == "" {
+			ext = ExtensionNone
+		}
+		stat := extensions[ext]
+		if stat == nil {
+			stat = &ExtensionStat{Extension: ext}
+			extensions[ext] = stat
+		}
+		stat.Count++
+		stat.Bytes += info.Size()
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errFolderStatsBudget) && !errors.Is(err, errWalkBudget) {
+		return nil, nil, err
+	}
+	detail.Partial = err != nil
+	detail.Extensions = topExtensions(extensions, folderStatsTopExtensions)
+	detail.LargestFiles = largest.list()
+	detail.OldestFiles = oldest.list()
+	detail.DeepestItems = deepest.list()
+	detail.ElapsedMs = time.Since(started).Milliseconds()
+	return totals, detail, nil
+}
+
+// topExtensions sorts extensions by bytes and folds everything past the
+// first n into a single (other) entry
+func topExtensions(extensions map[string]*ExtensionStat, n int) []ExtensionStat {
+	list := make([]ExtensionStat, 0, len(extensions))
+	for _, stat := range extensions {
+		list = append(list, *stat)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bytes != list[j].Bytes {
+			return list[i].Bytes > list[j].Bytes
+		}
+		return list[i].Extension < list[j].Extension
+	})
+	if len(list) <= n {
+		return list
+	}
+	other := ExtensionStat{Extension: ExtensionOther}
+	for _, stat := range list[n:] {
+		other.Count += stat.Count
+		other.Bytes += stat.Bytes
+	}
+	return append(list[:n], other)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputeFolderStatsDetail(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel string, size int, age time.Duration) {
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("video.MP4", 5000, time.Hour)
+	write("docs/a.pdf", 300, 48*time.Hour)
+	write("docs/b.pdf", 200, time.Minute)
+	write("docs/deep/er/notes", 10, time.Minute)
+	write(".hidden/big.bin", 100000, 0)
+
+	totals, detail, err := computeFolderStatsDetail(context.Background(), root, "/home/projects", 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if totals.FileCount != 4 || totals.FolderCount != 3 || totals.TotalSize != 5510 {
+		t.Errorf("totals = %+v", totals)
+	}
+	if detail.Partial {
+		t.Error("unexpected partial result")
+	}
+	if len(detail.Extensions) != 3 || detail.Extensions[0].Extension != "mp4" || detail.Extensions[1].Count != 2 {
+		t.Errorf("extensions = %+v", detail.Extensions)
+	}
+	if detail.Extensions[2].Extension != ExtensionNone {
+		t.Errorf("expected files without extension as %s, got %+v", ExtensionNone, detail.Extensions[2])
+	}
+	if len(detail.LargestFiles) != 2 || detail.LargestFiles[0].Path != "/home/projects/video.MP4" || detail.LargestFiles[1].Name != "a.pdf" {
+		t.Errorf("largest = %+v", detail.LargestFiles)
+	}
+	if detail.OldestFiles[0].Name != "a.pdf" {
+		t.Errorf("oldest = %+v", detail.OldestFiles)
+	}
+	if detail.MaxDepth != 4 || detail.DeepestItems[0].Path != "/home/projects/docs/deep/er/notes" {
+		t.Errorf("deepest = %+v (max %d)", detail.DeepestItems, detail.MaxDepth)
+	}
+}
+
+func TestTopExtensionsFoldsOther(t *testing.T) {
+	extensions := make(map[string]*ExtensionStat)
+	for i := 0; i < 25; i++ {
+		ext := fmt.Sprintf("e%02d", i)
+		extensions[ext] = &ExtensionStat{Extension: ext, Count: 1, Bytes: int64(100 - i)}
+	}
+	list := topExtensions(extensions, folderStatsTopExtensions)
+	if len(list) != folderStatsTopExtensions+1 {
+		t.Fatalf("got %d entries", len(list))
+	}
+	other := list[len(list)-1]
+	if other.Extension != ExtensionOther || other.Count != 5 || other.Bytes != 76+77+78+79+80 {
+		t.Errorf("other = %+v", other)
+	}
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+)
+
+// mmdbMetadataMarker precedes the metadata map at the end of an MMDB file
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// GeoIPReader looks up the country of an IP address in a MaxMind DB file
+// (GeoLite2-Country, DB-IP country lite and compatible databases). It reads
+// the file into memory and only decodes what a country lookup needs, so no
+// GeoIP library is required.
+type GeoIPReader struct {
+	data       []byte
+	nodeCount  uint64
+	recordSize uint64
+	ipVersion  uint64
+	dataStart  uint64
+	ipv4Start  uint64
+}
+
+// OpenGeoIP reads an MMDB file
+func OpenGeoIP(path string) (*GeoIPReader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newGeoIPReader(data)
+}
+
+func newGeoIPReader(data []byte) (*GeoIPReader, error) {
+	idx := bytes.LastIndex(data, mmdbMetadataMarker)
+	if idx < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	d := mmdbDecoder{data: data[idx+len(mmdbMetadataMarker):]}
+	value, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	meta, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid metadata")
+	}
+	r := &GeoIPReader{data: data}
+	r.nodeCount, _ = meta["node_count"].(uint64)
+	r.recordSize, _ = meta["record_size"].(uint64)
+	r.ipVersion, _ = meta["ip_version"].(uint64)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	treeSize := r.recordSize * 2 / 8 * r.nodeCount
+	if r.nodeCount == 0 || treeSize+16 > uint64(idx) {
+		return nil, errors.New("invalid search tree")
+	}
+	r.dataStart = treeSize + 16
+
+	// IPv4 addresses live under ::/96 of an IPv6 tree
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node
+func (r *GeoIPReader) record(node uint64, bit byte) uint64 {
+	switch r.recordSize {
+	case 24:
+		b := r.data[node*6+uint64(bit)*3:]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+	case 28:
+		b := r.data[node*7:]
+		if bit == 0 {
+			return uint64(b[3]&0xf0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		}
+		return uint64(b[3]&0x0f)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6])
+	default:
+		return uint64(binary.BigEndian.Uint32(r.data[node*8+uint64(bit)*4:]))
+	}
+}
+
+// Country returns the ISO 3166 country code of ip, or "" if the database
+// has none for it
+func (r *GeoIPReader) Country(ip net.IP) (string, error) {
+	if r == nil || ip == nil {
+		return "", nil
+	}
+	addr := ip.To4()
+	node := r.ipv4Start
+	if addr == nil {
+		if r.ipVersion != 6 {
+			return "", nil
+		}
+		addr = ip.To16()
+		node = 0
+	}
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, addr[i/8]>>(7-uint(i%8))&1)
+	}
+	if node <= r.nodeCount {
+		return "", nil // Not in the database
+	}
+
+	d := mmdbDecoder{data: r.data[r.dataStart:]}
+	value, _, err := d.decode(node-r.nodeCount-16, 0)
+	if err != nil {
+		return "", err
+	}
+	record, _ := value.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				return code, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// mmdbMaxDepth bounds nested maps, arrays and pointers in a damaged file
+const mmdbMaxDepth = 32
+
+// mmdbDecoder decodes the MaxMind DB data section format
+type mmdbDecoder struct {
+	data []byte
+}
+
+func (d *mmdbDecoder) bytes(offset, n uint64) ([]byte, error) {
+	if offset+n > uint64(len(d.data)) {
+		return nil, errors.New("unexpected end of data")
+	}
+	return d.data[offset : offset+n], nil
+}
+
+// decode returns the value at offset and the offset following it
+func (d *mmdbDecoder) decode(offset uint64, depth int) (interface{}, uint64, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	offset++
+	kind := ctrl >> 5
+
+	if kind == 1 { // Pointer
+		n := uint64(ctrl>>3&3) + 1
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		var ptr uint64
+		if n < 4 {
+			ptr = uint64(ctrl & 7)
+		}
+		for _, c := range b {
+			ptr = ptr<<8 | uint64(c)
+		}
+		ptr += [...]uint64{0, 2048, 526336, 0}[n-1]
+		value, _, err := d.decode(ptr, depth+1)
+		return value, offset + n, err
+	}
+
+	if kind == 0 { // Extended type
+		b, err := d.bytes(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + b[0]
+		offset++
+	}
+
+	size := uint64(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		size = 0
+		for _, c := range b {
+			size = size<<8 | uint64(c)
+		}
+		size += [...]uint64{29, 285, 65821}[n-1]
+		offset += n
+	}
+
+	switch kind {
+	case 7: // Map
+		m := make(map[string]interface{}, min(size, 64))
+		for i := uint64(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			if k, ok := key.(string); ok {
+				m[k] = value
+			}
+			offset = next
+		}
+		return m, offset, nil
+	case 11: // Array
+		a := make([]interface{}, 0, min(size, 64))
+		for i := uint64(0); i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case 14: // Boolean, the value is the size
+		return size != 0, offset, nil
+	}
+
+	b, err = d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch kind {
+	case 2: // UTF-8 string
+		return string(b), offset, nil
+	case 3: // Double
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15: // Float
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 5, 6, 9, 10: // Unsigned integers; uint128 keeps its low 64 bits
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case 8: // int32
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), offset, nil
+	case 4: // Bytes
+		return append([]byte(nil), b...), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", kind)
+}
+
+var (
+	geoIPMu     sync.Mutex
+	geoIPPath   string
+	geoIPReader *GeoIPReader
+)
+
+// loadGeoIP returns the reader for the geoip_mmdb_path setting, reopening it
+// when the path changes; nil when no database is configured or it cannot be read
+func loadGeoIP() *GeoIPReader {
+	var path string
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		path, _ = settings.GetSetting("geoip_mmdb_path")
+	}
+
+	geoIPMu.Lock()
+	defer geoIPMu.Unlock()
+	if path == geoIPPath {
+		return geoIPReader
+	}
+	geoIPPath, geoIPReader = path, nil
+	if path == "" {
+		return nil
+	}
+	reader, err := OpenGeoIP(path)
+	if err != nil {
+		LogWarn("GeoIP database unavailable", "path", path, "error", err)
+		return nil
+	}
+	geoIPReader = reader
+	return reader
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mmdbString, mmdbUint and mmdbMap encode data section values
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func mmdbUint(kind byte, v uint16) []byte {
+	return []byte{kind<<5 | 2, byte(v >> 8), byte(v)}
+}
+
+func mmdbMap(pairs ...[]byte) []byte {
+	b := []byte{7<<5 | byte(len(pairs)/2)}
+	for _, p := range pairs {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// buildTestMMDB builds an IPv4 database with 24-bit records in which
+// 10.0.0.0/8 is in country DE and nothing else is known
+func buildTestMMDB() []byte {
+	const nodes = 8
+	record := mmdbMap(mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString("DE")))
+
+	var tree []byte
+	put := func(v int) { tree = append(tree, byte(v>>16), byte(v>>8), byte(v)) }
+	for i := 0; i < nodes; i++ {
+		next := i + 1
+		if i == nodes-1 {
+			next = nodes + 16 // Data section offset 0
+		}
+		// 10 = 00001010: follow the bit of the address, the other branch is empty
+		if 10>>(7-i)&1 == 1 {
+			put(nodes)
+			put(next)
+		} else {
+			put(next)
+			put(nodes)
+		}
+	}
+
+	db := append(tree, make([]byte, 16)...)
+	db = append(db, record...)
+	db = append(db, mmdbMetadataMarker...)
+	return append(db, mmdbMap(
+		mmdbString("node_count"), mmdbUint(6, nodes),
+		mmdbString("record_size"), mmdbUint(5, 24),
+		mmdbString("ip_version"), mmdbUint(5, 4),
+	)...)
+}
+
+func TestGeoIPCountry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, buildTestMMDB(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenGeoIP(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, want := range map[string]string{
+		"10.1.2.3":    "DE",
+		"10.255.0.1":  "DE",
+		"11.0.0.1":    "",
+		"192.168.0.1": "",
+		"2001:db8::1": "",
+	} {
+		got, err := r.Country(net.ParseIP(ip))
+		if err != nil {
+			t.Fatalf("%s: %v", ip, err)
+		}
+		if got != want {
+			t.Errorf("Country(%s) = %q, want %q", ip, got, want)
+		}
+	}
+
+	if _, err := newGeoIPReader([]byte("not a database")); err == nil {
+		t.Error("expected an error for a file without metadata")
+	}
+}
@@ -0,0 +1,585 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Guest accounts. A guest is a user with a mandatory expiry who belongs
+// only to the shared drives it was created for: no home folder, no link
+// or user-to-user shares. Login and every token are refused from the
+// expiry on; the daily guests.expire job deactivates expired guests and
+// revokes their tokens, and archives them guest_archive_days later by
+// removing their drive memberships and shares. Extending a guest or
+// converting it into a full account, which creates the home folder, are
+// audited admin actions. Guests are kept in memory so the checks on
+// every request don't cost a query.
+
+const (
+	settingGuestMaxDays     = "guest_max_days"
+	settingGuestArchiveDays = "guest_archive_days"
+
+	// JobGuestsExpire is the job type deactivating and archiving expired guests
+	JobGuestsExpire = "guests.expire"
+)
+
+// Guest states reported in the user list
+const (
+	GuestStatusActive   = "active"
+	GuestStatusExpired  = "expired"
+	GuestStatusArchived = "archived"
+)
+
+// guestAccount is the in-memory state of one guest
+type guestAccount struct {
+	Username      string
+	ExpiresAt     time.Time
+	TokensRevoked time.Time // Tokens issued before are refused; zero if never revoked
+	Archived      bool
+}
+
+// Guests tracks guest accounts and expires them
+type Guests struct {
+	db    *sql.DB
+	audit *AuditHandler
+
+	mu     sync.RWMutex
+	byID   map[string]guestAccount
+	byName map[string]string // username -> user ID
+}
+
+var globalGuests *Guests
+
+// InitGuests loads the guest accounts and schedules their daily expiry
+func InitGuests(db *sql.DB, audit *AuditHandler) *Guests {
+	g := newGuests(db, audit)
+	if err := g.load(); err != nil {
+		log.Printf("[Guests] Failed to load guest accounts: %v", err)
+	}
+	globalGuests = g
+
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:        JobGuestsExpire,
+		Idempotent:  true,
+		MaxAttempts: 3,
+		Run:         g.expire,
+	})
+	jobs.Schedule(JobGuestsExpire, 24*time.Hour, true)
+	return g
+}
+
+func newGuests(db *sql.DB, audit *AuditHandler) *Guests {
+	return &Guests{
+		db:     db,
+		audit:  audit,
+		byID:   make(map[string]guestAccount),
+		byName: make(map[string]string),
+	}
+}
+
+// GetGuests returns the guest accounts (nil if not initialized)
+func GetGuests() *Guests {
+	return globalGuests
+}
+
+func (g *Guests) load() error {
+	rows, err := g.db.Query(`
+		SELECT id, username, guest_expires_at, tokens_revoked_at, guest_archived_at IS NOT NULL
+		FROM users WHERE is_guest
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var account guestAccount
+		var expiresAt, revokedAt sql.NullTime
+		if err := rows.Scan(&id, &account.Username, &expiresAt, &revokedAt, &account.Archived); err != nil {
+			return err
+		}
+		account.ExpiresAt, account.TokensRevoked = expiresAt.Time, revokedAt.Time
+		g.set(id, account)
+	}
+	return rows.Err()
+}
+
+func (g *Guests) set(userID string, account guestAccount) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.byID[userID] = account
+	g.byName[account.Username] = userID
+}
+
+// forget drops a user that is no longer a guest
+func (g *Guests) forget(userID string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if account, ok := g.byID[userID]; ok {
+		delete(g.byName, account.Username)
+		delete(g.byID, userID)
+	}
+}
+
+func (g *Guests) lookup(userID string) (guestAccount, bool) {
+	if g == nil {
+		return guestAccount{}, false
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	account, ok := g.byID[userID]
+	return account, ok
+}
+
+// IsGuest reports whether a user is a guest
+func (g *Guests) IsGuest(userID string) bool {
+	_, ok := g.lookup(userID)
+	return ok
+}
+
+// IsGuestName reports whether a username belongs to a guest
+func (g *Guests) IsGuestName(username string) bool {
+	if g == nil {
+		return false
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.byName[username]
+	return ok
+}
+
+// Expired reports whether a user is a guest past its expiry
+func (g *Guests) Expired(userID string) bool {
+	account, ok := g.lookup(userID)
+	return ok && !time.Now().Before(account.ExpiresAt)
+}
+
+// TokenAllowed reports whether a token may be used: always for full
+// accounts; for guests only before the expiry and if it was issued after
+// the guest's tokens were last revoked
+func (g *Guests) TokenAllowed(claims *JWTClaims) bool {
+	account, ok := g.lookup(claims.UserID)
+	if !ok {
+		return true
+	}
+	if !time.Now().Before(account.ExpiresAt) {
+		return false
+	}
+	// IssuedAt has a precision of seconds
+	revoked := account.TokensRevoked.Truncate(time.Second)
+	if !account.TokensRevoked.IsZero() && (claims.IssuedAt == nil || claims.IssuedAt.Before(revoked)) {
+		return false
+	}
+	return true
+}
+
+// status returns the guest status of a user for the user list, "" for full accounts
+func (g *Guests) status(userID string) (string, *time.Time) {
+	account, ok := g.lookup(userID)
+	switch {
+	case !ok:
+		return "", nil
+	case account.Archived:
+		return GuestStatusArchived, &account.ExpiresAt
+	case !time.Now().Before(account.ExpiresAt):
+		return GuestStatusExpired, &account.ExpiresAt
+	}
+	return GuestStatusActive, &account.ExpiresAt
+}
+
+// respondGuestExpired refuses a request made with an expired or revoked guest token
+func respondGuestExpired(c echo.Context) error {
+	return c.JSON(http.StatusUnauthorized, map[string]string{
+		"error": "Guest account has expired",
+	})
+}
+
+// expire deactivates guests past their expiry, revoking their tokens, and
+// archives those expired more than guest_archive_days ago
+func (g *Guests) expire(run *JobRun) error {
+	rows, err := g.db.Query(`
+		UPDATE users SET is_active = FALSE, tokens_revoked_at = NOW(), updated_at = NOW()
+		WHERE is_guest AND is_active AND guest_expires_at <= NOW()
+		RETURNING id, username, guest_expires_at, tokens_revoked_at
+	`)
+	if err != nil {
+		return err
+	}
+	var expired []string
+	for rows.Next() {
+		var id string
+		var account guestAccount
+		if err := rows.Scan(&id, &account.Username, &account.ExpiresAt, &account.TokensRevoked); err != nil {
+			rows.Close()
+			return err
+		}
+		g.set(id, account)
+		expired = append(expired, id)
+		if g.audit != nil {
+			_ = g.audit.LogEvent(nil, "0.0.0.0", EventGuestExpire, account.Username, map[string]interface{}{
+				"userId":    id,
+				"expiresAt": account.ExpiresAt,
+			})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	archiveDays := 30
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		archiveDays = sh.GetSettingInt(settingGuestArchiveDays, archiveDays)
+	}
+	due, err := g.db.Query(`
+		SELECT id, username FROM users
+		WHERE is_guest AND guest_archived_at IS NULL AND guest_expires_at <= NOW() - make_interval(days => $1)
+	`, max(archiveDays, 0))
+	if err != nil {
+		return err
+	}
+	type guestRef struct{ id, username string }
+	var toArchive []guestRef
+	for due.Next() {
+		var ref guestRef
+		if err := due.Scan(&ref.id, &ref.username); err != nil {
+			due.Close()
+			return err
+		}
+		toArchive = append(toArchive, ref)
+	}
+	due.Close()
+
+	archived := 0
+	for _, ref := range toArchive {
+		if err := run.Context().Err(); err != nil {
+			return err
+		}
+		if err := g.archive(ref.id, ref.username); err != nil {
+			log.Printf("[Guests] Failed to archive guest %s: %v", ref.username, err)
+			continue
+		}
+		archived++
+	}
+	run.SetProgress(map[string]interface{}{"deactivated": len(expired), "archived": archived})
+	if len(expired) > 0 || archived > 0 {
+		GetSMBShares().Sync(nil, "0.0.0.0", JobGuestsExpire)
+		log.Printf("[Guests] Deactivated %d expired guests, archived %d", len(expired), archived)
+	}
+	return nil
+}
+
+// archive removes an expired guest's drive memberships and shares. The
+// account itself stays, deactivated, for the audit trail.
+func (g *Guests) archive(userID, username string) error {
+	var memberships, fileShares int64
+	err := WithTransaction(g.db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM shared_folder_members WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		memberships, _ = res.RowsAffected()
+		res, err = tx.Exec(`DELETE FROM file_shares WHERE owner_id = $1 OR shared_with_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		fileShares, _ = res.RowsAffected()
+		if _, err := tx.Exec(`UPDATE shares SET is_active = FALSE WHERE created_by = $1`, userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE users SET guest_archived_at = NOW(), updated_at = NOW() WHERE id = $1`, userID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if account, ok := g.lookup(userID); ok {
+		account.Archived = true
+		g.set(userID, account)
+	}
+	if cache := GetPermissionCache(); cache != nil {
+		cache.InvalidateUser(userID)
+	}
+	if g.audit != nil {
+		_ = g.audit.LogEvent(nil, "0.0.0.0", EventGuestArchive, username, map[string]interface{}{
+			"userId":      userID,
+			"memberships": memberships,
+			"fileShares":  fileShares,
+		})
+	}
+	return nil
+}
+
+// guestExpiryError checks a requested guest expiry against guest_max_days
+func guestExpiryError(expiresAt time.Time, now time.Time) *APIError {
+	if !expiresAt.After(now) {
+		return ErrBadRequest("Expiry must be in the future")
+	}
+	maxDays := 90
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		maxDays = sh.GetSettingInt(settingGuestMaxDays, maxDays)
+	}
+	if maxDays > 0 && expiresAt.After(now.AddDate(0, 0, maxDays)) {
+		return ErrBadRequest(fmt.Sprintf("Guest accounts can be valid for at most %d days", maxDays)).
+			WithDetails(map[string]interface{}{"maxDays": maxDays})
+	}
+	return nil
+}
+
+// GuestDrive is a shared drive a guest is made a member of
+type GuestDrive struct {
+	FolderID        string `json:"folderId"`
+	PermissionLevel int    `json:"permissionLevel"` // 1 read-only (default), 2 read/write
+}
+
+// CreateGuestRequest creates a guest account
+type CreateGuestRequest struct {
+	Username  string       `json:"username"`
+	Email     string       `json:"email"`
+	Password  string       `json:"password"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+	Drives    []GuestDrive `json:"drives"`
+}
+
+// ExtendGuestRequest moves a guest's expiry
+type ExtendGuestRequest struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CreateGuest creates a time-limited guest account
+// @Summary		Create guest account
+// @Description	Creates a guest that expires at expiresAt (at most guest_max_days ahead) and is a member of the given shared drives only. Guests have no home folder and cannot create link or user-to-user shares.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		CreateGuestRequest	true	"Guest account"
+// @Success		201		{object}	map[string]interface{}	"Guest created"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid request"
+// @Failure		409		{object}	docs.ErrorResponse	"Username taken"
+// @Security	BearerAuth
+// @Router		/admin/guests [post]
+func (h *AuthHandler) CreateGuest(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	var req CreateGuestRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if len(req.Username) < 3 || len(req.Username) > 50 {
+		return RespondError(c, ErrBadRequest("Username must be between 3 and 50 characters"))
+	}
+	if err := ValidatePassword(req.Password); err != nil {
+		return RespondError(c, ErrBadRequest(err.Error()))
+	}
+	if err := ValidateEmail(req.Email); err != nil {
+		return RespondError(c, ErrBadRequest(err.Error()))
+	}
+	if apiErr := guestExpiryError(req.ExpiresAt, time.Now()); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if len(req.Drives) == 0 {
+		return RespondError(c, ErrBadRequest("A guest needs at least one shared drive"))
+	}
+	for i := range req.Drives {
+		if req.Drives[i].PermissionLevel != PermissionReadWrite {
+			req.Drives[i].PermissionLevel = PermissionReadOnly
+		}
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to hash password"))
+	}
+
+	var userID string
+	drives := make([]string, 0, len(req.Drives))
+	err = WithTransaction(h.db, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", req.Username).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrAlreadyExists("Username")
+		}
+		if err := tx.QueryRow(`
+			INSERT INTO users (username, email, password_hash, is_admin, is_active, is_guest, guest_expires_at, guest_created_by)
+			VALUES ($1, $2, $3, false, true, true, $4, $5)
+			RETURNING id
+		`, req.Username, req.Email, string(passwordHash), req.ExpiresAt, claims.UserID).Scan(&userID); err != nil {
+			return err
+		}
+		for _, drive := range req.Drives {
+			var name string
+			err := tx.QueryRow(`SELECT name FROM shared_folders WHERE id = $1 AND is_active = TRUE`, drive.FolderID).Scan(&name)
+			if err == sql.ErrNoRows {
+				return ErrNotFound("Shared drive " + drive.FolderID)
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`
+				INSERT INTO shared_folder_members (shared_folder_id, user_id, permission_level, added_by)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (shared_folder_id, user_id) DO UPDATE SET permission_level = EXCLUDED.permission_level
+			`, drive.FolderID, userID, drive.PermissionLevel, claims.UserID); err != nil {
+				return err
+			}
+			drives = append(drives, name)
+		}
+		return nil
+	})
+	if apiErr, ok := err.(*APIError); ok {
+		return RespondError(c, apiErr)
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("create guest", err))
+	}
+
+	GetGuests().set(userID, guestAccount{Username: req.Username, ExpiresAt: req.ExpiresAt})
+	GetSMBShares().Sync(&claims.UserID, c.RealIP(), "guest_create")
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminGuestCreate, req.Username, map[string]interface{}{
+		"userId":    userID,
+		"expiresAt": req.ExpiresAt,
+		"drives":    drives,
+	})
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"success":   true,
+		"id":        userID,
+		"expiresAt": req.ExpiresAt,
+		"drives":    drives,
+	})
+}
+
+// loadGuest returns a guest's username and expiry, or a not-found error
+// for users that aren't guests
+func (h *AuthHandler) loadGuest(userID string) (string, time.Time, bool, *APIError) {
+	var username string
+	var expiresAt sql.NullTime
+	var archived bool
+	err := h.db.QueryRow(`
+		SELECT username, guest_expires_at, guest_archived_at IS NOT NULL FROM users WHERE id = $1 AND is_guest
+	`, userID).Scan(&username, &expiresAt, &archived)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, false, ErrNotFound("Guest")
+	}
+	if err != nil {
+		return "", time.Time{}, false, ErrInternal("Database error")
+	}
+	return username, expiresAt.Time, archived, nil
+}
+
+// ExtendGuest moves a guest's expiry
+// @Summary		Extend guest account
+// @Description	Sets a new expiry (at most guest_max_days ahead) and reactivates an expired guest. Tokens revoked at the expiry stay invalid; the guest signs in again. Archived guests can't be extended.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string				true	"User ID"
+// @Param		request	body		ExtendGuestRequest	true	"New expiry"
+// @Success		200		{object}	map[string]interface{}	"Guest extended"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid expiry"
+// @Failure		404		{object}	docs.ErrorResponse	"Not a guest"
+// @Failure		409		{object}	docs.ErrorResponse	"Guest archived"
+// @Security	BearerAuth
+// @Router		/admin/guests/{id} [put]
+func (h *AuthHandler) ExtendGuest(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	userID := c.Param("id")
+	var req ExtendGuestRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if apiErr := guestExpiryError(req.ExpiresAt, time.Now()); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	username, previous, archived, apiErr := h.loadGuest(userID)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if archived {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "The guest was archived; create a new guest or convert it to a full account"))
+	}
+
+	var revokedAt sql.NullTime
+	if err := h.db.QueryRow(`
+		UPDATE users SET guest_expires_at = $2, is_active = TRUE, updated_at = NOW()
+		WHERE id = $1 RETURNING tokens_revoked_at
+	`, userID, req.ExpiresAt).Scan(&revokedAt); err != nil {
+		return RespondError(c, ErrOperationFailed("extend guest", err))
+	}
+	GetGuests().set(userID, guestAccount{Username: username, ExpiresAt: req.ExpiresAt, TokensRevoked: revokedAt.Time})
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminGuestExtend, username, map[string]interface{}{
+		"userId":            userID,
+		"previousExpiresAt": previous,
+		"expiresAt":         req.ExpiresAt,
+	})
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"id":        userID,
+		"expiresAt": req.ExpiresAt,
+	})
+}
+
+// ConvertGuest turns a guest into a full account
+// @Summary		Convert guest to full account
+// @Description	Removes the expiry and the guest restrictions, reactivates the account and creates its home folder. Drive memberships are kept; an archived guest has none left.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"User ID"
+// @Success		200	{object}	map[string]interface{}	"Converted"
+// @Failure		404	{object}	docs.ErrorResponse	"Not a guest"
+// @Security	BearerAuth
+// @Router		/admin/guests/{id}/convert [post]
+func (h *AuthHandler) ConvertGuest(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	userID := c.Param("id")
+	username, expiresAt, archived, apiErr := h.loadGuest(userID)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	if _, err := h.db.Exec(`
+		UPDATE users SET is_guest = FALSE, guest_expires_at = NULL, guest_archived_at = NULL, is_active = TRUE, updated_at = NOW()
+		WHERE id = $1
+	`, userID); err != nil {
+		return RespondError(c, ErrOperationFailed("convert guest", err))
+	}
+	GetGuests().forget(userID)
+
+	response := map[string]interface{}{
+		"success": true,
+		"id":      userID,
+		"message": "Guest converted to a full account",
+	}
+	if err := h.ensureUserHomeDir(username); err != nil {
+		log.Printf("WARNING: Failed to create home directory for user %s: %v", username, err)
+		response["warnings"] = []string{"Home directory creation failed - will be created on first access"}
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminGuestConvert, username, map[string]interface{}{
+		"userId":    userID,
+		"expiresAt": expiresAt,
+		"archived":  archived,
+	})
+	return c.JSON(http.StatusOK, response)
+}
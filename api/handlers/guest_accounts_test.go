@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func useTestGuests(t *testing.T, g *Guests) {
+	t.Helper()
+	prev := globalGuests
+	globalGuests = g
+	t.Cleanup(func() { globalGuests = prev })
+}
+
+func TestGuestsTokenAllowed(t *testing.T) {
+	g := newGuests(nil, nil)
+	useTestGuests(t, g)
+	now := time.Now()
+	issued := func(at time.Time) *JWTClaims {
+		return &JWTClaims{UserID: "g1", RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(at)}}
+	}
+
+	// Full accounts are not affected
+	if !g.TokenAllowed(&JWTClaims{UserID: "u1"}) || g.IsGuest("u1") {
+		t.Error("full account refused")
+	}
+
+	g.set("g1", guestAccount{Username: "visitor", ExpiresAt: now.Add(time.Hour)})
+	if !g.TokenAllowed(issued(now)) || !g.IsGuestName("visitor") {
+		t.Error("valid guest token refused")
+	}
+
+	// Refused from the expiry on
+	g.set("g1", guestAccount{Username: "visitor", ExpiresAt: now.Add(-time.Second)})
+	if g.TokenAllowed(issued(now)) || !g.Expired("g1") {
+		t.Error("expired guest token allowed")
+	}
+	if status, _ := g.status("g1"); status != GuestStatusExpired {
+		t.Errorf("status = %q", status)
+	}
+
+	// After an extension, tokens issued before the revocation stay invalid
+	g.set("g1", guestAccount{Username: "visitor", ExpiresAt: now.Add(time.Hour), TokensRevoked: now.Add(-time.Minute)})
+	if g.TokenAllowed(issued(now.Add(-time.Hour))) {
+		t.Error("revoked token allowed")
+	}
+	if !g.TokenAllowed(issued(now)) {
+		t.Error("token issued after the revocation refused")
+	}
+
+	// Guests have no home folder
+	h := &Handler{dataRoot: t.TempDir()}
+	if _, _, _, err := h.resolvePath("/home/notes.txt", &JWTClaims{UserID: "g1", Username: "visitor"}); err == nil {
+		t.Error("guest resolved a home path")
+	}
+
+	g.forget("g1")
+	if g.IsGuest("g1") || g.IsGuestName("visitor") {
+		t.Error("forgotten guest still tracked")
+	}
+}
+
+func TestCreateGuestValidation(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{settingGuestMaxDays: "30"})
+	h := &AuthHandler{db: tc.DB}
+
+	create := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := NewJSONRequest(http.MethodPost, "/api/admin/guests", body)
+		c := tc.Echo.NewContext(req, rec)
+		c.Set("user", &JWTClaims{UserID: "admin", IsAdmin: true})
+		if err := h.CreateGuest(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	guest := func(expiresAt time.Time, drives ...map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"username":  "visitor",
+			"email":     "visitor@example.com",
+			"password":  "Sup3r-Secret!",
+			"expiresAt": expiresAt,
+			"drives":    drives,
+		}
+	}
+	drive := map[string]interface{}{"folderId": "f1", "permissionLevel": 2}
+
+	AssertStatus(t, create(guest(time.Now().Add(-time.Hour), drive)), http.StatusBadRequest)
+	AssertStatus(t, create(guest(time.Now().AddDate(0, 0, 31), drive)), http.StatusBadRequest)
+	AssertStatus(t, create(guest(time.Now().AddDate(0, 0, 7))), http.StatusBadRequest)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGuestsExpire(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{settingGuestArchiveDays: "30"})
+	g := newGuests(tc.DB, NewAuditHandler(tc.DB, t.TempDir()))
+	useTestGuests(t, g)
+	now := time.Now()
+	g.set("g1", guestAccount{Username: "visitor", ExpiresAt: now.Add(-time.Minute)})
+	g.set("g2", guestAccount{Username: "auditor", ExpiresAt: now.AddDate(0, 0, -40)})
+
+	// Newly expired guests are deactivated and their tokens revoked
+	tc.Mock.ExpectQuery("UPDATE users SET is_active = FALSE, tokens_revoked_at = NOW\\(\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "guest_expires_at", "tokens_revoked_at"}).
+			AddRow("g1", "visitor", now.Add(-time.Minute), now))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Guests expired long enough ago are archived
+	tc.Mock.ExpectQuery("SELECT id, username FROM users").WithArgs(30).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow("g2", "auditor"))
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("DELETE FROM shared_folder_members").WithArgs("g2").WillReturnResult(sqlmock.NewResult(0, 2))
+	tc.Mock.ExpectExec("DELETE FROM file_shares").WithArgs("g2").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec("UPDATE shares SET is_active = FALSE").WithArgs("g2").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec("UPDATE users SET guest_archived_at").WithArgs("g2").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := g.expire(&JobRun{ID: 1, Type: JobGuestsExpire, ctx: context.Background()}); err != nil {
+		t.Fatal(err)
+	}
+	if account, _ := g.lookup("g1"); account.TokensRevoked.IsZero() {
+		t.Error("revocation not recorded")
+	}
+	if status, _ := g.status("g2"); status != GuestStatusArchived {
+		t.Errorf("g2 status = %q", status)
+	}
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -42,6 +44,8 @@ type HealthResponse struct {
 	Status    string `json:"status"`
 	Timestamp string `json:"timestamp"`
 	Database  string `json:"database"`
+	// OnlyOffice is only reported when enabled via the onlyoffice_health_in_readiness setting
+	OnlyOffice string `json:"onlyoffice,omitempty"`
 }
 
 // HealthCheck handles health check requests
@@ -54,27 +58,53 @@ type HealthResponse struct {
 // @Router /health [get]
 
 func (h *Handler) HealthCheck(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.serviceHealth())
+}
+
+// serviceHealth checks the database and, when configured, the document server
+func (h *Handler) serviceHealth() HealthResponse {
 	dbStatus := "connected"
 	if err := h.db.Ping(); err != nil {
 		dbStatus = "disconnected"
 	}
 
-	return c.JSON(http.StatusOK, HealthResponse{
+	resp := HealthResponse{
 		Status:    "ok",
 		Timestamp: time.Now().Format(time.RFC3339),
 		Database:  dbStatus,
-	})
+	}
+
+	// An unavailable document server degrades the service but never fails readiness
+	if settings := GetGlobalSettingsHandler(); settings != nil && settings.IsOnlyOfficeHealthInReadiness() {
+		resp.OnlyOffice = "ok"
+		if !onlyOfficeHealth.Available() {
+			resp.OnlyOffice = "unavailable"
+			resp.Status = "degraded"
+		}
+	}
+
+	return resp
 }
 
 // FileInfo represents file metadata
 type FileInfo struct {
-	Name      string    `json:"name"`
-	Path      string    `json:"path"`
-	Size      int64     `json:"size"`
-	IsDir     bool      `json:"isDir"`
-	ModTime   time.Time `json:"modTime"`
-	Extension string    `json:"extension,omitempty"`
-	MimeType  string    `json:"mimeType,omitempty"`
+	Name             string    `json:"name"`
+	Path             string    `json:"path"`
+	Size             int64     `json:"size"`
+	IsDir            bool      `json:"isDir"`
+	ModTime          time.Time `json:"modTime"`
+	Extension        string    `json:"extension,omitempty"`
+	MimeType         string    `json:"mimeType,omitempty"`
+	Encrypted        bool      `json:"encrypted,omitempty"`        // Stored encrypted, or (inside) an encrypted folder
+	PreviewAvailable bool      `json:"previewAvailable,omitempty"` // A thumbnail/preview can be generated
+	IsLink           bool      `json:"isLink,omitempty"`           // A .fhlink file; opening it serves the target
+	LinkTarget       string    `json:"linkTarget,omitempty"`       // Link target, if it is in the viewer's home or a shared drive
+	LinkBroken       bool      `json:"linkBroken,omitempty"`       // Link target no longer exists
+	LinkInaccessible bool      `json:"linkInaccessible,omitempty"` // Viewer has no permission on the link target
+	MountOwner       string    `json:"mountOwner,omitempty"`       // A read-only mount of this user's home folder
+	Actions          []string  `json:"actions,omitempty"`          // With ?includeActions=true: supported actions, see /files/capabilities
+	DefaultAction    string    `json:"defaultAction,omitempty"`    // With ?includeActions=true: action to run on open
+	InspectionFlag   string    `json:"inspectionFlag,omitempty"`   // Flagged by content inspection: the reason
 }
 
 // ListFilesResponse represents the response for listing files
@@ -84,10 +114,18 @@ type ListFilesResponse struct {
 	Files       []FileInfo `json:"files"`
 	Total       int        `json:"total"`
 	TotalSize   int64      `json:"totalSize"`
+	// Sort applied, from the request or the folder default
+	Sort   string   `json:"sort,omitempty"`
+	Order  string   `json:"order,omitempty"`
+	Pinned []string `json:"pinned,omitempty"` // Pinned child names, listed first
+	// Set inside a mount-in: the folder is a read-only view of this user's home folder
+	MountOwner string `json:"mountOwner,omitempty"`
 	// Pagination fields
 	Page       int `json:"page,omitempty"`
 	PageSize   int `json:"pageSize,omitempty"`
 	TotalPages int `json:"totalPages,omitempty"`
+	// Set above the entry warning threshold; such folders are always paginated
+	EntryWarning *DirEntryWarning `json:"entryWarning,omitempty"`
 }
 
 // validateAndCleanPath validates a path component and returns cleaned version
@@ -186,6 +224,9 @@ func (h *Handler) resolvePath(virtualPath string, claims *JWTClaims) (realPath s
 		if claims == nil {
 			return "", "", "", fmt.Errorf("authentication required for home folder")
 		}
+		if GetGuests().IsGuest(claims.UserID) {
+			return "", "", "", fmt.Errorf("guest accounts have no home folder")
+		}
 		allowedRoot = filepath.Join(h.dataRoot, "users", claims.Username)
 		realPath = filepath.Join(allowedRoot, subPath)
 		storageType = StorageHome
@@ -198,6 +239,11 @@ func (h *Handler) resolvePath(virtualPath string, claims *JWTClaims) (realPath s
 		realPath = filepath.Join(allowedRoot, subPath)
 		storageType = StorageShared
 		displayPath = "/" + filepath.Join("shared", subPath)
+		// Inside a mount-in the data is in the owner's home folder
+		if m, rest, ok := GetMountIns().Resolve(displayPath); ok {
+			allowedRoot = GetMountIns().RealPath(m, "")
+			realPath = filepath.Join(allowedRoot, rest)
+		}
 	case "shared-with-me":
 		if claims == nil {
 			return "", "", "", fmt.Errorf("authentication required for shared files")
@@ -217,6 +263,9 @@ func (h *Handler) resolvePath(virtualPath string, claims *JWTClaims) (realPath s
 		return "", "", "", fmt.Errorf("access denied: path escapes allowed directory")
 	}
 
+	// Drives and home folders moved by a storage migration live on another volume
+	realPath = GetStorageLocations().Map(realPath)
+
 	return realPath, storageType, displayPath, nil
 }
 
@@ -271,11 +320,12 @@ func (h *Handler) ListFiles(c echo.Context) error {
 	}
 
 	sortBy := c.QueryParam("sort")
+	sortOrder := c.QueryParam("order")
+	// Folder defaults apply only when the request doesn't pick a sort
+	useFolderSort := sortBy == "" && sortOrder == ""
 	if sortBy == "" {
 		sortBy = "name"
 	}
-
-	sortOrder := c.QueryParam("order")
 	if sortOrder == "" {
 		sortOrder = "asc"
 	}
@@ -328,7 +378,15 @@ func (h *Handler) ListFiles(c echo.Context) error {
 		}
 
 		// Add home folder and shared-with-me if user is authenticated
-		if claims != nil {
+		if claims != nil && GetGuests().IsGuest(claims.UserID) {
+			// Guests have no home folder
+			roots = append([]FileInfo{{
+				Name:    "shared-with-me",
+				Path:    "/shared-with-me",
+				IsDir:   true,
+				ModTime: time.Now(),
+			}}, roots...)
+		} else if claims != nil {
 			// Ensure home dir exists
 			_ = h.EnsureUserHomeDir(claims.Username)
 			roots = append([]FileInfo{
@@ -401,6 +459,17 @@ func (h *Handler) ListFiles(c echo.Context) error {
 		})
 	}
 
+	// Polling clients revalidate: an unchanged listing is not read again
+	etag := listingETag(realPath, info, claims, c.QueryParams())
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("Cache-Control", "private, no-cache")
+	if !CheckETag(c.Request(), etag) {
+		if m, _, ok := GetMountIns().Resolve(displayPath); ok && claims != nil && claims.UserID != m.OwnerID {
+			h.auditMountInAccess(c, claims, displayPath, "mount_in.access", nil)
+		}
+		return c.NoContent(http.StatusNotModified)
+	}
+
 	entries, err := os.ReadDir(realPath)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
@@ -408,9 +477,26 @@ func (h *Handler) ListFiles(c echo.Context) error {
 		})
 	}
 
+	// Keep the entry count index current; very large folders are paginated
+	// whatever the client asked for
+	GetStatsCache().SetChildCount(realPath, int64(len(entries)), info.ModTime())
+	entryWarning := dirEntryWarningFor(displayPath, int64(len(entries)))
+	if entryWarning != nil && !usePagination {
+		page, pageSize, usePagination = 1, dirEntryPageSize, true
+		if n, err := strconv.Atoi(pageStr); err == nil && n > 1 {
+			page = n
+		}
+	}
+
 	files := make([]FileInfo, 0, len(entries))
 	var totalSize int64
 
+	// Open actions for the UI's context menus, on request
+	var caps *FileCapabilities
+	if c.QueryParam("includeActions") == "true" {
+		caps = GetFileCapabilities().Capabilities()
+	}
+
 	for _, entry := range entries {
 		// Skip hidden files (starting with .)
 		if strings.HasPrefix(entry.Name(), ".") {
@@ -422,27 +508,79 @@ func (h *Handler) ListFiles(c echo.Context) error {
 			continue
 		}
 
+		entryPath := filepath.Join(realPath, entry.Name())
+		encrypted := IsEncryptedEntry(entryPath, info)
+		size := info.Size()
+		if encrypted && !entry.IsDir() {
+			size = encryptedPlainSize(size)
+		}
+
 		ext := ""
 		mimeType := ""
 		if !entry.IsDir() {
 			ext = strings.ToLower(strings.TrimPrefix(filepath.Ext(entry.Name()), "."))
 			mimeType = getMimeType(ext)
-			totalSize += info.Size()
+			totalSize += size
 		}
 
-		files = append(files, FileInfo{
-			Name:      entry.Name(),
-			Path:      filepath.Join(displayPath, entry.Name()),
-			Size:      info.Size(),
-			IsDir:     entry.IsDir(),
-			ModTime:   info.ModTime(),
-			Extension: ext,
-			MimeType:  mimeType,
-		})
+		fileInfo := FileInfo{
+			Name:             entry.Name(),
+			Path:             filepath.Join(displayPath, entry.Name()),
+			Size:             size,
+			IsDir:            entry.IsDir(),
+			ModTime:          info.ModTime(),
+			Extension:        ext,
+			MimeType:         mimeType,
+			Encrypted:        encrypted,
+			PreviewAvailable: !entry.IsDir() && PreviewAvailable(entryPath, entry.Name(), info.ModTime()),
+		}
+		if info.Mode().IsRegular() && isFileLink(entry.Name()) {
+			h.describeFileLink(&fileInfo, entryPath, claims)
+		}
+		if caps != nil && !entry.IsDir() {
+			describeFileActions(&fileInfo, caps)
+		}
+		files = append(files, fileInfo)
+	}
+
+	// Warning badges of files flagged by content inspection
+	if flags := GetContentInspection().FlagsIn(realPath); len(flags) > 0 {
+		for i := range files {
+			if f, ok := flags[files[i].Name]; ok && !files[i].IsDir {
+				files[i].InspectionFlag = f.Reason
+			}
+		}
+	}
+
+	// Mount-ins show up as folders of their parent
+	if storageType == StorageShared {
+		for _, m := range GetMountIns().Below(displayPath, true) {
+			if entry, ok := GetMountIns().mountInEntry(m); ok {
+				files = append(files, entry)
+			}
+		}
+	}
+
+	// Folder display metadata: default sort and pinned items
+	display, err := GetFolderDisplay().Get(realPath)
+	if err != nil {
+		log.Printf("[FolderDisplay] Failed to read %s: %v", displayPath, err)
+	}
+	if display != nil && useFolderSort {
+		if display.DefaultSort != "" {
+			sortBy = display.DefaultSort
+		}
+		if display.DefaultOrder != "" {
+			sortOrder = display.DefaultOrder
+		}
 	}
 
 	// Sort files
 	sortFiles(files, sortBy, sortOrder)
+	var pinned []string
+	if display != nil {
+		pinned = floatPinned(files, display.Pinned)
+	}
 
 	// Apply pagination if requested
 	total := len(files)
@@ -451,8 +589,19 @@ func (h *Handler) ListFiles(c echo.Context) error {
 		StorageType: storageType,
 		Total:       total,
 		TotalSize:   totalSize,
+		Sort:        sortBy,
+		Order:       sortOrder,
+		Pinned:      pinned,
+	}
+	if m, _, ok := GetMountIns().Resolve(displayPath); ok {
+		response.MountOwner = m.OwnerUsername
+		if claims != nil && claims.UserID != m.OwnerID {
+			h.auditMountInAccess(c, claims, displayPath, "mount_in.access", nil)
+		}
 	}
 
+	response.EntryWarning = entryWarning
+
 	if usePagination {
 		totalPages := (total + pageSize - 1) / pageSize
 		start := (page - 1) * pageSize
@@ -532,10 +681,10 @@ func (h *Handler) CheckSharedDrivePermission(userID, path string, requiredLevel
 	var permissionLevel int
 	var folderID string
 	err := h.db.QueryRow(`
-		SELECT sfm.permission_level, sf.id
-		FROM shared_folder_members sfm
-		INNER JOIN shared_folders sf ON sf.id = sfm.shared_folder_id
-		WHERE sf.name = $1 AND sfm.user_id = $2 AND sf.is_active = TRUE
+		SELECT sfa.permission_level, sf.id
+		FROM shared_folder_access sfa
+		INNER JOIN shared_folders sf ON sf.id = sfa.shared_folder_id
+		WHERE sf.name = $1 AND sfa.user_id = $2 AND sf.is_active = TRUE
 	`, folderName, userID).Scan(&permissionLevel, &folderID)
 
 	if err != nil {
@@ -563,74 +712,66 @@ func (h *Handler) CanReadSharedDrive(userID, path string) bool {
 
 // CanWriteSharedDrive checks if user can write to a shared drive path
 func (h *Handler) CanWriteSharedDrive(userID, path string) bool {
+	// Mount-ins are read-only for everyone
+	if _, _, ok := GetMountIns().Resolve(path); ok {
+		return false
+	}
 	return h.CheckSharedDrivePermission(userID, path, 2) // 2 = read-write
 }
 
-// CheckSharedDriveQuota checks if upload would exceed storage quota
-func (h *Handler) CheckSharedDriveQuota(path string, uploadSize int64) (allowed bool, quota int64, used int64) {
+// CheckSharedDriveQuota checks if upload would exceed storage quota. Usage
+// comes from the size index or the folder's storage_used, not a walk.
+func (h *Handler) CheckSharedDriveQuota(ctx context.Context, path string, uploadSize int64) (allowed bool, quota int64, used int64, err error) {
 	folderName := ExtractSharedDriveFolderName(path)
 	if folderName == "" {
-		return false, 0, 0
+		return false, 0, 0, nil
 	}
 
-	// Get quota
-	err := h.db.QueryRow(`
-		SELECT storage_quota FROM shared_folders WHERE name = $1 AND is_active = TRUE
-	`, folderName).Scan(&quota)
+	q, err := lookupSharedFolderQuota(ctx, h.db, h.dataRoot, folderName)
 	if err != nil {
-		return false, 0, 0
-	}
-
-	// 0 = unlimited
-	if quota == 0 {
-		return true, 0, 0
+		return false, 0, 0, nil
 	}
+	return q.Allows(uploadSize), q.Quota, q.Used, nil
+}
 
-	// Calculate current usage
-	folderPath := filepath.Join(h.dataRoot, "shared", folderName)
-	_ = filepath.Walk(folderPath, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if !info.IsDir() {
-			used += info.Size()
-		}
-		return nil
-	})
-
-	return (used + uploadSize) <= quota, quota, used
+// mimeTypes maps lowercase extensions (without dot) to the MIME type served
+var mimeTypes = map[string]string{
+	// Images
+	"jpg": "image/jpeg", "jpeg": "image/jpeg", "png": "image/png",
+	"gif": "image/gif", "webp": "image/webp", "svg": "image/svg+xml",
+	"ico": "image/x-icon", "bmp": "image/bmp",
+	"heic": "image/heic", "heif": "image/heif", "psd": "image/vnd.adobe.photoshop",
+	// Camera RAW
+	"cr2": "image/x-canon-cr2", "nef": "image/x-nikon-nef",
+	"arw": "image/x-sony-arw", "dng": "image/x-adobe-dng",
+	// Videos
+	"mp4": "video/mp4", "webm": "video/webm", "avi": "video/x-msvideo",
+	"mov": "video/quicktime", "mkv": "video/x-matroska",
+	// Audio
+	"mp3": "audio/mpeg", "wav": "audio/wav", "ogg": "audio/ogg",
+	"flac": "audio/flac", "m4a": "audio/mp4",
+	// Documents
+	"pdf": "application/pdf", "doc": "application/msword",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"xls": "application/vnd.ms-excel",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"ppt": "application/vnd.ms-powerpoint",
+	"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	// Mail, calendar and contacts
+	"eml": "message/rfc822", "msg": "application/vnd.ms-outlook",
+	"ics": "text/calendar", "vcf": "text/vcard",
+	// Text
+	"txt": "text/plain", "md": "text/markdown", "json": "application/json",
+	"xml": "application/xml", "html": "text/html", "css": "text/css",
+	"js": "application/javascript", "ts": "application/typescript",
+	// Archives
+	"zip": "application/zip", "rar": "application/x-rar-compressed",
+	"7z": "application/x-7z-compressed", "tar": "application/x-tar",
+	"gz": "application/gzip",
 }
 
 // getMimeType returns the MIME type for a file extension
 func getMimeType(ext string) string {
-	mimeTypes := map[string]string{
-		// Images
-		"jpg": "image/jpeg", "jpeg": "image/jpeg", "png": "image/png",
-		"gif": "image/gif", "webp": "image/webp", "svg": "image/svg+xml",
-		"ico": "image/x-icon", "bmp": "image/bmp",
-		// Videos
-		"mp4": "video/mp4", "webm": "video/webm", "avi": "video/x-msvideo",
-		"mov": "video/quicktime", "mkv": "video/x-matroska",
-		// Audio
-		"mp3": "audio/mpeg", "wav": "audio/wav", "ogg": "audio/ogg",
-		"flac": "audio/flac", "m4a": "audio/mp4",
-		// Documents
-		"pdf": "application/pdf", "doc": "application/msword",
-		"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
-		"xls": "application/vnd.ms-excel",
-		"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
-		"ppt": "application/vnd.ms-powerpoint",
-		"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
-		// Text
-		"txt": "text/plain", "md": "text/markdown", "json": "application/json",
-		"xml": "application/xml", "html": "text/html", "css": "text/css",
-		"js": "application/javascript", "ts": "application/typescript",
-		// Archives
-		"zip": "application/zip", "rar": "application/x-rar-compressed",
-		"7z": "application/x-7z-compressed", "tar": "application/x-tar",
-		"gz": "application/gzip",
-	}
-
 	if mime, ok := mimeTypes[ext]; ok {
 		return mime
 	}
@@ -654,7 +795,7 @@ func (h *Handler) listSharedWithMe(c echo.Context, claims *JWTClaims) error {
 			fs.permission_level, fs.created_at, u.username
 		FROM file_shares fs
 		INNER JOIN users u ON u.id = fs.owner_id
-		WHERE fs.shared_with_id = $1
+		WHERE fs.shared_with_id = $1 AND `+fileShareActiveSQL+`
 		ORDER BY fs.created_at DESC
 	`, claims.UserID)
 	if err != nil {
@@ -749,8 +890,8 @@ func (h *Handler) CheckFileSharePermission(userID, virtualPath string, requiredL
 	// Check exact path match
 	var permissionLevel int
 	err := h.db.QueryRow(`
-		SELECT permission_level FROM file_shares
-		WHERE shared_with_id = $1 AND item_path = $2
+		SELECT permission_level FROM file_shares fs
+		WHERE shared_with_id = $1 AND item_path = $2 AND `+fileShareActiveSQL+`
 	`, userID, virtualPath).Scan(&permissionLevel)
 	if err == nil && permissionLevel >= requiredLevel {
 		return true
@@ -758,8 +899,8 @@ func (h *Handler) CheckFileSharePermission(userID, virtualPath string, requiredL
 
 	// Check if this is a subpath of a shared folder
 	rows, err := h.db.Query(`
-		SELECT item_path, permission_level FROM file_shares
-		WHERE shared_with_id = $1 AND is_folder = TRUE
+		SELECT item_path, permission_level FROM file_shares fs
+		WHERE shared_with_id = $1 AND is_folder = TRUE AND `+fileShareActiveSQL+`
 	`, userID)
 	if err != nil {
 		return false
@@ -799,7 +940,7 @@ func (h *Handler) GetSharedFileOwnerPath(userID, virtualPath string) (realPath s
 		SELECT fs.item_path, u.username
 		FROM file_shares fs
 		INNER JOIN users u ON u.id = fs.owner_id
-		WHERE fs.shared_with_id = $1 AND fs.item_path = $2
+		WHERE fs.shared_with_id = $1 AND fs.item_path = $2 AND `+fileShareActiveSQL+`
 	`, userID, virtualPath).Scan(&itemPath, &ownerUsername)
 
 	if err == nil {
@@ -811,7 +952,7 @@ func (h *Handler) GetSharedFileOwnerPath(userID, virtualPath string) (realPath s
 			if len(parts) > 1 {
 				subPath = filepath.Join(parts[1:]...)
 			}
-			realPath = filepath.Join(h.dataRoot, "users", ownerUsername, subPath)
+			realPath = GetStorageLocations().Map(filepath.Join(h.dataRoot, "users", ownerUsername, subPath))
 			return realPath, ownerUsername, nil
 		}
 	}
@@ -821,7 +962,7 @@ func (h *Handler) GetSharedFileOwnerPath(userID, virtualPath string) (realPath s
 		SELECT fs.item_path, u.username, fs.is_folder
 		FROM file_shares fs
 		INNER JOIN users u ON u.id = fs.owner_id
-		WHERE fs.shared_with_id = $1 AND fs.is_folder = TRUE
+		WHERE fs.shared_with_id = $1 AND fs.is_folder = TRUE AND `+fileShareActiveSQL+`
 	`, userID)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to check shared folders")
@@ -844,7 +985,7 @@ func (h *Handler) GetSharedFileOwnerPath(userID, virtualPath string) (realPath s
 				if len(parts) > 1 {
 					subPath = filepath.Join(parts[1:]...)
 				}
-				realPath = filepath.Join(h.dataRoot, "users", username, subPath)
+				realPath = GetStorageLocations().Map(filepath.Join(h.dataRoot, "users", username, subPath))
 				return realPath, username, nil
 			}
 		}
@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Image formats browsers cannot display, previewed by converting them first
+	convertedImageExts = map[string]bool{
+		".heic": true, ".heif": true, ".psd": true,
+		".cr2": true, ".nef": true, ".arw": true, ".dng": true,
+	}
+
+	// convertedPreviewSize bounds the JPEG served by GetPreview for converted formats
+	convertedPreviewSize = ThumbnailSize{Width: 1920, Height: 1920, Name: "preview"}
+
+	errPreviewUnavailable = errors.New("no preview available for this file")
+)
+
+const (
+	// maxConvertPixels guards against decoding huge canvases into memory
+	maxConvertPixels = 100 * 1000 * 1000
+	// maxRawPreviewBytes bounds how much of a RAW file is scanned for previews
+	maxRawPreviewBytes = 256 << 20
+	// maxPreviewFailures bounds the failed-conversion memo
+	maxPreviewFailures = 10000
+)
+
+// previewFailures remembers files whose conversion failed, keyed by real path
+// with the mtime that failed, so listings stop offering a preview for them
+var previewFailures = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+func notePreviewFailure(realPath string, modTime time.Time) {
+	previewFailures.Lock()
+	defer previewFailures.Unlock()
+	if len(previewFailures.m) >= maxPreviewFailures {
+		previewFailures.m = make(map[string]time.Time)
+	}
+	previewFailures.m[realPath] = modTime
+}
+
+func previewFailed(realPath string, modTime time.Time) bool {
+	previewFailures.Lock()
+	defer previewFailures.Unlock()
+	failed, ok := previewFailures.m[realPath]
+	return ok && failed.Equal(modTime)
+}
+
+// isConvertedImage reports whether ext (with dot, lowercase) needs conversion
+func isConvertedImage(ext string) bool {
+	return convertedImageExts[ext]
+}
+
+// PreviewAvailable reports whether a thumbnail/preview can be offered for a
+// file, so clients can show the icon right away for everything else
+func PreviewAvailable(realPath, name string, modTime time.Time) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if supportedImageExts[ext] || supportedVideoExts[ext] {
+		return true
+	}
+	if !isConvertedImage(ext) {
+		return false
+	}
+	if (ext == ".heic" || ext == ".heif") && heicConverter() == "" {
+		return false
+	}
+	return !previewFailed(realPath, modTime)
+}
+
+// decodeImageFile decodes an image, converting formats Go cannot read natively
+func decodeImageFile(filePath string) (image.Image, error) {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if isConvertedImage(ext) {
+		return decodeConvertedImage(filePath, ext)
+	}
+
+	file, err := OpenPlain(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// decodeConvertedImage extracts a displayable image from HEIC, PSD or RAW
+// files. Failures are remembered so the file is listed without a preview.
+func decodeConvertedImage(filePath, ext string) (image.Image, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access file: %w", err)
+	}
+
+	var img image.Image
+	switch ext {
+	case ".heic", ".heif":
+		img, err = convertHEIC(filePath)
+	case ".psd":
+		img, err = decodePSDFile(filePath)
+	default:
+		img, err = extractRawPreview(filePath)
+	}
+	if err != nil {
+		notePreviewFailure(filePath, info.ModTime())
+		return nil, fmt.Errorf("failed to convert %s: %w", ext, err)
+	}
+	return img, nil
+}
+
+// heicConverter returns the external HEIC converter: HEIC_CONVERTER if set,
+// otherwise heif-convert or ffmpeg when installed
+func heicConverter() string {
+	if cmd := os.Getenv("HEIC_CONVERTER"); cmd != "" {
+		if p, err := exec.LookPath(cmd); err == nil {
+			return p
+		}
+		return ""
+	}
+	for _, name := range []string{"heif-convert", "ffmpeg"} {
+		if p, err := exec.LookPath(name); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// convertHEIC runs the external converter to JPEG and decodes the result
+func convertHEIC(filePath string) (image.Image, error) {
+	converter := heicConverter()
+	if converter == "" {
+		return nil, errPreviewUnavailable
+	}
+
+	// Encrypted files are handed to the converter as a plaintext temp copy
+	input := filePath
+	if GetFileEncryption().Active() && IsEncryptedFile(filePath) {
+		tmp, err := copyPlainToTemp(filePath, "heic_*"+filepath.Ext(filePath))
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp)
+		input = tmp
+	}
+
+	outFile, err := os.CreateTemp("", "heic_*.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	outPath := outFile.Name()
+	outFile.Close()
+	defer os.Remove(outPath)
+
+	var cmd *exec.Cmd
+	if strings.HasPrefix(filepath.Base(converter), "ffmpeg") {
+		cmd = exec.Command(converter, "-i", input, "-frames:v", "1", "-q:v", "2", "-y", outPath)
+	} else {
+		// heif-convert and ImageMagick take "<input> <output>"
+		cmd = exec.Command(converter, input, outPath)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("[Thumbnail] HEIC conversion failed for %s: %v, stderr: %s\n", filePath, err, stderr.String())
+		return nil, fmt.Errorf("converter failed: %w", err)
+	}
+
+	out, err := os.Open(outPath)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	return jpeg.Decode(out)
+}
+
+// copyPlainToTemp writes the plaintext of a (possibly encrypted) file to a temp file
+func copyPlainToTemp(filePath, pattern string) (string, error) {
+	src, err := OpenPlain(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// extractRawPreview returns the largest decodable JPEG embedded in a RAW file.
+// Camera RAW formats (CR2, NEF, ARW, DNG) carry a full-size or large preview
+// JPEG next to the sensor data.
+func extractRawPreview(filePath string) (image.Image, error) {
+	file, err := OpenPlain(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxRawPreviewBytes))
+	if err != nil {
+		return nil, err
+	}
+	return largestEmbeddedJPEG(data)
+}
+
+// largestEmbeddedJPEG scans data for JPEG streams and decodes the largest one.
+// Lossless JPEG sensor data is rejected by DecodeConfig and skipped.
+func largestEmbeddedJPEG(data []byte) (image.Image, error) {
+	type candidate struct {
+		offset int
+		pixels int
+	}
+	var candidates []candidate
+	soi := []byte{0xFF, 0xD8, 0xFF}
+	for i := 0; ; {
+		idx := bytes.Index(data[i:], soi)
+		if idx == -1 {
+			break
+		}
+		offset := i + idx
+		if cfg, err := jpeg.DecodeConfig(bytes.NewReader(data[offset:])); err == nil {
+			pixels := cfg.Width * cfg.Height
+			if pixels > 0 && pixels <= maxConvertPixels {
+				candidates = append(candidates, candidate{offset: offset, pixels: pixels})
+			}
+		}
+		i = offset + len(soi)
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool { return candidates[a].pixels > candidates[b].pixels })
+	for _, cand := range candidates {
+		if img, err := jpeg.Decode(bytes.NewReader(data[cand.offset:])); err == nil {
+			return img, nil
+		}
+	}
+	return nil, errPreviewUnavailable
+}
+
+// PSD color modes supported for the composite image
+const (
+	psdModeGrayscale = 1
+	psdModeRGB       = 3
+	psdModeCMYK      = 4
+)
+
+// decodePSDFile reads the flattened composite image stored at the end of a PSD
+func decodePSDFile(filePath string) (image.Image, error) {
+	file, err := OpenPlain(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return decodePSD(file)
+}
+
+// decodePSD decodes the composite image of an 8 or 16-bit Grayscale, RGB or
+// CMYK PSD (version 1). Extra channels such as alpha are ignored.
+func decodePSD(r io.ReadSeeker) (image.Image, error) {
+	var header struct {
+		Signature [4]byte
+		Version   uint16
+		Reserved  [6]byte
+		Channels  uint16
+		Height    uint32
+		Width     uint32
+		Depth     uint16
+		ColorMode uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("read psd header: %w", err)
+	}
+	if string(header.Signature[:]) != "8BPS" || header.Version != 1 {
+		return nil, errors.New("not a PSD file")
+	}
+	if header.Depth != 8 && header.Depth != 16 {
+		return nil, fmt.Errorf("unsupported psd depth %d", header.Depth)
+	}
+
+	var colorChannels int
+	switch header.ColorMode {
+	case psdModeGrayscale:
+		colorChannels = 1
+	case psdModeRGB:
+		colorChannels = 3
+	case psdModeCMYK:
+		colorChannels = 4
+	default:
+		return nil, fmt.Errorf("unsupported psd color mode %d", header.ColorMode)
+	}
+	if int(header.Channels) < colorChannels {
+		return nil, errors.New("psd has too few channels")
+	}
+
+	width, height := int(header.Width), int(header.Height)
+	if width <= 0 || height <= 0 || width*height > maxConvertPixels {
+		return nil, fmt.Errorf("unsupported psd dimensions %dx%d", width, height)
+	}
+
+	// Skip color mode data, image resources and layer/mask sections
+	for i := 0; i < 3; i++ {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("read psd section: %w", err)
+		}
+		if _, err := r.Seek(int64(length), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+
+	var compression uint16
+	if err := binary.Read(r, binary.BigEndian, &compression); err != nil {
+		return nil, fmt.Errorf("read psd image data: %w", err)
+	}
+
+	bytesPerSample := int(header.Depth) / 8
+	rowBytes := width * bytesPerSample
+	br := bufio.NewReader(r)
+
+	switch compression {
+	case 0:
+	case 1:
+		// Row byte counts for every channel precede the data; rows are
+		// decoded until full, so the counts are not needed
+		if _, err := br.Discard(int(header.Channels) * height * 2); err != nil {
+			return nil, fmt.Errorf("read psd row counts: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported psd compression %d", compression)
+	}
+
+	planes := make([][]byte, colorChannels)
+	row := make([]byte, rowBytes)
+	for ch := 0; ch < colorChannels; ch++ {
+		plane := make([]byte, width*height)
+		for y := 0; y < height; y++ {
+			var err error
+			if compression == 0 {
+				_, err = io.ReadFull(br, row)
+			} else {
+				err = unpackBits(br, row)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read psd channel %d: %w", ch, err)
+			}
+			// 16-bit samples are big-endian; the high byte is enough for a preview
+			for x := 0; x < width; x++ {
+				plane[y*width+x] = row[x*bytesPerSample]
+			}
+		}
+		planes[ch] = plane
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	switch header.ColorMode {
+	case psdModeGrayscale:
+		return &image.Gray{Pix: planes[0], Stride: width, Rect: rect}, nil
+	case psdModeRGB:
+		img := image.NewNRGBA(rect)
+		for i := 0; i < width*height; i++ {
+			img.Pix[i*4] = planes[0][i]
+			img.Pix[i*4+1] = planes[1][i]
+			img.Pix[i*4+2] = planes[2][i]
+			img.Pix[i*4+3] = 0xFF
+		}
+		return img, nil
+	default:
+		// PSD stores CMYK inverted (0 = full ink)
+		img := image.NewCMYK(rect)
+		for i := 0; i < width*height; i++ {
+			img.Pix[i*4] = 0xFF - planes[0][i]
+			img.Pix[i*4+1] = 0xFF - planes[1][i]
+			img.Pix[i*4+2] = 0xFF - planes[2][i]
+			img.Pix[i*4+3] = 0xFF - planes[3][i]
+		}
+		return img, nil
+	}
+}
+
+// unpackBits decodes one PackBits-compressed row into dst
+func unpackBits(r *bufio.Reader, dst []byte) error {
+	for n := 0; n < len(dst); {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		count := int(int8(b))
+		switch {
+		case count >= 0:
+			if n+count+1 > len(dst) {
+				return errors.New("packbits overflow")
+			}
+			if _, err := io.ReadFull(r, dst[n:n+count+1]); err != nil {
+				return err
+			}
+			n += count + 1
+		case count > -128:
+			v, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if n+1-count > len(dst) {
+				return errors.New("packbits overflow")
+			}
+			for i := 0; i < 1-count; i++ {
+				dst[n+i] = v
+			}
+			n += 1 - count
+		}
+	}
+	return nil
+}
+
+// convertedPreview returns a display-size JPEG of a converted format, cached
+// in the preview cache like thumbnails
+func convertedPreview(realPath string, info os.FileInfo) ([]byte, bool) {
+	cache := GetPreviewCache()
+	suffix := "preview:jpeg"
+	if cache != nil {
+		if data, ok := cache.Get(realPath, info.ModTime(), suffix); ok {
+			return data, true
+		}
+	}
+
+	data, err := generateImageThumbnail(realPath, convertedPreviewSize)
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	if cache != nil {
+		_ = cache.Set(realPath, info.ModTime(), suffix, data)
+	}
+	return data, true
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// buildPSD writes a minimal 8-bit RGB PSD whose composite is a solid color
+func buildPSD(t *testing.T, width, height int, rgb [3]byte, rle bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("8BPS")
+	binary.Write(&buf, binary.BigEndian, uint16(1))
+	buf.Write(make([]byte, 6))
+	binary.Write(&buf, binary.BigEndian, uint16(3))
+	binary.Write(&buf, binary.BigEndian, uint32(height))
+	binary.Write(&buf, binary.BigEndian, uint32(width))
+	binary.Write(&buf, binary.BigEndian, uint16(8))
+	binary.Write(&buf, binary.BigEndian, uint16(psdModeRGB))
+	// Color mode data, image resources (with junk), layer and mask info
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	binary.Write(&buf, binary.BigEndian, uint32(4))
+	buf.WriteString("junk")
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+
+	if !rle {
+		binary.Write(&buf, binary.BigEndian, uint16(0))
+		for ch := 0; ch < 3; ch++ {
+			buf.Write(bytes.Repeat([]byte{rgb[ch]}, width*height))
+		}
+		return buf.Bytes()
+	}
+
+	binary.Write(&buf, binary.BigEndian, uint16(1))
+	for i := 0; i < 3*height; i++ {
+		binary.Write(&buf, binary.BigEndian, uint16(2))
+	}
+	for ch := 0; ch < 3; ch++ {
+		for y := 0; y < height; y++ {
+			// Repeat run: -(width-1) followed by the value
+			buf.WriteByte(byte(int8(1 - width)))
+			buf.WriteByte(rgb[ch])
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestDecodePSD(t *testing.T) {
+	for _, rle := range []bool{false, true} {
+		data := buildPSD(t, 4, 3, [3]byte{200, 100, 50}, rle)
+		img, err := decodePSD(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("rle=%v: %v", rle, err)
+		}
+		if b := img.Bounds(); b.Dx() != 4 || b.Dy() != 3 {
+			t.Errorf("rle=%v: bounds = %v", rle, b)
+		}
+		got := color.NRGBAModel.Convert(img.At(3, 2)).(color.NRGBA)
+		if got.R != 200 || got.G != 100 || got.B != 50 {
+			t.Errorf("rle=%v: pixel = %+v", rle, got)
+		}
+	}
+
+	if _, err := decodePSD(bytes.NewReader([]byte("not a psd at all, really not"))); err == nil {
+		t.Error("expected error for invalid PSD")
+	}
+}
+
+func TestLargestEmbeddedJPEG(t *testing.T) {
+	encode := func(w, h int) []byte {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	// Fake RAW: header, small thumbnail, sensor junk with a stray SOI, large preview
+	var raw bytes.Buffer
+	raw.WriteString("II*\x00fake raw header")
+	raw.Write(encode(16, 8))
+	raw.Write([]byte{0x00, 0xFF, 0xD8, 0xFF, 0x12, 0x34})
+	raw.Write(encode(64, 32))
+	raw.Write(bytes.Repeat([]byte{0xAB}, 128))
+
+	img, err := largestEmbeddedJPEG(raw.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Errorf("picked %v, want the 64x32 preview", b)
+	}
+
+	if _, err := largestEmbeddedJPEG([]byte("no jpeg here")); err == nil {
+		t.Error("expected error without embedded JPEG")
+	}
+}
+
+func TestConvertedThumbnailAndPreviewAvailable(t *testing.T) {
+	dir := t.TempDir()
+	psdPath := filepath.Join(dir, "design.psd")
+	if err := os.WriteFile(psdPath, buildPSD(t, 40, 20, [3]byte{1, 2, 3}, true), 0644); err != nil {
+		t.Fatal(err)
+	}
+	brokenPath := filepath.Join(dir, "broken.nef")
+	if err := os.WriteFile(brokenPath, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := generateImageThumbnail(psdPath, ThumbnailSizes["small"])
+	if err != nil {
+		t.Fatalf("psd thumbnail: %v", err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("thumbnail is not a JPEG: %v", err)
+	}
+
+	info, _ := os.Stat(brokenPath)
+	if !PreviewAvailable(brokenPath, "broken.nef", info.ModTime()) {
+		t.Error("RAW preview should be offered before a failed conversion")
+	}
+	if _, err := generateImageThumbnail(brokenPath, ThumbnailSizes["small"]); err == nil {
+		t.Fatal("expected corrupt RAW to fail")
+	}
+	if PreviewAvailable(brokenPath, "broken.nef", info.ModTime()) {
+		t.Error("failed conversion should disable the preview")
+	}
+	if !PreviewAvailable(brokenPath, "broken.nef", info.ModTime().Add(time.Second)) {
+		t.Error("a modified file should be retried")
+	}
+	if PreviewAvailable(filepath.Join(dir, "notes.txt"), "notes.txt", time.Now()) {
+		t.Error("text files have no thumbnail")
+	}
+}
+
+func TestGetMimeTypeConvertedFormats(t *testing.T) {
+	for ext, want := range map[string]string{
+		"heic": "image/heic", "heif": "image/heif", "psd": "image/vnd.adobe.photoshop",
+		"cr2": "image/x-canon-cr2", "nef": "image/x-nikon-nef", "arw": "image/x-sony-arw", "dng": "image/x-adobe-dng",
+	} {
+		if got := getMimeType(ext); got != want {
+			t.Errorf("getMimeType(%q) = %q, want %q", ext, got, want)
+		}
+	}
+}
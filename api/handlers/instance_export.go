@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// exportInventories caches the file paths of finished exports, so serving
+// files to an importing instance does not re-read the manifest per request
+var (
+	exportInventoriesMu sync.Mutex
+	exportInventories   = make(map[string]map[string]bool)
+)
+
+// StartExport starts an export of this instance
+// @Summary		Export this instance
+// @Description	Starts a background job that writes a manifest of users (without passwords), shared drives and members, link shares, user-to-user shares, file metadata and a checksummed inventory of the files in home folders and shared drives. Import it on another instance with POST /admin/import.
+// @Tags		Admin
+// @Produce		json
+// @Success		202		{object}	docs.SuccessResponse	"Export started"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		409		{object}	docs.ErrorResponse	"An export is already running"
+// @Security	BearerAuth
+// @Router		/admin/export [post]
+func (h *InstanceTransferHandler) StartExport(c echo.Context) error {
+	claims, err := RequireAdmin(c)
+	if claims == nil {
+		return err // Error response already written
+	}
+	if run := runningTransfer(TransferExport); run != nil {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "An export is already running").WithDetails(run.snapshot()))
+	}
+
+	job := InstanceTransferJob{
+		ID:        newActivityID(ActivityExport),
+		Kind:      TransferExport,
+		Status:    AdoptRunning,
+		StartedAt: time.Now(),
+	}
+	job.ExportID = job.ID
+	if err := h.insertTransfer(job, "", claims.UserID); err != nil {
+		return RespondError(c, ErrInternal("Failed to create export"))
+	}
+
+	run := h.startTransfer(job, "", claims, c.RealIP())
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data":    run.snapshot(),
+	})
+}
+
+// runExport collects the entities and file inventory and writes the manifest
+func (h *InstanceTransferHandler) runExport(run *transferRun, activity *Activity) error {
+	// A resumed export starts over
+	run.update(func(j *InstanceTransferJob) {
+		j.FilesTotal, j.FilesDone, j.FilesFailed, j.BytesTotal, j.BytesDone, j.FileErrors = 0, 0, 0, 0, 0, nil
+	})
+	activity.SetBytes(0)
+	job := run.snapshot()
+	manifest, err := h.exportEntities()
+	if err != nil {
+		return err
+	}
+	manifest.ExportID = job.ExportID
+	manifest.CreatedAt = time.Now()
+
+	roots := make([]string, 0, len(manifest.Users)+len(manifest.SharedFolders))
+	for _, u := range manifest.Users {
+		roots = append(roots, "users/"+u.Username)
+	}
+	for _, f := range manifest.SharedFolders {
+		roots = append(roots, "shared/"+sanitizeFolderName(f.Name))
+	}
+	for _, root := range roots {
+		files, err := h.exportInventory(root, activity, run)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, files...)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := h.transferDir(job.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "manifest.json.tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, "manifest.json"))
+}
+
+// exportEntities reads the users, drives, shares and metadata to export
+func (h *InstanceTransferHandler) exportEntities() (*InstanceManifest, error) {
+	m := &InstanceManifest{
+		Version:       instanceManifestVersion,
+		Users:         []ExportUser{},
+		SharedFolders: []ExportSharedFolder{},
+		Shares:        []ExportShare{},
+		FileShares:    []ExportFileShare{},
+		FileMetadata:  []ExportFileMetadata{},
+		Files:         []ExportFile{},
+	}
+
+	rows, err := h.db.Query(`
+		SELECT username, COALESCE(email, ''), COALESCE(provider, 'local'), COALESCE(provider_id, ''),
+		       is_admin, is_active, COALESCE(storage_quota, 0)
+		FROM users ORDER BY username
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("read users: %w", err)
+	}
+	for rows.Next() {
+		var u ExportUser
+		if err := rows.Scan(&u.Username, &u.Email, &u.Provider, &u.ProviderID, &u.IsAdmin, &u.IsActive, &u.StorageQuota); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("read users: %w", err)
+		}
+		// Passwords stay on this instance; local accounts get a new one
+		u.ResetPassword = u.Provider == "local"
+		m.Users = append(m.Users, u)
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(`
+		SELECT name, COALESCE(description, ''), COALESCE(storage_quota, 0), COALESCE(is_active, true)
+		FROM shared_folders ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("read shared drives: %w", err)
+	}
+	folders := make(map[string]int)
+	for rows.Next() {
+		f := ExportSharedFolder{Members: []ProvisionMember{}}
+		if err := rows.Scan(&f.Name, &f.Description, &f.StorageQuota, &f.IsActive); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("read shared drives: %w", err)
+		}
+		folders[f.Name] = len(m.SharedFolders)
+		m.SharedFolders = append(m.SharedFolders, f)
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(`
+		SELECT sf.name, u.username, sfm.permission_level
+		FROM shared_folder_members sfm
+		INNER JOIN shared_folders sf ON sfm.shared_folder_id = sf.id
+		INNER JOIN users u ON sfm.user_id = u.id
+		ORDER BY sf.name, u.username
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("read members: %w", err)
+	}
+	for rows.Next() {
+		var folder string
+		var member ProvisionMember
+		if err := rows.Scan(&folder, &member.Username, &member.PermissionLevel); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("read members: %w", err)
+		}
+		if i, ok := folders[folder]; ok {
+			m.SharedFolders[i].Members = append(m.SharedFolders[i].Members, member)
+		}
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(`
+		SELECT s.token, s.path, COALESCE(u.username, ''), s.expires_at, COALESCE(s.password_hash, ''),
+		       s.max_access, COALESCE(s.is_active, true), COALESCE(s.require_login, false), s.share_type,
+		       COALESCE(s.editable, false), COALESCE(s.max_file_size, 0), COALESCE(s.allowed_extensions, ''),
+		       COALESCE(s.max_total_size, 0)
+		FROM shares s
+		LEFT JOIN users u ON s.created_by = u.id
+		ORDER BY s.created_at
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("read link shares: %w", err)
+	}
+	for rows.Next() {
+		var s ExportShare
+		if err := rows.Scan(&s.Token, &s.Path, &s.CreatedBy, &s.ExpiresAt, &s.PasswordHash,
+			&s.MaxAccess, &s.IsActive, &s.RequireLogin, &s.ShareType,
+			&s.Editable, &s.MaxFileSize, &s.AllowedExtensions, &s.MaxTotalSize); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("read link shares: %w", err)
+		}
+		m.Shares = append(m.Shares, s)
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(`
+		SELECT fs.item_path, fs.item_name, fs.is_folder, o.username, w.username,
+		       fs.permission_level, COALESCE(fs.message, ''), fs.status, fs.expires_at
+		FROM file_shares fs
+		INNER JOIN users o ON fs.owner_id = o.id
+		INNER JOIN users w ON fs.shared_with_id = w.id
+		ORDER BY fs.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("read file shares: %w", err)
+	}
+	for rows.Next() {
+		var s ExportFileShare
+		if err := rows.Scan(&s.ItemPath, &s.ItemName, &s.IsFolder, &s.Owner, &s.SharedWith,
+			&s.PermissionLevel, &s.Message, &s.Status, &s.ExpiresAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("read file shares: %w", err)
+		}
+		m.FileShares = append(m.FileShares, s)
+	}
+	rows.Close()
+
+	rows, err = h.db.Query(`
+		SELECT u.username, fm.file_path, COALESCE(fm.description, ''), COALESCE(fm.tags, '[]'::jsonb)
+		FROM file_metadata fm
+		INNER JOIN users u ON fm.user_id = u.id
+		ORDER BY fm.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("read file metadata: %w", err)
+	}
+	for rows.Next() {
+		var md ExportFileMetadata
+		var tags []byte
+		if err := rows.Scan(&md.Username, &md.FilePath, &md.Description, &tags); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("read file metadata: %w", err)
+		}
+		md.Tags = json.RawMessage(tags)
+		m.FileMetadata = append(m.FileMetadata, md)
+	}
+	rows.Close()
+
+	return m, nil
+}
+
+// exportInventory lists and checksums the files below a home folder or
+// shared drive. Content is read through OpenPlain, so files in encrypted
+// folders are listed with the size and checksum of their plaintext.
+func (h *InstanceTransferHandler) exportInventory(root string, activity *Activity, run *transferRun) ([]ExportFile, error) {
+	base := GetStorageLocations().Map(filepath.Join(h.dataRoot, filepath.FromSlash(root)))
+	files := []ExportFile{}
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == base && os.IsNotExist(err) {
+				return nil // Home folder never created
+			}
+			run.fileError("%s: %v", root, err)
+			return nil
+		}
+		if err := activity.Pace(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != base && (d.Name() == ".trash" || d.Name() == ".uploads") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || d.Name() == EncryptedMarkerFile {
+			return nil
+		}
+		rel, _ := filepath.Rel(base, p)
+		name := path.Join(root, filepath.ToSlash(rel))
+
+		file, err := exportFile(p, name)
+		if err != nil {
+			run.fileError("%s: %v", name, err)
+			return nil
+		}
+		files = append(files, file)
+		activity.AddBytes(file.Size)
+		run.update(func(j *InstanceTransferJob) {
+			j.FilesTotal++
+			j.FilesDone++
+			j.BytesTotal += file.Size
+			j.BytesDone += file.Size
+		})
+		return nil
+	})
+	return files, err
+}
+
+// exportFile describes one file of the inventory
+func exportFile(realPath, name string) (ExportFile, error) {
+	f, err := OpenPlain(realPath)
+	if err != nil {
+		return ExportFile{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ExportFile{}, err
+	}
+	sum := sha256.New()
+	n, err := io.Copy(sum, f)
+	if err != nil {
+		return ExportFile{}, err
+	}
+	return ExportFile{
+		Path:    name,
+		Size:    n,
+		ModTime: info.ModTime().UTC(),
+		SHA256:  hex.EncodeToString(sum.Sum(nil)),
+	}, nil
+}
+
+// loadExportManifest reads the manifest of a finished export
+func (h *InstanceTransferHandler) loadExportManifest(id string) (*InstanceManifest, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(h.transferDir(id), "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var m InstanceManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// exportedFiles returns the file paths of a finished export
+func (h *InstanceTransferHandler) exportedFiles(id string) (map[string]bool, error) {
+	exportInventoriesMu.Lock()
+	defer exportInventoriesMu.Unlock()
+	if files, ok := exportInventories[id]; ok {
+		return files, nil
+	}
+	m, err := h.loadExportManifest(id)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		files[f.Path] = true
+	}
+	exportInventories[id] = files
+	return files, nil
+}
+
+// GetExportManifest downloads the manifest of a finished export
+// @Summary		Download an export manifest
+// @Description	Returns the manifest written by an export, as a JSON attachment
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Export job ID"
+// @Success		200		{object}	InstanceManifest	"Manifest"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Export not found or not finished"
+// @Security	BearerAuth
+// @Router		/admin/export/{id}/manifest [get]
+func (h *InstanceTransferHandler) GetExportManifest(c echo.Context) error {
+	claims, err := RequireAdmin(c)
+	if claims == nil {
+		return err // Error response already written
+	}
+	id := c.Param("id")
+	if _, err := h.loadExportManifest(id); err != nil {
+		return RespondError(c, ErrNotFound("Export"))
+	}
+	setContentDisposition(c, "filehatch-export-"+id+".json")
+	return c.File(filepath.Join(h.transferDir(id), "manifest.json"))
+}
+
+// GetExportArchive streams the files of a finished export as a ZIP archive
+// @Summary		Download export files
+// @Description	Streams the files listed in an export's inventory as an uncompressed ZIP archive, named by their data-root relative paths. Upload it with the manifest to POST /admin/import when the target cannot reach this instance.
+// @Tags		Admin
+// @Produce		application/zip
+// @Param		id	path		string	true	"Export job ID"
+// @Success		200		{file}		binary	"ZIP archive"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Export not found or not finished"
+// @Security	BearerAuth
+// @Router		/admin/export/{id}/archive [get]
+func (h *InstanceTransferHandler) GetExportArchive(c echo.Context) error {
+	claims, err := RequireAdmin(c)
+	if claims == nil {
+		return err // Error response already written
+	}
+	id := c.Param("id")
+	m, err := h.loadExportManifest(id)
+	if err != nil {
+		return RespondError(c, ErrNotFound("Export"))
+	}
+
+	setContentDisposition(c, "filehatch-export-"+id+".zip")
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(c.Response())
+	for _, file := range m.Files {
+		if err := c.Request().Context().Err(); err != nil {
+			return nil // Client went away
+		}
+		realPath := GetStorageLocations().Map(filepath.Join(h.dataRoot, filepath.FromSlash(file.Path)))
+		f, err := OpenPlain(realPath)
+		if err != nil {
+			// Left out; the import reports it as a missing file
+			continue
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.Path, Method: zip.Store, Modified: file.ModTime})
+		if err == nil {
+			_, err = io.Copy(w, f)
+		}
+		f.Close()
+		if err != nil {
+			LogError("Export archive interrupted", err, "export", id, "path", file.Path)
+			return nil
+		}
+	}
+	_ = zw.Close()
+	return nil
+}
+
+// GetExportFile serves one file of a finished export
+// @Summary		Download an exported file
+// @Description	Serves a file listed in an export's inventory; the path is data-root relative as in the manifest. Used by imports that pull files from this instance.
+// @Tags		Admin
+// @Produce		octet-stream
+// @Param		id		path		string	true	"Export job ID"
+// @Param		path	path		string	true	"File path from the manifest"
+// @Success		200		{file}		binary	"File content"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not in the export"
+// @Security	BearerAuth
+// @Router		/admin/export/{id}/files/{path} [get]
+func (h *InstanceTransferHandler) GetExportFile(c echo.Context) error {
+	claims, err := RequireAdmin(c)
+	if claims == nil {
+		return err // Error response already written
+	}
+	files, err := h.exportedFiles(c.Param("id"))
+	if err != nil {
+		return RespondError(c, ErrNotFound("Export"))
+	}
+	name := c.Param("*")
+	if decodedPath, err := url.PathUnescape(name); err == nil {
+		name = decodedPath
+	}
+	if !files[name] {
+		return RespondError(c, ErrNotFound("File"))
+	}
+	realPath := GetStorageLocations().Map(filepath.Join(h.dataRoot, filepath.FromSlash(name)))
+	if _, err := os.Stat(realPath); err != nil {
+		return RespondError(c, ErrNotFound("File"))
+	}
+	return ServePlainFile(c, realPath)
+}
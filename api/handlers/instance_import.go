@@ -0,0 +1,1021 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Conflict resolutions for users and shared drives that already exist here
+const (
+	ImportMerge  = "merge"  // Use the existing one; its settings are left as they are
+	ImportSkip   = "skip"   // Leave it out, with its files, memberships and shares
+	ImportRename = "rename" // Create it under another name
+)
+
+// Import result actions, besides the provisioning ones
+const (
+	ImportActionMerged  = "merged"
+	ImportActionSkipped = "skipped"
+)
+
+// ImportResolution resolves a conflict. Name is the new name for rename.
+type ImportResolution struct {
+	Action string `json:"action"`
+	Name   string `json:"name,omitempty"`
+}
+
+// ImportSource is the instance an import pulls its manifest and files from
+type ImportSource struct {
+	URL      string `json:"url"`      // e.g. https://old.example.com
+	Token    string `json:"token"`    // Admin access token on the source
+	ExportID string `json:"exportId"` // Finished export on the source
+}
+
+// ImportRequest starts an import. Without a source the manifest is given
+// inline or uploaded, and the files come from an uploaded export archive.
+type ImportRequest struct {
+	Source      *ImportSource               `json:"source,omitempty"`
+	Manifest    *InstanceManifest           `json:"manifest,omitempty"`
+	Resolutions map[string]ImportResolution `json:"resolutions,omitempty"` // Keyed by conflict item
+	DryRun      bool                        `json:"dryRun"`
+}
+
+// ImportConflict is a user or shared drive that already exists here
+type ImportConflict struct {
+	Item          string            `json:"item"` // user:{name} or sharedFolder:{name}
+	Message       string            `json:"message"`
+	SuggestedName string            `json:"suggestedName"`
+	Choices       []string          `json:"choices"`
+	Resolution    *ImportResolution `json:"resolution,omitempty"`
+}
+
+// importSource reads the files of an export
+type importSource interface {
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	Close() error
+}
+
+// archiveSource reads files from an uploaded export archive
+type archiveSource struct {
+	zr    *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+func openArchiveSource(archivePath string) (*archiveSource, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	return &archiveSource{zr: zr, files: files}, nil
+}
+
+func (s *archiveSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, ok := s.files[name]
+	if !ok {
+		return nil, errors.New("not in the archive")
+	}
+	return f.Open()
+}
+
+func (s *archiveSource) Close() error {
+	return s.zr.Close()
+}
+
+// apiSource pulls files from the source instance's export
+type apiSource struct {
+	client   *http.Client
+	baseURL  string
+	token    string
+	exportID string
+}
+
+func (s *apiSource) get(ctx context.Context, endpoint string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/api/admin/export/"+url.PathEscape(s.exportID)+endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("source answered %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (s *apiSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return s.get(ctx, "/files/"+strings.Join(parts, "/"))
+}
+
+func (s *apiSource) Close() error {
+	return nil
+}
+
+// fetchManifest downloads the manifest of a finished export on the source
+func (s *apiSource) fetchManifest(ctx context.Context) (*InstanceManifest, error) {
+	body, err := s.get(ctx, "/manifest")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var m InstanceManifest
+	if err := json.NewDecoder(body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// importPlan maps the users, drives and paths of a manifest to this instance
+type importPlan struct {
+	users   map[string]string // Source username -> username here, "" if skipped
+	folders map[string]string // Source drive name -> drive name here, "" if skipped
+	dirs    map[string]string // Source drive directory -> source drive name
+}
+
+func newImportPlan(m *InstanceManifest, resolutions map[string]ImportResolution) *importPlan {
+	p := &importPlan{
+		users:   make(map[string]string, len(m.Users)),
+		folders: make(map[string]string, len(m.SharedFolders)),
+		dirs:    make(map[string]string, len(m.SharedFolders)),
+	}
+	target := func(item, name string) string {
+		switch r := resolutions[item]; r.Action {
+		case ImportSkip:
+			return ""
+		case ImportRename:
+			return r.Name
+		}
+		return name
+	}
+	for _, u := range m.Users {
+		p.users[u.Username] = target("user:"+u.Username, u.Username)
+	}
+	for _, f := range m.SharedFolders {
+		p.folders[f.Name] = target("sharedFolder:"+f.Name, f.Name)
+		p.dirs[sanitizeFolderName(f.Name)] = f.Name
+	}
+	return p
+}
+
+// dataPath maps a data-root relative path of the source to this instance;
+// false if its user or drive is skipped or not in the manifest
+func (p *importPlan) dataPath(rel string) (string, bool) {
+	root, rest, _ := strings.Cut(rel, "/")
+	owner, rest, _ := strings.Cut(rest, "/")
+	var mapped string
+	switch root {
+	case "users":
+		mapped = p.users[owner]
+	case "shared":
+		if name, ok := p.dirs[owner]; ok && p.folders[name] != "" {
+			mapped = sanitizeFolderName(p.folders[name])
+		}
+	}
+	if mapped == "" {
+		return "", false
+	}
+	return path.Join(root, mapped, rest), true
+}
+
+// displayPath maps a path as its owner sees it (/home/... or /shared/...);
+// home paths are relative to the owner and stay as they are
+func (p *importPlan) displayPath(displayPath string) (string, bool) {
+	if displayPath == "/home" || strings.HasPrefix(displayPath, "/home/") {
+		return displayPath, true
+	}
+	if !strings.HasPrefix(displayPath, "/shared/") {
+		return "", false
+	}
+	mapped, ok := p.dataPath(strings.TrimPrefix(displayPath, "/"))
+	if !ok {
+		return "", false
+	}
+	return "/" + mapped, true
+}
+
+// validateInstanceManifest checks a manifest before anything is imported
+func validateInstanceManifest(m *InstanceManifest) []ProvisionValidationError {
+	var problems []ProvisionValidationError
+	add := func(item, format string, args ...interface{}) {
+		problems = append(problems, ProvisionValidationError{Item: item, Message: fmt.Sprintf(format, args...)})
+	}
+	if m.Version != instanceManifestVersion {
+		add("manifest", "unsupported manifest version %d", m.Version)
+		return problems
+	}
+
+	users := make(map[string]bool)
+	for _, u := range m.Users {
+		item := "user:" + u.Username
+		if len(u.Username) < 3 || len(u.Username) > 50 || strings.ContainsAny(u.Username, `/\`) || strings.HasPrefix(u.Username, ".") {
+			add(item, "invalid username")
+		} else if users[u.Username] {
+			add(item, "duplicate username in manifest")
+		}
+		users[u.Username] = true
+	}
+	dirs := make(map[string]bool)
+	for _, f := range m.SharedFolders {
+		item := "sharedFolder:" + f.Name
+		dir := sanitizeFolderName(f.Name)
+		if dir == "" || strings.HasPrefix(dir, ".") {
+			add(item, "invalid name")
+		} else if dirs[dir] {
+			add(item, "duplicate shared drive in manifest")
+		}
+		dirs[dir] = true
+		for _, mem := range f.Members {
+			if !users[mem.Username] {
+				add("member:"+f.Name+"/"+mem.Username, "user not in manifest")
+			}
+		}
+	}
+	for _, file := range m.Files {
+		root, rest, _ := strings.Cut(file.Path, "/")
+		owner, _, _ := strings.Cut(rest, "/")
+		switch {
+		case path.Clean(file.Path) != file.Path || strings.Contains(file.Path, ".."):
+			add("file:"+file.Path, "path is not clean")
+		case !(root == "users" && users[owner]) && !(root == "shared" && dirs[owner]):
+			add("file:"+file.Path, "path is not in a home folder or shared drive of the manifest")
+		case file.Size < 0 || len(file.SHA256) != sha256.Size*2:
+			add("file:"+file.Path, "invalid size or checksum")
+		}
+	}
+	return problems
+}
+
+// importConflicts lists the users and drives of a manifest that already
+// exist here, with the resolution chosen for each. A rename whose name is
+// taken is reported as a problem.
+func (h *InstanceTransferHandler) importConflicts(m *InstanceManifest, resolutions map[string]ImportResolution) ([]ImportConflict, []ProvisionValidationError, error) {
+	conflicts := []ImportConflict{}
+	var problems []ProvisionValidationError
+
+	userTaken := func(name string) (bool, error) {
+		var exists bool
+		err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", name).Scan(&exists)
+		return exists, err
+	}
+	folderTaken := func(name string) (bool, error) {
+		var exists bool
+		err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM shared_folders WHERE name = $1)", name).Scan(&exists)
+		return exists, err
+	}
+	suggest := func(name string, taken func(string) (bool, error)) (string, error) {
+		for i := 2; ; i++ {
+			candidate := fmt.Sprintf("%s-%d", name, i)
+			exists, err := taken(candidate)
+			if err != nil || !exists {
+				return candidate, err
+			}
+		}
+	}
+	check := func(item, name, message string, taken func(string) (bool, error), validName func(string) bool) error {
+		exists, err := taken(name)
+		if err != nil || !exists {
+			return err
+		}
+		suggested, err := suggest(name, taken)
+		if err != nil {
+			return err
+		}
+		conflict := ImportConflict{
+			Item:          item,
+			Message:       message,
+			SuggestedName: suggested,
+			Choices:       []string{ImportMerge, ImportSkip, ImportRename},
+		}
+		if r, ok := resolutions[item]; ok {
+			switch r.Action {
+			case ImportMerge, ImportSkip:
+			case ImportRename:
+				if !validName(r.Name) {
+					problems = append(problems, ProvisionValidationError{Item: item, Message: "invalid new name"})
+				} else if taken, err := taken(r.Name); err != nil {
+					return err
+				} else if taken {
+					problems = append(problems, ProvisionValidationError{Item: item, Message: r.Name + " already exists"})
+				}
+			default:
+				problems = append(problems, ProvisionValidationError{Item: item, Message: "resolution must be merge, skip or rename"})
+			}
+			conflict.Resolution = &r
+		}
+		conflicts = append(conflicts, conflict)
+		return nil
+	}
+
+	for _, u := range m.Users {
+		validName := func(name string) bool {
+			return len(name) >= 3 && len(name) <= 50 && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".")
+		}
+		if err := check("user:"+u.Username, u.Username, "A user with this name already exists", userTaken, validName); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, f := range m.SharedFolders {
+		validName := func(name string) bool {
+			dir := sanitizeFolderName(name)
+			return dir != "" && !strings.HasPrefix(dir, ".")
+		}
+		if err := check("sharedFolder:"+f.Name, f.Name, "A shared drive with this name already exists", folderTaken, validName); err != nil {
+			return nil, nil, err
+		}
+	}
+	return conflicts, problems, nil
+}
+
+// StartImport imports users, drives, shares and files exported by another instance
+// @Summary		Import from another instance
+// @Description	Recreates the users, shared drives, memberships, link shares, user-to-user shares and file metadata of an export, then copies the files with checksum verification. The manifest and files come either from the source instance (source.url, source.token of an admin there, source.exportId) or from an upload (multipart: request JSON field, manifest and archive files from the source's export endpoints). Existing users and drives with the same name are conflicts; each needs a merge, skip or rename resolution, otherwise 409 lists them. Imported local users get a generated initial password, reported once in the job. Runs as a resumable job.
+// @Tags		Admin
+// @Accept		json,mpfd
+// @Produce		json
+// @Param		body	body		ImportRequest	true	"Import request"
+// @Success		200		{object}	docs.SuccessResponse	"Dry run: conflicts and totals"
+// @Success		202		{object}	docs.SuccessResponse	"Import started"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid manifest or source"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		409		{object}	docs.ErrorResponse	"Unresolved conflicts, or an import is already running"
+// @Failure		502		{object}	docs.ErrorResponse	"Source instance unreachable"
+// @Security	BearerAuth
+// @Router		/admin/import [post]
+func (h *InstanceTransferHandler) StartImport(c echo.Context) error {
+	claims, err := RequireAdmin(c)
+	if claims == nil {
+		return err // Error response already written
+	}
+
+	var req ImportRequest
+	var archive *os.File
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		if field := c.FormValue("request"); field != "" {
+			if err := json.Unmarshal([]byte(field), &req); err != nil {
+				return RespondError(c, ErrBadRequest("Invalid request field"))
+			}
+		}
+		if fh, err := c.FormFile("manifest"); err == nil {
+			f, err := fh.Open()
+			if err != nil {
+				return RespondError(c, ErrOperationFailed("read manifest", err))
+			}
+			req.Manifest = &InstanceManifest{}
+			err = json.NewDecoder(f).Decode(req.Manifest)
+			f.Close()
+			if err != nil {
+				return RespondError(c, ErrBadRequest("Invalid manifest"))
+			}
+		}
+		if fh, err := c.FormFile("archive"); err == nil {
+			f, err := fh.Open()
+			if err != nil {
+				return RespondError(c, ErrOperationFailed("read archive", err))
+			}
+			defer f.Close()
+			if archive, _ = f.(*os.File); archive == nil {
+				// Small uploads are kept in memory; spool them to disk
+				tmp, err := os.CreateTemp("", "filehatch-import-*.zip")
+				if err != nil {
+					return RespondError(c, ErrOperationFailed("store archive", err))
+				}
+				defer os.Remove(tmp.Name())
+				defer tmp.Close()
+				if _, err := io.Copy(tmp, f); err != nil {
+					return RespondError(c, ErrOperationFailed("store archive", err))
+				}
+				archive = tmp
+			}
+		}
+	} else if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+
+	var source *apiSource
+	if req.Source != nil {
+		u, err := url.Parse(strings.TrimSpace(req.Source.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return RespondError(c, ErrBadRequest("source.url must be an http(s) URL"))
+		}
+		if req.Source.Token == "" || req.Source.ExportID == "" {
+			return RespondError(c, ErrMissingParameter("source.token and source.exportId"))
+		}
+		source = &apiSource{
+			client:   h.client,
+			baseURL:  strings.TrimRight(u.String(), "/"),
+			token:    req.Source.Token,
+			exportID: req.Source.ExportID,
+		}
+		if req.Manifest == nil {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), time.Minute)
+			req.Manifest, err = source.fetchManifest(ctx)
+			cancel()
+			if err != nil {
+				return RespondError(c, NewAPIError(ErrCodeBadGateway, "Failed to fetch the manifest from the source: "+err.Error()))
+			}
+		}
+	} else if archive == nil && !req.DryRun {
+		return RespondError(c, ErrBadRequest("Give a source instance or upload the export archive"))
+	}
+	if req.Manifest == nil {
+		return RespondError(c, ErrMissingParameter("manifest"))
+	}
+	m := req.Manifest
+	if problems := validateInstanceManifest(m); len(problems) > 0 {
+		return RespondError(c, ErrBadRequest("Manifest validation failed").WithDetails(problems))
+	}
+
+	conflicts, problems, err := h.importConflicts(m, req.Resolutions)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	if len(problems) > 0 {
+		return RespondError(c, ErrBadRequest("Invalid conflict resolutions").WithDetails(problems))
+	}
+	unresolved := 0
+	for _, conflict := range conflicts {
+		if conflict.Resolution == nil {
+			unresolved++
+		}
+	}
+
+	var bytesTotal int64
+	for _, f := range m.Files {
+		bytesTotal += f.Size
+	}
+	if req.DryRun {
+		return RespondSuccess(c, map[string]interface{}{
+			"dryRun":        true,
+			"exportId":      m.ExportID,
+			"conflicts":     conflicts,
+			"unresolved":    unresolved,
+			"users":         len(m.Users),
+			"sharedFolders": len(m.SharedFolders),
+			"shares":        len(m.Shares),
+			"fileShares":    len(m.FileShares),
+			"fileMetadata":  len(m.FileMetadata),
+			"files":         len(m.Files),
+			"bytes":         bytesTotal,
+		})
+	}
+	if unresolved > 0 {
+		return RespondError(c, NewAPIError(ErrCodeConflict, fmt.Sprintf("%d users or shared drives already exist; choose merge, skip or rename for each", unresolved)).
+			WithDetails(map[string]interface{}{"conflicts": conflicts}))
+	}
+	if run := runningTransfer(TransferImport); run != nil {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "An import is already running").WithDetails(run.snapshot()))
+	}
+
+	job := InstanceTransferJob{
+		ID:          newActivityID(ActivityImport),
+		Kind:        TransferImport,
+		Phase:       ImportPhaseEntities,
+		Status:      AdoptRunning,
+		ExportID:    m.ExportID,
+		FromArchive: source == nil,
+		Resolutions: req.Resolutions,
+		FilesTotal:  int64(len(m.Files)),
+		BytesTotal:  bytesTotal,
+		StartedAt:   time.Now(),
+	}
+	token := ""
+	if source != nil {
+		job.SourceURL, token = source.baseURL, source.token
+		job.ExportID = source.exportID
+	}
+
+	// The manifest and archive are kept until the job is done, so it can resume
+	dir := h.transferDir(job.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return RespondError(c, ErrOperationFailed("store manifest", err))
+	}
+	data, _ := json.Marshal(m)
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), data, 0600); err != nil {
+		return RespondError(c, ErrOperationFailed("store manifest", err))
+	}
+	if archive != nil {
+		if err := copyImportArchive(archive, filepath.Join(dir, "archive.zip")); err != nil {
+			_ = os.RemoveAll(dir)
+			return RespondError(c, ErrOperationFailed("store archive", err))
+		}
+	}
+	if err := h.insertTransfer(job, token, claims.UserID); err != nil {
+		_ = os.RemoveAll(dir)
+		return RespondError(c, ErrInternal("Failed to create import"))
+	}
+
+	run := h.startTransfer(job, token, claims, c.RealIP())
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data":    run.snapshot(),
+	})
+}
+
+// copyImportArchive stores an uploaded archive with the job
+func copyImportArchive(src *os.File, dst string) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// importPhases runs the remaining phases of an import, saving each one so an
+// interrupted import picks up at the phase it was in
+func (h *InstanceTransferHandler) importPhases(run *transferRun, activity *Activity) error {
+	job := run.snapshot()
+	dir := h.transferDir(job.ID)
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	var m InstanceManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	plan := newImportPlan(&m, job.Resolutions)
+
+	next := func(phase string) error {
+		run.update(func(j *InstanceTransferJob) { j.Phase = phase })
+		return h.saveTransfer(run.snapshot(), run.token)
+	}
+
+	switch job.Phase {
+	case ImportPhaseEntities:
+		if err := h.importEntities(run, &m, plan); err != nil {
+			return err
+		}
+		if err := next(ImportPhaseFiles); err != nil {
+			return err
+		}
+		fallthrough
+
+	case ImportPhaseFiles:
+		var source importSource
+		if job.FromArchive {
+			archive, err := openArchiveSource(filepath.Join(dir, "archive.zip"))
+			if err != nil {
+				return fmt.Errorf("open archive: %w", err)
+			}
+			source = archive
+		} else {
+			source = &apiSource{client: h.client, baseURL: job.SourceURL, token: run.token, exportID: job.ExportID}
+		}
+		err := h.importFiles(run, activity, source, &m, plan)
+		source.Close()
+		if err != nil {
+			return err
+		}
+		if err := next(ImportPhaseReferences); err != nil {
+			return err
+		}
+		fallthrough
+
+	case ImportPhaseReferences:
+		if err := h.importReferences(run, &m, plan); err != nil {
+			return err
+		}
+		if err := next(ImportPhaseDone); err != nil {
+			return err
+		}
+		// Only the manifest is kept once the import is done
+		_ = os.Remove(filepath.Join(dir, "archive.zip"))
+	}
+	return nil
+}
+
+// importEntities creates the users, drives and memberships. Items with a
+// result from an earlier, interrupted run are not applied again.
+func (h *InstanceTransferHandler) importEntities(run *transferRun, m *InstanceManifest, plan *importPlan) error {
+	done := make(map[string]bool)
+	for _, r := range run.snapshot().Results {
+		done[r.Type+":"+r.Folder+"/"+r.Name] = true
+	}
+	record := func(r ProvisionResult) error {
+		run.update(func(j *InstanceTransferJob) { j.Results = append(j.Results, r) })
+		return h.saveTransfer(run.snapshot(), run.token)
+	}
+	actor, clientIP := run.actor, run.clientIP
+	resolutions := run.snapshot().Resolutions
+
+	for _, u := range m.Users {
+		if done["user:/"+u.Username] {
+			continue
+		}
+		result := ProvisionResult{Type: "user", Name: u.Username}
+		target := plan.users[u.Username]
+		switch {
+		case target == "":
+			result.Action = ImportActionSkipped
+		case resolutions["user:"+u.Username].Action == ImportMerge:
+			result.Action = ImportActionMerged
+		default:
+			result = h.importUser(u, target, actor, clientIP)
+		}
+		if err := record(result); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range m.SharedFolders {
+		target := plan.folders[f.Name]
+		if !done["sharedFolder:/"+f.Name] {
+			result := ProvisionResult{Type: "sharedFolder", Name: f.Name}
+			switch {
+			case target == "":
+				result.Action = ImportActionSkipped
+			case resolutions["sharedFolder:"+f.Name].Action == ImportMerge:
+				result.Action = ImportActionMerged
+			default:
+				result.Action = ProvisionActionCreated
+				if target != f.Name {
+					result.Changes = []string{"renamed to " + target}
+				}
+				folderID, apiErr := h.sharedFolders.createSharedFolder(target, f.Description, f.StorageQuota, actor.UserID, clientIP)
+				if apiErr == nil && !f.IsActive {
+					inactive := false
+					apiErr = h.sharedFolders.updateSharedFolder(folderID, target, f.Description, f.StorageQuota, &inactive, actor.UserID, clientIP)
+				}
+				if apiErr != nil {
+					result.Action = ProvisionActionFailed
+					result.Error = apiErr.Message
+				}
+			}
+			if err := record(result); err != nil {
+				return err
+			}
+		}
+		if target == "" {
+			continue
+		}
+
+		var folderID string
+		if err := h.db.QueryRow("SELECT id FROM shared_folders WHERE name = $1", target).Scan(&folderID); err != nil {
+			continue // Creating it failed; reported above
+		}
+		for _, mem := range f.Members {
+			username := plan.users[mem.Username]
+			if username == "" || done["member:"+f.Name+"/"+mem.Username] {
+				continue
+			}
+			result := ProvisionResult{Type: "member", Name: mem.Username, Folder: f.Name}
+			userID := h.userID(username)
+			var exists bool
+			_ = h.db.QueryRow(`
+				SELECT EXISTS(SELECT 1 FROM shared_folder_members WHERE shared_folder_id = $1 AND user_id = $2)
+			`, folderID, userID).Scan(&exists)
+			switch {
+			case userID == "":
+				result.Action = ProvisionActionFailed
+				result.Error = "User not available"
+			case exists:
+				// Merged drives keep the permissions they have here
+				result.Action = ProvisionActionUnchanged
+			default:
+				result.Action = ProvisionActionAdded
+				if apiErr := h.sharedFolders.setMember(folderID, userID, mem.PermissionLevel, actor, clientIP); apiErr != nil {
+					result.Action = ProvisionActionFailed
+					result.Error = apiErr.Message
+				}
+			}
+			if err := record(result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importUser creates a user of the manifest under its name here
+func (h *InstanceTransferHandler) importUser(u ExportUser, username string, actor *JWTClaims, clientIP string) ProvisionResult {
+	result := ProvisionResult{Type: "user", Name: u.Username, Action: ProvisionActionCreated}
+	if username != u.Username {
+		result.Changes = []string{"renamed to " + username}
+	}
+
+	// Passwords are not exported: every imported account gets a new one,
+	// which local users need to sign in
+	password, err := generateInitialPassword()
+	if err != nil {
+		result.Action = ProvisionActionFailed
+		result.Error = err.Error()
+		return result
+	}
+	userID, warnings, apiErr := h.authHandler.createUserAccount(CreateUserRequest{
+		Username: username,
+		Email:    u.Email,
+		Password: password,
+		IsAdmin:  u.IsAdmin,
+	})
+	if apiErr != nil {
+		result.Action = ProvisionActionFailed
+		result.Error = apiErr.Message
+		return result
+	}
+	result.Warnings = warnings
+	if u.ResetPassword {
+		result.InitialPassword = password
+	}
+
+	if u.StorageQuota != 0 || !u.IsActive {
+		quota := u.StorageQuota
+		update := UpdateUserRequest{IsAdmin: u.IsAdmin, IsActive: u.IsActive, StorageQuota: &quota}
+		if apiErr := h.authHandler.updateUserAccount(userID, update); apiErr != nil {
+			result.Warnings = append(result.Warnings, "Failed to apply quota: "+apiErr.Message)
+		}
+	}
+	if u.Provider != "" && u.Provider != "local" {
+		if _, err := h.db.Exec(`
+			UPDATE users SET provider = $1, provider_id = NULLIF($2, '') WHERE id = $3
+		`, u.Provider, u.ProviderID, userID); err != nil {
+			result.Warnings = append(result.Warnings, "Failed to link SSO account: "+err.Error())
+		}
+	}
+
+	_ = h.auditHandler.LogEvent(&actor.UserID, clientIP, EventAdminUserCreate, username, map[string]interface{}{
+		"isAdmin":  u.IsAdmin,
+		"imported": true,
+	})
+	return result
+}
+
+// userID returns the ID of a user here, or ""
+func (h *InstanceTransferHandler) userID(username string) string {
+	var id string
+	_ = h.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&id)
+	return id
+}
+
+// importFiles copies the files of the manifest. Files already in place with
+// the right checksum count as done, so a resumed import does not copy them
+// again; files here with other content are kept and reported.
+func (h *InstanceTransferHandler) importFiles(run *transferRun, activity *Activity, source importSource, m *InstanceManifest, plan *importPlan) error {
+	run.update(func(j *InstanceTransferJob) {
+		j.FilesDone, j.FilesSkipped, j.FilesFailed, j.BytesDone, j.FileErrors = 0, 0, 0, 0, nil
+	})
+	activity.SetBytes(0)
+
+	for i, f := range m.Files {
+		if err := activity.Pace(); err != nil {
+			return err
+		}
+		rel, ok := plan.dataPath(f.Path)
+		if !ok {
+			run.update(func(j *InstanceTransferJob) { j.FilesSkipped++ })
+			continue
+		}
+		if err := h.importFile(activity.Context(), source, f, rel); err != nil {
+			if activity.Err() != nil {
+				return activity.Err()
+			}
+			run.fileError("%s: %v", f.Path, err)
+		} else {
+			run.update(func(j *InstanceTransferJob) {
+				j.FilesDone++
+				j.BytesDone += f.Size
+			})
+		}
+		activity.AddBytes(f.Size)
+		// Checkpoint now and then so progress survives a restart
+		if i%100 == 99 {
+			if err := h.saveTransfer(run.snapshot(), run.token); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, username := range plan.users {
+		if username == "" {
+			continue
+		}
+		if id := h.userID(username); id != "" {
+			_ = h.files.RecalculateUserStorage(id, username)
+		}
+	}
+	for _, name := range plan.folders {
+		if name != "" {
+			_ = h.files.RecalculateSharedFolderStorage(name)
+		}
+	}
+	return nil
+}
+
+// importFile copies one file to rel (data-root relative), verifying its size
+// and checksum before it is moved into place
+func (h *InstanceTransferHandler) importFile(ctx context.Context, source importSource, f ExportFile, rel string) error {
+	dataPath := filepath.Join(h.dataRoot, filepath.FromSlash(rel))
+	if !isPathWithinRoot(dataPath, h.dataRoot) {
+		return errors.New("path outside the data root")
+	}
+	realPath := GetStorageLocations().Map(dataPath)
+	shared := strings.HasPrefix(rel, "shared/")
+
+	if info, err := os.Stat(realPath); err == nil {
+		if info.IsDir() {
+			return errors.New("a folder with this name exists here")
+		}
+		existing, err := exportFile(realPath, rel)
+		if err != nil {
+			return err
+		}
+		if existing.SHA256 != f.SHA256 {
+			return errors.New("a different file with this name exists here; kept it")
+		}
+		return nil
+	}
+
+	GetStorageLocations().waitWritable(realPath)
+	dir := filepath.Dir(realPath)
+	var err error
+	if shared {
+		err = MkdirAllShared(dir)
+	} else {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err != nil {
+		return err
+	}
+
+	body, err := source.Open(ctx, f.Path)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(realPath)+".*.import")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, sum), body)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && (n != f.Size || hex.EncodeToString(sum.Sum(nil)) != f.SHA256) {
+		err = fmt.Errorf("checksum mismatch (%d of %d bytes)", n, f.Size)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if shared {
+		_ = SetSharedPermissions(tmpPath, false)
+	} else {
+		_ = os.Chmod(tmpPath, 0644)
+	}
+	_ = os.Chtimes(tmpPath, f.ModTime, f.ModTime)
+	if err := os.Rename(tmpPath, realPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return SealPath(realPath)
+}
+
+// importReferences recreates link shares, user-to-user shares and file
+// metadata. Rows that already exist are left as they are.
+func (h *InstanceTransferHandler) importReferences(run *transferRun, m *InstanceManifest, plan *importPlan) error {
+	var shares, fileShares, metadata ImportCounts
+	// The job gets a new map each time, so snapshots can be read unlocked
+	publish := func() {
+		run.update(func(j *InstanceTransferJob) {
+			j.References = map[string]ImportCounts{"shares": shares, "fileShares": fileShares, "fileMetadata": metadata}
+		})
+	}
+	count := func(counts *ImportCounts, res sql.Result, err error, what string) {
+		switch {
+		case err != nil:
+			counts.Failed++
+			run.note("%s: %v", what, err)
+		case rowsAffected(res) == 0:
+			counts.Existing++
+		default:
+			counts.Created++
+		}
+		publish()
+	}
+	skip := func(counts *ImportCounts) {
+		counts.Skipped++
+		publish()
+	}
+	publish()
+	userIDs := make(map[string]string)
+	mappedUser := func(username string) string {
+		target := plan.users[username]
+		if target == "" {
+			return ""
+		}
+		if _, ok := userIDs[target]; !ok {
+			userIDs[target] = h.userID(target)
+		}
+		return userIDs[target]
+	}
+
+	for _, s := range m.Shares {
+		creator := mappedUser(s.CreatedBy)
+		sharePath, ok := plan.dataPath(s.Path)
+		if creator == "" || !ok {
+			skip(&shares)
+			continue
+		}
+		res, err := h.db.Exec(`
+			INSERT INTO shares (token, path, created_by, expires_at, password_hash, max_access, is_active,
+			                    require_login, share_type, editable, max_file_size, allowed_extensions, max_total_size)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, NULLIF($12, ''), $13)
+			ON CONFLICT (token) DO NOTHING
+		`, s.Token, sharePath, creator, s.ExpiresAt, s.PasswordHash, s.MaxAccess, s.IsActive,
+			s.RequireLogin, s.ShareType, s.Editable, s.MaxFileSize, s.AllowedExtensions, s.MaxTotalSize)
+		if err == nil && rowsAffected(res) == 0 {
+			// The token is taken; fine if it is this share imported before
+			var existingPath string
+			_ = h.db.QueryRow("SELECT path FROM shares WHERE token = $1", s.Token).Scan(&existingPath)
+			if existingPath != sharePath {
+				err = errors.New("token already used by another share here")
+			}
+		}
+		count(&shares, res, err, "link share /"+sharePath)
+	}
+
+	for _, s := range m.FileShares {
+		owner, recipient := mappedUser(s.Owner), mappedUser(s.SharedWith)
+		itemPath, ok := plan.displayPath(s.ItemPath)
+		if owner == "" || recipient == "" || !ok {
+			skip(&fileShares)
+			continue
+		}
+		res, err := h.db.Exec(`
+			INSERT INTO file_shares (item_path, item_name, is_folder, owner_id, shared_with_id, permission_level,
+			                         message, status, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9)
+			ON CONFLICT (item_path, owner_id, shared_with_id) DO NOTHING
+		`, itemPath, s.ItemName, s.IsFolder, owner, recipient, s.PermissionLevel, s.Message, s.Status, s.ExpiresAt)
+		count(&fileShares, res, err, "share of "+itemPath+" with "+s.SharedWith)
+	}
+
+	for _, md := range m.FileMetadata {
+		userID := mappedUser(md.Username)
+		filePath, ok := plan.displayPath(md.FilePath)
+		if userID == "" || !ok {
+			skip(&metadata)
+			continue
+		}
+		tags := md.Tags
+		if len(tags) == 0 {
+			tags = json.RawMessage("[]")
+		}
+		res, err := h.db.Exec(`
+			INSERT INTO file_metadata (user_id, file_path, description, tags)
+			VALUES ($1, $2, NULLIF($3, ''), $4)
+			ON CONFLICT (user_id, file_path) DO NOTHING
+		`, userID, filePath, md.Description, []byte(tags))
+		count(&metadata, res, err, "metadata of "+filePath)
+	}
+	return nil
+}
+
+// rowsAffected returns the rows affected by a statement, 0 if unknown
+func rowsAffected(res sql.Result) int64 {
+	if res == nil {
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return n
+}
@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Instance transfer job kinds
+const (
+	TransferExport = "export"
+	TransferImport = "import"
+)
+
+// Import phases, in order
+const (
+	ImportPhaseEntities   = "entities"   // Users, shared drives and memberships
+	ImportPhaseFiles      = "files"      // File content, checksums verified
+	ImportPhaseReferences = "references" // Link shares, user-to-user shares and metadata
+	ImportPhaseDone       = "done"
+)
+
+const (
+	// instanceManifestVersion is the manifest format written by exports
+	instanceManifestVersion = 1
+	// instanceTransferDir holds export manifests and import archives below the data root
+	instanceTransferDir = ".transfer"
+	// maxTransferFileErrors caps the per-file problems listed in a job
+	maxTransferFileErrors = 100
+)
+
+// InstanceTransferHandler moves users, shared drives, shares and files
+// between FileHatch instances: an export on the source writes a manifest, an
+// import on the target recreates what it lists and pulls the file content
+type InstanceTransferHandler struct {
+	db            *sql.DB
+	dataRoot      string
+	auditHandler  *AuditHandler
+	files         *Handler // Storage accounting after the files are in place
+	authHandler   *AuthHandler
+	sharedFolders *SharedFolderHandler
+	client        *http.Client // Requests to the source instance
+}
+
+// NewInstanceTransferHandler creates a new InstanceTransferHandler
+func NewInstanceTransferHandler(h *Handler, authHandler *AuthHandler, sharedFolderHandler *SharedFolderHandler) *InstanceTransferHandler {
+	return &InstanceTransferHandler{
+		db:            h.db,
+		dataRoot:      h.dataRoot,
+		auditHandler:  h.auditHandler,
+		files:         h,
+		authHandler:   authHandler,
+		sharedFolders: sharedFolderHandler,
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 30 * time.Second,
+		}},
+	}
+}
+
+// InstanceManifest lists what an export found on the source instance. Paths
+// of files and link shares are data-root relative (users/{username}/... or
+// shared/{folder}/...); user-to-user shares and metadata keep the paths their
+// owners see (/home/... or /shared/...).
+type InstanceManifest struct {
+	Version       int                  `json:"version"`
+	ExportID      string               `json:"exportId"`
+	CreatedAt     time.Time            `json:"createdAt"`
+	Users         []ExportUser         `json:"users"`
+	SharedFolders []ExportSharedFolder `json:"sharedFolders"`
+	Shares        []ExportShare        `json:"shares"`
+	FileShares    []ExportFileShare    `json:"fileShares"`
+	FileMetadata  []ExportFileMetadata `json:"fileMetadata"`
+	Files         []ExportFile         `json:"files"`
+}
+
+// ExportUser is a user account without its password
+type ExportUser struct {
+	Username      string `json:"username"`
+	Email         string `json:"email,omitempty"`
+	Provider      string `json:"provider"`
+	ProviderID    string `json:"providerId,omitempty"`
+	IsAdmin       bool   `json:"isAdmin"`
+	IsActive      bool   `json:"isActive"`
+	StorageQuota  int64  `json:"storageQuota"`
+	ResetPassword bool   `json:"resetPassword"` // Gets a new initial password on import (local accounts)
+}
+
+// ExportSharedFolder is a shared drive with its members
+type ExportSharedFolder struct {
+	Name         string            `json:"name"`
+	Description  string            `json:"description,omitempty"`
+	StorageQuota int64             `json:"storageQuota"`
+	IsActive     bool              `json:"isActive"`
+	Members      []ProvisionMember `json:"members"`
+}
+
+// ExportShare is a link share. The token is kept so links already handed
+// out keep working, and the password hash so a protected link keeps its
+// password.
+type ExportShare struct {
+	Token             string     `json:"token"`
+	Path              string     `json:"path"`
+	CreatedBy         string     `json:"createdBy"` // Username
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+	PasswordHash      string     `json:"passwordHash,omitempty"`
+	MaxAccess         *int       `json:"maxAccess,omitempty"`
+	IsActive          bool       `json:"isActive"`
+	RequireLogin      bool       `json:"requireLogin"`
+	ShareType         string     `json:"shareType"`
+	Editable          bool       `json:"editable"`
+	MaxFileSize       int64      `json:"maxFileSize"`
+	AllowedExtensions string     `json:"allowedExtensions,omitempty"`
+	MaxTotalSize      int64      `json:"maxTotalSize"`
+}
+
+// ExportFileShare is a user-to-user share
+type ExportFileShare struct {
+	ItemPath        string     `json:"itemPath"`
+	ItemName        string     `json:"itemName"`
+	IsFolder        bool       `json:"isFolder"`
+	Owner           string     `json:"owner"`      // Username
+	SharedWith      string     `json:"sharedWith"` // Username
+	PermissionLevel int        `json:"permissionLevel"`
+	Message         string     `json:"message,omitempty"`
+	Status          string     `json:"status"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+}
+
+// ExportFileMetadata is a user's description and tags of a file
+type ExportFileMetadata struct {
+	Username    string          `json:"username"`
+	FilePath    string          `json:"filePath"`
+	Description string          `json:"description,omitempty"`
+	Tags        json.RawMessage `json:"tags"`
+}
+
+// ExportFile is a file in the inventory
+type ExportFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// ImportCounts counts the references of one kind an import recreated
+type ImportCounts struct {
+	Created  int `json:"created"`
+	Existing int `json:"existing"` // Already here, left as they are
+	Skipped  int `json:"skipped"`  // Their owner or path was skipped
+	Failed   int `json:"failed"`
+}
+
+// InstanceTransferJob is an export or import. Its ID is also the activity ID
+// while it runs, so it shows up in /admin/activity/live and can be cancelled
+// there.
+type InstanceTransferJob struct {
+	ID           string                      `json:"id"`
+	Kind         string                      `json:"kind"`
+	Phase        string                      `json:"phase,omitempty"` // Imports only
+	Status       string                      `json:"status"`          // Same states as adopt jobs
+	ExportID     string                      `json:"exportId,omitempty"`
+	SourceURL    string                      `json:"sourceUrl,omitempty"`
+	FromArchive  bool                        `json:"fromArchive,omitempty"`
+	Resolutions  map[string]ImportResolution `json:"resolutions,omitempty"`
+	FilesTotal   int64                       `json:"filesTotal"`
+	FilesDone    int64                       `json:"filesDone"`    // Exported, or in place with a verified checksum
+	FilesSkipped int64                       `json:"filesSkipped"` // Left out with their user or drive
+	FilesFailed  int64                       `json:"filesFailed"`
+	BytesTotal   int64                       `json:"bytesTotal"`
+	BytesDone    int64                       `json:"bytesDone"`
+	Results      []ProvisionResult           `json:"results,omitempty"`
+	References   map[string]ImportCounts     `json:"references,omitempty"`
+	FileErrors   []string                    `json:"fileErrors,omitempty"`
+	Error        string                      `json:"error,omitempty"`
+	StartedAt    time.Time                   `json:"startedAt"`
+	FinishedAt   *time.Time                  `json:"finishedAt,omitempty"`
+}
+
+// transferRun guards a job while it updates the counters
+type transferRun struct {
+	mu       sync.Mutex
+	job      InstanceTransferJob
+	token    string // Admin token on the source of an API import
+	actor    *JWTClaims
+	clientIP string
+}
+
+// errTransferActorGone stops a resumed job whose admin was deleted meanwhile
+var errTransferActorGone = errors.New("the admin who started this transfer no longer exists")
+
+var (
+	transferRunsMu sync.Mutex
+	transferRuns   = make(map[string]*transferRun)
+)
+
+func (r *transferRun) snapshot() InstanceTransferJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.job
+}
+
+func (r *transferRun) update(fn func(j *InstanceTransferJob)) {
+	r.mu.Lock()
+	fn(&r.job)
+	r.mu.Unlock()
+}
+
+// fileError records a file that could not be transferred
+func (r *transferRun) fileError(format string, args ...interface{}) {
+	r.update(func(j *InstanceTransferJob) { j.FilesFailed++ })
+	r.note(format, args...)
+}
+
+// note records a problem with a file or reference, keeping the first few
+func (r *transferRun) note(format string, args ...interface{}) {
+	r.update(func(j *InstanceTransferJob) {
+		if len(j.FileErrors) < maxTransferFileErrors {
+			j.FileErrors = append(j.FileErrors, fmt.Sprintf(format, args...))
+		}
+	})
+}
+
+// runningTransfer returns the job of a kind in progress, if any
+func runningTransfer(kind string) *transferRun {
+	transferRunsMu.Lock()
+	defer transferRunsMu.Unlock()
+	for _, run := range transferRuns {
+		if job := run.snapshot(); job.Kind == kind && job.Status == AdoptRunning {
+			return run
+		}
+	}
+	return nil
+}
+
+// transferDir is where a job keeps its manifest and uploaded archive
+func (h *InstanceTransferHandler) transferDir(id string) string {
+	return filepath.Join(h.dataRoot, instanceTransferDir, id)
+}
+
+// saveTransfer writes the phase, counters and outcome of a job. Initial
+// passwords are not stored; the source token is dropped once the job is done.
+func (h *InstanceTransferHandler) saveTransfer(job InstanceTransferJob, token string) error {
+	results := make([]ProvisionResult, len(job.Results))
+	for i, r := range job.Results {
+		r.InitialPassword = ""
+		results[i] = r
+	}
+	resultsJSON, _ := json.Marshal(results)
+	referencesJSON, _ := json.Marshal(job.References)
+	fileErrorsJSON, _ := json.Marshal(job.FileErrors)
+	if job.Status == AdoptCompleted {
+		token = ""
+	}
+	_, err := h.db.Exec(`
+		UPDATE instance_transfers
+		SET phase = NULLIF($2, ''), status = $3, files_total = $4, files_done = $5, files_skipped = $6,
+		    files_failed = $7, bytes_total = $8, bytes_done = $9, results = $10, refs = $11,
+		    file_errors = $12, error = NULLIF($13, ''), source_token = NULLIF($14, ''), finished_at = $15
+		WHERE id = $1
+	`, job.ID, job.Phase, job.Status, job.FilesTotal, job.FilesDone, job.FilesSkipped,
+		job.FilesFailed, job.BytesTotal, job.BytesDone, resultsJSON, referencesJSON,
+		fileErrorsJSON, job.Error, token, job.FinishedAt)
+	return err
+}
+
+// insertTransfer records a new job
+func (h *InstanceTransferHandler) insertTransfer(job InstanceTransferJob, token, startedBy string) error {
+	resolutions, _ := json.Marshal(job.Resolutions)
+	_, err := h.db.Exec(`
+		INSERT INTO instance_transfers (id, kind, phase, status, export_id, source_url, source_token,
+		                                from_archive, resolutions, files_total, bytes_total, started_by, started_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, $11, $12, $13)
+	`, job.ID, job.Kind, job.Phase, job.Status, job.ExportID, job.SourceURL, token,
+		job.FromArchive, resolutions, job.FilesTotal, job.BytesTotal, startedBy, job.StartedAt)
+	return err
+}
+
+// storedTransfer is a job as loaded from the database
+type storedTransfer struct {
+	InstanceTransferJob
+	token     string
+	startedBy string
+}
+
+// loadTransfers reads jobs from the database, newest first
+func (h *InstanceTransferHandler) loadTransfers(where string, args ...interface{}) ([]storedTransfer, error) {
+	rows, err := h.db.Query(`
+		SELECT id, kind, COALESCE(phase, ''), status, COALESCE(export_id, ''), COALESCE(source_url, ''),
+		       COALESCE(source_token, ''), from_archive, resolutions, files_total, files_done, files_skipped,
+		       files_failed, bytes_total, bytes_done, results, refs, file_errors, COALESCE(error, ''),
+		       COALESCE(started_by::text, ''), started_at, finished_at
+		FROM instance_transfers
+		`+where+`
+		ORDER BY started_at DESC
+		LIMIT 50
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []storedTransfer{}
+	for rows.Next() {
+		var t storedTransfer
+		var resolutions, results, references, fileErrors []byte
+		if err := rows.Scan(&t.ID, &t.Kind, &t.Phase, &t.Status, &t.ExportID, &t.SourceURL,
+			&t.token, &t.FromArchive, &resolutions, &t.FilesTotal, &t.FilesDone, &t.FilesSkipped,
+			&t.FilesFailed, &t.BytesTotal, &t.BytesDone, &results, &references, &fileErrors, &t.Error,
+			&t.startedBy, &t.StartedAt, &t.FinishedAt); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal(resolutions, &t.Resolutions)
+		_ = json.Unmarshal(results, &t.Results)
+		_ = json.Unmarshal(references, &t.References)
+		_ = json.Unmarshal(fileErrors, &t.FileErrors)
+		jobs = append(jobs, t)
+	}
+	return jobs, rows.Err()
+}
+
+// startTransfer registers a job and runs it in the background
+func (h *InstanceTransferHandler) startTransfer(job InstanceTransferJob, token string, actor *JWTClaims, clientIP string) *transferRun {
+	kind := ActivityExport
+	if job.Kind == TransferImport {
+		kind = ActivityImport
+	}
+	activity := GetActivityRegistry().Start(context.Background(), ActivityInfo{
+		ID:         job.ID,
+		Kind:       kind,
+		Username:   actor.Username,
+		Path:       "/",
+		BytesTotal: job.BytesTotal,
+	})
+	run := &transferRun{job: job, token: token, actor: actor, clientIP: clientIP}
+	transferRunsMu.Lock()
+	transferRuns[job.ID] = run
+	transferRunsMu.Unlock()
+
+	go h.runTransfer(run, activity)
+	return run
+}
+
+// ResumeInstanceTransfers restarts jobs interrupted by a shutdown
+func (h *InstanceTransferHandler) ResumeInstanceTransfers() {
+	jobs, err := h.loadTransfers(`WHERE status = $1`, AdoptRunning)
+	if err != nil {
+		log.Printf("[Transfer] Failed to load interrupted jobs: %v", err)
+		return
+	}
+	for _, t := range jobs {
+		actor, err := h.transferActor(t.startedBy)
+		if err != nil {
+			now := time.Now()
+			t.Status, t.Error, t.FinishedAt = AdoptFailed, err.Error(), &now
+			_ = h.saveTransfer(t.InstanceTransferJob, t.token)
+			continue
+		}
+		log.Printf("[Transfer] Resuming %s %s in phase %s", t.Kind, t.ID, t.Phase)
+		h.startTransfer(t.InstanceTransferJob, t.token, actor, "")
+	}
+}
+
+// transferActor looks up the admin who started a job; an import creates
+// drives and memberships in their name
+func (h *InstanceTransferHandler) transferActor(userID string) (*JWTClaims, error) {
+	actor := &JWTClaims{UserID: userID, IsAdmin: true}
+	if userID == "" {
+		return nil, errTransferActorGone
+	}
+	if err := h.db.QueryRow(`SELECT username FROM users WHERE id = $1`, userID).Scan(&actor.Username); err != nil {
+		return nil, errTransferActorGone
+	}
+	return actor, nil
+}
+
+// runTransfer runs a job to the end and records its outcome
+func (h *InstanceTransferHandler) runTransfer(run *transferRun, activity *Activity) {
+	defer activity.Finish()
+
+	var err error
+	if run.snapshot().Kind == TransferImport {
+		err = h.importPhases(run, activity)
+	} else {
+		err = h.runExport(run, activity)
+	}
+	now := time.Now()
+	run.update(func(j *InstanceTransferJob) {
+		j.FinishedAt = &now
+		switch {
+		case err == nil:
+			j.Status = AdoptCompleted
+		case activity.Err() != nil:
+			j.Status = AdoptCancelled
+			j.Error = err.Error()
+		default:
+			j.Status = AdoptFailed
+			j.Error = err.Error()
+		}
+	})
+	job := run.snapshot()
+	if saveErr := h.saveTransfer(job, run.token); saveErr != nil {
+		log.Printf("[Transfer] Failed to save %s %s: %v", job.Kind, job.ID, saveErr)
+	}
+	if err != nil {
+		LogError("Instance transfer failed", err, "job", job.ID, "kind", job.Kind, "phase", job.Phase)
+		return
+	}
+
+	event := EventAdminInstanceExport
+	details := map[string]interface{}{
+		"jobId": job.ID,
+		"files": job.FilesDone,
+		"bytes": job.BytesDone,
+	}
+	if job.Kind == TransferImport {
+		event = EventAdminInstanceImport
+		details["exportId"] = job.ExportID
+		details["fromArchive"] = job.FromArchive
+		details["sourceUrl"] = job.SourceURL
+		details["filesFailed"] = job.FilesFailed
+		details["filesSkipped"] = job.FilesSkipped
+	}
+	_ = h.auditHandler.LogEvent(&run.actor.UserID, run.clientIP, event, job.ID, details)
+}
+
+// ListTransfers returns recent export and import jobs
+// @Summary		Instance transfers
+// @Description	Returns recent export and import jobs with live counters
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Jobs"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/transfers [get]
+func (h *InstanceTransferHandler) ListTransfers(c echo.Context) error {
+	claims, err := RequireAdmin(c)
+	if claims == nil {
+		return err // Error response already written
+	}
+	stored, err := h.loadTransfers("")
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load transfers"))
+	}
+	jobs := make([]InstanceTransferJob, len(stored))
+	for i, t := range stored {
+		jobs[i] = liveTransfer(t.InstanceTransferJob)
+	}
+	return RespondSuccess(c, map[string]interface{}{"jobs": jobs})
+}
+
+// GetTransfer returns progress or the result of an export or import
+// @Summary		Instance transfer status
+// @Description	Returns the phase, counters and per-item results of an export or import. Initial passwords of imported users are only included while the server that ran the import is up.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Job ID"
+// @Success		200		{object}	docs.SuccessResponse	"Job status"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/transfers/{id} [get]
+func (h *InstanceTransferHandler) GetTransfer(c echo.Context) error {
+	claims, err := RequireAdmin(c)
+	if claims == nil {
+		return err // Error response already written
+	}
+	jobs, err := h.loadTransfers(`WHERE id = $1`, c.Param("id"))
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load transfer"))
+	}
+	if len(jobs) == 0 {
+		return RespondError(c, ErrNotFound("Transfer"))
+	}
+	return RespondSuccess(c, liveTransfer(jobs[0].InstanceTransferJob))
+}
+
+// ResumeTransfer runs a failed or cancelled job again from the phase it stopped in
+// @Summary		Resume an instance transfer
+// @Description	Restarts a failed or cancelled export or import. An import continues in the phase it stopped in; files already in place with the right checksum are not transferred again.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Job ID"
+// @Success		202		{object}	docs.SuccessResponse	"Job resumed"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Job is running or completed, or another one is running"
+// @Security	BearerAuth
+// @Router		/admin/transfers/{id}/resume [post]
+func (h *InstanceTransferHandler) ResumeTransfer(c echo.Context) error {
+	claims, err := RequireAdmin(c)
+	if claims == nil {
+		return err // Error response already written
+	}
+	jobs, err := h.loadTransfers(`WHERE id = $1`, c.Param("id"))
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load transfer"))
+	}
+	if len(jobs) == 0 {
+		return RespondError(c, ErrNotFound("Transfer"))
+	}
+	t := jobs[0]
+	if t.Status == AdoptRunning || t.Status == AdoptCompleted {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "Only failed or cancelled transfers can be resumed"))
+	}
+	if run := runningTransfer(t.Kind); run != nil {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "Another "+t.Kind+" is running").WithDetails(run.snapshot()))
+	}
+
+	t.Status, t.Error, t.FinishedAt = AdoptRunning, "", nil
+	if err := h.saveTransfer(t.InstanceTransferJob, t.token); err != nil {
+		return RespondError(c, ErrInternal("Failed to resume transfer"))
+	}
+	run := h.startTransfer(t.InstanceTransferJob, t.token, claims, c.RealIP())
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data":    run.snapshot(),
+	})
+}
+
+// liveTransfer overlays the in-memory state of a running or recent job
+func liveTransfer(job InstanceTransferJob) InstanceTransferJob {
+	transferRunsMu.Lock()
+	run := transferRuns[job.ID]
+	transferRunsMu.Unlock()
+	if run == nil {
+		return job
+	}
+	return run.snapshot()
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func transferManifest() *InstanceManifest {
+	return &InstanceManifest{
+		Version: instanceManifestVersion,
+		Users:   []ExportUser{{Username: "alice"}, {Username: "bob"}},
+		SharedFolders: []ExportSharedFolder{
+			{Name: "Team: Design", Members: []ProvisionMember{{Username: "bob", PermissionLevel: 2}}},
+			{Name: "Archive"},
+		},
+	}
+}
+
+func TestImportPlan_MapsPaths(t *testing.T) {
+	plan := newImportPlan(transferManifest(), map[string]ImportResolution{
+		"user:alice":           {Action: ImportRename, Name: "alice-old"},
+		"sharedFolder:Archive": {Action: ImportSkip},
+	})
+
+	for _, c := range []struct{ in, want string }{
+		{"users/alice/docs/a.txt", "users/alice-old/docs/a.txt"},
+		{"users/bob/b.txt", "users/bob/b.txt"},
+		{"shared/Team_ Design/plan.pdf", "shared/Team_ Design/plan.pdf"},
+		{"shared/Archive/old.zip", ""},
+		{"users/carol/c.txt", ""},
+	} {
+		got, ok := plan.dataPath(c.in)
+		if got != c.want || ok != (c.want != "") {
+			t.Errorf("dataPath(%q) = %q, %v; want %q", c.in, got, ok, c.want)
+		}
+	}
+	if got, ok := plan.displayPath("/home/docs/a.txt"); !ok || got != "/home/docs/a.txt" {
+		t.Errorf("home display path = %q, %v", got, ok)
+	}
+	if _, ok := plan.displayPath("/shared/Archive/old.zip"); ok {
+		t.Error("display path in a skipped drive was mapped")
+	}
+}
+
+func TestValidateInstanceManifest(t *testing.T) {
+	m := transferManifest()
+	m.Files = []ExportFile{
+		{Path: "users/alice/a.txt", SHA256: hex.EncodeToString(make([]byte, 32))},
+		{Path: "users/alice/../../etc/passwd", SHA256: hex.EncodeToString(make([]byte, 32))},
+		{Path: "users/carol/c.txt", SHA256: hex.EncodeToString(make([]byte, 32))},
+		{Path: "shared/Archive/x", SHA256: "short"},
+	}
+	problems := validateInstanceManifest(m)
+	if len(problems) != 3 {
+		t.Fatalf("problems = %+v, want 3", problems)
+	}
+
+	m.Version = 99
+	if problems := validateInstanceManifest(m); len(problems) != 1 || problems[0].Item != "manifest" {
+		t.Errorf("version problems = %+v", problems)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &InstanceTransferHandler{db: tc.DB}
+	exists := func(table, name string, found bool) {
+		tc.Mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM " + table).WithArgs(name).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(found))
+	}
+	exists("users", "alice", true)
+	exists("users", "alice-2", false)
+	exists("users", "alice-old", false)
+	exists("users", "bob", false)
+	exists("shared_folders", "Team: Design", true)
+	exists("shared_folders", "Team: Design-2", false)
+	exists("shared_folders", "Archive", false)
+
+	conflicts, problems, err := h.importConflicts(transferManifest(), map[string]ImportResolution{
+		"user:alice": {Action: ImportRename, Name: "alice-old"},
+	})
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("err = %v, problems = %+v", err, problems)
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("conflicts = %+v", conflicts)
+	}
+	if c := conflicts[0]; c.Item != "user:alice" || c.SuggestedName != "alice-2" || c.Resolution == nil || c.Resolution.Name != "alice-old" {
+		t.Errorf("user conflict = %+v", c)
+	}
+	if c := conflicts[1]; c.Item != "sharedFolder:Team: Design" || c.SuggestedName != "Team: Design-2" || c.Resolution != nil {
+		t.Errorf("drive conflict = %+v", c)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// writeTransferArchive writes an export archive holding files
+func writeTransferArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "archive.zip")
+	out, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(out)
+	for name, content := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	return p
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestImportFile_VerifiesChecksum(t *testing.T) {
+	dataRoot := t.TempDir()
+	h := &InstanceTransferHandler{dataRoot: dataRoot}
+	source, err := openArchiveSource(writeTransferArchive(t, map[string]string{
+		"users/alice/docs/a.txt": "hello",
+		"users/alice/b.txt":      "tampered",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	modTime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	good := ExportFile{Path: "users/alice/docs/a.txt", Size: 5, ModTime: modTime, SHA256: sha256Hex("hello")}
+	if err := h.importFile(context.Background(), source, good, "users/alice-old/docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dataRoot, "users", "alice-old", "docs", "a.txt")
+	if data, _ := os.ReadFile(dst); string(data) != "hello" {
+		t.Errorf("imported content = %q", data)
+	}
+	if info, _ := os.Stat(dst); !info.ModTime().Equal(modTime) {
+		t.Errorf("mtime = %v, want %v", info.ModTime(), modTime)
+	}
+	// Resuming finds the file in place
+	if err := h.importFile(context.Background(), source, good, "users/alice-old/docs/a.txt"); err != nil {
+		t.Errorf("re-import: %v", err)
+	}
+
+	bad := ExportFile{Path: "users/alice/b.txt", Size: 8, SHA256: sha256Hex("original")}
+	if err := h.importFile(context.Background(), source, bad, "users/alice-old/b.txt"); err == nil {
+		t.Error("checksum mismatch was accepted")
+	}
+	entries, _ := os.ReadDir(filepath.Join(dataRoot, "users", "alice-old"))
+	for _, e := range entries {
+		if e.Name() != "docs" {
+			t.Errorf("left behind %s", e.Name())
+		}
+	}
+
+	// A different file already here is kept
+	if err := os.WriteFile(dst, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.importFile(context.Background(), source, good, "users/alice-old/docs/a.txt"); err == nil {
+		t.Error("existing file with other content was not reported")
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "local" {
+		t.Errorf("existing file overwritten: %q", data)
+	}
+}
+
+func TestExportInventory(t *testing.T) {
+	dataRoot := t.TempDir()
+	home := filepath.Join(dataRoot, "users", "alice")
+	for name, content := range map[string]string{
+		"a.txt":            "hello",
+		"docs/b.txt":       "world",
+		".trash/gone.txt":  "deleted",
+		".uploads/part.01": "partial",
+	} {
+		p := filepath.Join(home, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &InstanceTransferHandler{dataRoot: dataRoot}
+	run := &transferRun{}
+
+	files, err := h.exportInventory("users/alice", nil, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Path != "users/alice/a.txt" || files[1].Path != "users/alice/docs/b.txt" {
+		t.Fatalf("files = %+v", files)
+	}
+	if files[0].Size != 5 || files[0].SHA256 != sha256Hex("hello") {
+		t.Errorf("a.txt = %+v", files[0])
+	}
+	if job := run.snapshot(); job.FilesDone != 2 || job.BytesDone != 10 {
+		t.Errorf("counters = %d files, %d bytes", job.FilesDone, job.BytesDone)
+	}
+
+	// A home folder that was never created has no files
+	if files, err := h.exportInventory("users/nobody", nil, run); err != nil || len(files) != 0 {
+		t.Errorf("missing home = %+v, %v", files, err)
+	}
+}
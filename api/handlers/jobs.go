@@ -0,0 +1,750 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Background jobs. Work that outlives a request or runs on a schedule is a
+// row in the jobs table, run by the handler of a registered job type. The
+// dispatcher claims queued jobs up to each type's concurrency, the handler
+// reports progress, failures are retried with exponential backoff, and
+// users can cancel their jobs. Jobs running when the server stops are
+// marked interrupted and requeued at the next start if their type is
+// idempotent, or failed otherwise.
+
+// Job states
+const (
+	JobQueued      = "queued"
+	JobRunning     = "running"
+	JobSucceeded   = "succeeded"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+	JobInterrupted = "interrupted"
+)
+
+const (
+	// jobsPollInterval is how often the dispatcher looks for due jobs
+	// besides being woken by new ones
+	jobsPollInterval = 5 * time.Second
+	// jobsProgressInterval throttles progress writes of a running job
+	jobsProgressInterval = 2 * time.Second
+	// jobsSweepInterval is how often finished jobs past retention are deleted
+	jobsSweepInterval = time.Hour
+	// jobsKeepSucceeded bounds the succeeded jobs kept per type, so frequent
+	// scheduled jobs don't crowd the list
+	jobsKeepSucceeded = 50
+
+	settingJobsRetentionDays = "jobs_retention_days"
+)
+
+var (
+	errJobCancelled = errors.New("cancelled")
+	errJobShutdown  = errors.New("server shutting down")
+)
+
+// permanentJobError marks a failure retrying won't fix
+type permanentJobError struct{ error }
+
+func (e permanentJobError) Unwrap() error { return e.error }
+
+// JobPermanent wraps a job error so the job fails without further attempts
+func JobPermanent(err error) error {
+	return permanentJobError{err}
+}
+
+// JobHandler runs one attempt of a job. It should stop soon after the run's
+// context is done and report progress through the run.
+type JobHandler func(run *JobRun) error
+
+// JobType describes how jobs of a type are run
+type JobType struct {
+	Name        string
+	Run         JobHandler
+	Concurrency int           // Jobs of this type running at once (default 1)
+	MaxAttempts int           // Attempts before a failure is final (default 1)
+	Backoff     time.Duration // Delay before the first retry, doubled for each further one (default 1m)
+	MaxBackoff  time.Duration // Cap of the retry delay (default 1h)
+	Idempotent  bool          // Safe to run again after an interruption
+	Priority    string        // Pacing priority of JobRun.Pace; empty for unpaced
+}
+
+// Job is a background job as listed by the jobs API
+type Job struct {
+	ID            int64           `json:"id"`
+	Type          string          `json:"type"`
+	Params        json.RawMessage `json:"params"`
+	State         string          `json:"state"`
+	Progress      json.RawMessage `json:"progress"`
+	OwnerID       *string         `json:"ownerId,omitempty"` // Unset for system jobs
+	OwnerUsername string          `json:"ownerUsername,omitempty"`
+	Attempts      int             `json:"attempts"`
+	Error         string          `json:"error,omitempty"`
+	RunAfter      time.Time       `json:"runAfter"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	StartedAt     *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// JobRun is one attempt of a job, handed to its type's handler
+type JobRun struct {
+	ID      int64
+	Type    string
+	Params  json.RawMessage
+	OwnerID *string
+	Attempt int // 1 for the first run
+
+	ctx  context.Context
+	db   *sql.DB
+	pace *PaceJob
+
+	mu       sync.Mutex
+	progress json.RawMessage
+	savedAt  time.Time
+}
+
+// Context is cancelled when the job is cancelled or the server shuts down
+func (r *JobRun) Context() context.Context {
+	return r.ctx
+}
+
+// Decode unmarshals the job's params
+func (r *JobRun) Decode(v interface{}) error {
+	return json.Unmarshal(r.Params, v)
+}
+
+// Pace yields to foreground requests for job types with a pacing priority
+// and returns an error once the job should stop
+func (r *JobRun) Pace() error {
+	if err := r.pace.PaceContext(r.ctx); err != nil {
+		return err
+	}
+	return r.ctx.Err()
+}
+
+// SetProgress records the job's progress, any JSON value. Writes are
+// throttled; the last progress is saved when the job ends.
+func (r *JobRun) SetProgress(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	r.progress = data
+	due := time.Since(r.savedAt) >= jobsProgressInterval
+	if due {
+		r.savedAt = time.Now()
+	}
+	r.mu.Unlock()
+	if due && r.db != nil {
+		if _, err := r.db.Exec(`UPDATE jobs SET progress = $2, updated_at = NOW() WHERE id = $1`, r.ID, data); err != nil {
+			log.Printf("[Jobs] Failed to save progress of job %d: %v", r.ID, err)
+		}
+	}
+}
+
+// lastProgress returns the progress to save when the job ends, nil if none was set
+func (r *JobRun) lastProgress() interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil {
+		return nil
+	}
+	return []byte(r.progress)
+}
+
+// Jobs is the job queue and worker pool
+type Jobs struct {
+	db   *sql.DB
+	wake chan struct{}
+	ctx  context.Context
+	stop context.CancelCauseFunc
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	types    map[string]*JobType
+	running  map[int64]context.CancelCauseFunc
+	active   map[string]int // Running jobs per type
+	stopping bool
+}
+
+var globalJobs *Jobs
+
+// InitJobs creates the global job queue, marks jobs left running by the
+// last process interrupted and starts the dispatcher. Types register
+// afterwards; their interrupted jobs are handled on registration.
+func InitJobs(db *sql.DB) *Jobs {
+	j := newJobs(db)
+	if _, err := db.Exec(`UPDATE jobs SET state = $1, updated_at = NOW() WHERE state = $2`, JobInterrupted, JobRunning); err != nil {
+		log.Printf("[Jobs] Failed to mark interrupted jobs: %v", err)
+	}
+	globalJobs = j
+	go j.dispatch()
+	go j.sweep()
+	return j
+}
+
+func newJobs(db *sql.DB) *Jobs {
+	ctx, stop := context.WithCancelCause(context.Background())
+	return &Jobs{
+		db:      db,
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		stop:    stop,
+		types:   make(map[string]*JobType),
+		running: make(map[int64]context.CancelCauseFunc),
+		active:  make(map[string]int),
+	}
+}
+
+// GetJobs returns the global job queue (nil if not initialized)
+func GetJobs() *Jobs {
+	return globalJobs
+}
+
+// Register adds a job type. Its jobs interrupted by a restart are requeued
+// if the type is idempotent and failed otherwise.
+func (j *Jobs) Register(t JobType) {
+	if j == nil {
+		return
+	}
+	if t.Concurrency < 1 {
+		t.Concurrency = 1
+	}
+	if t.MaxAttempts < 1 {
+		t.MaxAttempts = 1
+	}
+	if t.Backoff <= 0 {
+		t.Backoff = time.Minute
+	}
+	if t.MaxBackoff <= 0 {
+		t.MaxBackoff = time.Hour
+	}
+	j.mu.Lock()
+	j.types[t.Name] = &t
+	j.mu.Unlock()
+
+	var err error
+	if t.Idempotent {
+		_, err = j.db.Exec(`UPDATE jobs SET state = $1, run_after = NOW(), updated_at = NOW() WHERE type = $2 AND state = $3`,
+			JobQueued, t.Name, JobInterrupted)
+	} else {
+		_, err = j.db.Exec(`
+			UPDATE jobs SET state = $1, error = 'interrupted by a restart', finished_at = NOW(), updated_at = NOW()
+			WHERE type = $2 AND state = $3
+		`, JobFailed, t.Name, JobInterrupted)
+	}
+	if err != nil {
+		log.Printf("[Jobs] Failed to recover interrupted %s jobs: %v", t.Name, err)
+	}
+	j.Wake()
+}
+
+// Enqueue adds a job of a registered type; ownerID is nil for system jobs
+func (j *Jobs) Enqueue(jobType string, params interface{}, ownerID *string) (int64, error) {
+	if j == nil {
+		return 0, errors.New("background jobs are not initialized")
+	}
+	if params == nil {
+		params = struct{}{}
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := j.db.QueryRow(`INSERT INTO jobs (type, params, owner_id) VALUES ($1, $2, $3) RETURNING id`,
+		jobType, data, ownerID).Scan(&id); err != nil {
+		return 0, err
+	}
+	j.Wake()
+	return id, nil
+}
+
+// Schedule enqueues a system job of a type every interval, and right away
+// if runNow is set, unless one is already queued or running
+func (j *Jobs) Schedule(jobType string, every time.Duration, runNow bool) {
+	if j == nil {
+		return
+	}
+	go func() {
+		if runNow {
+			j.enqueueIfIdle(jobType)
+		}
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.ctx.Done():
+				return
+			case <-ticker.C:
+				j.enqueueIfIdle(jobType)
+			}
+		}
+	}()
+}
+
+// enqueueIfIdle enqueues a system job unless one of the type is pending
+func (j *Jobs) enqueueIfIdle(jobType string) {
+	res, err := j.db.Exec(`
+		INSERT INTO jobs (type) SELECT $1
+		WHERE NOT EXISTS (SELECT 1 FROM jobs WHERE type = $1 AND state IN ($2, $3))
+	`, jobType, JobQueued, JobRunning)
+	if err != nil {
+		log.Printf("[Jobs] Failed to schedule %s: %v", jobType, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		j.Wake()
+	}
+}
+
+// Wake makes the dispatcher look for due jobs now
+func (j *Jobs) Wake() {
+	if j == nil {
+		return
+	}
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Cancel stops a running job or drops a queued one. It reports false if
+// the job is not queued or running.
+func (j *Jobs) Cancel(id int64) (bool, error) {
+	for range 2 {
+		j.mu.Lock()
+		cancel := j.running[id]
+		j.mu.Unlock()
+		if cancel != nil {
+			cancel(errJobCancelled)
+			return true, nil
+		}
+		res, err := j.db.Exec(`
+			UPDATE jobs SET state = $2, finished_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND state IN ($3, $4)
+		`, id, JobCancelled, JobQueued, JobInterrupted)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return true, nil
+		}
+		// The job may have been claimed in between; look again once
+	}
+	return false, nil
+}
+
+// Stop cancels running jobs and waits up to timeout for them to end; they
+// are recorded as interrupted. Jobs still running afterwards are marked
+// interrupted at the next start.
+func (j *Jobs) Stop(timeout time.Duration) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.stopping = true
+	j.mu.Unlock()
+	j.stop(errJobShutdown)
+
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("[Jobs] Shutdown: jobs still running after %v", timeout)
+	}
+}
+
+// dispatch claims due jobs whenever woken, and periodically
+func (j *Jobs) dispatch() {
+	ticker := time.NewTicker(jobsPollInterval)
+	defer ticker.Stop()
+	for {
+		j.claimDue()
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-j.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimDue starts due jobs of every registered type with free capacity
+func (j *Jobs) claimDue() {
+	j.mu.Lock()
+	types := make([]*JobType, 0, len(j.types))
+	for _, t := range j.types {
+		types = append(types, t)
+	}
+	j.mu.Unlock()
+	sort.Slice(types, func(a, b int) bool { return types[a].Name < types[b].Name })
+
+	for _, t := range types {
+		for j.hasCapacity(t) {
+			run, err := j.claim(t)
+			if err != nil {
+				if err != sql.ErrNoRows {
+					log.Printf("[Jobs] Failed to claim %s job: %v", t.Name, err)
+				}
+				break
+			}
+			j.start(t, run)
+		}
+	}
+}
+
+func (j *Jobs) hasCapacity(t *JobType) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.stopping && j.active[t.Name] < t.Concurrency
+}
+
+// claim takes the oldest due job of a type and counts the attempt
+func (j *Jobs) claim(t *JobType) (*JobRun, error) {
+	run := &JobRun{Type: t.Name, db: j.db}
+	err := j.db.QueryRow(`
+		UPDATE jobs SET state = $1, attempts = attempts + 1, started_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE type = $2 AND state = $3 AND run_after <= NOW()
+			ORDER BY run_after, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, params, owner_id, attempts
+	`, JobRunning, t.Name, JobQueued).Scan(&run.ID, &run.Params, &run.OwnerID, &run.Attempt)
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// start runs a claimed job in its own goroutine
+func (j *Jobs) start(t *JobType, run *JobRun) {
+	ctx, cancel := context.WithCancelCause(j.ctx)
+	run.ctx = ctx
+	j.mu.Lock()
+	j.running[run.ID] = cancel
+	j.active[t.Name]++
+	j.mu.Unlock()
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		defer func() {
+			j.mu.Lock()
+			delete(j.running, run.ID)
+			j.active[t.Name]--
+			j.mu.Unlock()
+			cancel(nil)
+			j.Wake()
+		}()
+		j.execute(t, run)
+	}()
+}
+
+// execute runs one attempt and records its outcome
+func (j *Jobs) execute(t *JobType, run *JobRun) {
+	if t.Priority != "" {
+		run.pace = GetBackgroundPacer().Begin(t.Name, t.Priority)
+		defer run.pace.End()
+	}
+	err := runJobHandler(t, run)
+	if err != nil {
+		log.Printf("[Jobs] %s job %d, attempt %d: %v", t.Name, run.ID, run.Attempt, err)
+	}
+	state, retryAt := jobOutcome(t, run.Attempt, err, context.Cause(run.ctx), time.Now())
+	j.finish(run, state, retryAt, err)
+}
+
+// runJobHandler calls the handler, turning a panic into an error
+func runJobHandler(t *JobType, run *JobRun) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return t.Run(run)
+}
+
+// jobOutcome decides the state after an attempt: cancelled and interrupted
+// jobs are recorded as such, failures are requeued with backoff until the
+// type's attempt limit unless they are permanent
+func jobOutcome(t *JobType, attempt int, err, cause error, now time.Time) (string, time.Time) {
+	switch {
+	case err == nil:
+		return JobSucceeded, time.Time{}
+	case errors.Is(cause, errJobCancelled):
+		return JobCancelled, time.Time{}
+	case errors.Is(cause, errJobShutdown):
+		return JobInterrupted, time.Time{}
+	case errors.As(err, &permanentJobError{}) || attempt >= t.MaxAttempts:
+		return JobFailed, time.Time{}
+	}
+	return JobQueued, now.Add(jobBackoff(t, attempt))
+}
+
+// jobBackoff is the delay before retrying after the given attempt
+func jobBackoff(t *JobType, attempt int) time.Duration {
+	delay := t.Backoff
+	for i := 1; i < attempt && delay < t.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, t.MaxBackoff)
+}
+
+// finish records the outcome of an attempt
+func (j *Jobs) finish(run *JobRun, state string, retryAt time.Time, runErr error) {
+	var message *string
+	if runErr != nil && state != JobSucceeded {
+		text := runErr.Error()
+		message = &text
+	}
+	var err error
+	if state == JobQueued {
+		_, err = j.db.Exec(`
+			UPDATE jobs SET state = $2, error = $3, run_after = $4, progress = COALESCE($5, progress), updated_at = NOW()
+			WHERE id = $1
+		`, run.ID, state, message, retryAt, run.lastProgress())
+	} else {
+		var finishedAt *time.Time
+		if state != JobInterrupted {
+			now := time.Now()
+			finishedAt = &now
+		}
+		_, err = j.db.Exec(`
+			UPDATE jobs SET state = $2, error = $3, finished_at = $4, progress = COALESCE($5, progress), updated_at = NOW()
+			WHERE id = $1
+		`, run.ID, state, message, finishedAt, run.lastProgress())
+	}
+	if err != nil {
+		log.Printf("[Jobs] Failed to record %s job %d as %s: %v", run.Type, run.ID, state, err)
+	}
+}
+
+// sweep deletes finished jobs past jobs_retention_days, keeping at most
+// jobsKeepSucceeded succeeded jobs per type
+func (j *Jobs) sweep() {
+	ticker := time.NewTicker(jobsSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		days := 7
+		if sh := GetGlobalSettingsHandler(); sh != nil {
+			days = sh.GetSettingInt(settingJobsRetentionDays, days)
+		}
+		if _, err := j.db.Exec(`
+			DELETE FROM jobs WHERE finished_at < NOW() - make_interval(days => $1)
+		`, max(days, 1)); err != nil {
+			log.Printf("[Jobs] Failed to delete old jobs: %v", err)
+		}
+		if _, err := j.db.Exec(`
+			DELETE FROM jobs WHERE id IN (
+				SELECT id FROM (
+					SELECT id, ROW_NUMBER() OVER (PARTITION BY type ORDER BY id DESC) AS n
+					FROM jobs WHERE state = $1
+				) ranked WHERE n > $2
+			)
+		`, JobSucceeded, jobsKeepSucceeded); err != nil {
+			log.Printf("[Jobs] Failed to trim succeeded jobs: %v", err)
+		}
+	}
+}
+
+const jobColumns = `j.id, j.type, j.params, j.state, j.progress, j.owner_id, COALESCE(u.username, ''),
+	j.attempts, j.error, j.run_after, j.created_at, j.started_at, j.finished_at`
+
+// scanJob scans a row selected with jobColumns from jobs j LEFT JOIN users u
+func scanJob(scan func(dest ...interface{}) error) (*Job, error) {
+	var job Job
+	var params, progress []byte
+	var jobError sql.NullString
+	var createdAt, startedAt, finishedAt sql.NullTime
+	if err := scan(&job.ID, &job.Type, &params, &job.State, &progress, &job.OwnerID, &job.OwnerUsername,
+		&job.Attempts, &jobError, &job.RunAfter, &createdAt, &startedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	job.Params, job.Progress = json.RawMessage(params), json.RawMessage(progress)
+	job.Error, job.CreatedAt = jobError.String, createdAt.Time
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+// listJobs lists jobs, newest first, of an owner or of everyone
+func (h *Handler) listJobs(c echo.Context, ownerID string) error {
+	limit := 50
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	rows, err := h.db.Query(`
+		SELECT `+jobColumns+` FROM jobs j LEFT JOIN users u ON u.id = j.owner_id
+		WHERE ($1 = '' OR j.owner_id::text = $1) AND ($2 = '' OR j.state = $2) AND ($3 = '' OR j.type = $3)
+		ORDER BY j.id DESC LIMIT $4
+	`, ownerID, c.QueryParam("state"), c.QueryParam("type"), limit)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list jobs", err))
+	}
+	defer rows.Close()
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows.Scan)
+		if err != nil {
+			return RespondError(c, ErrOperationFailed("list jobs", err))
+		}
+		jobs = append(jobs, job)
+	}
+	return RespondSuccess(c, jobs)
+}
+
+// ListMyJobs lists the caller's background jobs
+// @Summary		List my jobs
+// @Description	Background jobs started by the caller, newest first. Filter with state and type; limit defaults to 50. progress is the job type's own JSON.
+// @Tags		Jobs
+// @Produce		json
+// @Param		state	query		string	false	"queued, running, succeeded, failed, cancelled or interrupted"
+// @Param		type	query		string	false	"Job type"
+// @Param		limit	query		int		false	"Maximum jobs (up to 500)"
+// @Success		200		{array}		Job		"Jobs"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/jobs [get]
+func (h *Handler) ListMyJobs(c echo.Context) error {
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+	return h.listJobs(c, claims.UserID)
+}
+
+// GetJob returns one background job with its progress
+// @Summary		Get job
+// @Description	One of the caller's jobs; storage admins can read any job. Poll it for the progress of a long operation such as a bulk trash restore.
+// @Tags		Jobs
+// @Produce		json
+// @Param		id	path		int	true	"Job ID"
+// @Success		200	{object}	Job	"Job"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/jobs/{id} [get]
+func (h *Handler) GetJob(c echo.Context) error {
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Invalid job ID"))
+	}
+
+	job, err := scanJob(h.db.QueryRow(`SELECT `+jobColumns+` FROM jobs j LEFT JOIN users u ON u.id = j.owner_id WHERE j.id = $1`, id).Scan)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Job"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load job", err))
+	}
+	// Other users' jobs don't exist for non-admins
+	if (job.OwnerID == nil || *job.OwnerID != claims.UserID) && !claims.HasPermission(PermStorageAdmin) {
+		return RespondError(c, ErrNotFound("Job"))
+	}
+	return RespondSuccess(c, job)
+}
+
+// ListAllJobs lists every background job, system jobs included
+// @Summary		List all jobs
+// @Description	Background jobs of all users and the system (scheduled cleanups and syncs), newest first, with the same filters as /jobs.
+// @Tags		Admin
+// @Produce		json
+// @Param		state	query		string	false	"Job state"
+// @Param		type	query		string	false	"Job type"
+// @Param		limit	query		int		false	"Maximum jobs (up to 500)"
+// @Success		200		{array}		Job		"Jobs"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/jobs [get]
+func (h *Handler) ListAllJobs(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	return h.listJobs(c, "")
+}
+
+// CancelJob cancels a queued or running job
+// @Summary		Cancel job
+// @Description	Cancels one of the caller's jobs; storage admins can cancel any job. A queued job is dropped; a running job is stopped at its next checkpoint and recorded as cancelled.
+// @Tags		Jobs
+// @Produce		json
+// @Param		id	path		int	true	"Job ID"
+// @Success		200	{object}	docs.SuccessResponse	"Cancellation requested"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Failure		409	{object}	docs.ErrorResponse	"Job already finished"
+// @Security	BearerAuth
+// @Router		/jobs/{id} [delete]
+func (h *Handler) CancelJob(c echo.Context) error {
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Invalid job ID"))
+	}
+
+	var jobType, state string
+	var ownerID *string
+	err = h.db.QueryRow(`SELECT type, state, owner_id FROM jobs WHERE id = $1`, id).Scan(&jobType, &state, &ownerID)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Job"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load job", err))
+	}
+	// Other users' jobs don't exist for non-admins
+	if (ownerID == nil || *ownerID != claims.UserID) && !claims.HasPermission(PermStorageAdmin) {
+		return RespondError(c, ErrNotFound("Job"))
+	}
+
+	cancelled, err := GetJobs().Cancel(id)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("cancel job", err))
+	}
+	if !cancelled {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "The job has already finished").
+			WithDetails(map[string]interface{}{"state": state}))
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventJobCancel, fmt.Sprintf("/jobs/%d", id), map[string]interface{}{
+		"jobId": id,
+		"type":  jobType,
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"id":      id,
+	})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func useTestJobs(t *testing.T, j *Jobs) {
+	t.Helper()
+	prev := globalJobs
+	globalJobs = j
+	t.Cleanup(func() { globalJobs = prev })
+}
+
+func TestJobOutcome(t *testing.T) {
+	jt := &JobType{Name: "test", MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: 3 * time.Minute}
+	now := time.Now()
+	failure := errors.New("disk busy")
+
+	cases := []struct {
+		name    string
+		attempt int
+		err     error
+		cause   error
+		state   string
+		delay   time.Duration
+	}{
+		{"success", 1, nil, nil, JobSucceeded, 0},
+		{"first failure", 1, failure, nil, JobQueued, time.Minute},
+		{"second failure", 2, failure, nil, JobQueued, 2 * time.Minute},
+		{"last attempt", 3, failure, nil, JobFailed, 0},
+		{"permanent", 1, JobPermanent(failure), nil, JobFailed, 0},
+		{"cancelled", 1, context.Canceled, errJobCancelled, JobCancelled, 0},
+		{"shutdown", 1, context.Canceled, errJobShutdown, JobInterrupted, 0},
+	}
+	for _, tt := range cases {
+		state, retryAt := jobOutcome(jt, tt.attempt, tt.err, tt.cause, now)
+		if state != tt.state {
+			t.Errorf("%s: state = %s, want %s", tt.name, state, tt.state)
+		}
+		if tt.delay > 0 && !retryAt.Equal(now.Add(tt.delay)) {
+			t.Errorf("%s: retry in %v, want %v", tt.name, retryAt.Sub(now), tt.delay)
+		}
+	}
+
+	// The delay doubles up to the cap
+	if d := jobBackoff(jt, 5); d != 3*time.Minute {
+		t.Errorf("backoff after attempt 5 = %v", d)
+	}
+}
+
+func TestJobsExecute(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	j := newJobs(tc.DB)
+
+	// A failed attempt is requeued with its last progress
+	jt := &JobType{Name: "test", MaxAttempts: 2, Backoff: time.Minute, MaxBackoff: time.Hour,
+		Run: func(run *JobRun) error {
+			run.SetProgress(map[string]int{"done": 1})
+			return errors.New("disk busy")
+		}}
+	tc.Mock.ExpectExec("UPDATE jobs SET progress").WithArgs(int64(7), []byte(`{"done":1}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("UPDATE jobs SET state = \\$2, error = \\$3, run_after = \\$4").
+		WithArgs(int64(7), JobQueued, "disk busy", sqlmock.AnyArg(), []byte(`{"done":1}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	j.execute(jt, &JobRun{ID: 7, Type: "test", Attempt: 1, ctx: context.Background(), db: tc.DB})
+
+	// A panic fails the job instead of the server
+	jt.Run = func(run *JobRun) error { panic("boom") }
+	tc.Mock.ExpectExec("UPDATE jobs SET state = \\$2, error = \\$3, finished_at = \\$4").
+		WithArgs(int64(8), JobFailed, "panic: boom", sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	j.execute(jt, &JobRun{ID: 8, Type: "test", Attempt: 2, ctx: context.Background(), db: tc.DB})
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJobsCancelRunning(t *testing.T) {
+	j := newJobs(nil)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	j.running[3] = cancel
+
+	if ok, err := j.Cancel(3); !ok || err != nil {
+		t.Fatalf("Cancel = %v, %v", ok, err)
+	}
+	if !errors.Is(context.Cause(ctx), errJobCancelled) {
+		t.Errorf("cause = %v", context.Cause(ctx))
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useTestJobs(t, newJobs(tc.DB))
+	h := &Handler{db: tc.DB, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+
+	cancel := func(claims *JWTClaims) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, _ := NewJSONRequest(http.MethodDelete, "/api/jobs/5", nil)
+		c := tc.Echo.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("5")
+		c.Set("user", claims)
+		if err := h.CancelJob(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	expectJob := func(state string) {
+		tc.Mock.ExpectQuery("SELECT type, state, owner_id FROM jobs").WithArgs(int64(5)).
+			WillReturnRows(sqlmock.NewRows([]string{"type", "state", "owner_id"}).AddRow("test", state, "u1"))
+	}
+	owner := &JWTClaims{UserID: "u1", Username: "alice"}
+
+	// Someone else's job is not found
+	expectJob(JobQueued)
+	AssertStatus(t, cancel(&JWTClaims{UserID: "u2"}), http.StatusNotFound)
+
+	// The owner drops a queued job
+	expectJob(JobQueued)
+	tc.Mock.ExpectExec("UPDATE jobs SET state = \\$2").WithArgs(int64(5), JobCancelled, JobQueued, JobInterrupted).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	AssertStatus(t, cancel(owner), http.StatusOK)
+
+	// A finished job can't be cancelled
+	expectJob(JobSucceeded)
+	for range 2 {
+		tc.Mock.ExpectExec("UPDATE jobs SET state = \\$2").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	AssertStatus(t, cancel(owner), http.StatusConflict)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listingVersionTTL bounds how long a directory listing may be answered with
+// 304 Not Modified without a change being seen, as a fallback for changes
+// nothing reports (folders the watcher could not watch, for one)
+const listingVersionTTL = time.Minute
+
+// listingVersionsMax bounds the directories tracked; past it all are dropped
+const listingVersionsMax = 10000
+
+// ListingVersions hands out a change generation per listed directory. The
+// generation is part of the listing's ETag and is replaced whenever the API
+// or the file watcher reports a change in the directory, so a repeated
+// listing is answered with 304 Not Modified without reading the directory.
+type ListingVersions struct {
+	mu   sync.Mutex
+	next uint64
+	dirs map[string]listingVersion
+}
+
+type listingVersion struct {
+	gen    uint64
+	issued time.Time
+}
+
+// Generations start at the process start time so ETags of an earlier run
+// never match
+var globalListingVersions = &ListingVersions{
+	next: uint64(time.Now().UnixNano()),
+	dirs: make(map[string]listingVersion),
+}
+
+// GetListingVersions returns the global listing versions
+func GetListingVersions() *ListingVersions {
+	return globalListingVersions
+}
+
+// Version returns the current generation of a directory
+func (v *ListingVersions) Version(dirRealPath string) uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	dirRealPath = filepath.Clean(dirRealPath)
+	if cur, ok := v.dirs[dirRealPath]; ok && time.Since(cur.issued) < listingVersionTTL {
+		return cur.gen
+	}
+	if len(v.dirs) >= listingVersionsMax {
+		v.dirs = make(map[string]listingVersion)
+	}
+	v.next++
+	v.dirs[dirRealPath] = listingVersion{gen: v.next, issued: time.Now()}
+	return v.next
+}
+
+// Invalidate records a change at each path: the listing of its parent, of
+// the path itself and of everything below it changes. Empty paths are
+// ignored, so a move can pass both of its paths.
+func (v *ListingVersions) Invalidate(realPaths ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, p := range realPaths {
+		if p == "" {
+			continue
+		}
+		p = filepath.Clean(p)
+		delete(v.dirs, filepath.Dir(p))
+		prefix := p + string(filepath.Separator)
+		for dir := range v.dirs {
+			if dir == p || strings.HasPrefix(dir, prefix) {
+				delete(v.dirs, dir)
+			}
+		}
+	}
+}
+
+// InvalidateAll changes every listing, for settings that shape all of them
+func (v *ListingVersions) InvalidateAll() {
+	v.mu.Lock()
+	v.dirs = make(map[string]listingVersion)
+	v.mu.Unlock()
+}
+
+// listingETag identifies one listing response: the directory, its state and
+// generation, the user it was made for (home folders, links and permissions
+// differ per user) and the query parameters that shape it
+func listingETag(dirRealPath string, info os.FileInfo, claims *JWTClaims, query url.Values) string {
+	userID := ""
+	if claims != nil {
+		userID = claims.UserID
+	}
+	shape := url.Values{}
+	for _, key := range []string{"sort", "order", "page", "pageSize", "includeActions"} {
+		if value := query.Get(key); value != "" {
+			shape.Set(key, value)
+		}
+	}
+	data := fmt.Sprintf("%s:%d:%d:%d:%s:%s", dirRealPath, GetListingVersions().Version(dirRealPath),
+		info.ModTime().UnixNano(), info.Size(), userID, shape.Encode())
+	hash := md5.Sum([]byte(data))
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(hash[:]))
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListFiles_ETagRevalidation(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	useLocalStatsCache(t)
+	writeTestFiles(t, home, "docs/a.txt")
+	docs := filepath.Join(home, "docs")
+
+	list := func(userID, username, ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/files?path=/home/docs", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		c := CreateAuthenticatedContext(tc.Echo, rec, req, userID, username, false)
+		if err := h.ListFiles(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	first := list("u1", "alice", "")
+	AssertStatus(t, first, http.StatusOK)
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("listing has no ETag")
+	}
+
+	if rec := list("u1", "alice", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("unchanged listing: status %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+
+	// A write in place leaves the directory's mtime alone; the reported
+	// change does not
+	if err := os.WriteFile(filepath.Join(docs, "a.txt"), []byte("longer content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	GetListingVersions().Invalidate(filepath.Join(docs, "a.txt"))
+	second := list("u1", "alice", etag)
+	AssertStatus(t, second, http.StatusOK)
+	if second.Header().Get("ETag") == etag {
+		t.Error("ETag unchanged after a change in the directory")
+	}
+
+	// Moving the directory's parent away changes its listing too
+	etag = second.Header().Get("ETag")
+	GetListingVersions().Invalidate(home)
+	if rec := list("u1", "alice", etag); rec.Code != http.StatusOK {
+		t.Errorf("listing below a moved folder: status %d", rec.Code)
+	}
+}
+
+func TestListingETag_PerUserAndQuery(t *testing.T) {
+	dir := t.TempDir()
+	info := mustStat(t, dir)
+	alice := &JWTClaims{UserID: "u1", Username: "alice"}
+	bob := &JWTClaims{UserID: "u2", Username: "bob"}
+
+	base := listingETag(dir, info, alice, map[string][]string{"path": {"/home"}, "sort": {"name"}})
+	if got := listingETag(dir, info, alice, map[string][]string{"path": {"/home"}, "sort": {"name"}, "_": {"123"}}); got != base {
+		t.Error("ETag depends on an unrelated parameter")
+	}
+	if got := listingETag(dir, info, bob, map[string][]string{"path": {"/home"}, "sort": {"name"}}); got == base {
+		t.Error("two users share an ETag")
+	}
+	if got := listingETag(dir, info, alice, map[string][]string{"path": {"/home"}, "sort": {"size"}}); got == base {
+		t.Error("two sort orders share an ETag")
+	}
+	GetListingVersions().InvalidateAll()
+	if got := listingETag(dir, info, alice, map[string][]string{"path": {"/home"}, "sort": {"name"}}); got == base {
+		t.Error("ETag unchanged after InvalidateAll")
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SMTPSettings is the SMTP configuration as shown to admins. The password is
+// never returned, only whether one is set.
+type SMTPSettings struct {
+	Host        string `json:"host"`
+	Port        string `json:"port"`
+	TLSMode     string `json:"tlsMode"`
+	From        string `json:"from"`
+	Username    string `json:"username"`
+	HasPassword bool   `json:"hasPassword"`
+	// Source is "settings", "environment" (SMTP_* variables while no host is
+	// set here) or "" when mail is not configured
+	Source     string `json:"source"`
+	Configured bool   `json:"configured"`
+}
+
+// UpdateSMTPSettingsRequest replaces the SMTP settings. An empty host turns
+// the settings off, falling back to the environment.
+type UpdateSMTPSettingsRequest struct {
+	Host     string  `json:"host"`
+	Port     string  `json:"port"`
+	TLSMode  string  `json:"tlsMode"`
+	From     string  `json:"from"`
+	Username string  `json:"username"`
+	Password *string `json:"password,omitempty"` // nil keeps the stored password
+}
+
+// ValidateSMTPSettings checks SMTP settings before they are saved
+func ValidateSMTPSettings(pending map[string]string) error {
+	if value, ok := pending[settingSMTPPort]; ok && value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid value %q for %s: expected a port number", value, settingSMTPPort)
+		}
+	}
+	if value, ok := pending[settingSMTPTLSMode]; ok && value != "" &&
+		value != SMTPTLSStartTLS && value != SMTPTLSImplicit && value != SMTPTLSNone {
+		return fmt.Errorf("invalid value %q for %s: expected starttls, tls or none", value, settingSMTPTLSMode)
+	}
+	if value, ok := pending[settingSMTPFrom]; ok && value != "" {
+		if _, err := mail.ParseAddress(value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: expected an email address", value, settingSMTPFrom)
+		}
+	}
+	return nil
+}
+
+// smtpSettings describes the mailer's current configuration
+func smtpSettings() SMTPSettings {
+	m := GetMailer()
+	if m == nil {
+		return SMTPSettings{}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return SMTPSettings{
+		Host:        m.host,
+		Port:        m.port,
+		TLSMode:     m.tlsMode,
+		From:        m.from,
+		Username:    m.username,
+		HasPassword: m.password != "",
+		Source:      m.source,
+		Configured:  m.host != "" && m.from != "",
+	}
+}
+
+// GetSMTPSettings returns the SMTP configuration
+// @Summary		Get SMTP settings
+// @Description	Returns the outgoing mail configuration in use, from the smtp_* settings or the SMTP_* environment variables. The password is not returned.
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse{data=SMTPSettings}	"SMTP settings"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/admin/smtp [get]
+func (h *Handler) GetSMTPSettings(c echo.Context) error {
+	return RespondSuccess(c, smtpSettings())
+}
+
+// UpdateSMTPSettings saves the SMTP configuration
+// @Summary		Update SMTP settings
+// @Description	Saves the outgoing mail server. tlsMode is starttls (default), tls (implicit, port 465) or none. Omit password to keep the stored one. An empty host clears the settings so the SMTP_* environment variables apply again.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		UpdateSMTPSettingsRequest	true	"SMTP settings"
+// @Success		200		{object}	docs.SuccessResponse{data=SMTPSettings}	"Saved settings"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid settings"
+// @Security	BearerAuth
+// @Router		/admin/smtp [put]
+func (h *Handler) UpdateSMTPSettings(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	var req UpdateSMTPSettingsRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+
+	values := map[string]string{
+		settingSMTPHost:     strings.TrimSpace(req.Host),
+		settingSMTPPort:     strings.TrimSpace(req.Port),
+		settingSMTPTLSMode:  strings.TrimSpace(req.TLSMode),
+		settingSMTPFrom:     strings.TrimSpace(req.From),
+		settingSMTPUsername: strings.TrimSpace(req.Username),
+	}
+	if req.Password != nil {
+		values[settingSMTPPassword] = *req.Password
+	}
+	if values[settingSMTPHost] != "" && values[settingSMTPFrom] == "" && !strings.Contains(values[settingSMTPUsername], "@") {
+		return RespondError(c, ErrBadRequest("A from address is required"))
+	}
+	if err := ValidateSMTPSettings(values); err != nil {
+		return RespondError(c, ErrBadRequest(err.Error()))
+	}
+
+	sh := GetGlobalSettingsHandler()
+	for key, value := range values {
+		if _, err := h.db.Exec(`
+			INSERT INTO system_settings (key, value, updated_by, updated_at)
+			VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (key) DO UPDATE
+			SET value = EXCLUDED.value, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		`, key, value, claims.UserID); err != nil {
+			return RespondError(c, ErrOperationFailed("save SMTP settings", err))
+		}
+		if sh != nil {
+			sh.InvalidateCache(key)
+		}
+	}
+	GetMailer().Reload(sh)
+
+	h.auditHandler.LogEventFromContext(c, EventAdminSettingsUpdate, "smtp", map[string]interface{}{
+		"host":            values[settingSMTPHost],
+		"port":            values[settingSMTPPort],
+		"tlsMode":         values[settingSMTPTLSMode],
+		"from":            values[settingSMTPFrom],
+		"username":        values[settingSMTPUsername],
+		"passwordChanged": req.Password != nil,
+	})
+	return RespondSuccess(c, smtpSettings())
+}
+
+// SendTestEmail sends a test message with the current SMTP settings
+// @Summary		Send test email
+// @Description	Sends a test message to the given address, or the admin's own, and reports the SMTP error if delivery fails
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		object{to=string}	false	"Recipient"
+// @Success		200		{object}	docs.SuccessResponse	"Sent"
+// @Failure		400		{object}	docs.ErrorResponse	"SMTP not configured or no recipient"
+// @Failure		502		{object}	docs.ErrorResponse	"SMTP server error"
+// @Security	BearerAuth
+// @Router		/admin/smtp/test [post]
+func (h *Handler) SendTestEmail(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		To string `json:"to"`
+	}
+	_ = c.Bind(&req)
+	to := strings.TrimSpace(req.To)
+	if to == "" {
+		_ = h.db.QueryRow(`SELECT COALESCE(email, '') FROM users WHERE id = $1`, claims.UserID).Scan(&to)
+	}
+	if to == "" {
+		return RespondError(c, ErrBadRequest("No recipient: give an address or set an email on your account"))
+	}
+	if !GetMailer().Configured() {
+		return RespondError(c, ErrBadRequest("SMTP is not configured"))
+	}
+
+	settings := smtpSettings()
+	text := fmt.Sprintf("This is a test message from FileHatch, sent by %s through %s:%s (%s).\n",
+		claims.Username, settings.Host, settings.Port, settings.TLSMode)
+	err = GetMailer().Send(Mail{
+		To:      to,
+		Subject: "FileHatch test email",
+		Text:    text,
+		HTML:    "<p>" + html.EscapeString(text) + "</p>",
+	})
+	if err != nil {
+		return RespondError(c, NewAPIError(ErrCodeBadGateway, "Failed to send test email").
+			WithDetails(map[string]string{"error": err.Error()}))
+	}
+	return RespondSuccess(c, map[string]interface{}{"sent": true, "to": to})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMailerReload_SettingsOverrideEnvironment(t *testing.T) {
+	m := &Mailer{env: [6]string{"env.example.com", "", "", "env@example.com", "secret", ""}}
+
+	m.Reload(useCachedSettings(t, map[string]string{settingSMTPHost: ""}))
+	if got := smtpSettingsOf(m); got.Host != "env.example.com" || got.Port != "587" || got.TLSMode != SMTPTLSStartTLS ||
+		got.From != "env@example.com" || got.Source != "environment" || !got.HasPassword {
+		t.Errorf("environment config %+v", got)
+	}
+
+	m.Reload(useCachedSettings(t, map[string]string{
+		settingSMTPHost:     "mail.example.com",
+		settingSMTPPort:     "",
+		settingSMTPTLSMode:  SMTPTLSImplicit,
+		settingSMTPFrom:     "FileHatch <files@example.com>",
+		settingSMTPUsername: "files",
+		settingSMTPPassword: "",
+	}))
+	if got := smtpSettingsOf(m); got.Host != "mail.example.com" || got.Port != "465" || got.TLSMode != SMTPTLSImplicit ||
+		got.Source != "settings" || got.HasPassword || !got.Configured {
+		t.Errorf("settings config %+v", got)
+	}
+}
+
+// smtpSettingsOf describes a mailer that is not the global one
+func smtpSettingsOf(m *Mailer) SMTPSettings {
+	previous := globalMailer
+	globalMailer = m
+	defer func() { globalMailer = previous }()
+	return smtpSettings()
+}
+
+func TestValidateSMTPSettings(t *testing.T) {
+	for _, bad := range []map[string]string{
+		{settingSMTPPort: "smtp"},
+		{settingSMTPPort: "70000"},
+		{settingSMTPTLSMode: "ssl"},
+		{settingSMTPFrom: "not an address"},
+	} {
+		if err := ValidateSMTPSettings(bad); err == nil {
+			t.Errorf("%v: expected an error", bad)
+		}
+	}
+	if err := ValidateSMTPSettings(map[string]string{
+		settingSMTPPort: "2525", settingSMTPTLSMode: SMTPTLSNone, settingSMTPFrom: "files@example.com",
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSendTestEmail(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &Handler{db: tc.DB, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	sent := useTestMailer(t)
+
+	tc.Mock.ExpectQuery("SELECT COALESCE\\(email, ''\\) FROM users").
+		WithArgs("admin-1").
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("admin@example.com"))
+
+	req, _ := NewJSONRequest(http.MethodPost, "/api/admin/smtp/test", map[string]string{})
+	rec := tc.Recorder
+	c := tc.Echo.NewContext(req, rec)
+	c.Set("user", &JWTClaims{UserID: "admin-1", Username: "admin", IsAdmin: true})
+	if err := h.SendTestEmail(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, rec, http.StatusOK)
+	if len(*sent) != 1 || (*sent)[0] != "admin@example.com" {
+		t.Errorf("sent %v", *sent)
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mailerTimeout bounds connecting to and talking with the SMTP server
+const mailerTimeout = 30 * time.Second
+
+// Mail is a message with a plain text and an HTML body
+type Mail struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// SMTP TLS modes
+const (
+	SMTPTLSStartTLS = "starttls" // STARTTLS when the server offers it
+	SMTPTLSImplicit = "tls"      // TLS from the first byte, usually port 465
+	SMTPTLSNone     = "none"     // plain connection
+)
+
+// SMTP settings; while smtp_host is empty the SMTP_* environment variables
+// are used instead
+const (
+	settingSMTPHost     = "smtp_host"
+	settingSMTPPort     = "smtp_port"
+	settingSMTPTLSMode  = "smtp_tls_mode"
+	settingSMTPFrom     = "smtp_from"
+	settingSMTPUsername = "smtp_username"
+	settingSMTPPassword = "smtp_password"
+)
+
+// isSMTPSetting reports whether a settings key configures the mailer
+func isSMTPSetting(key string) bool {
+	return strings.HasPrefix(key, "smtp_")
+}
+
+// Mailer sends mail through the SMTP server configured in the smtp_*
+// settings, or by SMTP_HOST, SMTP_PORT, SMTP_TLS_MODE, SMTP_USERNAME,
+// SMTP_PASSWORD and SMTP_FROM while smtp_host is not set. Without a host it
+// is not configured and callers fall back to notifications only.
+type Mailer struct {
+	mu       sync.RWMutex
+	host     string
+	port     string
+	tlsMode  string
+	username string
+	password string
+	from     string
+	// source is "settings", "environment" or "" when not configured
+	source string
+
+	// env is the configuration from the environment
+	env [6]string
+
+	// send delivers a rendered message; replaced in tests
+	send func(from string, to []string, msg []byte) error
+}
+
+var globalMailer *Mailer
+
+// InitMailer creates the global mailer from the settings and the environment
+func InitMailer(settings *SettingsHandler) *Mailer {
+	m := &Mailer{
+		env: [6]string{
+			os.Getenv("SMTP_HOST"),
+			os.Getenv("SMTP_PORT"),
+			os.Getenv("SMTP_TLS_MODE"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			os.Getenv("SMTP_FROM"),
+		},
+	}
+	m.send = m.smtpSend
+	m.Reload(settings)
+	globalMailer = m
+	if m.Configured() {
+		log.Printf("[Mail] SMTP configured (%s:%s)", m.host, m.port)
+	}
+	return m
+}
+
+// GetMailer returns the global mailer (nil if not initialized)
+func GetMailer() *Mailer {
+	return globalMailer
+}
+
+// Reload applies the current SMTP settings
+func (m *Mailer) Reload(settings *SettingsHandler) {
+	if m == nil {
+		return
+	}
+	cfg, source := m.env, ""
+	if cfg[0] != "" {
+		source = "environment"
+	}
+	if settings != nil {
+		if host, _ := settings.GetSetting(settingSMTPHost); host != "" {
+			source = "settings"
+			for i, key := range []string{settingSMTPHost, settingSMTPPort, settingSMTPTLSMode,
+				settingSMTPUsername, settingSMTPPassword, settingSMTPFrom} {
+				cfg[i], _ = settings.GetSetting(key)
+			}
+		}
+	}
+	host, port, tlsMode, username, password, from := cfg[0], cfg[1], cfg[2], cfg[3], cfg[4], cfg[5]
+	if tlsMode == "" {
+		tlsMode = SMTPTLSStartTLS
+		if port == "465" {
+			tlsMode = SMTPTLSImplicit
+		}
+	}
+	if port == "" {
+		port = "587"
+		if tlsMode == SMTPTLSImplicit {
+			port = "465"
+		}
+	}
+	if from == "" && strings.Contains(username, "@") {
+		from = username
+	}
+
+	m.mu.Lock()
+	m.host, m.port, m.tlsMode, m.username, m.password, m.from = host, port, tlsMode, username, password, from
+	m.source = source
+	m.mu.Unlock()
+}
+
+// Configured reports whether mail can be sent
+func (m *Mailer) Configured() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.host != "" && m.from != ""
+}
+
+// Send delivers a message to one recipient
+func (m *Mailer) Send(msg Mail) error {
+	if !m.Configured() {
+		return fmt.Errorf("SMTP is not configured")
+	}
+	m.mu.RLock()
+	sender := m.from
+	m.mu.RUnlock()
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP from address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	return m.send(from.Address, []string{to.Address}, buildMail(from.String(), to.String(), msg))
+}
+
+// smtpSend delivers a message over SMTP with the configured TLS mode
+func (m *Mailer) smtpSend(from string, to []string, msg []byte) error {
+	m.mu.RLock()
+	host, port, tlsMode, username, password := m.host, m.port, m.tlsMode, m.username, m.password
+	m.mu.RUnlock()
+
+	addr := net.JoinHostPort(host, port)
+	dialer := &net.Dialer{Timeout: mailerTimeout}
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	if tlsMode == SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(mailerTimeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if tlsMode == SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if username != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		_ = w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMail renders a multipart/alternative message with UTF-8 text and
+// HTML parts
+func buildMail(from, to string, msg Mail) []byte {
+	var token [12]byte
+	_, _ = rand.Read(token[:])
+	boundary := "fh-" + hex.EncodeToString(token[:])
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		if part.body == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		encoded := base64.StdEncoding.EncodeToString([]byte(part.body))
+		for len(encoded) > 76 {
+			b.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		b.WriteString(encoded + "\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes()
+}
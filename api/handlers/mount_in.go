@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// MountIn is a folder of a user's home shown read-only inside a shared drive
+type MountIn struct {
+	ID              string    `json:"id"`
+	SharedFolderID  string    `json:"sharedFolderId"`
+	Path            string    `json:"path"` // Where members see it: /shared/{drive}/...
+	OwnerID         string    `json:"ownerId"`
+	OwnerUsername   string    `json:"ownerUsername"`
+	SourcePath      string    `json:"sourcePath"`      // As the owner sees it: /home/...
+	PermissionLevel int       `json:"permissionLevel"` // Ceiling for members; always read-only
+	CreatedAt       time.Time `json:"createdAt"`
+
+	sourceRel string // Data-root relative: users/{owner}/...
+}
+
+// MountInRegistry caches mount-ins by path so resolvePath needs no query
+type MountInRegistry struct {
+	db       *sql.DB
+	dataRoot string
+
+	mu     sync.RWMutex
+	mounts map[string]*MountIn // By Path
+}
+
+var globalMountIns *MountInRegistry
+
+// InitMountIns creates the global mount-in registry and loads the mounts
+func InitMountIns(db *sql.DB, dataRoot string) *MountInRegistry {
+	globalMountIns = &MountInRegistry{db: db, dataRoot: dataRoot, mounts: make(map[string]*MountIn)}
+	if err := globalMountIns.Reload(); err != nil {
+		log.Printf("[MountIn] Failed to load mount-ins: %v", err)
+	}
+	return globalMountIns
+}
+
+// GetMountIns returns the global mount-in registry (nil if not initialized)
+func GetMountIns() *MountInRegistry {
+	return globalMountIns
+}
+
+// Reload reads all mount-ins from the database
+func (r *MountInRegistry) Reload() error {
+	if r == nil {
+		return nil
+	}
+	rows, err := r.db.Query(`
+		SELECT m.id, m.shared_folder_id, m.mount_path, m.owner_id, u.username, m.source_path,
+		       m.permission_level, m.created_at
+		FROM mount_ins m
+		JOIN users u ON u.id = m.owner_id
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	mounts := make(map[string]*MountIn)
+	for rows.Next() {
+		var m MountIn
+		var mountRel string
+		if err := rows.Scan(&m.ID, &m.SharedFolderID, &mountRel, &m.OwnerID, &m.OwnerUsername, &m.sourceRel,
+			&m.PermissionLevel, &m.CreatedAt); err != nil {
+			return err
+		}
+		m.Path = "/" + mountRel
+		m.SourcePath = "/home" + strings.TrimPrefix(m.sourceRel, "users/"+m.OwnerUsername)
+		mounts[m.Path] = &m
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.mounts = mounts
+	r.mu.Unlock()
+	GetListingVersions().InvalidateAll()
+	return nil
+}
+
+// cleanVirtualPath normalizes "shared/x", "/shared/x/" and the like to "/shared/x"
+func cleanVirtualPath(virtualPath string) string {
+	return path.Clean("/" + strings.TrimPrefix(virtualPath, "/"))
+}
+
+// Resolve returns the mount-in holding virtualPath and the path below the
+// mount point ("" for the mount point itself)
+func (r *MountInRegistry) Resolve(virtualPath string) (*MountIn, string, bool) {
+	if r == nil {
+		return nil, "", false
+	}
+	p := cleanVirtualPath(virtualPath)
+	if !strings.HasPrefix(p, "/shared/") {
+		return nil, "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.mounts) == 0 {
+		return nil, "", false
+	}
+	// Mounts never nest, so the first mount point on the way down is the one
+	for prefix := p; prefix != "/shared"; prefix = path.Dir(prefix) {
+		if m, ok := r.mounts[prefix]; ok {
+			return m, strings.TrimPrefix(strings.TrimPrefix(p, prefix), "/"), true
+		}
+	}
+	return nil, "", false
+}
+
+// RealPath returns the real path of a path below a mount point
+func (r *MountInRegistry) RealPath(m *MountIn, rest string) string {
+	return filepath.Join(r.dataRoot, m.sourceRel, rest)
+}
+
+// Below returns the mount-ins at or below virtualDir; direct limits them to
+// the folder's own entries
+func (r *MountInRegistry) Below(virtualDir string, direct bool) []*MountIn {
+	if r == nil {
+		return nil
+	}
+	dir := cleanVirtualPath(virtualDir)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found []*MountIn
+	for p, m := range r.mounts {
+		if direct && path.Dir(p) != dir {
+			continue
+		}
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			found = append(found, m)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Path < found[j].Path })
+	return found
+}
+
+// List returns all mount-ins, or those of one owner
+func (r *MountInRegistry) List(ownerID string) []*MountIn {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*MountIn, 0, len(r.mounts))
+	for _, m := range r.mounts {
+		if ownerID == "" || m.OwnerID == ownerID {
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// byID returns a mount-in by ID
+func (r *MountInRegistry) byID(id string) *MountIn {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, m := range r.mounts {
+		if m.ID == id {
+			return m
+		}
+	}
+	return nil
+}
+
+// MovePath follows a rename or move of a mount's source folder in the owner's home
+func (r *MountInRegistry) MovePath(oldRealPath, newRealPath string) {
+	if r == nil {
+		return
+	}
+	oldRel, err1 := dataRootRel(r.dataRoot, oldRealPath)
+	newRel, err2 := dataRootRel(r.dataRoot, newRealPath)
+	if err1 != nil || err2 != nil || !strings.HasPrefix(oldRel, "users/") {
+		return
+	}
+	oldRel, newRel = filepath.ToSlash(oldRel), filepath.ToSlash(newRel)
+	res, err := r.db.Exec(`
+		UPDATE mount_ins
+		SET source_path = $2 || substr(source_path, length($1) + 1)
+		WHERE source_path = $1 OR starts_with(source_path, $1 || '/')
+	`, oldRel, newRel)
+	if err != nil {
+		log.Printf("[MountIn] Failed to move %s -> %s: %v", oldRel, newRel, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if err := r.Reload(); err != nil {
+			log.Printf("[MountIn] Failed to reload mount-ins: %v", err)
+		}
+	}
+}
+
+// mountInWriteError returns a READ_ONLY error when virtualPath is a mount
+// point or inside one
+func mountInWriteError(virtualPath string) *APIError {
+	m, _, ok := GetMountIns().Resolve(virtualPath)
+	if !ok {
+		return nil
+	}
+	return NewAPIError(ErrCodeReadOnly, fmt.Sprintf("%s is a read-only view of %s's folder", m.Path, m.OwnerUsername))
+}
+
+// mountInMoveError is mountInWriteError for deletes, moves and renames,
+// which also may not take away the folder a mount-in sits in
+func mountInMoveError(virtualPath string) *APIError {
+	if apiErr := mountInWriteError(virtualPath); apiErr != nil {
+		return apiErr
+	}
+	if below := GetMountIns().Below(virtualPath, false); len(below) > 0 {
+		return NewAPIError(ErrCodeConflict, fmt.Sprintf("%s contains mounted folders (%s); remove them first", cleanVirtualPath(virtualPath), below[0].Path))
+	}
+	return nil
+}
+
+// addMountInDetails adds the owner of the data to the audit details of an
+// access through a mount-in; other paths are left alone
+func addMountInDetails(virtualPath string, details map[string]interface{}) bool {
+	m, _, ok := GetMountIns().Resolve(virtualPath)
+	if !ok {
+		return false
+	}
+	details["mountId"] = m.ID
+	details["mountOwner"] = m.OwnerUsername
+	details["mountOwnerId"] = m.OwnerID
+	details["sourcePath"] = m.SourcePath + strings.TrimPrefix(cleanVirtualPath(virtualPath), m.Path)
+	return true
+}
+
+// auditMountInAccess records an access through a mount-in with both the
+// member (actor) and the owner of the data
+func (h *Handler) auditMountInAccess(c echo.Context, claims *JWTClaims, virtualPath, eventType string, details map[string]interface{}) {
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	if !addMountInDetails(virtualPath, details) || h.auditHandler == nil {
+		return
+	}
+	var actorID *string
+	if claims != nil {
+		actorID = &claims.UserID
+	}
+	_ = h.auditHandler.LogEvent(actorID, c.RealIP(), eventType, cleanVirtualPath(virtualPath), details)
+}
+
+// mountInEntry describes a mount point in its parent folder's listing
+func (r *MountInRegistry) mountInEntry(m *MountIn) (FileInfo, bool) {
+	info, err := os.Stat(r.RealPath(m, ""))
+	if err != nil || !info.IsDir() {
+		return FileInfo{}, false
+	}
+	return FileInfo{
+		Name:       path.Base(m.Path),
+		Path:       m.Path,
+		IsDir:      true,
+		ModTime:    info.ModTime(),
+		MountOwner: m.OwnerUsername,
+	}, true
+}
+
+// CreateMountInRequest creates a mount-in
+type CreateMountInRequest struct {
+	Path       string `json:"path"`       // /shared/{drive}/...
+	Owner      string `json:"owner"`      // Username
+	SourcePath string `json:"sourcePath"` // /home/... of the owner
+}
+
+// CreateMountIn mounts a folder of a user's home into a shared drive
+// @Summary		Create mount-in
+// @Description	Shows a folder of a user's home read-only at a path inside a shared drive. Members of the drive browse, preview and download it; writes are rejected. The data stays in the owner's home and counts against the owner's quota only.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		CreateMountInRequest	true	"Mount-in"
+// @Success		201		{object}	MountIn		"Created mount-in"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid path"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		409		{object}	docs.ErrorResponse	"Path already in use"
+// @Security	BearerAuth
+// @Router		/admin/mount-ins [post]
+func (h *Handler) CreateMountIn(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+
+	var req CreateMountInRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if req.Path == "" || req.Owner == "" || req.SourcePath == "" {
+		return RespondError(c, ErrMissingParameter("path, owner and sourcePath"))
+	}
+
+	// Mount point: below a drive root, not taken by a real item or another mount
+	mountPath, err := validateAndCleanPath(cleanVirtualPath(req.Path))
+	if err != nil || ExtractSharedFolderName(mountPath) == "" || strings.Count(mountPath, "/") < 3 {
+		return RespondError(c, ErrBadRequest("path must be a folder inside a shared drive, e.g. /shared/Design/External/wip"))
+	}
+	var folderID string
+	if err := h.db.QueryRow(`SELECT id FROM shared_folders WHERE name = $1 AND is_active = TRUE`,
+		ExtractSharedFolderName(mountPath)).Scan(&folderID); err != nil {
+		return RespondError(c, ErrNotFound("Shared drive"))
+	}
+	if m, _, ok := GetMountIns().Resolve(mountPath); ok {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "path is inside the mount-in "+m.Path))
+	}
+	if below := GetMountIns().Below(mountPath, false); len(below) > 0 {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "path contains the mount-in "+below[0].Path))
+	}
+	mountReal := filepath.Join(h.dataRoot, strings.TrimPrefix(mountPath, "/"))
+	if _, err := os.Lstat(mountReal); err == nil {
+		return RespondError(c, ErrAlreadyExists("An item already exists at "+mountPath))
+	}
+
+	// Source: an existing subfolder of the owner's home
+	var ownerID string
+	if err := h.db.QueryRow(`SELECT id FROM users WHERE username = $1`, req.Owner).Scan(&ownerID); err != nil {
+		return RespondError(c, ErrNotFound("User"))
+	}
+	sourcePath, err := validateAndCleanPath(cleanVirtualPath(req.SourcePath))
+	if err != nil || !strings.HasPrefix(sourcePath, "/home/") {
+		return RespondError(c, ErrBadRequest("sourcePath must be a folder inside the owner's home, e.g. /home/wip"))
+	}
+	sourceRel := path.Join("users", req.Owner, strings.TrimPrefix(sourcePath, "/home/"))
+	if info, err := os.Stat(filepath.Join(h.dataRoot, sourceRel)); err != nil || !info.IsDir() {
+		return RespondError(c, ErrNotFound("Source folder"))
+	}
+
+	// The parent shows the mount point, so it has to exist
+	if err := MkdirAllShared(filepath.Dir(mountReal)); err != nil {
+		return RespondError(c, ErrOperationFailed("create parent folder", err))
+	}
+
+	var id string
+	err = h.db.QueryRow(`
+		INSERT INTO mount_ins (shared_folder_id, mount_path, owner_id, source_path, permission_level, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, folderID, strings.TrimPrefix(mountPath, "/"), ownerID, sourceRel, PermissionReadOnly, claims.UserID).Scan(&id)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("create mount-in", err))
+	}
+	if err := GetMountIns().Reload(); err != nil {
+		log.Printf("[MountIn] Failed to reload mount-ins: %v", err)
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), "mount_in.create", mountPath, map[string]interface{}{
+		"mountId":      id,
+		"mountOwner":   req.Owner,
+		"mountOwnerId": ownerID,
+		"sourcePath":   sourcePath,
+	})
+
+	m := GetMountIns().byID(id)
+	if m == nil {
+		m = &MountIn{ID: id, SharedFolderID: folderID, Path: mountPath, OwnerID: ownerID, OwnerUsername: req.Owner,
+			SourcePath: sourcePath, PermissionLevel: PermissionReadOnly, CreatedAt: time.Now()}
+	}
+	return RespondCreated(c, m)
+}
+
+// ListMountIns lists mount-ins: all of them for admins with all=true,
+// otherwise those of the caller's own folders
+// @Summary		List mount-ins
+// @Description	Lists the caller's folders mounted into shared drives. Admins get every mount-in with all=true.
+// @Tags		Files
+// @Produce		json
+// @Param		all	query		bool	false	"All mount-ins (admin)"
+// @Success		200	{array}		MountIn	"Mount-ins"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/mount-ins [get]
+func (h *Handler) ListMountIns(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if claims == nil {
+		return err
+	}
+	ownerID := claims.UserID
+	if c.QueryParam("all") == "true" {
+		if !claims.HasPermission(PermStorageAdmin) {
+			return RespondError(c, ErrForbidden("Admin access required"))
+		}
+		ownerID = ""
+	}
+	return RespondSuccess(c, GetMountIns().List(ownerID))
+}
+
+// DeleteMountIn removes a mount-in; the owner of the folder can always revoke it
+// @Summary		Remove mount-in
+// @Description	Removes a mount-in. Allowed for admins and for the owner of the mounted folder. The folder itself is not touched.
+// @Tags		Files
+// @Produce		json
+// @Param		id	path		string	true	"Mount-in ID"
+// @Success		200	{object}	docs.SuccessResponse	"Removed"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/mount-ins/{id} [delete]
+func (h *Handler) DeleteMountIn(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if claims == nil {
+		return err
+	}
+	m := GetMountIns().byID(c.Param("id"))
+	if m == nil {
+		return RespondError(c, ErrNotFound("Mount-in"))
+	}
+	if !claims.HasPermission(PermStorageAdmin) && m.OwnerID != claims.UserID {
+		return RespondError(c, ErrForbidden("Only an admin or the folder's owner can remove this mount-in"))
+	}
+
+	if _, err := h.db.Exec(`DELETE FROM mount_ins WHERE id = $1`, m.ID); err != nil {
+		return RespondError(c, ErrOperationFailed("remove mount-in", err))
+	}
+	if err := GetMountIns().Reload(); err != nil {
+		log.Printf("[MountIn] Failed to reload mount-ins: %v", err)
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), "mount_in.delete", m.Path, map[string]interface{}{
+		"mountId":      m.ID,
+		"mountOwner":   m.OwnerUsername,
+		"mountOwnerId": m.OwnerID,
+		"sourcePath":   m.SourcePath,
+		"revokedBy":    map[bool]string{true: "owner", false: "admin"}[m.OwnerID == claims.UserID],
+	})
+	return RespondSuccess(c, map[string]string{"message": "Mount-in removed"})
+}
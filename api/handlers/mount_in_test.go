@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withMountIns installs a registry with john's /home/wip mounted at
+// /shared/Design/External/john-wip for the duration of the test
+func withMountIns(t *testing.T, dataRoot string) *MountIn {
+	t.Helper()
+	m := &MountIn{
+		ID:            "m1",
+		Path:          "/shared/Design/External/john-wip",
+		OwnerID:       "u-john",
+		OwnerUsername: "john",
+		SourcePath:    "/home/wip",
+		sourceRel:     "users/john/wip",
+	}
+	prev := globalMountIns
+	globalMountIns = &MountInRegistry{dataRoot: dataRoot, mounts: map[string]*MountIn{m.Path: m}}
+	t.Cleanup(func() { globalMountIns = prev })
+	return m
+}
+
+func TestMountInRegistry_Resolve(t *testing.T) {
+	withMountIns(t, "/data")
+	r := GetMountIns()
+
+	for path, want := range map[string]string{
+		"/shared/Design/External/john-wip":         "",
+		"shared/Design/External/john-wip/":         "",
+		"/shared/Design/External/john-wip/a/b.txt": "a/b.txt",
+		"/shared/Design/External/john-wip-old":     "-",
+		"/shared/Design/External":                  "-",
+		"/home/wip":                                "-",
+	} {
+		_, rest, ok := r.Resolve(path)
+		if (want == "-") == ok || (ok && rest != want) {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", path, rest, ok, want)
+		}
+	}
+
+	if got := r.Below("/shared/Design/External", true); len(got) != 1 {
+		t.Errorf("Below(parent, direct) = %v", got)
+	}
+	if got := r.Below("/shared/Design", true); len(got) != 0 {
+		t.Errorf("Below(grandparent, direct) = %v", got)
+	}
+	if got := r.Below("/shared/Design", false); len(got) != 1 {
+		t.Errorf("Below(grandparent) = %v", got)
+	}
+}
+
+func TestMountIn_ResolvePathAndGuards(t *testing.T) {
+	dataRoot := t.TempDir()
+	withMountIns(t, dataRoot)
+	h := &Handler{dataRoot: dataRoot}
+	claims := &JWTClaims{UserID: "u-alice", Username: "alice"}
+
+	realPath, storageType, displayPath, err := h.resolvePath("/shared/Design/External/john-wip/a.txt", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if realPath != filepath.Join(dataRoot, "users/john/wip/a.txt") || storageType != StorageShared ||
+		displayPath != "/shared/Design/External/john-wip/a.txt" {
+		t.Errorf("resolvePath = %s, %s, %s", realPath, storageType, displayPath)
+	}
+
+	apiErr := mountInWriteError("/shared/Design/External/john-wip/a.txt")
+	if apiErr == nil || apiErr.Code != ErrCodeReadOnly || apiErr.HTTPStatus() != http.StatusForbidden {
+		t.Fatalf("mountInWriteError = %v", apiErr)
+	}
+	if mountInWriteError("/shared/Design/External") != nil {
+		t.Error("parent folder of a mount-in must stay writable")
+	}
+	if apiErr := mountInMoveError("/shared/Design/External"); apiErr == nil || apiErr.Code != ErrCodeConflict {
+		t.Errorf("mountInMoveError(parent) = %v, want a conflict", apiErr)
+	}
+	if h.CanWriteSharedDrive("u-alice", "/shared/Design/External/john-wip") {
+		t.Error("CanWriteSharedDrive allowed a write into a mount-in")
+	}
+}
+
+func TestMountIn_ZipUsesMountNames(t *testing.T) {
+	dataRoot := t.TempDir()
+	m := withMountIns(t, dataRoot)
+	source := GetMountIns().RealPath(m, "")
+	if err := os.MkdirAll(filepath.Join(source, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(filepath.Join(source, "sub", "a.txt"), []byte("a"), 0644)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := zipAddMountIns(zw, nil, "/shared/Design", "Design"); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if !names["Design/External/john-wip/sub/a.txt"] {
+		t.Errorf("zip entries = %v", names)
+	}
+}
@@ -53,10 +53,12 @@ func (h *NotificationHandler) List(c echo.Context) error {
 	if notifications == nil {
 		notifications = []Notification{}
 	}
+	unreadCount, _ := h.service.GetUnreadCount(claims.UserID)
 
 	return RespondSuccess(c, map[string]interface{}{
 		"notifications": notifications,
 		"total":         total,
+		"unreadCount":   unreadCount,
 		"limit":         limit,
 		"offset":        offset,
 	})
@@ -102,6 +104,7 @@ func (h *NotificationHandler) GetUnreadCount(c echo.Context) error {
 // @Failure		500		{object}	docs.ErrorResponse	"Internal server error"
 // @Security	BearerAuth
 // @Router		/notifications/{id}/read [put]
+// @Router		/notifications/{id}/read [post]
 func (h *NotificationHandler) MarkAsRead(c echo.Context) error {
 	claims, err := RequireClaims(c)
 	if err != nil {
@@ -117,6 +120,7 @@ func (h *NotificationHandler) MarkAsRead(c echo.Context) error {
 		return RespondError(c, ErrInternal("Failed to mark as read"))
 	}
 
+	h.service.broadcastUnreadCount(claims.UserID)
 	return RespondSuccess(c, map[string]interface{}{"success": true})
 }
 
@@ -131,6 +135,7 @@ func (h *NotificationHandler) MarkAsRead(c echo.Context) error {
 // @Failure		500		{object}	docs.ErrorResponse	"Internal server error"
 // @Security	BearerAuth
 // @Router		/notifications/read-all [put]
+// @Router		/notifications/read-all [post]
 func (h *NotificationHandler) MarkAllAsRead(c echo.Context) error {
 	claims, err := RequireClaims(c)
 	if err != nil {
@@ -141,6 +146,7 @@ func (h *NotificationHandler) MarkAllAsRead(c echo.Context) error {
 		return RespondError(c, ErrInternal("Failed to mark all as read"))
 	}
 
+	h.service.broadcastUnreadCount(claims.UserID)
 	return RespondSuccess(c, map[string]interface{}{"success": true})
 }
 
@@ -172,6 +178,7 @@ func (h *NotificationHandler) Delete(c echo.Context) error {
 		return RespondError(c, ErrInternal("Failed to delete notification"))
 	}
 
+	h.service.broadcastUnreadCount(claims.UserID)
 	return RespondSuccess(c, map[string]interface{}{"success": true})
 }
 
@@ -12,13 +12,20 @@ const (
 	NotifShareReceived         = "share.received"
 	NotifSharePermissionChanged = "share.permission_changed"
 	NotifShareRemoved          = "share.removed"
+	NotifShareInvitation       = "share.invitation"
+	NotifShareAccepted         = "share.accepted"
+	NotifShareDeclined         = "share.declined"
 	NotifSharedFolderInvited   = "shared_folder.invited"
 	NotifSharedFolderRemoved   = "shared_folder.removed"
 	NotifSharedFileModified    = "shared_file.modified"
 	NotifShareLinkAccessed     = "share_link.accessed"
 	NotifUploadLinkReceived    = "upload_link.received"
+	NotifFileEditConflict      = "file.edit_conflict"
 )
 
+// notificationUnreadRetentionDays is the minimum age before unread notifications are purged
+const notificationUnreadRetentionDays = 90
+
 // Notification represents a notification record
 type Notification struct {
 	ID        int64                  `json:"id"`
@@ -93,10 +100,41 @@ func (s *NotificationService) Create(userID, notifType, title, message, link str
 
 	// Broadcast to user via WebSocket
 	BroadcastNotification(userID, notif)
+	s.broadcastUnreadCount(userID)
 
 	return notif, nil
 }
 
+// Send creates a notification in the background. File operations use it so
+// a slow or failing notification never delays or fails the operation itself.
+func (s *NotificationService) Send(userID, notifType, title, message, link string, actorID *string, metadata map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[Notification] Recovered while sending %s to %s: %v", notifType, userID, r)
+			}
+		}()
+		_, _ = s.Create(userID, notifType, title, message, link, actorID, metadata)
+	}()
+}
+
+// SendBulk is Send for multiple users
+func (s *NotificationService) SendBulk(userIDs []string, notifType, title, message, link string, actorID *string, metadata map[string]interface{}) {
+	for _, userID := range userIDs {
+		s.Send(userID, notifType, title, message, link, actorID, metadata)
+	}
+}
+
+// broadcastUnreadCount pushes the user's unread count to their open clients
+func (s *NotificationService) broadcastUnreadCount(userID string) {
+	if count, err := s.GetUnreadCount(userID); err == nil {
+		BroadcastUnreadCount(userID, count)
+	}
+}
+
 // CreateBulk creates notifications for multiple users
 func (s *NotificationService) CreateBulk(userIDs []string, notifType, title, message, link string, actorID *string, metadata map[string]interface{}) error {
 	for _, userID := range userIDs {
@@ -119,9 +157,6 @@ func (s *NotificationService) GetUnreadCount(userID string) (int, error) {
 
 // List returns notifications for a user with pagination
 func (s *NotificationService) List(userID string, limit, offset int) ([]Notification, int, error) {
-	// Clean up old notifications (30 days)
-	_, _ = s.db.Exec(`DELETE FROM notifications WHERE created_at < NOW() - INTERVAL '30 days'`)
-
 	rows, err := s.db.Query(`
 		SELECT n.id, n.user_id, n.type, n.title, n.message, n.link,
 		       n.actor_id, u.username, n.is_read, n.created_at, n.metadata
@@ -210,3 +245,43 @@ func (s *NotificationService) DeleteAllRead(userID string) error {
 	`, userID)
 	return err
 }
+
+// PurgeExpired deletes read notifications older than the retention setting.
+// Unread notifications are kept longer so missed ones are not lost, but not forever.
+func (s *NotificationService) PurgeExpired() error {
+	retentionDays := 30
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		retentionDays = settings.GetNotificationRetentionDays()
+	}
+	if retentionDays <= 0 {
+		return nil
+	}
+	unreadDays := max(retentionDays, notificationUnreadRetentionDays)
+
+	result, err := s.db.Exec(`
+		DELETE FROM notifications
+		WHERE (is_read = TRUE AND created_at < NOW() - make_interval(days => $1))
+		   OR created_at < NOW() - make_interval(days => $2)
+	`, retentionDays, unreadDays)
+	if err != nil {
+		return err
+	}
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		log.Printf("[Notification] Purged %d expired notifications", removed)
+	}
+	return nil
+}
+
+// StartRetention purges expired notifications now and then periodically
+func (s *NotificationService) StartRetention(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := s.PurgeExpired(); err != nil {
+				log.Printf("[Notification] Retention cleanup failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNotificationService_PurgeExpired(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+
+	// Without a settings handler the default of 30 days applies; unread
+	// notifications are kept for at least 90 days
+	tc.Mock.ExpectExec("DELETE FROM notifications").
+		WithArgs(30, notificationUnreadRetentionDays).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	if err := NewNotificationService(tc.DB).PurgeExpired(); err != nil {
+		t.Fatalf("PurgeExpired: %v", err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotificationService_SendNilSafe(t *testing.T) {
+	var s *NotificationService
+	// Callers may hold a nil service; sending must be a no-op
+	s.Send("user", NotifShareReceived, "title", "", "", nil, nil)
+	s.SendBulk([]string{"a", "b"}, NotifShareReceived, "title", "", "", nil, nil)
+}
@@ -34,16 +34,36 @@ func getOnlyOfficePublicURL() string {
 	return ""
 }
 
+// settingOnlyOfficeEnabled turns OnlyOffice off even when a document server is reachable
+const settingOnlyOfficeEnabled = "onlyoffice_enabled"
+
+// onlyOfficeEnabled reports whether OnlyOffice is turned on in the settings
+func onlyOfficeEnabled() bool {
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		return sh.GetSettingBool(settingOnlyOfficeEnabled, true)
+	}
+	return true
+}
+
 // OnlyOffice callback request structure
 type OnlyOfficeCallbackRequest struct {
-	Key     string   `json:"key"`
-	Status  int      `json:"status"`
-	URL     string   `json:"url"`
-	Users   []string `json:"users,omitempty"`
-	Actions []struct {
+	Key           string   `json:"key"`
+	Status        int      `json:"status"`
+	URL           string   `json:"url"`
+	Users         []string `json:"users,omitempty"`
+	ForceSaveType *int     `json:"forcesavetype,omitempty"` // Status 6/7: 0 command service, 1 save button, 2 server timer, 3 form submit
+	UserData      string   `json:"userdata,omitempty"`
+	Actions       []struct {
 		Type   int    `json:"type"`
 		UserID string `json:"userid"`
 	} `json:"actions,omitempty"`
+	History *struct {
+		Changes []struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"changes"`
+	} `json:"history,omitempty"` // Status 2/6: who changed the document
 }
 
 // OnlyOfficeCallback handles document save callbacks from OnlyOffice
@@ -55,7 +75,16 @@ func (h *Handler) OnlyOfficeCallback(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]int{"error": 1})
 	}
 
-	// Status 2 (ready for save) or 6 (force save) - download and save the document
+	// Status 3/7: the document server could not build the document. The
+	// editing session continues, so there is nothing to write.
+	if req.Status == 3 || req.Status == 7 {
+		log.Printf("[OnlyOffice] Document server save error for key %s (status %d)", req.Key, req.Status)
+		return c.JSON(http.StatusOK, map[string]int{"error": 0})
+	}
+
+	// Status 2 (ready for save) or 6 (force save) - download and save the document.
+	// Force saves keep the session open, so both go through the same
+	// conflict check and atomic write, and record the new session base.
 	if req.Status == 2 || req.Status == 6 {
 		if req.URL == "" {
 			return c.JSON(http.StatusBadRequest, map[string]int{"error": 1})
@@ -87,15 +116,55 @@ func (h *Handler) OnlyOfficeCallback(c echo.Context) error {
 		if tokenString != "" {
 			token, err := ValidateJWTToken(tokenString)
 			if err == nil && token.Valid {
-				if tokenClaims, ok := token.Claims.(*JWTClaims); ok {
+				if tokenClaims, ok := token.Claims.(*JWTClaims); ok && !tokenClaims.IsLimited() {
 					claims = tokenClaims
 				}
 			}
 		}
 
-		// Resolve the virtual path to real path
-		realPath, storageType, _, err := h.resolvePath(decodedPath, claims)
+		// Co-editing sessions name the canonical file; the save goes to the
+		// owner's file and is attributed to the user who edited
+		var realPath, storageType string
+		var err error
 		isSharedFile := false
+		if canonical := c.QueryParam("doc"); canonical != "" {
+			if !documentKeyMatchesPath(req.Key, canonical) {
+				log.Printf("[OnlyOffice] Key %s does not belong to %s", req.Key, canonical)
+				return c.JSON(http.StatusBadRequest, map[string]int{"error": 1})
+			}
+			fallbackID := ""
+			if claims != nil {
+				fallbackID = claims.UserID
+			}
+			editorID := onlyOfficeEditorID(&req, fallbackID)
+			editor, ok := h.onlyOfficeEditorClaims(editorID, canonical)
+			if !ok {
+				log.Printf("[OnlyOffice] User %s may not save %s", editorID, canonical)
+				return c.JSON(http.StatusForbidden, map[string]int{"error": 1})
+			}
+			claims = editor
+			realPath = filepath.Join(h.dataRoot, filepath.FromSlash(canonical))
+			if !isPathWithinRoot(realPath, h.dataRoot) {
+				return c.JSON(http.StatusBadRequest, map[string]int{"error": 1})
+			}
+			realPath = GetStorageLocations().Map(realPath)
+			storageType = StorageHome
+			if strings.HasPrefix(canonical, "shared/") {
+				storageType = StorageShared
+			}
+			decodedPath = onlyOfficeDisplayPath(canonical)
+		} else {
+			realPath, storageType, _, err = h.resolvePath(decodedPath, claims)
+		}
+		if mountInWriteError(decodedPath) != nil {
+			log.Printf("[OnlyOffice] Rejected save into read-only mount: %s", decodedPath)
+			return c.JSON(http.StatusForbidden, map[string]int{"error": 1})
+		}
+		if storageWriteError(realPath) != nil {
+			// OnlyOffice retries the callback; the folder is back within seconds
+			log.Printf("[OnlyOffice] Save deferred while %s is being migrated", decodedPath)
+			return c.JSON(http.StatusServiceUnavailable, map[string]int{"error": 1})
+		}
 		if err != nil || realPath == "" {
 			// Check if this is a shared file
 			if claims != nil {
@@ -120,6 +189,14 @@ func (h *Handler) OnlyOfficeCallback(c echo.Context) error {
 				return c.JSON(http.StatusForbidden, map[string]int{"error": 1})
 			}
 		}
+		var editorID *string
+		if claims != nil {
+			editorID = &claims.UserID
+		}
+		if GetRetention().Check(h.auditHandler, editorID, c.RealIP(), "", realPath, EventFileEdit, false) != nil {
+			log.Printf("[OnlyOffice] Rejected save of a file under retention hold: %s", decodedPath)
+			return c.JSON(http.StatusForbidden, map[string]int{"error": 1})
+		}
 
 		// Convert external URL to internal Docker network URL
 		// OnlyOffice sends URLs with its public address, but API needs internal Docker network address
@@ -139,19 +216,38 @@ func (h *Handler) OnlyOfficeCallback(c echo.Context) error {
 			return c.JSON(http.StatusInternalServerError, map[string]int{"error": 1})
 		}
 
-		// Read document content
-		content, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("[OnlyOffice] Failed to read response body: %v", err)
-			return c.JSON(http.StatusInternalServerError, map[string]int{"error": 1})
+		// The document key carries the mtime the session was opened at; a
+		// different mtime now means the file was changed outside this session
+		if info, err := os.Stat(realPath); err == nil {
+			if base, ok := GetWriterHints().sessionBase(req.Key); ok && info.ModTime().Unix() != base.Unix() {
+				log.Printf("[OnlyOffice] File changed during editing session: %s", realPath)
+				content, err := io.ReadAll(resp.Body)
+				if err != nil {
+					log.Printf("[OnlyOffice] Failed to read response body: %v", err)
+					return c.JSON(http.StatusInternalServerError, map[string]int{"error": 1})
+				}
+				if _, apiErr := h.handleEditConflict(c, claims, realPath, decodedPath, info, content, "onlyoffice"); apiErr != nil {
+					return c.JSON(http.StatusOK, map[string]int{"error": 1})
+				}
+				return c.JSON(http.StatusOK, map[string]int{"error": 0})
+			}
 		}
 
-		// Write to file
-		if err := writeFileAtomic(realPath, content, 0644); err != nil {
+		// Stream the document into place; a download that breaks off leaves
+		// the saved file as it was
+		body := &countingReader{r: resp.Body}
+		if err := WriteFileSealedFrom(realPath, body, 0644); err != nil {
 			log.Printf("[OnlyOffice] Failed to write file %s: %v", realPath, err)
 			return c.JSON(http.StatusInternalServerError, map[string]int{"error": 1})
 		}
-		log.Printf("[OnlyOffice] Successfully saved file: %s (%d bytes)", realPath, len(content))
+		log.Printf("[OnlyOffice] Successfully saved file: %s (%d bytes)", realPath, body.n)
+		GetChangeJournal().Record(ChangeModify, realPath, "", changeActor(claims))
+		GetListingVersions().Invalidate(realPath)
+		GetFileChecksums().ForgetTree(realPath)
+		GetWriterHints().NoteAPI(realPath, claims)
+		if info, err := os.Stat(realPath); err == nil {
+			GetWriterHints().noteSessionSave(req.Key, info.ModTime())
+		}
 
 		// Log the action
 		var userID *string
@@ -159,11 +255,21 @@ func (h *Handler) OnlyOfficeCallback(c echo.Context) error {
 			userID = &claims.UserID
 		}
 		clientIP := c.RealIP()
-		_ = h.auditHandler.LogEvent(userID, clientIP, EventFileEdit, decodedPath, map[string]interface{}{
-			"size":        len(content),
+		details := map[string]interface{}{
+			"size":        body.n,
 			"storageType": storageType,
 			"source":      "onlyoffice",
-		})
+		}
+		if len(req.Users) > 1 {
+			details["coEditors"] = req.Users
+		}
+		if req.Status == 6 {
+			details["forceSave"] = true
+			if req.ForceSaveType != nil {
+				details["forceSaveType"] = *req.ForceSaveType
+			}
+		}
+		_ = h.auditHandler.LogEvent(userID, clientIP, EventFileEdit, decodedPath, details)
 	}
 
 	// Return success to OnlyOffice
@@ -187,45 +293,22 @@ func (h *Handler) GetOnlyOfficeConfig(c echo.Context) error {
 		})
 	}
 
-	virtualPath := "/" + requestPath
-	isSharedFile := false
-	canEdit := true // By default, owner can edit
-
-	// Resolve path
-	realPath, _, _, err := h.resolvePath(virtualPath, claims)
-	if err != nil || realPath == "" {
-		// Check if this is a shared file
-		sharedRealPath, _, shareErr := h.GetSharedFileOwnerPath(claims.UserID, virtualPath)
-		if shareErr == nil {
-			realPath = sharedRealPath
-			isSharedFile = true
-			// Check if user has write permission for shared file
-			canEdit = h.CanWriteSharedFile(claims.UserID, virtualPath)
-		} else {
-			return c.JSON(http.StatusBadRequest, map[string]string{
-				"error": "File not found or no access",
-			})
-		}
+	if !onlyOfficeEnabled() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "OnlyOffice is disabled",
+		})
 	}
 
-	// Check if file exists
-	info, err := statFile(realPath)
-	if err != nil {
-		// Try shared file path again if not found
-		if !isSharedFile {
-			sharedRealPath, _, shareErr := h.GetSharedFileOwnerPath(claims.UserID, virtualPath)
-			if shareErr == nil {
-				realPath = sharedRealPath
-				canEdit = h.CanWriteSharedFile(claims.UserID, virtualPath)
-				info, err = statFile(realPath)
-			}
-		}
-		if err != nil {
-			return c.JSON(http.StatusNotFound, map[string]string{
-				"error": "File not found",
-			})
-		}
+	virtualPath := "/" + requestPath
+
+	// Own file, drive file or a file shared with the user
+	doc, apiErr := h.resolveOnlyOfficeDocument(virtualPath, claims)
+	if apiErr != nil {
+		return c.JSON(apiErr.HTTPStatus(), map[string]string{
+			"error": apiErr.Message,
+		})
 	}
+	info, canEdit := doc.Info, doc.CanEdit
 
 	if info.IsDir() {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -242,14 +325,16 @@ func (h *Handler) GetOnlyOfficeConfig(c echo.Context) error {
 		})
 	}
 
-	// Generate unique key for this document (path + modtime for version control)
+	// Generate unique key for this document (canonical path + modtime for version control),
+	// the same for the owner and everyone the file is shared with, so they co-edit
 	// Use SHA256 hash to ensure the key contains only safe characters (hex digits)
 	// OnlyOffice document key should only contain: 0-9, a-z, A-Z, -, _
-	documentKey := generateDocumentKey(virtualPath, info.ModTime().Unix())
+	documentKey := generateDocumentKey(doc.Canonical, info.ModTime().Unix())
 
 	// Build the host URL - use internal Docker network address for OnlyOffice to access
 	// OnlyOffice container needs to reach API via Docker internal network
-	internalBaseURL := "http://api:8080"
+	// (override with ONLYOFFICE_CALLBACK_URL when the document server runs elsewhere)
+	internalBaseURL := getOnlyOfficeCallbackBaseURL()
 
 	// Also build external URL for browser access (callback display only)
 	scheme := "http"
@@ -273,6 +358,8 @@ func (h *Handler) GetOnlyOfficeConfig(c echo.Context) error {
 		editorMode = "view"
 	}
 
+	forceSave, autosaveInterval := onlyOfficeForceSaveConfig()
+
 	editorConfig := map[string]interface{}{
 		"user": map[string]interface{}{
 			"id":   claims.UserID,
@@ -282,9 +369,9 @@ func (h *Handler) GetOnlyOfficeConfig(c echo.Context) error {
 		"mode": editorMode,
 		"customization": map[string]interface{}{
 			"autosave":  canEdit,
-			"forcesave": canEdit,
+			"forcesave": canEdit && forceSave,
 		},
-		// Disable co-editing to avoid potential SDK bugs with presentations
+		// Strict co-editing (changes show on save) to avoid potential SDK bugs with presentations
 		"coEditing": map[string]interface{}{
 			"mode":   "strict",
 			"change": false,
@@ -295,7 +382,7 @@ func (h *Handler) GetOnlyOfficeConfig(c echo.Context) error {
 	// Include encoded path in callback URL so the handler knows which file to update
 	if canEdit {
 		encodedVirtualPath := url.QueryEscape(virtualPath)
-		editorConfig["callbackUrl"] = fmt.Sprintf("%s/api/onlyoffice/callback?token=%s&path=%s", internalBaseURL, token, encodedVirtualPath)
+		editorConfig["callbackUrl"] = fmt.Sprintf("%s/api/onlyoffice/callback?token=%s&path=%s&doc=%s", internalBaseURL, token, encodedVirtualPath, url.QueryEscape(doc.Canonical))
 	}
 
 	config := map[string]interface{}{
@@ -309,6 +396,23 @@ func (h *Handler) GetOnlyOfficeConfig(c echo.Context) error {
 		"editorConfig": editorConfig,
 	}
 
+	// Sign the config when the document server has JWT enabled
+	if signed, err := signOnlyOfficePayload(config); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to sign editor config",
+		})
+	} else if signed != "" {
+		config["token"] = signed
+	}
+
+	// Read by the UI, which requests a force save at this interval (seconds);
+	// added after signing as the document server does not use it
+	if canEdit && forceSave {
+		config["autosaveInterval"] = autosaveInterval
+	} else {
+		config["autosaveInterval"] = 0
+	}
+
 	return c.JSON(http.StatusOK, config)
 }
 
@@ -318,7 +422,7 @@ func (h *Handler) GetOnlyOfficeSettings(c echo.Context) error {
 	internalURL := getOnlyOfficeInternalURL()
 
 	// Actually check if OnlyOffice is running by making a healthcheck request
-	available := checkOnlyOfficeHealth(internalURL)
+	available := onlyOfficeEnabled() && checkOnlyOfficeHealth(internalURL)
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"publicUrl": publicURL,
@@ -361,20 +465,17 @@ func decodeOnlyOfficePath(encoded string) string {
 	return string(decoded)
 }
 
+// onlyOfficeDocumentTypes maps the extensions OnlyOffice opens to its document type
+var onlyOfficeDocumentTypes = map[string]string{
+	".doc": "word", ".docx": "word", ".odt": "word", ".rtf": "word", ".txt": "word",
+	".xls": "cell", ".xlsx": "cell", ".ods": "cell", ".csv": "cell",
+	".ppt": "slide", ".pptx": "slide", ".odp": "slide",
+	".pdf": "word", // OnlyOffice can open PDFs in word mode
+}
+
 // getOnlyOfficeDocumentType returns document type for OnlyOffice
 func getOnlyOfficeDocumentType(ext string) string {
-	switch ext {
-	case ".doc", ".docx", ".odt", ".rtf", ".txt":
-		return "word"
-	case ".xls", ".xlsx", ".ods", ".csv":
-		return "cell"
-	case ".ppt", ".pptx", ".odp":
-		return "slide"
-	case ".pdf":
-		return "word" // OnlyOffice can open PDFs in word mode
-	default:
-		return ""
-	}
+	return onlyOfficeDocumentTypes[ext]
 }
 
 // IsOnlyOfficeSupported checks if the file extension is supported by OnlyOffice
@@ -411,3 +512,15 @@ func convertToInternalURL(externalURL string) string {
 
 	return externalURL
 }
+
+// countingReader counts the bytes read
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Co-editing: the owner of a file and everyone it is shared with open it
+// under different virtual paths (a recipient may even see it below another
+// name), so the OnlyOffice document key is derived from the canonical path,
+// the file's data-root relative path. Everyone with access joins the same
+// session; the callback carries that path and writes the owner's file.
+
+// onlyOfficeDocument is a file opened in OnlyOffice by one user
+type onlyOfficeDocument struct {
+	RealPath  string
+	Canonical string // Data-root relative: users/{owner}/... or shared/{drive}/...
+	Info      os.FileInfo
+	CanEdit   bool
+}
+
+// onlyOfficeCanonicalPath returns the data-root relative path of a file
+func onlyOfficeCanonicalPath(dataRoot, realPath string) string {
+	rel, err := dataRootRel(dataRoot, realPath)
+	if err != nil {
+		return realPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// resolveOnlyOfficeDocument resolves the file a user opens: their own file
+// or a drive file, else a file shared with them. CanEdit is false for
+// read-only drive members, level 1 share recipients, mount-ins and files
+// under a retention hold.
+func (h *Handler) resolveOnlyOfficeDocument(virtualPath string, claims *JWTClaims) (*onlyOfficeDocument, *APIError) {
+	doc := &onlyOfficeDocument{CanEdit: mountInWriteError(virtualPath) == nil}
+	shared := func() bool {
+		sharedRealPath, _, err := h.GetSharedFileOwnerPath(claims.UserID, virtualPath)
+		if err != nil {
+			return false
+		}
+		doc.RealPath = sharedRealPath
+		doc.CanEdit = h.CanWriteSharedFile(claims.UserID, virtualPath)
+		return true
+	}
+
+	realPath, storageType, _, err := h.resolvePath(virtualPath, claims)
+	isSharedFile := false
+	if err != nil || realPath == "" {
+		if !shared() {
+			return nil, ErrBadRequest("File not found or no access")
+		}
+		isSharedFile = true
+	} else {
+		doc.RealPath = realPath
+		if storageType == StorageShared && !h.CanWriteSharedDrive(claims.UserID, virtualPath) {
+			doc.CanEdit = false
+		}
+	}
+
+	info, err := statFile(doc.RealPath)
+	if err != nil && !isSharedFile && shared() {
+		// Not in the user's own home: a file shared under the same path
+		info, err = statFile(doc.RealPath)
+	}
+	if err != nil {
+		return nil, ErrNotFound("File")
+	}
+	doc.Info = info
+	doc.Canonical = onlyOfficeCanonicalPath(h.dataRoot, doc.RealPath)
+	if GetRetention().Blocks(doc.RealPath, false) {
+		doc.CanEdit = false
+	}
+	return doc, nil
+}
+
+// onlyOfficeEditorID returns the user a save is attributed to: the user who
+// requested a force save, else the author of the last change, else the
+// first user of the session. The callback URL belongs to whichever editor
+// joined last, so its token does not name the editor.
+func onlyOfficeEditorID(req *OnlyOfficeCallbackRequest, fallback string) string {
+	if req.UserData != "" {
+		return req.UserData
+	}
+	if req.History != nil {
+		for i := len(req.History.Changes) - 1; i >= 0; i-- {
+			if id := req.History.Changes[i].User.ID; id != "" {
+				return id
+			}
+		}
+	}
+	if len(req.Users) > 0 {
+		return req.Users[0]
+	}
+	return fallback
+}
+
+// onlyOfficeEditorClaims returns claims for the editor of a canonical path
+// if they may write it: the owner, a writer of the drive, or a recipient of
+// a read-write share from the owner
+func (h *Handler) onlyOfficeEditorClaims(userID, canonical string) (*JWTClaims, bool) {
+	claims := &JWTClaims{UserID: userID}
+	if err := h.db.QueryRow(`SELECT username, is_admin FROM users WHERE id = $1`, userID).
+		Scan(&claims.Username, &claims.IsAdmin); err != nil {
+		return nil, false
+	}
+
+	parts := strings.SplitN(canonical, "/", 3)
+	if len(parts) < 3 {
+		return nil, false
+	}
+	switch parts[0] {
+	case "users":
+		if parts[1] == claims.Username {
+			return claims, true
+		}
+		var level int
+		err := h.db.QueryRow(`
+			SELECT COALESCE(MAX(fs.permission_level), 0)
+			FROM file_shares fs
+			INNER JOIN users o ON o.id = fs.owner_id
+			WHERE fs.shared_with_id = $1 AND o.username = $2
+			  AND (fs.item_path = $3 OR (fs.is_folder = TRUE AND starts_with($3, fs.item_path || '/')))
+			  AND `+fileShareActiveSQL+`
+		`, userID, parts[1], "/home/"+parts[2]).Scan(&level)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, false
+		}
+		return claims, level >= 2
+	case "shared":
+		return claims, h.CanWriteSharedDrive(userID, "/"+canonical)
+	}
+	return nil, false
+}
+
+// onlyOfficeDisplayPath returns the path of a canonical file as its owner
+// sees it, for audit entries
+func onlyOfficeDisplayPath(canonical string) string {
+	if parts := strings.SplitN(canonical, "/", 3); parts[0] == "users" && len(parts) == 3 {
+		return "/home/" + parts[2]
+	}
+	return "/" + canonical
+}
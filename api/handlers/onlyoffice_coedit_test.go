@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// openOnlyOffice requests the editor config for path as a user
+func openOnlyOffice(t *testing.T, tc *TestContext, h *Handler, userID, username, path string) map[string]interface{} {
+	t.Helper()
+	tc.Recorder = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/onlyoffice/config/"+path, nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, userID, username, false)
+	c.SetParamNames("*")
+	c.SetParamValues(path)
+	if err := h.GetOnlyOfficeConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	var config map[string]interface{}
+	if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// expectFileShare mocks the share lookups of a recipient opening alice's file
+func expectFileShare(tc *TestContext, level int) {
+	tc.Mock.ExpectQuery("SELECT fs.item_path, u.username").
+		WillReturnRows(sqlmock.NewRows([]string{"item_path", "username"}).AddRow("/home/docs/report.docx", "alice"))
+	tc.Mock.ExpectQuery("SELECT permission_level FROM file_shares").
+		WillReturnRows(sqlmock.NewRows([]string{"permission_level"}).AddRow(level))
+	if level < 2 {
+		tc.Mock.ExpectQuery("SELECT item_path, permission_level FROM file_shares").
+			WillReturnRows(sqlmock.NewRows([]string{"item_path", "permission_level"}))
+	}
+}
+
+func TestOnlyOfficeConfig_SharedKey(t *testing.T) {
+	t.Setenv("ONLYOFFICE_JWT_SECRET", "")
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	_ = os.MkdirAll(filepath.Join(home, "docs"), 0755)
+	_ = os.WriteFile(filepath.Join(home, "docs", "report.docx"), []byte("doc"), 0644)
+
+	owner := openOnlyOffice(t, tc, h, "u-alice", "alice", "home/docs/report.docx")
+	expectFileShare(tc, 2)
+	editor := openOnlyOffice(t, tc, h, "u-bob", "bob", "home/docs/report.docx")
+	expectFileShare(tc, 1)
+	viewer := openOnlyOffice(t, tc, h, "u-carol", "carol", "home/docs/report.docx")
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := func(config map[string]interface{}) string {
+		return config["document"].(map[string]interface{})["key"].(string)
+	}
+	editorConfig := func(config map[string]interface{}) map[string]interface{} {
+		return config["editorConfig"].(map[string]interface{})
+	}
+	if key(owner) != key(editor) || key(owner) != key(viewer) {
+		t.Errorf("keys differ: owner %s, editor %s, viewer %s", key(owner), key(editor), key(viewer))
+	}
+	if !documentKeyMatchesPath(key(owner), "users/alice/docs/report.docx") {
+		t.Errorf("key %s is not derived from the canonical path", key(owner))
+	}
+
+	for name, config := range map[string]map[string]interface{}{"owner": owner, "editor": editor} {
+		ec := editorConfig(config)
+		callback, _ := ec["callbackUrl"].(string)
+		if ec["mode"] != "edit" || !strings.Contains(callback, "doc=users%2Falice%2Fdocs%2Freport.docx") {
+			t.Errorf("%s: mode %v, callback %q", name, ec["mode"], callback)
+		}
+	}
+	if ec := editorConfig(viewer); ec["mode"] != "view" || ec["callbackUrl"] != nil {
+		t.Errorf("level 1 recipient got mode %v, callback %v", ec["mode"], ec["callbackUrl"])
+	}
+}
+
+func TestOnlyOfficeCallback_CoEditing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("edited"))
+	}))
+	defer server.Close()
+	t.Setenv("ONLYOFFICE_INTERNAL_URL", server.URL)
+
+	for name, tt := range map[string]struct {
+		editor  string
+		level   int
+		status  int
+		content string
+	}{
+		"read-write recipient": {editor: "u-bob", level: 2, status: http.StatusOK, content: "edited"},
+		"read-only recipient":  {editor: "u-carol", level: 1, status: http.StatusForbidden, content: "doc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc, h, home := diffTestHandler(t)
+			defer tc.Cleanup()
+			h.auditHandler = NewAuditHandler(tc.DB, h.dataRoot)
+			file := filepath.Join(home, "report.docx")
+			_ = os.WriteFile(file, []byte("doc"), 0644)
+			info, _ := os.Stat(file)
+			key := generateDocumentKey("users/alice/report.docx", info.ModTime().Unix())
+
+			tc.Mock.ExpectQuery("SELECT username, is_admin FROM users").WithArgs(tt.editor).
+				WillReturnRows(sqlmock.NewRows([]string{"username", "is_admin"}).AddRow(strings.TrimPrefix(tt.editor, "u-"), false))
+			tc.Mock.ExpectQuery("FROM file_shares fs").WithArgs(tt.editor, "alice", "/home/report.docx").
+				WillReturnRows(sqlmock.NewRows([]string{"level"}).AddRow(tt.level))
+			if tt.status == http.StatusOK {
+				tc.Mock.ExpectExec("INSERT INTO audit_logs").
+					WithArgs(tt.editor, "0.0.0.0", EventFileEdit, "/home/report.docx", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			// The session's callback URL is alice's, but bob or carol made the change
+			body := fmt.Sprintf(`{"key":%q,"status":2,"url":"http://127.0.0.1/cache/report.docx","users":[%q],"history":{"changes":[{"user":{"id":%q}}]}}`, key, tt.editor, tt.editor)
+			req := httptest.NewRequest(http.MethodPost, "/api/onlyoffice/callback?path=%2Fhome%2Freport.docx&doc=users%2Falice%2Freport.docx", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.RemoteAddr = "0.0.0.0:1"
+			c := tc.Echo.NewContext(req, tc.Recorder)
+			if err := h.OnlyOfficeCallback(c); err != nil {
+				t.Fatal(err)
+			}
+			AssertStatus(t, tc.Recorder, tt.status)
+			if data, _ := os.ReadFile(file); string(data) != tt.content {
+				t.Errorf("file = %q, want %q", data, tt.content)
+			}
+			if err := tc.Mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestOnlyOfficeEditorID(t *testing.T) {
+	var req OnlyOfficeCallbackRequest
+	_ = json.Unmarshal([]byte(`{"users":["u1","u2"],"history":{"changes":[{"user":{"id":"u1"}},{"user":{"id":"u2"}}]}}`), &req)
+	if got := onlyOfficeEditorID(&req, "u3"); got != "u2" {
+		t.Errorf("editor = %s, want the last change's author", got)
+	}
+	req.UserData = "u1"
+	if got := onlyOfficeEditorID(&req, "u3"); got != "u1" {
+		t.Errorf("editor = %s, want the force save requester", got)
+	}
+	if got := onlyOfficeEditorID(&OnlyOfficeCallbackRequest{}, "u3"); got != "u3" {
+		t.Errorf("editor = %s, want the token user", got)
+	}
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// defaultOnlyOfficeCallbackBaseURL is the API address on the bundled Docker network
+const defaultOnlyOfficeCallbackBaseURL = "http://api:8080"
+
+// getOnlyOfficeCallbackBaseURL returns the API base URL the document server uses
+// to download documents and post save callbacks
+func getOnlyOfficeCallbackBaseURL() string {
+	if url := os.Getenv("ONLYOFFICE_CALLBACK_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return defaultOnlyOfficeCallbackBaseURL
+}
+
+// getOnlyOfficeJWTSecret returns the document server JWT secret (empty if JWT is disabled)
+func getOnlyOfficeJWTSecret() string {
+	return os.Getenv("ONLYOFFICE_JWT_SECRET")
+}
+
+// signOnlyOfficePayload signs a request/config payload with the document server secret
+func signOnlyOfficePayload(payload map[string]interface{}) (string, error) {
+	secret := getOnlyOfficeJWTSecret()
+	if secret == "" {
+		return "", nil
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(payload))
+	return token.SignedString([]byte(secret))
+}
+
+// OnlyOfficeDiagnosis is the result of a live OnlyOffice integration check
+type OnlyOfficeDiagnosis struct {
+	DocumentServerURL  string   `json:"documentServerUrl"`
+	Reachable          bool     `json:"reachable"`
+	Version            string   `json:"version,omitempty"`
+	JWTConfigured      bool     `json:"jwtConfigured"`
+	JWTOk              bool     `json:"jwtOk"`
+	CallbackURL        string   `json:"callbackUrl"`
+	CallbackReachable  bool     `json:"callbackReachable"`
+	PublicURL          string   `json:"publicUrl"`
+	PublicURLReachable *bool    `json:"publicUrlReachable,omitempty"`
+	Healthy            bool     `json:"healthy"`
+	Errors             []string `json:"errors"`
+}
+
+// onlyOfficeTestDoc is a one-time document served to the document server during a test
+type onlyOfficeTestDoc struct {
+	expiresAt time.Time
+	fetched   bool
+}
+
+var onlyOfficeTestDocs = struct {
+	mu   sync.Mutex
+	docs map[string]*onlyOfficeTestDoc
+}{docs: make(map[string]*onlyOfficeTestDoc)}
+
+func newOnlyOfficeTestDoc() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	key := hex.EncodeToString(b)
+
+	onlyOfficeTestDocs.mu.Lock()
+	defer onlyOfficeTestDocs.mu.Unlock()
+	now := time.Now()
+	for k, doc := range onlyOfficeTestDocs.docs {
+		if now.After(doc.expiresAt) {
+			delete(onlyOfficeTestDocs.docs, k)
+		}
+	}
+	onlyOfficeTestDocs.docs[key] = &onlyOfficeTestDoc{expiresAt: now.Add(2 * time.Minute)}
+	return key
+}
+
+func onlyOfficeTestDocFetched(key string) bool {
+	onlyOfficeTestDocs.mu.Lock()
+	defer onlyOfficeTestDocs.mu.Unlock()
+	doc, ok := onlyOfficeTestDocs.docs[key]
+	delete(onlyOfficeTestDocs.docs, key)
+	return ok && doc.fetched
+}
+
+// ServeOnlyOfficeTestDocument serves the tiny test document to the document server
+func (h *Handler) ServeOnlyOfficeTestDocument(c echo.Context) error {
+	key := c.Param("key")
+
+	onlyOfficeTestDocs.mu.Lock()
+	doc, ok := onlyOfficeTestDocs.docs[key]
+	if ok && time.Now().Before(doc.expiresAt) {
+		doc.fetched = true
+	} else {
+		ok = false
+	}
+	onlyOfficeTestDocs.mu.Unlock()
+
+	if !ok {
+		return RespondError(c, ErrNotFound("Test document"))
+	}
+	return c.Blob(http.StatusOK, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", createDocxTemplate())
+}
+
+// postOnlyOffice sends a (optionally JWT-signed) JSON request to the document server
+func postOnlyOffice(client *http.Client, endpoint string, payload map[string]interface{}) (map[string]interface{}, error) {
+	body := make(map[string]interface{}, len(payload)+1)
+	for k, v := range payload {
+		body[k] = v
+	}
+	token, err := signOnlyOfficePayload(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+	if token != "" {
+		body["token"] = token
+	}
+
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("unexpected response: %s", strings.TrimSpace(string(raw)))
+	}
+	return result, nil
+}
+
+// onlyOfficeErrorCode extracts the numeric "error" field of a document server response
+func onlyOfficeErrorCode(result map[string]interface{}) int {
+	if v, ok := result["error"].(float64); ok {
+		return int(v)
+	}
+	return 0
+}
+
+// testOnlyOfficeConversion asks the document server to convert the test document
+// served from baseURL. Returns the document server error code (0 = success) and
+// whether the document server fetched the document from us.
+func testOnlyOfficeConversion(client *http.Client, internalURL, baseURL string) (int, bool, error) {
+	key := newOnlyOfficeTestDoc()
+	result, err := postOnlyOffice(client, internalURL+"/ConvertService.ashx", map[string]interface{}{
+		"async":      false,
+		"filetype":   "docx",
+		"outputtype": "pdf",
+		"key":        "filehatch_test_" + key,
+		"title":      "filehatch-test.docx",
+		"url":        baseURL + "/api/onlyoffice/test-document/" + key,
+	})
+	fetched := onlyOfficeTestDocFetched(key)
+	if err != nil {
+		return 0, fetched, err
+	}
+	return onlyOfficeErrorCode(result), fetched, nil
+}
+
+// DiagnoseOnlyOffice runs a live check against the configured document server
+func DiagnoseOnlyOffice(publicURL string) OnlyOfficeDiagnosis {
+	internalURL := getOnlyOfficeInternalURL()
+	d := OnlyOfficeDiagnosis{
+		DocumentServerURL: internalURL,
+		JWTConfigured:     getOnlyOfficeJWTSecret() != "",
+		CallbackURL:       getOnlyOfficeCallbackBaseURL(),
+		PublicURL:         publicURL,
+		Errors:            []string{},
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+
+	// 1. Healthcheck
+	resp, err := client.Get(internalURL + "/healthcheck")
+	if err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("Document server is not reachable at %s (%v). Check ONLYOFFICE_INTERNAL_URL and that the onlyoffice container is running.", internalURL, err))
+		return d
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		d.Errors = append(d.Errors, fmt.Sprintf("Document server healthcheck at %s/healthcheck returned HTTP %d; the server may still be starting.", internalURL, resp.StatusCode))
+		return d
+	}
+	d.Reachable = true
+
+	// 2. Version (also the first JWT check: command service error 6 = invalid token)
+	jwtRejected := false
+	if result, err := postOnlyOffice(client, internalURL+onlyOfficeCommandServicePath, map[string]interface{}{"c": "version"}); err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("Could not query document server version: %v", err))
+	} else if code := onlyOfficeErrorCode(result); code == 6 {
+		jwtRejected = true
+	} else if v, ok := result["version"].(string); ok {
+		d.Version = v
+	}
+
+	// 3. Conversion round-trip: verifies the JWT secret and that the document
+	// server can download from the API
+	code, fetched, err := testOnlyOfficeConversion(client, internalURL, d.CallbackURL)
+	d.CallbackReachable = fetched
+	switch {
+	case err != nil:
+		d.Errors = append(d.Errors, fmt.Sprintf("Conversion request failed: %v", err))
+	case code == -8 || jwtRejected:
+		if d.JWTConfigured {
+			d.Errors = append(d.Errors, "Document server rejected the JWT signature. ONLYOFFICE_JWT_SECRET must match the document server's JWT_SECRET.")
+		} else {
+			d.Errors = append(d.Errors, "Document server requires JWT. Set ONLYOFFICE_JWT_SECRET to the document server's JWT_SECRET, or set JWT_ENABLED=false on the document server.")
+		}
+	case code == 0:
+		d.JWTOk = true
+		d.CallbackReachable = true
+	default:
+		// The request itself was accepted, so the signature (if any) is fine
+		d.JWTOk = true
+		if code == -4 && !fetched {
+			d.Errors = append(d.Errors, fmt.Sprintf("Document server could not download from %s. Set ONLYOFFICE_CALLBACK_URL to an API address reachable from the document server.", d.CallbackURL))
+		} else {
+			d.Errors = append(d.Errors, fmt.Sprintf("Test conversion failed with document server error %d.", code))
+		}
+	}
+
+	// 4. If the callback URL is unreachable, see whether the public URL would work
+	if !d.CallbackReachable && d.JWTOk && publicURL != "" && publicURL != d.CallbackURL {
+		_, fetched, err := testOnlyOfficeConversion(client, internalURL, publicURL)
+		reachable := err == nil && fetched
+		d.PublicURLReachable = &reachable
+		if reachable {
+			d.Errors = append(d.Errors, fmt.Sprintf("The public URL %s is reachable from the document server; set ONLYOFFICE_CALLBACK_URL=%s.", publicURL, publicURL))
+		}
+	}
+
+	d.Healthy = d.Reachable && d.JWTOk && d.CallbackReachable
+	return d
+}
+
+// TestOnlyOffice performs a live check of the OnlyOffice integration
+// @Summary		Test OnlyOffice integration
+// @Description	Checks document server reachability, version, JWT secret and whether the document server can reach back to the API
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse{data=OnlyOfficeDiagnosis}	"Diagnosis"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/onlyoffice/test [post]
+func (h *Handler) TestOnlyOffice(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermSettingsWrite); err != nil {
+		return err
+	}
+
+	publicURL := getExternalScheme(c) + "://" + getExternalHost(c)
+	diagnosis := DiagnoseOnlyOffice(publicURL)
+
+	onlyOfficeHealth.set(diagnosis.Reachable)
+	return RespondSuccess(c, diagnosis)
+}
+
+// onlyOfficeHealth caches the document server healthcheck for the readiness probe
+var onlyOfficeHealth = &onlyOfficeHealthCache{}
+
+type onlyOfficeHealthCache struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	ok        bool
+}
+
+func (c *onlyOfficeHealthCache) set(ok bool) {
+	c.mu.Lock()
+	c.ok = ok
+	c.checkedAt = time.Now()
+	c.mu.Unlock()
+}
+
+// reset makes the next Available call check the document server again
+func (c *onlyOfficeHealthCache) reset() {
+	c.mu.Lock()
+	c.checkedAt = time.Time{}
+	c.mu.Unlock()
+}
+
+// Available returns the cached healthcheck result, refreshing it every 30 seconds
+func (c *onlyOfficeHealthCache) Available() bool {
+	c.mu.Lock()
+	if time.Since(c.checkedAt) < 30*time.Second {
+		ok := c.ok
+		c.mu.Unlock()
+		return ok
+	}
+	c.mu.Unlock()
+
+	ok := checkOnlyOfficeHealth(getOnlyOfficeInternalURL())
+	c.set(ok)
+	return ok
+}
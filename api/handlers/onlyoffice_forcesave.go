@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// onlyOfficeCommandServicePath is the command service endpoint on the document server
+const onlyOfficeCommandServicePath = "/coauthoring/CommandService.ashx"
+
+// Settings controlling OnlyOffice force saves
+const (
+	settingOnlyOfficeForceSave        = "onlyoffice_forcesave_enabled"
+	settingOnlyOfficeAutosaveInterval = "onlyoffice_autosave_interval"
+	settingOnlyOfficeCommandURL       = "onlyoffice_command_url"
+)
+
+// defaultOnlyOfficeAutosaveInterval is how often, in seconds, an open editor
+// asks for a force save when the setting is not set
+const defaultOnlyOfficeAutosaveInterval = 300
+
+// OnlyOffice command service error codes
+const (
+	onlyOfficeCommandOK        = 0
+	onlyOfficeCommandNoKey     = 1 // No editing session with the given key
+	onlyOfficeCommandNoChanges = 4
+)
+
+// IsOnlyOfficeForceSaveEnabled reports whether open editors may flush their state before closing
+func (h *SettingsHandler) IsOnlyOfficeForceSaveEnabled() bool {
+	return h.GetSettingBool(settingOnlyOfficeForceSave, false)
+}
+
+// GetOnlyOfficeAutosaveInterval returns the force save interval in seconds (0 = only on demand)
+func (h *SettingsHandler) GetOnlyOfficeAutosaveInterval() int {
+	return h.GetSettingInt(settingOnlyOfficeAutosaveInterval, defaultOnlyOfficeAutosaveInterval)
+}
+
+// onlyOfficeForceSaveConfig reads the force save settings
+func onlyOfficeForceSaveConfig() (enabled bool, interval int) {
+	sh := GetGlobalSettingsHandler()
+	if sh == nil {
+		return false, 0
+	}
+	return sh.IsOnlyOfficeForceSaveEnabled(), max(sh.GetOnlyOfficeAutosaveInterval(), 0)
+}
+
+// onlyOfficeCommandURLFallback derives the command service URL from
+// ONLYOFFICE_INTERNAL_URL; empty when the variable is not set
+func onlyOfficeCommandURLFallback() string {
+	if internalURL := os.Getenv("ONLYOFFICE_INTERNAL_URL"); internalURL != "" {
+		return strings.TrimSuffix(internalURL, "/") + onlyOfficeCommandServicePath
+	}
+	return ""
+}
+
+// getOnlyOfficeCommandURL returns the document server command service URL.
+// Precedence: setting, ONLYOFFICE_INTERNAL_URL.
+func getOnlyOfficeCommandURL() string {
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		if value, _ := sh.GetSetting(settingOnlyOfficeCommandURL); strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return onlyOfficeCommandURLFallback()
+}
+
+// ValidateOnlyOfficeSettings checks OnlyOffice settings before they are saved.
+// Keys missing from pending are read from the stored settings.
+func (h *SettingsHandler) ValidateOnlyOfficeSettings(pending map[string]string) error {
+	if value, ok := pending[settingOnlyOfficeAutosaveInterval]; ok && value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("invalid value %q for %s: expected seconds (0 or more)", value, settingOnlyOfficeAutosaveInterval)
+		}
+	}
+
+	commandURL, urlPending := pending[settingOnlyOfficeCommandURL]
+	commandURL = strings.TrimSpace(commandURL)
+	if urlPending && commandURL != "" {
+		u, err := url.Parse(commandURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid value %q for %s: expected an http(s) URL", commandURL, settingOnlyOfficeCommandURL)
+		}
+	}
+
+	enabledValue, enabledPending := pending[settingOnlyOfficeForceSave]
+	if !enabledPending && !urlPending {
+		return nil
+	}
+	enabled := h.IsOnlyOfficeForceSaveEnabled()
+	if enabledPending {
+		enabled = enabledValue == "true" || enabledValue == "1" || enabledValue == "yes"
+	}
+	if !urlPending {
+		commandURL, _ = h.GetSetting(settingOnlyOfficeCommandURL)
+		commandURL = strings.TrimSpace(commandURL)
+	}
+	if enabled && commandURL == "" && onlyOfficeCommandURLFallback() == "" {
+		return fmt.Errorf("%s requires a document server command URL: set %s or ONLYOFFICE_INTERNAL_URL", settingOnlyOfficeForceSave, settingOnlyOfficeCommandURL)
+	}
+	return nil
+}
+
+// documentKeyMatchesPath reports whether a document key was generated for path
+func documentKeyMatchesPath(key, path string) bool {
+	idx := strings.LastIndex(key, "_")
+	return idx > 0 && key[:idx] == strings.TrimSuffix(generateDocumentKey(path, 0), "_0")
+}
+
+// ForceSaveRequest names the editing session to flush
+type ForceSaveRequest struct {
+	Key string `json:"key"`
+}
+
+// OnlyOfficeForceSave asks the document server to save the current state of
+// an open document. The document is written by the status 6 callback.
+// @Summary		Force save an OnlyOffice document
+// @Description	Flushes the editing session with the given document key; owner or editor only
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		path	path	string				true	"File path"
+// @Param		body	body	ForceSaveRequest	true	"Document key"
+// @Success		200		{object}	docs.SuccessResponse	"Force save requested"
+// @Failure		400		{object}	docs.ErrorResponse	"Force save disabled or invalid key"
+// @Failure		403		{object}	docs.ErrorResponse	"No write permission"
+// @Failure		404		{object}	docs.ErrorResponse	"No editing session"
+// @Security	BearerAuth
+// @Router		/onlyoffice/forcesave/{path} [post]
+func (h *Handler) OnlyOfficeForceSave(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	enabled, _ := onlyOfficeForceSaveConfig()
+	if !enabled {
+		return RespondError(c, ErrBadRequest("Force save is disabled"))
+	}
+	commandURL := getOnlyOfficeCommandURL()
+	if commandURL == "" {
+		return RespondError(c, NewAPIError(ErrCodeServiceUnavailable, "Document server command URL is not configured"))
+	}
+
+	requestPath := c.Param("*")
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	virtualPath := "/" + requestPath
+
+	var req ForceSaveRequest
+	if err := c.Bind(&req); err != nil || req.Key == "" {
+		return RespondError(c, ErrMissingParameter("key"))
+	}
+	// Owner (or shared drive writer), or a user the file is shared with for editing
+	doc, apiErr := h.resolveOnlyOfficeDocument(virtualPath, claims)
+	if apiErr != nil || !doc.CanEdit {
+		return RespondError(c, ErrForbidden("No write permission for this file"))
+	}
+	// Keys are generated per canonical path, so a key for another file is rejected
+	if !documentKeyMatchesPath(req.Key, doc.Canonical) {
+		return RespondError(c, ErrBadRequest("Document key does not belong to this file"))
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	result, err := postOnlyOffice(client, commandURL, map[string]interface{}{
+		"c":        "forcesave",
+		"key":      req.Key,
+		"userdata": claims.UserID,
+	})
+	if err != nil {
+		log.Printf("[OnlyOffice] Force save request failed for %s: %v", virtualPath, err)
+		return RespondError(c, ErrOperationFailed("request force save", err))
+	}
+
+	switch code := onlyOfficeErrorCode(result); code {
+	case onlyOfficeCommandOK:
+		return RespondSuccess(c, map[string]interface{}{"key": req.Key, "saved": true})
+	case onlyOfficeCommandNoChanges:
+		return RespondSuccess(c, map[string]interface{}{"key": req.Key, "saved": false})
+	case onlyOfficeCommandNoKey:
+		return RespondError(c, ErrNotFound("Editing session"))
+	default:
+		return RespondError(c, ErrOperationFailed("request force save", fmt.Errorf("document server error %d", code)))
+	}
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useCachedSettings installs a global settings handler serving values from
+// its cache only
+func useCachedSettings(t *testing.T, values map[string]string) *SettingsHandler {
+	t.Helper()
+	sh := NewSettingsHandler(nil)
+	for key, value := range values {
+		sh.cache[key] = settingsCacheEntry{value: value, expiresAt: time.Now().Add(time.Hour)}
+	}
+	previous := GetGlobalSettingsHandler()
+	SetGlobalSettingsHandler(sh)
+	t.Cleanup(func() { SetGlobalSettingsHandler(previous) })
+	return sh
+}
+
+func TestValidateOnlyOfficeSettings(t *testing.T) {
+	t.Setenv("ONLYOFFICE_INTERNAL_URL", "")
+	sh := useCachedSettings(t, map[string]string{
+		settingOnlyOfficeForceSave:  "false",
+		settingOnlyOfficeCommandURL: "",
+	})
+
+	if err := sh.ValidateOnlyOfficeSettings(map[string]string{settingOnlyOfficeForceSave: "true"}); err == nil {
+		t.Error("expected error enabling force save without a command URL")
+	}
+	if err := sh.ValidateOnlyOfficeSettings(map[string]string{
+		settingOnlyOfficeForceSave:  "true",
+		settingOnlyOfficeCommandURL: "http://onlyoffice/coauthoring/CommandService.ashx",
+	}); err != nil {
+		t.Errorf("unexpected error with command URL in the same update: %v", err)
+	}
+	if err := sh.ValidateOnlyOfficeSettings(map[string]string{settingOnlyOfficeCommandURL: "onlyoffice:80"}); err == nil {
+		t.Error("expected error for a command URL without scheme")
+	}
+	if err := sh.ValidateOnlyOfficeSettings(map[string]string{settingOnlyOfficeAutosaveInterval: "-5"}); err == nil {
+		t.Error("expected error for a negative interval")
+	}
+
+	t.Setenv("ONLYOFFICE_INTERNAL_URL", "http://onlyoffice")
+	if err := sh.ValidateOnlyOfficeSettings(map[string]string{settingOnlyOfficeForceSave: "true"}); err != nil {
+		t.Errorf("unexpected error with ONLYOFFICE_INTERNAL_URL set: %v", err)
+	}
+}
+
+func TestDocumentKeyMatchesPath(t *testing.T) {
+	key := generateDocumentKey("/home/report.docx", 1700000000)
+	if !documentKeyMatchesPath(key, "/home/report.docx") {
+		t.Error("key should match its own path")
+	}
+	if documentKeyMatchesPath(key, "/home/other.docx") {
+		t.Error("key should not match another path")
+	}
+}
+
+func TestOnlyOfficeForceSave_SendsCommand(t *testing.T) {
+	var command map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&command)
+		_, _ = w.Write([]byte(`{"error":0}`))
+	}))
+	defer server.Close()
+	t.Setenv("ONLYOFFICE_JWT_SECRET", "")
+	useCachedSettings(t, map[string]string{
+		settingOnlyOfficeForceSave:        "true",
+		settingOnlyOfficeAutosaveInterval: "60",
+		settingOnlyOfficeCommandURL:       server.URL,
+	})
+
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	_ = os.WriteFile(filepath.Join(home, "report.docx"), []byte("doc"), 0644)
+
+	// Keys name the canonical (data-root relative) path
+	key := generateDocumentKey("users/alice/report.docx", 1700000000)
+	req, _ := NewJSONRequest(http.MethodPost, "/api/onlyoffice/forcesave/home/report.docx", ForceSaveRequest{Key: key})
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	c.SetParamNames("*")
+	c.SetParamValues("home/report.docx")
+
+	if err := h.OnlyOfficeForceSave(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if command["c"] != "forcesave" || command["key"] != key {
+		t.Errorf("unexpected command: %v", command)
+	}
+}
+
+func TestOnlyOfficeForceSave_Rejects(t *testing.T) {
+	for name, tt := range map[string]struct {
+		enabled string
+		keyPath string
+		path    string
+		status  int
+	}{
+		"disabled":      {enabled: "false", keyPath: "users/alice/report.docx", path: "home/report.docx", status: http.StatusBadRequest},
+		"foreign key":   {enabled: "true", keyPath: "users/alice/report.docx", path: "home/other.docx", status: http.StatusBadRequest},
+		"viewer path":   {enabled: "true", keyPath: "/home/report.docx", path: "home/report.docx", status: http.StatusBadRequest},
+		"not writeable": {enabled: "true", keyPath: "/shared-with-me/report.docx", path: "shared-with-me/report.docx", status: http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			useCachedSettings(t, map[string]string{
+				settingOnlyOfficeForceSave:        tt.enabled,
+				settingOnlyOfficeAutosaveInterval: "60",
+				settingOnlyOfficeCommandURL:       "http://127.0.0.1:1",
+			})
+			tc, h, home := diffTestHandler(t)
+			defer tc.Cleanup()
+			_ = os.WriteFile(filepath.Join(home, "report.docx"), []byte("doc"), 0644)
+			_ = os.WriteFile(filepath.Join(home, "other.docx"), []byte("doc"), 0644)
+
+			key := generateDocumentKey(tt.keyPath, 1700000000)
+			req, _ := NewJSONRequest(http.MethodPost, "/api/onlyoffice/forcesave/"+tt.path, ForceSaveRequest{Key: key})
+			c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+			c.SetParamNames("*")
+			c.SetParamValues(tt.path)
+
+			if err := h.OnlyOfficeForceSave(c); err != nil {
+				t.Fatal(err)
+			}
+			AssertStatus(t, tc.Recorder, tt.status)
+		})
+	}
+}
+
+func TestOnlyOfficeCallback_ForceSaveError(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &Handler{db: tc.DB, dataRoot: t.TempDir()}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/onlyoffice/callback", strings.NewReader(`{"key":"abc_1","status":7,"forcesavetype":0}`))
+	req.Header.Set("Content-Type", "application/json")
+	c := tc.Echo.NewContext(req, tc.Recorder)
+
+	if err := h.OnlyOfficeCallback(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if body := strings.TrimSpace(tc.Recorder.Body.String()); body != `{"error":0}` {
+		t.Errorf("body = %s, want error 0", body)
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+
+	"github.com/svrforum/FileHatch/api/docs"
+)
+
+func loadOpenAPISpec(t *testing.T) map[string]any {
+	t.Helper()
+	var spec map[string]any
+	if err := json.Unmarshal(docs.OpenAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return spec
+}
+
+// specRef resolves a local "#/a/b" reference
+func specRef(t *testing.T, spec map[string]any, ref string) map[string]any {
+	t.Helper()
+	var node any = spec
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		m, _ := node.(map[string]any)
+		node = m[part]
+	}
+	resolved, ok := node.(map[string]any)
+	if !ok {
+		t.Fatalf("unresolved reference %s", ref)
+	}
+	return resolved
+}
+
+// responseSchema returns the JSON schema documented for an operation's status
+func responseSchema(t *testing.T, spec map[string]any, path, method string, status int) map[string]any {
+	t.Helper()
+	op, _ := spec["paths"].(map[string]any)[path].(map[string]any)[method].(map[string]any)
+	if op == nil {
+		t.Fatalf("%s %s is not documented", method, path)
+	}
+	resp, _ := op["responses"].(map[string]any)[strconv.Itoa(status)].(map[string]any)
+	if resp == nil {
+		t.Fatalf("%s %s does not document status %d", method, path, status)
+	}
+	if ref, ok := resp["$ref"].(string); ok {
+		resp = specRef(t, spec, ref)
+	}
+	content, _ := resp["content"].(map[string]any)["application/json"].(map[string]any)
+	if content == nil {
+		t.Fatalf("%s %s %d has no JSON body", method, path, status)
+	}
+	return content["schema"].(map[string]any)
+}
+
+// checkShape reports where value does not match schema. Only the subset of
+// JSON Schema used by openapi.json is understood; fields missing from an
+// object's properties are reported so undocumented fields are caught.
+func checkShape(t *testing.T, spec, schema map[string]any, value any, at string) {
+	t.Helper()
+	if ref, ok := schema["$ref"].(string); ok {
+		schema = specRef(t, spec, ref)
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); !nullable && schema["type"] != nil {
+			t.Errorf("%s: null is not allowed", at)
+		}
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == value
+		}
+		if !found {
+			t.Errorf("%s: %v is not one of %v", at, value, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			t.Errorf("%s: expected object, got %T", at, value)
+			return
+		}
+		for _, name := range schema["required"].([]any) {
+			if _, ok := obj[name.(string)]; !ok {
+				t.Errorf("%s: missing required %s", at, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		if len(props) == 0 {
+			return
+		}
+		for name, v := range obj {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				t.Errorf("%s: undocumented field %s", at, name)
+				continue
+			}
+			checkShape(t, spec, prop, v, at+"."+name)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			t.Errorf("%s: expected array, got %T", at, value)
+			return
+		}
+		for i, item := range items {
+			checkShape(t, spec, schema["items"].(map[string]any), item, at+"["+strconv.Itoa(i)+"]")
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			t.Errorf("%s: expected string, got %T", at, value)
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			t.Errorf("%s: expected integer, got %v", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			t.Errorf("%s: expected boolean, got %T", at, value)
+		}
+	}
+}
+
+func TestOpenAPISpec_References(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	if v, _ := spec["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		t.Fatalf("openapi = %q, want 3.x", v)
+	}
+
+	var walk func(node any)
+	walk = func(node any) {
+		switch n := node.(type) {
+		case map[string]any:
+			if ref, ok := n["$ref"].(string); ok {
+				specRef(t, spec, ref)
+			}
+			for _, v := range n {
+				walk(v)
+			}
+		case []any:
+			for _, v := range n {
+				walk(v)
+			}
+		}
+	}
+	walk(spec)
+
+	// Every error code the handlers send is documented
+	errorSchema := specRef(t, spec, "#/components/schemas/Error")
+	codes := errorSchema["properties"].(map[string]any)["code"].(map[string]any)["enum"].([]any)
+	for _, code := range []ErrorCode{ErrCodeUnauthorized, ErrCodeFileTooLarge, ErrCodeLocked, ErrCodeServiceUnavailable} {
+		found := false
+		for _, c := range codes {
+			found = found || c == string(code)
+		}
+		if !found {
+			t.Errorf("error code %s is not documented", code)
+		}
+	}
+}
+
+func TestOpenAPISpec_HandlerResponses(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+
+	for name, tt := range map[string]struct {
+		path, method string
+		status       int
+		run          func(t *testing.T, tc *TestContext, h *Handler, home string) error
+	}{
+		"list folder": {path: "/files", method: "get", status: http.StatusOK,
+			run: func(t *testing.T, tc *TestContext, h *Handler, home string) error {
+				_ = os.MkdirAll(filepath.Join(home, "docs"), 0755)
+				_ = os.WriteFile(filepath.Join(home, "a.txt"), []byte("a"), 0644)
+				req, _ := NewJSONRequest(http.MethodGet, "/api/files?path=/home", nil)
+				return h.ListFiles(CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false))
+			}},
+		"list missing folder": {path: "/files", method: "get", status: http.StatusNotFound,
+			run: func(t *testing.T, tc *TestContext, h *Handler, home string) error {
+				req, _ := NewJSONRequest(http.MethodGet, "/api/files?path=/home/missing", nil)
+				return h.ListFiles(CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false))
+			}},
+		"text diff": {path: "/files/diff", method: "post", status: http.StatusOK,
+			run: func(t *testing.T, tc *TestContext, h *Handler, home string) error {
+				_ = os.WriteFile(filepath.Join(home, "a.txt"), []byte("a\nb\n"), 0644)
+				_ = os.WriteFile(filepath.Join(home, "b.txt"), []byte("a\nc\n"), 0644)
+				req, _ := NewJSONRequest(http.MethodPost, "/api/files/diff", DiffRequest{
+					From: DiffSource{Path: "/home/a.txt"}, To: DiffSource{Path: "/home/b.txt"},
+				})
+				return h.DiffFiles(CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false))
+			}},
+		"diff too large": {path: "/files/diff", method: "post", status: http.StatusRequestEntityTooLarge,
+			run: func(t *testing.T, tc *TestContext, h *Handler, home string) error {
+				_ = os.WriteFile(filepath.Join(home, "a.txt"), []byte(strings.Repeat("x\n", maxDiffTextSize/2+1)), 0644)
+				_ = os.WriteFile(filepath.Join(home, "b.txt"), []byte("a\n"), 0644)
+				req, _ := NewJSONRequest(http.MethodPost, "/api/files/diff", DiffRequest{
+					From: DiffSource{Path: "/home/a.txt"}, To: DiffSource{Path: "/home/b.txt"},
+				})
+				return h.DiffFiles(CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false))
+			}},
+		"exposure": {path: "/files/exposure/{path}", method: "get", status: http.StatusOK,
+			run: func(t *testing.T, tc *TestContext, h *Handler, home string) error {
+				tc.Mock.ExpectQuery("FROM shares s").
+					WithArgs("u1", pq.Array([]string{"users/alice/a.txt", "users/alice"})).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "token", "path", "share_type", "has_password",
+						"require_login", "editable", "is_active", "expires_at", "max_access", "access_count"}).
+						AddRow("s1", "sh_a", "users/alice/a.txt", "download", false, false, false, true, nil, 3, 1))
+				tc.Mock.ExpectQuery("FROM file_shares fs").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "item_path", "is_folder", "shared_with_id", "username",
+						"permission_level", "status", "expires_at"}).
+						AddRow(7, "/home", true, "u2", "bob", 1, "pending", nil))
+				req, _ := NewJSONRequest(http.MethodGet, "/api/files/exposure/home/a.txt", nil)
+				c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+				c.SetParamNames("*")
+				c.SetParamValues("home/a.txt")
+				return h.GetFileExposure(c)
+			}},
+		"exposure without path": {path: "/files/exposure/{path}", method: "get", status: http.StatusBadRequest,
+			run: func(t *testing.T, tc *TestContext, h *Handler, home string) error {
+				req, _ := NewJSONRequest(http.MethodGet, "/api/files/exposure/", nil)
+				c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+				c.SetParamNames("*")
+				c.SetParamValues("")
+				return h.GetFileExposure(c)
+			}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc, h, home := diffTestHandler(t)
+			defer tc.Cleanup()
+
+			if err := tt.run(t, tc, h, home); err != nil {
+				t.Fatal(err)
+			}
+			AssertStatus(t, tc.Recorder, tt.status)
+
+			var body any
+			if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("response is not JSON: %v", err)
+			}
+			checkShape(t, spec, responseSchema(t, spec, tt.path, tt.method, tt.status), body, "body")
+		})
+	}
+}
+
+func TestAPIVersionMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Pre(APIVersionMiddleware())
+	e.GET("/api/files/*", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Param("*"))
+	})
+
+	for path, want := range map[string]struct {
+		status  int
+		body    string
+		version string
+	}{
+		"/api/v1/files/home/a%2Fb.txt": {http.StatusOK, "home/a%2Fb.txt", "1"},
+		"/api/files/home/a.txt":        {http.StatusOK, "home/a.txt", "1"},
+		"/api/v10/files/home/a.txt":    {http.StatusNotFound, "", "1"},
+		"/health":                      {http.StatusNotFound, "", ""},
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want.status || (want.body != "" && rec.Body.String() != want.body) {
+			t.Errorf("%s: %d %q, want %d %q", path, rec.Code, rec.Body.String(), want.status, want.body)
+		}
+		if got := rec.Header().Get(APIVersionHeader); got != want.version {
+			t.Errorf("%s: %s = %q, want %q", path, APIVersionHeader, got, want.version)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"fmt"
 	"io"
 	"net/url"
 	"os"
@@ -73,11 +72,20 @@ func (h *Handler) RenameItem(c echo.Context) error {
 	if storageType == "root" || displayPath == "/home" || displayPath == "/shared" {
 		return RespondError(c, ErrBadRequest("Cannot rename root folders"))
 	}
+	if apiErr := mountInMoveError(displayPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Check permissions for home folder
 	if storageType == StorageHome && claims == nil {
 		return RespondError(c, ErrUnauthorized("Authentication required"))
 	}
+	if apiErr := h.retentionError(c, claims, realPath, EventFileRename, true); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Check if source exists
 	if _, err := os.Stat(realPath); err != nil {
@@ -100,6 +108,17 @@ func (h *Handler) RenameItem(c echo.Context) error {
 	if err := os.Rename(realPath, newRealPath); err != nil {
 		return RespondError(c, ErrOperationFailed("rename item", err))
 	}
+	GetDownloadStats().MovePath(realPath, newRealPath)
+	GetFileEncryption().MovePath(realPath, newRealPath)
+	GetFileLinks().MovePath(realPath, newRealPath)
+	GetFolderDisplay().MovePath(realPath, newRealPath)
+	GetContentInspection().MovePath(realPath, newRealPath)
+	GetContentIndex().MovePath(realPath, newRealPath)
+	GetFileChecksums().MovePath(realPath, newRealPath)
+	GetDirSizes().MovePath(realPath, newRealPath)
+	GetMountIns().MovePath(realPath, newRealPath)
+	GetChangeJournal().Record(ChangeRename, newRealPath, realPath, changeActor(claims))
+	GetListingVersions().Invalidate(newRealPath, realPath)
 
 	newDisplayPath := filepath.Join(filepath.Dir(displayPath), req.NewName)
 
@@ -126,12 +145,14 @@ func (h *Handler) RenameItem(c echo.Context) error {
 
 // MoveRequest is the request body for moving files or folders
 type MoveRequest struct {
-	Destination string `json:"destination"`
+	Destination       string `json:"destination"`
+	CreateDestination bool   `json:"createDestination"` // create missing destination folders
+	OnConflict        string `json:"onConflict"`        // fail (default), overwrite, rename or skip
 }
 
 // MoveItem moves a file or folder to a new location
 // @Summary		Move item
-// @Description	Move a file or folder to a new location
+// @Description	Move a file or folder to a new location. onConflict decides what happens when the name is taken at the destination: fail (default, 409), overwrite (the existing item of the same kind goes to the trash), rename (next free "name (n)") or skip. The response reports the strategy applied in conflict (none when the name was free).
 // @Tags		Files
 // @Accept		json
 // @Produce		json
@@ -165,6 +186,10 @@ func (h *Handler) MoveItem(c echo.Context) error {
 	if req.Destination == "" {
 		return RespondError(c, ErrMissingParameter("destination"))
 	}
+	strategy, apiErr := parseConflictStrategy(req.OnConflict)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Get user claims
 	var claims *JWTClaims
@@ -172,103 +197,231 @@ func (h *Handler) MoveItem(c echo.Context) error {
 		claims = user
 	}
 
+	result, apiErr := h.moveOne(c, claims, itemOpRequest{
+		Source:            "/" + requestPath,
+		Destination:       req.Destination,
+		CreateDestination: req.CreateDestination,
+		OnConflict:        strategy,
+	})
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if result.Conflict != ConflictSkip {
+		var userID *string
+		if claims != nil {
+			userID = &claims.UserID
+		}
+		_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileMove, result.OldPath, result.auditDetails(strategy))
+	}
+
+	// Note: Move operation doesn't change total storage size, no update needed
+
+	return RespondSuccess(c, result.response())
+}
+
+// itemOpRequest describes moving or copying one item
+type itemOpRequest struct {
+	Source            string // display path of the item
+	Destination       string // display path of the destination folder
+	CreateDestination bool
+	OnConflict        string // a parsed conflict strategy
+	// PreserveAttributes keeps times and extended attributes of copies
+	PreserveAttributes bool
+}
+
+// itemOpResult is the outcome of moving, copying or trashing one item
+type itemOpResult struct {
+	OldPath         string
+	NewPath         string
+	IsDir           bool
+	Conflict        string
+	ReplacedTrashID string // item an overwrite sent to the trash
+	TrashID         string // the item itself, when it was trashed
+	Size            int64
+	CreatedDirs     *CreatedDirs
+	EntryWarning    *DirEntryWarning
+
+	chargeUserID string // copies into a home folder count against this user
+}
+
+// auditDetails describes a move or copy for its audit entry
+func (r *itemOpResult) auditDetails(strategy string) map[string]interface{} {
+	details := map[string]interface{}{
+		"destination": r.NewPath,
+		"isDir":       r.IsDir,
+		"onConflict":  strategy,
+		"conflict":    r.Conflict,
+	}
+	if r.ReplacedTrashID != "" {
+		details["replacedTrashId"] = r.ReplacedTrashID
+	}
+	if r.CreatedDirs != nil && len(r.CreatedDirs.DisplayPaths) > 0 {
+		details["createdDirs"] = r.CreatedDirs.DisplayPaths
+	}
+	return details
+}
+
+// response is the body a single move or copy answers with
+func (r *itemOpResult) response() map[string]interface{} {
+	response := map[string]interface{}{
+		"oldPath":  r.OldPath,
+		"newPath":  r.NewPath,
+		"conflict": r.Conflict,
+	}
+	if r.ReplacedTrashID != "" {
+		response["replacedTrashId"] = r.ReplacedTrashID
+	}
+	if r.EntryWarning != nil {
+		response["entryWarning"] = r.EntryWarning
+	}
+	return response
+}
+
+// moveOne moves one item with the checks of the move API. Destination
+// folders it created are removed again when the move fails.
+func (h *Handler) moveOne(c echo.Context, claims *JWTClaims, req itemOpRequest) (*itemOpResult, *APIError) {
 	// Resolve source path
-	srcRealPath, srcStorageType, srcDisplayPath, err := h.resolvePath("/"+requestPath, claims)
+	srcRealPath, srcStorageType, srcDisplayPath, err := h.resolvePath(req.Source, claims)
 	if err != nil {
-		return RespondError(c, ErrBadRequest(err.Error()))
+		return nil, ErrBadRequest(err.Error())
 	}
 
 	if srcStorageType == "root" || srcDisplayPath == "/home" || srcDisplayPath == "/shared" {
-		return RespondError(c, ErrBadRequest("Cannot move root folders"))
+		return nil, ErrBadRequest("Cannot move root folders")
 	}
 
 	// Resolve destination path
 	destRealPath, destStorageType, destDisplayPath, err := h.resolvePath(req.Destination, claims)
 	if err != nil {
-		return RespondError(c, ErrBadRequest(err.Error()))
+		return nil, ErrBadRequest(err.Error())
 	}
 
 	if destStorageType == "root" {
-		return RespondError(c, ErrBadRequest("Cannot move to root"))
+		return nil, ErrBadRequest("Cannot move to root")
+	}
+	if apiErr := mountInMoveError(srcDisplayPath); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := storageWriteError(srcRealPath); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := storageWriteError(destRealPath); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := mountInWriteError(destDisplayPath); apiErr != nil {
+		return nil, apiErr
 	}
 
 	// Check permissions
 	if (srcStorageType == StorageHome || destStorageType == StorageHome) && claims == nil {
-		return RespondError(c, ErrUnauthorized("Authentication required"))
+		return nil, ErrUnauthorized("Authentication required")
+	}
+	if apiErr := h.retentionError(c, claims, srcRealPath, EventFileMove, true); apiErr != nil {
+		return nil, apiErr
 	}
 
 	// Check if source exists
 	srcInfo, err := os.Stat(srcRealPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return RespondError(c, ErrNotFound("Source not found"))
+			return nil, ErrNotFound("Source not found")
+		}
+		return nil, ErrInternal("Failed to access source")
+	}
+
+	// Create missing destination folders if requested; roll them back on failure
+	var createdDirs *CreatedDirs
+	if req.CreateDestination {
+		var apiErr *APIError
+		if createdDirs, apiErr = h.CreateDestinationDirs(req.Destination, claims); apiErr != nil {
+			return nil, apiErr
 		}
-		return RespondError(c, ErrInternal("Failed to access source"))
 	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			RemoveCreatedDirs(createdDirs)
+		}
+	}()
 
 	// Check if destination is a directory
 	destInfo, err := os.Stat(destRealPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return RespondError(c, ErrNotFound("Destination not found"))
+			return nil, ErrNotFound("Destination not found")
 		}
-		return RespondError(c, ErrInternal("Failed to access destination"))
+		return nil, ErrInternal("Failed to access destination")
 	}
 
 	if !destInfo.IsDir() {
-		return RespondError(c, ErrBadRequest("Destination must be a directory"))
+		return nil, ErrBadRequest("Destination must be a directory")
 	}
 
-	// Build final destination path
-	finalDestPath := filepath.Join(destRealPath, srcInfo.Name())
-
-	// Check if destination already exists
-	if _, err := os.Stat(finalDestPath); err == nil {
-		return RespondError(c, ErrAlreadyExists("An item with that name already exists at destination"))
+	// Settle a name clash at the destination
+	conflict, apiErr := h.resolveConflict(c, claims, req.OnConflict, srcRealPath, srcInfo, destRealPath)
+	if apiErr != nil {
+		return nil, apiErr
 	}
-
-	// Move (rename)
-	if err := os.Rename(srcRealPath, finalDestPath); err != nil {
-		return RespondError(c, ErrOperationFailed("move item", err))
+	finalDestPath := conflict.FinalPath
+	result := &itemOpResult{
+		OldPath:     srcDisplayPath,
+		NewPath:     filepath.Join(destDisplayPath, filepath.Base(finalDestPath)),
+		IsDir:       srcInfo.IsDir(),
+		Conflict:    conflict.Applied,
+		CreatedDirs: createdDirs,
 	}
-
-	newDisplayPath := filepath.Join(destDisplayPath, srcInfo.Name())
-
-	// Log audit event
-	var userID *string
-	if claims != nil {
-		userID = &claims.UserID
+	if conflict.Applied == ConflictSkip {
+		succeeded = true
+		return result, nil
+	}
+	if result.ReplacedTrashID, apiErr = h.replaceExisting(claims, conflict, destDisplayPath); apiErr != nil {
+		return nil, apiErr
 	}
-	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileMove, srcDisplayPath, map[string]interface{}{
-		"destination": newDisplayPath,
-		"isDir":       srcInfo.IsDir(),
-	})
-
-	// Note: Move operation doesn't change total storage size, no update needed
 
-	return RespondSuccess(c, map[string]interface{}{
-		"oldPath": srcDisplayPath,
-		"newPath": newDisplayPath,
-	})
+	// Move (rename)
+	if err := os.Rename(srcRealPath, finalDestPath); err != nil {
+		return nil, ErrOperationFailed("move item", err)
+	}
+	succeeded = true
+	GetDownloadStats().MovePath(srcRealPath, finalDestPath)
+	GetFileEncryption().MovePath(srcRealPath, finalDestPath)
+	GetFileLinks().MovePath(srcRealPath, finalDestPath)
+	GetFolderDisplay().MovePath(srcRealPath, finalDestPath)
+	GetContentInspection().MovePath(srcRealPath, finalDestPath)
+	GetContentIndex().MovePath(srcRealPath, finalDestPath)
+	GetFileChecksums().MovePath(srcRealPath, finalDestPath)
+	GetDirSizes().MovePath(srcRealPath, finalDestPath)
+	GetMountIns().MovePath(srcRealPath, finalDestPath)
+	GetChangeJournal().Record(ChangeRename, finalDestPath, srcRealPath, changeActor(claims))
+	GetListingVersions().Invalidate(finalDestPath, srcRealPath)
+
+	return result, nil
 }
 
 // CopyRequest is the request body for copying files or folders
 type CopyRequest struct {
-	Destination string `json:"destination"`
+	Destination       string `json:"destination"`
+	CreateDestination bool   `json:"createDestination"` // create missing destination folders
+	OnConflict        string `json:"onConflict"`        // fail (default), overwrite, rename or skip
+	// PreserveAttributes keeps modification times, extended attributes and,
+	// within one home or drive, ownership (default true)
+	PreserveAttributes *bool `json:"preserveAttributes"`
 }
 
 // CopyItem copies a file or folder to a new location
 // @Summary		Copy item
-// @Description	Copy a file or folder to a new location
+// @Description	Copy a file or folder to a new location. onConflict decides what happens when the name is taken at the destination: fail (default, 409), overwrite (the existing item of the same kind goes to the trash once the copy is complete), rename (next free "name (n)") or skip. The response reports the strategy applied in conflict (none when the name was free). Copies keep modification times and extended attributes, and ownership within one home or shared drive, unless preserveAttributes is false.
 // @Tags		Files
 // @Accept		json
 // @Produce		json
 // @Param		path	path		string		true	"Source item path"
 // @Param		request	body		CopyRequest	true	"Destination path"
+// @Param		overrideEntryLimit	query	bool	false	"Pass the destination's entry limit (storage.admin)"
 // @Success		200		{object}	docs.SuccessResponse	"Item copied successfully"
 // @Failure		400		{object}	docs.ErrorResponse	"Bad request"
 // @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
 // @Failure		404		{object}	docs.ErrorResponse	"Item not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Item already exists or destination folder is full"
 // @Failure		500		{object}	docs.ErrorResponse	"Internal server error"
 // @Security	BearerAuth
 // @Router		/copy/{path} [post]
@@ -291,6 +444,10 @@ func (h *Handler) CopyItem(c echo.Context) error {
 	if req.Destination == "" {
 		return RespondError(c, ErrMissingParameter("destination"))
 	}
+	strategy, apiErr := parseConflictStrategy(req.OnConflict)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Get user claims
 	var claims *JWTClaims
@@ -298,118 +455,187 @@ func (h *Handler) CopyItem(c echo.Context) error {
 		claims = user
 	}
 
+	result, apiErr := h.copyOne(c, claims, itemOpRequest{
+		Source:             "/" + requestPath,
+		Destination:        req.Destination,
+		CreateDestination:  req.CreateDestination,
+		OnConflict:         strategy,
+		PreserveAttributes: req.PreserveAttributes == nil || *req.PreserveAttributes,
+	})
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if result.Conflict != ConflictSkip {
+		var userID *string
+		if claims != nil {
+			userID = &claims.UserID
+		}
+		_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileCopy, result.OldPath, result.auditDetails(strategy))
+	}
+
+	// Update storage tracking: add copied file size to user's storage
+	if result.chargeUserID != "" && result.Size > 0 {
+		_ = h.UpdateUserStorage(result.chargeUserID, result.Size)
+	}
+
+	return RespondSuccess(c, result.response())
+}
+
+// copyOne copies one item with the checks of the copy API. Destination
+// folders it created are removed again when the copy fails. The caller
+// charges Size to chargeUserID.
+func (h *Handler) copyOne(c echo.Context, claims *JWTClaims, req itemOpRequest) (*itemOpResult, *APIError) {
 	// Resolve source path
-	srcRealPath, srcStorageType, srcDisplayPath, err := h.resolvePath("/"+requestPath, claims)
+	srcRealPath, srcStorageType, srcDisplayPath, err := h.resolvePath(req.Source, claims)
 	if err != nil {
-		return RespondError(c, ErrBadRequest(err.Error()))
+		return nil, ErrBadRequest(err.Error())
 	}
 
 	if srcStorageType == "root" {
-		return RespondError(c, ErrBadRequest("Cannot copy root"))
+		return nil, ErrBadRequest("Cannot copy root")
 	}
 
 	// Resolve destination path
 	destRealPath, destStorageType, destDisplayPath, err := h.resolvePath(req.Destination, claims)
 	if err != nil {
-		return RespondError(c, ErrBadRequest(err.Error()))
+		return nil, ErrBadRequest(err.Error())
 	}
 
 	if destStorageType == "root" {
-		return RespondError(c, ErrBadRequest("Cannot copy to root"))
+		return nil, ErrBadRequest("Cannot copy to root")
+	}
+	if apiErr := mountInWriteError(destDisplayPath); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := storageWriteError(destRealPath); apiErr != nil {
+		return nil, apiErr
 	}
 
 	// Check permissions
 	if (srcStorageType == StorageHome || destStorageType == StorageHome) && claims == nil {
-		return RespondError(c, ErrUnauthorized("Authentication required"))
+		return nil, ErrUnauthorized("Authentication required")
 	}
 
 	// Check if source exists
 	srcInfo, err := os.Stat(srcRealPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return RespondError(c, ErrNotFound("Source not found"))
+			return nil, ErrNotFound("Source not found")
+		}
+		return nil, ErrInternal("Failed to access source")
+	}
+
+	// Create missing destination folders if requested; roll them back on failure
+	var createdDirs *CreatedDirs
+	if req.CreateDestination {
+		var apiErr *APIError
+		if createdDirs, apiErr = h.CreateDestinationDirs(req.Destination, claims); apiErr != nil {
+			return nil, apiErr
 		}
-		return RespondError(c, ErrInternal("Failed to access source"))
 	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			RemoveCreatedDirs(createdDirs)
+		}
+	}()
 
 	// Check if destination is a directory
 	destInfo, err := os.Stat(destRealPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return RespondError(c, ErrNotFound("Destination not found"))
+			return nil, ErrNotFound("Destination not found")
 		}
-		return RespondError(c, ErrInternal("Failed to access destination"))
+		return nil, ErrInternal("Failed to access destination")
 	}
 
 	if !destInfo.IsDir() {
-		return RespondError(c, ErrBadRequest("Destination must be a directory"))
+		return nil, ErrBadRequest("Destination must be a directory")
 	}
 
-	// Build final destination path
-	finalDestPath := filepath.Join(destRealPath, srcInfo.Name())
+	// Refuse above the destination's entry limit; warn above the threshold
+	entryWarning, apiErr := CheckDirEntries(destRealPath, destDisplayPath, 1, dirEntryOverride(c, claims))
+	if apiErr != nil {
+		return nil, apiErr
+	}
 
-	// Check if destination already exists - if so, create a copy with a number
-	baseName := srcInfo.Name()
-	ext := filepath.Ext(baseName)
-	nameWithoutExt := strings.TrimSuffix(baseName, ext)
-	counter := 1
-	for {
-		if _, err := os.Stat(finalDestPath); os.IsNotExist(err) {
-			break
-		}
-		if srcInfo.IsDir() {
-			finalDestPath = filepath.Join(destRealPath, fmt.Sprintf("%s (%d)", baseName, counter))
-		} else {
-			finalDestPath = filepath.Join(destRealPath, fmt.Sprintf("%s (%d)%s", nameWithoutExt, counter, ext))
-		}
-		counter++
+	// Settle a name clash at the destination
+	conflict, apiErr := h.resolveConflict(c, claims, req.OnConflict, srcRealPath, srcInfo, destRealPath)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	finalDestPath := conflict.FinalPath
+	result := &itemOpResult{
+		OldPath:      srcDisplayPath,
+		NewPath:      filepath.Join(destDisplayPath, filepath.Base(finalDestPath)),
+		IsDir:        srcInfo.IsDir(),
+		Conflict:     conflict.Applied,
+		CreatedDirs:  createdDirs,
+		EntryWarning: entryWarning,
+	}
+	if conflict.Applied == ConflictSkip {
+		succeeded = true
+		return result, nil
+	}
+
+	// An overwrite is copied beside the existing item, which is replaced
+	// only once the copy is complete
+	copyPath := finalDestPath
+	if conflict.Applied == ConflictOverwrite {
+		copyPath = conflictStagingPath(finalDestPath)
 	}
 
 	// Perform copy
+	keep := copyPreserveFor(req.PreserveAttributes, srcDisplayPath, destDisplayPath)
 	if srcInfo.IsDir() {
-		err = copyDir(srcRealPath, finalDestPath)
+		err = copyDir(srcRealPath, copyPath, keep)
 	} else {
-		err = copyFile(srcRealPath, finalDestPath)
+		err = copyFile(srcRealPath, copyPath, keep)
 	}
 
 	if err != nil {
-		return RespondError(c, ErrOperationFailed("copy item", err))
+		// Remove partial copy so created destination folders can be rolled back
+		_ = os.RemoveAll(copyPath)
+		return nil, ErrOperationFailed("copy item", err)
 	}
 
-	newDisplayPath := filepath.Join(destDisplayPath, filepath.Base(finalDestPath))
+	if result.ReplacedTrashID, apiErr = h.replaceExisting(claims, conflict, destDisplayPath); apiErr != nil {
+		_ = os.RemoveAll(copyPath)
+		return nil, apiErr
+	}
+	if copyPath != finalDestPath {
+		if err := os.Rename(copyPath, finalDestPath); err != nil {
+			_ = os.RemoveAll(copyPath)
+			return nil, ErrOperationFailed("replace item", err)
+		}
+	}
+	succeeded = true
 
-	// Log audit event
-	var userID *string
-	if claims != nil {
-		userID = &claims.UserID
+	_ = SealPath(finalDestPath)
+	GetChangeJournal().Record(ChangeCreate, finalDestPath, "", changeActor(claims))
+	GetListingVersions().Invalidate(finalDestPath)
+	if conflict.Applied != ConflictOverwrite {
+		GetDirEntryLimits().Added(destRealPath, 1)
 	}
-	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileCopy, srcDisplayPath, map[string]interface{}{
-		"destination": newDisplayPath,
-		"isDir":       srcInfo.IsDir(),
-	})
 
-	// Update storage tracking: add copied file size to user's storage
 	if claims != nil && destStorageType == StorageHome {
-		copiedSize, _ := GetFileSize(finalDestPath)
-		if copiedSize > 0 {
-			_ = h.UpdateUserStorage(claims.UserID, copiedSize)
-		}
+		result.Size, _ = GetFileSize(finalDestPath)
+		result.chargeUserID = claims.UserID
 	}
-
-	return RespondSuccess(c, map[string]interface{}{
-		"oldPath": srcDisplayPath,
-		"newPath": newDisplayPath,
-	})
+	return result, nil
 }
 
-// copyFile copies a single file
-func copyFile(src, dst string) error {
+// copyFile copies a single file with its mode and what keep selects
+func copyFile(src, dst string, keep copyPreserve) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer sourceFile.Close()
 
+	if err := copyOnWrite(dst, false); err != nil {
+		return err
+	}
 	destFile, err := os.Create(dst)
 	if err != nil {
 		return err
@@ -420,17 +646,24 @@ func copyFile(src, dst string) error {
 	if err != nil {
 		return err
 	}
+	if err := destFile.Close(); err != nil {
+		return err
+	}
 
 	// Copy file permissions
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
-	return os.Chmod(dst, srcInfo.Mode())
+	if err := os.Chmod(dst, srcInfo.Mode()); err != nil {
+		return err
+	}
+	return preserveAttrs(src, srcInfo, dst, keep)
 }
 
-// copyDir recursively copies a directory
-func copyDir(src, dst string) error {
+// copyDir recursively copies a directory. A folder's modification time is
+// set after its contents are written, which would change it again.
+func copyDir(src, dst string, keep copyPreserve) error {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return err
@@ -450,17 +683,17 @@ func copyDir(src, dst string) error {
 		dstPath := filepath.Join(dst, entry.Name())
 
 		if entry.IsDir() {
-			if err := copyDir(srcPath, dstPath); err != nil {
+			if err := copyDir(srcPath, dstPath, keep); err != nil {
 				return err
 			}
 		} else {
-			if err := copyFile(srcPath, dstPath); err != nil {
+			if err := copyFile(srcPath, dstPath, keep); err != nil {
 				return err
 			}
 		}
 	}
 
-	return nil
+	return preserveAttrs(src, srcInfo, dst, keep)
 }
 
 // CopyProgress represents the progress of a copy operation
@@ -474,19 +707,25 @@ type CopyProgress struct {
 	Error       string `json:"error,omitempty"`
 	NewPath     string `json:"newPath,omitempty"`
 	BytesPerSec int64  `json:"bytesPerSec,omitempty"`
+	// On "started": the destination folder is above the entry warning threshold
+	EntryWarning *DirEntryWarning `json:"entryWarning,omitempty"`
 }
 
 // CopyItemStream copies a file or folder with streaming progress via SSE
 // @Summary		Copy item with progress
-// @Description	Copy a file or folder with real-time progress updates via Server-Sent Events
+// @Description	Copy a file or folder with real-time progress updates via Server-Sent Events. The source is counted first, with "scanning" events carrying the running totals; "progress" events (bytes and files copied, current file, bytes/sec) follow at most every 200ms. Closing the stream cancels the copy and removes the partial copy.
 // @Tags		Files
 // @Produce		text/event-stream
 // @Param		path		path		string	true	"Source item path"
 // @Param		destination	query		string	true	"Destination folder path"
+// @Param		createDestination	query	bool	false	"Create missing destination folders"
+// @Param		overrideEntryLimit	query	bool	false	"Pass the destination's entry limit (storage.admin)"
+// @Param		preserveAttributes	query	bool	false	"Keep modification times, extended attributes and ownership (default true)"
 // @Success		200		{object}	CopyProgress	"SSE stream with progress updates"
 // @Failure		400		{object}	docs.ErrorResponse	"Bad request"
 // @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
 // @Failure		404		{object}	docs.ErrorResponse	"Item not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Destination folder is full"
 // @Security	BearerAuth
 // @Router		/copy-stream/{path} [get]
 func (h *Handler) CopyItemStream(c echo.Context) error {
@@ -504,6 +743,21 @@ func (h *Handler) CopyItemStream(c echo.Context) error {
 		return RespondError(c, ErrMissingParameter("destination"))
 	}
 
+	// Create missing destination folders if requested; roll them back on failure
+	var createdDirs *CreatedDirs
+	if c.QueryParam("createDestination") == "true" {
+		var apiErr *APIError
+		if createdDirs, apiErr = h.CreateDestinationDirs(destination, GetClaims(c)); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			RemoveCreatedDirs(createdDirs)
+		}
+	}()
+
 	// Resolve and validate paths
 	paths, err := h.ResolveOperationPaths(c, requestPath, destination, false)
 	if err != nil {
@@ -513,37 +767,62 @@ func (h *Handler) CopyItemStream(c echo.Context) error {
 		return RespondError(c, ErrInternal(err.Error()))
 	}
 
-	// Set up SSE and calculate stats
+	// Refuse above the destination's entry limit; warn above the threshold
+	entryWarning, apiErr := CheckDirEntries(paths.DestRealPath, paths.DestDisplayPath, 1, dirEntryOverride(c, paths.Claims))
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	// Set up SSE, then count what is to be copied; the walk reports
+	// "scanning" events and ends when the client goes away
 	sendProgress := SetupSSE(c)
-	stats := CalculateTotalSize(paths.SrcRealPath, paths.SrcInfo)
+	stats, err := ScanTotalSize(c.Request().Context(), paths.SrcRealPath, paths.SrcInfo, sendProgress)
+	if err != nil {
+		return nil
+	}
 
 	// Send started event
 	sendProgress(CopyProgress{
-		Status:     "started",
-		TotalBytes: stats.TotalBytes,
-		TotalFiles: stats.TotalFiles,
+		Status:       "started",
+		TotalBytes:   stats.TotalBytes,
+		TotalFiles:   stats.TotalFiles,
+		EntryWarning: entryWarning,
 	})
 
 	// Create copy context and perform copy
+	activity := TrackJob(c, ActivityCopy, paths.SrcDisplayPath, stats.TotalBytes)
+	defer activity.Finish()
 	ctx := NewCopyContext(stats, sendProgress)
+	ctx.Activity = activity
+	ctx.Preserve = copyPreserveFor(c.QueryParam("preserveAttributes") != "false", paths.SrcDisplayPath, paths.DestDisplayPath)
 	copyErr := ctx.CopyWithProgress(paths.SrcRealPath, paths.FinalDestPath, paths.SrcInfo.IsDir())
 
 	newDisplayPath := filepath.Join(paths.DestDisplayPath, filepath.Base(paths.FinalDestPath))
 
 	if copyErr != nil {
+		_ = os.RemoveAll(paths.FinalDestPath)
 		ctx.SendError(copyErr)
 		return nil
 	}
+	succeeded = true
+	_ = SealPath(paths.FinalDestPath)
+	GetChangeJournal().Record(ChangeCreate, paths.FinalDestPath, "", changeActor(paths.Claims))
+	GetListingVersions().Invalidate(paths.FinalDestPath)
+	GetDirEntryLimits().Added(paths.DestRealPath, 1)
 
 	// Log audit event
 	var userID *string
 	if paths.Claims != nil {
 		userID = &paths.Claims.UserID
 	}
-	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileCopy, paths.SrcDisplayPath, map[string]interface{}{
+	details := map[string]interface{}{
 		"destination": newDisplayPath,
 		"isDir":       paths.SrcInfo.IsDir(),
-	})
+	}
+	if createdDirs != nil && len(createdDirs.DisplayPaths) > 0 {
+		details["createdDirs"] = createdDirs.DisplayPaths
+	}
+	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileCopy, paths.SrcDisplayPath, details)
 
 	// Update storage tracking
 	if paths.Claims != nil && paths.DestStorageType == StorageHome {
@@ -556,11 +835,12 @@ func (h *Handler) CopyItemStream(c echo.Context) error {
 
 // MoveItemStream moves a file or folder with streaming progress via SSE
 // @Summary		Move item with progress
-// @Description	Move a file or folder with real-time progress updates via Server-Sent Events
+// @Description	Move a file or folder with real-time progress updates via Server-Sent Events. Moves within a filesystem are a rename; across filesystems the source is counted ("scanning" events), copied with "progress" events at most every 200ms and then removed. Closing the stream cancels a cross-filesystem move, removes the partial copy and leaves the source in place.
 // @Tags		Files
 // @Produce		text/event-stream
 // @Param		path		path		string	true	"Source item path"
 // @Param		destination	query		string	true	"Destination folder path"
+// @Param		createDestination	query	bool	false	"Create missing destination folders"
 // @Success		200		{object}	CopyProgress	"SSE stream with progress updates"
 // @Failure		400		{object}	docs.ErrorResponse	"Bad request"
 // @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
@@ -582,6 +862,21 @@ func (h *Handler) MoveItemStream(c echo.Context) error {
 		return RespondError(c, ErrMissingParameter("destination"))
 	}
 
+	// Create missing destination folders if requested; roll them back on failure
+	var createdDirs *CreatedDirs
+	if c.QueryParam("createDestination") == "true" {
+		var apiErr *APIError
+		if createdDirs, apiErr = h.CreateDestinationDirs(destination, GetClaims(c)); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			RemoveCreatedDirs(createdDirs)
+		}
+	}()
+
 	// Resolve and validate paths (allowSameFilename=false to generate unique names)
 	paths, err := h.ResolveOperationPaths(c, requestPath, destination, false)
 	if err != nil {
@@ -591,29 +886,41 @@ func (h *Handler) MoveItemStream(c echo.Context) error {
 		return RespondError(c, ErrInternal(err.Error()))
 	}
 
+	if apiErr := mountInMoveError(paths.SrcDisplayPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(paths.SrcRealPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := h.retentionError(c, paths.Claims, paths.SrcRealPath, EventFileMove, true); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
 	// Prevent moving a directory into itself
 	if strings.HasPrefix(paths.FinalDestPath, paths.SrcRealPath+string(os.PathSeparator)) {
 		return RespondError(c, ErrBadRequest("Cannot move directory into itself"))
 	}
 
-	// Set up SSE and calculate stats
 	sendProgress := SetupSSE(c)
-	stats := CalculateTotalSize(paths.SrcRealPath, paths.SrcInfo)
-
-	sendProgress(CopyProgress{
-		Status:     "started",
-		TotalBytes: stats.TotalBytes,
-		TotalFiles: stats.TotalFiles,
-	})
-
 	startTime := time.Now()
 	newDisplayPath := filepath.Join(paths.DestDisplayPath, filepath.Base(paths.FinalDestPath))
 
-	// Try simple rename first (instant for same filesystem)
+	// Try simple rename first (instant for same filesystem, so nothing is
+	// counted beforehand)
+	var stats FileStats
 	err = os.Rename(paths.SrcRealPath, paths.FinalDestPath)
-
-	if err != nil {
-		// Cross-device move: copy then delete
+	if err == nil {
+		sendProgress(CopyProgress{Status: "started"})
+	} else {
+		// Cross-device move: count, copy then delete
+		if stats, err = ScanTotalSize(c.Request().Context(), paths.SrcRealPath, paths.SrcInfo, sendProgress); err != nil {
+			return nil
+		}
+		sendProgress(CopyProgress{
+			Status:     "started",
+			TotalBytes: stats.TotalBytes,
+			TotalFiles: stats.TotalFiles,
+		})
 		sendProgress(CopyProgress{
 			Status:      "progress",
 			TotalBytes:  stats.TotalBytes,
@@ -621,10 +928,15 @@ func (h *Handler) MoveItemStream(c echo.Context) error {
 			CurrentFile: "Cross-device move in progress...",
 		})
 
+		activity := TrackJob(c, ActivityMove, paths.SrcDisplayPath, stats.TotalBytes)
+		defer activity.Finish()
 		ctx := NewCopyContext(stats, sendProgress)
+		ctx.Activity = activity
+		ctx.Preserve = preserveAll
 		copyErr := ctx.CopyWithProgress(paths.SrcRealPath, paths.FinalDestPath, paths.SrcInfo.IsDir())
 
 		if copyErr != nil {
+			_ = os.RemoveAll(paths.FinalDestPath)
 			ctx.SendError(copyErr)
 			return nil
 		}
@@ -637,14 +949,31 @@ func (h *Handler) MoveItemStream(c echo.Context) error {
 		}
 	}
 
+	succeeded = true
+	GetDownloadStats().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetFileEncryption().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetFileLinks().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetFolderDisplay().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetContentInspection().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetContentIndex().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetFileChecksums().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetDirSizes().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetMountIns().MovePath(paths.SrcRealPath, paths.FinalDestPath)
+	GetChangeJournal().Record(ChangeRename, paths.FinalDestPath, paths.SrcRealPath, changeActor(paths.Claims))
+	GetListingVersions().Invalidate(paths.FinalDestPath, paths.SrcRealPath)
+
 	// Log audit event
 	var userID *string
 	if paths.Claims != nil {
 		userID = &paths.Claims.UserID
 	}
-	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileMove, paths.SrcDisplayPath, map[string]interface{}{
+	details := map[string]interface{}{
 		"destination": newDisplayPath,
-	})
+	}
+	if createdDirs != nil && len(createdDirs.DisplayPaths) > 0 {
+		details["createdDirs"] = createdDirs.DisplayPaths
+	}
+	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventFileMove, paths.SrcDisplayPath, details)
 
 	// Send completed event
 	elapsed := time.Since(startTime).Seconds()
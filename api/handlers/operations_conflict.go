@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Strategies for a move or copy whose name is already taken at the destination
+const (
+	ConflictFail      = "fail"      // refuse with 409
+	ConflictOverwrite = "overwrite" // replace the existing item, which goes to the trash
+	ConflictRename    = "rename"    // use the next free "name (n)"
+	ConflictSkip      = "skip"      // leave both items alone
+)
+
+// ConflictNone is reported when the name was free
+const ConflictNone = "none"
+
+// parseConflictStrategy validates an onConflict value; empty means fail
+func parseConflictStrategy(value string) (string, *APIError) {
+	switch value {
+	case "":
+		return ConflictFail, nil
+	case ConflictFail, ConflictOverwrite, ConflictRename, ConflictSkip:
+		return value, nil
+	}
+	return "", ErrBadRequest("onConflict must be fail, overwrite, rename or skip")
+}
+
+// conflictResolution is how a move or copy of one item lands at its destination
+type conflictResolution struct {
+	FinalPath string // real path the item gets
+	Applied   string // ConflictNone or the strategy that was used
+	existing  os.FileInfo
+}
+
+// resolveConflict decides where srcInfo goes in destRealPath under strategy.
+// Overwrite refuses to replace a file with a folder or the other way round,
+// and to replace the source itself or a folder holding it.
+func (h *Handler) resolveConflict(c echo.Context, claims *JWTClaims, strategy, srcRealPath string, srcInfo os.FileInfo, destRealPath string) (*conflictResolution, *APIError) {
+	finalPath := filepath.Join(destRealPath, srcInfo.Name())
+	existing, err := os.Lstat(finalPath)
+	if os.IsNotExist(err) {
+		return &conflictResolution{FinalPath: finalPath, Applied: ConflictNone}, nil
+	}
+	if err != nil {
+		return nil, ErrOperationFailed("access destination", err)
+	}
+
+	switch strategy {
+	case ConflictRename:
+		return &conflictResolution{
+			FinalPath: GenerateUniquePath(destRealPath, srcInfo.Name(), srcInfo.IsDir(), false),
+			Applied:   ConflictRename,
+		}, nil
+	case ConflictSkip:
+		return &conflictResolution{FinalPath: finalPath, Applied: ConflictSkip}, nil
+	case ConflictOverwrite:
+		if existing.IsDir() != srcInfo.IsDir() {
+			if existing.IsDir() {
+				return nil, NewAPIError(ErrCodeConflict, "Cannot overwrite a folder with a file")
+			}
+			return nil, NewAPIError(ErrCodeConflict, "Cannot overwrite a file with a folder")
+		}
+		if finalPath == srcRealPath || strings.HasPrefix(srcRealPath, finalPath+string(os.PathSeparator)) {
+			return nil, ErrBadRequest("Cannot overwrite an item with itself or a folder containing it")
+		}
+		if claims == nil {
+			return nil, ErrUnauthorized("Authentication required to overwrite")
+		}
+		if apiErr := h.retentionError(c, claims, finalPath, EventFileDelete, true); apiErr != nil {
+			return nil, apiErr
+		}
+		return &conflictResolution{FinalPath: finalPath, Applied: ConflictOverwrite, existing: existing}, nil
+	}
+	return nil, ErrAlreadyExists("An item with that name already exists at destination")
+}
+
+// replaceExisting moves the item an overwrite replaces to the caller's trash,
+// so it stays recoverable. It returns the trash ID, or "" when nothing is
+// replaced.
+func (h *Handler) replaceExisting(claims *JWTClaims, res *conflictResolution, destDisplayPath string) (string, *APIError) {
+	if res.Applied != ConflictOverwrite {
+		return "", nil
+	}
+	displayPath := filepath.Join(destDisplayPath, filepath.Base(res.FinalPath))
+	item, apiErr := h.trashItem(claims, res.FinalPath, displayPath, res.existing)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	return item.ID, nil
+}
+
+// conflictStagingPath is a hidden sibling of finalPath an overwriting copy is
+// written to, so the replaced item is only trashed once the copy succeeded
+func conflictStagingPath(finalPath string) string {
+	return filepath.Join(filepath.Dir(finalPath), fmt.Sprintf(".%s.copy-%d", filepath.Base(finalPath), time.Now().UnixNano()))
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -51,6 +52,12 @@ func (h *Handler) ResolveOperationPaths(c echo.Context, requestPath, destination
 	if destStorageType == "root" {
 		return nil, ErrBadRequest("Cannot operate to root")
 	}
+	if apiErr := mountInWriteError(destDisplayPath); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := storageWriteError(destRealPath); apiErr != nil {
+		return nil, apiErr
+	}
 
 	// Check permissions
 	if (srcStorageType == StorageHome || destStorageType == StorageHome) && claims == nil {
@@ -95,6 +102,119 @@ func (h *Handler) ResolveOperationPaths(c echo.Context, requestPath, destination
 	}, nil
 }
 
+// MaxCreateDestinationLevels limits how many missing directory levels a
+// move/copy with createDestination may create
+const MaxCreateDestinationLevels = 5
+
+// CreatedDirs records directories created for a move/copy destination,
+// outermost first, so they can be audited and rolled back
+type CreatedDirs struct {
+	RealPaths    []string
+	DisplayPaths []string
+}
+
+// CreateDestinationDirs creates the missing components of a move/copy
+// destination. Every new name is checked with ValidateFolderName, at most
+// MaxCreateDestinationLevels levels are created and the storage root itself
+// (home or shared drive) is never created.
+func (h *Handler) CreateDestinationDirs(destination string, claims *JWTClaims) (*CreatedDirs, *APIError) {
+	destRealPath, destStorageType, destDisplayPath, err := h.resolvePath(destination, claims)
+	if err != nil {
+		return nil, ErrBadRequest(err.Error())
+	}
+	if destStorageType != StorageHome && destStorageType != StorageShared {
+		return nil, ErrBadRequest("Destination cannot be created here")
+	}
+	if claims == nil {
+		return nil, ErrUnauthorized("Authentication required")
+	}
+	if apiErr := mountInWriteError(destination); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := storageWriteError(destRealPath); apiErr != nil {
+		return nil, apiErr
+	}
+	if destStorageType == StorageShared && !h.CanWriteSharedDrive(claims.UserID, destination) {
+		return nil, ErrForbidden("No permission to create folders in this shared drive")
+	}
+
+	// Walk up until an existing directory is found
+	var missing []string
+	current := destRealPath
+	for {
+		info, statErr := os.Stat(current)
+		if statErr == nil {
+			if !info.IsDir() {
+				return nil, ErrBadRequest("Destination must be a directory")
+			}
+			break
+		}
+		if !os.IsNotExist(statErr) {
+			return nil, ErrInternal("Failed to access destination")
+		}
+		missing = append([]string{current}, missing...)
+		current = filepath.Dir(current)
+	}
+
+	created := &CreatedDirs{}
+	if len(missing) == 0 {
+		return created, nil
+	}
+
+	// The parent of the first missing directory must be inside a storage root,
+	// never the users/ or shared/ container itself
+	storageParent := filepath.Dir(missing[0])
+	if storageParent == filepath.Join(h.dataRoot, "users") || storageParent == filepath.Join(h.dataRoot, "shared") {
+		return nil, ErrNotFound("Destination")
+	}
+	if len(missing) > MaxCreateDestinationLevels {
+		return nil, ErrBadRequest(fmt.Sprintf("Cannot create more than %d destination folder levels", MaxCreateDestinationLevels))
+	}
+	for _, dir := range missing {
+		if err := ValidateFolderName(filepath.Base(dir)); err != nil {
+			return nil, ErrBadRequest(err.Error())
+		}
+	}
+
+	for i, dir := range missing {
+		var mkErr error
+		if destStorageType == StorageShared {
+			// Shared drive: group-writable with users group for SMB access
+			if mkErr = os.Mkdir(dir, SharedDirPerm); mkErr == nil {
+				_ = os.Chown(dir, -1, UsersGroupID)
+			}
+		} else {
+			mkErr = os.Mkdir(dir, 0755)
+		}
+		if mkErr != nil && !os.IsExist(mkErr) {
+			RemoveCreatedDirs(created)
+			return nil, ErrOperationFailed("create destination", mkErr)
+		}
+		if mkErr == nil {
+			// Display path of this level: strip the deeper missing components
+			display := destDisplayPath
+			for j := len(missing) - 1; j > i; j-- {
+				display = filepath.Dir(display)
+			}
+			created.RealPaths = append(created.RealPaths, dir)
+			created.DisplayPaths = append(created.DisplayPaths, display)
+		}
+	}
+
+	return created, nil
+}
+
+// RemoveCreatedDirs rolls back directories created by CreateDestinationDirs.
+// Only empty directories are removed, deepest first.
+func RemoveCreatedDirs(created *CreatedDirs) {
+	if created == nil {
+		return
+	}
+	for i := len(created.RealPaths) - 1; i >= 0; i-- {
+		_ = os.Remove(created.RealPaths[i])
+	}
+}
+
 // GenerateUniquePath generates a unique path for the destination, handling duplicates
 func GenerateUniquePath(destDir, baseName string, isDir, allowSameFilename bool) string {
 	finalPath := filepath.Join(destDir, baseName)
@@ -168,6 +288,37 @@ func CalculateTotalSize(path string, info os.FileInfo) FileStats {
 	return stats
 }
 
+// progressInterval is how often copy and move streams report progress
+const progressInterval = 200 * time.Millisecond
+
+// ScanTotalSize counts bytes and files like CalculateTotalSize while sending
+// "scanning" events with the running totals every progressInterval, so the
+// walk of a large tree keeps the stream (and proxies in front of it) alive.
+// It stops with ctx's error when ctx is done.
+func ScanTotalSize(ctx context.Context, path string, info os.FileInfo, send ProgressSender) (FileStats, error) {
+	if !info.IsDir() {
+		return CalculateTotalSize(path, info), nil
+	}
+
+	stats := FileStats{}
+	lastSent := time.Now()
+	err := filepath.Walk(path, func(_ string, fi os.FileInfo, _ error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fi != nil && !fi.IsDir() {
+			stats.TotalBytes += fi.Size()
+			stats.TotalFiles++
+		}
+		if time.Since(lastSent) >= progressInterval {
+			send(CopyProgress{Status: "scanning", TotalBytes: stats.TotalBytes, TotalFiles: stats.TotalFiles})
+			lastSent = time.Now()
+		}
+		return nil
+	})
+	return stats, err
+}
+
 // CopyContext holds the state for a copy operation with progress tracking
 type CopyContext struct {
 	TotalBytes       int64
@@ -177,6 +328,8 @@ type CopyContext struct {
 	StartTime        time.Time
 	LastProgressTime time.Time
 	SendProgress     ProgressSender
+	Activity         *Activity // Live activity entry (optional); cancelling it aborts the copy
+	Preserve         copyPreserve
 }
 
 // NewCopyContext creates a new CopyContext
@@ -199,16 +352,12 @@ func (ctx *CopyContext) CopyFileWithProgress(src, dst string) error {
 
 	srcStat, _ := sourceFile.Stat()
 
-	// Send progress for current file
-	ctx.SendProgress(CopyProgress{
-		Status:      "progress",
-		TotalBytes:  ctx.TotalBytes,
-		CopiedBytes: ctx.CopiedBytes,
-		CurrentFile: filepath.Base(src),
-		TotalFiles:  ctx.TotalFiles,
-		CopiedFiles: ctx.CopiedFiles,
-	})
+	// Report the current file, unless many small files go by quickly
+	ctx.reportProgress(filepath.Base(src))
 
+	if err := copyOnWrite(dst, false); err != nil {
+		return err
+	}
 	destFile, err := os.Create(dst)
 	if err != nil {
 		return err
@@ -217,6 +366,9 @@ func (ctx *CopyContext) CopyFileWithProgress(src, dst string) error {
 
 	buf := make([]byte, 1024*1024) // 1MB buffer
 	for {
+		if err := ctx.Activity.Err(); err != nil {
+			return err
+		}
 		n, readErr := sourceFile.Read(buf)
 		if n > 0 {
 			_, writeErr := destFile.Write(buf[:n])
@@ -224,25 +376,11 @@ func (ctx *CopyContext) CopyFileWithProgress(src, dst string) error {
 				return writeErr
 			}
 			ctx.CopiedBytes += int64(n)
-
-			// Send progress every 200ms
-			if time.Since(ctx.LastProgressTime) > 200*time.Millisecond {
-				elapsed := time.Since(ctx.StartTime).Seconds()
-				var bytesPerSec int64
-				if elapsed > 0 {
-					bytesPerSec = int64(float64(ctx.CopiedBytes) / elapsed)
-				}
-				ctx.SendProgress(CopyProgress{
-					Status:      "progress",
-					TotalBytes:  ctx.TotalBytes,
-					CopiedBytes: ctx.CopiedBytes,
-					CurrentFile: filepath.Base(src),
-					TotalFiles:  ctx.TotalFiles,
-					CopiedFiles: ctx.CopiedFiles,
-					BytesPerSec: bytesPerSec,
-				})
-				ctx.LastProgressTime = time.Now()
+			ctx.Activity.SetBytes(ctx.CopiedBytes)
+			if err := ctx.Activity.Pace(); err != nil {
+				return err
 			}
+			ctx.reportProgress(filepath.Base(src))
 		}
 		if readErr == io.EOF {
 			break
@@ -253,7 +391,36 @@ func (ctx *CopyContext) CopyFileWithProgress(src, dst string) error {
 	}
 
 	ctx.CopiedFiles++
-	return os.Chmod(dst, srcStat.Mode())
+	if err := destFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, srcStat.Mode()); err != nil {
+		return err
+	}
+	return preserveAttrs(src, srcStat, dst, ctx.Preserve)
+}
+
+// reportProgress sends a progress event with the average speed so far, at
+// most every progressInterval
+func (ctx *CopyContext) reportProgress(currentFile string) {
+	if time.Since(ctx.LastProgressTime) < progressInterval {
+		return
+	}
+	elapsed := time.Since(ctx.StartTime).Seconds()
+	var bytesPerSec int64
+	if elapsed > 0 {
+		bytesPerSec = int64(float64(ctx.CopiedBytes) / elapsed)
+	}
+	ctx.SendProgress(CopyProgress{
+		Status:      "progress",
+		TotalBytes:  ctx.TotalBytes,
+		CopiedBytes: ctx.CopiedBytes,
+		CurrentFile: currentFile,
+		TotalFiles:  ctx.TotalFiles,
+		CopiedFiles: ctx.CopiedFiles,
+		BytesPerSec: bytesPerSec,
+	})
+	ctx.LastProgressTime = time.Now()
 }
 
 // CopyDirWithProgress recursively copies a directory with progress tracking
@@ -273,6 +440,10 @@ func (ctx *CopyContext) CopyDirWithProgress(src, dst string) error {
 	}
 
 	for _, entry := range entries {
+		// Stop between entries too, for trees of empty files and folders
+		if err := ctx.Activity.Err(); err != nil {
+			return err
+		}
 		srcPath := filepath.Join(src, entry.Name())
 		dstPath := filepath.Join(dst, entry.Name())
 
@@ -287,7 +458,8 @@ func (ctx *CopyContext) CopyDirWithProgress(src, dst string) error {
 		}
 	}
 
-	return nil
+	// After the contents, which change the folder's time
+	return preserveAttrs(src, srcInfo, dst, ctx.Preserve)
 }
 
 // CopyWithProgress copies a file or directory with progress tracking
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cancelOnProgress is an SSE response that cancels the request once the
+// first progress event went out, like a client closing the stream
+type cancelOnProgress struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (w *cancelOnProgress) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte(`"status":"progress"`)) {
+		w.cancel()
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestCopyItemStream_CancelRemovesPartialCopy(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+
+	userDir := ftc.CreateTestUser(t, "testuser")
+	for _, name := range []string{"a.bin", "b.bin", "sub/c.bin"} {
+		ftc.CreateTestFile(t, filepath.Join(userDir, "big", name), bytes.Repeat([]byte{1}, 3<<20))
+	}
+	ftc.CreateTestFolder(t, filepath.Join(userDir, "dest"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancelOnProgress{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	req := httptest.NewRequest(http.MethodGet, "/api/files/copy-stream/home/big?destination=/home/dest", nil).WithContext(ctx)
+	c := ftc.Echo.NewContext(req, w)
+	c.Set("user", &JWTClaims{UserID: "1", Username: "testuser"})
+	c.SetParamNames("*")
+	c.SetParamValues("home/big")
+
+	if err := ftc.Handler.CopyItemStream(c); err != nil {
+		t.Fatal(err)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, `"status":"started","totalBytes":9437184`) || !strings.Contains(body, `"status":"error"`) {
+		t.Errorf("events = %s", body)
+	}
+	if _, err := os.Stat(filepath.Join(userDir, "dest", "big")); !os.IsNotExist(err) {
+		t.Error("partial copy left behind")
+	}
+	if _, err := os.Stat(filepath.Join(userDir, "big", "sub", "c.bin")); err != nil {
+		t.Error("source changed")
+	}
+}
+
+func TestScanTotalSize(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, "a.txt", "docs/b.txt", "docs/deep/c.txt")
+	info, _ := os.Stat(root)
+
+	stats, err := ScanTotalSize(context.Background(), root, info, func(CopyProgress) {})
+	if err != nil || stats.TotalFiles != 3 || stats.TotalBytes != int64(len("a.txt")+len("docs/b.txt")+len("docs/deep/c.txt")) {
+		t.Errorf("stats = %+v, %v", stats, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ScanTotalSize(ctx, root, info, func(CopyProgress) {}); err != context.Canceled {
+		t.Errorf("cancelled scan = %v", err)
+	}
+}
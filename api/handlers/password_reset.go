@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password token purposes
+const (
+	PasswordTokenReset = "reset" // Self-service reset requested by the user
+	PasswordTokenSetup = "setup" // Welcome mail of an account created by an admin
+)
+
+const (
+	passwordResetTTL = time.Hour
+	passwordSetupTTL = 72 * time.Hour
+	// passwordResetMaxAccounts bounds how many accounts sharing an email
+	// address get a reset link from one request
+	passwordResetMaxAccounts = 5
+)
+
+// PasswordResetRequest asks for a reset link; username may also be the
+// account's email address
+type PasswordResetRequest struct {
+	Username string `json:"username"`
+}
+
+// ResetPasswordRequest sets a new password with a reset or welcome token
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func hashPasswordToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createPasswordToken issues a single-use token for a user. Unused tokens
+// of the same purpose stop working, so only the latest link is good.
+func createPasswordToken(db *sql.DB, userID, purpose string, ttl time.Duration) (string, time.Time, error) {
+	token, err := GenerateURLSafeToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(ttl)
+	if _, err := db.Exec(`
+		UPDATE password_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`, userID, purpose); err != nil {
+		return "", time.Time{}, err
+	}
+	if _, err := db.Exec(`
+		INSERT INTO password_tokens (user_id, token_hash, purpose, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, hashPasswordToken(token), purpose, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	_, _ = db.Exec(`DELETE FROM password_tokens WHERE expires_at < NOW() - INTERVAL '7 days'`)
+	return token, expiresAt, nil
+}
+
+// passwordLink returns the page of the web UI that takes a password token
+func passwordLink(baseURL, token string) string {
+	return baseURL + "/reset-password?token=" + url.QueryEscape(token)
+}
+
+// appBaseURL returns the external URL of the web UI: EXTERNAL_URL, or the
+// scheme and host the request came in on
+func appBaseURL(c echo.Context) string {
+	if extURL := getExternalURL(); extURL != "" {
+		return extURL
+	}
+	return getExternalScheme(c) + "://" + getExternalHost(c)
+}
+
+// renderPasswordMail renders a reset or welcome mail
+func renderPasswordMail(purpose, username, link string, expiresAt time.Time) (subject, text, body string) {
+	var intro, action string
+	if purpose == PasswordTokenSetup {
+		subject = "Welcome to FileHatch"
+		intro = fmt.Sprintf("An account named %s has been created for you.", username)
+		action = "Set your password"
+	} else {
+		subject = "Reset your FileHatch password"
+		intro = fmt.Sprintf("A password reset was requested for the account %s. If this was not you, ignore this email.", username)
+		action = "Choose a new password"
+	}
+	valid := fmt.Sprintf("The link works once, until %s.", expiresAt.UTC().Format("2006-01-02 15:04 MST"))
+	text = fmt.Sprintf("%s\n\n%s: %s\n\n%s\n", intro, action, link, valid)
+	body = fmt.Sprintf("<p>%s</p><p><a href=\"%s\">%s</a></p><p>%s</p>",
+		html.EscapeString(intro), html.EscapeString(link), html.EscapeString(action), html.EscapeString(valid))
+	return subject, text, body
+}
+
+// sendPasswordMail issues a token for a user and mails them the link
+func (h *AuthHandler) sendPasswordMail(userID, username, email, purpose, baseURL string) error {
+	ttl := passwordResetTTL
+	if purpose == PasswordTokenSetup {
+		ttl = passwordSetupTTL
+	}
+	token, expiresAt, err := createPasswordToken(h.db, userID, purpose, ttl)
+	if err != nil {
+		return err
+	}
+	subject, text, body := renderPasswordMail(purpose, username, passwordLink(baseURL, token), expiresAt)
+	return GetMailer().Send(Mail{To: email, Subject: subject, Text: text, HTML: body})
+}
+
+// RequestPasswordReset mails a reset link to the account's address
+// @Summary		Request a password reset
+// @Description	Mails a single-use link, valid for one hour, to set a new password. Works for active local accounts with an email address; username may also be the email address. The response is the same whether or not an account matched. Requires SMTP and EXTERNAL_URL.
+// @Tags		Auth
+// @Accept		json
+// @Produce		json
+// @Param		request	body		PasswordResetRequest	true	"Account"
+// @Success		200		{object}	docs.SuccessResponse	"Request accepted"
+// @Failure		503		{object}	docs.ErrorResponse	"Password reset by email is not available"
+// @Router		/auth/password-reset/request [post]
+func (h *AuthHandler) RequestPasswordReset(c echo.Context) error {
+	var req PasswordResetRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	login := strings.TrimSpace(req.Username)
+	if login == "" {
+		return RespondError(c, ErrMissingParameter("username"))
+	}
+	// The link must not follow the Host header of an anonymous request
+	baseURL := getExternalURL()
+	if !GetMailer().Configured() || baseURL == "" {
+		return RespondError(c, NewAPIError(ErrCodeServiceUnavailable, "Password reset by email is not available"))
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, username, email
+		FROM users
+		WHERE (username = $1 OR LOWER(email) = LOWER($1))
+		  AND is_active = TRUE AND COALESCE(provider, 'local') = 'local'
+		  AND COALESCE(email, '') <> ''
+		ORDER BY username
+		LIMIT $2
+	`, login, passwordResetMaxAccounts)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	type account struct{ id, username, email string }
+	var accounts []account
+	for rows.Next() {
+		var a account
+		if err := rows.Scan(&a.id, &a.username, &a.email); err == nil {
+			accounts = append(accounts, a)
+		}
+	}
+	rows.Close()
+
+	ip := c.RealIP()
+	for _, a := range accounts {
+		_ = h.auditHandler.LogEvent(&a.id, ip, EventUserPasswordResetRequest, a.username, nil)
+	}
+	// Mail in the background so the response takes as long for an unknown account
+	go func() {
+		for _, a := range accounts {
+			if err := h.sendPasswordMail(a.id, a.username, a.email, PasswordTokenReset, baseURL); err != nil {
+				log.Printf("[PasswordReset] Failed to send reset mail to %s: %v", a.username, err)
+			}
+		}
+	}()
+
+	return RespondSuccess(c, map[string]interface{}{
+		"message": "If the account exists and has an email address, a reset link has been sent",
+	})
+}
+
+// CheckPasswordToken tells the reset page whether a token is still good
+// @Summary		Check a password token
+// @Description	Returns the account and purpose of a reset or welcome token without using it up
+// @Tags		Auth
+// @Accept		json
+// @Produce		json
+// @Param		request	body		object{token=string}	true	"Token"
+// @Success		200		{object}	docs.SuccessResponse	"Token is valid"
+// @Failure		404		{object}	docs.ErrorResponse	"Token is invalid, used or expired"
+// @Router		/auth/password-reset/check [post]
+func (h *AuthHandler) CheckPasswordToken(c echo.Context) error {
+	var req ResetPasswordRequest
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	var username, purpose string
+	var expiresAt time.Time
+	err := h.db.QueryRow(`
+		SELECT u.username, t.purpose, t.expires_at
+		FROM password_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > NOW() AND u.is_active = TRUE
+	`, hashPasswordToken(req.Token)).Scan(&username, &purpose, &expiresAt)
+	if err == sql.ErrNoRows {
+		return RespondError(c, NewAPIError(ErrCodeNotFound, "Password link is invalid, used or expired"))
+	}
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"username":  username,
+		"purpose":   purpose,
+		"expiresAt": expiresAt,
+	})
+}
+
+// ResetPassword sets a new password with a reset or welcome token
+// @Summary		Reset password
+// @Description	Sets the password of the token's account. The token is used up, and all sessions of the account end.
+// @Tags		Auth
+// @Accept		json
+// @Produce		json
+// @Param		request	body		ResetPasswordRequest	true	"Token and new password"
+// @Success		200		{object}	docs.SuccessResponse	"Password set"
+// @Failure		400		{object}	docs.ErrorResponse	"Password does not meet the policy"
+// @Failure		404		{object}	docs.ErrorResponse	"Token is invalid, used or expired"
+// @Router		/auth/password-reset [post]
+func (h *AuthHandler) ResetPassword(c echo.Context) error {
+	var req ResetPasswordRequest
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if err := ValidatePassword(req.Password); err != nil {
+		return RespondError(c, ErrBadRequest(err.Error()))
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to hash password"))
+	}
+
+	// Using up the token and setting the password commit together
+	var userID, purpose, username string
+	err = WithTransaction(h.db, func(tx *sql.Tx) error {
+		if err := tx.QueryRow(`
+			UPDATE password_tokens t SET used_at = NOW()
+			FROM users u
+			WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > NOW()
+			  AND u.id = t.user_id AND u.is_active = TRUE
+			RETURNING t.user_id, t.purpose, u.username
+		`, hashPasswordToken(req.Token)).Scan(&userID, &purpose, &username); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2
+		`, string(passwordHash), userID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return RespondError(c, NewAPIError(ErrCodeNotFound, "Password link is invalid, used or expired"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("update password", err))
+	}
+
+	if sessions := GetSessions(); sessions != nil {
+		if _, err := sessions.RevokeAll(userID); err != nil {
+			log.Printf("[PasswordReset] Failed to end sessions of %s: %v", username, err)
+		}
+	}
+	_ = h.auditHandler.LogEvent(&userID, c.RealIP(), EventUserPasswordReset, username, map[string]interface{}{
+		"purpose": purpose,
+	})
+
+	return RespondSuccess(c, map[string]interface{}{
+		"message":  "Password updated",
+		"username": username,
+	})
+}
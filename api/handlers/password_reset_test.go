@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+)
+
+func postPasswordReset(t *testing.T, tc *TestContext, handler echo.HandlerFunc, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := NewJSONRequest(http.MethodPost, path, body)
+	rec := httptest.NewRecorder()
+	if err := handler(tc.Echo.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestRequestPasswordReset_RequiresExternalURL(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &AuthHandler{db: tc.DB, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	useTestMailer(t)
+	t.Setenv("EXTERNAL_URL", "")
+
+	rec := postPasswordReset(t, tc, h.RequestPasswordReset, "/api/auth/password-reset/request", PasswordResetRequest{Username: "alice"})
+	AssertStatus(t, rec, http.StatusServiceUnavailable)
+}
+
+func TestRequestPasswordReset_MailsSingleUseLink(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &AuthHandler{db: tc.DB, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	t.Setenv("EXTERNAL_URL", "https://files.example.com/")
+	mails := make(chan string, 1)
+	previous := globalMailer
+	globalMailer = &Mailer{host: "smtp.example.com", port: "587", from: "fh@example.com",
+		send: func(from string, to []string, msg []byte) error {
+			mails <- to[0]
+			return nil
+		}}
+	defer func() { globalMailer = previous }()
+
+	tc.Mock.ExpectQuery("FROM users").
+		WithArgs("alice@example.com", passwordResetMaxAccounts).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email"}).AddRow("u-alice", "alice", "alice@example.com"))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("u-alice", sqlmock.AnyArg(), EventUserPasswordResetRequest, "alice", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// Earlier links stop working when a new one is issued
+	tc.Mock.ExpectExec("UPDATE password_tokens SET used_at").
+		WithArgs("u-alice", PasswordTokenReset).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	var tokenHash string
+	tc.Mock.ExpectExec("INSERT INTO password_tokens").
+		WithArgs("u-alice", capturedArg{&tokenHash}, PasswordTokenReset, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	tc.Mock.ExpectExec("DELETE FROM password_tokens").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rec := postPasswordReset(t, tc, h.RequestPasswordReset, "/api/auth/password-reset/request", PasswordResetRequest{Username: " alice@example.com "})
+	AssertStatus(t, rec, http.StatusOK)
+
+	select {
+	case to := <-mails:
+		if to != "alice@example.com" {
+			t.Errorf("mailed %s", to)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reset mail sent")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	// Only the hash of the token is stored
+	if len(tokenHash) != 64 {
+		t.Errorf("stored token hash %q", tokenHash)
+	}
+}
+
+func TestRenderPasswordMail(t *testing.T) {
+	expires := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	link := passwordLink("https://files.example.com", "a+b")
+	if link != "https://files.example.com/reset-password?token=a%2Bb" {
+		t.Errorf("link %s", link)
+	}
+	subject, text, body := renderPasswordMail(PasswordTokenSetup, "bob", link, expires)
+	if subject != "Welcome to FileHatch" || !strings.Contains(text, link) || !strings.Contains(text, "2026-10-16 12:00 UTC") {
+		t.Errorf("setup mail %q\n%s", subject, text)
+	}
+	if !strings.Contains(body, `href="https://files.example.com/reset-password?token=a%2Bb"`) {
+		t.Errorf("setup mail HTML %s", body)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &AuthHandler{db: tc.DB, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	previous := globalSessions
+	globalSessions = nil
+	defer func() { globalSessions = previous }()
+
+	// Weak passwords are refused before the token is touched
+	rec := postPasswordReset(t, tc, h.ResetPassword, "/api/auth/password-reset", ResetPasswordRequest{Token: "tok", Password: "short"})
+	AssertStatus(t, rec, http.StatusBadRequest)
+
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectQuery("UPDATE password_tokens t SET used_at").
+		WithArgs(hashPasswordToken("tok")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "purpose", "username"}).AddRow("u-alice", PasswordTokenReset, "alice"))
+	tc.Mock.ExpectExec("UPDATE users SET password_hash").
+		WithArgs(sqlmock.AnyArg(), "u-alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("u-alice", sqlmock.AnyArg(), EventUserPasswordReset, "alice", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	rec = postPasswordReset(t, tc, h.ResetPassword, "/api/auth/password-reset", ResetPasswordRequest{Token: "tok", Password: "N3w-Passw0rd!"})
+	AssertStatus(t, rec, http.StatusOK)
+
+	// A used or expired token matches no row
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectQuery("UPDATE password_tokens t SET used_at").
+		WithArgs(hashPasswordToken("tok")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "purpose", "username"}))
+	tc.Mock.ExpectRollback()
+	rec = postPasswordReset(t, tc, h.ResetPassword, "/api/auth/password-reset", ResetPasswordRequest{Token: "tok", Password: "N3w-Passw0rd!"})
+	AssertStatus(t, rec, http.StatusNotFound)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -73,10 +73,10 @@ func (p *PermissionChecker) CheckSharedFolderAccess(userID string, folderName st
 	var folderID string
 	var permissionLevel int
 	err := p.db.QueryRow(`
-		SELECT sf.id, sfm.permission_level
+		SELECT sf.id, sfa.permission_level
 		FROM shared_folders sf
-		INNER JOIN shared_folder_members sfm ON sf.id = sfm.shared_folder_id
-		WHERE sf.name = $1 AND sfm.user_id = $2 AND sf.is_active = TRUE
+		INNER JOIN shared_folder_access sfa ON sf.id = sfa.shared_folder_id
+		WHERE sf.name = $1 AND sfa.user_id = $2 AND sf.is_active = TRUE
 	`, folderName, userID).Scan(&folderID, &permissionLevel)
 
 	var result *ACLResult
@@ -154,10 +154,10 @@ func (p *PermissionChecker) CheckFileShareAccess(userID string, filePath string)
 	var permissionLevel int
 	err := p.db.QueryRow(`
 		SELECT id, permission_level
-		FROM file_shares
+		FROM file_shares fs
 		WHERE shared_with_id = $1
-		AND file_path = $2
-		AND (expires_at IS NULL OR expires_at > NOW())
+		AND item_path = $2
+		AND `+fileShareActiveSQL+`
 	`, userID, filePath).Scan(&shareID, &permissionLevel)
 
 	if err == sql.ErrNoRows {
@@ -248,6 +248,10 @@ func (h *Handler) resolvePathWithACL(virtualPath string, claims *JWTClaims, requ
 		return "", "", "", nil, err
 	}
 
+	if requireWrite && mountInWriteError(displayPath) != nil {
+		return "", "", "", nil, fmt.Errorf("access denied: read-only mount")
+	}
+
 	// If it's a shared folder, check ACL
 	if storageType == StorageShared {
 		folderName := ExtractSharedFolderName(virtualPath)
@@ -300,8 +304,8 @@ func (p *PermissionChecker) ListAccessibleSharedFolders(userID string) ([]string
 	rows, err := p.db.Query(`
 		SELECT sf.name
 		FROM shared_folders sf
-		INNER JOIN shared_folder_members sfm ON sf.id = sfm.shared_folder_id
-		WHERE sfm.user_id = $1 AND sf.is_active = TRUE
+		INNER JOIN shared_folder_access sfa ON sf.id = sfa.shared_folder_id
+		WHERE sfa.user_id = $1 AND sf.is_active = TRUE
 		ORDER BY sf.name
 	`, userID)
 	if err != nil {
@@ -101,6 +101,11 @@ func (c *PreviewCache) Get(filePath string, modTime time.Time, suffix string) ([
 
 // Set stores a preview in the cache
 func (c *PreviewCache) Set(filePath string, modTime time.Time, suffix string, data []byte) error {
+	// Previews of encrypted files would be plaintext copies on disk
+	if GetFileEncryption().Active() && IsEncryptedFile(filePath) {
+		return nil
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -143,6 +148,36 @@ func CheckETag(r *http.Request, etag string) bool {
 	return clientETag != etag
 }
 
+// CheckModifiedSince checks the client's If-Modified-Since header against the
+// file's modification time. It is ignored when If-None-Match is present.
+// Returns true if content should be returned, false if 304 should be sent
+func CheckModifiedSince(r *http.Request, modTime time.Time) bool {
+	header := r.Header.Get("If-Modified-Since")
+	if header == "" || r.Header.Get("If-None-Match") != "" {
+		return true
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return true
+	}
+	return modTime.Truncate(time.Second).After(since)
+}
+
+// CheckIfMatch checks the client's If-Match header against the current ETag
+// Returns true if the request may proceed (no header, "*", or a match)
+func CheckIfMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
 // SetCacheHeaders sets appropriate cache headers for preview responses
 func SetCacheHeaders(w http.ResponseWriter, etag string, maxAge int) {
 	w.Header().Set("ETag", etag)
@@ -294,7 +329,7 @@ func (c *PreviewCache) CachedTextPreview(filePath string, info os.FileInfo, opts
 	}
 
 	// Read from file
-	file, err := os.Open(filePath)
+	file, err := OpenPlain(filePath)
 	if err != nil {
 		return "", false, err
 	}
@@ -358,10 +393,10 @@ func ServeFileWithCache(w http.ResponseWriter, r *http.Request, filePath string,
 	ext := strings.ToLower(filepath.Ext(filePath))
 	contentType := getMimeType(strings.TrimPrefix(ext, "."))
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Content-Length", strconv.FormatInt(PlainSize(filePath, info), 10))
 
 	// Serve file
-	file, err := os.Open(filePath)
+	file, err := OpenPlain(filePath)
 	if err != nil {
 		return err
 	}
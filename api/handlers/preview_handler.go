@@ -35,6 +35,15 @@ func (h *Handler) GetPreview(c echo.Context) error {
 		})
 	}
 
+	// Links preview their target, with permissions checked against the target
+	if isFileLink(realPath) {
+		targetPath, apiErr := h.followFileLink(realPath, claims)
+		if apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+		realPath = targetPath
+	}
+
 	info, err := os.Stat(realPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -56,19 +65,69 @@ func (h *Handler) GetPreview(c echo.Context) error {
 	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(info.Name()), "."))
 	mimeType := getMimeType(ext)
 
-	// Generate ETag for cache validation
-	etag := GenerateETag(realPath, info.ModTime(), info.Size())
+	// Generate ETag for cache validation; thumbnails differ per size
+	sizeName := c.QueryParam("size")
+	etag := GenerateETag(realPath+sizeName, info.ModTime(), info.Size())
 
-	// Check If-None-Match header for cache validation
-	if !CheckETag(c.Request(), etag) {
+	// Check If-None-Match and If-Modified-Since headers for cache validation
+	if !CheckETag(c.Request(), etag) || !CheckModifiedSince(c.Request(), info.ModTime()) {
 		return c.NoContent(http.StatusNotModified)
 	}
 
+	// Images and videos scaled to a thumbnail size come from the thumbnail cache
+	if sizeName != "" {
+		size, ok := ThumbnailSizes[sizeName]
+		if !ok {
+			return RespondError(c, ErrBadRequest("Invalid size. Use: small, medium, or large"))
+		}
+		if !IsThumbnailSupported(info.Name()) {
+			return RespondError(c, ErrBadRequest("No thumbnail for this file type"))
+		}
+		data, cached, err := cachedThumbnail(realPath, info, size, "jpeg")
+		if err != nil {
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{
+				"error": "Preview not available for this file",
+			})
+		}
+		SetCacheHeaders(c.Response().Writer, etag, 86400) // 24 hour cache
+		c.Response().Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		if cached {
+			c.Response().Header().Set("X-Thumbnail-Cached", "true")
+		}
+		return c.Blob(http.StatusOK, "image/jpeg", data)
+	}
+
+	// HEIC, PSD and RAW are served as a converted JPEG; files that cannot be
+	// converted are reported as unsupported so clients show the icon
+	if isConvertedImage("." + ext) {
+		if data, ok := convertedPreview(realPath, info); ok {
+			SetCacheHeaders(c.Response().Writer, etag, 86400) // 24 hour cache
+			c.Response().Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+			return c.Blob(http.StatusOK, "image/jpeg", data)
+		}
+		SetCacheHeaders(c.Response().Writer, etag, 3600) // 1 hour cache
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"type":     "unsupported",
+			"mimeType": mimeType,
+			"size":     info.Size(),
+		})
+	}
+
 	// For images, return the file with caching headers
 	if strings.HasPrefix(mimeType, "image/") {
 		SetCacheHeaders(c.Response().Writer, etag, 86400) // 24 hour cache
 		c.Response().Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
-		return c.File(realPath)
+		return ServePlainFile(c, realPath)
+	}
+
+	// Email, calendar and contact files are parsed into structured views
+	if previewType := structuredPreviewType(ext); previewType != "" {
+		preview, apiErr := structuredPreview(previewType, realPath, displayPath, info)
+		if apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+		SetCacheHeaders(c.Response().Writer, etag, 300) // 5 minute cache, like text previews
+		return c.JSON(http.StatusOK, preview)
 	}
 
 	// For text files, return content with caching
@@ -87,7 +146,7 @@ func (h *Handler) GetPreview(c echo.Context) error {
 			}
 		} else {
 			// Fallback to direct read if cache not available
-			file, err := os.Open(realPath)
+			file, err := OpenPlain(realPath)
 			if err != nil {
 				return c.JSON(http.StatusInternalServerError, map[string]string{
 					"error": "Failed to open file",
@@ -120,10 +179,11 @@ func (h *Handler) GetPreview(c echo.Context) error {
 	if strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/") {
 		SetCacheHeaders(c.Response().Writer, etag, 3600) // 1 hour cache
 		return c.JSON(http.StatusOK, map[string]interface{}{
-			"type":     strings.Split(mimeType, "/")[0],
-			"mimeType": mimeType,
-			"url":      fmt.Sprintf("/api/files/%s", strings.TrimPrefix(displayPath, "/")),
-			"size":     info.Size(),
+			"type":      strings.Split(mimeType, "/")[0],
+			"mimeType":  mimeType,
+			"url":       fmt.Sprintf("/api/files/%s", strings.TrimPrefix(displayPath, "/")),
+			"streamUrl": fmt.Sprintf("/api/preview/stream/%s", strings.TrimPrefix(displayPath, "/")),
+			"size":      info.Size(),
 		})
 	}
 
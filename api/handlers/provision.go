@@ -0,0 +1,685 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Provisioning result actions
+const (
+	ProvisionActionCreated   = "created"
+	ProvisionActionUpdated   = "updated"
+	ProvisionActionUnchanged = "unchanged"
+	ProvisionActionAdded     = "added"
+	ProvisionActionRemoved   = "removed"
+	ProvisionActionFailed    = "failed"
+)
+
+// ProvisionHandler applies bulk user/shared folder manifests
+type ProvisionHandler struct {
+	db            *sql.DB
+	authHandler   *AuthHandler
+	sharedFolders *SharedFolderHandler
+	auditHandler  *AuditHandler
+}
+
+// NewProvisionHandler creates a new ProvisionHandler
+func NewProvisionHandler(db *sql.DB, authHandler *AuthHandler, sharedFolderHandler *SharedFolderHandler, auditHandler *AuditHandler) *ProvisionHandler {
+	return &ProvisionHandler{
+		db:            db,
+		authHandler:   authHandler,
+		sharedFolders: sharedFolderHandler,
+		auditHandler:  auditHandler,
+	}
+}
+
+// ProvisionQuota is a storage quota given either as a number of bytes or as a
+// human readable string such as "10GB", "512MB" or "unlimited"
+type ProvisionQuota string
+
+// UnmarshalJSON accepts both JSON numbers and strings
+func (q *ProvisionQuota) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*q = ProvisionQuota(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("quota must be a number or string")
+	}
+	*q = ProvisionQuota(n.String())
+	return nil
+}
+
+// ProvisionUser describes a user in a provisioning manifest
+type ProvisionUser struct {
+	Username string         `json:"username"`
+	Email    string         `json:"email"`
+	Password string         `json:"password,omitempty"`
+	Invite   bool           `json:"invite,omitempty"` // generate an initial password when none is given
+	IsAdmin  bool           `json:"isAdmin"`
+	IsActive *bool          `json:"isActive,omitempty"`
+	Quota    ProvisionQuota `json:"quota,omitempty"`
+}
+
+// ProvisionMember describes a shared folder membership in a provisioning manifest
+type ProvisionMember struct {
+	Username        string `json:"username"`
+	PermissionLevel int    `json:"permissionLevel"` // 1=read, 2=read-write
+}
+
+// ProvisionSharedFolder describes a shared folder in a provisioning manifest
+type ProvisionSharedFolder struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Quota       ProvisionQuota    `json:"quota,omitempty"`
+	Members     []ProvisionMember `json:"members"`
+}
+
+// ProvisionManifest is the body accepted by POST /admin/provision
+type ProvisionManifest struct {
+	Users         []ProvisionUser         `json:"users"`
+	SharedFolders []ProvisionSharedFolder `json:"sharedFolders"`
+	DryRun        bool                    `json:"dryRun"`
+	Sync          bool                    `json:"sync"` // remove members not listed in the manifest
+}
+
+// ProvisionResult reports the outcome for a single manifest item
+type ProvisionResult struct {
+	Type            string   `json:"type"` // user, sharedFolder, member
+	Name            string   `json:"name"`
+	Folder          string   `json:"folder,omitempty"`
+	Action          string   `json:"action"`
+	Changes         []string `json:"changes,omitempty"`
+	Error           string   `json:"error,omitempty"`
+	Warnings        []string `json:"warnings,omitempty"`
+	InitialPassword string   `json:"initialPassword,omitempty"`
+}
+
+// ProvisionValidationError describes a problem found while validating a manifest
+type ProvisionValidationError struct {
+	Item    string `json:"item"`
+	Message string `json:"message"`
+}
+
+// Provision imports users and shared folders from a JSON or CSV manifest
+// @Summary		Bulk provision users and shared folders
+// @Description	Validates the whole manifest first, then creates or updates users, shared folders and memberships. CSV manifests use the columns kind,name,email,password,quota,is_admin,folder,permission where kind is user, folder or member.
+// @Tags		Admin
+// @Accept		json,text/csv
+// @Produce		json
+// @Param		dryRun	query		bool	false	"Report the planned changes without applying them"
+// @Param		sync	query		bool	false	"Remove shared folder members not listed in the manifest"
+// @Param		body	body		ProvisionManifest	true	"Provisioning manifest"
+// @Success		200		{object}	docs.SuccessResponse	"Per-item provisioning report"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid manifest"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/provision [post]
+func (h *ProvisionHandler) Provision(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermUsersManage, PermStorageAdmin)
+	if err != nil {
+		return err
+	}
+
+	var manifest ProvisionManifest
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	if strings.HasPrefix(contentType, "text/csv") {
+		manifest, err = parseProvisionCSV(c.Request().Body)
+		if err != nil {
+			return RespondError(c, ErrBadRequest("Invalid CSV manifest: "+err.Error()))
+		}
+	} else if err := c.Bind(&manifest); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid manifest"))
+	}
+
+	if c.QueryParam("dryRun") == "true" {
+		manifest.DryRun = true
+	}
+	if c.QueryParam("sync") == "true" {
+		manifest.Sync = true
+	}
+
+	if len(manifest.Users) == 0 && len(manifest.SharedFolders) == 0 {
+		return RespondError(c, ErrBadRequest("Manifest is empty"))
+	}
+
+	if problems := h.validateManifest(&manifest); len(problems) > 0 {
+		return RespondError(c, ErrBadRequest("Manifest validation failed").WithDetails(problems))
+	}
+
+	results := make([]ProvisionResult, 0, len(manifest.Users)+len(manifest.SharedFolders))
+	userIDs := make(map[string]string)
+
+	for _, u := range manifest.Users {
+		result, userID := h.applyUser(u, manifest.DryRun, claims, c.RealIP())
+		if userID != "" {
+			userIDs[u.Username] = userID
+		}
+		results = append(results, result)
+	}
+
+	for _, f := range manifest.SharedFolders {
+		results = append(results, h.applySharedFolder(f, userIDs, manifest.DryRun, manifest.Sync, claims, c.RealIP())...)
+	}
+
+	summary := make(map[string]int)
+	for _, r := range results {
+		summary[r.Action]++
+	}
+
+	if !manifest.DryRun {
+		_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminProvision, "provision", map[string]interface{}{
+			"users":         len(manifest.Users),
+			"sharedFolders": len(manifest.SharedFolders),
+			"sync":          manifest.Sync,
+			"summary":       summary,
+		})
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"dryRun":  manifest.DryRun,
+		"sync":    manifest.Sync,
+		"results": results,
+		"summary": summary,
+	})
+}
+
+// validateManifest checks the whole manifest before anything is applied
+func (h *ProvisionHandler) validateManifest(m *ProvisionManifest) []ProvisionValidationError {
+	var problems []ProvisionValidationError
+	add := func(item, format string, args ...interface{}) {
+		problems = append(problems, ProvisionValidationError{Item: item, Message: fmt.Sprintf(format, args...)})
+	}
+
+	manifestUsers := make(map[string]bool)
+	for i := range m.Users {
+		u := &m.Users[i]
+		u.Username = strings.TrimSpace(u.Username)
+		u.Email = strings.TrimSpace(u.Email)
+		item := "user:" + u.Username
+
+		if len(u.Username) < 3 || len(u.Username) > 50 {
+			add(item, "username must be between 3 and 50 characters")
+			continue
+		}
+		if manifestUsers[u.Username] {
+			add(item, "duplicate username in manifest")
+			continue
+		}
+		manifestUsers[u.Username] = true
+
+		if u.Email != "" {
+			if err := ValidateEmail(u.Email); err != nil {
+				add(item, "%s", err.Error())
+			}
+		}
+		if u.Password != "" {
+			if err := ValidatePassword(u.Password); err != nil {
+				add(item, "%s", err.Error())
+			}
+		} else if !u.Invite && !h.userExists(u.Username) {
+			add(item, "new users need a password or invite=true")
+		}
+		if _, _, err := parseQuota(string(u.Quota)); err != nil {
+			add(item, "%s", err.Error())
+		}
+	}
+
+	folderNames := make(map[string]bool)
+	for i := range m.SharedFolders {
+		f := &m.SharedFolders[i]
+		f.Name = strings.TrimSpace(f.Name)
+		item := "sharedFolder:" + f.Name
+
+		if f.Name == "" {
+			add(item, "name is required")
+			continue
+		}
+		if folderNames[f.Name] {
+			add(item, "duplicate shared folder in manifest")
+			continue
+		}
+		folderNames[f.Name] = true
+
+		if _, _, err := parseQuota(string(f.Quota)); err != nil {
+			add(item, "%s", err.Error())
+		}
+
+		members := make(map[string]bool)
+		for j := range f.Members {
+			mem := &f.Members[j]
+			mem.Username = strings.TrimSpace(mem.Username)
+			memberItem := "member:" + f.Name + "/" + mem.Username
+
+			if members[mem.Username] {
+				add(memberItem, "duplicate member in shared folder")
+				continue
+			}
+			members[mem.Username] = true
+
+			if mem.PermissionLevel == 0 {
+				mem.PermissionLevel = PermissionReadOnly
+			}
+			if mem.PermissionLevel != PermissionReadOnly && mem.PermissionLevel != PermissionReadWrite {
+				add(memberItem, "permission level must be 1 (read) or 2 (read-write)")
+			}
+			if !manifestUsers[mem.Username] && !h.userExists(mem.Username) {
+				add(memberItem, "unknown user")
+			}
+		}
+	}
+
+	return problems
+}
+
+// applyUser creates or updates a single user
+func (h *ProvisionHandler) applyUser(u ProvisionUser, dryRun bool, actor *JWTClaims, clientIP string) (ProvisionResult, string) {
+	result := ProvisionResult{Type: "user", Name: u.Username}
+	quota, hasQuota, _ := parseQuota(string(u.Quota))
+
+	var (
+		userID   string
+		email    string
+		isAdmin  bool
+		isActive bool
+		curQuota int64
+	)
+	err := h.db.QueryRow(`
+		SELECT id, COALESCE(email, ''), is_admin, is_active, COALESCE(storage_quota, 0)
+		FROM users WHERE username = $1
+	`, u.Username).Scan(&userID, &email, &isAdmin, &isActive, &curQuota)
+
+	// Only superadmins create, promote or change superadmin accounts
+	if !actor.IsAdmin && (u.IsAdmin || isAdmin) {
+		_ = h.auditHandler.LogEvent(&actor.UserID, clientIP, EventPermissionDenied, u.Username, map[string]interface{}{
+			"permission":  RoleSuperadmin,
+			"provisioned": true,
+		})
+		result.Action = ProvisionActionFailed
+		result.Error = "Only a superadmin can provision superadmin accounts"
+		return result, userID
+	}
+
+	if err == sql.ErrNoRows {
+		result.Action = ProvisionActionCreated
+		if dryRun {
+			return result, ""
+		}
+
+		password := u.Password
+		if password == "" {
+			generated, genErr := generateInitialPassword()
+			if genErr != nil {
+				result.Action = ProvisionActionFailed
+				result.Error = genErr.Error()
+				return result, ""
+			}
+			password = generated
+			result.InitialPassword = generated
+		}
+
+		newID, warnings, apiErr := h.authHandler.createUserAccount(CreateUserRequest{
+			Username: u.Username,
+			Email:    u.Email,
+			Password: password,
+			IsAdmin:  u.IsAdmin,
+		})
+		if apiErr != nil {
+			result.Action = ProvisionActionFailed
+			result.Error = apiErr.Message
+			result.InitialPassword = ""
+			return result, ""
+		}
+		result.Warnings = warnings
+
+		// Quota and activation state are not part of CreateUserRequest
+		if hasQuota || (u.IsActive != nil && !*u.IsActive) {
+			update := UpdateUserRequest{IsAdmin: u.IsAdmin, IsActive: u.IsActive == nil || *u.IsActive}
+			if hasQuota {
+				update.StorageQuota = &quota
+			}
+			if apiErr := h.authHandler.updateUserAccount(newID, update); apiErr != nil {
+				result.Warnings = append(result.Warnings, "Failed to apply quota: "+apiErr.Message)
+			}
+		}
+
+		_ = h.auditHandler.LogEvent(&actor.UserID, clientIP, EventAdminUserCreate, u.Username, map[string]interface{}{
+			"isAdmin":     u.IsAdmin,
+			"provisioned": true,
+		})
+		return result, newID
+	}
+	if err != nil {
+		result.Action = ProvisionActionFailed
+		result.Error = "Database error"
+		return result, ""
+	}
+
+	// Existing user: work out what differs from the manifest
+	update := UpdateUserRequest{IsAdmin: u.IsAdmin, IsActive: isActive}
+	if u.IsActive != nil {
+		update.IsActive = *u.IsActive
+	}
+	if u.Email != "" && u.Email != email {
+		update.Email = u.Email
+		result.Changes = append(result.Changes, "email")
+	}
+	if u.IsAdmin != isAdmin {
+		result.Changes = append(result.Changes, "isAdmin")
+	}
+	if update.IsActive != isActive {
+		result.Changes = append(result.Changes, "isActive")
+	}
+	if hasQuota && quota != curQuota {
+		update.StorageQuota = &quota
+		result.Changes = append(result.Changes, "quota")
+	}
+	if u.Password != "" {
+		update.Password = u.Password
+		result.Changes = append(result.Changes, "password")
+	}
+
+	if len(result.Changes) == 0 {
+		result.Action = ProvisionActionUnchanged
+		return result, userID
+	}
+
+	result.Action = ProvisionActionUpdated
+	if dryRun {
+		return result, userID
+	}
+
+	if apiErr := h.authHandler.updateUserAccount(userID, update); apiErr != nil {
+		result.Action = ProvisionActionFailed
+		result.Error = apiErr.Message
+		return result, userID
+	}
+
+	_ = h.auditHandler.LogEvent(&actor.UserID, clientIP, EventAdminUserUpdate, u.Username, map[string]interface{}{
+		"changes":     result.Changes,
+		"provisioned": true,
+	})
+	return result, userID
+}
+
+// applySharedFolder creates or updates a shared folder and reconciles its members
+func (h *ProvisionHandler) applySharedFolder(f ProvisionSharedFolder, userIDs map[string]string, dryRun, sync bool, actor *JWTClaims, clientIP string) []ProvisionResult {
+	result := ProvisionResult{Type: "sharedFolder", Name: f.Name}
+	quota, hasQuota, _ := parseQuota(string(f.Quota))
+
+	var (
+		folderID    string
+		description string
+		curQuota    int64
+	)
+	err := h.db.QueryRow(`
+		SELECT id, COALESCE(description, ''), COALESCE(storage_quota, 0)
+		FROM shared_folders WHERE name = $1
+	`, f.Name).Scan(&folderID, &description, &curQuota)
+
+	switch {
+	case err == sql.ErrNoRows:
+		result.Action = ProvisionActionCreated
+		if !dryRun {
+			newID, apiErr := h.sharedFolders.createSharedFolder(f.Name, f.Description, quota, actor.UserID, clientIP)
+			if apiErr != nil {
+				result.Action = ProvisionActionFailed
+				result.Error = apiErr.Message
+				return []ProvisionResult{result}
+			}
+			folderID = newID
+		}
+	case err != nil:
+		result.Action = ProvisionActionFailed
+		result.Error = "Database error"
+		return []ProvisionResult{result}
+	default:
+		newDescription := description
+		newQuota := curQuota
+		if f.Description != "" && f.Description != description {
+			newDescription = f.Description
+			result.Changes = append(result.Changes, "description")
+		}
+		if hasQuota && quota != curQuota {
+			newQuota = quota
+			result.Changes = append(result.Changes, "quota")
+		}
+
+		result.Action = ProvisionActionUnchanged
+		if len(result.Changes) > 0 {
+			result.Action = ProvisionActionUpdated
+			if !dryRun {
+				if apiErr := h.sharedFolders.updateSharedFolder(folderID, f.Name, newDescription, newQuota, nil, actor.UserID, clientIP); apiErr != nil {
+					result.Action = ProvisionActionFailed
+					result.Error = apiErr.Message
+					return []ProvisionResult{result}
+				}
+			}
+		}
+	}
+
+	results := []ProvisionResult{result}
+
+	// Current memberships (none for a folder that does not exist yet)
+	current := make(map[string]int)
+	currentIDs := make(map[string]string)
+	if folderID != "" {
+		rows, err := h.db.Query(`
+			SELECT u.username, u.id, sfm.permission_level
+			FROM shared_folder_members sfm
+			INNER JOIN users u ON sfm.user_id = u.id
+			WHERE sfm.shared_folder_id = $1
+		`, folderID)
+		if err == nil {
+			for rows.Next() {
+				var username, id string
+				var level int
+				if rows.Scan(&username, &id, &level) == nil {
+					current[username] = level
+					currentIDs[username] = id
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	desired := make(map[string]bool)
+	for _, m := range f.Members {
+		desired[m.Username] = true
+		mr := ProvisionResult{Type: "member", Name: m.Username, Folder: f.Name}
+
+		level, isMember := current[m.Username]
+		switch {
+		case !isMember:
+			mr.Action = ProvisionActionAdded
+		case level != m.PermissionLevel:
+			mr.Action = ProvisionActionUpdated
+			mr.Changes = []string{"permissionLevel"}
+		default:
+			mr.Action = ProvisionActionUnchanged
+		}
+
+		if !dryRun && mr.Action != ProvisionActionUnchanged {
+			userID := userIDs[m.Username]
+			if userID == "" {
+				userID = currentIDs[m.Username]
+			}
+			if userID == "" {
+				_ = h.db.QueryRow("SELECT id FROM users WHERE username = $1", m.Username).Scan(&userID)
+			}
+			if userID == "" || folderID == "" {
+				mr.Action = ProvisionActionFailed
+				mr.Error = "User or shared folder not available"
+			} else if apiErr := h.sharedFolders.setMember(folderID, userID, m.PermissionLevel, actor, clientIP); apiErr != nil {
+				mr.Action = ProvisionActionFailed
+				mr.Error = apiErr.Message
+			}
+		}
+		results = append(results, mr)
+	}
+
+	if sync {
+		for username := range current {
+			if desired[username] {
+				continue
+			}
+			mr := ProvisionResult{Type: "member", Name: username, Folder: f.Name, Action: ProvisionActionRemoved}
+			if !dryRun {
+				if apiErr := h.sharedFolders.removeMember(folderID, currentIDs[username], actor, clientIP); apiErr != nil {
+					mr.Action = ProvisionActionFailed
+					mr.Error = apiErr.Message
+				}
+			}
+			results = append(results, mr)
+		}
+	}
+
+	return results
+}
+
+// userExists reports whether a user with the given username exists
+func (h *ProvisionHandler) userExists(username string) bool {
+	var exists bool
+	_ = h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists)
+	return exists
+}
+
+// generateInitialPassword creates a random password that satisfies ValidatePassword
+func generateInitialPassword() (string, error) {
+	token, err := GenerateSecureToken(12)
+	if err != nil {
+		return "", err
+	}
+	return "Fh!" + token, nil
+}
+
+// parseQuota parses a quota such as "10GB", "512MB", "1073741824" or "unlimited".
+// The second return value is false when no quota was given.
+func parseQuota(s string) (int64, bool, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	if s == "" {
+		return 0, false, nil
+	}
+	if s == "UNLIMITED" {
+		return 0, true, nil
+	}
+
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, false, fmt.Errorf("invalid quota format")
+	}
+	return int64(value * float64(mult)), true, nil
+}
+
+// parseProvisionCSV reads a CSV manifest. The header row names the columns;
+// kind is one of user, folder or member.
+func parseProvisionCSV(r io.Reader) (ProvisionManifest, error) {
+	var m ProvisionManifest
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return m, fmt.Errorf("missing header row")
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["kind"]; !ok {
+		return m, fmt.Errorf("missing kind column")
+	}
+	if _, ok := cols["name"]; !ok {
+		return m, fmt.Errorf("missing name column")
+	}
+
+	folderIndex := make(map[string]int)
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return m, fmt.Errorf("line %d: %v", line, err)
+		}
+		get := func(col string) string {
+			if i, ok := cols[col]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		switch strings.ToLower(get("kind")) {
+		case "user":
+			isAdmin, _ := strconv.ParseBool(get("is_admin"))
+			m.Users = append(m.Users, ProvisionUser{
+				Username: get("name"),
+				Email:    get("email"),
+				Password: get("password"),
+				Invite:   get("password") == "",
+				IsAdmin:  isAdmin,
+				Quota:    ProvisionQuota(get("quota")),
+			})
+		case "folder":
+			folderIndex[get("name")] = len(m.SharedFolders)
+			m.SharedFolders = append(m.SharedFolders, ProvisionSharedFolder{
+				Name:  get("name"),
+				Quota: ProvisionQuota(get("quota")),
+			})
+		case "member":
+			folder := get("folder")
+			idx, ok := folderIndex[folder]
+			if !ok {
+				return m, fmt.Errorf("line %d: member references folder %q before it is declared", line, folder)
+			}
+			level := PermissionReadOnly
+			switch strings.ToLower(get("permission")) {
+			case "", "1", "read", "r":
+			case "2", "write", "read-write", "rw":
+				level = PermissionReadWrite
+			default:
+				return m, fmt.Errorf("line %d: invalid permission %q", line, get("permission"))
+			}
+			m.SharedFolders[idx].Members = append(m.SharedFolders[idx].Members, ProvisionMember{
+				Username:        get("name"),
+				PermissionLevel: level,
+			})
+		case "":
+			continue
+		default:
+			return m, fmt.Errorf("line %d: unknown kind %q", line, get("kind"))
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQuota(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		set     bool
+		wantErr bool
+	}{
+		{"", 0, false, false},
+		{"unlimited", 0, true, false},
+		{"1073741824", 1073741824, true, false},
+		{"10GB", 10 << 30, true, false},
+		{"512mb", 512 << 20, true, false},
+		{"1.5G", 3 << 29, true, false},
+		{"abc", 0, false, true},
+		{"-1GB", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		got, set, err := parseQuota(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQuota(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want || set != tt.set {
+			t.Errorf("parseQuota(%q) = (%d, %v), want (%d, %v)", tt.input, got, set, tt.want, tt.set)
+		}
+	}
+}
+
+func TestParseProvisionCSV(t *testing.T) {
+	input := `kind,name,email,password,quota,is_admin,folder,permission
+user,alice,alice@example.com,Secret123!,10GB,true,,
+user,bob,,,,,,
+folder,Engineering,,,100GB,,,
+member,alice,,,,,Engineering,write
+member,bob,,,,,Engineering,
+`
+	m, err := parseProvisionCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseProvisionCSV returned error: %v", err)
+	}
+
+	if len(m.Users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(m.Users))
+	}
+	if !m.Users[0].IsAdmin || m.Users[0].Quota != "10GB" {
+		t.Errorf("unexpected first user: %+v", m.Users[0])
+	}
+	if !m.Users[1].Invite {
+		t.Errorf("user without password should be invited")
+	}
+
+	if len(m.SharedFolders) != 1 || len(m.SharedFolders[0].Members) != 2 {
+		t.Fatalf("unexpected shared folders: %+v", m.SharedFolders)
+	}
+	if m.SharedFolders[0].Members[0].PermissionLevel != PermissionReadWrite {
+		t.Errorf("expected read-write for alice")
+	}
+	if m.SharedFolders[0].Members[1].PermissionLevel != PermissionReadOnly {
+		t.Errorf("expected read-only for bob")
+	}
+}
+
+func TestParseProvisionCSV_MemberBeforeFolder(t *testing.T) {
+	input := "kind,name,folder\nmember,alice,Missing\n"
+	if _, err := parseProvisionCSV(strings.NewReader(input)); err == nil {
+		t.Error("expected error for member referencing undeclared folder")
+	}
+}
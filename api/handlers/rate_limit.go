@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+// Rate limit classes. Each class has its own limit and key.
+const (
+	RateClassAuth     = "auth"     // Login, 2FA verify, share password attempts: per IP and identifier
+	RateClassBrowse   = "browse"   // Listing, preview, thumbnails: per user, or IP when anonymous
+	RateClassDefault  = "default"  // Everything else: per user, or IP when anonymous
+	RateClassTransfer = "transfer" // tus PATCH data; left to the bandwidth controls
+)
+
+const (
+	// rateLimitVisitorTTL is how long an idle key keeps its limiter state
+	rateLimitVisitorTTL = 10 * time.Minute
+	// rateLimitTopKeys is how many limited keys the admin status lists
+	rateLimitTopKeys = 10
+	// rateLimitBodyPeek bounds how much of an auth request body is read for the identifier
+	rateLimitBodyPeek = 64 << 10
+)
+
+// rateLimitAuthPaths are POST endpoints that take credentials, keyed by the
+// account they target
+var rateLimitAuthPaths = map[string]bool{
+	"/api/auth/login":                  true,
+	"/api/auth/2fa/verify":             true,
+	"/api/auth/password-reset/request": true,
+	"/api/auth/password-reset/check":   true,
+	"/api/auth/password-reset":         true,
+	"/api/auth/register":               true,
+}
+
+// rateLimitBrowsePrefixes are read endpoints the UI calls in bursts
+var rateLimitBrowsePrefixes = []string{
+	"/api/files",
+	"/api/folders/stats/",
+	"/api/folders/batch-stats",
+	"/api/preview/",
+	"/api/subtitle/",
+	"/api/thumbnail/",
+	"/api/thumbnails/",
+	"/api/zip/preview/",
+	"/api/metadata/",
+}
+
+// rateLimitVisitor is the limiter state of one key
+type rateLimitVisitor struct {
+	limiter     *rate.Limiter
+	class       string
+	lastSeen    time.Time
+	limited     int64
+	lastLimited time.Time
+}
+
+// RateLimiter keeps token buckets per class and key
+type RateLimiter struct {
+	mu          sync.Mutex
+	visitors    map[string]*rateLimitVisitor
+	ttl         time.Duration
+	lastCleanup time.Time
+}
+
+// NewRateLimiter creates an empty limiter; idle keys are dropped after ttl
+func NewRateLimiter(ttl time.Duration) *RateLimiter {
+	return &RateLimiter{
+		visitors: make(map[string]*rateLimitVisitor),
+		ttl:      ttl,
+	}
+}
+
+var globalRateLimiter = NewRateLimiter(rateLimitVisitorTTL)
+
+// GetRateLimiter returns the global rate limiter
+func GetRateLimiter() *RateLimiter {
+	return globalRateLimiter
+}
+
+// RateLimitClassConfig is the limit applied to one class
+type RateLimitClassConfig struct {
+	Rate    float64 `json:"ratePerSecond"`
+	Burst   int     `json:"burst"`
+	KeyedBy string  `json:"keyedBy"`
+}
+
+// rateLimitConfig reads the class limits from settings. Changes apply to the
+// next request.
+func rateLimitConfig() (bool, map[string]RateLimitClassConfig) {
+	enabled := true
+	rps, browseRPS, authPerMinute := 100, 300, 10
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		enabled = sh.IsRateLimitEnabled()
+		rps = sh.GetRateLimitRPS()
+		browseRPS = sh.GetRateLimitBrowseRPS()
+		authPerMinute = sh.GetRateLimitAuthPerMinute()
+	}
+	rps = max(rps, 1)
+	browseRPS = max(browseRPS, 1)
+	authPerMinute = max(authPerMinute, 1)
+
+	return enabled, map[string]RateLimitClassConfig{
+		RateClassAuth:    {Rate: float64(authPerMinute) / 60, Burst: authPerMinute, KeyedBy: "ip+identifier"},
+		RateClassBrowse:  {Rate: float64(browseRPS), Burst: browseRPS, KeyedBy: "user|ip"},
+		RateClassDefault: {Rate: float64(rps), Burst: rps, KeyedBy: "user|ip"},
+	}
+}
+
+// RateLimitClass returns the class of a request
+func RateLimitClass(method, p string) string {
+	if method == http.MethodPatch && (strings.HasPrefix(p, "/api/upload/") || isShareUploadPath(p)) {
+		return RateClassTransfer
+	}
+	if method == http.MethodPost && (rateLimitAuthPaths[p] || isSharePasswordPath(p)) {
+		return RateClassAuth
+	}
+	if method == http.MethodGet && isSharePreviewPath(p) {
+		return RateClassBrowse
+	}
+	if method == http.MethodGet || method == http.MethodHead || p == "/api/folders/batch-stats" || p == "/api/thumbnails/batch" {
+		for _, prefix := range rateLimitBrowsePrefixes {
+			if strings.HasPrefix(p, prefix) {
+				return RateClassBrowse
+			}
+		}
+	}
+	return RateClassDefault
+}
+
+// isSharePreviewPath matches GET /api/s/:token/preview, which share pages
+// call for every thumbnail of a grid
+func isSharePreviewPath(p string) bool {
+	parts := strings.Split(strings.TrimPrefix(p, "/api/"), "/")
+	return len(parts) == 3 && parts[0] == "s" && parts[1] != "" && parts[2] == "preview"
+}
+
+// isSharePasswordPath matches POST /api/{s,e,u}/:token, where share passwords are submitted
+func isSharePasswordPath(p string) bool {
+	parts := strings.Split(strings.TrimPrefix(p, "/api/"), "/")
+	return len(parts) == 2 && (parts[0] == "s" || parts[0] == "e" || parts[0] == "u") && parts[1] != ""
+}
+
+// isShareUploadPath matches tus requests on upload share links: /api/u/:token/upload/...
+func isShareUploadPath(p string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/api/"), "/", 4)
+	return len(parts) >= 3 && parts[0] == "u" && parts[2] == "upload"
+}
+
+// rateLimitIdentifier returns the account or share an auth request targets
+func rateLimitIdentifier(c echo.Context) string {
+	req := c.Request()
+	p := req.URL.Path
+	if isSharePasswordPath(p) {
+		return "share:" + p[strings.LastIndex(p, "/")+1:]
+	}
+	if req.Body == nil {
+		return ""
+	}
+
+	// Peek at the body and put it back for the handler
+	peek, err := io.ReadAll(io.LimitReader(req.Body, rateLimitBodyPeek))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), req.Body), req.Body}
+	if err != nil {
+		return ""
+	}
+	var body struct {
+		Username string `json:"username"`
+		UserID   string `json:"userId"`
+	}
+	if json.Unmarshal(peek, &body) != nil {
+		return ""
+	}
+	if body.Username != "" {
+		return "user:" + strings.ToLower(strings.TrimSpace(body.Username))
+	}
+	if body.UserID != "" {
+		return "id:" + body.UserID
+	}
+	return ""
+}
+
+// rateLimitUser returns the user ID of a valid, unrestricted token on the request
+func rateLimitUser(c echo.Context) string {
+	tokenString := ""
+	if parts := strings.SplitN(c.Request().Header.Get("Authorization"), " ", 2); len(parts) == 2 && parts[0] == "Bearer" {
+		tokenString = parts[1]
+	}
+	if tokenString == "" {
+		tokenString = c.QueryParam("token")
+	}
+	if tokenString == "" {
+		return ""
+	}
+	token, err := ValidateJWTToken(tokenString)
+	if err != nil || !token.Valid {
+		return ""
+	}
+	if claims, ok := token.Claims.(*JWTClaims); ok && !claims.IsLimited() {
+		return claims.UserID
+	}
+	return ""
+}
+
+// rateLimitKey builds the limiter key of a request for its class
+func rateLimitKey(c echo.Context, class string) string {
+	if class == RateClassAuth {
+		return class + "|ip:" + c.RealIP() + "|" + rateLimitIdentifier(c)
+	}
+	if userID := rateLimitUser(c); userID != "" {
+		return class + "|user:" + userID
+	}
+	return class + "|ip:" + c.RealIP()
+}
+
+// Allow takes a token for key, returning how long to wait when none is left
+func (l *RateLimiter) Allow(key, class string, cfg RateLimitClassConfig, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) > time.Minute {
+		for k, v := range l.visitors {
+			if now.Sub(v.lastSeen) > l.ttl {
+				delete(l.visitors, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	v, ok := l.visitors[key]
+	if !ok {
+		v = &rateLimitVisitor{limiter: rate.NewLimiter(rate.Limit(cfg.Rate), cfg.Burst), class: class}
+		l.visitors[key] = v
+	}
+	if v.limiter.Limit() != rate.Limit(cfg.Rate) {
+		v.limiter.SetLimitAt(now, rate.Limit(cfg.Rate))
+	}
+	if v.limiter.Burst() != cfg.Burst {
+		v.limiter.SetBurstAt(now, cfg.Burst)
+	}
+	v.lastSeen = now
+
+	r := v.limiter.ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	if r.OK() && delay == 0 {
+		return true, 0
+	}
+	r.CancelAt(now)
+	v.limited++
+	v.lastLimited = now
+	if !r.OK() {
+		delay = time.Second
+	}
+	return false, delay
+}
+
+// RateLimitMiddleware limits requests per endpoint class. Settings are read
+// per request, so limits and the on/off switch apply without a restart.
+func RateLimitMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			enabled, classes := rateLimitConfig()
+			if !enabled {
+				return next(c)
+			}
+			class := RateLimitClass(c.Request().Method, c.Request().URL.Path)
+			cfg, limited := classes[class]
+			if !limited {
+				return next(c)
+			}
+
+			ok, retryAfter := GetRateLimiter().Allow(rateLimitKey(c, class), class, cfg, time.Now())
+			if ok {
+				return next(c)
+			}
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+			return RespondError(c, NewAPIError(ErrCodeRateLimited, "Too many requests").WithDetails(map[string]interface{}{
+				"class":      class,
+				"retryAfter": seconds,
+			}))
+		}
+	}
+}
+
+// RateLimitedKey is a key that hit its limit recently
+type RateLimitedKey struct {
+	Key         string    `json:"key"`
+	Class       string    `json:"class"`
+	Limited     int64     `json:"limited"` // Rejected requests while the key was tracked
+	LastLimited time.Time `json:"lastLimited"`
+}
+
+// RateLimitStatus is the limiter state shown on the admin system info endpoint
+type RateLimitStatus struct {
+	Enabled     bool                            `json:"enabled"`
+	Classes     map[string]RateLimitClassConfig `json:"classes"`
+	TrackedKeys int                             `json:"trackedKeys"`
+	TopLimited  []RateLimitedKey                `json:"topLimited"`
+}
+
+// TopLimited returns the n keys with the most rejected requests
+func (l *RateLimiter) TopLimited(n int) []RateLimitedKey {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]RateLimitedKey, 0)
+	for k, v := range l.visitors {
+		if v.limited > 0 {
+			keys = append(keys, RateLimitedKey{Key: k, Class: v.class, Limited: v.limited, LastLimited: v.lastLimited})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Limited != keys[j].Limited {
+			return keys[i].Limited > keys[j].Limited
+		}
+		return keys[i].LastLimited.After(keys[j].LastLimited)
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// EffectiveRateLimitStatus reports the current limits and most limited keys
+func EffectiveRateLimitStatus() RateLimitStatus {
+	enabled, classes := rateLimitConfig()
+	l := GetRateLimiter()
+	l.mu.Lock()
+	tracked := len(l.visitors)
+	l.mu.Unlock()
+	return RateLimitStatus{
+		Enabled:     enabled,
+		Classes:     classes,
+		TrackedKeys: tracked,
+		TopLimited:  l.TopLimited(rateLimitTopKeys),
+	}
+}
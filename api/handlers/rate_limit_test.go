@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRateLimitClass(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodPost, "/api/auth/login", RateClassAuth},
+		{http.MethodPost, "/api/auth/2fa/verify", RateClassAuth},
+		{http.MethodPost, "/api/s/abc123", RateClassAuth},
+		{http.MethodPost, "/api/u/abc123", RateClassAuth},
+		{http.MethodGet, "/api/s/abc123", RateClassDefault},
+		{http.MethodGet, "/api/s/abc123/preview", RateClassBrowse},
+		{http.MethodPost, "/api/u/abc123/callback", RateClassDefault},
+		{http.MethodPatch, "/api/upload/xyz", RateClassTransfer},
+		{http.MethodPatch, "/api/u/abc123/upload/xyz", RateClassTransfer},
+		{http.MethodGet, "/api/files/home/a", RateClassBrowse},
+		{http.MethodGet, "/api/preview/home/a.jpg", RateClassBrowse},
+		{http.MethodPost, "/api/thumbnails/batch", RateClassBrowse},
+		{http.MethodDelete, "/api/files/home/a", RateClassDefault},
+		{http.MethodPost, "/api/folders", RateClassDefault},
+	}
+	for _, tt := range tests {
+		if got := RateLimitClass(tt.method, tt.path); got != tt.want {
+			t.Errorf("RateLimitClass(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiter_AllowAndTopLimited(t *testing.T) {
+	l := NewRateLimiter(time.Minute)
+	cfg := RateLimitClassConfig{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("default|ip:1.2.3.4", RateClassDefault, cfg, now); !ok {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("default|ip:1.2.3.4", RateClassDefault, cfg, now)
+	if ok {
+		t.Fatal("third request should be limited")
+	}
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("retryAfter = %v, want (0, 1s]", retryAfter)
+	}
+	if ok, _ := l.Allow("default|ip:1.2.3.4", RateClassDefault, cfg, now.Add(time.Second)); !ok {
+		t.Error("request after refill should be allowed")
+	}
+
+	top := l.TopLimited(10)
+	if len(top) != 1 || top[0].Key != "default|ip:1.2.3.4" || top[0].Limited != 1 {
+		t.Errorf("TopLimited = %+v", top)
+	}
+}
+
+func TestRateLimitMiddleware_AuthKeyedByAccount(t *testing.T) {
+	e := echo.New()
+	limiter := globalRateLimiter
+	globalRateLimiter = NewRateLimiter(time.Minute)
+	defer func() { globalRateLimiter = limiter }()
+
+	var seen string
+	handler := RateLimitMiddleware()(func(c echo.Context) error {
+		body, _ := io.ReadAll(c.Request().Body)
+		seen = string(body)
+		return c.NoContent(http.StatusOK)
+	})
+
+	login := func(username string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"`+username+`","password":"x"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		_ = handler(e.NewContext(req, rec))
+		return rec
+	}
+
+	// Default auth budget is 10 attempts per minute
+	for i := 0; i < 10; i++ {
+		if rec := login("alice"); rec.Code != http.StatusOK {
+			t.Fatalf("attempt %d: status %d", i+1, rec.Code)
+		}
+	}
+	if !strings.Contains(seen, `"username":"alice"`) {
+		t.Errorf("handler did not receive the original body: %q", seen)
+	}
+
+	rec := login("alice")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+	if !strings.Contains(rec.Body.String(), string(ErrCodeRateLimited)) {
+		t.Errorf("body = %s", rec.Body.String())
+	}
+
+	// Another account from the same IP has its own budget
+	if rec := login("bob"); rec.Code != http.StatusOK {
+		t.Errorf("other account: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,523 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Reference issue types found by the integrity check
+const (
+	IntegrityShareTargetMissing   = "share_target_missing"    // Active link/upload share on a path that no longer exists
+	IntegrityShareCreatorMissing  = "share_creator_missing"   // Active link share whose creator was deleted
+	IntegrityFileShareUserMissing = "file_share_user_missing" // User share whose owner or recipient was deleted
+	IntegrityFileShareItemMissing = "file_share_item_missing" // User share on an item that no longer exists
+	IntegrityMemberUserMissing    = "member_user_missing"     // Drive membership of a deleted user
+	IntegrityMemberFolderMissing  = "member_folder_missing"   // Membership of a deleted drive
+	IntegrityDriveDirMissing      = "drive_dir_missing"       // Active drive whose directory is gone
+	IntegrityTrashPayloadMissing  = "trash_payload_missing"   // Trash entry without its file
+	IntegrityTrashUntracked       = "trash_untracked"         // File in trash without an entry
+)
+
+// integrityFixable lists the issue types fixed when fix=true: the rows can
+// no longer be used, so the fix only removes dead references. Missing items
+// of user shares and drives are reported for an admin to look at, as the
+// data may come back (e.g. a drive restored from backup).
+var integrityFixable = map[string]bool{
+	IntegrityShareTargetMissing:   true, // Deactivated, not deleted
+	IntegrityShareCreatorMissing:  true, // Deactivated, not deleted
+	IntegrityFileShareUserMissing: true,
+	IntegrityMemberUserMissing:    true,
+	IntegrityMemberFolderMissing:  true,
+	IntegrityTrashPayloadMissing:  true,
+}
+
+// maxIntegrityItems bounds the items listed per issue type in a report
+const maxIntegrityItems = 200
+
+// IntegrityIssue is one dead reference
+type IntegrityIssue struct {
+	ID     string `json:"id"` // Row ID, or trash ID for trash issues
+	Path   string `json:"path,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// IntegrityGroup collects the issues of one type
+type IntegrityGroup struct {
+	Type      string           `json:"type"`
+	Count     int              `json:"count"`
+	Fixable   bool             `json:"fixable"`
+	Fixed     int              `json:"fixed"`
+	Items     []IntegrityIssue `json:"items"` // At most maxIntegrityItems
+	Truncated bool             `json:"truncated,omitempty"`
+
+	all []IntegrityIssue
+}
+
+// IntegrityReport is the result of a reference integrity check
+type IntegrityReport struct {
+	CheckedAt   time.Time         `json:"checkedAt"`
+	DurationMs  int64             `json:"durationMs"`
+	Trigger     string            `json:"trigger"` // startup, scheduled or admin
+	Fix         bool              `json:"fix"`
+	Applied     bool              `json:"applied"` // False for a dry run; Fixed then counts what would be fixed
+	Total       int               `json:"total"`
+	Fixed       int               `json:"fixed"`
+	Outstanding int               `json:"outstanding"`
+	Groups      []*IntegrityGroup `json:"groups"`
+}
+
+// IntegrityStatus is the summary shown in the admin system info
+type IntegrityStatus struct {
+	CheckedAt   time.Time      `json:"checkedAt"`
+	Outstanding int            `json:"outstanding"`
+	ByType      map[string]int `json:"byType"`
+}
+
+var (
+	integrityMu      sync.Mutex
+	integrityLast    *IntegrityReport
+	integrityRunning atomic.Bool
+)
+
+// EffectiveIntegrityStatus returns the outcome of the last check, or nil
+// if none has finished yet
+func EffectiveIntegrityStatus() *IntegrityStatus {
+	integrityMu.Lock()
+	defer integrityMu.Unlock()
+	if integrityLast == nil {
+		return nil
+	}
+	status := &IntegrityStatus{
+		CheckedAt:   integrityLast.CheckedAt,
+		Outstanding: integrityLast.Outstanding,
+		ByType:      make(map[string]int),
+	}
+	for _, g := range integrityLast.Groups {
+		if n := g.Count - g.Fixed; n > 0 {
+			status.ByType[g.Type] = n
+		}
+	}
+	return status
+}
+
+// integrityCollector groups issues by type while a check runs
+type integrityCollector struct {
+	groups map[string]*IntegrityGroup
+}
+
+func (ic *integrityCollector) add(issueType string, issue IntegrityIssue) {
+	g := ic.groups[issueType]
+	if g == nil {
+		g = &IntegrityGroup{Type: issueType, Fixable: integrityFixable[issueType]}
+		ic.groups[issueType] = g
+	}
+	g.Count++
+	g.all = append(g.all, issue)
+}
+
+func (ic *integrityCollector) ids(issueType string) []string {
+	g := ic.groups[issueType]
+	if g == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(g.all))
+	for _, issue := range g.all {
+		ids = append(ids, issue.ID)
+	}
+	return ids
+}
+
+// fileShareRealPath maps a user share item path to the data path. Items
+// are stored as the owner sees them: /home/... or /shared/{drive}/...
+func (h *Handler) fileShareRealPath(itemPath, ownerUsername string) (string, bool) {
+	clean, err := validateAndCleanPath(strings.TrimPrefix(itemPath, "/"))
+	if err != nil {
+		return "", false
+	}
+	root, rest, _ := strings.Cut(clean, "/")
+	switch root {
+	case "home":
+		return filepath.Join(h.dataRoot, "users", ownerUsername, rest), true
+	case "shared":
+		if rest == "" {
+			return "", false
+		}
+		return filepath.Join(h.dataRoot, "shared", rest), true
+	}
+	return "", false
+}
+
+// pathExists reports whether a data path exists; errors other than
+// "not found" count as existing so a flaky mount never triggers a fix
+func pathExists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil || !os.IsNotExist(err)
+}
+
+// CheckReferences cross-checks share rows, memberships and trash metadata
+// against users, drives and the filesystem. With fix, the safe cases are
+// repaired; with dryRun as well, the repairs are worked out but not made.
+// Findings and fixes are audit-logged.
+func (h *Handler) CheckReferences(fix, dryRun bool, trigger string, actorID *string, clientIP string) (*IntegrityReport, error) {
+	started := time.Now()
+	ic := &integrityCollector{groups: make(map[string]*IntegrityGroup)}
+	pace := GetBackgroundPacer().Begin("reference-integrity", PacePriorityMaintenance)
+	defer pace.End()
+
+	// Link and upload shares: stored paths are data-root relative
+	rows, err := h.db.Query(`
+		SELECT s.id, s.path, s.created_by IS NULL OR u.id IS NULL
+		FROM shares s
+		LEFT JOIN users u ON u.id = s.created_by
+		WHERE COALESCE(s.is_active, TRUE) = TRUE
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query shares: %w", err)
+	}
+	for rows.Next() {
+		var id, storedPath string
+		var creatorMissing bool
+		if err := rows.Scan(&id, &storedPath, &creatorMissing); err != nil {
+			continue
+		}
+		pace.Pace()
+		switch clean, cleanErr := validateAndCleanPath(storedPath); {
+		case creatorMissing:
+			ic.add(IntegrityShareCreatorMissing, IntegrityIssue{ID: id, Path: "/" + storedPath})
+		case cleanErr != nil || !pathExists(filepath.Join(h.dataRoot, clean)):
+			ic.add(IntegrityShareTargetMissing, IntegrityIssue{ID: id, Path: "/" + storedPath})
+		}
+	}
+	rows.Close()
+
+	// User-to-user shares
+	rows, err = h.db.Query(`
+		SELECT fs.id, fs.item_path, COALESCE(o.username, ''), o.id IS NULL, w.id IS NULL
+		FROM file_shares fs
+		LEFT JOIN users o ON o.id = fs.owner_id
+		LEFT JOIN users w ON w.id = fs.shared_with_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query file shares: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var itemPath, owner string
+		var ownerMissing, recipientMissing bool
+		if err := rows.Scan(&id, &itemPath, &owner, &ownerMissing, &recipientMissing); err != nil {
+			continue
+		}
+		pace.Pace()
+		issue := IntegrityIssue{ID: fmt.Sprint(id), Path: itemPath}
+		switch {
+		case ownerMissing:
+			issue.Detail = "owner deleted"
+			ic.add(IntegrityFileShareUserMissing, issue)
+		case recipientMissing:
+			issue.Detail = "recipient deleted"
+			ic.add(IntegrityFileShareUserMissing, issue)
+		default:
+			if realPath, ok := h.fileShareRealPath(itemPath, owner); !ok || !pathExists(realPath) {
+				ic.add(IntegrityFileShareItemMissing, issue)
+			}
+		}
+	}
+	rows.Close()
+
+	// Drive memberships
+	rows, err = h.db.Query(`
+		SELECT m.id, m.shared_folder_id, COALESCE(sf.name, ''), u.id IS NULL, sf.id IS NULL
+		FROM shared_folder_members m
+		LEFT JOIN users u ON u.id = m.user_id
+		LEFT JOIN shared_folders sf ON sf.id = m.shared_folder_id
+		WHERE u.id IS NULL OR sf.id IS NULL
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query memberships: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var folderID, folderName string
+		var userMissing, folderMissing bool
+		if err := rows.Scan(&id, &folderID, &folderName, &userMissing, &folderMissing); err != nil {
+			continue
+		}
+		issue := IntegrityIssue{ID: fmt.Sprint(id), Detail: "drive " + folderID}
+		if folderName != "" {
+			issue.Path = "/shared/" + sanitizeFolderName(folderName)
+		}
+		if folderMissing {
+			ic.add(IntegrityMemberFolderMissing, issue)
+		} else {
+			ic.add(IntegrityMemberUserMissing, issue)
+		}
+	}
+	rows.Close()
+
+	// Drives whose directory vanished (members can no longer reach anything)
+	rows, err = h.db.Query(`
+		SELECT sf.id, sf.name, COUNT(m.id)
+		FROM shared_folders sf
+		LEFT JOIN shared_folder_members m ON m.shared_folder_id = sf.id
+		WHERE sf.is_active = TRUE
+		GROUP BY sf.id, sf.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query drives: %w", err)
+	}
+	for rows.Next() {
+		var id, name string
+		var members int
+		if err := rows.Scan(&id, &name, &members); err != nil {
+			continue
+		}
+		if !pathExists(filepath.Join(h.dataRoot, "shared", sanitizeFolderName(name))) {
+			ic.add(IntegrityDriveDirMissing, IntegrityIssue{ID: id, Path: "/shared/" + sanitizeFolderName(name),
+				Detail: fmt.Sprintf("%d members", members)})
+		}
+	}
+	rows.Close()
+
+	// Trash metadata against payloads
+	trashFixes := make(map[string][]string)
+	userDirs, _ := os.ReadDir(filepath.Join(h.dataRoot, "trash"))
+	for _, userDir := range userDirs {
+		if !userDir.IsDir() {
+			continue
+		}
+		username := userDir.Name()
+		meta, err := h.loadTrashMeta(username)
+		if err != nil {
+			continue
+		}
+		for trashID, item := range meta {
+			pace.Pace()
+			if !pathExists(filepath.Join(h.getTrashPath(username), trashID)) {
+				ic.add(IntegrityTrashPayloadMissing, IntegrityIssue{ID: username + "/" + trashID, Path: item.OriginalPath})
+				trashFixes[username] = append(trashFixes[username], trashID)
+			}
+		}
+		entries, _ := os.ReadDir(h.getTrashPath(username))
+		for _, e := range entries {
+			if _, tracked := meta[e.Name()]; !tracked && !strings.HasPrefix(e.Name(), ".") {
+				ic.add(IntegrityTrashUntracked, IntegrityIssue{ID: username + "/" + e.Name()})
+			}
+		}
+	}
+
+	applied := false
+	if fix {
+		applied = h.fixReferences(ic, trashFixes, dryRun, actorID, clientIP)
+	}
+
+	report := &IntegrityReport{
+		CheckedAt:  started,
+		DurationMs: time.Since(started).Milliseconds(),
+		Trigger:    trigger,
+		Fix:        fix,
+		Applied:    applied,
+		Groups:     make([]*IntegrityGroup, 0, len(ic.groups)),
+	}
+	counts := make(map[string]int)
+	for _, g := range ic.groups {
+		sort.Slice(g.all, func(i, j int) bool { return g.all[i].ID < g.all[j].ID })
+		g.Items = g.all
+		if len(g.Items) > maxIntegrityItems {
+			g.Items, g.Truncated = g.Items[:maxIntegrityItems], true
+		}
+		report.Total += g.Count
+		report.Fixed += g.Fixed
+		counts[g.Type] = g.Count
+		report.Groups = append(report.Groups, g)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool { return report.Groups[i].Type < report.Groups[j].Type })
+	report.Outstanding = report.Total - report.Fixed
+
+	// A dry run leaves no trace, and its would-be fixes must not show up
+	// in the status
+	if dryRun {
+		return report, nil
+	}
+
+	if report.Total > 0 || fix {
+		if clientIP == "" {
+			clientIP = "0.0.0.0"
+		}
+		_ = NewAuditHandler(h.db, h.dataRoot).LogEvent(actorID, clientIP, "integrity_check", "references",
+			map[string]interface{}{
+				"trigger":     trigger,
+				"fix":         fix,
+				"issues":      counts,
+				"fixed":       report.Fixed,
+				"outstanding": report.Outstanding,
+			})
+	}
+
+	integrityMu.Lock()
+	integrityLast = report
+	integrityMu.Unlock()
+	return report, nil
+}
+
+// fixReferences repairs the fixable groups in one transaction and audits
+// each repaired row. On a dry run the groups report what would be fixed and
+// nothing changes. Reports whether the repairs were applied.
+func (h *Handler) fixReferences(ic *integrityCollector, trashFixes map[string][]string, dryRun bool, actorID *string, clientIP string) bool {
+	if clientIP == "" {
+		clientIP = "0.0.0.0"
+	}
+	run, err := beginDestructiveRun(h.db, dryRun)
+	if err != nil {
+		log.Printf("[Integrity] Failed to start fix: %v", err)
+		return false
+	}
+	defer run.Abort()
+
+	actions := make(map[string]string) // Issue type -> action, for the audit
+	exec := func(issueType, table, query, action string) {
+		ids := ic.ids(issueType)
+		if len(ids) == 0 {
+			return
+		}
+		if _, err := run.Exec(table, query, pq.Array(ids)); err != nil {
+			log.Printf("[Integrity] Failed to fix %s: %v", issueType, err)
+			return
+		}
+		actions[issueType] = action
+	}
+
+	exec(IntegrityShareTargetMissing, "shares", "UPDATE shares SET is_active = FALSE WHERE id::text = ANY($1)", "deactivated")
+	exec(IntegrityShareCreatorMissing, "shares", "UPDATE shares SET is_active = FALSE WHERE id::text = ANY($1)", "deactivated")
+	exec(IntegrityFileShareUserMissing, "file_shares", "DELETE FROM file_shares WHERE id::text = ANY($1)", "deleted")
+	exec(IntegrityMemberUserMissing, "shared_folder_members", "DELETE FROM shared_folder_members WHERE id::text = ANY($1)", "deleted")
+	exec(IntegrityMemberFolderMissing, "shared_folder_members", "DELETE FROM shared_folder_members WHERE id::text = ANY($1)", "deleted")
+
+	trashFailed := false
+	if len(trashFixes) > 0 {
+		actions[IntegrityTrashPayloadMissing] = "entry removed"
+		for username, trashIDs := range trashFixes {
+			username, trashIDs := username, trashIDs
+			run.After(func() error {
+				meta, err := h.loadTrashMeta(username)
+				if err == nil {
+					for _, trashID := range trashIDs {
+						delete(meta, trashID)
+					}
+					err = h.saveTrashMeta(username, meta)
+				}
+				if err != nil {
+					log.Printf("[Integrity] Failed to update trash metadata for %s: %v", username, err)
+					trashFailed = true
+				}
+				return err
+			})
+		}
+	}
+
+	result, err := run.Finish()
+	if result == nil {
+		log.Printf("[Integrity] Failed to commit fix: %v", err)
+		return false
+	}
+	if trashFailed {
+		delete(actions, IntegrityTrashPayloadMissing)
+	}
+	for issueType := range actions {
+		g := ic.groups[issueType]
+		g.Fixed = g.Count
+	}
+	if !result.Applied {
+		return false
+	}
+
+	audit := NewAuditHandler(h.db, h.dataRoot)
+	for _, issueType := range integrityFixOrder {
+		action, ok := actions[issueType]
+		if !ok {
+			continue
+		}
+		for _, issue := range ic.groups[issueType].all {
+			_ = audit.LogEvent(actorID, clientIP, "integrity_fix", issue.Path, map[string]interface{}{
+				"type":   issueType,
+				"id":     issue.ID,
+				"action": action,
+			})
+		}
+	}
+	if _, ok := actions[IntegrityMemberUserMissing]; ok {
+		GetSMBShares().Sync(actorID, clientIP, "integrity_fix")
+	}
+	return true
+}
+
+// integrityFixOrder is the order fixes are audited in
+var integrityFixOrder = []string{
+	IntegrityShareTargetMissing,
+	IntegrityShareCreatorMissing,
+	IntegrityFileShareUserMissing,
+	IntegrityMemberUserMissing,
+	IntegrityMemberFolderMissing,
+	IntegrityTrashPayloadMissing,
+}
+
+// StartIntegrityChecks checks references at startup and then on interval.
+// Scheduled runs only report; fixes need an admin request.
+func (h *Handler) StartIntegrityChecks(interval time.Duration) {
+	go func() {
+		trigger := "startup"
+		for {
+			if integrityRunning.CompareAndSwap(false, true) {
+				report, err := h.CheckReferences(false, false, trigger, nil, "")
+				integrityRunning.Store(false)
+				if err != nil {
+					log.Printf("[Integrity] Reference check failed: %v", err)
+				} else if report.Outstanding > 0 {
+					log.Printf("[Integrity] %d dead references found; POST /api/admin/integrity/references?fix=true repairs the safe ones", report.Outstanding)
+				}
+			}
+			trigger = "scheduled"
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// CheckReferenceIntegrity runs the reference check on demand
+// @Summary		Check reference integrity
+// @Description	Cross-checks link shares and user shares against the filesystem and users, drive memberships against users and drives, and trash metadata against payloads. Returns issues grouped by type. With fix=true, dead link shares are deactivated, shares and memberships of deleted users or drives are deleted and trash entries without payload are removed. With dryRun=true as well, the report shows what would be fixed without changing anything (applied is false). Findings and fixes are audit-logged.
+// @Tags		Admin
+// @Produce		json
+// @Param		fix		query		bool	false	"Repair the safe cases"
+// @Param		dryRun	query		bool	false	"Only report what fix would repair"
+// @Success		200	{object}	IntegrityReport		"Report"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		409	{object}	docs.ErrorResponse	"A check is already running"
+// @Security	BearerAuth
+// @Router		/admin/integrity/references [post]
+func (h *Handler) CheckReferenceIntegrity(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err // Error response already written
+	}
+
+	if !integrityRunning.CompareAndSwap(false, true) {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "A reference check is already running"))
+	}
+	defer integrityRunning.Store(false)
+
+	fix := c.QueryParam("fix") == "true"
+	report, err := h.CheckReferences(fix, dryRunParam(c), "admin", &claims.UserID, c.RealIP())
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("check references", err))
+	}
+	return RespondSuccess(c, report)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestCheckReferences(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	_ = os.MkdirAll(filepath.Join(home, "docs"), 0755)
+	_ = os.MkdirAll(filepath.Join(h.dataRoot, "shared", "Team"), 0755)
+	trashDir := h.getTrashPath("alice")
+	_ = os.MkdirAll(trashDir, 0755)
+	_ = os.WriteFile(filepath.Join(trashDir, "t1"), []byte("a"), 0644)
+	_ = os.WriteFile(filepath.Join(trashDir, "stray"), []byte("b"), 0644)
+	if err := h.saveTrashMeta("alice", map[string]TrashItem{
+		"t1": {ID: "t1", Name: "a.txt", OriginalPath: "/home/a.txt"},
+		"t2": {ID: "t2", Name: "b.txt", OriginalPath: "/home/b.txt"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectScan := func() {
+		tc.Mock.ExpectQuery("FROM shares s").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "path", "creator_missing"}).
+				AddRow("s1", "users/alice/docs", false).
+				AddRow("s2", "users/alice/gone.txt", false).
+				AddRow("s3", "users/alice/docs", true))
+		tc.Mock.ExpectQuery("FROM file_shares fs").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "item_path", "owner", "owner_missing", "recipient_missing"}).
+				AddRow(1, "/home/docs", "alice", false, false).
+				AddRow(2, "/shared/Team/gone", "alice", false, false).
+				AddRow(3, "/home/docs", "alice", false, true))
+		tc.Mock.ExpectQuery("FROM shared_folder_members m").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "shared_folder_id", "name", "user_missing", "folder_missing"}).
+				AddRow(10, "f1", "Team", true, false))
+		tc.Mock.ExpectQuery("FROM shared_folders sf").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "members"}).
+				AddRow("f1", "Team", 1).
+				AddRow("f2", "Lost", 2))
+	}
+
+	// Report only: nothing is changed
+	expectScan()
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs(nil, "0.0.0.0", "integrity_check", "references", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	report, err := h.CheckReferences(false, false, "startup", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		IntegrityShareTargetMissing:   1,
+		IntegrityShareCreatorMissing:  1,
+		IntegrityFileShareItemMissing: 1,
+		IntegrityFileShareUserMissing: 1,
+		IntegrityMemberUserMissing:    1,
+		IntegrityDriveDirMissing:      1,
+		IntegrityTrashPayloadMissing:  1,
+		IntegrityTrashUntracked:       1,
+	}
+	got := make(map[string]int)
+	for _, g := range report.Groups {
+		got[g.Type] = g.Count
+	}
+	if len(got) != len(want) {
+		t.Fatalf("groups = %v, want %v", got, want)
+	}
+	for issueType, n := range want {
+		if got[issueType] != n {
+			t.Errorf("%s = %d, want %d", issueType, got[issueType], n)
+		}
+	}
+	if report.Total != 8 || report.Outstanding != 8 || report.Fixed != 0 {
+		t.Errorf("total/outstanding/fixed = %d/%d/%d", report.Total, report.Outstanding, report.Fixed)
+	}
+	if status := EffectiveIntegrityStatus(); status == nil || status.Outstanding != 8 {
+		t.Errorf("status = %+v", status)
+	}
+
+	// Fix through the admin endpoint
+	expectScan()
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("UPDATE shares SET is_active = FALSE").
+		WithArgs(pq.Array([]string{"s2"})).WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("UPDATE shares SET is_active = FALSE").
+		WithArgs(pq.Array([]string{"s3"})).WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("DELETE FROM file_shares").
+		WithArgs(pq.Array([]string{"3"})).WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("DELETE FROM shared_folder_members").
+		WithArgs(pq.Array([]string{"10"})).WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("admin1", "10.0.0.1", "integrity_fix", "/users/alice/gone.txt", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("admin1", "10.0.0.1", "integrity_fix", "/home/b.txt", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("admin1", "10.0.0.1", "integrity_check", "references", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req, _ := NewJSONRequest(http.MethodPost, "/api/admin/integrity/references?fix=true", nil)
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "admin1", "admin", true)
+	if err := h.CheckReferenceIntegrity(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+
+	var resp struct {
+		Data IntegrityReport `json:"data"`
+	}
+	if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Data.Applied {
+		t.Error("fix was not applied")
+	}
+	if resp.Data.Fixed != 5 || resp.Data.Outstanding != 3 {
+		t.Errorf("fixed/outstanding = %d/%d, want 5/3", resp.Data.Fixed, resp.Data.Outstanding)
+	}
+
+	meta, _ := h.loadTrashMeta("alice")
+	if _, ok := meta["t2"]; ok || len(meta) != 1 {
+		t.Errorf("trash meta = %v, want only t1", meta)
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, "stray")); err != nil {
+		t.Error("untracked trash payload must be left alone")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckReferenceIntegrity_RequiresAdmin(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	req, _ := NewJSONRequest(http.MethodPost, "/api/admin/integrity/references", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	_ = h.CheckReferenceIntegrity(c)
+	AssertStatus(t, tc.Recorder, http.StatusForbidden)
+}
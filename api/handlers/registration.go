@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Self-registration. With registration_enabled anyone can sign up through
+// POST /auth/register, limited to registration_email_domains when set. With
+// registration_require_approval new accounts are created inactive and
+// pending until an admin approves or rejects them; otherwise they can sign
+// in at once. Either way the home folder is created on first login.
+
+const (
+	settingRegistrationEnabled         = "registration_enabled"
+	settingRegistrationEmailDomains    = "registration_email_domains"
+	settingRegistrationRequireApproval = "registration_require_approval"
+)
+
+// RegistrationPolicy is the self-registration configuration
+type RegistrationPolicy struct {
+	Enabled          bool     `json:"enabled"`
+	RequiresApproval bool     `json:"requiresApproval"`
+	EmailDomains     []string `json:"emailDomains"`
+}
+
+// LoadRegistrationPolicy reads the registration settings. Registration is
+// off when no settings are available.
+func LoadRegistrationPolicy() RegistrationPolicy {
+	policy := RegistrationPolicy{RequiresApproval: true, EmailDomains: []string{}}
+	sh := GetGlobalSettingsHandler()
+	if sh == nil {
+		return policy
+	}
+	policy.Enabled = sh.GetSettingBool(settingRegistrationEnabled, false)
+	policy.RequiresApproval = sh.GetSettingBool(settingRegistrationRequireApproval, true)
+	value, _ := sh.GetSetting(settingRegistrationEmailDomains)
+	for _, domain := range strings.Split(value, ",") {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" {
+			policy.EmailDomains = append(policy.EmailDomains, domain)
+		}
+	}
+	return policy
+}
+
+// EmailAllowed reports whether an email address may register: any address
+// without a domain list, otherwise one of the listed domains
+func (p RegistrationPolicy) EmailAllowed(email string) bool {
+	if len(p.EmailDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range p.EmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingUser is a self-registered account waiting for approval
+type PendingUser struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	Email        string    `json:"email"`
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// GetRegistrationPolicy tells the login page whether sign-up is offered
+// @Summary		Get registration policy
+// @Description	Returns whether self-registration is enabled, whether new accounts need admin approval and the allowed email domains (empty: any)
+// @Tags		Auth
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse{data=RegistrationPolicy}	"Registration policy"
+// @Router		/auth/registration [get]
+func (h *AuthHandler) GetRegistrationPolicy(c echo.Context) error {
+	return RespondSuccess(c, LoadRegistrationPolicy())
+}
+
+// registrationPending reports whether an inactive account is waiting for
+// approval rather than disabled
+func (h *AuthHandler) registrationPending(userID string) bool {
+	var pending bool
+	err := h.db.QueryRow(`SELECT registration_pending FROM users WHERE id = $1`, userID).Scan(&pending)
+	return err == nil && pending
+}
+
+// ListPendingUsers lists the registrations waiting for approval
+// @Summary		List pending registrations
+// @Description	Lists self-registered accounts waiting for admin approval, oldest first
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Pending accounts"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/admin/users/pending [get]
+func (h *AuthHandler) ListPendingUsers(c echo.Context) error {
+	rows, err := h.db.Query(`
+		SELECT id, username, COALESCE(email, ''), created_at
+		FROM users
+		WHERE registration_pending
+		ORDER BY created_at
+	`)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	defer rows.Close()
+
+	users := []PendingUser{}
+	for rows.Next() {
+		var u PendingUser
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.RegisteredAt); err != nil {
+			return RespondError(c, ErrInternal("Database error"))
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"users": users,
+		"total": len(users),
+	})
+}
+
+// ApproveUser activates a pending registration
+// @Summary		Approve registration
+// @Description	Activates a self-registered account. The user is emailed when SMTP is configured and they gave an address. The home folder is created on their first login.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"User ID"
+// @Success		200	{object}	docs.SuccessResponse	"Approved"
+// @Failure		404	{object}	docs.ErrorResponse	"No pending registration"
+// @Security	BearerAuth
+// @Router		/admin/users/{id}/approve [post]
+func (h *AuthHandler) ApproveUser(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	userID := c.Param("id")
+
+	var username, email string
+	err = h.db.QueryRow(`
+		UPDATE users SET is_active = TRUE, registration_pending = FALSE, updated_at = NOW()
+		WHERE id = $1 AND registration_pending
+		RETURNING username, COALESCE(email, '')
+	`, userID).Scan(&username, &email)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Pending registration"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("approve registration", err))
+	}
+	GetSessions().SetUserActive(userID, true)
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminUserApprove, username, map[string]interface{}{
+		"userId": userID,
+		"email":  email,
+	})
+
+	if email != "" && GetMailer().Configured() {
+		baseURL := appBaseURL(c)
+		go func() {
+			if err := GetMailer().Send(renderApprovalMail(email, username, baseURL)); err != nil {
+				log.Printf("[Mail] Failed to send approval mail to %s: %v", username, err)
+			}
+		}()
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"id":       userID,
+		"username": username,
+		"approved": true,
+	})
+}
+
+// RejectUser deletes a pending registration
+// @Summary		Reject registration
+// @Description	Deletes a self-registered account that has not been approved. The username and email can register again.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"User ID"
+// @Success		200	{object}	docs.SuccessResponse	"Rejected"
+// @Failure		404	{object}	docs.ErrorResponse	"No pending registration"
+// @Security	BearerAuth
+// @Router		/admin/users/{id}/reject [post]
+func (h *AuthHandler) RejectUser(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	userID := c.Param("id")
+
+	// Pending accounts have never signed in, so they own nothing
+	var username, email string
+	err = h.db.QueryRow(`
+		DELETE FROM users WHERE id = $1 AND registration_pending AND NOT is_active
+		RETURNING username, COALESCE(email, '')
+	`, userID).Scan(&username, &email)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Pending registration"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("reject registration", err))
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminUserReject, username, map[string]interface{}{
+		"userId": userID,
+		"email":  email,
+	})
+
+	return RespondSuccess(c, map[string]interface{}{
+		"id":       userID,
+		"username": username,
+		"rejected": true,
+	})
+}
+
+// renderApprovalMail tells a self-registered user their account is active
+func renderApprovalMail(to, username, baseURL string) Mail {
+	link := baseURL + "/login"
+	return Mail{
+		To:      to,
+		Subject: "Your FileHatch account is ready",
+		Text:    fmt.Sprintf("Hello %s,\n\nyour FileHatch account has been approved. You can sign in now:\n\n%s\n", username, link),
+		HTML: "<p>Hello " + html.EscapeString(username) + ",</p><p>your FileHatch account has been approved. You can sign in now: " +
+			`<a href="` + html.EscapeString(link) + `">` + html.EscapeString(link) + "</a></p>",
+	}
+}
+
+// registrationResponse is the Register response
+func registrationResponse(c echo.Context, userID string, pending bool) error {
+	message := "User registered successfully"
+	if pending {
+		message = "Registration received; an administrator has to approve the account before you can sign in"
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"id":      userID,
+		"pending": pending,
+		"message": message,
+	})
+}
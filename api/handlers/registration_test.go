@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func useRegistrationSettings(t *testing.T, enabled, approval, domains string) {
+	t.Helper()
+	useCachedSettings(t, map[string]string{
+		settingRegistrationEnabled:         enabled,
+		settingRegistrationRequireApproval: approval,
+		settingRegistrationEmailDomains:    domains,
+	})
+}
+
+func register(t *testing.T, tc *TestContext, h *AuthHandler, body map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := NewJSONRequest(http.MethodPost, "/api/auth/register", body)
+	rec := httptest.NewRecorder()
+	if err := h.Register(tc.Echo.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestLoadRegistrationPolicy(t *testing.T) {
+	useRegistrationSettings(t, "true", "", " Example.com, @corp.example ,")
+
+	policy := LoadRegistrationPolicy()
+	if !policy.Enabled || !policy.RequiresApproval {
+		t.Errorf("policy %+v: want enabled, approval by default", policy)
+	}
+	for email, want := range map[string]bool{
+		"alice@example.com":       true,
+		"bob@CORP.example":        true,
+		"eve@example.com.evil":    false,
+		"mallory@sub.example.com": false,
+		"":                        false,
+	} {
+		if got := policy.EmailAllowed(email); got != want {
+			t.Errorf("EmailAllowed(%q) = %v, want %v", email, got, want)
+		}
+	}
+	if !(RegistrationPolicy{}).EmailAllowed("") {
+		t.Error("without a domain list the email is optional")
+	}
+}
+
+func TestRegister_Disabled(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useRegistrationSettings(t, "false", "true", "")
+
+	rec := register(t, tc, CreateTestAuthHandler(tc.DB), map[string]string{
+		"username": "newuser", "email": "new@example.com", "password": "Password123!",
+	})
+	AssertStatus(t, rec, http.StatusForbidden)
+}
+
+func TestRegister_EmailDomainNotAllowed(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useRegistrationSettings(t, "true", "true", "example.com")
+
+	rec := register(t, tc, CreateTestAuthHandler(tc.DB), map[string]string{
+		"username": "newuser", "email": "new@elsewhere.org", "password": "Password123!",
+	})
+	AssertStatus(t, rec, http.StatusBadRequest)
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRegister_PendingApproval(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useRegistrationSettings(t, "true", "true", "example.com")
+
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)`)).
+		WithArgs("newuser").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO users`)).
+		WithArgs("newuser", "new@example.com", sqlmock.AnyArg(), false, true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("new-user-id"))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("new-user-id", sqlmock.AnyArg(), EventUserRegister, "newuser", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rec := register(t, tc, CreateTestAuthHandler(tc.DB), map[string]string{
+		"username": "newuser", "email": "new@example.com", "password": "Password123!",
+	})
+	AssertStatus(t, rec, http.StatusCreated)
+	var resp map[string]interface{}
+	_ = ParseJSONResponse(rec, &resp)
+	if resp["pending"] != true {
+		t.Errorf("response %v: want pending", resp)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogin_PendingApproval(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	handler := CreateTestAuthHandler(tc.DB)
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, username, email, password_hash, smb_hash, provider, is_admin, is_active`)).
+		WithArgs("newuser").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "username", "email", "password_hash", "smb_hash", "provider",
+			"is_admin", "is_active", "totp_enabled", "setup_completed", "created_at", "updated_at",
+		}).AddRow("new-user-id", "newuser", "new@example.com", string(passwordHash), nil, "local",
+			false, false, false, true, time.Now(), time.Now()))
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT registration_pending FROM users`)).
+		WithArgs("new-user-id").
+		WillReturnRows(sqlmock.NewRows([]string{"registration_pending"}).AddRow(true))
+
+	req, _ := NewJSONRequest(http.MethodPost, "/api/auth/login", map[string]string{
+		"username": "newuser",
+		"password": "password123",
+	})
+	_ = handler.Login(tc.Echo.NewContext(req, tc.Recorder))
+
+	AssertStatus(t, tc.Recorder, http.StatusForbidden)
+	AssertJSONError(t, tc.Recorder, "Account is awaiting admin approval")
+}
+
+func TestApproveAndRejectUser(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	handler := CreateTestAuthHandler(tc.DB)
+
+	run := func(action func(h *AuthHandler, c echo.Context) error, id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		c.Set("user", &JWTClaims{UserID: "admin-1", Username: "admin", IsAdmin: true})
+		if err := action(handler, c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	approve := (*AuthHandler).ApproveUser
+	reject := (*AuthHandler).RejectUser
+
+	// Approve activates a pending account and is audited; mail is off
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET is_active = TRUE, registration_pending = FALSE`)).
+		WithArgs("u-1").
+		WillReturnRows(sqlmock.NewRows([]string{"username", "email"}).AddRow("alice", ""))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("admin-1", sqlmock.AnyArg(), EventAdminUserApprove, "alice", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	AssertStatus(t, run(approve, "u-1"), http.StatusOK)
+
+	// Only pending registrations can be approved or rejected
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`UPDATE users SET is_active = TRUE`)).
+		WithArgs("u-2").
+		WillReturnRows(sqlmock.NewRows([]string{"username", "email"}))
+	AssertStatus(t, run(approve, "u-2"), http.StatusNotFound)
+
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM users WHERE id = $1 AND registration_pending AND NOT is_active`)).
+		WithArgs("u-3").
+		WillReturnRows(sqlmock.NewRows([]string{"username", "email"}).AddRow("bob", "bob@example.com"))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("admin-1", sqlmock.AnyArg(), EventAdminUserReject, "bob", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	AssertStatus(t, run(reject, "u-3"), http.StatusOK)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,758 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Retention modes
+const (
+	RetentionModeDuration = "duration" // Each file is held for a number of days after it was written
+	RetentionModeUntil    = "until"    // Every file is held until a fixed date
+)
+
+// headerRetentionOverride carries an approved override token on a change
+const headerRetentionOverride = "X-Retention-Override"
+
+// retentionOverrideTTL is how long an approved override token can be used
+const retentionOverrideTTL = time.Hour
+
+// maxRetentionDays bounds duration policies to a century
+const maxRetentionDays = 36500
+
+// RetentionPolicy puts the files of a folder under a WORM hold: they can be
+// added but not changed, renamed, moved or deleted while held
+type RetentionPolicy struct {
+	ID             string     `json:"id"`
+	Path           string     `json:"path"` // Data-root relative: shared/{drive}/... or users/{owner}/...
+	SharedFolderID *string    `json:"sharedFolderId,omitempty"`
+	Mode           string     `json:"mode"`
+	RetentionDays  int        `json:"retentionDays,omitempty"`
+	HoldUntil      *time.Time `json:"holdUntil,omitempty"`
+	Reason         string     `json:"reason"`
+	CreatedBy      *string    `json:"createdBy,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// heldUntil returns when the hold on a file last written at modTime ends.
+// Held files cannot be rewritten, so the modification time is when the
+// file was created in the folder.
+func (p *RetentionPolicy) heldUntil(modTime time.Time) time.Time {
+	if p.Mode == RetentionModeUntil && p.HoldUntil != nil {
+		return *p.HoldUntil
+	}
+	return modTime.Add(time.Duration(p.RetentionDays) * 24 * time.Hour)
+}
+
+// RetentionRegistry caches retention policies by path so write checks need
+// no query
+type RetentionRegistry struct {
+	db       *sql.DB
+	dataRoot string
+
+	mu       sync.RWMutex
+	policies map[string]*RetentionPolicy // By Path
+}
+
+var globalRetention *RetentionRegistry
+
+// InitRetention creates the global retention registry and loads the policies
+func InitRetention(db *sql.DB, dataRoot string) *RetentionRegistry {
+	globalRetention = &RetentionRegistry{db: db, dataRoot: dataRoot, policies: make(map[string]*RetentionPolicy)}
+	if err := globalRetention.Reload(); err != nil {
+		log.Printf("[Retention] Failed to load retention policies: %v", err)
+	}
+	return globalRetention
+}
+
+// GetRetention returns the global retention registry (nil if not initialized)
+func GetRetention() *RetentionRegistry {
+	return globalRetention
+}
+
+// Reload reads all retention policies from the database
+func (r *RetentionRegistry) Reload() error {
+	if r == nil {
+		return nil
+	}
+	rows, err := r.db.Query(`
+		SELECT id, path, shared_folder_id, mode, COALESCE(retention_days, 0), hold_until,
+		       reason, created_by, created_at
+		FROM retention_policies
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	policies := make(map[string]*RetentionPolicy)
+	for rows.Next() {
+		var p RetentionPolicy
+		var holdUntil sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Path, &p.SharedFolderID, &p.Mode, &p.RetentionDays, &holdUntil,
+			&p.Reason, &p.CreatedBy, &p.CreatedAt); err != nil {
+			return err
+		}
+		if holdUntil.Valid {
+			p.HoldUntil = &holdUntil.Time
+		}
+		policies[p.Path] = &p
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.policies = policies
+	r.mu.Unlock()
+	return nil
+}
+
+// List returns all retention policies
+func (r *RetentionRegistry) List() []*RetentionPolicy {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*RetentionPolicy, 0, len(r.policies))
+	for _, p := range r.policies {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// byID returns a retention policy by ID
+func (r *RetentionRegistry) byID(id string) *RetentionPolicy {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.policies {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// HoldsDrive reports whether a policy lies in a shared drive, whose SMB
+// export then has to stay read-only
+func (r *RetentionRegistry) HoldsDrive(folderID string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.policies {
+		if p.SharedFolderID != nil && *p.SharedFolderID == folderID {
+			return true
+		}
+	}
+	return false
+}
+
+// rel returns the data-root relative path of a real path, "" outside it
+func (r *RetentionRegistry) rel(realPath string) string {
+	rel, err := dataRootRel(r.dataRoot, realPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// policyAt returns the policy covering a data-root relative path
+func (r *RetentionRegistry) policyAt(rel string) *RetentionPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.policies) == 0 {
+		return nil
+	}
+	// Policies never nest, so the first one on the way up is the one
+	for p := rel; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if policy, ok := r.policies[p]; ok {
+			return policy
+		}
+	}
+	return nil
+}
+
+// below returns the policies whose folder is rel or inside it
+func (r *RetentionRegistry) below(rel string) []*RetentionPolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found []*RetentionPolicy
+	for p, policy := range r.policies {
+		if p == rel || strings.HasPrefix(p, rel+"/") {
+			found = append(found, policy)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Path < found[j].Path })
+	return found
+}
+
+// retentionHold is why a change was refused: a held file, or a policy
+// folder that would be moved or deleted along with its parent
+type retentionHold struct {
+	policy *RetentionPolicy
+	path   string    // Data-root relative path of the held file or policy folder
+	until  time.Time // Zero for a policy folder
+}
+
+// apiError describes the hold to the client
+func (h *retentionHold) apiError() *APIError {
+	details := map[string]interface{}{
+		"policyId":   h.policy.ID,
+		"policyPath": h.policy.Path,
+		"mode":       h.policy.Mode,
+		"reason":     h.policy.Reason,
+	}
+	if h.until.IsZero() {
+		return NewAPIError(ErrCodeRetentionHold, fmt.Sprintf("%s has a retention policy; remove the policy first", path.Base(h.path))).
+			WithDetails(details)
+	}
+	details["heldUntil"] = h.until
+	return NewAPIError(ErrCodeRetentionHold, fmt.Sprintf("%s is under a retention hold until %s", path.Base(h.path), h.until.Format(time.RFC3339))).
+		WithDetails(details)
+}
+
+// holdOn returns the hold that keeps realPath from being changed. With tree
+// the whole folder counts, as for deletes, moves and renames, and so does a
+// policy folder inside it. Files that do not exist yet are never held.
+func (r *RetentionRegistry) holdOn(realPath string, tree bool) *retentionHold {
+	if r == nil {
+		return nil
+	}
+	rel := r.rel(realPath)
+	if rel == "" {
+		return nil
+	}
+	if tree {
+		if found := r.below(rel); len(found) > 0 {
+			return &retentionHold{policy: found[0], path: found[0].Path}
+		}
+	}
+	policy := r.policyAt(rel)
+	if policy == nil {
+		return nil
+	}
+	info, err := os.Lstat(realPath)
+	if err != nil {
+		return nil
+	}
+	if !info.IsDir() {
+		return r.fileHold(policy, rel, info)
+	}
+	if !tree {
+		return nil
+	}
+	return r.heldBelow(policy, realPath)
+}
+
+// fileHold returns the hold on one file, nil once it has expired
+func (r *RetentionRegistry) fileHold(policy *RetentionPolicy, rel string, info os.FileInfo) *retentionHold {
+	until := policy.heldUntil(info.ModTime())
+	if !time.Now().Before(until) {
+		return nil
+	}
+	return &retentionHold{policy: policy, path: rel, until: until}
+}
+
+// heldBelow returns the first held file in a folder of a policy
+func (r *RetentionRegistry) heldBelow(policy *RetentionPolicy, realDir string) *retentionHold {
+	var hold *retentionHold
+	_ = filepath.WalkDir(realDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if hold = r.fileHold(policy, r.rel(p), info); hold != nil {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return hold
+}
+
+// Blocks reports whether a change to realPath is held, for callers that
+// cannot present an override or explain the hold (WebDAV)
+func (r *RetentionRegistry) Blocks(realPath string, tree bool) bool {
+	return r.holdOn(realPath, tree) != nil
+}
+
+// Check returns a RETENTION_HOLD error when operation would change a held
+// file at realPath (with tree: in or below it). An override token approved
+// for the actor lets the change through once. Refusals are audited as
+// security events, overrides as admin events.
+func (r *RetentionRegistry) Check(audit *AuditHandler, actorID *string, clientIP, token, realPath, operation string, tree bool) *APIError {
+	hold := r.holdOn(realPath, tree)
+	if hold == nil {
+		return nil
+	}
+	if clientIP == "" {
+		clientIP = "0.0.0.0"
+	}
+	details := map[string]interface{}{
+		"operation":  operation,
+		"policyId":   hold.policy.ID,
+		"policyPath": hold.policy.Path,
+	}
+	if !hold.until.IsZero() {
+		details["heldUntil"] = hold.until
+	}
+
+	// A policy folder cannot be moved away by override; the policy goes first
+	if token != "" && actorID != nil && !hold.until.IsZero() {
+		overrideID, err := r.useOverride(token, *actorID, hold.policy.ID, r.rel(realPath))
+		if err == nil {
+			details["overrideId"] = overrideID
+			if audit != nil {
+				_ = audit.LogEvent(actorID, clientIP, EventRetentionOverride, "/"+hold.path, details)
+			}
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("[Retention] Failed to check override: %v", err)
+		}
+		details["overrideRejected"] = true
+	}
+
+	if audit != nil {
+		_ = audit.LogEvent(actorID, clientIP, EventRetentionViolation, "/"+hold.path, details)
+	}
+	return hold.apiError()
+}
+
+// useOverride marks an approved, unexpired override of the actor covering
+// rel as used and returns its ID; sql.ErrNoRows if there is none
+func (r *RetentionRegistry) useOverride(token, actorID, policyID, rel string) (string, error) {
+	var id string
+	err := r.db.QueryRow(`
+		UPDATE retention_overrides SET used_at = NOW()
+		WHERE token_hash = $1 AND requested_by = $2 AND policy_id = $3
+		  AND used_at IS NULL AND expires_at > NOW()
+		  AND ($4 = path OR starts_with($4, path || '/'))
+		RETURNING id
+	`, hashRetentionToken(token), actorID, policyID, rel).Scan(&id)
+	return id, err
+}
+
+// hashRetentionToken returns the stored form of an override token
+func hashRetentionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// retentionError checks a change made through the API; see Check
+func (h *Handler) retentionError(c echo.Context, claims *JWTClaims, realPath, operation string, tree bool) *APIError {
+	var actorID *string
+	if claims != nil {
+		actorID = &claims.UserID
+	}
+	return GetRetention().Check(h.auditHandler, actorID, c.RealIP(), c.Request().Header.Get(headerRetentionOverride),
+		realPath, operation, tree)
+}
+
+// retentionSMBNotice explains what a policy means for SMB access
+func retentionSMBNotice(shared bool) string {
+	if shared {
+		return "SMB cannot hold single files, so the drive's whole SMB export is switched to read-only while the policy exists"
+	}
+	return "The SMB home share cannot be restricted per folder, so SMB clients of the owner can still change files in this folder"
+}
+
+// CreateRetentionPolicyRequest creates a retention policy
+type CreateRetentionPolicyRequest struct {
+	Path           string     `json:"path"` // shared/{drive}/... or users/{username}/...
+	Mode           string     `json:"mode"` // duration or until
+	RetentionDays  int        `json:"retentionDays"`
+	HoldUntil      *time.Time `json:"holdUntil"`
+	Reason         string     `json:"reason"`
+	AcknowledgeSMB bool       `json:"acknowledgeSmb"`
+}
+
+// CreateRetentionPolicy puts a folder under a WORM hold
+// @Summary		Create retention policy
+// @Description	Puts a shared drive or home subfolder (shared/{drive}/... or users/{username}/...) under a WORM hold. Files in it can be added but not overwritten, edited, renamed, moved, trashed or deleted until retentionDays after they were written (mode duration) or until holdUntil (mode until). Requires acknowledgeSmb: a drive's SMB export becomes read-only, and the SMB home share cannot enforce the hold.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		CreateRetentionPolicyRequest	true	"Policy"
+// @Success		201		{object}	RetentionPolicy		"Created policy"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid policy or SMB not acknowledged"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Folder not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Overlaps another policy"
+// @Security	BearerAuth
+// @Router		/admin/retention/policies [post]
+func (h *Handler) CreateRetentionPolicy(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+
+	var req CreateRetentionPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Path == "" || req.Reason == "" {
+		return RespondError(c, ErrMissingParameter("path and reason"))
+	}
+	switch req.Mode {
+	case RetentionModeDuration:
+		if req.RetentionDays <= 0 || req.RetentionDays > maxRetentionDays {
+			return RespondError(c, ErrBadRequest(fmt.Sprintf("retentionDays must be between 1 and %d", maxRetentionDays)))
+		}
+		req.HoldUntil = nil
+	case RetentionModeUntil:
+		if req.HoldUntil == nil || !req.HoldUntil.After(time.Now()) {
+			return RespondError(c, ErrBadRequest("holdUntil must be in the future"))
+		}
+		req.RetentionDays = 0
+	default:
+		return RespondError(c, ErrBadRequest("mode must be duration or until"))
+	}
+
+	target, apiErr := h.resolveAdoptTarget(req.Path)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if info, err := os.Stat(target.realPath); err != nil || !info.IsDir() {
+		return RespondError(c, ErrBadRequest("path must be a folder"))
+	}
+	r := GetRetention()
+	if p := r.policyAt(target.rel); p != nil {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "path is inside the retention policy on "+p.Path))
+	}
+	if below := r.below(target.rel); len(below) > 0 {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "path contains the retention policy on "+below[0].Path))
+	}
+	if !req.AcknowledgeSMB {
+		return RespondError(c, ErrBadRequest("acknowledgeSmb is required").
+			WithDetails(map[string]interface{}{"smb": retentionSMBNotice(target.shared)}))
+	}
+
+	var folderID *string
+	if target.shared {
+		var id string
+		if err := h.db.QueryRow(`SELECT id FROM shared_folders WHERE name = $1`, target.name).Scan(&id); err != nil {
+			return RespondError(c, ErrNotFound("Shared drive"))
+		}
+		folderID = &id
+	}
+
+	var days *int
+	if req.RetentionDays > 0 {
+		days = &req.RetentionDays
+	}
+	var id string
+	err = h.db.QueryRow(`
+		INSERT INTO retention_policies (path, shared_folder_id, mode, retention_days, hold_until, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, target.rel, folderID, req.Mode, days, req.HoldUntil, req.Reason, claims.UserID).Scan(&id)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("create retention policy", err))
+	}
+	if err := r.Reload(); err != nil {
+		log.Printf("[Retention] Failed to reload retention policies: %v", err)
+	}
+
+	// The drive's SMB export goes read-only through its own settings
+	if folderID != nil {
+		if smb, err := GetSMBShares().Get(*folderID); err == nil && smb != nil && !smb.ReadOnly {
+			smb.ReadOnly = true
+			if err := GetSMBShares().Set(*folderID, smb, claims.UserID); err != nil {
+				log.Printf("[Retention] Failed to make the SMB export of %s read-only: %v", target.name, err)
+			}
+			GetSMBShares().Sync(&claims.UserID, c.RealIP(), "retention_policy")
+		}
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventRetentionPolicy, "/"+target.rel, map[string]interface{}{
+		"action":        "create",
+		"policyId":      id,
+		"mode":          req.Mode,
+		"retentionDays": req.RetentionDays,
+		"holdUntil":     req.HoldUntil,
+		"reason":        req.Reason,
+		"smbNotice":     retentionSMBNotice(target.shared),
+	})
+
+	p := r.byID(id)
+	if p == nil {
+		p = &RetentionPolicy{ID: id, Path: target.rel, SharedFolderID: folderID, Mode: req.Mode,
+			RetentionDays: req.RetentionDays, HoldUntil: req.HoldUntil, Reason: req.Reason,
+			CreatedBy: &claims.UserID, CreatedAt: time.Now()}
+	}
+	return RespondCreated(c, p)
+}
+
+// ListRetentionPolicies lists the retention policies
+// @Summary		List retention policies
+// @Description	Lists the folders under a WORM retention hold.
+// @Tags		Admin
+// @Produce		json
+// @Success		200	{array}		RetentionPolicy	"Policies"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/retention/policies [get]
+func (h *Handler) ListRetentionPolicies(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	return RespondSuccess(c, GetRetention().List())
+}
+
+// DeleteRetentionPolicy removes a retention policy; while it still holds
+// files this takes an override approved by a second admin
+// @Summary		Remove retention policy
+// @Description	Removes a retention policy. While files in the folder are still held, the request needs an override for the policy folder approved by a second admin, sent as X-Retention-Override. A drive's SMB export stays read-only until it is changed in the drive's settings.
+// @Tags		Admin
+// @Produce		json
+// @Param		id						path		string	true	"Policy ID"
+// @Param		X-Retention-Override	header		string	false	"Approved override token"
+// @Success		200		{object}	docs.SuccessResponse	"Removed"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Failure		423		{object}	docs.ErrorResponse	"Files are still held"
+// @Security	BearerAuth
+// @Router		/admin/retention/policies/{id} [delete]
+func (h *Handler) DeleteRetentionPolicy(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	r := GetRetention()
+	p := r.byID(c.Param("id"))
+	if p == nil {
+		return RespondError(c, ErrNotFound("Retention policy"))
+	}
+
+	details := map[string]interface{}{"action": "delete", "policyId": p.ID}
+	if hold := r.heldBelow(p, GetStorageLocations().Map(filepath.Join(h.dataRoot, filepath.FromSlash(p.Path)))); hold != nil {
+		token := c.Request().Header.Get(headerRetentionOverride)
+		if token == "" {
+			return RespondError(c, hold.apiError())
+		}
+		overrideID, err := r.useOverride(token, claims.UserID, p.ID, p.Path)
+		if err != nil {
+			_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventRetentionViolation, "/"+p.Path, map[string]interface{}{
+				"operation":        "retention.policy_delete",
+				"policyId":         p.ID,
+				"policyPath":       p.Path,
+				"overrideRejected": true,
+			})
+			return RespondError(c, hold.apiError())
+		}
+		details["overrideId"] = overrideID
+	}
+
+	if _, err := h.db.Exec(`DELETE FROM retention_policies WHERE id = $1`, p.ID); err != nil {
+		return RespondError(c, ErrOperationFailed("remove retention policy", err))
+	}
+	if err := r.Reload(); err != nil {
+		log.Printf("[Retention] Failed to reload retention policies: %v", err)
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventRetentionPolicy, "/"+p.Path, details)
+	return RespondSuccess(c, map[string]string{"message": "Retention policy removed"})
+}
+
+// RetentionOverride is a request to change held files, approved by a
+// second admin
+type RetentionOverride struct {
+	ID          string     `json:"id"`
+	PolicyID    string     `json:"policyId"`
+	Path        string     `json:"path"`
+	Reason      string     `json:"reason"`
+	RequestedBy string     `json:"requestedBy"`
+	ApprovedBy  *string    `json:"approvedBy,omitempty"`
+	ApprovedAt  *time.Time `json:"approvedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	UsedAt      *time.Time `json:"usedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// RequestRetentionOverrideRequest asks for an override
+type RequestRetentionOverrideRequest struct {
+	Path   string `json:"path"` // Held file or folder, data-root relative
+	Reason string `json:"reason"`
+}
+
+// RequestRetentionOverride asks a second admin to approve one change to
+// held files
+// @Summary		Request retention override
+// @Description	Requests an override for a held file or folder (data-root relative). Another admin approves it and passes the resulting token back; the requester then sends it as X-Retention-Override to make one change at or below the path within an hour.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		RequestRetentionOverrideRequest	true	"Override"
+// @Success		201		{object}	RetentionOverride	"Pending override"
+// @Failure		400		{object}	docs.ErrorResponse	"Path is not held"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/retention/overrides [post]
+func (h *Handler) RequestRetentionOverride(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+
+	var req RequestRetentionOverrideRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Path == "" || req.Reason == "" {
+		return RespondError(c, ErrMissingParameter("path and reason"))
+	}
+	target, apiErr := h.resolveAdoptTarget(req.Path)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	p := GetRetention().policyAt(target.rel)
+	if p == nil {
+		return RespondError(c, ErrBadRequest("path is not under a retention policy"))
+	}
+
+	o := RetentionOverride{PolicyID: p.ID, Path: target.rel, Reason: req.Reason, RequestedBy: claims.UserID}
+	err = h.db.QueryRow(`
+		INSERT INTO retention_overrides (policy_id, path, reason, requested_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, p.ID, target.rel, req.Reason, claims.UserID).Scan(&o.ID, &o.CreatedAt)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("request override", err))
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventRetentionOverride, "/"+target.rel, map[string]interface{}{
+		"action":     "request",
+		"overrideId": o.ID,
+		"policyId":   p.ID,
+		"reason":     req.Reason,
+	})
+	return RespondCreated(c, o)
+}
+
+// ListRetentionOverrides lists override requests, newest first
+// @Summary		List retention overrides
+// @Description	Lists retention override requests with their approval and use.
+// @Tags		Admin
+// @Produce		json
+// @Success		200	{array}		RetentionOverride	"Overrides"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/retention/overrides [get]
+func (h *Handler) ListRetentionOverrides(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+	rows, err := h.db.Query(`
+		SELECT id, policy_id, path, reason, requested_by, approved_by, approved_at, expires_at, used_at, created_at
+		FROM retention_overrides
+		ORDER BY created_at DESC
+		LIMIT 200
+	`)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list overrides", err))
+	}
+	defer rows.Close()
+	overrides := make([]RetentionOverride, 0)
+	for rows.Next() {
+		var o RetentionOverride
+		if err := rows.Scan(&o.ID, &o.PolicyID, &o.Path, &o.Reason, &o.RequestedBy, &o.ApprovedBy,
+			&o.ApprovedAt, &o.ExpiresAt, &o.UsedAt, &o.CreatedAt); err != nil {
+			return RespondError(c, ErrOperationFailed("list overrides", err))
+		}
+		overrides = append(overrides, o)
+	}
+	return RespondSuccess(c, overrides)
+}
+
+// ApproveRetentionOverride approves another admin's override request and
+// returns the token for it, which is shown only once
+// @Summary		Approve retention override
+// @Description	Approves an override requested by another admin. The response holds the one-time token the requester sends as X-Retention-Override; it is valid for one change within an hour and is not stored.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Override ID"
+// @Success		200	{object}	docs.SuccessResponse	"Token and expiry"
+// @Failure		403	{object}	docs.ErrorResponse	"Requester cannot approve"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Failure		409	{object}	docs.ErrorResponse	"Already approved"
+// @Security	BearerAuth
+// @Router		/admin/retention/overrides/{id}/approve [post]
+func (h *Handler) ApproveRetentionOverride(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err
+	}
+
+	var requestedBy, overridePath string
+	var approvedBy *string
+	err = h.db.QueryRow(`SELECT requested_by, approved_by, path FROM retention_overrides WHERE id = $1`, c.Param("id")).
+		Scan(&requestedBy, &approvedBy, &overridePath)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Retention override"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load override", err))
+	}
+	if requestedBy == claims.UserID {
+		return RespondError(c, ErrForbidden("A second admin has to approve this override"))
+	}
+	if approvedBy != nil {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "Override is already approved"))
+	}
+
+	token, err := GenerateSecureToken(32)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to generate token"))
+	}
+	expiresAt := time.Now().Add(retentionOverrideTTL)
+	res, err := h.db.Exec(`
+		UPDATE retention_overrides
+		SET approved_by = $2, approved_at = NOW(), token_hash = $3, expires_at = $4
+		WHERE id = $1 AND approved_by IS NULL
+	`, c.Param("id"), claims.UserID, hashRetentionToken(token), expiresAt)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("approve override", err))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "Override is already approved"))
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventRetentionOverride, "/"+overridePath, map[string]interface{}{
+		"action":      "approve",
+		"overrideId":  c.Param("id"),
+		"requestedBy": requestedBy,
+		"expiresAt":   expiresAt,
+	})
+	return RespondSuccess(c, map[string]interface{}{
+		"token":     token,
+		"expiresAt": expiresAt,
+	})
+}
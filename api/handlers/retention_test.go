@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// withRetention installs a registry holding the given policies for the
+// duration of the test
+func withRetention(t *testing.T, db *sql.DB, dataRoot string, policies ...*RetentionPolicy) {
+	t.Helper()
+	prev := globalRetention
+	globalRetention = &RetentionRegistry{db: db, dataRoot: dataRoot, policies: make(map[string]*RetentionPolicy)}
+	for _, p := range policies {
+		globalRetention.policies[p.Path] = p
+	}
+	t.Cleanup(func() { globalRetention = prev })
+}
+
+// writeAged creates a file last written age ago
+func writeAged(t *testing.T, realPath string, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(realPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(realPath, []byte("record"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(-age)
+	if err := os.Chtimes(realPath, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetentionRegistry_Holds(t *testing.T) {
+	dataRoot := t.TempDir()
+	until := time.Now().Add(48 * time.Hour)
+	withRetention(t, nil, dataRoot,
+		&RetentionPolicy{ID: "p1", Path: "users/alice/legal", Mode: RetentionModeDuration, RetentionDays: 30},
+		&RetentionPolicy{ID: "p2", Path: "shared/Finance/2025", Mode: RetentionModeUntil, HoldUntil: &until},
+	)
+	legal := filepath.Join(dataRoot, "users/alice/legal")
+	writeAged(t, filepath.Join(legal, "new.pdf"), time.Hour)
+	writeAged(t, filepath.Join(legal, "expired/old.pdf"), 40*24*time.Hour)
+	writeAged(t, filepath.Join(dataRoot, "shared/Finance/2025/ledger.xlsx"), 400*24*time.Hour)
+	writeAged(t, filepath.Join(dataRoot, "users/alice/notes.txt"), time.Hour)
+	r := GetRetention()
+
+	for name, tc := range map[string]struct {
+		path string
+		tree bool
+		held bool
+	}{
+		"new file":                  {"users/alice/legal/new.pdf", false, true},
+		"expired file":              {"users/alice/legal/expired/old.pdf", false, false},
+		"folder of expired files":   {"users/alice/legal/expired", true, false},
+		"until date":                {"shared/Finance/2025/ledger.xlsx", false, true},
+		"file outside":              {"users/alice/notes.txt", true, false},
+		"file not written yet":      {"users/alice/legal/upload.pdf", false, false},
+		"folder holding a new file": {"users/alice/legal", true, true},
+		"parent of a policy":        {"users/alice", true, true},
+		"folder itself, no tree":    {"users/alice/legal", false, false},
+	} {
+		if held := r.Blocks(filepath.Join(dataRoot, tc.path), tc.tree); held != tc.held {
+			t.Errorf("%s: Blocks(%s) = %v, want %v", name, tc.path, held, tc.held)
+		}
+	}
+
+	apiErr := r.holdOn(filepath.Join(legal, "new.pdf"), false).apiError()
+	if apiErr.Code != ErrCodeRetentionHold || apiErr.HTTPStatus() != http.StatusLocked {
+		t.Errorf("apiError = %+v", apiErr)
+	}
+	if r.HoldsDrive("") || r.policyAt("users/alice/legal/a/b") == nil || len(r.below("users")) != 1 {
+		t.Error("lookup by path is wrong")
+	}
+}
+
+func TestRetention_DeleteFileAndOverride(t *testing.T) {
+	ftc := SetupFileTest(t)
+	defer ftc.Cleanup()
+	userDir := ftc.CreateTestUser(t, "testuser")
+	withRetention(t, ftc.DB, ftc.DataRoot,
+		&RetentionPolicy{ID: "p1", Path: "users/testuser/legal", Mode: RetentionModeDuration, RetentionDays: 30})
+	held := filepath.Join(userDir, "legal/contract.pdf")
+	writeAged(t, held, time.Hour)
+	writeAged(t, filepath.Join(userDir, "legal/old.pdf"), 40*24*time.Hour)
+
+	del := func(name string, headers map[string]string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "/api/files/home/legal/"+name, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		c := CreateAuthenticatedContext(ftc.Echo, rec, req, "1", "testuser", false)
+		c.SetParamNames("*")
+		c.SetParamValues("home/legal/" + name)
+		if err := ftc.Handler.DeleteFile(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	expectDelete := func() {
+		ftc.Mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	// A held file is refused and the attempt is audited as a security event
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), EventRetentionViolation, "/users/testuser/legal/contract.pdf", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	rec := del("contract.pdf", nil)
+	AssertStatus(t, rec, http.StatusLocked)
+	var body struct {
+		Code ErrorCode `json:"code"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if body.Code != ErrCodeRetentionHold {
+		t.Errorf("code = %q", body.Code)
+	}
+	if _, err := os.Stat(held); err != nil {
+		t.Fatal("held file was deleted")
+	}
+
+	// Once its hold has expired a file goes as usual
+	expectDelete()
+	AssertStatus(t, del("old.pdf", nil), http.StatusOK)
+
+	// An approved override lets one delete through
+	ftc.Mock.ExpectQuery("UPDATE retention_overrides SET used_at").
+		WithArgs(hashRetentionToken("approved"), "1", "p1", "users/testuser/legal/contract.pdf").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("o1"))
+	ftc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), EventRetentionOverride, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectDelete()
+	AssertStatus(t, del("contract.pdf", map[string]string{headerRetentionOverride: "approved"}), http.StatusOK)
+	if _, err := os.Stat(held); !os.IsNotExist(err) {
+		t.Error("file was not deleted with the override")
+	}
+
+	if err := ftc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApproveRetentionOverride_SecondAdmin(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &Handler{db: tc.DB, auditHandler: &AuditHandler{db: tc.DB}}
+
+	approve := func(userID string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/retention/overrides/o1/approve", nil)
+		c := CreateAuthenticatedContext(tc.Echo, rec, req, userID, "admin-"+userID, true)
+		c.SetParamNames("id")
+		c.SetParamValues("o1")
+		if err := h.ApproveRetentionOverride(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	expectOverride := func() {
+		tc.Mock.ExpectQuery("SELECT requested_by, approved_by, path FROM retention_overrides").WithArgs("o1").
+			WillReturnRows(sqlmock.NewRows([]string{"requested_by", "approved_by", "path"}).
+				AddRow("a1", nil, "users/alice/legal/contract.pdf"))
+	}
+
+	// The requester cannot approve their own override
+	expectOverride()
+	AssertStatus(t, approve("a1"), http.StatusForbidden)
+
+	expectOverride()
+	tc.Mock.ExpectExec("UPDATE retention_overrides").
+		WithArgs("o1", "a2", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(1, 1))
+	rec := approve("a2")
+	AssertStatus(t, rec, http.StatusOK)
+	var resp struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Data.Token) != 64 {
+		t.Errorf("token = %q", resp.Data.Token)
+	}
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,21 +18,23 @@ import (
 
 // SearchResult represents a search result item
 type SearchResult struct {
-	Name         string     `json:"name"`
-	Path         string     `json:"path"`
-	Size         int64      `json:"size"`
-	IsDir        bool       `json:"isDir"`
-	ModTime      time.Time  `json:"modTime"`
-	Extension    string     `json:"extension,omitempty"`
-	MimeType     string     `json:"mimeType,omitempty"`
-	MatchType    string     `json:"matchType,omitempty"`   // "name", "tag", "description", "trash"
-	MatchedTag   string     `json:"matchedTag,omitempty"`  // The matched tag (if matchType is "tag")
-	Description  string     `json:"description,omitempty"` // File description
-	Tags         []string   `json:"tags,omitempty"`        // File tags
-	InTrash      bool       `json:"inTrash,omitempty"`     // Whether the item is in trash
-	TrashID      string     `json:"trashId,omitempty"`     // Trash ID for restore/delete
-	OriginalPath string     `json:"originalPath,omitempty"` // Original path before deletion
-	DeletedAt    *time.Time `json:"deletedAt,omitempty"`   // When the item was deleted
+	Name         string       `json:"name"`
+	Path         string       `json:"path"`
+	Size         int64        `json:"size"`
+	IsDir        bool         `json:"isDir"`
+	ModTime      time.Time    `json:"modTime"`
+	Extension    string       `json:"extension,omitempty"`
+	MimeType     string       `json:"mimeType,omitempty"`
+	MatchType    string       `json:"matchType,omitempty"`    // "name", "tag", "description", "content", "trash"
+	MatchedTag   string       `json:"matchedTag,omitempty"`   // The matched tag (if matchType is "tag")
+	Description  string       `json:"description,omitempty"`  // File description
+	Tags         []string     `json:"tags,omitempty"`         // File tags
+	InTrash      bool         `json:"inTrash,omitempty"`      // Whether the item is in trash
+	TrashID      string       `json:"trashId,omitempty"`      // Trash ID for restore/delete
+	OriginalPath string       `json:"originalPath,omitempty"` // Original path before deletion
+	DeletedAt    *time.Time   `json:"deletedAt,omitempty"`    // When the item was deleted
+	Snippet      string       `json:"snippet,omitempty"`      // Text around the match (if the content matched)
+	MatchRanges  []MatchRange `json:"matchRanges,omitempty"`  // Where the query occurs in the name, for highlighting
 }
 
 // SearchResponse is the response for search queries
@@ -41,9 +45,14 @@ type SearchResponse struct {
 	Page      int            `json:"page"`
 	Limit     int            `json:"limit"`
 	HasMore   bool           `json:"hasMore"`
-	MatchType string         `json:"matchType,omitempty"` // Filter applied: "all", "name", "tag", "description", "trash"
+	MatchType string         `json:"matchType,omitempty"` // Filter applied: "all", "name", "tag", "description", "content", "trash"
+	Partial   bool           `json:"partial,omitempty"`   // The search stopped at its time budget
 }
 
+// searchMaxResults bounds the results collected per search source; pages
+// are cut from these
+const searchMaxResults = 500
+
 // isGlobPattern checks if a query string contains glob pattern characters
 func isGlobPattern(query string) bool {
 	return strings.ContainsAny(query, "*?[")
@@ -62,7 +71,8 @@ func matchFileName(filename, query string, isGlob bool) bool {
 	return strings.Contains(filenameLower, query)
 }
 
-// SearchFiles searches for files and folders by name, tag, or description
+// SearchFiles searches for files and folders by name, tag, description, or
+// document content when the content index is on
 func (h *Handler) SearchFiles(c echo.Context) error {
 	query := c.QueryParam("q")
 	if query == "" {
@@ -91,7 +101,7 @@ func (h *Handler) SearchFiles(c echo.Context) error {
 		}
 	}
 
-	// Parse match type filter: "all", "name", "tag", "description"
+	// Parse match type filter: "all", "name", "tag", "description", "content"
 	matchTypeFilter := c.QueryParam("matchType")
 	if matchTypeFilter == "" {
 		matchTypeFilter = "all"
@@ -103,38 +113,111 @@ func (h *Handler) SearchFiles(c echo.Context) error {
 		claims = user
 	}
 
+	ctx, cancel := budgetSearch.Context(c.Request().Context())
+	defer cancel()
+
+	// Tag and description searches are paged by the database
+	if claims != nil && (matchTypeFilter == "tag" || matchTypeFilter == "description") {
+		queryLower := strings.ToLower(query)
+		results, total := h.searchInMetadataFiltered(ctx, queryLower, claims, matchTypeFilter, limit, (page-1)*limit)
+		if results == nil {
+			results = []SearchResult{}
+		}
+		for i := range results {
+			results[i].MatchRanges = nameMatchRanges(results[i].Name, queryLower)
+		}
+		return c.JSON(http.StatusOK, SearchResponse{
+			Query:     query,
+			Results:   results,
+			Total:     total,
+			Page:      page,
+			Limit:     limit,
+			HasMore:   page*limit < total,
+			MatchType: matchTypeFilter,
+		})
+	}
+
+	allResults, err := h.searchAll(ctx, query, searchPath, matchTypeFilter, claims)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	// A cancelled search is discarded; one over its budget returns what it found
+	stopped := walkStopped(ctx)
+	if stopped != nil && !errors.Is(stopped, errWalkBudget) {
+		return nil
+	}
+
+	// Apply pagination
+	totalCount := len(allResults)
+	startIdx := (page - 1) * limit
+	endIdx := startIdx + limit
+
+	var paginatedResults []SearchResult
+	if startIdx < totalCount {
+		if endIdx > totalCount {
+			endIdx = totalCount
+		}
+		paginatedResults = allResults[startIdx:endIdx]
+	}
+
+	// Ensure results is never nil
+	if paginatedResults == nil {
+		paginatedResults = []SearchResult{}
+	}
+
+	hasMore := endIdx < totalCount
+
+	return c.JSON(http.StatusOK, SearchResponse{
+		Query:     query,
+		Results:   paginatedResults,
+		Total:     totalCount,
+		Page:      page,
+		Limit:     limit,
+		HasMore:   hasMore,
+		MatchType: matchTypeFilter,
+		Partial:   stopped != nil,
+	})
+}
+
+// searchAll runs a search without pagination. Name matches are ranked
+// (see rankSearchResults) and cut to searchMaxResults, then tag, description
+// and content matches follow. It stops early when ctx ends; walkStopped(ctx)
+// then tells the results are incomplete.
+func (h *Handler) searchAll(ctx context.Context, query, searchPath, matchTypeFilter string, claims *JWTClaims) ([]SearchResult, error) {
 	queryLower := strings.ToLower(query)
 	isGlob := isGlobPattern(queryLower)
-	// Fetch more than needed for pagination
-	maxResults := 500
 
 	var allResults []SearchResult
 
 	// Search by file name (only if filter allows)
 	if matchTypeFilter == "all" || matchTypeFilter == "name" {
 		if searchPath == "/" {
-			allResults = h.parallelSearch(queryLower, isGlob, claims, maxResults)
+			allResults = h.parallelSearch(ctx, queryLower, isGlob, claims, searchMaxCandidates)
 		} else {
 			realPath, storageType, displayPath, err := h.resolvePath(searchPath, claims)
 			if err != nil {
-				return c.JSON(http.StatusBadRequest, map[string]string{
-					"error": err.Error(),
-				})
+				return nil, err
 			}
 
 			if storageType == "root" {
-				return c.JSON(http.StatusBadRequest, map[string]string{
-					"error": "Cannot search root",
-				})
+				return nil, errors.New("Cannot search root")
 			}
 
-			allResults = h.searchInDirParallel(realPath, displayPath, queryLower, isGlob, maxResults)
+			allResults = h.searchInDirParallel(ctx, realPath, displayPath, queryLower, isGlob, searchMaxCandidates)
+		}
+
+		// The walks collect in directory order; the best matches are kept
+		rankSearchResults(allResults, queryLower, isGlob)
+		if len(allResults) > searchMaxResults {
+			allResults = allResults[:searchMaxResults]
 		}
 	}
 
 	// Search in file metadata (tags and descriptions)
 	if claims != nil && (matchTypeFilter == "all" || matchTypeFilter == "tag" || matchTypeFilter == "description") {
-		metadataResults := h.searchInMetadataFiltered(queryLower, claims.UserID, maxResults, matchTypeFilter)
+		metadataResults, _ := h.searchInMetadataFiltered(ctx, queryLower, claims, matchTypeFilter, searchMaxResults, 0)
 
 		// Merge results, avoiding duplicates
 		existingPaths := make(map[string]bool)
@@ -150,35 +233,32 @@ func (h *Handler) SearchFiles(c echo.Context) error {
 		}
 	}
 
-	// Apply pagination
-	totalCount := len(allResults)
-	startIdx := (page - 1) * limit
-	endIdx := startIdx + limit
+	// Search in document text, best ranked first; files that matched
+	// otherwise get the snippet
+	if !isGlob && (matchTypeFilter == "all" || matchTypeFilter == "content") {
+		contentResults := h.searchContent(ctx, query, searchPath, claims, searchMaxResults)
 
-	var paginatedResults []SearchResult
-	if startIdx < totalCount {
-		if endIdx > totalCount {
-			endIdx = totalCount
+		existing := make(map[string]int, len(allResults))
+		for i, r := range allResults {
+			existing[r.Path] = i
 		}
-		paginatedResults = allResults[startIdx:endIdx]
-	}
 
-	// Ensure results is never nil
-	if paginatedResults == nil {
-		paginatedResults = []SearchResult{}
+		for _, cr := range contentResults {
+			if i, ok := existing[cr.Path]; ok {
+				allResults[i].Snippet = cr.Snippet
+				continue
+			}
+			allResults = append(allResults, cr)
+			existing[cr.Path] = len(allResults) - 1
+		}
 	}
 
-	hasMore := endIdx < totalCount
-
-	return c.JSON(http.StatusOK, SearchResponse{
-		Query:     query,
-		Results:   paginatedResults,
-		Total:     totalCount,
-		Page:      page,
-		Limit:     limit,
-		HasMore:   hasMore,
-		MatchType: matchTypeFilter,
-	})
+	if !isGlob {
+		for i := range allResults {
+			allResults[i].MatchRanges = nameMatchRanges(allResults[i].Name, queryLower)
+		}
+	}
+	return allResults, nil
 }
 
 // searchTarget represents a directory to search
@@ -188,7 +268,7 @@ type searchTarget struct {
 }
 
 // parallelSearch searches in multiple directories in parallel
-func (h *Handler) parallelSearch(query string, isGlob bool, claims *JWTClaims, maxResults int) []SearchResult {
+func (h *Handler) parallelSearch(ctx context.Context, query string, isGlob bool, claims *JWTClaims, maxResults int) []SearchResult {
 	// Collect search targets
 	targets := []searchTarget{
 		{
@@ -206,7 +286,7 @@ func (h *Handler) parallelSearch(query string, isGlob bool, claims *JWTClaims, m
 
 	// Search all targets in parallel
 	allResults := lop.Map(targets, func(target searchTarget, _ int) []SearchResult {
-		return h.searchInDirParallel(target.RealPath, target.DisplayPath, query, isGlob, maxResults)
+		return h.searchInDirParallel(ctx, target.RealPath, target.DisplayPath, query, isGlob, maxResults)
 	})
 
 	// Merge results
@@ -222,8 +302,9 @@ func (h *Handler) parallelSearch(query string, isGlob bool, claims *JWTClaims, m
 	return merged
 }
 
-// searchInDirParallel searches for files in a directory using parallel processing
-func (h *Handler) searchInDirParallel(realPath, displayPath, query string, isGlob bool, maxResults int) []SearchResult {
+// searchInDirParallel searches for files in a directory using parallel
+// processing. The walks stop when ctx ends.
+func (h *Handler) searchInDirParallel(ctx context.Context, realPath, displayPath, query string, isGlob bool, maxResults int) []SearchResult {
 	// First, collect top-level directories for parallel processing
 	entries, err := os.ReadDir(realPath)
 	if err != nil {
@@ -290,6 +371,9 @@ func (h *Handler) searchInDirParallel(realPath, displayPath, query string, isGlo
 				return
 			}
 			mu.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
 
 			dirPath := filepath.Join(realPath, dir.Name())
 			dirDisplayPath := filepath.Join(displayPath, dir.Name())
@@ -308,7 +392,11 @@ func (h *Handler) searchInDirParallel(realPath, displayPath, query string, isGlo
 			}
 
 			// Search inside directory
+			guard := newWalkGuard(ctx)
 			_ = filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+				if guard.Check() != nil {
+					return filepath.SkipAll
+				}
 				if err != nil {
 					return nil
 				}
@@ -368,56 +456,46 @@ func (h *Handler) searchInDirParallel(realPath, displayPath, query string, isGlo
 	return results
 }
 
-// searchInMetadataFiltered searches for files by tag or description with match type filter
-func (h *Handler) searchInMetadataFiltered(query, userID string, maxResults int, matchTypeFilter string) []SearchResult {
-	var results []SearchResult
-
-	// Build query based on filter
-	var sqlQuery string
+// metadataSearchWhere returns the condition matching file_metadata rows
+// (alias fm) by tag, description or either against the LIKE pattern $2.
+// Both sides use the trigram indexes of migration 048.
+func metadataSearchWhere(matchTypeFilter string) string {
+	tag := `file_metadata_tag_text(fm.tags) LIKE $2`
+	description := `lower(fm.description) LIKE $2`
 	switch matchTypeFilter {
 	case "tag":
-		sqlQuery = `
-			SELECT file_path, description, tags
-			FROM file_metadata
-			WHERE user_id = $1 AND EXISTS (
-				SELECT 1 FROM jsonb_array_elements_text(tags) AS tag
-				WHERE LOWER(tag) LIKE '%' || $2 || '%'
-			)
-			LIMIT $3
-		`
+		return tag
 	case "description":
-		sqlQuery = `
-			SELECT file_path, description, tags
-			FROM file_metadata
-			WHERE user_id = $1 AND LOWER(description) LIKE '%' || $2 || '%'
-			LIMIT $3
-		`
-	default: // "all"
-		sqlQuery = `
-			SELECT file_path, description, tags
-			FROM file_metadata
-			WHERE user_id = $1 AND (
-				EXISTS (
-					SELECT 1 FROM jsonb_array_elements_text(tags) AS tag
-					WHERE LOWER(tag) LIKE '%' || $2 || '%'
-				)
-				OR LOWER(description) LIKE '%' || $2 || '%'
-			)
-			LIMIT $3
-		`
+		return description
 	}
+	return "(" + tag + " OR " + description + ")"
+}
+
+// searchInMetadataFiltered searches the user's files by tag or description
+// with match type filter. It returns one page of results ordered by path
+// and the number of matching rows; files gone from disk are left out of
+// the page.
+func (h *Handler) searchInMetadataFiltered(ctx context.Context, query string, claims *JWTClaims, matchTypeFilter string, limit, offset int) ([]SearchResult, int) {
+	var results []SearchResult
 
-	rows, err := h.db.Query(sqlQuery, userID, query, maxResults)
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT fm.file_path, COALESCE(fm.description, ''), fm.tags, COUNT(*) OVER ()
+		FROM file_metadata fm
+		WHERE fm.user_id = $1 AND `+metadataSearchWhere(matchTypeFilter)+`
+		ORDER BY fm.file_path
+		LIMIT $3 OFFSET $4
+	`, claims.UserID, "%"+escapeLikePattern(query)+"%", limit, offset)
 	if err != nil {
-		return results
+		return results, 0
 	}
 	defer rows.Close()
 
+	total := 0
 	for rows.Next() {
 		var filePath, description string
 		var tagsJSON []byte
 
-		if err := rows.Scan(&filePath, &description, &tagsJSON); err != nil {
+		if err := rows.Scan(&filePath, &description, &tagsJSON, &total); err != nil {
 			continue
 		}
 
@@ -444,13 +522,13 @@ func (h *Handler) searchInMetadataFiltered(query, userID string, maxResults int,
 		}
 
 		// Skip if filter doesn't match
-		if matchTypeFilter != "all" && matchType != matchTypeFilter {
+		if matchType == "" || (matchTypeFilter != "all" && matchType != matchTypeFilter) {
 			continue
 		}
 
 		// Get file info from filesystem
-		realPath, storageType, _, err := h.resolvePathByUserID(filePath, userID)
-		if err != nil || storageType == "root" {
+		realPath, storageType, _ := h.resolvePathForUsername(filePath, claims.Username)
+		if storageType == "root" {
 			continue
 		}
 
@@ -479,20 +557,17 @@ func (h *Handler) searchInMetadataFiltered(query, userID string, maxResults int,
 			Description: description,
 			Tags:        tags,
 		})
-
-		if len(results) >= maxResults {
-			break
-		}
 	}
 
-	return results
+	return results, total
 }
 
-// resolvePathByUserID resolves a virtual path to real path using user ID
-func (h *Handler) resolvePathByUserID(virtualPath, userID string) (realPath, storageType, displayPath string, err error) {
+// resolvePathForUsername resolves a /home or /shared virtual path of a user
+// to its real path
+func (h *Handler) resolvePathForUsername(virtualPath, username string) (realPath, storageType, displayPath string) {
 	parts := strings.SplitN(strings.TrimPrefix(virtualPath, "/"), "/", 2)
 	if len(parts) == 0 {
-		return "", "root", "/", nil
+		return "", "root", "/"
 	}
 
 	root := parts[0]
@@ -501,13 +576,6 @@ func (h *Handler) resolvePathByUserID(virtualPath, userID string) (realPath, sto
 		remaining = parts[1]
 	}
 
-	// Get username from user ID
-	var username string
-	err = h.db.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&username)
-	if err != nil {
-		return "", "", "", err
-	}
-
 	switch root {
 	case "home":
 		realPath = filepath.Join(h.dataRoot, "users", username, remaining)
@@ -518,8 +586,8 @@ func (h *Handler) resolvePathByUserID(virtualPath, userID string) (realPath, sto
 		storageType = "shared"
 		displayPath = virtualPath
 	default:
-		return "", "root", "/", nil
+		return "", "root", "/"
 	}
 
-	return realPath, storageType, displayPath, nil
+	return GetStorageLocations().Map(realPath), storageType, displayPath
 }
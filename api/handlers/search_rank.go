@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// searchMaxCandidates bounds the name matches a walk collects before they
+// are ranked and cut to searchMaxResults
+const searchMaxCandidates = 5000
+
+// How well a name matches a search query, best first
+const (
+	nameMatchExact     = iota // the name, or the name without extension, is the query
+	nameMatchPrefix           // the name starts with the query
+	nameMatchSubstring        // the query is inside the name, or a glob matched
+)
+
+// MatchRange is a matched part of a result's name. Offsets count UTF-16
+// code units like JavaScript string indexes; End is exclusive.
+type MatchRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// nameMatchClass rates how a name matches a lower-cased query
+func nameMatchClass(name, query string, isGlob bool) int {
+	if isGlob {
+		return nameMatchSubstring
+	}
+	nameLower := strings.ToLower(name)
+	switch {
+	case nameLower == query || strings.TrimSuffix(nameLower, strings.ToLower(filepath.Ext(name))) == query:
+		return nameMatchExact
+	case strings.HasPrefix(nameLower, query):
+		return nameMatchPrefix
+	}
+	return nameMatchSubstring
+}
+
+// rankSearchResults orders name matches: exact names, then prefixes, then
+// substrings; within each, shallower paths first, then the most recently
+// modified
+func rankSearchResults(results []SearchResult, query string, isGlob bool) {
+	classes := make(map[string]int, len(results))
+	for _, r := range results {
+		classes[r.Path] = nameMatchClass(r.Name, query, isGlob)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if ca, cb := classes[a.Path], classes[b.Path]; ca != cb {
+			return ca < cb
+		}
+		if da, db := strings.Count(a.Path, "/"), strings.Count(b.Path, "/"); da != db {
+			return da < db
+		}
+		if !a.ModTime.Equal(b.ModTime) {
+			return a.ModTime.After(b.ModTime)
+		}
+		return a.Path < b.Path
+	})
+}
+
+// nameMatchRanges returns where a lower-cased query occurs in a name,
+// ignoring case; nil when it does not
+func nameMatchRanges(name, query string) []MatchRange {
+	q := lowerRunes(query)
+	if len(q) == 0 {
+		return nil
+	}
+	runes := []rune(name)
+	lower := lowerRunes(name)
+
+	// UTF-16 offset of each rune
+	offsets := make([]int, len(runes)+1)
+	for i, r := range runes {
+		offsets[i+1] = offsets[i] + max(utf16.RuneLen(r), 1)
+	}
+
+	var ranges []MatchRange
+	for i := 0; i+len(q) <= len(lower); {
+		if runesEqual(lower[i:i+len(q)], q) {
+			ranges = append(ranges, MatchRange{Start: offsets[i], End: offsets[i+len(q)]})
+			i += len(q)
+			continue
+		}
+		i++
+	}
+	return ranges
+}
+
+// lowerRunes lower-cases rune by rune, so indexes match the original's runes
+func lowerRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+func runesEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
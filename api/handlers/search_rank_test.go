@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNameMatchRanges(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        []MatchRange
+	}{
+		{"Report.pdf", "report", []MatchRange{{0, 6}}},
+		{"report-REPORT.txt", "report", []MatchRange{{0, 6}, {7, 13}}},
+		{"월간 보고서.docx", "보고서", []MatchRange{{3, 6}}},
+		{"🎉 party.txt", "party", []MatchRange{{3, 8}}},
+		{"notes.txt", "report", nil},
+	}
+	for _, tt := range tests {
+		if got := nameMatchRanges(tt.name, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nameMatchRanges(%q, %q) = %v, want %v", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRankSearchResults(t *testing.T) {
+	now := time.Now()
+	results := []SearchResult{
+		{Name: "old-report.txt", Path: "/home/old-report.txt", ModTime: now},
+		{Name: "report-2024.xlsx", Path: "/home/a/b/report-2024.xlsx", ModTime: now},
+		{Name: "report.pdf", Path: "/home/a/b/c/report.pdf", ModTime: now},
+		{Name: "report-2023.xlsx", Path: "/home/a/report-2023.xlsx", ModTime: now.Add(-time.Hour)},
+		{Name: "report-2025.xlsx", Path: "/home/a/report-2025.xlsx", ModTime: now},
+	}
+	rankSearchResults(results, "report", false)
+
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Path)
+	}
+	want := []string{
+		"/home/a/b/c/report.pdf",     // exact, however deep
+		"/home/a/report-2025.xlsx",   // prefix, shallow, newer
+		"/home/a/report-2023.xlsx",   // prefix, shallow, older
+		"/home/a/b/report-2024.xlsx", // prefix, deeper
+		"/home/old-report.txt",       // substring
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestSearchAll_RanksBeforeTruncating(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	// More deep substring matches than a search returns, walked before the
+	// exact match in directory order
+	deep := filepath.Join(home, "a", "archive")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < searchMaxResults+50; i++ {
+		if err := os.WriteFile(filepath.Join(deep, "old-report-"+strconv.Itoa(i)+".txt"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFiles(t, home, "z/report.pdf")
+
+	results, err := h.searchAll(context.Background(), "Report", "/home", "name", &JWTClaims{UserID: "u1", Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != searchMaxResults {
+		t.Fatalf("%d results, want %d", len(results), searchMaxResults)
+	}
+	if results[0].Path != "/home/z/report.pdf" || !reflect.DeepEqual(results[0].MatchRanges, []MatchRange{{0, 6}}) {
+		t.Errorf("first result = %+v", results[0])
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSearchFiles_TagSearchPagedInSQL(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	writeTestFiles(t, home, "plans/q3_100%.xlsx")
+
+	// Wildcards in the query are literal; page 2 of 1 is offset 1
+	tc.Mock.ExpectQuery(`FROM file_metadata fm\s+WHERE fm.user_id = \$1 AND file_metadata_tag_text\(fm.tags\) LIKE \$2\s+ORDER BY fm.file_path\s+LIMIT \$3 OFFSET \$4`).
+		WithArgs("u1", `%100\%%`, 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "description", "tags", "count"}).
+			AddRow("/home/plans/q3_100%.xlsx", "", []byte(`["Budget", "Done 100%"]`), 3))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/files/search?q=100%25&matchType=tag&page=2&limit=1", nil)
+	c := CreateAuthenticatedContext(tc.Echo, rec, req, "u1", "alice", false)
+	if err := h.SearchFiles(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, rec, http.StatusOK)
+
+	var resp SearchResponse
+	if err := ParseJSONResponse(rec, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 3 || !resp.HasMore || len(resp.Results) != 1 {
+		t.Fatalf("response = %+v", resp)
+	}
+	r := resp.Results[0]
+	if r.Name != "q3_100%.xlsx" || r.MatchType != "tag" || r.MatchedTag != "Done 100%" || len(r.MatchRanges) != 1 {
+		t.Errorf("result = %+v", r)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSearchInMetadataFiltered_SkipsMissingFiles(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	writeTestFiles(t, home, "notes.txt")
+
+	tc.Mock.ExpectQuery(`lower\(fm.description\) LIKE \$2\)`).
+		WithArgs("u1", "%meeting%", searchMaxResults, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"file_path", "description", "tags", "count"}).
+			AddRow("/home/gone.txt", "Meeting notes", []byte(`[]`), 2).
+			AddRow("/home/notes.txt", "Meeting notes", []byte(`null`), 2))
+
+	results, total := h.searchInMetadataFiltered(t.Context(), "meeting", &JWTClaims{UserID: "u1", Username: "alice"}, "all", searchMaxResults, 0)
+	if total != 2 || len(results) != 1 || results[0].Path != "/home/notes.txt" || results[0].MatchType != "description" {
+		t.Errorf("results = %+v, total = %d", results, total)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,709 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Built-in alert rules; their thresholds, windows and cooldowns are rows in
+// alert_rules
+const (
+	AlertRuleFailedLoginsIP       = "failed_logins_ip"
+	AlertRuleAdminNewCountry      = "admin_new_country"
+	AlertRulePermanentDeleteBurst = "permanent_delete_burst"
+	AlertRuleAccountLocked        = "account_locked"
+)
+
+// NotifSecurityAlert is the notification type of a fired alert
+const NotifSecurityAlert = "security.alert"
+
+const (
+	// alertQueueSize is how many audit events wait for the rules engine;
+	// events beyond it are dropped rather than slowing down the request
+	alertQueueSize = 1024
+	// alertRulesTTL is how long rule configuration is cached
+	alertRulesTTL = time.Minute
+	// alertMaxEvents is how many triggering events a firing keeps
+	alertMaxEvents = 50
+	// alertWebhookTimeout bounds delivering an alert to the webhook
+	alertWebhookTimeout = 10 * time.Second
+)
+
+// AlertRule is the configuration of a built-in alert rule
+type AlertRule struct {
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	Enabled         bool       `json:"enabled"`
+	Threshold       int        `json:"threshold"`
+	WindowSeconds   int        `json:"windowSeconds"`
+	CooldownSeconds int        `json:"cooldownSeconds"`
+	UpdatedAt       *time.Time `json:"updatedAt,omitempty"`
+}
+
+// AlertEvent is an audit event as seen by the rules engine
+type AlertEvent struct {
+	Timestamp      time.Time              `json:"timestamp"`
+	ActorID        *string                `json:"actorId,omitempty"`
+	IPAddress      string                 `json:"ipAddress"`
+	EventType      string                 `json:"eventType"`
+	TargetResource string                 `json:"targetResource"`
+	Details        map[string]interface{} `json:"details,omitempty"`
+}
+
+// AlertFiring is one firing of a rule with the events that triggered it
+type AlertFiring struct {
+	ID      int64        `json:"id"`
+	Rule    string       `json:"rule"`
+	Key     string       `json:"key"` // IP address or user the rule counted
+	Summary string       `json:"summary"`
+	Events  []AlertEvent `json:"events"`
+	FiredAt time.Time    `json:"firedAt"`
+}
+
+// UpdateAlertRuleRequest changes the configuration of an alert rule
+type UpdateAlertRuleRequest struct {
+	Enabled         *bool `json:"enabled,omitempty"`
+	Threshold       *int  `json:"threshold,omitempty"`
+	WindowSeconds   *int  `json:"windowSeconds,omitempty"`
+	CooldownSeconds *int  `json:"cooldownSeconds,omitempty"`
+}
+
+// alertWindow holds the recent matching events of one rule and key
+type alertWindow struct {
+	events  []AlertEvent
+	weights []int
+}
+
+// AlertEngine evaluates the alert rules over the audit event stream.
+// LogEvent hands it every event it records; the engine counts matching
+// events per key in a sliding window and fires when a rule's threshold is
+// reached, notifying admins in the notification center, by email and on the
+// webhook. Each rule and key then stays quiet for the rule's cooldown.
+type AlertEngine struct {
+	db            *sql.DB
+	notifications *NotificationService
+	events        chan AlertEvent
+
+	mu          sync.Mutex
+	rules       map[string]AlertRule
+	rulesLoaded time.Time
+	windows     map[string]*alertWindow
+	lastFired   map[string]time.Time
+
+	// deliver sends a firing to admins; replaced in tests
+	deliver func(firing *AlertFiring)
+}
+
+var globalAlertEngine *AlertEngine
+
+// InitAlertEngine sets up the global rules engine and starts evaluating events
+func InitAlertEngine(db *sql.DB, notifications *NotificationService) *AlertEngine {
+	e := newAlertEngine(db, notifications)
+	e.loadCooldowns()
+	globalAlertEngine = e
+	go e.run()
+	return e
+}
+
+// GetAlertEngine returns the global rules engine (nil before InitAlertEngine)
+func GetAlertEngine() *AlertEngine {
+	return globalAlertEngine
+}
+
+func newAlertEngine(db *sql.DB, notifications *NotificationService) *AlertEngine {
+	e := &AlertEngine{
+		db:            db,
+		notifications: notifications,
+		events:        make(chan AlertEvent, alertQueueSize),
+		windows:       make(map[string]*alertWindow),
+		lastFired:     make(map[string]time.Time),
+	}
+	e.deliver = e.deliverFiring
+	return e
+}
+
+// Observe queues an audit event for the rules. It never blocks: when the
+// queue is full the event is not evaluated.
+func (e *AlertEngine) Observe(event AlertEvent) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.events <- event:
+	default:
+	}
+}
+
+// run evaluates queued events and drops idle windows every few minutes
+func (e *AlertEngine) run() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-e.events:
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						log.Printf("[Alerts] Recovered while evaluating %s: %v", event.EventType, r)
+					}
+				}()
+				e.process(event)
+			}()
+		case <-ticker.C:
+			e.sweep(time.Now())
+		}
+	}
+}
+
+// alertsEnabled reports whether the security_alerts_enabled setting is on
+func alertsEnabled() bool {
+	settings := GetGlobalSettingsHandler()
+	return settings == nil || settings.GetSettingBool("security_alerts_enabled", true)
+}
+
+// process evaluates one event against every enabled rule
+func (e *AlertEngine) process(event AlertEvent) {
+	if !alertsEnabled() {
+		return
+	}
+	rules := e.loadRules()
+
+	if rule, ok := rules[AlertRulePermanentDeleteBurst]; ok && rule.Enabled &&
+		event.EventType == EventTrashPurge && event.ActorID != nil {
+		e.count(rule, *event.ActorID, alertEventWeight(event), event,
+			"%d items permanently deleted by %s within %s")
+	}
+	if rule, ok := rules[AlertRuleFailedLoginsIP]; ok && rule.Enabled &&
+		event.EventType == EventLoginFailed && event.IPAddress != "" {
+		e.count(rule, event.IPAddress, 1, event, "%d failed logins from %s within %s")
+	}
+	if rule, ok := rules[AlertRuleAdminNewCountry]; ok && rule.Enabled &&
+		event.EventType == EventUserLogin && event.ActorID != nil {
+		e.checkLoginCountry(rule, event)
+	}
+	if rule, ok := rules[AlertRuleAccountLocked]; ok && rule.Enabled &&
+		event.EventType == EventAccountLocked && event.TargetResource != "" {
+		e.checkAccountLocked(rule, event)
+	}
+}
+
+// alertEventWeight is how many items an event stands for: a trash purge
+// records the number of items it removed
+func alertEventWeight(event AlertEvent) int {
+	switch n := event.Details["items"].(type) {
+	case int:
+		return max(n, 1)
+	case int64:
+		return max(int(n), 1)
+	case float64:
+		return max(int(n), 1)
+	}
+	return 1
+}
+
+// count adds an event to the window of a rule and key, and fires once the
+// weights within the window reach the threshold
+func (e *AlertEngine) count(rule AlertRule, key string, weight int, event AlertEvent, summary string) {
+	window := time.Duration(rule.WindowSeconds) * time.Second
+	id := rule.Name + "\x00" + key
+
+	e.mu.Lock()
+	w := e.windows[id]
+	if w == nil {
+		w = &alertWindow{}
+		e.windows[id] = w
+	}
+	w.events = append(w.events, event)
+	w.weights = append(w.weights, weight)
+	w.trim(event.Timestamp.Add(-window))
+
+	total := 0
+	for _, n := range w.weights {
+		total += n
+	}
+	if total < max(rule.Threshold, 1) || e.coolingDown(rule, key, event.Timestamp) {
+		e.mu.Unlock()
+		return
+	}
+	events := w.events
+	delete(e.windows, id)
+	e.lastFired[id] = event.Timestamp
+	e.mu.Unlock()
+
+	subject := key
+	if rule.Name == AlertRulePermanentDeleteBurst {
+		subject = alertUsername(e.db, key)
+	}
+	e.fire(rule, key, fmt.Sprintf(summary, total, subject, window), events)
+}
+
+// trim drops events before cutoff
+func (w *alertWindow) trim(cutoff time.Time) {
+	i := 0
+	for i < len(w.events) && w.events[i].Timestamp.Before(cutoff) {
+		i++
+	}
+	w.events = w.events[i:]
+	w.weights = w.weights[i:]
+}
+
+// coolingDown reports whether a rule fired for key within its cooldown;
+// e.mu must be held
+func (e *AlertEngine) coolingDown(rule AlertRule, key string, now time.Time) bool {
+	last, ok := e.lastFired[rule.Name+"\x00"+key]
+	return ok && now.Sub(last) < time.Duration(rule.CooldownSeconds)*time.Second
+}
+
+// sweep drops windows whose newest event is older than any rule window
+func (e *AlertEngine) sweep(now time.Time) {
+	longest := time.Duration(0)
+	for _, rule := range e.loadRules() {
+		longest = max(longest, time.Duration(rule.WindowSeconds)*time.Second)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, w := range e.windows {
+		if len(w.events) == 0 || now.Sub(w.events[len(w.events)-1].Timestamp) > longest {
+			delete(e.windows, id)
+		}
+	}
+}
+
+// checkLoginCountry fires when an admin account logs in from a country it
+// has not logged in from before. Without a GeoIP database, for addresses the
+// database does not know and for the first country an account is seen from
+// nothing fires.
+func (e *AlertEngine) checkLoginCountry(rule AlertRule, event AlertEvent) {
+	userID := *event.ActorID
+	perms, err := GetAdminRoles().Lookup(userID)
+	if err != nil || perms == nil || len(perms.Permissions) == 0 {
+		return
+	}
+	geoip := loadGeoIP()
+	if geoip == nil {
+		return
+	}
+	country, err := geoip.Country(net.ParseIP(event.IPAddress))
+	if err != nil || country == "" {
+		return
+	}
+
+	result, err := e.db.Exec(`
+		INSERT INTO admin_login_countries (user_id, country) VALUES ($1, $2)
+		ON CONFLICT (user_id, country) DO NOTHING
+	`, userID, country)
+	if err != nil {
+		log.Printf("[Alerts] Failed to record login country of %s: %v", userID, err)
+		return
+	}
+	if added, _ := result.RowsAffected(); added == 0 {
+		return
+	}
+	var known int
+	if err := e.db.QueryRow(`SELECT COUNT(*) FROM admin_login_countries WHERE user_id = $1`, userID).Scan(&known); err != nil || known < 2 {
+		return
+	}
+
+	e.mu.Lock()
+	if e.coolingDown(rule, userID, event.Timestamp) {
+		e.mu.Unlock()
+		return
+	}
+	e.lastFired[rule.Name+"\x00"+userID] = event.Timestamp
+	e.mu.Unlock()
+
+	e.fire(rule, userID, fmt.Sprintf("Admin account %s logged in from a new country (%s) from %s",
+		perms.Username, country, event.IPAddress), []AlertEvent{event})
+}
+
+// checkAccountLocked fires when failed logins lock an account, once per
+// account within the cooldown
+func (e *AlertEngine) checkAccountLocked(rule AlertRule, event AlertEvent) {
+	username := event.TargetResource
+	e.mu.Lock()
+	if e.coolingDown(rule, username, event.Timestamp) {
+		e.mu.Unlock()
+		return
+	}
+	e.lastFired[rule.Name+"\x00"+username] = event.Timestamp
+	e.mu.Unlock()
+
+	e.fire(rule, username, fmt.Sprintf("Account %s locked for %v after repeated failed logins from %s",
+		username, event.Details["duration"], event.IPAddress), []AlertEvent{event})
+}
+
+// fire records a firing and delivers it to admins in the background
+func (e *AlertEngine) fire(rule AlertRule, key, summary string, events []AlertEvent) {
+	if len(events) > alertMaxEvents {
+		events = events[len(events)-alertMaxEvents:]
+	}
+	firing := &AlertFiring{Rule: rule.Name, Key: key, Summary: summary, Events: events}
+	eventsJSON, _ := json.Marshal(events)
+	if err := e.db.QueryRow(`
+		INSERT INTO alert_firings (rule, group_key, summary, events)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, fired_at
+	`, rule.Name, key, summary, eventsJSON).Scan(&firing.ID, &firing.FiredAt); err != nil {
+		log.Printf("[Alerts] Failed to record %s firing: %v", rule.Name, err)
+		firing.FiredAt = time.Now()
+	}
+	log.Printf("[Alerts] %s: %s", rule.Name, summary)
+	go e.deliver(firing)
+}
+
+// alertRecipient is an admin that receives alerts
+type alertRecipient struct {
+	id       string
+	username string
+	email    string
+}
+
+// loadAlertRecipients returns the active users holding an admin permission
+func loadAlertRecipients(db *sql.DB, permission string) ([]alertRecipient, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT u.id, u.username, COALESCE(u.email, '')
+		FROM users u
+		LEFT JOIN user_roles ur ON ur.user_id = u.id
+		LEFT JOIN roles r ON r.name = ur.role_name
+		WHERE u.is_active = TRUE AND (u.is_admin = TRUE OR r.permissions @> jsonb_build_array($1::text))
+	`, permission)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recipients []alertRecipient
+	for rows.Next() {
+		var r alertRecipient
+		if err := rows.Scan(&r.id, &r.username, &r.email); err == nil {
+			recipients = append(recipients, r)
+		}
+	}
+	return recipients, rows.Err()
+}
+
+// deliverFiring notifies every active user holding audit.read, emails them
+// when enabled and SMTP is configured, and posts the firing to the webhook
+func (e *AlertEngine) deliverFiring(firing *AlertFiring) {
+	recipients, err := loadAlertRecipients(e.db, PermAuditRead)
+	if err != nil {
+		log.Printf("[Alerts] Failed to load recipients: %v", err)
+		return
+	}
+
+	title := "Security alert: " + firing.Rule
+	metadata := map[string]interface{}{"alertId": firing.ID, "rule": firing.Rule, "key": firing.Key}
+	emailEnabled := true
+	var webhookURL string
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		emailEnabled = settings.GetSettingBool("security_alerts_email", true)
+		webhookURL, _ = settings.GetSetting("security_alerts_webhook_url")
+	}
+
+	for _, r := range recipients {
+		if e.notifications != nil {
+			if _, err := e.notifications.Create(r.id, NotifSecurityAlert, title, firing.Summary, "/admin/alerts", nil, metadata); err != nil {
+				log.Printf("[Alerts] Failed to notify %s: %v", r.username, err)
+			}
+		}
+		if emailEnabled && r.email != "" && GetMailer().Configured() {
+			if err := GetMailer().Send(Mail{To: r.email, Subject: title, Text: renderAlertText(firing), HTML: renderAlertHTML(firing)}); err != nil {
+				log.Printf("[Alerts] Failed to email %s: %v", r.username, err)
+			}
+		}
+	}
+	if webhookURL != "" {
+		if err := postAlertWebhook(webhookURL, firing); err != nil {
+			log.Printf("[Alerts] Webhook delivery failed: %v", err)
+		}
+	}
+}
+
+// postAlertWebhook posts a firing as JSON
+func postAlertWebhook(webhookURL string, firing *AlertFiring) error {
+	if u, err := url.Parse(webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid webhook URL %q", webhookURL)
+	}
+	body, err := json.Marshal(firing)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: alertWebhookTimeout}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func renderAlertText(firing *AlertFiring) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\nRule: %s\nFired: %s\n\nTriggering events:\n", firing.Summary, firing.Rule, firing.FiredAt.Format(time.RFC1123))
+	for _, ev := range firing.Events {
+		fmt.Fprintf(&b, "  %s  %s  %s  %s\n", ev.Timestamp.Format(time.RFC3339), ev.EventType, ev.IPAddress, ev.TargetResource)
+	}
+	return b.String()
+}
+
+func renderAlertHTML(firing *AlertFiring) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<p>%s</p><p>Rule: <code>%s</code><br>Fired: %s</p><table>",
+		html.EscapeString(firing.Summary), html.EscapeString(firing.Rule), firing.FiredAt.Format(time.RFC1123))
+	for _, ev := range firing.Events {
+		fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>",
+			ev.Timestamp.Format(time.RFC3339), html.EscapeString(ev.EventType),
+			html.EscapeString(ev.IPAddress), html.EscapeString(ev.TargetResource))
+	}
+	b.WriteString("</table>")
+	return b.String()
+}
+
+// alertUsername returns the username of a user ID, or the ID if unknown
+func alertUsername(db *sql.DB, userID string) string {
+	var username string
+	if err := db.QueryRow(`SELECT username FROM users WHERE id = $1`, userID).Scan(&username); err != nil {
+		return userID
+	}
+	return username
+}
+
+// loadRules returns the rule configuration, cached for alertRulesTTL; on a
+// database error the previous configuration stays in use
+func (e *AlertEngine) loadRules() map[string]AlertRule {
+	e.mu.Lock()
+	if e.rules != nil && time.Since(e.rulesLoaded) < alertRulesTTL {
+		defer e.mu.Unlock()
+		return e.rules
+	}
+	e.mu.Unlock()
+
+	rules, err := listAlertRules(e.db)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		log.Printf("[Alerts] Failed to load rules: %v", err)
+		e.rulesLoaded = time.Now()
+		return e.rules
+	}
+	e.rules = make(map[string]AlertRule, len(rules))
+	for _, rule := range rules {
+		e.rules[rule.Name] = rule
+	}
+	e.rulesLoaded = time.Now()
+	return e.rules
+}
+
+// invalidateRules makes the next event reload the rule configuration
+func (e *AlertEngine) invalidateRules() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.rules = nil
+	e.mu.Unlock()
+}
+
+// loadCooldowns restores when each rule last fired so a restart does not
+// repeat alerts that are still cooling down
+func (e *AlertEngine) loadCooldowns() {
+	rows, err := e.db.Query(`
+		SELECT rule, group_key, MAX(fired_at)
+		FROM alert_firings
+		WHERE fired_at > NOW() - INTERVAL '7 days'
+		GROUP BY rule, group_key
+	`)
+	if err != nil {
+		log.Printf("[Alerts] Failed to load cooldowns: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rule, key string
+		var firedAt time.Time
+		if err := rows.Scan(&rule, &key, &firedAt); err == nil {
+			e.lastFired[rule+"\x00"+key] = firedAt
+		}
+	}
+}
+
+func listAlertRules(db *sql.DB) ([]AlertRule, error) {
+	rows, err := db.Query(`
+		SELECT name, COALESCE(description, ''), enabled, threshold, window_seconds, cooldown_seconds, updated_at
+		FROM alert_rules
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AlertRule{}
+	for rows.Next() {
+		var rule AlertRule
+		if err := rows.Scan(&rule.Name, &rule.Description, &rule.Enabled, &rule.Threshold,
+			&rule.WindowSeconds, &rule.CooldownSeconds, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// AlertHandler serves the alert firings and rule configuration
+type AlertHandler struct {
+	db *sql.DB
+}
+
+// NewAlertHandler creates a new AlertHandler
+func NewAlertHandler(db *sql.DB) *AlertHandler {
+	return &AlertHandler{db: db}
+}
+
+// ListAlerts returns recent alert firings with their triggering events
+// @Summary		List security alerts
+// @Description	Returns recent firings of the alert rules, newest first, with the audit events that triggered them.
+// @Tags		Admin
+// @Produce		json
+// @Param		rule	query		string	false	"Only firings of this rule"
+// @Param		limit	query		int		false	"Maximum results (default 50, max 200)"
+// @Success		200		{object}	docs.SuccessResponse	"Firings in data.alerts"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/alerts [get]
+func (h *AlertHandler) ListAlerts(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermAuditRead)
+	if claims == nil {
+		return err // Error response already written
+	}
+	limit := 50
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+
+	query := `SELECT id, rule, group_key, summary, events, fired_at FROM alert_firings`
+	args := []interface{}{}
+	if rule := c.QueryParam("rule"); rule != "" {
+		query += ` WHERE rule = $1`
+		args = append(args, rule)
+	}
+	query += fmt.Sprintf(` ORDER BY fired_at DESC LIMIT %d`, limit)
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list alerts", err))
+	}
+	defer rows.Close()
+
+	alerts := []AlertFiring{}
+	for rows.Next() {
+		var a AlertFiring
+		var eventsJSON []byte
+		if err := rows.Scan(&a.ID, &a.Rule, &a.Key, &a.Summary, &eventsJSON, &a.FiredAt); err != nil {
+			return RespondError(c, ErrOperationFailed("list alerts", err))
+		}
+		_ = json.Unmarshal(eventsJSON, &a.Events)
+		alerts = append(alerts, a)
+	}
+	return RespondSuccess(c, map[string]interface{}{"alerts": alerts})
+}
+
+// ListAlertRules returns the alert rules and their configuration
+// @Summary		List alert rules
+// @Description	Returns the built-in alert rules with their thresholds, windows and cooldowns, and whether a GeoIP database is loaded.
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Rules in data.rules"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/alerts/rules [get]
+func (h *AlertHandler) ListAlertRules(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermAuditRead)
+	if claims == nil {
+		return err // Error response already written
+	}
+	rules, err := listAlertRules(h.db)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list alert rules", err))
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"rules":   rules,
+		"enabled": alertsEnabled(),
+		"geoip":   loadGeoIP() != nil,
+	})
+}
+
+// UpdateAlertRule changes the configuration of an alert rule
+// @Summary		Update alert rule
+// @Description	Enables or disables a rule or changes its threshold, window or cooldown.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		name	path		string					true	"Rule name"
+// @Param		request	body		UpdateAlertRuleRequest	true	"Changes"
+// @Success		200		{object}	docs.SuccessResponse	"Updated rule"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid request"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Rule not found"
+// @Security	BearerAuth
+// @Router		/admin/alerts/rules/{name} [put]
+func (h *AlertHandler) UpdateAlertRule(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermSettingsWrite)
+	if claims == nil {
+		return err // Error response already written
+	}
+	var req UpdateAlertRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	for field, value := range map[string]*int{
+		"threshold":       req.Threshold,
+		"windowSeconds":   req.WindowSeconds,
+		"cooldownSeconds": req.CooldownSeconds,
+	} {
+		if value != nil && *value < 0 {
+			return RespondError(c, ErrBadRequest(field+" must not be negative"))
+		}
+	}
+	if req.Threshold != nil && *req.Threshold == 0 {
+		return RespondError(c, ErrBadRequest("threshold must be at least 1"))
+	}
+
+	name := c.Param("name")
+	var rule AlertRule
+	err = h.db.QueryRow(`
+		UPDATE alert_rules SET
+			enabled = COALESCE($2, enabled),
+			threshold = COALESCE($3, threshold),
+			window_seconds = COALESCE($4, window_seconds),
+			cooldown_seconds = COALESCE($5, cooldown_seconds),
+			updated_by = $6, updated_at = NOW()
+		WHERE name = $1
+		RETURNING name, COALESCE(description, ''), enabled, threshold, window_seconds, cooldown_seconds, updated_at
+	`, name, req.Enabled, req.Threshold, req.WindowSeconds, req.CooldownSeconds, claims.UserID).Scan(
+		&rule.Name, &rule.Description, &rule.Enabled, &rule.Threshold,
+		&rule.WindowSeconds, &rule.CooldownSeconds, &rule.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Alert rule"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("update alert rule", err))
+	}
+	GetAlertEngine().invalidateRules()
+	return RespondSuccess(c, rule)
+}
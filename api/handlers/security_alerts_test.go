@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newTestAlertEngine returns an engine with fixed rules whose firings are
+// sent to the returned channel
+func newTestAlertEngine(tc *TestContext, rules ...AlertRule) (*AlertEngine, chan *AlertFiring) {
+	e := newAlertEngine(tc.DB, nil)
+	e.rules = make(map[string]AlertRule)
+	for _, rule := range rules {
+		e.rules[rule.Name] = rule
+	}
+	e.rulesLoaded = time.Now()
+	fired := make(chan *AlertFiring, 10)
+	e.deliver = func(f *AlertFiring) { fired <- f }
+	return e, fired
+}
+
+func expectFiring(mock sqlmock.Sqlmock, rule, key string) {
+	mock.ExpectQuery("INSERT INTO alert_firings").WithArgs(rule, key, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "fired_at"}).AddRow(1, time.Now()))
+}
+
+func TestAlertEngine_FailedLoginsFromIP(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	e, fired := newTestAlertEngine(tc, AlertRule{
+		Name: AlertRuleFailedLoginsIP, Enabled: true, Threshold: 3, WindowSeconds: 60, CooldownSeconds: 3600,
+	})
+
+	start := time.Now()
+	failed := func(ip, username string, at time.Duration) {
+		e.process(AlertEvent{Timestamp: start.Add(at), IPAddress: ip, EventType: EventLoginFailed, TargetResource: username})
+	}
+
+	// Spread beyond the window nothing fires
+	failed("203.0.113.9", "alice", 0)
+	failed("203.0.113.9", "bob", 2*time.Minute)
+
+	// Three accounts within a minute fire once
+	failed("203.0.113.9", "carol", 2*time.Minute+10*time.Second)
+	expectFiring(tc.Mock, AlertRuleFailedLoginsIP, "203.0.113.9")
+	failed("203.0.113.9", "dave", 2*time.Minute+20*time.Second)
+	select {
+	case f := <-fired:
+		if len(f.Events) != 3 || f.Events[0].TargetResource != "bob" {
+			t.Errorf("firing events = %+v", f.Events)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rule did not fire")
+	}
+
+	// Then the IP cools down, while another IP counts on its own
+	expectFiring(tc.Mock, AlertRuleFailedLoginsIP, "198.51.100.7")
+	for i := 0; i < 5; i++ {
+		failed("203.0.113.9", "erin", 3*time.Minute)
+		failed("198.51.100.7", "frank", 3*time.Minute)
+	}
+	if f := <-fired; f.Key != "198.51.100.7" {
+		t.Errorf("fired for %s", f.Key)
+	}
+	select {
+	case f := <-fired:
+		t.Errorf("fired again for %s during cooldown", f.Key)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Other events are ignored
+	e.process(AlertEvent{Timestamp: start, IPAddress: "203.0.113.9", EventType: EventUserLogin})
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAlertEngine_PermanentDeleteBurst(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	e, fired := newTestAlertEngine(tc, AlertRule{
+		Name: AlertRulePermanentDeleteBurst, Enabled: true, Threshold: 100, WindowSeconds: 300, CooldownSeconds: 3600,
+	})
+	userID := "u1"
+	purge := func(items int) {
+		e.process(AlertEvent{Timestamp: time.Now(), ActorID: &userID, EventType: EventTrashPurge, TargetResource: "/trash",
+			Details: map[string]interface{}{"items": items}})
+	}
+
+	// Emptying a trash of many items counts each of them
+	purge(40)
+	tc.Mock.ExpectQuery("SELECT username FROM users").WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"username"}).AddRow("alice"))
+	expectFiring(tc.Mock, AlertRulePermanentDeleteBurst, "u1")
+	purge(60)
+
+	select {
+	case f := <-fired:
+		if f.Summary != "100 items permanently deleted by alice within 5m0s" {
+			t.Errorf("summary = %q", f.Summary)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rule did not fire")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAlertEngine_AccountLocked(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	e, fired := newTestAlertEngine(tc, AlertRule{
+		Name: AlertRuleAccountLocked, Enabled: true, Threshold: 1, CooldownSeconds: 3600,
+	})
+
+	start := time.Now()
+	locked := func(username string, at time.Duration) {
+		e.process(AlertEvent{Timestamp: start.Add(at), IPAddress: "203.0.113.9", EventType: EventAccountLocked,
+			TargetResource: username, Details: map[string]interface{}{"duration": "30m0s"}})
+	}
+
+	expectFiring(tc.Mock, AlertRuleAccountLocked, "admin")
+	locked("admin", 0)
+	select {
+	case f := <-fired:
+		if f.Summary != "Account admin locked for 30m0s after repeated failed logins from 203.0.113.9" {
+			t.Errorf("summary = %q", f.Summary)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rule did not fire")
+	}
+
+	// A longer lock of the same account within the cooldown stays quiet
+	locked("admin", 20*time.Minute)
+	select {
+	case f := <-fired:
+		t.Errorf("fired again for %s during cooldown", f.Key)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
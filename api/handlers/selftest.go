@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/svrforum/FileHatch/api/database"
+)
+
+// Self-test outcomes, worst last
+const (
+	SelfTestPass = "pass"
+	SelfTestWarn = "warn"
+	SelfTestFail = "fail"
+)
+
+// devJWTSecret is the fallback secret used when JWT_SECRET is not set
+const devJWTSecret = "fh-dev-secret-not-for-production-use"
+
+// selfTestOwnershipSample bounds the entries checked per storage root for
+// ownership problems
+const selfTestOwnershipSample = 200
+
+// SelfTestCheck is the outcome of one self-test check
+type SelfTestCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // pass, warn or fail
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"` // What to change, for warn and fail
+}
+
+// SelfTestReport is the result of a self-test run
+type SelfTestReport struct {
+	CheckedAt  time.Time       `json:"checkedAt"`
+	DurationMs int64           `json:"durationMs"`
+	Trigger    string          `json:"trigger"` // startup or admin
+	Status     string          `json:"status"`  // Worst check status
+	Pass       int             `json:"pass"`
+	Warn       int             `json:"warn"`
+	Fail       int             `json:"fail"`
+	Checks     []SelfTestCheck `json:"checks"`
+}
+
+func (r *SelfTestReport) add(name, status, message, hint string) {
+	r.Checks = append(r.Checks, SelfTestCheck{Name: name, Status: status, Message: message, Hint: hint})
+	switch status {
+	case SelfTestPass:
+		r.Pass++
+	case SelfTestWarn:
+		r.Warn++
+	default:
+		r.Fail++
+	}
+}
+
+var (
+	selfTestMu   sync.Mutex
+	selfTestLast *SelfTestReport
+)
+
+// LastSelfTest returns the last self-test report, or nil before the first run
+func LastSelfTest() *SelfTestReport {
+	selfTestMu.Lock()
+	defer selfTestMu.Unlock()
+	return selfTestLast
+}
+
+// RunSelfTest checks the storage layout, permissions, upload staging, the
+// storage volumes, the database schema version and the JWT secret, and logs
+// a summary
+func (h *Handler) RunSelfTest(trigger string) *SelfTestReport {
+	start := time.Now()
+	report := &SelfTestReport{CheckedAt: start, Trigger: trigger}
+
+	h.selfTestSkeleton(report)
+	for _, root := range h.selfTestRoots() {
+		h.selfTestProbe(report, root)
+	}
+	h.selfTestOwnership(report)
+	h.selfTestStaging(report)
+	h.selfTestVolumes(report)
+	h.selfTestSchema(report)
+	selfTestJWTSecret(report)
+
+	report.Status = SelfTestPass
+	if report.Fail > 0 {
+		report.Status = SelfTestFail
+	} else if report.Warn > 0 {
+		report.Status = SelfTestWarn
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+
+	log.Printf("[SelfTest] status=%s pass=%d warn=%d fail=%d trigger=%s duration=%dms",
+		report.Status, report.Pass, report.Warn, report.Fail, trigger, report.DurationMs)
+	for _, check := range report.Checks {
+		if check.Status != SelfTestPass {
+			log.Printf("[SelfTest] %s check=%s msg=%q hint=%q", strings.ToUpper(check.Status), check.Name, check.Message, check.Hint)
+		}
+	}
+
+	selfTestMu.Lock()
+	selfTestLast = report
+	selfTestMu.Unlock()
+	return report
+}
+
+// selfTestRoot is a directory the server writes to
+type selfTestRoot struct {
+	Name string // Virtual root, e.g. /home or /shared/Design
+	Path string
+}
+
+// selfTestRoots returns the storage roots: home folders, the shared root and
+// every active shared drive
+func (h *Handler) selfTestRoots() []selfTestRoot {
+	roots := []selfTestRoot{
+		{Name: "/home", Path: filepath.Join(h.dataRoot, "users")},
+		{Name: "/shared", Path: filepath.Join(h.dataRoot, "shared")},
+	}
+	for _, name := range h.selfTestDrives() {
+		roots = append(roots, selfTestRoot{Name: "/shared/" + name, Path: filepath.Join(h.dataRoot, "shared", sanitizeFolderName(name))})
+	}
+	return roots
+}
+
+// selfTestDrives returns the active shared drive names; none without a database
+func (h *Handler) selfTestDrives() []string {
+	if h.db == nil {
+		return nil
+	}
+	rows, err := h.db.Query(`SELECT name FROM shared_folders WHERE is_active = TRUE ORDER BY name`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// selfTestSkeleton verifies the directory layout, creating missing
+// directories when the data root itself is there
+func (h *Handler) selfTestSkeleton(report *SelfTestReport) {
+	info, err := os.Stat(h.dataRoot)
+	if err != nil || !info.IsDir() {
+		report.add("storage.skeleton", SelfTestFail, fmt.Sprintf("Data root %s is missing", h.dataRoot),
+			"Mount the data volume at "+h.dataRoot+" (DATA_PATH in docker-compose)")
+		return
+	}
+
+	var created, failed []string
+	for _, dir := range []string{"users", "shared", ".uploads"} {
+		path := filepath.Join(h.dataRoot, dir)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		mkdir := os.MkdirAll
+		if dir == "shared" {
+			mkdir = func(p string, _ os.FileMode) error { return MkdirAllShared(p) }
+		}
+		if err := mkdir(path, 0755); err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", dir, err))
+		} else {
+			created = append(created, dir)
+		}
+	}
+	sf := &SharedFolderHandler{dataRoot: h.dataRoot}
+	for _, name := range h.selfTestDrives() {
+		if _, err := os.Stat(sf.GetFolderPath(name)); err == nil {
+			continue
+		}
+		if err := sf.EnsureSharedFolderDir(name); err != nil {
+			failed = append(failed, fmt.Sprintf("shared/%s (%v)", name, err))
+		} else {
+			created = append(created, "shared/"+name)
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		report.add("storage.skeleton", SelfTestFail, "Could not create "+strings.Join(failed, ", "),
+			"Check that "+h.dataRoot+" is writable by the API container")
+	case len(created) > 0:
+		report.add("storage.skeleton", SelfTestWarn, "Created missing directories: "+strings.Join(created, ", "),
+			"Expected on a fresh install; otherwise check that the right volume is mounted")
+	default:
+		report.add("storage.skeleton", SelfTestPass, "users, shared, .uploads and all drive directories exist", "")
+	}
+}
+
+// selfTestProbe writes, reads back and deletes a probe file in a storage root
+func (h *Handler) selfTestProbe(report *SelfTestReport, root selfTestRoot) {
+	name := "storage.write " + root.Name
+	if _, err := os.Stat(root.Path); err != nil {
+		report.add(name, SelfTestFail, fmt.Sprintf("%s is missing", root.Path), "")
+		return
+	}
+
+	probe := filepath.Join(root.Path, ".selftest-"+selfTestToken())
+	want := []byte("filehatch self-test " + time.Now().Format(time.RFC3339Nano))
+	err := os.WriteFile(probe, want, 0600)
+	if err == nil {
+		var got []byte
+		if got, err = os.ReadFile(probe); err == nil && string(got) != string(want) {
+			err = errors.New("read back different content")
+		}
+	}
+	removeErr := os.Remove(probe)
+	if err == nil {
+		err = removeErr
+	}
+
+	switch {
+	case err == nil:
+		report.add(name, SelfTestPass, "Write, read and delete succeeded", "")
+	case errors.Is(err, syscall.EROFS):
+		report.add(name, SelfTestFail, "Filesystem is read-only: "+err.Error(),
+			"Remove :ro from the "+h.dataRoot+" volume mount")
+	case errors.Is(err, os.ErrPermission):
+		report.add(name, SelfTestFail, "Permission denied: "+err.Error(),
+			fmt.Sprintf("The API runs as uid %d gid %d; give it write access to %s", os.Getuid(), os.Getgid(), root.Path))
+	default:
+		report.add(name, SelfTestFail, err.Error(), "")
+	}
+}
+
+// selfTestOwnership checks that the process can set the group shared
+// folders get (as EnsureSharedFolderDir does) and samples existing entries
+// for ones the API or the SMB users cannot write
+func (h *Handler) selfTestOwnership(report *SelfTestReport) {
+	sharedDir := filepath.Join(h.dataRoot, "shared")
+	probe := filepath.Join(sharedDir, ".selftest-"+selfTestToken())
+	if err := os.Mkdir(probe, SharedDirPerm); err != nil {
+		report.add("storage.chown", SelfTestFail, "Could not create a probe directory: "+err.Error(), "")
+	} else {
+		err := os.Chown(probe, -1, UsersGroupID)
+		os.Remove(probe)
+		if err != nil {
+			report.add("storage.chown", SelfTestWarn,
+				fmt.Sprintf("Cannot set group %d on new folders: %v", UsersGroupID, err),
+				fmt.Sprintf("SMB users (group users, gid %d) will not be able to write new folders; run the API as root or as a member of gid %d", UsersGroupID, UsersGroupID))
+		} else {
+			report.add("storage.chown", SelfTestPass, fmt.Sprintf("Can set group %d on new folders", UsersGroupID), "")
+		}
+	}
+
+	uid := os.Getuid()
+	var checked int
+	var notWritable, wrongGroup []string
+	for _, root := range []string{sharedDir, filepath.Join(h.dataRoot, "users")} {
+		entries, _ := os.ReadDir(root)
+		for _, drive := range entries {
+			if !drive.IsDir() || strings.HasPrefix(drive.Name(), ".") {
+				continue
+			}
+			for _, path := range selfTestSample(filepath.Join(root, drive.Name())) {
+				info, err := os.Lstat(path)
+				if err != nil {
+					continue
+				}
+				st, ok := info.Sys().(*syscall.Stat_t)
+				if !ok {
+					continue
+				}
+				checked++
+				rel, _ := filepath.Rel(h.dataRoot, path)
+				if uid != 0 && syscall.Access(path, 2) != nil { // W_OK
+					notWritable = append(notWritable, fmt.Sprintf("%s (uid %d)", rel, st.Uid))
+				}
+				if root == sharedDir && (st.Gid != UsersGroupID || info.Mode().Perm()&0020 == 0) {
+					wrongGroup = append(wrongGroup, fmt.Sprintf("%s (gid %d, %o)", rel, st.Gid, info.Mode().Perm()))
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(notWritable) > 0:
+		report.add("storage.ownership", SelfTestFail,
+			fmt.Sprintf("%d of %d sampled entries are not writable by uid %d: %s", len(notWritable), checked, uid, selfTestList(notWritable)),
+			"Existing files belong to another uid (wrong PUID or a different container user); chown them to the API user")
+	case len(wrongGroup) > 0:
+		report.add("storage.ownership", SelfTestWarn,
+			fmt.Sprintf("%d of %d sampled shared entries are not group-writable by gid %d: %s", len(wrongGroup), checked, UsersGroupID, selfTestList(wrongGroup)),
+			fmt.Sprintf("SMB users cannot write these; run chgrp -R %d and chmod -R g+w on the shared drives", UsersGroupID))
+	default:
+		report.add("storage.ownership", SelfTestPass, fmt.Sprintf("%d sampled entries have the expected ownership", checked), "")
+	}
+}
+
+// selfTestSample returns a directory and its first entries, enough to spot
+// files created by another container user without walking the whole drive
+func selfTestSample(dir string) []string {
+	paths := []string{dir}
+	f, err := os.Open(dir)
+	if err != nil {
+		return paths
+	}
+	defer f.Close()
+	names, _ := f.Readdirnames(selfTestOwnershipSample)
+	for _, name := range names {
+		if !strings.HasPrefix(name, ".") {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	return paths
+}
+
+// selfTestList shortens a list of findings for a check message
+func selfTestList(items []string) string {
+	if len(items) > 5 {
+		return strings.Join(items[:5], ", ") + fmt.Sprintf(" and %d more", len(items)-5)
+	}
+	return strings.Join(items, ", ")
+}
+
+// selfTestStaging checks that upload staging directories are on the
+// filesystem of their storage root, so completed uploads are renamed rather
+// than copied
+func (h *Handler) selfTestStaging(report *SelfTestReport) {
+	roots, err := parseUploadStaging(os.Getenv(uploadStagingEnv), h.dataRoot)
+	if err != nil {
+		report.add("upload.staging", SelfTestFail, err.Error(), "Fix "+uploadStagingEnv)
+		return
+	}
+
+	dirs := map[string]string{filepath.Join(h.dataRoot, ".uploads"): h.dataRoot}
+	for _, root := range roots {
+		dirs[root.Store.Path] = filepath.Join(h.dataRoot, root.Prefix)
+	}
+	var slow []string
+	for dir, target := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue // Created by the upload handler; the skeleton check covers .uploads
+		}
+		if !sameFilesystem(dir, target) {
+			slow = append(slow, fmt.Sprintf("%s -> %s", dir, target))
+		}
+	}
+	if len(slow) > 0 {
+		report.add("upload.staging", SelfTestWarn,
+			"Staging directories on another filesystem than their target: "+strings.Join(slow, ", "),
+			"Completed uploads are copied instead of renamed; stage them on the same mount with "+uploadStagingEnv)
+		return
+	}
+	report.add("upload.staging", SelfTestPass, "Upload staging is on the same filesystem as its targets", "")
+}
+
+// selfTestVolumes validates the storage_volumes setting and checks that the
+// drives and home folders moved to a volume are reachable
+func (h *Handler) selfTestVolumes(report *SelfTestReport) {
+	volumes := StorageVolumes()
+	var problems []string
+	for _, volume := range volumes {
+		if err := validateStorageVolume(h.dataRoot, volume); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for key, volume := range GetStorageLocations().Locations() {
+		if _, err := os.Stat(filepath.Join(volume, filepath.FromSlash(key))); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is missing on %s", key, volume))
+		}
+	}
+
+	switch {
+	case len(problems) > 0:
+		report.add("storage.volumes", SelfTestFail, selfTestList(problems),
+			"Mount the volumes from the storage_volumes setting and give the API container write access")
+	case len(volumes) == 0:
+		report.add("storage.volumes", SelfTestPass, "No additional storage volumes configured", "")
+	default:
+		report.add("storage.volumes", SelfTestPass, fmt.Sprintf("%d storage volumes are mounted and writable", len(volumes)), "")
+	}
+}
+
+// selfTestSchema compares the applied schema version with the newest
+// migration of this build
+func (h *Handler) selfTestSchema(report *SelfTestReport) {
+	want, err := database.LatestVersion()
+	if err != nil {
+		report.add("database.schema", SelfTestFail, "Cannot read embedded migrations: "+err.Error(), "")
+		return
+	}
+	if h.db == nil {
+		report.add("database.schema", SelfTestFail, "No database connection", "")
+		return
+	}
+	var have string
+	if err := h.db.QueryRow(`SELECT COALESCE(MAX(version), '') FROM schema_migrations`).Scan(&have); err != nil {
+		report.add("database.schema", SelfTestFail, "Cannot read schema_migrations: "+err.Error(),
+			"Check the database connection and that migrations ran")
+		return
+	}
+	switch {
+	case have == want:
+		report.add("database.schema", SelfTestPass, "Schema is at "+have, "")
+	case have < want:
+		report.add("database.schema", SelfTestFail, fmt.Sprintf("Schema is at %s, this build expects %s", have, want),
+			"Migrations did not complete; check the startup log for [Migration] errors")
+	default:
+		report.add("database.schema", SelfTestWarn, fmt.Sprintf("Schema is at %s, newer than this build (%s)", have, want),
+			"The server was downgraded; run the matching or a newer image")
+	}
+}
+
+// selfTestJWTSecret checks the JWT secret, strictly in production
+func selfTestJWTSecret(report *SelfTestReport) {
+	secret := os.Getenv("JWT_SECRET")
+	production := os.Getenv("FH_ENV") == "production"
+	problem := ""
+	switch {
+	case secret == "" || secret == devJWTSecret:
+		problem = "JWT_SECRET is not set; tokens are signed with the public development secret"
+	case len(secret) < 32:
+		problem = fmt.Sprintf("JWT_SECRET is only %d characters", len(secret))
+	case len(strings.Trim(secret, secret[:1])) == 0:
+		problem = "JWT_SECRET repeats a single character"
+	}
+	switch {
+	case problem == "":
+		report.add("security.jwt_secret", SelfTestPass, "JWT_SECRET is set and at least 32 characters", "")
+	case production:
+		report.add("security.jwt_secret", SelfTestFail, problem, "Set JWT_SECRET to at least 32 random characters, e.g. openssl rand -hex 32")
+	default:
+		report.add("security.jwt_secret", SelfTestWarn, problem+" (FH_ENV is not production)",
+			"Set JWT_SECRET to at least 32 random characters before going to production")
+	}
+}
+
+// selfTestToken returns a random suffix for probe names
+func selfTestToken() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// GetSelfTest re-runs the self-test
+// @Summary		Run self-test
+// @Description	Re-runs the startup self-test: storage layout and write probes per storage root, ownership of new and existing files, upload staging filesystems, storage volumes, database schema version and JWT secret strength. Each check is pass, warn or fail with a hint.
+// @Tags		Admin
+// @Produce		json
+// @Success		200	{object}	SelfTestReport		"Report"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/selftest [get]
+func (h *Handler) GetSelfTest(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err // Error response already written
+	}
+	return RespondSuccess(c, h.RunSelfTest("admin"))
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/svrforum/FileHatch/api/database"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+	t.Setenv("FH_ENV", "production")
+	t.Setenv("JWT_SECRET", "short")
+	t.Setenv(uploadStagingEnv, "")
+
+	latest, err := database.LatestVersion()
+	if err != nil || latest == "" {
+		t.Fatalf("LatestVersion = %q, %v", latest, err)
+	}
+	drives := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"name"}).AddRow("Team") }
+	tc.Mock.ExpectQuery("SELECT name FROM shared_folders").WillReturnRows(drives())
+	tc.Mock.ExpectQuery("SELECT name FROM shared_folders").WillReturnRows(drives())
+	tc.Mock.ExpectQuery("FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow("20240101000001"))
+
+	report := h.RunSelfTest("admin")
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]SelfTestCheck)
+	for _, check := range report.Checks {
+		got[check.Name] = check
+	}
+	for name, want := range map[string]string{
+		"storage.skeleton":           SelfTestWarn, // shared, .uploads and the drive were created
+		"storage.write /home":        SelfTestPass,
+		"storage.write /shared":      SelfTestPass,
+		"storage.write /shared/Team": SelfTestPass,
+		"upload.staging":             SelfTestPass,
+		"database.schema":            SelfTestFail,
+		"security.jwt_secret":        SelfTestFail, // Short secret in production
+	} {
+		if got[name].Status != want {
+			t.Errorf("%s = %+v, want %s", name, got[name], want)
+		}
+	}
+	if report.Status != SelfTestFail || LastSelfTest() != report {
+		t.Errorf("status = %s, last = %p", report.Status, LastSelfTest())
+	}
+	if _, err := os.Stat(filepath.Join(h.dataRoot, "shared", "Team")); err != nil {
+		t.Error("missing drive directory was not created")
+	}
+	entries, _ := os.ReadDir(filepath.Join(h.dataRoot, "shared"))
+	for _, e := range entries {
+		if e.Name() != "Team" {
+			t.Errorf("probe left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestSelfTestJWTSecret(t *testing.T) {
+	for _, tt := range []struct {
+		env, secret, want string
+	}{
+		{"production", "", SelfTestFail},
+		{"production", devJWTSecret, SelfTestFail},
+		{"production", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", SelfTestFail},
+		{"production", "3f1c9e0b7a2d4e8f9c6b5a4d3e2f1a0b9c8d7e6f", SelfTestPass},
+		{"", "", SelfTestWarn},
+	} {
+		t.Setenv("FH_ENV", tt.env)
+		t.Setenv("JWT_SECRET", tt.secret)
+		report := &SelfTestReport{}
+		selfTestJWTSecret(report)
+		if report.Checks[0].Status != tt.want {
+			t.Errorf("env=%q secret=%q: %+v, want %s", tt.env, tt.secret, report.Checks[0], tt.want)
+		}
+	}
+}
+
+func TestGetSelfTest_RequiresAdmin(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	req, _ := NewJSONRequest(http.MethodGet, "/api/admin/selftest", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	_ = h.GetSelfTest(c)
+	AssertStatus(t, tc.Recorder, http.StatusForbidden)
+}
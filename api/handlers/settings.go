@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -118,6 +119,14 @@ func (h *SettingsHandler) GetRateLimitRPS() int {
 	return h.GetSettingInt("rate_limit_rps", 100)
 }
 
+func (h *SettingsHandler) GetRateLimitBrowseRPS() int {
+	return h.GetSettingInt("rate_limit_browse_rps", 300)
+}
+
+func (h *SettingsHandler) GetRateLimitAuthPerMinute() int {
+	return h.GetSettingInt("rate_limit_auth_per_minute", 10)
+}
+
 func (h *SettingsHandler) IsSecurityHeadersEnabled() bool {
 	return h.GetSettingBool("security_headers_enabled", true)
 }
@@ -148,11 +157,43 @@ func (h *SettingsHandler) GetXFrameOptions() string {
 	}
 }
 
+// IsDownloadStatsEnabled reports whether per-file download counting is on
+func (h *SettingsHandler) IsDownloadStatsEnabled() bool {
+	return h.GetSettingBool("download_stats_enabled", true)
+}
+
+// IsOnlyOfficeHealthInReadiness reports whether /health includes the document server status
+func (h *SettingsHandler) IsOnlyOfficeHealthInReadiness() bool {
+	return h.GetSettingBool("onlyoffice_health_in_readiness", false)
+}
+
+// IsMaintenanceMode reports whether GET /api/status announces maintenance
+func (h *SettingsHandler) IsMaintenanceMode() bool {
+	return h.GetSettingBool("maintenance_mode", false)
+}
+
+// GetDownloadStatsDisabledFolders returns shared drives excluded from download statistics
+func (h *SettingsHandler) GetDownloadStatsDisabledFolders() []string {
+	value, err := h.GetSetting("download_stats_disabled_folders")
+	if err != nil || value == "" {
+		return nil
+	}
+	var folders []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			folders = append(folders, name)
+		}
+	}
+	return folders
+}
+
 // InvalidateCache removes a key from cache
 func (h *SettingsHandler) InvalidateCache(key string) {
 	h.mu.Lock()
 	delete(h.cache, key)
 	h.mu.Unlock()
+	// Listings show settings-dependent warnings and actions
+	GetListingVersions().InvalidateAll()
 }
 
 // GetAllSettings returns all system settings (admin only)
@@ -175,6 +216,10 @@ func (h *SettingsHandler) GetAllSettings(c echo.Context) error {
 		if err := rows.Scan(&s.Key, &s.Value, &s.Description, &s.UpdatedAt); err != nil {
 			continue
 		}
+		// The SMTP password is only written, through PUT /admin/smtp
+		if s.Key == settingSMTPPassword {
+			continue
+		}
 		settings = append(settings, s)
 	}
 
@@ -209,6 +254,24 @@ func (h *SettingsHandler) UpdateSetting(c echo.Context) error {
 		})
 	}
 
+	if err := ValidateCORSSetting(req.Key, req.Value); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err := h.ValidateOnlyOfficeSettings(map[string]string{req.Key: req.Value}); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err := ValidateSMTPSettings(map[string]string{req.Key: req.Value}); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	previousPolicy := LoadTwoFactorPolicy()
+
 	// Update in database
 	result, err := h.db.Exec(`
 		UPDATE system_settings
@@ -238,6 +301,19 @@ func (h *SettingsHandler) UpdateSetting(c echo.Context) error {
 
 	// Invalidate cache
 	h.InvalidateCache(req.Key)
+	if isFileCapabilitySetting(req.Key) {
+		GetFileCapabilities().Invalidate()
+	}
+
+	if isTwoFactorPolicyKey(req.Key) {
+		onTwoFactorPolicyChanged(h.db, h, previousPolicy, claims.UserID)
+	}
+	if isBruteForceSetting(req.Key) {
+		GetBruteForceGuard().ReloadConfig()
+	}
+	if isSMTPSetting(req.Key) {
+		GetMailer().Reload(h)
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -271,6 +347,31 @@ func (h *SettingsHandler) UpdateSettings(c echo.Context) error {
 		})
 	}
 
+	// Validate before writing so a bad entry does not leave a partial update
+	for key, value := range req.Settings {
+		if err := ValidateCORSSetting(key, value); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
+	if err := h.ValidateOnlyOfficeSettings(req.Settings); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err := ValidateSMTPSettings(req.Settings); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	previousPolicy := LoadTwoFactorPolicy()
+	policyChanged := false
+	capabilitiesChanged := false
+	bruteForceChanged := false
+	smtpChanged := false
+
 	// Update each setting
 	for key, value := range req.Settings {
 		_, err := h.db.Exec(`
@@ -288,6 +389,10 @@ func (h *SettingsHandler) UpdateSettings(c echo.Context) error {
 
 		// Invalidate cache
 		h.InvalidateCache(key)
+		policyChanged = policyChanged || isTwoFactorPolicyKey(key)
+		capabilitiesChanged = capabilitiesChanged || isFileCapabilitySetting(key)
+		bruteForceChanged = bruteForceChanged || isBruteForceSetting(key)
+		smtpChanged = smtpChanged || isSMTPSetting(key)
 
 		// Handle SMB container control
 		if key == "smb_enabled" {
@@ -297,6 +402,19 @@ func (h *SettingsHandler) UpdateSettings(c echo.Context) error {
 		}
 	}
 
+	if policyChanged {
+		onTwoFactorPolicyChanged(h.db, h, previousPolicy, claims.UserID)
+	}
+	if capabilitiesChanged {
+		GetFileCapabilities().Invalidate()
+	}
+	if bruteForceChanged {
+		GetBruteForceGuard().ReloadConfig()
+	}
+	if smtpChanged {
+		GetMailer().Reload(h)
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"success": true,
 		"updated": len(req.Settings),
@@ -308,6 +426,75 @@ func (h *SettingsHandler) GetTrashRetentionDays() int {
 	return h.GetSettingInt("trash_retention_days", 30)
 }
 
+// GetTrashMaxSizeBytes returns the largest trash a user may keep (0 = unlimited)
+func (h *SettingsHandler) GetTrashMaxSizeBytes() int64 {
+	return h.GetSettingInt64("trash_max_size_bytes", 0)
+}
+
+// GetThumbnailCacheMaxBytes returns the size cap of the thumbnail cache (0 = unlimited)
+func (h *SettingsHandler) GetThumbnailCacheMaxBytes() int64 {
+	return h.GetSettingInt64("thumbnail_cache_max_bytes", defaultThumbnailCacheMaxBytes)
+}
+
+// IsVideoTranscodeEnabled reports whether video previews browsers cannot play
+// are converted with ffmpeg
+func (h *SettingsHandler) IsVideoTranscodeEnabled() bool {
+	return h.GetSettingBool("video_transcode_enabled", true)
+}
+
+// IsContentIndexEnabled reports whether document text is indexed for content search
+func (h *SettingsHandler) IsContentIndexEnabled() bool {
+	return h.GetSettingBool("content_index_enabled", false)
+}
+
+// GetContentIndexMaxFileBytes returns the largest file whose text is indexed
+func (h *SettingsHandler) GetContentIndexMaxFileBytes() int64 {
+	return h.GetSettingInt64("content_index_max_file_bytes", defaultContentIndexMaxFileBytes)
+}
+
+// GetShareTokenMinLength returns the minimum length of the random part of new share tokens
+func (h *SettingsHandler) GetShareTokenMinLength() int {
+	return h.GetSettingInt("share_token_min_length", shareTokenMinChars)
+}
+
+// GetChangeJournalRetentionDays returns how long change journal entries are kept
+func (h *SettingsHandler) GetChangeJournalRetentionDays() int {
+	return h.GetSettingInt("change_journal_retention_days", 30)
+}
+
+// GetNotificationRetentionDays returns how long read notifications are kept
+func (h *SettingsHandler) GetNotificationRetentionDays() int {
+	return h.GetSettingInt("notification_retention_days", 30)
+}
+
+// GetAuditRetentionDays returns how long audit log entries stay in the
+// database before they are archived (0 = keep forever)
+func (h *SettingsHandler) GetAuditRetentionDays() int {
+	return h.GetSettingInt("audit_retention_days", 0)
+}
+
+// GetUploadExpiryHours returns how long unfinished uploads are kept without
+// activity before they are deleted (0 = keep forever)
+func (h *SettingsHandler) GetUploadExpiryHours() int {
+	return h.GetSettingInt("upload_expiry_hours", 24)
+}
+
+// GetUploadDedupEnabled reports whether upload prechecks may complete an
+// upload from an identical file elsewhere
+func (h *SettingsHandler) GetUploadDedupEnabled() bool {
+	return h.GetSettingBool("upload_dedup_enabled", true)
+}
+
+// GetZipDownloadMaxBytes returns the largest selection allowed as a ZIP download (0 = unlimited)
+func (h *SettingsHandler) GetZipDownloadMaxBytes() int64 {
+	return h.GetSettingInt64("zip_download_max_bytes", 0)
+}
+
+// GetZipDownloadLevel returns the deflate level of ZIP downloads (0 = store uncompressed)
+func (h *SettingsHandler) GetZipDownloadLevel() int {
+	return h.GetSettingInt("zip_download_level", 6)
+}
+
 // Global settings handler instance
 var globalSettingsHandler *SettingsHandler
 
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -42,6 +43,7 @@ type Share struct {
 	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
 	HasPassword  bool       `json:"hasPassword"`
 	AccessCount  int        `json:"accessCount"`
+	PreviewCount int        `json:"previewCount"` // Share page previews, counted apart from accesses
 	MaxAccess    *int       `json:"maxAccess,omitempty"`
 	IsActive     bool       `json:"isActive"`
 	RequireLogin bool       `json:"requireLogin"`
@@ -57,6 +59,7 @@ type Share struct {
 	UploadCount       int    `json:"uploadCount"`                 // Number of files uploaded
 	MaxTotalSize      int64  `json:"maxTotalSize,omitempty"`      // Max total upload size in bytes (0 = unlimited)
 	TotalUploadedSize int64  `json:"totalUploadedSize"`           // Current total uploaded bytes
+	NotifyEmail       bool   `json:"notifyEmail"`                 // Also email the creator about downloads and uploads
 }
 
 // CreateShareRequest represents share creation request
@@ -72,6 +75,24 @@ type CreateShareRequest struct {
 	MaxFileSize       int64  `json:"maxFileSize,omitempty"`       // Max size per file in bytes (0 = unlimited)
 	AllowedExtensions string `json:"allowedExtensions,omitempty"` // Comma-separated list
 	MaxTotalSize      int64  `json:"maxTotalSize,omitempty"`      // Max total upload size
+	NotifyEmail       bool   `json:"notifyEmail,omitempty"`       // Also email downloads and uploads when SMTP is configured
+	// EmailTo are addresses the new link is mailed to, with EmailMessage
+	EmailTo      []string `json:"emailTo,omitempty"`
+	EmailMessage string   `json:"emailMessage,omitempty"`
+}
+
+// UpdateShareRequest changes a live share; omitted fields keep their value.
+// Upload limits and counters only apply to upload shares.
+type UpdateShareRequest struct {
+	IsActive          *bool   `json:"isActive,omitempty"`          // false pauses the share, true resumes it
+	ExpiresIn         *int    `json:"expiresIn,omitempty"`         // hours from now, 0 = never expires
+	Password          *string `json:"password,omitempty"`          // "" removes the password
+	MaxAccess         *int    `json:"maxAccess,omitempty"`         // Max accesses (uploads of an upload share), 0 = unlimited
+	MaxFileSize       *int64  `json:"maxFileSize,omitempty"`       // 0 = unlimited
+	AllowedExtensions *string `json:"allowedExtensions,omitempty"` // Comma-separated list, "" = any
+	MaxTotalSize      *int64  `json:"maxTotalSize,omitempty"`      // 0 = unlimited
+	ResetCounters     bool    `json:"resetCounters,omitempty"`     // Zero upload count and uploaded size
+	NotifyEmail       *bool   `json:"notifyEmail,omitempty"`       // Email activity notifications
 }
 
 // AccessShareRequest represents share access request
@@ -131,6 +152,9 @@ func (h *ShareHandler) CreateShare(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	if GetGuests().IsGuest(claims.UserID) {
+		return RespondError(c, ErrForbidden("Guest accounts cannot create shares"))
+	}
 
 	var req CreateShareRequest
 	if err := c.Bind(&req); err != nil {
@@ -140,6 +164,10 @@ func (h *ShareHandler) CreateShare(c echo.Context) error {
 	if req.Path == "" {
 		return RespondError(c, ErrMissingParameter("path"))
 	}
+	emailTo, apiErr := validateShareEmailRecipients(req.EmailTo, req.EmailMessage)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Default share type is download
 	shareType := req.ShareType
@@ -151,6 +179,9 @@ func (h *ShareHandler) CreateShare(c echo.Context) error {
 	}
 
 	// Resolve virtual path to real filesystem path
+	if _, _, ok := GetMountIns().Resolve(req.Path); ok {
+		return RespondError(c, ErrBadRequest("Items in a mounted folder cannot be shared; the owner can share them from their home folder"))
+	}
 	fullPath, storedPath, err := h.resolvePath(req.Path, claims.Username)
 	if err != nil {
 		return RespondError(c, ErrBadRequest(err.Error()))
@@ -174,9 +205,6 @@ func (h *ShareHandler) CreateShare(c echo.Context) error {
 	// Editable flag is implicitly true for edit share type
 	editable := req.Editable || shareType == "edit"
 
-	// Generate token
-	token := generateShareToken()
-
 	// Hash password if provided
 	var passwordHash *string
 	if req.Password != "" {
@@ -201,16 +229,18 @@ func (h *ShareHandler) CreateShare(c echo.Context) error {
 		maxAccess = &req.MaxAccess
 	}
 
-	// Insert new share with editable and upload-specific fields
+	// Insert new share with editable and upload-specific fields; a token
+	// collision is retried with a fresh token
 	var shareID string
-	err = h.db.QueryRow(`
-		INSERT INTO shares (token, path, created_by, password_hash, expires_at, max_access, require_login,
-		                    share_type, editable, max_file_size, allowed_extensions, max_total_size)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-		RETURNING id
-	`, token, storedPath, claims.UserID, passwordHash, expiresAt, maxAccess, req.RequireLogin,
-		shareType, editable, req.MaxFileSize, req.AllowedExtensions, req.MaxTotalSize).Scan(&shareID)
-
+	token, err := insertShareWithToken(shareType, func(token string) error {
+		return h.db.QueryRow(`
+			INSERT INTO shares (token, path, created_by, password_hash, expires_at, max_access, require_login,
+			                    share_type, editable, max_file_size, allowed_extensions, max_total_size, notify_email)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			RETURNING id
+		`, token, storedPath, claims.UserID, passwordHash, expiresAt, maxAccess, req.RequireLogin,
+			shareType, editable, req.MaxFileSize, req.AllowedExtensions, req.MaxTotalSize, req.NotifyEmail).Scan(&shareID)
+	})
 	if err != nil {
 		return RespondError(c, ErrOperationFailed("create share", err))
 	}
@@ -235,8 +265,21 @@ func (h *ShareHandler) CreateShare(c echo.Context) error {
 		"maxAccess":      req.MaxAccess,
 		"requireLogin":   req.RequireLogin,
 		"editable":       editable,
+		"notifyEmail":    req.NotifyEmail,
 		"isDir":          fileInfo.IsDir(),
+		"emailedTo":      len(emailTo),
 	})
+	if len(emailTo) > 0 {
+		h.emailShareLink(shareLinkMail{
+			Sender:      claims.Username,
+			Name:        fileInfo.Name(),
+			URL:         appBaseURL(c) + shareURL,
+			ShareType:   shareType,
+			Message:     req.EmailMessage,
+			HasPassword: req.Password != "",
+			ExpiresAt:   expiresAt,
+		}, emailTo)
+	}
 
 	return RespondCreated(c, map[string]interface{}{
 		"id":           shareID,
@@ -247,25 +290,37 @@ func (h *ShareHandler) CreateShare(c echo.Context) error {
 		"requireLogin": req.RequireLogin,
 		"shareType":    shareType,
 		"editable":     editable,
+		"notifyEmail":  req.NotifyEmail,
+		"emailedTo":    emailTo,
 	})
 }
 
-// ListShares returns shares created by the current user
+// ListShares returns shares created by the current user, or with all=true
+// the shares of every user (requires shares.manage_all)
 func (h *ShareHandler) ListShares(c echo.Context) error {
 	claims, err := RequireClaims(c)
 	if err != nil {
 		return err
 	}
 
+	where, args := "WHERE created_by = $1", []interface{}{claims.UserID}
+	if c.QueryParam("all") == "true" {
+		if _, err := RequireAdminPermission(c, PermSharesManageAll); err != nil {
+			return err
+		}
+		where, args = "", nil
+	}
+
 	rows, err := h.db.Query(`
-		SELECT id, token, path, created_at, expires_at,
+		SELECT id, token, path, COALESCE(created_by::text, created_by_pseudonym, '') AS created_by, created_at, expires_at,
 		       CASE WHEN password_hash IS NOT NULL THEN true ELSE false END as has_password,
-		       access_count, max_access, is_active, require_login,
-		       share_type, COALESCE(editable, false) as editable, max_file_size, allowed_extensions, upload_count, max_total_size, total_uploaded_size
+		       access_count, preview_count, max_access, is_active, require_login,
+		       share_type, COALESCE(editable, false) as editable, max_file_size, allowed_extensions, upload_count, max_total_size, total_uploaded_size,
+		       notify_email
 		FROM shares
-		WHERE created_by = $1
+		`+where+`
 		ORDER BY created_at DESC
-	`, claims.UserID)
+	`, args...)
 
 	if err != nil {
 		return RespondError(c, ErrOperationFailed("list shares", err))
@@ -279,14 +334,14 @@ func (h *ShareHandler) ListShares(c echo.Context) error {
 		var maxAccess sql.NullInt32
 		var allowedExtensions sql.NullString
 
-		err := rows.Scan(&share.ID, &share.Token, &share.Path, &share.CreatedAt,
-			&expiresAt, &share.HasPassword, &share.AccessCount, &maxAccess, &share.IsActive, &share.RequireLogin,
-			&share.ShareType, &share.Editable, &share.MaxFileSize, &allowedExtensions, &share.UploadCount, &share.MaxTotalSize, &share.TotalUploadedSize)
+		err := rows.Scan(&share.ID, &share.Token, &share.Path, &share.CreatedBy, &share.CreatedAt,
+			&expiresAt, &share.HasPassword, &share.AccessCount, &share.PreviewCount, &maxAccess, &share.IsActive, &share.RequireLogin,
+			&share.ShareType, &share.Editable, &share.MaxFileSize, &allowedExtensions, &share.UploadCount, &share.MaxTotalSize, &share.TotalUploadedSize,
+			&share.NotifyEmail)
 		if err != nil {
 			continue
 		}
 
-		share.CreatedBy = claims.UserID
 		if expiresAt.Valid {
 			share.ExpiresAt = &expiresAt.Time
 		}
@@ -335,7 +390,197 @@ func (h *ShareHandler) ListShares(c echo.Context) error {
 	})
 }
 
-// DeleteShare deletes a share
+// UpdateShare changes a share while its link stays valid
+// UpdateShare godoc
+// @Summary Update a share
+// @Description Change a share without changing its link: pause or resume it, extend or clear its expiry, set or remove its password, or change its access limit; for upload shares also the upload limits, or reset the upload counters. maxAccess cannot go below the accesses (uploads of an upload share) already made. Every change is audited as share.update with its old and new value. Upload share changes apply to the next upload; uploads in progress when a share is paused finish unless the upload_share_pause_inflight setting is "reject". A new password ends the share sessions of earlier visitors.
+// @Tags Shares
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Share ID"
+// @Param request body UpdateShareRequest true "Changes"
+// @Success 200 {object} map[string]interface{} "Share updated"
+// @Failure 400 {object} map[string]string "Invalid request, or an upload limit on another share type"
+// @Failure 401 {object} map[string]string "Unauthorized"
+// @Failure 404 {object} map[string]string "Share not found"
+// @Router /shares/{id} [put]
+func (h *ShareHandler) UpdateShare(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	shareID := c.Param("id")
+
+	var req UpdateShareRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if (req.MaxFileSize != nil && *req.MaxFileSize < 0) || (req.MaxTotalSize != nil && *req.MaxTotalSize < 0) ||
+		(req.MaxAccess != nil && *req.MaxAccess < 0) || (req.ExpiresIn != nil && *req.ExpiresIn < 0) {
+		return RespondError(c, ErrBadRequest("Limits cannot be negative"))
+	}
+
+	// Current values, for validation and the audit trail
+	var sharePath, shareType string
+	var uploadCount, accessCount int
+	var totalUploadedSize, maxFileSize, maxTotalSize int64
+	var expiresAt sql.NullTime
+	var maxAccess sql.NullInt32
+	var hasPassword, isActive, notifyEmail bool
+	var allowedExtensions string
+	err = h.db.QueryRow(`
+		SELECT path, share_type, upload_count, total_uploaded_size, access_count, expires_at,
+		       password_hash IS NOT NULL, max_access, is_active, COALESCE(max_file_size, 0),
+		       COALESCE(allowed_extensions, ''), COALESCE(max_total_size, 0), notify_email
+		FROM shares WHERE id = $1 AND created_by = $2
+	`, shareID, claims.UserID).Scan(&sharePath, &shareType, &uploadCount, &totalUploadedSize, &accessCount, &expiresAt,
+		&hasPassword, &maxAccess, &isActive, &maxFileSize, &allowedExtensions, &maxTotalSize, &notifyEmail)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return RespondError(c, ErrNotFound("Share not found"))
+		}
+		return RespondError(c, ErrOperationFailed("query share", err))
+	}
+	uploadOnly := req.MaxFileSize != nil || req.AllowedExtensions != nil || req.MaxTotalSize != nil || req.ResetCounters
+	if shareType != "upload" && uploadOnly {
+		return RespondError(c, ErrBadRequest("Upload limits only apply to upload shares"))
+	}
+
+	var oldExpiresAt *time.Time
+	if expiresAt.Valid {
+		oldExpiresAt = &expiresAt.Time
+	}
+	newExpiresAt := oldExpiresAt
+	if req.ExpiresIn != nil {
+		newExpiresAt = nil
+		if *req.ExpiresIn > 0 {
+			t := time.Now().Add(time.Duration(*req.ExpiresIn) * time.Hour)
+			newExpiresAt = &t
+		}
+	}
+	if req.IsActive != nil && *req.IsActive && newExpiresAt != nil && time.Now().After(*newExpiresAt) {
+		return RespondError(c, ErrBadRequest("Share has expired; extend its expiry to resume it"))
+	}
+
+	// The limit can't drop below what has been used: accesses, or uploads
+	// of an upload share
+	if req.MaxAccess != nil && *req.MaxAccess > 0 {
+		used := accessCount
+		if shareType == "upload" {
+			used = uploadCount
+			if req.ResetCounters {
+				used = 0
+			}
+		}
+		if *req.MaxAccess < used {
+			return RespondError(c, ErrBadRequest(fmt.Sprintf("maxAccess cannot be below the current count of %d", used)))
+		}
+	}
+
+	var sets []string
+	var args []interface{}
+	changes := map[string]interface{}{"shareId": shareID}
+	set := func(column, key string, oldValue, value interface{}) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+		changes[key] = map[string]interface{}{"old": oldValue, "new": value}
+	}
+	if req.IsActive != nil {
+		set("is_active", "isActive", isActive, *req.IsActive)
+	}
+	if req.ExpiresIn != nil {
+		set("expires_at", "expiresAt", oldExpiresAt, newExpiresAt)
+		// Remind the owner again before the new expiry
+		sets = append(sets, "expiration_notified = FALSE")
+	}
+	if req.Password != nil {
+		var passwordHash *string
+		if *req.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return RespondError(c, ErrInternal("Failed to hash password"))
+			}
+			hashStr := string(hash)
+			passwordHash = &hashStr
+		}
+		set("password_hash", "password", nil, passwordHash)
+		// Never audit the hash, only whether there is a password
+		changes["password"] = map[string]interface{}{"old": hasPassword, "new": passwordHash != nil}
+	}
+	if req.MaxFileSize != nil {
+		set("max_file_size", "maxFileSize", maxFileSize, *req.MaxFileSize)
+	}
+	if req.AllowedExtensions != nil {
+		set("allowed_extensions", "allowedExtensions", allowedExtensions, normalizeShareExtensions(*req.AllowedExtensions))
+	}
+	if req.MaxTotalSize != nil {
+		set("max_total_size", "maxTotalSize", maxTotalSize, *req.MaxTotalSize)
+	}
+	if req.MaxAccess != nil {
+		var oldMaxAccess, newMaxAccess *int
+		if maxAccess.Valid {
+			val := int(maxAccess.Int32)
+			oldMaxAccess = &val
+		}
+		if *req.MaxAccess > 0 {
+			newMaxAccess = req.MaxAccess
+		}
+		set("max_access", "maxAccess", oldMaxAccess, newMaxAccess)
+	}
+	if req.NotifyEmail != nil {
+		set("notify_email", "notifyEmail", notifyEmail, *req.NotifyEmail)
+	}
+	changed := len(sets) > 0
+	if req.ResetCounters {
+		sets = append(sets, "upload_count = 0", "total_uploaded_size = 0")
+	}
+	if len(sets) == 0 {
+		return RespondError(c, ErrBadRequest("Nothing to update"))
+	}
+
+	args = append(args, shareID, claims.UserID)
+	result, err := h.db.Exec(fmt.Sprintf(`
+		UPDATE shares SET %s WHERE id = $%d AND created_by = $%d
+	`, strings.Join(sets, ", "), len(args)-1, len(args)), args...)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("update share", err))
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return RespondError(c, ErrNotFound("Share not found"))
+	}
+
+	if changed {
+		h.auditHandler.LogEventFromContext(c, EventShareUpdate, sharePath, changes)
+	}
+	if req.ResetCounters {
+		h.auditHandler.LogEventFromContext(c, EventShareResetCounters, sharePath, map[string]interface{}{
+			"shareId":           shareID,
+			"uploadCount":       uploadCount,
+			"totalUploadedSize": totalUploadedSize,
+		})
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"message":   "Share updated",
+		"expiresAt": newExpiresAt,
+	})
+}
+
+// normalizeShareExtensions cleans a comma-separated extension list the way
+// upload validation compares it: lower case, without dots or blanks
+func normalizeShareExtensions(list string) string {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		if ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")); ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	return strings.Join(exts, ",")
+}
+
+// DeleteShare deletes a share. Users with shares.manage_all can delete any
+// user's share.
 func (h *ShareHandler) DeleteShare(c echo.Context) error {
 	claims, err := RequireClaims(c)
 	if err != nil {
@@ -343,11 +588,16 @@ func (h *ShareHandler) DeleteShare(c echo.Context) error {
 	}
 	shareID := c.Param("id")
 
+	owner, args := " AND created_by = $2", []interface{}{shareID, claims.UserID}
+	if claims.HasPermission(PermSharesManageAll) {
+		owner, args = "", []interface{}{shareID}
+	}
+
 	// Get share details before deletion for audit
-	var sharePath, shareType string
+	var sharePath, shareType, createdBy string
 	err = h.db.QueryRow(`
-		SELECT path, share_type FROM shares WHERE id = $1 AND created_by = $2
-	`, shareID, claims.UserID).Scan(&sharePath, &shareType)
+		SELECT path, share_type, COALESCE(created_by::text, created_by_pseudonym, '') FROM shares WHERE id = $1`+owner,
+		args...).Scan(&sharePath, &shareType, &createdBy)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RespondError(c, ErrNotFound("Share not found"))
@@ -356,8 +606,7 @@ func (h *ShareHandler) DeleteShare(c echo.Context) error {
 	}
 
 	result, err := h.db.Exec(`
-		DELETE FROM shares WHERE id = $1 AND created_by = $2
-	`, shareID, claims.UserID)
+		DELETE FROM shares WHERE id = $1`+owner, args...)
 
 	if err != nil {
 		return RespondError(c, ErrOperationFailed("delete share", err))
@@ -369,17 +618,24 @@ func (h *ShareHandler) DeleteShare(c echo.Context) error {
 	}
 
 	// Audit log for share deletion
-	h.auditHandler.LogEventFromContext(c, EventShareDelete, sharePath, map[string]interface{}{
+	details := map[string]interface{}{
 		"shareId":   shareID,
 		"shareType": shareType,
-	})
+	}
+	if createdBy != claims.UserID {
+		details["createdBy"] = createdBy
+	}
+	h.auditHandler.LogEventFromContext(c, EventShareDelete, sharePath, details)
 
 	return RespondSuccess(c, map[string]interface{}{
 		"message": "Share deleted",
 	})
 }
 
-// AccessShare validates and returns share information for public access
+// AccessShare validates and returns share information for public access.
+// Opening a share counts one access and returns a share session for its
+// sub-requests; a folder comes with a page of its listing (subpath, page
+// and pageSize query parameters).
 func (h *ShareHandler) AccessShare(c echo.Context) error {
 	token := c.Param("token")
 
@@ -389,7 +645,7 @@ func (h *ShareHandler) AccessShare(c echo.Context) error {
 	var maxAccess sql.NullInt32
 
 	err := h.db.QueryRow(`
-		SELECT id, token, path, created_by, created_at, expires_at,
+		SELECT id, token, path, COALESCE(created_by::text, created_by_pseudonym, '') AS created_by, created_at, expires_at,
 		       password_hash, access_count, max_access, is_active, require_login,
 		       share_type, COALESCE(editable, false) as editable
 		FROM shares
@@ -415,8 +671,12 @@ func (h *ShareHandler) AccessShare(c echo.Context) error {
 		return c.JSON(http.StatusGone, map[string]string{"error": "Share has expired"})
 	}
 
+	// A share session from an earlier open continues that visit: it stands
+	// in for the password and is not counted again
+	inSession := validShareSession(shareSessionParam(c), &share, passwordHash.String, time.Now())
+
 	// Check max access
-	if maxAccess.Valid && share.AccessCount >= int(maxAccess.Int32) {
+	if !inSession && maxAccess.Valid && share.AccessCount >= int(maxAccess.Int32) {
 		return c.JSON(http.StatusGone, map[string]string{"error": "Share access limit reached"})
 	}
 
@@ -442,7 +702,7 @@ func (h *ShareHandler) AccessShare(c echo.Context) error {
 	}
 
 	// Check if password is required
-	if share.HasPassword {
+	if share.HasPassword && !inSession {
 		// Check if password is provided
 		var req AccessShareRequest
 		if err := c.Bind(&req); err != nil || req.Password == "" {
@@ -454,12 +714,24 @@ func (h *ShareHandler) AccessShare(c echo.Context) error {
 
 		// Verify password
 		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(req.Password)); err != nil {
+			h.logSharePasswordFailure(c, share.Path, share.Token)
 			return RespondError(c, ErrUnauthorized("Invalid password"))
 		}
 	}
 
-	// Increment access count
-	_, _ = h.db.Exec("UPDATE shares SET access_count = access_count + 1 WHERE id = $1", share.ID)
+	// Count the visit once and start its session
+	session := shareSessionParam(c)
+	if !inSession {
+		_, _ = h.db.Exec("UPDATE shares SET access_count = access_count + 1 WHERE id = $1", share.ID)
+		h.logShareEvent(c, EventShareAccess, share.Path, map[string]interface{}{
+			"action":      "open",
+			"shareId":     share.ID,
+			"token":       share.Token,
+			"shareType":   share.ShareType,
+			"hasPassword": share.HasPassword,
+		})
+		session = newShareSession(&share, passwordHash.String, time.Now())
+	}
 
 	// Get file info
 	fullPath := filepath.Join(h.dataRoot, share.Path)
@@ -468,7 +740,7 @@ func (h *ShareHandler) AccessShare(c echo.Context) error {
 		return RespondError(c, ErrNotFound("File not found"))
 	}
 
-	return RespondSuccess(c, map[string]interface{}{
+	result := map[string]interface{}{
 		"token":     share.Token,
 		"path":      share.Path,
 		"name":      filepath.Base(share.Path),
@@ -477,63 +749,53 @@ func (h *ShareHandler) AccessShare(c echo.Context) error {
 		"expiresAt": share.ExpiresAt,
 		"shareType": share.ShareType,
 		"editable":  share.Editable,
-	})
-}
-
-// DownloadShare handles file download for shared link
-func (h *ShareHandler) DownloadShare(c echo.Context) error {
-	token := c.Param("token")
+		"preview":   sharePreviewKind(info.Name()),
+		"session":   session,
+	}
 
-	var path string
-	var passwordHash sql.NullString
-	var expiresAt sql.NullTime
-	var maxAccess sql.NullInt32
-	var accessCount int
-	var isActive bool
-	var requireLogin bool
-	var createdBy string
+	// A shared folder opens on a page of its listing
+	if info.IsDir() && share.ShareType != "upload" {
+		page, _ := strconv.Atoi(c.QueryParam("page"))
+		pageSize, _ := strconv.Atoi(c.QueryParam("pageSize"))
+		listing, apiErr := listShareFolder(fullPath, c.QueryParam("subpath"), page, pageSize)
+		if apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+		result["listing"] = listing
+	}
 
-	err := h.db.QueryRow(`
-		SELECT path, password_hash, expires_at, access_count, max_access, is_active, require_login, created_by
-		FROM shares WHERE token = $1
-	`, token).Scan(&path, &passwordHash, &expiresAt, &accessCount, &maxAccess, &isActive, &requireLogin, &createdBy)
+	return RespondSuccess(c, result)
+}
 
-	if err == sql.ErrNoRows {
-		return RespondError(c, ErrNotFound("Share not found"))
-	}
-	if err != nil {
-		return RespondError(c, ErrInternal("Database error"))
+// logShareEvent audit-logs a request through a share link. It is
+// attributed to the signed-in user if there is one; the client IP is
+// recorded for anonymous visitors too.
+func (h *ShareHandler) logShareEvent(c echo.Context, eventType, path string, details map[string]interface{}) {
+	var userID *string
+	if claims, ok := c.Get("user").(*JWTClaims); ok && claims != nil {
+		userID = &claims.UserID
 	}
+	_ = h.auditHandler.LogEvent(userID, c.RealIP(), eventType, path, details)
+}
 
-	// Validate share
-	if !isActive {
-		return c.JSON(http.StatusGone, map[string]string{"error": "Share is no longer available"})
-	}
-	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
-		return c.JSON(http.StatusGone, map[string]string{"error": "Share has expired"})
-	}
-	if maxAccess.Valid && accessCount >= int(maxAccess.Int32) {
-		return c.JSON(http.StatusGone, map[string]string{"error": "Access limit reached"})
-	}
+// logSharePasswordFailure records a wrong password for a protected share
+func (h *ShareHandler) logSharePasswordFailure(c echo.Context, path, token string) {
+	h.logShareEvent(c, EventShareAccess, path, map[string]interface{}{
+		"action": "password",
+		"result": "invalid_password",
+		"token":  token,
+	})
+}
 
-	// Check if login is required
-	if requireLogin {
-		claims, _ := c.Get("user").(*JWTClaims)
-		if claims == nil {
-			return RespondError(c, ErrUnauthorized("Login required"))
-		}
-	}
+// DownloadShare handles file download for shared link
+func (h *ShareHandler) DownloadShare(c echo.Context) error {
+	token := c.Param("token")
 
-	// Check password if required
-	if passwordHash.Valid {
-		password := c.QueryParam("password")
-		if password == "" {
-			return RespondError(c, ErrUnauthorized("Password required"))
-		}
-		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(password)); err != nil {
-			return RespondError(c, ErrUnauthorized("Invalid password"))
-		}
+	share, err := h.openPublicShare(c, token)
+	if share == nil {
+		return err
 	}
+	path, createdBy := share.Path, share.CreatedBy
 
 	fullPath := filepath.Join(h.dataRoot, path)
 	info, err := os.Stat(fullPath)
@@ -552,8 +814,7 @@ func (h *ShareHandler) DownloadShare(c echo.Context) error {
 		userID = &claims.UserID
 		accessorUsername = claims.Username
 	}
-	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventShareAccess, path, map[string]interface{}{
-		"action":   "download",
+	h.logShareEvent(c, EventShareDownload, path, map[string]interface{}{
 		"token":    token,
 		"filename": info.Name(),
 		"size":     info.Size(),
@@ -569,7 +830,7 @@ func (h *ShareHandler) DownloadShare(c echo.Context) error {
 			message = "누군가가 '" + info.Name() + "' 파일을 다운로드했습니다 (IP: " + c.RealIP() + ")"
 		}
 		link := "/shared-by-me"
-		_, _ = h.notificationService.Create(
+		notifyShareActivity(h.db, h.notificationService, token,
 			createdBy,
 			NotifShareLinkAccessed,
 			title,
@@ -585,8 +846,18 @@ func (h *ShareHandler) DownloadShare(c echo.Context) error {
 		)
 	}
 
+	downloaderID := ""
+	if userID != nil {
+		downloaderID = *userID
+	}
+	GetDownloadStats().RecordDownload(fullPath, downloaderID, c.RealIP())
+
+	if info.Size() >= ActivityMinDownloadSize {
+		defer TrackResponse(c, ActivityDownload, path, token, info.Size()).Finish()
+	}
+
 	setContentDisposition(c, info.Name())
-	return c.File(fullPath)
+	return ServePlainFile(c, fullPath)
 }
 
 // GetShareOnlyOfficeConfig returns OnlyOffice configuration for editable share links
@@ -599,7 +870,7 @@ func (h *ShareHandler) GetShareOnlyOfficeConfig(c echo.Context) error {
 	var maxAccess sql.NullInt32
 
 	err := h.db.QueryRow(`
-		SELECT id, token, path, created_by, created_at, expires_at,
+		SELECT id, token, path, COALESCE(created_by::text, created_by_pseudonym, '') AS created_by, created_at, expires_at,
 		       password_hash, access_count, max_access, is_active, require_login,
 		       share_type, COALESCE(editable, false) as editable
 		FROM shares
@@ -767,7 +1038,7 @@ func (h *ShareHandler) GetShareFile(c echo.Context) error {
 	}
 
 	fullPath := filepath.Join(h.dataRoot, share.Path)
-	return c.File(fullPath)
+	return ServePlainFile(c, fullPath)
 }
 
 // ShareOnlyOfficeCallback handles OnlyOffice save callbacks for editable shares
@@ -780,7 +1051,7 @@ func (h *ShareHandler) ShareOnlyOfficeCallback(c echo.Context) error {
 	var maxAccess sql.NullInt32
 
 	err := h.db.QueryRow(`
-		SELECT id, token, path, created_by, is_active, expires_at, max_access, access_count,
+		SELECT id, token, path, COALESCE(created_by::text, created_by_pseudonym, '') AS created_by, is_active, expires_at, max_access, access_count,
 		       password_hash, COALESCE(editable, false) as editable
 		FROM shares WHERE token = $1
 	`, shareToken).Scan(&share.ID, &share.Token, &share.Path, &share.CreatedBy, &share.IsActive,
@@ -850,6 +1121,7 @@ func (h *ShareHandler) ShareOnlyOfficeCallback(c echo.Context) error {
 		if err := writeShareFile(fullPath, content, 0644); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]int{"error": 1})
 		}
+		GetFileChecksums().ForgetTree(fullPath)
 
 		// Log audit event
 		_ = h.auditHandler.LogEvent(&share.CreatedBy, c.RealIP(), EventFileEdit, share.Path, map[string]interface{}{
@@ -862,7 +1134,7 @@ func (h *ShareHandler) ShareOnlyOfficeCallback(c echo.Context) error {
 		if h.notificationService != nil {
 			title := "공유 문서가 수정되었습니다"
 			message := fmt.Sprintf("공유 링크를 통해 '%s' 파일이 수정되었습니다", filepath.Base(share.Path))
-			_, _ = h.notificationService.Create(
+			h.notificationService.Send(
 				share.CreatedBy,
 				NotifSharedFileModified,
 				title,
@@ -913,22 +1185,7 @@ func convertShareURL(externalURL string) string {
 }
 
 func writeShareFile(path string, content []byte, perm os.FileMode) error {
-	// Write to temp file first for atomic write
-	tmpPath := path + ".tmp"
-	if err := os.WriteFile(tmpPath, content, perm); err != nil {
-		return err
-	}
-	return os.Rename(tmpPath, path)
-}
-
-// generateShareToken generates a unique share token using crypto-secure random
-func generateShareToken() string {
-	token, err := GenerateSecureToken(16)
-	if err != nil {
-		// Fallback should never happen in normal operation
-		return MustGenerateSecureToken(16)
-	}
-	return token
+	return WriteFileSealed(path, content, perm)
 }
 
 // ListShareContents lists files inside a shared folder
@@ -952,7 +1209,7 @@ func (h *ShareHandler) ListShareContents(c echo.Context) error {
 	var maxAccess sql.NullInt32
 
 	err := h.db.QueryRow(`
-		SELECT id, token, path, created_by, created_at, expires_at,
+		SELECT id, token, path, COALESCE(created_by::text, created_by_pseudonym, '') AS created_by, created_at, expires_at,
 		       password_hash, access_count, max_access, is_active, require_login,
 		       share_type
 		FROM shares
@@ -975,7 +1232,8 @@ func (h *ShareHandler) ListShareContents(c echo.Context) error {
 	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
 		return c.JSON(http.StatusGone, map[string]string{"error": "Share has expired"})
 	}
-	if maxAccess.Valid && share.AccessCount >= int(maxAccess.Int32) {
+	inSession := validShareSession(shareSessionParam(c), &share, passwordHash.String, time.Now())
+	if !inSession && maxAccess.Valid && share.AccessCount >= int(maxAccess.Int32) {
 		return c.JSON(http.StatusGone, map[string]string{"error": "Access limit reached"})
 	}
 
@@ -989,8 +1247,8 @@ func (h *ShareHandler) ListShareContents(c echo.Context) error {
 		}
 	}
 
-	// Check password if required
-	if passwordHash.Valid {
+	// Check password if required, unless in a share session
+	if passwordHash.Valid && !inSession {
 		password := c.QueryParam("password")
 		if password == "" {
 			return RespondSuccess(c, map[string]interface{}{
@@ -1002,15 +1260,10 @@ func (h *ShareHandler) ListShareContents(c echo.Context) error {
 		}
 	}
 
-	// Build full path
-	fullPath := filepath.Join(h.dataRoot, share.Path)
-	if subpath != "" {
-		// Validate subpath to prevent path traversal
-		cleanSubpath := filepath.Clean(subpath)
-		if strings.Contains(cleanSubpath, "..") {
-			return RespondError(c, ErrBadRequest("Invalid subpath"))
-		}
-		fullPath = filepath.Join(fullPath, cleanSubpath)
+	// Resolve inside the share root, refusing traversal and hidden folders
+	fullPath, apiErr := sharePreviewPath(filepath.Join(h.dataRoot, share.Path), subpath)
+	if apiErr != nil {
+		return RespondError(c, ErrNotFound("Folder not found"))
 	}
 
 	// Check if path exists and is a directory
@@ -1034,6 +1287,7 @@ func (h *ShareHandler) ListShareContents(c echo.Context) error {
 		IsDir   bool      `json:"isDir"`
 		Size    int64     `json:"size"`
 		ModTime time.Time `json:"modTime"`
+		Preview string    `json:"preview,omitempty"` // image, video, audio or pdf for GET /s/:token/preview
 	}
 
 	files := make([]FileItem, 0, len(entries))
@@ -1065,6 +1319,7 @@ func (h *ShareHandler) ListShareContents(c echo.Context) error {
 		}
 
 		if !entry.IsDir() {
+			item.Preview = sharePreviewKind(entry.Name())
 			totalSize += entryInfo.Size()
 		}
 
@@ -1094,83 +1349,32 @@ func (h *ShareHandler) ListShareContents(c echo.Context) error {
 // @Tags		Shares
 // @Produce		octet-stream
 // @Param		token	path	string	true	"Share token"
-// @Param		filepath	query	string	true	"File path within the shared folder"
+// @Param		filepath	query	string	false	"File path within the shared folder; the /file/{file} form takes it from the URL path"
 // @Param		password	query	string	false	"Share password if required"
+// @Param		session		query	string	false	"Share session from opening the share, instead of the password (or the X-Share-Session header)"
 // @Success		200		{file}		binary
 // @Failure		400		{object}	map[string]string
 // @Failure		404		{object}	map[string]string
+// @Failure		410		{object}	map[string]string	"Share expired or inactive"
 // @Router		/s/{token}/file [get]
+// @Router		/s/{token}/file/{file} [get]
 func (h *ShareHandler) DownloadShareFile(c echo.Context) error {
 	token := c.Param("token")
-	filePath := c.QueryParam("filepath")
+	filePath := shareFileParam(c, "filepath")
 
 	if filePath == "" {
 		return RespondError(c, ErrBadRequest("File path is required"))
 	}
 
-	var share Share
-	var passwordHash sql.NullString
-	var expiresAt sql.NullTime
-	var maxAccess sql.NullInt32
-
-	err := h.db.QueryRow(`
-		SELECT id, token, path, created_by, expires_at,
-		       password_hash, access_count, max_access, is_active, require_login
-		FROM shares
-		WHERE token = $1
-	`, token).Scan(&share.ID, &share.Token, &share.Path, &share.CreatedBy,
-		&expiresAt, &passwordHash, &share.AccessCount, &maxAccess, &share.IsActive, &share.RequireLogin)
-
-	if err == sql.ErrNoRows {
-		return RespondError(c, ErrNotFound("Share not found"))
-	}
-	if err != nil {
-		return RespondError(c, ErrInternal("Database error"))
-	}
-
-	// Validate share
-	if !share.IsActive {
-		return c.JSON(http.StatusGone, map[string]string{"error": "Share is no longer available"})
-	}
-	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
-		return c.JSON(http.StatusGone, map[string]string{"error": "Share has expired"})
-	}
-	if maxAccess.Valid && share.AccessCount >= int(maxAccess.Int32) {
-		return c.JSON(http.StatusGone, map[string]string{"error": "Access limit reached"})
-	}
-
-	// Check if login is required
-	if share.RequireLogin {
-		claims, _ := c.Get("user").(*JWTClaims)
-		if claims == nil {
-			return RespondError(c, ErrUnauthorized("Login required"))
-		}
-	}
-
-	// Check password if required
-	if passwordHash.Valid {
-		password := c.QueryParam("password")
-		if password == "" {
-			return RespondError(c, ErrUnauthorized("Password required"))
-		}
-		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(password)); err != nil {
-			return RespondError(c, ErrUnauthorized("Invalid password"))
-		}
-	}
-
-	// Validate filepath to prevent path traversal
-	cleanPath := filepath.Clean(filePath)
-	if strings.Contains(cleanPath, "..") {
-		return RespondError(c, ErrBadRequest("Invalid file path"))
+	share, err := h.openPublicShare(c, token)
+	if share == nil {
+		return err
 	}
 
-	// Build full path
-	fullPath := filepath.Join(h.dataRoot, share.Path, cleanPath)
-
-	// Security check: ensure path is within share root
-	shareRoot := filepath.Join(h.dataRoot, share.Path)
-	if !strings.HasPrefix(fullPath, shareRoot) {
-		return RespondError(c, ErrForbidden("Access denied"))
+	// Resolve inside the share root, refusing traversal and hidden files
+	fullPath, apiErr := sharePreviewPath(filepath.Join(h.dataRoot, share.Path), filePath)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
 	}
 
 	// Check if file exists
@@ -1189,8 +1393,7 @@ func (h *ShareHandler) DownloadShareFile(c echo.Context) error {
 		userID = &claims.UserID
 		accessorUsername = claims.Username
 	}
-	_ = h.auditHandler.LogEvent(userID, c.RealIP(), EventShareAccess, share.Path, map[string]interface{}{
-		"action":   "download_file",
+	h.logShareEvent(c, EventShareDownload, share.Path, map[string]interface{}{
 		"token":    token,
 		"filename": info.Name(),
 		"filepath": filePath,
@@ -1206,7 +1409,7 @@ func (h *ShareHandler) DownloadShareFile(c echo.Context) error {
 		} else {
 			message = "누군가가 '" + info.Name() + "' 파일을 다운로드했습니다 (IP: " + c.RealIP() + ")"
 		}
-		_, _ = h.notificationService.Create(
+		notifyShareActivity(h.db, h.notificationService, token,
 			share.CreatedBy,
 			NotifShareLinkAccessed,
 			title,
@@ -1223,6 +1426,16 @@ func (h *ShareHandler) DownloadShareFile(c echo.Context) error {
 		)
 	}
 
+	downloaderID := ""
+	if userID != nil {
+		downloaderID = *userID
+	}
+	GetDownloadStats().RecordDownload(fullPath, downloaderID, c.RealIP())
+
+	if info.Size() >= ActivityMinDownloadSize {
+		defer TrackResponse(c, ActivityDownload, filepath.Join(share.Path, filePath), token, info.Size()).Finish()
+	}
+
 	setContentDisposition(c, info.Name())
-	return c.File(fullPath)
+	return ServePlainFile(c, fullPath)
 }
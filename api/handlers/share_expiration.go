@@ -16,6 +16,7 @@ const (
 type ShareExpirationChecker struct {
 	db                  *sql.DB
 	notificationService *NotificationService
+	auditHandler        *AuditHandler
 }
 
 // NewShareExpirationChecker creates a new ShareExpirationChecker
@@ -23,21 +24,28 @@ func NewShareExpirationChecker(db *sql.DB, notificationService *NotificationServ
 	return &ShareExpirationChecker{
 		db:                  db,
 		notificationService: notificationService,
+		auditHandler:        NewAuditHandler(db, "/data"),
 	}
 }
 
 // StartBackgroundCheck starts the background expiration check routine
-// Checks every hour for shares expiring within the next 24 hours
+// Checks every hour for shares expiring within the next 24 hours,
+// deactivates expired share links and removes expired user-to-user file
+// shares
 func (c *ShareExpirationChecker) StartBackgroundCheck(checkInterval time.Duration) {
 	go func() {
 		// Initial check on startup
 		c.checkExpiringShares()
+		c.deactivateExpiredShares()
+		c.cleanupExpiredFileShares()
 
 		ticker := time.NewTicker(checkInterval)
 		defer ticker.Stop()
 
 		for range ticker.C {
 			c.checkExpiringShares()
+			c.deactivateExpiredShares()
+			c.cleanupExpiredFileShares()
 		}
 	}()
 	log.Printf("[ShareExpiration] Background checker started (interval: %v)", checkInterval)
@@ -129,6 +137,84 @@ func (c *ShareExpirationChecker) checkExpiringShares() {
 	}
 }
 
+// deactivateExpiredShares deactivates share links past their expiry, so
+// the expiry shows up in the audit log as share.expire. The links are kept
+// for their owners' share lists.
+func (c *ShareExpirationChecker) deactivateExpiredShares() {
+	rows, err := c.db.Query(`
+		UPDATE shares SET is_active = FALSE
+		WHERE is_active = TRUE AND expires_at IS NOT NULL AND expires_at <= NOW()
+		RETURNING id, token, path, COALESCE(created_by::text, created_by_pseudonym, ''), share_type, expires_at, access_count
+	`)
+	if err != nil {
+		log.Printf("[ShareExpiration] Failed to deactivate expired shares: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	expired := 0
+	for rows.Next() {
+		var id, token, path, createdBy, shareType string
+		var expiresAt time.Time
+		var accessCount int
+		if err := rows.Scan(&id, &token, &path, &createdBy, &shareType, &expiresAt, &accessCount); err != nil {
+			log.Printf("[ShareExpiration] Failed to scan expired share: %v", err)
+			continue
+		}
+		_ = c.auditHandler.LogEvent(nil, "0.0.0.0", EventShareExpire, path, map[string]interface{}{
+			"shareId":     id,
+			"token":       token,
+			"shareType":   shareType,
+			"createdBy":   createdBy,
+			"expiresAt":   expiresAt,
+			"accessCount": accessCount,
+		})
+		expired++
+	}
+
+	if expired > 0 {
+		log.Printf("[ShareExpiration] Deactivated %d expired share links", expired)
+	}
+}
+
+// cleanupExpiredFileShares deletes file shares past their expiry. They no
+// longer grant access at that point; each removal is audit-logged.
+func (c *ShareExpirationChecker) cleanupExpiredFileShares() {
+	rows, err := c.db.Query(`
+		DELETE FROM file_shares
+		WHERE expires_at IS NOT NULL AND expires_at <= NOW()
+		RETURNING id, item_path, owner_id, shared_with_id, status, expires_at
+	`)
+	if err != nil {
+		log.Printf("[ShareExpiration] Failed to clean up expired file shares: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	removed := 0
+	for rows.Next() {
+		var id int64
+		var itemPath, ownerID, sharedWithID, status string
+		var expiresAt time.Time
+		if err := rows.Scan(&id, &itemPath, &ownerID, &sharedWithID, &status, &expiresAt); err != nil {
+			log.Printf("[ShareExpiration] Failed to scan expired file share: %v", err)
+			continue
+		}
+		_ = c.auditHandler.LogEvent(nil, "0.0.0.0", "file_share_expire", itemPath, map[string]interface{}{
+			"shareId":      id,
+			"ownerId":      ownerID,
+			"sharedWithId": sharedWithID,
+			"status":       status,
+			"expiresAt":    expiresAt,
+		})
+		removed++
+	}
+
+	if removed > 0 {
+		log.Printf("[ShareExpiration] Removed %d expired file shares", removed)
+	}
+}
+
 // getFileName extracts filename from path
 func getFileName(path string) string {
 	for i := len(path) - 1; i >= 0; i-- {
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Folder share browsing. Opening a folder download share returns a page of
+// its listing, and single files are fetched from GET /api/s/:token/file/*
+// and previewed from GET /api/s/:token/preview/*, which lets a shared
+// folder serve as a photo gallery. Opening the share counts one access and
+// hands out a share session: a signed token that stands in for the
+// password on the share's sub-requests and marks them as part of the
+// counted visit, so thumbnails and downloads never use up max_access.
+// Active, expiry and login checks still apply to every request.
+
+// ShareSessionHeader carries a share session on share sub-requests; the
+// session query parameter works too, for links and <img> sources
+const ShareSessionHeader = "X-Share-Session"
+
+// shareSessionTTL is how long a share session lasts, at most until the
+// share expires
+const shareSessionTTL = 12 * time.Hour
+
+// Listing page sizes of a shared folder
+const (
+	shareListingPageSize    = 100
+	shareListingMaxPageSize = 500
+)
+
+// ShareFolderListing is a page of a shared folder. Paths in Files are
+// relative to the share root.
+type ShareFolderListing struct {
+	Path       string     `json:"path"`
+	Files      []FileInfo `json:"files"`
+	Total      int        `json:"total"`
+	TotalSize  int64      `json:"totalSize"`
+	Page       int        `json:"page"`
+	PageSize   int        `json:"pageSize"`
+	TotalPages int        `json:"totalPages"`
+}
+
+// shareSessionSignature signs a share session. The password hash is part
+// of it so that changing the share's password ends its sessions.
+func shareSessionSignature(share *Share, passwordHash string, expires int64) string {
+	mac := hmac.New(sha256.New, sharedJWTSecret)
+	fmt.Fprintf(mac, "share-session\x00%s\x00%s\x00%s\x00%d", share.ID, share.Token, passwordHash, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newShareSession returns a session for a share opened now, valid for
+// shareSessionTTL or until the share expires
+func newShareSession(share *Share, passwordHash string, now time.Time) string {
+	expires := now.Add(shareSessionTTL)
+	if share.ExpiresAt != nil && share.ExpiresAt.Before(expires) {
+		expires = *share.ExpiresAt
+	}
+	return strconv.FormatInt(expires.Unix(), 10) + "." + shareSessionSignature(share, passwordHash, expires.Unix())
+}
+
+// validShareSession reports whether session is an unexpired session of
+// the share
+func validShareSession(session string, share *Share, passwordHash string, now time.Time) bool {
+	expiresStr, sig, ok := strings.Cut(session, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(shareSessionSignature(share, passwordHash, expires)))
+}
+
+// shareSessionParam returns the share session a request carries, if any
+func shareSessionParam(c echo.Context) string {
+	if session := c.Request().Header.Get(ShareSessionHeader); session != "" {
+		return session
+	}
+	return c.QueryParam("session")
+}
+
+// shareFileParam returns the file a share sub-request is about: the
+// wildcard of the /file/* and /preview/* routes, or the given query
+// parameter of the older routes
+func shareFileParam(c echo.Context, query string) string {
+	if relative := c.Param("*"); relative != "" {
+		if decoded, err := url.PathUnescape(relative); err == nil {
+			return decoded
+		}
+		return relative
+	}
+	return c.QueryParam(query)
+}
+
+// listShareFolder returns a page of a folder in a share, subpath being
+// relative to the share root. Hidden files, file links and symlinks are
+// left out, as they are never served through a share.
+func listShareFolder(shareRoot, subpath string, page, pageSize int) (*ShareFolderListing, *APIError) {
+	subpath = strings.Trim(filepath.ToSlash(subpath), "/")
+	dir, apiErr := sharePreviewPath(shareRoot, subpath)
+	if apiErr != nil {
+		return nil, ErrNotFound("Folder not found")
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return nil, ErrNotFound("Folder not found")
+	} else if !info.IsDir() {
+		return nil, ErrBadRequest("Path is not a folder")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, ErrInternal("Failed to read directory")
+	}
+
+	files := make([]FileInfo, 0, len(entries))
+	var totalSize int64
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || isFileLink(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+
+		entryPath := filepath.Join(dir, entry.Name())
+		encrypted := IsEncryptedEntry(entryPath, info)
+		item := FileInfo{
+			Name:      entry.Name(),
+			Path:      path.Join(subpath, entry.Name()),
+			Size:      info.Size(),
+			IsDir:     entry.IsDir(),
+			ModTime:   info.ModTime(),
+			Encrypted: encrypted,
+		}
+		if !entry.IsDir() {
+			if encrypted {
+				item.Size = encryptedPlainSize(item.Size)
+			}
+			item.Extension = strings.ToLower(strings.TrimPrefix(filepath.Ext(entry.Name()), "."))
+			item.MimeType = getMimeType(item.Extension)
+			item.PreviewAvailable = PreviewAvailable(entryPath, entry.Name(), info.ModTime())
+			totalSize += item.Size
+		}
+		files = append(files, item)
+	}
+
+	// Folders first, then by name
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].IsDir != files[j].IsDir {
+			return files[i].IsDir
+		}
+		return strings.ToLower(files[i].Name) < strings.ToLower(files[j].Name)
+	})
+
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = shareListingPageSize
+	}
+	if pageSize > shareListingMaxPageSize {
+		pageSize = shareListingMaxPageSize
+	}
+	total := len(files)
+	totalPages := (total + pageSize - 1) / pageSize
+	// Pages past the end are empty; page comes from a public query and is
+	// compared before multiplying so it can't overflow
+	start, end := total, total
+	if page <= totalPages {
+		start = (page - 1) * pageSize
+		end = min(start+pageSize, total)
+	}
+
+	return &ShareFolderListing{
+		Path:       subpath,
+		Files:      files[start:end],
+		Total:      total,
+		TotalSize:  totalSize,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: totalPages,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestShareSession(t *testing.T) {
+	if sharedJWTSecret == nil {
+		sharedJWTSecret = []byte("test-jwt-secret-for-testing-only-32chars")
+	}
+	now := time.Now()
+	share := &Share{ID: "s1", Token: "tok"}
+	session := newShareSession(share, "hash", now)
+
+	if !validShareSession(session, share, "hash", now) {
+		t.Fatal("fresh session should be valid")
+	}
+	if validShareSession(session, &Share{ID: "s2", Token: "tok2"}, "hash", now) {
+		t.Error("session of another share accepted")
+	}
+	if validShareSession(session, share, "new-hash", now) {
+		t.Error("session should end when the password changes")
+	}
+	if validShareSession(session, share, "hash", now.Add(shareSessionTTL+time.Minute)) {
+		t.Error("expired session accepted")
+	}
+	for _, crafted := range []string{"", "garbage", "9999999999.00", session + "0"} {
+		if validShareSession(crafted, share, "hash", now) {
+			t.Errorf("%q accepted", crafted)
+		}
+	}
+
+	// A session ends with its share
+	expires := now.Add(time.Hour)
+	short := newShareSession(&Share{ID: "s1", Token: "tok", ExpiresAt: &expires}, "hash", now)
+	if validShareSession(short, share, "hash", now.Add(2*time.Hour)) {
+		t.Error("session outlived the share")
+	}
+}
+
+func TestListShareFolder(t *testing.T) {
+	shareRoot := t.TempDir()
+	writeTestFiles(t, shareRoot, "b.jpg", "a.txt", "Trip/c.jpg", ".hidden", "Trip/.thumbs/c.jpg")
+
+	listing, apiErr := listShareFolder(shareRoot, "", 1, 0)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	var names []string
+	for _, f := range listing.Files {
+		names = append(names, f.Name)
+	}
+	if len(names) != 3 || names[0] != "Trip" || names[1] != "a.txt" || names[2] != "b.jpg" {
+		t.Errorf("files = %v", names)
+	}
+	if listing.Files[2].Extension != "jpg" || listing.Files[2].MimeType != "image/jpeg" {
+		t.Errorf("b.jpg = %+v", listing.Files[2])
+	}
+
+	// Paths stay relative to the share root
+	listing, apiErr = listShareFolder(shareRoot, "/Trip/", 1, 0)
+	if apiErr != nil || listing.Total != 1 || listing.Files[0].Path != "Trip/c.jpg" {
+		t.Errorf("Trip = %+v, %v", listing, apiErr)
+	}
+
+	// Paged
+	listing, _ = listShareFolder(shareRoot, "", 2, 2)
+	if listing.Total != 3 || listing.TotalPages != 2 || len(listing.Files) != 1 || listing.Files[0].Name != "b.jpg" {
+		t.Errorf("page 2 = %+v", listing)
+	}
+
+	// Pages past the end are empty, however far
+	for _, page := range []int{3, math.MaxInt} {
+		listing, apiErr = listShareFolder(shareRoot, "", page, 2)
+		if apiErr != nil || listing.Total != 3 || len(listing.Files) != 0 {
+			t.Errorf("page %d = %+v, %v", page, listing, apiErr)
+		}
+	}
+
+	for _, crafted := range []string{"../Photos", "Trip/../../Photos", "Trip/.thumbs", "a.txt"} {
+		if _, apiErr := listShareFolder(shareRoot, crafted, 1, 0); apiErr == nil {
+			t.Errorf("%q should be refused", crafted)
+		}
+	}
+}
+
+func TestDownloadShareFile_Session(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	writeTestFiles(t, dataRoot, "shared/Photos/Trip/beach.jpg")
+	h := &ShareHandler{db: tc.DB, dataRoot: dataRoot, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	session := newShareSession(&Share{ID: "s1", Token: "tok"}, string(passwordHash), time.Now())
+
+	// The one access the share allows has been used by opening it
+	expectShare := func(expiresAt interface{}) {
+		tc.Mock.ExpectQuery("FROM shares").WithArgs("tok").WillReturnRows(sqlmock.NewRows([]string{
+			"id", "token", "path", "created_by", "expires_at", "password_hash", "access_count", "max_access",
+			"is_active", "require_login", "share_type",
+		}).AddRow("s1", "tok", "shared/Photos", "u1", expiresAt, string(passwordHash), 1, 1, true, false, "download"))
+	}
+	download := func(file, session string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/s/tok/file/"+file, nil)
+		if session != "" {
+			req.Header.Set(ShareSessionHeader, session)
+		}
+		c := tc.Echo.NewContext(req, rec)
+		c.SetParamNames("token", "*")
+		c.SetParamValues("tok", file)
+		if err := h.DownloadShareFile(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	// Without the session the access limit applies
+	expectShare(nil)
+	AssertStatus(t, download("Trip/beach.jpg", ""), http.StatusGone)
+
+	// The session stands in for the password and continues the visit
+	expectShare(nil)
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	rec := download("Trip%2Fbeach.jpg", session)
+	AssertStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "shared/Photos/Trip/beach.jpg" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+
+	// Nothing outside the share, and expiry still applies
+	expectShare(nil)
+	AssertStatus(t, download("../Photos/../secret.jpg", session), http.StatusNotFound)
+	expectShare(time.Now().Add(-time.Hour))
+	AssertStatus(t, download("Trip/beach.jpg", session), http.StatusGone)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
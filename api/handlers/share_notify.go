@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// notifyShareActivity tells the creator of a share that someone used the
+// link. The notification center always gets it; when the share has
+// notify_email set, SMTP is configured and the creator has an address, it is
+// emailed as well. Both happen in the background so the download or upload
+// is never held up.
+func notifyShareActivity(db *sql.DB, ns *NotificationService, token, ownerID, notifType, title, message, link string, actorID *string, metadata map[string]interface{}) {
+	if ns == nil || ownerID == "" {
+		return
+	}
+	ns.Send(ownerID, notifType, title, message, link, actorID, metadata)
+	if !GetMailer().Configured() {
+		return
+	}
+
+	go func() {
+		var notifyEmail bool
+		var email string
+		err := db.QueryRow(`
+			SELECT s.notify_email, COALESCE(u.email, '')
+			FROM shares s
+			JOIN users u ON u.id = s.created_by
+			WHERE s.token = $1
+		`, token).Scan(&notifyEmail, &email)
+		if err != nil {
+			if err != sql.ErrNoRows {
+				log.Printf("[Share] Failed to look up email notification for share %s: %v", token, err)
+			}
+			return
+		}
+		if !notifyEmail || email == "" {
+			return
+		}
+		text, htmlBody := renderShareActivityMail(message, metadata)
+		if err := GetMailer().Send(Mail{To: email, Subject: title, Text: text, HTML: htmlBody}); err != nil {
+			log.Printf("[Share] Failed to email share activity to %s: %v", ownerID, err)
+		}
+	}()
+}
+
+// renderShareActivityMail renders the message of a share notification with
+// the file, size and client IP from its metadata
+func renderShareActivityMail(message string, metadata map[string]interface{}) (string, string) {
+	var lines [][2]string
+	if name, ok := metadata["filename"].(string); ok && name != "" {
+		lines = append(lines, [2]string{"File", name})
+	}
+	if size, ok := metadata["size"].(int64); ok {
+		lines = append(lines, [2]string{"Size", formatFileSize(size)})
+	}
+	if ip, ok := metadata["clientIP"].(string); ok && ip != "" {
+		lines = append(lines, [2]string{"Client IP", ip})
+	}
+
+	var text, body strings.Builder
+	text.WriteString(message + "\n\n")
+	fmt.Fprintf(&body, "<p>%s</p><table>", html.EscapeString(message))
+	for _, line := range lines {
+		fmt.Fprintf(&text, "%s: %s\n", line[0], line[1])
+		fmt.Fprintf(&body, "<tr><td>%s</td><td>%s</td></tr>", line[0], html.EscapeString(line[1]))
+	}
+	body.WriteString("</table>")
+	return text.String(), body.String()
+}
+
+// shareLinkMaxRecipients bounds the addresses a new link is mailed to
+const shareLinkMaxRecipients = 20
+
+// shareLinkMail is a share link mailed from CreateShare
+type shareLinkMail struct {
+	Sender      string
+	Name        string
+	URL         string
+	ShareType   string
+	Message     string
+	HasPassword bool
+	ExpiresAt   *time.Time
+}
+
+// validateShareEmailRecipients checks the addresses a new link is mailed
+// to, before the share is created, and returns them cleaned up
+func validateShareEmailRecipients(recipients []string, message string) ([]string, *APIError) {
+	var cleaned []string
+	seen := map[string]bool{}
+	for _, to := range recipients {
+		to = strings.TrimSpace(to)
+		if to == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, ErrBadRequest(fmt.Sprintf("Invalid email address: %s", to))
+		}
+		if key := strings.ToLower(addr.Address); !seen[key] {
+			seen[key] = true
+			cleaned = append(cleaned, addr.Address)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil, nil
+	}
+	if len(cleaned) > shareLinkMaxRecipients {
+		return nil, ErrBadRequest(fmt.Sprintf("A link can be emailed to at most %d addresses", shareLinkMaxRecipients))
+	}
+	if len(message) > 2000 {
+		return nil, ErrBadRequest("Email message is too long (2000 characters at most)")
+	}
+	if !GetMailer().Configured() {
+		return nil, ErrBadRequest("SMTP is not configured")
+	}
+	return cleaned, nil
+}
+
+// emailShareLink mails a new share link in the background
+func (h *ShareHandler) emailShareLink(link shareLinkMail, recipients []string) {
+	subject, text, body := renderShareLinkMail(link)
+	go func() {
+		for _, to := range recipients {
+			if err := GetMailer().Send(Mail{To: to, Subject: subject, Text: text, HTML: body}); err != nil {
+				log.Printf("[Share] Failed to email link for %s to %s: %v", link.Name, to, err)
+			}
+		}
+	}()
+}
+
+// renderShareLinkMail renders the mail of a share link
+func renderShareLinkMail(link shareLinkMail) (subject, text, body string) {
+	action := "shared"
+	if link.ShareType == "upload" {
+		action = "asks you to upload files to"
+	}
+	subject = fmt.Sprintf("%s %s \"%s\"", link.Sender, action, link.Name)
+	var notes []string
+	if link.HasPassword {
+		notes = append(notes, "The link is protected by a password; ask the sender for it.")
+	}
+	if link.ExpiresAt != nil {
+		notes = append(notes, "The link expires on "+link.ExpiresAt.UTC().Format("2006-01-02 15:04 MST")+".")
+	}
+
+	var t, b strings.Builder
+	fmt.Fprintf(&t, "%s %s \"%s\" with FileHatch.\n\n", link.Sender, action, link.Name)
+	fmt.Fprintf(&b, "<p>%s %s &quot;%s&quot; with FileHatch.</p>",
+		html.EscapeString(link.Sender), action, html.EscapeString(link.Name))
+	if link.Message != "" {
+		fmt.Fprintf(&t, "%s\n\n", link.Message)
+		fmt.Fprintf(&b, "<blockquote>%s</blockquote>", strings.ReplaceAll(html.EscapeString(link.Message), "\n", "<br>"))
+	}
+	fmt.Fprintf(&t, "%s\n", link.URL)
+	fmt.Fprintf(&b, "<p><a href=\"%s\">%s</a></p>", html.EscapeString(link.URL), html.EscapeString(link.URL))
+	for _, note := range notes {
+		fmt.Fprintf(&t, "\n%s", note)
+		fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(note))
+	}
+	return subject, t.String(), b.String()
+}
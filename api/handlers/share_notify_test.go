@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNotifyShareActivity_EmailsWhenEnabled(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	// Notifications go to a database of their own that fails every query,
+	// so the mock only sees the email lookup
+	notifDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifDB.Close()
+
+	mails := make(chan string, 1)
+	previous := globalMailer
+	globalMailer = &Mailer{host: "smtp.example.com", port: "587", from: "fh@example.com",
+		send: func(from string, to []string, msg []byte) error {
+			mails <- to[0] + "\n" + string(msg)
+			return nil
+		}}
+	defer func() { globalMailer = previous }()
+
+	tc.Mock.ExpectQuery("SELECT s.notify_email").
+		WithArgs("tok").
+		WillReturnRows(sqlmock.NewRows([]string{"notify_email", "email"}).AddRow(true, "alice@example.com"))
+
+	notifyShareActivity(tc.DB, NewNotificationService(notifDB), "tok", "u-alice", NotifUploadLinkReceived,
+		"File received", "Someone uploaded 'report.pdf'", "/home/inbox", nil,
+		map[string]interface{}{"filename": "report.pdf", "size": int64(2048), "clientIP": "203.0.113.7"})
+
+	select {
+	case mail := <-mails:
+		if !strings.HasPrefix(mail, "alice@example.com\n") || !strings.Contains(mail, "File received") {
+			t.Errorf("mail %q", mail)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no email sent")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotifyShareActivity_NoLookupWithoutSMTP(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	notifDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifDB.Close()
+	previous := globalMailer
+	globalMailer = nil
+	defer func() { globalMailer = previous }()
+
+	notifyShareActivity(tc.DB, NewNotificationService(notifDB), "tok", "u-alice", NotifShareLinkAccessed,
+		"Link used", "Someone downloaded 'report.pdf'", "/shared-by-me", nil, nil)
+	time.Sleep(50 * time.Millisecond)
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRenderShareActivityMail(t *testing.T) {
+	text, html := renderShareActivityMail("Someone downloaded '<b>.pdf'", map[string]interface{}{
+		"filename": "<b>.pdf",
+		"size":     int64(1536),
+		"clientIP": "198.51.100.1",
+	})
+	for _, want := range []string{"File: <b>.pdf", "Client IP: 198.51.100.1", "Size: "} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(html, "<b>") || !strings.Contains(html, "&lt;b&gt;.pdf") {
+		t.Errorf("HTML not escaped: %s", html)
+	}
+}
+
+func TestUpdateShare_NotifyEmailOnDownloadShare(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &ShareHandler{db: tc.DB, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	alice := &JWTClaims{UserID: "u-alice", Username: "alice"}
+
+	update := func(body UpdateShareRequest) int {
+		req, _ := NewJSONRequest(http.MethodPut, "/api/shares/s-1", body)
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(req, rec)
+		c.Set("user", alice)
+		c.SetParamNames("id")
+		c.SetParamValues("s-1")
+		if err := h.UpdateShare(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+	expectShare := func() {
+		tc.Mock.ExpectQuery("SELECT path, share_type, upload_count, total_uploaded_size").
+			WithArgs("s-1", "u-alice").
+			WillReturnRows(updateShareRows("users/alice/report.pdf", "download", 0, 0))
+	}
+
+	enabled := true
+	expectShare()
+	tc.Mock.ExpectExec(`UPDATE shares SET notify_email = \$1 WHERE id = \$2 AND created_by = \$3`).
+		WithArgs(true, "s-1", "u-alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("u-alice", sqlmock.AnyArg(), EventShareUpdate, "users/alice/report.pdf", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if code := update(UpdateShareRequest{NotifyEmail: &enabled}); code != http.StatusOK {
+		t.Errorf("notifyEmail: status %d", code)
+	}
+
+	// Upload limits still only apply to upload shares
+	expectShare()
+	maxFileSize := int64(1024)
+	if code := update(UpdateShareRequest{NotifyEmail: &enabled, MaxFileSize: &maxFileSize}); code != http.StatusBadRequest {
+		t.Errorf("maxFileSize on a download share: status %d", code)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateShareEmailRecipients(t *testing.T) {
+	previous := globalMailer
+	globalMailer = nil
+	defer func() { globalMailer = previous }()
+
+	if to, err := validateShareEmailRecipients([]string{" ", ""}, ""); err != nil || to != nil {
+		t.Errorf("blank recipients: %v, %v", to, err)
+	}
+	if _, err := validateShareEmailRecipients([]string{"bob@example.com"}, ""); err == nil {
+		t.Error("expected an error without SMTP")
+	}
+
+	useTestMailer(t)
+	to, err := validateShareEmailRecipients([]string{"Bob <bob@example.com>", "BOB@example.com", "carol@example.com"}, "")
+	if err != nil || len(to) != 2 || to[0] != "bob@example.com" || to[1] != "carol@example.com" {
+		t.Errorf("recipients %v, %v", to, err)
+	}
+	if _, err := validateShareEmailRecipients([]string{"bob"}, ""); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
+
+func TestRenderShareLinkMail(t *testing.T) {
+	expires := time.Date(2026, 11, 1, 9, 0, 0, 0, time.UTC)
+	subject, text, html := renderShareLinkMail(shareLinkMail{
+		Sender:      "alice",
+		Name:        "Q3 <final>.xlsx",
+		URL:         "https://files.example.com/s/abc",
+		ShareType:   "download",
+		Message:     "Numbers for Monday",
+		HasPassword: true,
+		ExpiresAt:   &expires,
+	})
+	if subject != `alice shared "Q3 <final>.xlsx"` {
+		t.Errorf("subject %q", subject)
+	}
+	for _, want := range []string{"https://files.example.com/s/abc", "Numbers for Monday", "ask the sender", "2026-11-01 09:00 UTC"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(html, "<final>") {
+		t.Errorf("HTML not escaped: %s", html)
+	}
+}
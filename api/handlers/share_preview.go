@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Share previews. Recipients of a share can look at images, videos, audio
+// and PDFs before downloading them: the listing marks previewable entries,
+// and GET /api/s/:token/preview serves a file's cached thumbnail or a
+// range-capable stream. Every request is validated like a download, but
+// previews are counted in preview_count instead of access_count and
+// download statistics, so they don't use up max_access. Hidden files and
+// file links are never served, whatever path is asked for.
+
+// Share preview kinds
+const (
+	SharePreviewImage = "image"
+	SharePreviewVideo = "video"
+	SharePreviewAudio = "audio"
+	SharePreviewPDF   = "pdf"
+)
+
+// sharePreviewKind returns how a file can be previewed on a share page, or
+// "" if it can't
+func sharePreviewKind(name string) string {
+	if strings.HasPrefix(name, ".") || isFileLink(name) {
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if supportedImageExts[ext] || isConvertedImage(ext) {
+		return SharePreviewImage
+	}
+	mimeType := getMimeType(strings.TrimPrefix(ext, "."))
+	switch {
+	case strings.HasPrefix(mimeType, "video/"):
+		return SharePreviewVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return SharePreviewAudio
+	case mimeType == "application/pdf":
+		return SharePreviewPDF
+	}
+	return ""
+}
+
+// sharePreviewPath resolves a path relative to the share root. Hidden
+// components, file links and symlinks leading out of the share are refused.
+func sharePreviewPath(shareRoot, relative string) (string, *APIError) {
+	if relative == "" {
+		return shareRoot, nil
+	}
+	clean := filepath.Clean("/" + filepath.ToSlash(relative))
+	for _, part := range strings.Split(strings.TrimPrefix(clean, "/"), "/") {
+		if part == ".." || strings.HasPrefix(part, ".") {
+			return "", ErrNotFound("File not found")
+		}
+	}
+	fullPath := filepath.Join(shareRoot, clean)
+
+	resolvedRoot, err := filepath.EvalSymlinks(shareRoot)
+	if err != nil {
+		return "", ErrNotFound("File not found")
+	}
+	resolved, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return "", ErrNotFound("File not found")
+	}
+	if resolved != resolvedRoot && !strings.HasPrefix(resolved, resolvedRoot+string(filepath.Separator)) {
+		return "", ErrNotFound("File not found")
+	}
+	return fullPath, nil
+}
+
+// openPublicShare loads a download or edit share and applies the checks of
+// a download: active, not expired, access limit, login and password (the
+// password query parameter). A share session from opening the share stands
+// in for the password and lifts the access limit, the visit having been
+// counted already. It returns nil and the written response if the request
+// is refused.
+func (h *ShareHandler) openPublicShare(c echo.Context, token string) (*Share, error) {
+	var share Share
+	var passwordHash sql.NullString
+	var expiresAt sql.NullTime
+	var maxAccess sql.NullInt32
+
+	err := h.db.QueryRow(`
+		SELECT id, token, path, COALESCE(created_by::text, created_by_pseudonym, '') AS created_by, expires_at,
+		       password_hash, access_count, max_access, is_active, require_login, share_type
+		FROM shares
+		WHERE token = $1
+	`, token).Scan(&share.ID, &share.Token, &share.Path, &share.CreatedBy,
+		&expiresAt, &passwordHash, &share.AccessCount, &maxAccess, &share.IsActive, &share.RequireLogin, &share.ShareType)
+	if err == sql.ErrNoRows || (err == nil && share.ShareType == "upload") {
+		return nil, RespondError(c, ErrNotFound("Share not found"))
+	}
+	if err != nil {
+		return nil, RespondError(c, ErrInternal("Database error"))
+	}
+
+	if !share.IsActive {
+		return nil, c.JSON(http.StatusGone, map[string]string{"error": "Share is no longer available"})
+	}
+	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
+		return nil, c.JSON(http.StatusGone, map[string]string{"error": "Share has expired"})
+	}
+	inSession := validShareSession(shareSessionParam(c), &share, passwordHash.String, time.Now())
+	if !inSession && maxAccess.Valid && share.AccessCount >= int(maxAccess.Int32) {
+		return nil, c.JSON(http.StatusGone, map[string]string{"error": "Access limit reached"})
+	}
+
+	if share.RequireLogin {
+		claims, _ := c.Get("user").(*JWTClaims)
+		if claims == nil {
+			return nil, RespondError(c, ErrUnauthorized("Login required"))
+		}
+	}
+
+	if passwordHash.Valid && !inSession {
+		password := c.QueryParam("password")
+		if password == "" {
+			return nil, RespondError(c, ErrUnauthorized("Password required"))
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash.String), []byte(password)); err != nil {
+			h.logSharePasswordFailure(c, share.Path, token)
+			return nil, RespondError(c, ErrUnauthorized("Invalid password"))
+		}
+	}
+	share.HasPassword = passwordHash.Valid
+	return &share, nil
+}
+
+// PreviewShareFile serves a preview of a file in a share
+// @Summary		Preview shared file
+// @Description	Serves an image, video, audio or PDF file of a share for viewing on the share page. With size, the cached thumbnail (JPEG) of an image or video; otherwise the file itself with Range support (HEIC, PSD and RAW as a converted JPEG). The share is validated like a download on every request. Previews count toward the share's previewCount, not its access count or download statistics; thumbnails and range requests continuing a stream are not counted.
+// @Tags		Shares
+// @Produce		octet-stream
+// @Param		token		path	string	true	"Share token"
+// @Param		file		query	string	false	"File path within a shared folder; omit for a file share. The /preview/{file} form takes it from the URL path."
+// @Param		size		query	string	false	"Thumbnail size: small, medium or large"
+// @Param		password	query	string	false	"Share password if required"
+// @Param		session		query	string	false	"Share session from opening the share, instead of the password (or the X-Share-Session header)"
+// @Success		200		{file}		binary
+// @Success		206		{file}		binary	"Partial content"
+// @Failure		400		{object}	map[string]string	"Not previewable"
+// @Failure		404		{object}	map[string]string	"Share or file not found"
+// @Failure		410		{object}	map[string]string	"Share expired or inactive"
+// @Router		/s/{token}/preview [get]
+// @Router		/s/{token}/preview/{file} [get]
+func (h *ShareHandler) PreviewShareFile(c echo.Context) error {
+	token := c.Param("token")
+	share, err := h.openPublicShare(c, token)
+	if share == nil {
+		return err
+	}
+
+	relative := shareFileParam(c, "file")
+	fullPath, apiErr := sharePreviewPath(filepath.Join(h.dataRoot, share.Path), relative)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	info, statErr := os.Stat(fullPath)
+	if statErr != nil {
+		return RespondError(c, ErrNotFound("File not found"))
+	}
+	if info.IsDir() {
+		return RespondError(c, ErrBadRequest("Path is a directory"))
+	}
+	kind := sharePreviewKind(info.Name())
+	if kind == "" {
+		return RespondError(c, ErrBadRequest("This file cannot be previewed"))
+	}
+
+	etag := GenerateETag(fullPath+c.QueryParam("size"), info.ModTime(), info.Size())
+	if !CheckETag(c.Request(), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	if sizeName := c.QueryParam("size"); sizeName != "" {
+		size, ok := ThumbnailSizes[sizeName]
+		if !ok {
+			return RespondError(c, ErrBadRequest("Invalid size. Use: small, medium, or large"))
+		}
+		if kind != SharePreviewImage && kind != SharePreviewVideo {
+			return RespondError(c, ErrBadRequest("No thumbnail for this file type"))
+		}
+		data, _, err := cachedThumbnail(fullPath, info, size, "jpeg")
+		if err != nil {
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{
+				"error": "Preview not available for this file",
+			})
+		}
+		SetCacheHeaders(c.Response().Writer, etag, 3600)
+		return c.Blob(http.StatusOK, "image/jpeg", data)
+	}
+
+	if countedSharePreview(c.Request()) {
+		h.recordSharePreview(c, share, relative, info)
+	}
+
+	SetCacheHeaders(c.Response().Writer, etag, 3600)
+	c.Response().Header().Set("Content-Disposition", "inline")
+	if isConvertedImage(strings.ToLower(filepath.Ext(info.Name()))) {
+		data, ok := convertedPreview(fullPath, info)
+		if !ok {
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{
+				"error": "Preview not available for this file",
+			})
+		}
+		return c.Blob(http.StatusOK, "image/jpeg", data)
+	}
+	if info.Size() >= ActivityMinDownloadSize {
+		defer TrackResponse(c, ActivityDownload, filepath.Join(share.Path, relative), token, info.Size()).Finish()
+	}
+	return ServePlainFile(c, fullPath)
+}
+
+// countedSharePreview reports whether a preview request opens a file, as
+// opposed to a range request continuing a stream that is already playing
+func countedSharePreview(r *http.Request) bool {
+	rangeHeader := r.Header.Get("Range")
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// recordSharePreview counts a preview and logs it, apart from downloads
+func (h *ShareHandler) recordSharePreview(c echo.Context, share *Share, relative string, info os.FileInfo) {
+	_, _ = h.db.Exec("UPDATE shares SET preview_count = preview_count + 1 WHERE id = $1", share.ID)
+
+	details := map[string]interface{}{
+		"action":   "preview",
+		"token":    share.Token,
+		"filename": info.Name(),
+		"size":     info.Size(),
+	}
+	if relative != "" {
+		details["filepath"] = relative
+	}
+	h.logShareEvent(c, EventShareAccess, share.Path, details)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSharePreviewKind(t *testing.T) {
+	cases := map[string]string{
+		"IMG_0001.JPG": SharePreviewImage,
+		"photo.heic":   SharePreviewImage,
+		"clip.mp4":     SharePreviewVideo,
+		"song.mp3":     SharePreviewAudio,
+		"report.pdf":   SharePreviewPDF,
+		"notes.txt":    "",
+		".hidden.jpg":  "",
+	}
+	for name, want := range cases {
+		if got := sharePreviewKind(name); got != want {
+			t.Errorf("sharePreviewKind(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSharePreviewPath(t *testing.T) {
+	root := t.TempDir()
+	shareRoot := filepath.Join(root, "shared", "Photos")
+	writeTestFiles(t, shareRoot, "a/b.jpg", ".thumbs/b.jpg")
+	writeTestFiles(t, root, "shared/secret.jpg")
+	_ = os.Symlink(filepath.Join(root, "shared", "secret.jpg"), filepath.Join(shareRoot, "escape.jpg"))
+
+	if got, apiErr := sharePreviewPath(shareRoot, "a/b.jpg"); apiErr != nil || got != filepath.Join(shareRoot, "a", "b.jpg") {
+		t.Errorf("a/b.jpg = %q, %v", got, apiErr)
+	}
+	for _, crafted := range []string{"../secret.jpg", "a/../../secret.jpg", ".thumbs/b.jpg", "a/../.thumbs/b.jpg", "escape.jpg"} {
+		if _, apiErr := sharePreviewPath(shareRoot, crafted); apiErr == nil {
+			t.Errorf("%q should be refused", crafted)
+		}
+	}
+}
+
+func TestPreviewShareFile(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	writeTestFiles(t, dataRoot, "shared/Photos/beach.jpg", "shared/Photos/notes.txt")
+	h := &ShareHandler{db: tc.DB, dataRoot: dataRoot, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+
+	preview := func(query, rangeHeader string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/s/tok/preview?"+query, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		c := tc.Echo.NewContext(req, rec)
+		c.SetParamNames("token")
+		c.SetParamValues("tok")
+		if err := h.PreviewShareFile(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	expectShare := func(expiresAt interface{}) {
+		tc.Mock.ExpectQuery("FROM shares").WithArgs("tok").WillReturnRows(sqlmock.NewRows([]string{
+			"id", "token", "path", "created_by", "expires_at", "password_hash", "access_count", "max_access",
+			"is_active", "require_login", "share_type",
+		}).AddRow("s1", "tok", "shared/Photos", "u1", expiresAt, nil, 0, nil, true, false, "download"))
+	}
+
+	// Opening a file counts a preview
+	expectShare(nil)
+	tc.Mock.ExpectExec("UPDATE shares SET preview_count").WithArgs("s1").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	rec := preview("file=beach.jpg", "")
+	AssertStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "shared/Photos/beach.jpg" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+
+	// Continuing a stream is served as a range and not counted again
+	expectShare(nil)
+	AssertStatus(t, preview("file=beach.jpg", "bytes=7-"), http.StatusPartialContent)
+
+	// Hidden paths and other file types are never previewed
+	expectShare(nil)
+	AssertStatus(t, preview("file=../Photos/.beach.jpg", ""), http.StatusNotFound)
+	expectShare(nil)
+	AssertStatus(t, preview("file=notes.txt", ""), http.StatusBadRequest)
+
+	// The share is validated on every request
+	expectShare(time.Now().Add(-time.Hour))
+	AssertStatus(t, preview("file=beach.jpg", ""), http.StatusGone)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDownloadShare_AuditsDownloadsAndWrongPasswords(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	writeTestFiles(t, dataRoot, "users/alice/finance.xlsx")
+	h := &ShareHandler{db: tc.DB, dataRoot: dataRoot, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+
+	download := func(password, event string) *httptest.ResponseRecorder {
+		tc.Mock.ExpectQuery("FROM shares").WithArgs("tok").WillReturnRows(sqlmock.NewRows([]string{
+			"id", "token", "path", "created_by", "expires_at", "password_hash", "access_count", "max_access",
+			"is_active", "require_login", "share_type",
+		}).AddRow("s1", "tok", "users/alice/finance.xlsx", "u1", nil, string(passwordHash), 0, nil, true, false, "download"))
+		tc.Mock.ExpectExec("INSERT INTO audit_logs").
+			WithArgs(nil, "203.0.113.7", event, "users/alice/finance.xlsx", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		req := httptest.NewRequest(http.MethodGet, "/api/s/tok/download?password="+password, nil)
+		req.RemoteAddr = "203.0.113.7:50000"
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(req, rec)
+		c.SetParamNames("token")
+		c.SetParamValues("tok")
+		_ = h.DownloadShare(c)
+		return rec
+	}
+
+	// A wrong password is recorded with the anonymous client's IP
+	if rec := download("guess", EventShareAccess); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d", rec.Code)
+	}
+
+	// A download is a share.download event
+	if rec := download("s3cret", EventShareDownload); rec.Code != http.StatusOK {
+		t.Errorf("download: status %d", rec.Code)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeactivateExpiredShares(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+
+	tc.Mock.ExpectQuery("UPDATE shares SET is_active = FALSE").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "token", "path", "created_by", "share_type", "expires_at", "access_count"}).
+			AddRow("s1", "tok", "users/alice/finance.xlsx", "u1", "download", time.Now().Add(-time.Minute), 3))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs(nil, "0.0.0.0", EventShareExpire, "users/alice/finance.xlsx", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	checker := &ShareExpirationChecker{db: tc.DB, auditHandler: &AuditHandler{db: tc.DB}}
+	checker.deactivateExpiredShares()
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// capturedJSON matches a JSON argument and keeps it
+type capturedJSON struct{ value *string }
+
+func (a capturedJSON) Match(v driver.Value) bool {
+	switch v := v.(type) {
+	case []byte:
+		*a.value = string(v)
+	case string:
+		*a.value = v
+	default:
+		return false
+	}
+	return true
+}
+
+func TestUpdateShare_ExpiryPasswordAndMaxAccess(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &ShareHandler{db: tc.DB, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	alice := &JWTClaims{UserID: "u-alice", Username: "alice"}
+
+	update := func(body UpdateShareRequest) int {
+		req, _ := NewJSONRequest(http.MethodPut, "/api/shares/s-1", body)
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(req, rec)
+		c.Set("user", alice)
+		c.SetParamNames("id")
+		c.SetParamValues("s-1")
+		if err := h.UpdateShare(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code
+	}
+	// A paused download share, expired an hour ago, opened 5 times of 5
+	expectShare := func() {
+		tc.Mock.ExpectQuery("SELECT path, share_type, upload_count, total_uploaded_size").
+			WithArgs("s-1", "u-alice").
+			WillReturnRows(sqlmock.NewRows([]string{"path", "share_type", "upload_count", "total_uploaded_size", "access_count", "expires_at",
+				"has_password", "max_access", "is_active", "max_file_size", "allowed_extensions", "max_total_size", "notify_email"}).
+				AddRow("users/alice/report.pdf", "download", 0, 0, 5, time.Now().Add(-time.Hour), true, 5, false, 0, "", 0, false))
+	}
+	resume, maxAccess, password, expiresIn := true, 3, "n3w-pass", 48
+
+	// The limit can't drop below the accesses made
+	expectShare()
+	if code := update(UpdateShareRequest{MaxAccess: &maxAccess}); code != http.StatusBadRequest {
+		t.Errorf("maxAccess below access count: status %d", code)
+	}
+
+	// An expired share can't be resumed without a new expiry
+	expectShare()
+	if code := update(UpdateShareRequest{IsActive: &resume}); code != http.StatusBadRequest {
+		t.Errorf("resume while expired: status %d", code)
+	}
+
+	// Extending, resuming and changing the password keep the share's row
+	var details string
+	maxAccess = 10
+	expectShare()
+	tc.Mock.ExpectExec(`UPDATE shares SET is_active = \$1, expires_at = \$2, expiration_notified = FALSE, password_hash = \$3, max_access = \$4 WHERE id = \$5 AND created_by = \$6`).
+		WithArgs(true, sqlmock.AnyArg(), sqlmock.AnyArg(), 10, "s-1", "u-alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("u-alice", sqlmock.AnyArg(), EventShareUpdate, "users/alice/report.pdf", capturedJSON{&details}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if code := update(UpdateShareRequest{IsActive: &resume, ExpiresIn: &expiresIn, Password: &password, MaxAccess: &maxAccess}); code != http.StatusOK {
+		t.Errorf("update: status %d", code)
+	}
+
+	// The audit event has old and new values, but no password hash
+	var logged map[string]json.RawMessage
+	_ = json.Unmarshal([]byte(details), &logged)
+	change := func(key string) map[string]interface{} {
+		var values map[string]interface{}
+		_ = json.Unmarshal(logged[key], &values)
+		return values
+	}
+	if change("isActive")["old"] != false || change("isActive")["new"] != true ||
+		change("maxAccess")["old"] != float64(5) || change("maxAccess")["new"] != float64(10) ||
+		change("password")["old"] != true || change("password")["new"] != true ||
+		change("expiresAt")["old"] == nil || change("expiresAt")["new"] == nil {
+		t.Errorf("audit details = %s", details)
+	}
+	if strings.Contains(details, "$2a$") {
+		t.Errorf("password hash audited: %s", details)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Share token prefixes identify the share type a token belongs to.
+// Tokens created before prefixes were introduced are 32 hex characters
+// without a prefix and are still accepted on every share route.
+const (
+	ShareTokenPrefixDownload = "sh_"
+	ShareTokenPrefixUpload   = "up_"
+	ShareTokenPrefixEdit     = "ed_"
+)
+
+const (
+	// shareTokenMinChars is the shortest random part we generate:
+	// 22 base64url characters carry 132 bits of entropy
+	shareTokenMinChars = 22
+	// shareTokenMaxLength is the size of the shares.token column
+	shareTokenMaxLength = 64
+	// shareTokenMinLength rejects tokens no generator ever produced
+	shareTokenMinLength = 16
+	// shareTokenInsertAttempts bounds retries on a token collision
+	shareTokenInsertAttempts = 5
+)
+
+// shareTokenPrefix returns the token prefix for a share type
+func shareTokenPrefix(shareType string) string {
+	switch shareType {
+	case "upload":
+		return ShareTokenPrefixUpload
+	case "edit":
+		return ShareTokenPrefixEdit
+	default:
+		return ShareTokenPrefixDownload
+	}
+}
+
+// GenerateShareToken generates a URL-safe share token with the type prefix.
+// The random part is at least 22 characters (128+ bits) and can be made
+// longer with the share_token_min_length setting.
+func GenerateShareToken(shareType string) (string, error) {
+	prefix := shareTokenPrefix(shareType)
+	chars := shareTokenMinChars
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		chars = max(chars, settings.GetShareTokenMinLength())
+	}
+	chars = min(chars, shareTokenMaxLength-len(prefix))
+
+	// Each base64url character encodes 6 bits
+	token, err := GenerateURLSafeToken((chars*6 + 7) / 8)
+	if err != nil {
+		return "", err
+	}
+	return prefix + token[:chars], nil
+}
+
+// isShareTokenCollision reports whether an insert failed on the unique token constraint
+func isShareTokenCollision(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && strings.Contains(pqErr.Constraint, "token")
+}
+
+// insertShareWithToken generates a token and runs insert with it, retrying
+// with a fresh token when the insert collides with an existing one
+func insertShareWithToken(shareType string, insert func(token string) error) (string, error) {
+	for attempt := 0; attempt < shareTokenInsertAttempts; attempt++ {
+		token, err := GenerateShareToken(shareType)
+		if err != nil {
+			return "", err
+		}
+		err = insert(token)
+		if err == nil {
+			return token, nil
+		}
+		if !isShareTokenCollision(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("failed to generate a unique share token after %d attempts", shareTokenInsertAttempts)
+}
+
+// isWellFormedShareToken checks the length and charset of a token and, for
+// prefixed tokens, that the prefix is one the route serves. Legacy tokens
+// without a prefix pass on any route.
+func isWellFormedShareToken(token string, allowedPrefixes []string) bool {
+	if len(token) < shareTokenMinLength || len(token) > shareTokenMaxLength {
+		return false
+	}
+	for i := 0; i < len(token); i++ {
+		ch := token[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+			return false
+		}
+	}
+	for _, prefix := range []string{ShareTokenPrefixDownload, ShareTokenPrefixUpload, ShareTokenPrefixEdit} {
+		if strings.HasPrefix(token, prefix) {
+			return slices.Contains(allowedPrefixes, prefix)
+		}
+	}
+	return true
+}
+
+// ShareTokenGuard rejects malformed tokens before any database lookup.
+// allowedPrefixes are the token prefixes the route serves.
+func ShareTokenGuard(allowedPrefixes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !isWellFormedShareToken(c.Param("token"), allowedPrefixes) {
+				return RespondError(c, ErrNotFound("Share not found"))
+			}
+			return next(c)
+		}
+	}
+}
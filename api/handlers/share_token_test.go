@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestGenerateShareToken(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token, err := GenerateShareToken("upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(token, ShareTokenPrefixUpload) || len(token) != len(ShareTokenPrefixUpload)+shareTokenMinChars {
+			t.Fatalf("unexpected token %q", token)
+		}
+		if !isWellFormedShareToken(token, []string{ShareTokenPrefixUpload}) {
+			t.Fatalf("generated token %q is not well formed", token)
+		}
+		if seen[token] {
+			t.Fatalf("duplicate token %q", token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestIsWellFormedShareToken(t *testing.T) {
+	shareRoute := []string{ShareTokenPrefixDownload, ShareTokenPrefixEdit}
+	tests := map[string]bool{
+		"0123456789abcdef0123456789abcdef": true, // legacy hex token
+		"sh_AbCdEfGhIjKlMnOpQrStUv":        true,
+		"ed_AbCdEfGhIjKlMnOpQrStUv":        true,
+		"up_AbCdEfGhIjKlMnOpQrStUv":        false, // upload token on a share route
+		"short":                            false,
+		"sh_AbCdEfGh.jKlMnOpQrStUv":        false,
+		"' OR 1=1 --":                      false,
+		strings.Repeat("a", 65):            false,
+	}
+	for token, want := range tests {
+		if got := isWellFormedShareToken(token, shareRoute); got != want {
+			t.Errorf("isWellFormedShareToken(%q) = %v, want %v", token, got, want)
+		}
+	}
+}
+
+func TestInsertShareWithToken_RetriesCollision(t *testing.T) {
+	collision := &pq.Error{Code: "23505", Constraint: "shares_token_key"}
+	attempts := 0
+	token, err := insertShareWithToken("download", func(string) error {
+		attempts++
+		if attempts < 3 {
+			return collision
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 || !strings.HasPrefix(token, ShareTokenPrefixDownload) {
+		t.Fatalf("token=%q attempts=%d err=%v", token, attempts, err)
+	}
+
+	attempts = 0
+	if _, err := insertShareWithToken("download", func(string) error { attempts++; return collision }); err == nil || attempts != shareTokenInsertAttempts {
+		t.Fatalf("expected failure after %d attempts, got %d (%v)", shareTokenInsertAttempts, attempts, err)
+	}
+
+	other := errors.New("connection refused")
+	attempts = 0
+	if _, err := insertShareWithToken("download", func(string) error { attempts++; return other }); err != other || attempts != 1 {
+		t.Fatalf("non-collision errors must not be retried: attempts=%d err=%v", attempts, err)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -28,9 +29,10 @@ type SharedFolder struct {
 	UpdatedAt    time.Time `json:"updatedAt"`
 	IsActive     bool      `json:"isActive"`
 	// Additional fields for display
-	CreatorUsername string `json:"creatorUsername,omitempty"`
-	UsedStorage     int64  `json:"usedStorage,omitempty"`
-	MemberCount     int    `json:"memberCount,omitempty"`
+	CreatorUsername string           `json:"creatorUsername,omitempty"`
+	UsedStorage     int64            `json:"usedStorage,omitempty"`
+	MemberCount     int              `json:"memberCount,omitempty"`
+	SMB             *SharedFolderSMB `json:"smb,omitempty"`
 }
 
 // SharedFolderMember represents a user's access to a shared folder
@@ -44,12 +46,18 @@ type SharedFolderMember struct {
 	// Additional fields for display
 	Username         string `json:"username,omitempty"`
 	AddedByUsername  string `json:"addedByUsername,omitempty"`
+	// Direct is false for users who have access only through groups;
+	// EffectivePermission is the highest of the direct and group levels
+	Direct              bool                      `json:"direct"`
+	Groups              []SharedFolderMemberGroup `json:"groups,omitempty"`
+	EffectivePermission int                       `json:"effectivePermission"`
 }
 
 // SharedFolderWithPermission combines folder info with user's permission
 type SharedFolderWithPermission struct {
 	SharedFolder
-	PermissionLevel int `json:"permissionLevel"`
+	PermissionLevel int                      `json:"permissionLevel"`
+	QuotaStatus     *SharedFolderQuotaStatus `json:"quotaStatus,omitempty"` // folders with a quota
 }
 
 // SharedFolderHandler handles shared folder operations
@@ -108,6 +116,9 @@ func (h *SharedFolderHandler) GetFolderPath(folderName string) string {
 // GetFolderStorageUsage calculates storage usage for a shared folder by name
 func (h *SharedFolderHandler) GetFolderStorageUsage(folderName string) (int64, error) {
 	dir := h.GetFolderPath(folderName)
+	if size, ok := indexedDirSize(dir); ok {
+		return size, nil
+	}
 	var size int64
 	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -159,10 +170,10 @@ func (h *SharedFolderHandler) ListMySharedFolders(c echo.Context) error {
 
 	query := `
 		SELECT sf.id, sf.name, sf.description, sf.storage_quota, sf.created_by,
-		       sf.created_at, sf.updated_at, sf.is_active, sf.storage_used, sfm.permission_level
+		       sf.created_at, sf.updated_at, sf.is_active, COALESCE(sf.storage_used, 0), sfa.permission_level
 		FROM shared_folders sf
-		INNER JOIN shared_folder_members sfm ON sf.id = sfm.shared_folder_id
-		WHERE sfm.user_id = $1 AND sf.is_active = TRUE
+		INNER JOIN shared_folder_access sfa ON sf.id = sfa.shared_folder_id
+		WHERE sfa.user_id = $1 AND sf.is_active = TRUE
 		ORDER BY sf.name ASC
 	`
 
@@ -185,6 +196,11 @@ func (h *SharedFolderHandler) ListMySharedFolders(c echo.Context) error {
 		if createdBy.Valid {
 			f.CreatedBy = createdBy.String
 		}
+		if f.StorageQuota > 0 {
+			f.UsedStorage = sharedFolderUsage(c.Request().Context(), h.dataRoot, f.Name, f.UsedStorage)
+			status := SharedFolderQuota{Quota: f.StorageQuota, Used: f.UsedStorage}.Status()
+			f.QuotaStatus = &status
+		}
 		folders = append(folders, f)
 	}
 
@@ -208,10 +224,10 @@ func (h *SharedFolderHandler) GetMyPermission(c echo.Context) error {
 
 	var permissionLevel int
 	queryErr := h.db.QueryRow(`
-		SELECT sfm.permission_level
-		FROM shared_folder_members sfm
-		INNER JOIN shared_folders sf ON sf.id = sfm.shared_folder_id
-		WHERE sfm.shared_folder_id = $1 AND sfm.user_id = $2 AND sf.is_active = TRUE
+		SELECT sfa.permission_level
+		FROM shared_folder_access sfa
+		INNER JOIN shared_folders sf ON sf.id = sfa.shared_folder_id
+		WHERE sfa.shared_folder_id = $1 AND sfa.user_id = $2 AND sf.is_active = TRUE
 	`, folderID, claims.UserID).Scan(&permissionLevel)
 
 	if queryErr == sql.ErrNoRows {
@@ -235,9 +251,11 @@ func (h *SharedFolderHandler) ListAllSharedFolders(c echo.Context) error {
 		SELECT sf.id, sf.name, sf.description, sf.storage_quota, sf.created_by,
 		       sf.created_at, sf.updated_at, sf.is_active, sf.storage_used,
 		       u.username as creator_username,
-			   (SELECT COUNT(*) FROM shared_folder_members WHERE shared_folder_id = sf.id) as member_count
+			   (SELECT COUNT(*) FROM shared_folder_access WHERE shared_folder_id = sf.id) as member_count,
+		       smb.enabled, smb.browseable, smb.guest_ok, smb.recycle_bin, smb.read_only
 		FROM shared_folders sf
 		LEFT JOIN users u ON sf.created_by = u.id
+		LEFT JOIN shared_folder_smb smb ON smb.shared_folder_id = sf.id
 		ORDER BY sf.created_at DESC
 	`
 
@@ -251,13 +269,24 @@ func (h *SharedFolderHandler) ListAllSharedFolders(c echo.Context) error {
 	for rows.Next() {
 		var f SharedFolder
 		var createdBy, creatorUsername sql.NullString
+		var smbEnabled, smbBrowseable, smbGuestOK, smbRecycleBin, smbReadOnly sql.NullBool
 		if scanErr := rows.Scan(
 			&f.ID, &f.Name, &f.Description, &f.StorageQuota, &createdBy,
 			&f.CreatedAt, &f.UpdatedAt, &f.IsActive, &f.UsedStorage,
 			&creatorUsername, &f.MemberCount,
+			&smbEnabled, &smbBrowseable, &smbGuestOK, &smbRecycleBin, &smbReadOnly,
 		); scanErr != nil {
 			continue
 		}
+		if smbEnabled.Valid {
+			f.SMB = &SharedFolderSMB{
+				Enabled:    smbEnabled.Bool,
+				Browseable: smbBrowseable.Bool,
+				GuestOK:    smbGuestOK.Bool,
+				RecycleBin: smbRecycleBin.Bool,
+				ReadOnly:   smbReadOnly.Bool,
+			}
+		}
 		if createdBy.Valid {
 			f.CreatedBy = createdBy.String
 		}
@@ -281,9 +310,10 @@ func (h *SharedFolderHandler) CreateSharedFolder(c echo.Context) error {
 	}
 
 	var req struct {
-		Name         string `json:"name"`
-		Description  string `json:"description"`
-		StorageQuota int64  `json:"storageQuota"` // bytes, 0 = unlimited
+		Name         string           `json:"name"`
+		Description  string           `json:"description"`
+		StorageQuota int64            `json:"storageQuota"` // bytes, 0 = unlimited
+		SMB          *SharedFolderSMB `json:"smb"`          // Optional SMB export
 	}
 	if err := c.Bind(&req); err != nil {
 		return RespondError(c, ErrBadRequest("Invalid request"))
@@ -293,12 +323,33 @@ func (h *SharedFolderHandler) CreateSharedFolder(c echo.Context) error {
 	if req.Name == "" {
 		return RespondError(c, ErrBadRequest("Name is required"))
 	}
+	if apiErr := checkSMBExport("", req.Name, req.Description, req.SMB); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	folderID, apiErr := h.createSharedFolder(req.Name, req.Description, req.StorageQuota, claims.UserID, c.RealIP())
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if req.SMB != nil {
+		h.setSMBExport(folderID, req.SMB, claims.UserID, c.RealIP())
+	}
+
+	return RespondCreated(c, map[string]interface{}{
+		"id":      folderID,
+		"name":    req.Name,
+		"message": "Shared folder created successfully",
+	})
+}
 
+// createSharedFolder inserts a shared folder, creates its directory and
+// records the audit event. Used by CreateSharedFolder and bulk provisioning.
+func (h *SharedFolderHandler) createSharedFolder(name, description string, storageQuota int64, actorID, clientIP string) (string, *APIError) {
 	// Check if folder with same name already exists
 	var existingCount int
-	_ = h.db.QueryRow("SELECT COUNT(*) FROM shared_folders WHERE name = $1", req.Name).Scan(&existingCount)
+	_ = h.db.QueryRow("SELECT COUNT(*) FROM shared_folders WHERE name = $1", name).Scan(&existingCount)
 	if existingCount > 0 {
-		return RespondError(c, ErrAlreadyExists("Folder with this name"))
+		return "", ErrAlreadyExists("Folder with this name")
 	}
 
 	// Create folder in database
@@ -307,33 +358,28 @@ func (h *SharedFolderHandler) CreateSharedFolder(c echo.Context) error {
 		INSERT INTO shared_folders (name, description, storage_quota, created_by)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id
-	`, req.Name, req.Description, req.StorageQuota, claims.UserID).Scan(&folderID)
+	`, name, description, storageQuota, actorID).Scan(&folderID)
 
 	if insertErr != nil {
-		return RespondError(c, ErrOperationFailed("create shared folder", insertErr))
+		return "", ErrOperationFailed("create shared folder", insertErr)
 	}
 
 	// Create directory on filesystem using folder name
-	if dirErr := h.EnsureSharedFolderDir(req.Name); dirErr != nil {
+	if dirErr := h.EnsureSharedFolderDir(name); dirErr != nil {
 		// Rollback database entry
 		_, _ = h.db.Exec("DELETE FROM shared_folders WHERE id = $1", folderID)
-		return RespondError(c, ErrOperationFailed("create folder directory", dirErr))
+		return "", ErrOperationFailed("create folder directory", dirErr)
 	}
 
 	// Audit log
-	userID := claims.UserID
-	_ = h.auditHandler.LogEvent(&userID, c.RealIP(), "shared_folder_create",
-		fmt.Sprintf("/shared/%s", sanitizeFolderName(req.Name)),
+	_ = h.auditHandler.LogEvent(&actorID, clientIP, "shared_folder_create",
+		fmt.Sprintf("/shared/%s", sanitizeFolderName(name)),
 		map[string]interface{}{
-			"name":         req.Name,
-			"storageQuota": req.StorageQuota,
+			"name":         name,
+			"storageQuota": storageQuota,
 		})
 
-	return RespondCreated(c, map[string]interface{}{
-		"id":      folderID,
-		"name":    req.Name,
-		"message": "Shared folder created successfully",
-	})
+	return folderID, nil
 }
 
 // UpdateSharedFolder updates a shared folder (admin only)
@@ -349,10 +395,11 @@ func (h *SharedFolderHandler) UpdateSharedFolder(c echo.Context) error {
 	}
 
 	var req struct {
-		Name         string `json:"name"`
-		Description  string `json:"description"`
-		StorageQuota int64  `json:"storageQuota"`
-		IsActive     *bool  `json:"isActive"`
+		Name         string           `json:"name"`
+		Description  string           `json:"description"`
+		StorageQuota int64            `json:"storageQuota"`
+		IsActive     *bool            `json:"isActive"`
+		SMB          *SharedFolderSMB `json:"smb"` // Omit to keep the current SMB export
 	}
 	if err := c.Bind(&req); err != nil {
 		return RespondError(c, ErrBadRequest("Invalid request"))
@@ -362,16 +409,50 @@ func (h *SharedFolderHandler) UpdateSharedFolder(c echo.Context) error {
 	if req.Name == "" {
 		return RespondError(c, ErrBadRequest("Name is required"))
 	}
+	smb := req.SMB
+	if smb == nil {
+		// A rename still has to produce a valid share name
+		smb, _ = GetSMBShares().Get(folderID)
+	}
+	if apiErr := checkSMBExport(folderID, req.Name, req.Description, smb); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	if apiErr := h.updateSharedFolder(folderID, req.Name, req.Description, req.StorageQuota, req.IsActive, claims.UserID, c.RealIP()); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if req.SMB != nil {
+		h.setSMBExport(folderID, req.SMB, claims.UserID, c.RealIP())
+	} else {
+		GetSMBShares().Sync(&claims.UserID, c.RealIP(), "shared_folder_update")
+	}
+
+	return RespondSuccess(c, map[string]string{"message": "Shared folder updated successfully"})
+}
+
+// updateSharedFolder updates a shared folder's attributes and records the
+// audit event. Used by UpdateSharedFolder and bulk provisioning.
+func (h *SharedFolderHandler) updateSharedFolder(folderID, name, description string, storageQuota int64, isActive *bool, actorID, clientIP string) *APIError {
+	// Retention policies are kept by the drive's folder name
+	if GetRetention().HoldsDrive(folderID) {
+		var current string
+		if err := h.db.QueryRow("SELECT name FROM shared_folders WHERE id = $1", folderID).Scan(&current); err != nil {
+			return ErrNotFound("Shared folder")
+		}
+		if sanitizeFolderName(current) != sanitizeFolderName(name) {
+			return NewAPIError(ErrCodeRetentionHold, "This drive holds a retention policy and cannot be renamed")
+		}
+	}
 
 	query := `
 		UPDATE shared_folders
 		SET name = $1, description = $2, storage_quota = $3, updated_at = NOW()
 	`
-	args := []interface{}{req.Name, req.Description, req.StorageQuota}
+	args := []interface{}{name, description, storageQuota}
 
-	if req.IsActive != nil {
+	if isActive != nil {
 		query += ", is_active = $4 WHERE id = $5"
-		args = append(args, *req.IsActive, folderID)
+		args = append(args, *isActive, folderID)
 	} else {
 		query += " WHERE id = $4"
 		args = append(args, folderID)
@@ -379,27 +460,66 @@ func (h *SharedFolderHandler) UpdateSharedFolder(c echo.Context) error {
 
 	result, updateErr := h.db.Exec(query, args...)
 	if updateErr != nil {
-		return RespondError(c, ErrOperationFailed("update shared folder", updateErr))
+		return ErrOperationFailed("update shared folder", updateErr)
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return RespondError(c, ErrNotFound("Shared folder"))
+		return ErrNotFound("Shared folder")
 	}
 
 	// Audit log
-	userID := claims.UserID
-	_ = h.auditHandler.LogEvent(&userID, c.RealIP(), "shared_folder_update",
-		fmt.Sprintf("/shared/%s", sanitizeFolderName(req.Name)),
+	_ = h.auditHandler.LogEvent(&actorID, clientIP, "shared_folder_update",
+		fmt.Sprintf("/shared/%s", sanitizeFolderName(name)),
 		map[string]interface{}{
-			"name":         req.Name,
-			"storageQuota": req.StorageQuota,
+			"name":         name,
+			"storageQuota": storageQuota,
 		})
 
-	return RespondSuccess(c, map[string]string{"message": "Shared folder updated successfully"})
+	return nil
+}
+
+// checkSMBExport renders the drive's SMB export together with the other
+// exported drives and rejects it if the result would not load
+func checkSMBExport(folderID, name, description string, smb *SharedFolderSMB) *APIError {
+	if smb == nil || !smb.Enabled {
+		return nil
+	}
+	_, issues, err := GetSMBShares().Preview(&smbShare{
+		FolderID:    folderID,
+		Name:        sanitizeFolderName(name),
+		Description: description,
+		SMB:         *smb,
+	})
+	if err != nil {
+		return ErrOperationFailed("check SMB export", err)
+	}
+	if smbConfigHasErrors(issues) {
+		return ErrBadRequest("SMB export is not valid").WithDetails(map[string]interface{}{"issues": issues})
+	}
+	return nil
+}
+
+// setSMBExport stores a drive's SMB export and renders the SMB config
+func (h *SharedFolderHandler) setSMBExport(folderID string, smb *SharedFolderSMB, actorID, clientIP string) {
+	if err := GetSMBShares().Set(folderID, smb, actorID); err != nil {
+		log.Printf("[SMB] Failed to save SMB export for shared folder %s: %v", folderID, err)
+		return
+	}
+	GetSMBShares().Sync(&actorID, clientIP, "shared_folder_smb")
+}
+
+// sharedFolderDependents are the rows deleted with a shared folder by ON DELETE CASCADE
+var sharedFolderDependents = []struct{ table, where string }{
+	{"shared_folder_members", "shared_folder_id = $1"},
+	{"shared_folder_groups", "shared_folder_id = $1"},
+	{"shared_folder_smb", "shared_folder_id = $1"},
+	{"mount_ins", "shared_folder_id = $1"},
+	{"shared_folder_snapshots", "shared_folder_id = $1"},
 }
 
-// DeleteSharedFolder deletes a shared folder (admin only)
+// DeleteSharedFolder deletes a shared folder (admin only). With dryRun=true
+// nothing is changed and the response lists what would be deleted.
 func (h *SharedFolderHandler) DeleteSharedFolder(c echo.Context) error {
 	claims, err := RequireClaims(c)
 	if err != nil {
@@ -418,32 +538,98 @@ func (h *SharedFolderHandler) DeleteSharedFolder(c echo.Context) error {
 		return RespondError(c, ErrNotFound("Shared folder"))
 	}
 
-	// Delete from database (cascades to members)
-	_, deleteErr := h.db.Exec("DELETE FROM shared_folders WHERE id = $1", folderID)
-	if deleteErr != nil {
-		return RespondError(c, ErrOperationFailed("delete shared folder", deleteErr))
+	if GetRetention().HoldsDrive(folderID) {
+		return RespondError(c, NewAPIError(ErrCodeRetentionHold, "This drive holds a retention policy; remove the policy first"))
+	}
+
+	run, err := beginDestructiveRun(h.db, dryRunParam(c))
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	defer run.Abort()
+
+	// Snapshots go with the drive, from every volume that holds some
+	snapshotVolumes, err := run.Query(`SELECT DISTINCT volume FROM shared_folder_snapshots WHERE shared_folder_id = $1`, folderID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("delete shared folder", err))
+	}
+	for snapshotVolumes.Next() {
+		var volume string
+		if err := snapshotVolumes.Scan(&volume); err == nil {
+			dir := filepath.Join(volume, snapshotsDirName, folderID)
+			run.After(func() error { return removeSnapshotDir(dir) })
+		}
+	}
+	snapshotVolumes.Close()
+
+	// Delete from database (cascades to members, SMB export, mount-ins and snapshots)
+	for _, dep := range sharedFolderDependents {
+		if err := run.Count(dep.table, dep.where, folderID); err != nil {
+			return RespondError(c, ErrOperationFailed("delete shared folder", err))
+		}
+	}
+	if _, err := run.Exec("shared_folders", "DELETE FROM shared_folders WHERE id = $1", folderID); err != nil {
+		return RespondError(c, ErrOperationFailed("delete shared folder", err))
 	}
 
-	// Delete directory from filesystem using folder name
+	// Delete directory from filesystem using folder name; a drive moved to
+	// another volume is removed there, along with its link and location
 	folderPath := h.GetFolderPath(folderName)
-	os.RemoveAll(folderPath)
+	storedPath := "shared/" + sanitizeFolderName(folderName)
+	dataPath := GetStorageLocations().Map(folderPath)
+	if dataPath != folderPath {
+		if _, err := run.Exec("storage_locations", "DELETE FROM storage_locations WHERE path = $1", storedPath); err != nil {
+			return RespondError(c, ErrOperationFailed("delete shared folder", err))
+		}
+		run.After(func() error { return os.Remove(folderPath) })
+		run.After(GetStorageLocations().Reload)
+	}
+	run.Remove(dataPath, "/"+storedPath)
+
+	result, err := run.Finish()
+	if result == nil {
+		return RespondError(c, ErrOperationFailed("delete shared folder", err))
+	}
+	if err != nil {
+		log.Printf("[SharedFolder] Deleting %s: %v", folderName, err)
+	}
+	if !result.Applied {
+		return RespondSuccess(c, result)
+	}
+
+	GetFileEncryption().ForgetTree(folderPath)
+	GetFileLinks().ForgetTree(folderPath)
+	GetFolderDisplay().ForgetTree(folderPath)
+	GetContentInspection().ForgetTree(folderPath)
+	GetContentIndex().ForgetTree(folderPath)
+	GetFileChecksums().ForgetTree(folderPath)
+	GetDirSizes().ForgetTree(folderPath)
+	GetListingVersions().Invalidate(folderPath)
 
 	// Invalidate permission cache for this folder (all users)
 	if cache := GetPermissionCache(); cache != nil {
 		cache.InvalidateFolder(folderName)
 	}
 
+	// The SMB export row went with the folder; drop its section
+	GetSMBShares().Sync(&claims.UserID, c.RealIP(), "shared_folder_delete")
+
 	// Audit log
 	userID := claims.UserID
 	_ = h.auditHandler.LogEvent(&userID, c.RealIP(), "shared_folder_delete",
-		fmt.Sprintf("/shared/%s", sanitizeFolderName(folderName)), nil)
+		fmt.Sprintf("/shared/%s", sanitizeFolderName(folderName)), map[string]interface{}{
+			"files": result.Files,
+			"bytes": result.Bytes,
+			"rows":  result.Rows,
+		})
 
-	return RespondSuccess(c, map[string]string{"message": "Shared folder deleted successfully"})
+	return RespondSuccess(c, result)
 }
 
 // --- Member Management ---
 
-// ListMembers lists all members of a shared folder (admin only)
+// ListMembers lists all members of a shared folder (admin only): direct members,
+// users with access through a group, and the folder's group grants
 func (h *SharedFolderHandler) ListMembers(c echo.Context) error {
 	folderID := c.Param("id")
 	if folderID == "" {
@@ -485,8 +671,18 @@ func (h *SharedFolderHandler) ListMembers(c echo.Context) error {
 		members = append(members, m)
 	}
 
+	members, err = h.addGroupMembers(folderID, members)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	groups, err := h.listFolderGroups(folderID)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+
 	return RespondSuccess(c, map[string]interface{}{
 		"members": members,
+		"groups":  groups,
 		"total":   len(members),
 	})
 }
@@ -532,59 +728,70 @@ func (h *SharedFolderHandler) AddMember(c echo.Context) error {
 		return RespondError(c, ErrNotFound("User"))
 	}
 
+	if apiErr := h.setMember(folderID, req.UserID, req.PermissionLevel, claims, c.RealIP()); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	return RespondSuccess(c, map[string]string{"message": "Member added successfully"})
+}
+
+// setMember inserts or updates a membership, then audits, invalidates the
+// permission cache and notifies the user. Used by AddMember and bulk provisioning.
+func (h *SharedFolderHandler) setMember(folderID, memberUserID string, permissionLevel int, actor *JWTClaims, clientIP string) *APIError {
 	// Insert or update member
 	_, insertErr := h.db.Exec(`
 		INSERT INTO shared_folder_members (shared_folder_id, user_id, permission_level, added_by)
 		VALUES ($1, $2, $3, $4)
 		ON CONFLICT (shared_folder_id, user_id)
 		DO UPDATE SET permission_level = EXCLUDED.permission_level
-	`, folderID, req.UserID, req.PermissionLevel, claims.UserID)
+	`, folderID, memberUserID, permissionLevel, actor.UserID)
 
 	if insertErr != nil {
-		return RespondError(c, ErrOperationFailed("add member", insertErr))
+		return ErrOperationFailed("add member", insertErr)
 	}
 
 	// Audit log - get folder name for path
 	var folderName string
 	_ = h.db.QueryRow("SELECT name FROM shared_folders WHERE id = $1", folderID).Scan(&folderName)
-	actorID := claims.UserID
-	_ = h.auditHandler.LogEvent(&actorID, c.RealIP(), "shared_folder_member_add",
+	actorID := actor.UserID
+	_ = h.auditHandler.LogEvent(&actorID, clientIP, "shared_folder_member_add",
 		fmt.Sprintf("/shared/%s", sanitizeFolderName(folderName)),
 		map[string]interface{}{
-			"memberUserId":    req.UserID,
-			"permissionLevel": req.PermissionLevel,
+			"memberUserId":    memberUserID,
+			"permissionLevel": permissionLevel,
 		})
 
 	// Invalidate permission cache for the user
 	if cache := GetPermissionCache(); cache != nil {
-		cache.InvalidateUser(req.UserID)
+		cache.InvalidateUser(memberUserID)
 	}
+	GetSMBShares().Sync(&actorID, clientIP, "shared_folder_member_add")
 
 	// Send notification to the invited user
 	if h.notificationService != nil {
 		permLabel := "읽기"
-		if req.PermissionLevel == PermissionReadWrite {
+		if permissionLevel == PermissionReadWrite {
 			permLabel = "읽기/쓰기"
 		}
 		title := "공유 폴더에 초대되었습니다"
-		message := claims.Username + "님이 '" + folderName + "' 폴더에 초대했습니다 (" + permLabel + " 권한)"
+		message := actor.Username + "님이 '" + folderName + "' 폴더에 초대했습니다 (" + permLabel + " 권한)"
 		link := "/shared/" + folderID
-		_, _ = h.notificationService.Create(
-			req.UserID,
+		h.notificationService.Send(
+			memberUserID,
 			NotifSharedFolderInvited,
 			title,
 			message,
 			link,
-			&claims.UserID,
+			&actor.UserID,
 			map[string]interface{}{
 				"folderId":        folderID,
 				"folderName":      folderName,
-				"permissionLevel": req.PermissionLevel,
+				"permissionLevel": permissionLevel,
 			},
 		)
 	}
 
-	return RespondSuccess(c, map[string]string{"message": "Member added successfully"})
+	return nil
 }
 
 // UpdateMemberPermission updates a member's permission level (admin only)
@@ -641,6 +848,7 @@ func (h *SharedFolderHandler) UpdateMemberPermission(c echo.Context) error {
 	if cache := GetPermissionCache(); cache != nil {
 		cache.InvalidateUser(userID)
 	}
+	GetSMBShares().Sync(&actorID, c.RealIP(), "shared_folder_member_update")
 
 	return RespondSuccess(c, map[string]string{"message": "Permission updated successfully"})
 }
@@ -658,46 +866,57 @@ func (h *SharedFolderHandler) RemoveMember(c echo.Context) error {
 		return RespondError(c, ErrBadRequest("Folder ID and User ID required"))
 	}
 
+	if apiErr := h.removeMember(folderID, userID, claims, c.RealIP()); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	return RespondSuccess(c, map[string]string{"message": "Member removed successfully"})
+}
+
+// removeMember deletes a membership, then audits, invalidates the permission
+// cache and notifies the user. Used by RemoveMember and bulk provisioning.
+func (h *SharedFolderHandler) removeMember(folderID, memberUserID string, actor *JWTClaims, clientIP string) *APIError {
 	result, deleteErr := h.db.Exec(`
 		DELETE FROM shared_folder_members
 		WHERE shared_folder_id = $1 AND user_id = $2
-	`, folderID, userID)
+	`, folderID, memberUserID)
 
 	if deleteErr != nil {
-		return RespondError(c, ErrOperationFailed("remove member", deleteErr))
+		return ErrOperationFailed("remove member", deleteErr)
 	}
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return RespondError(c, ErrNotFound("Member"))
+		return ErrNotFound("Member")
 	}
 
 	// Audit log - get folder name for path
 	var folderName string
 	_ = h.db.QueryRow("SELECT name FROM shared_folders WHERE id = $1", folderID).Scan(&folderName)
-	actorID := claims.UserID
-	_ = h.auditHandler.LogEvent(&actorID, c.RealIP(), "shared_folder_member_remove",
+	actorID := actor.UserID
+	_ = h.auditHandler.LogEvent(&actorID, clientIP, "shared_folder_member_remove",
 		fmt.Sprintf("/shared/%s", sanitizeFolderName(folderName)),
 		map[string]interface{}{
-			"memberUserId": userID,
+			"memberUserId": memberUserID,
 		})
 
 	// Invalidate permission cache for the user
 	if cache := GetPermissionCache(); cache != nil {
-		cache.InvalidateUser(userID)
+		cache.InvalidateUser(memberUserID)
 	}
+	GetSMBShares().Sync(&actorID, clientIP, "shared_folder_member_remove")
 
 	// Send notification to the removed user
 	if h.notificationService != nil {
 		title := "공유 폴더에서 제외되었습니다"
 		message := "'" + folderName + "' 폴더에서 제외되었습니다"
-		_, _ = h.notificationService.Create(
-			userID,
+		h.notificationService.Send(
+			memberUserID,
 			NotifSharedFolderRemoved,
 			title,
 			message,
 			"",
-			&claims.UserID,
+			&actor.UserID,
 			map[string]interface{}{
 				"folderId":   folderID,
 				"folderName": folderName,
@@ -705,19 +924,20 @@ func (h *SharedFolderHandler) RemoveMember(c echo.Context) error {
 		)
 	}
 
-	return RespondSuccess(c, map[string]string{"message": "Member removed successfully"})
+	return nil
 }
 
 // --- Permission Checking Helpers ---
 
-// CheckUserPermission checks if a user has access to a shared folder and returns their permission level
+// CheckUserPermission checks if a user has access to a shared folder and returns their permission level,
+// the highest of their direct membership and their groups' grants
 func (h *SharedFolderHandler) CheckUserPermission(userID, folderID string) (int, error) {
 	var permissionLevel int
 	err := h.db.QueryRow(`
-		SELECT sfm.permission_level
-		FROM shared_folder_members sfm
-		INNER JOIN shared_folders sf ON sf.id = sfm.shared_folder_id
-		WHERE sfm.shared_folder_id = $1 AND sfm.user_id = $2 AND sf.is_active = TRUE
+		SELECT sfa.permission_level
+		FROM shared_folder_access sfa
+		INNER JOIN shared_folders sf ON sf.id = sfa.shared_folder_id
+		WHERE sfa.shared_folder_id = $1 AND sfa.user_id = $2 AND sf.is_active = TRUE
 	`, folderID, userID).Scan(&permissionLevel)
 
 	if err == sql.ErrNoRows {
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"path/filepath"
+)
+
+// SharedFolderQuota is a shared folder's storage quota and current usage
+type SharedFolderQuota struct {
+	Quota int64 `json:"quota"` // bytes, 0 = unlimited
+	Used  int64 `json:"used"`
+}
+
+// Allows reports whether size more bytes fit in the quota
+func (q SharedFolderQuota) Allows(size int64) bool {
+	return q.Quota <= 0 || q.Used+size <= q.Quota
+}
+
+// SharedFolderQuotaStatus is a folder's quota usage for a usage bar
+type SharedFolderQuotaStatus struct {
+	Quota     int64   `json:"quota"`
+	Used      int64   `json:"used"`
+	Remaining int64   `json:"remaining"` // 0 when over the quota
+	Percent   float64 `json:"percent"`   // may pass 100
+	Exceeded  bool    `json:"exceeded"`  // no space left
+}
+
+// Status returns the quota usage of a folder with a quota
+func (q SharedFolderQuota) Status() SharedFolderQuotaStatus {
+	status := SharedFolderQuotaStatus{Quota: q.Quota, Used: q.Used}
+	if q.Quota > 0 {
+		status.Exceeded = q.Used >= q.Quota
+		status.Remaining = max(q.Quota-q.Used, 0)
+		status.Percent = math.Round(float64(q.Used)/float64(q.Quota)*1000) / 10
+	}
+	return status
+}
+
+// sharedFolderUsage returns a shared folder's usage from the size index when
+// it has scanned the folder, else the storage_used the uploads keep current.
+// Neither walks the folder.
+func sharedFolderUsage(ctx context.Context, dataRoot, folderName string, storageUsed int64) int64 {
+	realDir := GetStorageLocations().Map(filepath.Join(dataRoot, "shared", folderName))
+	if usage, ok := GetDirSizes().CachedUsage(ctx, realDir); ok {
+		return usage.Bytes
+	}
+	return storageUsed
+}
+
+// lookupSharedFolderQuota returns the quota and usage of an active shared
+// folder, sql.ErrNoRows if there is none by that name. Usage is only looked
+// up for folders with a quota.
+func lookupSharedFolderQuota(ctx context.Context, db *sql.DB, dataRoot, folderName string) (SharedFolderQuota, error) {
+	var q SharedFolderQuota
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(storage_quota, 0), COALESCE(storage_used, 0)
+		FROM shared_folders WHERE name = $1 AND is_active = TRUE
+	`, folderName).Scan(&q.Quota, &q.Used)
+	if err != nil {
+		return q, err
+	}
+	if q.Quota > 0 {
+		q.Used = sharedFolderUsage(ctx, dataRoot, folderName, q.Used)
+	}
+	return q, nil
+}
+
+// checkSharedUploadQuota checks an upload of size bytes into a /shared/
+// path against its folder's quota. Lookup errors let the upload through;
+// other paths are not checked.
+func checkSharedUploadQuota(ctx context.Context, db *sql.DB, dataRoot, path string, size int64) *APIError {
+	folderName := ExtractSharedDriveFolderName(path)
+	if folderName == "" {
+		return nil
+	}
+	q, err := lookupSharedFolderQuota(ctx, db, dataRoot, folderName)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			LogError("Shared folder quota check failed", err, "folder", folderName)
+		}
+		return nil
+	}
+	if !q.Allows(size) {
+		LogInfo("[QuotaCheck] REJECTED", "folder", folderName, "used", q.Used, "quota", q.Quota, "upload", size)
+		return ErrSharedQuotaExceeded(q.Quota, q.Used, size)
+	}
+	return nil
+}
+
+// ErrSharedQuotaExceeded returns a shared folder quota exceeded error
+func ErrSharedQuotaExceeded(quota, used, requested int64) *APIError {
+	apiErr := ErrQuotaExceeded(quota, used, requested)
+	apiErr.Message = "Shared drive quota exceeded"
+	return apiErr
+}
+
+// quotaRejectionBody is the tus response body for a quota rejection. The
+// quota fields are repeated at the top level, where the home quota
+// rejection has them.
+func quotaRejectionBody(apiErr *APIError) string {
+	body := map[string]interface{}{"error": apiErr.Message, "code": apiErr.Code, "details": apiErr.Details}
+	if details, ok := apiErr.Details.(map[string]int64); ok {
+		for k, v := range details {
+			body[k] = v
+		}
+	}
+	data, _ := json.Marshal(body)
+	return string(data)
+}
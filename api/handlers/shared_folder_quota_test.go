@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	tusd "github.com/tus/tusd/v2/pkg/handler"
+)
+
+func expectSharedQuota(tc *TestContext, folder string, quota, used int64) {
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(storage_quota, 0), COALESCE(storage_used, 0)`)).
+		WithArgs(folder).
+		WillReturnRows(sqlmock.NewRows([]string{"storage_quota", "storage_used"}).AddRow(quota, used))
+}
+
+func TestSharedFolderQuotaStatus(t *testing.T) {
+	for _, tt := range []struct {
+		q         SharedFolderQuota
+		size      int64
+		allows    bool
+		percent   float64
+		remaining int64
+		exceeded  bool
+	}{
+		{SharedFolderQuota{Quota: 0, Used: 500}, 1 << 40, true, 0, 0, false},
+		{SharedFolderQuota{Quota: 1000, Used: 250}, 750, true, 25, 750, false},
+		{SharedFolderQuota{Quota: 1000, Used: 250}, 751, false, 25, 750, false},
+		{SharedFolderQuota{Quota: 1000, Used: 1200}, 0, false, 120, 0, true},
+	} {
+		if got := tt.q.Allows(tt.size); got != tt.allows {
+			t.Errorf("%+v.Allows(%d) = %v, want %v", tt.q, tt.size, got, tt.allows)
+		}
+		status := tt.q.Status()
+		if status.Percent != tt.percent || status.Remaining != tt.remaining || status.Exceeded != tt.exceeded {
+			t.Errorf("%+v.Status() = %+v", tt.q, status)
+		}
+	}
+}
+
+func TestCheckSharedUploadQuota(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	ctx := context.Background()
+
+	// Home uploads are not checked here
+	if apiErr := checkSharedUploadQuota(ctx, tc.DB, t.TempDir(), "/home/docs", 1<<40); apiErr != nil {
+		t.Errorf("home upload refused: %v", apiErr)
+	}
+
+	expectSharedQuota(tc, "Team", 1000, 900)
+	if apiErr := checkSharedUploadQuota(ctx, tc.DB, t.TempDir(), "/shared/Team/docs", 100); apiErr != nil {
+		t.Errorf("upload that fits refused: %v", apiErr)
+	}
+
+	expectSharedQuota(tc, "Team", 1000, 900)
+	apiErr := checkSharedUploadQuota(ctx, tc.DB, t.TempDir(), "/shared/Team/docs", 101)
+	if apiErr == nil || apiErr.HTTPStatus() != http.StatusRequestEntityTooLarge {
+		t.Fatalf("apiErr = %v, want 413", apiErr)
+	}
+	if details := apiErr.Details.(map[string]int64); details["quota"] != 1000 || details["used"] != 900 || details["requested"] != 101 {
+		t.Errorf("details = %v", details)
+	}
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPreUploadCreate_SharedQuota(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &UploadHandler{db: tc.DB, dataRoot: t.TempDir()}
+
+	// The folder's quota applies, not the uploader's home quota
+	expectSharedQuota(tc, "Team", 1000, 900)
+	resp, _, err := h.preUploadCreateCallback(tusd.HookEvent{
+		Context: context.Background(),
+		Upload: tusd.FileInfo{
+			Size:     500,
+			MetaData: tusd.MetaData{"path": "/shared/Team", "filename": "big.iso", "username": "alice"},
+		},
+	})
+	if err == nil || resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, err = %v, want 413 rejection", resp.StatusCode, err)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["quota"] != float64(1000) || body["used"] != float64(900) || body["requested"] != float64(500) {
+		t.Errorf("body = %s", resp.Body)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,923 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Shared drive snapshots. A snapshot is a point-in-time copy of a drive in
+// the snapshots area of the drive's volume, {volume}/.snapshots/{drive id}/{id},
+// outside the shared tree, so it never shows up in listings, quota or SMB
+// shares. Its files are hard links to the live files when the area is on the
+// same filesystem, so a snapshot costs little until files change. The API
+// never writes through such a link: its write paths call copyOnWrite first,
+// which gives the live file its own copy. SMB clients write files in place,
+// so while SMB is enabled snapshots are full copies instead.
+
+const (
+	// JobSnapshot creates, restores or deletes a snapshot. One runs at a
+	// time, so the operations on a drive apply in the order requested.
+	JobSnapshot = "snapshots.run"
+	// JobSnapshotsPrune deletes snapshots past their retention
+	JobSnapshotsPrune = "snapshots.prune"
+
+	snapshotsDirName = ".snapshots"
+
+	maxSnapshotRetentionDays = 3650
+)
+
+// Snapshot modes
+const (
+	SnapshotModeHardlink = "hardlink"
+	SnapshotModeCopy     = "copy"
+)
+
+// Snapshot states
+const (
+	SnapshotCreating  = "creating"
+	SnapshotReady     = "ready"
+	SnapshotRestoring = "restoring"
+	SnapshotDeleting  = "deleting"
+	SnapshotFailed    = "failed"
+)
+
+// SharedFolderSnapshot is a point-in-time copy of a shared drive
+type SharedFolderSnapshot struct {
+	ID                string     `json:"id"`
+	SharedFolderID    string     `json:"sharedFolderId"`
+	Name              string     `json:"name"`
+	Mode              string     `json:"mode"`  // hardlink or copy
+	State             string     `json:"state"` // creating, ready, restoring, deleting or failed
+	Warning           string     `json:"warning,omitempty"`
+	Error             string     `json:"error,omitempty"`
+	Files             int64      `json:"files"`
+	SizeBytes         int64      `json:"sizeBytes"`      // Size of the drive when taken
+	ExclusiveBytes    int64      `json:"exclusiveBytes"` // Held only by the snapshot; freed by deleting it
+	JobID             *int64     `json:"jobId,omitempty"`
+	CreatedBy         *string    `json:"createdBy,omitempty"`
+	CreatedByUsername string     `json:"createdByUsername,omitempty"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+
+	volume     string
+	folderName string
+}
+
+// dir is where the snapshot's files are kept
+func (s *SharedFolderSnapshot) dir() string {
+	return filepath.Join(s.volume, snapshotsDirName, s.SharedFolderID, s.ID)
+}
+
+// snapshotJobParams are the params of a JobSnapshot job
+type snapshotJobParams struct {
+	Op         string `json:"op"` // create, restore or delete
+	SnapshotID string `json:"snapshotId"`
+	// PreserveID is a snapshot of the current state to take before restoring
+	PreserveID string `json:"preserveId,omitempty"`
+}
+
+// snapshotProgress is the progress of a JobSnapshot job
+type snapshotProgress struct {
+	Phase      string `json:"phase"` // counting, snapshot, restore or delete
+	Files      int64  `json:"files"`
+	FilesTotal int64  `json:"filesTotal"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// StartSnapshotJobs registers the snapshot job types and schedules the
+// daily deletion of expired snapshots
+func (h *SharedFolderHandler) StartSnapshotJobs() {
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:       JobSnapshot,
+		Idempotent: true,
+		Priority:   PacePriorityUser,
+		Run:        h.runSnapshotJob,
+	})
+	jobs.Register(JobType{
+		Name:        JobSnapshotsPrune,
+		Idempotent:  true,
+		MaxAttempts: 3,
+		Priority:    PacePriorityMaintenance,
+		Run:         h.pruneSnapshots,
+	})
+	jobs.Schedule(JobSnapshotsPrune, 24*time.Hour, true)
+}
+
+// copyOnWrite detaches realPath from the snapshots sharing it before it is
+// written in place. A regular file with more than one link is replaced by a
+// private copy if keepContent is set, for writes into the existing data,
+// and unlinked otherwise, for writes that replace the whole file. Missing
+// files and files with a single link are left alone.
+func copyOnWrite(realPath string, keepContent bool) error {
+	info, err := os.Lstat(realPath)
+	if err != nil || !info.Mode().IsRegular() || fileLinkCount(info) < 2 {
+		return nil
+	}
+	if !keepContent {
+		return os.Remove(realPath)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(realPath), ".cow-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if err := copyFileContent(realPath, tmp, info); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, realPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// copyFileContent copies src into the open file dst, keeping the mode and
+// modification time of src, and closes dst
+func copyFileContent(src string, dst *os.File, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	defer in.Close()
+	if _, err := io.Copy(dst, in); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	_ = os.Chmod(dst.Name(), info.Mode().Perm())
+	return os.Chtimes(dst.Name(), time.Now(), info.ModTime())
+}
+
+// copySnapshotFile creates dst as a copy of src
+func copySnapshotFile(src, dst string, info os.FileInfo) error {
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := copyFileContent(src, out, info); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// fileLinkCount returns the number of hard links to a file
+func fileLinkCount(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// smbWritesInPlace reports whether SMB clients may write to the drives
+func smbWritesInPlace() bool {
+	sh := GetGlobalSettingsHandler()
+	return sh == nil || sh.GetSettingBool("smb_enabled", true)
+}
+
+// placeSnapshotEntry creates dst as a hard link to or copy of the file or
+// symlink at src. A file that cannot be linked is copied.
+func placeSnapshotEntry(src, dst string, d fs.DirEntry, link bool) (int64, error) {
+	info, err := d.Info()
+	if err != nil {
+		return 0, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return 0, err
+		}
+		return 0, os.Symlink(target, dst)
+	}
+	if link && os.Link(src, dst) == nil {
+		return info.Size(), nil
+	}
+	return info.Size(), copySnapshotFile(src, dst, info)
+}
+
+// countTreeFiles counts the files below root, for progress totals
+func countTreeFiles(run *JobRun, root string) (int64, error) {
+	var files int64
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if paceErr := run.Pace(); paceErr != nil {
+			return paceErr
+		}
+		if d.IsDir() && d.Name() == uploadStagingDirName && p != root {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			files++
+		}
+		return nil
+	})
+	return files, err
+}
+
+// snapshotTree fills dst, which must not exist, with the tree at src: hard
+// links to its files if link is set, copies otherwise. Upload staging
+// directories are skipped. The directories are made read-only at the end.
+func snapshotTree(run *JobRun, src, dst string, link bool, progress *snapshotProgress) error {
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if paceErr := run.Pace(); paceErr != nil {
+			return paceErr
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			if d.Name() == uploadStagingDirName && p != src {
+				return filepath.SkipDir
+			}
+			return os.Mkdir(target, 0755)
+		case d.Type().IsRegular() || d.Type()&os.ModeSymlink != 0:
+			size, err := placeSnapshotEntry(p, target, d, link)
+			if err != nil {
+				return err
+			}
+			progress.Files++
+			progress.Bytes += size
+			run.SetProgress(progress)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return setSnapshotWritable(dst, false)
+}
+
+// restoreTree makes the tree at dst match the snapshot at src. Files still
+// linked to their snapshot copy are kept; others are replaced by a link to
+// it, or a copy if link is unset. Entries the snapshot lacks are removed.
+// Upload staging directories are left alone.
+func restoreTree(run *JobRun, src, dst string, link bool, progress *snapshotProgress) error {
+	if err := os.MkdirAll(dst, 0775); err != nil {
+		return err
+	}
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if paceErr := run.Pace(); paceErr != nil {
+			return paceErr
+		}
+		if p == src {
+			return nil
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		current, statErr := os.Lstat(target)
+
+		if d.IsDir() {
+			if statErr == nil && current.IsDir() {
+				return nil
+			}
+			if statErr == nil {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			return os.Mkdir(target, 0775)
+		}
+		if !d.Type().IsRegular() && d.Type()&os.ModeSymlink == 0 {
+			return nil
+		}
+		progress.Files++
+		run.SetProgress(progress)
+		if statErr == nil {
+			if info, err := d.Info(); err == nil && d.Type().IsRegular() && os.SameFile(info, current) {
+				return nil
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+		size, err := placeSnapshotEntry(p, target, d, link)
+		progress.Bytes += size
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove what was added since the snapshot
+	return filepath.WalkDir(dst, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if paceErr := run.Pace(); paceErr != nil {
+			return paceErr
+		}
+		if p == dst {
+			return nil
+		}
+		if d.IsDir() && d.Name() == uploadStagingDirName {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dst, p)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filepath.Join(src, rel)); !os.IsNotExist(err) {
+			return nil
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// setSnapshotWritable makes the directories of a snapshot writable, so it
+// can be removed, or read-only. The files are not touched: hard-linked
+// files share their mode with the live files.
+func setSnapshotWritable(root string, writable bool) error {
+	mode := os.FileMode(0555)
+	if writable {
+		mode = 0755
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return os.Chmod(p, mode)
+		}
+		return nil
+	})
+}
+
+// removeSnapshotDir removes a snapshot's files, or all snapshots of a drive
+func removeSnapshotDir(dir string) error {
+	if err := setSnapshotWritable(dir, true); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// snapshotExclusiveBytes sums the files of a snapshot that no live file or
+// other snapshot links to
+func snapshotExclusiveBytes(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && fileLinkCount(info) == 1 {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+const snapshotColumns = `s.id, s.shared_folder_id, s.name, s.volume, s.mode, s.state, COALESCE(s.warning, ''),
+	COALESCE(s.error, ''), s.file_count, s.size_bytes, s.job_id, s.created_by, COALESCE(u.username, ''),
+	s.created_at, s.expires_at, f.name`
+
+const snapshotFrom = ` FROM shared_folder_snapshots s
+	JOIN shared_folders f ON f.id = s.shared_folder_id
+	LEFT JOIN users u ON u.id = s.created_by`
+
+// scanSnapshot scans a row selected with snapshotColumns from snapshotFrom
+func scanSnapshot(scan func(dest ...interface{}) error) (*SharedFolderSnapshot, error) {
+	var s SharedFolderSnapshot
+	var expiresAt sql.NullTime
+	if err := scan(&s.ID, &s.SharedFolderID, &s.Name, &s.volume, &s.Mode, &s.State, &s.Warning,
+		&s.Error, &s.Files, &s.SizeBytes, &s.JobID, &s.CreatedBy, &s.CreatedByUsername,
+		&s.CreatedAt, &expiresAt, &s.folderName); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		s.ExpiresAt = &expiresAt.Time
+	}
+	return &s, nil
+}
+
+// loadSnapshot loads a snapshot by ID
+func (h *SharedFolderHandler) loadSnapshot(id string) (*SharedFolderSnapshot, error) {
+	return scanSnapshot(h.db.QueryRow(`SELECT `+snapshotColumns+snapshotFrom+` WHERE s.id = $1`, id).Scan)
+}
+
+// setSnapshotState records a snapshot's state and the error that led to it
+func (h *SharedFolderHandler) setSnapshotState(id, state string, cause error) {
+	var message *string
+	if cause != nil {
+		text := cause.Error()
+		message = &text
+	}
+	if _, err := h.db.Exec(`UPDATE shared_folder_snapshots SET state = $2, error = $3 WHERE id = $1`, id, state, message); err != nil {
+		log.Printf("[Snapshots] Failed to record snapshot %s as %s: %v", id, state, err)
+	}
+}
+
+// runSnapshotJob runs a JobSnapshot job
+func (h *SharedFolderHandler) runSnapshotJob(run *JobRun) error {
+	var params snapshotJobParams
+	if err := run.Decode(&params); err != nil {
+		return JobPermanent(err)
+	}
+	switch params.Op {
+	case "create":
+		return h.createSnapshotFiles(run, params.SnapshotID)
+	case "restore":
+		if params.PreserveID != "" {
+			if err := h.createSnapshotFiles(run, params.PreserveID); err != nil {
+				h.setSnapshotState(params.SnapshotID, SnapshotReady, nil)
+				return fmt.Errorf("snapshot of the current state: %w", err)
+			}
+		}
+		return h.restoreSnapshotFiles(run, params.SnapshotID)
+	case "delete":
+		return h.deleteSnapshotFiles(run, params.SnapshotID)
+	}
+	return JobPermanent(fmt.Errorf("unknown snapshot operation %q", params.Op))
+}
+
+// createSnapshotFiles takes a snapshot recorded as creating. A retried
+// attempt starts over; a snapshot already taken is left alone.
+func (h *SharedFolderHandler) createSnapshotFiles(run *JobRun, id string) error {
+	s, err := h.loadSnapshot(id)
+	if err == sql.ErrNoRows {
+		return JobPermanent(errors.New("snapshot no longer exists"))
+	}
+	if err != nil {
+		return err
+	}
+	if s.State == SnapshotReady {
+		return nil
+	}
+
+	live := GetStorageLocations().Map(h.GetFolderPath(s.folderName))
+	dir := s.dir()
+	if err := removeSnapshotDir(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+		return err
+	}
+	mode, warning := s.Mode, s.Warning
+	if mode == SnapshotModeHardlink && !sameFilesystem(live, filepath.Dir(dir)) {
+		mode = SnapshotModeCopy
+		warning = "The snapshots area is on another filesystem than the drive, so the files were copied in full"
+	}
+
+	progress := &snapshotProgress{Phase: "counting"}
+	run.SetProgress(progress)
+	progress.FilesTotal, err = countTreeFiles(run, live)
+	if err == nil {
+		progress.Phase = "snapshot"
+		err = snapshotTree(run, live, dir, mode == SnapshotModeHardlink, progress)
+	}
+	run.SetProgress(progress)
+	if err != nil {
+		h.setSnapshotState(id, SnapshotFailed, err)
+		if rmErr := removeSnapshotDir(dir); rmErr != nil {
+			log.Printf("[Snapshots] Failed to remove incomplete snapshot %s: %v", dir, rmErr)
+		}
+		return err
+	}
+
+	if _, err := h.db.Exec(`
+		UPDATE shared_folder_snapshots
+		SET state = $2, mode = $3, warning = NULLIF($4, ''), error = NULL, file_count = $5, size_bytes = $6
+		WHERE id = $1
+	`, id, SnapshotReady, mode, warning, progress.Files, progress.Bytes); err != nil {
+		return err
+	}
+	log.Printf("[Snapshots] Took snapshot %q of %s: %d files, %d bytes (%s)", s.Name, s.folderName, progress.Files, progress.Bytes, mode)
+	return nil
+}
+
+// restoreSnapshotFiles rolls a drive back to a snapshot recorded as restoring
+func (h *SharedFolderHandler) restoreSnapshotFiles(run *JobRun, id string) error {
+	s, err := h.loadSnapshot(id)
+	if err == sql.ErrNoRows {
+		return JobPermanent(errors.New("snapshot no longer exists"))
+	}
+	if err != nil {
+		return err
+	}
+	if GetRetention().HoldsDrive(s.SharedFolderID) {
+		h.setSnapshotState(id, SnapshotReady, nil)
+		return JobPermanent(errors.New("the drive holds a retention policy"))
+	}
+
+	live := GetStorageLocations().Map(h.GetFolderPath(s.folderName))
+	dir := s.dir()
+	// Restored files are linked to the snapshot only when later writes
+	// cannot go through the link
+	link := s.Mode == SnapshotModeHardlink && !smbWritesInPlace() && sameFilesystem(live, dir)
+
+	progress := &snapshotProgress{Phase: "counting"}
+	run.SetProgress(progress)
+	progress.FilesTotal, err = countTreeFiles(run, dir)
+	if err == nil {
+		progress.Phase = "restore"
+		err = restoreTree(run, dir, live, link, progress)
+	}
+	run.SetProgress(progress)
+	h.setSnapshotState(id, SnapshotReady, nil)
+	if err != nil {
+		return err
+	}
+
+	if size, err := h.GetFolderStorageUsage(s.folderName); err == nil {
+		_, _ = h.db.Exec(`UPDATE shared_folders SET storage_used = $1, updated_at = NOW() WHERE id = $2`, size, s.SharedFolderID)
+		GetStorageCache().InvalidateSharedUsage()
+	}
+	log.Printf("[Snapshots] Restored %s to snapshot %q", s.folderName, s.Name)
+	return nil
+}
+
+// deleteSnapshotFiles removes a snapshot's files and row
+func (h *SharedFolderHandler) deleteSnapshotFiles(run *JobRun, id string) error {
+	s, err := h.loadSnapshot(id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	run.SetProgress(&snapshotProgress{Phase: "delete", FilesTotal: s.Files})
+	if err := removeSnapshotDir(s.dir()); err != nil {
+		return err
+	}
+	_, err = h.db.Exec(`DELETE FROM shared_folder_snapshots WHERE id = $1`, id)
+	return err
+}
+
+// pruneSnapshots deletes snapshots past their retention
+func (h *SharedFolderHandler) pruneSnapshots(run *JobRun) error {
+	rows, err := h.db.Query(`
+		SELECT id FROM shared_folder_snapshots
+		WHERE expires_at < NOW() AND state IN ($1, $2)
+	`, SnapshotReady, SnapshotFailed)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	deleted := 0
+	for _, id := range ids {
+		if err := run.Pace(); err != nil {
+			return err
+		}
+		if err := h.deleteSnapshotFiles(run, id); err != nil {
+			log.Printf("[Snapshots] Failed to delete expired snapshot %s: %v", id, err)
+			continue
+		}
+		deleted++
+	}
+	run.SetProgress(map[string]interface{}{"deleted": deleted})
+	return nil
+}
+
+// loadSnapshotParam loads the :snapId snapshot of the :id drive
+func (h *SharedFolderHandler) loadSnapshotParam(c echo.Context) (*SharedFolderSnapshot, *APIError) {
+	s, err := h.loadSnapshot(c.Param("snapId"))
+	if err != nil || s.SharedFolderID != c.Param("id") {
+		return nil, ErrNotFound("Snapshot")
+	}
+	return s, nil
+}
+
+// insertSnapshot records a snapshot to take of a drive. Snapshots are full
+// copies if asked or while SMB is enabled.
+func (h *SharedFolderHandler) insertSnapshot(folderID, folderName, name string, retentionDays int, fullCopy bool, actorID string) (*SharedFolderSnapshot, error) {
+	s := &SharedFolderSnapshot{
+		SharedFolderID: folderID,
+		Name:           name,
+		Mode:           SnapshotModeHardlink,
+		State:          SnapshotCreating,
+		CreatedBy:      &actorID,
+		volume:         GetStorageLocations().Volume("shared/" + sanitizeFolderName(folderName)),
+		folderName:     folderName,
+	}
+	if s.volume == "" {
+		s.volume = h.dataRoot
+	}
+	switch {
+	case fullCopy:
+		s.Mode = SnapshotModeCopy
+	case smbWritesInPlace():
+		s.Mode = SnapshotModeCopy
+		s.Warning = "SMB is enabled and SMB clients write files in place, so the files are copied in full"
+	}
+	if retentionDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, retentionDays)
+		s.ExpiresAt = &expiresAt
+	}
+	err := h.db.QueryRow(`
+		INSERT INTO shared_folder_snapshots (shared_folder_id, name, volume, mode, state, warning, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+		RETURNING id, created_at
+	`, folderID, name, s.volume, s.Mode, s.State, s.Warning, actorID, s.ExpiresAt).Scan(&s.ID, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// enqueueSnapshotJob queues a snapshot operation and records its job on the snapshot
+func (h *SharedFolderHandler) enqueueSnapshotJob(s *SharedFolderSnapshot, params snapshotJobParams, actorID string) (int64, error) {
+	jobID, err := GetJobs().Enqueue(JobSnapshot, params, &actorID)
+	if err != nil {
+		return 0, err
+	}
+	s.JobID = &jobID
+	_, _ = h.db.Exec(`UPDATE shared_folder_snapshots SET job_id = $2 WHERE id = $1`, s.ID, jobID)
+	return jobID, nil
+}
+
+// ListSnapshots lists the snapshots of a shared drive
+// @Summary		List shared drive snapshots
+// @Description	Snapshots of a drive, newest first. sizeBytes is the drive's size when the snapshot was taken; exclusiveBytes is what only the snapshot holds, which deleting it frees. Snapshots are not counted in the drive's usage.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Shared folder ID"
+// @Success		200	{object}	docs.SuccessResponse	"Snapshots and their totals"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/shared-folders/{id}/snapshots [get]
+func (h *SharedFolderHandler) ListSnapshots(c echo.Context) error {
+	folderID := c.Param("id")
+	var folderName string
+	if err := h.db.QueryRow(`SELECT name FROM shared_folders WHERE id = $1`, folderID).Scan(&folderName); err != nil {
+		return RespondError(c, ErrNotFound("Shared folder"))
+	}
+
+	rows, err := h.db.Query(`SELECT `+snapshotColumns+snapshotFrom+` WHERE s.shared_folder_id = $1 ORDER BY s.created_at DESC`, folderID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list snapshots", err))
+	}
+	defer rows.Close()
+
+	snapshots := []*SharedFolderSnapshot{}
+	var exclusiveBytes int64
+	for rows.Next() {
+		s, err := scanSnapshot(rows.Scan)
+		if err != nil {
+			return RespondError(c, ErrOperationFailed("list snapshots", err))
+		}
+		if s.State == SnapshotReady || s.State == SnapshotRestoring {
+			s.ExclusiveBytes = snapshotExclusiveBytes(s.dir())
+			exclusiveBytes += s.ExclusiveBytes
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"snapshots":      snapshots,
+		"total":          len(snapshots),
+		"exclusiveBytes": exclusiveBytes,
+	})
+}
+
+// CreateSnapshot takes a snapshot of a shared drive in the background
+// @Summary		Create shared drive snapshot
+// @Description	Queues a job taking a point-in-time snapshot of the drive. Files are hard-linked when the snapshots area shares the drive's filesystem and copied otherwise, or when fullCopy is set or SMB is enabled; warning explains a copy. retentionDays deletes the snapshot after that many days; 0 keeps it until deleted.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string	true	"Shared folder ID"
+// @Param		request	body		object	true	"name, retentionDays, fullCopy"
+// @Success		202		{object}	docs.SuccessResponse	"Snapshot queued"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid request"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/shared-folders/{id}/snapshots [post]
+func (h *SharedFolderHandler) CreateSnapshot(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	folderID := c.Param("id")
+
+	var req struct {
+		Name          string `json:"name"`
+		RetentionDays int    `json:"retentionDays"`
+		FullCopy      bool   `json:"fullCopy"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		req.Name = "Snapshot " + time.Now().Format("2006-01-02 15:04")
+	}
+	if len(req.Name) > 255 {
+		return RespondError(c, ErrBadRequest("Name must be at most 255 characters"))
+	}
+	if req.RetentionDays < 0 || req.RetentionDays > maxSnapshotRetentionDays {
+		return RespondError(c, ErrBadRequest(fmt.Sprintf("retentionDays must be between 0 and %d", maxSnapshotRetentionDays)))
+	}
+
+	var folderName string
+	if err := h.db.QueryRow(`SELECT name FROM shared_folders WHERE id = $1`, folderID).Scan(&folderName); err != nil {
+		return RespondError(c, ErrNotFound("Shared folder"))
+	}
+
+	s, err := h.insertSnapshot(folderID, folderName, req.Name, req.RetentionDays, req.FullCopy, claims.UserID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("create snapshot", err))
+	}
+	s.CreatedByUsername = claims.Username
+	jobID, err := h.enqueueSnapshotJob(s, snapshotJobParams{Op: "create", SnapshotID: s.ID}, claims.UserID)
+	if err != nil {
+		h.setSnapshotState(s.ID, SnapshotFailed, err)
+		return RespondError(c, ErrOperationFailed("queue snapshot", err))
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminSnapshotCreate,
+		fmt.Sprintf("/shared/%s", sanitizeFolderName(folderName)), map[string]interface{}{
+			"snapshotId":    s.ID,
+			"name":          s.Name,
+			"mode":          s.Mode,
+			"retentionDays": req.RetentionDays,
+			"jobId":         jobID,
+		})
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data":    s,
+		"jobId":   jobID,
+	})
+}
+
+// RestoreSnapshot rolls a shared drive back to a snapshot in the background
+// @Summary		Restore shared drive snapshot
+// @Description	Queues a job rolling the drive back to the snapshot: changed files are replaced and files added since are deleted. With preserveCurrent the current state is snapshotted first, as a snapshot named "Before restoring {name}". Cancelling the job leaves the drive partly restored. Drives holding a retention policy cannot be restored.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string	true	"Shared folder ID"
+// @Param		snapId	path		string	true	"Snapshot ID"
+// @Param		request	body		object	false	"preserveCurrent"
+// @Success		202		{object}	docs.SuccessResponse	"Restore queued"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Snapshot not ready"
+// @Failure		423		{object}	docs.ErrorResponse	"Retention hold"
+// @Security	BearerAuth
+// @Router		/admin/shared-folders/{id}/snapshots/{snapId}/restore [post]
+func (h *SharedFolderHandler) RestoreSnapshot(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		PreserveCurrent bool `json:"preserveCurrent"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+
+	s, apiErr := h.loadSnapshotParam(c)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if s.State != SnapshotReady {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "The snapshot is not ready").
+			WithDetails(map[string]interface{}{"state": s.State}))
+	}
+	if GetRetention().HoldsDrive(s.SharedFolderID) {
+		return RespondError(c, NewAPIError(ErrCodeRetentionHold, "This drive holds a retention policy and cannot be restored"))
+	}
+
+	params := snapshotJobParams{Op: "restore", SnapshotID: s.ID}
+	var preserved *SharedFolderSnapshot
+	if req.PreserveCurrent {
+		preserved, err = h.insertSnapshot(s.SharedFolderID, s.folderName, "Before restoring "+s.Name, 0, false, claims.UserID)
+		if err != nil {
+			return RespondError(c, ErrOperationFailed("create snapshot", err))
+		}
+		preserved.CreatedByUsername = claims.Username
+		params.PreserveID = preserved.ID
+	}
+	h.setSnapshotState(s.ID, SnapshotRestoring, nil)
+	s.State = SnapshotRestoring
+	jobID, err := h.enqueueSnapshotJob(s, params, claims.UserID)
+	if err != nil {
+		h.setSnapshotState(s.ID, SnapshotReady, nil)
+		if preserved != nil {
+			h.setSnapshotState(preserved.ID, SnapshotFailed, err)
+		}
+		return RespondError(c, ErrOperationFailed("queue restore", err))
+	}
+	if preserved != nil {
+		preserved.JobID = &jobID
+		_, _ = h.db.Exec(`UPDATE shared_folder_snapshots SET job_id = $2 WHERE id = $1`, preserved.ID, jobID)
+	}
+
+	details := map[string]interface{}{
+		"snapshotId": s.ID,
+		"name":       s.Name,
+		"jobId":      jobID,
+	}
+	if preserved != nil {
+		details["preservedId"] = preserved.ID
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminSnapshotRestore,
+		fmt.Sprintf("/shared/%s", sanitizeFolderName(s.folderName)), details)
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"snapshot":  s,
+			"preserved": preserved,
+		},
+		"jobId": jobID,
+	})
+}
+
+// DeleteSnapshot deletes a snapshot in the background
+// @Summary		Delete shared drive snapshot
+// @Description	Queues a job deleting the snapshot's files. Snapshots being taken or restored cannot be deleted.
+// @Tags		Admin
+// @Produce		json
+// @Param		id		path		string	true	"Shared folder ID"
+// @Param		snapId	path		string	true	"Snapshot ID"
+// @Success		202		{object}	docs.SuccessResponse	"Deletion queued"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Snapshot busy"
+// @Security	BearerAuth
+// @Router		/admin/shared-folders/{id}/snapshots/{snapId} [delete]
+func (h *SharedFolderHandler) DeleteSnapshot(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	s, apiErr := h.loadSnapshotParam(c)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if s.State != SnapshotReady && s.State != SnapshotFailed {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "The snapshot is busy").
+			WithDetails(map[string]interface{}{"state": s.State}))
+	}
+
+	previous := s.State
+	h.setSnapshotState(s.ID, SnapshotDeleting, nil)
+	s.State = SnapshotDeleting
+	jobID, err := h.enqueueSnapshotJob(s, snapshotJobParams{Op: "delete", SnapshotID: s.ID}, claims.UserID)
+	if err != nil {
+		h.setSnapshotState(s.ID, previous, nil)
+		return RespondError(c, ErrOperationFailed("queue snapshot deletion", err))
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminSnapshotDelete,
+		fmt.Sprintf("/shared/%s", sanitizeFolderName(s.folderName)), map[string]interface{}{
+			"snapshotId": s.ID,
+			"name":       s.Name,
+			"jobId":      jobID,
+		})
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data":    s,
+		"jobId":   jobID,
+	})
+}
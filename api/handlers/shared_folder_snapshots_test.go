@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func sameTestFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	ia, errA := os.Stat(a)
+	ib, errB := os.Stat(b)
+	if errA != nil || errB != nil {
+		t.Fatalf("stat: %v, %v", errA, errB)
+	}
+	return os.SameFile(ia, ib)
+}
+
+// takeTestSnapshot snapshots a drive with hard links
+func takeTestSnapshot(t *testing.T) (live, snap string, run *JobRun) {
+	t.Helper()
+	root := t.TempDir()
+	live = filepath.Join(root, "shared", "Team")
+	snap = filepath.Join(root, snapshotsDirName, "f1", "s1")
+	writeTestFiles(t, live, "a.txt", "docs/b.txt", "docs/c.txt", uploadStagingDirName+"/partial")
+	for name, content := range map[string]string{"a.txt": "one", "docs/b.txt": "two", "docs/c.txt": "three"} {
+		if err := os.WriteFile(filepath.Join(live, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(snap), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = removeSnapshotDir(snap) })
+
+	run = &JobRun{ID: 1, Type: JobSnapshot, ctx: context.Background()}
+	progress := &snapshotProgress{}
+	if err := snapshotTree(run, live, snap, true, progress); err != nil {
+		t.Fatal(err)
+	}
+	if progress.Files != 3 || progress.Bytes != 11 {
+		t.Fatalf("progress = %+v", progress)
+	}
+	return live, snap, run
+}
+
+func TestSnapshotTree_CopyOnWrite(t *testing.T) {
+	live, snap, _ := takeTestSnapshot(t)
+
+	if !sameTestFile(t, filepath.Join(live, "a.txt"), filepath.Join(snap, "a.txt")) {
+		t.Fatal("snapshot file is not a hard link")
+	}
+	if _, err := os.Stat(filepath.Join(snap, uploadStagingDirName)); !os.IsNotExist(err) {
+		t.Error("upload staging was snapshotted")
+	}
+	if info, err := os.Stat(filepath.Join(snap, "docs")); err != nil || info.Mode().Perm() != 0555 {
+		t.Errorf("snapshot directory is writable: %v", info.Mode())
+	}
+	if got := snapshotExclusiveBytes(snap); got != 0 {
+		t.Errorf("exclusive bytes of an unchanged snapshot = %d", got)
+	}
+
+	// Saving a file replaces it instead of writing through the link
+	if err := WriteFileSealed(filepath.Join(live, "a.txt"), []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if readTestFile(t, filepath.Join(snap, "a.txt")) != "one" || readTestFile(t, filepath.Join(live, "a.txt")) != "edited" {
+		t.Error("save changed the snapshot")
+	}
+
+	// Appends and range writes get a private copy of the old content first
+	b := filepath.Join(live, "docs", "b.txt")
+	if err := copyOnWrite(b, true); err != nil {
+		t.Fatal(err)
+	}
+	if sameTestFile(t, b, filepath.Join(snap, "docs", "b.txt")) || readTestFile(t, b) != "two" {
+		t.Fatal("copy on write kept the link or lost the content")
+	}
+	f, err := os.OpenFile(b, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("+more")
+	f.Close()
+	if readTestFile(t, filepath.Join(snap, "docs", "b.txt")) != "two" {
+		t.Error("append changed the snapshot")
+	}
+
+	// Copying or extracting over a linked file leaves the snapshot alone
+	src := filepath.Join(t.TempDir(), "new.txt")
+	_ = os.WriteFile(src, []byte("copied"), 0644)
+	if err := copyFile(src, filepath.Join(live, "docs", "c.txt"), copyPreserve{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeExtractedFile(filepath.Join(live, "a.txt"), strings.NewReader("extracted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if readTestFile(t, filepath.Join(snap, "docs", "c.txt")) != "three" || readTestFile(t, filepath.Join(snap, "a.txt")) != "one" {
+		t.Error("copy or extract changed the snapshot")
+	}
+
+	// Everything diverged, so the snapshot holds all its bytes alone
+	if got := snapshotExclusiveBytes(snap); got != 11 {
+		t.Errorf("exclusive bytes = %d, want 11", got)
+	}
+
+	// A file with a single link is written in place
+	single := filepath.Join(live, "a.txt")
+	before, _ := os.Stat(single)
+	if err := copyOnWrite(single, true); err != nil {
+		t.Fatal(err)
+	}
+	if !sameFileInfo(t, before, single) {
+		t.Error("unlinked file was copied")
+	}
+}
+
+func sameFileInfo(t *testing.T, before os.FileInfo, path string) bool {
+	t.Helper()
+	after, err := os.Stat(path)
+	return err == nil && os.SameFile(before, after)
+}
+
+func TestRestoreTree(t *testing.T) {
+	live, snap, run := takeTestSnapshot(t)
+
+	// Edit, delete and add after the snapshot
+	_ = WriteFileSealed(filepath.Join(live, "a.txt"), []byte("edited"), 0644)
+	_ = os.Remove(filepath.Join(live, "docs", "b.txt"))
+	writeTestFiles(t, live, "new.txt", "extra/deep/file.txt")
+	_ = os.RemoveAll(filepath.Join(live, "docs", "c.txt"))
+	writeTestFiles(t, live, "docs/c.txt/now-a-dir")
+
+	progress := &snapshotProgress{}
+	if err := restoreTree(run, snap, live, true, progress); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "one", "docs/b.txt": "two", "docs/c.txt": "three"} {
+		if got := readTestFile(t, filepath.Join(live, name)); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+		if !sameTestFile(t, filepath.Join(live, name), filepath.Join(snap, name)) {
+			t.Errorf("%s not linked to the snapshot again", name)
+		}
+	}
+	for _, name := range []string{"new.txt", "extra"} {
+		if _, err := os.Lstat(filepath.Join(live, name)); !os.IsNotExist(err) {
+			t.Errorf("%s survived the restore", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(live, uploadStagingDirName, "partial")); err != nil {
+		t.Error("restore removed upload staging")
+	}
+	if progress.Files != 3 {
+		t.Errorf("progress = %+v", progress)
+	}
+
+	// Later edits still leave the snapshot alone
+	_ = WriteFileSealed(filepath.Join(live, "a.txt"), []byte("again"), 0644)
+	if readTestFile(t, filepath.Join(snap, "a.txt")) != "one" {
+		t.Error("edit after restore changed the snapshot")
+	}
+
+	// Read-only snapshots can still be removed
+	if err := removeSnapshotDir(snap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(snap); !os.IsNotExist(err) {
+		t.Error("snapshot not removed")
+	}
+}
+
+func TestRestoreSnapshot_Checks(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &SharedFolderHandler{db: tc.DB, dataRoot: t.TempDir(), auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+
+	snapshotRow := func(folderID, state string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "shared_folder_id", "name", "volume", "mode", "state", "warning", "error",
+			"file_count", "size_bytes", "job_id", "created_by", "username", "created_at", "expires_at", "folder"}).
+			AddRow("s1", folderID, "Before reorg", h.dataRoot, SnapshotModeHardlink, state, "", "", 3, 11, nil, nil, "", time.Now(), nil, "Team")
+	}
+	restore := func() *http.Response {
+		rec := httptest.NewRecorder()
+		req, _ := NewJSONRequest(http.MethodPost, "/api/admin/shared-folders/f1/snapshots/s1/restore", map[string]bool{"preserveCurrent": false})
+		c := CreateAuthenticatedContext(tc.Echo, rec, req, "u1", "admin", true)
+		c.SetParamNames("id", "snapId")
+		c.SetParamValues("f1", "s1")
+		if err := h.RestoreSnapshot(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Result()
+	}
+
+	// A snapshot of another drive does not exist here
+	tc.Mock.ExpectQuery("FROM shared_folder_snapshots").WithArgs("s1").WillReturnRows(snapshotRow("f2", SnapshotReady))
+	if resp := restore(); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("foreign snapshot: status %d", resp.StatusCode)
+	}
+
+	tc.Mock.ExpectQuery("FROM shared_folder_snapshots").WithArgs("s1").WillReturnRows(snapshotRow("f1", SnapshotCreating))
+	if resp := restore(); resp.StatusCode != http.StatusConflict {
+		t.Errorf("unfinished snapshot: status %d", resp.StatusCode)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
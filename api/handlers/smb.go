@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	"net/http"
@@ -15,9 +16,10 @@ import (
 )
 
 type SMBHandler struct {
-	db         *sql.DB
-	configPath string
-	crypto     *SMBCrypto
+	db           *sql.DB
+	configPath   string
+	crypto       *SMBCrypto
+	auditHandler *AuditHandler
 }
 
 func NewSMBHandler(db *sql.DB, configPath string) *SMBHandler {
@@ -28,9 +30,10 @@ func NewSMBHandler(db *sql.DB, configPath string) *SMBHandler {
 	}
 
 	handler := &SMBHandler{
-		db:         db,
-		configPath: configPath,
-		crypto:     crypto,
+		db:           db,
+		configPath:   configPath,
+		crypto:       crypto,
+		auditHandler: NewAuditHandler(db, ""),
 	}
 
 	// Migrate existing plaintext passwords if crypto is available
@@ -187,6 +190,7 @@ func (h *SMBHandler) CreateSMBUser(c echo.Context) error {
 		// Log but don't fail - user was created
 		fmt.Printf("Warning: Failed to write SMB users file: %v\n", err)
 	}
+	h.syncDriveShares(c, "smb_user_create")
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"success":  true,
@@ -250,6 +254,7 @@ func (h *SMBHandler) SetSMBPassword(c echo.Context) error {
 	if err := h.updateSMBUserPassword(req.Username, req.Password); err != nil {
 		fmt.Printf("Warning: Failed to write SMB users file: %v\n", err)
 	}
+	h.syncDriveShares(c, "smb_password_set")
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -291,6 +296,7 @@ func (h *SMBHandler) DeleteSMBUser(c echo.Context) error {
 	if err := h.removeSMBUser(username); err != nil {
 		fmt.Printf("Warning: Failed to update SMB users file: %v\n", err)
 	}
+	h.syncDriveShares(c, "smb_user_delete")
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -366,12 +372,16 @@ func (h *SMBHandler) UpdateSMBConfig(c echo.Context) error {
 
 [data]
    path = /data
+   veto files = /.snapshots/
    browseable = yes
    read only = no
    guest ok = {{if .GuestAccess}}yes{{else}}no{{end}}
    valid users = @users
    create mask = 0644
    directory mask = 0755
+
+# Shared drives exported over SMB
+{{.Include}}
 `
 
 	t, err := template.New("smb").Parse(tmpl)
@@ -381,20 +391,39 @@ func (h *SMBHandler) UpdateSMBConfig(c echo.Context) error {
 		})
 	}
 
-	configPath := filepath.Join(h.configPath, "smb.conf")
-	f, err := os.Create(configPath)
-	if err != nil {
+	// Values are single config lines; a newline would inject parameters
+	config.Workgroup = smbValue(config.Workgroup)
+	config.ServerName = smbValue(config.ServerName)
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, struct {
+		SMBConfig
+		Include string
+	}{config, smbIncludeLine()}); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to write config file",
+			"error": "Failed to generate config",
+		})
+	}
+	if issues := checkSMBConfig("smb.conf", buf.Bytes()); smbConfigHasErrors(issues) {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error":  "Generated SMB config is not valid",
+			"issues": issues,
 		})
 	}
-	defer f.Close()
 
-	if err := t.Execute(f, config); err != nil {
+	// Write in place: smb.conf is bind-mounted into the samba container as a file
+	configPath := filepath.Join(h.configPath, "smb.conf")
+	if err := os.WriteFile(configPath, buf.Bytes(), 0644); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": "Failed to generate config",
+			"error": "Failed to write config file",
 		})
 	}
+	h.auditHandler.LogEventFromContext(c, "smb_config_write", configPath, map[string]interface{}{
+		"reason":      "global_config",
+		"workgroup":   config.Workgroup,
+		"serverName":  config.ServerName,
+		"guestAccess": config.GuestAccess,
+	})
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -402,6 +431,93 @@ func (h *SMBHandler) UpdateSMBConfig(c echo.Context) error {
 	})
 }
 
+// syncDriveShares re-renders shared drive exports after an SMB account
+// change, as valid users only lists members with an SMB password
+func (h *SMBHandler) syncDriveShares(c echo.Context, reason string) {
+	var actorID *string
+	if claims := GetClaims(c); claims != nil {
+		actorID = &claims.UserID
+	}
+	GetSMBShares().Sync(actorID, c.RealIP(), reason)
+}
+
+// SMBValidateRequest optionally proposes one shared drive's SMB export
+type SMBValidateRequest struct {
+	FolderID    string           `json:"folderId"` // Empty for a drive that is not created yet
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	SMB         *SharedFolderSMB `json:"smb"`
+}
+
+// SMBValidateResponse is the result of a dry run
+type SMBValidateResponse struct {
+	Valid  bool              `json:"valid"`
+	Issues []SMBConfigIssue  `json:"issues"`
+	Files  map[string]string `json:"files"` // Rendered shared drive sections by file name
+}
+
+// ValidateSMBConfig checks smb.conf and the shared drive sections without
+// writing anything, optionally with one drive's export changed
+// @Summary		Validate SMB config
+// @Description	Dry run: parses smb.conf and renders all shared drive SMB sections (with the proposed drive export applied, if given) the way testparm would, and reports errors that would stop Samba from loading its config.
+// @Tags		SMB
+// @Accept		json
+// @Produce		json
+// @Param		request	body		SMBValidateRequest	false	"Proposed drive export"
+// @Success		200		{object}	SMBValidateResponse	"Validation result"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/smb/validate [post]
+func (h *SMBHandler) ValidateSMBConfig(c echo.Context) error {
+	if _, err := RequireAdminPermission(c, PermSettingsWrite); err != nil {
+		return err
+	}
+
+	var req SMBValidateRequest
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&req); err != nil {
+			return RespondError(c, ErrBadRequest("Invalid request"))
+		}
+	}
+
+	var change *smbShare
+	if req.SMB != nil {
+		name := sanitizeFolderName(strings.TrimSpace(req.Name))
+		description := req.Description
+		if req.FolderID != "" && name == "" {
+			err := h.db.QueryRow("SELECT name, COALESCE(description, '') FROM shared_folders WHERE id = $1", req.FolderID).
+				Scan(&name, &description)
+			if err != nil {
+				return RespondError(c, ErrNotFound("Shared folder"))
+			}
+			name = sanitizeFolderName(name)
+		}
+		if name == "" {
+			return RespondError(c, ErrMissingParameter("name"))
+		}
+		change = &smbShare{FolderID: req.FolderID, Name: name, Description: description, SMB: *req.SMB}
+	}
+
+	files, issues, err := GetSMBShares().Preview(change)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("render SMB shares", err))
+	}
+	if content, err := os.ReadFile(filepath.Join(h.configPath, "smb.conf")); err == nil {
+		issues = append(checkSMBConfig("smb.conf", content), issues...)
+	}
+
+	resp := SMBValidateResponse{
+		Valid:  !smbConfigHasErrors(issues),
+		Issues: issues,
+		Files:  make(map[string]string, len(files)),
+	}
+	for name, data := range files {
+		resp.Files[name] = string(data)
+	}
+	return RespondSuccess(c, resp)
+}
+
 // updateSMBUserPassword adds or updates a user's password using encrypted storage
 func (h *SMBHandler) updateSMBUserPassword(username, password string) error {
 	if h.crypto != nil {
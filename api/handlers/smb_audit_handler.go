@@ -102,6 +102,21 @@ func parseAuditLine(line string) (*SMBAuditEntry, error) {
 	return entry, nil
 }
 
+// smbRealPath returns the data path an audit entry refers to. Samba logs
+// paths relative to the share root for some operations.
+func smbRealPath(entry *SMBAuditEntry) string {
+	if filepath.IsAbs(entry.FilePath) {
+		return filepath.Clean(entry.FilePath)
+	}
+	if entry.ShareName == "shared" {
+		return filepath.Join("/data/shared", entry.FilePath)
+	}
+	if drive, ok := strings.CutPrefix(entry.ShareName, smbDriveSharePrefix); ok {
+		return filepath.Join("/data/shared", drive, entry.FilePath)
+	}
+	return filepath.Join("/data/users", entry.Username, entry.FilePath)
+}
+
 // mapOperationToAction maps SMB operations to audit action types
 // Samba 4.22+ uses new operation names: openat, mkdirat, unlinkat, renameat
 func mapOperationToAction(op string) string {
@@ -184,12 +199,17 @@ func (h *SMBAuditHandler) ProcessAuditLog() (int, error) {
 		auditPath := entry.FilePath
 		if entry.ShareName == "shared" {
 			auditPath = "/shared-drives" + strings.TrimPrefix(entry.FilePath, "/data/shared")
+		} else if strings.HasPrefix(entry.ShareName, smbDriveSharePrefix) {
+			auditPath = "/shared-drives" + strings.TrimPrefix(smbRealPath(entry), "/data/shared")
 		} else if entry.ShareName != "" {
 			auditPath = "/home/" + entry.Username + strings.TrimPrefix(entry.FilePath, "/data/users/"+entry.Username)
 		}
 
 		// Log to audit table
 		action := mapOperationToAction(entry.Operation)
+		if action == "smb_create" || action == "smb_write" {
+			GetWriterHints().NoteSMB(smbRealPath(entry), uid, entry.Username, entry.Timestamp)
+		}
 		_ = h.auditHandler.LogEvent(userID, entry.ClientIP, action, auditPath, map[string]interface{}{
 			"smbShare":  entry.ShareName,
 			"smbClient": entry.Hostname,
@@ -284,19 +304,27 @@ func (h *SMBAuditHandler) SyncSMBAuditLogs(c echo.Context) error {
 	})
 }
 
-// StartBackgroundSync starts a background goroutine that periodically syncs audit logs
-func (h *SMBAuditHandler) StartBackgroundSync(interval time.Duration) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+// JobSMBAuditSync is the job type importing the SMB audit log
+const JobSMBAuditSync = "smb.audit_sync"
 
-		for range ticker.C {
+// StartBackgroundSync registers the SMB audit sync job and schedules it
+// every interval
+func (h *SMBAuditHandler) StartBackgroundSync(interval time.Duration) {
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:       JobSMBAuditSync,
+		Idempotent: true,
+		Run: func(run *JobRun) error {
 			count, err := h.ProcessAuditLog()
 			if err != nil {
-				fmt.Printf("SMB audit sync error: %v\n", err)
-			} else if count > 0 {
+				return err
+			}
+			if count > 0 {
 				fmt.Printf("SMB audit sync: processed %d entries\n", count)
 			}
-		}
-	}()
+			run.SetProgress(map[string]interface{}{"processed": count})
+			return nil
+		},
+	})
+	jobs.Schedule(JobSMBAuditSync, interval, false)
 }
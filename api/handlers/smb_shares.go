@@ -0,0 +1,600 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Shared drives exported over SMB get one section file each in an include
+// directory next to the user sync file. smb.conf pulls them in through one
+// index file, and the samba container reloads when the trigger changes.
+const (
+	smbSharesDir       = "smb.d"
+	smbSharesIndex     = "shares.conf"
+	smbReloadTrigger   = ".reload"
+	smbContainerConfig = "/etc/filehatch" // configPath as mounted in the samba container
+	smbContainerData   = "/data"
+
+	// smbDriveSharePrefix marks drive shares in the full_audit prefix, so
+	// audit entries map back to /data/shared/{name} instead of a home
+	smbDriveSharePrefix = "drive:"
+
+	maxSMBShareName = 80
+)
+
+// smbReservedShares are section names smb.conf already uses
+var smbReservedShares = map[string]bool{
+	"global": true, "homes": true, "printers": true, "shared": true, "data": true, "ipc$": true,
+}
+
+// SharedFolderSMB is the SMB export of a shared drive
+type SharedFolderSMB struct {
+	Enabled    bool     `json:"enabled"`
+	Browseable bool     `json:"browseable"`
+	GuestOK    bool     `json:"guestOk"`
+	RecycleBin bool     `json:"recycleBin"`
+	ReadOnly   bool     `json:"readOnly"`
+	ValidUsers []string `json:"validUsers,omitempty"` // Members with an SMB password, filled in on render
+}
+
+// smbShareMember is a drive member with an SMB account
+type smbShareMember struct {
+	Username string
+	CanWrite bool
+}
+
+// smbShare is everything needed to render one share section
+type smbShare struct {
+	FolderID    string
+	Name        string
+	Description string
+	SMB         SharedFolderSMB
+	Members     []smbShareMember
+}
+
+// SMBConfigIssue is a problem found while checking SMB config text
+type SMBConfigIssue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"` // error or warning
+	Message  string `json:"message"`
+}
+
+// SMBConfigError is returned when rendered config would not load
+type SMBConfigError struct {
+	Issues []SMBConfigIssue
+}
+
+func (e *SMBConfigError) Error() string {
+	for _, issue := range e.Issues {
+		if issue.Severity == "error" {
+			return fmt.Sprintf("invalid SMB config: %s:%d: %s", issue.File, issue.Line, issue.Message)
+		}
+	}
+	return "invalid SMB config"
+}
+
+// smbBoolParams are parameters that only take a boolean
+var smbBoolParams = map[string]bool{
+	"browseable": true, "browsable": true, "read only": true, "writable": true, "writeable": true,
+	"guest ok": true, "public": true, "available": true, "guest only": true, "printable": true,
+	"load printers": true, "disable spoolss": true, "disable netbios": true, "follow symlinks": true,
+	"wide links": true, "hide dot files": true, "inherit permissions": true, "store dos attributes": true,
+}
+
+// smbKnownParams are the other parameters FileHatch writes or documents.
+// Module parameters (name:option) are accepted as-is, as Samba does.
+var smbKnownParams = map[string]bool{
+	"workgroup": true, "server string": true, "security": true, "map to guest": true, "passdb backend": true,
+	"log level": true, "log file": true, "max log size": true, "restrict anonymous": true,
+	"server min protocol": true, "server max protocol": true, "ntlm auth": true, "printing": true,
+	"printcap name": true, "include": true, "path": true, "comment": true, "create mask": true,
+	"directory mask": true, "force create mode": true, "force directory mode": true, "valid users": true,
+	"invalid users": true, "read list": true, "write list": true, "admin users": true, "force user": true,
+	"force group": true, "vfs objects": true, "hosts allow": true, "hosts deny": true, "veto files": true,
+	"delete veto files": true, "netbios name": true, "interfaces": true, "bind interfaces only": true,
+	"server role": true, "guest account": true, "min protocol": true, "max protocol": true,
+}
+
+var smbBoolValues = map[string]bool{
+	"yes": true, "no": true, "true": true, "false": true, "1": true, "0": true, "on": true, "off": true,
+}
+
+// checkSMBConfig parses config text the way smbd/testparm does and reports
+// lines that would stop the config from loading (errors) or be ignored
+// (warnings). file names the source in the reported issues.
+func checkSMBConfig(file string, data []byte) []SMBConfigIssue {
+	issues := make([]SMBConfigIssue, 0)
+	report := func(line int, severity, format string, args ...interface{}) {
+		issues = append(issues, SMBConfigIssue{File: file, Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	sections := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo, start := 0, 0
+	logical := ""
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimSpace(scanner.Text())
+		if logical == "" {
+			start = lineNo
+		}
+		// A trailing backslash continues the line
+		if strings.HasSuffix(text, "\\") {
+			logical += strings.TrimSuffix(text, "\\") + " "
+			continue
+		}
+		text = strings.TrimSpace(logical + text)
+		logical = ""
+
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				report(start, "error", "unterminated section header %q", text)
+				continue
+			}
+			name := strings.TrimSpace(text[1 : len(text)-1])
+			switch {
+			case name == "":
+				report(start, "error", "empty section name")
+			case strings.ContainsAny(name, "[]"):
+				report(start, "error", "invalid section name %q", name)
+			case sections[strings.ToLower(name)] > 0:
+				report(start, "error", "section [%s] is already defined on line %d", name, sections[strings.ToLower(name)])
+			default:
+				sections[strings.ToLower(name)] = start
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			report(start, "error", "expected \"name = value\" or a [section], got %q", text)
+			continue
+		}
+		key = strings.ToLower(strings.Join(strings.Fields(key), " "))
+		value = strings.TrimSpace(value)
+		switch {
+		case key == "":
+			report(start, "error", "missing parameter name")
+		case smbBoolParams[key]:
+			if !smbBoolValues[strings.ToLower(value)] {
+				report(start, "error", "%s expects yes or no, got %q", key, value)
+			}
+		case key == "path" && value == "":
+			report(start, "error", "path is empty")
+		case !smbKnownParams[key] && !strings.Contains(key, ":"):
+			report(start, "warning", "unknown parameter %q is ignored", key)
+		}
+	}
+	if logical != "" {
+		report(start, "error", "line continuation at end of file")
+	}
+	return issues
+}
+
+// smbConfigHasErrors reports whether any issue is an error
+func smbConfigHasErrors(issues []SMBConfigIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// smbShareNameProblem explains why a drive name cannot be a share name
+func smbShareNameProblem(name string) string {
+	switch {
+	case name == "" || len(name) > maxSMBShareName:
+		return fmt.Sprintf("share names must be 1-%d bytes", maxSMBShareName)
+	case strings.ContainsAny(name, "[]=;,+%\"\\/:*?<>|") || strings.ContainsFunc(name, func(r rune) bool { return r < 0x20 || r == 0x7f }):
+		return "share names cannot contain [ ] = ; , + % \" \\ / : * ? < > | or control characters"
+	case smbReservedShares[strings.ToLower(name)]:
+		return fmt.Sprintf("%q is used by the built-in SMB shares", name)
+	}
+	return ""
+}
+
+// smbList quotes a user list for valid users / write list
+func smbList(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.ContainsAny(name, " \t") {
+			name = `"` + name + `"`
+		}
+		quoted = append(quoted, name)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// smbValue flattens free text for a single config line
+func smbValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+	return strings.TrimRight(strings.Join(strings.Fields(s), " "), "\\")
+}
+
+func smbYesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// renderSMBShare writes the section for one drive. The share itself is
+// read-only and writers are listed in write list, so drive permissions,
+// guests and the read-only flag all come down to one list.
+func renderSMBShare(s *smbShare) []byte {
+	var b bytes.Buffer
+	param := func(key, value string) {
+		fmt.Fprintf(&b, "   %s = %s\n", key, value)
+	}
+
+	users := make([]string, 0, len(s.Members))
+	writers := make([]string, 0, len(s.Members))
+	for _, m := range s.Members {
+		users = append(users, m.Username)
+		if m.CanWrite {
+			writers = append(writers, m.Username)
+		}
+	}
+	s.SMB.ValidUsers = users
+
+	fmt.Fprintf(&b, "# Shared drive %s - managed by FileHatch, changes are overwritten\n", s.FolderID)
+	fmt.Fprintf(&b, "[%s]\n", s.Name)
+	param("path", smbContainerData+"/shared/"+s.Name)
+	if comment := smbValue(s.Description); comment != "" {
+		param("comment", comment)
+	}
+	param("browseable", smbYesNo(s.SMB.Browseable))
+	param("guest ok", smbYesNo(s.SMB.GuestOK))
+	param("read only", "yes")
+	switch {
+	case s.SMB.GuestOK:
+		// Anyone can read; members below can still write
+	case len(users) == 0:
+		// An empty valid users list would admit everyone
+		param("available", "no")
+	default:
+		param("valid users", smbList(users))
+	}
+	if !s.SMB.ReadOnly && len(writers) > 0 {
+		param("write list", smbList(writers))
+	}
+	param("force group", "users")
+	param("create mask", "0664")
+	param("directory mask", "0775")
+	if s.SMB.RecycleBin {
+		param("vfs objects", "full_audit recycle")
+		param("recycle:repository", ".recycle/%U")
+		param("recycle:keeptree", "yes")
+		param("recycle:versions", "yes")
+		param("recycle:touch", "yes")
+	} else {
+		param("vfs objects", "full_audit")
+	}
+	param("full_audit:prefix", "SMB_AUDIT|%u|%I|%m|"+smbDriveSharePrefix+"%S")
+	param("full_audit:success", "openat mkdirat unlinkat renameat")
+	param("full_audit:failure", "none")
+	param("full_audit:facility", "local7")
+	param("full_audit:priority", "notice")
+	return b.Bytes()
+}
+
+// SMBShareRegistry renders SMB sections for shared drives. Every change to
+// a drive, its members or a member's SMB account re-renders the whole set;
+// files are only rewritten (and audited) when their content changes.
+type SMBShareRegistry struct {
+	db           *sql.DB
+	configPath   string
+	auditHandler *AuditHandler
+	mu           sync.Mutex
+}
+
+var globalSMBShares *SMBShareRegistry
+
+// InitSMBShares creates the global SMB share registry
+func InitSMBShares(db *sql.DB, configPath string) *SMBShareRegistry {
+	globalSMBShares = &SMBShareRegistry{
+		db:           db,
+		configPath:   configPath,
+		auditHandler: NewAuditHandler(db, ""),
+	}
+	return globalSMBShares
+}
+
+// GetSMBShares returns the global SMB share registry (may be nil)
+func GetSMBShares() *SMBShareRegistry {
+	return globalSMBShares
+}
+
+// Get returns a drive's SMB export, or nil if it was never configured
+func (r *SMBShareRegistry) Get(folderID string) (*SharedFolderSMB, error) {
+	if r == nil {
+		return nil, nil
+	}
+	var s SharedFolderSMB
+	err := r.db.QueryRow(`
+		SELECT enabled, browseable, guest_ok, recycle_bin, read_only
+		FROM shared_folder_smb WHERE shared_folder_id = $1
+	`, folderID).Scan(&s.Enabled, &s.Browseable, &s.GuestOK, &s.RecycleBin, &s.ReadOnly)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Set stores a drive's SMB export options. Call Apply to render them. A
+// drive holding a retention policy is always exported read-only.
+func (r *SMBShareRegistry) Set(folderID string, s *SharedFolderSMB, actorID string) error {
+	if r == nil {
+		return nil
+	}
+	if !s.ReadOnly && GetRetention().HoldsDrive(folderID) {
+		forced := *s
+		forced.ReadOnly = true
+		s = &forced
+	}
+	_, err := r.db.Exec(`
+		INSERT INTO shared_folder_smb (shared_folder_id, enabled, browseable, guest_ok, recycle_bin, read_only, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (shared_folder_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled, browseable = EXCLUDED.browseable, guest_ok = EXCLUDED.guest_ok,
+			recycle_bin = EXCLUDED.recycle_bin, read_only = EXCLUDED.read_only,
+			updated_by = EXCLUDED.updated_by, updated_at = NOW()
+	`, folderID, s.Enabled, s.Browseable, s.GuestOK, s.RecycleBin, s.ReadOnly, actorID)
+	return err
+}
+
+// load returns the exported drives of active shared folders with their
+// members that have an SMB password
+func (r *SMBShareRegistry) load() ([]*smbShare, error) {
+	rows, err := r.db.Query(`
+		SELECT sf.id, sf.name, COALESCE(sf.description, ''),
+		       s.browseable, s.guest_ok, s.recycle_bin, s.read_only
+		FROM shared_folder_smb s
+		INNER JOIN shared_folders sf ON sf.id = s.shared_folder_id
+		WHERE s.enabled = TRUE AND sf.is_active = TRUE
+		ORDER BY sf.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := make([]*smbShare, 0)
+	byID := make(map[string]*smbShare)
+	for rows.Next() {
+		s := &smbShare{SMB: SharedFolderSMB{Enabled: true}}
+		if err := rows.Scan(&s.FolderID, &s.Name, &s.Description,
+			&s.SMB.Browseable, &s.SMB.GuestOK, &s.SMB.RecycleBin, &s.SMB.ReadOnly); err != nil {
+			return nil, err
+		}
+		s.Name = sanitizeFolderName(s.Name)
+		shares = append(shares, s)
+		byID[s.FolderID] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return shares, nil
+	}
+
+	members, err := r.db.Query(`
+		SELECT sfa.shared_folder_id, u.username, sfa.permission_level
+		FROM shared_folder_access sfa
+		INNER JOIN users u ON u.id = sfa.user_id
+		INNER JOIN shared_folder_smb s ON s.shared_folder_id = sfa.shared_folder_id
+		WHERE s.enabled = TRUE AND u.is_active = TRUE AND u.smb_hash IS NOT NULL AND u.smb_hash <> ''
+		ORDER BY u.username
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+	for members.Next() {
+		var folderID, username string
+		var level int
+		if err := members.Scan(&folderID, &username, &level); err != nil {
+			return nil, err
+		}
+		if s := byID[folderID]; s != nil && !strings.ContainsAny(username, "\",\r\n") {
+			s.Members = append(s.Members, smbShareMember{Username: username, CanWrite: level >= PermissionReadWrite})
+		}
+	}
+	return shares, members.Err()
+}
+
+// smbShareFiles is a rendered set of section files keyed by file name
+type smbShareFiles map[string][]byte
+
+// render turns drives into section files plus the index, checking each
+// share name and the generated text
+func (r *SMBShareRegistry) render(shares []*smbShare) (smbShareFiles, []SMBConfigIssue) {
+	files := make(smbShareFiles)
+	issues := make([]SMBConfigIssue, 0)
+	names := make(map[string]string)
+	includes := make([]string, 0, len(shares))
+
+	for _, s := range shares {
+		file := s.FolderID + ".conf"
+		if problem := smbShareNameProblem(s.Name); problem != "" {
+			issues = append(issues, SMBConfigIssue{File: file, Severity: "error",
+				Message: fmt.Sprintf("drive %q cannot be exported: %s", s.Name, problem)})
+			continue
+		}
+		if other, ok := names[strings.ToLower(s.Name)]; ok {
+			issues = append(issues, SMBConfigIssue{File: file, Severity: "error",
+				Message: fmt.Sprintf("share name %q is also used by drive %s", s.Name, other)})
+			continue
+		}
+		names[strings.ToLower(s.Name)] = s.FolderID
+
+		data := renderSMBShare(s)
+		issues = append(issues, checkSMBConfig(file, data)...)
+		files[file] = data
+		includes = append(includes, fmt.Sprintf("include = %s/%s/%s\n", smbContainerConfig, smbSharesDir, file))
+	}
+
+	sort.Strings(includes)
+	files[smbSharesIndex] = []byte("# Shared drives exported over SMB - managed by FileHatch\n" + strings.Join(includes, ""))
+	return files, issues
+}
+
+// Preview renders the current drives with one drive's export replaced (or
+// added, for a drive that does not exist yet), without writing anything
+func (r *SMBShareRegistry) Preview(change *smbShare) (smbShareFiles, []SMBConfigIssue, error) {
+	if r == nil {
+		return smbShareFiles{}, []SMBConfigIssue{}, nil
+	}
+	shares, err := r.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if change != nil {
+		kept := shares[:0]
+		for _, s := range shares {
+			if s.FolderID == change.FolderID {
+				if change.Members == nil {
+					change.Members = s.Members
+				}
+				continue
+			}
+			kept = append(kept, s)
+		}
+		shares = kept
+		if change.SMB.Enabled {
+			shares = append(shares, change)
+		}
+	}
+	files, issues := r.render(shares)
+	return files, issues, nil
+}
+
+// Apply renders all exported drives and writes the files that changed.
+// Nothing is written when the result has errors, so a bad drive never
+// reaches smbd. Writes are audit-logged with reason and trigger a reload.
+func (r *SMBShareRegistry) Apply(actorID *string, clientIP, reason string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	shares, err := r.load()
+	if err != nil {
+		return err
+	}
+	files, issues := r.render(shares)
+	if smbConfigHasErrors(issues) {
+		return &SMBConfigError{Issues: issues}
+	}
+
+	dir := filepath.Join(r.configPath, smbSharesDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	written := make([]string, 0)
+	for name, data := range files {
+		if existing, err := os.ReadFile(filepath.Join(dir, name)); err == nil && bytes.Equal(existing, data) {
+			continue
+		}
+		if err := writeFileAtomic(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+		written = append(written, name)
+	}
+	removed := make([]string, 0)
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if name := e.Name(); strings.HasSuffix(name, ".conf") && files[name] == nil {
+			if err := os.Remove(filepath.Join(dir, name)); err == nil {
+				removed = append(removed, name)
+			}
+		}
+	}
+	if err := r.ensureInclude(); err != nil {
+		log.Printf("[SMB] Failed to add shared drive include to smb.conf: %v", err)
+	}
+	if len(written) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, smbReloadTrigger), []byte(time.Now().UTC().Format(time.RFC3339Nano)+"\n"), 0644); err != nil {
+		log.Printf("[SMB] Failed to trigger reload: %v", err)
+	}
+	sort.Strings(written)
+	sort.Strings(removed)
+	shareNames := make([]string, 0, len(shares))
+	for _, s := range shares {
+		shareNames = append(shareNames, s.Name)
+	}
+	if clientIP == "" {
+		clientIP = "0.0.0.0"
+	}
+	_ = r.auditHandler.LogEvent(actorID, clientIP, "smb_config_write", smbContainerConfig+"/"+smbSharesDir,
+		map[string]interface{}{
+			"reason":  reason,
+			"written": written,
+			"removed": removed,
+			"shares":  shareNames,
+		})
+	return nil
+}
+
+// Sync runs Apply and logs failures. Used after changes whose own result
+// should not depend on the SMB export, such as membership updates.
+func (r *SMBShareRegistry) Sync(actorID *string, clientIP, reason string) {
+	if err := r.Apply(actorID, clientIP, reason); err != nil {
+		log.Printf("[SMB] Failed to render shared drive exports (%s): %v", reason, err)
+	}
+}
+
+// smbIncludeLine pulls the drive sections into smb.conf
+func smbIncludeLine() string {
+	return fmt.Sprintf("include = %s/%s/%s", smbContainerConfig, smbSharesDir, smbSharesIndex)
+}
+
+// ensureInclude appends the include line to an existing smb.conf that
+// predates per-drive exports
+func (r *SMBShareRegistry) ensureInclude() error {
+	configFile := filepath.Join(r.configPath, "smb.conf")
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if bytes.Contains(content, []byte(smbIncludeLine())) {
+		return nil
+	}
+	// Append in place: smb.conf is bind-mounted into the samba container as a file
+	f, err := os.OpenFile(configFile, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "\n# Shared drives exported over SMB\n%s\n", smbIncludeLine())
+	return err
+}
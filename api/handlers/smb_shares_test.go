@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCheckSMBConfig(t *testing.T) {
+	template, err := os.ReadFile(filepath.Join("..", "..", "samba", "smb.conf.template"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues := checkSMBConfig("smb.conf.template", template); len(issues) != 0 {
+		t.Errorf("template issues: %+v", issues)
+	}
+
+	for name, tt := range map[string]struct {
+		config   string
+		severity string
+		line     int
+	}{
+		"no equals":        {config: "[team]\n   path /data/shared/team\n", severity: "error", line: 2},
+		"bad boolean":      {config: "[team]\n   read only = maybe\n", severity: "error", line: 2},
+		"duplicate":        {config: "[team]\n[Team]\n", severity: "error", line: 2},
+		"unterminated":     {config: "[team\n", severity: "error", line: 1},
+		"dangling newline": {config: "[team]\n   comment = a \\\n", severity: "error", line: 2},
+		"unknown":          {config: "[team]\n   frobnicate = yes\n", severity: "warning", line: 2},
+		"continuation":     {config: "[team]\n   valid users = alice \\\n      bob\n   recycle:keeptree = yes\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			issues := checkSMBConfig("test.conf", []byte(tt.config))
+			if tt.severity == "" {
+				if len(issues) != 0 {
+					t.Errorf("unexpected issues: %+v", issues)
+				}
+				return
+			}
+			if len(issues) != 1 || issues[0].Severity != tt.severity || issues[0].Line != tt.line {
+				t.Errorf("issues = %+v, want one %s on line %d", issues, tt.severity, tt.line)
+			}
+		})
+	}
+}
+
+func TestRenderSMBShare(t *testing.T) {
+	share := &smbShare{
+		FolderID:    "f1",
+		Name:        "Team",
+		Description: "Design\n   path = /etc",
+		SMB:         SharedFolderSMB{Enabled: true, Browseable: true, RecycleBin: true},
+		Members: []smbShareMember{
+			{Username: "alice", CanWrite: true},
+			{Username: "bob smith"},
+		},
+	}
+	out := string(renderSMBShare(share))
+	for _, want := range []string{
+		"[Team]\n",
+		"path = /data/shared/Team\n",
+		"comment = Design path = /etc\n",
+		"read only = yes\n",
+		`valid users = alice "bob smith"` + "\n",
+		"write list = alice\n",
+		"vfs objects = full_audit recycle\n",
+		"full_audit:prefix = SMB_AUDIT|%u|%I|%m|drive:%S\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if issues := checkSMBConfig("f1.conf", []byte(out)); len(issues) != 0 {
+		t.Errorf("rendered section issues: %+v", issues)
+	}
+
+	// Read-only drops writers; no members closes the share instead of opening it to all
+	share.SMB.ReadOnly = true
+	share.Members = nil
+	out = string(renderSMBShare(share))
+	if strings.Contains(out, "write list") || strings.Contains(out, "valid users") || !strings.Contains(out, "available = no\n") {
+		t.Errorf("unexpected section:\n%s", out)
+	}
+
+	for _, name := range []string{"homes", "a;b", "a%b", strings.Repeat("x", maxSMBShareName+1)} {
+		if smbShareNameProblem(name) == "" {
+			t.Errorf("expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestSMBShares_Apply(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	configPath := t.TempDir()
+	r := &SMBShareRegistry{db: tc.DB, configPath: configPath, auditHandler: NewAuditHandler(tc.DB, "")}
+
+	sharesDir := filepath.Join(configPath, smbSharesDir)
+	_ = os.MkdirAll(sharesDir, 0755)
+	_ = os.WriteFile(filepath.Join(sharesDir, "gone.conf"), []byte("[gone]\n"), 0644)
+	_ = os.WriteFile(filepath.Join(configPath, "smb.conf"), []byte("[global]\n   workgroup = WORKGROUP\n"), 0644)
+
+	expectLoad := func() {
+		tc.Mock.ExpectQuery("FROM shared_folder_smb s").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "browseable", "guest_ok", "recycle_bin", "read_only"}).
+				AddRow("f1", "Team", "", true, false, false, false))
+		tc.Mock.ExpectQuery("FROM shared_folder_access sfa").
+			WillReturnRows(sqlmock.NewRows([]string{"shared_folder_id", "username", "permission_level"}).
+				AddRow("f1", "alice", PermissionReadWrite))
+	}
+
+	expectLoad()
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs(nil, "10.0.0.1", "smb_config_write", "/etc/filehatch/smb.d", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := r.Apply(nil, "10.0.0.1", "shared_folder_member_add"); err != nil {
+		t.Fatal(err)
+	}
+
+	section, err := os.ReadFile(filepath.Join(sharesDir, "f1.conf"))
+	if err != nil || !strings.Contains(string(section), "valid users = alice\n") {
+		t.Errorf("section = %q, %v", section, err)
+	}
+	index, _ := os.ReadFile(filepath.Join(sharesDir, smbSharesIndex))
+	if !strings.Contains(string(index), "include = /etc/filehatch/smb.d/f1.conf\n") {
+		t.Errorf("index = %q", index)
+	}
+	if _, err := os.Stat(filepath.Join(sharesDir, "gone.conf")); !os.IsNotExist(err) {
+		t.Error("stale section was not removed")
+	}
+	if _, err := os.Stat(filepath.Join(sharesDir, smbReloadTrigger)); err != nil {
+		t.Error("reload was not triggered")
+	}
+	mainConf, _ := os.ReadFile(filepath.Join(configPath, "smb.conf"))
+	if strings.Count(string(mainConf), smbIncludeLine()) != 1 {
+		t.Errorf("smb.conf = %q", mainConf)
+	}
+
+	// Unchanged output writes nothing and is not audited again
+	expectLoad()
+	if err := r.Apply(nil, "10.0.0.1", "shared_folder_update"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSMBShares_ApplyRejectsInvalid(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	configPath := t.TempDir()
+	r := &SMBShareRegistry{db: tc.DB, configPath: configPath, auditHandler: NewAuditHandler(tc.DB, "")}
+
+	tc.Mock.ExpectQuery("FROM shared_folder_smb s").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "browseable", "guest_ok", "recycle_bin", "read_only"}).
+			AddRow("f1", "a;b", "", true, false, false, false))
+	tc.Mock.ExpectQuery("FROM shared_folder_access sfa").
+		WillReturnRows(sqlmock.NewRows([]string{"shared_folder_id", "username", "permission_level"}))
+
+	err := r.Apply(nil, "10.0.0.1", "startup")
+	if _, ok := err.(*SMBConfigError); !ok {
+		t.Fatalf("err = %v, want SMBConfigError", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(configPath, smbSharesDir)); !os.IsNotExist(statErr) {
+		t.Error("nothing should be written for an invalid config")
+	}
+}
+
+func TestSMBRealPath_DriveShare(t *testing.T) {
+	entry := &SMBAuditEntry{Username: "alice", ShareName: smbDriveSharePrefix + "Team", FilePath: "docs/a.txt"}
+	if got := smbRealPath(entry); got != "/data/shared/Team/docs/a.txt" {
+		t.Errorf("smbRealPath = %s", got)
+	}
+}
@@ -71,22 +71,9 @@ func (h *SSOHandler) GetAuthURL(c echo.Context) error {
 		provider.AllowedDomains = allowedDomains.String
 	}
 
-	// Determine authorization URL
-	authorizationURL := provider.AuthorizationURL
-	if authorizationURL == "" {
-		switch provider.ProviderType {
-		case "google":
-			authorizationURL = "https://accounts.google.com/o/oauth2/v2/auth"
-		case "github":
-			authorizationURL = "https://github.com/login/oauth/authorize"
-		case "azure":
-			authorizationURL = "https://login.microsoftonline.com/common/oauth2/v2.0/authorize"
-		case "oidc":
-			if provider.IssuerURL != "" {
-				authorizationURL = strings.TrimSuffix(provider.IssuerURL, "/") + "/protocol/openid-connect/auth"
-			}
-		}
-	}
+	// Determine authorization URL; OIDC providers given only an issuer
+	// have theirs discovered
+	authorizationURL := providerEndpoints(c.Request().Context(), provider).Authorization
 
 	if authorizationURL == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -94,13 +81,15 @@ func (h *SSOHandler) GetAuthURL(c echo.Context) error {
 		})
 	}
 
-	// Generate state
-	state, err := generateState()
+	// Generate state, PKCE verifier and nonce; the callback has to present
+	// the state both from the provider and from this browser's cookie
+	state, login, err := pendingSSOLogins.start(providerID, time.Now())
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to generate state",
 		})
 	}
+	setSSOStateCookie(c, state)
 
 	// Build redirect URI using external URL configuration
 	scheme := getExternalScheme(c)
@@ -114,6 +103,11 @@ func (h *SSOHandler) GetAuthURL(c echo.Context) error {
 	params.Set("redirect_uri", redirectURI)
 	params.Set("scope", provider.Scopes)
 	params.Set("state", state)
+	params.Set("code_challenge", pkceChallenge(login.codeVerifier))
+	params.Set("code_challenge_method", "S256")
+	// Always sent: providers may return an id_token without the openid
+	// scope, and the callback checks its nonce whenever one comes back
+	params.Set("nonce", login.nonce)
 	if provider.ProviderType == "google" {
 		params.Set("access_type", "offline")
 		params.Set("prompt", "select_account")
@@ -131,13 +125,24 @@ func (h *SSOHandler) GetAuthURL(c echo.Context) error {
 func (h *SSOHandler) HandleCallback(c echo.Context) error {
 	providerID := c.Param("providerId")
 	code := c.QueryParam("code")
-	// state := c.QueryParam("state") // Could verify state here
+	state := c.QueryParam("state")
+
+	// Only the browser that started the login may finish it (CSRF)
+	var cookieState string
+	if cookie, err := c.Cookie(ssoStateCookieName); err == nil {
+		cookieState = cookie.Value
+	}
+	clearSSOStateCookie(c)
+	login, ok := pendingSSOLogins.take(state, cookieState, providerID, time.Now())
 
 	if code == "" {
 		errorMsg := c.QueryParam("error")
 		errorDesc := c.QueryParam("error_description")
 		return c.Redirect(http.StatusFound, fmt.Sprintf("/login?error=sso_failed&message=%s", url.QueryEscape(errorMsg+": "+errorDesc)))
 	}
+	if !ok {
+		return c.Redirect(http.StatusFound, "/login?error=invalid_state&message="+url.QueryEscape("SSO login expired or was started elsewhere, please try again"))
+	}
 
 	// Get provider configuration
 	var provider SSOProvider
@@ -169,22 +174,8 @@ func (h *SSOHandler) HandleCallback(c echo.Context) error {
 		provider.AllowedDomains = allowedDomains.String
 	}
 
-	// Determine token URL
-	tokenURLStr := provider.TokenURL
-	if tokenURLStr == "" {
-		switch provider.ProviderType {
-		case "google":
-			tokenURLStr = "https://oauth2.googleapis.com/token"
-		case "github":
-			tokenURLStr = "https://github.com/login/oauth/access_token"
-		case "azure":
-			tokenURLStr = "https://login.microsoftonline.com/common/oauth2/v2.0/token"
-		case "oidc":
-			if provider.IssuerURL != "" {
-				tokenURLStr = strings.TrimSuffix(provider.IssuerURL, "/") + "/protocol/openid-connect/token"
-			}
-		}
-	}
+	// Determine token and userinfo URLs
+	endpoints := providerEndpoints(c.Request().Context(), provider)
 
 	// Build redirect URI using external URL configuration
 	scheme := getExternalScheme(c)
@@ -192,16 +183,27 @@ func (h *SSOHandler) HandleCallback(c echo.Context) error {
 	redirectURI := fmt.Sprintf("%s://%s/api/auth/sso/callback/%s", scheme, host, providerID)
 
 	// Exchange code for token
-	tokenResp, err := h.exchangeCodeForToken(tokenURLStr, code, provider.ClientID, provider.ClientSecret, redirectURI)
+	tokenResp, err := h.exchangeCodeForToken(endpoints.Token, code, provider.ClientID, provider.ClientSecret, redirectURI, login.codeVerifier)
 	if err != nil {
 		return c.Redirect(http.StatusFound, "/login?error=token_exchange_failed&message="+url.QueryEscape(err.Error()))
 	}
 
+	// OIDC providers vouch for the user in the id_token
+	var idSubject string
+	if tokenResp.IDToken != "" || ssoExpectsIDToken(provider) {
+		if idSubject, err = validateIDToken(tokenResp.IDToken, provider, login, time.Now()); err != nil {
+			return c.Redirect(http.StatusFound, "/login?error=invalid_id_token&message="+url.QueryEscape(err.Error()))
+		}
+	}
+
 	// Get user info
-	userInfo, err := h.getUserInfo(provider, tokenResp.AccessToken)
+	userInfo, err := h.getUserInfo(provider, endpoints.Userinfo, tokenResp.AccessToken)
 	if err != nil {
 		return c.Redirect(http.StatusFound, "/login?error=userinfo_failed&message="+url.QueryEscape(err.Error()))
 	}
+	if idSubject != "" && userInfo.Sub != idSubject {
+		return c.Redirect(http.StatusFound, "/login?error=invalid_id_token&message="+url.QueryEscape("userinfo is for another user than the id_token"))
+	}
 
 	// Validate email domain
 	if provider.AllowedDomains != "" {
@@ -247,15 +249,43 @@ func (h *SSOHandler) HandleCallback(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/login?error=user_creation_failed&message="+url.QueryEscape(err.Error()))
 	}
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	// Apply the 2FA policy to SSO logins as well
+	var has2FA bool
+	var createdAt time.Time
+	_ = h.db.QueryRow("SELECT COALESCE(totp_enabled, false), created_at FROM users WHERE id = $1", user.ID).Scan(&has2FA, &createdAt)
+	compliance := LoadTwoFactorPolicy().Evaluate(user.IsAdmin, has2FA, createdAt, time.Now())
+
+	// Generate JWT token. SSO sign-ins start a session without remember me.
+	tokenClaims := jwt.MapClaims{
 		"userId":   user.ID,
 		"username": user.Username,
 		"isAdmin":  user.IsAdmin,
 		"iss":      "filehatch",
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
 		"iat":      time.Now().Unix(),
-	})
+	}
+	twoFactorParam := ""
+	if !compliance.Overdue {
+		policy := LoadSessionPolicy()
+		if sessions := GetSessions(); sessions != nil {
+			sessionID, expiresAt, refreshToken, err := sessions.Start(user.ID, false, c.RealIP(), c.Request().UserAgent())
+			if err != nil {
+				return c.Redirect(http.StatusFound, "/login?error=token_generation_failed")
+			}
+			setRefreshCookie(c, refreshToken, false, expiresAt)
+			tokenClaims["sid"] = sessionID
+			tokenClaims["exp"] = time.Now().Add(policy.accessLifetime(false, expiresAt, time.Now())).Unix()
+		} else {
+			tokenClaims["exp"] = time.Now().Add(policy.tokenLifetime(false, time.Time{}, time.Now())).Unix()
+		}
+	}
+	if compliance.Overdue {
+		tokenClaims["scope"] = TokenScope2FASetup
+		tokenClaims["exp"] = time.Now().Add(limitedTokenExpiration).Unix()
+		twoFactorParam = "&requires2faSetup=true"
+	} else if compliance.GraceUntil != nil {
+		twoFactorParam = "&twoFactorGraceUntil=" + url.QueryEscape(compliance.GraceUntil.Format(time.RFC3339))
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tokenClaims)
 
 	tokenString, err := token.SignedString([]byte(h.jwtSecret))
 	if err != nil {
@@ -269,17 +299,18 @@ func (h *SSOHandler) HandleCallback(c echo.Context) error {
 	`, user.ID, c.RealIP(), provider.Name, fmt.Sprintf(`{"provider": "%s", "email": "%s"}`, provider.Name, userInfo.Email))
 
 	// Redirect to frontend with token
-	return c.Redirect(http.StatusFound, fmt.Sprintf("/login?sso_token=%s", tokenString))
+	return c.Redirect(http.StatusFound, fmt.Sprintf("/login?sso_token=%s%s", tokenString, twoFactorParam))
 }
 
 // exchangeCodeForToken exchanges the authorization code for an access token
-func (h *SSOHandler) exchangeCodeForToken(tokenURL, code, clientID, clientSecret, redirectURI string) (*OIDCTokenResponse, error) {
+func (h *SSOHandler) exchangeCodeForToken(tokenURL, code, clientID, clientSecret, redirectURI, codeVerifier string) (*OIDCTokenResponse, error) {
 	data := url.Values{}
 	data.Set("grant_type", "authorization_code")
 	data.Set("code", code)
 	data.Set("client_id", clientID)
 	data.Set("client_secret", clientSecret)
 	data.Set("redirect_uri", redirectURI)
+	data.Set("code_verifier", codeVerifier)
 
 	req, err := http.NewRequestWithContext(context.Background(), "POST", tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
@@ -323,23 +354,7 @@ func (h *SSOHandler) exchangeCodeForToken(tokenURL, code, clientID, clientSecret
 }
 
 // getUserInfo fetches user info from the provider
-func (h *SSOHandler) getUserInfo(provider SSOProvider, accessToken string) (*OIDCUserInfo, error) {
-	userinfoURL := provider.UserinfoURL
-	if userinfoURL == "" {
-		switch provider.ProviderType {
-		case "google":
-			userinfoURL = "https://www.googleapis.com/oauth2/v3/userinfo"
-		case "github":
-			userinfoURL = "https://api.github.com/user"
-		case "azure":
-			userinfoURL = "https://graph.microsoft.com/v1.0/me"
-		case "oidc":
-			if provider.IssuerURL != "" {
-				userinfoURL = strings.TrimSuffix(provider.IssuerURL, "/") + "/protocol/openid-connect/userinfo"
-			}
-		}
-	}
-
+func (h *SSOHandler) getUserInfo(provider SSOProvider, userinfoURL, accessToken string) (*OIDCUserInfo, error) {
 	req, err := http.NewRequestWithContext(context.Background(), "GET", userinfoURL, nil)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// oidcDiscoveryTTL is how long a fetched discovery document is used
+	oidcDiscoveryTTL = time.Hour
+	// oidcDiscoveryRetry is how long a failed discovery is not tried again;
+	// logins use the fallback endpoints meanwhile
+	oidcDiscoveryRetry = time.Minute
+	// oidcDiscoveryTimeout bounds fetching a discovery document
+	oidcDiscoveryTimeout = 10 * time.Second
+	// oidcDiscoveryMaxBytes bounds the size of a discovery document
+	oidcDiscoveryMaxBytes = 1 << 20
+)
+
+// Where an endpoint of a provider comes from
+const (
+	endpointConfigured = "configured" // set on the provider
+	endpointDiscovered = "discovery"  // from the issuer's discovery document
+	endpointDefault    = "default"    // built in for the provider type
+)
+
+// OIDCDiscovery is the part of an OpenID Provider's configuration document
+// (/.well-known/openid-configuration) that sign-in uses
+type OIDCDiscovery struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	UserinfoEndpoint              string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI                       string   `json:"jwks_uri,omitempty"`
+	ScopesSupported               []string `json:"scopes_supported,omitempty"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// oidcDiscoveryURL returns where the discovery document of an issuer is
+func oidcDiscoveryURL(issuer string) string {
+	return strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+}
+
+// fetchOIDCDiscovery fetches the discovery document of an issuer. The
+// document has to name the issuer it was fetched for (OIDC Discovery 4.3).
+func fetchOIDCDiscovery(ctx context.Context, issuer string) (*OIDCDiscovery, error) {
+	ctx, cancel := context.WithTimeout(ctx, oidcDiscoveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oidcDiscoveryURL(issuer), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcDiscoveryMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	var doc OIDCDiscovery
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("discovery document is not valid JSON: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("discovery document is for issuer %q, not %q", doc.Issuer, issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, fmt.Errorf("discovery document has no authorization or token endpoint")
+	}
+	return &doc, nil
+}
+
+// oidcDiscoveryCache holds the discovery documents of the configured
+// issuers. Failures are remembered too, so a provider that is down is not
+// asked on every login.
+type oidcDiscoveryCache struct {
+	mu      sync.Mutex
+	entries map[string]oidcDiscoveryEntry // issuer -> document
+
+	// fetch gets a document; replaced in tests
+	fetch func(ctx context.Context, issuer string) (*OIDCDiscovery, error)
+}
+
+type oidcDiscoveryEntry struct {
+	doc     *OIDCDiscovery
+	err     error
+	fetched time.Time
+}
+
+var oidcDiscoveries = newOIDCDiscoveryCache()
+
+func newOIDCDiscoveryCache() *oidcDiscoveryCache {
+	return &oidcDiscoveryCache{entries: make(map[string]oidcDiscoveryEntry), fetch: fetchOIDCDiscovery}
+}
+
+// get returns the cached document of issuer, fetching it when it is older
+// than oidcDiscoveryTTL or failed more than oidcDiscoveryRetry ago
+func (d *oidcDiscoveryCache) get(ctx context.Context, issuer string, now time.Time) (*OIDCDiscovery, error) {
+	d.mu.Lock()
+	e, ok := d.entries[issuer]
+	d.mu.Unlock()
+	if ok {
+		ttl := oidcDiscoveryTTL
+		if e.err != nil {
+			ttl = oidcDiscoveryRetry
+		}
+		if now.Sub(e.fetched) < ttl {
+			return e.doc, e.err
+		}
+	}
+	return d.refresh(ctx, issuer, now)
+}
+
+// refresh fetches the document of issuer now and caches the outcome. A
+// failure keeps a previously fetched document in use until it expires.
+func (d *oidcDiscoveryCache) refresh(ctx context.Context, issuer string, now time.Time) (*OIDCDiscovery, error) {
+	doc, err := d.fetch(ctx, issuer)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		if prev, ok := d.entries[issuer]; ok && prev.err == nil && now.Sub(prev.fetched) < oidcDiscoveryTTL {
+			LogWarn("OIDC discovery failed, keeping the cached document", "issuer", issuer, "error", err)
+			return prev.doc, nil
+		}
+		LogWarn("OIDC discovery failed, using default endpoints", "issuer", issuer, "error", err)
+	}
+	d.entries[issuer] = oidcDiscoveryEntry{doc: doc, err: err, fetched: now}
+	return doc, err
+}
+
+// ssoEndpoints are the endpoints sign-in uses for a provider, with where
+// each comes from
+type ssoEndpoints struct {
+	Authorization       string `json:"authorizationUrl"`
+	Token               string `json:"tokenUrl"`
+	Userinfo            string `json:"userinfoUrl"`
+	AuthorizationSource string `json:"authorizationSource"`
+	TokenSource         string `json:"tokenSource"`
+	UserinfoSource      string `json:"userinfoSource"`
+}
+
+// resolveSSOEndpoints picks each endpoint of a provider: the one configured
+// on it, else the one in the issuer's discovery document (OIDC providers),
+// else the built-in one for the provider type. Without a discovery
+// document OIDC providers fall back to Keycloak's paths below the issuer.
+func resolveSSOEndpoints(provider SSOProvider, doc *OIDCDiscovery) ssoEndpoints {
+	var defaults, discovered [3]string
+	switch provider.ProviderType {
+	case "google":
+		defaults = [3]string{"https://accounts.google.com/o/oauth2/v2/auth", "https://oauth2.googleapis.com/token", "https://www.googleapis.com/oauth2/v3/userinfo"}
+	case "github":
+		defaults = [3]string{"https://github.com/login/oauth/authorize", "https://github.com/login/oauth/access_token", "https://api.github.com/user"}
+	case "azure":
+		defaults = [3]string{"https://login.microsoftonline.com/common/oauth2/v2.0/authorize", "https://login.microsoftonline.com/common/oauth2/v2.0/token", "https://graph.microsoft.com/v1.0/me"}
+	case "oidc":
+		if provider.IssuerURL != "" {
+			base := strings.TrimSuffix(provider.IssuerURL, "/") + "/protocol/openid-connect"
+			defaults = [3]string{base + "/auth", base + "/token", base + "/userinfo"}
+		}
+		if doc != nil {
+			discovered = [3]string{doc.AuthorizationEndpoint, doc.TokenEndpoint, doc.UserinfoEndpoint}
+		}
+	}
+
+	configured := [3]string{provider.AuthorizationURL, provider.TokenURL, provider.UserinfoURL}
+	var urls, sources [3]string
+	for i := range urls {
+		switch {
+		case configured[i] != "":
+			urls[i], sources[i] = configured[i], endpointConfigured
+		case discovered[i] != "":
+			urls[i], sources[i] = discovered[i], endpointDiscovered
+		case defaults[i] != "":
+			urls[i], sources[i] = defaults[i], endpointDefault
+		}
+	}
+	return ssoEndpoints{
+		Authorization: urls[0], AuthorizationSource: sources[0],
+		Token: urls[1], TokenSource: sources[1],
+		Userinfo: urls[2], UserinfoSource: sources[2],
+	}
+}
+
+// ssoNeedsDiscovery reports whether a provider leaves endpoints to discovery
+func ssoNeedsDiscovery(provider SSOProvider) bool {
+	return provider.ProviderType == "oidc" && provider.IssuerURL != "" &&
+		(provider.AuthorizationURL == "" || provider.TokenURL == "" || provider.UserinfoURL == "")
+}
+
+// providerEndpoints returns the endpoints of a provider, discovering those
+// that are not configured. When discovery fails the defaults are used.
+func providerEndpoints(ctx context.Context, provider SSOProvider) ssoEndpoints {
+	var doc *OIDCDiscovery
+	if ssoNeedsDiscovery(provider) {
+		doc, _ = oidcDiscoveries.get(ctx, provider.IssuerURL, time.Now())
+	}
+	return resolveSSOEndpoints(provider, doc)
+}
+
+// SSOProviderTest is the outcome of testing a provider's configuration
+type SSOProviderTest struct {
+	ProviderType   string         `json:"providerType"`
+	DiscoveryURL   string         `json:"discoveryUrl,omitempty"`
+	Discovered     bool           `json:"discovered"`
+	DiscoveryError string         `json:"discoveryError,omitempty"`
+	Discovery      *OIDCDiscovery `json:"discovery,omitempty"`
+	Endpoints      ssoEndpoints   `json:"endpoints"`
+	Warnings       []string       `json:"warnings"`
+}
+
+// TestProvider fetches the discovery document of an OIDC provider afresh
+// and reports it with the endpoints sign-in will use (admin only)
+// POST /api/admin/sso/providers/:id/test
+func (h *SSOHandler) TestProvider(c echo.Context) error {
+	id := c.Param("id")
+	if id == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Provider ID required",
+		})
+	}
+
+	var provider SSOProvider
+	var issuerURL, authURL, tokenURL, userinfoURL sql.NullString
+	err := h.db.QueryRow(`
+		SELECT id, provider_type, client_id, issuer_url, authorization_url, token_url, userinfo_url, scopes
+		FROM sso_providers WHERE id = $1
+	`, id).Scan(&provider.ID, &provider.ProviderType, &provider.ClientID,
+		&issuerURL, &authURL, &tokenURL, &userinfoURL, &provider.Scopes)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Provider not found",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to fetch provider",
+		})
+	}
+	provider.IssuerURL = issuerURL.String
+	provider.AuthorizationURL = authURL.String
+	provider.TokenURL = tokenURL.String
+	provider.UserinfoURL = userinfoURL.String
+
+	result := SSOProviderTest{ProviderType: provider.ProviderType, Warnings: []string{}}
+	var doc *OIDCDiscovery
+	if provider.ProviderType == "oidc" {
+		if provider.IssuerURL == "" {
+			result.Warnings = append(result.Warnings, "No issuer URL: set it to discover the endpoints")
+		} else {
+			result.DiscoveryURL = oidcDiscoveryURL(provider.IssuerURL)
+			doc, err = oidcDiscoveries.refresh(c.Request().Context(), provider.IssuerURL, time.Now())
+			if err != nil {
+				result.DiscoveryError = err.Error()
+				result.Warnings = append(result.Warnings, "Discovery failed: logins use the configured endpoints or Keycloak's default paths")
+			} else {
+				result.Discovered = true
+				result.Discovery = doc
+				if len(doc.CodeChallengeMethodsSupported) > 0 && !slices.Contains(doc.CodeChallengeMethodsSupported, "S256") {
+					result.Warnings = append(result.Warnings, "The provider does not list PKCE S256 support")
+				}
+				for _, scope := range strings.Fields(provider.Scopes) {
+					if len(doc.ScopesSupported) > 0 && !slices.Contains(doc.ScopesSupported, scope) {
+						result.Warnings = append(result.Warnings, fmt.Sprintf("The provider does not list the scope %q", scope))
+					}
+				}
+			}
+		}
+	}
+	result.Endpoints = resolveSSOEndpoints(provider, doc)
+	if result.Endpoints.Authorization == "" || result.Endpoints.Token == "" {
+		result.Warnings = append(result.Warnings, "Authorization or token URL unknown: logins will fail")
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// useTestOIDCDiscovery gives the test an empty discovery cache that fetches
+// with fetch, so no test reaches out to a real issuer
+func useTestOIDCDiscovery(t *testing.T, fetch func(ctx context.Context, issuer string) (*OIDCDiscovery, error)) {
+	t.Helper()
+	previous := oidcDiscoveries
+	oidcDiscoveries = newOIDCDiscoveryCache()
+	oidcDiscoveries.fetch = fetch
+	t.Cleanup(func() { oidcDiscoveries = previous })
+}
+
+// discoveryServer serves a discovery document naming its own URL as issuer
+func discoveryServer(t *testing.T, requests *int) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/realms/main/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		if requests != nil {
+			*requests++
+		}
+		issuer := srv.URL + "/realms/main"
+		_ = json.NewEncoder(w).Encode(OIDCDiscovery{
+			Issuer:                        issuer,
+			AuthorizationEndpoint:         issuer + "/authorize",
+			TokenEndpoint:                 issuer + "/token",
+			UserinfoEndpoint:              issuer + "/userinfo",
+			ScopesSupported:               []string{"openid", "email"},
+			CodeChallengeMethodsSupported: []string{"S256"},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchOIDCDiscovery(t *testing.T) {
+	srv := discoveryServer(t, nil)
+
+	doc, err := fetchOIDCDiscovery(context.Background(), srv.URL+"/realms/main/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.TokenEndpoint != srv.URL+"/realms/main/token" {
+		t.Errorf("token endpoint %q", doc.TokenEndpoint)
+	}
+
+	// A document served for another issuer is refused
+	if _, err := fetchOIDCDiscovery(context.Background(), srv.URL+"/realms/other"); err == nil {
+		t.Error("missing document accepted")
+	}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(OIDCDiscovery{Issuer: "https://evil.example.com",
+			AuthorizationEndpoint: "https://evil.example.com/auth", TokenEndpoint: "https://evil.example.com/token"})
+	}))
+	defer other.Close()
+	if _, err := fetchOIDCDiscovery(context.Background(), other.URL); err == nil {
+		t.Error("document of another issuer accepted")
+	}
+}
+
+func TestOIDCDiscoveryCache(t *testing.T) {
+	var requests int
+	srv := discoveryServer(t, &requests)
+	issuer := srv.URL + "/realms/main"
+	cache := newOIDCDiscoveryCache()
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.get(context.Background(), issuer, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("%d fetches within the TTL, want 1", requests)
+	}
+	_, _ = cache.get(context.Background(), issuer, now.Add(oidcDiscoveryTTL))
+	if requests != 2 {
+		t.Errorf("%d fetches after the TTL, want 2", requests)
+	}
+
+	// A failed refresh keeps the document in use; a failure is retried later
+	cache.fetch = func(context.Context, string) (*OIDCDiscovery, error) { return nil, errors.New("down") }
+	if doc, err := cache.refresh(context.Background(), issuer, now.Add(oidcDiscoveryTTL)); err != nil || doc == nil {
+		t.Errorf("refresh while down: %v, %v", doc, err)
+	}
+	failures := 0
+	cache.fetch = func(context.Context, string) (*OIDCDiscovery, error) {
+		failures++
+		return nil, errors.New("down")
+	}
+	unknown := "https://id.example.com"
+	for i := 0; i < 3; i++ {
+		if _, err := cache.get(context.Background(), unknown, now); err == nil {
+			t.Fatal("failed discovery returned no error")
+		}
+	}
+	_, _ = cache.get(context.Background(), unknown, now.Add(oidcDiscoveryRetry))
+	if failures != 2 {
+		t.Errorf("%d fetches of a failing issuer, want 2", failures)
+	}
+}
+
+func TestResolveSSOEndpoints(t *testing.T) {
+	doc := &OIDCDiscovery{
+		AuthorizationEndpoint: "https://id.example.com/authorize",
+		TokenEndpoint:         "https://id.example.com/token",
+		UserinfoEndpoint:      "https://id.example.com/userinfo",
+	}
+	provider := SSOProvider{ProviderType: "oidc", IssuerURL: "https://id.example.com/", TokenURL: "https://proxy.example.com/token"}
+
+	// Configured endpoints win over discovered ones
+	got := resolveSSOEndpoints(provider, doc)
+	if got.Authorization != doc.AuthorizationEndpoint || got.AuthorizationSource != endpointDiscovered ||
+		got.Token != provider.TokenURL || got.TokenSource != endpointConfigured {
+		t.Errorf("with discovery: %+v", got)
+	}
+
+	// Without a document the Keycloak paths below the issuer are used
+	got = resolveSSOEndpoints(provider, nil)
+	if got.Userinfo != "https://id.example.com/protocol/openid-connect/userinfo" || got.UserinfoSource != endpointDefault {
+		t.Errorf("without discovery: %+v", got)
+	}
+
+	// Built-in providers are never discovered
+	if got := resolveSSOEndpoints(SSOProvider{ProviderType: "github"}, doc); got.Token != "https://github.com/login/oauth/access_token" {
+		t.Errorf("github: %+v", got)
+	}
+	if ssoNeedsDiscovery(SSOProvider{ProviderType: "google", IssuerURL: "https://accounts.google.com"}) {
+		t.Error("google provider discovered")
+	}
+}
+
+func expectSSOProviderTest(mock sqlmock.Sqlmock, issuerURL string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, provider_type, client_id, issuer_url`)).
+		WithArgs("p1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "provider_type", "client_id", "issuer_url", "authorization_url", "token_url", "userinfo_url", "scopes",
+		}).AddRow("p1", "oidc", "filehatch", issuerURL, nil, nil, nil, "openid email profile"))
+}
+
+func TestSSOTestProvider(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := NewSSOHandler(tc.DB, "secret", t.TempDir())
+	useTestOIDCDiscovery(t, fetchOIDCDiscovery)
+	srv := discoveryServer(t, nil)
+
+	test := func() SSOProviderTest {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/sso/providers/p1/test", nil)
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("p1")
+		if err := h.TestProvider(c); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("TestProvider: %v, status %d", err, rec.Code)
+		}
+		var result SSOProviderTest
+		if err := ParseJSONResponse(rec, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// An issuer alone is enough; scopes the provider does not list are flagged
+	expectSSOProviderTest(tc.Mock, srv.URL+"/realms/main")
+	result := test()
+	if !result.Discovered || result.Endpoints.Token != srv.URL+"/realms/main/token" || result.Endpoints.TokenSource != endpointDiscovered {
+		t.Errorf("result %+v", result)
+	}
+	if len(result.Warnings) != 1 {
+		t.Errorf("warnings %q, want one for the profile scope", result.Warnings)
+	}
+
+	// A failed discovery is reported with the endpoints logins fall back to
+	expectSSOProviderTest(tc.Mock, srv.URL+"/realms/other")
+	result = test()
+	if result.Discovered || result.DiscoveryError == "" || result.Endpoints.TokenSource != endpointDefault {
+		t.Errorf("result %+v", result)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// ssoStateCookieName binds a started SSO login to the browser that
+	// started it; it holds the state sent to the provider
+	ssoStateCookieName = "filehatch_sso_state"
+	// ssoLoginExpiration is how long a user has to sign in at the provider
+	ssoLoginExpiration = 10 * time.Minute
+)
+
+// ssoLogin is an SSO login waiting for its callback
+type ssoLogin struct {
+	providerID   string
+	codeVerifier string // PKCE (RFC 7636)
+	nonce        string // OIDC, echoed in the id_token
+	expires      time.Time
+}
+
+// ssoLogins holds the logins started by GetAuthURL, keyed by state. Each is
+// taken once by its callback.
+type ssoLogins struct {
+	mu     sync.Mutex
+	logins map[string]ssoLogin
+}
+
+var pendingSSOLogins = &ssoLogins{logins: make(map[string]ssoLogin)}
+
+// start records a login for providerID and returns its state
+func (s *ssoLogins) start(providerID string, now time.Time) (string, ssoLogin, error) {
+	state, err := generateState()
+	if err != nil {
+		return "", ssoLogin{}, err
+	}
+	verifier, err := generateState()
+	if err != nil {
+		return "", ssoLogin{}, err
+	}
+	nonce, err := generateState()
+	if err != nil {
+		return "", ssoLogin{}, err
+	}
+	login := ssoLogin{
+		providerID: providerID,
+		// base64url without padding keeps the verifier within RFC 7636's alphabet
+		codeVerifier: strings.TrimRight(verifier, "="),
+		nonce:        nonce,
+		expires:      now.Add(ssoLoginExpiration),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Logins never finished are dropped as new ones come in
+	for st, l := range s.logins {
+		if !now.Before(l.expires) {
+			delete(s.logins, st)
+		}
+	}
+	s.logins[state] = login
+	return state, login, nil
+}
+
+// take returns and forgets the login of state. It fails unless the state
+// is known, was started for providerID, has not expired and equals the
+// state in the browser's cookie.
+func (s *ssoLogins) take(state, cookieState, providerID string, now time.Time) (ssoLogin, bool) {
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		return ssoLogin{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	login, ok := s.logins[state]
+	if !ok {
+		return ssoLogin{}, false
+	}
+	delete(s.logins, state)
+	return login, login.providerID == providerID && now.Before(login.expires)
+}
+
+// pkceChallenge returns the S256 code challenge of a verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// setSSOStateCookie remembers the state of a started login in the browser.
+// Lax, as the provider sends the browser back with a cross-site redirect.
+func setSSOStateCookie(c echo.Context, state string) {
+	c.SetCookie(&http.Cookie{
+		Name:     ssoStateCookieName,
+		Value:    state,
+		Path:     "/api/auth/sso",
+		MaxAge:   int(ssoLoginExpiration / time.Second),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSSOStateCookie removes the state cookie once the callback used it
+func clearSSOStateCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     ssoStateCookieName,
+		Path:     "/api/auth/sso",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ssoIssuers returns the issuers an id_token of the provider may name, or
+// nil when any is accepted (Azure's common endpoint names the user's tenant)
+func ssoIssuers(provider SSOProvider) []string {
+	switch provider.ProviderType {
+	case "google":
+		return []string{"https://accounts.google.com", "accounts.google.com"}
+	case "oidc":
+		if provider.IssuerURL != "" {
+			return []string{strings.TrimSuffix(provider.IssuerURL, "/")}
+		}
+	}
+	return nil
+}
+
+// ssoExpectsIDToken reports whether the provider has to return an id_token:
+// OIDC providers asked for the openid scope
+func ssoExpectsIDToken(provider SSOProvider) bool {
+	return provider.ProviderType != "github" && slices.Contains(strings.Fields(provider.Scopes), "openid")
+}
+
+// idTokenClaims are the id_token claims checked at sign-in
+type idTokenClaims struct {
+	Nonce string `json:"nonce"`
+	AZP   string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// validateIDToken checks the id_token of a token response against the
+// provider and the login it answers, and returns its subject. The token
+// comes straight from the provider's token endpoint over TLS, which OIDC
+// Core 3.1.3.7 accepts in place of checking its signature; issuer,
+// audience, expiry and nonce are what tie it to this client and login.
+func validateIDToken(idToken string, provider SSOProvider, login ssoLogin, now time.Time) (string, error) {
+	var claims idTokenClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, &claims); err != nil {
+		return "", fmt.Errorf("malformed id_token: %w", err)
+	}
+	if issuers := ssoIssuers(provider); issuers != nil &&
+		!slices.Contains(issuers, strings.TrimSuffix(claims.Issuer, "/")) {
+		return "", fmt.Errorf("id_token issued by %q, not %q", claims.Issuer, issuers[0])
+	}
+	if !slices.Contains(claims.Audience, provider.ClientID) {
+		return "", errors.New("id_token is not for this client")
+	}
+	if len(claims.Audience) > 1 && claims.AZP != provider.ClientID {
+		return "", errors.New("id_token is not authorized for this client")
+	}
+	// A minute of leeway for clocks
+	if claims.ExpiresAt == nil || now.After(claims.ExpiresAt.Add(time.Minute)) {
+		return "", errors.New("id_token has expired")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(login.nonce)) != 1 {
+		return "", errors.New("id_token nonce does not match the login")
+	}
+	if claims.Subject == "" {
+		return "", errors.New("id_token has no subject")
+	}
+	return claims.Subject, nil
+}
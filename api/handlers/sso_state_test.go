@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSSOLogins_StateBoundToBrowserAndProvider(t *testing.T) {
+	logins := &ssoLogins{logins: make(map[string]ssoLogin)}
+	now := time.Now()
+
+	state, login, err := logins.start("p1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(login.codeVerifier) < 43 || strings.ContainsAny(login.codeVerifier, "=+/") {
+		t.Errorf("code verifier %q", login.codeVerifier)
+	}
+	if _, ok := logins.take(state, "other", "p1", now); ok {
+		t.Error("state accepted with another browser's cookie")
+	}
+	if _, ok := logins.take(state, "", "p1", now); ok {
+		t.Error("state accepted without a cookie")
+	}
+	got, ok := logins.take(state, state, "p1", now)
+	if !ok || got.codeVerifier != login.codeVerifier {
+		t.Fatal("state of this browser refused")
+	}
+	if _, ok := logins.take(state, state, "p1", now); ok {
+		t.Error("state accepted twice")
+	}
+
+	// Another provider's callback and late callbacks are refused
+	state, _, _ = logins.start("p1", now)
+	if _, ok := logins.take(state, state, "p2", now); ok {
+		t.Error("state accepted for another provider")
+	}
+	state, _, _ = logins.start("p1", now)
+	if _, ok := logins.take(state, state, "p1", now.Add(ssoLoginExpiration)); ok {
+		t.Error("expired state accepted")
+	}
+}
+
+func TestValidateIDToken(t *testing.T) {
+	now := time.Now()
+	provider := SSOProvider{ProviderType: "oidc", ClientID: "filehatch", IssuerURL: "https://id.example.com/realms/main/"}
+	login := ssoLogin{nonce: "n-123"}
+	token := func(edit func(c *idTokenClaims)) string {
+		claims := idTokenClaims{
+			Nonce: "n-123",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "https://id.example.com/realms/main",
+				Subject:   "user-42",
+				Audience:  jwt.ClaimStrings{"filehatch"},
+				ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			},
+		}
+		if edit != nil {
+			edit(&claims)
+		}
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("provider-key"))
+		return s
+	}
+
+	if sub, err := validateIDToken(token(nil), provider, login, now); err != nil || sub != "user-42" {
+		t.Fatalf("valid id_token: %q, %v", sub, err)
+	}
+	for name, edit := range map[string]func(c *idTokenClaims){
+		"issuer":   func(c *idTokenClaims) { c.Issuer = "https://evil.example.com" },
+		"audience": func(c *idTokenClaims) { c.Audience = jwt.ClaimStrings{"other-app"} },
+		"azp":      func(c *idTokenClaims) { c.Audience = jwt.ClaimStrings{"filehatch", "other-app"} },
+		"expired":  func(c *idTokenClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-2 * time.Minute)) },
+		"nonce":    func(c *idTokenClaims) { c.Nonce = "replayed" },
+		"subject":  func(c *idTokenClaims) { c.Subject = "" },
+	} {
+		if _, err := validateIDToken(token(edit), provider, login, now); err == nil {
+			t.Errorf("%s: id_token accepted", name)
+		}
+	}
+	if _, err := validateIDToken("", provider, login, now); err == nil {
+		t.Error("missing id_token accepted")
+	}
+}
+
+func expectSSOProvider(mock sqlmock.Sqlmock, tokenURL string) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, provider_type, client_id, client_secret`)).
+		WithArgs("p1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "provider_type", "client_id", "client_secret", "issuer_url",
+			"authorization_url", "token_url", "userinfo_url", "scopes", "allowed_domains",
+			"auto_create_user", "default_admin",
+		}).AddRow("p1", "Example", "oidc", "filehatch", "secret", "https://id.example.com",
+			"https://id.example.com/auth", tokenURL, nil, "openid email", nil, true, false))
+}
+
+func TestSSOCallback_StateAndPKCE(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := NewSSOHandler(tc.DB, "secret", t.TempDir())
+	previous := pendingSSOLogins
+	pendingSSOLogins = &ssoLogins{logins: make(map[string]ssoLogin)}
+	defer func() { pendingSSOLogins = previous }()
+	useTestOIDCDiscovery(t, func(context.Context, string) (*OIDCDiscovery, error) {
+		return nil, errors.New("no discovery in tests")
+	})
+
+	// Starting a login sets the state cookie and sends the PKCE challenge
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, provider_type, client_id, client_secret`)).
+		WithArgs("p1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "provider_type", "client_id", "client_secret", "issuer_url",
+			"authorization_url", "token_url", "userinfo_url", "scopes", "allowed_domains",
+			"auto_create_user", "default_admin", "is_enabled", "display_order", "icon_url", "button_color",
+		}).AddRow("p1", "Example", "oidc", "filehatch", "secret", "https://id.example.com",
+			"https://id.example.com/auth", nil, nil, "openid email", nil, true, false, true, 0, nil, nil))
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/sso/auth/p1", nil)
+	rec := httptest.NewRecorder()
+	c := tc.Echo.NewContext(req, rec)
+	c.SetParamNames("providerId")
+	c.SetParamValues("p1")
+	if err := h.GetAuthURL(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GetAuthURL: %v, status %d", err, rec.Code)
+	}
+	var started struct {
+		AuthURL string `json:"authUrl"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &started)
+	authURL, _ := url.Parse(started.AuthURL)
+	state := authURL.Query().Get("state")
+	var cookie *http.Cookie
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == ssoStateCookieName {
+			cookie = ck
+		}
+	}
+	if cookie == nil || cookie.Value != state || !cookie.HttpOnly {
+		t.Fatalf("state cookie %+v for state %q", cookie, state)
+	}
+	login := pendingSSOLogins.logins[state]
+	if authURL.Query().Get("code_challenge") != pkceChallenge(login.codeVerifier) ||
+		authURL.Query().Get("code_challenge_method") != "S256" || authURL.Query().Get("nonce") != login.nonce {
+		t.Errorf("auth URL %s", started.AuthURL)
+	}
+
+	callback := func(state string, withCookie bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/sso/callback/p1?code=abc&state="+url.QueryEscape(state), nil)
+		if withCookie {
+			req.AddCookie(&http.Cookie{Name: ssoStateCookieName, Value: cookie.Value})
+		}
+		rec := httptest.NewRecorder()
+		c := tc.Echo.NewContext(req, rec)
+		c.SetParamNames("providerId")
+		c.SetParamValues("p1")
+		_ = h.HandleCallback(c)
+		return rec
+	}
+
+	// A callback from another browser is refused before anything else
+	if rec := callback(state, false); !strings.Contains(rec.Header().Get("Location"), "error=invalid_state") {
+		t.Fatalf("callback without cookie redirected to %s", rec.Header().Get("Location"))
+	}
+
+	// The token exchange sends the verifier; an id_token of another login fails
+	var verifier string
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		verifier = r.PostForm.Get("code_verifier")
+		idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, idTokenClaims{
+			Nonce: "another-login",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer: "https://id.example.com", Subject: "user-42", Audience: jwt.ClaimStrings{"filehatch"},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		}).SignedString([]byte("provider-key"))
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "id_token": idToken})
+	}))
+	defer provider.Close()
+	expectSSOProvider(tc.Mock, provider.URL)
+	state, login, _ = pendingSSOLogins.start("p1", time.Now())
+	cookie.Value = state
+	rec = callback(state, true)
+	if verifier != login.codeVerifier {
+		t.Errorf("code_verifier %q, want %q", verifier, login.codeVerifier)
+	}
+	if !strings.Contains(rec.Header().Get("Location"), "error=invalid_id_token") {
+		t.Errorf("callback with foreign id_token redirected to %s", rec.Header().Get("Location"))
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetAuthURL_NonceWithoutOpenIDScope(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := NewSSOHandler(tc.DB, "secret", t.TempDir())
+	previous := pendingSSOLogins
+	pendingSSOLogins = &ssoLogins{logins: make(map[string]ssoLogin)}
+	defer func() { pendingSSOLogins = previous }()
+
+	// A plain OAuth2 provider may still return an id_token, which the
+	// callback validates, nonce included
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, provider_type, client_id, client_secret`)).
+		WithArgs("p1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "name", "provider_type", "client_id", "client_secret", "issuer_url",
+			"authorization_url", "token_url", "userinfo_url", "scopes", "allowed_domains",
+			"auto_create_user", "default_admin", "is_enabled", "display_order", "icon_url", "button_color",
+		}).AddRow("p1", "Example", "oauth2", "filehatch", "secret", nil,
+			"https://id.example.com/auth", nil, nil, "email profile", nil, true, false, true, 0, nil, nil))
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/sso/auth/p1", nil)
+	rec := httptest.NewRecorder()
+	c := tc.Echo.NewContext(req, rec)
+	c.SetParamNames("providerId")
+	c.SetParamValues("p1")
+	if err := h.GetAuthURL(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GetAuthURL: %v, status %d", err, rec.Code)
+	}
+	var started struct {
+		AuthURL string `json:"authUrl"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &started)
+	authURL, _ := url.Parse(started.AuthURL)
+	login := pendingSSOLogins.logins[authURL.Query().Get("state")]
+	if login.nonce == "" || authURL.Query().Get("nonce") != login.nonce {
+		t.Errorf("auth URL %s", started.AuthURL)
+	}
+}
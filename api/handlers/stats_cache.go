@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -18,6 +19,7 @@ type StatsCache struct {
 	prefix     string
 	defaultTTL time.Duration
 	localCache sync.Map // In-memory cache for frequently accessed stats
+	childCounts sync.Map // Direct entry counts by directory, see ChildCount
 }
 
 // CachedFolderStats represents cached folder statistics
@@ -29,6 +31,13 @@ type CachedFolderStats struct {
 	DirModTime time.Time `json:"dirModTime"` // Directory modification time for cache invalidation
 }
 
+// cachedChildCount is the number of direct entries of a directory as of its
+// modification time
+type cachedChildCount struct {
+	Count      int64
+	DirModTime time.Time
+}
+
 // StatsCacheConfig holds cache configuration
 type StatsCacheConfig struct {
 	RedisAddr    string
@@ -247,6 +256,80 @@ func (c *StatsCache) GetOrCompute(path string, computeFn func() (*CachedFolderSt
 	return stats, nil
 }
 
+// ChildCount returns the number of direct entries of a directory, hidden
+// ones included. The count is kept with the directory's modification time
+// and only recounted, by name without a stat per entry, once the directory
+// changed outside the API.
+func (c *StatsCache) ChildCount(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if c != nil {
+		if cached, ok := c.childCounts.Load(path); ok {
+			if cc := cached.(cachedChildCount); cc.DirModTime.Equal(info.ModTime()) {
+				return cc.Count, nil
+			}
+		}
+	}
+
+	count, err := countDirEntries(path)
+	if err != nil {
+		return 0, err
+	}
+	c.SetChildCount(path, count, info.ModTime())
+	return count, nil
+}
+
+// SetChildCount records the direct entry count of a directory, such as
+// after a listing read it anyway
+func (c *StatsCache) SetChildCount(path string, count int64, dirModTime time.Time) {
+	if c == nil {
+		return
+	}
+	c.childCounts.Store(path, cachedChildCount{Count: count, DirModTime: dirModTime})
+}
+
+// AddChildren adjusts a known entry count after the API added entries to
+// the directory, so its own changes don't force a recount. Entries added
+// outside the API in the same instant go unnoticed until the next listing.
+func (c *StatsCache) AddChildren(path string, delta int64) {
+	if c == nil {
+		return
+	}
+	cached, ok := c.childCounts.Load(path)
+	if !ok {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		c.childCounts.Delete(path)
+		return
+	}
+	c.SetChildCount(path, max(cached.(cachedChildCount).Count+delta, 0), info.ModTime())
+}
+
+// countDirEntries counts the names in a directory in batches
+func countDirEntries(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var count int64
+	for {
+		names, err := f.Readdirnames(1024)
+		count += int64(len(names))
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
 // BatchGet retrieves multiple folder stats
 func (c *StatsCache) BatchGet(paths []string) map[string]*CachedFolderStats {
 	results := make(map[string]*CachedFolderStats)
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Public service states
+const (
+	ServiceOK          = "ok"
+	ServiceDegraded    = "degraded"
+	ServiceMaintenance = "maintenance"
+)
+
+// PublicStatus is the service state shown to end users. It carries no usage
+// numbers, counts or configuration.
+type PublicStatus struct {
+	Status              string    `json:"status"`            // ok, degraded or maintenance
+	Message             string    `json:"message,omitempty"` // Maintenance or incident message set by an admin
+	UploadsRestricted   bool      `json:"uploadsRestricted"`
+	DownloadsRestricted bool      `json:"downloadsRestricted"`
+	ServerTime          time.Time `json:"serverTime"`
+}
+
+// publicStatus derives the public state from the health check, the last
+// self-test and the storage migrations. Maintenance is announced by an admin
+// through the maintenance_mode setting and overrides the derived state; it
+// restricts nothing by itself.
+func (h *Handler) publicStatus() PublicStatus {
+	health := h.serviceHealth()
+	status := PublicStatus{Status: ServiceOK, ServerTime: time.Now()}
+
+	// Without the database nothing can be authorized
+	if health.Database != "connected" {
+		status.UploadsRestricted = true
+		status.DownloadsRestricted = true
+	}
+	// A migration switching a folder to another volume holds writes to it
+	if GetStorageLocations().HoldingWrites() {
+		status.UploadsRestricted = true
+	}
+	if health.Status != "ok" || status.UploadsRestricted || status.DownloadsRestricted {
+		status.Status = ServiceDegraded
+	}
+	if report := LastSelfTest(); report != nil && report.Status == SelfTestFail {
+		status.Status = ServiceDegraded
+	}
+
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		if message, err := settings.GetSetting("status_incident_message"); err == nil {
+			status.Message = strings.TrimSpace(message)
+		}
+		if settings.IsMaintenanceMode() {
+			status.Status = ServiceMaintenance
+			if message, err := settings.GetSetting("maintenance_message"); err == nil && strings.TrimSpace(message) != "" {
+				status.Message = strings.TrimSpace(message)
+			}
+		}
+	}
+	return status
+}
+
+// GetPublicStatus returns the service state for the status banner
+// @Summary		Service status
+// @Description	Public, unauthenticated service state for end users: ok, degraded or maintenance (maintenance_mode setting), the admin's maintenance_message while in maintenance or else the incident message (status_incident_message setting), whether uploads or downloads are currently restricted, and the server time. Derived from the health check, the last startup or admin self-test and running storage migrations. Contains no usage numbers or counts.
+// @Tags		System
+// @Produce		json
+// @Success		200	{object}	PublicStatus	"Status"
+// @Router		/status [get]
+func (h *Handler) GetPublicStatus(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return RespondSuccess(c, h.publicStatus())
+}
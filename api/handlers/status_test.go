@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getPublicStatus calls GET /api/status and returns its data
+func getPublicStatus(t *testing.T, tc *TestContext, h *Handler) PublicStatus {
+	t.Helper()
+	tc.Recorder = httptest.NewRecorder()
+	c := tc.Echo.NewContext(httptest.NewRequest(http.MethodGet, "/api/status", nil), tc.Recorder)
+	if err := h.GetPublicStatus(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	var resp struct {
+		Data PublicStatus `json:"data"`
+	}
+	if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+func TestGetPublicStatus(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+	r := withStorageLocations(t, tc, h.dataRoot, map[string]string{})
+	prev := LastSelfTest()
+	setSelfTest := func(report *SelfTestReport) {
+		selfTestMu.Lock()
+		selfTestLast = report
+		selfTestMu.Unlock()
+	}
+	setSelfTest(nil)
+	t.Cleanup(func() { setSelfTest(prev) })
+
+	get := func() PublicStatus { return getPublicStatus(t, tc, h) }
+
+	if status := get(); status.Status != ServiceOK || status.UploadsRestricted || status.DownloadsRestricted || status.ServerTime.IsZero() {
+		t.Errorf("status = %+v", status)
+	}
+
+	// A migration holding writes restricts uploads only
+	r.quiesce("shared/Media", true)
+	if status := get(); status.Status != ServiceDegraded || !status.UploadsRestricted || status.DownloadsRestricted {
+		t.Errorf("status while holding writes = %+v", status)
+	}
+	r.quiesce("shared/Media", false)
+
+	// A failed self-test degrades the service
+	setSelfTest(&SelfTestReport{Status: SelfTestFail})
+	if status := get(); status.Status != ServiceDegraded || status.UploadsRestricted {
+		t.Errorf("status after failed self-test = %+v", status)
+	}
+}
+
+func TestGetPublicStatus_Maintenance(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+	withStorageLocations(t, tc, h.dataRoot, map[string]string{})
+	prev := LastSelfTest()
+	selfTestMu.Lock()
+	selfTestLast = nil
+	selfTestMu.Unlock()
+	t.Cleanup(func() {
+		selfTestMu.Lock()
+		selfTestLast = prev
+		selfTestMu.Unlock()
+	})
+
+	settings := map[string]string{
+		"onlyoffice_health_in_readiness": "false",
+		"status_incident_message":        "Slow previews",
+		"maintenance_mode":               "true",
+		"maintenance_message":            "  Upgrading storage until 22:00  ",
+	}
+	useCachedSettings(t, settings)
+	if status := getPublicStatus(t, tc, h); status.Status != ServiceMaintenance || status.Message != "Upgrading storage until 22:00" ||
+		status.UploadsRestricted || status.DownloadsRestricted {
+		t.Errorf("status in maintenance = %+v", status)
+	}
+
+	// Without a maintenance message the incident message is shown
+	settings["maintenance_message"] = ""
+	useCachedSettings(t, settings)
+	if status := getPublicStatus(t, tc, h); status.Status != ServiceMaintenance || status.Message != "Slow previews" {
+		t.Errorf("status without maintenance message = %+v", status)
+	}
+
+	settings["maintenance_mode"] = "false"
+	useCachedSettings(t, settings)
+	if status := getPublicStatus(t, tc, h); status.Status != ServiceOK || status.Message != "Slow previews" {
+		t.Errorf("status after maintenance = %+v", status)
+	}
+}
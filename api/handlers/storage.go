@@ -45,7 +45,7 @@ func (h *Handler) GetStorageUsage(c echo.Context) error {
 	// Read storage values from database (instant query)
 	var storageUsed, trashUsed, storageQuota sql.NullInt64
 	err := h.db.QueryRow(`
-		SELECT storage_used, trash_used, storage_quota
+		SELECT storage_used, trash_used, user_storage_quota(id, storage_quota, NULL)
 		FROM users WHERE id = $1
 	`, claims.UserID).Scan(&storageUsed, &trashUsed, &storageQuota)
 
@@ -77,10 +77,10 @@ func (h *Handler) GetStorageUsage(c echo.Context) error {
 	sharedSize := h.getSharedStorageUsage()
 
 	// For admin users, include disk info and total data usage
-	if claims.IsAdmin {
+	if claims.HasPermission(PermStorageAdmin) {
 		diskInfo := getDiskInfo(h.dataRoot)
 		// Calculate total data directory usage
-		dataUsed, _ := h.calculateDirSize(h.dataRoot)
+		dataUsed := h.dataUsage()
 
 		return c.JSON(http.StatusOK, map[string]any{
 			"homeUsed":   homeUsed,
@@ -124,7 +124,7 @@ func (h *Handler) getStorageUsageFallback(c echo.Context, claims *JWTClaims) err
 
 	totalQuota := int64(10 * 1024 * 1024 * 1024)
 	var dbQuota sql.NullInt64
-	err := h.db.QueryRow(`SELECT storage_quota FROM users WHERE id = $1`, claims.UserID).Scan(&dbQuota)
+	err := h.db.QueryRow(`SELECT user_storage_quota(id, storage_quota, NULL) FROM users WHERE id = $1`, claims.UserID).Scan(&dbQuota)
 	if err == nil && dbQuota.Valid && dbQuota.Int64 > 0 {
 		totalQuota = dbQuota.Int64
 	}
@@ -148,11 +148,29 @@ func (h *Handler) getSharedStorageUsage() int64 {
 
 	// Calculate and cache
 	sharedPath := filepath.Join(h.dataRoot, "shared")
-	size, _ := h.calculateDirSize(sharedPath)
+	size, ok := indexedDirSize(sharedPath)
+	if !ok {
+		size, _ = h.calculateDirSize(sharedPath)
+	}
 	cache.SetSharedUsage(size)
 	return size
 }
 
+// dataUsage returns the size of the home folders, shared drives and trash
+// from the folder size index, walking the data directory without it
+func (h *Handler) dataUsage() int64 {
+	var total int64
+	for _, top := range dirSizeTops {
+		size, ok := indexedDirSize(filepath.Join(h.dataRoot, top))
+		if !ok {
+			total, _ = h.calculateDirSize(h.dataRoot)
+			return total
+		}
+		total += size
+	}
+	return total
+}
+
 // UpdateUserStorage updates the storage_used value in the database
 // delta can be positive (file added) or negative (file removed)
 func (h *Handler) UpdateUserStorage(userID string, delta int64) error {
@@ -195,7 +213,7 @@ func (h *Handler) UpdateStorageForMove(userID string, fileSize int64, toTrash bo
 // RecalculateUserStorage recalculates storage by scanning filesystem
 // Used for initial migration or manual recalculation
 func (h *Handler) RecalculateUserStorage(userID, username string) error {
-	homePath := filepath.Join(h.dataRoot, "users", username)
+	homePath := GetStorageLocations().Map(filepath.Join(h.dataRoot, "users", username))
 	homeSize, _ := h.calculateDirSize(homePath)
 
 	trashPath := filepath.Join(h.dataRoot, "trash", username)
@@ -246,8 +264,12 @@ func InvalidateStorageCache(username string) {
 
 // calculateDirSize calculates the total size of a directory
 func (h *Handler) calculateDirSize(path string) (int64, error) {
+	pace := GetBackgroundPacer().Begin("dir-size", PacePriorityUser)
+	defer pace.End()
+
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		pace.Pace()
 		if err != nil {
 			return nil
 		}
@@ -309,7 +331,7 @@ func (h *Handler) UpdateSharedFolderStorage(folderName string, delta int64) erro
 
 // RecalculateSharedFolderStorage recalculates storage by scanning filesystem
 func (h *Handler) RecalculateSharedFolderStorage(folderName string) error {
-	folderPath := filepath.Join(h.dataRoot, "shared", folderName)
+	folderPath := GetStorageLocations().Map(filepath.Join(h.dataRoot, "shared", folderName))
 	size, _ := h.calculateDirSize(folderPath)
 
 	_, err := h.db.Exec(`
@@ -348,3 +370,126 @@ func (h *Handler) RecalculateAllSharedFoldersStorage() error {
 	fmt.Printf("[Storage] Recalculated storage for %d shared folders\n", count)
 	return nil
 }
+
+// StorageDrift is a user or shared drive whose recorded usage differs from
+// what is on disk
+type StorageDrift struct {
+	Type          string `json:"type"` // user or drive
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Recorded      int64  `json:"recorded"`
+	Actual        int64  `json:"actual"`
+	RecordedTrash int64  `json:"recordedTrash,omitempty"`
+	ActualTrash   int64  `json:"actualTrash,omitempty"`
+}
+
+// recalculateStorage rescans every active user and shared drive and
+// corrects the recorded usage where it drifted
+func (h *Handler) recalculateStorage(run *destructiveRun) ([]StorageDrift, error) {
+	type subject struct {
+		id, name        string
+		used, trashUsed int64
+	}
+	scan := func(query string, withTrash bool) ([]subject, error) {
+		rows, err := run.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var subjects []subject
+		for rows.Next() {
+			var s subject
+			dest := []interface{}{&s.id, &s.name, &s.used}
+			if withTrash {
+				dest = append(dest, &s.trashUsed)
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return nil, err
+			}
+			subjects = append(subjects, s)
+		}
+		return subjects, rows.Err()
+	}
+
+	drifts := []StorageDrift{}
+	users, err := scan(`SELECT id, username, COALESCE(storage_used, 0), COALESCE(trash_used, 0) FROM users WHERE is_active = true ORDER BY username`, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		home, _ := h.calculateDirSize(GetStorageLocations().Map(filepath.Join(h.dataRoot, "users", u.name)))
+		trash, _ := h.calculateDirSize(h.getTrashPath(u.name))
+		if home == u.used && trash == u.trashUsed {
+			continue
+		}
+		if _, err := run.Exec("users", `
+			UPDATE users SET storage_used = $1, trash_used = $2, updated_at = NOW() WHERE id = $3
+		`, home, trash, u.id); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, StorageDrift{Type: "user", ID: u.id, Name: u.name,
+			Recorded: u.used, Actual: home, RecordedTrash: u.trashUsed, ActualTrash: trash})
+	}
+
+	drives, err := scan(`SELECT id, name, COALESCE(storage_used, 0) FROM shared_folders WHERE is_active = true ORDER BY name`, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range drives {
+		size, _ := h.calculateDirSize(GetStorageLocations().Map(filepath.Join(h.dataRoot, "shared", d.name)))
+		if size == d.used {
+			continue
+		}
+		if _, err := run.Exec("shared_folders", `
+			UPDATE shared_folders SET storage_used = $1, updated_at = NOW() WHERE id = $2
+		`, size, d.id); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, StorageDrift{Type: "drive", ID: d.id, Name: d.name, Recorded: d.used, Actual: size})
+	}
+	return drifts, nil
+}
+
+// RecalculateStorage rescans the recorded storage usage of users and drives
+// @Summary		Recalculate storage usage
+// @Description	Rescans the home folder, trash and shared drive sizes on disk and corrects the recorded usage where it drifted. With dryRun=true nothing is changed and the response lists the drifts that would be corrected.
+// @Tags		Admin
+// @Produce		json
+// @Param		dryRun	query		bool	false	"Only report the drifts"
+// @Success		200		{object}	DestructiveResult	"Drifts in details.drifts"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/storage/recalculate [post]
+func (h *Handler) RecalculateStorage(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err // Error response already written
+	}
+
+	run, err := beginDestructiveRun(h.db, dryRunParam(c))
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	defer run.Abort()
+
+	drifts, err := h.recalculateStorage(run)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("recalculate storage", err))
+	}
+	result, err := run.Finish()
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("recalculate storage", err))
+	}
+	result.Details = map[string]interface{}{"drifts": drifts}
+
+	if result.Applied {
+		GetStorageCache().InvalidateSharedUsage()
+		if h.auditHandler != nil {
+			_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminStorageRecalc, "storage", map[string]interface{}{
+				"corrected": len(drifts),
+				"rows":      result.Rows,
+			})
+		}
+	}
+	return RespondSuccess(c, result)
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// settingStorageVolumes lists the volumes shared drives and home folders may
+// be moved to: comma-separated absolute paths of mounted filesystems
+const settingStorageVolumes = "storage_volumes"
+
+// storageQuiesceRetryAfter is the delay suggested to clients whose write was
+// rejected while a migration switches its subtree
+const storageQuiesceRetryAfter = 5
+
+// storageQuiesceMaxWait bounds how long background writes, such as
+// completing uploads, wait for a switch to finish
+const storageQuiesceMaxWait = 2 * time.Minute
+
+// StorageLocations maps shared drives and home folders that were moved to
+// another volume. Paths are data-root relative (shared/{drive} or
+// users/{username}). resolvePath consults it, so no query is needed per
+// request; the data root keeps a symlink to the new location for code that
+// builds paths from the data root directly.
+type StorageLocations struct {
+	db       *sql.DB
+	dataRoot string
+
+	mu        sync.RWMutex
+	locations map[string]string // Relative path -> volume root
+	quiesced  map[string]bool   // Relative paths whose writes are held
+}
+
+var globalStorageLocations *StorageLocations
+
+// InitStorageLocations creates the global storage location registry and loads the locations
+func InitStorageLocations(db *sql.DB, dataRoot string) *StorageLocations {
+	globalStorageLocations = &StorageLocations{
+		db:        db,
+		dataRoot:  dataRoot,
+		locations: make(map[string]string),
+		quiesced:  make(map[string]bool),
+	}
+	if err := globalStorageLocations.Reload(); err != nil {
+		log.Printf("[Storage] Failed to load storage locations: %v", err)
+	}
+	return globalStorageLocations
+}
+
+// GetStorageLocations returns the global storage location registry (nil if not initialized)
+func GetStorageLocations() *StorageLocations {
+	return globalStorageLocations
+}
+
+// Reload reads all storage locations from the database
+func (r *StorageLocations) Reload() error {
+	if r == nil {
+		return nil
+	}
+	rows, err := r.db.Query(`SELECT path, volume FROM storage_locations`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	locations := make(map[string]string)
+	for rows.Next() {
+		var rel, volume string
+		if err := rows.Scan(&rel, &volume); err != nil {
+			return err
+		}
+		locations[rel] = volume
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.locations = locations
+	r.mu.Unlock()
+	return nil
+}
+
+// storageLocationKey splits a data-root relative path into the drive or home
+// folder it belongs to and the rest
+func storageLocationKey(rel string) (key, rest string, ok bool) {
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+	if len(parts) < 2 || (parts[0] != "shared" && parts[0] != "users") || parts[1] == "" {
+		return "", "", false
+	}
+	key = parts[0] + "/" + parts[1]
+	if len(parts) == 3 {
+		rest = parts[2]
+	}
+	return key, rest, true
+}
+
+// Volume returns the volume root holding a drive or home folder; the data
+// root unless it was moved
+func (r *StorageLocations) Volume(key string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if volume, ok := r.locations[key]; ok {
+		return volume
+	}
+	return r.dataRoot
+}
+
+// Map returns where a path below the data root is stored
+func (r *StorageLocations) Map(realPath string) string {
+	if r == nil {
+		return realPath
+	}
+	r.mu.RLock()
+	n := len(r.locations)
+	r.mu.RUnlock()
+	if n == 0 {
+		return realPath
+	}
+	rel, err := filepath.Rel(r.dataRoot, realPath)
+	if err != nil {
+		return realPath
+	}
+	key, rest, ok := storageLocationKey(rel)
+	if !ok {
+		return realPath
+	}
+	r.mu.RLock()
+	volume, moved := r.locations[key]
+	r.mu.RUnlock()
+	if !moved {
+		return realPath
+	}
+	return filepath.Join(volume, filepath.FromSlash(key), filepath.FromSlash(rest))
+}
+
+// Unmap is the inverse of Map: it returns the data root path of a path on
+// another volume, or realPath itself
+func (r *StorageLocations) Unmap(realPath string) string {
+	if r == nil {
+		return realPath
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for key, volume := range r.locations {
+		base := filepath.Join(volume, filepath.FromSlash(key))
+		if isPathWithinRoot(realPath, base) {
+			rest, _ := filepath.Rel(base, realPath)
+			return filepath.Join(r.dataRoot, filepath.FromSlash(key), rest)
+		}
+	}
+	return realPath
+}
+
+// Locations returns the moved drives and home folders and their volumes
+func (r *StorageLocations) Locations() map[string]string {
+	locations := make(map[string]string)
+	if r == nil {
+		return locations
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for key, volume := range r.locations {
+		locations[key] = volume
+	}
+	return locations
+}
+
+// set records the new volume of a drive or home folder
+func (r *StorageLocations) set(key, volume string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if volume == r.dataRoot {
+		delete(r.locations, key)
+	} else {
+		r.locations[key] = volume
+	}
+}
+
+// quiesce holds or releases writes below a drive or home folder
+func (r *StorageLocations) quiesce(key string, hold bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if hold {
+		r.quiesced[key] = true
+	} else {
+		delete(r.quiesced, key)
+	}
+}
+
+// HoldingWrites reports whether writes to any folder are held by a migration
+func (r *StorageLocations) HoldingWrites() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.quiesced) > 0
+}
+
+// Quiesced reports whether writes to realPath are held by a migration
+func (r *StorageLocations) Quiesced(realPath string) bool {
+	if r == nil || realPath == "" {
+		return false
+	}
+	r.mu.RLock()
+	n := len(r.quiesced)
+	r.mu.RUnlock()
+	if n == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(r.dataRoot, r.Unmap(realPath))
+	if err != nil {
+		return false
+	}
+	key, _, ok := storageLocationKey(rel)
+	if !ok {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.quiesced[key]
+}
+
+// waitWritable blocks while writes to realPath are held, up to
+// storageQuiesceMaxWait. Returns true if it waited.
+func (r *StorageLocations) waitWritable(realPath string) bool {
+	waited := false
+	for deadline := time.Now().Add(storageQuiesceMaxWait); r.Quiesced(realPath) && time.Now().Before(deadline); {
+		waited = true
+		time.Sleep(250 * time.Millisecond)
+	}
+	return waited
+}
+
+// dataRootRel returns the data-root relative path of a real path, which may
+// be on a volume a drive or home folder was moved to
+func dataRootRel(dataRoot, realPath string) (string, error) {
+	return filepath.Rel(dataRoot, GetStorageLocations().Unmap(realPath))
+}
+
+// storageWriteError returns a retryable SERVICE_UNAVAILABLE error while a
+// migration switches the drive or home folder holding realPath
+func storageWriteError(realPath string) *APIError {
+	if !GetStorageLocations().Quiesced(realPath) {
+		return nil
+	}
+	return NewAPIError(ErrCodeServiceUnavailable, "This folder is being moved to another volume; retry in a few seconds").
+		WithDetails(map[string]interface{}{"retryAfter": storageQuiesceRetryAfter})
+}
+
+// StorageVolumes returns the configured storage volumes
+func StorageVolumes() []string {
+	var raw string
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		raw, _ = sh.GetSetting(settingStorageVolumes)
+	}
+	var volumes []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			volumes = append(volumes, filepath.Clean(v))
+		}
+	}
+	return volumes
+}
+
+// validateStorageVolume checks that a volume is usable as a migration target
+func validateStorageVolume(dataRoot, volume string) error {
+	if !filepath.IsAbs(volume) {
+		return fmt.Errorf("%s is not an absolute path", volume)
+	}
+	if isPathWithinRoot(volume, dataRoot) || isPathWithinRoot(dataRoot, volume) {
+		return fmt.Errorf("%s overlaps the data root %s", volume, dataRoot)
+	}
+	info, err := os.Stat(volume)
+	if err != nil {
+		return fmt.Errorf("%s is not mounted: %v", volume, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", volume)
+	}
+	probe := filepath.Join(volume, ".selftest-"+selfTestToken())
+	if err := os.WriteFile(probe, []byte(time.Now().Format(time.RFC3339Nano)), 0600); err != nil {
+		return fmt.Errorf("%s is not writable: %v", volume, err)
+	}
+	return os.Remove(probe)
+}
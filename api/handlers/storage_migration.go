@@ -0,0 +1,738 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Storage migration phases, in order
+const (
+	StorageMigrationCopy    = "copy"    // Bulk copy; the old location stays live
+	StorageMigrationSwitch  = "switch"  // Writes held, final delta sync, mapping switched
+	StorageMigrationVerify  = "verify"  // Size and checksum sampling against the old copy
+	StorageMigrationCleanup = "cleanup" // Old copy removed
+	StorageMigrationDone    = "done"
+)
+
+const (
+	// storageVerifySample is how many files are checksummed on verification;
+	// sizes are compared for every file
+	storageVerifySample = 200
+	// storageMigratedDir holds old copies between the switch and cleanup, on
+	// the filesystem they were on so moving them aside is a rename
+	storageMigratedDir = ".storage-migrated"
+)
+
+// storageQuiesceGrace is how long writes already past their checks get to
+// finish before the final sync
+var storageQuiesceGrace = 2 * time.Second
+
+// StorageMigrateRequest is the body of POST /api/admin/storage/migrate
+type StorageMigrateRequest struct {
+	Source       string `json:"source"`       // /shared/{folder} or /users/{username}
+	TargetVolume string `json:"targetVolume"` // One of the storage_volumes setting
+}
+
+// StorageMigrationJob moves a shared drive or home folder to another volume.
+// Its ID is also the activity ID while it runs.
+type StorageMigrationJob struct {
+	ID           string     `json:"id"`
+	Path         string     `json:"path"` // Data-root relative: shared/{folder} or users/{username}
+	SourceVolume string     `json:"sourceVolume"`
+	TargetVolume string     `json:"targetVolume"`
+	Phase        string     `json:"phase"`
+	Status       string     `json:"status"` // Same states as adopt jobs
+	BytesTotal   int64      `json:"bytesTotal"`
+	BytesCopied  int64      `json:"bytesCopied"`
+	FilesTotal   int64      `json:"filesTotal"`
+	FilesCopied  int64      `json:"filesCopied"`
+	Verified     int64      `json:"verified"`
+	Error        string     `json:"error,omitempty"`
+	SwitchedAt   *time.Time `json:"switchedAt,omitempty"`
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+}
+
+// storageMigrationRun guards a job while its copy updates the counters
+type storageMigrationRun struct {
+	mu  sync.Mutex
+	job StorageMigrationJob
+}
+
+var (
+	storageMigrationsMu sync.Mutex
+	storageMigrations   = make(map[string]*storageMigrationRun)
+)
+
+func (r *storageMigrationRun) snapshot() StorageMigrationJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.job
+}
+
+func (r *storageMigrationRun) update(fn func(j *StorageMigrationJob)) {
+	r.mu.Lock()
+	fn(&r.job)
+	r.mu.Unlock()
+}
+
+// runningStorageMigration returns the migration in progress, if any
+func runningStorageMigration() *storageMigrationRun {
+	storageMigrationsMu.Lock()
+	defer storageMigrationsMu.Unlock()
+	for _, run := range storageMigrations {
+		if run.snapshot().Status == AdoptRunning {
+			return run
+		}
+	}
+	return nil
+}
+
+// saveStorageMigration writes the phase, counters and outcome of a job
+func (h *Handler) saveStorageMigration(job StorageMigrationJob) error {
+	_, err := h.db.Exec(`
+		UPDATE storage_migrations
+		SET phase = $2, status = $3, bytes_total = $4, files_total = $5, verified = $6,
+		    error = NULLIF($7, ''), switched_at = $8, finished_at = $9
+		WHERE id = $1
+	`, job.ID, job.Phase, job.Status, job.BytesTotal, job.FilesTotal, job.Verified,
+		job.Error, job.SwitchedAt, job.FinishedAt)
+	return err
+}
+
+// loadStorageMigrations reads jobs from the database, newest first
+func (h *Handler) loadStorageMigrations(where string, args ...interface{}) ([]StorageMigrationJob, error) {
+	rows, err := h.db.Query(`
+		SELECT id, path, source_volume, target_volume, phase, status, bytes_total, files_total,
+		       verified, COALESCE(error, ''), switched_at, started_at, finished_at
+		FROM storage_migrations
+		`+where+`
+		ORDER BY started_at DESC
+		LIMIT 50
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []StorageMigrationJob{}
+	for rows.Next() {
+		var j StorageMigrationJob
+		if err := rows.Scan(&j.ID, &j.Path, &j.SourceVolume, &j.TargetVolume, &j.Phase, &j.Status,
+			&j.BytesTotal, &j.FilesTotal, &j.Verified, &j.Error, &j.SwitchedAt, &j.StartedAt, &j.FinishedAt); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// resolveStorageMigrationSource validates the drive or home folder to move
+// and returns its data-root relative path
+func (h *Handler) resolveStorageMigrationSource(source string) (string, *APIError) {
+	parts := strings.Split(strings.TrimPrefix(path.Clean("/"+source), "/"), "/")
+	if len(parts) != 2 || (parts[0] != "shared" && parts[0] != "users") || parts[1] == "" || parts[1] == ".." {
+		return "", ErrInvalidPath("Source must be /shared/{folder} or /users/{username}")
+	}
+
+	var exists bool
+	var err error
+	if parts[0] == "shared" {
+		rows, qErr := h.db.Query(`SELECT name FROM shared_folders WHERE is_active = TRUE`)
+		if qErr != nil {
+			return "", ErrInternal("Failed to load shared drives")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if rows.Scan(&name) == nil && (name == parts[1] || sanitizeFolderName(name) == parts[1]) {
+				parts[1] = sanitizeFolderName(name)
+				exists = true
+				break
+			}
+		}
+	} else {
+		err = h.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)`, parts[1]).Scan(&exists)
+	}
+	if err != nil {
+		return "", ErrInternal("Failed to look up source")
+	}
+	if !exists {
+		return "", ErrNotFound("Shared drive or user")
+	}
+	return parts[0] + "/" + parts[1], nil
+}
+
+// StartStorageMigration moves a shared drive or home folder to another volume
+// @Summary		Migrate storage
+// @Description	Moves a shared drive (/shared/{folder}) or home folder (/users/{username}) to a volume from the storage_volumes setting while the service stays up. The tree is copied while the old location stays live, then writes below it are rejected with a retryable 503 for a few seconds while a final delta sync runs and the mapping switches. The old copy is removed once sizes and a checksum sample match. Starting a migration again for the same source resumes a failed or cancelled job.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		StorageMigrateRequest	true	"Source and target volume"
+// @Success		202		{object}	docs.SuccessResponse	"Job started"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Failure		409		{object}	docs.ErrorResponse	"A migration is already running"
+// @Failure		507		{object}	docs.ErrorResponse	"Not enough space on the target volume"
+// @Security	BearerAuth
+// @Router		/admin/storage/migrate [post]
+func (h *Handler) StartStorageMigration(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err // Error response already written
+	}
+
+	var req StorageMigrateRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if req.Source == "" {
+		return RespondError(c, ErrMissingParameter("source"))
+	}
+	if req.TargetVolume == "" {
+		return RespondError(c, ErrMissingParameter("targetVolume"))
+	}
+	rel, apiErr := h.resolveStorageMigrationSource(req.Source)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	target := filepath.Clean(req.TargetVolume)
+	configured := false
+	for _, v := range StorageVolumes() {
+		configured = configured || v == target
+	}
+	if !configured {
+		return RespondError(c, ErrBadRequest("Target volume is not listed in the storage_volumes setting"))
+	}
+	if err := validateStorageVolume(h.dataRoot, target); err != nil {
+		return RespondError(c, ErrBadRequest(err.Error()))
+	}
+	if run := runningStorageMigration(); run != nil {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "A storage migration is already running").WithDetails(run.snapshot()))
+	}
+
+	// A failed or cancelled job for the same move resumes from its phase
+	previous, err := h.loadStorageMigrations(`WHERE path = $1 AND target_volume = $2 AND phase <> $3 AND status <> $4`,
+		rel, target, StorageMigrationDone, AdoptCompleted)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load storage migrations"))
+	}
+	var job StorageMigrationJob
+	if len(previous) > 0 {
+		job = previous[0]
+		job.Status, job.Error, job.FinishedAt = AdoptRunning, "", nil
+		if err := h.saveStorageMigration(job); err != nil {
+			return RespondError(c, ErrInternal("Failed to resume storage migration"))
+		}
+	} else {
+		source := GetStorageLocations().Volume(rel)
+		if source == target {
+			return RespondError(c, ErrBadRequest("Source is already on the target volume"))
+		}
+		if _, err := os.Lstat(filepath.Join(target, filepath.FromSlash(rel))); err == nil {
+			return RespondError(c, NewAPIError(ErrCodeConflict, "Target already contains "+rel))
+		}
+		info, err := os.Stat(filepath.Join(source, filepath.FromSlash(rel)))
+		if err != nil || !info.IsDir() {
+			return RespondError(c, ErrNotFound("Source directory"))
+		}
+		stats := CalculateTotalSize(filepath.Join(source, filepath.FromSlash(rel)), info)
+		if free := getDiskInfo(target).Free; uint64(stats.TotalBytes) > free {
+			return RespondError(c, NewAPIError(ErrCodeStorageFull,
+				fmt.Sprintf("%s needs %s but %s has %s free", rel, formatBytes(stats.TotalBytes), target, formatBytes(int64(free)))))
+		}
+
+		job = StorageMigrationJob{
+			ID:           newActivityID(ActivityMigrate),
+			Path:         rel,
+			SourceVolume: source,
+			TargetVolume: target,
+			Phase:        StorageMigrationCopy,
+			Status:       AdoptRunning,
+			BytesTotal:   stats.TotalBytes,
+			FilesTotal:   int64(stats.TotalFiles),
+			StartedAt:    time.Now(),
+		}
+		if _, err := h.db.Exec(`
+			INSERT INTO storage_migrations (id, path, source_volume, target_volume, phase, status, bytes_total, files_total, started_by, started_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, job.ID, job.Path, job.SourceVolume, job.TargetVolume, job.Phase, job.Status,
+			job.BytesTotal, job.FilesTotal, claims.UserID, job.StartedAt); err != nil {
+			return RespondError(c, ErrInternal("Failed to create storage migration"))
+		}
+	}
+
+	run := h.startStorageMigration(job, claims.Username, claims.UserID, c.RealIP())
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data":    run.snapshot(),
+	})
+}
+
+// startStorageMigration registers a job and runs it in the background
+func (h *Handler) startStorageMigration(job StorageMigrationJob, username, actorID, clientIP string) *storageMigrationRun {
+	activity := GetActivityRegistry().Start(context.Background(), ActivityInfo{
+		ID:         job.ID,
+		Kind:       ActivityMigrate,
+		Username:   username,
+		Path:       "/" + job.Path,
+		BytesTotal: job.BytesTotal,
+	})
+	run := &storageMigrationRun{job: job}
+	storageMigrationsMu.Lock()
+	storageMigrations[job.ID] = run
+	storageMigrationsMu.Unlock()
+
+	go h.runStorageMigration(run, activity, actorID, clientIP)
+	return run
+}
+
+// ResumeStorageMigrations restarts jobs interrupted by a shutdown
+func (h *Handler) ResumeStorageMigrations() {
+	jobs, err := h.loadStorageMigrations(`WHERE status = $1`, AdoptRunning)
+	if err != nil {
+		log.Printf("[Storage] Failed to load interrupted migrations: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		log.Printf("[Storage] Resuming migration %s of %s in phase %s", job.ID, job.Path, job.Phase)
+		h.startStorageMigration(job, "", "", "")
+	}
+}
+
+// runStorageMigration runs a job from its current phase to the end
+func (h *Handler) runStorageMigration(run *storageMigrationRun, activity *Activity, actorID, clientIP string) {
+	defer activity.Finish()
+
+	err := h.storageMigrationPhases(run, activity)
+	now := time.Now()
+	run.update(func(j *StorageMigrationJob) {
+		j.FinishedAt = &now
+		switch {
+		case err == nil:
+			j.Status = AdoptCompleted
+		case activity.Err() != nil:
+			j.Status = AdoptCancelled
+			j.Error = err.Error()
+		default:
+			j.Status = AdoptFailed
+			j.Error = err.Error()
+		}
+	})
+	job := run.snapshot()
+	if saveErr := h.saveStorageMigration(job); saveErr != nil {
+		log.Printf("[Storage] Failed to save migration %s: %v", job.ID, saveErr)
+	}
+	if err != nil {
+		LogError("Storage migration failed", err, "job", job.ID, "path", job.Path, "phase", job.Phase)
+		return
+	}
+
+	var actor *string
+	if actorID != "" {
+		actor = &actorID
+	}
+	_ = h.auditHandler.LogEvent(actor, clientIP, EventAdminStorageMigrate, "/"+job.Path, map[string]interface{}{
+		"jobId":        job.ID,
+		"sourceVolume": job.SourceVolume,
+		"targetVolume": job.TargetVolume,
+		"bytes":        job.BytesTotal,
+		"files":        job.FilesTotal,
+		"verified":     job.Verified,
+	})
+}
+
+// storageMigrationPhases runs the remaining phases of a job, saving each one
+// so an interrupted job picks up at the phase it was in
+func (h *Handler) storageMigrationPhases(run *storageMigrationRun, activity *Activity) error {
+	job := run.snapshot()
+	key := filepath.FromSlash(job.Path)
+	oldDir := filepath.Join(job.SourceVolume, key)
+	newDir := filepath.Join(job.TargetVolume, key)
+	asideDir := filepath.Join(job.SourceVolume, storageMigratedDir, job.ID)
+
+	next := func(phase string) error {
+		run.update(func(j *StorageMigrationJob) { j.Phase = phase })
+		return h.saveStorageMigration(run.snapshot())
+	}
+	progress := func(p CopyProgress) {
+		run.update(func(j *StorageMigrationJob) {
+			j.BytesCopied, j.FilesCopied = p.CopiedBytes, int64(p.CopiedFiles)
+		})
+	}
+	syncTree := func() error {
+		ctx := NewCopyContext(FileStats{TotalBytes: job.BytesTotal, TotalFiles: int(job.FilesTotal)}, progress)
+		ctx.Activity = activity
+		return syncStorageTree(ctx, oldDir, newDir, func() {
+			progress(CopyProgress{CopiedBytes: ctx.CopiedBytes, CopiedFiles: ctx.CopiedFiles})
+		})
+	}
+	link := func() error {
+		return h.linkStorageLocation(job.Path, oldDir, newDir, asideDir)
+	}
+
+	switch job.Phase {
+	case StorageMigrationCopy:
+		// Listings and uploads keep using the old location meanwhile
+		if err := syncTree(); err != nil {
+			return err
+		}
+		if err := next(StorageMigrationSwitch); err != nil {
+			return err
+		}
+		fallthrough
+
+	case StorageMigrationSwitch:
+		if err := h.switchStorageLocation(run, activity, syncTree, link); err != nil {
+			return err
+		}
+		fallthrough
+
+	case StorageMigrationVerify:
+		// Idempotent: a job interrupted right after the switch finishes it here
+		if err := link(); err != nil {
+			return err
+		}
+		verified, err := verifyStorageCopy(activity, asideDir, newDir, *run.snapshot().SwitchedAt, job.FilesTotal)
+		run.update(func(j *StorageMigrationJob) { j.Verified = verified })
+		if err != nil {
+			return err
+		}
+		if err := next(StorageMigrationCleanup); err != nil {
+			return err
+		}
+		fallthrough
+
+	case StorageMigrationCleanup:
+		if err := os.RemoveAll(asideDir); err != nil {
+			return fmt.Errorf("remove old copy %s: %w", asideDir, err)
+		}
+		_ = os.Remove(filepath.Dir(asideDir)) // Only when no other old copy is left
+		return next(StorageMigrationDone)
+	}
+	return nil
+}
+
+// switchStorageLocation holds writes below the migrated folder, runs the
+// final delta sync and points the mapping and the data root path at the new
+// location
+func (h *Handler) switchStorageLocation(run *storageMigrationRun, activity *Activity, syncTree, link func() error) error {
+	job := run.snapshot()
+	locations := GetStorageLocations()
+	locations.quiesce(job.Path, true)
+	defer locations.quiesce(job.Path, false)
+
+	select {
+	case <-time.After(storageQuiesceGrace):
+	case <-activity.Context().Done():
+		return activity.Err()
+	}
+	if err := syncTree(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	run.update(func(j *StorageMigrationJob) {
+		j.Phase = StorageMigrationVerify
+		j.SwitchedAt = &now
+	})
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		INSERT INTO storage_locations (path, volume, migrated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (path) DO UPDATE SET volume = EXCLUDED.volume, migrated_at = NOW()
+	`, job.Path, job.TargetVolume); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE storage_migrations SET phase = $2, switched_at = $3 WHERE id = $1`,
+		job.ID, StorageMigrationVerify, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	locations.set(job.Path, job.TargetVolume)
+	log.Printf("[Storage] Switched %s to %s", job.Path, job.TargetVolume)
+	return link()
+}
+
+// linkStorageLocation moves the old copy aside and points the data root
+// path at the new location, for code that builds paths from the data root
+func (h *Handler) linkStorageLocation(rel, oldDir, newDir, asideDir string) error {
+	if info, err := os.Lstat(oldDir); err == nil && info.IsDir() {
+		if err := os.MkdirAll(filepath.Dir(asideDir), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(oldDir, asideDir); err != nil {
+			return fmt.Errorf("move old copy aside: %w", err)
+		}
+	}
+
+	link := filepath.Join(h.dataRoot, filepath.FromSlash(rel))
+	if target, err := os.Readlink(link); err == nil && target == newDir {
+		return nil
+	}
+	tmp := link + ".migrating"
+	_ = os.Remove(tmp)
+	if err := os.Symlink(newDir, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, link)
+}
+
+// syncStorageTree mirrors src into dst: new and changed files (by size and
+// modification time) are copied, entries gone from src are removed from dst.
+// Unchanged files are skipped, so a rerun continues an interrupted copy.
+func syncStorageTree(ctx *CopyContext, src, dst string, progress func()) error {
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Activity.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, p)
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			_ = os.Chmod(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if current, err := os.Readlink(target); err == nil && current == link {
+				return nil
+			}
+			_ = os.RemoveAll(target)
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			if t, err := os.Lstat(target); err == nil && t.Mode().IsRegular() &&
+				t.Size() == info.Size() && t.ModTime().Equal(info.ModTime()) {
+				ctx.CopiedBytes += info.Size()
+				ctx.CopiedFiles++
+				progress()
+				return nil
+			}
+			if err := ctx.CopyFileWithProgress(p, target); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+				return err
+			}
+			progress()
+		default:
+			return nil // Sockets, devices and pipes are not stored data
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			_ = os.Lchown(target, int(st.Uid), int(st.Gid))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove what was deleted from the old location since the last pass
+	return filepath.WalkDir(dst, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dst, p)
+		if _, err := os.Lstat(filepath.Join(src, rel)); errors.Is(err, fs.ErrNotExist) {
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+}
+
+// verifyStorageCopy compares the old copy with the new location: sizes of
+// every file and checksums of a sample. Files changed or deleted after the
+// switch are skipped. Returns the number of files checked.
+func verifyStorageCopy(activity *Activity, oldDir, newDir string, switchedAt time.Time, filesTotal int64) (int64, error) {
+	stride := max(filesTotal/storageVerifySample, 1)
+	var checked, index int64
+	var mismatches []string
+	err := filepath.WalkDir(oldDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if paceErr := activity.Pace(); paceErr != nil {
+			return paceErr
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(oldDir, p)
+		newInfo, err := os.Stat(filepath.Join(newDir, rel))
+		if err != nil || !newInfo.ModTime().Before(switchedAt) {
+			return nil
+		}
+		oldInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		checked++
+		index++
+		if oldInfo.Size() != newInfo.Size() {
+			mismatches = append(mismatches, rel)
+			return nil
+		}
+		if index%stride == 0 {
+			same, err := sameFileChecksum(p, filepath.Join(newDir, rel))
+			if err != nil {
+				return err
+			}
+			if !same {
+				mismatches = append(mismatches, rel)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return checked, err
+	}
+	if len(mismatches) > 0 {
+		return checked, fmt.Errorf("%d files differ from the old copy (kept at %s): %s",
+			len(mismatches), oldDir, selfTestList(mismatches))
+	}
+	return checked, nil
+}
+
+// sameFileChecksum compares the SHA-256 of two files
+func sameFileChecksum(a, b string) (bool, error) {
+	sum := func(p string) ([]byte, error) {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+	sumA, err := sum(a)
+	if err != nil {
+		return false, err
+	}
+	sumB, err := sum(b)
+	if err != nil {
+		return false, err
+	}
+	return string(sumA) == string(sumB), nil
+}
+
+// ListStorageMigrations returns volumes, moved folders and migration jobs
+// @Summary		Storage volumes and migrations
+// @Description	Returns the configured storage volumes with free space, the drives and home folders stored on them, and recent migration jobs with live counters
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Volumes, locations and jobs"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/storage/migrations [get]
+func (h *Handler) ListStorageMigrations(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err // Error response already written
+	}
+
+	jobs, err := h.loadStorageMigrations("")
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load storage migrations"))
+	}
+	for i := range jobs {
+		jobs[i] = liveStorageMigration(jobs[i])
+	}
+
+	volumes := []map[string]interface{}{}
+	for _, v := range StorageVolumes() {
+		volume := map[string]interface{}{"path": v, "disk": getDiskInfo(v)}
+		if err := validateStorageVolume(h.dataRoot, v); err != nil {
+			volume["error"] = err.Error()
+		}
+		volumes = append(volumes, volume)
+	}
+
+	return RespondSuccess(c, map[string]interface{}{
+		"volumes":   volumes,
+		"locations": GetStorageLocations().Locations(),
+		"jobs":      jobs,
+	})
+}
+
+// GetStorageMigration returns progress or the result of a migration job
+// @Summary		Storage migration status
+// @Description	Returns the phase, counters and status of a storage migration job
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Job ID"
+// @Success		200		{object}	docs.SuccessResponse	"Job status"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404		{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/storage/migrations/{id} [get]
+func (h *Handler) GetStorageMigration(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err // Error response already written
+	}
+	jobs, err := h.loadStorageMigrations(`WHERE id = $1`, c.Param("id"))
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to load storage migration"))
+	}
+	if len(jobs) == 0 {
+		return RespondError(c, ErrNotFound("Storage migration"))
+	}
+	return RespondSuccess(c, liveStorageMigration(jobs[0]))
+}
+
+// liveStorageMigration overlays the in-memory state of a running job
+func liveStorageMigration(job StorageMigrationJob) StorageMigrationJob {
+	storageMigrationsMu.Lock()
+	run := storageMigrations[job.ID]
+	storageMigrationsMu.Unlock()
+	if run == nil {
+		return job
+	}
+	return run.snapshot()
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// withStorageLocations installs a registry for the duration of the test
+func withStorageLocations(t *testing.T, tc *TestContext, dataRoot string, locations map[string]string) *StorageLocations {
+	t.Helper()
+	prev := globalStorageLocations
+	globalStorageLocations = &StorageLocations{db: tc.DB, dataRoot: dataRoot, locations: locations, quiesced: map[string]bool{}}
+	t.Cleanup(func() { globalStorageLocations = prev })
+	return globalStorageLocations
+}
+
+func TestStorageLocations_Map(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	r := withStorageLocations(t, tc, "/data", map[string]string{"shared/Media": "/data2"})
+
+	for in, want := range map[string]string{
+		"/data/shared/Media":         "/data2/shared/Media",
+		"/data/shared/Media/a/b.mp4": "/data2/shared/Media/a/b.mp4",
+		"/data/shared/MediaOld/a":    "/data/shared/MediaOld/a",
+		"/data/users/alice/a":        "/data/users/alice/a",
+	} {
+		if got := r.Map(in); got != want {
+			t.Errorf("Map(%s) = %s, want %s", in, got, want)
+		}
+		if got := r.Unmap(r.Map(in)); got != in {
+			t.Errorf("Unmap(Map(%s)) = %s", in, got)
+		}
+	}
+	if rel, _ := dataRootRel("/data", "/data2/shared/Media/a"); rel != "shared/Media/a" {
+		t.Errorf("dataRootRel = %s", rel)
+	}
+
+	h := &Handler{dataRoot: "/data"}
+	realPath, _, displayPath, err := h.resolvePath("/shared/Media/a.mp4", &JWTClaims{UserID: "u1", Username: "alice"})
+	if err != nil || realPath != "/data2/shared/Media/a.mp4" || displayPath != "/shared/Media/a.mp4" {
+		t.Errorf("resolvePath = %s, %s, %v", realPath, displayPath, err)
+	}
+}
+
+func TestStorageWriteError_Quiesced(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	r := withStorageLocations(t, tc, "/data", map[string]string{})
+
+	r.quiesce("shared/Media", true)
+	apiErr := storageWriteError("/data/shared/Media/a.txt")
+	if apiErr == nil || apiErr.HTTPStatus() != http.StatusServiceUnavailable {
+		t.Fatalf("storageWriteError = %v", apiErr)
+	}
+	if storageWriteError("/data/shared/Other/a.txt") != nil || storageWriteError("/data/users/alice/a.txt") != nil {
+		t.Error("writes outside the migrated folder were held")
+	}
+	rec := httptest.NewRecorder()
+	_ = RespondError(tc.Echo.NewContext(httptest.NewRequest(http.MethodPut, "/", nil), rec), apiErr)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+
+	r.quiesce("shared/Media", false)
+	if storageWriteError("/data/shared/Media/a.txt") != nil {
+		t.Error("writes still held after the switch")
+	}
+}
+
+func TestSyncStorageTree_Delta(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	_ = os.MkdirAll(filepath.Join(src, "a"), 0755)
+	_ = os.WriteFile(filepath.Join(src, "a", "keep.txt"), []byte("keep"), 0644)
+	_ = os.WriteFile(filepath.Join(src, "gone.txt"), []byte("gone"), 0644)
+
+	sync := func() *CopyContext {
+		ctx := NewCopyContext(FileStats{}, func(CopyProgress) {})
+		if err := syncStorageTree(ctx, src, dst, func() {}); err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+	sync()
+
+	// Changes made during the copy phase reach the new location on the next pass
+	_ = os.Remove(filepath.Join(src, "gone.txt"))
+	_ = os.WriteFile(filepath.Join(src, "new.txt"), []byte("new"), 0644)
+	ctx := sync()
+
+	if _, err := os.Stat(filepath.Join(dst, "gone.txt")); !os.IsNotExist(err) {
+		t.Error("deleted file was not removed from the new location")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "new.txt")); string(data) != "new" {
+		t.Errorf("new.txt = %q", data)
+	}
+	if ctx.CopiedFiles != 2 || ctx.CopiedBytes != 7 {
+		t.Errorf("second pass counted %d files, %d bytes", ctx.CopiedFiles, ctx.CopiedBytes)
+	}
+}
+
+func TestStorageMigration_Phases(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+	volume := t.TempDir()
+	locations := withStorageLocations(t, tc, h.dataRoot, map[string]string{})
+	prevGrace := storageQuiesceGrace
+	storageQuiesceGrace = 0
+	t.Cleanup(func() { storageQuiesceGrace = prevGrace })
+
+	oldDir := filepath.Join(h.dataRoot, "shared", "Media")
+	_ = os.MkdirAll(filepath.Join(oldDir, "clips"), 0755)
+	_ = os.WriteFile(filepath.Join(oldDir, "clips", "a.mp4"), []byte("aaaa"), 0644)
+	_ = os.WriteFile(filepath.Join(oldDir, "b.mp4"), []byte("bb"), 0644)
+
+	update := func() { tc.Mock.ExpectExec("UPDATE storage_migrations").WillReturnResult(sqlmock.NewResult(0, 1)) }
+	update() // switch
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("INSERT INTO storage_locations").WithArgs("shared/Media", volume).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("UPDATE storage_migrations SET phase").
+		WithArgs("migrate-1", StorageMigrationVerify, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+	update() // cleanup
+	update() // done
+
+	run := &storageMigrationRun{job: StorageMigrationJob{
+		ID:           "migrate-1",
+		Path:         "shared/Media",
+		SourceVolume: h.dataRoot,
+		TargetVolume: volume,
+		Phase:        StorageMigrationCopy,
+		Status:       AdoptRunning,
+		BytesTotal:   6,
+		FilesTotal:   2,
+		StartedAt:    time.Now(),
+	}}
+	if err := h.storageMigrationPhases(run, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	job := run.snapshot()
+	if job.Phase != StorageMigrationDone || job.Verified != 2 || job.BytesCopied != 6 {
+		t.Errorf("job = %+v", job)
+	}
+	newDir := filepath.Join(volume, "shared", "Media")
+	if data, _ := os.ReadFile(filepath.Join(newDir, "clips", "a.mp4")); string(data) != "aaaa" {
+		t.Errorf("a.mp4 on the new volume = %q", data)
+	}
+	if target, err := os.Readlink(oldDir); err != nil || target != newDir {
+		t.Errorf("data root path links to %q, %v", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(h.dataRoot, storageMigratedDir)); !os.IsNotExist(err) {
+		t.Error("old copy was not removed")
+	}
+	if got := locations.Map(filepath.Join(oldDir, "b.mp4")); got != filepath.Join(newDir, "b.mp4") {
+		t.Errorf("Map after the switch = %s", got)
+	}
+	if storageWriteError(filepath.Join(newDir, "b.mp4")) != nil {
+		t.Error("writes still held after the migration")
+	}
+}
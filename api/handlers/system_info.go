@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -15,18 +16,22 @@ import (
 
 // SystemInfo represents the server system information
 type SystemInfo struct {
-	Hostname    string          `json:"hostname"`
-	OS          string          `json:"os"`
-	Arch        string          `json:"arch"`
-	CPUs        int             `json:"cpus"`
-	GoVersion   string          `json:"goVersion"`
-	Memory      MemoryInfo      `json:"memory"`
-	Disk        DiskInfo        `json:"disk"`
-	Uptime      string          `json:"uptime"`
-	ServerTime  string          `json:"serverTime"`
-	DataPath    string          `json:"dataPath"`
-	ProjectInfo ProjectInfo     `json:"projectInfo"`
-	FolderTree  []FolderStat    `json:"folderTree"`
+	Hostname    string           `json:"hostname"`
+	OS          string           `json:"os"`
+	Arch        string           `json:"arch"`
+	CPUs        int              `json:"cpus"`
+	GoVersion   string           `json:"goVersion"`
+	Memory      MemoryInfo       `json:"memory"`
+	Disk        DiskInfo         `json:"disk"`
+	Uptime      string           `json:"uptime"`
+	ServerTime  string           `json:"serverTime"`
+	DataPath    string           `json:"dataPath"`
+	ProjectInfo ProjectInfo      `json:"projectInfo"`
+	FolderTree  []FolderStat     `json:"folderTree"`
+	CORS        CORSStatus       `json:"cors"`
+	RateLimit   RateLimitStatus  `json:"rateLimit"`
+	Pacing      PacingStatus     `json:"pacing"`
+	Integrity   *IntegrityStatus `json:"integrity,omitempty"` // Last reference check
 }
 
 // MemoryInfo represents memory statistics
@@ -80,7 +85,7 @@ type FolderStat struct {
 // GetSystemInfo returns system information
 func (h *Handler) GetSystemInfo(c echo.Context) error {
 	// Check admin permission
-	_, err := RequireAdmin(c)
+	_, err := RequireAdminPermission(c, PermStorageAdmin)
 	if err != nil {
 		return err
 	}
@@ -119,6 +124,10 @@ func (h *Handler) GetSystemInfo(c echo.Context) error {
 		DataPath:    h.dataRoot,
 		ProjectInfo: projectInfo,
 		FolderTree:  folderTree,
+		CORS:        EffectiveCORSStatus(c),
+		RateLimit:   EffectiveRateLimitStatus(),
+		Pacing:      EffectivePacingStatus(),
+		Integrity:   EffectiveIntegrityStatus(),
 	}
 
 	return RespondSuccess(c, info)
@@ -127,7 +136,7 @@ func (h *Handler) GetSystemInfo(c echo.Context) error {
 // GetFolderTreeAPI returns folder tree for a specific path
 func (h *Handler) GetFolderTreeAPI(c echo.Context) error {
 	// Check admin permission
-	_, err := RequireAdmin(c)
+	_, err := RequireAdminPermission(c, PermStorageAdmin)
 	if err != nil {
 		return err
 	}
@@ -309,10 +318,18 @@ func (h *Handler) getFolderTree(rootPath string, maxDepth int) []FolderStat {
 }
 
 func calculateDirSize(path string) (int64, int) {
+	if u, ok := GetDirSizes().Usage(context.Background(), path); ok {
+		return u.Bytes, int(u.Files)
+	}
+
+	pace := GetBackgroundPacer().Begin("dir-size", PacePriorityUser)
+	defer pace.End()
+
 	var size int64
 	var count int
 
 	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		pace.Pace()
 		if err != nil {
 			return nil
 		}
@@ -14,7 +14,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/labstack/echo/v4"
 	"golang.org/x/image/draw"
@@ -56,17 +55,16 @@ type ThumbnailWorkerPool struct {
 	jobs    chan ThumbnailJob
 	workers int
 	wg      sync.WaitGroup
-	cache   *PreviewCache
+
+	mu      sync.Mutex
+	pending map[string]bool // queued jobs without a result channel
 }
 
 // ThumbnailJob represents a thumbnail generation job
 type ThumbnailJob struct {
-	FilePath    string
-	CacheKey    string
-	Size        ThumbnailSize
-	IsVideo     bool
-	ModTime     time.Time
-	ResultChan  chan ThumbnailResult
+	FilePath   string
+	Size       ThumbnailSize
+	ResultChan chan ThumbnailResult
 }
 
 // ThumbnailResult contains the result of thumbnail generation
@@ -76,11 +74,11 @@ type ThumbnailResult struct {
 }
 
 // NewThumbnailWorkerPool creates a new worker pool
-func NewThumbnailWorkerPool(workers int, cache *PreviewCache) *ThumbnailWorkerPool {
+func NewThumbnailWorkerPool(workers int) *ThumbnailWorkerPool {
 	pool := &ThumbnailWorkerPool{
 		jobs:    make(chan ThumbnailJob, 100),
 		workers: workers,
-		cache:   cache,
+		pending: make(map[string]bool),
 	}
 
 	// Start workers
@@ -92,25 +90,18 @@ func NewThumbnailWorkerPool(workers int, cache *PreviewCache) *ThumbnailWorkerPo
 	return pool
 }
 
-// worker processes thumbnail jobs
+// worker processes thumbnail jobs. The file is looked at when the job runs,
+// so a job queued for a file that has changed since caches the new version.
 func (p *ThumbnailWorkerPool) worker() {
 	defer p.wg.Done()
 
 	for job := range p.jobs {
 		var data []byte
-		var err error
-
-		if job.IsVideo {
-			data, err = generateVideoThumbnail(job.FilePath, job.Size)
-		} else {
-			data, err = generateImageThumbnail(job.FilePath, job.Size)
-		}
-
-		// Cache the result
-		if err == nil && len(data) > 0 && p.cache != nil {
-			suffix := fmt.Sprintf("thumb:%s", job.Size.Name)
-			_ = p.cache.Set(job.FilePath, job.ModTime, suffix, data)
+		info, err := os.Stat(job.FilePath)
+		if err == nil {
+			data, _, err = cachedThumbnail(job.FilePath, info, job.Size, "jpeg")
 		}
+		p.done(job)
 
 		// Send result if channel provided
 		if job.ResultChan != nil {
@@ -119,18 +110,42 @@ func (p *ThumbnailWorkerPool) worker() {
 	}
 }
 
-// Submit adds a job to the worker pool
+// Submit adds a job to the worker pool. A job without a result channel for a
+// thumbnail that is already queued is dropped.
 func (p *ThumbnailWorkerPool) Submit(job ThumbnailJob) {
+	if job.ResultChan == nil {
+		p.mu.Lock()
+		if p.pending[job.key()] {
+			p.mu.Unlock()
+			return
+		}
+		p.pending[job.key()] = true
+		p.mu.Unlock()
+	}
+
 	select {
 	case p.jobs <- job:
 	default:
 		// Queue is full, skip job
+		p.done(job)
 		if job.ResultChan != nil {
 			job.ResultChan <- ThumbnailResult{Error: fmt.Errorf("worker queue full")}
 		}
 	}
 }
 
+func (p *ThumbnailWorkerPool) done(job ThumbnailJob) {
+	if job.ResultChan == nil {
+		p.mu.Lock()
+		delete(p.pending, job.key())
+		p.mu.Unlock()
+	}
+}
+
+func (job ThumbnailJob) key() string {
+	return job.FilePath + "\x00" + job.Size.Name
+}
+
 // Close shuts down the worker pool
 func (p *ThumbnailWorkerPool) Close() {
 	close(p.jobs)
@@ -140,8 +155,7 @@ func (p *ThumbnailWorkerPool) Close() {
 // GetThumbnailWorkerPool returns the global thumbnail worker pool
 func GetThumbnailWorkerPool() *ThumbnailWorkerPool {
 	thumbnailPoolOnce.Do(func() {
-		cache := GetPreviewCache()
-		thumbnailWorkerPool = NewThumbnailWorkerPool(4, cache) // 4 workers
+		thumbnailWorkerPool = NewThumbnailWorkerPool(4) // 4 workers
 	})
 	return thumbnailWorkerPool
 }
@@ -207,7 +221,7 @@ func (h *Handler) GetThumbnail(c echo.Context) error {
 	}
 
 	ext := strings.ToLower(filepath.Ext(info.Name()))
-	isImage := supportedImageExts[ext]
+	isImage := supportedImageExts[ext] || isConvertedImage(ext)
 	isVideo := supportedVideoExts[ext]
 
 	if !isImage && !isVideo {
@@ -219,55 +233,29 @@ func (h *Handler) GetThumbnail(c echo.Context) error {
 	// Generate ETag
 	etag := GenerateETag(realPath+sizeName+format, info.ModTime(), info.Size())
 
-	// Check If-None-Match
-	if !CheckETag(c.Request(), etag) {
+	// Check If-None-Match and If-Modified-Since
+	if !CheckETag(c.Request(), etag) || !CheckModifiedSince(c.Request(), info.ModTime()) {
 		return c.NoContent(http.StatusNotModified)
 	}
 
-	// Try to get from cache
-	cache := GetPreviewCache()
-	suffix := fmt.Sprintf("thumb:%s:%s", sizeName, format)
-	if cache != nil {
-		if data, ok := cache.Get(realPath, info.ModTime(), suffix); ok {
-			SetCacheHeaders(c.Response().Writer, etag, 604800) // 7 days
-			contentType := "image/jpeg"
-			if format == "webp" {
-				contentType = "image/webp"
-			}
-			c.Response().Header().Set("Content-Type", contentType)
-			c.Response().Header().Set("X-Thumbnail-Cached", "true")
-			return c.Blob(http.StatusOK, contentType, data)
-		}
-	}
-
-	// Generate thumbnail
-	var thumbData []byte
-	if isVideo {
-		thumbData, err = generateVideoThumbnail(realPath, size)
-	} else {
-		thumbData, err = generateImageThumbnail(realPath, size)
-	}
-
+	thumbData, cached, err := cachedThumbnail(realPath, info, size, format)
 	if err != nil {
+		// Converted formats without a usable preview fall back to the file icon
+		if isConvertedImage(ext) {
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{
+				"error": "Preview not available for this file",
+			})
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": fmt.Sprintf("Failed to generate thumbnail: %v", err),
 		})
 	}
 
-	// Convert to WebP if requested
-	if format == "webp" && len(thumbData) > 0 {
-		webpData, webpErr := convertToWebP(thumbData)
-		if webpErr == nil {
-			thumbData = webpData
-		}
-	}
-
-	// Cache the result
-	if cache != nil && len(thumbData) > 0 {
-		_ = cache.Set(realPath, info.ModTime(), suffix, thumbData)
-	}
-
 	SetCacheHeaders(c.Response().Writer, etag, 604800) // 7 days
+	c.Response().Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	if cached {
+		c.Response().Header().Set("X-Thumbnail-Cached", "true")
+	}
 	contentType := "image/jpeg"
 	if format == "webp" {
 		contentType = "image/webp"
@@ -277,16 +265,10 @@ func (h *Handler) GetThumbnail(c echo.Context) error {
 
 // generateImageThumbnail creates a thumbnail from an image file
 func generateImageThumbnail(filePath string, size ThumbnailSize) ([]byte, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	// Decode image
-	img, _, err := image.Decode(file)
+	// Decode image (HEIC, PSD and RAW are converted first)
+	img, err := decodeImageFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %w", err)
+		return nil, err
 	}
 
 	// Calculate new dimensions maintaining aspect ratio
@@ -469,7 +451,7 @@ func (h *Handler) PreloadThumbnails(c echo.Context) error {
 	}
 
 	pool := GetThumbnailWorkerPool()
-	cache := GetPreviewCache()
+	cache := GetThumbnailCache()
 	queued := 0
 
 	entries, err := os.ReadDir(realPath)
@@ -485,7 +467,7 @@ func (h *Handler) PreloadThumbnails(c echo.Context) error {
 		}
 
 		ext := strings.ToLower(filepath.Ext(entry.Name()))
-		isImage := supportedImageExts[ext]
+		isImage := supportedImageExts[ext] || isConvertedImage(ext)
 		isVideo := supportedVideoExts[ext]
 
 		if !isImage && !isVideo {
@@ -499,19 +481,14 @@ func (h *Handler) PreloadThumbnails(c echo.Context) error {
 		}
 
 		// Check if already cached
-		suffix := fmt.Sprintf("thumb:%s:jpeg", "medium")
-		if cache != nil {
-			if _, ok := cache.Get(filePath, fileInfo.ModTime(), suffix); ok {
-				continue // Already cached
-			}
+		if _, ok := cache.Get(filePath, fileInfo, ThumbnailSizes["medium"], "jpeg"); ok {
+			continue
 		}
 
 		// Queue for generation
 		pool.Submit(ThumbnailJob{
 			FilePath: filePath,
 			Size:     ThumbnailSizes["medium"],
-			IsVideo:  isVideo,
-			ModTime:  fileInfo.ModTime(),
 		})
 		queued++
 	}
@@ -560,7 +537,7 @@ func (h *Handler) GetBatchThumbnails(c echo.Context) error {
 		claims = user
 	}
 
-	cache := GetPreviewCache()
+	cache := GetThumbnailCache()
 	results := make(map[string]interface{})
 
 	for _, path := range req.Paths {
@@ -577,7 +554,7 @@ func (h *Handler) GetBatchThumbnails(c echo.Context) error {
 		}
 
 		ext := strings.ToLower(filepath.Ext(info.Name()))
-		isImage := supportedImageExts[ext]
+		isImage := supportedImageExts[ext] || isConvertedImage(ext)
 		isVideo := supportedVideoExts[ext]
 
 		if !isImage && !isVideo {
@@ -586,15 +563,12 @@ func (h *Handler) GetBatchThumbnails(c echo.Context) error {
 		}
 
 		// Try cache
-		suffix := fmt.Sprintf("thumb:%s:jpeg", sizeName)
-		if cache != nil {
-			if _, ok := cache.Get(realPath, info.ModTime(), suffix); ok {
-				results[path] = map[string]interface{}{
-					"status": "cached",
-					"url":    fmt.Sprintf("/api/thumbnail/%s?size=%s", strings.TrimPrefix(path, "/"), sizeName),
-				}
-				continue
+		if _, ok := cache.Get(realPath, info, size, "jpeg"); ok {
+			results[path] = map[string]interface{}{
+				"status": "cached",
+				"url":    fmt.Sprintf("/api/thumbnail/%s?size=%s", strings.TrimPrefix(path, "/"), sizeName),
 			}
+			continue
 		}
 
 		// Queue for generation
@@ -602,8 +576,6 @@ func (h *Handler) GetBatchThumbnails(c echo.Context) error {
 		pool.Submit(ThumbnailJob{
 			FilePath: realPath,
 			Size:     size,
-			IsVideo:  isVideo,
-			ModTime:  info.ModTime(),
 		})
 
 		results[path] = map[string]interface{}{
@@ -617,25 +589,26 @@ func (h *Handler) GetBatchThumbnails(c echo.Context) error {
 
 // ThumbnailStats returns thumbnail cache statistics
 func (h *Handler) ThumbnailStats(c echo.Context) error {
-	cache := GetPreviewCache()
+	cache := GetThumbnailCache()
 	if cache == nil {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"enabled": false,
 		})
 	}
 
-	files, size, oldest := cache.GetStats()
+	stats := cache.Stats()
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"enabled":     true,
-		"totalFiles":  files,
-		"totalSize":   size,
-		"oldestEntry": oldest,
+		"totalFiles":  stats.Files,
+		"totalSize":   stats.Bytes,
+		"maxSize":     stats.MaxBytes,
+		"oldestEntry": stats.Oldest,
 	})
 }
 
 // ClearThumbnailCache clears the thumbnail cache (admin only)
 func (h *Handler) ClearThumbnailCache(c echo.Context) error {
-	cache := GetPreviewCache()
+	cache := GetThumbnailCache()
 	if cache == nil {
 		return c.JSON(http.StatusOK, map[string]string{
 			"message": "Cache not enabled",
@@ -688,7 +661,7 @@ func (h *Handler) GetResponsiveThumbnail(c echo.Context) error {
 	}
 
 	ext := strings.ToLower(filepath.Ext(info.Name()))
-	if !supportedImageExts[ext] && !supportedVideoExts[ext] {
+	if !supportedImageExts[ext] && !supportedVideoExts[ext] && !isConvertedImage(ext) {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Unsupported file type",
 		})
@@ -713,5 +686,5 @@ func (h *Handler) GetResponsiveThumbnail(c echo.Context) error {
 // IsThumbnailSupported checks if a file type supports thumbnails
 func IsThumbnailSupported(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
-	return supportedImageExts[ext] || supportedVideoExts[ext]
+	return supportedImageExts[ext] || supportedVideoExts[ext] || isConvertedImage(ext)
 }
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultThumbnailCacheMaxBytes caps the thumbnail cache when the setting is missing
+const defaultThumbnailCacheMaxBytes int64 = 2 << 30
+
+// pregeneratedThumbnailSizes are made ahead for new images and videos: the
+// sizes the file list and the grid ask for
+var pregeneratedThumbnailSizes = []string{"small", "medium"}
+
+// thumbnailSourceFile names the file in each entry directory holding the real
+// path of the source, so entries can be found again after a restart
+const thumbnailSourceFile = "source"
+
+// ThumbnailCache keeps generated thumbnails on disk under <dataRoot>/.thumbnails.
+// Every source file has a directory named after the hash of its real path;
+// its thumbnails are named after the source's mtime and size and the
+// requested dimensions and format, so a changed source never hits a stale
+// thumbnail. The total size is capped by thumbnail_cache_max_bytes, evicting
+// the least recently used thumbnails first.
+type ThumbnailCache struct {
+	root     string
+	maxBytes func() int64
+
+	mu   sync.Mutex
+	lru  *list.List // of *thumbnailCacheEntry, most recently used first
+	dirs map[string]*thumbnailCacheDir
+	used int64
+}
+
+// thumbnailCacheDir holds the thumbnails of one source file
+type thumbnailCacheDir struct {
+	source   string
+	variants map[string]*list.Element
+}
+
+// thumbnailCacheEntry is one cached thumbnail
+type thumbnailCacheEntry struct {
+	dir      string
+	name     string
+	size     int64
+	lastUsed time.Time
+}
+
+var globalThumbnailCache *ThumbnailCache
+
+// InitThumbnailCache opens the thumbnail cache and indexes the thumbnails
+// kept from earlier runs. Entries of sources that no longer exist are removed.
+func InitThumbnailCache(dataRoot string) *ThumbnailCache {
+	cache, err := newThumbnailCache(filepath.Join(dataRoot, ".thumbnails"), func() int64 {
+		if settings := GetGlobalSettingsHandler(); settings != nil {
+			return settings.GetThumbnailCacheMaxBytes()
+		}
+		return defaultThumbnailCacheMaxBytes
+	})
+	if err != nil {
+		LogError("Failed to open thumbnail cache", err)
+		return nil
+	}
+	globalThumbnailCache = cache
+	return cache
+}
+
+// GetThumbnailCache returns the thumbnail cache (nil if not initialized)
+func GetThumbnailCache() *ThumbnailCache {
+	return globalThumbnailCache
+}
+
+func newThumbnailCache(root string, maxBytes func() int64) (*ThumbnailCache, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail cache directory: %w", err)
+	}
+	c := &ThumbnailCache{
+		root:     root,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		dirs:     make(map[string]*thumbnailCacheDir),
+	}
+	c.load()
+	return c, nil
+}
+
+// load indexes the thumbnails on disk, ordered by their last use
+func (c *ThumbnailCache) load() {
+	var found []*thumbnailCacheEntry
+	shards, _ := os.ReadDir(c.root)
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		dirs, _ := os.ReadDir(filepath.Join(c.root, shard.Name()))
+		for _, d := range dirs {
+			dirPath := filepath.Join(c.root, shard.Name(), d.Name())
+			source, err := os.ReadFile(filepath.Join(dirPath, thumbnailSourceFile))
+			if err != nil {
+				_ = os.RemoveAll(dirPath)
+				continue
+			}
+			if _, err := os.Stat(string(source)); err != nil {
+				_ = os.RemoveAll(dirPath)
+				continue
+			}
+			c.dirs[d.Name()] = &thumbnailCacheDir{source: string(source), variants: make(map[string]*list.Element)}
+			files, _ := os.ReadDir(dirPath)
+			for _, f := range files {
+				info, err := f.Info()
+				if err != nil || f.Name() == thumbnailSourceFile || strings.HasPrefix(f.Name(), ".") {
+					continue
+				}
+				found = append(found, &thumbnailCacheEntry{dir: d.Name(), name: f.Name(), size: info.Size(), lastUsed: info.ModTime()})
+			}
+			if len(found) == 0 || found[len(found)-1].dir != d.Name() {
+				delete(c.dirs, d.Name())
+				_ = os.RemoveAll(dirPath)
+			}
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].lastUsed.After(found[j].lastUsed) })
+	for _, entry := range found {
+		c.dirs[entry.dir].variants[entry.name] = c.lru.PushBack(entry)
+		c.used += entry.size
+	}
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+}
+
+// thumbnailKey returns the directory of a source and the name of one of its
+// thumbnails
+func thumbnailKey(realPath string, info os.FileInfo, size ThumbnailSize, format string) (dir, name string) {
+	sum := sha256.Sum256([]byte(realPath))
+	dir = hex.EncodeToString(sum[:])
+	name = fmt.Sprintf("%s%dx%d.%s", thumbnailVersion(info), size.Width, size.Height, format)
+	return dir, name
+}
+
+// thumbnailVersion is the name prefix shared by all thumbnails of one version
+// of a source
+func thumbnailVersion(info os.FileInfo) string {
+	return fmt.Sprintf("%d-%d-", info.ModTime().UnixNano(), info.Size())
+}
+
+func (c *ThumbnailCache) dirPath(dir string) string {
+	return filepath.Join(c.root, dir[:2], dir)
+}
+
+// Get returns a cached thumbnail of the current version of realPath
+func (c *ThumbnailCache) Get(realPath string, info os.FileInfo, size ThumbnailSize, format string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	dir, name := thumbnailKey(realPath, info, size, format)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.dirs[dir]
+	if !ok {
+		return nil, false
+	}
+	elem, ok := d.variants[name]
+	if !ok {
+		return nil, false
+	}
+	file := filepath.Join(c.dirPath(dir), name)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		c.removeLocked(elem)
+		return nil, false
+	}
+	// The file time keeps the use order across restarts
+	now := time.Now()
+	elem.Value.(*thumbnailCacheEntry).lastUsed = now
+	_ = os.Chtimes(file, now, now)
+	c.lru.MoveToFront(elem)
+	return data, true
+}
+
+// Set stores a thumbnail of the current version of realPath, removing the
+// thumbnails of earlier versions
+func (c *ThumbnailCache) Set(realPath string, info os.FileInfo, size ThumbnailSize, format string, data []byte) error {
+	// Thumbnails of encrypted files would be plaintext copies on disk
+	if c == nil || (GetFileEncryption().Active() && IsEncryptedFile(realPath)) {
+		return nil
+	}
+	dir, name := thumbnailKey(realPath, info, size, format)
+	dirPath := c.dirPath(dir)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("failed to create thumbnail directory: %w", err)
+	}
+	d, ok := c.dirs[dir]
+	if !ok {
+		if err := os.WriteFile(filepath.Join(dirPath, thumbnailSourceFile), []byte(realPath), 0644); err != nil {
+			return fmt.Errorf("failed to write thumbnail source: %w", err)
+		}
+		d = &thumbnailCacheDir{source: realPath, variants: make(map[string]*list.Element)}
+		c.dirs[dir] = d
+	}
+	tmp := filepath.Join(dirPath, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dirPath, name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+
+	if elem, ok := d.variants[name]; ok {
+		entry := elem.Value.(*thumbnailCacheEntry)
+		c.used += int64(len(data)) - entry.size
+		entry.size, entry.lastUsed = int64(len(data)), time.Now()
+		c.lru.MoveToFront(elem)
+	} else {
+		entry := &thumbnailCacheEntry{dir: dir, name: name, size: int64(len(data)), lastUsed: time.Now()}
+		d.variants[name] = c.lru.PushFront(entry)
+		c.used += entry.size
+	}
+	version := thumbnailVersion(info)
+	for variant, elem := range d.variants {
+		if !strings.HasPrefix(variant, version) {
+			c.removeLocked(elem)
+		}
+	}
+	c.evictLocked()
+	return nil
+}
+
+// Invalidate removes the thumbnails of realPath and, for a folder, of
+// everything in it
+func (c *ThumbnailCache) Invalidate(realPath string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for dir, d := range c.dirs {
+		if d.source == realPath || strings.HasPrefix(d.source, realPath+"/") {
+			c.removeDirLocked(dir)
+		}
+	}
+}
+
+// Clear removes every cached thumbnail
+func (c *ThumbnailCache) Clear() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for dir := range c.dirs {
+		c.removeDirLocked(dir)
+	}
+	return nil
+}
+
+// ThumbnailCacheStats describes the thumbnail cache
+type ThumbnailCacheStats struct {
+	Files    int
+	Bytes    int64
+	MaxBytes int64     // 0 = unlimited
+	Oldest   time.Time // last use of the least recently used thumbnail
+}
+
+// Stats returns the number and total size of cached thumbnails
+func (c *ThumbnailCache) Stats() ThumbnailCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := ThumbnailCacheStats{Files: c.lru.Len(), Bytes: c.used, MaxBytes: c.maxBytes()}
+	if back := c.lru.Back(); back != nil {
+		stats.Oldest = back.Value.(*thumbnailCacheEntry).lastUsed
+	}
+	return stats
+}
+
+// evictLocked removes the least recently used thumbnails until the cache
+// fits its limit
+func (c *ThumbnailCache) evictLocked() {
+	limit := c.maxBytes()
+	if limit <= 0 {
+		return
+	}
+	for c.used > limit && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// removeLocked removes one thumbnail, and its directory once it is empty
+func (c *ThumbnailCache) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*thumbnailCacheEntry)
+	c.used -= entry.size
+	_ = os.Remove(filepath.Join(c.dirPath(entry.dir), entry.name))
+	d := c.dirs[entry.dir]
+	delete(d.variants, entry.name)
+	if len(d.variants) == 0 {
+		delete(c.dirs, entry.dir)
+		_ = os.RemoveAll(c.dirPath(entry.dir))
+	}
+}
+
+func (c *ThumbnailCache) removeDirLocked(dir string) {
+	for _, elem := range c.dirs[dir].variants {
+		entry := c.lru.Remove(elem).(*thumbnailCacheEntry)
+		c.used -= entry.size
+	}
+	delete(c.dirs, dir)
+	_ = os.RemoveAll(c.dirPath(dir))
+}
+
+// cachedThumbnail returns the thumbnail of realPath in the given size and
+// format (jpeg or webp), generating and caching it on a miss
+func cachedThumbnail(realPath string, info os.FileInfo, size ThumbnailSize, format string) (data []byte, cached bool, err error) {
+	cache := GetThumbnailCache()
+	if data, ok := cache.Get(realPath, info, size, format); ok {
+		return data, true, nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(realPath))
+	if supportedVideoExts[ext] || strings.HasPrefix(getMimeType(strings.TrimPrefix(ext, ".")), "video/") {
+		data, err = generateVideoThumbnail(realPath, size)
+	} else {
+		data, err = generateImageThumbnail(realPath, size)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if format == "webp" && len(data) > 0 {
+		if webpData, webpErr := convertToWebP(data); webpErr == nil {
+			data = webpData
+		}
+	}
+	if len(data) > 0 {
+		if err := cache.Set(realPath, info, size, format, data); err != nil {
+			log.Printf("[Thumbnail] Failed to cache thumbnail of %s: %v", realPath, err)
+		}
+	}
+	return data, false, nil
+}
+
+// PregenerateThumbnails queues the thumbnails clients ask for first of a new
+// or changed image or video, so opening its folder does not wait for them
+func PregenerateThumbnails(realPath string) {
+	if GetThumbnailCache() == nil || !IsThumbnailSupported(realPath) {
+		return
+	}
+	if info, err := os.Stat(realPath); err != nil || info.IsDir() {
+		return
+	}
+	pool := GetThumbnailWorkerPool()
+	for _, sizeName := range pregeneratedThumbnailSizes {
+		pool.Submit(ThumbnailJob{FilePath: realPath, Size: ThumbnailSizes[sizeName]})
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testThumbnailCache(t *testing.T, root string, maxBytes int64) *ThumbnailCache {
+	t.Helper()
+	cache, err := newThumbnailCache(root, func() int64 { return maxBytes })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestThumbnailCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	src := t.TempDir()
+	writeTestFiles(t, src, "a.jpg", "b.jpg", "c.jpg")
+	cache := testThumbnailCache(t, t.TempDir(), 250)
+	small := ThumbnailSizes["small"]
+	thumb := make([]byte, 100)
+
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		path := filepath.Join(src, name)
+		if err := cache.Set(path, mustStat(t, path), small, "jpeg", thumb); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := filepath.Join(src, "a.jpg")
+	if _, ok := cache.Get(a, mustStat(t, a), small, "jpeg"); !ok {
+		t.Fatal("a not cached")
+	}
+	c := filepath.Join(src, "c.jpg")
+	if err := cache.Set(c, mustStat(t, c), small, "jpeg", thumb); err != nil {
+		t.Fatal(err)
+	}
+
+	b := filepath.Join(src, "b.jpg")
+	if _, ok := cache.Get(b, mustStat(t, b), small, "jpeg"); ok {
+		t.Error("least recently used thumbnail kept")
+	}
+	if _, ok := cache.Get(a, mustStat(t, a), small, "jpeg"); !ok {
+		t.Error("recently used thumbnail evicted")
+	}
+	if stats := cache.Stats(); stats.Files != 2 || stats.Bytes != 200 || stats.MaxBytes != 250 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestThumbnailCache_ChangedSourceReplacesThumbnails(t *testing.T) {
+	src := t.TempDir()
+	writeTestFiles(t, src, "a.jpg")
+	path := filepath.Join(src, "a.jpg")
+	cache := testThumbnailCache(t, t.TempDir(), 0)
+
+	old := mustStat(t, path)
+	_ = cache.Set(path, old, ThumbnailSizes["small"], "jpeg", []byte("old small"))
+	_ = cache.Set(path, old, ThumbnailSizes["medium"], "jpeg", []byte("old medium"))
+
+	later := old.ModTime().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	info := mustStat(t, path)
+	if _, ok := cache.Get(path, info, ThumbnailSizes["small"], "jpeg"); ok {
+		t.Fatal("thumbnail of the old version served")
+	}
+	_ = cache.Set(path, info, ThumbnailSizes["small"], "jpeg", []byte("new"))
+
+	if data, ok := cache.Get(path, info, ThumbnailSizes["small"], "jpeg"); !ok || string(data) != "new" {
+		t.Errorf("thumbnail = %q, %v", data, ok)
+	}
+	dir, _ := thumbnailKey(path, info, ThumbnailSizes["small"], "jpeg")
+	if entries, _ := os.ReadDir(cache.dirPath(dir)); len(entries) != 2 {
+		t.Errorf("%d files in the entry directory, want the source and one thumbnail", len(entries))
+	}
+	if stats := cache.Stats(); stats.Files != 1 || stats.Bytes != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestThumbnailCache_InvalidateAndReload(t *testing.T) {
+	src := t.TempDir()
+	writeTestFiles(t, src, "photos/a.jpg", "photos/b.jpg", "c.jpg")
+	root := t.TempDir()
+	cache := testThumbnailCache(t, root, 0)
+	small := ThumbnailSizes["small"]
+	for _, name := range []string{"photos/a.jpg", "photos/b.jpg", "c.jpg"} {
+		path := filepath.Join(src, name)
+		_ = cache.Set(path, mustStat(t, path), small, "jpeg", []byte(name))
+	}
+
+	// A removed folder takes the thumbnails of everything in it
+	cache.Invalidate(filepath.Join(src, "photos"))
+	if stats := cache.Stats(); stats.Files != 1 {
+		t.Errorf("%d thumbnails after invalidating the folder", stats.Files)
+	}
+
+	// Thumbnails survive a restart unless their source is gone
+	path := filepath.Join(src, "photos", "d.jpg")
+	writeTestFiles(t, src, "photos/d.jpg")
+	_ = cache.Set(path, mustStat(t, path), small, "jpeg", []byte("d"))
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := testThumbnailCache(t, root, 0)
+	c := filepath.Join(src, "c.jpg")
+	if data, ok := reloaded.Get(c, mustStat(t, c), small, "jpeg"); !ok || string(data) != "c.jpg" {
+		t.Errorf("thumbnail after reload = %q, %v", data, ok)
+	}
+	if stats := reloaded.Stats(); stats.Files != 1 {
+		t.Errorf("%d thumbnails after reload, want the one with a source", stats.Files)
+	}
+}
+
+func TestGetPreview_ThumbnailFromCache(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for x := 0; x < 640; x++ {
+		img.Set(x, x%480, color.RGBA{R: 255, A: 255})
+	}
+	f, err := os.Create(filepath.Join(home, "photo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	prev := globalThumbnailCache
+	globalThumbnailCache = testThumbnailCache(t, filepath.Join(h.dataRoot, ".thumbnails"), 0)
+	defer func() { globalThumbnailCache = prev }()
+
+	preview := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/preview/home/photo.png?size=small", nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		c := CreateAuthenticatedContext(tc.Echo, rec, req, "u1", "alice", false)
+		c.SetParamNames("*")
+		c.SetParamValues("home/photo.png")
+		if err := h.GetPreview(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	first := preview(nil)
+	AssertStatus(t, first, http.StatusOK)
+	if first.Header().Get("X-Thumbnail-Cached") != "" || first.Header().Get("Last-Modified") == "" {
+		t.Errorf("first response headers = %v", first.Header())
+	}
+	thumb, _, err := image.Decode(first.Body)
+	if err != nil || thumb.Bounds().Dx() != 100 || thumb.Bounds().Dy() != 75 {
+		t.Fatalf("thumbnail = %v, %v", thumb, err)
+	}
+
+	second := preview(nil)
+	if second.Header().Get("X-Thumbnail-Cached") != "true" {
+		t.Error("second request not served from the cache")
+	}
+
+	etag := second.Header().Get("ETag")
+	AssertStatus(t, preview(http.Header{"If-None-Match": {etag}}), http.StatusNotModified)
+	AssertStatus(t, preview(http.Header{"If-Modified-Since": {second.Header().Get("Last-Modified")}}), http.StatusNotModified)
+}
@@ -209,11 +209,24 @@ func (h *TOTPHandler) Enable2FA(c echo.Context) error {
 	// Audit log
 	h.auditHandler.LogEventFromContext(c, "user.2fa.enable", claims.Username, nil)
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
+	response := map[string]interface{}{
 		"success":     true,
 		"message":     "2FA enabled successfully",
 		"backupCodes": backupCodes,
-	})
+	}
+
+	// Swap a 2FA setup token for a full session now that the policy is met
+	if claims.IsLimited() {
+		token, err := issueSessionToken(c, claims.UserID, claims.Username, claims.IsAdmin, false)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to generate token",
+			})
+		}
+		response["token"] = token
+	}
+
+	return c.JSON(http.StatusOK, response)
 }
 
 // Disable2FARequest represents the request to disable 2FA
@@ -275,9 +288,9 @@ func (h *TOTPHandler) Disable2FA(c echo.Context) error {
 
 // Verify2FARequest represents the request to verify 2FA during login
 type Verify2FARequest struct {
-	UserID     string `json:"userId"`
-	Code       string `json:"code"`
-	RememberMe bool   `json:"rememberMe"`
+	PendingToken string `json:"pendingToken"` // From the login response that asked for the code
+	Code         string `json:"code"`
+	RememberMe   bool   `json:"rememberMe"`
 }
 
 // Verify2FA verifies the 2FA code during login and returns JWT token
@@ -289,12 +302,24 @@ func (h *TOTPHandler) Verify2FA(c echo.Context) error {
 		})
 	}
 
-	if req.UserID == "" || req.Code == "" {
+	if req.PendingToken == "" || req.Code == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "User ID and code are required",
+			"error": "Pending token and code are required",
 		})
 	}
 
+	// Only an account that just passed its password check can be verified
+	pending, err := parsePending2FAToken(req.PendingToken)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": "Login expired, please sign in again",
+		})
+	}
+
+	if wait := twoFactorGuard.retryAfter(pending.UserID, time.Now()); wait > 0 {
+		return respondLoginBlocked(c, h.auditHandler, pending.Username, "Too many invalid codes", wait)
+	}
+
 	// Get user info
 	var user User
 	var encryptedSecret sql.NullString
@@ -303,10 +328,10 @@ func (h *TOTPHandler) Verify2FA(c echo.Context) error {
 	var smbHash sql.NullString
 	var provider sql.NullString
 
-	err := h.db.QueryRow(`
+	err = h.db.QueryRow(`
 		SELECT id, username, email, smb_hash, provider, is_admin, is_active, totp_secret, totp_backup_codes, created_at, updated_at
 		FROM users WHERE id = $1 AND COALESCE(totp_enabled, false) = true
-	`, req.UserID).Scan(&user.ID, &user.Username, &email, &smbHash, &provider, &user.IsAdmin, &user.IsActive, &encryptedSecret, &backupCodesJSON, &user.CreatedAt, &user.UpdatedAt)
+	`, pending.UserID).Scan(&user.ID, &user.Username, &email, &smbHash, &provider, &user.IsAdmin, &user.IsActive, &encryptedSecret, &backupCodesJSON, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return c.JSON(http.StatusUnauthorized, map[string]string{
@@ -325,6 +350,15 @@ func (h *TOTPHandler) Verify2FA(c echo.Context) error {
 		})
 	}
 
+	// Codes count toward the same lockout as passwords
+	ctx := c.Request().Context()
+	guard := GetBruteForceGuard()
+	if guard != nil {
+		if allowed, reason, _, retryAfter := guard.CheckAndRecordAttempt(ctx, c.RealIP(), user.Username); !allowed {
+			return respondLoginBlocked(c, h.auditHandler, user.Username, reason, retryAfter)
+		}
+	}
+
 	// Check if it's a backup code (8 characters)
 	isBackupCode := len(req.Code) == 8
 
@@ -347,7 +381,7 @@ func (h *TOTPHandler) Verify2FA(c echo.Context) error {
 		codeFound := false
 		var remainingCodes []string
 		for _, hashedCode := range hashedCodes {
-			if bcrypt.CompareHashAndPassword([]byte(hashedCode), []byte(req.Code)) == nil {
+			if !codeFound && bcrypt.CompareHashAndPassword([]byte(hashedCode), []byte(req.Code)) == nil {
 				codeFound = true
 				// Don't add this code to remaining (it's been used)
 			} else {
@@ -356,14 +390,30 @@ func (h *TOTPHandler) Verify2FA(c echo.Context) error {
 		}
 
 		if !codeFound {
+			h.record2FAFailure(c, &user, "invalid_backup_code")
 			return c.JSON(http.StatusUnauthorized, map[string]string{
 				"error": "Invalid backup code",
 			})
 		}
 
-		// Update remaining backup codes
+		// Burn the code: the update only applies to the codes as read, so of
+		// two logins racing with the same code only one gets through
 		remainingJSON, _ := json.Marshal(remainingCodes)
-		_, _ = h.db.Exec(`UPDATE users SET totp_backup_codes = $1, updated_at = NOW() WHERE id = $2`, string(remainingJSON), user.ID)
+		result, err := h.db.Exec(`
+			UPDATE users SET totp_backup_codes = $1, updated_at = NOW()
+			WHERE id = $2 AND totp_backup_codes = $3
+		`, string(remainingJSON), user.ID, backupCodesJSON.String)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to update backup codes",
+			})
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			h.record2FAFailure(c, &user, "backup_code_reused")
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "Invalid backup code",
+			})
+		}
 
 		// Audit log
 		_ = h.auditHandler.LogEvent(&user.ID, c.RealIP(), "user.2fa.backup_used", user.Username, map[string]interface{}{
@@ -384,13 +434,16 @@ func (h *TOTPHandler) Verify2FA(c echo.Context) error {
 			})
 		}
 
-		valid := totp.Validate(req.Code, string(secretBytes))
-		if !valid {
+		// A code is good once: replaying it, or an older one after it, fails
+		step, valid := matchTOTP(string(secretBytes), req.Code, time.Now())
+		if !valid || !twoFactorGuard.useStep(user.ID, step) {
+			h.record2FAFailure(c, &user, "invalid_2fa_code")
 			return c.JSON(http.StatusUnauthorized, map[string]string{
 				"error": "Invalid verification code",
 			})
 		}
 	}
+	twoFactorGuard.succeed(user.ID)
 
 	// Set optional fields
 	if email.Valid {
@@ -403,21 +456,19 @@ func (h *TOTPHandler) Verify2FA(c echo.Context) error {
 	}
 	user.HasSMB = smbHash.Valid && smbHash.String != ""
 
-	// Determine token expiration based on rememberMe
-	// Default: 1 day, RememberMe: 30 days
-	expiration := 24 * time.Hour
-	if req.RememberMe {
-		expiration = 30 * 24 * time.Hour
-	}
-
-	// Generate JWT token with appropriate expiration
-	token, err := GenerateJWTWithExpiration(user.ID, user.Username, user.IsAdmin, req.RememberMe, expiration)
+	// Start a session: short-lived and sliding without rememberMe, long-lived with it
+	token, err := issueSessionToken(c, user.ID, user.Username, user.IsAdmin, req.RememberMe)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to generate token",
 		})
 	}
 
+	// Reset brute force counters on successful login
+	if guard != nil {
+		guard.RecordSuccessfulLogin(ctx, c.RealIP(), user.Username)
+	}
+
 	// Audit log
 	_ = h.auditHandler.LogEvent(&user.ID, c.RealIP(), EventUserLogin, user.Username, map[string]interface{}{
 		"username":   user.Username,
@@ -432,6 +483,18 @@ func (h *TOTPHandler) Verify2FA(c echo.Context) error {
 	})
 }
 
+// record2FAFailure counts a wrong code as a failed login of the user
+func (h *TOTPHandler) record2FAFailure(c echo.Context, user *User, reason string) {
+	twoFactorGuard.fail(user.ID, time.Now())
+	if guard := GetBruteForceGuard(); guard != nil {
+		guard.RecordFailedAttempt(c.Request().Context(), c.RealIP(), user.Username)
+	}
+	_ = h.auditHandler.LogEvent(&user.ID, c.RealIP(), EventLoginFailed, user.Username, map[string]interface{}{
+		"username": user.Username,
+		"reason":   reason,
+	})
+}
+
 // Get2FAStatus returns the 2FA status for the current user
 func (h *TOTPHandler) Get2FAStatus(c echo.Context) error {
 	claims := c.Get("user").(*JWTClaims)
@@ -514,6 +577,9 @@ func (h *TOTPHandler) RegenerateBackupCodes(c echo.Context) error {
 func (h *TOTPHandler) AdminReset2FA(c echo.Context) error {
 	adminClaims := c.Get("user").(*JWTClaims)
 	userID := c.Param("id")
+	if apiErr := guardSuperadminAccount(c, h.db, adminClaims, userID); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
 
 	// Get username for audit log
 	var username string
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -94,29 +95,72 @@ func (h *Handler) MoveToTrash(c echo.Context) error {
 		return RespondError(c, ErrUnauthorized(""))
 	}
 
-	// Resolve path
-	realPath, storageType, displayPath, err := h.resolvePath("/"+requestPath, claims)
+	result, apiErr := h.trashOne(c, claims, "/"+requestPath)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	// Log audit event
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventFileDelete, result.OldPath, map[string]interface{}{
+		"isDir":   result.IsDir,
+		"size":    result.Size,
+		"trashId": result.TrashID,
+	})
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"path":    result.OldPath,
+		"trashId": result.TrashID,
+	})
+}
+
+// trashOne moves one item to the caller's trash with the checks of the
+// trash API
+func (h *Handler) trashOne(c echo.Context, claims *JWTClaims, path string) (*itemOpResult, *APIError) {
+	realPath, storageType, displayPath, err := h.resolvePath(path, claims)
 	if err != nil {
-		return RespondError(c, ErrInvalidPath(err.Error()))
+		return nil, ErrInvalidPath(err.Error())
 	}
 
 	if storageType == "root" || displayPath == "/home" || displayPath == "/shared" {
-		return RespondError(c, ErrForbidden("Cannot delete root folders"))
+		return nil, ErrForbidden("Cannot delete root folders")
+	}
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return nil, apiErr
 	}
 
 	// Check if source exists
 	info, err := os.Stat(realPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return RespondError(c, ErrNotFound("Item"))
+			return nil, ErrNotFound("Item")
 		}
-		return RespondError(c, ErrOperationFailed("access item", err))
+		return nil, ErrOperationFailed("access item", err)
+	}
+
+	if apiErr := h.retentionError(c, claims, realPath, EventFileDelete, true); apiErr != nil {
+		return nil, apiErr
 	}
 
+	item, apiErr := h.trashItem(claims, realPath, displayPath, info)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return &itemOpResult{
+		OldPath: displayPath,
+		IsDir:   item.IsDir,
+		Size:    item.Size,
+		TrashID: item.ID,
+	}, nil
+}
+
+// trashItem moves an item into the user's trash, records it in the trash
+// metadata and moves its size from home usage to trash usage
+func (h *Handler) trashItem(claims *JWTClaims, realPath, displayPath string, info os.FileInfo) (TrashItem, *APIError) {
 	// Create trash directory
 	trashPath := h.getTrashPath(claims.Username)
 	if err := os.MkdirAll(trashPath, 0755); err != nil {
-		return RespondError(c, ErrOperationFailed("create trash directory", err))
+		return TrashItem{}, ErrOperationFailed("create trash directory", err)
 	}
 
 	// Generate unique ID for trash item
@@ -125,8 +169,18 @@ func (h *Handler) MoveToTrash(c echo.Context) error {
 
 	// Move to trash
 	if err := os.Rename(realPath, trashItemPath); err != nil {
-		return RespondError(c, ErrOperationFailed("move to trash", err))
-	}
+		return TrashItem{}, ErrOperationFailed("move to trash", err)
+	}
+	GetDownloadStats().MarkDeleted(realPath)
+	GetFileEncryption().ForgetTree(realPath)
+	GetFileLinks().ForgetTree(realPath)
+	GetFolderDisplay().ForgetTree(realPath)
+	GetContentInspection().ForgetTree(realPath)
+	GetContentIndex().ForgetTree(realPath)
+	GetFileChecksums().ForgetTree(realPath)
+	GetDirSizes().MovePath(realPath, trashItemPath)
+	GetChangeJournal().Record(ChangeDelete, realPath, "", claims.UserID)
+	GetListingVersions().Invalidate(realPath)
 
 	// Calculate size
 	var size int64
@@ -137,8 +191,7 @@ func (h *Handler) MoveToTrash(c echo.Context) error {
 	}
 
 	// Update trash metadata
-	meta, _ := h.loadTrashMeta(claims.Username)
-	meta[trashID] = TrashItem{
+	item := TrashItem{
 		ID:           trashID,
 		Name:         info.Name(),
 		OriginalPath: displayPath,
@@ -146,25 +199,16 @@ func (h *Handler) MoveToTrash(c echo.Context) error {
 		IsDir:        info.IsDir(),
 		DeletedAt:    time.Now(),
 	}
+	meta, _ := h.loadTrashMeta(claims.Username)
+	meta[trashID] = item
 	_ = h.saveTrashMeta(claims.Username, meta)
 
-	// Log audit event
-	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventFileDelete, displayPath, map[string]interface{}{
-		"isDir":   info.IsDir(),
-		"size":    size,
-		"trashId": trashID,
-	})
-
 	// Update storage tracking: move from home to trash
 	if err := h.UpdateStorageForMove(claims.UserID, size, true); err != nil {
 		fmt.Printf("[Storage] Failed to update storage for %s: %v\n", claims.Username, err)
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"success": true,
-		"path":    displayPath,
-		"trashId": trashID,
-	})
+	return item, nil
 }
 
 // ListTrash lists items in the user's trash
@@ -208,16 +252,52 @@ func (h *Handler) ListTrash(c echo.Context) error {
 	})
 }
 
+// Restore conflict strategies
+const (
+	// RestoreKeepBoth restores next to a live item of the same name with a " (restored)" suffix
+	RestoreKeepBoth = "keepBoth"
+	// RestoreOverwrite moves the live item into trash first so the restore stays reversible
+	RestoreOverwrite = "overwrite"
+)
+
+// RestoreRequest is the optional request body for restoring from trash
+type RestoreRequest struct {
+	Overwrite bool `json:"overwrite"`
+}
+
+// RestoreRename records an item restored under a different name
+type RestoreRename struct {
+	OriginalPath string `json:"originalPath"`
+	RestoredPath string `json:"restoredPath"`
+}
+
+// RestoreDisplaced records a live item moved into trash by an overwrite restore
+type RestoreDisplaced struct {
+	Path    string `json:"path"`
+	TrashID string `json:"trashId"`
+}
+
+// restoreOutcome collects what a restore did, for the response and audit log
+type restoreOutcome struct {
+	Strategy     string             `json:"strategy"`
+	RestoredPath string             `json:"restoredPath"`
+	Merged       bool               `json:"merged"`
+	Renamed      []RestoreRename    `json:"renamed"`
+	Displaced    []RestoreDisplaced `json:"displaced"`
+}
+
 // RestoreFromTrash restores an item from trash
 // @Summary		Restore from trash
-// @Description	Restore an item from trash to its original location
+// @Description	Restore an item from trash to its original location. If a live item with the same name exists, the restored item is kept alongside it with a " (restored)" suffix, or with overwrite=true the live item is moved to trash first. Folders restored into a recreated folder are merged child by child.
 // @Tags		Trash
 // @Accept		json
 // @Produce		json
-// @Param		id		path		string	true	"Trash item ID"
+// @Param		id			path		string	true	"Trash item ID"
+// @Param		overwrite	query		bool	false	"Move conflicting live items to trash instead of keeping both"
 // @Success		200		{object}	docs.SuccessResponse	"Item restored successfully"
 // @Failure		400		{object}	docs.ErrorResponse	"Bad request"
 // @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
 // @Failure		404		{object}	docs.ErrorResponse	"Trash item not found"
 // @Failure		500		{object}	docs.ErrorResponse	"Internal server error"
 // @Security	BearerAuth
@@ -233,6 +313,13 @@ func (h *Handler) RestoreFromTrash(c echo.Context) error {
 		return RespondError(c, ErrUnauthorized(""))
 	}
 
+	var req RestoreRequest
+	_ = c.Bind(&req)
+	strategy := RestoreKeepBoth
+	if req.Overwrite || c.QueryParam("overwrite") == "true" {
+		strategy = RestoreOverwrite
+	}
+
 	meta, err := h.loadTrashMeta(claims.Username)
 	if err != nil {
 		return RespondError(c, ErrOperationFailed("load trash", err))
@@ -244,29 +331,51 @@ func (h *Handler) RestoreFromTrash(c echo.Context) error {
 	}
 
 	// Resolve original path
-	realPath, _, _, err := h.resolvePath(item.OriginalPath, claims)
+	realPath, storageType, _, err := h.resolvePath(item.OriginalPath, claims)
 	if err != nil {
 		return RespondError(c, ErrInvalidPath("Cannot restore to original location"))
 	}
-
-	// Check if destination already exists
-	if _, err := os.Stat(realPath); err == nil {
-		// Add suffix to avoid conflict
-		ext := filepath.Ext(realPath)
-		base := strings.TrimSuffix(realPath, ext)
-		realPath = fmt.Sprintf("%s_restored_%d%s", base, time.Now().Unix(), ext)
+	if apiErr := mountInWriteError(item.OriginalPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if strategy == RestoreOverwrite && storageType == StorageShared && !h.CanWriteSharedDrive(claims.UserID, item.OriginalPath) {
+		return RespondError(c, ErrForbidden("No permission to overwrite items in this shared drive"))
+	}
+	// Overwriting trashes what is in the way, which a retention hold forbids
+	if strategy == RestoreOverwrite {
+		if apiErr := h.retentionError(c, claims, realPath, "trash.restore", true); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
 	}
 
-	// Ensure parent directory exists
-	parentDir := filepath.Dir(realPath)
-	if err := os.MkdirAll(parentDir, 0755); err != nil {
-		return RespondError(c, ErrOperationFailed("create parent directory", err))
+	outcome := &restoreOutcome{
+		Strategy:     strategy,
+		RestoredPath: item.OriginalPath,
+		Renamed:      []RestoreRename{},
+		Displaced:    []RestoreDisplaced{},
 	}
 
-	// Move back from trash
+	// Move back from trash, resolving collisions with live items
 	trashItemPath := filepath.Join(h.getTrashPath(claims.Username), trashID)
-	if err := os.Rename(trashItemPath, realPath); err != nil {
-		return RespondError(c, ErrOperationFailed("restore item", err))
+	restoreErr := h.restoreInto(claims, trashItemPath, realPath, item.OriginalPath, strategy, outcome)
+
+	// Displaced items were added to the trash metadata, so reload it
+	meta, _ = h.loadTrashMeta(claims.Username)
+	if restoreErr != nil {
+		// Part of a merged folder may already be restored; keep what's left in trash
+		if _, err := os.Stat(trashItemPath); err == nil {
+			remaining, _ := h.calculateDirSize(trashItemPath)
+			if restored := item.Size - remaining; restored > 0 {
+				_ = h.UpdateStorageForMove(claims.UserID, restored, false)
+			}
+			item.Size = remaining
+			meta[trashID] = item
+			_ = h.saveTrashMeta(claims.Username, meta)
+		}
+		return RespondError(c, ErrOperationFailed("restore item", restoreErr))
 	}
 
 	// Update metadata
@@ -275,8 +384,19 @@ func (h *Handler) RestoreFromTrash(c echo.Context) error {
 
 	// Log restore event for recent files tracking
 	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), "trash.restore", item.OriginalPath, map[string]interface{}{
-		"trashId": trashID,
+		"trashId":      trashID,
+		"strategy":     outcome.Strategy,
+		"restoredPath": outcome.RestoredPath,
+		"merged":       outcome.Merged,
+		"renamed":      outcome.Renamed,
+		"displaced":    outcome.Displaced,
 	})
+	for _, displaced := range outcome.Displaced {
+		_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventFileDelete, displaced.Path, map[string]interface{}{
+			"trashId":     displaced.TrashID,
+			"displacedBy": trashID,
+		})
+	}
 
 	// Update storage tracking: move from trash back to home
 	if err := h.UpdateStorageForMove(claims.UserID, item.Size, false); err != nil {
@@ -285,10 +405,111 @@ func (h *Handler) RestoreFromTrash(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"success":      true,
-		"restoredPath": item.OriginalPath,
+		"restoredPath": outcome.RestoredPath,
+		"strategy":     outcome.Strategy,
+		"merged":       outcome.Merged,
+		"renamed":      outcome.Renamed,
+		"displaced":    outcome.Displaced,
 	})
 }
 
+// restoreInto moves src (inside the trash) to dest. When dest exists and both
+// are folders their children are merged one by one; other collisions are
+// resolved by the strategy (keep both or move the live item to trash).
+func (h *Handler) restoreInto(claims *JWTClaims, src, dest, displayDest, strategy string, outcome *restoreOutcome) error {
+	destInfo, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("create parent directory: %w", err)
+		}
+		if err := os.Rename(src, dest); err != nil {
+			return err
+		}
+		GetDownloadStats().MarkRestored(dest)
+		GetDirSizes().MovePath(src, dest)
+		_ = SealPath(dest)
+		GetChangeJournal().Record(ChangeCreate, dest, "", claims.UserID)
+		GetListingVersions().Invalidate(dest)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if srcInfo.IsDir() && destInfo.IsDir() {
+		outcome.Merged = true
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := h.restoreInto(claims, filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name()),
+				path.Join(displayDest, entry.Name()), strategy, outcome); err != nil {
+				return err
+			}
+		}
+		return os.Remove(src)
+	}
+
+	if strategy == RestoreOverwrite {
+		displaced, apiErr := h.trashItem(claims, dest, displayDest, destInfo)
+		if apiErr != nil {
+			return apiErr
+		}
+		outcome.Displaced = append(outcome.Displaced, RestoreDisplaced{Path: displayDest, TrashID: displaced.ID})
+		if err := os.Rename(src, dest); err != nil {
+			return err
+		}
+		GetDownloadStats().MarkRestored(dest)
+		GetDirSizes().MovePath(src, dest)
+		_ = SealPath(dest)
+		GetChangeJournal().Record(ChangeCreate, dest, "", claims.UserID)
+		GetListingVersions().Invalidate(dest)
+		return nil
+	}
+
+	restoredPath := restoredCopyPath(dest)
+	if err := os.Rename(src, restoredPath); err != nil {
+		return err
+	}
+	GetDirSizes().MovePath(src, restoredPath)
+	_ = SealPath(restoredPath)
+	renamed := RestoreRename{
+		OriginalPath: displayDest,
+		RestoredPath: path.Join(path.Dir(displayDest), filepath.Base(restoredPath)),
+	}
+	outcome.Renamed = append(outcome.Renamed, renamed)
+	if displayDest == outcome.RestoredPath {
+		outcome.RestoredPath = renamed.RestoredPath
+	}
+	return nil
+}
+
+// restoredCopyPath returns a free path next to dest: "name (restored).ext",
+// then "name (restored 2).ext" and so on
+func restoredCopyPath(dest string) string {
+	dir := filepath.Dir(dest)
+	name := filepath.Base(dest)
+	ext := filepath.Ext(name)
+	if ext == name {
+		ext = "" // dotfile without extension
+	}
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := filepath.Join(dir, fmt.Sprintf("%s (restored)%s", base, ext))
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = filepath.Join(dir, fmt.Sprintf("%s (restored %d)%s", base, i, ext))
+	}
+}
+
 // DeleteFromTrash permanently deletes an item from trash
 // @Summary		Delete from trash
 // @Description	Permanently delete an item from trash (irreversible)
@@ -329,6 +550,7 @@ func (h *Handler) DeleteFromTrash(c echo.Context) error {
 	if err := os.RemoveAll(trashItemPath); err != nil {
 		return RespondError(c, ErrOperationFailed("delete item", err))
 	}
+	GetDirSizes().ForgetTree(trashItemPath)
 
 	// Update metadata
 	delete(meta, trashID)
@@ -339,6 +561,12 @@ func (h *Handler) DeleteFromTrash(c echo.Context) error {
 		fmt.Printf("[Storage] Failed to update storage for %s: %v\n", claims.Username, err)
 	}
 
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventTrashPurge, item.OriginalPath, map[string]interface{}{
+		"items":   1,
+		"size":    item.Size,
+		"trashId": trashID,
+	})
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"success": true,
 	})
@@ -346,11 +574,12 @@ func (h *Handler) DeleteFromTrash(c echo.Context) error {
 
 // EmptyTrash permanently deletes all items from trash
 // @Summary		Empty trash
-// @Description	Permanently delete all items from trash (irreversible)
+// @Description	Permanently delete all items from trash (irreversible). With dryRun=true nothing is deleted and the response lists the items, file count and bytes that would be.
 // @Tags		Trash
 // @Accept		json
 // @Produce		json
-// @Success		200		{object}	docs.SuccessResponse	"Trash emptied"
+// @Param		dryRun	query		bool	false	"Only report what would be deleted"
+// @Success		200		{object}	DestructiveResult	"Trash emptied"
 // @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
 // @Failure		500		{object}	docs.ErrorResponse	"Internal server error"
 // @Security	BearerAuth
@@ -362,86 +591,156 @@ func (h *Handler) EmptyTrash(c echo.Context) error {
 	}
 
 	trashPath := h.getTrashPath(claims.Username)
-
-	// Remove all contents
-	if err := os.RemoveAll(trashPath); err != nil {
-		return RespondError(c, ErrOperationFailed("empty trash", err))
+	meta, err := h.loadTrashMeta(claims.Username)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("read trash", err))
 	}
 
-	// Recreate empty trash directory
-	_ = os.MkdirAll(trashPath, 0755)
+	run, err := beginDestructiveRun(h.db, dryRunParam(c))
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	defer run.Abort()
 
 	// Update storage tracking: set trash to 0
-	if _, err := h.db.Exec(`UPDATE users SET trash_used = 0, updated_at = NOW() WHERE id = $1`, claims.UserID); err != nil {
-		fmt.Printf("[Storage] Failed to reset trash for %s: %v\n", claims.Username, err)
+	if _, err := run.Exec("users", `UPDATE users SET trash_used = 0, updated_at = NOW() WHERE id = $1`, claims.UserID); err != nil {
+		return RespondError(c, ErrOperationFailed("empty trash", err))
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"success": true,
-	})
+	// Remove each item, newest first, then anything left over (the metadata
+	// and untracked payloads) and recreate the empty trash directory
+	ids := make([]string, 0, len(meta))
+	for id := range meta {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return meta[ids[i]].DeletedAt.After(meta[ids[j]].DeletedAt) })
+	for _, id := range ids {
+		run.Remove(filepath.Join(trashPath, id), meta[id].OriginalPath)
+	}
+	run.After(func() error { return os.RemoveAll(trashPath) })
+	run.After(func() error { return os.MkdirAll(trashPath, 0755) })
+
+	result, err := run.Finish()
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("empty trash", err))
+	}
+	if result.Applied {
+		GetDirSizes().ForgetTree(trashPath)
+	}
+	if result.Applied && len(ids) > 0 && h.auditHandler != nil {
+		_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventTrashPurge, "/trash", map[string]interface{}{
+			"items": len(ids),
+			"bytes": result.Bytes,
+		})
+	}
+	return RespondSuccess(c, result)
 }
 
 // TrashAutoCleanupConfig holds configuration for automatic trash cleanup
 type TrashAutoCleanupConfig struct {
 	RetentionDays int           // Number of days to keep items in trash (default: 30)
+	MaxSizeBytes  int64         // Largest trash per user, oldest items go first (default: 0 = unlimited)
 	CleanupPeriod time.Duration // How often to run cleanup (default: 24 hours)
 }
 
-// DefaultTrashCleanupConfig returns the default cleanup configuration
-func DefaultTrashCleanupConfig() TrashAutoCleanupConfig {
-	retentionDays := 30
+// TrashPolicy is what the cleanup job enforces on every user's trash
+type TrashPolicy struct {
+	RetentionDays int   `json:"retentionDays"`
+	MaxSizeBytes  int64 `json:"maxSizeBytes"` // 0 = unlimited
+}
+
+// currentTrashPolicy reads the policy from the system settings, or returns
+// fallback when they are not loaded
+func currentTrashPolicy(fallback TrashPolicy) TrashPolicy {
 	if sh := GetGlobalSettingsHandler(); sh != nil {
-		retentionDays = sh.GetTrashRetentionDays()
+		return TrashPolicy{
+			RetentionDays: sh.GetTrashRetentionDays(),
+			MaxSizeBytes:  sh.GetTrashMaxSizeBytes(),
+		}
 	}
+	return fallback
+}
+
+// DefaultTrashCleanupConfig returns the default cleanup configuration
+func DefaultTrashCleanupConfig() TrashAutoCleanupConfig {
+	policy := currentTrashPolicy(TrashPolicy{RetentionDays: 30})
 	return TrashAutoCleanupConfig{
-		RetentionDays: retentionDays,
+		RetentionDays: policy.RetentionDays,
+		MaxSizeBytes:  policy.MaxSizeBytes,
 		CleanupPeriod: 24 * time.Hour,
 	}
 }
 
-// StartTrashAutoCleanup starts the automatic trash cleanup background task
-func (h *Handler) StartTrashAutoCleanup(config TrashAutoCleanupConfig) {
-	go func() {
-		// Get retention days from settings (may have been updated)
-		retentionDays := config.RetentionDays
-		if sh := GetGlobalSettingsHandler(); sh != nil {
-			retentionDays = sh.GetTrashRetentionDays()
-		}
-
-		// Run immediately on startup
-		h.runTrashCleanup(retentionDays)
-
-		// Then run periodically
-		ticker := time.NewTicker(config.CleanupPeriod)
-		defer ticker.Stop()
+// JobTrashCleanup is the job type deleting expired trash items
+const JobTrashCleanup = "trash.cleanup"
 
-		for range ticker.C {
-			// Reload retention days from settings on each run
-			currentRetention := config.RetentionDays
-			if sh := GetGlobalSettingsHandler(); sh != nil {
-				currentRetention = sh.GetTrashRetentionDays()
-			}
-			h.runTrashCleanup(currentRetention)
-		}
-	}()
+// StartTrashAutoCleanup registers the trash cleanup job and schedules it
+// every CleanupPeriod, starting now
+func (h *Handler) StartTrashAutoCleanup(config TrashAutoCleanupConfig) {
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:        JobTrashCleanup,
+		Idempotent:  true,
+		MaxAttempts: 3,
+		Priority:    PacePriorityMaintenance,
+		Run: func(run *JobRun) error {
+			// Reload the policy from settings on each run
+			return h.runTrashCleanup(run, currentTrashPolicy(TrashPolicy{
+				RetentionDays: config.RetentionDays,
+				MaxSizeBytes:  config.MaxSizeBytes,
+			}))
+		},
+	})
+	jobs.Schedule(JobTrashCleanup, config.CleanupPeriod, true)
 
 	fmt.Printf("[Trash] Auto-cleanup started: items older than %d days will be deleted every %v\n",
 		config.RetentionDays, config.CleanupPeriod)
 }
 
-// runTrashCleanup performs the actual cleanup of old trash items
-func (h *Handler) runTrashCleanup(retentionDays int) {
-	cutoffTime := time.Now().AddDate(0, 0, -retentionDays)
+// trashEvictions returns the IDs of the items policy removes from one trash:
+// the expired ones, then the oldest of the rest until the trash fits
+// MaxSizeBytes
+func trashEvictions(meta map[string]TrashItem, policy TrashPolicy, now time.Time) (expired, overLimit []string) {
+	cutoffTime := now.AddDate(0, 0, -policy.RetentionDays)
+
+	var kept []string
+	var keptSize int64
+	for trashID, item := range meta {
+		if item.DeletedAt.Before(cutoffTime) {
+			expired = append(expired, trashID)
+			continue
+		}
+		kept = append(kept, trashID)
+		keptSize += item.Size
+	}
+	if policy.MaxSizeBytes <= 0 || keptSize <= policy.MaxSizeBytes {
+		return expired, nil
+	}
 
+	sort.Slice(kept, func(i, j int) bool { return meta[kept[i]].DeletedAt.Before(meta[kept[j]].DeletedAt) })
+	for _, trashID := range kept {
+		if keptSize <= policy.MaxSizeBytes {
+			break
+		}
+		overLimit = append(overLimit, trashID)
+		keptSize -= meta[trashID].Size
+	}
+	return expired, overLimit
+}
+
+// runTrashCleanup performs the actual cleanup of old trash items and of
+// trash over the size limit
+func (h *Handler) runTrashCleanup(run *JobRun, policy TrashPolicy) error {
 	// Get all users with trash folders
 	trashRoot := filepath.Join(h.dataRoot, "trash")
 	userDirs, err := os.ReadDir(trashRoot)
 	if err != nil {
 		// Trash directory might not exist yet, that's fine
-		return
+		return nil
 	}
 
-	var totalCleaned int
+	now := time.Now()
+	var totalExpired, totalEvicted int
 	var totalSize int64
 
 	for _, userDir := range userDirs {
@@ -455,41 +754,64 @@ func (h *Handler) runTrashCleanup(retentionDays int) {
 			continue
 		}
 
-		var toDelete []string
-		for trashID, item := range meta {
-			if item.DeletedAt.Before(cutoffTime) {
-				toDelete = append(toDelete, trashID)
-				totalSize += item.Size
+		expired, overLimit := trashEvictions(meta, policy, now)
+		toDelete := append(expired, overLimit...)
+
+		// Save metadata and usage for what was deleted so far
+		var freed int64
+		deleted := 0
+		save := func() {
+			if deleted == 0 {
+				return
+			}
+			_ = h.saveTrashMeta(username, meta)
+			if h.db != nil {
+				_, _ = h.db.Exec(`
+					UPDATE users
+					SET trash_used = GREATEST(0, COALESCE(trash_used, 0) - $1),
+					    updated_at = NOW()
+					WHERE username = $2
+				`, freed, username)
 			}
 		}
 
-		// Delete expired items
-		for _, trashID := range toDelete {
+		for i, trashID := range toDelete {
+			if err := run.Pace(); err != nil {
+				save()
+				return err
+			}
 			trashItemPath := filepath.Join(h.getTrashPath(username), trashID)
 			if err := os.RemoveAll(trashItemPath); err != nil {
-				fmt.Printf("[Trash] Failed to delete expired item %s for user %s: %v\n",
+				fmt.Printf("[Trash] Failed to delete item %s for user %s: %v\n",
 					trashID, username, err)
 				continue
 			}
+			GetDirSizes().ForgetTree(trashItemPath)
+			freed += meta[trashID].Size
 			delete(meta, trashID)
-			totalCleaned++
+			deleted++
+			if i < len(expired) {
+				totalExpired++
+			} else {
+				totalEvicted++
+			}
 		}
+		save()
+		totalSize += freed
 
-		// Save updated metadata
-		if len(toDelete) > 0 {
-			_ = h.saveTrashMeta(username, meta)
-		}
+		run.SetProgress(map[string]interface{}{"deleted": totalExpired + totalEvicted, "overLimit": totalEvicted})
 	}
 
-	if totalCleaned > 0 {
-		fmt.Printf("[Trash] Auto-cleanup completed: deleted %d items (%.2f MB) older than %d days\n",
-			totalCleaned, float64(totalSize)/(1024*1024), retentionDays)
+	if totalExpired+totalEvicted > 0 {
+		fmt.Printf("[Trash] Auto-cleanup completed: deleted %d items (%.2f MB), %d older than %d days and %d over the %d byte limit\n",
+			totalExpired+totalEvicted, float64(totalSize)/(1024*1024), totalExpired, policy.RetentionDays, totalEvicted, policy.MaxSizeBytes)
 	}
+	return nil
 }
 
 // GetTrashStats returns statistics about trash usage
 // @Summary		Get trash statistics
-// @Description	Get statistics about the user's trash including item count, total size, and retention info
+// @Description	Get statistics about the user's trash including item count, total size, and the cleanup policy: items older than retentionDays are removed, and when maxSizeBytes is set (0 = unlimited) the oldest items are removed until the trash fits
 // @Tags		Trash
 // @Accept		json
 // @Produce		json
@@ -523,16 +845,15 @@ func (h *Handler) GetTrashStats(c echo.Context) error {
 		}
 	}
 
-	// Get retention days from settings
-	retentionDays := 30
-	if sh := GetGlobalSettingsHandler(); sh != nil {
-		retentionDays = sh.GetTrashRetentionDays()
-	}
+	// The policy the cleanup job enforces
+	policy := currentTrashPolicy(TrashPolicy{RetentionDays: 30})
+	retentionDays := policy.RetentionDays
 
 	stats := map[string]interface{}{
 		"itemCount":     len(meta),
 		"totalSize":     totalSize,
 		"retentionDays": retentionDays,
+		"policy":        policy,
 	}
 
 	if oldestItem != nil {
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTrashEvictions(t *testing.T) {
+	now := time.Now()
+	meta := map[string]TrashItem{
+		"expired": {Size: 100, DeletedAt: now.AddDate(0, 0, -10)},
+		"old":     {Size: 40, DeletedAt: now.AddDate(0, 0, -3)},
+		"mid":     {Size: 40, DeletedAt: now.AddDate(0, 0, -2)},
+		"new":     {Size: 40, DeletedAt: now.AddDate(0, 0, -1)},
+	}
+
+	expired, overLimit := trashEvictions(meta, TrashPolicy{RetentionDays: 7}, now)
+	if len(expired) != 1 || expired[0] != "expired" || len(overLimit) != 0 {
+		t.Errorf("no size limit: %v, %v", expired, overLimit)
+	}
+
+	// Expired items do not count toward the limit; the oldest of the rest go first
+	expired, overLimit = trashEvictions(meta, TrashPolicy{RetentionDays: 7, MaxSizeBytes: 50}, now)
+	if len(expired) != 1 || len(overLimit) != 2 || overLimit[0] != "old" || overLimit[1] != "mid" {
+		t.Errorf("limit 50: %v, %v", expired, overLimit)
+	}
+
+	if _, overLimit = trashEvictions(meta, TrashPolicy{RetentionDays: 7, MaxSizeBytes: 120}, now); len(overLimit) != 0 {
+		t.Errorf("trash that fits evicted %v", overLimit)
+	}
+}
+
+func TestRunTrashCleanup_SizeLimit(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	trashDir := h.getTrashPath("alice")
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	meta := map[string]TrashItem{}
+	for i, id := range []string{"1_a.txt", "2_b.txt", "3_c.txt"} {
+		_ = os.WriteFile(filepath.Join(trashDir, id), make([]byte, 10), 0644)
+		meta[id] = TrashItem{ID: id, Size: 10, DeletedAt: now.Add(time.Duration(i-3) * time.Hour)}
+	}
+	if err := h.saveTrashMeta("alice", meta); err != nil {
+		t.Fatal(err)
+	}
+
+	tc.Mock.ExpectExec("UPDATE users").WithArgs(int64(20), "alice").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	run := &JobRun{ID: 1, Type: JobTrashCleanup, ctx: context.Background()}
+	if err := h.runTrashCleanup(run, TrashPolicy{RetentionDays: 30, MaxSizeBytes: 15}); err != nil {
+		t.Fatal(err)
+	}
+
+	left, _ := h.loadTrashMeta("alice")
+	if _, ok := left["3_c.txt"]; len(left) != 1 || !ok {
+		t.Errorf("trash left = %v", left)
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, "1_a.txt")); !os.IsNotExist(err) {
+		t.Error("oldest item not removed")
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
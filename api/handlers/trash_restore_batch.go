@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JobTrashRestore restores many trash items of one user
+const JobTrashRestore = "trash.restore"
+
+const (
+	// trashRestoreMaxIDs bounds the IDs of one bulk restore request; use all
+	// to restore more
+	trashRestoreMaxIDs = 10000
+	// trashRestoreReportMax bounds the skipped and failed items listed in
+	// the job's progress; the counts stay exact
+	trashRestoreReportMax = 1000
+	// trashRestoreSaveEvery is how many restored items are written back to
+	// the trash metadata at once
+	trashRestoreSaveEvery = 200
+)
+
+// TrashRestoreBatchRequest selects the trash items to restore
+type TrashRestoreBatchRequest struct {
+	IDs []string `json:"ids"`
+	All bool     `json:"all"` // every item in the trash; ids are ignored
+}
+
+// trashRestoreParams are the params of a JobTrashRestore job
+type trashRestoreParams struct {
+	UserID   string   `json:"userId"`
+	Username string   `json:"username"`
+	IDs      []string `json:"ids,omitempty"`
+	All      bool     `json:"all,omitempty"`
+	IP       string   `json:"ip"`
+}
+
+// TrashRestoreIssue is a trash item a bulk restore skipped or failed to restore
+type TrashRestoreIssue struct {
+	ID           string `json:"id"`
+	OriginalPath string `json:"originalPath,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+// TrashRestoreProgress is the progress and, once finished, the result of a
+// JobTrashRestore job
+type TrashRestoreProgress struct {
+	Total        int                 `json:"total"`
+	Restored     int                 `json:"restored"`
+	Skipped      int                 `json:"skipped"` // original path is taken
+	Failed       int                 `json:"failed"`
+	SkippedItems []TrashRestoreIssue `json:"skippedItems"`
+	FailedItems  []TrashRestoreIssue `json:"failedItems"`
+}
+
+func (p *TrashRestoreProgress) skip(id, originalPath, reason string) {
+	p.Skipped++
+	if len(p.SkippedItems) < trashRestoreReportMax {
+		p.SkippedItems = append(p.SkippedItems, TrashRestoreIssue{ID: id, OriginalPath: originalPath, Reason: reason})
+	}
+}
+
+func (p *TrashRestoreProgress) fail(id, originalPath string, err error) {
+	p.Failed++
+	if len(p.FailedItems) < trashRestoreReportMax {
+		p.FailedItems = append(p.FailedItems, TrashRestoreIssue{ID: id, OriginalPath: originalPath, Reason: err.Error()})
+	}
+}
+
+// StartTrashRestoreJobs registers the bulk trash restore job type
+func (h *Handler) StartTrashRestoreJobs() {
+	GetJobs().Register(JobType{
+		Name:       JobTrashRestore,
+		Idempotent: true, // restored items have left the trash, a rerun restores the rest
+		Priority:   PacePriorityUser,
+		Run:        h.runTrashRestore,
+	})
+}
+
+// RestoreTrashBatch queues the restore of many trash items
+// @Summary		Restore many items from trash
+// @Description	Restores the listed trash items, or all of them, in one background job. Items go back to their original paths, recreating missing parent folders; an item whose original path is taken is skipped and left in the trash. Folders are restored before what was deleted inside them. Poll /jobs/{id} for progress: its progress holds the total, restored, skipped and failed counts and the skipped and failed items (up to 1000 each). One audit entry (trash.restore_batch) with the counts is written when the job ends.
+// @Tags		Trash
+// @Accept		json
+// @Produce		json
+// @Param		request	body		TrashRestoreBatchRequest	true	"Trash IDs, or all"
+// @Success		202		{object}	docs.SuccessResponse	"Restore queued: jobId and total"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		404		{object}	docs.ErrorResponse	"No such trash items"
+// @Security	BearerAuth
+// @Router		/trash/restore-batch [post]
+func (h *Handler) RestoreTrashBatch(c echo.Context) error {
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+
+	var req TrashRestoreBatchRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if !req.All && len(req.IDs) == 0 {
+		return RespondError(c, ErrMissingParameter("ids"))
+	}
+	if len(req.IDs) > trashRestoreMaxIDs {
+		return RespondError(c, ErrBadRequest("Too many items in one restore").WithDetails(map[string]int{"max": trashRestoreMaxIDs}))
+	}
+
+	meta, err := h.loadTrashMeta(claims.Username)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load trash", err))
+	}
+	params := trashRestoreParams{UserID: claims.UserID, Username: claims.Username, All: req.All, IP: c.RealIP()}
+	total := len(meta)
+	if !req.All {
+		params.IDs = req.IDs
+		total = 0
+		for _, id := range req.IDs {
+			if _, ok := meta[id]; ok {
+				total++
+			}
+		}
+	}
+	if total == 0 {
+		return RespondError(c, ErrNotFound("Trash item"))
+	}
+
+	jobID, err := GetJobs().Enqueue(JobTrashRestore, params, &claims.UserID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("queue restore", err))
+	}
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"jobId": jobID,
+			"total": total,
+		},
+	})
+}
+
+// restoreOrder sorts trash IDs so shallower original paths come first and,
+// for the same depth, newer deletions first: a folder is restored before
+// items deleted from it earlier, which then land inside it
+func restoreOrder(ids []string, meta map[string]TrashItem) {
+	depth := func(id string) int {
+		item, ok := meta[id]
+		if !ok {
+			return math.MaxInt // unknown IDs last
+		}
+		return strings.Count(strings.Trim(item.OriginalPath, "/"), "/")
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		if di, dj := depth(ids[i]), depth(ids[j]); di != dj {
+			return di < dj
+		}
+		return meta[ids[i]].DeletedAt.After(meta[ids[j]].DeletedAt)
+	})
+}
+
+// runTrashRestore runs a JobTrashRestore job
+func (h *Handler) runTrashRestore(run *JobRun) error {
+	var params trashRestoreParams
+	if err := run.Decode(&params); err != nil {
+		return JobPermanent(err)
+	}
+	claims := &JWTClaims{UserID: params.UserID, Username: params.Username}
+
+	meta, err := h.loadTrashMeta(params.Username)
+	if err != nil {
+		return err
+	}
+	ids := params.IDs
+	if params.All {
+		ids = make([]string, 0, len(meta))
+		for id := range meta {
+			ids = append(ids, id)
+		}
+	}
+	ids = append([]string(nil), ids...)
+	restoreOrder(ids, meta)
+
+	progress := &TrashRestoreProgress{Total: len(ids), SkippedItems: []TrashRestoreIssue{}, FailedItems: []TrashRestoreIssue{}}
+	var pending []string
+	var pendingBytes int64
+
+	// commit removes the restored items from the trash metadata, reloaded
+	// so items trashed meanwhile are kept, and moves their size back
+	commit := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if current, err := h.loadTrashMeta(params.Username); err == nil {
+			for _, id := range pending {
+				delete(current, id)
+			}
+			_ = h.saveTrashMeta(params.Username, current)
+		}
+		if err := h.UpdateStorageForMove(params.UserID, pendingBytes, false); err != nil {
+			fmt.Printf("[Storage] Failed to update storage for %s: %v\n", params.Username, err)
+		}
+		pending, pendingBytes = nil, 0
+	}
+	finish := func() {
+		commit()
+		run.SetProgress(progress)
+		if progress.Restored+progress.Skipped+progress.Failed > 0 && h.auditHandler != nil {
+			_ = h.auditHandler.LogEvent(&params.UserID, params.IP, EventTrashRestoreBatch, "/trash", map[string]interface{}{
+				"jobId":    run.ID,
+				"total":    progress.Total,
+				"restored": progress.Restored,
+				"skipped":  progress.Skipped,
+				"failed":   progress.Failed,
+			})
+		}
+	}
+
+	for _, id := range ids {
+		if err := run.Pace(); err != nil {
+			finish()
+			return err
+		}
+		item, ok := meta[id]
+		if !ok {
+			progress.fail(id, "", errors.New("not in the trash"))
+			continue
+		}
+		restored, err := h.restoreIfFree(claims, id, item)
+		switch {
+		case err != nil:
+			progress.fail(id, item.OriginalPath, err)
+		case !restored:
+			progress.skip(id, item.OriginalPath, "original path is taken")
+		default:
+			progress.Restored++
+			pending = append(pending, id)
+			pendingBytes += item.Size
+			if len(pending) >= trashRestoreSaveEvery {
+				commit()
+			}
+		}
+		run.SetProgress(progress)
+	}
+	finish()
+	return nil
+}
+
+// restoreIfFree moves a trash item back to its original path, creating
+// missing parent folders. It returns false and leaves the item in the trash
+// when the path is taken.
+func (h *Handler) restoreIfFree(claims *JWTClaims, trashID string, item TrashItem) (bool, error) {
+	realPath, _, _, err := h.resolvePath(item.OriginalPath, claims)
+	if err != nil {
+		return false, errors.New("cannot restore to original location")
+	}
+	if apiErr := mountInWriteError(item.OriginalPath); apiErr != nil {
+		return false, apiErr
+	}
+	if apiErr := storageWriteError(realPath); apiErr != nil {
+		return false, apiErr
+	}
+	if _, err := os.Lstat(realPath); err == nil {
+		return false, nil
+	}
+
+	trashItemPath := filepath.Join(h.getTrashPath(claims.Username), trashID)
+	if _, err := os.Lstat(trashItemPath); err != nil {
+		return false, errors.New("missing from the trash")
+	}
+	outcome := &restoreOutcome{Strategy: RestoreKeepBoth, RestoredPath: item.OriginalPath}
+	if err := h.restoreInto(claims, trashItemPath, realPath, item.OriginalPath, RestoreKeepBoth, outcome); err != nil {
+		return false, err
+	}
+	return true, nil
+}
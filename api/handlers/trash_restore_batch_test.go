@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRunTrashRestore(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+
+	// docs/a.txt was deleted before its folder; b.txt is back in use
+	trashDir := h.getTrashPath("alice")
+	writeTestFiles(t, trashDir, "1_a.txt", "2_docs/c.txt", "3_b.txt", "4_x.txt")
+	writeTestFiles(t, home, "b.txt")
+	now := time.Now()
+	_ = h.saveTrashMeta("alice", map[string]TrashItem{
+		"1_a.txt":  {ID: "1_a.txt", OriginalPath: "/home/docs/a.txt", Size: 7, DeletedAt: now.Add(-2 * time.Hour)},
+		"2_docs":   {ID: "2_docs", OriginalPath: "/home/docs", IsDir: true, Size: 12, DeletedAt: now.Add(-time.Hour)},
+		"3_b.txt":  {ID: "3_b.txt", OriginalPath: "/home/b.txt", Size: 7, DeletedAt: now},
+		"4_x.txt":  {ID: "4_x.txt", OriginalPath: "/home/new/deep/x.txt", Size: 7, DeletedAt: now},
+		"kept.txt": {ID: "kept.txt", OriginalPath: "/home/kept.txt", DeletedAt: now},
+	})
+
+	tc.Mock.ExpectExec("UPDATE users SET storage_used").WithArgs(int64(26), "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("UPDATE users SET trash_used").WithArgs(int64(-26), "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	params, _ := json.Marshal(trashRestoreParams{UserID: "u1", Username: "alice", IDs: []string{"1_a.txt", "3_b.txt", "missing", "2_docs", "4_x.txt"}})
+	run := &JobRun{ID: 1, Type: JobTrashRestore, Params: params, ctx: context.Background()}
+	if err := h.runTrashRestore(run); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress TrashRestoreProgress
+	if err := json.Unmarshal(run.lastProgress().([]byte), &progress); err != nil {
+		t.Fatal(err)
+	}
+	if progress.Total != 5 || progress.Restored != 3 || progress.Skipped != 1 || progress.Failed != 1 {
+		t.Errorf("progress = %+v", progress)
+	}
+	if len(progress.SkippedItems) != 1 || progress.SkippedItems[0].OriginalPath != "/home/b.txt" || progress.FailedItems[0].ID != "missing" {
+		t.Errorf("issues = %+v, %+v", progress.SkippedItems, progress.FailedItems)
+	}
+	for _, p := range []string{"docs/a.txt", "docs/c.txt", "new/deep/x.txt"} {
+		if _, err := os.Stat(filepath.Join(home, p)); err != nil {
+			t.Errorf("%s not restored", p)
+		}
+	}
+	left, _ := h.loadTrashMeta("alice")
+	if _, ok := left["3_b.txt"]; len(left) != 2 || !ok {
+		t.Errorf("trash left = %v", left)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRestoreTrashBatch_Queues(t *testing.T) {
+	tc, h, _ := diffTestHandler(t)
+	defer tc.Cleanup()
+	useTestJobs(t, newJobs(tc.DB))
+
+	_ = os.MkdirAll(h.getTrashPath("alice"), 0755)
+	_ = h.saveTrashMeta("alice", map[string]TrashItem{
+		"t1": {ID: "t1", OriginalPath: "/home/a.txt"},
+		"t2": {ID: "t2", OriginalPath: "/home/b.txt"},
+	})
+
+	restore := func(body TrashRestoreBatchRequest) *http.Response {
+		rec := httptest.NewRecorder()
+		req, _ := NewJSONRequest(http.MethodPost, "/api/trash/restore-batch", body)
+		c := CreateAuthenticatedContext(tc.Echo, rec, req, "u1", "alice", false)
+		if err := h.RestoreTrashBatch(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Result()
+	}
+
+	if res := restore(TrashRestoreBatchRequest{}); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty request = %d", res.StatusCode)
+	}
+	if res := restore(TrashRestoreBatchRequest{IDs: []string{"nope"}}); res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown ids = %d", res.StatusCode)
+	}
+
+	tc.Mock.ExpectQuery("INSERT INTO jobs").
+		WithArgs(JobTrashRestore, sqlmock.AnyArg(), "u1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	res := restore(TrashRestoreBatchRequest{All: true})
+	var body struct {
+		Data struct {
+			JobID int64 `json:"jobId"`
+			Total int   `json:"total"`
+		} `json:"data"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	if res.StatusCode != http.StatusAccepted || body.Data.JobID != 42 || body.Data.Total != 2 {
+		t.Errorf("all = %d %+v", res.StatusCode, body.Data)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
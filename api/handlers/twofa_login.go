@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// TokenScope2FAPending marks the token Login returns to accounts with 2FA.
+// It only identifies the account to POST /api/auth/2fa/verify; every route
+// refuses it as a bearer token.
+const TokenScope2FAPending = "2fa_pending"
+
+const (
+	// pending2FAExpiration is how long a login may take to enter its code
+	pending2FAExpiration = 5 * time.Minute
+	// max2FAAttempts is how many wrong codes an account may send within
+	// pending2FAExpiration, whatever the brute force settings are
+	max2FAAttempts = 5
+	// totpPeriod is the time step of the codes, as set up by Setup2FA
+	totpPeriod = 30
+)
+
+// errInvalid2FAToken is returned for a missing, expired or wrong-scope token
+var errInvalid2FAToken = errors.New("invalid or expired 2FA token")
+
+// generatePending2FAToken returns the token that carries a password login
+// on to the 2FA step
+func generatePending2FAToken(userID, username string, isAdmin bool) (string, error) {
+	return generateJWT(userID, username, isAdmin, false, TokenScope2FAPending, "", pending2FAExpiration)
+}
+
+// parsePending2FAToken returns the claims of a valid pending 2FA token
+func parsePending2FAToken(tokenString string) (*JWTClaims, error) {
+	if tokenString == "" {
+		return nil, errInvalid2FAToken
+	}
+	token, err := ValidateJWTToken(tokenString)
+	if err != nil || !token.Valid {
+		return nil, errInvalid2FAToken
+	}
+	claims, ok := token.Claims.(*JWTClaims)
+	if !ok || claims.Scope != TokenScope2FAPending || claims.UserID == "" {
+		return nil, errInvalid2FAToken
+	}
+	return claims, nil
+}
+
+// twoFactorAttempts throttles code guessing per account and remembers the
+// last time step each account signed in with, so a code is good only once
+type twoFactorAttempts struct {
+	mu       sync.Mutex
+	failures map[string]twoFactorFailures // user ID -> wrong codes
+	lastStep map[string]int64             // user ID -> time step of the last code used
+}
+
+type twoFactorFailures struct {
+	count int
+	since time.Time
+}
+
+var twoFactorGuard = newTwoFactorAttempts()
+
+func newTwoFactorAttempts() *twoFactorAttempts {
+	return &twoFactorAttempts{
+		failures: make(map[string]twoFactorFailures),
+		lastStep: make(map[string]int64),
+	}
+}
+
+// retryAfter returns how long the account has to wait before sending
+// another code, or zero
+func (a *twoFactorAttempts) retryAfter(userID string, now time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.failures[userID]
+	if !ok || f.count < max2FAAttempts {
+		return 0
+	}
+	if wait := f.since.Add(pending2FAExpiration).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// fail counts a wrong code of the account
+func (a *twoFactorAttempts) fail(userID string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Counts whose window has passed are dropped as new ones come in
+	for id, f := range a.failures {
+		if !now.Before(f.since.Add(pending2FAExpiration)) {
+			delete(a.failures, id)
+		}
+	}
+	f, ok := a.failures[userID]
+	if !ok {
+		f = twoFactorFailures{since: now}
+	}
+	f.count++
+	a.failures[userID] = f
+}
+
+// succeed clears the wrong codes of the account
+func (a *twoFactorAttempts) succeed(userID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.failures, userID)
+}
+
+// useStep records that the account signed in with the code of step. It
+// fails when that code or a later one was used already.
+func (a *twoFactorAttempts) useStep(userID string, step int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if last, ok := a.lastStep[userID]; ok && step <= last {
+		return false
+	}
+	a.lastStep[userID] = step
+	return true
+}
+
+// matchTOTP returns the time step whose code equals code. The steps before
+// and after now are accepted for clock drift; all three are always computed
+// and compared in constant time, so the response time does not tell which
+// step, if any, was close.
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	current := now.Unix() / totpPeriod
+	var step int64
+	found := 0
+	for _, s := range []int64{current - 1, current, current + 1} {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(s*totpPeriod, 0), opts)
+		if err != nil {
+			return 0, false
+		}
+		match := subtle.ConstantTimeCompare([]byte(expected), []byte(code))
+		if match == 1 && found == 0 {
+			step = s
+		}
+		found |= match
+	}
+	return step, found == 1
+}
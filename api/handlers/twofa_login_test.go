@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useTestTwoFactorGuard gives the test fresh 2FA attempt counts
+func useTestTwoFactorGuard(t *testing.T) {
+	t.Helper()
+	previous := twoFactorGuard
+	twoFactorGuard = newTwoFactorAttempts()
+	t.Cleanup(func() { twoFactorGuard = previous })
+}
+
+// capturedArg matches any string argument and keeps it
+type capturedArg struct{ value *string }
+
+func (a capturedArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	if ok {
+		*a.value = s
+	}
+	return ok
+}
+
+func expect2FAUser(mock sqlmock.Sqlmock, secret, backupCodes interface{}) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, username, email, smb_hash`)).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "username", "email", "smb_hash", "provider", "is_admin", "is_active",
+			"totp_secret", "totp_backup_codes", "created_at", "updated_at",
+		}).AddRow("user-1", "alice", nil, nil, "local", false, true,
+			secret, backupCodes, time.Now(), time.Now()))
+}
+
+func verify2FARequest(t *testing.T, tc *TestContext, handler *TOTPHandler, body map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := NewJSONRequest(http.MethodPost, "/api/auth/2fa/verify", body)
+	rec := httptest.NewRecorder()
+	_ = handler.Verify2FA(tc.Echo.NewContext(req, rec))
+	return rec
+}
+
+func TestLogin_2FAReturnsPendingToken(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	handler := CreateTestAuthHandler(tc.DB)
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, username, email, password_hash`)).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "username", "email", "password_hash", "smb_hash", "provider",
+			"is_admin", "is_active", "totp_enabled", "setup_completed", "created_at", "updated_at",
+		}).AddRow("user-1", "alice", nil, string(passwordHash), nil, "local",
+			false, true, true, true, time.Now(), time.Now()))
+
+	req, _ := NewJSONRequest(http.MethodPost, "/api/auth/login", map[string]string{
+		"username": "alice",
+		"password": "password123",
+	})
+	_ = handler.Login(tc.Echo.NewContext(req, tc.Recorder))
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+
+	var resp LoginResponse
+	if err := ParseJSONResponse(tc.Recorder, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Requires2FA || resp.UserID != "" || resp.Token != "" {
+		t.Errorf("response = %+v", resp)
+	}
+	claims, err := parsePending2FAToken(resp.PendingToken)
+	if err != nil || claims.UserID != "user-1" {
+		t.Fatalf("pending token: %v, %+v", err, claims)
+	}
+	if !claims.IsLimited() || allowedForLimitedToken(claims, http.MethodGet, "/api/auth/profile") {
+		t.Error("pending token usable as a bearer token")
+	}
+}
+
+func TestVerify2FA_RequiresPendingToken(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useTestTwoFactorGuard(t)
+	handler := &TOTPHandler{db: tc.DB, auditHandler: &AuditHandler{db: tc.DB}}
+
+	// The user ID alone is no longer accepted
+	if rec := verify2FARequest(t, tc, handler, map[string]string{"userId": "user-1", "code": "123456"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("user ID only: status %d", rec.Code)
+	}
+
+	// Nor is any other token of the account
+	fullToken, _ := GenerateJWT("user-1", "alice", false)
+	setupToken, _ := GenerateScopedJWT("user-1", "alice", false, TokenScope2FASetup)
+	for _, token := range []string{fullToken, setupToken, "garbage"} {
+		if rec := verify2FARequest(t, tc, handler, map[string]string{"pendingToken": token, "code": "123456"}); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %.20s: status %d", token, rec.Code)
+		}
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerify2FA_BackupCodeWorksOnce(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useTestTwoFactorGuard(t)
+	handler := &TOTPHandler{db: tc.DB, auditHandler: &AuditHandler{db: tc.DB}}
+	tc.Mock.MatchExpectationsInOrder(false)
+	pendingToken, _ := generatePending2FAToken("user-1", "alice", false)
+
+	first, _ := bcrypt.GenerateFromPassword([]byte("AAAA1111"), bcrypt.MinCost)
+	second, _ := bcrypt.GenerateFromPassword([]byte("BBBB2222"), bcrypt.MinCost)
+	codes, _ := json.Marshal([]string{string(first), string(second)})
+
+	// The code is burnt with an update conditional on the codes as read
+	var remaining string
+	expect2FAUser(tc.Mock, nil, string(codes))
+	tc.Mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET totp_backup_codes = $1`)).
+		WithArgs(capturedArg{&remaining}, "user-1", string(codes)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	rec := verify2FARequest(t, tc, handler, map[string]string{"pendingToken": pendingToken, "code": "AAAA1111"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first use: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var left []string
+	if err := json.Unmarshal([]byte(remaining), &left); err != nil || len(left) != 1 || left[0] != string(second) {
+		t.Fatalf("remaining codes = %s", remaining)
+	}
+
+	// The second use reads the remaining codes and is refused
+	expect2FAUser(tc.Mock, nil, remaining)
+	if rec := verify2FARequest(t, tc, handler, map[string]string{"pendingToken": pendingToken, "code": "AAAA1111"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("second use: status %d", rec.Code)
+	}
+
+	// A login that read the codes before another one burnt them is refused too
+	expect2FAUser(tc.Mock, nil, remaining)
+	tc.Mock.ExpectExec(regexp.QuoteMeta(`UPDATE users SET totp_backup_codes = $1`)).
+		WithArgs(sqlmock.AnyArg(), "user-1", remaining).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if rec := verify2FARequest(t, tc, handler, map[string]string{"pendingToken": pendingToken, "code": "BBBB2222"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("racing use: status %d", rec.Code)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerify2FA_CodeReplayAndThrottle(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useTestTwoFactorGuard(t)
+	key := make([]byte, 32)
+	handler := &TOTPHandler{db: tc.DB, encryptKey: key, auditHandler: &AuditHandler{db: tc.DB}}
+	tc.Mock.MatchExpectationsInOrder(false)
+	pendingToken, _ := generatePending2FAToken("user-1", "alice", false)
+
+	const secret = "JBSWY3DPEHPK3PXP"
+	encrypted, err := EncryptAESGCM([]byte(secret), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := totp.GenerateCode(secret, time.Now())
+	verify := func(code string) int {
+		expect2FAUser(tc.Mock, encrypted, nil)
+		return verify2FARequest(t, tc, handler, map[string]string{"pendingToken": pendingToken, "code": code}).Code
+	}
+
+	if status := verify(code); status != http.StatusOK {
+		t.Fatalf("valid code: status %d", status)
+	}
+	if status := verify(code); status != http.StatusUnauthorized {
+		t.Errorf("replayed code: status %d", status)
+	}
+
+	// Wrong codes are throttled per account before the database is asked
+	for i := 1; i < max2FAAttempts; i++ {
+		if status := verify("000000"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: status %d", i, status)
+		}
+	}
+	rec := verify2FARequest(t, tc, handler, map[string]string{"pendingToken": pendingToken, "code": code})
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("after %d wrong codes: status %d, Retry-After %q", max2FAAttempts, rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestMatchTOTP_Window(t *testing.T) {
+	const secret = "JBSWY3DPEHPK3PXP"
+	now := time.Unix(1700000000, 0)
+	for offset, ok := range map[time.Duration]bool{
+		-totpPeriod * time.Second:     true,
+		0:                             true,
+		totpPeriod * time.Second:      true,
+		-2 * totpPeriod * time.Second: false,
+		2 * totpPeriod * time.Second:  false,
+	} {
+		code, _ := totp.GenerateCode(secret, now.Add(offset))
+		step, got := matchTOTP(secret, code, now)
+		if got != ok {
+			t.Errorf("code of %v: matched %v", offset, got)
+		}
+		if got && step != now.Add(offset).Unix()/totpPeriod {
+			t.Errorf("code of %v: step %d", offset, step)
+		}
+	}
+}
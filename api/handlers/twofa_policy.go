@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TokenScope2FASetup limits a token to the 2FA setup endpoints. It is issued
+// at login to accounts that are past the 2FA grace period without 2FA.
+const TokenScope2FASetup = "2fa_setup"
+
+// NotifTwoFactorRequired tells a user that the 2FA policy now applies to them
+const NotifTwoFactorRequired = "security.2fa_required"
+
+// limitedTokenExpiration is the lifetime of a 2FA setup token
+const limitedTokenExpiration = 30 * time.Minute
+
+// twoFactorSetupRoutes are the only routes a 2FA setup token may call
+var twoFactorSetupRoutes = map[string]bool{
+	http.MethodGet + " /api/auth/2fa/status":  true,
+	http.MethodGet + " /api/auth/2fa/setup":   true,
+	http.MethodPost + " /api/auth/2fa/enable": true,
+	http.MethodGet + " /api/auth/profile":     true,
+}
+
+// TwoFactorPolicy is the 2FA enforcement configured in system settings
+type TwoFactorPolicy struct {
+	RequireForAdmins bool
+	RequireForAll    bool
+	GraceDays        int
+	EnforcedAt       time.Time // when the policy was switched on; grace starts here
+}
+
+// TwoFactorCompliance describes how a user stands against the 2FA policy
+type TwoFactorCompliance struct {
+	Required   bool       `json:"required"`
+	Compliant  bool       `json:"compliant"`
+	GraceUntil *time.Time `json:"graceUntil,omitempty"`
+	Overdue    bool       `json:"overdue,omitempty"`
+}
+
+// IsLimited reports whether the token only grants a restricted scope
+func (c *JWTClaims) IsLimited() bool {
+	return c != nil && c.Scope != ""
+}
+
+// LoadTwoFactorPolicy reads the 2FA policy from system settings
+func LoadTwoFactorPolicy() TwoFactorPolicy {
+	settings := GetGlobalSettingsHandler()
+	if settings == nil {
+		return TwoFactorPolicy{}
+	}
+	policy := TwoFactorPolicy{
+		RequireForAdmins: settings.GetSettingBool("require_2fa_for_admins", false),
+		RequireForAll:    settings.GetSettingBool("require_2fa_for_all", false),
+		GraceDays:        settings.GetSettingInt("require_2fa_grace_days", 7),
+	}
+	if value, err := settings.GetSetting("require_2fa_enforced_at"); err == nil && value != "" {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			policy.EnforcedAt = t
+		}
+	}
+	return policy
+}
+
+// Enabled reports whether any 2FA requirement is switched on
+func (p TwoFactorPolicy) Enabled() bool {
+	return p.RequireForAdmins || p.RequireForAll
+}
+
+// Applies reports whether the policy covers an account
+func (p TwoFactorPolicy) Applies(isAdmin bool) bool {
+	return p.RequireForAll || (isAdmin && p.RequireForAdmins)
+}
+
+// Evaluate checks one account against the policy. The grace period starts
+// when the policy was enabled, or when the account was created if later.
+func (p TwoFactorPolicy) Evaluate(isAdmin, has2FA bool, createdAt, now time.Time) TwoFactorCompliance {
+	if !p.Applies(isAdmin) {
+		return TwoFactorCompliance{Compliant: true}
+	}
+	if has2FA {
+		return TwoFactorCompliance{Required: true, Compliant: true}
+	}
+
+	start := p.EnforcedAt
+	if createdAt.After(start) {
+		start = createdAt
+	}
+	graceUntil := start.AddDate(0, 0, max(p.GraceDays, 0))
+	return TwoFactorCompliance{
+		Required:   true,
+		GraceUntil: &graceUntil,
+		Overdue:    !now.Before(graceUntil),
+	}
+}
+
+// GenerateScopedJWT generates a short-lived token restricted to a scope
+func GenerateScopedJWT(userID, username string, isAdmin bool, scope string) (string, error) {
+	return generateJWT(userID, username, isAdmin, false, scope, "", limitedTokenExpiration)
+}
+
+// allowedForLimitedToken reports whether a limited token may call the route
+func allowedForLimitedToken(claims *JWTClaims, method, route string) bool {
+	return claims.Scope == TokenScope2FASetup && twoFactorSetupRoutes[method+" "+route]
+}
+
+// adminViolates2FAPolicy reports whether an admin account lacks 2FA while the
+// policy requires it. Admin actions are refused even during the grace period.
+func adminViolates2FAPolicy(db *sql.DB, userID string) bool {
+	if db == nil || !LoadTwoFactorPolicy().Applies(true) {
+		return false
+	}
+	var totpEnabled bool
+	if err := db.QueryRow("SELECT COALESCE(totp_enabled, false) FROM users WHERE id = $1", userID).Scan(&totpEnabled); err != nil {
+		return false
+	}
+	return !totpEnabled
+}
+
+// onTwoFactorPolicyChanged records when enforcement starts and notifies the
+// users who now have to set up 2FA. previous is the policy before the change.
+func onTwoFactorPolicyChanged(db *sql.DB, settings *SettingsHandler, previous TwoFactorPolicy, actorID string) {
+	current := LoadTwoFactorPolicy()
+	newlyAdmins := current.Applies(true) && !previous.Applies(true)
+	newlyAll := current.RequireForAll && !previous.RequireForAll
+	if !newlyAdmins && !newlyAll {
+		return
+	}
+
+	// Start the grace period when enforcement goes from off to on
+	if !previous.Enabled() {
+		_, err := db.Exec(`
+			INSERT INTO system_settings (key, value, description, updated_by, updated_at)
+			VALUES ('require_2fa_enforced_at', $1, 'When 2FA enforcement was enabled (grace period start)', $2, NOW())
+			ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+		`, time.Now().UTC().Format(time.RFC3339), actorID)
+		if err != nil {
+			log.Printf("[2FA Policy] Failed to record enforcement start: %v", err)
+		}
+		settings.InvalidateCache("require_2fa_enforced_at")
+		current = LoadTwoFactorPolicy()
+	}
+
+	// Users who were not covered before and lack 2FA
+	rows, err := db.Query(`
+		SELECT id, is_admin, created_at FROM users
+		WHERE is_active = TRUE AND COALESCE(totp_enabled, false) = FALSE
+		  AND ($1 OR is_admin = TRUE)
+	`, newlyAll)
+	if err != nil {
+		log.Printf("[2FA Policy] Failed to list affected users: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	notifications := NewNotificationService(db)
+	now := time.Now()
+	for rows.Next() {
+		var userID string
+		var isAdmin bool
+		var createdAt time.Time
+		if err := rows.Scan(&userID, &isAdmin, &createdAt); err != nil {
+			continue
+		}
+		if previous.Applies(isAdmin) {
+			continue
+		}
+		compliance := current.Evaluate(isAdmin, false, createdAt, now)
+		metadata := map[string]interface{}{"admin": isAdmin}
+		message := "Two-factor authentication is now required for your account."
+		if compliance.GraceUntil != nil && !compliance.Overdue {
+			metadata["graceUntil"] = compliance.GraceUntil
+			message += " Please set it up before " + compliance.GraceUntil.Format("2006-01-02") + "."
+		}
+		if _, err := notifications.Create(userID, NotifTwoFactorRequired, "Set up two-factor authentication",
+			message, "/settings/security", &actorID, metadata); err != nil {
+			log.Printf("[2FA Policy] Failed to notify user %s: %v", userID, err)
+		}
+	}
+}
+
+// isTwoFactorPolicyKey reports whether a setting key affects 2FA enforcement
+func isTwoFactorPolicyKey(key string) bool {
+	return key == "require_2fa_for_admins" || key == "require_2fa_for_all"
+}
+
+// respondLimitedToken rejects a request made with a 2FA setup token
+func respondLimitedToken(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]string{
+		"error": "Two-factor authentication setup is required",
+		"code":  "2FA_SETUP_REQUIRED",
+	})
+}
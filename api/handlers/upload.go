@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/tus/tusd/v2/pkg/filestore"
 	tusd "github.com/tus/tusd/v2/pkg/handler"
 )
 
@@ -33,32 +35,31 @@ type UploadHandler struct {
 }
 
 func NewUploadHandler(dataRoot string, db *sql.DB) (*UploadHandler, error) {
-	// Create upload temp directory
-	uploadDir := filepath.Join(dataRoot, ".uploads")
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	h := &UploadHandler{
+		dataRoot:     dataRoot,
+		db:           db,
+		auditHandler: NewAuditHandler(db, dataRoot),
 	}
 
-	// Create file store for tus
-	store := filestore.New(uploadDir)
+	// Create file store for tus, staging under .uploads or the destination's staging directory
+	store, err := NewStagingStore(dataRoot, filepath.Join(dataRoot, ".uploads"), h.stagingTarget)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create tus handler with unrouted handler for more control
 	composer := tusd.NewStoreComposer()
 	store.UseIn(composer)
 
-	h := &UploadHandler{
-		dataRoot:     dataRoot,
-		db:           db,
-		auditHandler: NewAuditHandler(db, dataRoot),
-	}
-
 	// Create TUS handler with pre-upload validation
 	handler, err := tusd.NewUnroutedHandler(tusd.Config{
-		BasePath:                "/",
-		StoreComposer:           composer,
-		NotifyCompleteUploads:   true,
-		RespectForwardedHeaders: true,
-		PreUploadCreateCallback: h.preUploadCreateCallback,
+		BasePath:                  "/",
+		StoreComposer:             composer,
+		NotifyCompleteUploads:     true,
+		NotifyUploadProgress:      true,
+		RespectForwardedHeaders:   true,
+		PreUploadCreateCallback:   h.preUploadCreateCallback,
+		PreFinishResponseCallback: h.preFinishResponseCallback,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create tus handler: %w", err)
@@ -66,9 +67,19 @@ func NewUploadHandler(dataRoot string, db *sql.DB) (*UploadHandler, error) {
 
 	h.tusHandler = handler
 
+	// Held uploads are put in place once inspection lets them through
+	RegisterUploadPlacer("web", h.placeUpload)
+
 	// Start goroutine to handle completed uploads
 	go h.handleCompletedUploads()
 
+	// Feed upload progress into the live activity registry
+	go func() {
+		for event := range handler.UploadProgress {
+			GetActivityRegistry().TrackTusProgress(event)
+		}
+	}()
+
 	return h, nil
 }
 
@@ -109,34 +120,72 @@ func (h *UploadHandler) preUploadCreateCallback(hook tusd.HookEvent) (tusd.HTTPR
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
+	// Files of a folder upload go below the destination by their relative
+	// directory, created on completion
+	relativeDir, err := validateRelativeDir(hook.Upload.MetaData["relativePath"])
+	if err != nil {
+		fmt.Printf("[TUS-PreUpload] REJECTED: %s\n", err.Error())
+		resp.StatusCode = 400
+		resp.Body = fmt.Sprintf(`{"error":%q}`, err.Error())
+		return resp, changes, tusd.ErrUploadRejectedByServer
+	}
+	destPath = uploadDestPath(destPath, relativeDir)
+
+	// Mount-ins are read-only views of another user's home folder
+	if apiErr := mountInWriteError(destPath); apiErr != nil {
+		fmt.Printf("[TUS-PreUpload] REJECTED: %s\n", apiErr.Message)
+		resp.StatusCode = apiErr.HTTPStatus()
+		body, _ := json.Marshal(map[string]string{"error": apiErr.Message, "code": string(apiErr.Code)})
+		resp.Body = string(body)
+		return resp, changes, tusd.ErrUploadRejectedByServer
+	}
+
 	// Validate path security
-	_, err := h.resolveVirtualPath(destPath, username)
+	realDestPath, err := h.resolveVirtualPath(destPath, username)
 	if err != nil {
 		fmt.Printf("[TUS-PreUpload] REJECTED: path validation failed: %s\n", err.Error())
 		resp.StatusCode = 400
 		resp.Body = fmt.Sprintf(`{"error":"Invalid upload path: %s"}`, err.Error())
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
+	if apiErr := storageWriteError(realDestPath); apiErr != nil {
+		fmt.Printf("[TUS-PreUpload] REJECTED: %s\n", apiErr.Message)
+		resp.StatusCode = apiErr.HTTPStatus()
+		resp.Header = tusd.HTTPHeader{"Retry-After": strconv.Itoa(storageQuiesceRetryAfter)}
+		body, _ := json.Marshal(map[string]string{"error": apiErr.Message, "code": string(apiErr.Code)})
+		resp.Body = string(body)
+		return resp, changes, tusd.ErrUploadRejectedByServer
+	}
 
-	// Check storage quota
-	if username != "" && uploadSize > 0 {
-		quotaOk, remaining, err := h.checkUserQuota(username, uploadSize)
-		if err != nil {
-			fmt.Printf("Quota check error for user %s: %v\n", username, err)
-			// Allow upload on quota check error (fail-open for now)
-		} else if !quotaOk {
-			resp.StatusCode = 413
-			resp.Body = fmt.Sprintf(`{"error":"Storage quota exceeded","remaining":%d,"required":%d}`, remaining, uploadSize)
+	// New files may be added to a folder under retention hold, held ones not replaced
+	if target := filepath.Join(realDestPath, filename); hook.Upload.MetaData["overwrite"] == "true" && GetRetention().Blocks(target, false) {
+		if apiErr := GetRetention().Check(h.auditHandler, h.getUserIDByUsername(username), "", "", target, EventFileOverwrite, false); apiErr != nil {
+			fmt.Printf("[TUS-PreUpload] REJECTED: %s\n", apiErr.Message)
+			resp.StatusCode = apiErr.HTTPStatus()
+			body, _ := json.Marshal(map[string]interface{}{"error": apiErr.Message, "code": apiErr.Code, "details": apiErr.Details})
+			resp.Body = string(body)
 			return resp, changes, tusd.ErrUploadRejectedByServer
 		}
 	}
 
-	// Check for shared drive quota if uploading to shared drive
+	// Check storage quota: uploads into a shared folder count against the
+	// folder's quota, everything else against the user's. Files of a batch
+	// are checked with the rest of the batch.
+	quotaSize := UploadBatchBytes(uploadSize, hook.Upload.MetaData["batchRemaining"])
 	if strings.HasPrefix(destPath, "/shared/") {
-		allowed, quota, used := h.checkSharedDriveQuota(destPath, uploadSize)
-		if !allowed && quota > 0 { // quota > 0 means quota is set (not unlimited)
+		if apiErr := checkSharedUploadQuota(hook.Context, h.db, h.dataRoot, destPath, quotaSize); apiErr != nil {
+			resp.StatusCode = apiErr.HTTPStatus()
+			resp.Body = quotaRejectionBody(apiErr)
+			return resp, changes, tusd.ErrUploadRejectedByServer
+		}
+	} else if username != "" && uploadSize > 0 {
+		quotaOk, remaining, err := h.checkUserQuota(username, quotaSize)
+		if err != nil {
+			fmt.Printf("Quota check error for user %s: %v\n", username, err)
+			// Allow upload on quota check error (fail-open for now)
+		} else if !quotaOk {
 			resp.StatusCode = 413
-			resp.Body = fmt.Sprintf(`{"error":"Shared drive quota exceeded","quota":%d,"used":%d,"required":%d}`, quota, used, uploadSize)
+			resp.Body = fmt.Sprintf(`{"error":"Storage quota exceeded","remaining":%d,"required":%d}`, remaining, quotaSize)
 			return resp, changes, tusd.ErrUploadRejectedByServer
 		}
 	}
@@ -148,6 +197,46 @@ func (h *UploadHandler) preUploadCreateCallback(hook tusd.HookEvent) (tusd.HTTPR
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
+	// Refuse above the folder's entry limit; warn above the threshold.
+	// Camera backups are routed into dated folders and skip the check.
+	if hook.Upload.MetaData["ingest"] != IngestCamera {
+		target, adding := filepath.Join(realDestPath, filename), 1
+		if _, err := os.Stat(target); err == nil && hook.Upload.MetaData["overwrite"] == "true" {
+			adding = 0
+		}
+		warning, apiErr := CheckDirEntries(filepath.Dir(target), filepath.Dir(filepath.Join(destPath, filename)), adding, h.uploadEntryOverride(hook, username))
+		if apiErr != nil {
+			fmt.Printf("[TUS-PreUpload] REJECTED: %s\n", apiErr.Message)
+			resp.StatusCode = apiErr.HTTPStatus()
+			body, _ := json.Marshal(map[string]interface{}{"error": apiErr.Message, "code": apiErr.Code, "details": apiErr.Details})
+			resp.Body = string(body)
+			return resp, changes, tusd.ErrUploadRejectedByServer
+		}
+		if warning != nil {
+			resp.Header = tusd.HTTPHeader{DirEntryWarningHeader: warning.Message}
+		}
+	}
+
+	// Camera backup uploads: skip content the client already knows the server has
+	if hook.Upload.MetaData["ingest"] == IngestCamera {
+		if username == "" {
+			resp.StatusCode = 400
+			resp.Body = `{"error":"Camera backup requires a username"}`
+			return resp, changes, tusd.ErrUploadRejectedByServer
+		}
+		if checksum := strings.ToLower(hook.Upload.MetaData["checksum"]); checksum != "" {
+			if userID := h.getUserIDByUsername(username); userID != nil {
+				if cfg, err := loadCameraBackupConfig(h.db, *userID); err == nil {
+					if existing, ok := findCameraDuplicate(h.db, h.dataRoot, *userID, username, cfg, checksum, uploadSize); ok {
+						resp.StatusCode = 409
+						resp.Body = fmt.Sprintf(`{"error":"already exists","status":%q,"path":%q}`, CameraIngestAlreadyExists, existing)
+						return resp, changes, tusd.ErrUploadRejectedByServer
+					}
+				}
+			}
+		}
+	}
+
 	// Log successful pre-upload validation
 	fmt.Printf("Pre-upload validation passed: user=%s, path=%s, filename=%s, size=%d\n",
 		username, destPath, filename, uploadSize)
@@ -155,14 +244,29 @@ func (h *UploadHandler) preUploadCreateCallback(hook tusd.HookEvent) (tusd.HTTPR
 	return resp, changes, nil
 }
 
+// uploadEntryOverride reports whether an upload asks to pass the hard
+// entry limit through its metadata and its user holds storage.admin
+func (h *UploadHandler) uploadEntryOverride(hook tusd.HookEvent, username string) bool {
+	if hook.Upload.MetaData[DirEntryOverrideParam] != "true" || username == "" {
+		return false
+	}
+	userID := h.getUserIDByUsername(username)
+	if userID == nil {
+		return false
+	}
+	perms, err := GetAdminRoles().Lookup(*userID)
+	return err == nil && (perms.Superadmin || slices.Contains(perms.Permissions, PermStorageAdmin))
+}
+
 // checkUserQuota checks if user has enough storage quota for the upload
 // Quota is checked against home folder + trash usage (shared folders have separate quota)
-// Uses database-stored values for instant checks (no filesystem scan)
+// Uses database-stored values for instant checks (no filesystem scan). Users
+// without a quota of their own get the largest quota of their groups.
 func (h *UploadHandler) checkUserQuota(username string, uploadSize int64) (bool, int64, error) {
 	// Get user quota and current usage from database
 	var quota, storageUsed, trashUsed sql.NullInt64
 	err := h.db.QueryRow(`
-		SELECT COALESCE(storage_quota, $1), storage_used, trash_used
+		SELECT user_storage_quota(id, storage_quota, $1), storage_used, trash_used
 		FROM users WHERE username = $2
 	`, DefaultUserQuota, username).Scan(&quota, &storageUsed, &trashUsed)
 
@@ -196,36 +300,6 @@ func (h *UploadHandler) checkUserQuota(username string, uploadSize int64) (bool,
 	return true, remaining, nil
 }
 
-// checkSharedDriveQuota checks quota for shared drive
-// Uses database-stored storage_used value for instant checks (no filesystem scan)
-func (h *UploadHandler) checkSharedDriveQuota(path string, uploadSize int64) (allowed bool, quota int64, used int64) {
-	folderName := ExtractSharedDriveFolderName(path)
-	if folderName == "" {
-		return true, 0, 0 // No folder name means root shared, allow
-	}
-
-	// Get quota and current usage from database
-	var storageUsed sql.NullInt64
-	err := h.db.QueryRow(`
-		SELECT storage_quota, storage_used FROM shared_folders WHERE name = $1 AND is_active = TRUE
-	`, folderName).Scan(&quota, &storageUsed)
-	if err != nil {
-		return true, 0, 0 // Allow on error
-	}
-
-	// 0 = unlimited
-	if quota == 0 {
-		return true, 0, 0
-	}
-
-	// Get current usage from DB (instant - no filesystem scan)
-	if storageUsed.Valid {
-		used = storageUsed.Int64
-	}
-
-	return (used + uploadSize) <= quota, quota, used
-}
-
 // validateFilename checks for dangerous filename patterns
 func validateFilename(filename string) error {
 	// Check for empty filename
@@ -301,6 +375,9 @@ func (h *UploadHandler) resolveVirtualPath(virtualPath string, username string)
 		if username == "" {
 			return "", fmt.Errorf("username required for home folder")
 		}
+		if GetGuests().IsGuestName(username) {
+			return "", fmt.Errorf("guest accounts have no home folder")
+		}
 		allowedRoot = filepath.Join(h.dataRoot, "users", username)
 		realPath = filepath.Join(allowedRoot, subPath)
 	case "shared":
@@ -316,132 +393,305 @@ func (h *UploadHandler) resolveVirtualPath(virtualPath string, username string)
 		return "", fmt.Errorf("access denied: path escapes allowed directory")
 	}
 
-	return realPath, nil
+	return GetStorageLocations().Map(realPath), nil
+}
+
+// stagingTarget returns the data-root relative destination of an upload
+func (h *UploadHandler) stagingTarget(info tusd.FileInfo) string {
+	destPath := info.MetaData["path"]
+	if destPath == "" || info.MetaData["ingest"] == IngestCamera {
+		destPath = "/home"
+	}
+	relativeDir, _ := validateRelativeDir(info.MetaData["relativePath"])
+	realPath, err := h.resolveVirtualPath(uploadDestPath(destPath, relativeDir), info.MetaData["username"])
+	if err != nil {
+		return ""
+	}
+	rel, _ := dataRootRel(h.dataRoot, realPath)
+	return filepath.ToSlash(rel)
 }
 
 func (h *UploadHandler) handleCompletedUploads() {
 	for event := range h.tusHandler.CompleteUploads {
-		// Get destination path from metadata
-		destPath := event.Upload.MetaData["path"]
-		filename := event.Upload.MetaData["filename"]
-		username := event.Upload.MetaData["username"] // Added for virtual path resolution
-		overwrite := event.Upload.MetaData["overwrite"] == "true"
-
-		if destPath == "" {
-			destPath = "/home" // Default to home folder
-		}
-		if filename == "" {
-			filename = event.Upload.ID
-		}
+		h.completeUpload(event)
+	}
+}
 
-		// Resolve virtual path to real path
-		realDestPath, err := h.resolveVirtualPath(destPath, username)
-		if err != nil {
-			fmt.Printf("Failed to resolve virtual path %s: %v\n", destPath, err)
-			continue
-		}
+// completeUpload moves a finished upload into place, or into the pending
+// area when its folder has blocking inspection. The upload stays in the
+// live activity list until then, and nothing announces it before the move
+// succeeded.
+func (h *UploadHandler) completeUpload(event tusd.HookEvent) {
+	defer GetActivityRegistry().FinishTusUpload(event.Upload.ID)
 
-		// Move file to destination
-		srcPath := filepath.Join(h.dataRoot, ".uploads", event.Upload.ID)
-		finalPath := filepath.Join(realDestPath, filename)
+	// Get destination path from metadata
+	destPath := event.Upload.MetaData["path"]
+	filename := event.Upload.MetaData["filename"]
+	username := event.Upload.MetaData["username"] // Added for virtual path resolution
+	overwrite := event.Upload.MetaData["overwrite"] == "true"
 
-		// Ensure destination directory exists with appropriate permissions
-		destDir := filepath.Dir(finalPath)
-		if strings.HasPrefix(destPath, "/shared/") {
-			if err := MkdirAllShared(destDir); err != nil {
-				fmt.Printf("Failed to create directory: %v\n", err)
-				continue
-			}
-		} else {
-			if err := os.MkdirAll(destDir, 0755); err != nil {
-				fmt.Printf("Failed to create directory: %v\n", err)
-				continue
-			}
-		}
+	if destPath == "" {
+		destPath = "/home" // Default to home folder
+	}
+	if filename == "" {
+		filename = event.Upload.ID
+	}
 
-		// Check if file already exists
-		if !overwrite {
-			// Generate unique name if file exists and overwrite is not requested
-			finalPath = h.getUniqueFilePath(finalPath)
-		}
-		// If overwrite is true, the existing file will be replaced by os.Rename
+	if event.Upload.MetaData["ingest"] == IngestCamera {
+		h.completeCameraIngest(event, username, filename)
+		return
+	}
 
-		// Mark this file as a web upload before moving
-		tracker := GetWebUploadTracker()
-		tracker.MarkUploading(finalPath)
+	// Folder uploads were validated on creation; the intermediate folders
+	// are created when the file is put in place
+	relativeDir, err := validateRelativeDir(event.Upload.MetaData["relativePath"])
+	if err != nil {
+		fmt.Printf("Invalid relative path for upload %s: %v\n", event.Upload.ID, err)
+		return
+	}
+	folder := uploadTopFolder(destPath, relativeDir)
+	destPath = uploadDestPath(destPath, relativeDir)
 
-		// Move file (will overwrite if exists)
-		if err := os.Rename(srcPath, finalPath); err != nil {
-			fmt.Printf("Failed to move file: %v\n", err)
-			tracker.UnmarkUploading(finalPath)
-			continue
-		}
+	realDestPath, err := h.resolveUploadDest(destPath, username)
+	if err != nil {
+		fmt.Printf("Failed to resolve virtual path %s: %v\n", destPath, err)
+		return
+	}
+
+	srcPath := stagedUploadPath(event.Upload, filepath.Join(h.dataRoot, ".uploads"))
+	// Clean up .info file
+	defer os.Remove(srcPath + ".info")
+
+	// Get client IP from the tracker (stored when upload was created)
+	ipAddr := GetTusIPTracker().GetIP(event.Upload.ID)
+	if ipAddr == "" {
+		ipAddr = "0.0.0.0"
+	}
+	upload := &InspectedUpload{
+		Source:    "web",
+		DestPath:  destPath,
+		Filename:  filename,
+		Folder:    folder,
+		Username:  username,
+		Overwrite: overwrite,
+		Size:      event.Upload.Size,
+		ClientIP:  ipAddr,
+	}
+	var userID *string
+	if username != "" {
+		userID = h.getUserIDByUsername(username)
+	}
 
-		// Set permissions for shared folders
-		if strings.HasPrefix(destPath, "/shared/") {
-			_ = SetSharedPermissions(finalPath, false)
+	// Uploads into a folder with blocking inspection wait for the verdict
+	if GetContentInspection().Hold(srcPath, realDestPath, upload, userID) {
+		return
+	}
+	finalPath, err := h.placeUpload(srcPath, upload)
+	if err != nil {
+		fmt.Printf("Failed to move file: %v\n", err)
+		return
+	}
+	GetContentInspection().Inspect(finalPath, upload.Size, userID)
+}
+
+// resolveUploadDest resolves the destination folder of an upload
+func (h *UploadHandler) resolveUploadDest(destPath, username string) (string, error) {
+	realDestPath, err := h.resolveVirtualPath(destPath, username)
+	if err != nil {
+		return "", err
+	}
+	// A storage migration switching the destination holds writes briefly;
+	// resolve again afterwards since the folder may have moved
+	if GetStorageLocations().waitWritable(realDestPath) {
+		return h.resolveVirtualPath(destPath, username)
+	}
+	return realDestPath, nil
+}
+
+// placeUpload moves a finished or released upload to its destination,
+// accounts its storage and announces it. It returns the final path.
+func (h *UploadHandler) placeUpload(srcPath string, upload *InspectedUpload) (string, error) {
+	destPath, filename, username, overwrite := upload.DestPath, upload.Filename, upload.Username, upload.Overwrite
+	realDestPath, err := h.resolveUploadDest(destPath, username)
+	if err != nil {
+		return "", err
+	}
+
+	// Move file to destination
+	finalPath := filepath.Join(realDestPath, filename)
+
+	var userID *string
+	if username != "" {
+		userID = h.getUserIDByUsername(username)
+	}
+	actorID := ""
+	if userID != nil {
+		actorID = *userID
+	}
+
+	// Ensure destination directory exists with appropriate permissions,
+	// with the folders of a folder upload
+	if err := mkdirUploadFolders(filepath.Dir(finalPath), strings.HasPrefix(destPath, "/shared/"), actorID); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// A hold may have started since the upload was accepted; keep the held file
+	if overwrite && GetRetention().Blocks(finalPath, false) {
+		fmt.Printf("Upload %s would replace a file under retention hold; storing it under a new name\n", filename)
+		overwrite = false
+	}
+
+	// Mark names as web uploads before moving
+	tracker := GetWebUploadTracker()
+	var marked []string
+	mark := func(path string) {
+		tracker.MarkUploading(path)
+		marked = append(marked, path)
+	}
+	unmark := func() {
+		for _, path := range marked {
+			tracker.UnmarkUploading(path)
 		}
+	}
 
-		// Clean up .info file
-		infoPath := srcPath + ".info"
-		os.Remove(infoPath)
+	replaced := false
+	if overwrite {
+		// The existing file is replaced by the rename
+		_, statErr := os.Stat(finalPath)
+		replaced = statErr == nil
+		mark(finalPath)
+		err = finalizeUpload(srcPath, finalPath)
+	} else {
+		// Without overwrite an existing name, also one taken by an upload
+		// finishing at the same moment, gets the upload a "[n]" suffix
+		finalPath, err = finalizeUploadUnique(srcPath, finalPath, mark)
+	}
+	if err != nil {
+		unmark()
+		return "", err
+	}
+	_ = SealPath(finalPath)
+
+	// Set permissions for shared folders
+	if strings.HasPrefix(destPath, "/shared/") {
+		_ = SetSharedPermissions(finalPath, false)
+	}
 
-		fmt.Printf("Upload completed: %s -> %s (overwrite: %v)\n", filename, finalPath, overwrite)
+	fmt.Printf("Upload completed: %s -> %s (overwrite: %v)\n", filename, finalPath, overwrite)
 
-		// Update storage tracking for the user (home folder uploads)
-		if username != "" && h.auditHandler != nil && h.auditHandler.db != nil && !strings.HasPrefix(destPath, "/shared/") {
-			// Get file size after upload
-			fileSize := event.Upload.Size
+	// Update storage tracking for the user (home folder uploads)
+	if username != "" && h.auditHandler != nil && h.auditHandler.db != nil && !strings.HasPrefix(destPath, "/shared/") {
+		// Get file size after upload
+		fileSize := upload.Size
+		_, err := h.auditHandler.db.Exec(`
+			UPDATE users
+			SET storage_used = GREATEST(0, COALESCE(storage_used, 0) + $1),
+			    updated_at = NOW()
+			WHERE username = $2
+		`, fileSize, username)
+		if err != nil {
+			fmt.Printf("[Storage] Failed to update storage for %s: %v\n", username, err)
+		}
+	}
+
+	// Update storage tracking for shared folders
+	if strings.HasPrefix(destPath, "/shared/") && h.auditHandler != nil && h.auditHandler.db != nil {
+		folderName := ExtractSharedDriveFolderName(destPath)
+		if folderName != "" {
+			fileSize := upload.Size
 			_, err := h.auditHandler.db.Exec(`
-				UPDATE users
+				UPDATE shared_folders
 				SET storage_used = GREATEST(0, COALESCE(storage_used, 0) + $1),
 				    updated_at = NOW()
-				WHERE username = $2
-			`, fileSize, username)
+				WHERE name = $2 AND is_active = TRUE
+			`, fileSize, folderName)
 			if err != nil {
-				fmt.Printf("[Storage] Failed to update storage for %s: %v\n", username, err)
+				fmt.Printf("[Storage] Failed to update shared folder storage for %s: %v\n", folderName, err)
 			}
 		}
+	}
 
-		// Update storage tracking for shared folders
-		if strings.HasPrefix(destPath, "/shared/") && h.auditHandler != nil && h.auditHandler.db != nil {
-			folderName := ExtractSharedDriveFolderName(destPath)
-			if folderName != "" {
-				fileSize := event.Upload.Size
-				_, err := h.auditHandler.db.Exec(`
-					UPDATE shared_folders
-					SET storage_used = GREATEST(0, COALESCE(storage_used, 0) + $1),
-					    updated_at = NOW()
-					WHERE name = $2 AND is_active = TRUE
-				`, fileSize, folderName)
-				if err != nil {
-					fmt.Printf("[Storage] Failed to update shared folder storage for %s: %v\n", folderName, err)
-				}
-			}
-		}
+	// Log audit event for file upload
+	ipAddr := upload.ClientIP
+	if ipAddr == "" {
+		ipAddr = "0.0.0.0"
+	}
+	changeType := ChangeCreate
+	if replaced {
+		changeType = ChangeModify
+	}
+	GetChangeJournal().Record(changeType, finalPath, "", actorID)
+	GetListingVersions().Invalidate(finalPath)
+	PregenerateThumbnails(finalPath)
+	GetContentIndex().Changed(finalPath)
+	GetDirSizes().Changed(finalPath)
+	GetFileChecksums().Queue(finalPath)
+	if !replaced {
+		GetDirEntryLimits().Added(filepath.Dir(finalPath), 1)
+	}
+	details := map[string]interface{}{
+		"fileName": filename,
+		"size":     upload.Size,
+		"source":   "web",
+	}
+	if upload.Folder != "" {
+		details["folder"] = upload.Folder
+	}
+	_ = h.auditHandler.LogEvent(userID, ipAddr, EventFileUpload, destPath+"/"+filename, details)
 
-		// Log audit event for file upload
-		// Get client IP from the tracker (stored when upload was created)
-		ipAddr := GetTusIPTracker().GetIP(event.Upload.ID)
-		if ipAddr == "" {
-			ipAddr = "0.0.0.0"
-		}
-		var userID *string
-		if username != "" {
-			userID = h.getUserIDByUsername(username)
-		}
-		_ = h.auditHandler.LogEvent(userID, ipAddr, EventFileUpload, destPath+"/"+filename, map[string]interface{}{
-			"fileName": filename,
-			"size":     event.Upload.Size,
-			"source":   "web",
-		})
-
-		// Keep the mark for 10 seconds then remove it
-		go func(path string) {
-			time.Sleep(10 * time.Second)
-			tracker.UnmarkUploading(path)
-		}(finalPath)
+	// Keep the marks for 10 seconds then remove them
+	go func() {
+		time.Sleep(10 * time.Second)
+		unmark()
+	}()
+
+	return finalPath, nil
+}
+
+// completeCameraIngest routes a finished camera backup upload into the user's
+// backup folder by capture date, discarding it if the content already exists
+func (h *UploadHandler) completeCameraIngest(event tusd.HookEvent, username, filename string) {
+	srcPath := stagedUploadPath(event.Upload, filepath.Join(h.dataRoot, ".uploads"))
+	defer os.Remove(srcPath + ".info")
+
+	userID := h.getUserIDByUsername(username)
+	if userID == nil {
+		fmt.Printf("[CameraBackup] Unknown user %q, discarding upload %s\n", username, event.Upload.ID)
+		os.Remove(srcPath)
+		return
+	}
+
+	result, err := ingestCameraFile(h.db, h.dataRoot, *userID, username, srcPath, filename, event.Upload.MetaData["capturedAt"])
+	if err != nil {
+		fmt.Printf("[CameraBackup] Failed to ingest %s: %v\n", filename, err)
+		return
 	}
+	fmt.Printf("[CameraBackup] %s: %s -> %s\n", result.Status, filename, result.Path)
+
+	if result.Status != CameraIngestIngested {
+		return
+	}
+
+	if _, err := h.db.Exec(`
+		UPDATE users
+		SET storage_used = GREATEST(0, COALESCE(storage_used, 0) + $1),
+		    updated_at = NOW()
+		WHERE id = $2
+	`, result.Size, *userID); err != nil {
+		fmt.Printf("[Storage] Failed to update storage for %s: %v\n", username, err)
+	}
+
+	ipAddr := GetTusIPTracker().GetIP(event.Upload.ID)
+	if ipAddr == "" {
+		ipAddr = "0.0.0.0"
+	}
+	_ = h.auditHandler.LogEvent(userID, ipAddr, EventFileUpload, result.Path, map[string]interface{}{
+		"fileName":   filename,
+		"size":       result.Size,
+		"source":     IngestCamera,
+		"capturedAt": result.CapturedAt,
+		"dateSource": result.DateSource,
+	})
 }
 
 // getUserIDByUsername looks up user ID by username
@@ -457,31 +707,6 @@ func (h *UploadHandler) getUserIDByUsername(username string) *string {
 	return &userID
 }
 
-// getUniqueFilePath returns a unique file path by adding [1], [2], etc. if file exists
-func (h *UploadHandler) getUniqueFilePath(path string) string {
-	// Check if file exists
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return path
-	}
-
-	// File exists, need to generate unique name
-	dir := filepath.Dir(path)
-	ext := filepath.Ext(path)
-	base := strings.TrimSuffix(filepath.Base(path), ext)
-
-	// Try adding [1], [2], etc.
-	for i := 1; i < 1000; i++ {
-		newPath := filepath.Join(dir, fmt.Sprintf("%s[%d]%s", base, i, ext))
-		if _, err := os.Stat(newPath); os.IsNotExist(err) {
-			return newPath
-		}
-	}
-
-	// Fallback: use timestamp (very unlikely to reach here)
-	timestamp := fmt.Sprintf("%d", os.Getpid())
-	return filepath.Join(dir, fmt.Sprintf("%s_%s%s", base, timestamp, ext))
-}
-
 // TusHandler returns the UnroutedHandler for tus uploads
 func (h *UploadHandler) TusHandler() *tusd.UnroutedHandler {
 	return h.tusHandler
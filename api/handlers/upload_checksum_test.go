@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVerifyUploadChecksum(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"no header", "", 0},
+		{"sha1 match", "sha1 qvTGHdzF6KLavt4PO0gs2a6pQ00=", 0},
+		{"sha256 match", "sha256 LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=", 0},
+		{"md5 match", "md5 XUFAKrxLKna5cZ2REBfFkg==", 0},
+		{"mismatch", "sha1 AAAAAAAAAAAAAAAAAAAAAAAAAAA=", StatusChecksumMismatch},
+		{"unsupported", "crc32 AAAAAA==", http.StatusBadRequest},
+		{"malformed", "sha1", http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			req := httptest.NewRequest(http.MethodPatch, "/api/upload/abc", strings.NewReader("hello"))
+			if tt.header != "" {
+				req.Header.Set("Upload-Checksum", tt.header)
+			}
+			cleanup, status, err := VerifyUploadChecksum(req, dir)
+			if status != tt.status {
+				t.Fatalf("status = %d (%v), want %d", status, err, tt.status)
+			}
+			if status == 0 {
+				if body, _ := io.ReadAll(req.Body); string(body) != "hello" {
+					t.Errorf("body = %q, want the chunk handed on", body)
+				}
+			}
+			cleanup()
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%d spool files left", len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Upload precheck answers
+const (
+	PrecheckIdentical = "identical" // The destination already holds the content
+	PrecheckElsewhere = "elsewhere" // A file the user can read holds it; the server can copy it
+	PrecheckCopied    = "copied"    // Completed from that file, nothing to upload
+	PrecheckAbsent    = "absent"    // Upload as usual
+)
+
+// precheckCandidates bounds the stored files with the checksum looked at
+const precheckCandidates = 20
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// UploadPrecheckRequest asks whether an upload's content is already on the
+// server before its bytes are sent
+type UploadPrecheckRequest struct {
+	Path      string `json:"path"` // Destination file, e.g. /shared/Team/iso/os.iso
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Dedup     bool   `json:"dedup"`     // Look for the content in other files the user can read
+	Complete  bool   `json:"complete"`  // With dedup, copy a found file to the destination
+	Overwrite bool   `json:"overwrite"` // Replace an existing different destination file
+}
+
+// UploadPrecheckResult is the answer to an upload precheck
+type UploadPrecheckResult struct {
+	Status string `json:"status"`
+	Path   string `json:"path,omitempty"`   // Destination file, for identical and copied
+	Source string `json:"source,omitempty"` // File holding the content, for elsewhere and copied
+	Method string `json:"method,omitempty"` // link or copy, for copied
+
+	EntryWarning *DirEntryWarning `json:"entryWarning,omitempty"`
+}
+
+// UploadPrecheck compares an upload with what the server already holds
+// @Summary		Upload precheck
+// @Description	Checks by SHA-256 whether an upload is needed. "identical" means the destination already holds the content. With dedup, a file the user can read with the same content is "elsewhere"; adding complete copies it to the destination (a hard link within the same drive while SMB is off) and answers "copied", logged as upload.dedup. Other answers are "absent". Without dedup, or with upload_dedup_enabled off, other files are not looked at.
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		request	body		UploadPrecheckRequest	true	"Destination file, size and SHA-256"
+// @Success		200		{object}	docs.SuccessResponse	"status, path, source and method in data"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid request"
+// @Failure		403		{object}	docs.ErrorResponse	"No write permission"
+// @Failure		413		{object}	docs.ErrorResponse	"Storage quota exceeded"
+// @Security	BearerAuth
+// @Router		/upload/precheck [post]
+func (h *Handler) UploadPrecheck(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	var req UploadPrecheckRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	req.SHA256 = strings.ToLower(strings.TrimSpace(req.SHA256))
+	if req.Path == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	if !sha256Pattern.MatchString(req.SHA256) {
+		return RespondError(c, ErrBadRequest("sha256 must be 64 hex characters"))
+	}
+	if req.Size < 0 {
+		return RespondError(c, ErrBadRequest("size must not be negative"))
+	}
+
+	dirPath, filename := path.Split(path.Clean("/" + req.Path))
+	if err := validateFilename(filename); err != nil {
+		return RespondError(c, ErrBadRequest(err.Error()))
+	}
+	destDir, storageType, displayDir, err := h.resolvePath(dirPath, claims)
+	if err != nil {
+		return RespondError(c, ErrInvalidPath(err.Error()))
+	}
+	if storageType != StorageHome && storageType != StorageShared {
+		return RespondError(c, ErrBadRequest("Uploads go to home or shared drive folders"))
+	}
+	if displayDir == "/shared" {
+		return RespondError(c, ErrForbidden("공유 드라이브 루트에는 파일을 업로드할 수 없습니다"))
+	}
+	if storageType == StorageShared && !h.CanWriteSharedDrive(claims.UserID, displayDir) {
+		return RespondError(c, ErrForbidden("No permission to upload to this shared drive"))
+	}
+	if apiErr := mountInWriteError(displayDir); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	destPath := filepath.Join(destDir, filename)
+	displayPath := path.Join(displayDir, filename)
+	ctx := c.Request().Context()
+
+	if info, err := os.Stat(destPath); err == nil && !info.IsDir() && PlainSize(destPath, info) == req.Size {
+		if sum, err := GetFileChecksums().Get(ctx, destPath); err == nil && sum.Checksum == req.SHA256 {
+			return RespondSuccess(c, UploadPrecheckResult{Status: PrecheckIdentical, Path: displayPath})
+		}
+	}
+
+	if !req.Dedup || !uploadDedupEnabled() {
+		return RespondSuccess(c, UploadPrecheckResult{Status: PrecheckAbsent})
+	}
+	source, sourceDisplay := h.findReadableCopy(ctx, claims, req.SHA256, req.Size, destPath)
+	if source == "" {
+		return RespondSuccess(c, UploadPrecheckResult{Status: PrecheckAbsent})
+	}
+	if !req.Complete {
+		return RespondSuccess(c, UploadPrecheckResult{Status: PrecheckElsewhere, Source: sourceDisplay})
+	}
+
+	// The copy takes the checks of an upload to the destination
+	if apiErr := storageWriteError(destDir); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if info, err := os.Stat(destDir); err != nil || !info.IsDir() {
+		return RespondError(c, ErrNotFound("Destination folder"))
+	}
+	if storageType == StorageShared {
+		if apiErr := checkSharedUploadQuota(ctx, h.db, h.dataRoot, displayDir, req.Size); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+	} else if apiErr := h.homeQuotaError(claims.UserID, req.Size); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	overwrite := req.Overwrite
+	if overwrite && GetRetention().Blocks(destPath, false) {
+		if apiErr := GetRetention().Check(h.auditHandler, &claims.UserID, "", "", destPath, EventFileOverwrite, false); apiErr != nil {
+			return RespondError(c, apiErr)
+		}
+	}
+	_, statErr := os.Stat(destPath)
+	replacing := statErr == nil && overwrite
+	adding := 1
+	if replacing {
+		adding = 0
+	}
+	entryWarning, apiErr := CheckDirEntries(destDir, displayDir, adding, dirEntryOverride(c, claims))
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	// A link is only safe while every write replaces the file; SMB clients
+	// write in place and would change both
+	link := sameDrive(h.dataRoot, source, destPath) && !smbWritesInPlace()
+	finalPath, method, err := placeDedupCopy(source, destPath, overwrite, link)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("copy file", err))
+	}
+	_ = SealPath(finalPath)
+	if storageType == StorageShared {
+		_ = SetSharedPermissions(finalPath, false)
+		folderName := ExtractSharedDriveFolderName(displayDir)
+		if err := h.UpdateSharedFolderStorage(folderName, req.Size); err != nil {
+			LogError("Failed to update storage usage", err, "folder", folderName)
+		}
+	} else if err := h.UpdateUserStorage(claims.UserID, req.Size); err != nil {
+		LogError("Failed to update storage usage", err, "user", claims.Username)
+	}
+
+	changeType := ChangeCreate
+	if replacing {
+		changeType = ChangeModify
+	}
+	GetChangeJournal().Record(changeType, finalPath, "", claims.UserID)
+	GetListingVersions().Invalidate(finalPath)
+	PregenerateThumbnails(finalPath)
+	GetContentIndex().Changed(finalPath)
+	GetDirSizes().Changed(finalPath)
+	GetFileChecksums().Queue(finalPath)
+	if !replacing {
+		GetDirEntryLimits().Added(destDir, 1)
+	}
+
+	finalDisplay := path.Join(displayDir, filepath.Base(finalPath))
+	h.auditHandler.LogEventFromContext(c, EventUploadDedup, finalDisplay, map[string]interface{}{
+		"fileName": filepath.Base(finalPath),
+		"size":     req.Size,
+		"sha256":   req.SHA256,
+		"source":   sourceDisplay,
+		"method":   method,
+	})
+
+	return RespondSuccess(c, UploadPrecheckResult{
+		Status:       PrecheckCopied,
+		Path:         finalDisplay,
+		Source:       sourceDisplay,
+		Method:       method,
+		EntryWarning: entryWarning,
+	})
+}
+
+// uploadDedupEnabled reports whether prechecks may look at other files
+func uploadDedupEnabled() bool {
+	sh := GetGlobalSettingsHandler()
+	return sh == nil || sh.GetUploadDedupEnabled()
+}
+
+// findReadableCopy returns a file other than exclude that the user can read
+// and that holds content of the checksum and plain size, with its display
+// path. Only the user's home folder and shared drives they may read are
+// looked at, so the answer reveals nothing the user could not open.
+func (h *Handler) findReadableCopy(ctx context.Context, claims *JWTClaims, checksum string, size int64, exclude string) (string, string) {
+	rels, err := GetFileChecksums().Find(ctx, checksum, precheckCandidates)
+	if err != nil {
+		LogError("Failed to look up checksums", err)
+		return "", ""
+	}
+	home := "users/" + claims.Username + "/"
+	for _, rel := range rels {
+		var displayPath string
+		switch {
+		case strings.HasPrefix(rel, home):
+			displayPath = "/home/" + strings.TrimPrefix(rel, home)
+		case strings.HasPrefix(rel, "shared/"):
+			displayPath = "/" + rel
+			if !h.CanReadSharedDrive(claims.UserID, displayPath) {
+				continue
+			}
+		default:
+			continue
+		}
+		realPath, _, _, err := h.resolvePath(displayPath, claims)
+		if err != nil || realPath == exclude {
+			continue
+		}
+		info, err := os.Stat(realPath)
+		if err != nil || !info.Mode().IsRegular() || PlainSize(realPath, info) != size {
+			continue
+		}
+		if sum, err := GetFileChecksums().Get(ctx, realPath); err == nil && sum.Checksum == checksum {
+			return realPath, displayPath
+		}
+	}
+	return "", ""
+}
+
+// homeQuotaError returns the quota error for adding size bytes to a user's
+// home folder, nil if it fits or the quota cannot be read
+func (h *Handler) homeQuotaError(userID string, size int64) *APIError {
+	var quota, used int64
+	if err := h.db.QueryRow(`
+		SELECT user_storage_quota(id, storage_quota, $1), COALESCE(storage_used, 0) + COALESCE(trash_used, 0)
+		FROM users WHERE id = $2
+	`, DefaultUserQuota, userID).Scan(&quota, &used); err == nil && quota > 0 && used+size > quota {
+		return ErrQuotaExceeded(quota, used, size)
+	}
+	return nil
+}
+
+// sameDrive reports whether two real paths lie in the same home folder or
+// shared drive. Files hard linked across drives would share the mode and
+// group a drive sets.
+func sameDrive(dataRoot, a, b string) bool {
+	driveOf := func(realPath string) string {
+		rel, err := dataRootRel(dataRoot, realPath)
+		if err != nil {
+			return ""
+		}
+		parts := strings.SplitN(filepath.ToSlash(rel), "/", 3)
+		if len(parts) < 3 || (parts[0] != "users" && parts[0] != "shared") {
+			return ""
+		}
+		return parts[0] + "/" + parts[1]
+	}
+	drive := driveOf(a)
+	return drive != "" && drive == driveOf(b)
+}
+
+// placeDedupCopy puts the content of source at destPath, as a hard link when
+// link is set and the filesystem allows it, otherwise as a copy. Without
+// overwrite an existing name gets the file a "[n]" suffix like an upload.
+// It returns the final path and "link" or "copy".
+func placeDedupCopy(source, destPath string, overwrite, link bool) (string, string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", "", err
+	}
+	staged, err := os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+".*.upload")
+	if err != nil {
+		return "", "", err
+	}
+	stagedPath := staged.Name()
+
+	method := "copy"
+	if link {
+		staged.Close()
+		os.Remove(stagedPath)
+		if os.Link(source, stagedPath) == nil {
+			method = "link"
+		} else if staged, err = os.OpenFile(stagedPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			return "", "", err
+		}
+	}
+	if method == "copy" {
+		if err := copyFileContent(source, staged, info); err != nil {
+			os.Remove(stagedPath)
+			return "", "", err
+		}
+	}
+
+	// Mark names as web uploads so the watcher does not take them for SMB
+	// activity; the marks go after 10 seconds as an upload's do
+	tracker := GetWebUploadTracker()
+	var marked []string
+	mark := func(p string) {
+		tracker.MarkUploading(p)
+		marked = append(marked, p)
+	}
+	defer time.AfterFunc(10*time.Second, func() {
+		for _, p := range marked {
+			tracker.UnmarkUploading(p)
+		}
+	})
+
+	finalPath := destPath
+	if overwrite {
+		mark(destPath)
+		err = os.Rename(stagedPath, destPath)
+	} else {
+		finalPath, err = finalizeUploadUnique(stagedPath, destPath, mark)
+	}
+	if err != nil {
+		os.Remove(stagedPath)
+		return "", "", err
+	}
+	return finalPath, method, nil
+}
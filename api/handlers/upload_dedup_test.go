@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPlaceDedupCopy(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "a.iso")
+	if err := os.WriteFile(source, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	linked, method, err := placeDedupCopy(source, filepath.Join(dir, "b.iso"), false, true)
+	if err != nil || method != "link" || linked != filepath.Join(dir, "b.iso") {
+		t.Fatalf("link = %q, %q, %v", linked, method, err)
+	}
+	if info, _ := os.Stat(linked); fileLinkCount(info) != 2 {
+		t.Errorf("%s has %d links, want 2", linked, fileLinkCount(info))
+	}
+
+	// An existing name gets a suffix unless overwritten
+	copied, method, err := placeDedupCopy(source, filepath.Join(dir, "b.iso"), false, false)
+	if err != nil || method != "copy" || copied == linked {
+		t.Fatalf("copy = %q, %q, %v", copied, method, err)
+	}
+	if data, _ := os.ReadFile(copied); string(data) != "image" {
+		t.Errorf("copy holds %q", data)
+	}
+	if info, _ := os.Stat(copied); fileLinkCount(info) != 1 {
+		t.Errorf("copy shares its data")
+	}
+
+	replaced, _, err := placeDedupCopy(source, copied, true, false)
+	if err != nil || replaced != copied {
+		t.Errorf("overwrite = %q, %v", replaced, err)
+	}
+
+	// No staged files are left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("%d entries, want a.iso, b.iso and its copy", len(entries))
+	}
+}
+
+func TestSameDrive(t *testing.T) {
+	root := "/data"
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"/data/users/alice/a", "/data/users/alice/x/b", true},
+		{"/data/users/alice/a", "/data/users/bob/a", false},
+		{"/data/shared/Team/a", "/data/shared/Team/b", true},
+		{"/data/shared/Team/a", "/data/shared/Ops/a", false},
+		{"/data/shared/Team/a", "/data/users/alice/a", false},
+		{"/data/.trash/a/b", "/data/.trash/a/c", false},
+	} {
+		if got := sameDrive(root, tt.a, tt.b); got != tt.want {
+			t.Errorf("sameDrive(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUploadPrecheck_InvalidChecksum(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &Handler{db: tc.DB, dataRoot: t.TempDir()}
+
+	req, _ := NewJSONRequest(http.MethodPost, "/api/upload/precheck", map[string]interface{}{
+		"path": "/home/os.iso", "size": 10, "sha256": "not-a-checksum",
+	})
+	c := tc.Echo.NewContext(req, tc.Recorder)
+	c.Set("user", &JWTClaims{UserID: "u1", Username: "alice"})
+	if err := h.UploadPrecheck(c); err != nil {
+		t.Fatal(err)
+	}
+	if tc.Recorder.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", tc.Recorder.Code)
+	}
+}
+
+func TestUploadPrecheck_CopiesWhileSMBWritesInPlace(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	writeTestFiles(t, dataRoot, "users/alice/src/os.iso")
+	if err := os.MkdirAll(filepath.Join(dataRoot, "users", "alice", "dst"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{db: tc.DB, dataRoot: dataRoot, auditHandler: NewAuditHandler(tc.DB, t.TempDir())}
+	useCachedSettings(t, map[string]string{
+		"upload_dedup_enabled": "true", "smb_enabled": "true", settingDirEntriesWarn: "0", settingDirEntriesMax: "0",
+	})
+	previous := globalFileChecksums
+	globalFileChecksums = &FileChecksums{db: tc.DB, dataRoot: dataRoot, queue: make(chan string, 1)}
+	t.Cleanup(func() { globalFileChecksums = previous })
+
+	content := "users/alice/src/os.iso"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+	source := filepath.Join(dataRoot, "users", "alice", "src", "os.iso")
+	info, _ := os.Stat(source)
+
+	tc.Mock.ExpectQuery("SELECT path FROM file_checksums").
+		WillReturnRows(sqlmock.NewRows([]string{"path"}).AddRow("users/alice/src/os.iso"))
+	tc.Mock.ExpectQuery("SELECT size, mtime, sha256, computed_at FROM file_checksums").
+		WillReturnRows(sqlmock.NewRows([]string{"size", "mtime", "sha256", "computed_at"}).
+			AddRow(info.Size(), info.ModTime().Truncate(time.Microsecond), checksum, time.Now()))
+	tc.Mock.ExpectQuery("SELECT user_storage_quota").
+		WillReturnRows(sqlmock.NewRows([]string{"quota", "used"}).AddRow(0, 0))
+	tc.Mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("u1", sqlmock.AnyArg(), EventUploadDedup, "/home/dst/os.iso", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req, _ := NewJSONRequest(http.MethodPost, "/api/upload/precheck", map[string]interface{}{
+		"path": "/home/dst/os.iso", "size": len(content), "sha256": checksum, "dedup": true, "complete": true,
+	})
+	c := tc.Echo.NewContext(req, tc.Recorder)
+	c.Set("user", &JWTClaims{UserID: "u1", Username: "alice"})
+	if err := h.UploadPrecheck(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	if !strings.Contains(tc.Recorder.Body.String(), `"method":"copy"`) {
+		t.Errorf("body = %s", tc.Recorder.Body.String())
+	}
+
+	// Writing the copy over SMB must leave the source alone
+	copied, err := os.Stat(filepath.Join(dataRoot, "users", "alice", "dst", "os.iso"))
+	if err != nil || fileLinkCount(copied) != 1 {
+		t.Fatalf("copy = %v, %v", copied, err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// JobUploadsExpire is the job type deleting unfinished uploads idle past
+// upload_expiry_hours
+const JobUploadsExpire = "uploads.expire"
+
+// UploadExpiryResult is the outcome of an expiry run
+type UploadExpiryResult struct {
+	Cutoff  time.Time `json:"cutoff"`
+	Uploads int       `json:"uploads"`
+	Bytes   int64     `json:"bytes"` // Reclaimed
+}
+
+// StartUploadExpiry registers the upload expiry job and runs it hourly. It
+// covers the staging directories of the stores created so far.
+func StartUploadExpiry(audit *AuditHandler) {
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:        JobUploadsExpire,
+		Idempotent:  true,
+		MaxAttempts: 3,
+		Priority:    PacePriorityMaintenance,
+		Run: func(run *JobRun) error {
+			return runUploadExpiry(run, audit)
+		},
+	})
+	jobs.Schedule(JobUploadsExpire, time.Hour, false)
+}
+
+func runUploadExpiry(run *JobRun, audit *AuditHandler) error {
+	hours := 24
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		hours = sh.GetUploadExpiryHours()
+	}
+	if hours <= 0 {
+		return nil
+	}
+
+	result, err := expireUploads(StagingDirs(), time.Now().Add(-time.Duration(hours)*time.Hour), run.Pace)
+	if result.Uploads > 0 {
+		LogInfo("Unfinished uploads expired", "uploads", result.Uploads, "bytes", result.Bytes,
+			"expiryHours", hours, "complete", err == nil)
+		if audit != nil {
+			_ = audit.LogEvent(nil, "0.0.0.0", EventUploadExpire, "", map[string]interface{}{
+				"uploads":        result.Uploads,
+				"reclaimedBytes": result.Bytes,
+				"expiryHours":    hours,
+			})
+		}
+	}
+	return err
+}
+
+// expireUploads deletes the tus uploads in dirs whose data and info files
+// have not changed since cutoff. A resumed upload writes its data file, so
+// only abandoned ones are that old; their clients get 404 and start over.
+func expireUploads(dirs []string, cutoff time.Time, pace func() error) (UploadExpiryResult, error) {
+	result := UploadExpiryResult{Cutoff: cutoff}
+	for _, dir := range dirs {
+		if err := pace(); err != nil {
+			return result, err
+		}
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		for _, entry := range entries {
+			id, ok := strings.CutSuffix(entry.Name(), ".info")
+			if !ok || entry.IsDir() {
+				continue
+			}
+			infoPath := filepath.Join(dir, entry.Name())
+			dataPath := filepath.Join(dir, id)
+
+			info, err := os.Stat(infoPath)
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			var size int64
+			if data, err := os.Stat(dataPath); err == nil {
+				if data.ModTime().After(cutoff) {
+					continue
+				}
+				size = data.Size()
+			}
+
+			if err := os.Remove(dataPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				LogError("Failed to delete expired upload", err, "path", dataPath)
+				continue
+			}
+			_ = os.Remove(infoPath)
+			GetTusIPTracker().Forget(id)
+			result.Uploads++
+			result.Bytes += size
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExpireUploads(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	stage := func(id string, size int, infoTime, dataTime time.Time) {
+		t.Helper()
+		for path, mtime := range map[string]time.Time{filepath.Join(dir, id+".info"): infoTime, filepath.Join(dir, id): dataTime} {
+			if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	stage("abandoned", 1000, old, old)
+	stage("resumed", 1000, old, time.Now())
+	stage("fresh", 1000, time.Now(), time.Now())
+
+	result, err := expireUploads([]string{dir, filepath.Join(dir, "missing")}, time.Now().Add(-24*time.Hour), func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Uploads != 1 || result.Bytes != 1000 {
+		t.Errorf("result = %+v, want 1 upload of 1000 bytes", result)
+	}
+	for id, want := range map[string]bool{"abandoned": false, "resumed": true, "fresh": true} {
+		for _, name := range []string{id, id + ".info"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != want {
+				t.Errorf("%s exists = %v, want %v", name, err == nil, want)
+			}
+		}
+	}
+}
+
+func TestTusIPTracker_AfterRestart(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	tracker := &TusIPTracker{uploads: make(map[string]*TusUploadInfo), db: tc.DB}
+
+	tc.Mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO tus_upload_clients`)).
+		WithArgs("u1", "10.0.0.5").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tracker.StoreIP("u1", "10.0.0.5")
+
+	// A restart loses the in-memory entry; the database still has it
+	tracker.uploads = make(map[string]*TusUploadInfo)
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`DELETE FROM tus_upload_clients WHERE upload_id = $1 RETURNING client_ip`)).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"client_ip"}).AddRow("10.0.0.5"))
+	if ip := tracker.GetIP("u1"); ip != "10.0.0.5" {
+		t.Errorf("GetIP = %q, want 10.0.0.5", ip)
+	}
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tusd "github.com/tus/tusd/v2/pkg/handler"
+)
+
+// UploadFolderHeader carries the top-level folder a folder upload creates,
+// on the completing tus PATCH, so the client can open it
+const UploadFolderHeader = "X-Upload-Folder"
+
+// validateRelativeDir checks the relativePath of a file in a folder upload,
+// its directory inside the dragged folder such as "Photos/2024". Each part
+// follows the CreateFolder rules. It returns the cleaned path, "" for none.
+func validateRelativeDir(relativePath string) (string, error) {
+	relativePath = strings.Trim(relativePath, "/")
+	if relativePath == "" {
+		return "", nil
+	}
+	if len(relativePath) > 4096 {
+		return "", errors.New("relative path too long")
+	}
+	for _, part := range strings.Split(relativePath, "/") {
+		switch {
+		case part == "" || part == "." || part == "..":
+			return "", errors.New("invalid relative path")
+		case strings.ContainsAny(part, `\:*?"<>|`+"\x00"):
+			return "", errors.New("invalid folder name in relative path")
+		case len(part) > 255:
+			return "", errors.New("folder name too long (max 255 characters)")
+		}
+	}
+	return relativePath, nil
+}
+
+// uploadDestPath returns the virtual folder a file of a folder upload goes
+// to: the destination joined with its validated relative directory
+func uploadDestPath(destPath, relativeDir string) string {
+	if relativeDir == "" {
+		return destPath
+	}
+	return path.Join(destPath, relativeDir)
+}
+
+// uploadTopFolder returns the top-level folder a folder upload creates under
+// its destination, "" for a plain upload
+func uploadTopFolder(destPath, relativeDir string) string {
+	if relativeDir == "" {
+		return ""
+	}
+	top, _, _ := strings.Cut(relativeDir, "/")
+	return path.Join(destPath, top)
+}
+
+// preFinishResponseCallback points the client of a completed folder upload
+// at the top-level folder, escaped like a path segment
+func (h *UploadHandler) preFinishResponseCallback(hook tusd.HookEvent) (tusd.HTTPResponse, error) {
+	resp := tusd.HTTPResponse{}
+	relativeDir, err := validateRelativeDir(hook.Upload.MetaData["relativePath"])
+	if err != nil || relativeDir == "" {
+		return resp, nil
+	}
+	destPath := hook.Upload.MetaData["path"]
+	if destPath == "" {
+		destPath = "/home"
+	}
+	resp.Header = tusd.HTTPHeader{UploadFolderHeader: url.PathEscape(uploadTopFolder(destPath, relativeDir))}
+	return resp, nil
+}
+
+// UploadBatchBytes returns the bytes an upload's quota check covers: the
+// file itself, or batchRemaining when the client sends it for a batch. That
+// is the batch's bytes not yet stored, this file's included; as files
+// complete they move into the used storage, so each check sees the whole
+// batch.
+func UploadBatchBytes(size int64, batchRemaining string) int64 {
+	if n, err := strconv.ParseInt(batchRemaining, 10, 64); err == nil && n > size {
+		return n
+	}
+	return size
+}
+
+// mkdirUploadFolders creates the destination folder of an upload with any
+// missing parents. Folders it creates get the shared drive permissions when
+// shared and are journaled like CreateFolder's.
+func mkdirUploadFolders(dir string, shared bool, actorID string) error {
+	var created []string
+	for p := filepath.Clean(dir); ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err == nil || p == filepath.Dir(p) {
+			break
+		}
+		created = append(created, p)
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	perm := os.FileMode(0755)
+	if shared {
+		perm = SharedDirPerm
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	// Outermost first, as CreateFolder would have made them
+	for i := len(created) - 1; i >= 0; i-- {
+		if shared {
+			_ = SetSharedPermissions(created[i], true)
+		}
+		GetChangeJournal().Record(ChangeCreate, created[i], "", actorID)
+	}
+	GetListingVersions().Invalidate(created[len(created)-1])
+	return nil
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tusd "github.com/tus/tusd/v2/pkg/handler"
+)
+
+func TestValidateRelativeDir(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", "", true},
+		{"Photos", "Photos", true},
+		{"/Photos/2024/", "Photos/2024", true},
+		{"사진/여름", "사진/여름", true},
+		{"Photos/../..", "", false},
+		{"../etc", "", false},
+		{"Photos//2024", "", false},
+		{"Photos/./2024", "", false},
+		{`Photos\2024`, "", false},
+		{"Photos/a:b", "", false},
+		{"Photos/a\x00", "", false},
+	} {
+		got, err := validateRelativeDir(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("validateRelativeDir(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestUploadFolderPaths(t *testing.T) {
+	if got := uploadDestPath("/home/docs", "Photos/2024"); got != "/home/docs/Photos/2024" {
+		t.Errorf("uploadDestPath = %q", got)
+	}
+	if got := uploadTopFolder("/home/docs", "Photos/2024"); got != "/home/docs/Photos" {
+		t.Errorf("uploadTopFolder = %q", got)
+	}
+	if got := uploadTopFolder("/home/docs", ""); got != "" {
+		t.Errorf("uploadTopFolder of a plain upload = %q", got)
+	}
+	for _, tt := range []struct {
+		size      int64
+		remaining string
+		want      int64
+	}{
+		{100, "", 100},
+		{100, "5000", 5000},
+		{100, "50", 100},
+		{100, "junk", 100},
+	} {
+		if got := UploadBatchBytes(tt.size, tt.remaining); got != tt.want {
+			t.Errorf("UploadBatchBytes(%d, %q) = %d, want %d", tt.size, tt.remaining, got, tt.want)
+		}
+	}
+}
+
+func TestMkdirUploadFolders(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Photos", "2024")
+	if err := mkdirUploadFolders(dir, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Fatalf("%s not created: %v", dir, err)
+	}
+	// Existing folders are left alone
+	if err := mkdirUploadFolders(dir, false, ""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPreUploadCreate_RelativePath(t *testing.T) {
+	h := &UploadHandler{dataRoot: t.TempDir()}
+	for _, meta := range []tusd.MetaData{
+		{"path": "/home", "filename": "a.txt", "username": "alice", "relativePath": "../../etc"},
+		{"path": "/home", "filename": "a.txt", "username": "alice", "relativePath": "Photos/a|b"},
+	} {
+		resp, _, err := h.preUploadCreateCallback(tusd.HookEvent{
+			Context: context.Background(),
+			Upload:  tusd.FileInfo{Size: 10, MetaData: meta},
+		})
+		if err == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("relativePath %q: status = %d, err = %v, want 400", meta["relativePath"], resp.StatusCode, err)
+		}
+	}
+
+	resp, _ := h.preFinishResponseCallback(tusd.HookEvent{Upload: tusd.FileInfo{
+		MetaData: tusd.MetaData{"path": "/home/docs", "relativePath": "Photos/2024"},
+	}})
+	if got := resp.Header[UploadFolderHeader]; got != "%2Fhome%2Fdocs%2FPhotos" {
+		t.Errorf("%s = %q", UploadFolderHeader, got)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,7 +12,6 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/tus/tusd/v2/pkg/filestore"
 	tusd "github.com/tus/tusd/v2/pkg/handler"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -40,17 +40,60 @@ type UploadShareInfo struct {
 	RemainingUploads  int        `json:"remainingUploads,omitempty"`
 }
 
+// uploadShareLimits are an upload share's current limits and counters. They
+// come with every rejected upload, so an uploader page shows the values the
+// owner set last without reloading.
+type uploadShareLimits struct {
+	Paused            bool   `json:"paused"`
+	MaxFileSize       int64  `json:"maxFileSize"`
+	AllowedExtensions string `json:"allowedExtensions,omitempty"`
+	MaxTotalSize      int64  `json:"maxTotalSize"`
+	TotalUploadedSize int64  `json:"totalUploadedSize"`
+	MaxAccess         *int   `json:"maxAccess,omitempty"`
+	UploadCount       int    `json:"uploadCount"`
+}
+
+func newUploadShareLimits(isActive bool, maxFileSize int64, allowedExtensions sql.NullString, maxTotalSize, totalUploadedSize int64, maxAccess sql.NullInt32, uploadCount int) uploadShareLimits {
+	limits := uploadShareLimits{
+		Paused:            !isActive,
+		MaxFileSize:       maxFileSize,
+		AllowedExtensions: allowedExtensions.String,
+		MaxTotalSize:      maxTotalSize,
+		TotalUploadedSize: totalUploadedSize,
+		UploadCount:       uploadCount,
+	}
+	if maxAccess.Valid {
+		val := int(maxAccess.Int32)
+		limits.MaxAccess = &val
+	}
+	return limits
+}
+
+// rejectUpload fills a tus response rejecting an upload. fields are added to
+// the error body; limits, when known, are sent along.
+func rejectUpload(resp *tusd.HTTPResponse, status int, message string, limits *uploadShareLimits, fields map[string]interface{}) {
+	body := map[string]interface{}{"error": message}
+	for k, v := range fields {
+		body[k] = v
+	}
+	if limits != nil {
+		body["limits"] = limits
+	}
+	data, _ := json.Marshal(body)
+	resp.StatusCode = status
+	resp.Body = string(data)
+}
+
 // NewUploadShareHandler creates a new UploadShareHandler
 func NewUploadShareHandler(db *sql.DB, dataRoot string, auditHandler *AuditHandler, notificationService *NotificationService) (*UploadShareHandler, error) {
-	// Create upload temp directory for share uploads
-	uploadDir := filepath.Join(dataRoot, ".share-uploads")
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create share upload directory: %w", err)
+	// Create file store for tus, staging under .share-uploads or the destination's staging directory
+	store, err := NewStagingStore(dataRoot, filepath.Join(dataRoot, ".share-uploads"), func(info tusd.FileInfo) string {
+		return info.MetaData["destPath"]
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Create file store for tus
-	store := filestore.New(uploadDir)
-
 	// Create tus handler
 	composer := tusd.NewStoreComposer()
 	store.UseIn(composer)
@@ -76,6 +119,9 @@ func NewUploadShareHandler(db *sql.DB, dataRoot string, auditHandler *AuditHandl
 
 	h.tusHandler = handler
 
+	// Held uploads are put in place once inspection lets them through
+	RegisterUploadPlacer("share_upload", h.placeUpload)
+
 	// Start goroutine to handle completed uploads
 	go h.handleCompletedUploads()
 
@@ -133,10 +179,18 @@ func (h *UploadShareHandler) AccessUploadShare(c echo.Context) error {
 		})
 	}
 
-	// Check if active
+	// Public share info is read on every visit; uploader pages poll it to
+	// pick up limits the owner changed
+	c.Response().Header().Set("Cache-Control", "no-store")
+	limits := newUploadShareLimits(share.IsActive, share.MaxFileSize, share.AllowedExtensions,
+		share.MaxTotalSize, share.TotalUploadedSize, share.MaxAccess, share.UploadCount)
+
+	// Check if paused by the owner
 	if !share.IsActive {
-		return c.JSON(http.StatusGone, map[string]string{
-			"error": "Share is no longer available",
+		return c.JSON(http.StatusGone, map[string]interface{}{
+			"error":  "Share is paused",
+			"paused": true,
+			"limits": limits,
 		})
 	}
 
@@ -149,8 +203,9 @@ func (h *UploadShareHandler) AccessUploadShare(c echo.Context) error {
 
 	// Check max upload count
 	if share.MaxAccess.Valid && share.UploadCount >= int(share.MaxAccess.Int32) {
-		return c.JSON(http.StatusGone, map[string]string{
-			"error": "Upload limit reached",
+		return c.JSON(http.StatusGone, map[string]interface{}{
+			"error":  "Upload limit reached",
+			"limits": limits,
 		})
 	}
 
@@ -191,6 +246,7 @@ func (h *UploadShareHandler) AccessUploadShare(c echo.Context) error {
 		"uploadCount":       share.UploadCount,
 		"maxTotalSize":      share.MaxTotalSize,
 		"totalUploadedSize": share.TotalUploadedSize,
+		"paused":            false,
 	}
 
 	if share.ExpiresAt.Valid {
@@ -222,22 +278,19 @@ func (h *UploadShareHandler) preUploadValidation(hook tusd.HookEvent) (tusd.HTTP
 	uploadSize := hook.Upload.Size
 
 	if shareToken == "" {
-		resp.StatusCode = 400
-		resp.Body = `{"error":"Share token is required"}`
+		rejectUpload(&resp, 400, "Share token is required", nil, nil)
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
 	if filename == "" {
-		resp.StatusCode = 400
-		resp.Body = `{"error":"Filename is required"}`
+		rejectUpload(&resp, 400, "Filename is required", nil, nil)
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
 	// Validate filename
 	if err := validateFilename(filename); err != nil {
 		fmt.Printf("[UploadShare] Filename validation failed: %s (filename: %s)\n", err.Error(), filename)
-		resp.StatusCode = 400
-		resp.Body = fmt.Sprintf(`{"error":"%s"}`, err.Error())
+		rejectUpload(&resp, 400, err.Error(), nil, nil)
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
@@ -266,48 +319,50 @@ func (h *UploadShareHandler) preUploadValidation(hook tusd.HookEvent) (tusd.HTTP
 		&share.UploadCount, &share.MaxTotalSize, &share.TotalUploadedSize)
 
 	if err != nil {
-		resp.StatusCode = 404
-		resp.Body = `{"error":"Share not found"}`
+		rejectUpload(&resp, 404, "Share not found", nil, nil)
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
 	// Validate share
 	if share.ShareType != "upload" {
-		resp.StatusCode = 400
-		resp.Body = `{"error":"Not an upload share"}`
+		rejectUpload(&resp, 400, "Not an upload share", nil, nil)
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
+	limits := newUploadShareLimits(share.IsActive, share.MaxFileSize, share.AllowedExtensions,
+		share.MaxTotalSize, share.TotalUploadedSize, share.MaxAccess, share.UploadCount)
+
 	if !share.IsActive {
-		resp.StatusCode = 410
-		resp.Body = `{"error":"Share is no longer available"}`
+		rejectUpload(&resp, 410, "Share is paused", &limits, map[string]interface{}{"paused": true})
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
 	if share.ExpiresAt.Valid && time.Now().After(share.ExpiresAt.Time) {
-		resp.StatusCode = 410
-		resp.Body = `{"error":"Share has expired"}`
+		rejectUpload(&resp, 410, "Share has expired", nil, nil)
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
 	if share.MaxAccess.Valid && share.UploadCount >= int(share.MaxAccess.Int32) {
-		resp.StatusCode = 410
-		resp.Body = `{"error":"Upload limit reached"}`
+		rejectUpload(&resp, 410, "Upload limit reached", &limits, nil)
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
 	// Check file size
 	if share.MaxFileSize > 0 && uploadSize > share.MaxFileSize {
-		resp.StatusCode = 413
-		resp.Body = fmt.Sprintf(`{"error":"File too large","maxSize":%d,"actualSize":%d}`, share.MaxFileSize, uploadSize)
+		rejectUpload(&resp, 413, "File too large", &limits, map[string]interface{}{
+			"maxSize":    share.MaxFileSize,
+			"actualSize": uploadSize,
+		})
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
 	// Check total size
 	if share.MaxTotalSize > 0 && share.TotalUploadedSize+uploadSize > share.MaxTotalSize {
 		remaining := share.MaxTotalSize - share.TotalUploadedSize
-		resp.StatusCode = 413
-		resp.Body = fmt.Sprintf(`{"error":"Total upload size limit exceeded","remaining":%d,"required":%d}`, remaining, uploadSize)
+		rejectUpload(&resp, 413, "Total upload size limit exceeded", &limits, map[string]interface{}{
+			"remaining": remaining,
+			"required":  uploadSize,
+		})
 		return resp, changes, tusd.ErrUploadRejectedByServer
 	}
 
@@ -323,12 +378,25 @@ func (h *UploadShareHandler) preUploadValidation(hook tusd.HookEvent) (tusd.HTTP
 			}
 		}
 		if !isAllowed {
-			resp.StatusCode = 400
-			resp.Body = fmt.Sprintf(`{"error":"File type not allowed","allowed":"%s"}`, share.AllowedExtensions.String)
+			rejectUpload(&resp, 400, "File type not allowed", &limits, map[string]interface{}{
+				"allowed": share.AllowedExtensions.String,
+			})
 			return resp, changes, tusd.ErrUploadRejectedByServer
 		}
 	}
 
+	// Refuse above the folder's entry limit; warn above the threshold
+	warning, apiErr := CheckDirEntries(filepath.Join(h.dataRoot, share.Path), "", 1, false)
+	if apiErr != nil {
+		rejectUpload(&resp, apiErr.HTTPStatus(), "This folder is full; ask the share owner to organize it into subfolders", &limits, map[string]interface{}{
+			"code": apiErr.Code,
+		})
+		return resp, changes, tusd.ErrUploadRejectedByServer
+	}
+	if warning != nil {
+		resp.Header = tusd.HTTPHeader{DirEntryWarningHeader: warning.Message}
+	}
+
 	// Store share ID and path in metadata for completion handler
 	// Preserve clientIP from the original request metadata
 	clientIP := hook.Upload.MetaData["clientIP"]
@@ -352,100 +420,137 @@ func (h *UploadShareHandler) preUploadValidation(hook tusd.HookEvent) (tusd.HTTP
 // handleCompletedUploads processes completed uploads
 func (h *UploadShareHandler) handleCompletedUploads() {
 	for event := range h.tusHandler.CompleteUploads {
-		shareID := event.Upload.MetaData["shareID"]
-		destPath := event.Upload.MetaData["destPath"]
-		filename := event.Upload.MetaData["filename"]
-		shareToken := event.Upload.MetaData["shareToken"]
-		// clientIP is already decoded by TUS library (no need for base64 decode)
-		clientIP := event.Upload.MetaData["clientIP"]
-		if clientIP == "" {
-			clientIP = "0.0.0.0"
-		}
-
-		if shareID == "" || destPath == "" || filename == "" {
-			fmt.Println("Share upload completion: missing metadata")
-			continue
-		}
+		h.completeUpload(event)
+	}
+}
 
-		// Get share owner info for audit logging
-		var ownerID, ownerUsername string
-		_ = h.db.QueryRow(`
-			SELECT s.created_by, u.username
-			FROM shares s
-			JOIN users u ON s.created_by = u.id
-			WHERE s.id = $1
-		`, shareID).Scan(&ownerID, &ownerUsername)
-
-		// Build full destination path
-		realPath := filepath.Join(h.dataRoot, destPath)
-		finalPath := filepath.Join(realPath, filename)
-
-		// Ensure destination directory exists
-		if err := os.MkdirAll(realPath, 0755); err != nil {
-			fmt.Printf("Failed to create directory: %v\n", err)
-			continue
-		}
+// completeUpload moves a finished share upload into place, or into the
+// pending area when its folder has blocking inspection
+func (h *UploadShareHandler) completeUpload(event tusd.HookEvent) {
+	shareID := event.Upload.MetaData["shareID"]
+	destPath := event.Upload.MetaData["destPath"]
+	filename := event.Upload.MetaData["filename"]
+	shareToken := event.Upload.MetaData["shareToken"]
+	// clientIP is already decoded by TUS library (no need for base64 decode)
+	clientIP := event.Upload.MetaData["clientIP"]
+	if clientIP == "" {
+		clientIP = "0.0.0.0"
+	}
+
+	if shareID == "" || destPath == "" || filename == "" {
+		fmt.Println("Share upload completion: missing metadata")
+		return
+	}
+
+	srcPath := stagedUploadPath(event.Upload, filepath.Join(h.dataRoot, ".share-uploads"))
+	// Clean up .info file
+	defer os.Remove(srcPath + ".info")
+
+	// Update share statistics
+	_, _ = h.db.Exec(`
+		UPDATE shares
+		SET upload_count = upload_count + 1,
+		    total_uploaded_size = total_uploaded_size + $1
+		WHERE id = $2
+	`, event.Upload.Size, shareID)
+
+	upload := &InspectedUpload{
+		Source:     "share_upload",
+		DestPath:   destPath,
+		Filename:   filename,
+		Size:       event.Upload.Size,
+		ClientIP:   clientIP,
+		ShareID:    shareID,
+		ShareToken: shareToken,
+	}
+	var ownerID *string
+	var owner string
+	if err := h.db.QueryRow(`SELECT created_by FROM shares WHERE id = $1`, shareID).Scan(&owner); err == nil {
+		ownerID = &owner
+	}
+
+	// Uploads into a folder with blocking inspection wait for the verdict
+	if GetContentInspection().Hold(srcPath, filepath.Join(h.dataRoot, destPath), upload, ownerID) {
+		return
+	}
+	finalPath, err := h.placeUpload(srcPath, upload)
+	if err != nil {
+		fmt.Printf("Failed to move file: %v\n", err)
+		return
+	}
+	GetContentInspection().Inspect(finalPath, upload.Size, ownerID)
+}
 
-		// Check if file already exists, generate unique name
-		finalPath = h.getUniqueFilePath(finalPath)
+// placeUpload moves a finished or released share upload to its
+// destination and tells the share owner. It returns the final path.
+func (h *UploadShareHandler) placeUpload(srcPath string, upload *InspectedUpload) (string, error) {
+	destPath, shareToken, clientIP := upload.DestPath, upload.ShareToken, upload.ClientIP
 
-		// Move file from temp to destination
-		srcPath := filepath.Join(h.dataRoot, ".share-uploads", event.Upload.ID)
-		if err := os.Rename(srcPath, finalPath); err != nil {
-			fmt.Printf("Failed to move file: %v\n", err)
-			continue
-		}
+	// Get share owner info for audit logging
+	var ownerID, ownerUsername string
+	_ = h.db.QueryRow(`
+		SELECT s.created_by, u.username
+		FROM shares s
+		JOIN users u ON s.created_by = u.id
+		WHERE s.id = $1
+	`, upload.ShareID).Scan(&ownerID, &ownerUsername)
 
-		// Clean up .info file
-		infoPath := srcPath + ".info"
-		os.Remove(infoPath)
-
-		// Update share statistics
-		_, _ = h.db.Exec(`
-			UPDATE shares
-			SET upload_count = upload_count + 1,
-			    total_uploaded_size = total_uploaded_size + $1
-			WHERE id = $2
-		`, event.Upload.Size, shareID)
-
-		fmt.Printf("Share upload completed: token=%s, file=%s, size=%d\n",
-			shareToken, filepath.Base(finalPath), event.Upload.Size)
-
-		// Log audit event with share owner as actor
-		var actorID *string
-		if ownerID != "" {
-			actorID = &ownerID
-		}
-		_ = h.auditHandler.LogEvent(actorID, clientIP, EventFileUpload, "/"+destPath+"/"+filepath.Base(finalPath), map[string]interface{}{
-			"fileName":      filepath.Base(finalPath),
-			"size":          event.Upload.Size,
-			"source":        "share_upload",
-			"shareToken":    shareToken,
-			"shareOwner":    ownerUsername,
-			"uploadedVia":   "공유 링크",
-		})
+	// Build full destination path
+	realPath := filepath.Join(h.dataRoot, destPath)
+	finalPath := filepath.Join(realPath, upload.Filename)
 
-		// Send notification to share owner
-		if h.notificationService != nil && ownerID != "" {
-			title := "업로드 링크로 파일이 업로드되었습니다"
-			message := fmt.Sprintf("누군가가 '%s' 파일을 업로드했습니다 (%s)", filepath.Base(finalPath), formatFileSize(event.Upload.Size))
-			link := "/" + destPath
-			_, _ = h.notificationService.Create(
-				ownerID,
-				NotifUploadLinkReceived,
-				title,
-				message,
-				link,
-				nil,
-				map[string]interface{}{
-					"shareToken": shareToken,
-					"filename":   filepath.Base(finalPath),
-					"size":       event.Upload.Size,
-					"clientIP":   clientIP,
-				},
-			)
-		}
+	// Ensure destination directory exists
+	if err := os.MkdirAll(realPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
 	}
+
+	// Move file from temp to destination, with a "[n]" suffix if the name
+	// is taken, also by an upload finishing at the same moment
+	finalPath, err := finalizeUploadUnique(srcPath, finalPath, func(string) {})
+	if err != nil {
+		return "", err
+	}
+	_ = SealPath(finalPath)
+	GetDirEntryLimits().Added(realPath, 1)
+
+	fmt.Printf("Share upload completed: token=%s, file=%s, size=%d\n",
+		shareToken, filepath.Base(finalPath), upload.Size)
+
+	// Log audit event with share owner as actor
+	var actorID *string
+	if ownerID != "" {
+		actorID = &ownerID
+	}
+	_ = h.auditHandler.LogEvent(actorID, clientIP, EventFileUpload, "/"+destPath+"/"+filepath.Base(finalPath), map[string]interface{}{
+		"fileName":    filepath.Base(finalPath),
+		"size":        upload.Size,
+		"source":      "share_upload",
+		"shareToken":  shareToken,
+		"shareOwner":  ownerUsername,
+		"uploadedVia": "공유 링크",
+	})
+
+	// Send notification to share owner
+	if h.notificationService != nil && ownerID != "" {
+		title := "업로드 링크로 파일이 업로드되었습니다"
+		message := fmt.Sprintf("누군가가 '%s' 파일을 업로드했습니다 (%s)", filepath.Base(finalPath), formatFileSize(upload.Size))
+		link := "/" + destPath
+		notifyShareActivity(h.db, h.notificationService, shareToken,
+			ownerID,
+			NotifUploadLinkReceived,
+			title,
+			message,
+			link,
+			nil,
+			map[string]interface{}{
+				"shareToken": shareToken,
+				"filename":   filepath.Base(finalPath),
+				"size":       upload.Size,
+				"clientIP":   clientIP,
+			},
+		)
+	}
+	return finalPath, nil
 }
 
 // formatFileSize formats file size in human-readable format
@@ -467,27 +572,6 @@ func formatFileSize(size int64) string {
 	}
 }
 
-// getUniqueFilePath returns a unique file path by adding [1], [2], etc. if file exists
-func (h *UploadShareHandler) getUniqueFilePath(path string) string {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return path
-	}
-
-	dir := filepath.Dir(path)
-	ext := filepath.Ext(path)
-	base := strings.TrimSuffix(filepath.Base(path), ext)
-
-	for i := 1; i < 1000; i++ {
-		newPath := filepath.Join(dir, fmt.Sprintf("%s[%d]%s", base, i, ext))
-		if _, err := os.Stat(newPath); os.IsNotExist(err) {
-			return newPath
-		}
-	}
-
-	timestamp := fmt.Sprintf("%d", time.Now().UnixNano())
-	return filepath.Join(dir, fmt.Sprintf("%s_%s%s", base, timestamp, ext))
-}
-
 // TusHandler returns the TUS handler for upload shares
 func (h *UploadShareHandler) TusHandler() *tusd.UnroutedHandler {
 	return h.tusHandler
@@ -530,6 +614,16 @@ func (h *UploadShareHandler) HandleShareUpload(c echo.Context) error {
 		req.Header.Set("Upload-Metadata", metadata)
 	}
 
+	// Uploads started before the owner paused the share finish unless the
+	// upload_share_pause_inflight setting says to reject them
+	if req.Method == http.MethodPatch && h.pausedForInflight(token) {
+		req.URL.Path = originalPath
+		return c.JSON(http.StatusGone, map[string]interface{}{
+			"error":  "Share is paused",
+			"paused": true,
+		})
+	}
+
 	switch req.Method {
 	case http.MethodPost:
 		// Wrap response writer to fix Location header
@@ -541,6 +635,13 @@ func (h *UploadShareHandler) HandleShareUpload(c echo.Context) error {
 	case http.MethodHead:
 		h.tusHandler.HeadFile(res, req)
 	case http.MethodPatch:
+		cleanup, status, err := VerifyUploadChecksum(req, filepath.Join(h.dataRoot, ".share-uploads"))
+		defer cleanup()
+		if status != 0 {
+			req.URL.Path = originalPath
+			res.Header().Set("Tus-Resumable", "1.0.0")
+			return c.JSON(status, map[string]string{"error": err.Error()})
+		}
 		h.tusHandler.PatchFile(res, req)
 	case http.MethodDelete:
 		h.tusHandler.DelFile(res, req)
@@ -549,7 +650,8 @@ func (h *UploadShareHandler) HandleShareUpload(c echo.Context) error {
 	case http.MethodOptions:
 		res.Header().Set("Tus-Resumable", "1.0.0")
 		res.Header().Set("Tus-Version", "1.0.0")
-		res.Header().Set("Tus-Extension", "creation,creation-with-upload,termination")
+		res.Header().Set("Tus-Extension", "creation,creation-with-upload,termination,checksum")
+		res.Header().Set("Tus-Checksum-Algorithm", TusChecksumAlgorithms)
 		res.Header().Set("Tus-Max-Size", "10737418240")
 		res.WriteHeader(http.StatusNoContent)
 	default:
@@ -561,6 +663,23 @@ func (h *UploadShareHandler) HandleShareUpload(c echo.Context) error {
 	return nil
 }
 
+// pausedForInflight reports whether the rest of an upload to a paused share
+// is rejected
+func (h *UploadShareHandler) pausedForInflight(token string) bool {
+	sh := GetGlobalSettingsHandler()
+	if sh == nil {
+		return false
+	}
+	if mode, _ := sh.GetSetting("upload_share_pause_inflight"); mode != "reject" {
+		return false
+	}
+	var isActive bool
+	if err := h.db.QueryRow(`SELECT is_active FROM shares WHERE token = $1`, token).Scan(&isActive); err != nil {
+		return false
+	}
+	return !isActive
+}
+
 // EncodeBase64 encodes bytes to base64 string
 func EncodeBase64(data []byte) string {
 	return base64.StdEncoding.EncodeToString(data)
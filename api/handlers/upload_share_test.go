@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	tusd "github.com/tus/tusd/v2/pkg/handler"
+)
+
+func updateShare(t *testing.T, f *FixtureContext, owner TestUser, shareID string, body UpdateShareRequest) {
+	t.Helper()
+	req, _ := NewJSONRequest(http.MethodPut, "/api/shares/"+shareID, body)
+	c := f.Context(req, owner)
+	c.SetParamNames("id")
+	c.SetParamValues(shareID)
+	if err := f.Shares.UpdateShare(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// updateShareRows returns the share UpdateShare reads before changing it:
+// active, no expiry, password or limits
+func updateShareRows(sharePath, shareType string, uploadCount int, uploaded int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"path", "share_type", "upload_count", "total_uploaded_size", "access_count", "expires_at",
+		"has_password", "max_access", "is_active", "max_file_size", "allowed_extensions", "max_total_size", "notify_email"}).
+		AddRow(sharePath, shareType, uploadCount, uploaded, 0, nil, false, nil, true, 0, "", 0, false)
+}
+
+// uploadShareFixture creates alice's upload share of her inbox folder
+func uploadShareFixture(t *testing.T, f *FixtureContext, req CreateShareRequest) (TestUser, TestShare) {
+	t.Helper()
+	alice := f.User(t, "alice", false)
+	f.CreateTestFolder(t, filepath.Join(f.DataRoot, "users", alice.Username, "inbox"))
+	req.Path, req.ShareType = "/home/inbox", "upload"
+	return alice, f.Share(t, alice, req)
+}
+
+func TestUpdateShare_PauseAndResetCounters(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		alice, share := uploadShareFixture(t, f, CreateShareRequest{MaxAccess: 5})
+		sharePath := "users/" + alice.Username + "/inbox"
+
+		f.Mock.ExpectQuery("SELECT path, share_type, upload_count, total_uploaded_size").
+			WithArgs(share.ID, alice.ID).
+			WillReturnRows(updateShareRows(sharePath, "upload", 7, 4096))
+		f.Mock.ExpectExec(`UPDATE shares SET is_active = \$1, allowed_extensions = \$2, max_access = \$3, upload_count = 0, total_uploaded_size = 0 WHERE id = \$4 AND created_by = \$5`).
+			WithArgs(false, "pdf,jpg", nil, share.ID, alice.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		f.Mock.ExpectExec("INSERT INTO audit_logs").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), EventShareUpdate, sharePath, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		f.Mock.ExpectExec("INSERT INTO audit_logs").
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), EventShareResetCounters, sharePath, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		paused, exts, unlimited := false, " .PDF, jpg ,", 0
+		updateShare(t, f, alice, share.ID, UpdateShareRequest{IsActive: &paused, AllowedExtensions: &exts, MaxAccess: &unlimited, ResetCounters: true})
+
+		AssertStatus(t, f.Recorder, http.StatusOK)
+		f.AssertExpectations(t)
+	})
+}
+
+func TestUpdateShare_OnlyUploadShares(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		alice := f.User(t, "alice", false)
+		f.CreateTestFile(t, filepath.Join(f.DataRoot, "users", alice.Username, "report.pdf"), []byte("%PDF"))
+		share := f.Share(t, alice, CreateShareRequest{Path: "/home/report.pdf"})
+
+		f.Mock.ExpectQuery("SELECT path, share_type, upload_count, total_uploaded_size").
+			WillReturnRows(updateShareRows("users/alice/report.pdf", "download", 0, 0))
+
+		maxFileSize := int64(1024)
+		updateShare(t, f, alice, share.ID, UpdateShareRequest{MaxFileSize: &maxFileSize})
+
+		AssertStatus(t, f.Recorder, http.StatusBadRequest)
+		f.AssertExpectations(t)
+	})
+}
+
+func TestPreUploadValidation_RejectionCarriesLimits(t *testing.T) {
+	RunWithFixtures(t, func(t *testing.T, f *FixtureContext) {
+		_, share := uploadShareFixture(t, f, CreateShareRequest{MaxAccess: 10, AllowedExtensions: "jpg,png"})
+		columns := []string{"id", "path", "expires_at", "max_access", "is_active", "share_type",
+			"max_file_size", "allowed_extensions", "upload_count", "max_total_size", "total_uploaded_size"}
+		hook := tusd.HookEvent{Upload: tusd.FileInfo{Size: 2048, MetaData: map[string]string{
+			"shareToken": share.Token, "filename": "photo.jpg",
+		}}}
+
+		for _, c := range []struct {
+			name     string
+			isActive bool
+			maxSize  int64
+			status   int
+			errMsg   string
+		}{
+			{"paused", false, 0, 410, "Share is paused"},
+			{"file too large", true, 1024, 413, "File too large"},
+		} {
+			f.Mock.ExpectQuery("FROM shares").WithArgs(share.Token).
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(share.ID, "users/alice/inbox", nil, 10, c.isActive, "upload", c.maxSize, "jpg,png", 3, 0, 512))
+
+			resp, _, err := f.UploadShares.preUploadValidation(hook)
+			if err == nil || resp.StatusCode != c.status {
+				t.Fatalf("%s: status %d, err %v", c.name, resp.StatusCode, err)
+			}
+			var body struct {
+				Error  string            `json:"error"`
+				Limits uploadShareLimits `json:"limits"`
+			}
+			if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+				t.Fatalf("%s: %v in %s", c.name, err, resp.Body)
+			}
+			limits := body.Limits
+			if body.Error != c.errMsg || limits.Paused != !c.isActive || limits.MaxFileSize != c.maxSize ||
+				limits.AllowedExtensions != "jpg,png" || limits.MaxAccess == nil || *limits.MaxAccess != 10 ||
+				limits.UploadCount != 3 || limits.TotalUploadedSize != 512 {
+				t.Errorf("%s: body = %s", c.name, resp.Body)
+			}
+		}
+		f.AssertExpectations(t)
+	})
+}
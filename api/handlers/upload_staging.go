@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/tus/tusd/v2/pkg/filestore"
+	tusd "github.com/tus/tusd/v2/pkg/handler"
+)
+
+// uploadStagingEnv lists per-storage-root staging directories as
+// "root=dir" pairs separated by commas, e.g.
+// "/shared/Archive=/data/shared/Archive/.uploads". Uploads into a root stage
+// in its directory, so a root on its own mount finalizes with a rename.
+const uploadStagingEnv = "UPLOAD_STAGING_DIRS"
+
+// uploadStagingDirName is the required name for staging directories inside
+// the data root; the file watcher and listings skip it
+const uploadStagingDirName = ".uploads"
+
+// stagingDirs are the directories of every staging store, for the expiry of
+// abandoned uploads
+var (
+	stagingDirsMu sync.Mutex
+	stagingDirs   = map[string]struct{}{}
+)
+
+// registerStagingDir adds a staging directory to stagingDirs
+func registerStagingDir(dir string) {
+	stagingDirsMu.Lock()
+	defer stagingDirsMu.Unlock()
+	stagingDirs[dir] = struct{}{}
+}
+
+// StagingDirs returns the staging directories of all stores, sorted
+func StagingDirs() []string {
+	stagingDirsMu.Lock()
+	defer stagingDirsMu.Unlock()
+	dirs := make([]string, 0, len(stagingDirs))
+	for dir := range stagingDirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// stagingRoot is a configured staging directory for one storage root
+type stagingRoot struct {
+	Prefix string // Data-root relative: "users" for /home, "shared/{drive}"
+	Store  filestore.FileStore
+}
+
+// StagingStore is a tus data store that keeps each upload in the staging
+// directory of its destination's storage root, falling back to the default
+// directory. Uploads are found again by probing the directories.
+type StagingStore struct {
+	def    filestore.FileStore
+	roots  []stagingRoot // Longest prefix first
+	target func(info tusd.FileInfo) string
+}
+
+// NewStagingStore creates a store staging in defaultDir and the directories
+// configured in UPLOAD_STAGING_DIRS. target returns an upload's data-root
+// relative destination directory.
+func NewStagingStore(dataRoot, defaultDir string, target func(info tusd.FileInfo) string) (*StagingStore, error) {
+	if err := os.MkdirAll(defaultDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	s := &StagingStore{def: filestore.New(defaultDir), target: target}
+	registerStagingDir(defaultDir)
+
+	roots, err := parseUploadStaging(os.Getenv(uploadStagingEnv), dataRoot)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		dir := root.Store.Path
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create upload directory for /%s: %w", root.Prefix, err)
+		}
+		// Advisory only: a mismatch still works, but finalizes by copying
+		if !sameFilesystem(dir, filepath.Join(dataRoot, root.Prefix)) {
+			log.Printf("[Upload] Staging directory %s is not on the filesystem of /%s; uploads there will be copied on completion", dir, root.Prefix)
+		}
+		s.roots = append(s.roots, root)
+		registerStagingDir(dir)
+	}
+	return s, nil
+}
+
+// parseUploadStaging parses UPLOAD_STAGING_DIRS. Roots are virtual: /home
+// (all home folders) or /shared/{drive}.
+func parseUploadStaging(value, dataRoot string) ([]stagingRoot, error) {
+	var roots []stagingRoot
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		root, dir, ok := strings.Cut(pair, "=")
+		root, dir = path.Clean("/"+strings.TrimSpace(root)), filepath.Clean(strings.TrimSpace(dir))
+		if !ok || !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("%s: %q must be root=/absolute/dir", uploadStagingEnv, pair)
+		}
+
+		var prefix string
+		switch {
+		case root == "/home":
+			prefix = "users"
+		case strings.HasPrefix(root, "/shared/") && strings.Count(root, "/") == 2:
+			prefix = strings.TrimPrefix(root, "/")
+		default:
+			return nil, fmt.Errorf("%s: root %q must be /home or /shared/{drive}", uploadStagingEnv, root)
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("%s: root %q is listed twice", uploadStagingEnv, root)
+		}
+		seen[prefix] = true
+
+		if isPathWithinRoot(dir, dataRoot) && filepath.Base(dir) != uploadStagingDirName {
+			return nil, fmt.Errorf("%s: staging directory %s inside the data root must be named %s", uploadStagingEnv, dir, uploadStagingDirName)
+		}
+		roots = append(roots, stagingRoot{Prefix: prefix, Store: filestore.New(dir)})
+	}
+	sort.Slice(roots, func(i, j int) bool { return len(roots[i].Prefix) > len(roots[j].Prefix) })
+	return roots, nil
+}
+
+// UseIn sets the store as the core data store with the filestore extensions
+func (s *StagingStore) UseIn(composer *tusd.StoreComposer) {
+	composer.UseCore(s)
+	composer.UseTerminater(s)
+	composer.UseConcater(s)
+	composer.UseLengthDeferrer(s)
+}
+
+// storeFor returns the store staging uploads into a data-root relative directory
+func (s *StagingStore) storeFor(dest string) filestore.FileStore {
+	for _, root := range s.roots {
+		if dest == root.Prefix || strings.HasPrefix(dest, root.Prefix+"/") {
+			return root.Store
+		}
+	}
+	return s.def
+}
+
+// NewUpload creates the upload in the staging directory of its destination
+func (s *StagingStore) NewUpload(ctx context.Context, info tusd.FileInfo) (tusd.Upload, error) {
+	dest := ""
+	if s.target != nil {
+		dest = s.target(info)
+	}
+	return s.storeFor(dest).NewUpload(ctx, info)
+}
+
+// GetUpload looks the upload up in the default directory, then the roots
+func (s *StagingStore) GetUpload(ctx context.Context, id string) (tusd.Upload, error) {
+	upload, err := s.def.GetUpload(ctx, id)
+	for _, root := range s.roots {
+		if !errors.Is(err, tusd.ErrNotFound) {
+			break
+		}
+		upload, err = root.Store.GetUpload(ctx, id)
+	}
+	return upload, err
+}
+
+// The filestore extensions only type-assert the upload, whichever directory it is in
+
+func (s *StagingStore) AsTerminatableUpload(upload tusd.Upload) tusd.TerminatableUpload {
+	return s.def.AsTerminatableUpload(upload)
+}
+
+func (s *StagingStore) AsLengthDeclarableUpload(upload tusd.Upload) tusd.LengthDeclarableUpload {
+	return s.def.AsLengthDeclarableUpload(upload)
+}
+
+func (s *StagingStore) AsConcatableUpload(upload tusd.Upload) tusd.ConcatableUpload {
+	return s.def.AsConcatableUpload(upload)
+}
+
+// stagedUploadPath returns where a finished upload's data is staged
+func stagedUploadPath(upload tusd.FileInfo, fallbackDir string) string {
+	if p := upload.Storage["Path"]; p != "" {
+		return p
+	}
+	return filepath.Join(fallbackDir, upload.ID)
+}
+
+// sameFilesystem reports whether two existing paths are on the same device
+func sameFilesystem(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	as, aok := ai.Sys().(*syscall.Stat_t)
+	bs, bok := bi.Sys().(*syscall.Stat_t)
+	return aok && bok && as.Dev == bs.Dev
+}
+
+// finalizeUpload moves a staged upload to finalPath. On the same filesystem
+// this is a rename. Across devices the data is copied to a hidden temp name
+// next to finalPath, synced and renamed into place; the staged file is only
+// removed after that, so finalPath never shows a partial file.
+func finalizeUpload(srcPath, finalPath string) error {
+	err := os.Rename(srcPath, finalPath)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	tmpPath, err := copyNextTo(srcPath, finalPath)
+	if err == nil {
+		err = os.Rename(tmpPath, finalPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cross-device copy: %w", err)
+	}
+	syncDir(filepath.Dir(finalPath))
+	os.Remove(srcPath)
+	return nil
+}
+
+// copyNextTo copies a staged upload to a hidden temp name in the folder of
+// finalPath, synced and with the staged file's mode. On error the returned
+// path, if any, is the partial copy to remove.
+func copyNextTo(srcPath, finalPath string) (string, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(finalPath), "."+filepath.Base(finalPath)+".*.upload")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmp.Name()
+	if _, err = io.Copy(tmp, src); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if info, statErr := src.Stat(); err == nil && statErr == nil {
+		err = os.Chmod(tmpPath, info.Mode().Perm())
+	}
+	return tmpPath, err
+}
+
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		_ = f.Sync()
+		f.Close()
+	}
+}
+
+// uploadNameAttempts bounds the "name[n].ext" candidates of an upload
+const uploadNameAttempts = 1000
+
+// uploadNameCandidate returns the n-th name tried for an upload that must
+// not replace anything: finalPath, then "name[1].ext", "name[2].ext", ...
+func uploadNameCandidate(finalPath string, n int) string {
+	if n == 0 {
+		return finalPath
+	}
+	ext := filepath.Ext(finalPath)
+	base := strings.TrimSuffix(filepath.Base(finalPath), ext)
+	return filepath.Join(filepath.Dir(finalPath), fmt.Sprintf("%s[%d]%s", base, n, ext))
+}
+
+// finalizeUploadUnique moves a staged upload to finalPath without replacing
+// anything and returns the path it ended up at. If the name is taken,
+// also by an upload finalizing at the same moment, the candidates of
+// uploadNameCandidate are tried in order, and each goes to exactly one
+// upload. mark is called with a name before it is tried, for the file
+// watcher.
+//
+// A name is claimed with a hard link, which fails if the name exists, and
+// the staged name is removed afterwards. On filesystems without hard links
+// the name is chosen and renamed to under a lock per destination folder,
+// which covers the uploads of this server. Across devices the data is first
+// copied next to finalPath, as in finalizeUpload.
+func finalizeUploadUnique(srcPath, finalPath string, mark func(path string)) (string, error) {
+	staged := srcPath
+	if !sameFilesystem(srcPath, filepath.Dir(finalPath)) {
+		tmpPath, err := copyNextTo(srcPath, finalPath)
+		if err != nil {
+			os.Remove(tmpPath)
+			return "", fmt.Errorf("cross-device copy: %w", err)
+		}
+		staged = tmpPath
+	}
+
+	placed, err := claimUploadName(staged, finalPath, mark)
+	if err != nil {
+		if staged != srcPath {
+			os.Remove(staged)
+		}
+		return "", err
+	}
+	if staged != srcPath {
+		syncDir(filepath.Dir(placed))
+		os.Remove(srcPath)
+	}
+	return placed, nil
+}
+
+// claimUploadName links staged to the first free candidate name and
+// removes staged
+func claimUploadName(staged, finalPath string, mark func(path string)) (string, error) {
+	for n := 0; n < uploadNameAttempts; n++ {
+		candidate := uploadNameCandidate(finalPath, n)
+		mark(candidate)
+		err := os.Link(staged, candidate)
+		if err == nil {
+			if err := os.Remove(staged); err != nil {
+				os.Remove(candidate)
+				return "", err
+			}
+			return candidate, nil
+		}
+		if !os.IsExist(err) {
+			// No hard links on this filesystem
+			return claimUploadNameLocked(staged, finalPath, n, mark)
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", filepath.Base(finalPath))
+}
+
+// claimUploadNameLocked renames staged to the first free candidate name
+// from the n-th on, holding the folder's upload lock
+func claimUploadNameLocked(staged, finalPath string, n int, mark func(path string)) (string, error) {
+	unlock := lockUploadDir(filepath.Dir(finalPath))
+	defer unlock()
+	for ; n < uploadNameAttempts; n++ {
+		candidate := uploadNameCandidate(finalPath, n)
+		if _, err := os.Lstat(candidate); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		mark(candidate)
+		if err := os.Rename(staged, candidate); err != nil {
+			return "", err
+		}
+		return candidate, nil
+	}
+	return "", fmt.Errorf("no free name for %s", filepath.Base(finalPath))
+}
+
+// uploadDirLocks are the folder locks of claimUploadNameLocked, dropped
+// when no upload holds or waits for them
+var uploadDirLocks = struct {
+	sync.Mutex
+	dirs map[string]*uploadDirLock
+}{dirs: make(map[string]*uploadDirLock)}
+
+type uploadDirLock struct {
+	sync.Mutex
+	refs int
+}
+
+func lockUploadDir(dir string) func() {
+	uploadDirLocks.Lock()
+	l := uploadDirLocks.dirs[dir]
+	if l == nil {
+		l = &uploadDirLock{}
+		uploadDirLocks.dirs[dir] = l
+	}
+	l.refs++
+	uploadDirLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		uploadDirLocks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(uploadDirLocks.dirs, dir)
+		}
+		uploadDirLocks.Unlock()
+	}
+}
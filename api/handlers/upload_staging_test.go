@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	tusd "github.com/tus/tusd/v2/pkg/handler"
+)
+
+func TestParseUploadStaging(t *testing.T) {
+	roots, err := parseUploadStaging(" /home=/mnt/a/.uploads, /shared/Archive/=/data/shared/Archive/.uploads ", "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[0].Prefix != "shared/Archive" || roots[1].Prefix != "users" {
+		t.Fatalf("roots = %+v", roots)
+	}
+
+	for _, value := range []string{
+		"/home",                          // No directory
+		"/home=relative/dir",             // Not absolute
+		"/shared=/mnt/a",                 // Shared root itself
+		"/shared/a/b=/mnt/a",             // Below a drive
+		"/home=/mnt/a,/home=/mnt/b",      // Duplicate
+		"/shared/Archive=/data/shared/x", // Visible directory in the data root
+	} {
+		if _, err := parseUploadStaging(value, "/data"); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestStagingStore(t *testing.T) {
+	dataRoot := t.TempDir()
+	archiveDir := filepath.Join(dataRoot, "shared", "Archive", uploadStagingDirName)
+	t.Setenv(uploadStagingEnv, "/shared/Archive="+archiveDir)
+
+	store, err := NewStagingStore(dataRoot, filepath.Join(dataRoot, ".uploads"), func(info tusd.FileInfo) string {
+		return info.MetaData["destPath"]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for dest, dir := range map[string]string{
+		"shared/Archive/2024": archiveDir,
+		"shared/Archived":     filepath.Join(dataRoot, ".uploads"),
+		"users/alice":         filepath.Join(dataRoot, ".uploads"),
+	} {
+		upload, err := store.NewUpload(ctx, tusd.FileInfo{Size: 1, MetaData: tusd.MetaData{"destPath": dest}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, _ := upload.GetInfo(ctx)
+		if filepath.Dir(info.Storage["Path"]) != dir {
+			t.Errorf("%s staged at %s, want %s", dest, info.Storage["Path"], dir)
+		}
+
+		// Found again wherever it was staged
+		found, err := store.GetUpload(ctx, info.ID)
+		if err != nil {
+			t.Fatalf("%s: GetUpload: %v", dest, err)
+		}
+		if _, err := found.WriteChunk(ctx, 0, strings.NewReader("x")); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AsTerminatableUpload(found).Terminate(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.GetUpload(ctx, "missing"); !errors.Is(err, tusd.ErrNotFound) {
+		t.Errorf("GetUpload(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFinalizeUpload(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, ".uploads", "abc")
+	_ = os.MkdirAll(filepath.Dir(src), 0755)
+	_ = os.WriteFile(src, []byte("data"), 0644)
+
+	final := filepath.Join(dir, "a.txt")
+	if err := finalizeUpload(src, final); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(final); string(data) != "data" {
+		t.Errorf("final = %q", data)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("staged file was not moved")
+	}
+}
+
+func TestFinalizeUpload_CrossDevice(t *testing.T) {
+	other, err := os.MkdirTemp("/dev/shm", "staging-")
+	if err != nil {
+		t.Skip("no second filesystem")
+	}
+	defer os.RemoveAll(other)
+	dir := t.TempDir()
+	if sameFilesystem(other, dir) {
+		t.Skip("no second filesystem")
+	}
+
+	src := filepath.Join(other, "abc")
+	_ = os.WriteFile(src, []byte("data"), 0640)
+	final := filepath.Join(dir, "a.txt")
+	if err := finalizeUpload(src, final); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(final)
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("final = %v, %v", info, err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("staged file was not removed after the copy")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+}
+
+// racingUploads finalizes n uploads of report.pdf into the same folder at
+// once and checks that each ended up under its own name, complete, with
+// nothing left behind
+func racingUploads(t *testing.T, stagingDir string, n int, finalize func(src, final string) (string, error)) {
+	t.Helper()
+	dir := t.TempDir()
+	final := filepath.Join(dir, "report.pdf")
+	contents := make(map[string]string, n)
+	srcs := make([]string, n)
+	for i := range srcs {
+		srcs[i] = filepath.Join(stagingDir, fmt.Sprintf("upload-%d", i))
+		contents[srcs[i]] = strings.Repeat(fmt.Sprintf("upload %d;", i), 1000+i)
+		if err := os.WriteFile(srcs[i], []byte(contents[srcs[i]]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	placed := make([]string, n)
+	errs := make([]error, n)
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := range srcs {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			placed[i], errs[i] = finalize(srcs[i], final)
+		}()
+	}
+	start.Done()
+	done.Wait()
+
+	names := make(map[string]bool, n)
+	for i, src := range srcs {
+		if errs[i] != nil {
+			t.Fatalf("upload %d: %v", i, errs[i])
+		}
+		if names[placed[i]] {
+			t.Fatalf("%s placed twice", placed[i])
+		}
+		names[placed[i]] = true
+		if data, _ := os.ReadFile(placed[i]); string(data) != contents[src] {
+			t.Errorf("%s holds %d bytes, want upload %d (%d bytes)", placed[i], len(data), i, len(contents[src]))
+		}
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Errorf("staged upload %d left behind", i)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if !names[uploadNameCandidate(final, i)] {
+			t.Errorf("%s missing", filepath.Base(uploadNameCandidate(final, i)))
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != n {
+		t.Errorf("%d entries, want %d: temp files left behind", len(entries), n)
+	}
+}
+
+func TestFinalizeUploadUnique_Race(t *testing.T) {
+	const n = 32
+	mark := func(string) {}
+
+	// Hard links
+	racingUploads(t, t.TempDir(), n, func(src, final string) (string, error) {
+		return finalizeUploadUnique(src, final, mark)
+	})
+
+	// The folder lock, for filesystems without hard links
+	racingUploads(t, t.TempDir(), n, func(src, final string) (string, error) {
+		return claimUploadNameLocked(src, final, 0, mark)
+	})
+	if len(uploadDirLocks.dirs) != 0 {
+		t.Errorf("folder locks left: %v", uploadDirLocks.dirs)
+	}
+
+	// Across devices
+	other, err := os.MkdirTemp("/dev/shm", "staging-")
+	if err != nil {
+		t.Skip("no second filesystem")
+	}
+	defer os.RemoveAll(other)
+	if sameFilesystem(other, t.TempDir()) {
+		t.Skip("no second filesystem")
+	}
+	racingUploads(t, other, n, func(src, final string) (string, error) {
+		return finalizeUploadUnique(src, final, mark)
+	})
+}
@@ -1,20 +1,30 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/base64"
 	"sync"
 	"time"
 )
 
+// tusClientRetention is how long the IP of a tus upload that neither
+// completes nor expires is kept in the database
+const tusClientRetention = 7 * 24 * time.Hour
+
+// webUploadMarkTTL is how long a web upload mark is kept
+const webUploadMarkTTL = 60 * time.Second
+
 // DecodeBase64 decodes a base64 encoded string
 func DecodeBase64(s string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(s)
 }
 
-// WebUploadTracker tracks files being uploaded via web to distinguish from SMB
+// WebUploadTracker tracks files being uploaded via web to distinguish from SMB.
+// After InitUploadTrackers the marks are also kept in web_upload_marks.
 type WebUploadTracker struct {
 	mu      sync.RWMutex
 	uploads map[string]time.Time
+	db      *sql.DB
 }
 
 // TusUploadInfo stores information about a tus upload
@@ -23,10 +33,13 @@ type TusUploadInfo struct {
 	CreatedAt time.Time
 }
 
-// TusIPTracker tracks client IPs for tus uploads
+// TusIPTracker tracks client IPs for tus uploads. After InitUploadTrackers
+// they are also kept in tus_upload_clients, so uploads completed after a
+// restart still have theirs.
 type TusIPTracker struct {
 	mu      sync.RWMutex
 	uploads map[string]*TusUploadInfo
+	db      *sql.DB
 }
 
 var webUploadTracker = &WebUploadTracker{
@@ -47,37 +60,89 @@ func GetTusIPTracker() *TusIPTracker {
 	return tusIPTracker
 }
 
+// InitUploadTrackers keeps the upload trackers' state in the database and
+// loads the web upload marks left by a restart
+func InitUploadTrackers(db *sql.DB) {
+	tusIPTracker.mu.Lock()
+	tusIPTracker.db = db
+	tusIPTracker.mu.Unlock()
+
+	if err := webUploadTracker.load(db); err != nil {
+		LogError("Failed to load web upload marks", err)
+	}
+}
+
 // StoreIP stores the client IP for an upload ID
 func (t *TusIPTracker) StoreIP(uploadID, clientIP string) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	t.uploads[uploadID] = &TusUploadInfo{
 		ClientIP:  clientIP,
 		CreatedAt: time.Now(),
 	}
+	db := t.db
+	t.mu.Unlock()
+
+	if db != nil {
+		if _, err := db.Exec(`
+			INSERT INTO tus_upload_clients (upload_id, client_ip) VALUES ($1, $2)
+			ON CONFLICT (upload_id) DO UPDATE SET client_ip = EXCLUDED.client_ip
+		`, uploadID, clientIP); err != nil {
+			LogError("Failed to record upload client", err, "upload", uploadID)
+		}
+	}
 }
 
-// GetIP retrieves and removes the client IP for an upload ID
+// GetIP retrieves and removes the client IP for an upload ID. IPs recorded
+// before a restart come from the database.
 func (t *TusIPTracker) GetIP(uploadID string) string {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-	if info, exists := t.uploads[uploadID]; exists {
-		delete(t.uploads, uploadID)
-		return info.ClientIP
+	info, exists := t.uploads[uploadID]
+	delete(t.uploads, uploadID)
+	db := t.db
+	t.mu.Unlock()
+
+	clientIP := ""
+	if exists {
+		clientIP = info.ClientIP
+	}
+	if db != nil {
+		var stored string
+		err := db.QueryRow(`DELETE FROM tus_upload_clients WHERE upload_id = $1 RETURNING client_ip`, uploadID).Scan(&stored)
+		if !exists && err == nil {
+			clientIP = stored
+		}
+	}
+	return clientIP
+}
+
+// Forget removes an upload that will not complete
+func (t *TusIPTracker) Forget(uploadID string) {
+	t.mu.Lock()
+	delete(t.uploads, uploadID)
+	db := t.db
+	t.mu.Unlock()
+
+	if db != nil {
+		_, _ = db.Exec(`DELETE FROM tus_upload_clients WHERE upload_id = $1`, uploadID)
 	}
-	return ""
 }
 
-// Cleanup removes old entries
+// Cleanup removes old entries. The database keeps IPs longer, for uploads
+// resumed after a day.
 func (t *TusIPTracker) Cleanup() {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	now := time.Now()
 	for id, info := range t.uploads {
 		if now.Sub(info.CreatedAt) > 24*time.Hour {
 			delete(t.uploads, id)
 		}
 	}
+	db := t.db
+	t.mu.Unlock()
+
+	if db != nil {
+		_, _ = db.Exec(`DELETE FROM tus_upload_clients WHERE created_at < $1`, now.Add(-tusClientRetention))
+	}
 }
 
 // StartCleanupRoutine starts the cleanup routine for TusIPTracker
@@ -90,18 +155,60 @@ func (t *TusIPTracker) StartCleanupRoutine() {
 	}()
 }
 
+// load keeps the marks in the database and reads back those that have
+// not expired yet
+func (t *WebUploadTracker) load(db *sql.DB) error {
+	cutoff := time.Now().Add(-webUploadMarkTTL)
+	if _, err := db.Exec(`DELETE FROM web_upload_marks WHERE marked_at < $1`, cutoff); err != nil {
+		return err
+	}
+	rows, err := db.Query(`SELECT path, marked_at FROM web_upload_marks`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.db = db
+	for rows.Next() {
+		var path string
+		var markedAt time.Time
+		if err := rows.Scan(&path, &markedAt); err != nil {
+			return err
+		}
+		t.uploads[path] = markedAt
+	}
+	return rows.Err()
+}
+
 // MarkUploading marks a file path as being uploaded via web
 func (t *WebUploadTracker) MarkUploading(path string) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	t.uploads[path] = time.Now()
+	db := t.db
+	t.mu.Unlock()
+
+	if db != nil {
+		if _, err := db.Exec(`
+			INSERT INTO web_upload_marks (path) VALUES ($1)
+			ON CONFLICT (path) DO UPDATE SET marked_at = NOW()
+		`, path); err != nil {
+			LogError("Failed to record web upload", err, "path", path)
+		}
+	}
 }
 
 // UnmarkUploading removes the upload mark
 func (t *WebUploadTracker) UnmarkUploading(path string) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	delete(t.uploads, path)
+	db := t.db
+	t.mu.Unlock()
+
+	if db != nil {
+		_, _ = db.Exec(`DELETE FROM web_upload_marks WHERE path = $1`, path)
+	}
 }
 
 // IsWebUpload checks if a file was recently uploaded via web
@@ -121,14 +228,18 @@ func (t *WebUploadTracker) IsWebUpload(path string) bool {
 // Cleanup removes old entries periodically
 func (t *WebUploadTracker) Cleanup() {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-
 	now := time.Now()
 	for path, uploadTime := range t.uploads {
-		if now.Sub(uploadTime) > 60*time.Second {
+		if now.Sub(uploadTime) > webUploadMarkTTL {
 			delete(t.uploads, path)
 		}
 	}
+	db := t.db
+	t.mu.Unlock()
+
+	if db != nil {
+		_, _ = db.Exec(`DELETE FROM web_upload_marks WHERE marked_at < $1`, now.Add(-webUploadMarkTTL))
+	}
 }
 
 // StartCleanupRoutine starts a goroutine to clean up old entries
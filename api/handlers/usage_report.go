@@ -0,0 +1,669 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Notification type for monthly usage reports
+const (
+	NotifUsageReport = "usage.report"
+)
+
+// settingUsageReportsEnabled turns the monthly usage reports on or off;
+// snapshots are taken either way so growth is known once they are enabled
+const settingUsageReportsEnabled = "usage_reports_enabled"
+
+const (
+	// usageReportTopItems is how many of the largest files and folders a
+	// user report lists
+	usageReportTopItems = 5
+	// usageReportTopUsers is how many users the admin report lists
+	usageReportTopUsers = 10
+)
+
+// UsageItem is a file or top-level folder in a usage report
+type UsageItem struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// UserUsageReport is the monthly usage report of one user
+type UserUsageReport struct {
+	Month          string      `json:"month"` // YYYY-MM
+	Username       string      `json:"username"`
+	Used           int64       `json:"used"`
+	Quota          int64       `json:"quota"`            // 0 = unlimited
+	Growth         *int64      `json:"growth,omitempty"` // Nil without a snapshot to compare with
+	TrashBytes     int64       `json:"trashBytes"`
+	ActiveShares   int         `json:"activeShares"`
+	LargestFiles   []UsageItem `json:"largestFiles"`
+	LargestFolders []UsageItem `json:"largestFolders"`
+}
+
+// UsageSubject is a user or shared drive in the admin usage report
+type UsageSubject struct {
+	Name   string `json:"name"`
+	Used   int64  `json:"used"`
+	Quota  int64  `json:"quota"`
+	Growth *int64 `json:"growth,omitempty"`
+}
+
+// InstanceUsageReport is the monthly usage report for admins
+type InstanceUsageReport struct {
+	Month       string         `json:"month"` // YYYY-MM
+	Users       int            `json:"users"`
+	TotalUsed   int64          `json:"totalUsed"`
+	TotalGrowth *int64         `json:"totalGrowth,omitempty"`
+	TopUsers    []UsageSubject `json:"topUsers"`
+	Drives      []UsageSubject `json:"drives"`
+	DrivesUsed  int64          `json:"drivesUsed"`
+}
+
+// UsageReporter snapshots storage usage at the start of each month and sends
+// the monthly usage reports: to users who opted in, and an instance-wide
+// report to admins. Reports go to the notification center, and by email when
+// SMTP is configured and the user has an address.
+type UsageReporter struct {
+	db                  *sql.DB
+	dataRoot            string
+	notificationService *NotificationService
+}
+
+// NewUsageReporter creates a new UsageReporter
+func NewUsageReporter(db *sql.DB, dataRoot string, notificationService *NotificationService) *UsageReporter {
+	return &UsageReporter{
+		db:                  db,
+		dataRoot:            dataRoot,
+		notificationService: notificationService,
+	}
+}
+
+// StartSchedule runs the usage reports now and then periodically; each month
+// is reported once, by the first check after it starts
+func (r *UsageReporter) StartSchedule(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := r.RunUsageReports(time.Now()); err != nil {
+				log.Printf("[UsageReport] Monthly reports failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+	log.Printf("[UsageReport] Scheduler started (interval: %v)", interval)
+}
+
+// usageMonth returns the first day of the month of t
+func usageMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// snapshotUsage records the usage of every active user and shared drive for
+// month; existing snapshots are kept
+func (r *UsageReporter) snapshotUsage(month time.Time) error {
+	if _, err := r.db.Exec(`
+		INSERT INTO usage_snapshots (month, subject_type, subject_id, bytes_used, quota, trash_bytes)
+		SELECT $1, 'user', id, COALESCE(storage_used, 0), COALESCE(storage_quota, 0), COALESCE(trash_used, 0)
+		FROM users WHERE is_active = TRUE
+		ON CONFLICT (subject_type, subject_id, month) DO NOTHING
+	`, month); err != nil {
+		return fmt.Errorf("user snapshots: %w", err)
+	}
+	if _, err := r.db.Exec(`
+		INSERT INTO usage_snapshots (month, subject_type, subject_id, bytes_used, quota)
+		SELECT $1, 'drive', id, COALESCE(storage_used, 0), COALESCE(storage_quota, 0)
+		FROM shared_folders WHERE is_active = TRUE
+		ON CONFLICT (subject_type, subject_id, month) DO NOTHING
+	`, month); err != nil {
+		return fmt.Errorf("drive snapshots: %w", err)
+	}
+	return nil
+}
+
+// RunUsageReports snapshots usage for the month of now and, the first time
+// it runs in a month, sends the reports for the month before
+func (r *UsageReporter) RunUsageReports(now time.Time) error {
+	month := usageMonth(now)
+	if err := r.snapshotUsage(month); err != nil {
+		return err
+	}
+	if sh := GetGlobalSettingsHandler(); sh != nil && !sh.GetSettingBool(settingUsageReportsEnabled, true) {
+		return nil
+	}
+
+	// Claim the month so it is reported once, also with several API instances
+	var claimed time.Time
+	err := r.db.QueryRow(`
+		INSERT INTO usage_report_runs (month) VALUES ($1)
+		ON CONFLICT (month) DO NOTHING
+		RETURNING month
+	`, month).Scan(&claimed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	reports, emails, runErr := r.sendUsageReports(month.AddDate(0, -1, 0))
+	var errText *string
+	if runErr != nil {
+		text := runErr.Error()
+		errText = &text
+	}
+	if _, err := r.db.Exec(`
+		UPDATE usage_report_runs SET finished_at = NOW(), reports = $2, emails = $3, error = $4
+		WHERE month = $1
+	`, month, reports, emails, errText); err != nil {
+		log.Printf("[UsageReport] Failed to record run: %v", err)
+	}
+	log.Printf("[UsageReport] Sent %d reports for %s (%d by email)", reports, month.AddDate(0, -1, 0).Format("2006-01"), emails)
+	return runErr
+}
+
+// usageReportRecipient is a user receiving a usage report
+type usageReportRecipient struct {
+	id, username, email string
+	isAdmin, optedIn    bool
+}
+
+// sendUsageReports sends the reports for the month starting at reported;
+// growth is measured from its snapshot
+func (r *UsageReporter) sendUsageReports(reported time.Time) (reports, emails int, err error) {
+	rows, err := r.db.Query(`
+		SELECT id, username, COALESCE(email, ''), is_admin, COALESCE(usage_report_enabled, FALSE)
+		FROM users
+		WHERE is_active = TRUE AND (usage_report_enabled = TRUE OR is_admin = TRUE)
+		ORDER BY username
+	`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var recipients []usageReportRecipient
+	for rows.Next() {
+		var u usageReportRecipient
+		if err := rows.Scan(&u.id, &u.username, &u.email, &u.isAdmin, &u.optedIn); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		recipients = append(recipients, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	label := reported.Format("2006-01")
+	pace := GetBackgroundPacer().Begin("usage-report", PacePriorityMaintenance)
+	defer pace.End()
+
+	var instance *InstanceUsageReport
+	var failed []string
+	for _, u := range recipients {
+		if u.optedIn {
+			report, err := r.buildUserReport(u.id, reported, label, pace)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", u.username, err))
+			} else {
+				title, message := userUsageNotification(report)
+				r.notify(u.id, title, message, "/settings", map[string]interface{}{
+					"month": report.Month, "used": report.Used, "quota": report.Quota, "growth": report.Growth,
+				})
+				reports++
+				if r.email(u, title, renderUserUsageText(report), renderUserUsageHTML(report)) {
+					emails++
+				}
+			}
+		}
+		if u.isAdmin {
+			if instance == nil {
+				if instance, err = r.buildInstanceReport(reported, label); err != nil {
+					return reports, emails, err
+				}
+			}
+			title, message := instanceUsageNotification(instance)
+			r.notify(u.id, title, message, "/admin", map[string]interface{}{
+				"month": instance.Month, "totalUsed": instance.TotalUsed, "totalGrowth": instance.TotalGrowth,
+			})
+			reports++
+			if r.email(u, title, renderInstanceUsageText(instance), renderInstanceUsageHTML(instance)) {
+				emails++
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return reports, emails, fmt.Errorf("%d reports failed: %s", len(failed), strings.Join(failed, "; "))
+	}
+	return reports, emails, nil
+}
+
+// notify adds a report to the user's notification center
+func (r *UsageReporter) notify(userID, title, message, link string, metadata map[string]interface{}) {
+	if r.notificationService == nil {
+		return
+	}
+	if _, err := r.notificationService.Create(userID, NotifUsageReport, title, message, link, nil, metadata); err != nil {
+		log.Printf("[UsageReport] Failed to notify user %s: %v", userID, err)
+	}
+}
+
+// email sends a report if SMTP is configured and the user has an address
+func (r *UsageReporter) email(u usageReportRecipient, subject, text, html string) bool {
+	if u.email == "" || !GetMailer().Configured() {
+		return false
+	}
+	if err := GetMailer().Send(Mail{To: u.email, Subject: subject, Text: text, HTML: html}); err != nil {
+		log.Printf("[UsageReport] Failed to email %s: %v", u.username, err)
+		return false
+	}
+	return true
+}
+
+// buildUserReport collects a user's usage; growth is measured from the
+// snapshot of month
+func (r *UsageReporter) buildUserReport(userID string, month time.Time, label string, pace *PaceJob) (*UserUsageReport, error) {
+	report := &UserUsageReport{Month: label}
+	var snapshot sql.NullInt64
+	err := r.db.QueryRow(`
+		SELECT u.username, COALESCE(u.storage_used, 0), COALESCE(u.storage_quota, 0), COALESCE(u.trash_used, 0), s.bytes_used
+		FROM users u
+		LEFT JOIN usage_snapshots s ON s.subject_type = 'user' AND s.subject_id = u.id AND s.month = $2
+		WHERE u.id = $1
+	`, userID, month).Scan(&report.Username, &report.Used, &report.Quota, &report.TrashBytes, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Valid {
+		growth := report.Used - snapshot.Int64
+		report.Growth = &growth
+	}
+
+	// Link shares and user-to-user shares that still grant access
+	if err := r.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM shares
+			 WHERE created_by = $1 AND is_active = TRUE AND (expires_at IS NULL OR expires_at > NOW()))
+			+
+			(SELECT COUNT(*) FROM file_shares
+			 WHERE owner_id = $1 AND status IN ('pending', 'accepted') AND (expires_at IS NULL OR expires_at > NOW()))
+	`, userID).Scan(&report.ActiveShares); err != nil {
+		return nil, err
+	}
+
+	home := GetStorageLocations().Map(filepath.Join(r.dataRoot, "users", report.Username))
+	report.LargestFiles, report.LargestFolders = largestUsageItems(home, usageReportTopItems, pace)
+	return report, nil
+}
+
+// largestUsageItems walks a home folder for its largest files and top-level
+// folders, skipping the trash. Paths are virtual (/home/...).
+func largestUsageItems(home string, n int, pace *PaceJob) (files, folders []UsageItem) {
+	files = []UsageItem{}
+	folderSizes := make(map[string]int64)
+	_ = filepath.WalkDir(home, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".trash" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		pace.Pace()
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(home, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if top, _, nested := strings.Cut(rel, "/"); nested {
+			folderSizes[top] += info.Size()
+		}
+		files = insertLargest(files, UsageItem{Path: "/home/" + rel, Size: info.Size()}, n)
+		return nil
+	})
+
+	folders = []UsageItem{}
+	for name, size := range folderSizes {
+		folders = insertLargest(folders, UsageItem{Path: "/home/" + name, Size: size}, n)
+	}
+	return files, folders
+}
+
+// insertLargest adds item to items, kept sorted by size (largest first) and
+// at most n long
+func insertLargest(items []UsageItem, item UsageItem, n int) []UsageItem {
+	i := sort.Search(len(items), func(i int) bool {
+		if items[i].Size == item.Size {
+			return items[i].Path > item.Path
+		}
+		return items[i].Size < item.Size
+	})
+	if i >= n {
+		return items
+	}
+	items = append(items, UsageItem{})
+	copy(items[i+1:], items[i:])
+	items[i] = item
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+// buildInstanceReport collects usage of all users and shared drives; growth
+// is measured from the snapshot of month
+func (r *UsageReporter) buildInstanceReport(month time.Time, label string) (*InstanceUsageReport, error) {
+	report := &InstanceUsageReport{Month: label, TopUsers: []UsageSubject{}, Drives: []UsageSubject{}}
+
+	var snapshotTotal int64
+	var snapshots int
+	if err := r.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE is_active = TRUE),
+			(SELECT COALESCE(SUM(storage_used), 0) FROM users WHERE is_active = TRUE),
+			(SELECT COUNT(*) FROM usage_snapshots WHERE subject_type = 'user' AND month = $1),
+			(SELECT COALESCE(SUM(bytes_used), 0) FROM usage_snapshots WHERE subject_type = 'user' AND month = $1)
+	`, month).Scan(&report.Users, &report.TotalUsed, &snapshots, &snapshotTotal); err != nil {
+		return nil, err
+	}
+	if snapshots > 0 {
+		growth := report.TotalUsed - snapshotTotal
+		report.TotalGrowth = &growth
+	}
+
+	var err error
+	if report.TopUsers, err = r.usageSubjects(`
+		SELECT u.username, COALESCE(u.storage_used, 0), COALESCE(u.storage_quota, 0), s.bytes_used
+		FROM users u
+		LEFT JOIN usage_snapshots s ON s.subject_type = 'user' AND s.subject_id = u.id AND s.month = $1
+		WHERE u.is_active = TRUE
+		ORDER BY u.storage_used DESC NULLS LAST, u.username
+		LIMIT $2
+	`, month, usageReportTopUsers); err != nil {
+		return nil, err
+	}
+	if report.Drives, err = r.usageSubjects(`
+		SELECT f.name, COALESCE(f.storage_used, 0), COALESCE(f.storage_quota, 0), s.bytes_used
+		FROM shared_folders f
+		LEFT JOIN usage_snapshots s ON s.subject_type = 'drive' AND s.subject_id = f.id AND s.month = $1
+		WHERE f.is_active = TRUE
+		ORDER BY f.storage_used DESC NULLS LAST, f.name
+	`, month); err != nil {
+		return nil, err
+	}
+	for _, d := range report.Drives {
+		report.DrivesUsed += d.Used
+	}
+	return report, nil
+}
+
+// usageSubjects scans name, used, quota and snapshot rows
+func (r *UsageReporter) usageSubjects(query string, args ...interface{}) ([]UsageSubject, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	subjects := []UsageSubject{}
+	for rows.Next() {
+		var s UsageSubject
+		var snapshot sql.NullInt64
+		if err := rows.Scan(&s.Name, &s.Used, &s.Quota, &snapshot); err != nil {
+			return nil, err
+		}
+		if snapshot.Valid {
+			growth := s.Used - snapshot.Int64
+			s.Growth = &growth
+		}
+		subjects = append(subjects, s)
+	}
+	return subjects, rows.Err()
+}
+
+// usageReportMonthTitle formats a YYYY-MM month for report titles
+func usageReportMonthTitle(month string) string {
+	t, err := time.Parse("2006-01", month)
+	if err != nil {
+		return month
+	}
+	return fmt.Sprintf("%d년 %d월", t.Year(), int(t.Month()))
+}
+
+// formatUsageQuota formats a quota; 0 is unlimited
+func formatUsageQuota(quota int64) string {
+	if quota <= 0 {
+		return "무제한"
+	}
+	return formatBytes(quota)
+}
+
+// formatUsageGrowth formats growth with its sign, or "-" without a snapshot
+func formatUsageGrowth(growth *int64) string {
+	switch {
+	case growth == nil:
+		return "-"
+	case *growth < 0:
+		return "-" + formatBytes(-*growth)
+	default:
+		return "+" + formatBytes(*growth)
+	}
+}
+
+// userUsageNotification returns the title and message of a user report
+func userUsageNotification(r *UserUsageReport) (string, string) {
+	title := usageReportMonthTitle(r.Month) + " 사용량 리포트"
+	message := fmt.Sprintf("사용량 %s / %s (%s), 휴지통 %s, 활성 공유 %d개",
+		formatBytes(r.Used), formatUsageQuota(r.Quota), formatUsageGrowth(r.Growth), formatBytes(r.TrashBytes), r.ActiveShares)
+	return title, message
+}
+
+// instanceUsageNotification returns the title and message of an admin report
+func instanceUsageNotification(r *InstanceUsageReport) (string, string) {
+	title := usageReportMonthTitle(r.Month) + " 전체 사용량 리포트"
+	message := fmt.Sprintf("사용자 %d명 %s (%s), 공유 드라이브 %d개 %s",
+		r.Users, formatBytes(r.TotalUsed), formatUsageGrowth(r.TotalGrowth), len(r.Drives), formatBytes(r.DrivesUsed))
+	return title, message
+}
+
+// renderUserUsageText renders the plain text email of a user report
+func renderUserUsageText(r *UserUsageReport) string {
+	var b strings.Builder
+	title, _ := userUsageNotification(r)
+	fmt.Fprintf(&b, "%s (%s)\n\n", title, r.Username)
+	fmt.Fprintf(&b, "사용량: %s / %s\n", formatBytes(r.Used), formatUsageQuota(r.Quota))
+	fmt.Fprintf(&b, "지난달 대비: %s\n", formatUsageGrowth(r.Growth))
+	fmt.Fprintf(&b, "휴지통: %s\n", formatBytes(r.TrashBytes))
+	fmt.Fprintf(&b, "활성 공유: %d개\n", r.ActiveShares)
+	for _, section := range []struct {
+		title string
+		items []UsageItem
+	}{{"가장 큰 파일", r.LargestFiles}, {"가장 큰 폴더", r.LargestFolders}} {
+		if len(section.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s:\n", section.title)
+		for _, item := range section.items {
+			fmt.Fprintf(&b, "  %s  %s\n", formatBytes(item.Size), item.Path)
+		}
+	}
+	return b.String()
+}
+
+// renderInstanceUsageText renders the plain text email of an admin report
+func renderInstanceUsageText(r *InstanceUsageReport) string {
+	var b strings.Builder
+	title, _ := instanceUsageNotification(r)
+	fmt.Fprintf(&b, "%s\n\n", title)
+	fmt.Fprintf(&b, "사용자: %d명, %s (%s)\n", r.Users, formatBytes(r.TotalUsed), formatUsageGrowth(r.TotalGrowth))
+	fmt.Fprintf(&b, "공유 드라이브: %d개, %s\n", len(r.Drives), formatBytes(r.DrivesUsed))
+	for _, section := range []struct {
+		title    string
+		subjects []UsageSubject
+	}{{"사용량 상위 사용자", r.TopUsers}, {"공유 드라이브", r.Drives}} {
+		if len(section.subjects) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s:\n", section.title)
+		for _, s := range section.subjects {
+			fmt.Fprintf(&b, "  %s  %s / %s (%s)\n", s.Name, formatBytes(s.Used), formatUsageQuota(s.Quota), formatUsageGrowth(s.Growth))
+		}
+	}
+	return b.String()
+}
+
+var usageReportFuncs = template.FuncMap{
+	"bytes":  formatBytes,
+	"quota":  formatUsageQuota,
+	"growth": formatUsageGrowth,
+}
+
+var userUsageHTML = template.Must(template.New("user").Funcs(usageReportFuncs).Parse(`<html><body style="font-family:sans-serif">
+<h2>{{.Title}}</h2>
+<p>{{.Report.Username}}</p>
+<table cellpadding="4">
+<tr><td>사용량</td><td>{{bytes .Report.Used}} / {{quota .Report.Quota}}</td></tr>
+<tr><td>지난달 대비</td><td>{{growth .Report.Growth}}</td></tr>
+<tr><td>휴지통</td><td>{{bytes .Report.TrashBytes}}</td></tr>
+<tr><td>활성 공유</td><td>{{.Report.ActiveShares}}개</td></tr>
+</table>
+{{if .Report.LargestFiles}}<h3>가장 큰 파일</h3><table cellpadding="4">{{range .Report.LargestFiles}}<tr><td>{{bytes .Size}}</td><td>{{.Path}}</td></tr>{{end}}</table>{{end}}
+{{if .Report.LargestFolders}}<h3>가장 큰 폴더</h3><table cellpadding="4">{{range .Report.LargestFolders}}<tr><td>{{bytes .Size}}</td><td>{{.Path}}</td></tr>{{end}}</table>{{end}}
+</body></html>`))
+
+var instanceUsageHTML = template.Must(template.New("instance").Funcs(usageReportFuncs).Parse(`<html><body style="font-family:sans-serif">
+<h2>{{.Title}}</h2>
+<table cellpadding="4">
+<tr><td>사용자</td><td>{{.Report.Users}}명, {{bytes .Report.TotalUsed}} ({{growth .Report.TotalGrowth}})</td></tr>
+<tr><td>공유 드라이브</td><td>{{len .Report.Drives}}개, {{bytes .Report.DrivesUsed}}</td></tr>
+</table>
+{{if .Report.TopUsers}}<h3>사용량 상위 사용자</h3><table cellpadding="4">{{range .Report.TopUsers}}<tr><td>{{.Name}}</td><td>{{bytes .Used}} / {{quota .Quota}}</td><td>{{growth .Growth}}</td></tr>{{end}}</table>{{end}}
+{{if .Report.Drives}}<h3>공유 드라이브</h3><table cellpadding="4">{{range .Report.Drives}}<tr><td>{{.Name}}</td><td>{{bytes .Used}} / {{quota .Quota}}</td><td>{{growth .Growth}}</td></tr>{{end}}</table>{{end}}
+</body></html>`))
+
+// renderUserUsageHTML renders the HTML email of a user report
+func renderUserUsageHTML(r *UserUsageReport) string {
+	title, _ := userUsageNotification(r)
+	var b bytes.Buffer
+	if err := userUsageHTML.Execute(&b, map[string]interface{}{"Title": title, "Report": r}); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// renderInstanceUsageHTML renders the HTML email of an admin report
+func renderInstanceUsageHTML(r *InstanceUsageReport) string {
+	title, _ := instanceUsageNotification(r)
+	var b bytes.Buffer
+	if err := instanceUsageHTML.Execute(&b, map[string]interface{}{"Title": title, "Report": r}); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+// GetUsageReport previews the current user's usage report for this month
+// @Summary		Usage report preview
+// @Description	Returns the current user's usage, growth this month, trash size, active shares and largest items, and whether the monthly report is enabled
+// @Tags		Users
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Usage report"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/usage-report [get]
+func (r *UsageReporter) GetUsageReport(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+
+	var enabled bool
+	if err := r.db.QueryRow(`SELECT COALESCE(usage_report_enabled, FALSE) FROM users WHERE id = $1`, claims.UserID).Scan(&enabled); err != nil {
+		return RespondError(c, ErrInternal("Failed to load usage report settings"))
+	}
+
+	pace := GetBackgroundPacer().Begin("usage-report", PacePriorityUser)
+	defer pace.End()
+	now := time.Now()
+	report, err := r.buildUserReport(claims.UserID, usageMonth(now), now.Format("2006-01"), pace)
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to build usage report"))
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"enabled":      enabled,
+		"emailEnabled": GetMailer().Configured(),
+		"report":       report,
+	})
+}
+
+// UsageReportSettingsRequest is the request body for opting in to the monthly usage report
+type UsageReportSettingsRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// UpdateUsageReportSettings opts the current user in to or out of the monthly usage report
+// @Summary		Usage report opt-in
+// @Description	Enables or disables the monthly usage report for the current user
+// @Tags		Users
+// @Accept		json
+// @Produce		json
+// @Param		request	body		UsageReportSettingsRequest	true	"Settings"
+// @Success		200		{object}	docs.SuccessResponse	"Updated"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid request"
+// @Security	BearerAuth
+// @Router		/usage-report [put]
+func (r *UsageReporter) UpdateUsageReportSettings(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	var req UsageReportSettingsRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	if _, err := r.db.Exec(`UPDATE users SET usage_report_enabled = $1, updated_at = NOW() WHERE id = $2`, req.Enabled, claims.UserID); err != nil {
+		return RespondError(c, ErrInternal("Failed to update usage report settings"))
+	}
+	return RespondSuccess(c, map[string]interface{}{"enabled": req.Enabled})
+}
+
+// GetInstanceUsageReport previews the admin usage report for this month
+// @Summary		Instance usage report preview
+// @Description	Returns total usage and growth this month, the top users and shared drive usage
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Usage report"
+// @Failure		403		{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/usage-report [get]
+func (r *UsageReporter) GetInstanceUsageReport(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermStorageAdmin)
+	if claims == nil {
+		return err // Error response already written
+	}
+	now := time.Now()
+	report, err := r.buildInstanceReport(usageMonth(now), now.Format("2006-01"))
+	if err != nil {
+		return RespondError(c, ErrInternal("Failed to build usage report"))
+	}
+	return RespondSuccess(c, report)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLargestUsageItems(t *testing.T) {
+	home := t.TempDir()
+	for path, size := range map[string]int{
+		"big.iso":           500,
+		"docs/a.pdf":        300,
+		"docs/b.pdf":        250,
+		"photos/2025/x.jpg": 400,
+		"notes.txt":         10,
+		".trash/old.bin":    1000,
+	} {
+		_ = os.MkdirAll(filepath.Join(home, filepath.Dir(path)), 0755)
+		_ = os.WriteFile(filepath.Join(home, path), make([]byte, size), 0644)
+	}
+
+	files, folders := largestUsageItems(home, 3, nil)
+	want := []UsageItem{{"/home/big.iso", 500}, {"/home/photos/2025/x.jpg", 400}, {"/home/docs/a.pdf", 300}}
+	if len(files) != len(want) {
+		t.Fatalf("files = %v, want %v", files, want)
+	}
+	for i := range want {
+		if files[i] != want[i] {
+			t.Errorf("files[%d] = %v, want %v", i, files[i], want[i])
+		}
+	}
+	if len(folders) != 2 || folders[0] != (UsageItem{"/home/docs", 550}) || folders[1] != (UsageItem{"/home/photos", 400}) {
+		t.Errorf("folders = %v", folders)
+	}
+}
+
+func TestBuildMail(t *testing.T) {
+	msg := string(buildMail("FileHatch <fh@example.com>", "alice@example.com", Mail{
+		Subject: "2026년 9월 사용량 리포트",
+		Text:    "사용량: 3 GB",
+		HTML:    "<p>사용량</p>",
+	}))
+	if !strings.Contains(msg, "Subject: =?utf-8?q?") {
+		t.Errorf("subject is not encoded:\n%s", msg)
+	}
+	for _, part := range []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"} {
+		if !strings.Contains(msg, part) {
+			t.Errorf("missing %s part", part)
+		}
+	}
+	if !strings.Contains(msg, base64.StdEncoding.EncodeToString([]byte("사용량: 3 GB"))) {
+		t.Error("text part is not base64 encoded")
+	}
+}
+
+// useTestMailer installs a configured mailer that records sent messages
+func useTestMailer(t *testing.T) *[]string {
+	t.Helper()
+	sent := &[]string{}
+	previous := globalMailer
+	globalMailer = &Mailer{host: "smtp.example.com", port: "587", from: "fh@example.com",
+		send: func(from string, to []string, msg []byte) error {
+			*sent = append(*sent, to[0])
+			return nil
+		}}
+	t.Cleanup(func() { globalMailer = previous })
+	return sent
+}
+
+func TestRunUsageReports(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	dataRoot := t.TempDir()
+	home := filepath.Join(dataRoot, "users", "alice")
+	_ = os.MkdirAll(filepath.Join(home, "docs"), 0755)
+	_ = os.WriteFile(filepath.Join(home, "docs", "report.pdf"), make([]byte, 64), 0644)
+	sent := useTestMailer(t)
+	r := NewUsageReporter(tc.DB, dataRoot, NewNotificationService(tc.DB))
+
+	now := time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC)
+	month := usageMonth(now)
+	previous := month.AddDate(0, -1, 0)
+
+	tc.Mock.ExpectExec("INSERT INTO usage_snapshots .* 'user'").WithArgs(month).WillReturnResult(sqlmock.NewResult(0, 2))
+	tc.Mock.ExpectExec("INSERT INTO usage_snapshots .* 'drive'").WithArgs(month).WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectQuery("INSERT INTO usage_report_runs").WithArgs(month).
+		WillReturnRows(sqlmock.NewRows([]string{"month"}).AddRow(month))
+	tc.Mock.ExpectQuery("SELECT id, username").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "is_admin", "usage_report_enabled"}).
+			AddRow("u-alice", "alice", "alice@example.com", false, true).
+			AddRow("u-admin", "admin", "", true, false))
+
+	// alice's report, growth measured from September's snapshot
+	tc.Mock.ExpectQuery("SELECT u.username").WithArgs("u-alice", previous).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "storage_used", "storage_quota", "trash_used", "bytes_used"}).
+			AddRow("alice", 3000, 10000, 100, 1000))
+	tc.Mock.ExpectQuery("FROM shares").WithArgs("u-alice").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	tc.Mock.ExpectQuery("INSERT INTO notifications").
+		WithArgs("u-alice", NotifUsageReport, "2026년 9월 사용량 리포트", sqlmock.AnyArg(), "/settings", nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+	tc.Mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	// Admin report
+	tc.Mock.ExpectQuery(regexp.QuoteMeta("SELECT\n\t\t\t(SELECT COUNT(*) FROM users")).WithArgs(previous).
+		WillReturnRows(sqlmock.NewRows([]string{"users", "used", "snapshots", "snapshot_used"}).AddRow(2, 5000, 2, 4000))
+	tc.Mock.ExpectQuery("FROM users u").WithArgs(previous, usageReportTopUsers).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "storage_used", "storage_quota", "bytes_used"}).
+			AddRow("alice", 3000, 10000, 1000).AddRow("admin", 2000, 0, nil))
+	tc.Mock.ExpectQuery("FROM shared_folders f").WithArgs(previous).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "storage_used", "storage_quota", "bytes_used"}).AddRow("team", 700, 0, 500))
+	tc.Mock.ExpectQuery("INSERT INTO notifications").
+		WithArgs("u-admin", NotifUsageReport, "2026년 9월 전체 사용량 리포트", sqlmock.AnyArg(), "/admin", nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(2, now))
+	tc.Mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	tc.Mock.ExpectExec("UPDATE usage_report_runs").WithArgs(month, 2, 1, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := r.RunUsageReports(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	// The admin has no address, so only alice is emailed
+	if len(*sent) != 1 || (*sent)[0] != "alice@example.com" {
+		t.Errorf("emails sent to %v", *sent)
+	}
+}
+
+func TestRunUsageReports_MonthAlreadyReported(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	sent := useTestMailer(t)
+	r := NewUsageReporter(tc.DB, t.TempDir(), nil)
+
+	tc.Mock.ExpectExec("INSERT INTO usage_snapshots").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectExec("INSERT INTO usage_snapshots").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectQuery("INSERT INTO usage_report_runs").WillReturnError(sql.ErrNoRows)
+
+	if err := r.RunUsageReports(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*sent) != 0 {
+		t.Errorf("emails sent to %v", *sent)
+	}
+}
+
+func TestUserUsageNotification(t *testing.T) {
+	growth := int64(-2048)
+	title, message := userUsageNotification(&UserUsageReport{
+		Month: "2026-09", Used: 1024, Growth: &growth, TrashBytes: 0, ActiveShares: 3,
+	})
+	if title != "2026년 9월 사용량 리포트" {
+		t.Errorf("title = %q", title)
+	}
+	if message != "사용량 1.00 KB / 무제한 (-2.00 KB), 휴지통 0 B, 활성 공유 3개" {
+		t.Errorf("message = %q", message)
+	}
+}
@@ -0,0 +1,574 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GDPR erasure settings
+const (
+	settingErasureHoldDays    = "gdpr_erasure_hold_days"
+	settingErasureTruncateIPs = "gdpr_erasure_truncate_ips"
+)
+
+// auditDetailWhitelist lists the details fields kept in the audit rows of an
+// erased user, by event type or category ("file."); "" applies to all.
+// Other fields are removed. Kept strings still have the user's identity
+// replaced by the pseudonym.
+var auditDetailWhitelist = map[string][]string{
+	"": {
+		"action", "bytes", "dirs", "duration", "editable", "encrypted", "expiresAt",
+		"files", "force", "format", "hasPassword", "isDir", "isFolder", "items",
+		"jobId", "kind", "lockType", "maxAccess", "method", "mode", "operation",
+		"permission", "permissionLevel", "policyId", "requireLogin", "rows",
+		"shareId", "shareType", "size", "source", "status", "strategy", "success",
+		"trashId",
+	},
+	"file.":   {"fileName", "fileType", "newName", "newPath", "oldPath", "sourcePath", "destination"},
+	"folder.": {"newPath", "oldPath"},
+	"trash.":  {"restoredPath"},
+}
+
+// ErasureReport is what an erasure pseudonymized, kept for the DPO's records
+type ErasureReport struct {
+	AuditRows         int64            `json:"auditRows"`         // Rows the user acted in
+	AuditMentions     int64            `json:"auditMentions"`     // Other rows naming the user or their home folder
+	IPsTruncated      int64            `json:"ipsTruncated"`      // In rows the user acted in
+	ScrubbedFields    map[string]int64 `json:"scrubbedFields"`    // Details fields removed, by field
+	Shares            int64            `json:"shares"`            // Link shares pseudonymized and deactivated
+	FileSharesRemoved int64            `json:"fileSharesRemoved"` // Removed with the user
+}
+
+// UserErasure is the record of an erased user. UserID, Username and Email
+// map the pseudonym back to the user until the hold ends.
+type UserErasure struct {
+	ID          string        `json:"id"`
+	Pseudonym   string        `json:"pseudonym"`
+	UserID      *string       `json:"userId,omitempty"`
+	Username    *string       `json:"username,omitempty"`
+	Email       *string       `json:"email,omitempty"`
+	TruncateIPs bool          `json:"truncateIps"`
+	Report      ErasureReport `json:"report"`
+	RequestedBy *string       `json:"requestedBy,omitempty"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	HoldUntil   time.Time     `json:"holdUntil"`
+	PurgedAt    *time.Time    `json:"purgedAt,omitempty"`
+	ReversedBy  *string       `json:"reversedBy,omitempty"`
+	ReversedAt  *time.Time    `json:"reversedAt,omitempty"`
+	Status      string        `json:"status"` // held, purged or reversed
+}
+
+// erasureIdentity is the user being erased and their pseudonym
+type erasureIdentity struct {
+	userID, username, email, pseudonym string
+}
+
+// scrub replaces the user's identity in a string: their ID and email
+// anywhere, their username as a whole value or their home folder in paths
+func (id erasureIdentity) scrub(s string) string {
+	home := "/users/" + id.username
+	switch {
+	case s == home || strings.HasPrefix(s, home+"/"):
+		s = "/users/" + id.pseudonym + strings.TrimPrefix(s, home)
+	case s == id.username:
+		return id.pseudonym
+	}
+	s = strings.ReplaceAll(s, id.userID, id.pseudonym)
+	if id.email != "" {
+		s = replaceFold(s, id.email, id.pseudonym)
+	}
+	return s
+}
+
+// scrubValue applies scrub to every string in a details value
+func (id erasureIdentity) scrubValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return id.scrub(v)
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = id.scrubValue(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = id.scrubValue(e)
+		}
+	}
+	return v
+}
+
+// replaceFold replaces old in s case-insensitively
+func replaceFold(s, old, new string) string {
+	lower, oldLower := strings.ToLower(s), strings.ToLower(old)
+	if len(lower) != len(s) || !strings.Contains(lower, oldLower) {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(lower, oldLower)
+		if i < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		b.WriteString(new)
+		s, lower = s[i+len(old):], lower[i+len(old):]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// whitelistedDetail reports whether an erased actor's row keeps a field
+func whitelistedDetail(eventType, field string) bool {
+	keys := [][]string{auditDetailWhitelist[""], auditDetailWhitelist[eventType]}
+	if i := strings.Index(eventType, "."); i >= 0 {
+		keys = append(keys, auditDetailWhitelist[eventType[:i+1]])
+	}
+	for _, list := range keys {
+		for _, k := range list {
+			if k == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// truncateIP keeps the /24 of an IPv4 and the /48 of an IPv6 address
+func truncateIP(ip string) (string, bool) {
+	prefix, err := netip.ParsePrefix(ip)
+	if err != nil {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return ip, false
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	bits := 48
+	if prefix.Addr().Is4() {
+		bits = 24
+	}
+	if prefix.Bits() <= bits {
+		return ip, false
+	}
+	truncated, err := prefix.Addr().Prefix(bits)
+	if err != nil {
+		return ip, false
+	}
+	return truncated.String(), true
+}
+
+// erasureAuditRow is an audit row naming the erased user
+type erasureAuditRow struct {
+	id        int64
+	actorID   sql.NullString
+	ip        sql.NullString
+	eventType string
+	target    sql.NullString
+	details   []byte
+}
+
+// eraseUser pseudonymizes a user in the audit log and their link shares
+// within a destructive run, keeping the originals for holdDays so the
+// erasure can be reversed. Call it after the user's dependents are counted
+// and before the user is deleted.
+func eraseUser(run *destructiveRun, userID, username, email, requestedBy string, truncateIPs bool, holdDays int) (*UserErasure, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	id := erasureIdentity{userID: userID, username: username, email: email, pseudonym: "anon-" + hex.EncodeToString(token)}
+	e := &UserErasure{Pseudonym: id.pseudonym, UserID: &userID, Username: &username, TruncateIPs: truncateIPs,
+		RequestedBy: &requestedBy, Status: "held"}
+	if email != "" {
+		e.Email = &email
+	}
+	e.Report.ScrubbedFields = make(map[string]int64)
+
+	err := run.QueryRow(`
+		INSERT INTO user_erasures (pseudonym, user_id, username, email, truncate_ips, requested_by, hold_until)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NOW() + make_interval(days => $7))
+		RETURNING id, created_at, hold_until
+	`, id.pseudonym, userID, username, email, truncateIPs, requestedBy, holdDays).Scan(&e.ID, &e.CreatedAt, &e.HoldUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows the user acted in, rows on their home folder and rows naming them
+	emailValue := email
+	if emailValue == "" {
+		emailValue = userID
+	}
+	rows, err := run.Query(`
+		SELECT id, actor_id::text, ip_addr::text, event_type, target_resource, details
+		FROM audit_logs
+		WHERE actor_id = $1
+		   OR target_resource = $2 OR target_resource LIKE $3 ESCAPE '\'
+		   OR jsonb_path_exists(details, '$.** ? (@ == $id || @ == $name || @ == $email)',
+		                        jsonb_build_object('id', $1::text, 'name', $4::text, 'email', $5::text))
+	`, userID, "/users/"+username, escapeLikePattern("/users/"+username+"/")+"%", username, emailValue)
+	if err != nil {
+		return nil, err
+	}
+	var auditRows []erasureAuditRow
+	for rows.Next() {
+		var r erasureAuditRow
+		if err := rows.Scan(&r.id, &r.actorID, &r.ip, &r.eventType, &r.target, &r.details); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		auditRows = append(auditRows, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range auditRows {
+		actor := r.actorID.Valid && r.actorID.String == userID
+		original := map[string]interface{}{"actorId": nullable(r.actorID), "ip": nullable(r.ip), "target": nullable(r.target), "details": json.RawMessage("null")}
+		if len(r.details) > 0 {
+			original["details"] = json.RawMessage(r.details)
+		}
+
+		var details map[string]interface{}
+		if len(r.details) > 0 {
+			_ = json.Unmarshal(r.details, &details)
+		}
+		for field, value := range details {
+			if actor && !whitelistedDetail(r.eventType, field) {
+				delete(details, field)
+				e.Report.ScrubbedFields[field]++
+				continue
+			}
+			details[field] = id.scrubValue(value)
+		}
+		var detailsJSON []byte
+		if details != nil {
+			detailsJSON, _ = json.Marshal(details)
+		} else if len(r.details) > 0 {
+			detailsJSON = r.details // Not an object; left as it was
+		}
+
+		target := r.target
+		if target.Valid {
+			target.String = id.scrub(target.String)
+		}
+		actorID, pseudonym, ip := r.actorID, sql.NullString{}, r.ip
+		if actor {
+			actorID, pseudonym = sql.NullString{}, sql.NullString{String: id.pseudonym, Valid: true}
+			if truncateIPs && ip.Valid {
+				if truncated, ok := truncateIP(ip.String); ok {
+					ip.String = truncated
+					e.Report.IPsTruncated++
+				}
+			}
+			e.Report.AuditRows++
+		} else {
+			e.Report.AuditMentions++
+		}
+
+		originalJSON, _ := json.Marshal(original)
+		if _, err := run.Exec("audit_logs", `
+			WITH saved AS (
+				INSERT INTO user_erasure_rows (erasure_id, table_name, row_id, original)
+				VALUES ($1, 'audit_logs', $2, $3)
+			)
+			UPDATE audit_logs
+			SET actor_id = $4::uuid, actor_pseudonym = $5, ip_addr = $6::inet, target_resource = $7, details = $8::jsonb
+			WHERE id = $9
+		`, e.ID, r.id, originalJSON, actorID, pseudonym, ip, target, nullJSON(detailsJSON), r.id); err != nil {
+			return nil, err
+		}
+	}
+
+	// Link shares keep their history under the pseudonym and stop working
+	e.Report.Shares, err = run.Exec("shares", `
+		WITH saved AS (
+			INSERT INTO user_erasure_rows (erasure_id, table_name, row_id, original)
+			SELECT $1, 'shares', id::text, jsonb_build_object('createdBy', created_by, 'isActive', is_active)
+			FROM shares WHERE created_by = $2
+		)
+		UPDATE shares SET created_by = NULL, created_by_pseudonym = $3, is_active = FALSE
+		WHERE created_by = $2
+	`, e.ID, userID, id.pseudonym)
+	if err != nil {
+		return nil, err
+	}
+	e.Report.FileSharesRemoved = run.result.Rows["file_shares"]
+
+	report, _ := json.Marshal(e.Report)
+	if _, err := run.Exec("user_erasures", "UPDATE user_erasures SET report = $2 WHERE id = $1", e.ID, report); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// nullable returns a JSON value for a nullable column
+func nullable(s sql.NullString) interface{} {
+	if !s.Valid {
+		return nil
+	}
+	return s.String
+}
+
+// nullJSON passes empty JSON as NULL
+func nullJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+// erasureSettings returns the hold in days and whether IPs are truncated;
+// truncateIPs=true/false in the request overrides the setting
+func erasureSettings(c echo.Context) (int, bool) {
+	holdDays, truncate := 30, true
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		holdDays = sh.GetSettingInt(settingErasureHoldDays, 30)
+		truncate = sh.GetSettingBool(settingErasureTruncateIPs, true)
+	}
+	if holdDays < 0 {
+		holdDays = 0
+	}
+	switch c.QueryParam("truncateIps") {
+	case "true":
+		truncate = true
+	case "false":
+		truncate = false
+	}
+	return holdDays, truncate
+}
+
+// PurgeUserErasures drops the mapping of erasures whose hold has ended, so
+// they can no longer be reversed
+func PurgeUserErasures(db *sql.DB) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM user_erasure_rows WHERE erasure_id IN (
+			SELECT id FROM user_erasures
+			WHERE purged_at IS NULL AND reversed_at IS NULL AND hold_until <= NOW()
+		)
+	`); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`
+		UPDATE user_erasures SET purged_at = NOW(), user_id = NULL, username = NULL, email = NULL
+		WHERE purged_at IS NULL AND reversed_at IS NULL AND hold_until <= NOW()
+	`)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return n, tx.Commit()
+}
+
+// StartUserErasurePurge purges expired erasure mappings periodically
+func StartUserErasurePurge(db *sql.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if n, err := PurgeUserErasures(db); err != nil {
+				log.Printf("[UserErasure] Purge failed: %v", err)
+			} else if n > 0 {
+				log.Printf("[UserErasure] Purged the mapping of %d erasures", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// scanUserErasure scans a user_erasures row selected with userErasureColumns
+func scanUserErasure(scan func(dest ...interface{}) error) (*UserErasure, error) {
+	var e UserErasure
+	var userID, username, email, requestedBy, reversedBy sql.NullString
+	var purgedAt, reversedAt sql.NullTime
+	var report []byte
+	if err := scan(&e.ID, &e.Pseudonym, &userID, &username, &email, &e.TruncateIPs, &report,
+		&requestedBy, &e.CreatedAt, &e.HoldUntil, &purgedAt, &reversedBy, &reversedAt); err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal(report, &e.Report)
+	str := func(s sql.NullString) *string {
+		if !s.Valid {
+			return nil
+		}
+		return &s.String
+	}
+	e.UserID, e.Username, e.Email = str(userID), str(username), str(email)
+	e.RequestedBy, e.ReversedBy = str(requestedBy), str(reversedBy)
+	e.Status = "held"
+	if purgedAt.Valid {
+		e.PurgedAt, e.Status = &purgedAt.Time, "purged"
+	}
+	if reversedAt.Valid {
+		e.ReversedAt, e.Status = &reversedAt.Time, "reversed"
+	}
+	return &e, nil
+}
+
+const userErasureColumns = `id, pseudonym, user_id, username, email, truncate_ips, report,
+	requested_by, created_at, hold_until, purged_at, reversed_by, reversed_at`
+
+// ListUserErasures lists the erasures for the DPO's records
+// @Summary		List user erasures
+// @Description	Lists GDPR erasures with what each pseudonymized: audit rows the user acted in, other audit rows naming them, truncated IPs, removed details fields and link shares. The mapping to the user (userId, username, email) is shown until the hold ends.
+// @Tags		Admin
+// @Produce		json
+// @Success		200	{array}		UserErasure			"Erasures"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Security	BearerAuth
+// @Router		/admin/erasures [get]
+func (h *AuthHandler) ListUserErasures(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermAuditRead)
+	if claims == nil {
+		return err
+	}
+	rows, err := h.db.Query(`SELECT ` + userErasureColumns + ` FROM user_erasures ORDER BY created_at DESC`)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list erasures", err))
+	}
+	defer rows.Close()
+	erasures := []*UserErasure{}
+	for rows.Next() {
+		e, err := scanUserErasure(rows.Scan)
+		if err != nil {
+			return RespondError(c, ErrOperationFailed("list erasures", err))
+		}
+		erasures = append(erasures, e)
+	}
+	return RespondSuccess(c, erasures)
+}
+
+// GetUserErasure returns one erasure
+// @Summary		Get user erasure
+// @Description	Returns an erasure and its report.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Erasure ID"
+// @Success		200	{object}	UserErasure			"Erasure"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Security	BearerAuth
+// @Router		/admin/erasures/{id} [get]
+func (h *AuthHandler) GetUserErasure(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermAuditRead)
+	if claims == nil {
+		return err
+	}
+	e, err := scanUserErasure(h.db.QueryRow(`SELECT `+userErasureColumns+` FROM user_erasures WHERE id = $1`, c.Param("id")).Scan)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Erasure"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("load erasure", err))
+	}
+	return RespondSuccess(c, e)
+}
+
+// ReverseUserErasure restores the original audit rows and link shares of
+// an erasure whose hold has not ended
+// @Summary		Reverse user erasure
+// @Description	Restores the audit rows of an erasure to their original actor, IP, target and details, for a retracted deletion. Link shares get their creator back only if that user exists again, and stay deactivated. Not possible once the hold has ended and the mapping was purged.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Erasure ID"
+// @Success		200	{object}	docs.SuccessResponse	"Restored row counts"
+// @Failure		403	{object}	docs.ErrorResponse	"Forbidden"
+// @Failure		404	{object}	docs.ErrorResponse	"Not found"
+// @Failure		409	{object}	docs.ErrorResponse	"Already reversed or purged"
+// @Security	BearerAuth
+// @Router		/admin/erasures/{id}/reverse [post]
+func (h *AuthHandler) ReverseUserErasure(c echo.Context) error {
+	claims, err := RequireAdminPermission(c, PermUsersManage)
+	if claims == nil {
+		return err
+	}
+	erasureID := c.Param("id")
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("reverse erasure", err))
+	}
+	defer tx.Rollback()
+
+	var pseudonym string
+	var purgedAt, reversedAt sql.NullTime
+	err = tx.QueryRow(`SELECT pseudonym, purged_at, reversed_at FROM user_erasures WHERE id = $1 FOR UPDATE`, erasureID).
+		Scan(&pseudonym, &purgedAt, &reversedAt)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Erasure"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("reverse erasure", err))
+	}
+	if reversedAt.Valid {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "Erasure was already reversed"))
+	}
+	if purgedAt.Valid {
+		return RespondError(c, NewAPIError(ErrCodeConflict, "The hold has ended and the mapping was purged"))
+	}
+
+	res, err := tx.Exec(`
+		UPDATE audit_logs al
+		SET actor_id = (r.original->>'actorId')::uuid, actor_pseudonym = NULL,
+			ip_addr = (r.original->>'ip')::inet, target_resource = r.original->>'target',
+			details = NULLIF(r.original->'details', 'null'::jsonb)
+		FROM user_erasure_rows r
+		WHERE r.erasure_id = $1 AND r.table_name = 'audit_logs' AND al.id = r.row_id::bigint
+	`, erasureID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("restore audit rows", err))
+	}
+	auditRows, _ := res.RowsAffected()
+
+	res, err = tx.Exec(`
+		UPDATE shares s
+		SET created_by = (r.original->>'createdBy')::uuid, created_by_pseudonym = NULL
+		FROM user_erasure_rows r
+		WHERE r.erasure_id = $1 AND r.table_name = 'shares' AND s.id = r.row_id::uuid
+		  AND EXISTS (SELECT 1 FROM users u WHERE u.id = (r.original->>'createdBy')::uuid)
+	`, erasureID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("restore shares", err))
+	}
+	shares, _ := res.RowsAffected()
+
+	if _, err := tx.Exec(`DELETE FROM user_erasure_rows WHERE erasure_id = $1`, erasureID); err != nil {
+		return RespondError(c, ErrOperationFailed("reverse erasure", err))
+	}
+	if _, err := tx.Exec(`
+		UPDATE user_erasures SET reversed_at = NOW(), reversed_by = $2, user_id = NULL, username = NULL, email = NULL
+		WHERE id = $1
+	`, erasureID, claims.UserID); err != nil {
+		return RespondError(c, ErrOperationFailed("reverse erasure", err))
+	}
+	if err := tx.Commit(); err != nil {
+		return RespondError(c, ErrOperationFailed("reverse erasure", err))
+	}
+
+	if h.auditHandler != nil {
+		_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminUserEraseReverse, erasureID, map[string]interface{}{
+			"pseudonym": pseudonym,
+			"rows":      auditRows,
+			"shares":    shares,
+		})
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"auditRows": auditRows,
+		"shares":    shares,
+	})
+}
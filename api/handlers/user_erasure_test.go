@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// jsonArg matches a JSON query argument by its decoded value
+type jsonArg struct{ want map[string]interface{} }
+
+func (a jsonArg) Match(v driver.Value) bool {
+	var s []byte
+	switch v := v.(type) {
+	case string:
+		s = []byte(v)
+	case []byte:
+		s = v
+	default:
+		return false
+	}
+	var got map[string]interface{}
+	return json.Unmarshal(s, &got) == nil && reflect.DeepEqual(got, a.want)
+}
+
+func TestErasureIdentityScrub(t *testing.T) {
+	id := erasureIdentity{userID: "u-123", username: "alice", email: "Alice@Example.com", pseudonym: "anon-1"}
+	for in, want := range map[string]string{
+		"/users/alice":             "/users/anon-1",
+		"/users/alice/docs/a.txt":  "/users/anon-1/docs/a.txt",
+		"/users/alicebob/a.txt":    "/users/alicebob/a.txt",
+		"alice":                    "anon-1",
+		"alice's notes.txt":        "alice's notes.txt",
+		"member u-123 added":       "member anon-1 added",
+		"mail alice@example.COM x": "mail anon-1 x",
+		"/shared/Team":             "/shared/Team",
+	} {
+		if got := id.scrub(in); got != want {
+			t.Errorf("scrub(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	v := id.scrubValue(map[string]interface{}{"users": []interface{}{"alice", "bob"}, "n": 2.0})
+	if want := map[string]interface{}{"users": []interface{}{"anon-1", "bob"}, "n": 2.0}; !reflect.DeepEqual(v, want) {
+		t.Errorf("scrubValue = %v", v)
+	}
+}
+
+func TestWhitelistedDetail(t *testing.T) {
+	for _, tc := range []struct {
+		event, field string
+		want         bool
+	}{
+		{"file.upload", "size", true},
+		{"file.upload", "fileName", true},
+		{"folder.create", "fileName", false},
+		{"user.login", "username", false},
+		{"user.login", "userAgent", false},
+		{"share.create", "shareId", true},
+	} {
+		if got := whitelistedDetail(tc.event, tc.field); got != tc.want {
+			t.Errorf("whitelistedDetail(%s, %s) = %v", tc.event, tc.field, got)
+		}
+	}
+}
+
+func TestTruncateIP(t *testing.T) {
+	for in, want := range map[string]string{
+		"203.0.113.7":         "203.0.113.0/24",
+		"203.0.113.7/32":      "203.0.113.0/24",
+		"10.1.0.0/16":         "10.1.0.0/16",
+		"2001:db8:1:2::5/128": "2001:db8:1::/48",
+		"not an ip":           "not an ip",
+	} {
+		if got, _ := truncateIP(in); got != want {
+			t.Errorf("truncateIP(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	userID, adminID, erasureID := "11111111-1111-1111-1111-111111111111", "22222222-2222-2222-2222-222222222222", "e1"
+	now := time.Now()
+
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectQuery("INSERT INTO user_erasures").
+		WithArgs(sqlmock.AnyArg(), userID, "alice", "alice@example.com", true, adminID, 30).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "hold_until"}).AddRow(erasureID, now, now.AddDate(0, 0, 30)))
+	tc.Mock.ExpectQuery("SELECT id, actor_id::text, ip_addr::text, event_type, target_resource, details FROM audit_logs").
+		WithArgs(userID, "/users/alice", `/users/alice/%`, "alice", "alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor_id", "ip_addr", "event_type", "target_resource", "details"}).
+			AddRow(1, userID, "203.0.113.7/32", "file.upload", "/users/alice/a.txt",
+				[]byte(`{"fileName":"a.txt","size":10,"userAgent":"Firefox","path":"/users/alice/a.txt"}`)).
+			AddRow(2, adminID, "198.51.100.1/32", "admin.user.update", userID,
+				[]byte(`{"username":"alice","changes":["email"]}`)))
+	tc.Mock.ExpectExec("UPDATE audit_logs").
+		WithArgs(erasureID, int64(1), sqlmock.AnyArg(), nil, sqlmock.AnyArg(), "203.0.113.0/24", sqlmock.AnyArg(),
+			jsonArg{map[string]interface{}{"fileName": "a.txt", "size": 10.0}}, int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("UPDATE audit_logs").
+		WithArgs(erasureID, int64(2), sqlmock.AnyArg(), adminID, nil, "198.51.100.1/32", sqlmock.AnyArg(),
+			sqlmock.AnyArg(), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("UPDATE shares SET created_by = NULL").
+		WithArgs(erasureID, userID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	tc.Mock.ExpectExec("UPDATE user_erasures SET report").WithArgs(erasureID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+
+	run, err := beginDestructiveRun(tc.DB, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := eraseUser(run, userID, "alice", "alice@example.com", adminID, true, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ErasureReport{AuditRows: 1, AuditMentions: 1, IPsTruncated: 1, Shares: 3,
+		ScrubbedFields: map[string]int64{"userAgent": 1, "path": 1}}
+	if !reflect.DeepEqual(e.Report, want) {
+		t.Errorf("report = %+v, want %+v", e.Report, want)
+	}
+	if e.ID != erasureID || len(e.Pseudonym) != len("anon-")+16 || e.Status != "held" {
+		t.Errorf("erasure = %+v", e)
+	}
+}
+
+func TestReverseUserErasure(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := &AuthHandler{db: tc.DB}
+	reverse := func() {
+		tc.Recorder.Body.Reset()
+		req, _ := NewJSONRequest(http.MethodPost, "/api/admin/erasures/e1/reverse", nil)
+		c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "admin", true)
+		c.SetParamNames("id")
+		c.SetParamValues("e1")
+		if err := h.ReverseUserErasure(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A reversed erasure cannot be reversed again
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectQuery("SELECT pseudonym, purged_at, reversed_at FROM user_erasures").WithArgs("e1").
+		WillReturnRows(sqlmock.NewRows([]string{"pseudonym", "purged_at", "reversed_at"}).AddRow("anon-1", nil, time.Now()))
+	tc.Mock.ExpectRollback()
+	reverse()
+	AssertStatus(t, tc.Recorder, http.StatusConflict)
+
+	tc.Recorder = httptest.NewRecorder()
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectQuery("SELECT pseudonym, purged_at, reversed_at FROM user_erasures").WithArgs("e1").
+		WillReturnRows(sqlmock.NewRows([]string{"pseudonym", "purged_at", "reversed_at"}).AddRow("anon-1", nil, nil))
+	tc.Mock.ExpectExec("UPDATE audit_logs al").WithArgs("e1").WillReturnResult(sqlmock.NewResult(0, 4))
+	tc.Mock.ExpectExec("UPDATE shares s").WithArgs("e1").WillReturnResult(sqlmock.NewResult(0, 2))
+	tc.Mock.ExpectExec("DELETE FROM user_erasure_rows").WithArgs("e1").WillReturnResult(sqlmock.NewResult(0, 6))
+	tc.Mock.ExpectExec("UPDATE user_erasures SET reversed_at").WithArgs("e1", "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectCommit()
+	reverse()
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+
+	var resp struct {
+		Data map[string]int64 `json:"data"`
+	}
+	if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Data["auditRows"] != 4 || resp.Data["shares"] != 2 {
+		t.Errorf("restored = %v", resp.Data)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// User groups. Admins group users and grant a group read or read-write on
+// shared folders alongside individual members; a user's permission on a
+// folder is the highest of their direct membership and their groups' grants
+// (the shared_folder_access view). The built-in everyone group always
+// contains every active non-guest account and cannot be deleted or edited
+// member by member. A group's storage quota applies to members without a
+// quota of their own (user_storage_quota).
+
+// UserGroup is a group of users
+type UserGroup struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Everyone marks the built-in group of all active users
+	Everyone     bool      `json:"everyone"`
+	StorageQuota *int64    `json:"storageQuota"` // bytes, nil = no group quota
+	MemberCount  int       `json:"memberCount"`
+	FolderCount  int       `json:"folderCount"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// UserGroupMember is a user in a group
+type UserGroupMember struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// UserGroupRequest creates or updates a group
+type UserGroupRequest struct {
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	StorageQuota *int64 `json:"storageQuota"` // bytes, nil or 0 = no group quota
+}
+
+// validate trims the request and checks it
+func (r *UserGroupRequest) validate() *APIError {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Description = strings.TrimSpace(r.Description)
+	if r.Name == "" {
+		return ErrBadRequest("Name is required")
+	}
+	if len(r.Name) > 100 {
+		return ErrBadRequest("Name must be at most 100 characters")
+	}
+	if r.StorageQuota != nil {
+		if *r.StorageQuota < 0 {
+			return ErrBadRequest("Storage quota must not be negative")
+		}
+		if *r.StorageQuota == 0 {
+			r.StorageQuota = nil
+		}
+	}
+	return nil
+}
+
+// scanUserGroup scans a row of userGroupColumns
+func scanUserGroup(row interface{ Scan(...any) error }) (*UserGroup, error) {
+	var g UserGroup
+	var quota sql.NullInt64
+	if err := row.Scan(&g.ID, &g.Name, &g.Description, &g.Everyone, &quota,
+		&g.MemberCount, &g.FolderCount, &g.CreatedAt, &g.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if quota.Valid {
+		g.StorageQuota = &quota.Int64
+	}
+	return &g, nil
+}
+
+const userGroupColumns = `
+	g.id, g.name, g.description, g.all_users, g.storage_quota,
+	(SELECT COUNT(*) FROM user_group_membership m WHERE m.group_id = g.id),
+	(SELECT COUNT(*) FROM shared_folder_groups sfg WHERE sfg.group_id = g.id),
+	g.created_at, g.updated_at`
+
+// ListGroups lists all user groups
+// @Summary		List user groups
+// @Description	Lists the user groups, the everyone group first, with their member and shared folder counts
+// @Tags		Admin
+// @Produce		json
+// @Success		200		{object}	docs.SuccessResponse	"Groups"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Security	BearerAuth
+// @Router		/admin/groups [get]
+func (h *AuthHandler) ListGroups(c echo.Context) error {
+	rows, err := h.db.Query(`SELECT ` + userGroupColumns + ` FROM user_groups g ORDER BY g.all_users DESC, g.name`)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	defer rows.Close()
+
+	groups := []UserGroup{}
+	for rows.Next() {
+		g, err := scanUserGroup(rows)
+		if err != nil {
+			return RespondError(c, ErrInternal("Database error"))
+		}
+		groups = append(groups, *g)
+	}
+	if err := rows.Err(); err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"groups": groups,
+		"total":  len(groups),
+	})
+}
+
+// CreateGroup creates a user group
+// @Summary		Create user group
+// @Description	Creates an empty user group. storageQuota (bytes) applies to members without a quota of their own.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		request	body		UserGroupRequest	true	"Group"
+// @Success		201		{object}	docs.SuccessResponse{data=UserGroup}	"Created group"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid group"
+// @Failure		409		{object}	docs.ErrorResponse	"Name taken"
+// @Security	BearerAuth
+// @Router		/admin/groups [post]
+func (h *AuthHandler) CreateGroup(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	var req UserGroupRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if apiErr := req.validate(); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := h.checkGroupName(req.Name, ""); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	g, err := scanUserGroup(h.db.QueryRow(`
+		INSERT INTO user_groups (name, description, storage_quota, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, name, description, all_users, storage_quota, 0, 0, created_at, updated_at
+	`, req.Name, req.Description, req.StorageQuota, claims.UserID))
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("create group", err))
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminGroupCreate, g.Name, map[string]interface{}{
+		"groupId":      g.ID,
+		"storageQuota": req.StorageQuota,
+	})
+	return RespondCreated(c, g)
+}
+
+// UpdateGroup renames a group or changes its description or quota
+// @Summary		Update user group
+// @Description	Changes a group's name, description and storage quota. The everyone group can be renamed too.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string				true	"Group ID"
+// @Param		request	body		UserGroupRequest	true	"Group"
+// @Success		200		{object}	docs.SuccessResponse{data=UserGroup}	"Updated group"
+// @Failure		400		{object}	docs.ErrorResponse	"Invalid group"
+// @Failure		404		{object}	docs.ErrorResponse	"Group not found"
+// @Failure		409		{object}	docs.ErrorResponse	"Name taken"
+// @Security	BearerAuth
+// @Router		/admin/groups/{id} [put]
+func (h *AuthHandler) UpdateGroup(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	groupID := c.Param("id")
+	var req UserGroupRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request"))
+	}
+	if apiErr := req.validate(); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if apiErr := h.checkGroupName(req.Name, groupID); apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	result, err := h.db.Exec(`
+		UPDATE user_groups SET name = $2, description = $3, storage_quota = $4, updated_at = NOW()
+		WHERE id = $1
+	`, groupID, req.Name, req.Description, req.StorageQuota)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("update group", err))
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return RespondError(c, ErrNotFound("Group"))
+	}
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminGroupUpdate, req.Name, map[string]interface{}{
+		"groupId":      groupID,
+		"description":  req.Description,
+		"storageQuota": req.StorageQuota,
+	})
+
+	g, err := scanUserGroup(h.db.QueryRow(`SELECT `+userGroupColumns+` FROM user_groups g WHERE g.id = $1`, groupID))
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	return RespondSuccess(c, g)
+}
+
+// DeleteGroup deletes a group with its memberships and shared folder grants
+// @Summary		Delete user group
+// @Description	Deletes a group. Its members lose the shared folder access they had only through it. The everyone group cannot be deleted.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Group ID"
+// @Success		200	{object}	docs.SuccessResponse	"Deleted"
+// @Failure		400	{object}	docs.ErrorResponse	"Everyone group"
+// @Failure		404	{object}	docs.ErrorResponse	"Group not found"
+// @Security	BearerAuth
+// @Router		/admin/groups/{id} [delete]
+func (h *AuthHandler) DeleteGroup(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	groupID := c.Param("id")
+	group, apiErr := h.lookupGroup(groupID)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if group.Everyone {
+		return RespondError(c, ErrBadRequest("The everyone group cannot be deleted"))
+	}
+
+	if _, err := h.db.Exec(`DELETE FROM user_groups WHERE id = $1`, groupID); err != nil {
+		return RespondError(c, ErrOperationFailed("delete group", err))
+	}
+	groupAccessChanged(&claims.UserID, c.RealIP(), EventAdminGroupDelete)
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminGroupDelete, group.Name, map[string]interface{}{
+		"groupId":     groupID,
+		"memberCount": group.MemberCount,
+		"folderCount": group.FolderCount,
+	})
+	return RespondSuccess(c, map[string]interface{}{
+		"id":      groupID,
+		"deleted": true,
+	})
+}
+
+// ListGroupMembers lists the users in a group
+// @Summary		List group members
+// @Description	Lists a group's members by username. For the everyone group these are all active non-guest users.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"Group ID"
+// @Success		200	{object}	docs.SuccessResponse	"Members"
+// @Failure		404	{object}	docs.ErrorResponse	"Group not found"
+// @Security	BearerAuth
+// @Router		/admin/groups/{id}/members [get]
+func (h *AuthHandler) ListGroupMembers(c echo.Context) error {
+	groupID := c.Param("id")
+	group, apiErr := h.lookupGroup(groupID)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	rows, err := h.db.Query(`
+		SELECT u.id, u.username, COALESCE(u.email, '')
+		FROM user_group_membership m
+		INNER JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1
+		ORDER BY u.username
+	`, groupID)
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	defer rows.Close()
+
+	members := []UserGroupMember{}
+	for rows.Next() {
+		var m UserGroupMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Email); err != nil {
+			return RespondError(c, ErrInternal("Database error"))
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	return RespondSuccess(c, map[string]interface{}{
+		"group":   group,
+		"members": members,
+		"total":   len(members),
+	})
+}
+
+// AddGroupMember adds a user to a group
+// @Summary		Add group member
+// @Description	Adds a user to a group. Guest accounts cannot join groups, and the everyone group's members are managed automatically.
+// @Tags		Admin
+// @Accept		json
+// @Produce		json
+// @Param		id		path		string					true	"Group ID"
+// @Param		request	body		object{userId=string}	true	"User"
+// @Success		200		{object}	docs.SuccessResponse	"Added"
+// @Failure		400		{object}	docs.ErrorResponse	"Everyone group or guest account"
+// @Failure		404		{object}	docs.ErrorResponse	"Group or user not found"
+// @Security	BearerAuth
+// @Router		/admin/groups/{id}/members [post]
+func (h *AuthHandler) AddGroupMember(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	groupID := c.Param("id")
+	var req struct {
+		UserID string `json:"userId"`
+	}
+	if err := c.Bind(&req); err != nil || req.UserID == "" {
+		return RespondError(c, ErrBadRequest("User ID is required"))
+	}
+	group, apiErr := h.lookupGroup(groupID)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	if group.Everyone {
+		return RespondError(c, ErrBadRequest("The everyone group includes all active users automatically"))
+	}
+
+	var username string
+	var isGuest bool
+	err = h.db.QueryRow(`SELECT username, is_guest FROM users WHERE id = $1`, req.UserID).Scan(&username, &isGuest)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("User"))
+	}
+	if err != nil {
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	if isGuest {
+		return RespondError(c, ErrBadRequest("Guest accounts cannot join groups"))
+	}
+
+	if _, err := h.db.Exec(`
+		INSERT INTO user_group_members (group_id, user_id, added_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`, groupID, req.UserID, claims.UserID); err != nil {
+		return RespondError(c, ErrOperationFailed("add group member", err))
+	}
+	groupMemberChanged(req.UserID, &claims.UserID, c.RealIP())
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminGroupMemberAdd, group.Name, map[string]interface{}{
+		"groupId":  groupID,
+		"userId":   req.UserID,
+		"username": username,
+	})
+	return RespondSuccess(c, map[string]string{"message": "Member added successfully"})
+}
+
+// RemoveGroupMember removes a user from a group
+// @Summary		Remove group member
+// @Description	Removes a user from a group. They keep shared folder access they have directly or through other groups.
+// @Tags		Admin
+// @Produce		json
+// @Param		id		path		string	true	"Group ID"
+// @Param		userId	path		string	true	"User ID"
+// @Success		200		{object}	docs.SuccessResponse	"Removed"
+// @Failure		404		{object}	docs.ErrorResponse	"Not a member"
+// @Security	BearerAuth
+// @Router		/admin/groups/{id}/members/{userId} [delete]
+func (h *AuthHandler) RemoveGroupMember(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	groupID := c.Param("id")
+	userID := c.Param("userId")
+
+	var groupName string
+	err = h.db.QueryRow(`
+		WITH removed AS (
+			DELETE FROM user_group_members WHERE group_id = $1 AND user_id = $2
+			RETURNING group_id
+		)
+		SELECT g.name FROM removed INNER JOIN user_groups g ON g.id = removed.group_id
+	`, groupID, userID).Scan(&groupName)
+	if err == sql.ErrNoRows {
+		return RespondError(c, ErrNotFound("Group member"))
+	}
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("remove group member", err))
+	}
+	groupMemberChanged(userID, &claims.UserID, c.RealIP())
+
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminGroupMemberRemove, groupName, map[string]interface{}{
+		"groupId": groupID,
+		"userId":  userID,
+	})
+	return RespondSuccess(c, map[string]string{"message": "Member removed successfully"})
+}
+
+// lookupGroup loads a group by ID
+func (h *AuthHandler) lookupGroup(groupID string) (*UserGroup, *APIError) {
+	g, err := scanUserGroup(h.db.QueryRow(`SELECT `+userGroupColumns+` FROM user_groups g WHERE g.id = $1`, groupID))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound("Group")
+	}
+	if err != nil {
+		return nil, ErrInternal("Database error")
+	}
+	return g, nil
+}
+
+// checkGroupName rejects a name used by another group
+func (h *AuthHandler) checkGroupName(name, groupID string) *APIError {
+	var taken bool
+	_ = h.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM user_groups WHERE lower(name) = lower($1) AND id::text <> $2)`,
+		name, groupID).Scan(&taken)
+	if taken {
+		return ErrAlreadyExists("Group with this name")
+	}
+	return nil
+}
+
+// groupMemberChanged refreshes what depends on one user's group memberships
+func groupMemberChanged(userID string, actorID *string, clientIP string) {
+	if cache := GetPermissionCache(); cache != nil {
+		cache.InvalidateUser(userID)
+	}
+	GetSMBShares().Sync(actorID, clientIP, "user_group_member")
+}
+
+// groupAccessChanged refreshes cached permissions after a change that can
+// affect any number of users, such as a group grant or deleting a group
+func groupAccessChanged(actorID *string, clientIP, reason string) {
+	if cache := GetPermissionCache(); cache != nil {
+		cache.InvalidateAll()
+	}
+	GetSMBShares().Sync(actorID, clientIP, reason)
+}
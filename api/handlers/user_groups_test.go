@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+)
+
+func groupRows(everyone bool) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "name", "description", "all_users", "storage_quota", "members", "folders", "created_at", "updated_at"}).
+		AddRow("g1", map[bool]string{true: "everyone", false: "design"}[everyone], "", everyone, nil, 3, 1, time.Now(), time.Now())
+}
+
+func groupContext(tc *TestContext, method string, body interface{}, names []string, values ...string) (echo.Context, *httptest.ResponseRecorder) {
+	req, _ := NewJSONRequest(method, "/", body)
+	rec := httptest.NewRecorder()
+	c := tc.Echo.NewContext(req, rec)
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	c.Set("user", &JWTClaims{UserID: "admin-1", Username: "admin", IsAdmin: true})
+	return c, rec
+}
+
+func TestUserGroupRequestValidate(t *testing.T) {
+	quota := func(n int64) *int64 { return &n }
+	for _, tt := range []struct {
+		req     UserGroupRequest
+		wantErr bool
+	}{
+		{UserGroupRequest{Name: " design "}, false},
+		{UserGroupRequest{Name: "  "}, true},
+		{UserGroupRequest{Name: string(make([]byte, 101))}, true},
+		{UserGroupRequest{Name: "design", StorageQuota: quota(-1)}, true},
+		{UserGroupRequest{Name: "design", StorageQuota: quota(1 << 30)}, false},
+	} {
+		if err := tt.req.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) = %v, wantErr %v", tt.req, err, tt.wantErr)
+		}
+	}
+
+	req := UserGroupRequest{Name: " design ", StorageQuota: quota(0)}
+	_ = req.validate()
+	if req.Name != "design" || req.StorageQuota != nil {
+		t.Errorf("validate normalized to %+v, want trimmed name and no quota", req)
+	}
+}
+
+func TestDeleteGroup_Everyone(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`FROM user_groups g WHERE g.id = $1`)).
+		WithArgs("g1").
+		WillReturnRows(groupRows(true))
+
+	c, rec := groupContext(tc, http.MethodDelete, nil, []string{"id"}, "g1")
+	if err := CreateTestAuthHandler(tc.DB).DeleteGroup(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, rec, http.StatusBadRequest)
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAddGroupMember(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	handler := CreateTestAuthHandler(tc.DB)
+
+	add := func(userID string) *httptest.ResponseRecorder {
+		c, rec := groupContext(tc, http.MethodPost, map[string]string{"userId": userID}, []string{"id"}, "g1")
+		if err := handler.AddGroupMember(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	expectUser := func(userID string, guest bool) {
+		tc.Mock.ExpectQuery(regexp.QuoteMeta(`FROM user_groups g WHERE g.id = $1`)).
+			WithArgs("g1").
+			WillReturnRows(groupRows(false))
+		tc.Mock.ExpectQuery(regexp.QuoteMeta(`SELECT username, is_guest FROM users`)).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"username", "is_guest"}).AddRow(userID, guest))
+	}
+
+	// Guests cannot join groups
+	expectUser("visitor", true)
+	AssertStatus(t, add("visitor"), http.StatusBadRequest)
+
+	expectUser("alice", false)
+	tc.Mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO user_group_members`)).
+		WithArgs("g1", "alice", "admin-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectExec("INSERT INTO audit_logs").
+		WithArgs("admin-1", sqlmock.AnyArg(), EventAdminGroupMemberAdd, "design", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	AssertStatus(t, add("alice"), http.StatusOK)
+
+	// The everyone group's members are implicit
+	tc.Mock.ExpectQuery(regexp.QuoteMeta(`FROM user_groups g WHERE g.id = $1`)).
+		WithArgs("g1").
+		WillReturnRows(groupRows(true))
+	AssertStatus(t, add("alice"), http.StatusBadRequest)
+
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListMembers_GroupAccess(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	handler := NewSharedFolderHandler(tc.DB, t.TempDir(), nil)
+	now := time.Now()
+
+	tc.Mock.ExpectQuery("FROM shared_folder_members sfm").
+		WithArgs("f1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "shared_folder_id", "user_id", "permission_level", "added_by", "created_at", "username", "added_by_username"}).
+			AddRow(1, "f1", "u-bob", PermissionReadOnly, "admin-1", now, "bob", "admin"))
+	tc.Mock.ExpectQuery("FROM shared_folder_groups sfg").
+		WithArgs("f1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "group_id", "group_name", "permission_level"}).
+			AddRow("u-alice", "alice", "g2", "design", PermissionReadOnly).
+			AddRow("u-alice", "alice", "g1", "everyone", PermissionReadWrite).
+			AddRow("u-bob", "bob", "g1", "everyone", PermissionReadWrite))
+	tc.Mock.ExpectQuery("FROM shared_folder_groups sfg").
+		WithArgs("f1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "all_users", "permission_level", "members", "created_at"}).
+			AddRow("g1", "everyone", true, PermissionReadWrite, 2, now))
+
+	c, rec := groupContext(tc, http.MethodGet, nil, []string{"id"}, "f1")
+	if err := handler.ListMembers(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, rec, http.StatusOK)
+
+	var resp struct {
+		Data struct {
+			Members []SharedFolderMember `json:"members"`
+			Groups  []SharedFolderGroup  `json:"groups"`
+		} `json:"data"`
+	}
+	if err := ParseJSONResponse(rec, &resp); err != nil {
+		t.Fatal(err)
+	}
+	members := resp.Data.Members
+	if len(members) != 2 || members[0].Username != "alice" || members[1].Username != "bob" {
+		t.Fatalf("members = %+v, want alice and bob", members)
+	}
+	if alice := members[0]; alice.Direct || len(alice.Groups) != 2 || alice.PermissionLevel != PermissionReadWrite {
+		t.Errorf("alice = %+v, want read-write through two groups", alice)
+	}
+	if bob := members[1]; !bob.Direct || bob.PermissionLevel != PermissionReadOnly || bob.EffectivePermission != PermissionReadWrite {
+		t.Errorf("bob = %+v, want direct read-only, effective read-write", bob)
+	}
+	if len(resp.Data.Groups) != 1 || !resp.Data.Groups[0].Everyone {
+		t.Errorf("groups = %+v", resp.Data.Groups)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
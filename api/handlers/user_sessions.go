@@ -0,0 +1,774 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Login sessions. Every sign-in starts a row in user_sessions and its tokens
+// carry the row's ID. Without remember me a session is short-lived: a token
+// lasts session_idle_minutes and /auth/refresh slides it forward on
+// activity, but never past session_max_hours after sign-in, so a browser
+// left open on a shared PC signs itself out. Remembered sessions get
+// session_remember_days tokens that keep sliding. Users list their sessions
+// with type, sign-in time, last activity, IP and user agent, and can end
+// one or all of them; admins can sign a user out everywhere. Signing out
+// everywhere also sets users.tokens_revoked_at, which refuses tokens that
+// aren't bound to a session. Sessions are kept in memory so the check on
+// every request costs no query; last activity is written back at most once
+// per sessionActivityInterval.
+//
+// Sign-in also sets an httpOnly refresh cookie holding a random token whose
+// hash is kept with the session. Browsers exchange it at /auth/refresh for
+// access tokens of session_access_minutes, so a leaked access token is only
+// good for minutes and ending the session ends the cookie with it. Clients
+// without the cookie keep refreshing with their current token.
+//
+// Tokens of deactivated and deleted users are refused too. Whether each user
+// is active is kept in memory, updated right away by the admin endpoints
+// and reloaded every userStateInterval for changes made elsewhere.
+
+const (
+	settingSessionIdleMinutes   = "session_idle_minutes"
+	settingSessionMaxHours      = "session_max_hours"
+	settingSessionRememberDays  = "session_remember_days"
+	settingSessionAccessMinutes = "session_access_minutes"
+
+	// refreshCookieName is the cookie carrying a session's refresh token
+	refreshCookieName = "filehatch_refresh"
+
+	// JobSessionsPrune is the job type deleting ended sessions
+	JobSessionsPrune = "sessions.prune"
+
+	sessionActivityInterval = time.Minute
+	userStateInterval       = 15 * time.Second
+)
+
+// Session types reported by the sessions endpoints
+const (
+	SessionTypeTemporary  = "temporary"
+	SessionTypeRemembered = "remembered"
+)
+
+var errSessionEnded = errors.New("session ended")
+
+// SessionPolicy is the session lifetime configured in system settings
+type SessionPolicy struct {
+	Idle     time.Duration // token lifetime without remember me
+	Max      time.Duration // absolute session length without remember me
+	Remember time.Duration // token lifetime with remember me
+	Access   time.Duration // lifetime of tokens issued for the refresh cookie
+}
+
+// LoadSessionPolicy reads the session lifetimes from system settings
+func LoadSessionPolicy() SessionPolicy {
+	policy := SessionPolicy{Idle: 2 * time.Hour, Max: 12 * time.Hour, Remember: 30 * 24 * time.Hour, Access: 15 * time.Minute}
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		policy.Idle = time.Duration(settings.GetSettingInt(settingSessionIdleMinutes, 120)) * time.Minute
+		policy.Max = time.Duration(settings.GetSettingInt(settingSessionMaxHours, 12)) * time.Hour
+		policy.Remember = time.Duration(settings.GetSettingInt(settingSessionRememberDays, 30)) * 24 * time.Hour
+		policy.Access = time.Duration(settings.GetSettingInt(settingSessionAccessMinutes, 15)) * time.Minute
+	}
+	// A session is never shorter than its first token
+	policy.Idle = max(policy.Idle, 5*time.Minute)
+	policy.Max = max(policy.Max, policy.Idle)
+	policy.Remember = max(policy.Remember, time.Hour)
+	policy.Access = min(max(policy.Access, time.Minute), policy.Idle)
+	return policy
+}
+
+// sessionEnd is when a session started now ends
+func (p SessionPolicy) sessionEnd(rememberMe bool, now time.Time) time.Time {
+	if rememberMe {
+		return now.Add(p.Remember)
+	}
+	return now.Add(p.Max)
+}
+
+// tokenLifetime is how long a token issued now lasts: the idle timeout for a
+// temporary session, cut off at the end of the session
+func (p SessionPolicy) tokenLifetime(rememberMe bool, sessionEnd, now time.Time) time.Duration {
+	if rememberMe {
+		return p.Remember
+	}
+	lifetime := p.Idle
+	if !sessionEnd.IsZero() && sessionEnd.Sub(now) < lifetime {
+		lifetime = sessionEnd.Sub(now)
+	}
+	return lifetime
+}
+
+// accessLifetime is how long a token issued now for the refresh cookie
+// lasts: the access token lifetime, cut off at the end of the session
+func (p SessionPolicy) accessLifetime(rememberMe bool, sessionEnd, now time.Time) time.Duration {
+	return min(p.Access, p.tokenLifetime(rememberMe, sessionEnd, now))
+}
+
+// userSession is the in-memory state of one session
+type userSession struct {
+	UserID            string
+	RememberMe        bool
+	ExpiresAt         time.Time
+	LastActivity      time.Time
+	persistedActivity time.Time
+}
+
+// Sessions tracks the live login sessions
+type Sessions struct {
+	db *sql.DB
+
+	mu            sync.RWMutex
+	byID          map[string]*userSession
+	revokedBefore map[string]time.Time // user ID -> tokens issued before are refused
+	userActive    map[string]bool      // user ID -> is_active; nil until loaded
+	stateChanges  map[string]bool      // set since the running reload started
+}
+
+var globalSessions *Sessions
+
+// InitSessions loads the live sessions and schedules pruning of ended ones
+func InitSessions(db *sql.DB) *Sessions {
+	s := newSessions(db)
+	if err := s.load(); err != nil {
+		log.Printf("[Sessions] Failed to load sessions: %v", err)
+	}
+	globalSessions = s
+	go s.watchUserStates()
+
+	jobs := GetJobs()
+	jobs.Register(JobType{
+		Name:        JobSessionsPrune,
+		Idempotent:  true,
+		MaxAttempts: 3,
+		Run:         s.prune,
+	})
+	jobs.Schedule(JobSessionsPrune, 24*time.Hour, false)
+	return s
+}
+
+func newSessions(db *sql.DB) *Sessions {
+	return &Sessions{
+		db:            db,
+		byID:          make(map[string]*userSession),
+		revokedBefore: make(map[string]time.Time),
+		stateChanges:  make(map[string]bool),
+	}
+}
+
+// GetSessions returns the session tracker (nil if not initialized)
+func GetSessions() *Sessions {
+	return globalSessions
+}
+
+func (s *Sessions) load() error {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, remember_me, expires_at, last_activity_at
+		FROM user_sessions WHERE revoked_at IS NULL AND expires_at > NOW()
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		session := &userSession{}
+		if err := rows.Scan(&id, &session.UserID, &session.RememberMe, &session.ExpiresAt, &session.LastActivity); err != nil {
+			return err
+		}
+		session.persistedActivity = session.LastActivity
+		s.byID[id] = session
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	revoked, err := s.db.Query(`SELECT id, tokens_revoked_at FROM users WHERE tokens_revoked_at IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	defer revoked.Close()
+	for revoked.Next() {
+		var userID string
+		var at time.Time
+		if err := revoked.Scan(&userID, &at); err != nil {
+			return err
+		}
+		s.revokedBefore[userID] = at
+	}
+	if err := revoked.Err(); err != nil {
+		return err
+	}
+	return s.loadUserStates()
+}
+
+// loadUserStates reads which users exist and are active. Changes recorded
+// with SetUserActive while the query runs win over its result.
+func (s *Sessions) loadUserStates() error {
+	s.mu.Lock()
+	s.stateChanges = make(map[string]bool)
+	s.mu.Unlock()
+
+	rows, err := s.db.Query(`SELECT id, is_active FROM users`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	states := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		var active bool
+		if err := rows.Scan(&userID, &active); err != nil {
+			return err
+		}
+		states[userID] = active
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	for userID, active := range s.stateChanges {
+		states[userID] = active
+	}
+	s.userActive = states
+	s.mu.Unlock()
+	return nil
+}
+
+// watchUserStates reloads the user states every userStateInterval
+func (s *Sessions) watchUserStates() {
+	ticker := time.NewTicker(userStateInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.loadUserStates(); err != nil {
+			log.Printf("[Sessions] Failed to load user states: %v", err)
+		}
+	}
+}
+
+// SetUserActive records that a user was activated or deactivated. Tokens of
+// inactive users are refused from now on.
+func (s *Sessions) SetUserActive(userID string, active bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.userActive != nil {
+		s.userActive[userID] = active
+	}
+	s.stateChanges[userID] = active
+}
+
+// ForgetUser drops a deleted user: its sessions are gone with it and its
+// tokens are refused
+func (s *Sessions) ForgetUser(userID string) {
+	if s == nil {
+		return
+	}
+	s.SetUserActive(userID, false)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.byID {
+		if session.UserID == userID {
+			delete(s.byID, id)
+		}
+	}
+}
+
+// Start records a new session and returns its ID, end and refresh token
+func (s *Sessions) Start(userID string, rememberMe bool, ip, userAgent string) (string, time.Time, string, error) {
+	now := time.Now()
+	expiresAt := LoadSessionPolicy().sessionEnd(rememberMe, now)
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	refreshToken, err := GenerateURLSafeToken(32)
+	if err != nil {
+		return "", time.Time{}, "", err
+	}
+	var id string
+	if err := s.db.QueryRow(`
+		INSERT INTO user_sessions (user_id, remember_me, ip_addr, user_agent, created_at, last_activity_at, expires_at, refresh_token_hash)
+		VALUES ($1, $2, $3, $4, $5, $5, $6, $7) RETURNING id
+	`, userID, rememberMe, ip, userAgent, now, expiresAt, hashRefreshToken(refreshToken)).Scan(&id); err != nil {
+		return "", time.Time{}, "", err
+	}
+
+	s.mu.Lock()
+	s.byID[id] = &userSession{UserID: userID, RememberMe: rememberMe, ExpiresAt: expiresAt, LastActivity: now, persistedActivity: now}
+	s.mu.Unlock()
+	// Signing in means the account is active, even if it was created or
+	// reactivated after the user states were last loaded
+	s.SetUserActive(userID, true)
+	return id, expiresAt, refreshToken, nil
+}
+
+// Redeem returns the live session a refresh token belongs to. A temporary
+// session also has to have been active within the idle timeout: the cookie
+// outlives the tokens of an idle browser, but not the idle timeout.
+func (s *Sessions) Redeem(refreshToken string) (sessionID, userID string, rememberMe bool, err error) {
+	if refreshToken == "" {
+		return "", "", false, errSessionEnded
+	}
+	err = s.db.QueryRow(`
+		SELECT id, user_id FROM user_sessions
+		WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+	`, hashRefreshToken(refreshToken)).Scan(&sessionID, &userID)
+	if err == sql.ErrNoRows {
+		return "", "", false, errSessionEnded
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+
+	s.mu.RLock()
+	session, ok := s.byID[sessionID]
+	live := ok && session.UserID == userID && time.Now().Before(session.ExpiresAt)
+	if live {
+		rememberMe = session.RememberMe
+		live = rememberMe || time.Since(session.LastActivity) < LoadSessionPolicy().Idle
+	}
+	s.mu.RUnlock()
+	if !live {
+		return "", "", false, errSessionEnded
+	}
+	return sessionID, userID, rememberMe, nil
+}
+
+// hashRefreshToken returns the stored form of a refresh token
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Extend returns the lifetime of a refreshed token for a session. Remembered
+// sessions slide their end forward; temporary ones keep theirs.
+func (s *Sessions) Extend(sessionID string) (time.Duration, error) {
+	now := time.Now()
+	policy := LoadSessionPolicy()
+
+	s.mu.RLock()
+	session, ok := s.byID[sessionID]
+	var rememberMe bool
+	var expiresAt time.Time
+	if ok {
+		rememberMe, expiresAt = session.RememberMe, session.ExpiresAt
+	}
+	s.mu.RUnlock()
+	if !ok || !now.Before(expiresAt) {
+		return 0, errSessionEnded
+	}
+
+	if rememberMe {
+		expiresAt = policy.sessionEnd(true, now)
+		if _, err := s.db.Exec(`UPDATE user_sessions SET expires_at = $2, last_activity_at = $3 WHERE id = $1`, sessionID, expiresAt, now); err != nil {
+			return 0, err
+		}
+		s.mu.Lock()
+		session.ExpiresAt = expiresAt
+		session.LastActivity, session.persistedActivity = now, now
+		s.mu.Unlock()
+	}
+	return policy.tokenLifetime(rememberMe, expiresAt, now), nil
+}
+
+// TokenAllowed reports whether a token may be used: its user must exist and
+// be active, its session must still be live, and it must have been issued
+// after the user last signed out everywhere. Tokens without a session, such
+// as document server tokens, are not subject to the session check.
+func (s *Sessions) TokenAllowed(claims *JWTClaims) bool {
+	if s == nil || claims == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.userActive != nil && !s.userActive[claims.UserID] {
+		return false
+	}
+	if revokedAt, ok := s.revokedBefore[claims.UserID]; ok {
+		// IssuedAt has a precision of seconds
+		if claims.IssuedAt == nil || claims.IssuedAt.Before(revokedAt.Truncate(time.Second)) {
+			return false
+		}
+	}
+	if claims.SessionID == "" {
+		return true
+	}
+	session, ok := s.byID[claims.SessionID]
+	return ok && session.UserID == claims.UserID && time.Now().Before(session.ExpiresAt)
+}
+
+// Touch records activity on a session, writing it back at most once per
+// sessionActivityInterval
+func (s *Sessions) Touch(sessionID string) {
+	if s == nil || sessionID == "" {
+		return
+	}
+	now := time.Now()
+	s.mu.Lock()
+	session, ok := s.byID[sessionID]
+	persist := ok && now.Sub(session.persistedActivity) >= sessionActivityInterval
+	if ok {
+		session.LastActivity = now
+		if persist {
+			session.persistedActivity = now
+		}
+	}
+	s.mu.Unlock()
+	if persist {
+		go func() {
+			if _, err := s.db.Exec(`UPDATE user_sessions SET last_activity_at = $2 WHERE id = $1`, sessionID, now); err != nil {
+				log.Printf("[Sessions] Failed to record activity: %v", err)
+			}
+		}()
+	}
+}
+
+// Revoke ends one session of a user. It reports false if there was no such
+// live session.
+func (s *Sessions) Revoke(userID, sessionID string) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+	`, sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	if session, ok := s.byID[sessionID]; ok && session.UserID == userID {
+		delete(s.byID, sessionID)
+	}
+	s.mu.Unlock()
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RevokeAll signs a user out everywhere: every session ends and tokens
+// issued until now are refused. It returns the number of sessions ended.
+func (s *Sessions) RevokeAll(userID string) (int64, error) {
+	var ended int64
+	var revokedAt time.Time
+	err := WithTransaction(s.db, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`UPDATE user_sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`, userID)
+		if err != nil {
+			return err
+		}
+		ended, _ = res.RowsAffected()
+		return tx.QueryRow(`UPDATE users SET tokens_revoked_at = NOW() WHERE id = $1 RETURNING tokens_revoked_at`, userID).Scan(&revokedAt)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	s.revokedBefore[userID] = revokedAt
+	for id, session := range s.byID {
+		if session.UserID == userID {
+			delete(s.byID, id)
+		}
+	}
+	s.mu.Unlock()
+	if account, ok := GetGuests().lookup(userID); ok {
+		account.TokensRevoked = revokedAt
+		GetGuests().set(userID, account)
+	}
+	return ended, nil
+}
+
+// SessionInfo describes a live session for its user or an admin
+type SessionInfo struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"` // temporary or remembered
+	CreatedAt      time.Time `json:"createdAt"`
+	LastActivityAt time.Time `json:"lastActivityAt"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	IPAddress      string    `json:"ipAddress"`
+	UserAgent      string    `json:"userAgent"`
+	Current        bool      `json:"current"`
+}
+
+// List returns the live sessions of a user, most recently active first
+func (s *Sessions) List(userID, currentID string) ([]SessionInfo, error) {
+	rows, err := s.db.Query(`
+		SELECT id, remember_me, created_at, last_activity_at, expires_at, COALESCE(ip_addr, ''), COALESCE(user_agent, '')
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_activity_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []SessionInfo{}
+	for rows.Next() {
+		var info SessionInfo
+		var rememberMe bool
+		if err := rows.Scan(&info.ID, &rememberMe, &info.CreatedAt, &info.LastActivityAt, &info.ExpiresAt, &info.IPAddress, &info.UserAgent); err != nil {
+			return nil, err
+		}
+		info.Type = SessionTypeTemporary
+		if rememberMe {
+			info.Type = SessionTypeRemembered
+		}
+		info.Current = info.ID == currentID
+		// Activity not yet written back
+		s.mu.RLock()
+		if session, ok := s.byID[info.ID]; ok && session.LastActivity.After(info.LastActivityAt) {
+			info.LastActivityAt = session.LastActivity
+		}
+		s.mu.RUnlock()
+		sessions = append(sessions, info)
+	}
+	return sessions, rows.Err()
+}
+
+// prune deletes sessions that ended more than a day ago
+func (s *Sessions) prune(run *JobRun) error {
+	res, err := s.db.ExecContext(run.Context(), `
+		DELETE FROM user_sessions
+		WHERE expires_at <= NOW() - INTERVAL '1 day' OR revoked_at <= NOW() - INTERVAL '1 day'
+	`)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s.mu.Lock()
+	for id, session := range s.byID {
+		if !now.Before(session.ExpiresAt) {
+			delete(s.byID, id)
+		}
+	}
+	s.mu.Unlock()
+	deleted, _ := res.RowsAffected()
+	run.SetProgress(map[string]int64{"deleted": deleted})
+	return nil
+}
+
+// respondSessionEnded refuses a request made with a token of an ended session
+func respondSessionEnded(c echo.Context) error {
+	return c.JSON(http.StatusUnauthorized, map[string]string{
+		"error": "Session has ended",
+	})
+}
+
+// setRefreshCookie hands a session's refresh token to the browser. The
+// cookie of a remembered session lasts until the session's end; a temporary
+// session's is dropped when the browser closes.
+func setRefreshCookie(c echo.Context, refreshToken string, rememberMe bool, expiresAt time.Time) {
+	cookie := &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    refreshToken,
+		Path:     "/api",
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteStrictMode,
+	}
+	if rememberMe {
+		cookie.Expires = expiresAt
+	}
+	c.SetCookie(cookie)
+}
+
+// clearRefreshCookie removes the refresh cookie after the session ended
+func clearRefreshCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     refreshCookieName,
+		Path:     "/api",
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	})
+}
+
+// issueSessionToken signs a user in: it starts a session of the requested
+// type, sets its refresh cookie and returns an access token bound to it
+func issueSessionToken(c echo.Context, userID, username string, isAdmin, rememberMe bool) (string, error) {
+	policy := LoadSessionPolicy()
+	sessions := GetSessions()
+	if sessions == nil {
+		return generateJWT(userID, username, isAdmin, rememberMe, "", "", policy.tokenLifetime(rememberMe, time.Time{}, time.Now()))
+	}
+	sessionID, expiresAt, refreshToken, err := sessions.Start(userID, rememberMe, c.RealIP(), c.Request().UserAgent())
+	if err != nil {
+		return "", err
+	}
+	setRefreshCookie(c, refreshToken, rememberMe, expiresAt)
+	return generateJWT(userID, username, isAdmin, rememberMe, "", sessionID, policy.accessLifetime(rememberMe, expiresAt, time.Now()))
+}
+
+// ListSessions returns the current user's live sessions
+// @Summary		List my sessions
+// @Description	Live sessions of the current user with their type (temporary or remembered), sign-in time, last activity, end, IP address and user agent. current marks the session of this request.
+// @Tags		Auth
+// @Produce		json
+// @Success		200	{object}	map[string]interface{}	"sessions"
+// @Security	BearerAuth
+// @Router		/auth/sessions [get]
+func (h *AuthHandler) ListSessions(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	sessions, err := GetSessions().List(claims.UserID, claims.SessionID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list sessions", err))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+// RevokeSession ends one of the current user's sessions
+// @Summary		Sign out a session
+// @Tags		Auth
+// @Produce		json
+// @Param		id	path		string	true	"Session ID"
+// @Success		200	{object}	map[string]interface{}	"Signed out"
+// @Failure		404	{object}	docs.ErrorResponse	"No such session"
+// @Security	BearerAuth
+// @Router		/auth/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	sessionID := c.Param("id")
+	ended, err := GetSessions().Revoke(claims.UserID, sessionID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("end session", err))
+	}
+	if !ended {
+		return RespondError(c, ErrNotFound("Session"))
+	}
+	if sessionID == claims.SessionID {
+		clearRefreshCookie(c)
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventUserLogout, claims.Username, map[string]interface{}{
+		"sessionId": sessionID,
+		"current":   sessionID == claims.SessionID,
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"current": sessionID == claims.SessionID,
+	})
+}
+
+// Logout ends the session of the request's token
+// @Summary		Sign out
+// @Tags		Auth
+// @Produce		json
+// @Success		200	{object}	map[string]interface{}	"Signed out"
+// @Security	BearerAuth
+// @Router		/auth/logout [post]
+func (h *AuthHandler) Logout(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	if claims.SessionID != "" {
+		if _, err := GetSessions().Revoke(claims.UserID, claims.SessionID); err != nil {
+			return RespondError(c, ErrOperationFailed("end session", err))
+		}
+	}
+	clearRefreshCookie(c)
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventUserLogout, claims.Username, map[string]interface{}{
+		"sessionId": claims.SessionID,
+		"current":   true,
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// SignOutEverywhere ends all sessions of the current user, this one included
+// @Summary		Sign out everywhere
+// @Description	Ends every session of the current user, including this one, and refuses all tokens issued so far.
+// @Tags		Auth
+// @Produce		json
+// @Success		200	{object}	map[string]interface{}	"Sessions ended"
+// @Security	BearerAuth
+// @Router		/auth/sessions/revoke-all [post]
+func (h *AuthHandler) SignOutEverywhere(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	ended, err := GetSessions().RevokeAll(claims.UserID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("end sessions", err))
+	}
+	clearRefreshCookie(c)
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventUserLogoutAll, claims.Username, map[string]interface{}{
+		"sessions": ended,
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"ended":   ended,
+	})
+}
+
+// ListUserSessions returns a user's live sessions for an admin
+// @Summary		List a user's sessions
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"User ID"
+// @Success		200	{object}	map[string]interface{}	"sessions"
+// @Security	BearerAuth
+// @Router		/admin/users/{id}/sessions [get]
+func (h *AuthHandler) ListUserSessions(c echo.Context) error {
+	sessions, err := GetSessions().List(c.Param("id"), "")
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("list sessions", err))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+// SignOutUser ends all sessions of a user
+// @Summary		Sign a user out everywhere
+// @Description	Ends every session of the user and refuses all tokens issued so far.
+// @Tags		Admin
+// @Produce		json
+// @Param		id	path		string	true	"User ID"
+// @Success		200	{object}	map[string]interface{}	"Sessions ended"
+// @Failure		404	{object}	docs.ErrorResponse	"User not found"
+// @Security	BearerAuth
+// @Router		/admin/users/{id}/sessions [delete]
+func (h *AuthHandler) SignOutUser(c echo.Context) error {
+	claims, err := RequireClaims(c)
+	if err != nil {
+		return err
+	}
+	userID := c.Param("id")
+	var username string
+	if err := h.db.QueryRow(`SELECT username FROM users WHERE id = $1`, userID).Scan(&username); err != nil {
+		if err == sql.ErrNoRows {
+			return RespondError(c, ErrNotFound("User"))
+		}
+		return RespondError(c, ErrInternal("Database error"))
+	}
+	ended, err := GetSessions().RevokeAll(userID)
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("end sessions", err))
+	}
+	_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventAdminUserSignOut, username, map[string]interface{}{
+		"userId":   userID,
+		"sessions": ended,
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"success": true,
+		"ended":   ended,
+	})
+}
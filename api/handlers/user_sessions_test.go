@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func useTestSessions(t *testing.T, s *Sessions) {
+	t.Helper()
+	prev := globalSessions
+	globalSessions = s
+	t.Cleanup(func() { globalSessions = prev })
+}
+
+func TestSessionPolicy(t *testing.T) {
+	useCachedSettings(t, map[string]string{
+		settingSessionIdleMinutes:   "60",
+		settingSessionMaxHours:      "8",
+		settingSessionRememberDays:  "14",
+		settingSessionAccessMinutes: "15",
+	})
+	policy := LoadSessionPolicy()
+	now := time.Now()
+
+	if policy.Idle != time.Hour || policy.Max != 8*time.Hour || policy.Remember != 14*24*time.Hour {
+		t.Fatalf("policy = %+v", policy)
+	}
+	if got := policy.sessionEnd(false, now); !got.Equal(now.Add(8 * time.Hour)) {
+		t.Errorf("temporary session end = %v", got)
+	}
+	if got := policy.tokenLifetime(false, now.Add(8*time.Hour), now); got != time.Hour {
+		t.Errorf("token lifetime = %v, want the idle timeout", got)
+	}
+	// Near the absolute end the token is cut off there
+	if got := policy.tokenLifetime(false, now.Add(10*time.Minute), now); got != 10*time.Minute {
+		t.Errorf("token lifetime near the end = %v", got)
+	}
+	if got := policy.tokenLifetime(true, now.Add(time.Minute), now); got != 14*24*time.Hour {
+		t.Errorf("remembered token lifetime = %v", got)
+	}
+
+	// Tokens for the refresh cookie are short, and never outlive the session
+	if policy.Access != 15*time.Minute {
+		t.Errorf("access lifetime = %v", policy.Access)
+	}
+	if got := policy.accessLifetime(false, now.Add(5*time.Minute), now); got != 5*time.Minute {
+		t.Errorf("access lifetime near the end = %v", got)
+	}
+
+	// A maximum below the idle timeout is raised to it
+	useCachedSettings(t, map[string]string{settingSessionIdleMinutes: "180", settingSessionMaxHours: "1", settingSessionRememberDays: "30", settingSessionAccessMinutes: "15"})
+	if policy := LoadSessionPolicy(); policy.Max != 3*time.Hour {
+		t.Errorf("max = %v", policy.Max)
+	}
+}
+
+func TestSessionsTokenAllowed(t *testing.T) {
+	s := newSessions(nil)
+	now := time.Now()
+	issued := func(userID, sessionID string, at time.Time) *JWTClaims {
+		return &JWTClaims{UserID: userID, SessionID: sessionID, RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(at)}}
+	}
+	s.byID["live"] = &userSession{UserID: "u1", ExpiresAt: now.Add(time.Hour)}
+	s.byID["over"] = &userSession{UserID: "u1", ExpiresAt: now.Add(-time.Second)}
+
+	if !s.TokenAllowed(issued("u1", "live", now)) {
+		t.Error("live session refused")
+	}
+	if s.TokenAllowed(issued("u1", "over", now)) {
+		t.Error("session past its absolute end allowed")
+	}
+	if s.TokenAllowed(issued("u1", "unknown", now)) || s.TokenAllowed(issued("u2", "live", now)) {
+		t.Error("unknown or foreign session allowed")
+	}
+	if !s.TokenAllowed(issued("u1", "", now)) {
+		t.Error("token without a session refused")
+	}
+
+	// Signing out everywhere refuses tokens issued before, with or without a session
+	s.revokedBefore["u1"] = now.Add(-time.Minute)
+	if s.TokenAllowed(issued("u1", "", now.Add(-time.Hour))) || s.TokenAllowed(issued("u1", "live", now.Add(-time.Hour))) {
+		t.Error("token issued before signing out everywhere allowed")
+	}
+	if !s.TokenAllowed(issued("u1", "live", now)) {
+		t.Error("token issued after signing out everywhere refused")
+	}
+
+	var none *Sessions
+	if !none.TokenAllowed(issued("u1", "x", now)) {
+		t.Error("uninitialized tracker refused a token")
+	}
+}
+
+func TestSessionsLifecycle(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{settingSessionIdleMinutes: "120", settingSessionMaxHours: "12", settingSessionRememberDays: "30", settingSessionAccessMinutes: "15"})
+	s := newSessions(tc.DB)
+
+	tc.Mock.ExpectQuery("INSERT INTO user_sessions").
+		WithArgs("u1", false, "10.0.0.5", "Firefox", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("s1"))
+	id, expiresAt, refreshToken, err := s.Start("u1", false, "10.0.0.5", "Firefox")
+	if err != nil || id != "s1" || time.Until(expiresAt) < 11*time.Hour || len(refreshToken) < 40 {
+		t.Fatalf("Start = %q, %v, %q, %v", id, expiresAt, refreshToken, err)
+	}
+
+	// A temporary session keeps its end; the token lasts the idle timeout
+	lifetime, err := s.Extend("s1")
+	if err != nil || lifetime != 2*time.Hour {
+		t.Errorf("Extend = %v, %v", lifetime, err)
+	}
+	s.byID["s1"].ExpiresAt = time.Now().Add(30 * time.Minute)
+	if lifetime, _ := s.Extend("s1"); lifetime > 30*time.Minute {
+		t.Errorf("token outlives the session: %v", lifetime)
+	}
+
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("UPDATE user_sessions SET revoked_at").WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectQuery("UPDATE users SET tokens_revoked_at").WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"tokens_revoked_at"}).AddRow(time.Now()))
+	tc.Mock.ExpectCommit()
+	if ended, err := s.RevokeAll("u1"); err != nil || ended != 1 {
+		t.Fatalf("RevokeAll = %d, %v", ended, err)
+	}
+	if _, err := s.Extend("s1"); err != errSessionEnded {
+		t.Errorf("Extend after sign-out = %v", err)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshToken_EndedSession(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useTestSessions(t, newSessions(tc.DB))
+	h := &AuthHandler{db: tc.DB}
+
+	tc.Mock.ExpectQuery("SELECT is_active FROM users").WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"is_active"}).AddRow(true))
+	rec := httptest.NewRecorder()
+	c := tc.Echo.NewContext(httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil), rec)
+	c.Set("user", &JWTClaims{UserID: "u1", Username: "alice", SessionID: "gone"})
+	if err := h.RefreshToken(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, rec, http.StatusUnauthorized)
+}
+
+func TestRefreshToken_Cookie(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{settingSessionIdleMinutes: "120", settingSessionMaxHours: "12", settingSessionRememberDays: "30", settingSessionAccessMinutes: "10"})
+	s := newSessions(tc.DB)
+	useTestSessions(t, s)
+	h := &AuthHandler{db: tc.DB}
+	s.byID["s1"] = &userSession{UserID: "u1", ExpiresAt: time.Now().Add(8 * time.Hour), LastActivity: time.Now()}
+	s.byID["s2"] = &userSession{UserID: "u1", ExpiresAt: time.Now().Add(8 * time.Hour), LastActivity: time.Now().Add(-3 * time.Hour)}
+
+	refresh := func(cookie string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: refreshCookieName, Value: cookie})
+		rec := httptest.NewRecorder()
+		if err := h.RefreshToken(tc.Echo.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	// No valid token needed: the cookie names the session
+	tc.Mock.ExpectQuery("FROM user_sessions").WithArgs(hashRefreshToken("live")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("s1", "u1"))
+	tc.Mock.ExpectQuery("SELECT username, is_admin, is_active FROM users").WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"username", "is_admin", "is_active"}).AddRow("alice", false, true))
+	rec := refresh("live")
+	AssertStatus(t, rec, http.StatusOK)
+	var body struct{ Token string }
+	if err := ParseJSONResponse(rec, &body); err != nil {
+		t.Fatal(err)
+	}
+	claims := &JWTClaims{}
+	if _, err := jwt.ParseWithClaims(body.Token, claims, func(*jwt.Token) (interface{}, error) { return sharedJWTSecret, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if claims.SessionID != "s1" || claims.Username != "alice" {
+		t.Errorf("claims = %+v", claims)
+	}
+	if lifetime := time.Until(claims.ExpiresAt.Time); lifetime > 10*time.Minute || lifetime < 9*time.Minute {
+		t.Errorf("access token lifetime = %v", lifetime)
+	}
+
+	// An unknown token and a temporary session idle past its timeout end,
+	// and the cookie goes with them
+	tc.Mock.ExpectQuery("FROM user_sessions").WithArgs(hashRefreshToken("revoked")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}))
+	tc.Mock.ExpectQuery("FROM user_sessions").WithArgs(hashRefreshToken("idle")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("s2", "u1"))
+	for _, cookie := range []string{"revoked", "idle"} {
+		rec := refresh(cookie)
+		AssertStatus(t, rec, http.StatusUnauthorized)
+		if set := rec.Header().Get("Set-Cookie"); !strings.Contains(set, refreshCookieName+"=;") || !strings.Contains(set, "Max-Age=0") {
+			t.Errorf("%s: cookie not cleared: %q", cookie, set)
+		}
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJWTMiddleware_RefusesDeactivatedAndDeletedUsers(t *testing.T) {
+	tc := SetupTest(t)
+	defer tc.Cleanup()
+	h := CreateTestAuthHandler(tc.DB)
+	s := newSessions(tc.DB)
+	useTestSessions(t, s)
+
+	tc.Mock.ExpectQuery("SELECT id, is_active FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow("u1", true).AddRow("u2", true).AddRow("u3", false))
+	if err := s.loadUserStates(); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(userID string) int {
+		t.Helper()
+		token, err := GenerateJWT(userID, "user-"+userID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		_ = h.JWTMiddleware(func(c echo.Context) error { return c.NoContent(http.StatusOK) })(tc.Echo.NewContext(req, rec))
+		return rec.Code
+	}
+	if code := call("u1"); code != http.StatusOK {
+		t.Fatalf("active user: status %d", code)
+	}
+	if code := call("u3"); code != http.StatusUnauthorized {
+		t.Errorf("inactive user: status %d", code)
+	}
+
+	// Deactivating takes effect on the next request
+	token, _ := GenerateJWT("u1", "alice", false)
+	tc.Mock.ExpectExec("UPDATE users SET").WithArgs(false, false, "u1").WillReturnResult(sqlmock.NewResult(0, 1))
+	tc.Mock.ExpectBegin()
+	tc.Mock.ExpectExec("UPDATE user_sessions SET revoked_at").WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 0))
+	tc.Mock.ExpectQuery("UPDATE users SET tokens_revoked_at").WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"tokens_revoked_at"}).AddRow(time.Now()))
+	tc.Mock.ExpectCommit()
+	if apiErr := h.updateUserAccount("u1", UpdateUserRequest{IsActive: false}); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	_ = h.JWTMiddleware(func(c echo.Context) error { return c.NoContent(http.StatusOK) })(tc.Echo.NewContext(req, rec))
+	AssertStatus(t, rec, http.StatusUnauthorized)
+
+	// So does deleting, here or on another instance
+	s.ForgetUser("u2")
+	if code := call("u2"); code != http.StatusUnauthorized {
+		t.Errorf("deleted user: status %d", code)
+	}
+	s.SetUserActive("u4", true)
+	tc.Mock.ExpectQuery("SELECT id, is_active FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow("u1", false))
+	if err := s.loadUserStates(); err != nil {
+		t.Fatal(err)
+	}
+	if code := call("u4"); code != http.StatusUnauthorized {
+		t.Errorf("user missing from the reload: status %d", code)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
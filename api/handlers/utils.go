@@ -11,5 +11,5 @@ func statFile(path string) (os.FileInfo, error) {
 
 // writeFileAtomic writes content to file atomically
 func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
-	return os.WriteFile(path, content, perm)
+	return WriteFileSealed(path, content, perm)
 }
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// How a video is streamed to the browser
+const (
+	VideoStreamDirect    = "direct"    // the browser plays the file as it is
+	VideoStreamRemux     = "remux"     // the video is copied into fragmented MP4, audio converted if needed
+	VideoStreamTranscode = "transcode" // the video is re-encoded to H.264
+)
+
+// maxVideoTranscodes bounds the ffmpeg processes streaming previews at once
+const maxVideoTranscodes = 2
+
+// videoProbeTimeout bounds one ffprobe run
+const videoProbeTimeout = 15 * time.Second
+
+var videoTranscodeSlots = make(chan struct{}, maxVideoTranscodes)
+
+// videoProbe is what ffprobe reports about a video
+type videoProbe struct {
+	Container  string
+	VideoCodec string
+	AudioCodec string
+	Duration   float64
+}
+
+// VideoStreamInfo describes how a video preview is streamed
+type VideoStreamInfo struct {
+	Mode             string  `json:"mode"` // direct, remux or transcode
+	Container        string  `json:"container"`
+	VideoCodec       string  `json:"videoCodec"`
+	AudioCodec       string  `json:"audioCodec,omitempty"`
+	Duration         float64 `json:"duration"` // seconds
+	TranscodeEnabled bool    `json:"transcodeEnabled"`
+	// Whether start= seeks; direct streams seek with Range instead
+	Seekable bool `json:"seekable"`
+}
+
+// Codecs browsers decode in the containers they play natively
+var (
+	nativeMP4VideoCodecs  = map[string]bool{"h264": true, "av1": true, "vp9": true}
+	nativeMP4AudioCodecs  = map[string]bool{"": true, "aac": true, "mp3": true, "opus": true, "flac": true}
+	nativeWebMVideoCodecs = map[string]bool{"vp8": true, "vp9": true, "av1": true}
+	nativeWebMAudioCodecs = map[string]bool{"": true, "opus": true, "vorbis": true}
+)
+
+// parseVideoProbe reads the JSON of ffprobe -show_format -show_streams
+func parseVideoProbe(data []byte) (*videoProbe, error) {
+	var out struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+	probe := &videoProbe{Container: out.Format.FormatName}
+	probe.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	for _, stream := range out.Streams {
+		switch {
+		case stream.CodecType == "video" && probe.VideoCodec == "" && stream.CodecName != "mjpeg" && stream.CodecName != "png":
+			// Cover art is stored as a picture "video" stream
+			probe.VideoCodec = stream.CodecName
+		case stream.CodecType == "audio" && probe.AudioCodec == "":
+			probe.AudioCodec = stream.CodecName
+		}
+	}
+	if probe.VideoCodec == "" {
+		return nil, fmt.Errorf("no video stream")
+	}
+	return probe, nil
+}
+
+// probeVideo runs ffprobe on a file
+func probeVideo(ctx context.Context, realPath string) (*videoProbe, error) {
+	ctx, cancel := context.WithTimeout(ctx, videoProbeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", realPath)
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	return parseVideoProbe(data)
+}
+
+// videoStreamMode picks how a video reaches the browser: as it is when its
+// container and codecs play natively, copied into fragmented MP4 when only
+// the container is the problem, re-encoded otherwise
+func videoStreamMode(probe *videoProbe, ext string) string {
+	containers := strings.Split(probe.Container, ",")
+	has := func(name string) bool {
+		for _, c := range containers {
+			if c == name {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("mp4") && nativeMP4VideoCodecs[probe.VideoCodec] && nativeMP4AudioCodecs[probe.AudioCodec]:
+		return VideoStreamDirect
+	case has("webm") && ext == ".webm" && nativeWebMVideoCodecs[probe.VideoCodec] && nativeWebMAudioCodecs[probe.AudioCodec]:
+		return VideoStreamDirect
+	case probe.VideoCodec == "h264":
+		return VideoStreamRemux
+	}
+	return VideoStreamTranscode
+}
+
+// videoTranscodeArgs returns the ffmpeg arguments writing a fragmented MP4 of
+// realPath from start seconds on to stdout
+func videoTranscodeArgs(realPath string, probe *videoProbe, mode string, start float64) []string {
+	args := []string{"-hide_banner", "-loglevel", "error"}
+	if start > 0 {
+		// Seeking before the input jumps to the nearest keyframe quickly
+		args = append(args, "-ss", strconv.FormatFloat(start, 'f', 3, 64))
+	}
+	args = append(args, "-i", realPath, "-map", "0:v:0", "-map", "0:a:0?", "-sn", "-dn")
+	if mode == VideoStreamRemux {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args,
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "23",
+			"-pix_fmt", "yuv420p", "-vf", "scale='min(1920,iw)':-2")
+	}
+	if probe.AudioCodec == "aac" {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "160k", "-ac", "2")
+	}
+	return append(args,
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4", "pipe:1")
+}
+
+// StreamVideoPreview streams a video the browser can play
+// @Summary		Stream a video preview
+// @Description	Serves videos whose container and codecs browsers play as they are, with Range support. Others are converted with ffmpeg to fragmented MP4 while streaming: H.264 video is copied into the new container, other codecs are re-encoded. Converted streams cannot be ranged; start restarts the conversion at a position in seconds. info=true reports the stream mode and duration instead. Converting needs video_transcode_enabled and at most two conversions run at once. Access is checked like file downloads.
+// @Tags		Files
+// @Produce		video/mp4
+// @Param		path	path		string	true	"Virtual path of the video"
+// @Param		info	query		bool	false	"Report how the video is streamed instead of streaming it"
+// @Param		start	query		number	false	"Position in seconds to start a converted stream at"
+// @Success		200		{file}		binary	"Video stream"
+// @Success		206		{file}		binary	"Requested range of a direct stream"
+// @Failure		400		{object}	docs.ErrorResponse	"Not a video"
+// @Failure		401		{object}	docs.ErrorResponse	"Unauthorized"
+// @Failure		404		{object}	docs.ErrorResponse	"File not found"
+// @Failure		429		{object}	docs.ErrorResponse	"Too many conversions running"
+// @Failure		503		{object}	docs.ErrorResponse	"ffmpeg missing or conversion disabled"
+// @Security	BearerAuth
+// @Router		/preview/stream/{path} [get]
+func (h *Handler) StreamVideoPreview(c echo.Context) error {
+	requestPath := c.Param("*")
+	if requestPath == "" {
+		return RespondError(c, ErrMissingParameter("path"))
+	}
+	decodedPath, err := url.PathUnescape(requestPath)
+	if err != nil {
+		decodedPath = requestPath
+	}
+
+	realPath, _, info, apiErr := h.resolveReadableFile("/"+decodedPath, GetClaims(c))
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+	ext := strings.ToLower(filepath.Ext(info.Name()))
+	if !strings.HasPrefix(getMimeType(strings.TrimPrefix(ext, ".")), "video/") {
+		return RespondError(c, ErrBadRequest("Not a video"))
+	}
+
+	// ffmpeg cannot read files encrypted at rest; they are served as they are
+	if GetFileEncryption().Active() && IsEncryptedFile(realPath) {
+		if c.QueryParam("info") == "true" {
+			return RespondSuccess(c, VideoStreamInfo{Mode: VideoStreamDirect})
+		}
+		return ServePlainFile(c, realPath)
+	}
+
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return RespondError(c, NewAPIError(ErrCodeServiceUnavailable, "ffmpeg is not installed"))
+	}
+	probe, err := probeVideo(c.Request().Context(), realPath)
+	if err != nil {
+		return RespondError(c, ErrBadRequest("Video could not be read").WithDetails(map[string]string{"reason": err.Error()}))
+	}
+	mode := videoStreamMode(probe, ext)
+	enabled := true
+	if settings := GetGlobalSettingsHandler(); settings != nil {
+		enabled = settings.IsVideoTranscodeEnabled()
+	}
+
+	if c.QueryParam("info") == "true" {
+		return RespondSuccess(c, VideoStreamInfo{
+			Mode:             mode,
+			Container:        probe.Container,
+			VideoCodec:       probe.VideoCodec,
+			AudioCodec:       probe.AudioCodec,
+			Duration:         probe.Duration,
+			TranscodeEnabled: enabled,
+			Seekable:         mode != VideoStreamDirect,
+		})
+	}
+
+	if mode == VideoStreamDirect {
+		c.Response().Header().Set("ETag", GenerateETag(realPath, info.ModTime(), info.Size()))
+		return ServePlainFile(c, realPath)
+	}
+	if !enabled {
+		return RespondError(c, NewAPIError(ErrCodeServiceUnavailable, "Video conversion is disabled").
+			WithDetails(map[string]string{"mode": mode}))
+	}
+
+	var start float64
+	if s := c.QueryParam("start"); s != "" {
+		if start, err = strconv.ParseFloat(s, 64); err != nil || start < 0 || (probe.Duration > 0 && start >= probe.Duration) {
+			return RespondError(c, ErrBadRequest("start must be a position within the video in seconds"))
+		}
+	}
+
+	select {
+	case videoTranscodeSlots <- struct{}{}:
+		defer func() { <-videoTranscodeSlots }()
+	default:
+		return RespondError(c, NewAPIError(ErrCodeRateLimited, "Too many videos are being converted; retry later"))
+	}
+
+	cmd := exec.CommandContext(c.Request().Context(), "ffmpeg", videoTranscodeArgs(realPath, probe, mode, start)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return RespondError(c, ErrOperationFailed("convert video", err))
+	}
+	if err := cmd.Start(); err != nil {
+		return RespondError(c, ErrOperationFailed("convert video", err))
+	}
+
+	header := c.Response().Header()
+	header.Set("Content-Type", "video/mp4")
+	header.Set("Cache-Control", "no-store")
+	header.Set("Accept-Ranges", "none")
+	header.Set("X-Video-Stream-Mode", mode)
+	header.Set("X-Video-Start", strconv.FormatFloat(start, 'f', 3, 64))
+	if probe.Duration > 0 {
+		header.Set("X-Video-Duration", strconv.FormatFloat(probe.Duration, 'f', 3, 64))
+	}
+	c.Response().WriteHeader(http.StatusOK)
+	_, copyErr := io.Copy(c.Response(), stdout)
+	if err := cmd.Wait(); err != nil && copyErr == nil && c.Request().Context().Err() == nil {
+		log.Printf("[Video] ffmpeg failed for %s: %v: %s", realPath, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
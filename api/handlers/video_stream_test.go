@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseVideoProbe(t *testing.T) {
+	probe, err := parseVideoProbe([]byte(`{
+		"streams": [
+			{"codec_type": "video", "codec_name": "mjpeg"},
+			{"codec_type": "video", "codec_name": "hevc"},
+			{"codec_type": "audio", "codec_name": "ac3"},
+			{"codec_type": "subtitle", "codec_name": "subrip"}
+		],
+		"format": {"format_name": "matroska,webm", "duration": "5423.120000"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe.Container != "matroska,webm" || probe.VideoCodec != "hevc" || probe.AudioCodec != "ac3" || probe.Duration != 5423.12 {
+		t.Errorf("probe = %+v", probe)
+	}
+
+	if _, err := parseVideoProbe([]byte(`{"streams": [{"codec_type": "audio", "codec_name": "mp3"}], "format": {}}`)); err == nil {
+		t.Error("file without video accepted")
+	}
+}
+
+func TestVideoStreamMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe videoProbe
+		ext   string
+		want  string
+	}{
+		{"mp4 h264", videoProbe{Container: "mov,mp4,m4a,3gp,3g2,mj2", VideoCodec: "h264", AudioCodec: "aac"}, ".mp4", VideoStreamDirect},
+		{"webm vp9", videoProbe{Container: "matroska,webm", VideoCodec: "vp9", AudioCodec: "opus"}, ".webm", VideoStreamDirect},
+		{"mkv vp9", videoProbe{Container: "matroska,webm", VideoCodec: "vp9", AudioCodec: "opus"}, ".mkv", VideoStreamTranscode},
+		{"mkv h264", videoProbe{Container: "matroska,webm", VideoCodec: "h264", AudioCodec: "ac3"}, ".mkv", VideoStreamRemux},
+		{"mp4 h264 with dts", videoProbe{Container: "mov,mp4,m4a,3gp,3g2,mj2", VideoCodec: "h264", AudioCodec: "dts"}, ".mp4", VideoStreamRemux},
+		{"mkv hevc", videoProbe{Container: "matroska,webm", VideoCodec: "hevc", AudioCodec: "aac"}, ".mkv", VideoStreamTranscode},
+		{"mp4 hevc", videoProbe{Container: "mov,mp4,m4a,3gp,3g2,mj2", VideoCodec: "hevc", AudioCodec: "aac"}, ".mp4", VideoStreamTranscode},
+	}
+	for _, tt := range tests {
+		if got := videoStreamMode(&tt.probe, tt.ext); got != tt.want {
+			t.Errorf("%s: mode = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVideoTranscodeArgs(t *testing.T) {
+	remux := strings.Join(videoTranscodeArgs("/data/a.mkv", &videoProbe{VideoCodec: "h264", AudioCodec: "aac"}, VideoStreamRemux, 0), " ")
+	if strings.Contains(remux, "-ss") || !strings.Contains(remux, "-c:v copy") || !strings.Contains(remux, "-c:a copy") {
+		t.Errorf("remux args = %s", remux)
+	}
+
+	transcode := strings.Join(videoTranscodeArgs("/data/a.mkv", &videoProbe{VideoCodec: "hevc", AudioCodec: "ac3"}, VideoStreamTranscode, 90.5), " ")
+	if !strings.HasPrefix(transcode, "-hide_banner -loglevel error -ss 90.500 -i /data/a.mkv") ||
+		!strings.Contains(transcode, "-c:v libx264") || !strings.Contains(transcode, "-c:a aac") ||
+		!strings.HasSuffix(transcode, "-movflags frag_keyframe+empty_moov+default_base_moof -f mp4 pipe:1") {
+		t.Errorf("transcode args = %s", transcode)
+	}
+}
+
+func TestStreamVideoPreview_RejectsOtherFiles(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	writeTestFiles(t, home, "notes.txt")
+
+	for path, want := range map[string]int{"home/notes.txt": http.StatusBadRequest, "home/missing.mkv": http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/preview/stream/"+path, nil)
+		c := CreateAuthenticatedContext(tc.Echo, rec, req, "u1", "alice", false)
+		c.SetParamNames("*")
+		c.SetParamValues(path)
+		if err := h.StreamVideoPreview(c); err != nil {
+			t.Fatal(err)
+		}
+		AssertStatus(t, rec, want)
+	}
+}
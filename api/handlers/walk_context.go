@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// walkCheckEvery is how many entries a request's walk visits between
+// checks of its context; checking on every entry is measurable on large trees
+const walkCheckEvery = 256
+
+// walkBudgetRetryAfter is the delay suggested to clients whose request ran
+// out of its time budget (the stats cache may have an answer by then)
+const walkBudgetRetryAfter = 30
+
+// errWalkBudget is returned by walks stopped at their request's time budget
+var errWalkBudget = errors.New("operation time budget exceeded")
+
+// Per-endpoint time budgets, with their settings
+var (
+	budgetFolderStats = operationBudget{"folder_stats_budget_seconds", 30 * time.Second}
+	budgetSearch      = operationBudget{"search_budget_seconds", 20 * time.Second}
+	budgetQuotaCheck  = operationBudget{"quota_check_budget_seconds", 10 * time.Second}
+)
+
+// operationBudget bounds how long a request may spend walking the filesystem
+type operationBudget struct {
+	setting string
+	def     time.Duration
+}
+
+// duration reads the budget from settings; 0 or less disables it
+func (b operationBudget) duration() time.Duration {
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		return time.Duration(sh.GetSettingInt(b.setting, int(b.def/time.Second))) * time.Second
+	}
+	return b.def
+}
+
+// Context derives the context a walk for a request runs under from the
+// request's context: it ends when the client disconnects or the budget runs out
+func (b operationBudget) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := b.duration(); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// walkGuard stops a walk once its context ends. Check is called for every
+// entry and looks at the context every walkCheckEvery entries.
+type walkGuard struct {
+	ctx context.Context
+	n   int
+}
+
+func newWalkGuard(ctx context.Context) *walkGuard {
+	return &walkGuard{ctx: ctx}
+}
+
+// Check returns errWalkBudget when the budget ran out, the context's error
+// when the request was cancelled, and nil otherwise
+func (g *walkGuard) Check() error {
+	if g.n++; g.n%walkCheckEvery != 0 {
+		return nil
+	}
+	return walkStopped(g.ctx)
+}
+
+// walkStopped reports why ctx ended, mapping a timeout to errWalkBudget
+func walkStopped(ctx context.Context) error {
+	switch err := ctx.Err(); {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return errWalkBudget
+	default:
+		return err
+	}
+}
+
+// respondWalkStopped answers a request whose walk was stopped. A cancelled
+// request gets no response (the client is gone and partial results are
+// discarded); a request over its budget gets a retryable 503.
+func respondWalkStopped(c echo.Context, err error) error {
+	if errors.Is(err, errWalkBudget) {
+		return RespondError(c, errBudgetExceeded())
+	}
+	return nil
+}
+
+// errBudgetExceeded is the retryable error of a request over its budget
+func errBudgetExceeded() *APIError {
+	return NewAPIError(ErrCodeServiceUnavailable, "The operation took too long; retry later").
+		WithDetails(map[string]interface{}{"retryAfter": walkBudgetRetryAfter})
+}
+
+// isWalkStopped reports whether err is a stopped walk rather than a failure
+func isWalkStopped(err error) bool {
+	return errors.Is(err, errWalkBudget) || errors.Is(err, context.Canceled)
+}
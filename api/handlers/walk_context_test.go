@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countdownContext reports cancellation once Err has been called left times,
+// so a walk is cancelled at a known point in the middle
+type countdownContext struct {
+	context.Context
+	left  atomic.Int64
+	calls atomic.Int64
+}
+
+func newCountdownContext(left int64) *countdownContext {
+	ctx := &countdownContext{Context: context.Background()}
+	ctx.left.Store(left)
+	return ctx
+}
+
+func (c *countdownContext) Err() error {
+	c.calls.Add(1)
+	if c.left.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+// syntheticTree creates dirs folders of files empty files each below root
+func syntheticTree(t *testing.T, root string, dirs, files int) {
+	t.Helper()
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%02d", d))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for f := 0; f < files; f++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%04d.txt", f)), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// waitGoroutines waits for the goroutine count to drop back to baseline
+func waitGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if runtime.NumGoroutine() <= baseline {
+			return
+		}
+	}
+	t.Errorf("goroutines leaked: %d running, %d before", runtime.NumGoroutine(), baseline)
+}
+
+func TestFolderStatsWalkStopsOnCancel(t *testing.T) {
+	root := t.TempDir()
+	syntheticTree(t, root, 20, 200)
+	h := &Handler{dataRoot: root}
+
+	ctx := newCountdownContext(3)
+	stats, err := h.computeFolderStatsInternal(ctx, root)
+	if !errors.Is(err, context.Canceled) || stats != nil {
+		t.Fatalf("stats, err = %+v, %v; want the walk cancelled", stats, err)
+	}
+	// The walk stops at the first check after the cancellation
+	if calls := ctx.calls.Load(); calls != 4 {
+		t.Errorf("context checked %d times, want 4", calls)
+	}
+
+	stats, err = h.computeFolderStatsInternal(context.Background(), root)
+	if err != nil || stats.FileCount != 4000 || stats.FolderCount != 20 {
+		t.Errorf("uncancelled stats = %+v, %v", stats, err)
+	}
+}
+
+func TestSearchStopsOnCancel(t *testing.T) {
+	root := t.TempDir()
+	syntheticTree(t, root, 20, 200)
+	h := &Handler{dataRoot: root}
+	baseline := runtime.NumGoroutine()
+
+	ctx := newCountdownContext(5)
+	started := time.Now()
+	results := h.searchInDirParallel(ctx, root, "/home", "file", false, 10000)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("cancelled search took %v", elapsed)
+	}
+	if len(results) >= 4000 {
+		t.Errorf("cancelled search found all %d files", len(results))
+	}
+	waitGoroutines(t, baseline)
+
+	if all := h.searchInDirParallel(context.Background(), root, "/home", "file", false, 10000); len(all) != 4000 {
+		t.Errorf("uncancelled search found %d files, want 4000", len(all))
+	}
+}
+
+func TestGetFolderStats_OverBudget(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	useLocalStatsCache(t)
+	syntheticTree(t, filepath.Join(home, "big"), 4, 200)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	req, _ := NewJSONRequest(http.MethodGet, "/api/folders/stats/home/big", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req.WithContext(ctx), "u1", "alice", false)
+	c.SetParamNames("*")
+	c.SetParamValues("home/big")
+	if err := h.GetFolderStats(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusServiceUnavailable)
+	if got := tc.Recorder.Header().Get("Retry-After"); got == "" {
+		t.Error("missing Retry-After")
+	}
+	// The partial walk must not be cached
+	if _, ok := GetStatsCache().Get(filepath.Join(home, "big")); ok {
+		t.Error("partial stats were cached")
+	}
+}
+
+func TestSearchFiles_OverBudgetIsPartial(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	syntheticTree(t, filepath.Join(home, "big"), 4, 200)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	req, _ := NewJSONRequest(http.MethodGet, "/api/files/search?q=file&path=/home&matchType=name", nil)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req.WithContext(ctx), "u1", "alice", false)
+	if err := h.SearchFiles(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	var resp SearchResponse
+	if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Partial || resp.Total >= 800 {
+		t.Errorf("partial = %v, total = %d", resp.Partial, resp.Total)
+	}
+}
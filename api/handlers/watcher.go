@@ -224,6 +224,9 @@ func (fw *FileWatcher) eventLoop() {
 				continue
 			}
 
+			// Listings change with every event, including those debounced below
+			GetListingVersions().Invalidate(event.Name)
+
 			// Smart debounce logic:
 			// 1. Skip WRITE events if we recently saw CREATE for same file
 			// 2. Skip duplicate events of same type within debounce interval
@@ -276,6 +279,33 @@ func (fw *FileWatcher) eventLoop() {
 			log.Printf("[Watcher] Event: %s %s (isDir: %v)", eventType, virtualPath, isDir)
 			BroadcastFileChange(changeEvent)
 
+			// Journal changes made outside the API (SMB, direct disk access)
+			GetChangeJournal().RecordWatcherEvent(event.Name, eventType)
+
+			// Thumbnails are made ahead for new images and videos and
+			// dropped with their source; document text and folder sizes
+			// are indexed likewise
+			switch eventType {
+			case "create", "write":
+				if !isDir {
+					PregenerateThumbnails(event.Name)
+				}
+				GetContentIndex().Changed(event.Name)
+				GetDirSizes().Changed(event.Name)
+				GetFileChecksums().Changed(event.Name)
+			case "remove", "rename":
+				GetThumbnailCache().Invalidate(event.Name)
+				GetContentIndex().ForgetTree(event.Name)
+				GetDirSizes().ForgetTree(event.Name)
+				GetFileChecksums().ForgetTree(event.Name)
+			}
+
+			// Last-writer hint for conflict detection; the SMB audit sync
+			// attributes it to a user later
+			if !isDir && (eventType == "create" || eventType == "write") {
+				GetWriterHints().NoteExternal(event.Name, now)
+			}
+
 			// SMB audit logging is now handled by vfs_full_audit (smb_audit_handler.go)
 			// which provides accurate username and IP information
 			_ = username       // Suppress unused variable warning
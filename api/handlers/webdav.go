@@ -19,7 +19,7 @@ import (
 type WebDAVHandler struct {
 	db         *sql.DB
 	dataRoot   string
-	lockSystem webdav.LockSystem
+	locks      *davLocks
 }
 
 // NewWebDAVHandler creates a new WebDAV handler
@@ -27,7 +27,7 @@ func NewWebDAVHandler(db *sql.DB, dataRoot string) *WebDAVHandler {
 	return &WebDAVHandler{
 		db:         db,
 		dataRoot:   dataRoot,
-		lockSystem: webdav.NewMemLS(),
+		locks:      newDAVLocks(),
 	}
 }
 
@@ -58,30 +58,33 @@ func (h *WebDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create virtual filesystem for this user
+	// Log access
+	h.logAccess(user.ID, r)
+
+	// Serve WebDAV request
+	capLockTimeout(r)
+	h.davHandler(user).ServeHTTP(w, r)
+}
+
+// davHandler returns the WebDAV handler serving the virtual filesystem of user
+func (h *WebDAVHandler) davHandler(user *UserInfo) *webdav.Handler {
 	vfs := &VirtualFS{
 		db:       h.db,
 		dataRoot: h.dataRoot,
 		user:     user,
 	}
 
-	// Create WebDAV handler with shared lock system
-	davHandler := &webdav.Handler{
+	// Locks are shared between users, keyed by the real files
+	return &webdav.Handler{
 		Prefix:     "/webdav",
 		FileSystem: vfs,
-		LockSystem: h.lockSystem,
+		LockSystem: h.locks.forUser(vfs),
 		Logger: func(r *http.Request, err error) {
 			if err != nil {
 				fmt.Printf("[WebDAV] %s %s: %v\n", r.Method, r.URL.Path, err)
 			}
 		},
 	}
-
-	// Log access
-	h.logAccess(user.ID, r)
-
-	// Serve WebDAV request
-	davHandler.ServeHTTP(w, r)
 }
 
 // UserInfo holds basic user info
@@ -105,6 +108,9 @@ func (h *WebDAVHandler) authenticateUser(username, password string) (*UserInfo,
 	if err != nil {
 		return nil, fmt.Errorf("user not found")
 	}
+	if GetGuests().Expired(user.ID) {
+		return nil, fmt.Errorf("guest account has expired")
+	}
 
 	if !smbHash.Valid || smbHash.String == "" {
 		return nil, fmt.Errorf("application password not set")
@@ -231,8 +237,25 @@ func (vfs *VirtualFS) OpenFile(ctx context.Context, name string, flag int, perm
 	if err != nil {
 		return nil, err
 	}
+	// Directories are opened for PROPPATCH with write access; there is
+	// nothing in them to write
+	if info, err := os.Stat(realPath); err == nil && info.IsDir() {
+		flag = os.O_RDONLY
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC) != 0 {
+		if GetRetention().Blocks(realPath, false) {
+			return nil, os.ErrPermission
+		}
+		if err := copyOnWrite(realPath, flag&os.O_TRUNC == 0); err != nil {
+			return nil, err
+		}
+	}
 
-	return os.OpenFile(realPath, flag, perm)
+	f, err := os.OpenFile(realPath, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{f}, nil
 }
 
 // RemoveAll removes a file or directory
@@ -241,6 +264,9 @@ func (vfs *VirtualFS) RemoveAll(ctx context.Context, name string) error {
 	if err != nil {
 		return err
 	}
+	if GetRetention().Blocks(realPath, true) {
+		return os.ErrPermission
+	}
 	return os.RemoveAll(realPath)
 }
 
@@ -254,6 +280,10 @@ func (vfs *VirtualFS) Rename(ctx context.Context, oldName, newName string) error
 	if err != nil {
 		return err
 	}
+	// Files under a retention hold stay put, and so do the ones a move would replace
+	if GetRetention().Blocks(oldPath, true) || GetRetention().Blocks(newPath, true) {
+		return os.ErrPermission
+	}
 	return os.Rename(oldPath, newPath)
 }
 
@@ -280,7 +310,11 @@ func (vfs *VirtualFS) Stat(ctx context.Context, name string) (os.FileInfo, error
 		return nil, err
 	}
 
-	return os.Stat(realPath)
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return nil, err
+	}
+	return davFileInfo{info}, nil
 }
 
 // resolvePath converts virtual path to real filesystem path
@@ -289,6 +323,10 @@ func (vfs *VirtualFS) resolvePath(name string, write bool) (string, error) {
 
 	// /home/* -> user's home directory (uses /data/users/{username})
 	if strings.HasPrefix(name, "/home/") || name == "/home" {
+		// Guests have no home folder
+		if GetGuests().IsGuest(vfs.user.ID) {
+			return "", os.ErrPermission
+		}
 		subPath := strings.TrimPrefix(name, "/home")
 		userHome := filepath.Join(vfs.dataRoot, "users", vfs.user.Username)
 
@@ -297,7 +335,7 @@ func (vfs *VirtualFS) resolvePath(name string, write bool) (string, error) {
 			return "", err
 		}
 
-		return filepath.Join(userHome, subPath), nil
+		return guardEncryptedPath(filepath.Join(userHome, subPath))
 	}
 
 	// /shared/{folder-name}/* -> shared folder
@@ -321,12 +359,25 @@ func (vfs *VirtualFS) resolvePath(name string, write bool) (string, error) {
 			subPath = parts[1]
 		}
 
-		return filepath.Join(folder.Path, subPath), nil
+		return guardEncryptedPath(filepath.Join(folder.Path, subPath))
 	}
 
 	return "", os.ErrNotExist
 }
 
+// guardEncryptedPath denies WebDAV access to encrypted folders and files.
+// WebDAV serves files as stored, so it would only expose ciphertext.
+func guardEncryptedPath(realPath string) (string, error) {
+	e := GetFileEncryption()
+	if !e.Active() {
+		return realPath, nil
+	}
+	if e.InEncryptedFolder(realPath) || IsEncryptedFile(realPath) {
+		return "", os.ErrPermission
+	}
+	return realPath, nil
+}
+
 // SharedFolderInfo holds shared folder info
 type SharedFolderInfo struct {
 	ID         string
@@ -341,10 +392,10 @@ func (vfs *VirtualFS) getSharedFolder(name string) (*SharedFolderInfo, error) {
 	var permLevel int
 
 	err := vfs.db.QueryRow(`
-		SELECT sf.id, sf.name, sfm.permission_level
+		SELECT sf.id, sf.name, sfa.permission_level
 		FROM shared_folders sf
-		JOIN shared_folder_members sfm ON sf.id = sfm.shared_folder_id
-		WHERE sf.name = $1 AND sfm.user_id = $2 AND sf.is_active = true
+		JOIN shared_folder_access sfa ON sf.id = sfa.shared_folder_id
+		WHERE sf.name = $1 AND sfa.user_id = $2 AND sf.is_active = true
 	`, name, vfs.user.ID).Scan(&folder.ID, &folder.Name, &permLevel)
 
 	if err != nil {
@@ -383,10 +434,10 @@ func (vfs *VirtualFS) getSharedFolder(name string) (*SharedFolderInfo, error) {
 // getUserSharedFolders returns all shared folders the user has access to
 func (vfs *VirtualFS) getUserSharedFolders() ([]SharedFolderInfo, error) {
 	query := `
-		SELECT sf.id, sf.name, sfm.permission_level
+		SELECT sf.id, sf.name, sfa.permission_level
 		FROM shared_folders sf
-		JOIN shared_folder_members sfm ON sf.id = sfm.shared_folder_id
-		WHERE sfm.user_id = $1 AND sf.is_active = true
+		JOIN shared_folder_access sfa ON sf.id = sfa.shared_folder_id
+		WHERE sfa.user_id = $1 AND sf.is_active = true
 		ORDER BY sf.name
 	`
 
@@ -422,8 +473,8 @@ func (vfs *VirtualFS) getUserSharedFolders() ([]SharedFolderInfo, error) {
 			FROM shared_folders sf
 			WHERE sf.created_by = $1 AND sf.is_active = true
 			AND NOT EXISTS (
-				SELECT 1 FROM shared_folder_members sfm
-				WHERE sfm.shared_folder_id = sf.id AND sfm.user_id = $1
+				SELECT 1 FROM shared_folder_access sfa
+				WHERE sfa.shared_folder_id = sf.id AND sfa.user_id = $1
 			)
 		`
 		adminRows, err := vfs.db.Query(adminQuery, vfs.user.ID)
@@ -452,7 +503,7 @@ type virtualDirInfo struct {
 func (v *virtualDirInfo) Name() string       { return v.name }
 func (v *virtualDirInfo) Size() int64        { return 0 }
 func (v *virtualDirInfo) Mode() os.FileMode  { return os.ModeDir | 0755 }
-func (v *virtualDirInfo) ModTime() time.Time { return time.Now() }
+func (v *virtualDirInfo) ModTime() time.Time { return webdavStarted }
 func (v *virtualDirInfo) IsDir() bool        { return v.isDir }
 func (v *virtualDirInfo) Sys() interface{}   { return nil }
 
@@ -516,6 +567,9 @@ func (d *VirtualHomeDir) ensureOpen() error {
 	if d.opened {
 		return nil
 	}
+	if GetGuests().IsGuest(d.vfs.user.ID) {
+		return os.ErrPermission
+	}
 	userHome := filepath.Join(d.vfs.dataRoot, "users", d.vfs.user.Username)
 	if err := os.MkdirAll(userHome, 0755); err != nil {
 		return err
@@ -548,7 +602,8 @@ func (d *VirtualHomeDir) Readdir(count int) ([]os.FileInfo, error) {
 	if err := d.ensureOpen(); err != nil {
 		return nil, err
 	}
-	return d.realDir.Readdir(count)
+	infos, err := d.realDir.Readdir(count)
+	return davFileInfos(infos), err
 }
 
 func (d *VirtualHomeDir) Stat() (os.FileInfo, error) {
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// webdavStarted is the modification time reported for the virtual
+// directories, so their ETags stay the same between requests
+var webdavStarted = time.Now()
+
+// davMicrosoftNS is the namespace of the Win32* properties Windows sets with
+// PROPPATCH after creating a file or folder
+const davMicrosoftNS = "urn:schemas-microsoft-com:"
+
+// davFileInfo adds a stable ETag to the file info of a real file
+type davFileInfo struct {
+	os.FileInfo
+}
+
+// ETag implements webdav.ETager. Inode, modification time and size change
+// whenever the content does, including when a save replaces the file.
+func (fi davFileInfo) ETag(ctx context.Context) (string, error) {
+	var ino uint64
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		ino = uint64(st.Ino)
+	}
+	return fmt.Sprintf(`"%x-%x-%x"`, ino, fi.ModTime().UnixNano(), fi.Size()), nil
+}
+
+func davFileInfos(infos []os.FileInfo) []os.FileInfo {
+	for i, fi := range infos {
+		infos[i] = davFileInfo{fi}
+	}
+	return infos
+}
+
+// davFile is a real file served over WebDAV
+type davFile struct {
+	*os.File
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return davFileInfo{fi}, nil
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	return davFileInfos(infos), err
+}
+
+// DeadProps implements webdav.DeadPropsHolder. No properties are stored.
+func (f *davFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	return map[xml.Name]webdav.Property{}, nil
+}
+
+// Patch implements webdav.DeadPropsHolder. Windows sets Win32 times and
+// attributes on every new file and folder and gives up on the item when
+// that fails; these are accepted, with the modification time applied.
+// Other properties cannot be stored and are refused.
+func (f *davFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	accepted := webdav.Propstat{Status: http.StatusOK}
+	refused := webdav.Propstat{Status: http.StatusForbidden}
+	var modified string
+	for _, patch := range patches {
+		for _, p := range patch.Props {
+			name := webdav.Property{XMLName: p.XMLName}
+			if p.XMLName.Space != davMicrosoftNS {
+				refused.Props = append(refused.Props, name)
+				continue
+			}
+			if !patch.Remove && p.XMLName.Local == "Win32LastModifiedTime" {
+				modified = strings.TrimSpace(string(p.InnerXML))
+			}
+			accepted.Props = append(accepted.Props, name)
+		}
+	}
+	if len(refused.Props) > 0 {
+		// Nothing is applied when any property fails (RFC 4918 9.2)
+		if len(accepted.Props) == 0 {
+			return []webdav.Propstat{refused}, nil
+		}
+		accepted.Status = http.StatusFailedDependency
+		return []webdav.Propstat{refused, accepted}, nil
+	}
+	if t, err := http.ParseTime(modified); err == nil {
+		os.Chtimes(f.Name(), time.Time{}, t)
+	}
+	return []webdav.Propstat{accepted}, nil
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// webdavMaxLockTimeout caps the lifetime of a WebDAV lock. Clients that hold
+// a file open refresh their locks well within it (Office and the Windows
+// redirector ask for an hour); a client that goes away without UNLOCK
+// leaves a file locked for at most this long instead of until a restart.
+const webdavMaxLockTimeout = time.Hour
+
+// davLocks holds the WebDAV locks of all users. Locks are keyed by the file
+// they resolve to, so a shared drive file is one lock for everyone while
+// /home/report.docx of two users are two, and remember who took them so
+// another user cannot use, refresh or release a token it got hold of.
+type davLocks struct {
+	ls webdav.LockSystem
+
+	mu     sync.Mutex
+	owners map[string]davLockOwner // token -> owner
+}
+
+type davLockOwner struct {
+	userID  string
+	expires time.Time // zero: no timeout
+}
+
+func newDAVLocks() *davLocks {
+	return &davLocks{ls: webdav.NewMemLS(), owners: make(map[string]davLockOwner)}
+}
+
+// forUser returns the lock system WebDAV requests of the user of vfs work on
+func (l *davLocks) forUser(vfs *VirtualFS) webdav.LockSystem {
+	return &davUserLocks{locks: l, vfs: vfs}
+}
+
+func (l *davLocks) owned(token, userID string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	o, ok := l.owners[token]
+	return ok && o.userID == userID && (o.expires.IsZero() || now.Before(o.expires))
+}
+
+func (l *davLocks) setOwner(token, userID string, now time.Time, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Locks that ran out are forgotten by the lock system on its own; drop
+	// their owners as new ones come in
+	for t, o := range l.owners {
+		if !o.expires.IsZero() && !now.Before(o.expires) {
+			delete(l.owners, t)
+		}
+	}
+	o := davLockOwner{userID: userID}
+	if d >= 0 {
+		o.expires = now.Add(d)
+	}
+	l.owners[token] = o
+}
+
+func (l *davLocks) dropOwner(token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.owners, token)
+}
+
+// davUserLocks is the view of davLocks for one user's requests. It turns the
+// names of the user's virtual file system into lock keys and back.
+type davUserLocks struct {
+	locks *davLocks
+	vfs   *VirtualFS
+}
+
+// key maps a virtual name to its lock key: the data-root relative path it
+// resolves to, or a name of the user's own for the virtual directories
+func (u *davUserLocks) key(name string) string {
+	if name == "" {
+		return ""
+	}
+	if realPath, err := u.vfs.resolvePath(name, false); err == nil {
+		if rel, err := filepath.Rel(u.vfs.dataRoot, realPath); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return path.Clean("/" + filepath.ToSlash(rel))
+		}
+	}
+	return path.Join("/dav", u.vfs.user.ID, path.Clean("/"+name))
+}
+
+// name maps a lock key back to the user's virtual name
+func (u *davUserLocks) name(key string) string {
+	home := "/users/" + u.vfs.user.Username
+	own := "/dav/" + u.vfs.user.ID
+	switch {
+	case key == home || strings.HasPrefix(key, home+"/"):
+		return "/home" + strings.TrimPrefix(key, home)
+	case key == own || strings.HasPrefix(key, own+"/"):
+		if name := strings.TrimPrefix(key, own); name != "" {
+			return name
+		}
+		return "/"
+	}
+	return key
+}
+
+func (u *davUserLocks) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	// Tokens of other users' locks do not count as held
+	own := make([]webdav.Condition, 0, len(conditions))
+	for _, c := range conditions {
+		if c.Token != "" && !c.Not && !u.locks.owned(c.Token, u.vfs.user.ID, now) {
+			continue
+		}
+		own = append(own, c)
+	}
+	return u.locks.ls.Confirm(now, u.key(name0), u.key(name1), own...)
+}
+
+func (u *davUserLocks) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Root = u.key(details.Root)
+	token, err := u.locks.ls.Create(now, details)
+	if err != nil {
+		return "", err
+	}
+	u.locks.setOwner(token, u.vfs.user.ID, now, details.Duration)
+	return token, nil
+}
+
+func (u *davUserLocks) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	if !u.locks.owned(token, u.vfs.user.ID, now) {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	ld, err := u.locks.ls.Refresh(now, token, duration)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	u.locks.setOwner(token, u.vfs.user.ID, now, duration)
+	ld.Root = u.name(ld.Root)
+	return ld, nil
+}
+
+func (u *davUserLocks) Unlock(now time.Time, token string) error {
+	if !u.locks.owned(token, u.vfs.user.ID, now) {
+		return webdav.ErrForbidden
+	}
+	if err := u.locks.ls.Unlock(now, token); err != nil {
+		return err
+	}
+	u.locks.dropOwner(token)
+	return nil
+}
+
+// capLockTimeout rewrites the Timeout header of a LOCK request that asks for
+// no timeout or more than webdavMaxLockTimeout, so the lock and the timeout
+// reported back to the client agree
+func capLockTimeout(r *http.Request) {
+	if r.Method != "LOCK" {
+		return
+	}
+	capped := fmt.Sprintf("Second-%d", int(webdavMaxLockTimeout/time.Second))
+	s := strings.TrimSpace(r.Header.Get("Timeout"))
+	if s == "" {
+		r.Header.Set("Timeout", capped)
+		return
+	}
+	// Only the first of a list of timeouts is looked at
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if strings.HasPrefix(s, "Second-") {
+		if n, err := strconv.ParseInt(s[len("Second-"):], 10, 64); err == nil && n >= 0 &&
+			n <= int64(webdavMaxLockTimeout/time.Second) {
+			return
+		}
+	}
+	r.Header.Set("Timeout", capped)
+}
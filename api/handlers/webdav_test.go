@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func davRequest(t *testing.T, h *WebDAVHandler, user *UserInfo, method, target string, header map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/webdav"+target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	capLockTimeout(req)
+	h.davHandler(user).ServeHTTP(rec, req)
+	return rec
+}
+
+const davLockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+
+func TestWebDAV_LocksPerUserAndToken(t *testing.T) {
+	dataRoot := t.TempDir()
+	h := NewWebDAVHandler(nil, dataRoot)
+	alice := &UserInfo{ID: "u1", Username: "alice"}
+	bob := &UserInfo{ID: "u2", Username: "bob"}
+
+	rec := davRequest(t, h, alice, "LOCK", "/home/report.docx", map[string]string{"Timeout": "Infinite"}, davLockBody)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("LOCK: status %d: %s", rec.Code, rec.Body.String())
+	}
+	token := rec.Header().Get("Lock-Token")
+	if token == "" {
+		t.Fatal("LOCK returned no token")
+	}
+	// Infinite locks are capped and reported as such
+	if !strings.Contains(rec.Body.String(), "Second-3600") {
+		t.Errorf("lock timeout not capped: %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "<D:href>/home/report.docx</D:href>") {
+		t.Errorf("lock root not the requested name: %s", rec.Body.String())
+	}
+
+	// Another user's home has a file of the same name
+	if rec := davRequest(t, h, bob, "LOCK", "/home/report.docx", nil, davLockBody); rec.Code != http.StatusCreated {
+		t.Errorf("LOCK of another home: status %d", rec.Code)
+	}
+
+	// Writes need the token, and only its owner can use or release it
+	if rec := davRequest(t, h, alice, "PUT", "/home/report.docx", nil, "x"); rec.Code != http.StatusLocked {
+		t.Errorf("PUT without token: status %d", rec.Code)
+	}
+	withToken := map[string]string{"If": "(" + token + ")"}
+	if rec := davRequest(t, h, alice, "PUT", "/home/report.docx", withToken, "saved"); rec.Code != http.StatusCreated && rec.Code != http.StatusNoContent {
+		t.Errorf("PUT with token: status %d", rec.Code)
+	}
+	if rec := davRequest(t, h, bob, "UNLOCK", "/home/report.docx", map[string]string{"Lock-Token": token}, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("UNLOCK by another user: status %d", rec.Code)
+	}
+	if rec := davRequest(t, h, alice, "UNLOCK", "/home/report.docx", map[string]string{"Lock-Token": token}, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("UNLOCK: status %d", rec.Code)
+	}
+	if rec := davRequest(t, h, alice, "DELETE", "/home/report.docx", nil, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE after UNLOCK: status %d", rec.Code)
+	}
+}
+
+func TestWebDAV_StableETags(t *testing.T) {
+	dataRoot := t.TempDir()
+	h := NewWebDAVHandler(nil, dataRoot)
+	alice := &UserInfo{ID: "u1", Username: "alice"}
+	writeTestFiles(t, filepath.Join(dataRoot, "users", "alice"), "docs/a.txt")
+
+	get := davRequest(t, h, alice, "GET", "/home/docs/a.txt", nil, "")
+	etag := get.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET returned no ETag")
+	}
+	propfind := davRequest(t, h, alice, "PROPFIND", "/home/docs", map[string]string{"Depth": "1"}, "")
+	if !strings.Contains(propfind.Body.String(), etag) {
+		t.Errorf("PROPFIND ETag differs from GET %s: %s", etag, propfind.Body.String())
+	}
+	if rec := davRequest(t, h, alice, "GET", "/home/docs/a.txt", map[string]string{"If-None-Match": etag}, ""); rec.Code != http.StatusNotModified {
+		t.Errorf("unchanged file: status %d", rec.Code)
+	}
+
+	// A save that replaces the file changes the ETag even with equal size and time
+	realPath := filepath.Join(dataRoot, "users", "alice", "docs", "a.txt")
+	info := mustStat(t, realPath)
+	tmp := realPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte("docs/b.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	if err := os.Rename(tmp, realPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := davRequest(t, h, alice, "GET", "/home/docs/a.txt", nil, "").Header().Get("ETag"); got == etag {
+		t.Error("ETag unchanged after the file was replaced")
+	}
+
+	// Virtual directories keep their modification time
+	root := regexp.MustCompile(`<D:getlastmodified>[^<]*</D:getlastmodified>`)
+	first := root.FindAllString(davRequest(t, h, alice, "PROPFIND", "/", map[string]string{"Depth": "1"}, "").Body.String(), -1)
+	time.Sleep(1100 * time.Millisecond)
+	second := root.FindAllString(davRequest(t, h, alice, "PROPFIND", "/", map[string]string{"Depth": "1"}, "").Body.String(), -1)
+	if len(first) == 0 || strings.Join(first, "") != strings.Join(second, "") {
+		t.Errorf("root modified %v, then %v", first, second)
+	}
+}
+
+func TestWebDAV_NewFolderProppatch(t *testing.T) {
+	dataRoot := t.TempDir()
+	h := NewWebDAVHandler(nil, dataRoot)
+	alice := &UserInfo{ID: "u1", Username: "alice"}
+
+	if rec := davRequest(t, h, alice, "MKCOL", "/home/New%20folder", nil, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("MKCOL: status %d", rec.Code)
+	}
+	rec := davRequest(t, h, alice, "LOCK", "/home/New%20folder", map[string]string{"Depth": "0"}, davLockBody)
+	if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+		t.Fatalf("LOCK: status %d", rec.Code)
+	}
+	token := rec.Header().Get("Lock-Token")
+
+	// What Explorer sends after creating a folder
+	body := `<?xml version="1.0" encoding="utf-8" ?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:schemas-microsoft-com:"><D:set><D:prop>
+<Z:Win32CreationTime>Mon, 15 Jan 2024 10:00:00 GMT</Z:Win32CreationTime>
+<Z:Win32LastModifiedTime>Mon, 15 Jan 2024 10:00:00 GMT</Z:Win32LastModifiedTime>
+<Z:Win32FileAttributes>00000010</Z:Win32FileAttributes>
+</D:prop></D:set></D:propertyupdate>`
+	rec = davRequest(t, h, alice, "PROPPATCH", "/home/New%20folder", map[string]string{"If": "(" + token + ")"}, body)
+	if rec.Code != http.StatusMultiStatus || !strings.Contains(rec.Body.String(), "200 OK") || strings.Contains(rec.Body.String(), "403") {
+		t.Fatalf("PROPPATCH: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := mustStat(t, filepath.Join(dataRoot, "users", "alice", "New folder")).ModTime().UTC().Year(); got != 2024 {
+		t.Errorf("modification time not applied, year %d", got)
+	}
+
+	// Properties outside the Microsoft namespace cannot be stored
+	body = `<?xml version="1.0" encoding="utf-8" ?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:X="urn:example"><D:set><D:prop><X:color>red</X:color></D:prop></D:set></D:propertyupdate>`
+	rec = davRequest(t, h, alice, "PROPPATCH", "/home/New%20folder", map[string]string{"If": "(" + token + ")"}, body)
+	if !strings.Contains(rec.Body.String(), "403") {
+		t.Errorf("PROPPATCH of other properties: %s", rec.Body.String())
+	}
+}
@@ -156,6 +156,17 @@ func (h *Hub) run() {
 	}
 }
 
+// ClientCounts returns the number of connected WebSocket clients per username
+func (h *Hub) ClientCounts() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	counts := make(map[string]int)
+	for client := range h.clients {
+		counts[client.username]++
+	}
+	return counts
+}
+
 func (h *Hub) shouldNotify(client *Client, path string) bool {
 	// Get the parent directory of the changed file
 	parentPath := filepath.Dir(path)
@@ -192,15 +203,15 @@ type NotificationEvent struct {
 	Data *Notification `json:"data"`
 }
 
-// BroadcastNotification sends a notification to a specific user
-func BroadcastNotification(userID string, notif *Notification) {
-	event := NotificationEvent{
-		Type: "notification",
-		Data: notif,
-	}
-
-	data := mustMarshal(event)
+// UnreadCountEvent tells a user's clients the current unread notification
+// count, so badges update without refetching the list
+type UnreadCountEvent struct {
+	Type        string `json:"type"` // Always "notification.unread"
+	UnreadCount int    `json:"unreadCount"`
+}
 
+// sendToUser queues a message for every connection of a user
+func sendToUser(userID string, data []byte, kind string) {
 	hub.mu.RLock()
 	defer hub.mu.RUnlock()
 
@@ -208,7 +219,7 @@ func BroadcastNotification(userID string, notif *Notification) {
 		if client.userID == userID {
 			select {
 			case client.send <- data:
-				log.Printf("[WebSocket] Notification sent to user %s: %s", client.username, notif.Type)
+				log.Printf("[WebSocket] Notification sent to user %s: %s", client.username, kind)
 			default:
 				log.Printf("[WebSocket] Notification buffer full for user %s", client.username)
 			}
@@ -216,6 +227,24 @@ func BroadcastNotification(userID string, notif *Notification) {
 	}
 }
 
+// BroadcastNotification sends a notification to a specific user
+func BroadcastNotification(userID string, notif *Notification) {
+	event := NotificationEvent{
+		Type: "notification",
+		Data: notif,
+	}
+	sendToUser(userID, mustMarshal(event), notif.Type)
+}
+
+// BroadcastUnreadCount sends the unread notification count to a specific user
+func BroadcastUnreadCount(userID string, count int) {
+	event := UnreadCountEvent{
+		Type:        "notification.unread",
+		UnreadCount: count,
+	}
+	sendToUser(userID, mustMarshal(event), event.Type)
+}
+
 // HandleWebSocket handles WebSocket connections for file change notifications
 func (h *Handler) HandleWebSocket(c echo.Context) error {
 	// Get token from query parameter (WebSocket connections can't use Authorization header)
@@ -242,6 +271,12 @@ func (h *Handler) HandleWebSocket(c echo.Context) error {
 			"error": "Invalid token claims",
 		})
 	}
+	if claims.IsLimited() {
+		return respondLimitedToken(c)
+	}
+	if !GetSessions().TokenAllowed(claims) {
+		return respondSessionEnded(c)
+	}
 
 	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"archive/zip"
+	"compress/flate"
 	"fmt"
 	"io"
 	"net/http"
@@ -33,32 +34,17 @@ func (h *Handler) DownloadAsZip(c echo.Context) error {
 	claims := GetClaims(c)
 
 	// Validate all paths and collect real paths
-	type pathInfo struct {
-		realPath    string
-		displayPath string
-		isDir       bool
+	validPaths, apiErr := h.resolveZipPaths(req.Paths, claims)
+	if apiErr != nil {
+		return RespondError(c, apiErr)
 	}
-	validPaths := make([]pathInfo, 0, len(req.Paths))
 
-	for _, path := range req.Paths {
-		realPath, _, displayPath, err := h.resolvePath(path, claims)
-		if err != nil {
-			return RespondError(c, ErrInvalidPath(fmt.Sprintf("Invalid path: %s", path)))
-		}
-
-		info, err := os.Stat(realPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return RespondError(c, ErrNotFound(fmt.Sprintf("Path not found: %s", path)))
-			}
-			return RespondError(c, ErrOperationFailed("access path", err))
+	// Refuse selections over the configured limit before streaming starts
+	if limit := zipDownloadMaxBytes(); limit > 0 {
+		preflight := preflightZip(validPaths, GetStatsCache(), limit, zipPreflightBudget)
+		if preflight.LimitExceeded {
+			return RespondError(c, NewAPIError(ErrCodeFileTooLarge, "Selection exceeds the ZIP download limit").WithDetails(preflight))
 		}
-
-		validPaths = append(validPaths, pathInfo{
-			realPath:    realPath,
-			displayPath: displayPath,
-			isDir:       info.IsDir(),
-		})
 	}
 
 	// Generate ZIP filename
@@ -74,68 +60,141 @@ func (h *Handler) DownloadAsZip(c echo.Context) error {
 		zipName = fmt.Sprintf("download_%s.zip", time.Now().Format("20060102_150405"))
 	}
 
+	activity := TrackResponse(c, ActivityZip, validPaths[0].displayPath, "", 0)
+	defer activity.Finish()
+
 	// Set response headers
 	c.Response().Header().Set("Content-Type", "application/zip")
 	setContentDisposition(c, zipName)
 	c.Response().WriteHeader(http.StatusOK)
 
-	// Create ZIP writer
-	zipWriter := zip.NewWriter(c.Response())
+	zipWriter := newDownloadZipWriter(c.Response())
 	defer zipWriter.Close()
 
 	// Add files to ZIP
 	for _, pi := range validPaths {
-		if pi.isDir {
-			// Walk directory and add all files
-			basePath := filepath.Dir(pi.realPath)
-			err := filepath.Walk(pi.realPath, func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-
-				// Create relative path for ZIP
-				relPath, err := filepath.Rel(basePath, path)
-				if err != nil {
-					return err
-				}
-
-				// Skip the root directory itself
-				if relPath == "." {
-					return nil
-				}
-
-				if info.IsDir() {
-					// Add directory entry
-					_, err := zipWriter.Create(relPath + "/")
-					return err
-				}
-
-				// Add file
-				if err := zipAddFile(zipWriter, path, relPath); err != nil {
-					return err
-				}
-				return nil
-			})
-			if err != nil {
-				LogError("Failed to add directory to ZIP", err, "path", pi.displayPath)
-				continue
-			}
-		} else {
-			// Add single file
-			fileName := filepath.Base(pi.realPath)
-			if err := zipAddFile(zipWriter, pi.realPath, fileName); err != nil {
-				LogError("Failed to add file to ZIP", err, "path", pi.displayPath)
-				continue
-			}
+		if activity.Err() != nil {
+			break
 		}
+		h.auditZipMountIns(c, claims, pi)
+		zipAddPath(zipWriter, activity, pi, filepath.Base(pi.displayPath))
 	}
 
 	return nil
 }
 
+// zipDownloadLevel returns the configured deflate level of ZIP downloads,
+// 0 storing files uncompressed
+func zipDownloadLevel() int {
+	level := 6
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		level = sh.GetZipDownloadLevel()
+	}
+	if level < flate.NoCompression || level > flate.BestCompression {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+// newDownloadZipWriter starts a ZIP download written straight to w as the
+// entries are added, so nothing is staged on disk and the client gets the
+// first bytes right away. Entries are deflated at the configured level.
+func newDownloadZipWriter(w io.Writer) *zip.Writer {
+	zipWriter := zip.NewWriter(w)
+	level := zipDownloadLevel()
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	return zipWriter
+}
+
+// zipAddPath adds a file, or a folder with everything below it, to the
+// archive under name. Failures are logged and the entry is skipped. Once the
+// client is gone (the activity context derives from the request context)
+// the walk stops between files without logging.
+func zipAddPath(zipWriter *zip.Writer, activity *Activity, pi zipPathInfo, name string) {
+	if !pi.isDir {
+		if err := zipAddFile(zipWriter, pi.realPath, name); err != nil && activity.Err() == nil {
+			LogError("Failed to add file to ZIP", err, "path", pi.displayPath)
+		}
+		return
+	}
+
+	// Walk directory and add all files
+	err := filepath.Walk(pi.realPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := activity.Err(); err != nil {
+			return err
+		}
+
+		// Create relative path for ZIP, named as shown (a mount-in's
+		// source folder may have another name)
+		relPath, err := filepath.Rel(pi.realPath, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.Join(name, relPath)
+
+		// Skip the root directory itself
+		if relPath == "." {
+			return nil
+		}
+
+		if info.IsDir() {
+			// Add directory entry
+			_, err := zipWriter.Create(relPath + "/")
+			return err
+		}
+
+		// Add file
+		return zipAddFile(zipWriter, path, relPath)
+	})
+	if err == nil {
+		err = zipAddMountIns(zipWriter, activity, pi.displayPath, name)
+	}
+	if err != nil && activity.Err() == nil {
+		LogError("Failed to add directory to ZIP", err, "path", pi.displayPath)
+	}
+}
+
+// zipPathInfo is a validated entry of a ZIP download selection
+type zipPathInfo struct {
+	realPath    string
+	displayPath string
+	isDir       bool
+}
+
+// resolveZipPaths validates a selection with the same checks the download uses
+func (h *Handler) resolveZipPaths(paths []string, claims *JWTClaims) ([]zipPathInfo, *APIError) {
+	validPaths := make([]zipPathInfo, 0, len(paths))
+	for _, path := range paths {
+		realPath, _, displayPath, err := h.resolvePath(path, claims)
+		if err != nil {
+			return nil, ErrInvalidPath(fmt.Sprintf("Invalid path: %s", path))
+		}
+
+		info, err := os.Stat(realPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, ErrNotFound(fmt.Sprintf("Path not found: %s", path))
+			}
+			return nil, ErrOperationFailed("access path", err)
+		}
+
+		validPaths = append(validPaths, zipPathInfo{
+			realPath:    realPath,
+			displayPath: displayPath,
+			isDir:       info.IsDir(),
+		})
+	}
+	return validPaths, nil
+}
+
 // zipAddFile adds a single file to the ZIP archive
 func zipAddFile(zipWriter *zip.Writer, filePath, zipPath string) error {
-	file, err := os.Open(filePath)
+	file, err := OpenPlain(filePath)
 	if err != nil {
 		return err
 	}
@@ -214,11 +273,11 @@ func (h *Handler) PreviewZip(c echo.Context) error {
 	}
 
 	// Open ZIP file
-	reader, err := zip.OpenReader(realPath)
+	reader, zipFile, err := openPlainZip(realPath)
 	if err != nil {
 		return RespondError(c, ErrOperationFailed("open ZIP file", err))
 	}
-	defer reader.Close()
+	defer zipFile.Close()
 
 	// Build file list
 	files := make([]ZipFileEntry, 0, len(reader.File))
@@ -273,45 +332,85 @@ func (h *Handler) DownloadFolderAsZip(c echo.Context) error {
 		return RespondError(c, ErrBadRequest("Path is not a folder"))
 	}
 
+	if limit := zipDownloadMaxBytes(); limit > 0 {
+		selection := []zipPathInfo{{realPath: realPath, displayPath: displayPath, isDir: true}}
+		preflight := preflightZip(selection, GetStatsCache(), limit, zipPreflightBudget)
+		if preflight.LimitExceeded {
+			return RespondError(c, NewAPIError(ErrCodeFileTooLarge, "Folder exceeds the ZIP download limit").WithDetails(preflight))
+		}
+	}
+
 	// Generate ZIP filename
 	zipName := filepath.Base(displayPath) + ".zip"
 
+	activity := TrackResponse(c, ActivityZip, displayPath, "", 0)
+	defer activity.Finish()
+
 	// Set response headers
 	c.Response().Header().Set("Content-Type", "application/zip")
 	setContentDisposition(c, zipName)
 	c.Response().WriteHeader(http.StatusOK)
 
-	// Create ZIP writer
-	zipWriter := zip.NewWriter(c.Response())
+	zipWriter := newDownloadZipWriter(c.Response())
 	defer zipWriter.Close()
 
-	// Walk directory and add all files
-	basePath := filepath.Dir(realPath)
-	baseName := filepath.Base(realPath)
+	pi := zipPathInfo{realPath: realPath, displayPath: displayPath, isDir: true}
+	h.auditZipMountIns(c, claims, pi)
+	zipAddPath(zipWriter, activity, pi, filepath.Base(displayPath))
 
-	return filepath.Walk(realPath, func(path string, fileInfo os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	// The response has started, so failures and disconnects end the archive
+	// early instead of returning an error
+	return nil
+}
 
-		// Create relative path for ZIP (include the folder name)
-		relPath, err := filepath.Rel(basePath, path)
+// zipAddMountIns adds the mount-ins below a zipped folder, which the walk of
+// the drive folder does not see, under zipRoot
+func zipAddMountIns(zipWriter *zip.Writer, activity *Activity, displayPath, zipRoot string) error {
+	for _, m := range GetMountIns().Below(displayPath, false) {
+		if m.Path == cleanVirtualPath(displayPath) {
+			continue // The zipped folder itself, already walked
+		}
+		sourcePath := GetMountIns().RealPath(m, "")
+		mountRoot := filepath.Join(zipRoot, strings.TrimPrefix(m.Path, cleanVirtualPath(displayPath)+"/"))
+		err := filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := activity.Err(); err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(sourcePath, path)
+			if err != nil {
+				return err
+			}
+			relPath = filepath.Join(mountRoot, relPath)
+			if info.IsDir() {
+				_, err := zipWriter.Create(relPath + "/")
+				return err
+			}
+			return zipAddFile(zipWriter, path, relPath)
+		})
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		// Skip if it's the base directory entry point
-		if relPath == baseName && fileInfo.IsDir() {
-			return nil
+// auditZipMountIns records the mount-ins a ZIP download reads from, so their
+// owners can see which members took their data
+func (h *Handler) auditZipMountIns(c echo.Context, claims *JWTClaims, pi zipPathInfo) {
+	targets := []string{pi.displayPath}
+	if _, _, ok := GetMountIns().Resolve(pi.displayPath); !ok {
+		targets = targets[:0]
+		for _, m := range GetMountIns().Below(pi.displayPath, false) {
+			targets = append(targets, m.Path)
 		}
-
-		if fileInfo.IsDir() {
-			// Add directory entry
-			_, err := zipWriter.Create(relPath + "/")
-			return err
+	}
+	for _, target := range targets {
+		if m, _, _ := GetMountIns().Resolve(target); claims != nil && claims.UserID == m.OwnerID {
+			continue
 		}
-
-		// Add file
-		return zipAddFile(zipWriter, path, relPath)
-	})
+		h.auditMountInAccess(c, claims, target, EventFileDownload, map[string]interface{}{"zip": true})
+	}
 }
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// tailWriter is a response that discards the body, counting it and keeping
+// the end where the ZIP central directory is
+type tailWriter struct {
+	header http.Header
+	status int
+	n      int64
+	tail   []byte
+}
+
+func (w *tailWriter) Header() http.Header  { return w.header }
+func (w *tailWriter) WriteHeader(code int) { w.status = code }
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	w.tail = append(w.tail, p...)
+	if len(w.tail) > 4096 {
+		w.tail = w.tail[len(w.tail)-4096:]
+	}
+	return len(p), nil
+}
+
+func downloadTestFolder(t *testing.T, ctx context.Context, h *Handler, w http.ResponseWriter, path string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/download/folder/"+path, nil).WithContext(ctx)
+	c := echo.New().NewContext(req, w)
+	c.Set("user", &JWTClaims{UserID: "u1", Username: "alice"})
+	c.SetParamNames("*")
+	c.SetParamValues(path)
+	if err := h.DownloadFolderAsZip(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listTree(t *testing.T, root string) []string {
+	t.Helper()
+	var paths []string
+	_ = filepath.WalkDir(root, func(path string, _ fs.DirEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	return paths
+}
+
+func TestDownloadFolderAsZip_StreamsLargeFolder(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams a few gigabytes")
+	}
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{"zip_download_max_bytes": "0", "zip_download_level": "0"})
+
+	// Sparse files take no space but are read in full
+	const fileSize = 1 << 30
+	writeTestFiles(t, home, "media/notes.txt", "media/a.mkv", "media/raw/b.mkv", "media/raw/c.mkv")
+	for _, name := range []string{"media/a.mkv", "media/raw/b.mkv", "media/raw/c.mkv"} {
+		if err := os.Truncate(filepath.Join(home, name), fileSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := listTree(t, h.dataRoot)
+
+	w := &tailWriter{header: make(http.Header)}
+	downloadTestFolder(t, context.Background(), h, w, "home/media")
+
+	if w.status != http.StatusOK || w.header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("status %d, content type %q", w.status, w.header.Get("Content-Type"))
+	}
+	if got := w.header.Get("Content-Disposition"); !bytes.Contains([]byte(got), []byte("media.zip")) {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if w.n < 3*fileSize {
+		t.Errorf("streamed %d bytes, want at least %d", w.n, 3*fileSize)
+	}
+	// End of central directory: media/, media/raw/ and four files
+	eocd := w.tail[len(w.tail)-22:]
+	if binary.LittleEndian.Uint32(eocd) != 0x06054b50 {
+		t.Fatal("archive does not end with a central directory")
+	}
+	if entries := binary.LittleEndian.Uint16(eocd[10:]); entries != 6 {
+		t.Errorf("entries = %d, want 6", entries)
+	}
+
+	after := listTree(t, h.dataRoot)
+	if len(after) != len(before) {
+		t.Errorf("download left files in the data root: %v", after)
+	}
+}
+
+func TestDownloadFolderAsZip_StopsWhenClientLeaves(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	useCachedSettings(t, map[string]string{"zip_download_max_bytes": "0", "zip_download_level": "6"})
+	writeTestFiles(t, home, "docs/a.txt", "docs/b.txt", "docs/sub/c.txt")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	downloadTestFolder(t, ctx, h, rec, "home/docs")
+
+	// Headers went out, then the walk stopped without writing anything more
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("status %d, %d bytes written after disconnect", rec.Code, rec.Body.Len())
+	}
+}
+
+func TestDownloadFolderAsZip_Level(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	content := bytes.Repeat([]byte("compressible "), 4096)
+	writeTestFiles(t, home, "docs/a.txt")
+	if err := os.WriteFile(filepath.Join(home, "docs", "a.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := map[string]uint64{}
+	for _, level := range []string{"0", "9"} {
+		useCachedSettings(t, map[string]string{"zip_download_max_bytes": "0", "zip_download_level": level})
+		rec := httptest.NewRecorder()
+		downloadTestFolder(t, context.Background(), h, rec, "home/docs")
+		data := rec.Body.Bytes()
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			if f.Name != "docs/a.txt" {
+				continue
+			}
+			rc, _ := f.Open()
+			got, _ := io.ReadAll(rc)
+			rc.Close()
+			if !bytes.Equal(got, content) {
+				t.Errorf("level %s: content differs", level)
+			}
+			sizes[level] = f.CompressedSize64
+		}
+	}
+	if sizes["0"] < uint64(len(content)) || sizes["9"] >= uint64(len(content))/10 {
+		t.Errorf("compressed sizes = %v for %d bytes", sizes, len(content))
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// zipPreflightBudget bounds the walk so the UI can call the preflight on
+// every selection change
+const zipPreflightBudget = 2 * time.Second
+
+var errZipPreflightBudget = errors.New("zip preflight time budget exceeded")
+
+// ZipPreflight summarizes what a ZIP download of a selection would contain
+type ZipPreflight struct {
+	FileCount     int64 `json:"fileCount"`
+	FolderCount   int64 `json:"folderCount"`
+	TotalBytes    int64 `json:"totalBytes"`
+	LimitBytes    int64 `json:"limitBytes"` // 0 = no limit configured
+	LimitExceeded bool  `json:"limitExceeded"`
+	// Estimated is set when folder sizes came from the stats cache or the
+	// walk stopped at the time budget (totals are then a lower bound)
+	Estimated bool  `json:"estimated"`
+	ElapsedMs int64 `json:"elapsedMs"`
+}
+
+// zipDownloadMaxBytes returns the configured ZIP download limit (0 = unlimited)
+func zipDownloadMaxBytes() int64 {
+	if sh := GetGlobalSettingsHandler(); sh != nil {
+		return sh.GetZipDownloadMaxBytes()
+	}
+	return 0
+}
+
+// DownloadPreflight returns the file count and size of a ZIP download
+// @Summary		ZIP download preflight
+// @Description	Count files and bytes of a multi-select ZIP download before starting it. Takes the same paths as /download/zip with the same permission checks. Folder sizes come from the stats cache when available; otherwise folders are walked within a short time budget. estimated is true when cached or partial totals were used.
+// @Tags		Files
+// @Accept		json
+// @Produce		json
+// @Param		request	body		ZipDownloadRequest	true	"Selected paths"
+// @Success		200		{object}	ZipPreflight		"Selection totals"
+// @Failure		400		{object}	map[string]string	"Bad request"
+// @Failure		404		{object}	map[string]string	"Path not found"
+// @Router		/download/preflight [post]
+func (h *Handler) DownloadPreflight(c echo.Context) error {
+	var req ZipDownloadRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+
+	if len(req.Paths) == 0 {
+		return RespondError(c, ErrMissingParameter("paths"))
+	}
+
+	validPaths, apiErr := h.resolveZipPaths(req.Paths, GetClaims(c))
+	if apiErr != nil {
+		return RespondError(c, apiErr)
+	}
+
+	preflight := preflightZip(validPaths, GetStatsCache(), zipDownloadMaxBytes(), zipPreflightBudget)
+	return c.JSON(http.StatusOK, preflight)
+}
+
+// preflightZip totals a selection. Up-to-date stats cache entries answer for
+// folders; other folders are walked until the budget runs out, and complete
+// walks are cached for the next call. Hidden entries are skipped like in the
+// folder stats.
+func preflightZip(paths []zipPathInfo, cache *StatsCache, limit int64, budget time.Duration) *ZipPreflight {
+	started := time.Now()
+	deadline := started.Add(budget)
+	result := &ZipPreflight{LimitBytes: limit}
+
+	for _, pi := range paths {
+		if !pi.isDir {
+			if info, err := os.Stat(pi.realPath); err == nil {
+				result.FileCount++
+				result.TotalBytes += PlainSize(pi.realPath, info)
+			}
+			continue
+		}
+
+		if cache != nil {
+			if stats, ok := cache.Get(pi.realPath); ok && statsCurrent(pi.realPath, stats) {
+				result.FolderCount += stats.FolderCount + 1
+				result.FileCount += stats.FileCount
+				result.TotalBytes += stats.TotalSize
+				result.Estimated = true
+				continue
+			}
+		}
+
+		if time.Now().After(deadline) {
+			result.Estimated = true
+			continue
+		}
+		stats, complete := walkZipFolder(pi.realPath, deadline)
+		result.FolderCount += stats.FolderCount + 1
+		result.FileCount += stats.FileCount
+		result.TotalBytes += stats.TotalSize
+		if !complete {
+			result.Estimated = true
+		} else if cache != nil {
+			_ = cache.Set(pi.realPath, stats)
+		}
+	}
+
+	result.LimitExceeded = limit > 0 && result.TotalBytes > limit
+	result.ElapsedMs = time.Since(started).Milliseconds()
+	return result
+}
+
+// statsCurrent reports whether cached stats are newer than the folder itself
+func statsCurrent(realPath string, stats *CachedFolderStats) bool {
+	info, err := os.Stat(realPath)
+	return err == nil && !info.ModTime().After(stats.DirModTime)
+}
+
+// walkZipFolder totals a folder until the deadline; complete is false if the
+// walk was cut short
+func walkZipFolder(realPath string, deadline time.Time) (*CachedFolderStats, bool) {
+	stats := &CachedFolderStats{}
+	if info, err := os.Stat(realPath); err == nil {
+		stats.DirModTime = info.ModTime()
+	}
+
+	walked := 0
+	err := filepath.WalkDir(realPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip errors
+		}
+		if walked++; walked%256 == 0 && time.Now().After(deadline) {
+			return errZipPreflightBudget
+		}
+		if p == realPath {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			stats.FolderCount++
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		stats.FileCount++
+		stats.TotalSize += info.Size()
+		return nil
+	})
+	return stats, !errors.Is(err, errZipPreflightBudget)
+}
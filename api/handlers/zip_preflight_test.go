@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPreflightZip(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel string, size int) string {
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	single := write("report.pdf", 100)
+	write("photos/a.jpg", 1000)
+	write("photos/2024/b.jpg", 2000)
+	write("photos/.cache/thumb", 50000)
+
+	selection := []zipPathInfo{
+		{realPath: single, displayPath: "/home/report.pdf"},
+		{realPath: filepath.Join(root, "photos"), displayPath: "/home/photos", isDir: true},
+	}
+
+	result := preflightZip(selection, nil, 0, time.Minute)
+	if result.FileCount != 3 || result.FolderCount != 2 || result.TotalBytes != 3100 {
+		t.Errorf("totals = %+v", result)
+	}
+	if result.Estimated || result.LimitExceeded {
+		t.Errorf("unexpected flags: %+v", result)
+	}
+
+	if result := preflightZip(selection, nil, 3000, time.Minute); !result.LimitExceeded || result.LimitBytes != 3000 {
+		t.Errorf("expected limit exceeded: %+v", result)
+	}
+
+	// With the budget already spent folders are skipped and the result is estimated
+	result = preflightZip(selection, nil, 0, -time.Second)
+	if !result.Estimated || result.FileCount != 1 || result.TotalBytes != 100 {
+		t.Errorf("expected estimated partial result: %+v", result)
+	}
+}
+
+func TestPreflightZipUsesStatsCache(t *testing.T) {
+	root := t.TempDir()
+	cache := &StatsCache{}
+	_ = cache.Set(root, &CachedFolderStats{FileCount: 10, FolderCount: 2, TotalSize: 1 << 30, DirModTime: time.Now().Add(time.Hour)})
+
+	result := preflightZip([]zipPathInfo{{realPath: root, isDir: true}}, cache, 0, time.Minute)
+	if !result.Estimated || result.FileCount != 10 || result.TotalBytes != 1<<30 {
+		t.Errorf("expected cached estimate: %+v", result)
+	}
+
+	// Stale cache entries are walked again and replaced
+	_ = cache.Set(root, &CachedFolderStats{FileCount: 10, DirModTime: time.Now().Add(-time.Hour)})
+	result = preflightZip([]zipPathInfo{{realPath: root, isDir: true}}, cache, 0, time.Minute)
+	if result.Estimated || result.FileCount != 0 {
+		t.Errorf("expected fresh walk: %+v", result)
+	}
+	if stats, ok := cache.Get(root); !ok || stats.FileCount != 0 {
+		t.Errorf("walk result not cached: %+v", stats)
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ZipQueryRequest selects the files of a ZIP download by search or by tag.
+// Exactly one of Query and Tag is set; Path and MatchType are the search
+// parameters of /files/search.
+type ZipQueryRequest struct {
+	Query     string `json:"q,omitempty"`
+	Path      string `json:"path,omitempty"`
+	MatchType string `json:"matchType,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+}
+
+// DownloadZipByQuery downloads everything a search or tag finds as one ZIP
+// @Summary		ZIP download of search results or a tag
+// @Description	Runs the search (q, path, matchType as in /files/search) or the tag lookup again with the caller's permissions and streams all results as one ZIP. Entries keep their folders relative to the deepest folder common to all results, so equal names from different folders stay apart. The zip_download_max_bytes limit applies; the preflight totals are sent in the X-Zip-File-Count, X-Zip-Total-Bytes, X-Zip-Estimated and X-Zip-Truncated (result limit or search time budget reached) headers. Folders found are included with their contents.
+// @Tags		Files
+// @Accept		json
+// @Produce		application/zip
+// @Param		request	body		ZipQueryRequest		true	"Search or tag"
+// @Success		200		{file}		binary				"ZIP archive"
+// @Failure		400		{object}	docs.ErrorResponse	"Bad request"
+// @Failure		404		{object}	docs.ErrorResponse	"Nothing found"
+// @Failure		413		{object}	docs.ErrorResponse	"Exceeds the ZIP download limit"
+// @Router		/download/zip-by-query [post]
+func (h *Handler) DownloadZipByQuery(c echo.Context) error {
+	var req ZipQueryRequest
+	if err := c.Bind(&req); err != nil {
+		return RespondError(c, ErrBadRequest("Invalid request body"))
+	}
+	req.Query = strings.TrimSpace(req.Query)
+	req.Tag = strings.TrimSpace(req.Tag)
+	if (req.Query == "") == (req.Tag == "") {
+		return RespondError(c, ErrBadRequest("Either q or tag is required"))
+	}
+	claims := GetClaims(c)
+	if claims == nil {
+		return RespondError(c, ErrUnauthorized(""))
+	}
+
+	var results []SearchResult
+	var truncated bool
+	label := req.Query
+	if req.Tag != "" {
+		var err error
+		if results, err = h.tagResults(claims, req.Tag); err != nil {
+			return RespondError(c, ErrOperationFailed("search by tag", err))
+		}
+		label = req.Tag
+	} else {
+		if req.Path == "" {
+			req.Path = "/"
+		}
+		if req.MatchType == "" {
+			req.MatchType = "all"
+		}
+		ctx, cancel := budgetSearch.Context(c.Request().Context())
+		defer cancel()
+		var err error
+		if results, err = h.searchAll(ctx, req.Query, req.Path, req.MatchType, claims); err != nil {
+			return RespondError(c, ErrBadRequest(err.Error()))
+		}
+		if stopped := walkStopped(ctx); stopped != nil {
+			if !errors.Is(stopped, errWalkBudget) {
+				return nil // Client gone
+			}
+			truncated = true
+		}
+	}
+	// Each search source stops at searchMaxResults
+	truncated = truncated || len(results) >= searchMaxResults
+
+	selection := h.zipQuerySelection(results, claims)
+	if len(selection) == 0 {
+		return RespondError(c, ErrNotFound("Matching files"))
+	}
+
+	limit := zipDownloadMaxBytes()
+	preflight := preflightZip(selection, GetStatsCache(), limit, zipPreflightBudget)
+	if preflight.LimitExceeded {
+		return RespondError(c, NewAPIError(ErrCodeFileTooLarge, "Results exceed the ZIP download limit").WithDetails(preflight))
+	}
+
+	displayPaths := make([]string, len(selection))
+	for i, pi := range selection {
+		displayPaths[i] = pi.displayPath
+	}
+	root := zipCommonRoot(displayPaths)
+
+	if h.auditHandler != nil {
+		details := map[string]interface{}{
+			"zip":   true,
+			"items": len(selection),
+			"files": preflight.FileCount,
+			"bytes": preflight.TotalBytes,
+		}
+		target := "search:" + req.Query
+		if req.Tag != "" {
+			target = "tag:" + req.Tag
+			details["tag"] = req.Tag
+		} else {
+			details["query"] = req.Query
+			details["path"] = req.Path
+			details["matchType"] = req.MatchType
+		}
+		_ = h.auditHandler.LogEvent(&claims.UserID, c.RealIP(), EventFileDownload, target, details)
+	}
+
+	activity := TrackResponse(c, ActivityZip, root, "", preflight.TotalBytes)
+	defer activity.Finish()
+
+	header := c.Response().Header()
+	header.Set("Content-Type", "application/zip")
+	header.Set("X-Zip-File-Count", strconv.FormatInt(preflight.FileCount, 10))
+	header.Set("X-Zip-Total-Bytes", strconv.FormatInt(preflight.TotalBytes, 10))
+	header.Set("X-Zip-Estimated", strconv.FormatBool(preflight.Estimated))
+	header.Set("X-Zip-Truncated", strconv.FormatBool(truncated))
+	setContentDisposition(c, zipQueryName(label, time.Now()))
+	c.Response().WriteHeader(http.StatusOK)
+
+	zipWriter := newDownloadZipWriter(c.Response())
+	defer zipWriter.Close()
+
+	names := make(zipNames)
+	for _, pi := range selection {
+		if activity.Err() != nil {
+			break
+		}
+		h.auditZipMountIns(c, claims, pi)
+		rel := strings.TrimPrefix(strings.TrimPrefix(pi.displayPath, root), "/")
+		zipAddPath(zipWriter, activity, pi, names.claim(rel))
+	}
+	return nil
+}
+
+// tagResults lists the caller's files carrying tag, like the tag search
+func (h *Handler) tagResults(claims *JWTClaims, tag string) ([]SearchResult, error) {
+	rows, err := h.db.Query(`
+		SELECT file_path
+		FROM file_metadata
+		WHERE user_id = $1 AND tags ? $2
+		ORDER BY file_path
+		LIMIT $3
+	`, claims.UserID, tag, searchMaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var filePath string
+		if err := rows.Scan(&filePath); err != nil {
+			continue
+		}
+		results = append(results, SearchResult{Path: filePath, MatchType: "tag", MatchedTag: tag})
+	}
+	return results, rows.Err()
+}
+
+// zipQuerySelection resolves search results for a download. Results the
+// caller cannot read or that are gone are left out, and results inside a
+// folder that was found as well come with that folder.
+func (h *Handler) zipQuerySelection(results []SearchResult, claims *JWTClaims) []zipPathInfo {
+	paths := make([]string, 0, len(results))
+	for _, r := range results {
+		if !r.InTrash {
+			paths = append(paths, path.Clean("/"+r.Path))
+		}
+	}
+	sort.Strings(paths)
+
+	var selection []zipPathInfo
+	dirs := make(map[string]bool)
+	for i, p := range paths {
+		if i > 0 && p == paths[i-1] {
+			continue
+		}
+		if zipInSelectedDir(p, dirs) {
+			continue
+		}
+		realPath, storageType, displayPath, err := h.resolvePath(p, claims)
+		if err != nil || realPath == "" {
+			continue
+		}
+		if storageType == StorageShared && !h.CanReadSharedDrive(claims.UserID, displayPath) {
+			continue
+		}
+		info, err := os.Stat(realPath)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			dirs[displayPath] = true
+		}
+		selection = append(selection, zipPathInfo{realPath: realPath, displayPath: displayPath, isDir: info.IsDir()})
+	}
+	return selection
+}
+
+// zipInSelectedDir reports whether an ancestor of p is in dirs. Sorting puts
+// every folder before its contents.
+func zipInSelectedDir(p string, dirs map[string]bool) bool {
+	for dir := path.Dir(p); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if dirs[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// zipCommonRoot returns the deepest folder containing all paths
+func zipCommonRoot(paths []string) string {
+	root := path.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for root != "/" && !strings.HasPrefix(p, root+"/") {
+			root = path.Dir(root)
+		}
+	}
+	return root
+}
+
+// zipNames hands out archive entry names, renaming an entry whose name is
+// taken, also when only the case differs (Windows and macOS extract those
+// onto one file)
+type zipNames map[string]bool
+
+// claim reserves name or, if it is taken, "name (2)", "name (3)", ...
+func (n zipNames) claim(name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; n[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	n[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// zipQueryName names the archive after the search or tag
+func zipQueryName(label string, now time.Time) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, label)
+	if len([]rune(name)) > 50 {
+		name = string([]rune(name)[:50])
+	}
+	return fmt.Sprintf("%s_%s.zip", name, now.Format("20060102_150405"))
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// zipByQuery posts a ZIP-by-query request as alice and returns the entry names
+func zipByQuery(t *testing.T, tc *TestContext, h *Handler, body ZipQueryRequest) []string {
+	t.Helper()
+	useLocalStatsCache(t)
+	req, _ := NewJSONRequest(http.MethodPost, "/api/download/zip-by-query", body)
+	c := CreateAuthenticatedContext(tc.Echo, tc.Recorder, req, "u1", "alice", false)
+	if err := h.DownloadZipByQuery(c); err != nil {
+		t.Fatal(err)
+	}
+	AssertStatus(t, tc.Recorder, http.StatusOK)
+	data := tc.Recorder.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// useLocalStatsCache keeps the stats cache off Redis, which tests cannot reach
+func useLocalStatsCache(t *testing.T) {
+	t.Helper()
+	statsCacheOnce.Do(func() {})
+	previous := globalStatsCache
+	globalStatsCache = &StatsCache{}
+	t.Cleanup(func() { globalStatsCache = previous })
+}
+
+func writeTestFiles(t *testing.T, root string, files ...string) {
+	t.Helper()
+	for _, f := range files {
+		_ = os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 0755)
+		if err := os.WriteFile(filepath.Join(root, f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDownloadZipByQuery_Search(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	writeTestFiles(t, home,
+		"docs/2023/invoice.pdf",
+		"docs/2024/invoice.pdf",
+		"docs/invoices/invoice-x.pdf",
+		"docs/invoices/receipt.pdf",
+		"docs/other.pdf",
+	)
+
+	names := zipByQuery(t, tc, h, ZipQueryRequest{Query: "invoice", Path: "/home", MatchType: "name"})
+
+	// Relative to /home/docs; the found folder brings its contents once
+	want := []string{"2023/invoice.pdf", "2024/invoice.pdf", "invoices/", "invoices/invoice-x.pdf", "invoices/receipt.pdf"}
+	if len(names) != len(want) {
+		t.Fatalf("entries = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("entries = %v, want %v", names, want)
+			break
+		}
+	}
+	if got := tc.Recorder.Header().Get("X-Zip-File-Count"); got != "4" {
+		t.Errorf("X-Zip-File-Count = %q", got)
+	}
+}
+
+func TestDownloadZipByQuery_TagSkipsUnreadableDrives(t *testing.T) {
+	tc, h, home := diffTestHandler(t)
+	defer tc.Cleanup()
+	writeTestFiles(t, home, "a/report.pdf", "b/report.pdf")
+	writeTestFiles(t, filepath.Join(h.dataRoot, "shared"), "Finance/budget.xlsx")
+
+	tc.Mock.ExpectQuery("FROM file_metadata").WithArgs("u1", "q3", searchMaxResults).
+		WillReturnRows(sqlmock.NewRows([]string{"file_path"}).
+			AddRow("/home/a/report.pdf").AddRow("/home/b/report.pdf").AddRow("/shared/Finance/budget.xlsx"))
+	tc.Mock.ExpectQuery("FROM shared_folder_access").WillReturnRows(sqlmock.NewRows([]string{"permission_level", "id"}))
+
+	names := zipByQuery(t, tc, h, ZipQueryRequest{Tag: "q3"})
+	if len(names) != 2 || names[0] != "a/report.pdf" || names[1] != "b/report.pdf" {
+		t.Errorf("entries = %v", names)
+	}
+	if err := tc.Mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestZipNamesClaim(t *testing.T) {
+	names := make(zipNames)
+	for _, c := range []struct{ name, want string }{
+		{"docs/report.pdf", "docs/report.pdf"},
+		{"Docs/Report.pdf", "Docs/Report (2).pdf"},
+		{"docs/report.pdf", "docs/report (3).pdf"},
+		{"docs/notes", "docs/notes"},
+	} {
+		if got := names.claim(c.name); got != c.want {
+			t.Errorf("claim(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestZipCommonRoot(t *testing.T) {
+	for _, c := range []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"/home/a.txt"}, "/home"},
+		{[]string{"/home/docs/x/a.txt", "/home/docs/y/a.txt"}, "/home/docs"},
+		{[]string{"/home/docs/a.txt", "/home/docsx/a.txt"}, "/home"},
+		{[]string{"/home/a.txt", "/shared/Team/b.txt"}, "/"},
+	} {
+		if got := zipCommonRoot(c.paths); got != c.want {
+			t.Errorf("zipCommonRoot(%v) = %q, want %q", c.paths, got, c.want)
+		}
+	}
+}
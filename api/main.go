@@ -1,59 +1,38 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/svrforum/FileHatch/api/database"
-	_ "github.com/svrforum/FileHatch/api/docs" // Swagger docs
+	"github.com/svrforum/FileHatch/api/docs" // Swagger docs
 	"github.com/svrforum/FileHatch/api/handlers"
 	echoSwagger "github.com/swaggo/echo-swagger"
-	"golang.org/x/time/rate"
 )
 
 const dataRoot = "/data"
 
-// getCORSOrigins returns allowed CORS origins from environment or defaults
-func getCORSOrigins() []string {
-	origins := os.Getenv("CORS_ALLOWED_ORIGINS")
-	if origins == "" {
-		env := os.Getenv("FH_ENV")
-		if env == "production" {
-			log.Println("WARNING: CORS_ALLOWED_ORIGINS not set in production. Using restrictive defaults.")
-			// In production without explicit config, only allow same-origin
-			return []string{}
-		}
-		// Development defaults
-		log.Println("CORS: Using development defaults (localhost:3000, 3080, 5173)")
-		return []string{
-			"http://localhost:3000",
-			"http://localhost:3080",
-			"http://localhost:5173",
-			"http://127.0.0.1:3000",
-			"http://127.0.0.1:3080",
-			"http://127.0.0.1:5173",
-		}
-	}
-
-	// Parse comma-separated origins
-	originList := strings.Split(origins, ",")
-	result := make([]string, 0, len(originList))
-	for _, o := range originList {
-		o = strings.TrimSpace(o)
-		if o != "" {
-			result = append(result, o)
+// uploadMetadata decodes a tus Upload-Metadata header ("key base64value,...")
+func uploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), " ")
+		if decoded, err := handlers.DecodeBase64(value); err == nil {
+			metadata[key] = string(decoded)
 		}
 	}
-	log.Printf("CORS: Configured origins: %v", result)
-	return result
+	return metadata
 }
 
 // fixLocationHeader rewrites the Location header to use the correct scheme and host
@@ -141,17 +120,15 @@ func main() {
 		log.Println("Security headers middleware disabled")
 	}
 
-	// Conditionally apply Rate Limiting Middleware based on settings
-	if settingsHandler.IsRateLimitEnabled() {
-		rps := settingsHandler.GetRateLimitRPS()
-		if rps < 1 {
-			rps = 100 // default
-		}
-		e.Use(middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(rate.Limit(rps))))
-		log.Printf("Rate limiting enabled: %d requests/second per IP", rps)
-	} else {
-		log.Println("Rate limiting disabled")
-	}
+	// /api/v1 is served by the /api routes; rewritten before routing
+	e.Pre(handlers.APIVersionMiddleware())
+
+	// Rate limiting per endpoint class; limits and the on/off switch are read
+	// from settings on each request
+	e.Use(handlers.RateLimitMiddleware())
+
+	// Listing latency drives how far background jobs back off
+	e.Use(handlers.LatencyMiddleware())
 
 	// Middleware
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
@@ -165,43 +142,14 @@ func main() {
 		},
 	}))
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: getCORSOrigins(),
-		AllowMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead, http.MethodOptions},
-		AllowHeaders: []string{
-			"Accept",
-			"Accept-Language",
-			"Authorization",
-			"Content-Type",
-			"Content-Length",
-			"X-Requested-With",
-			"Origin",
-			"Cache-Control",
-			"If-None-Match",
-			"If-Modified-Since",
-			"Upload-Length",
-			"Upload-Offset",
-			"Tus-Resumable",
-			"Upload-Metadata",
-			"Upload-Defer-Length",
-			"Upload-Concat",
-		},
-		ExposeHeaders: []string{
-			"Upload-Offset",
-			"Location",
-			"Upload-Length",
-			"Tus-Version",
-			"Tus-Resumable",
-			"Tus-Max-Size",
-			"Tus-Extension",
-			"Upload-Metadata",
-			"Upload-Defer-Length",
-			"Upload-Concat",
-			"ETag",
-			"Last-Modified",
-			"Content-Disposition",
-		},
-	}))
+	// CORS origins come from system settings and are resolved per request
+	e.Use(handlers.CORSMiddleware())
+
+	// Load encryption keys and encrypted folders before any file is served
+	handlers.InitFileEncryption(db, dataRoot)
+
+	// Background job queue; job types register as their features start
+	handlers.InitJobs(db)
 
 	// Create handlers
 	h := handlers.NewHandler(db)
@@ -225,6 +173,17 @@ func main() {
 
 	// Create Audit handler
 	auditHandler := handlers.NewAuditHandler(db, dataRoot)
+	// Archive audit log entries past audit_retention_days
+	auditHandler.StartArchive()
+
+	// Resolve admin permissions from roles for tokens and admin routes
+	handlers.InitAdminRoles(db, auditHandler)
+
+	// Guest accounts are checked on login and on every token
+	handlers.InitGuests(db, auditHandler)
+
+	// Login sessions: idle and absolute timeouts, sign out everywhere
+	handlers.InitSessions(db)
 
 	// Initialize Brute Force Guard for login protection
 	bruteForceGuard := handlers.InitBruteForceGuard(db, auditHandler)
@@ -236,11 +195,25 @@ func main() {
 	// Create Notification service and handler (must be before handlers that use it)
 	notificationService := handlers.NewNotificationService(db)
 	notificationHandler := handlers.NewNotificationHandler(notificationService)
+	// Purge read notifications past notification_retention_days
+	notificationService.StartRetention(6 * time.Hour)
+
+	// Alert rules over the audit event stream (failed login bursts, new admin
+	// login countries, permanent deletion bursts)
+	handlers.InitAlertEngine(db, notificationService)
+	alertHandler := handlers.NewAlertHandler(db)
 
 	// Create share expiration checker (runs every hour to notify about expiring links)
 	shareExpirationChecker := handlers.NewShareExpirationChecker(db, notificationService)
 	shareExpirationChecker.StartBackgroundCheck(1 * time.Hour)
 
+	// Outgoing mail from the smtp_* settings, or SMTP_* while those are unset
+	handlers.InitMailer(settingsHandler)
+
+	// Monthly usage reports (notification center, and email when SMTP is configured)
+	usageReporter := handlers.NewUsageReporter(db, dataRoot, notificationService)
+	usageReporter.StartSchedule(1 * time.Hour)
+
 	// Create Share handler
 	shareHandler := handlers.NewShareHandler(db, dataRoot, auditHandler, notificationService)
 
@@ -252,6 +225,7 @@ func main() {
 
 	// Create Shared Folder handler
 	sharedFolderHandler := handlers.NewSharedFolderHandler(db, dataRoot, notificationService)
+	sharedFolderHandler.StartSnapshotJobs()
 
 	// Create File Share handler
 	fileShareHandler := handlers.NewFileShareHandler(db, notificationService)
@@ -259,6 +233,12 @@ func main() {
 	// Create File Metadata handler (descriptions and tags)
 	fileMetadataHandler := handlers.NewFileMetadataHandler(db)
 
+	// Create Provision handler (bulk user/shared folder import)
+	provisionHandler := handlers.NewProvisionHandler(db, authHandler, sharedFolderHandler, auditHandler)
+
+	// Create Instance Transfer handler (export/import between instances)
+	instanceTransferHandler := handlers.NewInstanceTransferHandler(h, authHandler, sharedFolderHandler)
+
 	// Create SSO handler
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
@@ -274,12 +254,18 @@ func main() {
 	// Routes
 	e.GET("/health", h.HealthCheck)
 	e.GET("/api/health", h.HealthCheck)
+	e.GET("/api/status", h.GetPublicStatus)
 
 	// Version endpoint
 	e.GET("/api/version", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, GetVersionInfo())
 	})
 
+	// OpenAPI 3 contract for third-party clients
+	e.GET("/api/openapi.json", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, docs.OpenAPISpec)
+	})
+
 	// Swagger documentation
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
@@ -289,6 +275,14 @@ func main() {
 	// Auth routes (public)
 	api.POST("/auth/login", authHandler.Login)
 	api.POST("/auth/2fa/verify", totpHandler.Verify2FA)
+	api.POST("/auth/password-reset/request", authHandler.RequestPasswordReset)
+	api.POST("/auth/password-reset/check", authHandler.CheckPasswordToken)
+	api.POST("/auth/password-reset", authHandler.ResetPassword)
+	api.GET("/auth/registration", authHandler.GetRegistrationPolicy)
+	api.POST("/auth/register", authHandler.Register)
+
+	// Token refresh: with the refresh cookie, or with a still valid token
+	api.POST("/auth/refresh", authHandler.RefreshToken, authHandler.OptionalJWTMiddleware)
 
 	// Initial setup route (requires auth token from login)
 	api.POST("/auth/initial-setup", authHandler.InitialSetup, authHandler.JWTMiddleware)
@@ -303,7 +297,10 @@ func main() {
 	authApi.Use(authHandler.JWTMiddleware)
 	authApi.GET("/auth/profile", authHandler.GetProfile)
 	authApi.PUT("/auth/profile", authHandler.UpdateProfile)
-	authApi.POST("/auth/refresh", authHandler.RefreshToken)
+	authApi.POST("/auth/logout", authHandler.Logout)
+	authApi.GET("/auth/sessions", authHandler.ListSessions)
+	authApi.POST("/auth/sessions/revoke-all", authHandler.SignOutEverywhere)
+	authApi.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
 	authApi.PUT("/auth/smb-password", authHandler.SetMySMBPassword)
 	authApi.GET("/auth/storage", authHandler.GetMyStorageUsage)
 
@@ -314,31 +311,71 @@ func main() {
 	authApi.POST("/auth/2fa/disable", totpHandler.Disable2FA)
 	authApi.POST("/auth/2fa/backup-codes", totpHandler.RegenerateBackupCodes)
 
-	// Admin routes (protected + admin only)
-	adminApi := authApi.Group("")
-	adminApi.Use(authHandler.AdminMiddleware)
-	adminApi.GET("/admin/users", authHandler.ListUsers)
-	adminApi.POST("/admin/users", authHandler.CreateUser)
-	adminApi.PUT("/admin/users/:id", authHandler.UpdateUser)
-	adminApi.DELETE("/admin/users/:id", authHandler.DeleteUser)
-	adminApi.DELETE("/admin/users/:id/2fa", totpHandler.AdminReset2FA)
+	// Admin routes, grouped by the admin permission they require
+	usersAdmin := authApi.Group("", authHandler.RequirePermission(handlers.PermUsersManage))
+	settingsAdmin := authApi.Group("", authHandler.RequirePermission(handlers.PermSettingsWrite))
+	auditAdmin := authApi.Group("", authHandler.RequirePermission(handlers.PermAuditRead))
+	storageAdmin := authApi.Group("", authHandler.RequirePermission(handlers.PermStorageAdmin))
+	provisionAdmin := authApi.Group("", authHandler.RequirePermission(handlers.PermUsersManage, handlers.PermStorageAdmin))
+	superAdmin := authApi.Group("", authHandler.RequirePermission(handlers.AdminPermissions...))
+	// Each group registers a catch-all; unknown routes stay a 404 rather than a permission error
+	authApi.RouteNotFound("/*", echo.NotFoundHandler)
+	usersAdmin.GET("/admin/roles", authHandler.ListRoles)
+	usersAdmin.GET("/admin/users/:id/roles", authHandler.GetUserRoles)
+	usersAdmin.PUT("/admin/users/:id/roles", authHandler.SetUserRoles)
+	usersAdmin.GET("/admin/users", authHandler.ListUsers)
+	usersAdmin.GET("/admin/users/pending", authHandler.ListPendingUsers)
+	usersAdmin.POST("/admin/users/:id/approve", authHandler.ApproveUser)
+	usersAdmin.POST("/admin/users/:id/reject", authHandler.RejectUser)
+	usersAdmin.GET("/admin/groups", authHandler.ListGroups)
+	usersAdmin.POST("/admin/groups", authHandler.CreateGroup)
+	usersAdmin.PUT("/admin/groups/:id", authHandler.UpdateGroup)
+	usersAdmin.DELETE("/admin/groups/:id", authHandler.DeleteGroup)
+	usersAdmin.GET("/admin/groups/:id/members", authHandler.ListGroupMembers)
+	usersAdmin.POST("/admin/groups/:id/members", authHandler.AddGroupMember)
+	usersAdmin.DELETE("/admin/groups/:id/members/:userId", authHandler.RemoveGroupMember)
+	usersAdmin.POST("/admin/users", authHandler.CreateUser)
+	usersAdmin.PUT("/admin/users/:id", authHandler.UpdateUser)
+	usersAdmin.DELETE("/admin/users/:id", authHandler.DeleteUser)
+	usersAdmin.DELETE("/admin/users/:id/2fa", totpHandler.AdminReset2FA)
+	usersAdmin.GET("/admin/users/:id/sessions", authHandler.ListUserSessions)
+	usersAdmin.DELETE("/admin/users/:id/sessions", authHandler.SignOutUser)
+	usersAdmin.POST("/admin/guests", authHandler.CreateGuest)
+	usersAdmin.PUT("/admin/guests/:id", authHandler.ExtendGuest)
+	usersAdmin.POST("/admin/guests/:id/convert", authHandler.ConvertGuest)
+	auditAdmin.GET("/admin/erasures", authHandler.ListUserErasures)
+	auditAdmin.GET("/admin/erasures/:id", authHandler.GetUserErasure)
+	usersAdmin.POST("/admin/erasures/:id/reverse", authHandler.ReverseUserErasure)
 
 	// File API routes (with optional auth for virtual path resolution)
 	api.GET("/files", h.ListFiles, authHandler.OptionalJWTMiddleware)
 	api.GET("/files/check", h.CheckFileExists, authHandler.OptionalJWTMiddleware)
 	api.GET("/files/search", h.SearchFiles, authHandler.OptionalJWTMiddleware)
 	api.Match([]string{http.MethodGet, http.MethodHead}, "/subtitle/*", h.GetSubtitle, authHandler.OptionalJWTMiddleware)
+	api.GET("/files/signature/*", h.GetFileSignature, authHandler.OptionalJWTMiddleware)
+	api.GET("/files/capabilities", h.GetFileCapabilities, authHandler.OptionalJWTMiddleware)
 	api.GET("/files/*", h.GetFile, authHandler.OptionalJWTMiddleware)
+	api.POST("/files/link", h.CreateFileLink, authHandler.OptionalJWTMiddleware)
 	api.PUT("/files/content/*", h.SaveFileContent, authHandler.OptionalJWTMiddleware)
+	api.PATCH("/files/content/*", h.PatchFileContent, authHandler.OptionalJWTMiddleware)
+	api.PATCH("/files/delta/*", h.PatchFileDelta, authHandler.OptionalJWTMiddleware)
 	api.DELETE("/files/*", h.DeleteFile, authHandler.OptionalJWTMiddleware)
 	api.PUT("/files/rename/*", h.RenameItem, authHandler.OptionalJWTMiddleware)
 	api.PUT("/files/move/*", h.MoveItem, authHandler.OptionalJWTMiddleware)
 	api.POST("/files/copy/*", h.CopyItem, authHandler.OptionalJWTMiddleware)
+	api.POST("/files/batch", h.BatchFileOperation, authHandler.OptionalJWTMiddleware)
 	api.GET("/files/move-stream/*", h.MoveItemStream, authHandler.OptionalJWTMiddleware)
 	api.GET("/files/copy-stream/*", h.CopyItemStream, authHandler.OptionalJWTMiddleware)
 	api.POST("/folders", h.CreateFolder, authHandler.OptionalJWTMiddleware)
 	api.DELETE("/folders/*", h.DeleteFolder, authHandler.OptionalJWTMiddleware)
 	api.GET("/folders/stats/*", h.GetFolderStats, authHandler.OptionalJWTMiddleware)
+	authApi.GET("/files/stats/*", h.GetFileDownloadStats)
+	authApi.GET("/files/exposure/*", h.GetFileExposure)
+	authApi.GET("/files/history/*", h.GetFileHistory)
+	authApi.GET("/files/checksum/*", h.GetFileChecksum)
+	authApi.POST("/upload/precheck", h.UploadPrecheck)
+	authApi.POST("/files/diff", h.DiffFiles)
+	authApi.GET("/changes", h.GetChanges)
 	api.POST("/folders/batch-stats", h.BatchGetFolderStats, authHandler.OptionalJWTMiddleware)
 	api.GET("/storage/usage", h.GetStorageUsage, authHandler.OptionalJWTMiddleware)
 	api.POST("/files/create", h.CreateFile, authHandler.OptionalJWTMiddleware)
@@ -348,32 +385,57 @@ func main() {
 
 	// ZIP Download API routes
 	api.POST("/download/zip", h.DownloadAsZip, authHandler.OptionalJWTMiddleware)
+	api.POST("/download/zip-by-query", h.DownloadZipByQuery, authHandler.OptionalJWTMiddleware)
+	api.POST("/download/preflight", h.DownloadPreflight, authHandler.OptionalJWTMiddleware)
 	api.GET("/download/folder/*", h.DownloadFolderAsZip, authHandler.OptionalJWTMiddleware)
 	api.GET("/zip/preview/*", h.PreviewZip, authHandler.OptionalJWTMiddleware)
 
+	// Resumable download sessions: a manifest of signed per-file URLs
+	authApi.POST("/download/session", h.CreateDownloadSession)
+	authApi.GET("/download/session/:id", h.GetDownloadSession)
+	authApi.POST("/download/session/:id/complete", h.CompleteDownloadSessionFiles)
+	authApi.DELETE("/download/session/:id", h.DeleteDownloadSession)
+	api.GET("/download/session/:id/files/:index", h.GetDownloadSessionFile)
+
+	// Camera backup (mobile photo/video ingest via upload with ingest=camera)
+	authApi.GET("/camera-backup", h.GetCameraBackup)
+	authApi.PUT("/camera-backup", h.UpdateCameraBackup)
+
 	// Trash API routes
 	api.POST("/trash/*", h.MoveToTrash, authHandler.OptionalJWTMiddleware)
 	api.GET("/trash", h.ListTrash, authHandler.OptionalJWTMiddleware)
 	api.GET("/trash/stats", h.GetTrashStats, authHandler.OptionalJWTMiddleware)
 	api.POST("/trash/restore/:id", h.RestoreFromTrash, authHandler.OptionalJWTMiddleware)
+	api.POST("/trash/restore-batch", h.RestoreTrashBatch, authHandler.OptionalJWTMiddleware)
 	api.DELETE("/trash/:id", h.DeleteFromTrash, authHandler.OptionalJWTMiddleware)
 	api.DELETE("/trash", h.EmptyTrash, authHandler.OptionalJWTMiddleware)
 
 	// Preview API
+	api.GET("/preview/stream/*", h.StreamVideoPreview, authHandler.OptionalJWTMiddleware)
 	api.GET("/preview/*", h.GetPreview, authHandler.OptionalJWTMiddleware)
+	api.GET("/email/attachment/*", h.GetEmailAttachment, authHandler.OptionalJWTMiddleware)
 
 	// Thumbnail API
 	api.Match([]string{http.MethodGet, http.MethodHead}, "/thumbnail/*", h.GetThumbnail, authHandler.OptionalJWTMiddleware)
 	api.GET("/thumbnails/responsive/*", h.GetResponsiveThumbnail, authHandler.OptionalJWTMiddleware)
 	api.POST("/thumbnails/batch", h.GetBatchThumbnails, authHandler.OptionalJWTMiddleware)
 	api.POST("/thumbnails/preload/*", h.PreloadThumbnails, authHandler.OptionalJWTMiddleware)
-	adminApi.GET("/thumbnails/stats", h.ThumbnailStats)
-	adminApi.DELETE("/thumbnails/cache", h.ClearThumbnailCache)
+	storageAdmin.GET("/thumbnails/stats", h.ThumbnailStats)
+	storageAdmin.DELETE("/thumbnails/cache", h.ClearThumbnailCache)
 
 	// OnlyOffice API routes
 	api.GET("/onlyoffice/settings", h.GetOnlyOfficeSettings)
 	api.GET("/onlyoffice/config/*", h.GetOnlyOfficeConfig, authHandler.JWTMiddleware)
+	api.POST("/onlyoffice/forcesave/*", h.OnlyOfficeForceSave, authHandler.JWTMiddleware)
 	api.POST("/onlyoffice/callback", h.OnlyOfficeCallback)
+	api.GET("/onlyoffice/test-document/:key", h.ServeOnlyOfficeTestDocument)
+	settingsAdmin.POST("/admin/onlyoffice/test", h.TestOnlyOffice)
+	settingsAdmin.GET("/admin/smtp", h.GetSMTPSettings)
+	settingsAdmin.PUT("/admin/smtp", h.UpdateSMTPSettings)
+	settingsAdmin.POST("/admin/smtp/test", h.SendTestEmail)
+	settingsAdmin.GET("/admin/file-actions", h.ListFileActionOverrides)
+	settingsAdmin.PUT("/admin/file-actions/:ext", h.SetFileActionOverride)
+	settingsAdmin.DELETE("/admin/file-actions/:ext", h.DeleteFileActionOverride)
 
 	// SMB Management API (protected)
 	authApi.GET("/smb/users", smbHandler.ListSMBUsers)
@@ -382,13 +444,16 @@ func main() {
 	authApi.DELETE("/smb/users/:username", smbHandler.DeleteSMBUser)
 	authApi.GET("/smb/config", smbHandler.GetSMBConfig)
 	authApi.PUT("/smb/config", smbHandler.UpdateSMBConfig)
-	adminApi.GET("/smb/audit", smbAuditHandler.GetSMBAuditLogs)
-	adminApi.POST("/smb/audit/sync", smbAuditHandler.SyncSMBAuditLogs)
+	auditAdmin.GET("/smb/audit", smbAuditHandler.GetSMBAuditLogs)
+	auditAdmin.POST("/smb/audit/sync", smbAuditHandler.SyncSMBAuditLogs)
 
-	// Audit logs API (protected)
-	authApi.GET("/audit/logs", auditHandler.ListAuditLogs)
-	authApi.GET("/audit/resource/*", auditHandler.GetResourceHistory)
-	authApi.GET("/audit/system", auditHandler.GetSystemLogs)
+	// Audit logs API (audit.read)
+	auditAdmin.GET("/audit/logs", auditHandler.ListAuditLogs)
+	auditAdmin.GET("/audit/export", auditHandler.ExportAuditLogs)
+	auditAdmin.GET("/audit/archives", auditHandler.ListAuditArchives)
+	auditAdmin.GET("/audit/archives/:name", auditHandler.DownloadAuditArchive)
+	auditAdmin.GET("/audit/resource/*", auditHandler.GetResourceHistory)
+	auditAdmin.GET("/audit/system", auditHandler.GetSystemLogs)
 
 	// Recent files API (protected)
 	authApi.GET("/files/recent", auditHandler.GetRecentFiles)
@@ -398,75 +463,178 @@ func main() {
 	authApi.GET("/notifications/unread-count", notificationHandler.GetUnreadCount)
 	authApi.PUT("/notifications/:id/read", notificationHandler.MarkAsRead)
 	authApi.PUT("/notifications/read-all", notificationHandler.MarkAllAsRead)
+	authApi.POST("/notifications/:id/read", notificationHandler.MarkAsRead)
+	authApi.POST("/notifications/read-all", notificationHandler.MarkAllAsRead)
 	authApi.DELETE("/notifications/:id", notificationHandler.Delete)
 	authApi.DELETE("/notifications", notificationHandler.DeleteAllRead)
 
 	// Share API (protected for management)
 	authApi.POST("/shares", shareHandler.CreateShare)
 	authApi.GET("/shares", shareHandler.ListShares)
+	authApi.PUT("/shares/:id", shareHandler.UpdateShare)
 	authApi.DELETE("/shares/:id", shareHandler.DeleteShare)
 
+	// Share token guards reject malformed tokens before any database lookup.
+	// Download and edit shares are served on both /s and /e.
+	shareGuard := handlers.ShareTokenGuard(handlers.ShareTokenPrefixDownload, handlers.ShareTokenPrefixEdit)
+	uploadShareGuard := handlers.ShareTokenGuard(handlers.ShareTokenPrefixUpload)
+
 	// Share access (public, with optional auth for require_login check)
-	api.GET("/s/:token", shareHandler.AccessShare, authHandler.OptionalJWTMiddleware)
-	api.POST("/s/:token", shareHandler.AccessShare, authHandler.OptionalJWTMiddleware)
-	api.GET("/s/:token/download", shareHandler.DownloadShare, authHandler.OptionalJWTMiddleware)
-	api.GET("/s/:token/list", shareHandler.ListShareContents, authHandler.OptionalJWTMiddleware)
-	api.GET("/s/:token/file", shareHandler.DownloadShareFile, authHandler.OptionalJWTMiddleware)
+	api.GET("/s/:token", shareHandler.AccessShare, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.POST("/s/:token", shareHandler.AccessShare, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.GET("/s/:token/download", shareHandler.DownloadShare, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.GET("/s/:token/list", shareHandler.ListShareContents, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.GET("/s/:token/file", shareHandler.DownloadShareFile, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.GET("/s/:token/preview", shareHandler.PreviewShareFile, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.GET("/s/:token/file/*", shareHandler.DownloadShareFile, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.GET("/s/:token/preview/*", shareHandler.PreviewShareFile, shareGuard, authHandler.OptionalJWTMiddleware)
 
 	// Edit share access (for OnlyOffice editable shares)
-	api.GET("/e/:token", shareHandler.AccessShare, authHandler.OptionalJWTMiddleware)
-	api.POST("/e/:token", shareHandler.AccessShare, authHandler.OptionalJWTMiddleware)
-	api.GET("/e/:token/config", shareHandler.GetShareOnlyOfficeConfig, authHandler.OptionalJWTMiddleware)
-	api.GET("/e/:token/file", shareHandler.GetShareFile, authHandler.OptionalJWTMiddleware)
-	api.POST("/e/:token/callback", shareHandler.ShareOnlyOfficeCallback)
+	api.GET("/e/:token", shareHandler.AccessShare, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.POST("/e/:token", shareHandler.AccessShare, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.GET("/e/:token/config", shareHandler.GetShareOnlyOfficeConfig, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.GET("/e/:token/file", shareHandler.GetShareFile, shareGuard, authHandler.OptionalJWTMiddleware)
+	api.POST("/e/:token/callback", shareHandler.ShareOnlyOfficeCallback, shareGuard)
 
 	// Upload share access (public, with optional auth for require_login check)
-	api.GET("/u/:token", uploadShareHandler.AccessUploadShare, authHandler.OptionalJWTMiddleware)
-	api.POST("/u/:token", uploadShareHandler.AccessUploadShare, authHandler.OptionalJWTMiddleware)
+	api.GET("/u/:token", uploadShareHandler.AccessUploadShare, uploadShareGuard, authHandler.OptionalJWTMiddleware)
+	api.POST("/u/:token", uploadShareHandler.AccessUploadShare, uploadShareGuard, authHandler.OptionalJWTMiddleware)
 
 	// Upload share TUS routes
-	e.Any("/api/u/:token/upload/", uploadShareHandler.HandleShareUpload, authHandler.OptionalJWTMiddleware)
-	e.Any("/api/u/:token/upload/*", uploadShareHandler.HandleShareUpload, authHandler.OptionalJWTMiddleware)
+	e.Any("/api/u/:token/upload/", uploadShareHandler.HandleShareUpload, uploadShareGuard, authHandler.OptionalJWTMiddleware)
+	e.Any("/api/u/:token/upload/*", uploadShareHandler.HandleShareUpload, uploadShareGuard, authHandler.OptionalJWTMiddleware)
 
 	// Shared Folders API (user - protected)
 	authApi.GET("/shared-folders", sharedFolderHandler.ListMySharedFolders)
 	authApi.GET("/shared-folders/:id/permission", sharedFolderHandler.GetMyPermission)
 
 	// Shared Folders API (admin - protected + admin only)
-	adminApi.GET("/admin/shared-folders", sharedFolderHandler.ListAllSharedFolders)
-	adminApi.POST("/admin/shared-folders", sharedFolderHandler.CreateSharedFolder)
-	adminApi.PUT("/admin/shared-folders/:id", sharedFolderHandler.UpdateSharedFolder)
-	adminApi.DELETE("/admin/shared-folders/:id", sharedFolderHandler.DeleteSharedFolder)
-	adminApi.GET("/admin/shared-folders/:id/members", sharedFolderHandler.ListMembers)
-	adminApi.POST("/admin/shared-folders/:id/members", sharedFolderHandler.AddMember)
-	adminApi.PUT("/admin/shared-folders/:id/members/:userId", sharedFolderHandler.UpdateMemberPermission)
-	adminApi.DELETE("/admin/shared-folders/:id/members/:userId", sharedFolderHandler.RemoveMember)
+	storageAdmin.GET("/admin/shared-folders", sharedFolderHandler.ListAllSharedFolders)
+	storageAdmin.POST("/admin/shared-folders", sharedFolderHandler.CreateSharedFolder)
+	storageAdmin.PUT("/admin/shared-folders/:id", sharedFolderHandler.UpdateSharedFolder)
+	storageAdmin.DELETE("/admin/shared-folders/:id", sharedFolderHandler.DeleteSharedFolder)
+	storageAdmin.GET("/admin/shared-folders/:id/snapshots", sharedFolderHandler.ListSnapshots)
+	storageAdmin.POST("/admin/shared-folders/:id/snapshots", sharedFolderHandler.CreateSnapshot)
+	storageAdmin.POST("/admin/shared-folders/:id/snapshots/:snapId/restore", sharedFolderHandler.RestoreSnapshot)
+	storageAdmin.DELETE("/admin/shared-folders/:id/snapshots/:snapId", sharedFolderHandler.DeleteSnapshot)
+	storageAdmin.GET("/admin/shared-folders/:id/members", sharedFolderHandler.ListMembers)
+	storageAdmin.POST("/admin/shared-folders/:id/members", sharedFolderHandler.AddMember)
+	storageAdmin.PUT("/admin/shared-folders/:id/members/:userId", sharedFolderHandler.UpdateMemberPermission)
+	storageAdmin.DELETE("/admin/shared-folders/:id/members/:userId", sharedFolderHandler.RemoveMember)
+	storageAdmin.PUT("/admin/shared-folders/:id/groups/:groupId", sharedFolderHandler.SetFolderGroup)
+	storageAdmin.DELETE("/admin/shared-folders/:id/groups/:groupId", sharedFolderHandler.RemoveFolderGroup)
+	settingsAdmin.POST("/admin/smb/validate", smbHandler.ValidateSMBConfig)
+
+	// Mount-ins: home folders shown read-only inside shared drives
+	storageAdmin.POST("/admin/mount-ins", h.CreateMountIn)
+	authApi.GET("/mount-ins", h.ListMountIns)
+	authApi.DELETE("/mount-ins/:id", h.DeleteMountIn)
+
+	// Retention holds (WORM) and their two-admin overrides
+	storageAdmin.GET("/admin/retention/policies", h.ListRetentionPolicies)
+	storageAdmin.POST("/admin/retention/policies", h.CreateRetentionPolicy)
+	storageAdmin.DELETE("/admin/retention/policies/:id", h.DeleteRetentionPolicy)
+	storageAdmin.GET("/admin/retention/overrides", h.ListRetentionOverrides)
+	storageAdmin.POST("/admin/retention/overrides", h.RequestRetentionOverride)
+	storageAdmin.POST("/admin/retention/overrides/:id/approve", h.ApproveRetentionOverride)
+
+	// Upload inspection: per-folder policies, DLP patterns, the queue and flags
+	storageAdmin.GET("/admin/inspection/policies", h.ListInspectionPolicies)
+	storageAdmin.POST("/admin/inspection/policies", h.CreateInspectionPolicy)
+	storageAdmin.PUT("/admin/inspection/policies/:id", h.UpdateInspectionPolicy)
+	storageAdmin.DELETE("/admin/inspection/policies/:id", h.DeleteInspectionPolicy)
+	settingsAdmin.GET("/admin/inspection/patterns", h.ListDLPPatterns)
+	settingsAdmin.POST("/admin/inspection/patterns", h.CreateDLPPattern)
+	settingsAdmin.PUT("/admin/inspection/patterns/:id", h.UpdateDLPPattern)
+	settingsAdmin.DELETE("/admin/inspection/patterns/:id", h.DeleteDLPPattern)
+	storageAdmin.GET("/admin/inspection/jobs", h.ListInspectionJobs)
+	storageAdmin.POST("/admin/inspection/jobs/:id/release", h.ReleaseInspectionJob)
+	storageAdmin.POST("/admin/inspection/jobs/:id/discard", h.DiscardInspectionJob)
+	storageAdmin.DELETE("/admin/inspection/flags", h.ClearInspectionFlag)
+
+	// Full-text content index: status and rebuild
+	storageAdmin.GET("/admin/content-index", h.GetContentIndexStatus)
+	storageAdmin.POST("/admin/content-index/reindex", h.ReindexContent)
+
+	// Startup self-test, re-run on demand (admin only)
+	storageAdmin.GET("/admin/selftest", h.GetSelfTest)
+
+	// Reference integrity (admin only)
+	storageAdmin.POST("/admin/integrity/references", h.CheckReferenceIntegrity)
+
+	// Storage migration of drives and home folders between volumes (admin only)
+	storageAdmin.POST("/admin/storage/migrate", h.StartStorageMigration)
+	storageAdmin.GET("/admin/storage/migrations", h.ListStorageMigrations)
+	storageAdmin.GET("/admin/storage/migrations/:id", h.GetStorageMigration)
+	storageAdmin.POST("/admin/storage/recalculate", h.RecalculateStorage)
+	storageAdmin.POST("/admin/storage/size-index/rebuild", h.RebuildDirSizeIndex)
+
+	// Background jobs: own jobs, cancel, and all jobs (admin only)
+	authApi.GET("/jobs", h.ListMyJobs)
+	authApi.GET("/jobs/:id", h.GetJob)
+	authApi.DELETE("/jobs/:id", h.CancelJob)
+	storageAdmin.GET("/admin/jobs", h.ListAllJobs)
+
+	// Monthly usage report preview and opt-in
+	authApi.GET("/usage-report", usageReporter.GetUsageReport)
+	authApi.PUT("/usage-report", usageReporter.UpdateUsageReportSettings)
+	storageAdmin.GET("/admin/usage-report", usageReporter.GetInstanceUsageReport)
+
+	// Bulk provisioning (admin only)
+	provisionAdmin.POST("/admin/provision", provisionHandler.Provision)
+
+	// Instance export/import for moving to another server (admin only)
+	superAdmin.POST("/admin/export", instanceTransferHandler.StartExport)
+	superAdmin.GET("/admin/export/:id/manifest", instanceTransferHandler.GetExportManifest)
+	superAdmin.GET("/admin/export/:id/archive", instanceTransferHandler.GetExportArchive)
+	superAdmin.GET("/admin/export/:id/files/*", instanceTransferHandler.GetExportFile)
+	superAdmin.POST("/admin/import", instanceTransferHandler.StartImport)
+	superAdmin.GET("/admin/transfers", instanceTransferHandler.ListTransfers)
+	superAdmin.GET("/admin/transfers/:id", instanceTransferHandler.GetTransfer)
+	superAdmin.POST("/admin/transfers/:id/resume", instanceTransferHandler.ResumeTransfer)
 
 	// System Settings API (admin only)
-	adminApi.GET("/admin/settings", settingsHandler.GetAllSettings)
-	adminApi.PUT("/admin/settings", settingsHandler.UpdateSettings)
+	settingsAdmin.GET("/admin/settings", settingsHandler.GetAllSettings)
+	settingsAdmin.PUT("/admin/settings", settingsHandler.UpdateSettings)
 
 	// System Info API (admin only)
-	adminApi.GET("/admin/system-info", h.GetSystemInfo)
-	adminApi.GET("/admin/system-info/tree", h.GetFolderTreeAPI)
+	storageAdmin.GET("/admin/system-info", h.GetSystemInfo)
+	storageAdmin.GET("/admin/system-info/tree", h.GetFolderTreeAPI)
+	auditAdmin.GET("/admin/downloads/top", h.GetTopDownloads)
+	storageAdmin.GET("/admin/activity/live", h.GetLiveActivity)
+	storageAdmin.POST("/admin/activity/:id/cancel", h.CancelActivity)
+	storageAdmin.POST("/admin/files/adopt", h.AdoptFiles)
+	storageAdmin.GET("/admin/files/adopt/:id", h.GetAdoptJob)
+	storageAdmin.GET("/admin/encryption", h.GetEncryptionStatus)
+	storageAdmin.PUT("/admin/encryption/folders", h.SetEncryptedFolder)
+	storageAdmin.POST("/admin/encryption/rewrap", h.StartEncryptionRewrap)
+	storageAdmin.GET("/admin/encryption/rewrap/:id", h.GetEncryptionRewrapJob)
+	auditAdmin.GET("/admin/access-report", h.GetAccessReport)
 
 	// SSO Provider Management API (admin only)
-	adminApi.GET("/admin/sso/providers", ssoHandler.ListAllProviders)
-	adminApi.POST("/admin/sso/providers", ssoHandler.CreateProvider)
-	adminApi.PUT("/admin/sso/providers/:id", ssoHandler.UpdateProvider)
-	adminApi.DELETE("/admin/sso/providers/:id", ssoHandler.DeleteProvider)
-	adminApi.GET("/admin/sso/settings", ssoHandler.GetSSOSettings)
-	adminApi.PUT("/admin/sso/settings", ssoHandler.UpdateSSOSettings)
+	settingsAdmin.GET("/admin/sso/providers", ssoHandler.ListAllProviders)
+	settingsAdmin.POST("/admin/sso/providers", ssoHandler.CreateProvider)
+	settingsAdmin.PUT("/admin/sso/providers/:id", ssoHandler.UpdateProvider)
+	settingsAdmin.DELETE("/admin/sso/providers/:id", ssoHandler.DeleteProvider)
+	settingsAdmin.POST("/admin/sso/providers/:id/test", ssoHandler.TestProvider)
+	settingsAdmin.GET("/admin/sso/settings", ssoHandler.GetSSOSettings)
+	settingsAdmin.PUT("/admin/sso/settings", ssoHandler.UpdateSSOSettings)
 
 	// Security Management API (admin only) - Brute Force Protection
-	adminApi.GET("/admin/security/locked-users", bruteForceGuard.GetLockedUsers)
-	adminApi.DELETE("/admin/security/locked-users/:username", bruteForceGuard.UnlockUser)
-	adminApi.GET("/admin/security/stats", bruteForceGuard.GetStats)
+	usersAdmin.GET("/admin/security/locked-users", bruteForceGuard.GetLockedUsers)
+	usersAdmin.DELETE("/admin/security/locked-users/:username", bruteForceGuard.UnlockUser)
+	auditAdmin.GET("/admin/security/stats", bruteForceGuard.GetStats)
+	auditAdmin.GET("/admin/alerts", alertHandler.ListAlerts)
+	auditAdmin.GET("/admin/alerts/rules", alertHandler.ListAlertRules)
+	settingsAdmin.PUT("/admin/alerts/rules/:name", alertHandler.UpdateAlertRule)
 
 	// File Share API (user-to-user sharing - protected)
 	authApi.POST("/file-shares", fileShareHandler.CreateFileShare)
 	authApi.GET("/file-shares/shared-by-me", fileShareHandler.ListSharedByMe)
 	authApi.GET("/file-shares/shared-with-me", fileShareHandler.ListSharedWithMe)
+	authApi.GET("/file-shares/invitations", fileShareHandler.ListInvitations)
+	authApi.POST("/file-shares/:id/accept", fileShareHandler.AcceptInvitation)
+	authApi.POST("/file-shares/:id/decline", fileShareHandler.DeclineInvitation)
 	authApi.PUT("/file-shares/:id", fileShareHandler.UpdateFileShare)
 	authApi.DELETE("/file-shares/:id", fileShareHandler.DeleteFileShare)
 	authApi.GET("/file-shares/file/*", fileShareHandler.GetFileShareInfo)
@@ -474,8 +642,12 @@ func main() {
 
 	// File Metadata API (descriptions and tags - protected)
 	authApi.GET("/file-metadata/tags", fileMetadataHandler.ListUserTags)
+	authApi.POST("/file-metadata/tags/apply", fileMetadataHandler.ApplyTags)
+	authApi.PUT("/file-metadata/tags/rename", fileMetadataHandler.RenameTag)
+	authApi.DELETE("/file-metadata/tags/:tag", fileMetadataHandler.DeleteTag)
 	authApi.GET("/file-metadata/search", fileMetadataHandler.SearchByTag)
 	authApi.POST("/file-metadata/batch", fileMetadataHandler.GetBatchMetadata)
+	authApi.PUT("/file-metadata/folder/*", h.UpdateFolderDisplay)
 	authApi.GET("/file-metadata/*", fileMetadataHandler.GetFileMetadata)
 	authApi.PUT("/file-metadata/*", fileMetadataHandler.UpdateFileMetadata)
 	authApi.DELETE("/file-metadata/*", fileMetadataHandler.DeleteFileMetadata)
@@ -520,35 +692,22 @@ func main() {
 
 		switch req.Method {
 		case http.MethodPost:
-			// Check quota before allowing upload (only for /home/ uploads)
-			uploadPath := req.Header.Get("Upload-Metadata")
-			uploadLengthStr := req.Header.Get("Upload-Length")
-			if uploadLengthStr != "" && strings.Contains(uploadPath, "cGF0aA") { // base64 of "path"
-				uploadLength, _ := strconv.ParseInt(uploadLengthStr, 10, 64)
-				// Extract username from metadata (base64 encoded as "username <base64value>")
-				if strings.Contains(uploadPath, "dXNlcm5hbWU") { // base64 of "username"
-					// Parse metadata to get username
-					parts := strings.Split(uploadPath, ",")
-					for _, part := range parts {
-						part = strings.TrimSpace(part)
-						if strings.HasPrefix(part, "username ") {
-							// Decode base64 username
-							usernameB64 := strings.TrimPrefix(part, "username ")
-							if decoded, err := handlers.DecodeBase64(usernameB64); err == nil {
-								username := string(decoded)
-								allowed, quota, used := authHandler.CheckQuota(username, uploadLength)
-								if !allowed {
-									log.Printf("[TUS] Quota exceeded for user %s: used=%d, quota=%d, upload=%d", username, used, quota, uploadLength)
-									return c.JSON(http.StatusForbidden, map[string]interface{}{
-										"error":     "Storage quota exceeded",
-										"quota":     quota,
-										"used":      used,
-										"requested": uploadLength,
-									})
-								}
-							}
-						}
-					}
+			// Check quota before allowing upload (only for /home/ uploads;
+			// the pre-create hook checks shared folders against their own quota)
+			metadata := uploadMetadata(req.Header.Get("Upload-Metadata"))
+			uploadLength, _ := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+			if username := metadata["username"]; username != "" && uploadLength > 0 && !strings.HasPrefix(metadata["path"], "/shared/") {
+				// Files of a folder upload are checked with the rest of the batch
+				uploadLength = handlers.UploadBatchBytes(uploadLength, metadata["batchRemaining"])
+				allowed, quota, used := authHandler.CheckQuota(username, uploadLength)
+				if !allowed {
+					log.Printf("[TUS] Quota exceeded for user %s: used=%d, quota=%d, upload=%d", username, used, quota, uploadLength)
+					return c.JSON(http.StatusRequestEntityTooLarge, map[string]interface{}{
+						"error":     "Storage quota exceeded",
+						"quota":     quota,
+						"used":      used,
+						"requested": uploadLength,
+					})
 				}
 			}
 			tusHandler.PostFile(res, req)
@@ -570,6 +729,13 @@ func main() {
 		case http.MethodHead:
 			tusHandler.HeadFile(res, req)
 		case http.MethodPatch:
+			cleanup, status, err := handlers.VerifyUploadChecksum(req, filepath.Join(dataRoot, ".uploads"))
+			defer cleanup()
+			if status != 0 {
+				req.URL.Path = originalPath
+				res.Header().Set("Tus-Resumable", "1.0.0")
+				return c.JSON(status, map[string]string{"error": err.Error()})
+			}
 			tusHandler.PatchFile(res, req)
 		case http.MethodDelete:
 			tusHandler.DelFile(res, req)
@@ -579,7 +745,8 @@ func main() {
 			// Return Tus supported methods
 			res.Header().Set("Tus-Resumable", "1.0.0")
 			res.Header().Set("Tus-Version", "1.0.0")
-			res.Header().Set("Tus-Extension", "creation,creation-with-upload,termination")
+			res.Header().Set("Tus-Extension", "creation,creation-with-upload,termination,checksum")
+			res.Header().Set("Tus-Checksum-Algorithm", handlers.TusChecksumAlgorithms)
 			res.Header().Set("Tus-Max-Size", "10737418240")
 			res.WriteHeader(http.StatusNoContent)
 		default:
@@ -598,10 +765,15 @@ func main() {
 	// WebSocket route for file change notifications (auth handled in handler via query param)
 	api.GET("/ws", h.HandleWebSocket)
 
-	// Start web upload tracker cleanup routines
+	// Start web upload tracker cleanup routines; their state is kept in the
+	// database so uploads resumed after a restart keep their client IP
+	handlers.InitUploadTrackers(db)
 	handlers.GetWebUploadTracker().StartCleanupRoutine()
 	handlers.GetTusIPTracker().StartCleanupRoutine()
 
+	// Delete unfinished uploads idle past upload_expiry_hours
+	handlers.StartUploadExpiry(auditHandler)
+
 	// Initialize storage tracking for all users (background task with delay)
 	go func() {
 		// Wait 5 seconds before recalculating to avoid slowing down initial requests
@@ -612,9 +784,82 @@ func main() {
 		}
 	}()
 
+	// Start download statistics flushing (counts are buffered in memory)
+	handlers.InitDownloadStats(db, dataRoot).StartFlushRoutine(1 * time.Minute)
+
+	// Checksum download session files and delete expired sessions
+	handlers.InitDownloadSessions(db)
+
+	// Start change journal compaction for sync clients
+	handlers.InitChangeJournal(db, dataRoot).StartCompaction(6 * time.Hour)
+
+	// Purge the identity mapping of GDPR erasures once their hold ends
+	handlers.StartUserErasurePurge(db, 6*time.Hour)
+
+	// Index link files so moves of their targets can update them
+	handlers.InitFileLinks(db, dataRoot)
+
+	// Folder default sort and pinned items follow renames and moves
+	handlers.InitFolderDisplay(db, dataRoot)
+
+	// Home folders mounted read-only into shared drives
+	handlers.InitMountIns(db, dataRoot)
+
+	// Drives and home folders moved to other volumes
+	handlers.InitStorageLocations(db, dataRoot)
+
+	// WORM retention holds on designated folders
+	handlers.InitRetention(db, dataRoot)
+
+	// Antivirus/DLP inspection of uploads into folders with a policy
+	handlers.InitContentInspection(db, dataRoot, notificationService)
+
+	// Per-folder entry count warnings and limits
+	handlers.InitDirEntryLimits(db, dataRoot, notificationService)
+
+	// Thumbnails kept on disk across restarts, capped by thumbnail_cache_max_bytes
+	handlers.InitThumbnailCache(dataRoot)
+
+	// Text of documents for content search, kept while content_index_enabled is on
+	handlers.InitContentIndex(db, dataRoot)
+	handlers.InitFileChecksums(db, dataRoot)
+
+	// Per-folder sizes for storage usage and folder statistics
+	handlers.InitDirSizes(db, dataRoot)
+
+	// Per-extension open actions reported to clients
+	handlers.InitFileCapabilities(db)
+
+	// Render SMB sections for shared drives exported over SMB
+	handlers.InitSMBShares(db, "/etc/filehatch").Sync(nil, "", "startup")
+
+	// Sample live transfer rates for the admin activity view
+	handlers.GetActivityRegistry().StartSampler(5 * time.Second)
+
 	// Start trash auto-cleanup (runs every 24 hours)
 	h.StartTrashAutoCleanup(handlers.DefaultTrashCleanupConfig())
 
+	// Bulk trash restores run as background jobs
+	h.StartTrashRestoreJobs()
+
+	// Daily content index catch-up, also queued from the admin API
+	h.StartContentIndexJobs()
+
+	// Daily folder size reconcile, also queued from the admin API
+	h.StartDirSizeJobs()
+
+	// Check storage layout, permissions and configuration; the summary is logged
+	h.RunSelfTest("startup")
+
+	// Continue storage migrations interrupted by a restart
+	h.ResumeStorageMigrations()
+
+	// Continue instance exports and imports interrupted by a restart
+	instanceTransferHandler.ResumeInstanceTransfers()
+
+	// Report dead share, membership and trash references (startup, then daily)
+	h.StartIntegrityChecks(24 * time.Hour)
+
 	// Start file watcher for real-time updates and SMB audit logging
 	fileWatcher, err := handlers.NewFileWatcher(dataRoot, db)
 	if err != nil {
@@ -652,6 +897,19 @@ func main() {
 		Addr:    ":" + port,
 		Handler: combinedHandler,
 	}
+
+	// On SIGINT/SIGTERM, let running jobs stop at a checkpoint, then drain requests
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+		log.Printf("Shutting down")
+		handlers.GetJobs().Stop(15 * time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Failed to start server: %v", err)
 	}
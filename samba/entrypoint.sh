@@ -3,6 +3,7 @@ set -e
 
 SYNC_FILE="/etc/filehatch/smb_users.txt"
 SYNC_DIR="/etc/filehatch"
+SHARES_DIR="/etc/filehatch/smb.d"
 AUDIT_LOG="/etc/filehatch/smb_audit.log"
 
 echo "[FileHatch-Samba] Starting user sync service..."
@@ -14,6 +15,11 @@ if [ ! -s "/etc/samba/smb.conf" ] && [ -f "/smb.conf.template" ]; then
     cat /smb.conf.template > /etc/samba/smb.conf
 fi
 
+# Per shared drive sections are rendered by the API into $SHARES_DIR;
+# smb.conf includes the index, which must exist before smbd starts
+mkdir -p "$SHARES_DIR"
+[ -f "$SHARES_DIR/shares.conf" ] || echo "# Shared drives exported over SMB - managed by FileHatch" > "$SHARES_DIR/shares.conf"
+
 # Create users group if not exists
 groupadd -f users 2>/dev/null || true
 
@@ -33,6 +39,14 @@ find /data/shared -mindepth 1 -type d -exec chown :users {} \; 2>/dev/null || tr
 find /data/shared -mindepth 1 -type f -exec chmod 664 {} \; 2>/dev/null || true
 find /data/shared -mindepth 1 -type f -exec chown :users {} \; 2>/dev/null || true
 
+# Encrypted folders (marked by the API with .fh-encrypted) hold ciphertext and
+# are only readable through the web UI/API. Keep them closed to SMB users.
+find /data/shared /data/users -name .fh-encrypted -type f 2>/dev/null | while read -r marker; do
+    dir=$(dirname "$marker")
+    chown root:root "$dir" 2>/dev/null || true
+    chmod 700 "$dir"
+done
+
 # Create audit log files
 echo "[FileHatch-Samba] Setting up audit logging..."
 touch "$AUDIT_LOG"
@@ -120,6 +134,18 @@ sync_users
     done
 ) &
 
+# Reload config when the API re-renders shared drive sections. The API
+# validates sections before writing, so a reload never sees a broken file.
+(
+    echo "[FileHatch-Samba] Starting shared drive config watcher..."
+    while true; do
+        inotifywait -q -e close_write,moved_to "$SHARES_DIR" 2>/dev/null | grep -q '\.reload$' || continue
+        sleep 0.5
+        echo "[FileHatch-Samba] Shared drive sections changed, reloading config..."
+        smbcontrol smbd reload-config 2>/dev/null || true
+    done
+) &
+
 echo "[FileHatch-Samba] User sync service started."
 
 # Keep container running
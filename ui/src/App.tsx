@@ -15,6 +15,7 @@ import UserProfile from './components/UserProfile'
 import LoginPage from './components/LoginPage'
 import ShareAccessPage from './components/ShareAccessPage'
 import UploadShareAccessPage from './components/UploadShareAccessPage'
+import ResetPasswordPage from './components/ResetPasswordPage'
 import FileListSkeleton from './components/FileListSkeleton'
 import ErrorBoundary from './components/ErrorBoundary'
 import './styles/app.css'
@@ -25,6 +26,7 @@ const AdminSettings = lazy(() => import('./components/AdminSettings'))
 const AdminSSOSettings = lazy(() => import('./components/AdminSSOSettings'))
 const AdminLogs = lazy(() => import('./components/AdminLogs'))
 const AdminSharedFolders = lazy(() => import('./components/AdminSharedFolders'))
+const AdminGroups = lazy(() => import('./components/AdminGroups'))
 const AdminSystemInfo = lazy(() => import('./components/AdminSystemInfo'))
 const MyActivity = lazy(() => import('./components/MyActivity'))
 const NotificationCenter = lazy(() => import('./components/NotificationCenter'))
@@ -119,6 +121,7 @@ function App() {
   // Get admin view from URL
   const getAdminView = (): AdminView => {
     if (location.pathname === '/fhadmin/shared-folders') return 'shared-folders'
+    if (location.pathname === '/fhadmin/groups') return 'groups'
     if (location.pathname === '/fhadmin/settings') return 'settings'
     if (location.pathname === '/fhadmin/sso') return 'sso'
     if (location.pathname === '/fhadmin/logs') return 'logs'
@@ -146,10 +149,16 @@ function App() {
 
     // If token is already expired or about to expire, try to refresh immediately
     if (timeUntilRefresh <= 0) {
-      // Check if token is completely expired
+      // Check if token is completely expired; the refresh cookie may still
+      // hold a live session
       if (expiration <= now) {
-        console.log('[Auth] Token expired, logging out')
-        logout()
+        console.log('[Auth] Token expired, refreshing with the session cookie')
+        refreshAuthToken().then((success) => {
+          if (!success) {
+            console.log('[Auth] Session ended, logging out')
+            logout()
+          }
+        })
         return
       }
       // Token is about to expire, refresh immediately
@@ -237,6 +246,11 @@ function App() {
     return <UploadShareAccessPage />
   }
 
+  // Handle password reset and welcome links (public route, no auth required)
+  if (location.pathname === '/reset-password') {
+    return <ResetPasswordPage />
+  }
+
   // Show login page if not authenticated
   if (!token) {
     return <LoginPage />
@@ -423,6 +437,11 @@ function App() {
                   <AdminSharedFolders />
                 </Suspense>
               } />
+              <Route path="/fhadmin/groups" element={
+                <Suspense fallback={<AdminSkeleton />}>
+                  <AdminGroups />
+                </Suspense>
+              } />
               <Route path="/fhadmin/settings" element={
                 <Suspense fallback={<AdminSkeleton />}>
                   <AdminSettings />
@@ -34,8 +34,29 @@ export interface LoginRequest {
 export interface CreateUserRequest {
   username: string
   email?: string
-  password: string
+  password?: string
   isAdmin?: boolean
+  /** Mail the user a one-time link to set their password; password may then be omitted */
+  sendWelcomeEmail?: boolean
+}
+
+export interface RegistrationPolicy {
+  enabled: boolean
+  requiresApproval: boolean
+  emailDomains: string[] // empty: any address, email optional
+}
+
+export interface PendingUser {
+  id: string
+  username: string
+  email: string
+  registeredAt: string
+}
+
+export interface PasswordTokenInfo {
+  username: string
+  purpose: 'reset' | 'setup'
+  expiresAt: string
 }
 
 export interface AuthResponse {
@@ -44,6 +65,7 @@ export interface AuthResponse {
   requires2fa?: boolean
   requiresSetup?: boolean
   userId?: string
+  pendingToken?: string  // Short-lived token to send with the 2FA code
 }
 
 export interface InitialSetupRequest {
@@ -124,6 +146,33 @@ export interface SSOProvider {
   updatedAt: string
 }
 
+export interface SSOEndpoints {
+  authorizationUrl: string
+  tokenUrl: string
+  userinfoUrl: string
+  authorizationSource: string
+  tokenSource: string
+  userinfoSource: string
+}
+
+export interface SSOProviderTestResult {
+  providerType: string
+  discoveryUrl?: string
+  discovered: boolean
+  discoveryError?: string
+  discovery?: {
+    issuer: string
+    authorization_endpoint: string
+    token_endpoint: string
+    userinfo_endpoint?: string
+    jwks_uri?: string
+    scopes_supported?: string[]
+    code_challenge_methods_supported?: string[]
+  }
+  endpoints: SSOEndpoints
+  warnings: string[]
+}
+
 export interface SSOSettings {
   sso_enabled: string
   sso_only_mode: string
@@ -155,6 +204,46 @@ export async function completeInitialSetup(
   })
 }
 
+/**
+ * Get whether self-registration is offered
+ */
+export async function getRegistrationPolicy(): Promise<RegistrationPolicy> {
+  const response = await api.get<{ data: RegistrationPolicy }>('/auth/registration', { noAuth: true })
+  return response.data
+}
+
+/**
+ * Register an account. With pending, an admin has to approve it first.
+ */
+export async function register(data: { username: string; email?: string; password: string }): Promise<{ id: string; pending: boolean; message: string }> {
+  return api.post<{ id: string; pending: boolean; message: string }>('/auth/register', data, { noAuth: true })
+}
+
+/**
+ * Request a password reset mail. The response is the same whether or not
+ * the username or email matched an account.
+ */
+export async function requestPasswordReset(username: string): Promise<{ message: string }> {
+  const response = await api.post<{ data: { message: string } }>('/auth/password-reset/request', { username }, { noAuth: true })
+  return response.data
+}
+
+/**
+ * Check a password reset or setup link without using it up
+ */
+export async function checkPasswordToken(token: string): Promise<PasswordTokenInfo> {
+  const response = await api.post<{ data: PasswordTokenInfo }>('/auth/password-reset/check', { token }, { noAuth: true })
+  return response.data
+}
+
+/**
+ * Set a new password with a reset or setup link. The link works once.
+ */
+export async function resetPassword(token: string, password: string): Promise<{ message: string }> {
+  const response = await api.post<{ data: { message: string } }>('/auth/password-reset', { token, password }, { noAuth: true })
+  return response.data
+}
+
 /**
  * Get current user profile
  * @param _token - Deprecated, token is now handled automatically
@@ -234,13 +323,22 @@ export async function disable2FA(_tokenOrPassword: string, password?: string): P
 
 /**
  * Verify 2FA code during login (no auth required)
+ * @param pendingToken - Token from the login response that asked for the code
  */
-export async function verify2FA(userId: string, code: string, rememberMe?: boolean): Promise<AuthResponse> {
-  return api.post<AuthResponse>('/auth/2fa/verify', { userId, code, rememberMe }, { noAuth: true })
+export async function verify2FA(pendingToken: string, code: string, rememberMe?: boolean): Promise<AuthResponse> {
+  return api.post<AuthResponse>('/auth/2fa/verify', { pendingToken, code, rememberMe }, { noAuth: true })
 }
 
 /**
- * Refresh the authentication token
+ * End the current session on the server, including its refresh cookie
+ */
+export async function logoutSession(): Promise<void> {
+  await api.post('/auth/logout')
+}
+
+/**
+ * Refresh the authentication token. The httpOnly refresh cookie set at
+ * sign-in is sent along, so this also works once the token has expired.
  */
 export async function refreshToken(): Promise<{ token: string }> {
   return api.post<{ token: string }>('/auth/refresh')
@@ -279,6 +377,28 @@ export async function createUser(
   return api.post<{ id: string }>('/admin/users', userData)
 }
 
+/**
+ * List self-registered accounts waiting for approval (admin only)
+ */
+export async function listPendingUsers(): Promise<{ users: PendingUser[]; total: number }> {
+  const response = await api.get<{ data: { users: PendingUser[]; total: number } }>('/admin/users/pending')
+  return response.data
+}
+
+/**
+ * Approve a pending registration (admin only)
+ */
+export async function approveUser(userId: string): Promise<void> {
+  await api.post(`/admin/users/${userId}/approve`)
+}
+
+/**
+ * Reject a pending registration, deleting the account (admin only)
+ */
+export async function rejectUser(userId: string): Promise<void> {
+  await api.post(`/admin/users/${userId}/reject`)
+}
+
 /**
  * Update a user (admin only)
  * @param _tokenOrUserId - If called with token (deprecated), pass token here. Otherwise, user ID.
@@ -390,6 +510,15 @@ export async function deleteSSOProvider(_tokenOrId: string, id?: string): Promis
   await api.delete(`/admin/sso/providers/${providerId}`)
 }
 
+/**
+ * Test an SSO provider's configuration (admin only). OIDC providers have
+ * their discovery document fetched afresh.
+ * @param id - Provider ID
+ */
+export async function testSSOProvider(id: string): Promise<SSOProviderTestResult> {
+  return api.post<SSOProviderTestResult>(`/admin/sso/providers/${id}/test`)
+}
+
 /**
  * Get SSO settings (admin only)
  * @param _token - Deprecated, token is now handled automatically
@@ -131,6 +131,7 @@ export interface LinkShare {
   uploadCount?: number
   maxTotalSize?: number
   totalUploadedSize?: number
+  notifyEmail?: boolean
 }
 
 export interface UploadShareInfo {
@@ -163,8 +164,11 @@ export async function createShareLink(data: {
   maxFileSize?: number // max file size in bytes (0 = unlimited)
   allowedExtensions?: string // comma-separated list
   maxTotalSize?: number // max total upload size in bytes
-}): Promise<{ id: string; token: string; url: string; shareType: string }> {
-  const response = await api.post<{ data: { id: string; token: string; url: string; shareType: string } }>('/shares', data)
+  notifyEmail?: boolean // also email downloads/uploads when SMTP is configured
+  emailTo?: string[] // email the link to these addresses (max 20, needs SMTP)
+  emailMessage?: string // personal note for the link email
+}): Promise<{ id: string; token: string; url: string; shareType: string; emailedTo?: string[] }> {
+  const response = await api.post<{ data: { id: string; token: string; url: string; shareType: string; emailedTo?: string[] } }>('/shares', data)
   return response.data
 }
 
@@ -176,6 +180,25 @@ export async function getMyShareLinks(): Promise<LinkShare[]> {
   return response.data?.shares || []
 }
 
+/**
+ * Update a share link, keeping its URL. Omitted fields keep their value;
+ * upload limits and resetCounters apply to upload shares only.
+ */
+export async function updateShareLink(shareId: string, data: {
+  isActive?: boolean // false pauses the link, true resumes it
+  expiresIn?: number // hours from now, 0 = never
+  password?: string // '' removes the password
+  maxAccess?: number // 0 = unlimited, not below the current count
+  maxFileSize?: number
+  allowedExtensions?: string
+  maxTotalSize?: number
+  resetCounters?: boolean
+  notifyEmail?: boolean
+}): Promise<{ expiresAt?: string }> {
+  const response = await api.put<{ data: { expiresAt?: string } }>(`/shares/${shareId}`, data)
+  return response.data
+}
+
 /**
  * Delete a share link
  */
@@ -237,6 +260,7 @@ export interface ShareFileItem {
   isDir: boolean
   size: number
   modTime: string
+  preview?: 'image' | 'video' | 'audio' | 'pdf'
 }
 
 export interface ShareContentsResponse {
@@ -255,11 +279,13 @@ export interface ShareContentsResponse {
 export async function listShareContents(
   token: string,
   subpath?: string,
-  password?: string
+  password?: string,
+  session?: string
 ): Promise<ShareContentsResponse> {
   const params = new URLSearchParams()
   if (subpath) params.append('subpath', subpath)
-  if (password) params.append('password', password)
+  if (session) params.append('session', session)
+  else if (password) params.append('password', password)
   const queryString = params.toString()
   const url = `/s/${token}/list${queryString ? `?${queryString}` : ''}`
   const response = await api.get<{ data: ShareContentsResponse }>(url, { noAuth: true })
@@ -272,12 +298,32 @@ export async function listShareContents(
 export function getShareFileDownloadUrl(
   token: string,
   filepath: string,
-  password?: string
+  password?: string,
+  session?: string
 ): string {
   const params = new URLSearchParams()
-  params.append('filepath', filepath)
-  if (password) params.append('password', password)
-  return `/api/s/${token}/file?${params.toString()}`
+  if (session) params.append('session', session)
+  else if (password) params.append('password', password)
+  const query = params.toString()
+  return `/api/s/${token}/file/${encodeSharePath(filepath)}${query ? `?${query}` : ''}`
+}
+
+/**
+ * Get thumbnail URL for an image or video within a shared folder
+ */
+export function getShareThumbnailUrl(
+  token: string,
+  filepath: string,
+  session?: string,
+  size: 'small' | 'medium' | 'large' = 'small'
+): string {
+  const params = new URLSearchParams({ size })
+  if (session) params.append('session', session)
+  return `/api/s/${token}/preview/${encodeSharePath(filepath)}?${params.toString()}`
+}
+
+function encodeSharePath(filepath: string): string {
+  return filepath.split('/').filter(Boolean).map(encodeURIComponent).join('/')
 }
 
 // ========== Helper Functions ==========
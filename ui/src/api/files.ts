@@ -1,5 +1,11 @@
 import { api, apiUrl, getAuthHeaders, getAuthToken as _getAuthToken } from './client'
 
+// Matched part of a search result's name, in JavaScript string indexes
+export interface MatchRange {
+  start: number
+  end: number
+}
+
 export interface FileInfo {
   name: string
   path: string
@@ -9,8 +15,10 @@ export interface FileInfo {
   extension?: string
   mimeType?: string
   // Search result fields
-  matchType?: 'name' | 'tag' | 'description' | 'trash'
+  matchType?: 'name' | 'tag' | 'description' | 'content' | 'trash'
   matchedTag?: string
+  snippet?: string
+  matchRanges?: MatchRange[]
   description?: string
   tags?: string[]
   // Trash-related fields
@@ -26,6 +34,10 @@ export interface ListFilesResponse {
   files: FileInfo[]
   total: number
   totalSize: number
+  // Sort applied: the requested one, or the folder default
+  sort?: string
+  order?: 'asc' | 'desc'
+  pinned?: string[]
 }
 
 const API_BASE = '/api'
@@ -56,10 +68,11 @@ function parseContentDisposition(header: string | null, fallback: string): strin
   return fallback
 }
 
+// Without sort and order, the folder's default sort applies
 export async function fetchFiles(
   path: string = '/',
-  sort: string = 'name',
-  order: string = 'asc'
+  sort?: string,
+  order?: string
 ): Promise<ListFilesResponse> {
   return api.get<ListFilesResponse>(apiUrl.withParams('/files', { path, sort, order }))
 }
@@ -245,19 +258,53 @@ export async function renameItem(path: string, newName: string): Promise<{ succe
   return api.put<{ success: boolean; newPath: string }>(`/files/rename/${apiUrl.encodePath(path)}`, { newName })
 }
 
+// What a move or copy does when the name is taken at the destination
+export type ConflictStrategy = 'fail' | 'overwrite' | 'rename' | 'skip'
+
 // Move file or folder
-export async function moveItem(path: string, destination: string): Promise<{ success: boolean; newPath: string }> {
-  return api.put<{ success: boolean; newPath: string }>(`/files/move/${apiUrl.encodePath(path)}`, { destination })
+export async function moveItem(path: string, destination: string, onConflict: ConflictStrategy = 'fail'): Promise<{ success: boolean; newPath: string }> {
+  return api.put<{ success: boolean; newPath: string }>(`/files/move/${apiUrl.encodePath(path)}`, { destination, onConflict })
+}
+
+// Copy file or folder (a taken name gets the next free "name (n)" unless told otherwise)
+export async function copyItem(path: string, destination: string, onConflict: ConflictStrategy = 'rename'): Promise<{ success: boolean; newPath: string }> {
+  return api.post<{ success: boolean; newPath: string }>(`/files/copy/${apiUrl.encodePath(path)}`, { destination, onConflict })
+}
+
+// Per-item outcome of a batch move, copy or delete
+export interface BatchItemResult {
+  path: string
+  status: 'ok' | 'skipped' | 'failed'
+  code?: string
+  error?: string
+  newPath?: string
+  conflict?: string
+  trashId?: string
+  replacedTrashId?: string
+}
+
+export interface BatchResult {
+  operation: 'move' | 'copy' | 'delete'
+  succeeded: number
+  skipped: number
+  failed: number
+  results: BatchItemResult[]
 }
 
-// Copy file or folder
-export async function copyItem(path: string, destination: string): Promise<{ success: boolean; newPath: string }> {
-  return api.post<{ success: boolean; newPath: string }>(`/files/copy/${apiUrl.encodePath(path)}`, { destination })
+// Move, copy or trash several items in one request; a failing item does not stop the others
+export async function batchFileOperation(
+  operation: 'move' | 'copy' | 'delete',
+  items: string[],
+  destination?: string,
+  onConflict?: ConflictStrategy
+): Promise<BatchResult> {
+  const response = await api.post<{ success: boolean; data: BatchResult }>('/files/batch', { operation, items, destination, onConflict })
+  return response.data
 }
 
 // Progress callback type for streaming operations
 export interface TransferProgress {
-  status: 'started' | 'progress' | 'completed' | 'error'
+  status: 'scanning' | 'started' | 'progress' | 'completed' | 'error'
   totalBytes: number
   copiedBytes: number
   currentFile?: string
@@ -373,7 +420,7 @@ export function copyItemStream(
 }
 
 // Search files
-export type MatchType = 'all' | 'name' | 'tag' | 'description'
+export type MatchType = 'all' | 'name' | 'tag' | 'description' | 'content'
 
 export interface SearchResponse {
   query: string
@@ -454,6 +501,47 @@ export async function restoreFromTrash(trashId: string): Promise<{ success: bool
   return api.post<{ success: boolean; restoredPath: string }>(`/trash/restore/${encodeURIComponent(trashId)}`)
 }
 
+export interface TrashRestoreProgress {
+  total: number
+  restored: number
+  skipped: number
+  failed: number
+  skippedItems: { id: string; originalPath?: string; reason: string }[]
+  failedItems: { id: string; originalPath?: string; reason: string }[]
+}
+
+export interface BackgroundJob<P = unknown> {
+  id: number
+  type: string
+  state: 'queued' | 'running' | 'succeeded' | 'failed' | 'cancelled' | 'interrupted'
+  progress: P | null
+  error?: string
+}
+
+// Restore many trash items (or all of them) in a background job
+export async function restoreTrashBatch(request: { ids?: string[]; all?: boolean }): Promise<{ jobId: number; total: number }> {
+  const response = await api.post<{ success: boolean; data: { jobId: number; total: number } }>('/trash/restore-batch', request)
+  return response.data
+}
+
+// Get a background job with its progress
+export async function getJob<P = unknown>(id: number): Promise<BackgroundJob<P>> {
+  const response = await api.get<{ success: boolean; data: BackgroundJob<P> }>(`/jobs/${id}`)
+  return response.data
+}
+
+// Poll a background job until it has finished
+export async function waitForJob<P = unknown>(id: number, onProgress?: (progress: P | null) => void): Promise<BackgroundJob<P>> {
+  for (;;) {
+    const job = await getJob<P>(id)
+    onProgress?.(job.progress)
+    if (job.state !== 'queued' && job.state !== 'running') {
+      return job
+    }
+    await new Promise(resolve => setTimeout(resolve, 1000))
+  }
+}
+
 // Delete from trash permanently
 export async function deleteFromTrash(trashId: string): Promise<void> {
   await api.delete(`/trash/${encodeURIComponent(trashId)}`)
@@ -508,6 +596,31 @@ export function getFileUrl(path: string): string {
   return `${API_BASE}/files/${encodedPath}`
 }
 
+// How /api/preview/stream serves a video
+export interface VideoStreamInfo {
+  mode: 'direct' | 'remux' | 'transcode'
+  container: string
+  videoCodec: string
+  audioCodec?: string
+  duration: number
+  transcodeEnabled: boolean
+  seekable: boolean
+}
+
+// URL of the browser-playable stream of a video; converted streams start at `start` seconds
+export function getVideoStreamUrl(path: string, start = 0): string {
+  const url = getFileUrl(path).replace(`${API_BASE}/files/`, `${API_BASE}/preview/stream/`)
+  return start > 0 ? `${url}?start=${start.toFixed(3)}` : url
+}
+
+// Ask whether a video plays as it is or is converted while streaming
+export async function getVideoStreamInfo(path: string): Promise<VideoStreamInfo> {
+  const cleanPath = path.startsWith('/') ? path.slice(1) : path
+  const encodedPath = cleanPath.split('/').map(segment => encodeURIComponent(segment)).join('/')
+  const response = await api.get<{ success: boolean; data: VideoStreamInfo }>(`/preview/stream/${encodedPath}?info=true`)
+  return response.data
+}
+
 // Re-export getAuthToken from client for backwards compatibility
 export { getAuthToken } from './client'
 
@@ -573,6 +686,8 @@ export interface OnlyOfficeConfig {
       forcesave: boolean
     }
   }
+  // Seconds between force saves requested by the editor (0 = disabled)
+  autosaveInterval?: number
 }
 
 // Get OnlyOffice editor configuration for a file
@@ -580,6 +695,11 @@ export async function getOnlyOfficeConfig(path: string): Promise<OnlyOfficeConfi
   return api.get<OnlyOfficeConfig>(`/onlyoffice/config/${apiUrl.encodePath(path)}`)
 }
 
+// Ask the document server to save the open document now
+export async function forceSaveOnlyOffice(path: string, key: string): Promise<{ key: string; saved: boolean }> {
+  return api.post<{ key: string; saved: boolean }>(`/onlyoffice/forcesave/${apiUrl.encodePath(path)}`, { key })
+}
+
 // Check if file type is supported by OnlyOffice
 export function isOnlyOfficeSupported(extension: string | undefined): boolean {
   if (!extension) return false
@@ -650,8 +770,74 @@ export async function updateFileMetadata(
 }
 
 // Get all user tags for autocomplete
-export async function getUserTags(): Promise<{ tags: string[]; total: number }> {
-  return api.get<{ tags: string[]; total: number }>('/file-metadata/tags')
+export interface TagUsage {
+  tag: string
+  count: number
+}
+
+export async function getUserTags(): Promise<{ tags: TagUsage[]; total: number }> {
+  return api.get<{ tags: TagUsage[]; total: number }>('/file-metadata/tags')
+}
+
+// File comparison: each source is a live path or a trash item
+export interface DiffSource {
+  path?: string
+  trashId?: string
+}
+
+export interface DiffLine {
+  type: 'context' | 'add' | 'delete'
+  text: string
+  oldLine?: number
+  newLine?: number
+}
+
+export interface DiffHunk {
+  oldStart: number
+  oldLines: number
+  newStart: number
+  newLines: number
+  lines: DiffLine[]
+}
+
+export interface DiffSide {
+  label: string
+  size: number
+  modTime: string
+  checksum: string
+  encoding?: string
+  lines?: number
+}
+
+export interface FileDiff {
+  mode: 'text' | 'binary'
+  from: DiffSide
+  to: DiffSide
+  identical: boolean
+  added: number
+  removed: number
+  hunks?: DiffHunk[]
+  unified?: string
+}
+
+export async function diffFiles(from: DiffSource, to: DiffSource, context?: number): Promise<FileDiff> {
+  const response = await api.post<{ data: FileDiff }>('/files/diff', { from, to, context })
+  return response.data
+}
+
+// Folder display metadata, shared by everyone who opens the folder
+export interface FolderDisplay {
+  defaultSort?: 'name' | 'size' | 'date' | 'type'
+  defaultOrder?: 'asc' | 'desc'
+  pinned: string[]
+  updatedAt?: string
+}
+
+export async function updateFolderDisplay(folderPath: string, display: FolderDisplay): Promise<FolderDisplay> {
+  const normalizedPath = folderPath.startsWith('/') ? folderPath.slice(1) : folderPath
+  const encodedPath = encodeURIComponent(normalizedPath).replace(/%2F/g, '/')
+  const response = await api.put<{ data: FolderDisplay }>(`/file-metadata/folder/${encodedPath}`, display)
+  return response.data
 }
 
 // Search files by tag
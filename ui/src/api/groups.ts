@@ -0,0 +1,81 @@
+/**
+ * User Groups API (admin only)
+ */
+import { api } from './client'
+
+export interface UserGroup {
+  id: string
+  name: string
+  description: string
+  everyone: boolean // built-in group of all active users
+  storageQuota: number | null // bytes, null = no group quota
+  memberCount: number
+  folderCount: number
+  createdAt: string
+  updatedAt: string
+}
+
+export interface UserGroupMember {
+  userId: string
+  username: string
+  email: string
+}
+
+export interface UserGroupInput {
+  name: string
+  description?: string
+  storageQuota?: number | null
+}
+
+/**
+ * List user groups, the everyone group first
+ */
+export async function listGroups(): Promise<UserGroup[]> {
+  const response = await api.get<{ data: { groups: UserGroup[] } }>('/admin/groups')
+  return response.data?.groups || []
+}
+
+/**
+ * Create a user group
+ */
+export async function createGroup(input: UserGroupInput): Promise<UserGroup> {
+  const response = await api.post<{ data: UserGroup }>('/admin/groups', input)
+  return response.data
+}
+
+/**
+ * Update a group's name, description and quota
+ */
+export async function updateGroup(groupId: string, input: UserGroupInput): Promise<UserGroup> {
+  const response = await api.put<{ data: UserGroup }>(`/admin/groups/${groupId}`, input)
+  return response.data
+}
+
+/**
+ * Delete a group (not the everyone group)
+ */
+export async function deleteGroup(groupId: string): Promise<void> {
+  await api.delete(`/admin/groups/${groupId}`)
+}
+
+/**
+ * List a group's members
+ */
+export async function listGroupMembers(groupId: string): Promise<UserGroupMember[]> {
+  const response = await api.get<{ data: { members: UserGroupMember[] } }>(`/admin/groups/${groupId}/members`)
+  return response.data?.members || []
+}
+
+/**
+ * Add a user to a group
+ */
+export async function addGroupMember(groupId: string, userId: string): Promise<void> {
+  await api.post(`/admin/groups/${groupId}/members`, { userId })
+}
+
+/**
+ * Remove a user from a group
+ */
+export async function removeGroupMember(groupId: string, userId: string): Promise<void> {
+  await api.delete(`/admin/groups/${groupId}/members/${userId}`)
+}
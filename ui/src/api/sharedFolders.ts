@@ -15,10 +15,38 @@ export interface SharedFolder {
   creatorUsername?: string
   usedStorage?: number
   memberCount?: number
+  smb?: SharedFolderSMB
+}
+
+/** SMB export of a shared drive; valid users are the members with an SMB password */
+export interface SharedFolderSMB {
+  enabled: boolean
+  browseable: boolean
+  guestOk: boolean
+  recycleBin: boolean
+  readOnly: boolean
+  validUsers?: string[]
+}
+
+export interface SMBConfigIssue {
+  file: string
+  line?: number
+  severity: 'error' | 'warning'
+  message: string
+}
+
+/** Quota usage of a shared folder with a quota; uploads past it are refused */
+export interface SharedFolderQuotaStatus {
+  quota: number
+  used: number
+  remaining: number // 0 when over the quota
+  percent: number // may pass 100
+  exceeded: boolean
 }
 
 export interface SharedFolderWithPermission extends SharedFolder {
   permissionLevel: number // 1=read-only, 2=read-write
+  quotaStatus?: SharedFolderQuotaStatus // only for folders with a quota
 }
 
 export interface SharedFolderMember {
@@ -30,6 +58,26 @@ export interface SharedFolderMember {
   createdAt: string
   username?: string
   addedByUsername?: string
+  direct: boolean // false when access comes only from groups
+  groups?: SharedFolderMemberGroup[]
+  effectivePermission: number // highest of direct and group permissions
+}
+
+/** A group through which a member has access */
+export interface SharedFolderMemberGroup {
+  groupId: string
+  name: string
+  permissionLevel: number
+}
+
+/** A group's grant on a shared folder */
+export interface SharedFolderGroup {
+  groupId: string
+  name: string
+  everyone: boolean
+  permissionLevel: number
+  memberCount: number
+  createdAt: string
 }
 
 export const PERMISSION_READ_ONLY = 1
@@ -81,6 +129,7 @@ export async function createSharedFolder(data: {
   name: string
   description?: string
   storageQuota?: number
+  smb?: SharedFolderSMB
 }): Promise<{ id: string }> {
   return api.post<{ id: string }>('/admin/shared-folders', data)
 }
@@ -95,6 +144,7 @@ export async function updateSharedFolder(
     description?: string
     storageQuota?: number
     isActive?: boolean
+    smb?: SharedFolderSMB
   }
 ): Promise<void> {
   await api.put(`/admin/shared-folders/${folderId}`, data)
@@ -107,6 +157,21 @@ export async function deleteSharedFolder(folderId: string): Promise<void> {
   await api.delete(`/admin/shared-folders/${folderId}`)
 }
 
+/**
+ * Dry-run the SMB config with a drive's proposed export (admin only)
+ */
+export async function validateSMBExport(data: {
+  folderId?: string
+  name: string
+  description?: string
+  smb: SharedFolderSMB
+}): Promise<{ valid: boolean; issues: SMBConfigIssue[]; files: Record<string, string> }> {
+  const response = await api.post<{
+    data: { valid: boolean; issues: SMBConfigIssue[]; files: Record<string, string> }
+  }>('/admin/smb/validate', data)
+  return response.data
+}
+
 // ========== Member Management (Admin) ==========
 
 /**
@@ -119,6 +184,18 @@ export async function getSharedFolderMembers(folderId: string): Promise<SharedFo
   return response.data?.members || []
 }
 
+/**
+ * Get members of a shared folder with the groups granted access (admin only)
+ */
+export async function getSharedFolderAccess(
+  folderId: string
+): Promise<{ members: SharedFolderMember[]; groups: SharedFolderGroup[] }> {
+  const response = await api.get<{ data: { members: SharedFolderMember[]; groups: SharedFolderGroup[] } }>(
+    `/admin/shared-folders/${folderId}/members`
+  )
+  return { members: response.data?.members || [], groups: response.data?.groups || [] }
+}
+
 /**
  * Add a member to a shared folder (admin only)
  */
@@ -148,6 +225,24 @@ export async function removeSharedFolderMember(folderId: string, userId: string)
   await api.delete(`/admin/shared-folders/${folderId}/members/${userId}`)
 }
 
+/**
+ * Grant a group access to a shared folder or change its level (admin only)
+ */
+export async function setSharedFolderGroup(
+  folderId: string,
+  groupId: string,
+  permissionLevel: number
+): Promise<void> {
+  await api.put(`/admin/shared-folders/${folderId}/groups/${groupId}`, { permissionLevel })
+}
+
+/**
+ * Revoke a group's access to a shared folder (admin only)
+ */
+export async function removeSharedFolderGroup(folderId: string, groupId: string): Promise<void> {
+  await api.delete(`/admin/shared-folders/${folderId}/groups/${groupId}`)
+}
+
 // ========== Helper Functions ==========
 
 /**
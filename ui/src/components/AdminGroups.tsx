@@ -0,0 +1,384 @@
+import { useState, useEffect, useCallback } from 'react'
+import {
+  listGroups,
+  createGroup,
+  updateGroup,
+  deleteGroup,
+  listGroupMembers,
+  addGroupMember,
+  removeGroupMember,
+  UserGroup,
+  UserGroupMember,
+} from '../api/groups'
+import { formatStorageSize } from '../api/sharedFolders'
+import { api } from '../api/client'
+import './AdminSharedFolders.css'
+import './AdminGroups.css'
+
+interface User {
+  id: string
+  username: string
+  email: string
+}
+
+const GB = 1024 * 1024 * 1024
+
+async function getUsers(): Promise<User[]> {
+  const data = await api.get<{ users: User[] }>('/admin/users')
+  return data.users
+}
+
+// Admin page for user groups. Groups are granted shared drive access from the
+// shared drive members dialog; the everyone group always holds all active users.
+function AdminGroups() {
+  const [groups, setGroups] = useState<UserGroup[]>([])
+  const [loading, setLoading] = useState(true)
+  const [error, setError] = useState<string | null>(null)
+
+  // Create/Edit modal
+  const [showModal, setShowModal] = useState(false)
+  const [editingGroup, setEditingGroup] = useState<UserGroup | null>(null)
+  const [formData, setFormData] = useState({ name: '', description: '', quotaGB: '' })
+  const [formError, setFormError] = useState<string | null>(null)
+  const [saving, setSaving] = useState(false)
+
+  // Members modal
+  const [membersGroup, setMembersGroup] = useState<UserGroup | null>(null)
+  const [members, setMembers] = useState<UserGroupMember[]>([])
+  const [users, setUsers] = useState<User[]>([])
+  const [loadingMembers, setLoadingMembers] = useState(false)
+  const [newMemberId, setNewMemberId] = useState('')
+
+  const loadGroups = useCallback(async () => {
+    try {
+      setGroups(await listGroups())
+      setError(null)
+    } catch (err) {
+      setError(err instanceof Error ? err.message : '그룹을 불러오는데 실패했습니다')
+    } finally {
+      setLoading(false)
+    }
+  }, [])
+
+  useEffect(() => {
+    loadGroups()
+  }, [loadGroups])
+
+  const handleCreate = () => {
+    setEditingGroup(null)
+    setFormData({ name: '', description: '', quotaGB: '' })
+    setFormError(null)
+    setShowModal(true)
+  }
+
+  const handleEdit = (group: UserGroup) => {
+    setEditingGroup(group)
+    setFormData({
+      name: group.name,
+      description: group.description,
+      quotaGB: group.storageQuota ? String(group.storageQuota / GB) : '',
+    })
+    setFormError(null)
+    setShowModal(true)
+  }
+
+  const handleSave = async () => {
+    if (!formData.name.trim()) {
+      setFormError('그룹 이름을 입력하세요')
+      return
+    }
+    const quota = formData.quotaGB ? Math.round(parseFloat(formData.quotaGB) * GB) : null
+    if (quota !== null && (isNaN(quota) || quota < 0)) {
+      setFormError('할당량이 올바르지 않습니다')
+      return
+    }
+    setSaving(true)
+    setFormError(null)
+    try {
+      const input = { name: formData.name.trim(), description: formData.description, storageQuota: quota }
+      if (editingGroup) {
+        await updateGroup(editingGroup.id, input)
+      } else {
+        await createGroup(input)
+      }
+      setShowModal(false)
+      loadGroups()
+    } catch (err) {
+      setFormError(err instanceof Error ? err.message : '저장에 실패했습니다')
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  const handleDelete = async (group: UserGroup) => {
+    if (!confirm(`'${group.name}' 그룹을 삭제하시겠습니까? 이 그룹으로만 받은 공유 드라이브 권한은 사라집니다.`)) return
+    try {
+      await deleteGroup(group.id)
+      loadGroups()
+    } catch (err) {
+      alert(err instanceof Error ? err.message : '그룹 삭제에 실패했습니다')
+    }
+  }
+
+  const handleManageMembers = async (group: UserGroup) => {
+    setMembersGroup(group)
+    setLoadingMembers(true)
+    setNewMemberId('')
+    try {
+      const [membersData, usersData] = await Promise.all([
+        listGroupMembers(group.id),
+        group.everyone ? Promise.resolve([] as User[]) : getUsers(),
+      ])
+      setMembers(membersData)
+      setUsers(usersData)
+    } catch (err) {
+      alert('멤버를 불러오는데 실패했습니다')
+    } finally {
+      setLoadingMembers(false)
+    }
+  }
+
+  const handleAddMember = async () => {
+    if (!membersGroup || !newMemberId) return
+    try {
+      await addGroupMember(membersGroup.id, newMemberId)
+      setMembers(await listGroupMembers(membersGroup.id))
+      setNewMemberId('')
+      loadGroups()
+    } catch (err) {
+      alert(err instanceof Error ? err.message : '멤버 추가에 실패했습니다')
+    }
+  }
+
+  const handleRemoveMember = async (userId: string) => {
+    if (!membersGroup) return
+    try {
+      await removeGroupMember(membersGroup.id, userId)
+      setMembers(await listGroupMembers(membersGroup.id))
+      loadGroups()
+    } catch (err) {
+      alert(err instanceof Error ? err.message : '멤버 제거에 실패했습니다')
+    }
+  }
+
+  const availableUsers = users.filter(u => !members.some(m => m.userId === u.id))
+
+  if (loading) {
+    return (
+      <div className="admin-shared-folders-page">
+        <div className="loading-container">
+          <div className="spinner"></div>
+          <p>그룹을 불러오는 중...</p>
+        </div>
+      </div>
+    )
+  }
+
+  return (
+    <div className="admin-shared-folders-page">
+      <div className="page-header">
+        <div className="header-content">
+          <div className="header-icon">
+            <svg width="28" height="28" viewBox="0 0 24 24" fill="none">
+              <path d="M17 21V19C17 16.79 15.21 15 13 15H5C2.79 15 1 16.79 1 19V21" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+              <circle cx="9" cy="7" r="4" stroke="currentColor" strokeWidth="2"/>
+              <path d="M23 21V19C23 17.18 21.77 15.6 20 15.13M16 3.13C17.77 3.58 19 5.17 19 7C19 8.83 17.77 10.42 16 10.87" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+            </svg>
+          </div>
+          <div>
+            <h1>사용자 그룹</h1>
+            <p>그룹 단위로 공유 드라이브 권한과 저장 공간 할당량을 관리합니다.</p>
+          </div>
+        </div>
+        <button className="create-btn" onClick={handleCreate}>
+          <svg width="18" height="18" viewBox="0 0 24 24" fill="none">
+            <path d="M12 5V19M5 12H19" stroke="currentColor" strokeWidth="2" strokeLinecap="round"/>
+          </svg>
+          새 그룹
+        </button>
+      </div>
+
+      {error && <div className="error-banner">{error}</div>}
+
+      <div className="group-list">
+        {groups.map(group => (
+          <div key={group.id} className="group-row">
+            <div className="group-main">
+              <span className="group-name">
+                {group.name}
+                {group.everyone && <span className="group-badge">기본</span>}
+              </span>
+              <span className="group-description">
+                {group.description || (group.everyone ? '모든 활성 사용자 (게스트 제외)' : '')}
+              </span>
+            </div>
+            <div className="group-stats">
+              <span>{group.memberCount}명</span>
+              <span>드라이브 {group.folderCount}개</span>
+              <span>{group.storageQuota ? `할당량 ${formatStorageSize(group.storageQuota)}` : '할당량 없음'}</span>
+            </div>
+            <div className="group-actions">
+              <button className="btn-secondary" onClick={() => handleManageMembers(group)}>멤버</button>
+              <button className="btn-secondary" onClick={() => handleEdit(group)}>수정</button>
+              {!group.everyone && (
+                <button className="btn-secondary danger" onClick={() => handleDelete(group)}>삭제</button>
+              )}
+            </div>
+          </div>
+        ))}
+      </div>
+
+      {/* Create/Edit Modal */}
+      {showModal && (
+        <div className="modal-overlay" onClick={() => setShowModal(false)}>
+          <div className="sf-modal" onClick={e => e.stopPropagation()}>
+            <div className="modal-header">
+              <div className="modal-title-row">
+                <div>
+                  <h2>{editingGroup ? '그룹 수정' : '새 그룹'}</h2>
+                </div>
+              </div>
+              <button className="close-btn" onClick={() => setShowModal(false)}>
+                <svg width="20" height="20" viewBox="0 0 24 24" fill="none">
+                  <path d="M18 6L6 18M6 6L18 18" stroke="currentColor" strokeWidth="2" strokeLinecap="round"/>
+                </svg>
+              </button>
+            </div>
+            <div className="modal-body">
+              {formError && <div className="error-banner">{formError}</div>}
+              <div className="form-group">
+                <label htmlFor="groupName">이름</label>
+                <input
+                  id="groupName"
+                  type="text"
+                  value={formData.name}
+                  onChange={e => setFormData({ ...formData, name: e.target.value })}
+                  maxLength={100}
+                  autoFocus
+                />
+              </div>
+              <div className="form-group">
+                <label htmlFor="groupDescription">설명</label>
+                <input
+                  id="groupDescription"
+                  type="text"
+                  value={formData.description}
+                  onChange={e => setFormData({ ...formData, description: e.target.value })}
+                />
+              </div>
+              <div className="form-group">
+                <label htmlFor="groupQuota">저장 공간 할당량 (GB)</label>
+                <input
+                  id="groupQuota"
+                  type="number"
+                  min="0"
+                  step="any"
+                  value={formData.quotaGB}
+                  onChange={e => setFormData({ ...formData, quotaGB: e.target.value })}
+                  placeholder="비워두면 없음"
+                />
+                <p className="form-hint">자기 할당량이 없는 멤버에게 적용됩니다. 여러 그룹에 속하면 가장 큰 값이 적용됩니다.</p>
+              </div>
+            </div>
+            <div className="modal-footer">
+              <button className="btn-secondary" onClick={() => setShowModal(false)}>취소</button>
+              <button className="btn-primary" onClick={handleSave} disabled={saving}>
+                {saving ? '저장 중...' : '저장'}
+              </button>
+            </div>
+          </div>
+        </div>
+      )}
+
+      {/* Members Modal */}
+      {membersGroup && (
+        <div className="modal-overlay" onClick={() => setMembersGroup(null)}>
+          <div className="sf-modal members-modal" onClick={e => e.stopPropagation()}>
+            <div className="modal-header">
+              <div className="modal-title-row">
+                <div>
+                  <h2>그룹 멤버</h2>
+                  <p className="modal-subtitle">{membersGroup.name}</p>
+                </div>
+              </div>
+              <button className="close-btn" onClick={() => setMembersGroup(null)}>
+                <svg width="20" height="20" viewBox="0 0 24 24" fill="none">
+                  <path d="M18 6L6 18M6 6L18 18" stroke="currentColor" strokeWidth="2" strokeLinecap="round"/>
+                </svg>
+              </button>
+            </div>
+            <div className="modal-body">
+              {loadingMembers ? (
+                <div className="loading-container small">
+                  <div className="spinner"></div>
+                  <p>멤버를 불러오는 중...</p>
+                </div>
+              ) : (
+                <>
+                  {membersGroup.everyone ? (
+                    <p className="form-hint">이 그룹에는 게스트를 제외한 모든 활성 사용자가 자동으로 포함됩니다.</p>
+                  ) : (
+                    <div className="add-member-section">
+                      <h3>멤버 추가</h3>
+                      <div className="add-member-form">
+                        <select value={newMemberId} onChange={e => setNewMemberId(e.target.value)}>
+                          <option value="">사용자 선택...</option>
+                          {availableUsers.map(user => (
+                            <option key={user.id} value={user.id}>
+                              {user.username}{user.email ? ` (${user.email})` : ''}
+                            </option>
+                          ))}
+                        </select>
+                        <button className="btn-primary" onClick={handleAddMember} disabled={!newMemberId}>
+                          추가
+                        </button>
+                      </div>
+                    </div>
+                  )}
+
+                  <div className="members-section">
+                    <h3>멤버 ({members.length})</h3>
+                    {members.length === 0 ? (
+                      <div className="no-members">
+                        <p>아직 멤버가 없습니다</p>
+                      </div>
+                    ) : (
+                      <div className="members-list">
+                        {members.map(member => (
+                          <div key={member.userId} className="member-item">
+                            <div className="member-info">
+                              <div className="member-avatar">
+                                {member.username.slice(0, 2).toUpperCase()}
+                              </div>
+                              <span className="member-name">{member.username}</span>
+                              {member.email && <span className="member-source">{member.email}</span>}
+                            </div>
+                            {!membersGroup.everyone && (
+                              <div className="member-actions">
+                                <button
+                                  className="remove-member-btn"
+                                  onClick={() => handleRemoveMember(member.userId)}
+                                  title="멤버 제거"
+                                >
+                                  <svg width="18" height="18" viewBox="0 0 24 24" fill="none">
+                                    <path d="M18 6L6 18M6 6L18 18" stroke="currentColor" strokeWidth="2" strokeLinecap="round"/>
+                                  </svg>
+                                </button>
+                              </div>
+                            )}
+                          </div>
+                        ))}
+                      </div>
+                    )}
+                  </div>
+                </>
+              )}
+            </div>
+          </div>
+        </div>
+      )}
+    </div>
+  )
+}
+
+export default AdminGroups
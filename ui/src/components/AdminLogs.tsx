@@ -6,6 +6,8 @@ type LogTab = 'file' | 'user' | 'admin' | 'system'
 type LogLevel = 'all' | 'info' | 'warn' | 'error' | 'fatal'
 type DatePreset = 'all' | 'today' | 'yesterday' | 'week' | 'month' | 'custom'
 
+const SHARE_EVENT_TYPES = ['share.create', 'share.access', 'share.download', 'share.delete', 'share.expire']
+
 interface AuditLogEntry {
   id: number
   timestamp: string
@@ -76,6 +78,7 @@ function AdminLogs() {
   const [auditLogs, setAuditLogs] = useState<AuditLogEntry[]>([])
   const [systemLogs, setSystemLogs] = useState<SystemLogEntry[]>([])
   const [loading, setLoading] = useState(false)
+  const [exporting, setExporting] = useState(false)
   const [searchQuery, setSearchQuery] = useState('')
   const [logLevel, setLogLevel] = useState<LogLevel>('all')
   const [container, setContainer] = useState<string>('all')
@@ -227,6 +230,40 @@ function AdminLogs() {
     }
   }
 
+  // Download the audit log of the selected date range (all of it without one)
+  const handleExport = async (format: 'csv' | 'json') => {
+    setExporting(true)
+    try {
+      let url = `/api/audit/export?format=${format}`
+      if (startDate) {
+        url += `&from=${startDate}`
+      }
+      if (endDate) {
+        url += `&to=${endDate}`
+      }
+      const response = await fetch(url, {
+        headers: {
+          'Authorization': `Bearer ${token}`,
+        },
+      })
+      if (!response.ok) {
+        throw new Error(`Export failed: ${response.status}`)
+      }
+      const blob = await response.blob()
+      const disposition = response.headers.get('Content-Disposition') || ''
+      const match = disposition.match(/filename="([^"]+)"/)
+      const link = document.createElement('a')
+      link.href = URL.createObjectURL(blob)
+      link.download = match ? match[1] : `filehatch-audit.${format}`
+      link.click()
+      URL.revokeObjectURL(link.href)
+    } catch (err) {
+      console.error('Failed to export audit logs:', err)
+    } finally {
+      setExporting(false)
+    }
+  }
+
   // Filter logs by search query
   const filteredAuditLogs = auditLogs.filter(log =>
     (log.actorUsername?.toLowerCase() || '').includes(searchQuery.toLowerCase()) ||
@@ -241,7 +278,12 @@ function AdminLogs() {
   )
 
   // Get unique event types for filter
-  const eventTypes = Array.from(new Set(auditLogs.map(log => log.eventType))).sort()
+  // Share link events stay selectable on the user tab even when the current
+  // page holds none of them
+  const eventTypes = Array.from(new Set([
+    ...auditLogs.map(log => log.eventType),
+    ...(activeTab === 'user' ? SHARE_EVENT_TYPES : []),
+  ])).sort()
 
   const getEventTypeLabel = (eventType: string) => {
     const labels: Record<string, string> = {
@@ -269,6 +311,10 @@ function AdminLogs() {
       'share.create': '공유 생성',
       'share.access': '공유 접근',
       'share.delete': '공유 삭제',
+      'share.update': '공유 수정',
+      'share.reset_counters': '공유 카운터 초기화',
+      'share.download': '공유 다운로드',
+      'share.expire': '공유 만료',
       'admin.user.create': '사용자 생성',
       'admin.user.update': '사용자 수정',
       'admin.user.delete': '사용자 삭제',
@@ -387,14 +433,26 @@ function AdminLogs() {
             <p>파일 변경, 사용자 활동, 관리자 로그를 확인합니다.</p>
           </div>
         </div>
-        <button className="refresh-btn" onClick={handleRefresh} disabled={loading}>
-          <svg width="18" height="18" viewBox="0 0 24 24" fill="none" className={loading ? 'spinning' : ''}>
-            <path d="M1 4V10H7" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
-            <path d="M23 20V14H17" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
-            <path d="M20.49 9A9 9 0 0 0 5.64 5.64L1 10M23 14L18.36 18.36A9 9 0 0 1 3.51 15" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
-          </svg>
-          새로고침
-        </button>
+        <div className="logs-header-actions">
+          {activeTab !== 'system' && (
+            <button className="refresh-btn" onClick={() => handleExport('csv')} disabled={exporting} title="선택한 기간의 감사 로그를 CSV로 내보내기">
+              <svg width="18" height="18" viewBox="0 0 24 24" fill="none">
+                <path d="M21 15V19C21 20.1 20.1 21 19 21H5C3.9 21 3 20.1 3 19V15" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+                <path d="M7 10L12 15L17 10" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+                <path d="M12 15V3" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+              </svg>
+              {exporting ? '내보내는 중...' : 'CSV 내보내기'}
+            </button>
+          )}
+          <button className="refresh-btn" onClick={handleRefresh} disabled={loading}>
+            <svg width="18" height="18" viewBox="0 0 24 24" fill="none" className={loading ? 'spinning' : ''}>
+              <path d="M1 4V10H7" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+              <path d="M23 20V14H17" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+              <path d="M20.49 9A9 9 0 0 0 5.64 5.64L1 10M23 14L18.36 18.36A9 9 0 0 1 3.51 15" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+            </svg>
+            새로고침
+          </button>
+        </div>
       </div>
 
       {/* Stats Cards - Only for audit logs */}
@@ -7,6 +7,7 @@ import {
   createSSOProvider,
   updateSSOProvider,
   deleteSSOProvider,
+  testSSOProvider,
   getSSOSettings,
   updateSSOSettings,
   SSOSettings
@@ -22,7 +23,7 @@ const PROVIDER_TYPES = [
 
 function AdminSSOSettings() {
   const { user: currentUser, token } = useAuthStore()
-  const { showSuccess, showError } = useToastStore()
+  const { showSuccess, showError, showWarning } = useToastStore()
   const [loading, setLoading] = useState(true)
 
   // SSO State
@@ -194,6 +195,23 @@ function AdminSSOSettings() {
     }
   }
 
+  const handleTestProvider = async (provider: SSOProvider) => {
+    try {
+      const result = await testSSOProvider(provider.id)
+      if (result.discoveryError) {
+        showError(`Discovery 실패: ${result.discoveryError}`)
+      } else if (result.warnings.length > 0) {
+        showWarning(result.warnings.join(' / '))
+      } else if (result.discovered) {
+        showSuccess(`Discovery 성공: ${result.endpoints.authorizationUrl}`)
+      } else {
+        showSuccess(`설정 확인 완료: ${result.endpoints.authorizationUrl}`)
+      }
+    } catch (error) {
+      showError('SSO 프로바이더 테스트에 실패했습니다.')
+    }
+  }
+
   if (!currentUser?.isAdmin) {
     return (
       <div className="as-container">
@@ -376,6 +394,12 @@ function AdminSSOSettings() {
                       <span className={`as-provider-status ${provider.isEnabled ? 'active' : 'inactive'}`}>
                         {provider.isEnabled ? '활성' : '비활성'}
                       </span>
+                      <button className="as-btn-icon" onClick={() => handleTestProvider(provider)} title="연결 테스트">
+                        <svg width="16" height="16" viewBox="0 0 24 24" fill="none">
+                          <path d="M22 11.08V12a10 10 0 11-5.93-9.14" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+                          <path d="M22 4L12 14.01l-3-3" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+                        </svg>
+                      </button>
                       <button className="as-btn-icon" onClick={() => openEditProviderModal(provider)} title="수정">
                         <svg width="16" height="16" viewBox="0 0 24 24" fill="none">
                           <path d="M11 4H4a2 2 0 00-2 2v14a2 2 0 002 2h14a2 2 0 002-2v-7" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
@@ -517,7 +541,7 @@ function AdminSSOSettings() {
                       onChange={(e) => setProviderForm({ ...providerForm, issuerUrl: e.target.value })}
                       placeholder="https://keycloak.example.com/realms/master"
                     />
-                    <span className="as-form-hint">Keycloak: https://host/realms/REALM_NAME</span>
+                    <span className="as-form-hint">Keycloak: https://host/realms/REALM_NAME · 엔드포인트는 .well-known/openid-configuration에서 자동으로 찾습니다</span>
                   </div>
                   <div className="as-form-row">
                     <div className="as-form-group">
@@ -526,7 +550,7 @@ function AdminSSOSettings() {
                         type="text"
                         value={providerForm.authorizationUrl}
                         onChange={(e) => setProviderForm({ ...providerForm, authorizationUrl: e.target.value })}
-                        placeholder="자동 생성됨"
+                        placeholder="Discovery로 자동 설정"
                       />
                     </div>
                     <div className="as-form-group">
@@ -535,7 +559,7 @@ function AdminSSOSettings() {
                         type="text"
                         value={providerForm.tokenUrl}
                         onChange={(e) => setProviderForm({ ...providerForm, tokenUrl: e.target.value })}
-                        placeholder="자동 생성됨"
+                        placeholder="Discovery로 자동 설정"
                       />
                     </div>
                   </div>
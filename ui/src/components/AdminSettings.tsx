@@ -7,8 +7,12 @@ const API_BASE = '/api'
 
 interface SystemSettings {
   trash_retention_days: string
+  trash_max_size_bytes: string
   default_storage_quota: string
   max_file_size: string
+  thumbnail_cache_max_bytes: string
+  video_transcode_enabled: string
+  content_index_enabled: string
   session_timeout_hours: string
   // Security Settings
   rate_limit_enabled: string
@@ -20,9 +24,24 @@ interface SystemSettings {
   x_frame_options: string
   // SMB Settings
   smb_enabled: string
+  // Registration Settings
+  registration_enabled: string
+  registration_require_approval: string
+  registration_email_domains: string
   [key: string]: string
 }
 
+interface SMTPSettings {
+  host: string
+  port: string
+  tlsMode: string
+  from: string
+  username: string
+  hasPassword: boolean
+  source: string
+  configured: boolean
+}
+
 function AdminSettings() {
   const { user: currentUser, token } = useAuthStore()
   const { showSuccess, showError } = useToastStore()
@@ -31,8 +50,12 @@ function AdminSettings() {
 
   const [settings, setSettings] = useState<SystemSettings>({
     trash_retention_days: '30',
+    trash_max_size_bytes: '0',
     default_storage_quota: '10737418240',
     max_file_size: '10737418240',
+    thumbnail_cache_max_bytes: '2147483648',
+    video_transcode_enabled: 'true',
+    content_index_enabled: 'false',
     session_timeout_hours: '24',
     // Security Settings
     rate_limit_enabled: 'true',
@@ -43,8 +66,22 @@ function AdminSettings() {
     csp_enabled: 'true',
     x_frame_options: 'SAMEORIGIN',
     // SMB Settings
-    smb_enabled: 'true'
+    smb_enabled: 'true',
+    // Registration Settings
+    registration_enabled: 'false',
+    registration_require_approval: 'true',
+    registration_email_domains: ''
+  })
+
+  // Outgoing mail, saved separately through /admin/smtp
+  const [smtp, setSmtp] = useState<SMTPSettings>({
+    host: '', port: '', tlsMode: 'starttls', from: '', username: '',
+    hasPassword: false, source: '', configured: false
   })
+  const [smtpPassword, setSmtpPassword] = useState('')
+  const [smtpSaving, setSmtpSaving] = useState(false)
+  const [smtpTesting, setSmtpTesting] = useState(false)
+  const [testRecipient, setTestRecipient] = useState('')
 
   // Convert bytes to GB for display
   const bytesToGB = (bytes: string) => {
@@ -70,8 +107,12 @@ function AdminSettings() {
         const data = await response.json()
         const loadedSettings: SystemSettings = {
           trash_retention_days: '30',
+          trash_max_size_bytes: '0',
           default_storage_quota: '10737418240',
           max_file_size: '10737418240',
+          thumbnail_cache_max_bytes: '2147483648',
+          video_transcode_enabled: 'true',
+          content_index_enabled: 'false',
           session_timeout_hours: '24',
           // Security Settings
           rate_limit_enabled: 'true',
@@ -82,7 +123,11 @@ function AdminSettings() {
           csp_enabled: 'true',
           x_frame_options: 'SAMEORIGIN',
           // SMB Settings
-          smb_enabled: 'true'
+          smb_enabled: 'true',
+          // Registration Settings
+          registration_enabled: 'false',
+          registration_require_approval: 'true',
+          registration_email_domains: ''
         }
         data.settings?.forEach((s: { key: string; value: string }) => {
           if (s.key in loadedSettings) {
@@ -90,6 +135,14 @@ function AdminSettings() {
           }
         })
         setSettings(loadedSettings)
+
+        const smtpResponse = await fetch(`${API_BASE}/admin/smtp`, {
+          headers: { Authorization: `Bearer ${token}` }
+        })
+        if (smtpResponse.ok) {
+          const smtpData = await smtpResponse.json()
+          setSmtp(smtpData.data)
+        }
       } catch (error) {
         console.error('Failed to load settings:', error)
         showError('설정을 불러오는데 실패했습니다.')
@@ -128,6 +181,65 @@ function AdminSettings() {
     }
   }
 
+  const handleSaveSmtp = async () => {
+    setSmtpSaving(true)
+    try {
+      const response = await fetch(`${API_BASE}/admin/smtp`, {
+        method: 'PUT',
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${token}`
+        },
+        body: JSON.stringify({
+          host: smtp.host,
+          port: smtp.port,
+          tlsMode: smtp.tlsMode,
+          from: smtp.from,
+          username: smtp.username,
+          // An empty field keeps the stored password
+          ...(smtpPassword ? { password: smtpPassword } : {})
+        })
+      })
+      const data = await response.json()
+      if (!response.ok) {
+        throw new Error(data.error || 'Failed to save SMTP settings')
+      }
+      setSmtp(data.data)
+      setSmtpPassword('')
+      showSuccess('메일 설정이 저장되었습니다.')
+    } catch (error) {
+      console.error('Failed to save SMTP settings:', error)
+      showError(error instanceof Error ? error.message : '메일 설정 저장에 실패했습니다.')
+    } finally {
+      setSmtpSaving(false)
+    }
+  }
+
+  const handleTestSmtp = async () => {
+    setSmtpTesting(true)
+    try {
+      const response = await fetch(`${API_BASE}/admin/smtp/test`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${token}`
+        },
+        body: JSON.stringify({ to: testRecipient })
+      })
+      const data = await response.json()
+      if (!response.ok) {
+        const detail = data.details?.error
+        throw new Error(detail ? `${data.error}: ${detail}` : data.error || 'Failed to send test email')
+      }
+      showSuccess(`테스트 메일을 ${data.data.to}(으)로 보냈습니다.`)
+    } catch (error) {
+      console.error('Failed to send test email:', error)
+      showError(error instanceof Error ? error.message : '테스트 메일 발송에 실패했습니다.')
+    } finally {
+      setSmtpTesting(false)
+    }
+  }
+
   if (!currentUser?.isAdmin) {
     return (
       <div className="as-container">
@@ -206,6 +318,22 @@ function AdminSettings() {
                 <span className="as-input-unit">일</span>
               </div>
             </div>
+            <div className="as-divider"></div>
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>사용자별 최대 크기</label>
+                <span className="as-setting-desc">휴지통이 이 크기를 넘으면 오래된 항목부터 자동으로 삭제됩니다. 0이면 제한하지 않습니다.</span>
+              </div>
+              <div className="as-setting-input-group">
+                <input
+                  type="number"
+                  value={bytesToGB(settings.trash_max_size_bytes)}
+                  onChange={(e) => setSettings({ ...settings, trash_max_size_bytes: gbToBytes(parseInt(e.target.value, 10) || 0) })}
+                  min="0"
+                />
+                <span className="as-input-unit">GB</span>
+              </div>
+            </div>
           </div>
         </div>
 
@@ -257,6 +385,52 @@ function AdminSettings() {
                 <span className="as-input-unit">GB</span>
               </div>
             </div>
+            <div className="as-divider"></div>
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>썸네일 캐시 크기</label>
+                <span className="as-setting-desc">저장된 썸네일이 이 크기를 넘으면 가장 오래 사용하지 않은 것부터 삭제됩니다. 0이면 제한하지 않습니다.</span>
+              </div>
+              <div className="as-setting-input-group">
+                <input
+                  type="number"
+                  value={bytesToGB(settings.thumbnail_cache_max_bytes)}
+                  onChange={(e) => setSettings({ ...settings, thumbnail_cache_max_bytes: gbToBytes(parseInt(e.target.value, 10) || 0) })}
+                  min="0"
+                />
+                <span className="as-input-unit">GB</span>
+              </div>
+            </div>
+            <div className="as-divider"></div>
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>동영상 변환 재생</label>
+                <span className="as-setting-desc">MKV, HEVC 등 브라우저가 재생할 수 없는 동영상을 ffmpeg로 변환해 미리보기합니다. 저사양 NAS에서는 끄는 것이 좋습니다.</span>
+              </div>
+              <label className="as-toggle">
+                <input
+                  type="checkbox"
+                  checked={settings.video_transcode_enabled === 'true'}
+                  onChange={(e) => setSettings({ ...settings, video_transcode_enabled: e.target.checked ? 'true' : 'false' })}
+                />
+                <span className="as-toggle-slider"></span>
+              </label>
+            </div>
+            <div className="as-divider"></div>
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>문서 내용 검색</label>
+                <span className="as-setting-desc">txt, md, pdf, docx, xlsx 파일의 본문을 인덱싱해 내용으로 검색합니다. 켠 뒤 기존 파일은 재인덱싱 작업(매일 자동 실행)으로 추가됩니다.</span>
+              </div>
+              <label className="as-toggle">
+                <input
+                  type="checkbox"
+                  checked={settings.content_index_enabled === 'true'}
+                  onChange={(e) => setSettings({ ...settings, content_index_enabled: e.target.checked ? 'true' : 'false' })}
+                />
+                <span className="as-toggle-slider"></span>
+              </label>
+            </div>
           </div>
         </div>
 
@@ -499,6 +673,207 @@ function AdminSettings() {
           </div>
         </div>
 
+        {/* Registration Settings */}
+        <div className="as-section">
+          <div className="as-section-header">
+            <div className="as-section-icon security">
+              <svg width="22" height="22" viewBox="0 0 24 24" fill="none">
+                <path d="M16 21V19C16 16.7909 14.2091 15 12 15H5C2.79086 15 1 16.7909 1 19V21" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+                <circle cx="8.5" cy="7" r="4" stroke="currentColor" strokeWidth="2"/>
+                <path d="M20 8V14M23 11H17" stroke="currentColor" strokeWidth="2" strokeLinecap="round"/>
+              </svg>
+            </div>
+            <div className="as-section-title">
+              <h3>회원가입 설정</h3>
+              <p>사용자가 로그인 화면에서 직접 계정을 만들 수 있게 합니다.</p>
+            </div>
+          </div>
+          <div className="as-section-content">
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>회원가입 허용</label>
+                <span className="as-setting-desc">로그인 화면에 계정 만들기 링크를 표시합니다.</span>
+              </div>
+              <label className="as-toggle">
+                <input
+                  type="checkbox"
+                  checked={settings.registration_enabled === 'true'}
+                  onChange={(e) => setSettings({ ...settings, registration_enabled: e.target.checked ? 'true' : 'false' })}
+                />
+                <span className="as-toggle-slider"></span>
+              </label>
+            </div>
+            {settings.registration_enabled === 'true' && (
+              <>
+                <div className="as-divider"></div>
+                <div className="as-setting-row">
+                  <div className="as-setting-info">
+                    <label>관리자 승인 필요</label>
+                    <span className="as-setting-desc">새 계정은 사용자 관리의 승인 대기 목록에서 승인해야 로그인할 수 있습니다.</span>
+                  </div>
+                  <label className="as-toggle">
+                    <input
+                      type="checkbox"
+                      checked={settings.registration_require_approval === 'true'}
+                      onChange={(e) => setSettings({ ...settings, registration_require_approval: e.target.checked ? 'true' : 'false' })}
+                    />
+                    <span className="as-toggle-slider"></span>
+                  </label>
+                </div>
+                <div className="as-divider"></div>
+                <div className="as-setting-row">
+                  <div className="as-setting-info">
+                    <label>허용 이메일 도메인</label>
+                    <span className="as-setting-desc">쉼표로 구분합니다. 지정하면 해당 도메인의 이메일이 있어야 가입할 수 있습니다.</span>
+                  </div>
+                  <div className="as-setting-input-group">
+                    <input
+                      type="text"
+                      value={settings.registration_email_domains}
+                      onChange={(e) => setSettings({ ...settings, registration_email_domains: e.target.value })}
+                      placeholder="example.com, corp.example.com"
+                    />
+                  </div>
+                </div>
+              </>
+            )}
+          </div>
+        </div>
+
+        {/* Mail Settings */}
+        <div className="as-section">
+          <div className="as-section-header">
+            <div className="as-section-icon mail">
+              <svg width="22" height="22" viewBox="0 0 24 24" fill="none">
+                <rect x="2" y="4" width="20" height="16" rx="2" stroke="currentColor" strokeWidth="2"/>
+                <path d="M22 6L12 13L2 6" stroke="currentColor" strokeWidth="2" strokeLinecap="round" strokeLinejoin="round"/>
+              </svg>
+            </div>
+            <div className="as-section-title">
+              <h3>메일 (SMTP) 설정</h3>
+              <p>
+                초대, 공유 링크, 비밀번호 재설정 메일을 보낼 서버입니다.
+                {smtp.source === 'environment' && ' 현재 SMTP_* 환경 변수가 사용되고 있습니다.'}
+              </p>
+            </div>
+          </div>
+          <div className="as-section-content">
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>SMTP 서버</label>
+                <span className="as-setting-desc">비워두면 SMTP_* 환경 변수를 사용합니다.</span>
+              </div>
+              <div className="as-setting-input-group">
+                <input
+                  type="text"
+                  value={smtp.host}
+                  onChange={(e) => setSmtp({ ...smtp, host: e.target.value })}
+                  placeholder="smtp.example.com"
+                />
+              </div>
+            </div>
+            <div className="as-divider"></div>
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>포트 / 보안</label>
+                <span className="as-setting-desc">STARTTLS는 보통 587, TLS는 465 포트를 사용합니다.</span>
+              </div>
+              <div className="as-setting-input-group">
+                <input
+                  type="number"
+                  value={smtp.port}
+                  onChange={(e) => setSmtp({ ...smtp, port: e.target.value })}
+                  placeholder={smtp.tlsMode === 'tls' ? '465' : '587'}
+                  min="1"
+                  max="65535"
+                />
+                <select
+                  className="as-select"
+                  value={smtp.tlsMode}
+                  onChange={(e) => setSmtp({ ...smtp, tlsMode: e.target.value })}
+                >
+                  <option value="starttls">STARTTLS</option>
+                  <option value="tls">TLS</option>
+                  <option value="none">없음</option>
+                </select>
+              </div>
+            </div>
+            <div className="as-divider"></div>
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>보내는 주소</label>
+                <span className="as-setting-desc">비워두면 사용자명이 이메일 주소일 때 그 주소를 사용합니다.</span>
+              </div>
+              <div className="as-setting-input-group">
+                <input
+                  type="email"
+                  value={smtp.from}
+                  onChange={(e) => setSmtp({ ...smtp, from: e.target.value })}
+                  placeholder="filehatch@example.com"
+                />
+              </div>
+            </div>
+            <div className="as-divider"></div>
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>사용자명 / 비밀번호</label>
+                <span className="as-setting-desc">
+                  {smtp.hasPassword ? '비밀번호가 저장되어 있습니다. 바꿀 때만 입력하세요.' : 'SMTP 인증 정보입니다.'}
+                </span>
+              </div>
+              <div className="as-setting-input-group">
+                <input
+                  type="text"
+                  value={smtp.username}
+                  onChange={(e) => setSmtp({ ...smtp, username: e.target.value })}
+                  placeholder="사용자명"
+                  autoComplete="off"
+                />
+                <input
+                  type="password"
+                  value={smtpPassword}
+                  onChange={(e) => setSmtpPassword(e.target.value)}
+                  placeholder={smtp.hasPassword ? '••••••••' : '비밀번호'}
+                  autoComplete="new-password"
+                />
+              </div>
+            </div>
+            <div className="as-divider"></div>
+            <div className="as-setting-row">
+              <div className="as-setting-info">
+                <label>테스트 메일</label>
+                <span className="as-setting-desc">
+                  저장된 설정으로 메일을 보냅니다. 받는 사람을 비워두면 내 계정의 이메일로 보냅니다.
+                </span>
+              </div>
+              <div className="as-setting-input-group">
+                <input
+                  type="email"
+                  value={testRecipient}
+                  onChange={(e) => setTestRecipient(e.target.value)}
+                  placeholder={currentUser?.email || '받는 사람'}
+                />
+                <button
+                  className="as-btn-secondary"
+                  onClick={handleTestSmtp}
+                  disabled={smtpTesting || !smtp.configured}
+                >
+                  {smtpTesting ? '보내는 중...' : '보내기'}
+                </button>
+              </div>
+            </div>
+            <div className="as-smtp-actions">
+              <button
+                className="as-btn-secondary"
+                onClick={handleSaveSmtp}
+                disabled={smtpSaving}
+              >
+                {smtpSaving ? '저장 중...' : '메일 설정 저장'}
+              </button>
+            </div>
+          </div>
+        </div>
+
         {/* Save Actions */}
         <div className="as-actions">
           <button
@@ -4,18 +4,24 @@ import {
   createSharedFolder,
   updateSharedFolder,
   deleteSharedFolder,
-  getSharedFolderMembers,
+  getSharedFolderAccess,
   addSharedFolderMember,
   updateMemberPermission,
   removeSharedFolderMember,
+  setSharedFolderGroup,
+  removeSharedFolderGroup,
+  validateSMBExport,
   SharedFolder,
+  SharedFolderSMB,
   SharedFolderMember,
+  SharedFolderGroup,
   formatStorageSize,
   getPermissionLabel,
   PERMISSION_READ_ONLY,
   PERMISSION_READ_WRITE,
 } from '../api/sharedFolders'
 import { api } from '../api/client'
+import { listGroups, UserGroup } from '../api/groups'
 import './AdminSharedFolders.css'
 
 interface User {
@@ -32,6 +38,14 @@ interface InitialMember {
   permission: number
 }
 
+const defaultSMB: SharedFolderSMB = {
+  enabled: false,
+  browseable: true,
+  guestOk: false,
+  recycleBin: false,
+  readOnly: false,
+}
+
 async function getUsers(): Promise<User[]> {
   const data = await api.get<{ users: User[] }>('/admin/users')
   return data.users
@@ -55,6 +69,7 @@ function AdminSharedFolders() {
     storageQuota: 0,
     storageQuotaUnit: 'GB' as 'MB' | 'GB' | 'TB',
     isActive: true,
+    smb: defaultSMB,
   })
   const [formError, setFormError] = useState<string | null>(null)
   const [saving, setSaving] = useState(false)
@@ -75,6 +90,10 @@ function AdminSharedFolders() {
   const [newMemberUserId, setNewMemberUserId] = useState('')
   const [newMemberPermission, setNewMemberPermission] = useState(PERMISSION_READ_ONLY)
   const [memberModalSearch, setMemberModalSearch] = useState('')
+  const [folderGroups, setFolderGroups] = useState<SharedFolderGroup[]>([])
+  const [groups, setGroups] = useState<UserGroup[]>([])
+  const [newGroupId, setNewGroupId] = useState('')
+  const [newGroupPermission, setNewGroupPermission] = useState(PERMISSION_READ_ONLY)
 
   // Delete confirmation
   const [showDeleteConfirm, setShowDeleteConfirm] = useState(false)
@@ -160,6 +179,7 @@ function AdminSharedFolders() {
       storageQuota: 0,
       storageQuotaUnit: 'GB',
       isActive: true,
+      smb: defaultSMB,
     })
     setFormError(null)
     setInitialMembers([])
@@ -193,6 +213,7 @@ function AdminSharedFolders() {
       storageQuota: quota,
       storageQuotaUnit: unit,
       isActive: folder.isActive,
+      smb: folder.smb ? { ...defaultSMB, ...folder.smb } : defaultSMB,
     })
     setFormError(null)
     setInitialMembers([])
@@ -254,12 +275,28 @@ function AdminSharedFolders() {
 
     setSaving(true)
     try {
+      // Dry-run the SMB config so a bad share never reaches Samba
+      if (formData.smb.enabled) {
+        const check = await validateSMBExport({
+          folderId: editingFolder?.id,
+          name: formData.name.trim(),
+          description: formData.description.trim(),
+          smb: formData.smb,
+        })
+        if (!check.valid) {
+          const firstError = check.issues.find(i => i.severity === 'error')
+          setFormError(`SMB 설정 오류: ${firstError?.message || '설정을 확인하세요'}`)
+          return
+        }
+      }
+
       if (editingFolder) {
         await updateSharedFolder(editingFolder.id, {
           name: formData.name.trim(),
           description: formData.description.trim(),
           storageQuota: quotaBytes,
           isActive: formData.isActive,
+          smb: formData.smb,
         })
       } else {
         // Create new folder
@@ -267,6 +304,7 @@ function AdminSharedFolders() {
           name: formData.name.trim(),
           description: formData.description.trim(),
           storageQuota: quotaBytes,
+          smb: formData.smb.enabled ? formData.smb : undefined,
         })
 
         // Add initial members if any
@@ -312,14 +350,19 @@ function AdminSharedFolders() {
     setShowMembersModal(true)
     setMemberModalSearch('')
     setNewMemberUserId('')
+    setNewGroupId('')
 
     try {
-      const [membersData, usersData] = await Promise.all([
-        getSharedFolderMembers(folder.id),
+      const [access, usersData, groupsData] = await Promise.all([
+        getSharedFolderAccess(folder.id),
         getUsers(),
+        // Listing groups needs user management permission
+        listGroups().catch(() => [] as UserGroup[]),
       ])
-      setMembers(membersData)
+      setMembers(access.members)
+      setFolderGroups(access.groups)
       setUsers(usersData)
+      setGroups(groupsData)
     } catch (err) {
       alert('멤버를 불러오는데 실패했습니다')
     } finally {
@@ -327,6 +370,13 @@ function AdminSharedFolders() {
     }
   }
 
+  // Reload members and group grants of the open folder
+  const reloadMembers = async (folderId: string) => {
+    const access = await getSharedFolderAccess(folderId)
+    setMembers(access.members)
+    setFolderGroups(access.groups)
+  }
+
   // Add member
   const handleAddMember = async (userId?: string, permission?: number) => {
     const targetUserId = userId || newMemberUserId
@@ -335,8 +385,7 @@ function AdminSharedFolders() {
     setAddingMember(true)
     try {
       await addSharedFolderMember(selectedFolder.id, targetUserId, targetPermission)
-      const updated = await getSharedFolderMembers(selectedFolder.id)
-      setMembers(updated)
+      await reloadMembers(selectedFolder.id)
       setNewMemberUserId('')
       setNewMemberPermission(PERMISSION_READ_ONLY)
       // Reload folders to update member count
@@ -353,8 +402,7 @@ function AdminSharedFolders() {
     if (!selectedFolder) return
     try {
       await updateMemberPermission(selectedFolder.id, userId, level)
-      const updated = await getSharedFolderMembers(selectedFolder.id)
-      setMembers(updated)
+      await reloadMembers(selectedFolder.id)
     } catch (err) {
       alert(err instanceof Error ? err.message : '권한 수정에 실패했습니다')
     }
@@ -366,8 +414,7 @@ function AdminSharedFolders() {
     if (!confirm('이 멤버를 제거하시겠습니까?')) return
     try {
       await removeSharedFolderMember(selectedFolder.id, userId)
-      const updated = await getSharedFolderMembers(selectedFolder.id)
-      setMembers(updated)
+      await reloadMembers(selectedFolder.id)
       // Reload folders to update member count
       loadFolders()
     } catch (err) {
@@ -375,7 +422,36 @@ function AdminSharedFolders() {
     }
   }
 
-  const availableUsers = users.filter(u => !members.some(m => m.userId === u.id))
+  // Grant a group access, or change its level
+  const handleSetGroup = async (groupId: string, level: number) => {
+    if (!selectedFolder || !groupId) return
+    try {
+      await setSharedFolderGroup(selectedFolder.id, groupId, level)
+      await reloadMembers(selectedFolder.id)
+      setNewGroupId('')
+      setNewGroupPermission(PERMISSION_READ_ONLY)
+      loadFolders()
+    } catch (err) {
+      alert(err instanceof Error ? err.message : '그룹 권한 설정에 실패했습니다')
+    }
+  }
+
+  // Revoke a group's access
+  const handleRemoveGroup = async (groupId: string) => {
+    if (!selectedFolder) return
+    if (!confirm('이 그룹의 권한을 제거하시겠습니까? 직접 멤버이거나 다른 그룹에 속한 사용자는 접근이 유지됩니다.')) return
+    try {
+      await removeSharedFolderGroup(selectedFolder.id, groupId)
+      await reloadMembers(selectedFolder.id)
+      loadFolders()
+    } catch (err) {
+      alert(err instanceof Error ? err.message : '그룹 권한 제거에 실패했습니다')
+    }
+  }
+
+  // Users with access only through groups can still be added directly
+  const availableUsers = users.filter(u => !members.some(m => m.userId === u.id && m.direct))
+  const availableGroups = groups.filter(g => !folderGroups.some(fg => fg.groupId === g.id))
 
   // Filter for member modal search
   const filteredAvailableUsers = availableUsers.filter(u =>
@@ -767,6 +843,48 @@ function AdminSharedFolders() {
                 )}
               </div>
 
+              {/* SMB export - one Samba share per drive */}
+              <div className="form-section">
+                <h3 className="form-section-title">SMB 내보내기</h3>
+                <div className="form-group">
+                  <label className="toggle-label">
+                    <span>SMB로도 내보내기</span>
+                    <div className={`toggle-switch ${formData.smb.enabled ? 'active' : ''}`}>
+                      <input
+                        type="checkbox"
+                        checked={formData.smb.enabled}
+                        onChange={e => setFormData({ ...formData, smb: { ...formData.smb, enabled: e.target.checked } })}
+                      />
+                      <span className="toggle-slider"></span>
+                    </div>
+                  </label>
+                  <span className="form-hint">
+                    SMB 비밀번호가 설정된 멤버만 접속할 수 있으며, 멤버가 바뀌면 자동으로 반영됩니다.
+                  </span>
+                </div>
+
+                {formData.smb.enabled && ([
+                  ['browseable', '네트워크 목록에 표시'],
+                  ['guestOk', '게스트 읽기 허용'],
+                  ['recycleBin', '휴지통 사용 (.recycle)'],
+                  ['readOnly', '읽기 전용'],
+                ] as const).map(([key, label]) => (
+                  <div className="form-group" key={key}>
+                    <label className="toggle-label">
+                      <span>{label}</span>
+                      <div className={`toggle-switch ${formData.smb[key] ? 'active' : ''}`}>
+                        <input
+                          type="checkbox"
+                          checked={formData.smb[key]}
+                          onChange={e => setFormData({ ...formData, smb: { ...formData.smb, [key]: e.target.checked } })}
+                        />
+                        <span className="toggle-slider"></span>
+                      </div>
+                    </label>
+                  </div>
+                ))}
+              </div>
+
               {/* Initial Members Section - Only for Create */}
               {!editingFolder && (
                 <div className="form-section">
@@ -1022,6 +1140,68 @@ function AdminSharedFolders() {
                     )}
                   </div>
 
+                  {/* Group grants */}
+                  <div className="members-section">
+                    <h3>그룹 권한 ({folderGroups.length})</h3>
+                    {availableGroups.length > 0 && (
+                      <div className="add-member-form">
+                        <select value={newGroupId} onChange={e => setNewGroupId(e.target.value)}>
+                          <option value="">그룹 선택...</option>
+                          {availableGroups.map(group => (
+                            <option key={group.id} value={group.id}>
+                              {group.name} ({group.memberCount}명)
+                            </option>
+                          ))}
+                        </select>
+                        <select value={newGroupPermission} onChange={e => setNewGroupPermission(Number(e.target.value))}>
+                          <option value={PERMISSION_READ_ONLY}>{getPermissionLabel(PERMISSION_READ_ONLY)}</option>
+                          <option value={PERMISSION_READ_WRITE}>{getPermissionLabel(PERMISSION_READ_WRITE)}</option>
+                        </select>
+                        <button
+                          className="btn-primary"
+                          onClick={() => handleSetGroup(newGroupId, newGroupPermission)}
+                          disabled={!newGroupId}
+                        >
+                          추가
+                        </button>
+                      </div>
+                    )}
+                    {folderGroups.length > 0 && (
+                      <div className="members-list group-grants">
+                        {folderGroups.map(group => (
+                          <div key={group.groupId} className="member-item">
+                            <div className="member-info">
+                              <div className="member-avatar group">
+                                {group.name.slice(0, 2).toUpperCase()}
+                              </div>
+                              <span className="member-name">{group.name}</span>
+                              <span className="member-source">{group.everyone ? '모든 사용자' : `${group.memberCount}명`}</span>
+                            </div>
+                            <div className="member-actions">
+                              <select
+                                value={group.permissionLevel}
+                                onChange={e => handleSetGroup(group.groupId, Number(e.target.value))}
+                                className="permission-select"
+                              >
+                                <option value={PERMISSION_READ_ONLY}>{getPermissionLabel(PERMISSION_READ_ONLY)}</option>
+                                <option value={PERMISSION_READ_WRITE}>{getPermissionLabel(PERMISSION_READ_WRITE)}</option>
+                              </select>
+                              <button
+                                className="remove-member-btn"
+                                onClick={() => handleRemoveGroup(group.groupId)}
+                                title="그룹 권한 제거"
+                              >
+                                <svg width="18" height="18" viewBox="0 0 24 24" fill="none">
+                                  <path d="M18 6L6 18M6 6L18 18" stroke="currentColor" strokeWidth="2" strokeLinecap="round"/>
+                                </svg>
+                              </button>
+                            </div>
+                          </div>
+                        ))}
+                      </div>
+                    )}
+                  </div>
+
                   {/* Members list */}
                   <div className="members-section">
                     <h3>현재 멤버 ({members.length}){memberModalSearch && filteredMembers.length !== members.length && <span style={{ fontWeight: 400, color: 'var(--text-tertiary)' }}> - {filteredMembers.length}명 표시</span>}</h3>
@@ -1040,32 +1220,46 @@ function AdminSharedFolders() {
                     ) : (
                       <div className="members-list">
                         {filteredMembers.map(member => (
-                          <div key={member.id} className="member-item">
+                          <div key={member.userId} className="member-item">
                             <div className="member-info">
                               <div className="member-avatar">
                                 {(member.username || '??').slice(0, 2).toUpperCase()}
                               </div>
                               <span className="member-name">{member.username || '알 수 없음'}</span>
+                              {member.groups && member.groups.length > 0 && (
+                                <span
+                                  className="member-source"
+                                  title={member.groups.map(g => `${g.name}: ${getPermissionLabel(g.permissionLevel)}`).join('\n')}
+                                >
+                                  {member.direct ? '+ ' : ''}그룹: {member.groups.map(g => g.name).join(', ')}
+                                </span>
+                              )}
                             </div>
-                            <div className="member-actions">
-                              <select
-                                value={member.permissionLevel}
-                                onChange={e => handleUpdatePermission(member.userId, Number(e.target.value))}
-                                className="permission-select"
-                              >
-                                <option value={PERMISSION_READ_ONLY}>{getPermissionLabel(PERMISSION_READ_ONLY)}</option>
-                                <option value={PERMISSION_READ_WRITE}>{getPermissionLabel(PERMISSION_READ_WRITE)}</option>
-                              </select>
-                              <button
-                                className="remove-member-btn"
-                                onClick={() => handleRemoveMember(member.userId)}
-                                title="멤버 제거"
-                              >
-                                <svg width="18" height="18" viewBox="0 0 24 24" fill="none">
-                                  <path d="M18 6L6 18M6 6L18 18" stroke="currentColor" strokeWidth="2" strokeLinecap="round"/>
-                                </svg>
-                              </button>
-                            </div>
+                            {!member.direct ? (
+                              <div className="member-actions">
+                                <span className="member-group-permission">{getPermissionLabel(member.effectivePermission)}</span>
+                              </div>
+                            ) : (
+                              <div className="member-actions">
+                                <select
+                                  value={member.permissionLevel}
+                                  onChange={e => handleUpdatePermission(member.userId, Number(e.target.value))}
+                                  className="permission-select"
+                                >
+                                  <option value={PERMISSION_READ_ONLY}>{getPermissionLabel(PERMISSION_READ_ONLY)}</option>
+                                  <option value={PERMISSION_READ_WRITE}>{getPermissionLabel(PERMISSION_READ_WRITE)}</option>
+                                </select>
+                                <button
+                                  className="remove-member-btn"
+                                  onClick={() => handleRemoveMember(member.userId)}
+                                  title="멤버 제거"
+                                >
+                                  <svg width="18" height="18" viewBox="0 0 24 24" fill="none">
+                                    <path d="M18 6L6 18M6 6L18 18" stroke="currentColor" strokeWidth="2" strokeLinecap="round"/>
+                                  </svg>
+                                </button>
+                              </div>
+                            )}
                           </div>
                         ))}
                       </div>
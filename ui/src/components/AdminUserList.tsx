@@ -1,6 +1,6 @@
 import { useState, useEffect, useMemo } from 'react'
 import { useAuthStore } from '../stores/authStore'
-import { listUsers, updateUser, deleteUser, User } from '../api/auth'
+import { listUsers, updateUser, deleteUser, listPendingUsers, approveUser, rejectUser, User, PendingUser } from '../api/auth'
 import CreateUserModal from './CreateUserModal'
 import EditUserModal from './EditUserModal'
 import './AdminUserList.css'
@@ -11,6 +11,7 @@ type FilterStatus = 'all' | 'active' | 'inactive' | 'admin'
 function AdminUserList() {
   const { token, user: currentUser } = useAuthStore()
   const [users, setUsers] = useState<User[]>([])
+  const [pendingUsers, setPendingUsers] = useState<PendingUser[]>([])
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState<string | null>(null)
   const [showCreateModal, setShowCreateModal] = useState(false)
@@ -50,8 +51,9 @@ function AdminUserList() {
     setLoading(true)
     setError(null)
     try {
-      const data = await listUsers(token)
+      const [data, pending] = await Promise.all([listUsers(token), listPendingUsers()])
       setUsers(data.users)
+      setPendingUsers(pending.users)
     } catch (err) {
       setError(err instanceof Error ? err.message : 'Failed to load users')
     } finally {
@@ -80,6 +82,34 @@ function AdminUserList() {
     }
   }
 
+  const handleApprove = async (user: PendingUser) => {
+    setLoading(true)
+    setError(null)
+    try {
+      await approveUser(user.id)
+      loadUsers()
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Failed to approve user')
+    } finally {
+      setLoading(false)
+    }
+  }
+
+  const handleReject = async (user: PendingUser) => {
+    if (!confirm(`${user.username}의 가입 신청을 거절하시겠습니까?\n계정이 삭제됩니다.`)) return
+
+    setLoading(true)
+    setError(null)
+    try {
+      await rejectUser(user.id)
+      loadUsers()
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Failed to reject user')
+    } finally {
+      setLoading(false)
+    }
+  }
+
   const handleDeleteUser = async (userId: string, username: string) => {
     if (!token) return
     if (!confirm(`정말 ${username} 사용자를 삭제하시겠습니까?\n이 작업은 되돌릴 수 없습니다.`)) return
@@ -188,6 +218,26 @@ function AdminUserList() {
           </div>
         )}
 
+        {/* Registrations waiting for approval */}
+        {pendingUsers.length > 0 && (
+          <div className="pending-users">
+            <h3>가입 승인 대기 ({pendingUsers.length})</h3>
+            {pendingUsers.map(user => (
+              <div key={user.id} className="pending-user-row">
+                <div className="pending-user-info">
+                  <span className="pending-user-name">{user.username}</span>
+                  {user.email && <span className="pending-user-email">{user.email}</span>}
+                  <span className="pending-user-date">{new Date(user.registeredAt).toLocaleString('ko-KR')}</span>
+                </div>
+                <div className="pending-user-actions">
+                  <button className="btn-primary" onClick={() => handleApprove(user)} disabled={loading}>승인</button>
+                  <button className="btn-secondary" onClick={() => handleReject(user)} disabled={loading}>거절</button>
+                </div>
+              </div>
+            ))}
+          </div>
+        )}
+
         {/* Stats Cards */}
         <div className="stats-row">
           <div className={`stat-card ${filterStatus === 'all' ? 'active' : ''}`} onClick={() => setFilterStatus('all')}>
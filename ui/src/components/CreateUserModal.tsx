@@ -33,6 +33,7 @@ function CreateUserModal({ isOpen, onClose, onCreated }: CreateUserModalProps) {
   const [password, setPassword] = useState('')
   const [confirmPassword, setConfirmPassword] = useState('')
   const [isAdmin, setIsAdmin] = useState(false)
+  const [sendWelcomeEmail, setSendWelcomeEmail] = useState(false)
 
   // Shared folders state
   const [sharedFolders, setSharedFolders] = useState<SharedFolder[]>([])
@@ -65,6 +66,7 @@ function CreateUserModal({ isOpen, onClose, onCreated }: CreateUserModalProps) {
     setPassword('')
     setConfirmPassword('')
     setIsAdmin(false)
+    setSendWelcomeEmail(false)
     setError(null)
     setFolderSearch('')
   }
@@ -109,8 +111,11 @@ function CreateUserModal({ isOpen, onClose, onCreated }: CreateUserModalProps) {
     e.preventDefault()
     if (!token) return
 
+    // A welcome email lets the user set their own password
+    const welcome = sendWelcomeEmail && !!email
+
     // Validate passwords match
-    if (password !== confirmPassword) {
+    if (!welcome && password !== confirmPassword) {
       setError('비밀번호가 일치하지 않습니다')
       return
     }
@@ -123,8 +128,9 @@ function CreateUserModal({ isOpen, onClose, onCreated }: CreateUserModalProps) {
       const result = await createUser(token, {
         username,
         email: email || undefined,
-        password,
+        password: welcome ? undefined : password,
         isAdmin,
+        sendWelcomeEmail: welcome,
       })
 
       // Add permissions to shared folders
@@ -191,6 +197,21 @@ function CreateUserModal({ isOpen, onClose, onCreated }: CreateUserModalProps) {
                 </div>
               </div>
 
+              <div className="form-group checkbox-group">
+                <label className="checkbox-label">
+                  <input
+                    type="checkbox"
+                    checked={sendWelcomeEmail}
+                    onChange={e => setSendWelcomeEmail(e.target.checked)}
+                    disabled={!email}
+                  />
+                  <span className="checkmark"></span>
+                  <span>환영 이메일 보내기</span>
+                </label>
+                <p className="form-hint">사용자가 직접 비밀번호를 설정할 수 있는 1회용 링크(72시간 유효)를 이메일로 보냅니다.</p>
+              </div>
+
+              {!(sendWelcomeEmail && email) && (
               <div className="form-row">
                 <div className="form-group">
                   <label>비밀번호 *</label>
@@ -215,6 +236,7 @@ function CreateUserModal({ isOpen, onClose, onCreated }: CreateUserModalProps) {
                   />
                 </div>
               </div>
+              )}
 
               <div className="form-group checkbox-group">
                 <label className="checkbox-label">
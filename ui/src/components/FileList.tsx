@@ -48,8 +48,8 @@ interface FileListProps {
 }
 
 function FileList({ currentPath, onNavigate, onUploadClick, onNewFolderClick, highlightedFilePath, onClearHighlight }: FileListProps) {
-  const [sortBy, setSortBy] = useState<SortField>('name')
-  const [sortOrder, setSortOrder] = useState<SortOrder>('asc')
+  // null until the user picks a sort, so the folder default applies
+  const [sortChoice, setSortChoice] = useState<{ field: SortField; order: SortOrder } | null>(null)
   const [viewMode, setViewMode] = useState<ViewMode>(() => {
     // Persist view mode preference
     const saved = localStorage.getItem('fileViewMode')
@@ -153,8 +153,8 @@ function FileList({ currentPath, onNavigate, onUploadClick, onNewFolderClick, hi
 
   // Regular file list query
   const { data, isLoading, error } = useQuery({
-    queryKey: ['files', currentPath, sortBy, sortOrder],
-    queryFn: () => fetchFiles(currentPath, sortBy, sortOrder),
+    queryKey: ['files', currentPath, sortChoice?.field, sortChoice?.order],
+    queryFn: () => fetchFiles(currentPath, sortChoice?.field, sortChoice?.order),
     enabled: !isSpecialShareView,
     staleTime: 30000, // Consider data fresh for 30 seconds
   })
@@ -331,11 +331,12 @@ function FileList({ currentPath, onNavigate, onUploadClick, onNewFolderClick, hi
     await handleFolderUploadDrop(e, folder)
   }, [handleFolderMoveDrop, handleFolderUploadDrop])
 
-  // Clear selected file when path changes
+  // Clear selected file when path changes; the next folder opens with its default sort
   useEffect(() => {
     setSelectedFile(null)
     setSelectedFiles(new Set())
     setFolderStats(null)
+    setSortChoice(null)
   }, [currentPath])
 
   // Adjust context menu position to keep it within viewport
@@ -1102,14 +1103,16 @@ function FileList({ currentPath, onNavigate, onUploadClick, onNewFolderClick, hi
     onRedo: handleRedo,
   })
 
+  const sortBy: SortField = sortChoice?.field ?? (data?.sort === 'size' || data?.sort === 'date' ? data.sort : 'name')
+  const sortOrder: SortOrder = sortChoice?.order ?? data?.order ?? 'asc'
+
   const handleSort = useCallback((field: SortField) => {
     if (sortBy === field) {
-      setSortOrder(prev => prev === 'asc' ? 'desc' : 'asc')
+      setSortChoice({ field, order: sortOrder === 'asc' ? 'desc' : 'asc' })
     } else {
-      setSortBy(field)
-      setSortOrder('asc')
+      setSortChoice({ field, order: 'asc' })
     }
-  }, [sortBy])
+  }, [sortBy, sortOrder])
 
 
   const getSortIcon = (field: SortField) => {
@@ -1561,6 +1564,7 @@ function FileList({ currentPath, onNavigate, onUploadClick, onNewFolderClick, hi
       {onlyOfficeConfig && onlyOfficeFile && (
         <OnlyOfficeEditor
           config={onlyOfficeConfig}
+          path={onlyOfficeFile.path}
           publicUrl={onlyOfficePublicUrl}
           onClose={() => {
             setOnlyOfficeConfig(null)
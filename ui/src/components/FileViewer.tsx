@@ -1,6 +1,6 @@
 import { useState, useEffect, useCallback } from 'react'
 import { Document, Page, pdfjs } from 'react-pdf'
-import { getFileUrl, getAuthToken, FileInfo } from '../api/files'
+import { getFileUrl, getAuthToken, getVideoStreamInfo, getVideoStreamUrl, FileInfo, VideoStreamInfo } from '../api/files'
 import 'react-pdf/dist/Page/AnnotationLayer.css'
 import 'react-pdf/dist/Page/TextLayer.css'
 import './FileViewer.css'
@@ -43,6 +43,14 @@ function isBrowserPlayableVideo(fileName: string): boolean {
   return playableExts.includes(ext)
 }
 
+// Format seconds as m:ss or h:mm:ss
+function formatVideoTime(seconds: number): string {
+  const s = Math.floor(seconds % 60).toString().padStart(2, '0')
+  const m = Math.floor((seconds / 60) % 60)
+  const h = Math.floor(seconds / 3600)
+  return h > 0 ? `${h}:${m.toString().padStart(2, '0')}:${s}` : `${m}:${s}`
+}
+
 // Get MIME type for audio files
 function getAudioMimeType(fileName: string): string {
   const ext = fileName.split('.').pop()?.toLowerCase() || ''
@@ -114,9 +122,21 @@ function FileViewer({ filePath, fileName, mimeType, onClose, siblingFiles, onNav
   const [pdfData, setPdfData] = useState<{ data: ArrayBuffer } | null>(null)
   const [imageUrl, setImageUrl] = useState<string | null>(null)
   const [subtitleUrl, setSubtitleUrl] = useState<string | null>(null)
+  // Videos browsers cannot play are converted by the server; seeking restarts the stream
+  const [streamInfo, setStreamInfo] = useState<VideoStreamInfo | null>(null)
+  const [streamStart, setStreamStart] = useState(0)
+  const [streamTime, setStreamTime] = useState(0)
   // For video/audio, use streaming URL with token query param
   const streamingUrl = token ? `${fileUrl}?token=${encodeURIComponent(token)}` : fileUrl
 
+  const withToken = (url: string) =>
+    token ? `${url}${url.includes('?') ? '&' : '?'}token=${encodeURIComponent(token)}` : url
+  const convertedVideo = streamInfo !== null && streamInfo.mode !== 'direct'
+  const canPlayVideo = streamInfo
+    ? streamInfo.mode === 'direct' || streamInfo.transcodeEnabled
+    : isBrowserPlayableVideo(fileName)
+  const videoSrc = convertedVideo ? withToken(getVideoStreamUrl(filePath, streamStart)) : streamingUrl
+
   // Build subtitle URL
   const subtitleApiUrl = fileUrl.replace('/api/files/', '/api/subtitle/') + (token ? `?token=${encodeURIComponent(token)}` : '')
 
@@ -127,6 +147,9 @@ function FileViewer({ filePath, fileName, mimeType, onClose, siblingFiles, onNav
     setScale(1)
     setPdfData(null)
     setSubtitleUrl(null)
+    setStreamInfo(null)
+    setStreamStart(0)
+    setStreamTime(0)
     if (imageUrl) URL.revokeObjectURL(imageUrl)
     setImageUrl(null)
 
@@ -135,6 +158,11 @@ function FileViewer({ filePath, fileName, mimeType, onClose, siblingFiles, onNav
       setLoading(false)
       // Check if subtitle exists
       if (viewerType === 'video') {
+        getVideoStreamInfo(filePath)
+          .then(setStreamInfo)
+          .catch(() => {
+            // Without ffprobe the extension decides
+          })
         fetch(subtitleApiUrl, { method: 'HEAD' })
           .then(res => {
             if (res.ok) {
@@ -369,16 +397,18 @@ function FileViewer({ filePath, fileName, mimeType, onClose, siblingFiles, onNav
             </div>
           )}
 
-          {!loading && !error && viewerType === 'video' && isBrowserPlayableVideo(fileName) && (
+          {!loading && !error && viewerType === 'video' && canPlayVideo && (
             <div className="video-container">
               <video
+                key={videoSrc}
                 controls
                 autoPlay
                 playsInline
                 crossOrigin="anonymous"
+                onTimeUpdate={(e) => convertedVideo && setStreamTime(e.currentTarget.currentTime)}
                 onError={() => setError('비디오를 재생할 수 없습니다')}
               >
-                <source src={streamingUrl} type={getVideoMimeType(fileName)} />
+                <source src={videoSrc} type={convertedVideo ? 'video/mp4' : getVideoMimeType(fileName)} />
                 {subtitleUrl && (
                   <track
                     kind="subtitles"
@@ -390,10 +420,25 @@ function FileViewer({ filePath, fileName, mimeType, onClose, siblingFiles, onNav
                 )}
                 브라우저가 비디오 재생을 지원하지 않습니다.
               </video>
+              {convertedVideo && streamInfo.duration > 0 && (
+                <div className="video-transcode-seek">
+                  <input
+                    type="range"
+                    min={0}
+                    max={Math.floor(streamInfo.duration)}
+                    value={Math.floor(streamStart + streamTime)}
+                    onChange={(e) => {
+                      setStreamStart(parseInt(e.target.value, 10))
+                      setStreamTime(0)
+                    }}
+                  />
+                  <span>{formatVideoTime(streamStart + streamTime)} / {formatVideoTime(streamInfo.duration)}</span>
+                </div>
+              )}
             </div>
           )}
 
-          {!loading && !error && viewerType === 'video' && !isBrowserPlayableVideo(fileName) && (
+          {!loading && !error && viewerType === 'video' && !canPlayVideo && (
             <div className="unsupported-video-container">
               <div className="unsupported-video-icon">
                 <svg width="64" height="64" viewBox="0 0 24 24" fill="none" stroke="currentColor" strokeWidth="1.5">
@@ -406,7 +451,9 @@ function FileViewer({ filePath, fileName, mimeType, onClose, siblingFiles, onNav
                 이 비디오 형식({fileName.split('.').pop()?.toUpperCase()})은 브라우저에서 직접 재생할 수 없습니다.
               </p>
               <p className="unsupported-video-hint">
-                MP4, WebM, OGG 형식만 브라우저에서 재생 가능합니다.
+                {streamInfo && !streamInfo.transcodeEnabled
+                  ? '서버의 동영상 변환이 꺼져 있어 재생할 수 없습니다.'
+                  : 'MP4, WebM, OGG 형식만 브라우저에서 재생 가능합니다.'}
               </p>
               <button
                 className="download-video-btn"
@@ -5,6 +5,7 @@ import { useSharedFolders } from '../hooks/useSharedFolders'
 import { useTheme } from '../contexts/ThemeContext'
 import SearchModal from './SearchModal'
 import { NotificationBell } from './NotificationBell'
+import { highlightMatches } from '../utils/highlight'
 import './Header.css'
 
 interface HeaderProps {
@@ -222,10 +223,10 @@ function Header({ onProfileClick, onNavigate, onFileSelect, currentPath = '/', i
                   </svg>
                   <div className="result-info">
                     <div className="result-name-row">
-                      <span className="result-name">{file.name}</span>
+                      <span className="result-name">{highlightMatches(file.name, file.matchRanges)}</span>
                       {file.matchType && file.matchType !== 'name' && (
                         <span className={`match-badge ${file.matchType}`}>
-                          {file.matchType === 'tag' ? `#${file.matchedTag || '태그'}` : '설명'}
+                          {file.matchType === 'tag' ? `#${file.matchedTag || '태그'}` : file.matchType === 'content' ? '내용' : '설명'}
                         </span>
                       )}
                     </div>
@@ -33,6 +33,10 @@ function LinkShareModal({ isOpen, onClose, itemPath, itemName, isFolder }: LinkS
   const [useMaxAccess, setUseMaxAccess] = useState(false)
   const [maxAccess, setMaxAccess] = useState(10)
   const [requireLogin, setRequireLogin] = useState(false)
+  const [notifyEmail, setNotifyEmail] = useState(false)
+  const [useEmailTo, setUseEmailTo] = useState(false)
+  const [emailTo, setEmailTo] = useState('')
+  const [emailMessage, setEmailMessage] = useState('')
   // Upload share specific state
   const [shareType, setShareType] = useState<'download' | 'upload'>('download')
   const [useMaxFileSize, setUseMaxFileSize] = useState(false)
@@ -76,6 +80,7 @@ function LinkShareModal({ isOpen, onClose, itemPath, itemName, isFolder }: LinkS
       setUseMaxAccess(false)
       setMaxAccess(10)
       setRequireLogin(false)
+      setNotifyEmail(false)
       // Reset upload share options
       setShareType('download')
       setUseMaxFileSize(false)
@@ -109,6 +114,9 @@ function LinkShareModal({ isOpen, onClose, itemPath, itemName, isFolder }: LinkS
         expiresIn: useExpiry ? expiryHours : undefined,
         maxAccess: useMaxAccess ? maxAccess : undefined,
         requireLogin: requireLogin,
+        notifyEmail: notifyEmail,
+        emailTo: useEmailTo ? emailTo.split(/[,;\s]+/).filter(Boolean) : undefined,
+        emailMessage: useEmailTo && emailMessage ? emailMessage : undefined,
         // Upload share options
         shareType: shareType,
         maxFileSize: shareType === 'upload' && useMaxFileSize ? maxFileSize : undefined,
@@ -118,12 +126,15 @@ function LinkShareModal({ isOpen, onClose, itemPath, itemName, isFolder }: LinkS
 
       const fullUrl = `${window.location.origin}${result.url}`
       setCreatedLink(fullUrl)
+      const emailed = result.emailedTo?.length
+        ? ` · ${result.emailedTo.length}명에게 이메일을 보냈습니다`
+        : ''
 
       // Auto-copy to clipboard
       try {
         await navigator.clipboard.writeText(fullUrl)
         setCopied(true)
-        setSuccess('링크가 클립보드에 복사되었습니다')
+        setSuccess('링크가 클립보드에 복사되었습니다' + emailed)
         setTimeout(() => setCopied(false), 2000)
       } catch {
         // Fallback for older browsers
@@ -134,7 +145,7 @@ function LinkShareModal({ isOpen, onClose, itemPath, itemName, isFolder }: LinkS
         document.execCommand('copy')
         document.body.removeChild(textArea)
         setCopied(true)
-        setSuccess('링크가 클립보드에 복사되었습니다')
+        setSuccess('링크가 클립보드에 복사되었습니다' + emailed)
         setTimeout(() => setCopied(false), 2000)
       }
 
@@ -146,6 +157,10 @@ function LinkShareModal({ isOpen, onClose, itemPath, itemName, isFolder }: LinkS
       setUseExpiry(false)
       setUseMaxAccess(false)
       setRequireLogin(false)
+      setNotifyEmail(false)
+      setUseEmailTo(false)
+      setEmailTo('')
+      setEmailMessage('')
       setShareType('download')
       setUseMaxFileSize(false)
       setUseAllowedExtensions(false)
@@ -382,6 +397,52 @@ function LinkShareModal({ isOpen, onClose, itemPath, itemName, isFolder }: LinkS
               )}
             </div>
 
+            <div className="option-row">
+              <label className="checkbox-label">
+                <input
+                  type="checkbox"
+                  checked={notifyEmail}
+                  onChange={(e) => setNotifyEmail(e.target.checked)}
+                />
+                <span>이메일 알림</span>
+              </label>
+              {notifyEmail && (
+                <span className="option-hint">
+                  {shareType === 'upload' ? '파일이 업로드되면' : '파일이 다운로드되면'} 이메일로도 알림 (SMTP 설정 시)
+                </span>
+              )}
+            </div>
+
+            <div className="option-row">
+              <label className="checkbox-label">
+                <input
+                  type="checkbox"
+                  checked={useEmailTo}
+                  onChange={(e) => setUseEmailTo(e.target.checked)}
+                />
+                <span>이메일로 링크 보내기</span>
+              </label>
+              {useEmailTo && (
+                <div className="email-to-wrapper">
+                  <input
+                    type="text"
+                    value={emailTo}
+                    onChange={(e) => setEmailTo(e.target.value)}
+                    placeholder="받는 사람 (쉼표로 구분, 최대 20명)"
+                    className="option-input"
+                  />
+                  <textarea
+                    value={emailMessage}
+                    onChange={(e) => setEmailMessage(e.target.value)}
+                    placeholder="메시지 (선택)"
+                    className="option-input"
+                    maxLength={2000}
+                    rows={3}
+                  />
+                </div>
+              )}
+            </div>
+
             {/* Upload-specific options */}
             {shareType === 'upload' && (
               <>
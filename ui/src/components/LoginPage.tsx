@@ -1,7 +1,8 @@
 import { useState, useEffect } from 'react'
 import { useAuthStore } from '../stores/authStore'
-import { getSSOProviders, getSSOAuthURL, SSOProviderPublic } from '../api/auth'
+import { getSSOProviders, getSSOAuthURL, requestPasswordReset, getRegistrationPolicy, SSOProviderPublic, RegistrationPolicy } from '../api/auth'
 import InitialSetupModal from './InitialSetupModal'
+import RegisterForm from './RegisterForm'
 import './LoginPage.css'
 
 // Provider icons
@@ -41,6 +42,13 @@ function LoginPage() {
   const [ssoOnlyMode, setSSOOnlyMode] = useState(false)
   const [ssoLoading, setSSOLoading] = useState<string | null>(null)
   const [ssoError, setSSOError] = useState<string | null>(null)
+  const [forgotMode, setForgotMode] = useState(false)
+  const [registration, setRegistration] = useState<RegistrationPolicy | null>(null)
+  const [registerMode, setRegisterMode] = useState(false)
+  const [resetName, setResetName] = useState('')
+  const [resetSending, setResetSending] = useState(false)
+  const [resetMessage, setResetMessage] = useState<string | null>(null)
+  const [resetError, setResetError] = useState<string | null>(null)
 
   const { login, verify2FACode, cancel2FA, isLoading, error, clearError, requires2FA, requiresSetup, setToken } = useAuthStore()
 
@@ -78,6 +86,11 @@ function LoginPage() {
       .catch(() => {
         // Ignore errors - SSO just won't be available
       })
+    getRegistrationPolicy()
+      .then(setRegistration)
+      .catch(() => {
+        // Ignore errors - sign-up just won't be offered
+      })
   }, [])
 
   const handleSubmit = async (e: React.FormEvent) => {
@@ -154,6 +167,27 @@ function LoginPage() {
     }
   }
 
+  const handleResetRequest = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setResetSending(true)
+    setResetError(null)
+    try {
+      await requestPasswordReset(resetName)
+      setResetMessage('계정에 등록된 이메일로 비밀번호 재설정 링크를 보냈습니다. 메일이 오지 않으면 관리자에게 문의하세요.')
+    } catch (err) {
+      setResetError(err instanceof Error ? err.message : '요청을 처리하지 못했습니다.')
+    } finally {
+      setResetSending(false)
+    }
+  }
+
+  const closeForgotMode = () => {
+    setForgotMode(false)
+    setResetName('')
+    setResetMessage(null)
+    setResetError(null)
+  }
+
   // Initial setup modal (shown when admin needs to change default credentials)
   if (requiresSetup) {
     return <InitialSetupModal />
@@ -216,6 +250,68 @@ function LoginPage() {
     )
   }
 
+  // Self-registration form
+  if (registerMode && registration) {
+    return <RegisterForm policy={registration} onBack={() => setRegisterMode(false)} />
+  }
+
+  // Forgot password form
+  if (forgotMode) {
+    return (
+      <div className="login-page">
+        <div className="login-container">
+          <div className="login-header">
+            <div className="login-banner">
+              <img src="/banner.png" alt="FileHatch" />
+            </div>
+            <h1>비밀번호 재설정</h1>
+            <p>사용자명 또는 이메일을 입력하면 재설정 링크를 보내드립니다</p>
+          </div>
+
+          <form onSubmit={handleResetRequest} className="login-form">
+            {resetError && <div className="login-error">{resetError}</div>}
+            {resetMessage ? (
+              <p>{resetMessage}</p>
+            ) : (
+              <>
+                <div className="form-group">
+                  <label htmlFor="resetName">사용자명 또는 이메일</label>
+                  <input
+                    id="resetName"
+                    type="text"
+                    value={resetName}
+                    onChange={(e) => setResetName(e.target.value)}
+                    placeholder="사용자명 또는 이메일을 입력하세요"
+                    required
+                    autoComplete="username"
+                    autoFocus
+                  />
+                </div>
+
+                <button
+                  type="submit"
+                  className="login-btn"
+                  disabled={resetSending || !resetName}
+                >
+                  {resetSending ? '보내는 중...' : '재설정 링크 보내기'}
+                </button>
+              </>
+            )}
+
+            <button
+              type="button"
+              className="cancel-btn"
+              onClick={closeForgotMode}
+              disabled={resetSending}
+            >
+              로그인으로 돌아가기
+            </button>
+          </form>
+        </div>
+      </div>
+    )
+  }
+
   return (
     <div className="login-page">
       <div className="login-container">
@@ -304,6 +400,14 @@ function LoginPage() {
             >
               {isLoading ? '로그인 중...' : '로그인'}
             </button>
+
+            <button
+              type="button"
+              className="forgot-password-link"
+              onClick={() => { clearError(); setForgotMode(true) }}
+            >
+              비밀번호를 잊으셨나요?
+            </button>
           </form>
         )}
 
@@ -315,7 +419,20 @@ function LoginPage() {
         )}
 
         <div className="login-footer">
-          <p>계정이 없으신가요? 관리자에게 문의하세요.</p>
+          {registration?.enabled && !ssoOnlyMode ? (
+            <p>
+              계정이 없으신가요?{' '}
+              <button
+                type="button"
+                className="forgot-password-link inline"
+                onClick={() => { clearError(); setRegisterMode(true) }}
+              >
+                계정 만들기
+              </button>
+            </p>
+          ) : (
+            <p>계정이 없으신가요? 관리자에게 문의하세요.</p>
+          )}
         </div>
       </div>
     </div>
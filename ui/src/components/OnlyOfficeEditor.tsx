@@ -1,5 +1,5 @@
 import { useEffect, useRef, useState } from 'react'
-import { OnlyOfficeConfig } from '../api/files'
+import { OnlyOfficeConfig, forceSaveOnlyOffice } from '../api/files'
 import './OnlyOfficeEditor.css'
 
 // Declare the DocsAPI type for OnlyOffice Document Server API
@@ -49,12 +49,13 @@ interface OnlyOfficeEditorConfig {
 
 interface OnlyOfficeEditorProps {
   config: OnlyOfficeConfig
+  path?: string
   publicUrl?: string | null
   onClose: () => void
   onError?: (error: string) => void
 }
 
-function OnlyOfficeEditor({ config, publicUrl, onClose, onError }: OnlyOfficeEditorProps) {
+function OnlyOfficeEditor({ config, path, publicUrl, onClose, onError }: OnlyOfficeEditorProps) {
   const [isLoading, setIsLoading] = useState(true)
   const [error, setError] = useState<string | null>(null)
   const editorRef = useRef<OnlyOfficeDocEditor | null>(null)
@@ -195,6 +196,18 @@ function OnlyOfficeEditor({ config, publicUrl, onClose, onError }: OnlyOfficeEdi
     }
   }, [config, publicUrl, onClose, onError])
 
+  // Periodically flush edits so they persist before the editor is closed
+  useEffect(() => {
+    const interval = config.autosaveInterval ?? 0
+    if (!path || interval <= 0 || !config.editorConfig.customization.forcesave) return
+    const timer = setInterval(() => {
+      forceSaveOnlyOffice(path, config.document.key).catch(() => {
+        // The next interval retries
+      })
+    }, interval * 1000)
+    return () => clearInterval(timer)
+  }, [config, path])
+
   return (
     <div className="onlyoffice-overlay">
       <div className="onlyoffice-container" ref={containerRef}>
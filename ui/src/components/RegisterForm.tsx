@@ -0,0 +1,144 @@
+import { useState } from 'react'
+import { register, RegistrationPolicy } from '../api/auth'
+import './LoginPage.css'
+
+interface RegisterFormProps {
+  policy: RegistrationPolicy
+  onBack: () => void
+}
+
+// Self-registration form shown from the login page when registration_enabled is set
+function RegisterForm({ policy, onBack }: RegisterFormProps) {
+  const [username, setUsername] = useState('')
+  const [email, setEmail] = useState('')
+  const [password, setPassword] = useState('')
+  const [confirmPassword, setConfirmPassword] = useState('')
+  const [error, setError] = useState<string | null>(null)
+  const [submitting, setSubmitting] = useState(false)
+  const [result, setResult] = useState<'active' | 'pending' | null>(null)
+
+  const emailRequired = policy.emailDomains.length > 0
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (password !== confirmPassword) {
+      setError('비밀번호가 일치하지 않습니다.')
+      return
+    }
+    setSubmitting(true)
+    setError(null)
+    try {
+      const response = await register({ username, email: email || undefined, password })
+      setResult(response.pending ? 'pending' : 'active')
+    } catch (err) {
+      setError(err instanceof Error ? err.message : '가입에 실패했습니다.')
+    } finally {
+      setSubmitting(false)
+    }
+  }
+
+  return (
+    <div className="login-page">
+      <div className="login-container">
+        <div className="login-header">
+          <div className="login-banner">
+            <img src="/banner.png" alt="FileHatch" />
+          </div>
+          <h1>계정 만들기</h1>
+          <p>
+            {policy.requiresApproval
+              ? '가입 후 관리자가 승인하면 로그인할 수 있습니다'
+              : '가입하면 바로 로그인할 수 있습니다'}
+          </p>
+        </div>
+
+        {result ? (
+          <div className="login-form">
+            <p>
+              {result === 'pending'
+                ? '가입 신청이 접수되었습니다. 관리자가 승인하면 로그인할 수 있습니다.'
+                : '가입이 완료되었습니다. 새 계정으로 로그인하세요.'}
+            </p>
+            <button type="button" className="login-btn" onClick={onBack}>
+              로그인으로 돌아가기
+            </button>
+          </div>
+        ) : (
+          <form onSubmit={handleSubmit} className="login-form">
+            {error && <div className="login-error">{error}</div>}
+
+            <div className="form-group">
+              <label htmlFor="regUsername">사용자명</label>
+              <input
+                id="regUsername"
+                type="text"
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                placeholder="3자 이상"
+                required
+                minLength={3}
+                maxLength={50}
+                autoComplete="username"
+                autoFocus
+              />
+            </div>
+
+            <div className="form-group">
+              <label htmlFor="regEmail">이메일{emailRequired ? '' : ' (선택)'}</label>
+              <input
+                id="regEmail"
+                type="email"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                placeholder={emailRequired ? policy.emailDomains.map((d) => `@${d}`).join(', ') : '이메일을 입력하세요'}
+                required={emailRequired}
+                autoComplete="email"
+              />
+            </div>
+
+            <div className="form-group">
+              <label htmlFor="regPassword">비밀번호</label>
+              <input
+                id="regPassword"
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                placeholder="8자 이상"
+                required
+                minLength={8}
+                autoComplete="new-password"
+              />
+            </div>
+
+            <div className="form-group">
+              <label htmlFor="regConfirmPassword">비밀번호 확인</label>
+              <input
+                id="regConfirmPassword"
+                type="password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                placeholder="비밀번호를 다시 입력하세요"
+                required
+                autoComplete="new-password"
+              />
+            </div>
+
+            <button
+              type="submit"
+              className="login-btn"
+              disabled={submitting || !username || !password || !confirmPassword}
+            >
+              {submitting ? '가입 중...' : '가입하기'}
+            </button>
+
+            <button type="button" className="cancel-btn" onClick={onBack} disabled={submitting}>
+              로그인으로 돌아가기
+            </button>
+          </form>
+        )}
+      </div>
+    </div>
+  )
+}
+
+export default RegisterForm
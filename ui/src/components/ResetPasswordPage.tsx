@@ -0,0 +1,129 @@
+import { useState, useEffect } from 'react'
+import { useNavigate, useLocation } from 'react-router-dom'
+import { checkPasswordToken, resetPassword } from '../api/auth'
+import './LoginPage.css'
+
+// Public page for the links in password reset and welcome mails:
+// /reset-password?token=...
+function ResetPasswordPage() {
+  const navigate = useNavigate()
+  const location = useLocation()
+  const token = new URLSearchParams(location.search).get('token') || ''
+  const [loading, setLoading] = useState(true)
+  const [linkError, setLinkError] = useState<string | null>(null)
+  const [username, setUsername] = useState('')
+  const [purpose, setPurpose] = useState<'reset' | 'setup'>('reset')
+  const [password, setPassword] = useState('')
+  const [confirmPassword, setConfirmPassword] = useState('')
+  const [error, setError] = useState<string | null>(null)
+  const [saving, setSaving] = useState(false)
+  const [done, setDone] = useState(false)
+
+  useEffect(() => {
+    if (!token) {
+      setLinkError('링크가 올바르지 않습니다.')
+      setLoading(false)
+      return
+    }
+    checkPasswordToken(token)
+      .then((info) => {
+        setUsername(info.username)
+        setPurpose(info.purpose)
+      })
+      .catch(() => setLinkError('링크가 올바르지 않거나, 이미 사용되었거나, 만료되었습니다.'))
+      .finally(() => setLoading(false))
+  }, [token])
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    if (password !== confirmPassword) {
+      setError('비밀번호가 일치하지 않습니다.')
+      return
+    }
+    setSaving(true)
+    setError(null)
+    try {
+      await resetPassword(token, password)
+      setDone(true)
+    } catch (err) {
+      setError(err instanceof Error ? err.message : '비밀번호를 변경하지 못했습니다.')
+    } finally {
+      setSaving(false)
+    }
+  }
+
+  const title = purpose === 'setup' ? '비밀번호 설정' : '비밀번호 재설정'
+
+  return (
+    <div className="login-page">
+      <div className="login-container">
+        <div className="login-header">
+          <div className="login-banner">
+            <img src="/banner.png" alt="FileHatch" />
+          </div>
+          <h1>{title}</h1>
+          {username && <p>{username} 계정의 새 비밀번호를 입력하세요</p>}
+        </div>
+
+        {loading ? (
+          <div className="login-form"><p>확인 중...</p></div>
+        ) : linkError ? (
+          <div className="login-form">
+            <div className="login-error">{linkError}</div>
+            <button type="button" className="login-btn" onClick={() => navigate('/login')}>
+              로그인으로 돌아가기
+            </button>
+          </div>
+        ) : done ? (
+          <div className="login-form">
+            <p>비밀번호가 설정되었습니다. 새 비밀번호로 로그인하세요.</p>
+            <button type="button" className="login-btn" onClick={() => navigate('/login')}>
+              로그인
+            </button>
+          </div>
+        ) : (
+          <form onSubmit={handleSubmit} className="login-form">
+            {error && <div className="login-error">{error}</div>}
+
+            <div className="form-group">
+              <label htmlFor="newPassword">새 비밀번호</label>
+              <input
+                id="newPassword"
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                placeholder="새 비밀번호를 입력하세요"
+                required
+                autoComplete="new-password"
+                autoFocus
+              />
+            </div>
+
+            <div className="form-group">
+              <label htmlFor="confirmPassword">비밀번호 확인</label>
+              <input
+                id="confirmPassword"
+                type="password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                placeholder="비밀번호를 다시 입력하세요"
+                required
+                autoComplete="new-password"
+              />
+            </div>
+
+            <button
+              type="submit"
+              className="login-btn"
+              disabled={saving || !password || !confirmPassword}
+            >
+              {saving ? '저장 중...' : title}
+            </button>
+          </form>
+        )}
+      </div>
+    </div>
+  )
+}
+
+export default ResetPasswordPage
@@ -1,5 +1,6 @@
 import { useState, useEffect, useCallback, useRef } from 'react'
 import { searchFiles, FileInfo, formatFileSize, MatchType } from '../api/files'
+import { highlightMatches } from '../utils/highlight'
 import './SearchModal.css'
 
 interface SearchModalProps {
@@ -10,13 +11,14 @@ interface SearchModalProps {
   onFileSelect?: (filePath: string, parentPath: string) => void
 }
 
-type TabType = 'all' | 'name' | 'tag' | 'description'
+type TabType = 'all' | 'name' | 'tag' | 'description' | 'content'
 
 const TABS: { key: TabType; label: string }[] = [
   { key: 'all', label: '전체' },
   { key: 'name', label: '파일명' },
   { key: 'description', label: '설명' },
   { key: 'tag', label: '태그' },
+  { key: 'content', label: '내용' },
 ]
 
 function SearchModal({ isOpen, onClose, initialQuery, onNavigate, onFileSelect }: SearchModalProps) {
@@ -237,10 +239,10 @@ function SearchModal({ isOpen, onClose, initialQuery, onNavigate, onFileSelect }
                   </div>
                   <div className="search-item-content">
                     <div className="search-item-name-row">
-                      <span className="search-item-name">{file.name}</span>
+                      <span className="search-item-name">{highlightMatches(file.name, file.matchRanges)}</span>
                       {file.matchType && file.matchType !== 'name' && (
                         <span className={`search-item-badge ${file.matchType}`}>
-                          {file.matchType === 'tag' ? `#${file.matchedTag || '태그'}` : '설명'}
+                          {file.matchType === 'tag' ? `#${file.matchedTag || '태그'}` : file.matchType === 'content' ? '내용' : '설명'}
                         </span>
                       )}
                     </div>
@@ -248,6 +250,9 @@ function SearchModal({ isOpen, onClose, initialQuery, onNavigate, onFileSelect }
                     {file.matchType === 'description' && file.description && (
                       <span className="search-item-description">{file.description}</span>
                     )}
+                    {file.snippet && (
+                      <span className="search-item-snippet">{file.snippet}</span>
+                    )}
                     {file.matchType === 'tag' && file.tags && file.tags.length > 0 && (
                       <div className="search-item-tags">
                         {file.tags.slice(0, 5).map((tag, i) => (
@@ -1,7 +1,7 @@
 import { useState, useEffect, useCallback, useRef } from 'react'
 import { useNavigate, useLocation } from 'react-router-dom'
 import { useAuthStore } from '../stores/authStore'
-import { listShareContents, getShareFileDownloadUrl, ShareFileItem } from '../api/fileShares'
+import { listShareContents, getShareFileDownloadUrl, getShareThumbnailUrl, ShareFileItem } from '../api/fileShares'
 import './ShareAccessPage.css'
 
 interface ShareInfo {
@@ -15,6 +15,8 @@ interface ShareInfo {
   requiresLogin?: boolean
   shareType?: string
   editable?: boolean
+  // Share session for sub-requests, standing in for the password
+  session?: string
 }
 
 interface OnlyOfficeConfig {
@@ -159,7 +161,9 @@ function ShareAccessPage() {
   const getDownloadUrl = () => {
     if (!token) return ''
     let url = `/api/s/${token}/download`
-    if (password) {
+    if (shareInfo?.session) {
+      url += `?session=${encodeURIComponent(shareInfo.session)}`
+    } else if (password) {
       url += `?password=${encodeURIComponent(password)}`
     }
     return url
@@ -368,7 +372,7 @@ function ShareAccessPage() {
 
     setLoadingContents(true)
     try {
-      const contents = await listShareContents(token, subpath, password || undefined)
+      const contents = await listShareContents(token, subpath, password || undefined, shareInfo?.session)
       if (contents.requiresPassword || contents.requiresLogin) {
         // Handle auth requirements
         return
@@ -380,7 +384,7 @@ function ShareAccessPage() {
     } finally {
       setLoadingContents(false)
     }
-  }, [token, shareInfo?.isDir, password])
+  }, [token, shareInfo?.isDir, shareInfo?.session, password])
 
   // Load folder contents when shareInfo changes
   useEffect(() => {
@@ -430,7 +434,7 @@ function ShareAccessPage() {
   const handleDownloadSelected = () => {
     if (!token) return
     selectedFiles.forEach(filepath => {
-      const url = getShareFileDownloadUrl(token, filepath, password || undefined)
+      const url = getShareFileDownloadUrl(token, filepath, password || undefined, shareInfo?.session)
       const link = document.createElement('a')
       link.href = url
       link.download = filepath.split('/').pop() || filepath
@@ -455,6 +459,18 @@ function ShareAccessPage() {
       )
     }
 
+    // Images and videos show their thumbnail, gallery style
+    if (token && (item.preview === 'image' || item.preview === 'video')) {
+      return (
+        <img
+          className="folder-item-icon thumbnail"
+          src={getShareThumbnailUrl(token, item.path, shareInfo?.session)}
+          alt=""
+          loading="lazy"
+        />
+      )
+    }
+
     const ext = getFileExtension(item.name)
     const videoExts = ['mp4', 'webm', 'ogg', 'mov', 'avi', 'mkv', 'm4v']
     const imageExts = ['jpg', 'jpeg', 'png', 'gif', 'webp', 'svg', 'bmp', 'ico']
@@ -754,7 +770,7 @@ function ShareAccessPage() {
                         </div>
                         {!item.isDir && (
                           <a
-                            href={getShareFileDownloadUrl(token!, item.path, password || undefined)}
+                            href={getShareFileDownloadUrl(token!, item.path, password || undefined, shareInfo.session)}
                             className="share-folder-item-download"
                             download={item.name}
                             onClick={(e) => e.stopPropagation()}
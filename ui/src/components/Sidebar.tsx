@@ -16,7 +16,7 @@ interface VersionInfo {
   git_commit?: string
 }
 
-export type AdminView = 'users' | 'shared-folders' | 'settings' | 'sso' | 'logs' | 'system-info'
+export type AdminView = 'users' | 'groups' | 'shared-folders' | 'settings' | 'sso' | 'logs' | 'system-info'
 
 interface SidebarProps {
   currentPath: string
@@ -176,8 +176,9 @@ function Sidebar({ currentPath, onNavigate, onUploadClick, onNewFolderClick, onA
 
   useEffect(() => {
     if (completedUploadCount > 0 || completedTransferCount > 0) {
-      // Invalidate storage query when uploads/transfers complete
+      // Invalidate storage queries when uploads/transfers complete
       queryClient.invalidateQueries({ queryKey: ['storage-usage'] })
+      queryClient.invalidateQueries({ queryKey: ['shared-folders'] })
     }
   }, [completedUploadCount, completedTransferCount, queryClient])
 
@@ -298,8 +299,16 @@ function Sidebar({ currentPath, onNavigate, onUploadClick, onNewFolderClick, onA
                         to={`/shared-drive/${encodeURIComponent(folder.name)}`}
                         className={`nav-item shared-drive-item ${location.pathname.startsWith(`/shared-drive/${encodeURIComponent(folder.name)}`) ? 'active' : ''}`}
                         onClick={() => onMobileClose?.()}
+                        title={folder.quotaStatus ? `${formatFileSize(folder.quotaStatus.used)} / ${formatFileSize(folder.quotaStatus.quota)}` : undefined}
                       >
-                        <span className="drive-name">{folder.name}</span>
+                        <span className="drive-main">
+                          <span className="drive-name">{folder.name}</span>
+                          {folder.quotaStatus && (
+                            <span className={`drive-quota-bar ${folder.quotaStatus.exceeded ? 'full' : ''}`}>
+                              <span className="drive-quota-fill" style={{ width: `${Math.min(100, folder.quotaStatus.percent)}%` }} />
+                            </span>
+                          )}
+                        </span>
                         <span className={`permission-badge ${folder.permissionLevel === PERMISSION_READ_WRITE ? 'rw' : 'r'}`}>
                           {folder.permissionLevel === PERMISSION_READ_WRITE ? 'RW' : 'R'}
                         </span>
@@ -396,6 +405,14 @@ function Sidebar({ currentPath, onNavigate, onUploadClick, onNewFolderClick, onA
               {icons.users}
               <span>사용자 관리</span>
             </Link>
+            <Link
+              to="/fhadmin/groups"
+              className={`nav-item ${adminView === 'groups' ? 'active' : ''}`}
+              onClick={() => onMobileClose?.()}
+            >
+              {icons.users}
+              <span>사용자 그룹</span>
+            </Link>
             <Link
               to="/fhadmin/shared-folders"
               className={`nav-item ${adminView === 'shared-folders' ? 'active' : ''}`}
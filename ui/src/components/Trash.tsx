@@ -1,6 +1,6 @@
 import { useState, useMemo } from 'react'
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query'
-import { listTrash, restoreFromTrash, deleteFromTrash, emptyTrash, formatFileSize, TrashItem } from '../api/files'
+import { listTrash, restoreFromTrash, restoreTrashBatch, waitForJob, deleteFromTrash, emptyTrash, formatFileSize, TrashItem, TrashRestoreProgress } from '../api/files'
 import { useToastStore } from '../stores/toastStore'
 import './Trash.css'
 
@@ -48,6 +48,29 @@ export default function Trash({ onNavigate }: TrashProps) {
     },
   })
 
+  const restoreAllMutation = useMutation({
+    mutationFn: async () => {
+      const { jobId } = await restoreTrashBatch({ all: true })
+      return waitForJob<TrashRestoreProgress>(jobId)
+    },
+    onSuccess: (job) => {
+      queryClient.invalidateQueries({ queryKey: ['trash'] })
+      queryClient.invalidateQueries({ queryKey: ['files'] })
+      queryClient.invalidateQueries({ queryKey: ['storage-usage'] })
+      const progress = job.progress
+      if (job.state !== 'succeeded' || !progress) {
+        showError(job.error || '복원하지 못했습니다')
+      } else if (progress.skipped + progress.failed > 0) {
+        showError(`${progress.restored}개 복원, ${progress.skipped}개는 같은 위치에 항목이 있어 건너뜀, ${progress.failed}개 실패`)
+      } else {
+        showSuccess(`${progress.restored}개 항목이 복원되었습니다`)
+      }
+    },
+    onError: (err: Error) => {
+      showError(err.message)
+    },
+  })
+
   const deleteMutation = useMutation({
     mutationFn: deleteFromTrash,
     onSuccess: () => {
@@ -275,6 +298,15 @@ export default function Trash({ onNavigate }: TrashProps) {
             {hasFilters ? `${formatFileSize(filteredSize)} / ${formatFileSize(totalSize)}` : formatFileSize(totalSize)}
           </span>
         </div>
+        {allItems.length > 0 && (
+          <button
+            className="empty-trash-btn"
+            onClick={() => restoreAllMutation.mutate()}
+            disabled={restoreAllMutation.isPending}
+          >
+            {restoreAllMutation.isPending ? '복원 중...' : '모두 복원'}
+          </button>
+        )}
         {allItems.length > 0 && (
           <button
             className="empty-trash-btn"
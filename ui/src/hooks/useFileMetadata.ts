@@ -62,7 +62,7 @@ export function useFileMetadata({
   // Load all user tags for autocomplete
   useEffect(() => {
     getUserTags()
-      .then(({ tags }) => setAllUserTags(tags))
+      .then(({ tags }) => setAllUserTags(tags.map((t) => t.tag)))
       .catch(() => setAllUserTags([]))
   }, [])
 
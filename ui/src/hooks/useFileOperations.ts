@@ -9,6 +9,7 @@ import {
   copyItem,
   moveItem,
   moveToTrash,
+  batchFileOperation,
   createFile,
   compressFiles,
   downloadAsZip,
@@ -181,21 +182,27 @@ export function useFileOperations({ currentPath, onToast }: UseFileOperationsOpt
   // Move files to folder
   const moveToFolder = useCallback(async (files: FileInfo[], destination: string) => {
     try {
-      const results = await Promise.all(
-        files.map(file => moveItem(file.path, destination))
-      )
-
-      addToHistory({
-        type: 'move',
-        sourcePaths: files.map(f => f.path),
-        destination,
-        destPaths: results.map(r => r.newPath),
-      })
+      const batch = await batchFileOperation('move', files.map(f => f.path), destination)
+      const moved = batch.results.filter(r => r.status === 'ok')
+
+      if (moved.length > 0) {
+        addToHistory({
+          type: 'move',
+          sourcePaths: moved.map(r => r.path),
+          destination,
+          destPaths: moved.map(r => r.newPath!),
+        })
+      }
 
-      onToast(`${files.length}개 항목이 이동되었습니다`, 'success')
+      if (batch.failed > 0) {
+        const firstError = batch.results.find(r => r.status === 'failed')?.error
+        onToast(`${moved.length}개 항목 이동, ${batch.failed}개 실패${firstError ? `: ${firstError}` : ''}`, 'error')
+      } else {
+        onToast(`${files.length}개 항목이 이동되었습니다`, 'success')
+      }
       refreshFiles()
       refreshStorage()
-      return true
+      return batch.failed === 0
     } catch (error: any) {
       onToast(error.message || '이동에 실패했습니다', 'error')
       return false
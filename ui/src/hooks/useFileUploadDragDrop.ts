@@ -147,15 +147,8 @@ export function useFileUploadDragDrop({
         return
       }
 
-      // Group files by their folder path and add to upload store
-      for (const { file, relativePath } of allFiles) {
-        const pathParts = relativePath.split('/')
-        pathParts.pop() // Remove filename
-        const targetPath = pathParts.length > 0
-          ? `${currentPath}/${pathParts.join('/')}`
-          : currentPath
-        uploadStore.addFiles([file], targetPath)
-      }
+      // The server creates the dragged folders below the current path
+      uploadStore.addFolderFiles(allFiles, currentPath)
 
       // Open upload panel and start uploads
       uploadStore.openPanel()
@@ -254,14 +247,7 @@ export function useFileUploadDragDrop({
         return
       }
 
-      for (const { file, relativePath } of allFiles) {
-        const pathParts = relativePath.split('/')
-        pathParts.pop()
-        const uploadPath = pathParts.length > 0
-          ? `${targetPath}/${pathParts.join('/')}`
-          : targetPath
-        uploadStore.addFiles([file], uploadPath)
-      }
+      uploadStore.addFolderFiles(allFiles, targetPath)
 
       uploadStore.openPanel()
       setTimeout(() => {
@@ -1,6 +1,6 @@
 import { create } from 'zustand'
 import { persist } from 'zustand/middleware'
-import { User, login, getProfile, LoginRequest, verify2FA, refreshToken, completeInitialSetup, InitialSetupRequest } from '../api/auth'
+import { User, login, getProfile, LoginRequest, verify2FA, refreshToken, logoutSession, completeInitialSetup, InitialSetupRequest } from '../api/auth'
 import { ApiError } from '../api/client'
 
 interface AuthState {
@@ -10,7 +10,7 @@ interface AuthState {
   error: string | null
   // 2FA state
   requires2FA: boolean
-  pending2FAUserId: string | null
+  pending2FAToken: string | null
   pendingRememberMe: boolean  // Store rememberMe during 2FA flow
   // Initial setup state
   requiresSetup: boolean
@@ -35,7 +35,7 @@ export const useAuthStore = create<AuthState>()(
       isLoading: false,
       error: null,
       requires2FA: false,
-      pending2FAUserId: null,
+      pending2FAToken: null,
       pendingRememberMe: false,
       requiresSetup: false,
       pendingSetupToken: null,
@@ -45,7 +45,7 @@ export const useAuthStore = create<AuthState>()(
           isLoading: true,
           error: null,
           requires2FA: false,
-          pending2FAUserId: null,
+          pending2FAToken: null,
           pendingRememberMe: false,
           requiresSetup: false,
           pendingSetupToken: null
@@ -64,11 +64,11 @@ export const useAuthStore = create<AuthState>()(
           }
 
           // Check if 2FA is required
-          if (result.requires2fa && result.userId) {
+          if (result.requires2fa && result.pendingToken) {
             set({
               isLoading: false,
               requires2FA: true,
-              pending2FAUserId: result.userId,
+              pending2FAToken: result.pendingToken,
               pendingRememberMe: data.rememberMe || false  // Store for 2FA verification
             })
             return '2fa'
@@ -86,22 +86,22 @@ export const useAuthStore = create<AuthState>()(
       },
 
       verify2FACode: async (code: string) => {
-        const { pending2FAUserId, pendingRememberMe } = get()
-        if (!pending2FAUserId) {
+        const { pending2FAToken, pendingRememberMe } = get()
+        if (!pending2FAToken) {
           set({ error: 'No pending 2FA verification' })
           return
         }
 
         set({ isLoading: true, error: null })
         try {
-          const result = await verify2FA(pending2FAUserId, code, pendingRememberMe)
+          const result = await verify2FA(pending2FAToken, code, pendingRememberMe)
           if (result.token && result.user) {
             set({
               token: result.token,
               user: result.user,
               isLoading: false,
               requires2FA: false,
-              pending2FAUserId: null,
+              pending2FAToken: null,
               pendingRememberMe: false
             })
           }
@@ -117,7 +117,7 @@ export const useAuthStore = create<AuthState>()(
       cancel2FA: () => {
         set({
           requires2FA: false,
-          pending2FAUserId: null,
+          pending2FAToken: null,
           pendingRememberMe: false,
           error: null
         })
@@ -160,12 +160,16 @@ export const useAuthStore = create<AuthState>()(
       },
 
       logout: () => {
+        // End the session server-side too, so its refresh cookie stops working
+        if (get().token) {
+          logoutSession().catch(() => {})
+        }
         set({
           token: null,
           user: null,
           error: null,
           requires2FA: false,
-          pending2FAUserId: null,
+          pending2FAToken: null,
           pendingRememberMe: false,
           requiresSetup: false,
           pendingSetupToken: null
@@ -183,6 +187,14 @@ export const useAuthStore = create<AuthState>()(
           // Only logout if it's an authentication error (401/403)
           // Don't logout for network errors or other issues
           if (err instanceof ApiError && (err.status === 401 || err.status === 403)) {
+            // An expired token may still be renewed with the refresh cookie
+            if (err.status === 401 && await get().refreshAuthToken()) {
+              const user = await getProfile(get().token!).catch(() => null)
+              if (user) {
+                set({ user })
+                return
+              }
+            }
             console.log('[Auth] Token expired or unauthorized, logging out')
             set({ token: null, user: null })
           } else {
@@ -68,6 +68,11 @@ export const useToastStore = create<ToastState>((set) => ({
 export function parseUploadError(errorMessage: string): string {
   const lowerMessage = errorMessage.toLowerCase()
 
+  // Shared drives have their own quota, which trash and home files don't affect
+  if (lowerMessage.includes('shared drive quota')) {
+    return '공유 드라이브 저장 공간이 부족합니다. 관리자에게 할당량 확인을 요청해주세요.'
+  }
+
   // Check for quota-related keywords first (most specific)
   if (
     lowerMessage.includes('quota exceeded') ||
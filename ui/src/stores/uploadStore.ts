@@ -24,6 +24,12 @@ export interface UploadItem {
   upload?: tus.Upload
   path: string
   relativePath?: string // For folder uploads
+  // Folder uploads: the folder dropped into, the file's directory inside the
+  // dragged folder and the batch of files dragged together
+  destination?: string
+  relativeDir?: string
+  batchId?: string
+  folder?: string // Top-level folder created, from the server
   overwrite?: boolean
   // Speed tracking
   uploadSpeed?: number
@@ -56,6 +62,7 @@ interface UploadState {
 
   // Upload functions
   addFiles: (files: File[], currentPath: string, isFolder?: boolean) => void
+  addFolderFiles: (files: { file: File; relativePath: string }[], currentPath: string) => void
   startUpload: (id: string, overwrite?: boolean) => void
   startAllUploads: () => Promise<void>
   startNextUpload: () => void
@@ -99,6 +106,22 @@ function generateId(filename: string): string {
   return `${filename}-${Date.now()}-${Math.random().toString(36).slice(2)}`
 }
 
+// Helper: Directory part of a path relative to the dragged folder
+function relativeDirOf(relativePath: string): string {
+  const parts = relativePath.split('/')
+  parts.pop() // Remove filename
+  return parts.join('/')
+}
+
+// Helper: Bytes of an item's batch not yet uploaded, the item's included,
+// so the server checks the quota against the whole batch
+function batchRemaining(items: UploadItem[], item: UploadItem): number {
+  if (!item.batchId) return item.file.size
+  return items
+    .filter((i) => i.batchId === item.batchId && i.status !== 'completed' && i.status !== 'error')
+    .reduce((sum, i) => sum + i.file.size, 0)
+}
+
 export const useUploadStore = create<UploadState>((set, get) => ({
   items: [],
   downloads: [],
@@ -108,21 +131,34 @@ export const useUploadStore = create<UploadState>((set, get) => ({
 
   // Add files to upload queue
   addFiles: (files, currentPath, isFolder = false) => {
+    const fileArray = Array.from(files).filter((file) => file.size > 0)
+    if (isFolder) {
+      // Get relative path for folder uploads
+      get().addFolderFiles(
+        fileArray.map((file) => ({
+          file,
+          relativePath: 'webkitRelativePath' in file && file.webkitRelativePath ? (file.webkitRelativePath as string) : '',
+        })),
+        currentPath
+      )
+      return
+    }
+    get().addFolderFiles(fileArray.map((file) => ({ file, relativePath: '' })), currentPath)
+  },
+
+  // Add files with their paths inside dragged folders; the server creates
+  // the folders below currentPath
+  addFolderFiles: (files, currentPath) => {
     set({ overwriteAll: false })
     const existingItems = get().items
-
-    const fileArray = Array.from(files).filter((file) => file.size > 0)
+    const batchId = files.some((f) => relativeDirOf(f.relativePath) !== '') ? generateId('batch') : undefined
 
     // Filter out duplicates already in queue
-    const newItems: UploadItem[] = fileArray
-      .map((file) => {
-        // Get relative path for folder uploads
-        let relativePath = ''
-        if (isFolder && 'webkitRelativePath' in file && file.webkitRelativePath) {
-          relativePath = file.webkitRelativePath as string
-        }
-
+    const newItems: UploadItem[] = files
+      .filter(({ file }) => file.size > 0)
+      .map(({ file, relativePath }) => {
         const targetPath = getTargetPath(currentPath, relativePath)
+        const relativeDir = relativeDirOf(relativePath)
 
         return {
           id: generateId(relativePath || file.name),
@@ -131,6 +167,9 @@ export const useUploadStore = create<UploadState>((set, get) => ({
           status: 'pending' as const,
           path: targetPath,
           relativePath,
+          destination: relativeDir ? currentPath : undefined,
+          relativeDir: relativeDir || undefined,
+          batchId,
         }
       })
       .filter((item) => {
@@ -169,9 +208,11 @@ export const useUploadStore = create<UploadState>((set, get) => ({
       metadata: {
         filename: item.file.name,
         filetype: item.file.type,
-        path: item.path,
+        path: item.relativeDir && item.destination ? item.destination : item.path,
         username: username || '',
         overwrite: overwrite ? 'true' : 'false',
+        ...(item.relativeDir ? { relativePath: item.relativeDir } : {}),
+        ...(item.batchId ? { batchRemaining: String(batchRemaining(get().items, item)) } : {}),
       },
       onError: (error) => {
         const errorMessage = parseUploadError(error.message)
@@ -189,9 +230,25 @@ export const useUploadStore = create<UploadState>((set, get) => ({
         )
         get().updateProgress(id, progress, uploadSpeed, bytesUploaded, Date.now())
       },
-      onSuccess: () => {
+      onSuccess: (payload) => {
+        const folderHeader = payload?.lastResponse?.getHeader('X-Upload-Folder')
+        if (folderHeader) {
+          const folder = decodeURIComponent(folderHeader)
+          set((state) => ({
+            items: state.items.map((i) => (i.id === id ? { ...i, folder } : i)),
+          }))
+        }
         get().setStatus(id, 'completed')
         invalidateStorageCache() // Clear cache after upload
+
+        // Name the folder once the last file of a folder upload is in
+        if (item.batchId) {
+          const batch = get().items.filter((i) => i.batchId === item.batchId)
+          const folder = batch.find((i) => i.folder)?.folder
+          if (folder && batch.every((i) => i.status === 'completed')) {
+            useToastStore.getState().showSuccess(`폴더 업로드 완료: ${folder}`)
+          }
+        }
         setTimeout(() => get().startNextUpload(), 100)
       },
     })
@@ -214,11 +271,13 @@ export const useUploadStore = create<UploadState>((set, get) => ({
         createTimeout<never>(API_TIMEOUT, 'Quota check timeout'),
       ])
 
-      // quota === 0 means unlimited
-      if (storage.quota > 0) {
+      // quota === 0 means unlimited; shared drive uploads count against the
+      // drive's own quota, which the server checks
+      if (storage.quota > 0 && !item.path.startsWith('/shared/')) {
         const remaining = storage.quota - storage.totalUsed
-        if (item.file.size > remaining) {
-          const errorMessage = `저장 공간이 부족합니다. 필요: ${formatFileSize(item.file.size)}, 남은 공간: ${formatFileSize(remaining)}`
+        const required = batchRemaining(get().items, item)
+        if (required > remaining) {
+          const errorMessage = `저장 공간이 부족합니다. 필요: ${formatFileSize(required)}, 남은 공간: ${formatFileSize(remaining)}`
           useToastStore.getState().showError(errorMessage)
           get().setStatus(id, 'error', errorMessage)
           setTimeout(() => get().startNextUpload(), 100)
@@ -0,0 +1,25 @@
+// 검색 결과 강조 유틸리티
+// 서버가 준 matchRanges(UTF-16 오프셋)로 이름의 일치 부분을 <mark>로 감싸기
+
+import { ReactNode } from 'react'
+import { MatchRange } from '../api/files'
+
+export function highlightMatches(text: string, ranges?: MatchRange[]): ReactNode {
+  if (!ranges || ranges.length === 0) {
+    return text
+  }
+  const parts: ReactNode[] = []
+  let last = 0
+  ranges.forEach((range, i) => {
+    if (range.start < last || range.end > text.length) {
+      return
+    }
+    if (range.start > last) {
+      parts.push(text.slice(last, range.start))
+    }
+    parts.push(<mark key={i} className="search-match">{text.slice(range.start, range.end)}</mark>)
+    last = range.end
+  })
+  parts.push(text.slice(last))
+  return parts
+}